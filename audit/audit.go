@@ -0,0 +1,201 @@
+package audit
+
+// audit module records who did what within FOXDEN/CHESS services. Audit
+// records contain subject from JWT token, action, resource, before/after
+// diff, client IP and request ID. Records are kept in append-only stores,
+// either document storage (e.g. MongoDB collection) or local file with
+// hash chaining which allows to detect modifications of the audit trail.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"time"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	"github.com/google/uuid"
+)
+
+// Collection defines storage collection of audit records
+var Collection = "audit"
+
+// Change represents change of single field
+type Change struct {
+	Before any `json:"before,omitempty"`
+	After  any `json:"after,omitempty"`
+}
+
+// Record represents audit record
+type Record struct {
+	ID        string            `json:"id"`
+	Time      int64             `json:"time"` // unix time in milliseconds
+	Subject   string            `json:"subject"`
+	Actor     string            `json:"actor,omitempty"` // administrator acting on behalf of subject via impersonation token
+	Kind      string            `json:"kind,omitempty"`  // kind of credentials, e.g. apikey
+	Action    string            `json:"action"`
+	Resource  string            `json:"resource"`
+	Status    int               `json:"status,omitempty"` // HTTP status code
+	Diff      map[string]Change `json:"diff,omitempty"`
+	Details   map[string]any    `json:"details,omitempty"` // action specific details, e.g. downloaded bytes
+	IP        string            `json:"ip,omitempty"`
+	RequestID string            `json:"request_id,omitempty"`
+	PrevHash  string            `json:"prev_hash,omitempty"`
+	Hash      string            `json:"hash,omitempty"`
+}
+
+// Query represents query of audit records, empty fields match all records
+type Query struct {
+	Subject  string
+	Action   string
+	Resource string
+	Since    time.Time
+	Until    time.Time
+	Limit    int
+}
+
+// helper function to check if record matches the query
+func (q Query) matches(rec Record) bool {
+	if q.Subject != "" && rec.Subject != q.Subject {
+		return false
+	}
+	if q.Action != "" && rec.Action != q.Action {
+		return false
+	}
+	if q.Resource != "" && rec.Resource != q.Resource {
+		return false
+	}
+	if !q.Since.IsZero() && rec.Time < q.Since.UnixMilli() {
+		return false
+	}
+	if !q.Until.IsZero() && rec.Time > q.Until.UnixMilli() {
+		return false
+	}
+	return true
+}
+
+// Store defines interface of append-only audit store
+type Store interface {
+	// Append appends record to the store
+	Append(ctx context.Context, rec *Record) error
+	// Query returns records matching the query, most recent first
+	Query(ctx context.Context, q Query) ([]Record, error)
+}
+
+// Diff provides changes between before and after states, states are
+// compared by their JSON representation
+func Diff(before, after any) map[string]Change {
+	b := toMap(before)
+	a := toMap(after)
+	diff := make(map[string]Change)
+	for k, v := range b {
+		if nv, ok := a[k]; !ok || !reflect.DeepEqual(v, nv) {
+			diff[k] = Change{Before: v, After: a[k]}
+		}
+	}
+	for k, v := range a {
+		if _, ok := b[k]; !ok {
+			diff[k] = Change{After: v}
+		}
+	}
+	if len(diff) == 0 {
+		return nil
+	}
+	return diff
+}
+
+// helper function to convert value into map via its JSON representation
+func toMap(val any) map[string]any {
+	if val == nil {
+		return nil
+	}
+	// JSON round trip normalizes values, e.g. numbers and structs
+	data, err := json.Marshal(val)
+	if err != nil {
+		log.Printf("WARNING: unable to marshal audit state, error %v", err)
+		return nil
+	}
+	var out map[string]any
+	if err := json.Unmarshal(data, &out); err != nil {
+		// non-object values are represented by single value key
+		var v any
+		json.Unmarshal(data, &v)
+		return map[string]any{"value": v}
+	}
+	return out
+}
+
+// Logger records audit records into the store
+type Logger struct {
+	Store   Store
+	Verbose int
+}
+
+// AuditLogger represents audit logger used by services, it should be
+// initialized via Init function
+var AuditLogger *Logger
+
+// Init initializes audit logger with given store, the logger also records
+// impersonation tokens issued by authz module
+func Init(store Store, verbose int) {
+	AuditLogger = &Logger{Store: store, Verbose: verbose}
+	authz.ImpersonationAuditor = AuditLogger.Impersonation
+	authz.LoginAuditor = AuditLogger.Login
+}
+
+// Record records audit record, record ID and time are assigned if they
+// are not set
+func (l *Logger) Record(ctx context.Context, rec Record) error {
+	if l == nil || l.Store == nil {
+		return errors.New("audit logger is not initialized")
+	}
+	if rec.Action == "" {
+		return errors.New("audit record requires action")
+	}
+	if rec.ID == "" {
+		rec.ID = uuid.NewString()
+	}
+	if rec.Time == 0 {
+		rec.Time = time.Now().UnixMilli()
+	}
+	if err := l.Store.Append(ctx, &rec); err != nil {
+		log.Printf("ERROR: unable to record audit record %+v, error %v", rec, err)
+		return err
+	}
+	if l.Verbose > 0 {
+		log.Printf("INFO: audit %s %s %s by %s", rec.RequestID, rec.Action, rec.Resource, rec.Subject)
+	}
+	return nil
+}
+
+// Query returns audit records matching the query
+func (l *Logger) Query(ctx context.Context, q Query) ([]Record, error) {
+	if l == nil || l.Store == nil {
+		return nil, errors.New("audit logger is not initialized")
+	}
+	return l.Store.Query(ctx, q)
+}
+
+// helper function to sort records in reverse chronological order and apply
+// query limit
+func latest(records []Record, limit int) []Record {
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Time > records[j].Time
+	})
+	if limit > 0 && len(records) > limit {
+		records = records[:limit]
+	}
+	return records
+}
+
+// String provides string representation of audit record
+func (r Record) String() string {
+	subject := r.Subject
+	if r.Actor != "" {
+		subject = fmt.Sprintf("%s (acted by %s)", r.Subject, r.Actor)
+	}
+	return fmt.Sprintf("%s %s %s %s by %s from %s", time.UnixMilli(r.Time).Format(time.RFC3339), r.RequestID, r.Action, r.Resource, subject, r.IP)
+}
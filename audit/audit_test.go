@@ -0,0 +1,160 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	"github.com/CHESSComputing/golib/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// TestDiff
+func TestDiff(t *testing.T) {
+	before := map[string]any{"name": "dataset", "size": 10, "tags": []string{"a"}}
+	after := map[string]any{"name": "dataset", "size": 20, "owner": "alice"}
+	diff := Diff(before, after)
+	if len(diff) != 3 {
+		t.Fatalf("unexpected diff %+v", diff)
+	}
+	if diff["size"].Before != float64(10) || diff["size"].After != float64(20) {
+		t.Errorf("unexpected size change %+v", diff["size"])
+	}
+	if diff["owner"].Before != nil || diff["owner"].After != "alice" {
+		t.Errorf("unexpected owner change %+v", diff["owner"])
+	}
+	if Diff(before, before) != nil {
+		t.Error("diff of equal states should be empty")
+	}
+}
+
+// TestFileStore
+func TestFileStore(t *testing.T) {
+	ctx := context.Background()
+	fname := filepath.Join(t.TempDir(), "audit.log")
+	logger := &Logger{Store: NewFileStore(fname)}
+	for _, action := range []string{"create", "update", "delete"} {
+		if err := logger.Record(ctx, Record{Subject: "alice", Action: action, Resource: "/dataset/1"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// new store should continue existing chain
+	store := NewFileStore(fname)
+	if err := store.Append(ctx, &Record{ID: "4", Subject: "bob", Action: "create"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	records, err := store.Query(ctx, Query{Subject: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 {
+		t.Errorf("unexpected number of records %d", len(records))
+	}
+
+	// modification of audit trail should be detected
+	data, _ := os.ReadFile(fname)
+	data = []byte(strings.Replace(string(data), `"subject":"bob"`, `"subject":"eve"`, 1))
+	os.WriteFile(fname, data, 0640)
+	if err := store.Verify(); err == nil {
+		t.Error("modified audit trail is not detected")
+	}
+}
+
+// TestMiddleware
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	secret := "test-secret"
+	logger := &Logger{Store: NewDocumentStore(storage.NewMemoryStore())}
+	r := gin.New()
+	r.PUT("/dataset/:id", logger.Middleware("dataset.update", secret), func(c *gin.Context) {
+		SetBefore(c, map[string]any{"size": 1})
+		SetAfter(c, map[string]any{"size": 2})
+		c.Status(http.StatusOK)
+	})
+	r.GET("/audit", logger.QueryHandler)
+
+	token, _ := authz.JWTAccessToken(secret, 60, authz.CustomClaims{User: "alice", Scope: "write"})
+	req := httptest.NewRequest("PUT", "/dataset/1", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set(RequestIDHeader, "rid-1")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Header().Get(RequestIDHeader) != "rid-1" {
+		t.Errorf("request ID is not propagated")
+	}
+
+	req = httptest.NewRequest("GET", "/audit?subject=alice&action=dataset.update", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	var records []Record
+	if err := json.Unmarshal(w.Body.Bytes(), &records); err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("unexpected audit records %s", w.Body.String())
+	}
+	rec := records[0]
+	if rec.Resource != "/dataset/1" || rec.RequestID != "rid-1" || rec.Status != http.StatusOK || rec.IP == "" {
+		t.Errorf("unexpected audit record %+v", rec)
+	}
+	if rec.Diff["size"].After != float64(2) {
+		t.Errorf("unexpected audit diff %+v", rec.Diff)
+	}
+
+	req = httptest.NewRequest("GET", "/audit?since=bad", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("invalid since should be rejected, code %d", w.Code)
+	}
+}
+
+// TestImpersonation
+func TestImpersonation(t *testing.T) {
+	auditor := authz.ImpersonationAuditor
+	t.Cleanup(func() { authz.ImpersonationAuditor = auditor })
+	logger := AuditLogger
+	t.Cleanup(func() { AuditLogger = logger })
+	Init(NewDocumentStore(storage.NewMemoryStore()), 0)
+	if authz.ImpersonationAuditor == nil {
+		t.Fatal("impersonation auditor is not set")
+	}
+	event := authz.ImpersonationEvent{Actor: "admin", User: "alice", Scope: "read", Reason: "ticket 42", TokenID: "abc", Expires: 1}
+	if err := authz.ImpersonationAuditor(context.Background(), event); err != nil {
+		t.Fatal(err)
+	}
+
+	// requests of impersonation tokens record the actor
+	gin.SetMode(gin.TestMode)
+	secret := "test-secret"
+	r := gin.New()
+	r.PUT("/dataset/:id", AuditLogger.Middleware("dataset.update", secret), func(c *gin.Context) {
+		claims := &authz.Claims{CustomClaims: authz.CustomClaims{User: "alice", Kind: "impersonation"}, Actor: &authz.Actor{Subject: "admin"}}
+		c.Set("claims", claims)
+		c.Status(http.StatusOK)
+	})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("PUT", "/dataset/1", nil))
+
+	records, err := AuditLogger.Query(context.Background(), Query{Subject: "alice"})
+	if err != nil || len(records) != 2 {
+		t.Fatalf("unexpected audit records %+v, error %v", records, err)
+	}
+	for _, rec := range records {
+		if rec.Actor != "admin" {
+			t.Errorf("actor is not recorded %+v", rec)
+		}
+		if rec.Action == ImpersonationAction && (rec.Resource != "token/abc" || rec.Details["reason"] != "ticket 42") {
+			t.Errorf("unexpected impersonation record %+v", rec)
+		}
+	}
+}
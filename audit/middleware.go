@@ -0,0 +1,204 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	services "github.com/CHESSComputing/golib/services"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// RequestIDHeader defines HTTP header with request ID
+var RequestIDHeader = "X-Request-ID"
+
+// gin context keys used by audit middleware
+const (
+	beforeKey   = "audit.before"
+	afterKey    = "audit.after"
+	resourceKey = "audit.resource"
+)
+
+// SetBefore sets state of resource before the change, it should be called
+// by handlers of audited endpoints
+func SetBefore(c *gin.Context, state any) {
+	c.Set(beforeKey, state)
+}
+
+// SetAfter sets state of resource after the change, it should be called
+// by handlers of audited endpoints
+func SetAfter(c *gin.Context, state any) {
+	c.Set(afterKey, state)
+}
+
+// SetResource overwrites audited resource, by default request path is used
+func SetResource(c *gin.Context, resource string) {
+	c.Set(resourceKey, resource)
+}
+
+// RequestID returns request ID of HTTP request, if request does not have
+// it new ID is generated and set in request and response headers
+func RequestID(c *gin.Context) string {
+	rid := c.GetHeader(RequestIDHeader)
+	if rid == "" {
+		rid = uuid.NewString()
+		c.Request.Header.Set(RequestIDHeader, rid)
+	}
+	c.Writer.Header().Set(RequestIDHeader, rid)
+	return rid
+}
+
+// helper function to get request claims either from gin context set by
+// authz middleware or from request token
+func requestClaims(c *gin.Context, clientId string) *authz.Claims {
+	if val, ok := c.Get("claims"); ok {
+		if claims, ok := val.(*authz.Claims); ok {
+			return claims
+		}
+	}
+	token := authz.RequestToken(c.Request)
+	if token == "" || clientId == "" {
+		return nil
+	}
+	claims, err := authz.TokenClaims(token, clientId)
+	if err != nil {
+		return nil
+	}
+	return claims
+}
+
+// Middleware provides gin middleware which records audit record of given
+// action for every processed request. It should be used with write
+// endpoints after authorization middleware, the clientId is used to
+// extract subject from JWT token.
+func (l *Logger) Middleware(action, clientId string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rid := RequestID(c)
+		c.Next()
+		rec := Record{
+			Action:    action,
+			Resource:  c.Request.URL.Path,
+			Status:    c.Writer.Status(),
+			IP:        c.ClientIP(),
+			RequestID: rid,
+		}
+		if resource := c.GetString(resourceKey); resource != "" {
+			rec.Resource = resource
+		}
+		if claims := requestClaims(c, clientId); claims != nil {
+			rec.Subject = claims.CustomClaims.User
+			rec.Kind = claims.CustomClaims.Kind
+			if claims.Actor != nil {
+				rec.Actor = claims.Actor.Subject
+			}
+		} else if user := c.GetString("user"); user != "" {
+			rec.Subject = user
+		}
+		before, _ := c.Get(beforeKey)
+		after, _ := c.Get(afterKey)
+		rec.Diff = Diff(before, after)
+		if err := l.Record(c.Request.Context(), rec); err != nil {
+			log.Printf("ERROR: unable to audit request %s, error %v", rid, err)
+		}
+	}
+}
+
+// ImpersonationAction defines audit action of issued impersonation tokens
+const ImpersonationAction = "impersonate"
+
+// Impersonation records impersonation token issued by administrator, it
+// implements authz.ImpersonationAuditor
+func (l *Logger) Impersonation(ctx context.Context, event authz.ImpersonationEvent) error {
+	rec := Record{
+		Subject:  event.User,
+		Actor:    event.Actor,
+		Kind:     "impersonation",
+		Action:   ImpersonationAction,
+		Resource: "token/" + event.TokenID,
+		Details: map[string]any{
+			"scope":   event.Scope,
+			"roles":   event.Roles,
+			"reason":  event.Reason,
+			"expires": event.Expires,
+		},
+	}
+	return l.Record(ctx, rec)
+}
+
+// Login records failed login or lockout of account or IP address, it
+// implements authz.LoginAuditor
+func (l *Logger) Login(ctx context.Context, event authz.LoginEvent) error {
+	rec := Record{
+		Subject:  event.Account,
+		Kind:     "login",
+		Action:   event.Action,
+		Resource: "login",
+		IP:       event.IP,
+		Details: map[string]any{
+			"failures": event.Failures,
+		},
+	}
+	if event.LockedUntil > 0 {
+		rec.Details["locked_until"] = event.LockedUntil
+	}
+	return l.Record(ctx, rec)
+}
+
+// QueryHandler provides gin handler of audit records query API. It accepts
+// subject, action, resource, since and until (RFC3339 or unix seconds) and
+// limit query parameters.
+func (l *Logger) QueryHandler(c *gin.Context) {
+	q := Query{
+		Subject:  c.Query("subject"),
+		Action:   c.Query("action"),
+		Resource: c.Query("resource"),
+		Limit:    100,
+	}
+	var err error
+	if val := c.Query("limit"); val != "" {
+		if q.Limit, err = strconv.Atoi(val); err != nil {
+			rec := services.Response("audit", http.StatusBadRequest, services.ParametersError, fmt.Errorf("invalid limit %s", val))
+			c.JSON(http.StatusBadRequest, rec)
+			return
+		}
+	}
+	if q.Since, err = parseTime(c.Query("since")); err == nil {
+		q.Until, err = parseTime(c.Query("until"))
+	}
+	if err != nil {
+		rec := services.Response("audit", http.StatusBadRequest, services.ParametersError, err)
+		c.JSON(http.StatusBadRequest, rec)
+		return
+	}
+	records, err := l.Query(c.Request.Context(), q)
+	if err != nil {
+		rec := services.Response("audit", http.StatusInternalServerError, services.GenericError, err)
+		c.JSON(http.StatusInternalServerError, rec)
+		return
+	}
+	if records == nil {
+		records = []Record{}
+	}
+	c.JSON(http.StatusOK, records)
+}
+
+// helper function to parse time given either in RFC3339 format or as unix
+// seconds
+func parseTime(val string) (time.Time, error) {
+	if val == "" {
+		return time.Time{}, nil
+	}
+	if sec, err := strconv.ParseInt(val, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	t, err := time.Parse(time.RFC3339, val)
+	if err != nil {
+		return t, fmt.Errorf("invalid time %s, error %v", val, err)
+	}
+	return t, nil
+}
@@ -0,0 +1,207 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/CHESSComputing/golib/storage"
+)
+
+// DocumentStore keeps audit records within document storage, e.g. MongoDB
+// collection. Records are only inserted and never updated.
+type DocumentStore struct {
+	Store      storage.Store
+	Collection string
+}
+
+// NewDocumentStore returns audit store within given document storage
+func NewDocumentStore(store storage.Store) *DocumentStore {
+	return &DocumentStore{Store: store, Collection: Collection}
+}
+
+// NewMongoStore returns audit store within MongoDB database, MongoDB
+// connection should be initialized via mongo.InitMongoDB
+func NewMongoStore(dbname string) *DocumentStore {
+	return NewDocumentStore(storage.NewMongoStore(dbname))
+}
+
+// Append implements Store interface
+func (s *DocumentStore) Append(ctx context.Context, rec *Record) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	doc["_id"] = rec.ID
+	delete(doc, "id")
+	return s.Store.Insert(ctx, s.Collection, doc)
+}
+
+// Query implements Store interface
+func (s *DocumentStore) Query(ctx context.Context, q Query) ([]Record, error) {
+	spec := make(map[string]any)
+	if q.Subject != "" {
+		spec["subject"] = q.Subject
+	}
+	if q.Action != "" {
+		spec["action"] = q.Action
+	}
+	if q.Resource != "" {
+		spec["resource"] = q.Resource
+	}
+	docs, err := s.Store.Find(ctx, s.Collection, spec, &storage.FindOptions{Sort: []string{"-time"}})
+	if err != nil {
+		return nil, err
+	}
+	var records []Record
+	for _, doc := range docs {
+		doc["id"] = doc["_id"]
+		delete(doc, "_id")
+		data, err := json.Marshal(doc)
+		if err != nil {
+			return nil, err
+		}
+		var rec Record
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return nil, err
+		}
+		if q.matches(rec) {
+			records = append(records, rec)
+		}
+	}
+	return latest(records, q.Limit), nil
+}
+
+// FileStore keeps audit records in local file, one JSON record per line.
+// Records are hash chained, i.e. every record contains hash of previous
+// one, which allows to detect modification or removal of records.
+type FileStore struct {
+	Path string
+
+	mu       sync.Mutex
+	lastHash string
+	loaded   bool
+}
+
+// NewFileStore returns audit store within given file
+func NewFileStore(fname string) *FileStore {
+	return &FileStore{Path: fname}
+}
+
+// RecordHash provides hash of audit record which includes hash of previous
+// record
+func RecordHash(rec Record) string {
+	rec.Hash = ""
+	data, _ := json.Marshal(rec)
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+// helper function to read all records from the file
+func (s *FileStore) read() ([]Record, error) {
+	file, err := os.Open(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var records []Record
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var rec Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("unable to parse audit record at line %d of %s, error %v", line, s.Path, err)
+		}
+		records = append(records, rec)
+	}
+	return records, scanner.Err()
+}
+
+// Append implements Store interface
+func (s *FileStore) Append(ctx context.Context, rec *Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.loaded {
+		records, err := s.read()
+		if err != nil {
+			return err
+		}
+		if len(records) > 0 {
+			s.lastHash = records[len(records)-1].Hash
+		}
+		s.loaded = true
+	}
+	rec.PrevHash = s.lastHash
+	rec.Hash = RecordHash(*rec)
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(s.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	s.lastHash = rec.Hash
+	return nil
+}
+
+// Query implements Store interface
+func (s *FileStore) Query(ctx context.Context, q Query) ([]Record, error) {
+	s.mu.Lock()
+	records, err := s.read()
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	var out []Record
+	for _, rec := range records {
+		if q.matches(rec) {
+			out = append(out, rec)
+		}
+	}
+	return latest(out, q.Limit), nil
+}
+
+// Verify verifies hash chain of audit records and returns error describing
+// first broken record
+func (s *FileStore) Verify() error {
+	s.mu.Lock()
+	records, err := s.read()
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	var prev string
+	for idx, rec := range records {
+		if rec.PrevHash != prev {
+			return fmt.Errorf("audit record %d (%s) does not follow previous record", idx+1, rec.ID)
+		}
+		if RecordHash(rec) != rec.Hash {
+			return fmt.Errorf("audit record %d (%s) is modified", idx+1, rec.ID)
+		}
+		prev = rec.Hash
+	}
+	return nil
+}
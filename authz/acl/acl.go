@@ -0,0 +1,218 @@
+package acl
+
+// acl module provides per-record access control lists. ACL is stored with
+// the record and it grants read or write access to co-owners, users and
+// groups, i.e. proposal teams can share records with collaborators without
+// administrator involvement. Groups are matched against roles of token
+// claims and groups of users provided by group resolver.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	policy "github.com/CHESSComputing/golib/authz/policy"
+)
+
+// access levels, every level includes lower ones
+const (
+	AccessNone  = ""
+	AccessRead  = "read"
+	AccessWrite = "write"
+	AccessOwner = "owner" // owners and co-owners manage ACL of the record
+)
+
+// Key defines record key which holds ACL of the record
+var Key = "_acl"
+
+// DefaultOwnerKey defines record key which holds owner of records without
+// ACL
+var DefaultOwnerKey = "user"
+
+// MaxGrants defines maximum number of co-owners and grants of single ACL
+var MaxGrants = 100
+
+// ACL actions evaluated by the manager
+const (
+	ActionRead     = "acl.read"
+	ActionUpdate   = "acl.update"
+	ActionTransfer = "acl.transfer" // change of record owner by others than the owner
+)
+
+// DefaultActions defines access levels required by policy actions
+var DefaultActions = map[string]string{
+	"record.read":   AccessRead,
+	"record.update": AccessWrite,
+	"record.delete": AccessOwner,
+	ActionRead:      AccessRead,
+	ActionUpdate:    AccessOwner,
+}
+
+// ErrInvalid is returned for invalid ACLs
+var ErrInvalid = errors.New("invalid ACL")
+
+// Grant represents access granted to user or group
+type Grant struct {
+	Name   string `json:"name"`
+	Access string `json:"access"` // read or write
+}
+
+// ACL represents access control list of the record
+type ACL struct {
+	Owner    string   `json:"owner"`
+	CoOwners []string `json:"co_owners,omitempty"`
+	Users    []Grant  `json:"users,omitempty"`
+	Groups   []Grant  `json:"groups,omitempty"`
+}
+
+// helper function to order access levels
+func level(access string) int {
+	switch access {
+	case AccessRead:
+		return 1
+	case AccessWrite:
+		return 2
+	case AccessOwner:
+		return 3
+	}
+	return 0
+}
+
+// Validate checks ACL, owner is required and grants should provide read
+// or write access
+func (a ACL) Validate() error {
+	if a.Owner == "" {
+		return fmt.Errorf("%w, owner is required", ErrInvalid)
+	}
+	if len(a.CoOwners)+len(a.Users)+len(a.Groups) > MaxGrants {
+		return fmt.Errorf("%w, more than %d grants", ErrInvalid, MaxGrants)
+	}
+	for _, name := range a.CoOwners {
+		if name == "" {
+			return fmt.Errorf("%w, empty co-owner", ErrInvalid)
+		}
+	}
+	for _, g := range append(append([]Grant{}, a.Users...), a.Groups...) {
+		if g.Name == "" {
+			return fmt.Errorf("%w, grant without name", ErrInvalid)
+		}
+		if g.Access != AccessRead && g.Access != AccessWrite {
+			return fmt.Errorf("%w, unsupported access '%s' of %s", ErrInvalid, g.Access, g.Name)
+		}
+	}
+	return nil
+}
+
+// Access returns access level of given user with given groups
+func (a ACL) Access(user string, groups []string) string {
+	if user == "" {
+		return AccessNone
+	}
+	if user == a.Owner {
+		return AccessOwner
+	}
+	for _, name := range a.CoOwners {
+		if name == user {
+			return AccessOwner
+		}
+	}
+	access := AccessNone
+	for _, g := range a.Users {
+		if g.Name == user && level(g.Access) > level(access) {
+			access = g.Access
+		}
+	}
+	for _, g := range a.Groups {
+		for _, group := range groups {
+			if g.Name == group && level(g.Access) > level(access) {
+				access = g.Access
+			}
+		}
+	}
+	return access
+}
+
+// Allows checks if ACL grants given access to the user with given groups
+func (a ACL) Allows(user string, groups []string, access string) bool {
+	return level(access) > 0 && level(a.Access(user, groups)) >= level(access)
+}
+
+// FromRecord returns ACL of the record, records without ACL are owned by
+// user of given owner key
+func FromRecord(rec map[string]any, ownerKey string) (ACL, error) {
+	var a ACL
+	val, ok := rec[Key]
+	if !ok || val == nil {
+		a.Owner, _ = rec[ownerKey].(string)
+		return a, nil
+	}
+	// ACL is stored as nested document, e.g. bson.M of MongoDB records
+	data, err := json.Marshal(val)
+	if err != nil {
+		return a, err
+	}
+	if err := json.Unmarshal(data, &a); err != nil {
+		return a, fmt.Errorf("%w of record %v, error %v", ErrInvalid, rec["_id"], err)
+	}
+	return a, nil
+}
+
+// ToRecord returns ACL as nested document of the record
+func (a ACL) ToRecord() map[string]any {
+	out := map[string]any{"owner": a.Owner}
+	if len(a.CoOwners) > 0 {
+		out["co_owners"] = append([]string{}, a.CoOwners...)
+	}
+	grants := func(list []Grant) []map[string]any {
+		var out []map[string]any
+		for _, g := range list {
+			out = append(out, map[string]any{"name": g.Name, "access": g.Access})
+		}
+		return out
+	}
+	if len(a.Users) > 0 {
+		out["users"] = grants(a.Users)
+	}
+	if len(a.Groups) > 0 {
+		out["groups"] = grants(a.Groups)
+	}
+	return out
+}
+
+// Evaluator represents policy evaluator which allows actions granted by
+// ACL of the policy record, other requests are evaluated by Next evaluator
+type Evaluator struct {
+	Next     policy.Evaluator     // evaluator of requests not granted by ACL, nil denies them
+	Actions  map[string]string    // access levels required by actions
+	OwnerKey string               // owner key of records without ACL
+	Groups   policy.GroupResolver // resolver of user groups, e.g. groups module, roles are used as groups if nil
+}
+
+// NewEvaluator returns ACL evaluator with default actions on top of given
+// evaluator
+func NewEvaluator(next policy.Evaluator) *Evaluator {
+	return &Evaluator{Next: next, Actions: DefaultActions, OwnerKey: DefaultOwnerKey}
+}
+
+// Allowed implements policy.Evaluator interface
+func (e *Evaluator) Allowed(ctx context.Context, input policy.Input) (bool, error) {
+	input, err := policy.ResolveGroups(ctx, e.Groups, input)
+	if err != nil {
+		return false, err
+	}
+	if access, ok := e.Actions[input.Action]; ok && input.Record != nil {
+		a, err := FromRecord(input.Record, e.OwnerKey)
+		if err != nil {
+			return false, err
+		}
+		groups := append(append([]string{}, input.Roles...), input.Groups...)
+		if a.Allows(input.Subject, groups, access) {
+			return true, nil
+		}
+	}
+	if e.Next == nil {
+		return false, nil
+	}
+	return e.Next.Allowed(ctx, input)
+}
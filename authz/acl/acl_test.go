@@ -0,0 +1,185 @@
+package acl
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	policy "github.com/CHESSComputing/golib/authz/policy"
+	storage "github.com/CHESSComputing/golib/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// helper function to create claims of given user and roles
+func claims(user string, roles ...string) *authz.Claims {
+	return &authz.Claims{CustomClaims: authz.CustomClaims{User: user, Roles: roles}}
+}
+
+// TestAccess
+func TestAccess(t *testing.T) {
+	a := ACL{
+		Owner:    "alice",
+		CoOwners: []string{"bob"},
+		Users:    []Grant{{Name: "carol", Access: AccessRead}, {Name: "carol", Access: AccessWrite}},
+		Groups:   []Grant{{Name: "p-1234", Access: AccessRead}},
+	}
+	if err := a.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	expect := []struct {
+		user   string
+		groups []string
+		access string
+	}{
+		{"alice", nil, AccessOwner},
+		{"bob", nil, AccessOwner},
+		{"carol", nil, AccessWrite},
+		{"dave", []string{"p-1234"}, AccessRead},
+		{"dave", []string{"p-9999"}, AccessNone},
+		{"", []string{"p-1234"}, AccessNone},
+	}
+	for _, e := range expect {
+		if access := a.Access(e.user, e.groups); access != e.access {
+			t.Errorf("user %s groups %v: expect %q, got %q", e.user, e.groups, e.access, access)
+		}
+	}
+	invalid := []ACL{
+		{},
+		{Owner: "alice", CoOwners: []string{""}},
+		{Owner: "alice", Users: []Grant{{Name: "bob", Access: AccessOwner}}},
+		{Owner: "alice", Groups: []Grant{{Access: AccessRead}}},
+	}
+	for _, a := range invalid {
+		if err := a.Validate(); !errors.Is(err, ErrInvalid) {
+			t.Errorf("invalid ACL %+v is accepted, error %v", a, err)
+		}
+	}
+
+	// ACL survives round trip through the record
+	rec := map[string]any{"_id": "/a", Key: a.ToRecord()}
+	if b, err := FromRecord(rec, DefaultOwnerKey); err != nil || b.Access("carol", nil) != AccessWrite {
+		t.Errorf("wrong ACL of the record %+v, error %v", b, err)
+	}
+	if b, _ := FromRecord(map[string]any{"user": "alice"}, DefaultOwnerKey); b.Owner != "alice" {
+		t.Errorf("wrong owner of record without ACL %+v", b)
+	}
+}
+
+// TestEvaluator
+func TestEvaluator(t *testing.T) {
+	next, err := policy.NewEngine(map[string][]string{"*": {"role='admin'"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := NewEvaluator(next)
+	rec := map[string]any{"_id": "/a", "user": "alice", Key: map[string]any{
+		"owner":  "alice",
+		"groups": []any{map[string]any{"name": "p-1234", "access": "read"}},
+	}}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set("claims", claims(c.GetHeader("X-User"), c.Request.Header.Values("X-Role")...))
+	})
+	loader := func(c *gin.Context) (map[string]any, error) {
+		return rec, nil
+	}
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	r.GET("/record", policy.PolicyMiddleware(e, "record.read", loader, "", 0), ok)
+	r.PUT("/record", policy.PolicyMiddleware(e, "record.update", loader, "", 0), ok)
+	expect := []struct {
+		method, user, role string
+		code               int
+	}{
+		{"GET", "alice", "", http.StatusOK},
+		{"PUT", "alice", "", http.StatusOK},
+		{"GET", "bob", "p-1234", http.StatusOK},
+		{"PUT", "bob", "p-1234", http.StatusForbidden},
+		{"GET", "bob", "", http.StatusForbidden},
+		{"PUT", "root", "admin", http.StatusOK},
+	}
+	for _, x := range expect {
+		req := httptest.NewRequest(x.method, "/record", nil)
+		req.Header.Set("X-User", x.user)
+		if x.role != "" {
+			req.Header.Set("X-Role", x.role)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != x.code {
+			t.Errorf("%s of %s: expect %d, got %d %s", x.method, x.user, x.code, w.Code, w.Body.String())
+		}
+	}
+
+	// groups of users are provided by group resolver
+	e.Groups = staticGroups{"dave": {"p-1234"}}
+	input := policy.Input{Action: "record.read", Subject: "dave", Record: rec}
+	if ok, err := e.Allowed(context.Background(), input); err != nil || !ok {
+		t.Errorf("member of resolved group is denied, error %v", err)
+	}
+}
+
+// helper type to resolve groups of users from static map
+type staticGroups map[string][]string
+
+// UserGroups implements policy.GroupResolver interface
+func (s staticGroups) UserGroups(ctx context.Context, user string) ([]string, error) {
+	return s[user], nil
+}
+
+// TestHandlers
+func TestHandlers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := storage.NewMemoryStore()
+	store.Insert(context.Background(), "meta", map[string]any{"_id": "a", "user": "alice"})
+	next, _ := policy.NewEngine(map[string][]string{"*": {"role='admin'"}})
+	m := NewManager(store, "meta", next)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		if user := c.GetHeader("X-User"); user != "" {
+			c.Set("claims", claims(user, c.Request.Header.Values("X-Role")...))
+		}
+	})
+	for _, route := range m.Routes("/records") {
+		r.Handle(route.Method, route.Path, route.Handler)
+	}
+	expect := []struct {
+		method, target, user, role, body string
+		code                             int
+	}{
+		{"GET", "/records/a/acl", "alice", "", "", http.StatusOK},
+		{"GET", "/records/a/acl", "bob", "", "", http.StatusForbidden},
+		{"GET", "/records/b/acl", "alice", "", "", http.StatusNotFound},
+		{"GET", "/records/a/acl", "", "", "", http.StatusUnauthorized},
+		{"PUT", "/records/a/acl", "alice", "", `{"owner": "alice", "co_owners": ["bob"], "groups": [{"name": "p-1234", "access": "write"}]}`, http.StatusOK},
+		{"PUT", "/records/a/acl", "alice", "", `{"owner": "alice", "users": [{"name": "carol", "access": "admin"}]}`, http.StatusBadRequest},
+		{"PUT", "/records/a/acl", "alice", "", `[`, http.StatusBadRequest},
+		// co-owners and group members read the ACL, but only owners change it
+		{"GET", "/records/a/acl", "bob", "", "", http.StatusOK},
+		{"GET", "/records/a/acl", "carol", "p-1234", "", http.StatusOK},
+		{"PUT", "/records/a/acl", "carol", "p-1234", `{"owner": "carol"}`, http.StatusForbidden},
+		{"PUT", "/records/a/acl", "bob", "", `{"owner": "bob"}`, http.StatusForbidden},
+		{"PUT", "/records/a/acl", "root", "admin", `{"owner": "bob", "co_owners": ["alice"]}`, http.StatusOK},
+		{"PUT", "/records/a/acl", "alice", "", `{"owner": "alice"}`, http.StatusForbidden},
+	}
+	for _, e := range expect {
+		req := httptest.NewRequest(e.method, e.target, strings.NewReader(e.body))
+		req.Header.Set("X-User", e.user)
+		if e.role != "" {
+			req.Header.Set("X-Role", e.role)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != e.code {
+			t.Errorf("%s %s of %s: expect %d, got %d %s", e.method, e.target, e.user, e.code, w.Code, w.Body.String())
+		}
+	}
+	a, err := m.Get(context.Background(), claims("bob"), "a")
+	if err != nil || a.Owner != "bob" || a.Access("alice", nil) != AccessOwner {
+		t.Errorf("wrong ACL after transfer %+v, error %v", a, err)
+	}
+}
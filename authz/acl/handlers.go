@@ -0,0 +1,174 @@
+package acl
+
+// handlers module provides HTTP endpoints which manage ACLs of records
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	policy "github.com/CHESSComputing/golib/authz/policy"
+	server "github.com/CHESSComputing/golib/server"
+	services "github.com/CHESSComputing/golib/services"
+	storage "github.com/CHESSComputing/golib/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// ErrForbidden is returned when user is not allowed to manage the ACL
+var ErrForbidden = errors.New("user is not allowed to manage ACL")
+
+// Manager manages ACLs of records of given collection
+type Manager struct {
+	Store      storage.Store
+	Collection string
+	Evaluator  *Evaluator // evaluator of ACL actions, Next evaluator may allow administrators
+}
+
+// NewManager returns ACL manager of records of given collection, ACL
+// actions not granted by ACLs are evaluated by given evaluator
+func NewManager(store storage.Store, collection string, next policy.Evaluator) *Manager {
+	return &Manager{Store: store, Collection: collection, Evaluator: NewEvaluator(next)}
+}
+
+// helper function to load record and check that user may perform action
+func (m *Manager) authorize(ctx context.Context, claims *authz.Claims, id, action string) (map[string]any, error) {
+	rec, err := storage.FindOne(ctx, m.Store, m.Collection, map[string]any{"_id": id})
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, fmt.Errorf("%w '%s'", policy.ErrRecordNotFound, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	allowed, err := m.Evaluator.Allowed(ctx, policy.NewInput(action, claims, rec))
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, fmt.Errorf("%w of record %s", ErrForbidden, id)
+	}
+	return rec, nil
+}
+
+// Get returns ACL of the record if user of given claims may read it
+func (m *Manager) Get(ctx context.Context, claims *authz.Claims, id string) (ACL, error) {
+	rec, err := m.authorize(ctx, claims, id, ActionRead)
+	if err != nil {
+		return ACL{}, err
+	}
+	return FromRecord(rec, m.Evaluator.OwnerKey)
+}
+
+// Set replaces ACL of the record if user of given claims may update it.
+// Only the owner transfers the record to other owner unless Next evaluator
+// allows ActionTransfer, e.g. to administrators.
+func (m *Manager) Set(ctx context.Context, claims *authz.Claims, id string, a ACL) (ACL, error) {
+	if err := a.Validate(); err != nil {
+		return a, err
+	}
+	rec, err := m.authorize(ctx, claims, id, ActionUpdate)
+	if err != nil {
+		return a, err
+	}
+	current, err := FromRecord(rec, m.Evaluator.OwnerKey)
+	if err != nil {
+		return a, err
+	}
+	if a.Owner != current.Owner && claims.CustomClaims.User != current.Owner {
+		allowed := false
+		if m.Evaluator.Next != nil {
+			input, err := policy.ResolveGroups(ctx, m.Evaluator.Groups, policy.NewInput(ActionTransfer, claims, rec))
+			if err != nil {
+				return a, err
+			}
+			allowed, err = m.Evaluator.Next.Allowed(ctx, input)
+			if err != nil {
+				return a, err
+			}
+		}
+		if !allowed {
+			return a, fmt.Errorf("%w, only owner may transfer record %s", ErrForbidden, id)
+		}
+	}
+	_, err = m.Store.Update(ctx, m.Collection, map[string]any{"_id": id}, map[string]any{Key: a.ToRecord()})
+	return a, err
+}
+
+// helper function to abort request with service error
+func abort(c *gin.Context, code, srvCode int, err error) {
+	rec := services.Response("acl", code, srvCode, err)
+	c.AbortWithStatusJSON(code, rec)
+}
+
+// helper function to abort request with error of the manager
+func abortWithError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, policy.ErrRecordNotFound):
+		abort(c, http.StatusNotFound, services.QueryError, err)
+	case errors.Is(err, ErrForbidden):
+		abort(c, http.StatusForbidden, services.PolicyError, err)
+	case errors.Is(err, ErrInvalid):
+		abort(c, http.StatusBadRequest, services.ValidateError, err)
+	default:
+		log.Printf("ERROR: ACL request failed, error %v", err)
+		abort(c, http.StatusInternalServerError, services.DatabaseError, err)
+	}
+}
+
+// helper function to provide token claims of the request
+func requestClaims(c *gin.Context) (*authz.Claims, bool) {
+	if val, ok := c.Get("claims"); ok {
+		if claims, ok := val.(*authz.Claims); ok && claims.CustomClaims.User != "" {
+			return claims, true
+		}
+	}
+	abort(c, http.StatusUnauthorized, services.CredentialsError, errors.New("no token claims"))
+	return nil, false
+}
+
+// GetHandler provides ACL of the record
+func (m *Manager) GetHandler(c *gin.Context) {
+	claims, ok := requestClaims(c)
+	if !ok {
+		return
+	}
+	a, err := m.Get(c.Request.Context(), claims, c.Param("id"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, a)
+}
+
+// SetHandler replaces ACL of the record, the request body is JSON ACL
+func (m *Manager) SetHandler(c *gin.Context) {
+	claims, ok := requestClaims(c)
+	if !ok {
+		return
+	}
+	var a ACL
+	if err := c.ShouldBindJSON(&a); err != nil {
+		abort(c, http.StatusBadRequest, services.BindError, err)
+		return
+	}
+	a, err := m.Set(c.Request.Context(), claims, c.Param("id"), a)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, a)
+}
+
+// Routes returns server routes of ACLs under given path, e.g. /records,
+// i.e. ACLs are managed via <path>/:id/acl. Access to ACLs is decided by
+// ACLs themselves.
+func (m *Manager) Routes(path string) []server.Route {
+	return []server.Route{
+		{Method: "GET", Path: path + "/:id/acl", Authorized: true, Scope: "read", Handler: m.GetHandler,
+			Summary: "get ACL of the record", Response: ACL{}},
+		{Method: "PUT", Path: path + "/:id/acl", Authorized: true, Scope: "write", Handler: m.SetHandler,
+			Summary: "replace ACL of the record", Request: ACL{}, Response: ACL{}},
+	}
+}
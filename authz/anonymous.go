@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"log"
+	"net/http"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	utils "github.com/CHESSComputing/golib/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// AnonymousKind defines kind of claims given to anonymous requests
+const AnonymousKind = "anonymous"
+
+// DefaultAnonymousUser defines name of anonymous user
+const DefaultAnonymousUser = "anonymous"
+
+// DefaultAnonymousScopes defines scopes granted to anonymous user
+var DefaultAnonymousScopes = []string{"read"}
+
+// helper function to get anonymous access configuration
+func anonymousConfig() (srvConfig.Anonymous, bool) {
+	if srvConfig.Config == nil || !srvConfig.Config.Authz.Anonymous.Enabled {
+		return srvConfig.Anonymous{}, false
+	}
+	cfg := srvConfig.Config.Authz.Anonymous
+	if cfg.User == "" {
+		cfg.User = DefaultAnonymousUser
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = DefaultAnonymousScopes
+	}
+	return cfg, true
+}
+
+// AnonymousClaims provides claims of anonymous user for given scope, read
+// scope is assumed if scope is empty. It returns false if anonymous access
+// is disabled or scope is not granted to anonymous user, write scope is
+// never granted.
+func AnonymousClaims(scope string) (*Claims, bool) {
+	cfg, ok := anonymousConfig()
+	if !ok {
+		return nil, false
+	}
+	if scope == "" {
+		scope = "read"
+	}
+	if scope == "write" || !utils.InList(scope, cfg.Scopes) {
+		return nil, false
+	}
+	claims := &Claims{
+		CustomClaims: CustomClaims{User: cfg.User, Scope: scope, Kind: AnonymousKind, Roles: cfg.Roles},
+	}
+	claims.Subject = cfg.User
+	return claims, true
+}
+
+// IsAnonymous checks if claims belong to anonymous user
+func IsAnonymous(claims *Claims) bool {
+	return claims != nil && claims.CustomClaims.Kind == AnonymousKind
+}
+
+// helper function to check if request method only reads resources,
+// anonymous user is read-only identity
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// helper function to check if request provides any credentials
+func hasCredentials(r *http.Request) bool {
+	return r.Header.Get("Authorization") != "" || r.Header.Get(APIKeyHeader) != ""
+}
+
+// helper function to authorize request without credentials as anonymous
+// user, it sets anonymous claims in gin context and returns true if request
+// is authorized. Only GET, HEAD and OPTIONS requests are authorized, write
+// requests require credentials regardless of the route scope.
+func anonymousAccess(c *gin.Context, scope string, verbose int) bool {
+	if hasCredentials(c.Request) || !safeMethod(c.Request.Method) {
+		return false
+	}
+	claims, ok := AnonymousClaims(scope)
+	if !ok {
+		return false
+	}
+	if verbose > 0 {
+		log.Printf("INFO: anonymous request %s %s with scope '%s'", c.Request.Method, c.Request.URL.Path, claims.CustomClaims.Scope)
+	}
+	c.Set("claims", claims)
+	c.Set("user", claims.CustomClaims.User)
+	return true
+}
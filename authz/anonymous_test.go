@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	"github.com/gin-gonic/gin"
+)
+
+// TestAnonymous
+func TestAnonymous(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	config := srvConfig.Config
+	t.Cleanup(func() { srvConfig.Config = config })
+	srvConfig.Config = &srvConfig.SrvConfig{}
+	srvConfig.Config.Authz.ClientID = "secret"
+
+	var user string
+	var anonymous bool
+	router := gin.New()
+	handler := func(c *gin.Context) {
+		user = c.GetString("user")
+		if val, ok := c.Get("claims"); ok {
+			anonymous = IsAnonymous(val.(*Claims))
+		}
+		c.String(http.StatusOK, "ok")
+	}
+	router.GET("/read", TokenMiddleware("secret", 0), handler)
+	router.POST("/read", TokenMiddleware("secret", 0), handler)
+	router.POST("/keys", KeyOrTokenMiddleware(nil, "", "secret", 0), handler)
+	router.GET("/public", ScopeTokenMiddleware("public", "secret", 0), handler)
+	router.POST("/write", ScopeTokenMiddleware("write", "secret", 0), handler)
+	router.GET("/keys", KeyOrTokenMiddleware(nil, "", "secret", 0), handler)
+	serve := func(method, path, token string) int {
+		r := httptest.NewRequest(method, path, nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// anonymous access is disabled by default
+	if code := serve("GET", "/read", ""); code != http.StatusUnauthorized {
+		t.Errorf("anonymous request is accepted, code %d", code)
+	}
+
+	srvConfig.Config.Authz.Anonymous = srvConfig.Anonymous{Enabled: true, User: "guest", Scopes: []string{"read", "public", "write"}}
+	for _, path := range []string{"/read", "/public", "/keys"} {
+		user = ""
+		if code := serve("GET", path, ""); code != http.StatusOK || user != "guest" || !anonymous {
+			t.Errorf("anonymous request to %s is rejected, code %d user %s", path, code, user)
+		}
+	}
+	for _, path := range []string{"/write", "/read", "/keys"} {
+		if code := serve("POST", path, ""); code != http.StatusUnauthorized {
+			t.Errorf("anonymous POST request to %s is accepted, code %d", path, code)
+		}
+	}
+	// invalid credentials are never downgraded to anonymous access
+	if code := serve("GET", "/read", "invalid"); code != http.StatusUnauthorized {
+		t.Errorf("request with invalid token is accepted, code %d", code)
+	}
+	write, _ := JWTAccessToken("secret", 60, CustomClaims{User: "alice", Scope: "write"})
+	if code := serve("POST", "/write", write); code != http.StatusOK || user != "alice" || anonymous {
+		t.Errorf("write request is rejected, code %d", code)
+	}
+
+	// default scopes
+	srvConfig.Config.Authz.Anonymous = srvConfig.Anonymous{Enabled: true}
+	if claims, ok := AnonymousClaims(""); !ok || claims.CustomClaims.User != DefaultAnonymousUser || claims.CustomClaims.Scope != "read" {
+		t.Errorf("unexpected anonymous claims %+v", claims)
+	}
+	if code := serve("GET", "/public", ""); code != http.StatusUnauthorized {
+		t.Errorf("anonymous request with not granted scope is accepted, code %d", code)
+	}
+}
@@ -0,0 +1,305 @@
+package auth
+
+// apikeys module provides long-lived API keys for programmatic access,
+// e.g. automated beamline pipelines which can not use interactive OAuth.
+// Only hashes of API keys are stored, keys are scoped, can expire and be
+// revoked.
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	services "github.com/CHESSComputing/golib/services"
+	"github.com/CHESSComputing/golib/storage"
+	utils "github.com/CHESSComputing/golib/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// APIKeyHeader defines HTTP header used to pass API keys
+var APIKeyHeader = "X-Api-Key"
+
+// APIKeyPrefix defines prefix of issued API keys
+var APIKeyPrefix = "fxd"
+
+// APIKeysCollection defines storage collection of API keys
+const APIKeysCollection = "api_keys"
+
+// errors of API keys
+var (
+	ErrInvalidAPIKey = errors.New("invalid API key")
+	ErrExpiredAPIKey = errors.New("API key is expired")
+	ErrRevokedAPIKey = errors.New("API key is revoked")
+)
+
+// APIKey represents API key record, the key itself is never stored
+type APIKey struct {
+	ID       string   `json:"id"`
+	Name     string   `json:"name"` // description of the key, e.g. pipeline name
+	User     string   `json:"user"`
+	Scope    string   `json:"scope"`
+	Roles    []string `json:"roles"`
+	Hash     string   `json:"-"`
+	Created  int64    `json:"created"`
+	Expires  int64    `json:"expires"` // 0 means key never expires
+	LastUsed int64    `json:"last_used"`
+	Revoked  bool     `json:"revoked"`
+}
+
+// Claims provides token claims of the API key, it allows handlers to
+// treat API keys and JWT tokens in the same way
+func (k APIKey) Claims() *Claims {
+	claims := &Claims{
+		CustomClaims: CustomClaims{User: k.User, Scope: k.Scope, Kind: "apikey", Roles: k.Roles},
+	}
+	claims.ID = k.ID
+	claims.Subject = k.User
+	return claims
+}
+
+// helper function to convert API key into storage record
+func (k APIKey) record() map[string]any {
+	return map[string]any{
+		"_id":       k.ID,
+		"name":      k.Name,
+		"user":      k.User,
+		"scope":     k.Scope,
+		"roles":     k.Roles,
+		"hash":      k.Hash,
+		"created":   k.Created,
+		"expires":   k.Expires,
+		"last_used": k.LastUsed,
+		"revoked":   k.Revoked,
+	}
+}
+
+// helper function to convert storage record into API key
+func apiKeyRecord(rec map[string]any) APIKey {
+	str := func(key string) string {
+		v, _ := rec[key].(string)
+		return v
+	}
+	num := func(key string) int64 {
+		switch v := rec[key].(type) {
+		case int:
+			return int64(v)
+		case int32:
+			return int64(v)
+		case int64:
+			return v
+		case float64:
+			return int64(v)
+		}
+		return 0
+	}
+	var roles []string
+	// MongoDB returns arrays as primitive.A
+	if list, ok := utils.ListValues(rec["roles"]); ok {
+		for _, r := range list {
+			roles = append(roles, fmt.Sprintf("%v", r))
+		}
+	}
+	revoked, _ := rec["revoked"].(bool)
+	return APIKey{
+		ID:       str("_id"),
+		Name:     str("name"),
+		User:     str("user"),
+		Scope:    str("scope"),
+		Roles:    roles,
+		Hash:     str("hash"),
+		Created:  num("created"),
+		Expires:  num("expires"),
+		LastUsed: num("last_used"),
+		Revoked:  revoked,
+	}
+}
+
+// helper function to hash API key secret
+func apiKeyHash(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
+
+// helper function to generate random hex string
+func randomHex(size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// APIKeys manages API keys persisted via storage interface
+type APIKeys struct {
+	Store   storage.Store
+	Verbose int
+}
+
+// NewAPIKeys returns API keys manager
+func NewAPIKeys(store storage.Store) *APIKeys {
+	return &APIKeys{Store: store}
+}
+
+// Issue issues new API key for given user, scope and roles. The key is
+// returned only once, ttl of zero means that key never expires.
+func (a *APIKeys) Issue(ctx context.Context, user, name, scope string, roles []string, ttl time.Duration) (string, APIKey, error) {
+	var key APIKey
+	if user == "" {
+		return "", key, errors.New("API key requires user")
+	}
+	id, err := randomHex(8)
+	if err != nil {
+		return "", key, err
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return "", key, err
+	}
+	now := time.Now()
+	key = APIKey{
+		ID:      id,
+		Name:    name,
+		User:    user,
+		Scope:   scope,
+		Roles:   roles,
+		Hash:    apiKeyHash(secret),
+		Created: now.Unix(),
+	}
+	if ttl != 0 {
+		key.Expires = now.Add(ttl).Unix()
+	}
+	if err := a.Store.Insert(ctx, APIKeysCollection, key.record()); err != nil {
+		log.Printf("ERROR: unable to store API key %s, error %v", id, err)
+		return "", key, err
+	}
+	return fmt.Sprintf("%s_%s_%s", APIKeyPrefix, id, secret), key, nil
+}
+
+// Validate validates given API key and returns its record
+func (a *APIKeys) Validate(ctx context.Context, apiKey string) (APIKey, error) {
+	var key APIKey
+	arr := strings.Split(apiKey, "_")
+	if len(arr) != 3 || arr[0] != APIKeyPrefix {
+		return key, ErrInvalidAPIKey
+	}
+	rec, err := storage.FindOne(ctx, a.Store, APIKeysCollection, map[string]any{"_id": arr[1]})
+	if errors.Is(err, storage.ErrNotFound) {
+		return key, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return key, err
+	}
+	key = apiKeyRecord(rec)
+	if subtle.ConstantTimeCompare([]byte(key.Hash), []byte(apiKeyHash(arr[2]))) != 1 {
+		return key, ErrInvalidAPIKey
+	}
+	if key.Revoked {
+		return key, ErrRevokedAPIKey
+	}
+	now := time.Now().Unix()
+	if key.Expires > 0 && key.Expires < now {
+		return key, ErrExpiredAPIKey
+	}
+	key.LastUsed = now
+	if _, err := a.Store.Update(ctx, APIKeysCollection, map[string]any{"_id": key.ID}, map[string]any{"last_used": now}); err != nil {
+		log.Printf("WARNING: unable to update API key %s, error %v", key.ID, err)
+	}
+	return key, nil
+}
+
+// Revoke revokes API key with given id
+func (a *APIKeys) Revoke(ctx context.Context, id string) error {
+	n, err := a.Store.Update(ctx, APIKeysCollection, map[string]any{"_id": id}, map[string]any{"revoked": true})
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrInvalidAPIKey
+	}
+	return nil
+}
+
+// List returns API keys of given user, empty user means all keys
+func (a *APIKeys) List(ctx context.Context, user string) ([]APIKey, error) {
+	var spec map[string]any
+	if user != "" {
+		spec = map[string]any{"user": user}
+	}
+	records, err := a.Store.Find(ctx, APIKeysCollection, spec, &storage.FindOptions{Sort: []string{"created"}})
+	if err != nil {
+		return nil, err
+	}
+	var keys []APIKey
+	for _, rec := range records {
+		keys = append(keys, apiKeyRecord(rec))
+	}
+	return keys, nil
+}
+
+// RequestClaims provides claims of HTTP request authorized either by
+// X-Api-Key header or by Authorization: Bearer JWT token
+func (a *APIKeys) RequestClaims(r *http.Request, clientId string) (*Claims, error) {
+	if apiKey := r.Header.Get(APIKeyHeader); apiKey != "" {
+		if a == nil {
+			return nil, errors.New("API keys are not supported")
+		}
+		key, err := a.Validate(r.Context(), apiKey)
+		if err != nil {
+			return nil, err
+		}
+		return key.Claims(), nil
+	}
+	tokenStr := RequestToken(r)
+	token := &Token{AccessToken: tokenStr}
+	if err := token.Validate(clientId); err != nil {
+		return nil, err
+	}
+	claims, err := TokenClaims(tokenStr, clientId)
+	if err != nil {
+		return nil, err
+	}
+	if err := VerifyTokenBinding(r, tokenStr, claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// KeyOrTokenMiddleware provides authorization via either API key or JWT
+// token with optional scope. Request claims are stored in gin context
+// under "claims" key and user name under "user" key.
+func KeyOrTokenMiddleware(keys *APIKeys, scope, clientId string, verbose int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if anonymousAccess(c, scope, verbose) {
+			c.Next()
+			return
+		}
+		claims, err := keys.RequestClaims(c.Request, clientId)
+		if err != nil {
+			log.Printf("ERROR: KeyOrTokenMiddleware: unable to authorize request, error %v", err)
+			rec := services.Response("authz", http.StatusUnauthorized, services.TokenError, err)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, rec)
+			return
+		}
+		if scope != "" && claims.CustomClaims.Scope != scope {
+			msg := fmt.Sprintf("KeyOrTokenMiddleware: scope '%s' does not match with scope '%s'", claims.CustomClaims.Scope, scope)
+			log.Println("ERROR:", msg)
+			rec := services.Response("authz", http.StatusUnauthorized, services.ScopeError, errors.New(msg))
+			c.AbortWithStatusJSON(http.StatusUnauthorized, rec)
+			return
+		}
+		if verbose > 0 {
+			log.Printf("INFO: request of user %s is authorized via %s", claims.CustomClaims.User, claims.CustomClaims.Kind)
+		}
+		c.Set("claims", claims)
+		c.Set("user", claims.CustomClaims.User)
+		c.Next()
+	}
+}
@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/CHESSComputing/golib/storage"
+	"github.com/gin-gonic/gin"
+	bson "go.mongodb.org/mongo-driver/bson"
+)
+
+// TestAPIKeys
+func TestAPIKeys(t *testing.T) {
+	ctx := context.Background()
+	keys := NewAPIKeys(storage.NewMemoryStore())
+	apiKey, key, err := keys.Issue(ctx, "pipeline", "id3a reduction", "write", []string{"beamline"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.Hash == "" || key.Hash == apiKey {
+		t.Error("API key should be stored as hash")
+	}
+	rec, err := keys.Validate(ctx, apiKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.User != "pipeline" || rec.Scope != "write" || rec.LastUsed == 0 {
+		t.Errorf("unexpected API key record %+v", rec)
+	}
+	if _, err := keys.Validate(ctx, apiKey+"0"); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("expected ErrInvalidAPIKey, got %v", err)
+	}
+	if _, err := keys.Validate(ctx, "bogus"); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("expected ErrInvalidAPIKey, got %v", err)
+	}
+	expiredKey, _, _ := keys.Issue(ctx, "pipeline", "expired", "read", nil, -time.Second)
+	if _, err := keys.Validate(ctx, expiredKey); !errors.Is(err, ErrExpiredAPIKey) {
+		t.Errorf("expected ErrExpiredAPIKey, got %v", err)
+	}
+	if list, err := keys.List(ctx, "pipeline"); err != nil || len(list) != 2 {
+		t.Errorf("unexpected list of keys %+v error %v", list, err)
+	}
+
+	// middleware accepts either API key or JWT token
+	gin.SetMode(gin.TestMode)
+	secret := "test-secret"
+	r := gin.New()
+	r.POST("/data", KeyOrTokenMiddleware(keys, "write", secret, 0), func(c *gin.Context) {
+		c.String(http.StatusOK, c.GetString("user"))
+	})
+	call := func(header, value string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/data", nil)
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	if w := call(APIKeyHeader, apiKey); w.Code != http.StatusOK || w.Body.String() != "pipeline" {
+		t.Errorf("API key request failed, code %d body %s", w.Code, w.Body.String())
+	}
+	token, err := JWTAccessToken(secret, 60, CustomClaims{User: "alice", Scope: "write"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := call("Authorization", "Bearer "+token); w.Code != http.StatusOK || w.Body.String() != "alice" {
+		t.Errorf("JWT request failed, code %d body %s", w.Code, w.Body.String())
+	}
+	readToken, _ := JWTAccessToken(secret, 60, CustomClaims{User: "alice", Scope: "read"})
+	if w := call("Authorization", "Bearer "+readToken); w.Code != http.StatusUnauthorized {
+		t.Errorf("read token should not be accepted for write scope, code %d", w.Code)
+	}
+	if w := call("", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("request without credentials should fail, code %d", w.Code)
+	}
+
+	// revoked key is rejected
+	if err := keys.Revoke(ctx, key.ID); err != nil {
+		t.Fatal(err)
+	}
+	if w := call(APIKeyHeader, apiKey); w.Code != http.StatusUnauthorized {
+		t.Errorf("revoked API key should be rejected, code %d", w.Code)
+	}
+}
+
+// TestAPIKeyRecord
+func TestAPIKeyRecord(t *testing.T) {
+	key := APIKey{ID: "k1", Name: "pipeline", User: "alice", Roles: []string{"operator", "staff"}, Expires: 123}
+	// MongoDB decodes arrays of records as primitive.A
+	data, err := bson.Marshal(key.record())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rec map[string]any
+	if err := bson.Unmarshal(data, &rec); err != nil {
+		t.Fatal(err)
+	}
+	out := apiKeyRecord(rec)
+	if len(out.Roles) != 2 || out.Roles[0] != "operator" || out.User != "alice" || out.Expires != 123 {
+		t.Errorf("wrong API key %+v", out)
+	}
+}
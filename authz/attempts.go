@@ -0,0 +1,398 @@
+package auth
+
+// attempts module protects logins against brute-force attacks. Failed
+// logins are tracked per account and client IP address via storage
+// interface, so they are shared by replicas of Authz service. Accounts and
+// addresses with too many failures are locked out for exponentially
+// growing period, CAPTCHA (see github.com/dchest/captcha) is required
+// before lockout and after it. Failures and lockouts are counted by
+// metrics and recorded by LoginAuditor.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	services "github.com/CHESSComputing/golib/services"
+	"github.com/CHESSComputing/golib/storage"
+	utils "github.com/CHESSComputing/golib/utils"
+	"github.com/dchest/captcha"
+	"github.com/gin-gonic/gin"
+)
+
+// LoginAttemptsCollection defines storage collection of login attempts
+const LoginAttemptsCollection = "login_attempts"
+
+// defaults of brute-force protection
+const (
+	DefaultMaxFailures       = 5
+	DefaultCaptchaFailures   = 3
+	DefaultIPMaxFailures     = 50
+	DefaultIPCaptchaFailures = 10
+	DefaultFailureWindow     = 15 * time.Minute
+	DefaultLockout           = time.Minute
+	DefaultMaxLockout        = time.Hour
+)
+
+// CAPTCHA parameters of login requests, they are read from headers or
+// form values
+const (
+	CaptchaIDHeader       = "X-Captcha-Id"
+	CaptchaSolutionHeader = "X-Captcha-Solution"
+)
+
+// errors of brute-force protection
+var (
+	ErrLoginLocked     = errors.New("too many failed logins, try again later")
+	ErrCaptchaRequired = errors.New("CAPTCHA solution is required")
+)
+
+// metrics of brute-force protection
+var (
+	TotalLoginFailures     uint64 // number of failed logins
+	TotalLoginLockouts     uint64 // number of lockouts of accounts and IP addresses
+	TotalCaptchaChallenges uint64 // number of issued CAPTCHA challenges
+)
+
+// LoginEvent represents failed login or lockout of account or IP address
+type LoginEvent struct {
+	Action      string // LoginFailureAction or LoginLockoutAction
+	Account     string
+	IP          string
+	Failures    int64 // number of failures within failure window
+	LockedUntil int64 // end of lockout, unix time
+}
+
+// actions of login events
+const (
+	LoginFailureAction = "login.failure"
+	LoginLockoutAction = "login.lockout"
+)
+
+// LoginAuditor records login events, errors of auditor are logged and do
+// not affect logins. The audit module sets it when audit logger is
+// initialized.
+var LoginAuditor func(ctx context.Context, event LoginEvent) error
+
+// CaptchaVerifier verifies solution of CAPTCHA with given id, CAPTCHA is
+// removed after verification
+var CaptchaVerifier = captcha.VerifyString
+
+// NewCaptcha creates new CAPTCHA and returns its id, its image is provided
+// by server.CaptchaHandler, e.g. /captcha/<id>.png
+var NewCaptcha = func() string {
+	return captcha.New()
+}
+
+// LoginStatus represents protection status of login request
+type LoginStatus struct {
+	Locked          bool      `json:"locked"`
+	LockedUntil     time.Time `json:"locked_until,omitempty"`
+	CaptchaRequired bool      `json:"captcha_required"`
+	CaptchaID       string    `json:"captcha_id,omitempty"` // new CAPTCHA to solve
+}
+
+// RetryAfter returns remaining lockout period in seconds
+func (s LoginStatus) RetryAfter() int64 {
+	return int64(math.Ceil(time.Until(s.LockedUntil).Seconds()))
+}
+
+// loginAttempt represents failed logins of account or IP address
+type loginAttempt struct {
+	ID          string // account:<name> or ip:<address>
+	Failures    int64  // failures within failure window
+	Last        int64  // time of the last failure
+	Lockouts    int64  // number of lockouts
+	LockedUntil int64
+}
+
+// helper function to convert login attempt into storage record
+func (a loginAttempt) record() map[string]any {
+	return map[string]any{
+		"_id":          a.ID,
+		"failures":     a.Failures,
+		"last":         a.Last,
+		"lockouts":     a.Lockouts,
+		"locked_until": a.LockedUntil,
+	}
+}
+
+// helper function to convert storage record into login attempt
+func attemptRecord(rec map[string]any) loginAttempt {
+	id, _ := rec["_id"].(string)
+	return loginAttempt{
+		ID:          id,
+		Failures:    utils.ToInt64(rec["failures"]),
+		Last:        utils.ToInt64(rec["last"]),
+		Lockouts:    utils.ToInt64(rec["lockouts"]),
+		LockedUntil: utils.ToInt64(rec["locked_until"]),
+	}
+}
+
+// LoginAttempts tracks failed logins of accounts and IP addresses
+type LoginAttempts struct {
+	Store             storage.Store
+	MaxFailures       int64 // failures of account before lockout
+	CaptchaFailures   int64 // failures of account before CAPTCHA is required, negative disables CAPTCHA
+	IPMaxFailures     int64 // failures from IP address before lockout
+	IPCaptchaFailures int64 // failures from IP address before CAPTCHA is required
+	Window            time.Duration
+	Lockout           time.Duration
+	MaxLockout        time.Duration
+	Verbose           int
+}
+
+// NewLoginAttempts returns tracker of login attempts of BruteForce
+// configuration
+func NewLoginAttempts(store storage.Store, cfg srvConfig.BruteForce) *LoginAttempts {
+	value := func(v, def int) int64 {
+		if v == 0 {
+			return int64(def)
+		}
+		return int64(v)
+	}
+	period := func(v, def time.Duration) time.Duration {
+		if v <= 0 {
+			return def
+		}
+		return v
+	}
+	return &LoginAttempts{
+		Store:             store,
+		MaxFailures:       value(cfg.MaxFailures, DefaultMaxFailures),
+		CaptchaFailures:   value(cfg.CaptchaFailures, DefaultCaptchaFailures),
+		IPMaxFailures:     value(cfg.IPMaxFailures, DefaultIPMaxFailures),
+		IPCaptchaFailures: value(cfg.IPCaptchaFailures, DefaultIPCaptchaFailures),
+		Window:            period(cfg.Window, DefaultFailureWindow),
+		Lockout:           period(cfg.Lockout, DefaultLockout),
+		MaxLockout:        period(cfg.MaxLockout, DefaultMaxLockout),
+	}
+}
+
+// helper function to provide keys of tracked account and IP address
+func attemptKeys(account, ip string) []string {
+	var keys []string
+	if account != "" {
+		keys = append(keys, "account:"+account)
+	}
+	if ip != "" {
+		keys = append(keys, "ip:"+ip)
+	}
+	return keys
+}
+
+// helper function to get login attempt, failures older than failure window
+// are forgotten and lockouts are forgotten after maximum lockout period
+func (a *LoginAttempts) get(ctx context.Context, key string, now time.Time) (loginAttempt, bool, error) {
+	rec, err := storage.FindOne(ctx, a.Store, LoginAttemptsCollection, map[string]any{"_id": key})
+	if errors.Is(err, storage.ErrNotFound) {
+		return loginAttempt{ID: key}, false, nil
+	} else if err != nil {
+		return loginAttempt{ID: key}, false, err
+	}
+	att := attemptRecord(rec)
+	last := time.Unix(att.Last, 0)
+	if now.Sub(last) > a.Window {
+		att.Failures = 0
+	}
+	if now.Unix() > att.LockedUntil && now.Sub(last) > a.MaxLockout {
+		att.Lockouts = 0
+	}
+	return att, true, nil
+}
+
+// helper function to provide thresholds of lockout and CAPTCHA of the key
+func (a *LoginAttempts) thresholds(key string) (int64, int64) {
+	if strings.HasPrefix(key, "ip:") {
+		if a.CaptchaFailures < 0 {
+			return a.IPMaxFailures, a.CaptchaFailures
+		}
+		return a.IPMaxFailures, a.IPCaptchaFailures
+	}
+	return a.MaxFailures, a.CaptchaFailures
+}
+
+// Status returns protection status of login of account from IP address,
+// login is locked if either account or address is locked
+func (a *LoginAttempts) Status(ctx context.Context, account, ip string) (LoginStatus, error) {
+	var status LoginStatus
+	now := time.Now()
+	for _, key := range attemptKeys(account, ip) {
+		att, _, err := a.get(ctx, key, now)
+		if err != nil {
+			return status, err
+		}
+		if att.LockedUntil > now.Unix() {
+			status.Locked = true
+			if until := time.Unix(att.LockedUntil, 0); until.After(status.LockedUntil) {
+				status.LockedUntil = until
+			}
+		}
+		_, captchaFailures := a.thresholds(key)
+		if captchaFailures >= 0 && (att.Failures >= captchaFailures || att.Lockouts > 0) {
+			status.CaptchaRequired = true
+		}
+	}
+	return status, nil
+}
+
+// Check checks if login of account from IP address may proceed, it should
+// be called before credentials are verified. It returns ErrLoginLocked
+// during lockout and ErrCaptchaRequired with new CAPTCHA id if CAPTCHA is
+// required and given solution is not valid.
+func (a *LoginAttempts) Check(ctx context.Context, account, ip, captchaID, solution string) (LoginStatus, error) {
+	status, err := a.Status(ctx, account, ip)
+	if err != nil {
+		return status, err
+	}
+	if status.Locked {
+		return status, fmt.Errorf("%w, retry after %d seconds", ErrLoginLocked, status.RetryAfter())
+	}
+	if status.CaptchaRequired && (captchaID == "" || !CaptchaVerifier(captchaID, solution)) {
+		status.CaptchaID = NewCaptcha()
+		atomic.AddUint64(&TotalCaptchaChallenges, 1)
+		return status, ErrCaptchaRequired
+	}
+	return status, nil
+}
+
+// helper function to record login event
+func (a *LoginAttempts) audit(ctx context.Context, event LoginEvent) {
+	if LoginAuditor == nil {
+		return
+	}
+	if err := LoginAuditor(ctx, event); err != nil {
+		log.Printf("ERROR: unable to audit login event %+v, error %v", event, err)
+	}
+}
+
+// Failure records failed login of account from IP address, account or
+// address is locked out once it reaches maximum number of failures. Lockout
+// period doubles with every lockout up to maximum lockout period.
+func (a *LoginAttempts) Failure(ctx context.Context, account, ip string) (LoginStatus, error) {
+	atomic.AddUint64(&TotalLoginFailures, 1)
+	now := time.Now()
+	event := LoginEvent{Action: LoginFailureAction, Account: account, IP: ip}
+	for _, key := range attemptKeys(account, ip) {
+		att, found, err := a.get(ctx, key, now)
+		if err != nil {
+			return LoginStatus{}, err
+		}
+		att.Failures++
+		att.Last = now.Unix()
+		if att.Failures > event.Failures {
+			event.Failures = att.Failures
+		}
+		if maxFailures, _ := a.thresholds(key); att.Failures >= maxFailures {
+			lockout := a.Lockout << att.Lockouts
+			if lockout > a.MaxLockout || lockout <= 0 {
+				lockout = a.MaxLockout
+			}
+			att.Lockouts++
+			att.Failures = 0
+			att.LockedUntil = now.Add(lockout).Unix()
+			atomic.AddUint64(&TotalLoginLockouts, 1)
+			log.Printf("WARNING: %s is locked out for %v after %d failed logins", key, lockout, maxFailures)
+			a.audit(ctx, LoginEvent{Action: LoginLockoutAction, Account: account, IP: ip, Failures: maxFailures, LockedUntil: att.LockedUntil})
+		}
+		if found {
+			_, err = a.Store.Update(ctx, LoginAttemptsCollection, map[string]any{"_id": key}, att.record())
+		} else {
+			err = a.Store.Insert(ctx, LoginAttemptsCollection, att.record())
+		}
+		if err != nil {
+			log.Printf("ERROR: unable to record failed login of %s, error %v", key, err)
+			return LoginStatus{}, err
+		}
+	}
+	if a.Verbose > 0 {
+		log.Printf("INFO: failed login of account '%s' from %s", account, ip)
+	}
+	a.audit(ctx, event)
+	return a.Status(ctx, account, ip)
+}
+
+// Success records successful login of account, failures and lockouts of
+// the account are forgotten while failures of IP address are kept
+func (a *LoginAttempts) Success(ctx context.Context, account string) error {
+	if account == "" {
+		return nil
+	}
+	_, err := a.Store.Remove(ctx, LoginAttemptsCollection, map[string]any{"_id": "account:" + account})
+	return err
+}
+
+// Unlock removes failures and lockouts of account and IP address, e.g. by
+// administrator
+func (a *LoginAttempts) Unlock(ctx context.Context, account, ip string) error {
+	for _, key := range attemptKeys(account, ip) {
+		if _, err := a.Store.Remove(ctx, LoginAttemptsCollection, map[string]any{"_id": key}); err != nil {
+			return err
+		}
+	}
+	log.Printf("INFO: login of account '%s' from '%s' is unlocked", account, ip)
+	return nil
+}
+
+// helper function to get CAPTCHA parameters of the request
+func captchaParams(c *gin.Context) (string, string) {
+	id := c.GetHeader(CaptchaIDHeader)
+	solution := c.GetHeader(CaptchaSolutionHeader)
+	if id == "" {
+		id = c.PostForm("captcha_id")
+		solution = c.PostForm("captcha_solution")
+	}
+	return id, solution
+}
+
+// LoginAttemptsMiddleware protects login handler against brute-force
+// attacks. Account name of the request is provided by account function,
+// e.g. from form value, nil function tracks only client IP address. Locked
+// logins are rejected with 429 status and Retry-After header, logins which
+// require CAPTCHA are rejected with 401 status and id of new CAPTCHA in
+// X-Captcha-Id header. Responses of the handler with 401 or 403 status are
+// recorded as failures and responses with 2xx or 3xx status as successes.
+func LoginAttemptsMiddleware(a *LoginAttempts, account func(c *gin.Context) string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var user string
+		if account != nil {
+			user = account(c)
+		}
+		ctx := c.Request.Context()
+		ip := c.ClientIP()
+		captchaID, solution := captchaParams(c)
+		status, err := a.Check(ctx, user, ip, captchaID, solution)
+		if errors.Is(err, ErrLoginLocked) {
+			c.Header("Retry-After", fmt.Sprintf("%d", status.RetryAfter()))
+			rec := services.Response("authz", http.StatusTooManyRequests, services.CredentialsError, err)
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, rec)
+			return
+		} else if errors.Is(err, ErrCaptchaRequired) {
+			c.Header(CaptchaIDHeader, status.CaptchaID)
+			rec := services.Response("authz", http.StatusUnauthorized, services.CredentialsError, err)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, rec)
+			return
+		} else if err != nil {
+			// storage errors should not lock users out
+			log.Printf("ERROR: unable to check login attempts of '%s' from %s, error %v", user, ip, err)
+		}
+		c.Next()
+		switch code := c.Writer.Status(); {
+		case code == http.StatusUnauthorized || code == http.StatusForbidden:
+			if _, err := a.Failure(ctx, user, ip); err != nil {
+				log.Printf("ERROR: unable to record failed login of '%s' from %s, error %v", user, ip, err)
+			}
+		case code < http.StatusBadRequest:
+			if err := a.Success(ctx, user); err != nil {
+				log.Printf("ERROR: unable to record login of '%s', error %v", user, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	"github.com/CHESSComputing/golib/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// helper function to replace CAPTCHA functions, solution of CAPTCHA is
+// its id in upper case
+func testCaptcha(t *testing.T) {
+	verifier, newCaptcha := CaptchaVerifier, NewCaptcha
+	t.Cleanup(func() { CaptchaVerifier, NewCaptcha = verifier, newCaptcha })
+	CaptchaVerifier = func(id, solution string) bool { return solution == strings.ToUpper(id) }
+	NewCaptcha = func() string { return "abc" }
+}
+
+// TestLoginAttempts
+func TestLoginAttempts(t *testing.T) {
+	testCaptcha(t)
+	ctx := context.Background()
+	attempts := NewLoginAttempts(storage.NewMemoryStore(), srvConfig.BruteForce{MaxFailures: 4, CaptchaFailures: 2, IPMaxFailures: 6})
+	var events []LoginEvent
+	LoginAuditor = func(ctx context.Context, event LoginEvent) error {
+		events = append(events, event)
+		return nil
+	}
+	t.Cleanup(func() { LoginAuditor = nil })
+
+	for i := 0; i < 2; i++ {
+		if _, err := attempts.Check(ctx, "alice", "10.0.0.1", "", ""); err != nil {
+			t.Fatalf("login %d is not allowed, error %v", i, err)
+		}
+		attempts.Failure(ctx, "alice", "10.0.0.1")
+	}
+	// CAPTCHA is required after two failures
+	status, err := attempts.Check(ctx, "alice", "10.0.0.1", "", "")
+	if !errors.Is(err, ErrCaptchaRequired) || status.CaptchaID != "abc" {
+		t.Errorf("CAPTCHA is not required %+v, error %v", status, err)
+	}
+	if _, err := attempts.Check(ctx, "alice", "10.0.0.1", "abc", "wrong"); !errors.Is(err, ErrCaptchaRequired) {
+		t.Errorf("wrong CAPTCHA solution is accepted, error %v", err)
+	}
+	if _, err := attempts.Check(ctx, "alice", "10.0.0.1", "abc", "ABC"); err != nil {
+		t.Errorf("valid CAPTCHA solution is rejected, error %v", err)
+	}
+	if _, err := attempts.Check(ctx, "bob", "10.0.0.2", "", ""); err != nil {
+		t.Errorf("login of another account is not allowed, error %v", err)
+	}
+
+	// account is locked after four failures, lockout period doubles
+	attempts.Failure(ctx, "alice", "10.0.0.1")
+	status, _ = attempts.Failure(ctx, "alice", "10.0.0.1")
+	if !status.Locked || status.RetryAfter() > 60 || status.RetryAfter() < 59 {
+		t.Errorf("account is not locked for one minute %+v", status)
+	}
+	if _, err := attempts.Check(ctx, "alice", "10.0.0.3", "abc", "ABC"); !errors.Is(err, ErrLoginLocked) {
+		t.Errorf("locked account is allowed from other address, error %v", err)
+	}
+	if len(events) != 5 || events[3].Action != LoginLockoutAction || events[4].Action != LoginFailureAction {
+		t.Errorf("wrong login events %+v", events)
+	}
+	attempts.Unlock(ctx, "alice", "")
+	for i := 0; i < 8; i++ {
+		status, _ = attempts.Failure(ctx, "alice", "10.0.0.4")
+	}
+	if !status.Locked || status.RetryAfter() < 119 {
+		t.Errorf("second lockout is not doubled %+v", status)
+	}
+
+	// IP address is locked regardless of account
+	attempts.Unlock(ctx, "", "10.0.0.1")
+	for i := 0; i < 6; i++ {
+		attempts.Failure(ctx, "user"+string(rune('a'+i)), "10.0.0.6")
+	}
+	if _, err := attempts.Check(ctx, "carol", "10.0.0.6", "abc", "ABC"); !errors.Is(err, ErrLoginLocked) {
+		t.Errorf("locked address is allowed, error %v", err)
+	}
+
+	// successful login forgets failures of account
+	attempts.Failure(ctx, "dave", "10.0.0.7")
+	attempts.Failure(ctx, "dave", "10.0.0.7")
+	attempts.Success(ctx, "dave")
+	if status, _ := attempts.Status(ctx, "dave", ""); status.CaptchaRequired || status.Locked {
+		t.Errorf("failures are kept after successful login %+v", status)
+	}
+}
+
+// TestLoginAttemptsMiddleware
+func TestLoginAttemptsMiddleware(t *testing.T) {
+	testCaptcha(t)
+	gin.SetMode(gin.TestMode)
+	attempts := NewLoginAttempts(storage.NewMemoryStore(), srvConfig.BruteForce{MaxFailures: 3, CaptchaFailures: -1})
+	r := gin.New()
+	r.POST("/login", LoginAttemptsMiddleware(attempts, func(c *gin.Context) string {
+		return c.PostForm("user")
+	}), func(c *gin.Context) {
+		if c.PostForm("password") != "secret" {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		c.Status(http.StatusOK)
+	})
+	login := func(password string) *httptest.ResponseRecorder {
+		form := url.Values{"user": {"alice"}, "password": {password}}
+		req := httptest.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	for i := 0; i < 2; i++ {
+		if w := login("wrong"); w.Code != http.StatusUnauthorized {
+			t.Errorf("wrong response code %d", w.Code)
+		}
+	}
+	if w := login("secret"); w.Code != http.StatusOK {
+		t.Errorf("valid login is rejected, code %d", w.Code)
+	}
+	for i := 0; i < 3; i++ {
+		login("wrong")
+	}
+	w := login("secret")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("locked login is allowed, code %d", w.Code)
+	}
+	attempts.Unlock(context.Background(), "alice", "")
+	if w := login("secret"); w.Code != http.StatusOK {
+		t.Errorf("unlocked login is rejected, code %d", w.Code)
+	}
+}
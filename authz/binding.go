@@ -0,0 +1,411 @@
+package auth
+
+// sender-constrained tokens: tokens bound to client TLS certificate
+// (RFC 8705) or to public key of DPoP proofs (RFC 9449)
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	jwt "github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+)
+
+// DPoPHeader defines HTTP header of DPoP proofs
+const DPoPHeader = "DPoP"
+
+// DefaultDPoPMaxAge defines maximum age of DPoP proofs if it is not
+// configured
+const DefaultDPoPMaxAge = 5 * time.Minute
+
+// ErrTokenBinding is returned when sender-constrained token is presented
+// without valid proof of possession
+var ErrTokenBinding = errors.New("token binding error")
+
+// Confirmation represents confirmation (cnf) claim of sender-constrained
+// token, see RFC 7800
+type Confirmation struct {
+	X5TS256 string `json:"x5t#S256,omitempty"` // SHA-256 thumbprint of client certificate
+	JKT     string `json:"jkt,omitempty"`      // SHA-256 thumbprint of DPoP public key
+}
+
+// helper function to get token binding configuration
+func bindingConfig() srvConfig.TokenBinding {
+	if srvConfig.Config == nil {
+		return srvConfig.TokenBinding{}
+	}
+	return srvConfig.Config.Authz.TokenBinding
+}
+
+// helper function to check if binding method is enabled
+func bindingEnabled(method string) bool {
+	for _, m := range bindingConfig().Methods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// helper function to encode SHA-256 hash of data in base64url encoding
+func sha256Base64(data []byte) string {
+	hash := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+// CertificateThumbprint returns SHA-256 thumbprint of certificate used in
+// x5t#S256 confirmation
+func CertificateThumbprint(cert *x509.Certificate) string {
+	return sha256Base64(cert.Raw)
+}
+
+// ClientCertificate returns client TLS certificate of the request either
+// from TLS connection or from URL encoded PEM certificate of configured
+// reverse proxy header, nil is returned if request has no certificate
+func ClientCertificate(r *http.Request) (*x509.Certificate, error) {
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		return r.TLS.PeerCertificates[0], nil
+	}
+	header := bindingConfig().CertHeader
+	if header == "" || r.Header.Get(header) == "" {
+		return nil, nil
+	}
+	data, err := url.QueryUnescape(r.Header.Get(header))
+	if err != nil {
+		return nil, fmt.Errorf("invalid client certificate header, error %v", err)
+	}
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.New("client certificate header does not contain PEM certificate")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// JWK represents JSON web key of DPoP proof
+type JWK struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+// helper function to encode big integer of fixed size
+func encodeInt(val *big.Int, size int) string {
+	return base64.RawURLEncoding.EncodeToString(val.FillBytes(make([]byte, size)))
+}
+
+// NewJWK returns JSON web key of ECDSA, RSA or Ed25519 public key
+func NewJWK(pub crypto.PublicKey) (JWK, error) {
+	switch key := pub.(type) {
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		return JWK{Kty: "EC", Crv: key.Curve.Params().Name, X: encodeInt(key.X, size), Y: encodeInt(key.Y, size)}, nil
+	case *rsa.PublicKey:
+		e := big.NewInt(int64(key.E))
+		return JWK{Kty: "RSA", N: base64.RawURLEncoding.EncodeToString(key.N.Bytes()), E: base64.RawURLEncoding.EncodeToString(e.Bytes())}, nil
+	case ed25519.PublicKey:
+		return JWK{Kty: "OKP", Crv: "Ed25519", X: base64.RawURLEncoding.EncodeToString(key)}, nil
+	}
+	return JWK{}, fmt.Errorf("unsupported public key %T", pub)
+}
+
+// helper function to decode base64url encoded big integer
+func decodeInt(val string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(val)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+// PublicKey returns public key of JSON web key
+func (k JWK) PublicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := decodeInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("invalid EC public key")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "RSA":
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if n.BitLen() < 2048 || !e.IsInt64() {
+			return nil, errors.New("invalid RSA public key")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 public key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+}
+
+// Thumbprint returns SHA-256 thumbprint of JSON web key (RFC 7638) used in
+// jkt confirmation
+func (k JWK) Thumbprint() string {
+	var data string
+	switch k.Kty {
+	case "EC":
+		data = fmt.Sprintf(`{"crv":"%s","kty":"EC","x":"%s","y":"%s"}`, k.Crv, k.X, k.Y)
+	case "RSA":
+		data = fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`, k.E, k.N)
+	default:
+		data = fmt.Sprintf(`{"crv":"%s","kty":"%s","x":"%s"}`, k.Crv, k.Kty, k.X)
+	}
+	return sha256Base64([]byte(data))
+}
+
+// DPoPClaims represents claims of DPoP proof
+type DPoPClaims struct {
+	jwt.RegisteredClaims
+	Method string `json:"htm"`           // HTTP method of the request
+	URL    string `json:"htu"`           // HTTP URL of the request without query and fragment
+	ATH    string `json:"ath,omitempty"` // hash of access token
+}
+
+// Valid implements jwt.Claims interface, proof age is verified by
+// VerifyDPoPProof
+func (c DPoPClaims) Valid() error {
+	return nil
+}
+
+// helper function to get signing method of DPoP key
+func dpopSigningMethod(pub crypto.PublicKey) (jwt.SigningMethod, error) {
+	switch key := pub.(type) {
+	case *ecdsa.PublicKey:
+		if key.Curve == elliptic.P384() {
+			return jwt.SigningMethodES384, nil
+		}
+		return jwt.SigningMethodES256, nil
+	case *rsa.PublicKey:
+		return jwt.SigningMethodRS256, nil
+	case ed25519.PublicKey:
+		return jwt.SigningMethodEdDSA, nil
+	}
+	return nil, fmt.Errorf("unsupported public key %T", pub)
+}
+
+// NewDPoPProof returns DPoP proof of HTTP request signed with given key,
+// access token is empty for token requests
+func NewDPoPProof(key crypto.Signer, method, rurl, accessToken string) (string, error) {
+	jwk, err := NewJWK(key.Public())
+	if err != nil {
+		return "", err
+	}
+	alg, err := dpopSigningMethod(key.Public())
+	if err != nil {
+		return "", err
+	}
+	jti, err := uuid.NewRandom()
+	if err != nil {
+		return "", err
+	}
+	claims := DPoPClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:       hex.EncodeToString(jti[:]),
+			IssuedAt: jwt.NewNumericDate(time.Now()),
+		},
+		Method: method,
+		URL:    htu(rurl),
+	}
+	if accessToken != "" {
+		claims.ATH = sha256Base64([]byte(accessToken))
+	}
+	token := jwt.NewWithClaims(alg, claims)
+	token.Header["typ"] = "dpop+jwt"
+	token.Header["jwk"] = jwk
+	return token.SignedString(key)
+}
+
+// helper function to normalize URL of DPoP proof, query and fragment are
+// not part of htu claim
+func htu(rurl string) string {
+	u, err := url.Parse(rurl)
+	if err != nil {
+		return rurl
+	}
+	return strings.ToLower(u.Scheme) + "://" + strings.ToLower(u.Host) + u.Path
+}
+
+// dpopReplays keeps identifiers of seen DPoP proofs
+var dpopReplays = struct {
+	sync.Mutex
+	seen map[string]time.Time
+}{seen: make(map[string]time.Time)}
+
+// helper function to check if DPoP proof was already used
+func dpopReplayed(key string, maxAge time.Duration) bool {
+	dpopReplays.Lock()
+	defer dpopReplays.Unlock()
+	now := time.Now()
+	for k, t := range dpopReplays.seen {
+		if now.Sub(t) > 2*maxAge {
+			delete(dpopReplays.seen, k)
+		}
+	}
+	if _, ok := dpopReplays.seen[key]; ok {
+		return true
+	}
+	dpopReplays.seen[key] = now
+	return false
+}
+
+// VerifyDPoPProof verifies DPoP proof of HTTP request and returns
+// thumbprint of its public key, access token is empty for token requests
+func VerifyDPoPProof(r *http.Request, proof, accessToken string) (string, error) {
+	maxAge := DefaultDPoPMaxAge
+	if age := bindingConfig().DPoPMaxAge; age > 0 {
+		maxAge = age
+	}
+	var jwk JWK
+	claims := &DPoPClaims{}
+	methods := []string{"ES256", "ES384", "RS256", "PS256", "EdDSA"}
+	_, err := jwt.ParseWithClaims(proof, claims, func(token *jwt.Token) (interface{}, error) {
+		if typ, _ := token.Header["typ"].(string); typ != "dpop+jwt" {
+			return nil, fmt.Errorf("invalid DPoP proof type '%s'", typ)
+		}
+		data, err := json.Marshal(token.Header["jwk"])
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &jwk); err != nil {
+			return nil, fmt.Errorf("invalid DPoP proof key, error %v", err)
+		}
+		return jwk.PublicKey()
+	}, jwt.WithValidMethods(methods))
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid DPoP proof, error %v", ErrTokenBinding, err)
+	}
+	if !strings.EqualFold(claims.Method, r.Method) {
+		return "", fmt.Errorf("%w: DPoP proof method %s does not match request method %s", ErrTokenBinding, claims.Method, r.Method)
+	}
+	if rurl := htu(ExternalURL(r, r.URL.Path)); htu(claims.URL) != rurl {
+		return "", fmt.Errorf("%w: DPoP proof URL %s does not match request URL %s", ErrTokenBinding, claims.URL, rurl)
+	}
+	if claims.IssuedAt == nil || time.Since(claims.IssuedAt.Time).Abs() > maxAge {
+		return "", fmt.Errorf("%w: DPoP proof is expired or issued in the future", ErrTokenBinding)
+	}
+	if accessToken != "" && claims.ATH != sha256Base64([]byte(accessToken)) {
+		return "", fmt.Errorf("%w: DPoP proof is not issued for presented token", ErrTokenBinding)
+	}
+	jkt := jwk.Thumbprint()
+	if claims.ID == "" || dpopReplayed(jkt+":"+claims.ID, maxAge) {
+		return "", fmt.Errorf("%w: DPoP proof is replayed", ErrTokenBinding)
+	}
+	return jkt, nil
+}
+
+// RequestConfirmation returns confirmation claim of token request to issue
+// sender-constrained token with one of configured binding methods: DPoP
+// proof of the request or client TLS certificate, nil is returned if
+// request does not provide any of them
+func RequestConfirmation(r *http.Request) (*Confirmation, error) {
+	if proof := r.Header.Get(DPoPHeader); proof != "" && bindingEnabled("dpop") {
+		jkt, err := VerifyDPoPProof(r, proof, "")
+		if err != nil {
+			return nil, err
+		}
+		return &Confirmation{JKT: jkt}, nil
+	}
+	if bindingEnabled("mtls") {
+		cert, err := ClientCertificate(r)
+		if err != nil {
+			return nil, err
+		}
+		if cert != nil {
+			return &Confirmation{X5TS256: CertificateThumbprint(cert)}, nil
+		}
+	}
+	return nil, nil
+}
+
+// VerifyTokenBinding verifies that sender-constrained token is presented
+// with proof of possession: client TLS certificate or DPoP proof. Tokens
+// without confirmation claim are accepted unless their scope requires
+// binding.
+func VerifyTokenBinding(r *http.Request, accessToken string, claims *Claims) error {
+	cnf := claims.Confirmation
+	if cnf == nil || (cnf.X5TS256 == "" && cnf.JKT == "") {
+		for _, scope := range bindingConfig().Scopes {
+			if scope == claims.CustomClaims.Scope {
+				return fmt.Errorf("%w: token of %s scope should be bound to client certificate or DPoP key", ErrTokenBinding, scope)
+			}
+		}
+		return nil
+	}
+	if cnf.X5TS256 != "" {
+		cert, err := ClientCertificate(r)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrTokenBinding, err)
+		}
+		if cert == nil {
+			return fmt.Errorf("%w: client certificate is required", ErrTokenBinding)
+		}
+		if CertificateThumbprint(cert) != cnf.X5TS256 {
+			return fmt.Errorf("%w: token is bound to another client certificate", ErrTokenBinding)
+		}
+	}
+	if cnf.JKT != "" {
+		proof := r.Header.Get(DPoPHeader)
+		if proof == "" {
+			return fmt.Errorf("%w: DPoP proof is required", ErrTokenBinding)
+		}
+		jkt, err := VerifyDPoPProof(r, proof, accessToken)
+		if err != nil {
+			return err
+		}
+		if jkt != cnf.JKT {
+			return fmt.Errorf("%w: token is bound to another DPoP key", ErrTokenBinding)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,235 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	"github.com/gin-gonic/gin"
+)
+
+// helper function to create self-signed client certificate
+func testCertificate(t *testing.T) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "pipeline"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// helper function to set token binding configuration
+func bindingTestConfig(t *testing.T, binding srvConfig.TokenBinding) {
+	config := srvConfig.Config
+	t.Cleanup(func() { srvConfig.Config = config })
+	srvConfig.Config = &srvConfig.SrvConfig{}
+	srvConfig.Config.Authz.ClientID = "secret"
+	srvConfig.Config.Authz.TokenBinding = binding
+}
+
+// TestJWK
+func TestJWK(t *testing.T) {
+	// example of RFC 7638
+	jwk := JWK{
+		Kty: "RSA",
+		E:   "AQAB",
+		N:   "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+	}
+	if tp := jwk.Thumbprint(); tp != "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs" {
+		t.Errorf("invalid thumbprint %s", tp)
+	}
+	pub, err := jwk.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, err := NewJWK(pub); err != nil || out != jwk {
+		t.Errorf("unexpected key %+v, error %v", out, err)
+	}
+
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	for _, key := range []any{&ecKey.PublicKey, edKey.Public()} {
+		jwk, err := NewJWK(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pub, err := jwk.PublicKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out, _ := NewJWK(pub); out != jwk {
+			t.Errorf("key %T is not restored", key)
+		}
+	}
+	if _, err := (JWK{Kty: "EC", Crv: "P-256", X: "AQAB", Y: "AQAB"}).PublicKey(); err == nil {
+		t.Error("invalid EC key is accepted")
+	}
+}
+
+// TestDPoPProof
+func TestDPoPProof(t *testing.T) {
+	bindingTestConfig(t, srvConfig.TokenBinding{})
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	r := httptest.NewRequest("POST", "http://foxden.example.org/data?did=/a/b/c", nil)
+	proof, err := NewDPoPProof(ecKey, "POST", "http://foxden.example.org/data", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	jkt, err := VerifyDPoPProof(r, proof, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk, _ := NewJWK(ecKey.Public())
+	if jkt != jwk.Thumbprint() {
+		t.Errorf("unexpected thumbprint %s", jkt)
+	}
+	if _, err := VerifyDPoPProof(r, proof, "token"); err == nil {
+		t.Error("replayed proof is accepted")
+	}
+	for _, tc := range []struct {
+		name, method, url, token string
+	}{
+		{"method", "GET", "http://foxden.example.org/data", "token"},
+		{"url", "POST", "http://foxden.example.org/other", "token"},
+		{"token", "POST", "http://foxden.example.org/data", "other"},
+	} {
+		proof, _ := NewDPoPProof(ecKey, tc.method, tc.url, tc.token)
+		if _, err := VerifyDPoPProof(r, proof, "token"); !errors.Is(err, ErrTokenBinding) {
+			t.Errorf("proof with wrong %s is accepted, error %v", tc.name, err)
+		}
+	}
+	// other key types and proxy URL
+	srvConfig.Config.Frontend.TrustedProxies = []string{"192.0.2.1"}
+	r = httptest.NewRequest("GET", "http://localhost:8300/data", nil)
+	r.Header.Set("X-Forwarded-Host", "foxden.example.org")
+	r.Header.Set("X-Forwarded-Proto", "https")
+	for _, proof := range []func() (string, error){
+		func() (string, error) { return NewDPoPProof(rsaKey, "GET", "https://foxden.example.org/data", "") },
+		func() (string, error) { return NewDPoPProof(edKey, "GET", "https://FOXDEN.example.org/data", "") },
+	} {
+		p, err := proof()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := VerifyDPoPProof(r, p, ""); err != nil {
+			t.Errorf("valid proof is rejected, error %v", err)
+		}
+	}
+}
+
+// TestTokenBinding
+func TestTokenBinding(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	bindingTestConfig(t, srvConfig.TokenBinding{Methods: []string{"mtls", "dpop"}, Scopes: []string{"write"}, CertHeader: "X-Client-Cert"})
+	router := gin.New()
+	handler := func(c *gin.Context) { c.String(http.StatusOK, "ok") }
+	router.GET("/read", TokenMiddleware("secret", 0), handler)
+	router.POST("/write", ScopeTokenMiddleware("write", "secret", 0), handler)
+	serve := func(r *http.Request) int {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// bearer tokens
+	read, _ := JWTAccessToken("secret", 60, CustomClaims{User: "alice", Scope: "read"})
+	write, _ := JWTAccessToken("secret", 60, CustomClaims{User: "alice", Scope: "write"})
+	r := httptest.NewRequest("GET", "http://foxden.example.org/read", nil)
+	r.Header.Set("Authorization", "Bearer "+read)
+	if code := serve(r); code != http.StatusOK {
+		t.Errorf("bearer read token is rejected, code %d", code)
+	}
+	r = httptest.NewRequest("POST", "http://foxden.example.org/write", nil)
+	r.Header.Set("Authorization", "Bearer "+write)
+	if code := serve(r); code != http.StatusUnauthorized {
+		t.Errorf("unbound write token is accepted, code %d", code)
+	}
+
+	// DPoP bound token
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	treq := httptest.NewRequest("POST", "http://foxden.example.org/oauth/token", nil)
+	proof, _ := NewDPoPProof(key, "POST", "http://foxden.example.org/oauth/token", "")
+	treq.Header.Set(DPoPHeader, proof)
+	cnf, err := RequestConfirmation(treq)
+	if err != nil || cnf == nil || cnf.JKT == "" {
+		t.Fatalf("unexpected confirmation %+v, error %v", cnf, err)
+	}
+	token, _ := BoundJWTAccessToken("secret", 60, CustomClaims{User: "pipeline", Scope: "write"}, cnf)
+	if claims, err := TokenClaims(token, "secret"); err != nil || claims.Confirmation == nil || claims.Confirmation.JKT != cnf.JKT {
+		t.Errorf("confirmation is not set %+v, error %v", claims, err)
+	}
+	r = httptest.NewRequest("POST", "http://foxden.example.org/write", nil)
+	r.Header.Set("Authorization", "DPoP "+token)
+	proof, _ = NewDPoPProof(key, "POST", "http://foxden.example.org/write", token)
+	r.Header.Set(DPoPHeader, proof)
+	if code := serve(r); code != http.StatusOK {
+		t.Errorf("DPoP bound token is rejected, code %d", code)
+	}
+	r.Header.Del(DPoPHeader)
+	if code := serve(r); code != http.StatusUnauthorized {
+		t.Errorf("DPoP bound token without proof is accepted, code %d", code)
+	}
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	proof, _ = NewDPoPProof(other, "POST", "http://foxden.example.org/write", token)
+	r.Header.Set(DPoPHeader, proof)
+	if code := serve(r); code != http.StatusUnauthorized {
+		t.Errorf("DPoP bound token with proof of another key is accepted, code %d", code)
+	}
+
+	// certificate bound token
+	cert := testCertificate(t)
+	treq = httptest.NewRequest("POST", "https://foxden.example.org/oauth/token", nil)
+	treq.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	cnf, err = RequestConfirmation(treq)
+	if err != nil || cnf == nil || cnf.X5TS256 != CertificateThumbprint(cert) {
+		t.Fatalf("unexpected confirmation %+v, error %v", cnf, err)
+	}
+	token, _ = BoundJWTAccessToken("secret", 60, CustomClaims{User: "pipeline", Scope: "read"}, cnf)
+	r = httptest.NewRequest("GET", "https://foxden.example.org/read", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	if code := serve(r); code != http.StatusUnauthorized {
+		t.Errorf("certificate bound token without certificate is accepted, code %d", code)
+	}
+	r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{testCertificate(t)}}
+	if code := serve(r); code != http.StatusUnauthorized {
+		t.Errorf("certificate bound token with another certificate is accepted, code %d", code)
+	}
+	r.TLS = nil
+	block := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	r.Header.Set("X-Client-Cert", url.QueryEscape(string(block)))
+	if code := serve(r); code != http.StatusOK {
+		t.Errorf("certificate bound token with proxy certificate header is rejected, code %d", code)
+	}
+}
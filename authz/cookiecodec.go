@@ -0,0 +1,204 @@
+package auth
+
+// cookiecodec module encrypts and authenticates cookie payloads with AEAD
+// cipher of Encryption configuration. Cookies sealed with rotated secrets
+// (Encryption.OldSecrets) are accepted and re-issued with current secret.
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// cookieCodecVersion defines version of encrypted cookie format
+const cookieCodecVersion = 1
+
+// size of key id prefix of encrypted cookies
+const cookieKeyIDSize = 4
+
+// errors of encrypted cookies
+var (
+	ErrInvalidCookie = errors.New("invalid encrypted cookie")
+	ErrExpiredCookie = errors.New("encrypted cookie is expired")
+)
+
+// cookieKey represents AEAD cipher of single secret
+type cookieKey struct {
+	id   []byte
+	aead cipher.AEAD
+}
+
+// CookieCodec encrypts and decrypts cookie values, the first key is used
+// to seal cookies and all keys are used to open them
+type CookieCodec struct {
+	keys []cookieKey
+}
+
+// helper function to create AEAD cipher of the secret
+func newCookieKey(secret, name string) (cookieKey, error) {
+	key := sha256.Sum256([]byte("foxden cookie key:" + secret))
+	hash := sha256.Sum256(key[:])
+	var aead cipher.AEAD
+	var err error
+	switch strings.ToLower(name) {
+	case "", "aes", "aes-gcm":
+		var block cipher.Block
+		block, err = aes.NewCipher(key[:])
+		if err == nil {
+			aead, err = cipher.NewGCM(block)
+		}
+	case "chacha20-poly1305":
+		aead, err = chacha20poly1305.New(key[:])
+	default:
+		err = fmt.Errorf("unsupported cipher '%s'", name)
+	}
+	return cookieKey{id: hash[:cookieKeyIDSize], aead: aead}, err
+}
+
+// NewCookieCodec returns cookie codec of encryption configuration, Secret
+// is used to seal cookies and OldSecrets to open cookies sealed before key
+// rotation
+func NewCookieCodec(cfg srvConfig.Encryption) (*CookieCodec, error) {
+	if cfg.Secret == "" {
+		return nil, errors.New("encrypted cookies require encryption secret")
+	}
+	codec := &CookieCodec{}
+	for _, secret := range append([]string{cfg.Secret}, cfg.OldSecrets...) {
+		if secret == "" {
+			continue
+		}
+		key, err := newCookieKey(secret, cfg.Cipher)
+		if err != nil {
+			return nil, err
+		}
+		codec.keys = append(codec.keys, key)
+	}
+	return codec, nil
+}
+
+// DefaultCookieCodec returns cookie codec of Encryption configuration
+func DefaultCookieCodec() (*CookieCodec, error) {
+	if srvConfig.Config == nil {
+		return nil, errors.New("configuration is not initialized")
+	}
+	return NewCookieCodec(srvConfig.Config.Encryption)
+}
+
+// cookiePayload represents sealed cookie value
+type cookiePayload struct {
+	Value   json.RawMessage `json:"v"`
+	Expires int64           `json:"e,omitempty"` // unix time, zero means session cookie
+}
+
+// Encode seals value of cookie with given name, the name is authenticated
+// so cookie value can not be moved to another cookie. The value expires
+// after maxAge seconds, zero means no expiration.
+func (c *CookieCodec) Encode(name string, value any, maxAge int) (string, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	payload := cookiePayload{Value: data}
+	if maxAge > 0 {
+		payload.Expires = time.Now().Add(time.Duration(maxAge) * time.Second).Unix()
+	}
+	plain, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	key := c.keys[0]
+	buf := make([]byte, 1+cookieKeyIDSize+key.aead.NonceSize(), 1+cookieKeyIDSize+key.aead.NonceSize()+len(plain)+key.aead.Overhead())
+	buf[0] = cookieCodecVersion
+	copy(buf[1:], key.id)
+	nonce := buf[1+cookieKeyIDSize:]
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := key.aead.Seal(buf, nonce, plain, []byte(name))
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// Decode opens cookie with given name into value. It returns true if cookie
+// is sealed with rotated secret and should be re-issued, and remaining
+// lifetime of the cookie in seconds, zero for session cookies.
+func (c *CookieCodec) Decode(name, cookie string, value any) (bool, int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cookie)
+	if err != nil || len(data) < 1+cookieKeyIDSize || data[0] != cookieCodecVersion {
+		return false, 0, ErrInvalidCookie
+	}
+	kid := data[1 : 1+cookieKeyIDSize]
+	for idx, key := range c.keys {
+		if string(key.id) != string(kid) {
+			continue
+		}
+		sealed := data[1+cookieKeyIDSize:]
+		if len(sealed) < key.aead.NonceSize() {
+			return false, 0, ErrInvalidCookie
+		}
+		nonce := sealed[:key.aead.NonceSize()]
+		plain, err := key.aead.Open(nil, nonce, sealed[key.aead.NonceSize():], []byte(name))
+		if err != nil {
+			return false, 0, ErrInvalidCookie
+		}
+		var payload cookiePayload
+		if err := json.Unmarshal(plain, &payload); err != nil {
+			return false, 0, ErrInvalidCookie
+		}
+		var maxAge int
+		if payload.Expires > 0 {
+			maxAge = int(payload.Expires - time.Now().Unix())
+			if maxAge <= 0 {
+				return false, 0, ErrExpiredCookie
+			}
+		}
+		if err := json.Unmarshal(payload.Value, value); err != nil {
+			return false, 0, err
+		}
+		return idx > 0, maxAge, nil
+	}
+	return false, 0, ErrInvalidCookie
+}
+
+// SetSecureCookie sets encrypted cookie with cookie options of frontend
+// configuration
+func SetSecureCookie(ctx *gin.Context, codec *CookieCodec, name string, value any, maxAge int) error {
+	data, err := codec.Encode(name, value, maxAge)
+	if err != nil {
+		return err
+	}
+	cfg := cookieConfig()
+	ctx.SetSameSite(SameSite(cfg.SameSite))
+	ctx.SetCookie(name, data, maxAge, cookiePath(), CookieDomain(RequestHost(ctx.Request)), cfg.Secure, true)
+	return nil
+}
+
+// SecureCookie reads encrypted cookie into value, cookies sealed with
+// rotated secret are re-issued with current secret and remaining lifetime
+func SecureCookie(ctx *gin.Context, codec *CookieCodec, name string, value any) error {
+	data, err := ctx.Cookie(name)
+	if err != nil {
+		return err
+	}
+	rotated, maxAge, err := codec.Decode(name, data, value)
+	if err != nil {
+		return err
+	}
+	if rotated {
+		if err := SetSecureCookie(ctx, codec, name, value, maxAge); err != nil {
+			log.Printf("WARNING: unable to re-issue cookie %s, error %v", name, err)
+		}
+	}
+	return nil
+}
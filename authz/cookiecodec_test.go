@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	"github.com/gin-gonic/gin"
+)
+
+// TestCookieCodec
+func TestCookieCodec(t *testing.T) {
+	type session struct {
+		User  string   `json:"user"`
+		Roles []string `json:"roles"`
+	}
+	for _, name := range []string{"aes", "chacha20-poly1305"} {
+		codec, err := NewCookieCodec(srvConfig.Encryption{Secret: "secret", Cipher: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := codec.Encode("session", session{User: "alice", Roles: []string{"staff"}}, 60)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(data, "alice") {
+			t.Errorf("%s: cookie is readable %s", name, data)
+		}
+		var s session
+		rotated, maxAge, err := codec.Decode("session", data, &s)
+		if err != nil || rotated || maxAge <= 0 || maxAge > 60 || s.User != "alice" || len(s.Roles) != 1 {
+			t.Errorf("%s: unexpected session %+v rotated %v max age %d, error %v", name, s, rotated, maxAge, err)
+		}
+		if _, _, err := codec.Decode("other", data, &s); !errors.Is(err, ErrInvalidCookie) {
+			t.Errorf("%s: cookie is accepted under another name, error %v", name, err)
+		}
+		tampered := []byte(data)
+		tampered[len(tampered)-2] ^= 1
+		if _, _, err := codec.Decode("session", string(tampered), &s); !errors.Is(err, ErrInvalidCookie) {
+			t.Errorf("%s: tampered cookie is accepted, error %v", name, err)
+		}
+	}
+	codec, _ := NewCookieCodec(srvConfig.Encryption{Secret: "secret"})
+	data, _ := codec.Encode("session", "alice", -1)
+	var user string
+	if _, _, err := codec.Decode("session", data, &user); err != nil {
+		t.Errorf("session cookie is rejected, error %v", err)
+	}
+	if _, err := NewCookieCodec(srvConfig.Encryption{}); err == nil {
+		t.Error("codec without secret is created")
+	}
+
+	// key rotation
+	rotatedCodec, _ := NewCookieCodec(srvConfig.Encryption{Secret: "new", OldSecrets: []string{"secret"}})
+	rotated, _, err := rotatedCodec.Decode("session", data, &user)
+	if err != nil || !rotated || user != "alice" {
+		t.Errorf("cookie of rotated secret is not accepted, rotated %v error %v", rotated, err)
+	}
+	newCodec, _ := NewCookieCodec(srvConfig.Encryption{Secret: "new"})
+	if _, _, err := newCodec.Decode("session", data, &user); !errors.Is(err, ErrInvalidCookie) {
+		t.Errorf("cookie of removed secret is accepted, error %v", err)
+	}
+}
+
+// TestSecureCookie
+func TestSecureCookie(t *testing.T) {
+	orig := srvConfig.Config
+	defer func() { srvConfig.Config = orig }()
+	srvConfig.Config = &srvConfig.SrvConfig{}
+	srvConfig.Config.Encryption = srvConfig.Encryption{Secret: "secret"}
+	srvConfig.Config.Frontend.Cookie = srvConfig.Cookie{Encrypt: true}
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "http://foxden.example.org/callback", nil)
+	SetUserCookie(c, "alice", 0)
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != UserCookie || strings.Contains(cookies[0].Value, "alice") {
+		t.Fatalf("user cookie is not encrypted %+v", cookies)
+	}
+
+	// rotate secret, old cookie is accepted and re-issued
+	srvConfig.Config.Encryption = srvConfig.Encryption{Secret: "new", OldSecrets: []string{"secret"}}
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "http://foxden.example.org/", nil)
+	c.Request.AddCookie(cookies[0])
+	if user, err := UserFromCookie(c); err != nil || user != "alice" {
+		t.Fatalf("unexpected user %s, error %v", user, err)
+	}
+	reissued := w.Result().Cookies()
+	if len(reissued) != 1 || reissued[0].Value == cookies[0].Value || reissued[0].MaxAge <= 0 {
+		t.Fatalf("cookie is not re-issued %+v", reissued)
+	}
+	srvConfig.Config.Encryption = srvConfig.Encryption{Secret: "new"}
+	c, _ = gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "http://foxden.example.org/", nil)
+	c.Request.AddCookie(&http.Cookie{Name: UserCookie, Value: reissued[0].Value})
+	if user, err := UserFromCookie(c); err != nil || user != "alice" {
+		t.Errorf("re-issued cookie is rejected, user %s error %v", user, err)
+	}
+}
@@ -0,0 +1,271 @@
+package auth
+
+// cookies module provides user cookie handling for frontends served under
+// multiple domains, e.g. subdomain-wide cookies for .chess.cornell.edu, and
+// helpers to build login/logout redirect URLs behind reverse proxy.
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+)
+
+// UserCookie defines name of user cookie set by OAuth flows
+var UserCookie = "user"
+
+// helper function to get frontend cookie configuration
+func cookieConfig() srvConfig.Cookie {
+	if srvConfig.Config == nil {
+		return srvConfig.Cookie{}
+	}
+	return srvConfig.Config.Frontend.Cookie
+}
+
+// helper function to strip port from host
+func hostname(host string) string {
+	if strings.HasPrefix(host, "[") {
+		if idx := strings.Index(host, "]"); idx > 0 {
+			return host[1:idx]
+		}
+	}
+	if idx := strings.LastIndex(host, ":"); idx > 0 && !strings.Contains(host[:idx], ":") {
+		return host[:idx]
+	}
+	return host
+}
+
+// helper function to check if host belongs to cookie domain
+func inDomain(host, domain string) bool {
+	domain = strings.ToLower(strings.TrimPrefix(domain, "."))
+	host = strings.ToLower(hostname(host))
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// CookieDomain returns cookie domain for given request host. It is one of
+// configured cookie domains which matches the host or empty string, i.e.
+// host-only cookie, if none matches. If cookie domains are not configured
+// the domain of the server host name is used.
+func CookieDomain(host string) string {
+	cfg := cookieConfig()
+	if len(cfg.Domains) == 0 {
+		return domain()
+	}
+	for _, d := range cfg.Domains {
+		if inDomain(host, d) {
+			return d
+		}
+	}
+	return ""
+}
+
+// SameSite returns SameSite cookie attribute from its name
+func SameSite(name string) http.SameSite {
+	switch strings.ToLower(name) {
+	case "lax":
+		return http.SameSiteLaxMode
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	}
+	return http.SameSiteDefaultMode
+}
+
+// helper function to provide cookie path
+func cookiePath() string {
+	if path := cookieConfig().Path; path != "" {
+		return path
+	}
+	return "/"
+}
+
+// SetUserCookie sets user cookie for domain of the request host, maxAge of
+// zero means expiration from frontend configuration or 7200 seconds. The
+// cookie is encrypted if Frontend.Cookie.Encrypt option is set.
+func SetUserCookie(ctx *gin.Context, user string, maxAge int) {
+	cfg := cookieConfig()
+	if maxAge == 0 {
+		maxAge = 7200
+		if srvConfig.Config != nil && srvConfig.Config.Frontend.UserCookieExpires > 0 {
+			maxAge = int(srvConfig.Config.Frontend.UserCookieExpires.Seconds())
+		}
+	}
+	ctx.Set("user", user)
+	if cfg.Encrypt {
+		codec, err := DefaultCookieCodec()
+		if err == nil {
+			err = SetSecureCookie(ctx, codec, UserCookie, user, maxAge)
+		}
+		if err != nil {
+			log.Printf("ERROR: unable to set encrypted user cookie, error %v", err)
+		}
+		return
+	}
+	ctx.SetSameSite(SameSite(cfg.SameSite))
+	ctx.SetCookie(UserCookie, user, maxAge, cookiePath(), CookieDomain(RequestHost(ctx.Request)), cfg.Secure, true)
+}
+
+// UserFromCookie returns user name of user cookie, encrypted cookie is
+// decrypted if Frontend.Cookie.Encrypt option is set
+func UserFromCookie(ctx *gin.Context) (string, error) {
+	if !cookieConfig().Encrypt {
+		return ctx.Cookie(UserCookie)
+	}
+	codec, err := DefaultCookieCodec()
+	if err != nil {
+		return "", err
+	}
+	var user string
+	err = SecureCookie(ctx, codec, UserCookie, &user)
+	return user, err
+}
+
+// ClearUserCookie removes user cookie, e.g. on logout
+func ClearUserCookie(ctx *gin.Context) {
+	cfg := cookieConfig()
+	ctx.SetSameSite(SameSite(cfg.SameSite))
+	ctx.SetCookie(UserCookie, "", -1, cookiePath(), CookieDomain(RequestHost(ctx.Request)), cfg.Secure, true)
+}
+
+// SessionOptions provides options of session cookies used by OAuth flows
+func SessionOptions() sessions.Options {
+	cfg := cookieConfig()
+	return sessions.Options{
+		Path:     cookiePath(),
+		Secure:   cfg.Secure,
+		HttpOnly: true,
+		SameSite: SameSite(cfg.SameSite),
+	}
+}
+
+// TrustedProxy checks if request comes from one of Frontend.TrustedProxies,
+// i.e. its X-Forwarded-* headers are set by reverse proxy and not by client
+func TrustedProxy(r *http.Request) bool {
+	if srvConfig.Config == nil || len(srvConfig.Config.Frontend.TrustedProxies) == 0 {
+		return false
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, proxy := range srvConfig.Config.Frontend.TrustedProxies {
+		if _, network, err := net.ParseCIDR(proxy); err == nil {
+			if network.Contains(ip) {
+				return true
+			}
+		} else if addr := net.ParseIP(proxy); addr != nil && addr.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// helper function to get first value of comma separated forwarded header,
+// headers of requests which do not come from trusted proxy are ignored
+func forwardedHeader(r *http.Request, key string) string {
+	if !TrustedProxy(r) {
+		return ""
+	}
+	val := r.Header.Get(key)
+	if idx := strings.Index(val, ","); idx >= 0 {
+		val = val[:idx]
+	}
+	return strings.TrimSpace(val)
+}
+
+// RequestHost returns host of the request as seen by the client, i.e.
+// X-Forwarded-Host header set by trusted reverse proxy or request host
+func RequestHost(r *http.Request) string {
+	if host := forwardedHeader(r, "X-Forwarded-Host"); host != "" {
+		return host
+	}
+	return r.Host
+}
+
+// RequestScheme returns scheme of the request as seen by the client
+func RequestScheme(r *http.Request) string {
+	if proto := forwardedHeader(r, "X-Forwarded-Proto"); proto != "" {
+		return strings.ToLower(proto)
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// ExternalURL returns absolute URL of given path as seen by the client,
+// X-Forwarded-Prefix header is prepended to the path
+func ExternalURL(r *http.Request, path string) string {
+	prefix := strings.TrimSuffix(forwardedHeader(r, "X-Forwarded-Prefix"), "/")
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return fmt.Sprintf("%s://%s%s%s", RequestScheme(r), RequestHost(r), prefix, path)
+}
+
+// SafeRedirect returns target if it is relative URL or absolute URL
+// within request host or cookie domains, otherwise fallback is returned.
+// It prevents open redirects via redirect parameters.
+func SafeRedirect(r *http.Request, target, fallback string) string {
+	if target == "" {
+		return fallback
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return fallback
+	}
+	if u.Scheme == "" && u.Host == "" {
+		// reject scheme relative URLs, e.g. //evil.com or /\evil.com
+		if strings.HasPrefix(target, "/") && !strings.HasPrefix(target, "//") && !strings.HasPrefix(target, "/\\") {
+			return target
+		}
+		return fallback
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fallback
+	}
+	if strings.EqualFold(hostname(u.Host), hostname(RequestHost(r))) {
+		return target
+	}
+	for _, d := range cookieConfig().Domains {
+		if inDomain(u.Host, d) {
+			return target
+		}
+	}
+	return fallback
+}
+
+// LoginRedirectURL returns URL of login page with redirect parameter
+// pointing back to the current request
+func LoginRedirectURL(r *http.Request, loginPath string) string {
+	back := ExternalURL(r, r.URL.RequestURI())
+	return fmt.Sprintf("%s?redirect=%s", ExternalURL(r, loginPath), url.QueryEscape(back))
+}
+
+// LogoutRedirectURL returns URL where user is redirected after logout,
+// it uses redirect query parameter if it is safe or given path otherwise
+func LogoutRedirectURL(r *http.Request, path string) string {
+	return SafeRedirect(r, r.URL.Query().Get("redirect"), ExternalURL(r, path))
+}
+
+// helper function to provide copy of OAuth configuration with absolute
+// redirect URL, relative redirect URLs are resolved against request host
+func oauthConfig(r *http.Request, conf *oauth2.Config) *oauth2.Config {
+	out := *conf
+	if out.RedirectURL != "" && !strings.HasPrefix(out.RedirectURL, "http://") && !strings.HasPrefix(out.RedirectURL, "https://") {
+		out.RedirectURL = ExternalURL(r, out.RedirectURL)
+	}
+	return &out
+}
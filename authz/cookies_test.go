@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	"github.com/gin-gonic/gin"
+)
+
+// TestCookies
+func TestCookies(t *testing.T) {
+	orig := srvConfig.Config
+	defer func() { srvConfig.Config = orig }()
+	srvConfig.Config = &srvConfig.SrvConfig{}
+	srvConfig.Config.Frontend.Cookie = srvConfig.Cookie{
+		Domains:  []string{".chess.cornell.edu", "foxden.partner.org"},
+		Secure:   true,
+		SameSite: "lax",
+	}
+	srvConfig.Config.Frontend.TrustedProxies = []string{"192.0.2.0/24"}
+	if d := CookieDomain("foxden.chess.cornell.edu:8344"); d != ".chess.cornell.edu" {
+		t.Errorf("unexpected cookie domain %s", d)
+	}
+	if d := CookieDomain("foxden.partner.org"); d != "foxden.partner.org" {
+		t.Errorf("unexpected cookie domain %s", d)
+	}
+	if d := CookieDomain("evil.org"); d != "" {
+		t.Errorf("unknown host should get host-only cookie, got %s", d)
+	}
+
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "http://10.0.0.1/callback", nil)
+	c.Request.Header.Set("X-Forwarded-Host", "foxden.chess.cornell.edu")
+	SetUserCookie(c, "alice", 0)
+	cookie := w.Header().Get("Set-Cookie")
+	for _, attr := range []string{"user=alice", "Domain=chess.cornell.edu", "Max-Age=7200", "Secure", "HttpOnly", "SameSite=Lax"} {
+		if !strings.Contains(cookie, attr) {
+			t.Errorf("cookie %s does not contain %s", cookie, attr)
+		}
+	}
+}
+
+// TestRedirects
+func TestRedirects(t *testing.T) {
+	orig := srvConfig.Config
+	defer func() { srvConfig.Config = orig }()
+	srvConfig.Config = &srvConfig.SrvConfig{}
+	srvConfig.Config.Frontend.Cookie.Domains = []string{".chess.cornell.edu"}
+	srvConfig.Config.Frontend.TrustedProxies = []string{"192.0.2.1"}
+
+	r := httptest.NewRequest("GET", "http://127.0.0.1:8344/data?did=1", nil)
+	r.Header.Set("X-Forwarded-Host", "foxden.chess.cornell.edu")
+	r.Header.Set("X-Forwarded-Proto", "https")
+	r.Header.Set("X-Forwarded-Prefix", "/foxden/")
+	if u := ExternalURL(r, "/login"); u != "https://foxden.chess.cornell.edu/foxden/login" {
+		t.Errorf("unexpected external URL %s", u)
+	}
+	expect := "https://foxden.chess.cornell.edu/foxden/login?redirect=https%3A%2F%2Ffoxden.chess.cornell.edu%2Ffoxden%2Fdata%3Fdid%3D1"
+	if u := LoginRedirectURL(r, "/login"); u != expect {
+		t.Errorf("unexpected login redirect URL %s", u)
+	}
+	fallback := "/"
+	tests := map[string]string{
+		"/data":                           "/data",
+		"//evil.com":                      fallback,
+		"https://evil.com/":               fallback,
+		"javascript:alert(1)":             fallback,
+		"https://docs.chess.cornell.edu/": "https://docs.chess.cornell.edu/",
+	}
+	for target, expect := range tests {
+		if u := SafeRedirect(r, target, fallback); u != expect {
+			t.Errorf("redirect %s resolved to %s, expected %s", target, u, expect)
+		}
+	}
+	r = httptest.NewRequest("GET", "http://localhost/logout?redirect=https://evil.com", nil)
+	if u := LogoutRedirectURL(r, "/"); u != "http://localhost/" {
+		t.Errorf("unexpected logout redirect URL %s", u)
+	}
+
+	// forwarded headers of direct clients are ignored
+	r = httptest.NewRequest("GET", "http://localhost/login", nil)
+	r.RemoteAddr = "203.0.113.7:4321"
+	r.Header.Set("X-Forwarded-Host", "evil.com")
+	r.Header.Set("X-Forwarded-Proto", "https")
+	if h := RequestHost(r); h != "localhost" {
+		t.Errorf("spoofed forwarded host %s is honored", h)
+	}
+	if u := ExternalURL(r, "/data"); u != "http://localhost/data" {
+		t.Errorf("unexpected external URL %s of direct client", u)
+	}
+	if u := SafeRedirect(r, "https://evil.com/", fallback); u != fallback {
+		t.Errorf("redirect to spoofed forwarded host %s", u)
+	}
+	if SameSite("strict") != http.SameSiteStrictMode {
+		t.Error("unexpected SameSite mode")
+	}
+}
@@ -0,0 +1,545 @@
+package auth
+
+// device module implements OAuth device authorization grant (RFC 8628)
+// which allows clients without browser, e.g. detector machines and batch
+// jobs, to obtain scoped tokens: client requests device and user codes,
+// user approves the user code on verification page with own credentials
+// and client polls token endpoint until request is approved. Device
+// authorizations are persisted via storage interface, so they are shared
+// by replicas of Authz service, only hashes of device codes are stored.
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	services "github.com/CHESSComputing/golib/services"
+	"github.com/CHESSComputing/golib/storage"
+	utils "github.com/CHESSComputing/golib/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// DeviceCodesCollection defines storage collection of device authorizations
+const DeviceCodesCollection = "device_codes"
+
+// DeviceGrantType defines grant type of device access token requests
+const DeviceGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+// DeviceKind defines kind of claims of tokens issued via device grant
+const DeviceKind = "device"
+
+// defaults of device authorization grant
+const (
+	DefaultDeviceCodeExpires = 10 * time.Minute
+	DefaultDeviceInterval    = 5 * time.Second
+)
+
+// userCodeChars defines characters of user codes, vowels and similar
+// looking characters are excluded (RFC 8628, section 6.1)
+const userCodeChars = "BCDFGHJKLMNPQRSTVWXZ"
+
+// status of device authorizations
+const (
+	devicePending  = "pending"
+	deviceApproved = "approved"
+	deviceDenied   = "denied"
+	deviceUsed     = "used"
+)
+
+// errors of device authorization grant, their messages are OAuth error
+// codes returned by token endpoint
+var (
+	ErrAuthorizationPending = errors.New("authorization_pending")
+	ErrSlowDown             = errors.New("slow_down")
+	ErrDeviceAccessDenied   = errors.New("access_denied")
+	ErrDeviceCodeExpired    = errors.New("expired_token")
+	ErrInvalidDeviceCode    = errors.New("invalid_grant")
+	ErrInvalidDeviceClient  = errors.New("invalid_client")
+	ErrInvalidDeviceScope   = errors.New("invalid_scope")
+	ErrInvalidUserCode      = errors.New("invalid user code")
+)
+
+// DeviceCode represents device authorization response
+type DeviceCode struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete,omitempty"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval"`
+}
+
+// DeviceAuthorization represents device authorization record
+type DeviceAuthorization struct {
+	ID       string   `json:"-"`         // hash of device code
+	UserCode string   `json:"user_code"` // normalized user code without separator
+	ClientID string   `json:"client_id"`
+	Scope    string   `json:"scope"`
+	Created  int64    `json:"created"`
+	Expires  int64    `json:"expires"`
+	Interval int64    `json:"interval"`  // minimum interval of token requests in seconds
+	LastPoll int64    `json:"last_poll"` // time of the last token request
+	Status   string   `json:"status"`
+	User     string   `json:"user,omitempty"` // user who approved the request
+	Roles    []string `json:"roles,omitempty"`
+	Tenant   string   `json:"tenant,omitempty"`
+}
+
+// helper function to convert device authorization into storage record
+func (d DeviceAuthorization) record() map[string]any {
+	return map[string]any{
+		"_id":       d.ID,
+		"user_code": d.UserCode,
+		"client_id": d.ClientID,
+		"scope":     d.Scope,
+		"created":   d.Created,
+		"expires":   d.Expires,
+		"interval":  d.Interval,
+		"last_poll": d.LastPoll,
+		"status":    d.Status,
+		"user":      d.User,
+		"roles":     d.Roles,
+		"tenant":    d.Tenant,
+	}
+}
+
+// helper function to convert storage record into device authorization
+func deviceRecord(rec map[string]any) DeviceAuthorization {
+	str := func(key string) string {
+		v, _ := rec[key].(string)
+		return v
+	}
+	num := func(key string) int64 {
+		switch v := rec[key].(type) {
+		case int:
+			return int64(v)
+		case int32:
+			return int64(v)
+		case int64:
+			return v
+		case float64:
+			return int64(v)
+		}
+		return 0
+	}
+	var roles []string
+	// MongoDB returns arrays as primitive.A
+	if list, ok := utils.ListValues(rec["roles"]); ok {
+		for _, r := range list {
+			roles = append(roles, fmt.Sprintf("%v", r))
+		}
+	}
+	return DeviceAuthorization{
+		ID:       str("_id"),
+		UserCode: str("user_code"),
+		ClientID: str("client_id"),
+		Scope:    str("scope"),
+		Created:  num("created"),
+		Expires:  num("expires"),
+		Interval: num("interval"),
+		LastPoll: num("last_poll"),
+		Status:   str("status"),
+		User:     str("user"),
+		Roles:    roles,
+		Tenant:   str("tenant"),
+	}
+}
+
+// NormalizeUserCode returns user code in upper case without separators
+// and spaces as it is stored
+func NormalizeUserCode(code string) string {
+	code = strings.ToUpper(code)
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, code)
+}
+
+// helper function to generate user code, e.g. WDJB-MJHT
+func newUserCode() (string, error) {
+	code := make([]byte, 8)
+	max := big.NewInt(int64(len(userCodeChars)))
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = userCodeChars[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// helper function to format normalized user code for users
+func formatUserCode(code string) string {
+	if len(code) != 8 {
+		return code
+	}
+	return code[:4] + "-" + code[4:]
+}
+
+// DeviceAuthorizations manages device authorizations persisted via
+// storage interface
+type DeviceAuthorizations struct {
+	Store           storage.Store
+	Clients         []string      // public client ids allowed to request device codes
+	Scopes          []string      // scopes which can be requested
+	VerificationURI string        // page where users enter user codes
+	CodeExpires     time.Duration // lifetime of device codes
+	Interval        time.Duration // minimum interval of token requests
+	Verbose         int
+}
+
+// NewDeviceAuthorizations returns device authorizations manager of given
+// configuration
+func NewDeviceAuthorizations(store storage.Store, cfg srvConfig.DeviceAuthorization) *DeviceAuthorizations {
+	d := &DeviceAuthorizations{
+		Store:           store,
+		Clients:         cfg.Clients,
+		Scopes:          cfg.Scopes,
+		VerificationURI: cfg.VerificationURI,
+		CodeExpires:     cfg.CodeExpires,
+		Interval:        cfg.Interval,
+	}
+	if len(d.Scopes) == 0 {
+		d.Scopes = []string{"read"}
+	}
+	if d.CodeExpires <= 0 {
+		d.CodeExpires = DefaultDeviceCodeExpires
+	}
+	if d.Interval <= 0 {
+		d.Interval = DefaultDeviceInterval
+	}
+	return d
+}
+
+// Request issues device and user codes of client with given scope, empty
+// scope means read scope
+func (d *DeviceAuthorizations) Request(ctx context.Context, clientID, scope string) (DeviceCode, error) {
+	var code DeviceCode
+	if clientID == "" || !utils.InList(clientID, d.Clients) {
+		return code, fmt.Errorf("%w: client '%s' is not allowed to use device authorization", ErrInvalidDeviceClient, clientID)
+	}
+	if scope == "" {
+		scope = "read"
+	}
+	if !utils.InList(scope, d.Scopes) {
+		return code, fmt.Errorf("%w: scope '%s' can not be requested", ErrInvalidDeviceScope, scope)
+	}
+	deviceCode, err := randomHex(32)
+	if err != nil {
+		return code, err
+	}
+	userCode, err := newUserCode()
+	if err != nil {
+		return code, err
+	}
+	now := time.Now()
+	auth := DeviceAuthorization{
+		ID:       apiKeyHash(deviceCode),
+		UserCode: userCode,
+		ClientID: clientID,
+		Scope:    scope,
+		Created:  now.Unix(),
+		Expires:  now.Add(d.CodeExpires).Unix(),
+		Interval: int64(d.Interval / time.Second),
+		Status:   devicePending,
+	}
+	if auth.Interval < 1 {
+		auth.Interval = 1
+	}
+	if err := d.Store.Insert(ctx, DeviceCodesCollection, auth.record()); err != nil {
+		log.Printf("ERROR: unable to store device authorization of client %s, error %v", clientID, err)
+		return code, err
+	}
+	code = DeviceCode{
+		DeviceCode:      deviceCode,
+		UserCode:        formatUserCode(userCode),
+		VerificationURI: d.VerificationURI,
+		ExpiresIn:       int64(d.CodeExpires / time.Second),
+		Interval:        auth.Interval,
+	}
+	if d.VerificationURI != "" {
+		sep := "?"
+		if strings.Contains(d.VerificationURI, "?") {
+			sep = "&"
+		}
+		code.VerificationURIComplete = d.VerificationURI + sep + "user_code=" + url.QueryEscape(code.UserCode)
+	}
+	return code, nil
+}
+
+// Lookup returns pending device authorization of given user code, e.g. to
+// show client and scope of the request on verification page
+func (d *DeviceAuthorizations) Lookup(ctx context.Context, userCode string) (DeviceAuthorization, error) {
+	spec := map[string]any{"user_code": NormalizeUserCode(userCode), "status": devicePending}
+	rec, err := storage.FindOne(ctx, d.Store, DeviceCodesCollection, spec)
+	if errors.Is(err, storage.ErrNotFound) {
+		return DeviceAuthorization{}, ErrInvalidUserCode
+	} else if err != nil {
+		return DeviceAuthorization{}, err
+	}
+	auth := deviceRecord(rec)
+	if auth.Expires < time.Now().Unix() {
+		return auth, ErrDeviceCodeExpired
+	}
+	return auth, nil
+}
+
+// Approve approves pending device authorization of given user code with
+// identity of approving user, token issued to the device carries user
+// name, roles and tenant of the user and requested scope. Anonymous and
+// impersonation claims can not approve requests.
+func (d *DeviceAuthorizations) Approve(ctx context.Context, userCode string, claims *Claims) error {
+	if claims == nil || claims.CustomClaims.User == "" || IsAnonymous(claims) || IsImpersonated(claims) {
+		return fmt.Errorf("%w: device authorization requires authenticated user", ErrDeviceAccessDenied)
+	}
+	auth, err := d.Lookup(ctx, userCode)
+	if err != nil {
+		return err
+	}
+	update := map[string]any{
+		"status": deviceApproved,
+		"user":   claims.CustomClaims.User,
+		"roles":  claims.CustomClaims.Roles,
+		"tenant": claims.CustomClaims.Tenant,
+	}
+	if err := d.setStatus(ctx, auth, update); err != nil {
+		return err
+	}
+	log.Printf("INFO: user %s approved device authorization of client %s with scope %s", claims.CustomClaims.User, auth.ClientID, auth.Scope)
+	return nil
+}
+
+// Deny denies pending device authorization of given user code on behalf
+// of authenticated user
+func (d *DeviceAuthorizations) Deny(ctx context.Context, userCode string, claims *Claims) error {
+	if claims == nil || claims.CustomClaims.User == "" || IsAnonymous(claims) {
+		return fmt.Errorf("%w: device authorization requires authenticated user", ErrDeviceAccessDenied)
+	}
+	auth, err := d.Lookup(ctx, userCode)
+	if err != nil {
+		return err
+	}
+	return d.setStatus(ctx, auth, map[string]any{"status": deviceDenied})
+}
+
+// helper function to update pending device authorization, concurrent
+// updates are detected via status condition
+func (d *DeviceAuthorizations) setStatus(ctx context.Context, auth DeviceAuthorization, update map[string]any) error {
+	spec := map[string]any{"_id": auth.ID, "status": devicePending}
+	n, err := d.Store.Update(ctx, DeviceCodesCollection, spec, update)
+	if err != nil {
+		log.Printf("ERROR: unable to update device authorization, error %v", err)
+		return err
+	}
+	if n == 0 {
+		return ErrInvalidUserCode
+	}
+	return nil
+}
+
+// Poll checks device authorization of client device code, approved
+// authorization is returned once, pending authorization returns
+// ErrAuthorizationPending or ErrSlowDown if client polls too often
+func (d *DeviceAuthorizations) Poll(ctx context.Context, clientID, deviceCode string) (DeviceAuthorization, error) {
+	id := apiKeyHash(deviceCode)
+	rec, err := storage.FindOne(ctx, d.Store, DeviceCodesCollection, map[string]any{"_id": id})
+	if errors.Is(err, storage.ErrNotFound) {
+		return DeviceAuthorization{}, ErrInvalidDeviceCode
+	} else if err != nil {
+		return DeviceAuthorization{}, err
+	}
+	auth := deviceRecord(rec)
+	if auth.ClientID != clientID {
+		return auth, ErrInvalidDeviceCode
+	}
+	now := time.Now().Unix()
+	if auth.Expires < now {
+		return auth, ErrDeviceCodeExpired
+	}
+	switch auth.Status {
+	case devicePending:
+		update := map[string]any{"last_poll": now}
+		err := ErrAuthorizationPending
+		if now-auth.LastPoll < auth.Interval {
+			// clients polling too often should increase interval by 5
+			// seconds (RFC 8628, section 3.5)
+			update["interval"] = auth.Interval + 5
+			err = ErrSlowDown
+		}
+		if _, uerr := d.Store.Update(ctx, DeviceCodesCollection, map[string]any{"_id": id}, update); uerr != nil {
+			return auth, uerr
+		}
+		return auth, err
+	case deviceDenied:
+		return auth, ErrDeviceAccessDenied
+	case deviceApproved:
+		// device code can be exchanged for token only once
+		spec := map[string]any{"_id": id, "status": deviceApproved}
+		n, err := d.Store.Update(ctx, DeviceCodesCollection, spec, map[string]any{"status": deviceUsed})
+		if err != nil {
+			return auth, err
+		}
+		if n == 0 {
+			return auth, ErrInvalidDeviceCode
+		}
+		return auth, nil
+	}
+	return auth, ErrInvalidDeviceCode
+}
+
+// Cleanup removes device authorizations expired before given time
+func (d *DeviceAuthorizations) Cleanup(ctx context.Context, before time.Time) (int64, error) {
+	return d.Store.Remove(ctx, DeviceCodesCollection, map[string]any{"expires": map[string]any{"$lt": before.Unix()}})
+}
+
+// deviceError represents OAuth error response of device endpoints
+type deviceError struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+// helper function to write OAuth error response
+func deviceErrorResponse(c *gin.Context, err error) {
+	code := http.StatusBadRequest
+	oerr := deviceError{Error: "server_error", Description: err.Error()}
+	for _, e := range []error{ErrAuthorizationPending, ErrSlowDown, ErrDeviceAccessDenied, ErrDeviceCodeExpired, ErrInvalidDeviceCode, ErrInvalidDeviceClient, ErrInvalidDeviceScope} {
+		if errors.Is(err, e) {
+			oerr.Error = e.Error()
+			if oerr.Description == oerr.Error {
+				oerr.Description = ""
+			}
+		}
+	}
+	if oerr.Error == "server_error" {
+		code = http.StatusInternalServerError
+	} else if oerr.Error == ErrInvalidDeviceClient.Error() {
+		code = http.StatusUnauthorized
+	}
+	c.JSON(code, oerr)
+}
+
+// DeviceCodeHandler provides gin handler of device authorization endpoint,
+// e.g. POST /oauth/device/code with client_id and scope form parameters
+func (d *DeviceAuthorizations) DeviceCodeHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		code, err := d.Request(c.Request.Context(), c.PostForm("client_id"), c.PostForm("scope"))
+		if err != nil {
+			log.Printf("ERROR: unable to issue device code, error %v", err)
+			deviceErrorResponse(c, err)
+			return
+		}
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusOK, code)
+	}
+}
+
+// DeviceTokenHandler provides gin handler of token endpoint of device
+// grant, e.g. POST /oauth/token with grant_type, device_code and client_id
+// form parameters. Tokens of approved requests are signed with clientId
+// secret, they are bound to DPoP key or client certificate of the request
+// if token binding is configured.
+func (d *DeviceAuthorizations) DeviceTokenHandler(clientId string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if grant := c.PostForm("grant_type"); grant != DeviceGrantType {
+			c.JSON(http.StatusBadRequest, deviceError{Error: "unsupported_grant_type", Description: grant})
+			return
+		}
+		cnf, err := RequestConfirmation(c.Request)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, deviceError{Error: "invalid_dpop_proof", Description: err.Error()})
+			return
+		}
+		auth, err := d.Poll(c.Request.Context(), c.PostForm("client_id"), c.PostForm("device_code"))
+		if err != nil {
+			if d.Verbose > 0 || !errors.Is(err, ErrAuthorizationPending) {
+				log.Printf("WARNING: device token request of client %s, error %v", c.PostForm("client_id"), err)
+			}
+			deviceErrorResponse(c, err)
+			return
+		}
+		claims := CustomClaims{
+			User:        auth.User,
+			Scope:       auth.Scope,
+			Kind:        DeviceKind,
+			Roles:       auth.Roles,
+			Application: auth.ClientID,
+			Tenant:      auth.Tenant,
+		}
+		token, err := BoundJWTAccessToken(clientId, 0, claims, cnf)
+		if err != nil {
+			deviceErrorResponse(c, err)
+			return
+		}
+		tclaims, err := TokenClaims(token, clientId)
+		if err != nil {
+			deviceErrorResponse(c, err)
+			return
+		}
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusOK, Token{
+			AccessToken: token,
+			Expires:     tclaims.ExpiresAt.Unix() - time.Now().Unix(),
+			Scope:       auth.Scope,
+			TokenType:   "bearer",
+		})
+	}
+}
+
+// DeviceApproveRequest represents approval of device authorization
+type DeviceApproveRequest struct {
+	UserCode string `json:"user_code" form:"user_code"`
+	Approve  bool   `json:"approve" form:"approve"` // false denies the request
+}
+
+// DeviceApproveHandler provides gin handler of verification page which
+// approves or denies device authorization of user code, it should be used
+// after authorization middleware which sets request claims in gin context
+func (d *DeviceAuthorizations) DeviceApproveHandler(clientId string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var claims *Claims
+		if val, ok := c.Get("claims"); ok {
+			claims, _ = val.(*Claims)
+		} else if tclaims, err := TokenClaims(RequestToken(c.Request), clientId); err == nil {
+			claims = tclaims
+		}
+		var req DeviceApproveRequest
+		if err := c.ShouldBind(&req); err != nil {
+			rec := services.Response("authz", http.StatusBadRequest, services.BindError, err)
+			c.JSON(http.StatusBadRequest, rec)
+			return
+		}
+		var err error
+		if req.Approve {
+			err = d.Approve(c.Request.Context(), req.UserCode, claims)
+		} else {
+			err = d.Deny(c.Request.Context(), req.UserCode, claims)
+		}
+		if err != nil {
+			code := http.StatusBadRequest
+			if errors.Is(err, ErrDeviceAccessDenied) {
+				code = http.StatusForbidden
+			} else if !errors.Is(err, ErrInvalidUserCode) && !errors.Is(err, ErrDeviceCodeExpired) {
+				code = http.StatusInternalServerError
+			}
+			rec := services.Response("authz", code, services.TokenError, err)
+			c.JSON(code, rec)
+			return
+		}
+		status := deviceApproved
+		if !req.Approve {
+			status = deviceDenied
+		}
+		c.JSON(http.StatusOK, map[string]string{"user_code": req.UserCode, "status": status})
+	}
+}
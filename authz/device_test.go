@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	"github.com/CHESSComputing/golib/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// TestDeviceAuthorizations
+func TestDeviceAuthorizations(t *testing.T) {
+	ctx := context.Background()
+	cfg := srvConfig.DeviceAuthorization{Clients: []string{"daq"}, Scopes: []string{"read", "write"}, VerificationURI: "https://authz/device"}
+	devices := NewDeviceAuthorizations(storage.NewMemoryStore(), cfg)
+	if _, err := devices.Request(ctx, "unknown", "read"); !errors.Is(err, ErrInvalidDeviceClient) {
+		t.Errorf("unknown client is allowed, error %v", err)
+	}
+	if _, err := devices.Request(ctx, "daq", "delete"); !errors.Is(err, ErrInvalidDeviceScope) {
+		t.Errorf("delete scope is allowed, error %v", err)
+	}
+	code, err := devices.Request(ctx, "daq", "write")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(code.UserCode) != 9 || code.Interval != 5 || code.ExpiresIn != 600 || code.VerificationURIComplete != "https://authz/device?user_code="+code.UserCode {
+		t.Errorf("wrong device code %+v", code)
+	}
+
+	// the first poll is pending, immediate next poll should slow down
+	if _, err := devices.Poll(ctx, "daq", code.DeviceCode); !errors.Is(err, ErrAuthorizationPending) {
+		t.Errorf("wrong error %v", err)
+	}
+	if _, err := devices.Poll(ctx, "daq", code.DeviceCode); !errors.Is(err, ErrSlowDown) {
+		t.Errorf("wrong error %v", err)
+	}
+	if _, err := devices.Poll(ctx, "other", code.DeviceCode); !errors.Is(err, ErrInvalidDeviceCode) {
+		t.Errorf("device code of another client is accepted, error %v", err)
+	}
+
+	// anonymous users can not approve requests
+	anonymous := &Claims{CustomClaims: CustomClaims{User: "anonymous", Kind: AnonymousKind}}
+	if err := devices.Approve(ctx, code.UserCode, anonymous); !errors.Is(err, ErrDeviceAccessDenied) {
+		t.Errorf("anonymous approval, error %v", err)
+	}
+	user := &Claims{CustomClaims: CustomClaims{User: "alice", Roles: []string{"id3a"}}}
+	lower := strings.ToLower(code.UserCode)
+	if auth, err := devices.Lookup(ctx, lower); err != nil || auth.ClientID != "daq" || auth.Scope != "write" {
+		t.Errorf("wrong authorization %+v, error %v", auth, err)
+	}
+	if err := devices.Approve(ctx, lower, user); err != nil {
+		t.Fatal(err)
+	}
+	if err := devices.Approve(ctx, code.UserCode, user); !errors.Is(err, ErrInvalidUserCode) {
+		t.Errorf("request is approved twice, error %v", err)
+	}
+	auth, err := devices.Poll(ctx, "daq", code.DeviceCode)
+	if err != nil || auth.User != "alice" || len(auth.Roles) != 1 || auth.Scope != "write" {
+		t.Errorf("wrong authorization %+v, error %v", auth, err)
+	}
+	if _, err := devices.Poll(ctx, "daq", code.DeviceCode); !errors.Is(err, ErrInvalidDeviceCode) {
+		t.Errorf("device code is used twice, error %v", err)
+	}
+
+	// denied request
+	code, _ = devices.Request(ctx, "daq", "")
+	if err := devices.Deny(ctx, code.UserCode, user); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := devices.Poll(ctx, "daq", code.DeviceCode); !errors.Is(err, ErrDeviceAccessDenied) {
+		t.Errorf("wrong error %v", err)
+	}
+}
+
+// TestDeviceHandlers
+func TestDeviceHandlers(t *testing.T) {
+	config := srvConfig.Config
+	t.Cleanup(func() { srvConfig.Config = config })
+	srvConfig.Config = &srvConfig.SrvConfig{}
+	gin.SetMode(gin.TestMode)
+	cfg := srvConfig.DeviceAuthorization{Clients: []string{"daq"}, VerificationURI: "https://authz/device"}
+	devices := NewDeviceAuthorizations(storage.NewMemoryStore(), cfg)
+	devices.Interval = 0
+	r := gin.New()
+	r.POST("/oauth/device/code", devices.DeviceCodeHandler())
+	r.POST("/oauth/token", devices.DeviceTokenHandler("secret"))
+	r.POST("/device", TokenMiddleware("secret", 0), devices.DeviceApproveHandler("secret"))
+	post := func(path string, form url.Values, token string) (int, map[string]any) {
+		req := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var out map[string]any
+		json.Unmarshal(w.Body.Bytes(), &out)
+		return w.Code, out
+	}
+
+	code, out := post("/oauth/device/code", url.Values{"client_id": {"daq"}}, "")
+	if code != http.StatusOK || out["user_code"] == nil || out["device_code"] == nil {
+		t.Fatalf("wrong device code response %d %v", code, out)
+	}
+	userCode, deviceCode := out["user_code"].(string), out["device_code"].(string)
+	tokenForm := url.Values{"grant_type": {DeviceGrantType}, "client_id": {"daq"}, "device_code": {deviceCode}}
+	if code, out := post("/oauth/token", tokenForm, ""); code != http.StatusBadRequest || out["error"] != "authorization_pending" {
+		t.Errorf("wrong pending response %d %v", code, out)
+	}
+	if code, _ := post("/device", url.Values{"user_code": {userCode}, "approve": {"true"}}, ""); code != http.StatusUnauthorized {
+		t.Errorf("approval without token, code %d", code)
+	}
+	token, _ := JWTAccessToken("secret", 60, CustomClaims{User: "alice", Scope: "read", Roles: []string{"id3a"}})
+	if code, out := post("/device", url.Values{"user_code": {userCode}, "approve": {"true"}}, token); code != http.StatusOK || out["status"] != "approved" {
+		t.Errorf("wrong approval response %d %v", code, out)
+	}
+	code, out = post("/oauth/token", tokenForm, "")
+	if code != http.StatusOK || out["access_token"] == nil {
+		t.Fatalf("wrong token response %d %v", code, out)
+	}
+	claims, err := TokenClaims(out["access_token"].(string), "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.CustomClaims.User != "alice" || claims.CustomClaims.Kind != DeviceKind || claims.CustomClaims.Application != "daq" || claims.CustomClaims.Scope != "read" {
+		t.Errorf("wrong token claims %+v", claims.CustomClaims)
+	}
+	if code, out := post("/oauth/token", tokenForm, ""); code != http.StatusBadRequest || out["error"] != "invalid_grant" {
+		t.Errorf("device code is used twice, response %d %v", code, out)
+	}
+	if code, out := post("/oauth/device/code", url.Values{"client_id": {"other"}}, ""); code != http.StatusUnauthorized || out["error"] != "invalid_client" {
+		t.Errorf("wrong response of unknown client %d %v", code, out)
+	}
+}
@@ -54,7 +54,7 @@ func FacebookOauthLogin(ctx *gin.Context, verbose int) {
 	if verbose > 0 {
 		log.Printf("FacebookOauthLogin config %+v", config)
 	}
-	redirectURL := config.AuthCodeURL(state)
+	redirectURL := oauthConfig(ctx.Request, config).AuthCodeURL(state)
 	session := sessions.Default(ctx)
 	session.Set("state", state)
 	err := session.Save()
@@ -81,7 +81,7 @@ func FacebookCallBack(ctx *gin.Context, endpoint string, verbose int) {
 	}
 
 	code := ctx.Query("code")
-	token, err := facebook_config.Exchange(ctx, code)
+	token, err := oauthConfig(ctx.Request, facebook_config).Exchange(ctx, code)
 	if err != nil {
 		_ = ctx.AbortWithError(http.StatusUnauthorized, err)
 		return
@@ -111,7 +111,6 @@ func FacebookCallBack(ctx *gin.Context, endpoint string, verbose int) {
 		log.Printf("### facebook json %+v", user)
 	}
 	// set necessary cookie for our web server
-	ctx.Set("user", user.Name)
-	ctx.SetCookie("user", user.Name, 7200, "/", domain(), false, true)
+	SetUserCookie(ctx, user.Name, 0)
 	ctx.Redirect(http.StatusSeeOther, endpoint)
 }
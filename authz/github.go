@@ -82,7 +82,7 @@ func GithubOauthLogin(ctx *gin.Context, verbose int) {
 	if verbose > 0 {
 		log.Printf("GithubOauthLogin config %+v", config)
 	}
-	redirectURL := config.AuthCodeURL(state)
+	redirectURL := oauthConfig(ctx.Request, config).AuthCodeURL(state)
 	session := sessions.Default(ctx)
 	session.Set("state", state)
 	err := session.Save()
@@ -105,7 +105,7 @@ func GithubCallBack(ctx *gin.Context, endpoint string, verbose int) {
 	}
 
 	code := ctx.Query("code")
-	token, err := github_config.Exchange(ctx, code)
+	token, err := oauthConfig(ctx.Request, github_config).Exchange(ctx, code)
 	if err != nil {
 		_ = ctx.AbortWithError(http.StatusUnauthorized, err)
 		return
@@ -138,8 +138,7 @@ func GithubCallBack(ctx *gin.Context, endpoint string, verbose int) {
 	//     expiration := time.Now().Add(24 * time.Hour)
 	//     cookie := http.Cookie{Name: "user", Value: user.Login, Expires: expiration}
 	//     http.SetCookie(ctx.Writer, &cookie)
-	ctx.Set("user", user.Login)
-	ctx.SetCookie("user", user.Login, 7200, "/", domain(), false, true)
+	SetUserCookie(ctx, user.Login, 0)
 	ctx.Redirect(http.StatusSeeOther, endpoint)
 }
 
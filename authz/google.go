@@ -61,7 +61,7 @@ func GoogleOauthLogin(ctx *gin.Context, verbose int) {
 	if verbose > 0 {
 		log.Printf("GoogleOauthLogin config %+v", config)
 	}
-	redirectURL := config.AuthCodeURL(state)
+	redirectURL := oauthConfig(ctx.Request, config).AuthCodeURL(state)
 	session := sessions.Default(ctx)
 	session.Set("state", state)
 	err := session.Save()
@@ -87,7 +87,7 @@ func GoogleCallBack(ctx *gin.Context, endpoint string, verbose int) {
 	}
 
 	code := ctx.Query("code")
-	token, err := google_config.Exchange(ctx, code)
+	token, err := oauthConfig(ctx.Request, google_config).Exchange(ctx, code)
 	if err != nil {
 		log.Printf("GoogleCallBack exchange %s", err)
 		_ = ctx.AbortWithError(http.StatusUnauthorized, err)
@@ -122,7 +122,6 @@ func GoogleCallBack(ctx *gin.Context, endpoint string, verbose int) {
 		log.Printf("### google json %+v", user)
 	}
 	// set necessary cookie for our web server
-	ctx.Set("user", user.Name)
-	ctx.SetCookie("user", user.Name, 7200, "/", domain(), false, true)
+	SetUserCookie(ctx, user.Name, 0)
 	ctx.Redirect(http.StatusSeeOther, endpoint)
 }
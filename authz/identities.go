@@ -0,0 +1,242 @@
+package auth
+
+// identities module links external identities, i.e. subjects of OAuth
+// providers (ORCID, GitHub, Google, etc.) and Kerberos principals, to one
+// canonical user, so a user gets the same permissions regardless of the
+// way they log in. Identity records are persisted via storage interface.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/CHESSComputing/golib/storage"
+	"gopkg.in/jcmturner/gokrb5.v7/credentials"
+)
+
+// IdentitiesCollection defines storage collection of linked identities
+const IdentitiesCollection = "identities"
+
+// KerberosProvider defines provider name of Kerberos principals
+const KerberosProvider = "kerberos"
+
+// errors of linked identities
+var (
+	ErrIdentityNotLinked = errors.New("identity is not linked")
+	ErrIdentityConflict  = errors.New("identity is linked to another user")
+)
+
+// Identity represents external identity linked to canonical user
+type Identity struct {
+	ID       string `json:"id"`       // provider:subject
+	Provider string `json:"provider"` // provider name, e.g. orcid, github or kerberos
+	Subject  string `json:"subject"`  // provider subject id or Kerberos principal
+	User     string `json:"user"`     // canonical user
+	Email    string `json:"email"`
+	Created  int64  `json:"created"`
+}
+
+// helper function to convert identity into storage record
+func (i Identity) record() map[string]any {
+	return map[string]any{
+		"_id":      i.ID,
+		"provider": i.Provider,
+		"subject":  i.Subject,
+		"user":     i.User,
+		"email":    i.Email,
+		"created":  i.Created,
+	}
+}
+
+// helper function to convert storage record into identity
+func identityRecord(rec map[string]any) Identity {
+	str := func(key string) string {
+		v, _ := rec[key].(string)
+		return v
+	}
+	var created int64
+	switch v := rec["created"].(type) {
+	case int:
+		created = int64(v)
+	case int32:
+		created = int64(v)
+	case int64:
+		created = v
+	case float64:
+		created = int64(v)
+	}
+	return Identity{
+		ID:       str("_id"),
+		Provider: str("provider"),
+		Subject:  str("subject"),
+		User:     str("user"),
+		Email:    str("email"),
+		Created:  created,
+	}
+}
+
+// IdentityID returns normalized id of provider identity. Provider names are
+// case insensitive, realms of Kerberos principals are upper case.
+func IdentityID(provider, subject string) (string, error) {
+	provider = strings.ToLower(strings.TrimSpace(provider))
+	subject = strings.TrimSpace(subject)
+	if provider == "" || subject == "" {
+		return "", errors.New("identity requires provider and subject")
+	}
+	if provider == KerberosProvider {
+		subject = normalizePrincipal(subject)
+	}
+	return provider + ":" + subject, nil
+}
+
+// helper function to normalize Kerberos principal
+func normalizePrincipal(principal string) string {
+	if idx := strings.LastIndex(principal, "@"); idx > 0 {
+		return principal[:idx] + "@" + strings.ToUpper(principal[idx+1:])
+	}
+	return principal
+}
+
+// KerberosPrincipal returns principal name of Kerberos credentials, e.g.
+// user@CHESS.CORNELL.EDU
+func KerberosPrincipal(creds *credentials.Credentials) string {
+	return fmt.Sprintf("%s@%s", creds.UserName(), strings.ToUpper(creds.Domain()))
+}
+
+// Identities manages identities linked to canonical users and persisted via
+// storage interface
+type Identities struct {
+	Store   storage.Store
+	Verbose int
+}
+
+// NewIdentities returns identities manager
+func NewIdentities(store storage.Store) *Identities {
+	return &Identities{Store: store}
+}
+
+// helper function to find identity record
+func (i *Identities) find(ctx context.Context, id string) (Identity, error) {
+	rec, err := storage.FindOne(ctx, i.Store, IdentitiesCollection, map[string]any{"_id": id})
+	if errors.Is(err, storage.ErrNotFound) {
+		return Identity{}, ErrIdentityNotLinked
+	}
+	if err != nil {
+		return Identity{}, err
+	}
+	return identityRecord(rec), nil
+}
+
+// Link links provider identity to canonical user. Linking identity already
+// linked to the same user is no-op, ErrIdentityConflict is returned if
+// identity is linked to another user.
+func (i *Identities) Link(ctx context.Context, user, provider, subject, email string) (Identity, error) {
+	if user == "" {
+		return Identity{}, errors.New("identity requires user")
+	}
+	id, err := IdentityID(provider, subject)
+	if err != nil {
+		return Identity{}, err
+	}
+	ident, err := i.find(ctx, id)
+	if err == nil {
+		if ident.User != user {
+			log.Printf("ERROR: identity %s of user %s can not be linked to user %s", id, ident.User, user)
+			return ident, fmt.Errorf("%w: %s is linked to user %s", ErrIdentityConflict, id, ident.User)
+		}
+		return ident, nil
+	}
+	if !errors.Is(err, ErrIdentityNotLinked) {
+		return Identity{}, err
+	}
+	provider, subject, _ = strings.Cut(id, ":")
+	ident = Identity{
+		ID:       id,
+		Provider: provider,
+		Subject:  subject,
+		User:     user,
+		Email:    email,
+		Created:  time.Now().Unix(),
+	}
+	if err := i.Store.Insert(ctx, IdentitiesCollection, ident.record()); err != nil {
+		if errors.Is(err, storage.ErrDuplicate) {
+			// identity is linked concurrently, check its owner
+			return i.Link(ctx, user, provider, subject, email)
+		}
+		log.Printf("ERROR: unable to store identity %s, error %v", id, err)
+		return Identity{}, err
+	}
+	if i.Verbose > 0 {
+		log.Printf("INFO: identity %s is linked to user %s", id, user)
+	}
+	return ident, nil
+}
+
+// Unlink unlinks provider identity from canonical user, ErrIdentityConflict
+// is returned if identity is linked to another user
+func (i *Identities) Unlink(ctx context.Context, user, provider, subject string) error {
+	id, err := IdentityID(provider, subject)
+	if err != nil {
+		return err
+	}
+	ident, err := i.find(ctx, id)
+	if err != nil {
+		return err
+	}
+	if ident.User != user {
+		return fmt.Errorf("%w: %s is linked to user %s", ErrIdentityConflict, id, ident.User)
+	}
+	if _, err := i.Store.Remove(ctx, IdentitiesCollection, map[string]any{"_id": id}); err != nil {
+		return err
+	}
+	if i.Verbose > 0 {
+		log.Printf("INFO: identity %s is unlinked from user %s", id, user)
+	}
+	return nil
+}
+
+// Resolve returns canonical user of provider identity or
+// ErrIdentityNotLinked
+func (i *Identities) Resolve(ctx context.Context, provider, subject string) (string, error) {
+	id, err := IdentityID(provider, subject)
+	if err != nil {
+		return "", err
+	}
+	ident, err := i.find(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	return ident.User, nil
+}
+
+// CanonicalUser returns canonical user of identity used to log in, given
+// user name is returned if identity is not linked. Login flows use it to
+// grant the same permissions to all identities of the user.
+func (i *Identities) CanonicalUser(ctx context.Context, provider, subject, user string) (string, error) {
+	canonical, err := i.Resolve(ctx, provider, subject)
+	if errors.Is(err, ErrIdentityNotLinked) {
+		return user, nil
+	}
+	return canonical, err
+}
+
+// List returns identities linked to given user, empty user means all
+// identities
+func (i *Identities) List(ctx context.Context, user string) ([]Identity, error) {
+	var spec map[string]any
+	if user != "" {
+		spec = map[string]any{"user": user}
+	}
+	records, err := i.Store.Find(ctx, IdentitiesCollection, spec, &storage.FindOptions{Sort: []string{"created"}})
+	if err != nil {
+		return nil, err
+	}
+	var idents []Identity
+	for _, rec := range records {
+		idents = append(idents, identityRecord(rec))
+	}
+	return idents, nil
+}
@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/CHESSComputing/golib/storage"
+)
+
+// TestIdentities
+func TestIdentities(t *testing.T) {
+	ctx := context.Background()
+	idents := NewIdentities(storage.NewMemoryStore())
+	ident, err := idents.Link(ctx, "alice", "ORCID", "0000-0002-1825-0097", "alice@example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ident.ID != "orcid:0000-0002-1825-0097" || ident.Provider != "orcid" || ident.User != "alice" {
+		t.Errorf("unexpected identity %+v", ident)
+	}
+	if _, err := idents.Link(ctx, "alice", KerberosProvider, "ajones@chess.cornell.edu", ""); err != nil {
+		t.Fatal(err)
+	}
+	// linking is idempotent and principal realm is case insensitive
+	if _, err := idents.Link(ctx, "alice", "kerberos", "ajones@CHESS.CORNELL.EDU", ""); err != nil {
+		t.Errorf("linking the same identity fails, error %v", err)
+	}
+	if _, err := idents.Link(ctx, "bob", "orcid", "0000-0002-1825-0097", ""); !errors.Is(err, ErrIdentityConflict) {
+		t.Errorf("expected ErrIdentityConflict, got %v", err)
+	}
+	if _, err := idents.Link(ctx, "bob", "", "bob", ""); err == nil {
+		t.Error("identity without provider is linked")
+	}
+
+	// both identities resolve to the same user
+	for _, id := range [][2]string{{"orcid", "0000-0002-1825-0097"}, {"kerberos", "ajones@CHESS.CORNELL.EDU"}} {
+		if user, err := idents.CanonicalUser(ctx, id[0], id[1], "ajones"); err != nil || user != "alice" {
+			t.Errorf("identity %v resolves to user %s, error %v", id, user, err)
+		}
+	}
+	if user, err := idents.CanonicalUser(ctx, "github", "12345", "bob"); err != nil || user != "bob" {
+		t.Errorf("unlinked identity resolves to user %s, error %v", user, err)
+	}
+	if _, err := idents.Resolve(ctx, "github", "12345"); !errors.Is(err, ErrIdentityNotLinked) {
+		t.Errorf("expected ErrIdentityNotLinked, got %v", err)
+	}
+	if list, err := idents.List(ctx, "alice"); err != nil || len(list) != 2 {
+		t.Errorf("unexpected identities %+v, error %v", list, err)
+	}
+
+	// unlink
+	if err := idents.Unlink(ctx, "bob", "orcid", "0000-0002-1825-0097"); !errors.Is(err, ErrIdentityConflict) {
+		t.Errorf("identity of another user is unlinked, error %v", err)
+	}
+	if err := idents.Unlink(ctx, "alice", "orcid", "0000-0002-1825-0097"); err != nil {
+		t.Fatal(err)
+	}
+	if err := idents.Unlink(ctx, "alice", "orcid", "0000-0002-1825-0097"); !errors.Is(err, ErrIdentityNotLinked) {
+		t.Errorf("expected ErrIdentityNotLinked, got %v", err)
+	}
+	if _, err := idents.Link(ctx, "bob", "orcid", "0000-0002-1825-0097", ""); err != nil {
+		t.Errorf("unlinked identity can not be linked to another user, error %v", err)
+	}
+}
@@ -0,0 +1,182 @@
+package auth
+
+// impersonation module allows administrators to issue short-lived tokens
+// acting as another user, e.g. to debug user specific permission issues.
+// Impersonation tokens carry act claim (RFC 8693) with the true actor and
+// every issued token is recorded by the impersonation auditor.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	services "github.com/CHESSComputing/golib/services"
+	utils "github.com/CHESSComputing/golib/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultImpersonationExpires defines maximum lifetime of impersonation
+// tokens if it is not configured
+const DefaultImpersonationExpires = 15 * time.Minute
+
+// errors of impersonation
+var (
+	ErrImpersonationDisabled  = errors.New("impersonation is disabled")
+	ErrImpersonationForbidden = errors.New("impersonation is not allowed")
+)
+
+// Actor represents act claim of impersonation token (RFC 8693), i.e. the
+// user who acts on behalf of token subject
+type Actor struct {
+	Subject string `json:"sub"`
+}
+
+// ImpersonationEvent represents issued impersonation token
+type ImpersonationEvent struct {
+	Actor   string // administrator who issued the token
+	User    string // impersonated user
+	Scope   string
+	Roles   []string
+	Reason  string
+	TokenID string // jti claim of issued token
+	Expires int64  // token expiration, unix time
+}
+
+// ImpersonationAuditor records issued impersonation tokens, tokens are not
+// issued if auditor is not set or fails to record the event. The audit
+// module sets it when audit logger is initialized.
+var ImpersonationAuditor func(ctx context.Context, event ImpersonationEvent) error
+
+// IsImpersonated checks if claims belong to impersonation token
+func IsImpersonated(claims *Claims) bool {
+	return claims != nil && claims.Actor != nil
+}
+
+// helper function to get impersonation configuration
+func impersonationConfig() ([]string, time.Duration) {
+	if srvConfig.Config == nil {
+		return nil, DefaultImpersonationExpires
+	}
+	cfg := srvConfig.Config.Authz.Impersonation
+	maxExpires := cfg.MaxExpires
+	if maxExpires <= 0 {
+		maxExpires = DefaultImpersonationExpires
+	}
+	return cfg.AdminRoles, maxExpires
+}
+
+// Impersonate issues token of given user claims on behalf of administrator
+// with admin claims. Administrator must have one of configured admin roles,
+// impersonation tokens can not be used to impersonate other users. Token
+// lifetime is limited by configured maximum, zero expires means maximum
+// lifetime.
+func Impersonate(ctx context.Context, admin *Claims, clientId string, user CustomClaims, reason string, expires int64) (string, ImpersonationEvent, error) {
+	var event ImpersonationEvent
+	roles, maxExpires := impersonationConfig()
+	if len(roles) == 0 {
+		return "", event, ErrImpersonationDisabled
+	}
+	if admin == nil || admin.CustomClaims.User == "" {
+		return "", event, fmt.Errorf("%w: unknown administrator", ErrImpersonationForbidden)
+	}
+	if IsImpersonated(admin) {
+		return "", event, fmt.Errorf("%w: impersonation token of %s", ErrImpersonationForbidden, admin.Actor.Subject)
+	}
+	var isAdmin bool
+	for _, role := range admin.CustomClaims.Roles {
+		if utils.InList(role, roles) {
+			isAdmin = true
+			break
+		}
+	}
+	if !isAdmin {
+		log.Printf("ERROR: user %s without admin role attempts to impersonate user %s", admin.CustomClaims.User, user.User)
+		return "", event, fmt.Errorf("%w: user %s does not have admin role", ErrImpersonationForbidden, admin.CustomClaims.User)
+	}
+	if user.User == "" || user.User == admin.CustomClaims.User {
+		return "", event, fmt.Errorf("%w: invalid user '%s'", ErrImpersonationForbidden, user.User)
+	}
+	if reason == "" {
+		return "", event, errors.New("impersonation requires reason")
+	}
+	if ImpersonationAuditor == nil {
+		return "", event, errors.New("impersonation requires audit logger")
+	}
+	if expires <= 0 || time.Duration(expires)*time.Second > maxExpires {
+		expires = int64(maxExpires / time.Second)
+	}
+	if user.Scope == "" {
+		user.Scope = "read"
+	}
+	user.Kind = "impersonation"
+
+	claims := newClaims(expires, user)
+	claims.Actor = &Actor{Subject: admin.CustomClaims.User}
+	event = ImpersonationEvent{
+		Actor:   admin.CustomClaims.User,
+		User:    user.User,
+		Scope:   user.Scope,
+		Roles:   user.Roles,
+		Reason:  reason,
+		TokenID: claims.ID,
+		Expires: claims.ExpiresAt.Unix(),
+	}
+	if err := ImpersonationAuditor(ctx, event); err != nil {
+		log.Printf("ERROR: unable to audit impersonation %+v, error %v", event, err)
+		return "", event, err
+	}
+	log.Printf("WARNING: user %s impersonates user %s with scope %s until %s, reason: %s",
+		event.Actor, event.User, event.Scope, time.Unix(event.Expires, 0).Format(time.RFC3339), reason)
+	token, err := signedToken(clientId, claims)
+	return token, event, err
+}
+
+// ImpersonationRequest represents request of impersonation token
+type ImpersonationRequest struct {
+	User    string   `json:"user"`
+	Scope   string   `json:"scope"`
+	Roles   []string `json:"roles"`
+	Reason  string   `json:"reason"`
+	Expires int64    `json:"expires"` // token lifetime in seconds
+}
+
+// ImpersonationHandler provides gin handler which issues impersonation
+// tokens to administrators, it should be used after authorization
+// middleware which sets request claims in gin context.
+func ImpersonationHandler(clientId string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var admin *Claims
+		if val, ok := c.Get("claims"); ok {
+			admin, _ = val.(*Claims)
+		} else if claims, err := TokenClaims(RequestToken(c.Request), clientId); err == nil {
+			admin = claims
+		}
+		var req ImpersonationRequest
+		if err := c.BindJSON(&req); err != nil {
+			rec := services.Response("authz", http.StatusBadRequest, services.BindError, err)
+			c.JSON(http.StatusBadRequest, rec)
+			return
+		}
+		user := CustomClaims{User: req.User, Scope: req.Scope, Roles: req.Roles}
+		token, event, err := Impersonate(c.Request.Context(), admin, clientId, user, req.Reason, req.Expires)
+		if err != nil {
+			code := http.StatusInternalServerError
+			if errors.Is(err, ErrImpersonationDisabled) || errors.Is(err, ErrImpersonationForbidden) {
+				code = http.StatusForbidden
+			}
+			rec := services.Response("authz", code, services.TokenError, err)
+			c.JSON(code, rec)
+			return
+		}
+		c.JSON(http.StatusOK, Token{
+			AccessToken: token,
+			Expires:     event.Expires - time.Now().Unix(),
+			Scope:       event.Scope,
+			TokenType:   "bearer",
+		})
+	}
+}
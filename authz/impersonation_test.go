@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	"github.com/gin-gonic/gin"
+)
+
+// TestImpersonate
+func TestImpersonate(t *testing.T) {
+	config, auditor := srvConfig.Config, ImpersonationAuditor
+	t.Cleanup(func() { srvConfig.Config, ImpersonationAuditor = config, auditor })
+	srvConfig.Config = &srvConfig.SrvConfig{}
+	srvConfig.Config.Authz.ClientID = "secret"
+	var events []ImpersonationEvent
+	ImpersonationAuditor = func(ctx context.Context, event ImpersonationEvent) error {
+		events = append(events, event)
+		return nil
+	}
+	ctx := context.Background()
+	admin := &Claims{CustomClaims: CustomClaims{User: "admin", Roles: []string{"staff", "foxden-admin"}}}
+	user := CustomClaims{User: "alice", Scope: "read", Roles: []string{"id3a"}}
+
+	if _, _, err := Impersonate(ctx, admin, "secret", user, "debug", 60); !errors.Is(err, ErrImpersonationDisabled) {
+		t.Errorf("expected ErrImpersonationDisabled, got %v", err)
+	}
+	srvConfig.Config.Authz.Impersonation = srvConfig.Impersonation{AdminRoles: []string{"foxden-admin"}, MaxExpires: 5 * time.Minute}
+	token, event, err := Impersonate(ctx, admin, "secret", user, "ticket 42", 3600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := TokenClaims(token, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsImpersonated(claims) || claims.Actor.Subject != "admin" || claims.CustomClaims.User != "alice" || claims.Subject != "alice" {
+		t.Errorf("unexpected impersonation claims %+v", claims)
+	}
+	if ttl := time.Until(claims.ExpiresAt.Time); ttl > 300*time.Second {
+		t.Errorf("token lifetime %v is not limited", ttl)
+	}
+	if len(events) != 1 || events[0].TokenID != event.TokenID || event.TokenID != claims.ID || event.Reason != "ticket 42" {
+		t.Errorf("impersonation is not audited, events %+v", events)
+	}
+
+	for name, admin := range map[string]*Claims{
+		"non-admin":     {CustomClaims: CustomClaims{User: "bob", Roles: []string{"staff"}}},
+		"impersonation": claims,
+		"self":          {CustomClaims: CustomClaims{User: "alice", Roles: []string{"foxden-admin"}}},
+		"unknown":       nil,
+	} {
+		if _, _, err := Impersonate(ctx, admin, "secret", user, "debug", 60); !errors.Is(err, ErrImpersonationForbidden) {
+			t.Errorf("%s user impersonates, error %v", name, err)
+		}
+	}
+	if _, _, err := Impersonate(ctx, admin, "secret", user, "", 60); err == nil {
+		t.Error("impersonation without reason is allowed")
+	}
+	ImpersonationAuditor = func(ctx context.Context, event ImpersonationEvent) error {
+		return errors.New("audit store is not available")
+	}
+	if token, _, err := Impersonate(ctx, admin, "secret", user, "debug", 60); err == nil || token != "" {
+		t.Error("token is issued without audit record")
+	}
+	ImpersonationAuditor = nil
+	if _, _, err := Impersonate(ctx, admin, "secret", user, "debug", 60); err == nil {
+		t.Error("token is issued without audit logger")
+	}
+}
+
+// TestImpersonationHandler
+func TestImpersonationHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	config, auditor := srvConfig.Config, ImpersonationAuditor
+	t.Cleanup(func() { srvConfig.Config, ImpersonationAuditor = config, auditor })
+	srvConfig.Config = &srvConfig.SrvConfig{}
+	srvConfig.Config.Authz.ClientID = "secret"
+	srvConfig.Config.Authz.Impersonation.AdminRoles = []string{"foxden-admin"}
+	ImpersonationAuditor = func(ctx context.Context, event ImpersonationEvent) error { return nil }
+
+	router := gin.New()
+	router.POST("/impersonate", TokenMiddleware("secret", 0), ImpersonationHandler("secret"))
+	post := func(token string, req ImpersonationRequest) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(req)
+		r := httptest.NewRequest("POST", "/impersonate", bytes.NewReader(data))
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+	admin, _ := JWTAccessToken("secret", 60, CustomClaims{User: "admin", Roles: []string{"foxden-admin"}})
+	w := post(admin, ImpersonationRequest{User: "alice", Scope: "write", Reason: "debug"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("impersonation request is rejected, code %d %s", w.Code, w.Body.String())
+	}
+	var token Token
+	if err := json.Unmarshal(w.Body.Bytes(), &token); err != nil || token.Scope != "write" || token.Expires <= 0 {
+		t.Fatalf("unexpected token %+v, error %v", token, err)
+	}
+	if claims, err := TokenClaims(token.AccessToken, "secret"); err != nil || claims.Actor == nil || claims.Actor.Subject != "admin" {
+		t.Errorf("unexpected claims %+v, error %v", claims, err)
+	}
+	user, _ := JWTAccessToken("secret", 60, CustomClaims{User: "bob"})
+	if w := post(user, ImpersonationRequest{User: "alice", Reason: "debug"}); w.Code != http.StatusForbidden {
+		t.Errorf("impersonation request of non-admin is accepted, code %d", w.Code)
+	}
+}
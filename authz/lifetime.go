@@ -0,0 +1,176 @@
+package auth
+
+// lifetime module applies token lifetime policies of Authz configuration
+// per client (application) and scope when tokens are issued and refreshed
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	services "github.com/CHESSComputing/golib/services"
+	"github.com/gin-gonic/gin"
+	jwt "github.com/golang-jwt/jwt/v4"
+)
+
+// DefaultTokenExpires defines lifetime of tokens in seconds if neither
+// token policy nor Authz TokenExpires is configured
+const DefaultTokenExpires = 3600
+
+// errors of token refresh
+var (
+	ErrTokenIdle        = errors.New("token is idle for too long")
+	ErrTokenMaxLifetime = errors.New("token reached maximum lifetime")
+)
+
+// TokenLifetime represents lifetime policy of tokens
+type TokenLifetime struct {
+	Expires     time.Duration // lifetime of issued and refreshed tokens
+	MaxLifetime time.Duration // maximum lifetime since authentication, zero means no limit
+	IdleTimeout time.Duration // maximum age of refreshed token, zero means only valid tokens can be refreshed
+}
+
+// helper function to get specificity of policy matching client and scope,
+// negative value means that policy does not match
+func policyRank(p srvConfig.TokenPolicy, client, scope string) int {
+	if (p.Client != "" && p.Client != client) || (p.Scope != "" && p.Scope != scope) {
+		return -1
+	}
+	var rank int
+	if p.Client != "" {
+		rank += 2
+	}
+	if p.Scope != "" {
+		rank++
+	}
+	return rank
+}
+
+// TokenLifetimePolicy returns lifetime policy of tokens issued to given
+// client and scope. Fields of matching policies are applied from the least
+// to the most specific one: global, scope, client, client and scope.
+func TokenLifetimePolicy(client, scope string) TokenLifetime {
+	lifetime := TokenLifetime{Expires: DefaultTokenExpires * time.Second}
+	if srvConfig.Config == nil {
+		return lifetime
+	}
+	if srvConfig.Config.Authz.TokenExpires > 0 {
+		lifetime.Expires = srvConfig.Config.Authz.TokenExpires
+	}
+	var policies []srvConfig.TokenPolicy
+	for _, p := range srvConfig.Config.Authz.TokenPolicies {
+		if policyRank(p, client, scope) >= 0 {
+			policies = append(policies, p)
+		}
+	}
+	sort.SliceStable(policies, func(i, j int) bool {
+		return policyRank(policies[i], client, scope) < policyRank(policies[j], client, scope)
+	})
+	for _, p := range policies {
+		if p.Expires > 0 {
+			lifetime.Expires = p.Expires
+		}
+		if p.MaxLifetime > 0 {
+			lifetime.MaxLifetime = p.MaxLifetime
+		}
+		if p.IdleTimeout > 0 {
+			lifetime.IdleTimeout = p.IdleTimeout
+		}
+	}
+	return lifetime
+}
+
+// Lifetime returns lifetime of token with requested expiration in seconds,
+// zero value means policy expiration, lifetime is limited by maximum
+// lifetime of the policy
+func (l TokenLifetime) Lifetime(expires int64) time.Duration {
+	lifetime := time.Duration(expires) * time.Second
+	if expires == 0 {
+		lifetime = l.Expires
+	}
+	if l.MaxLifetime > 0 && lifetime > l.MaxLifetime {
+		lifetime = l.MaxLifetime
+	}
+	return lifetime
+}
+
+// RefreshAccessToken issues new token with claims of request token
+// according to lifetime policy of its client and scope. Expired tokens can
+// be refreshed within idle timeout of the policy, tokens are not refreshed
+// beyond maximum lifetime since authentication. Sender-constrained tokens
+// require proof of possession and impersonation tokens can not be
+// refreshed.
+func RefreshAccessToken(r *http.Request, secretKey string) (string, error) {
+	accessToken := RequestToken(r)
+	claims := &Claims{}
+	parser := jwt.Parser{SkipClaimsValidation: true}
+	if _, err := parser.ParseWithClaims(accessToken, claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(secretKey), nil
+	}); err != nil {
+		return "", fmt.Errorf("invalid token: %w", err)
+	}
+	err := claims.Valid()
+	expired := errors.Is(err, jwt.ErrTokenExpired)
+	if err != nil && !expired {
+		return "", fmt.Errorf("invalid token: %w", err)
+	}
+	if IsImpersonated(claims) {
+		return "", errors.New("impersonation token can not be refreshed")
+	}
+	if err := VerifyTokenBinding(r, accessToken, claims); err != nil {
+		return "", err
+	}
+	policy := TokenLifetimePolicy(claims.CustomClaims.Application, claims.CustomClaims.Scope)
+	now := time.Now()
+	if expired && (policy.IdleTimeout == 0 || claims.IssuedAt == nil || now.Sub(claims.IssuedAt.Time) > policy.IdleTimeout) {
+		return "", fmt.Errorf("%w: issued at %v", ErrTokenIdle, claims.IssuedAt)
+	}
+	authTime := now
+	if claims.AuthTime != nil {
+		authTime = claims.AuthTime.Time
+	} else if claims.IssuedAt != nil {
+		authTime = claims.IssuedAt.Time
+	}
+	if policy.MaxLifetime > 0 && now.Sub(authTime) >= policy.MaxLifetime {
+		return "", fmt.Errorf("%w: authenticated at %v", ErrTokenMaxLifetime, authTime)
+	}
+
+	refreshed := newClaims(0, claims.CustomClaims)
+	refreshed.Audience = claims.Audience
+	refreshed.AuthTime = jwt.NewNumericDate(authTime)
+	if policy.MaxLifetime > 0 && refreshed.ExpiresAt.After(authTime.Add(policy.MaxLifetime)) {
+		refreshed.ExpiresAt = jwt.NewNumericDate(authTime.Add(policy.MaxLifetime))
+	}
+	refreshed.Confirmation = claims.Confirmation
+	refreshed.AMR = claims.AMR
+	return signedToken(secretKey, refreshed)
+}
+
+// RefreshHandler provides gin handler which refreshes request token
+func RefreshHandler(clientId string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, err := RefreshAccessToken(c.Request, clientId)
+		if err != nil {
+			log.Printf("ERROR: unable to refresh token, error %v", err)
+			rec := services.Response("authz", http.StatusUnauthorized, services.TokenError, err)
+			c.JSON(http.StatusUnauthorized, rec)
+			return
+		}
+		claims, err := TokenClaims(token, clientId)
+		if err != nil {
+			rec := services.Response("authz", http.StatusInternalServerError, services.TokenError, err)
+			c.JSON(http.StatusInternalServerError, rec)
+			return
+		}
+		c.JSON(http.StatusOK, Token{
+			AccessToken: token,
+			Expires:     claims.ExpiresAt.Unix() - time.Now().Unix(),
+			Scope:       claims.CustomClaims.Scope,
+			TokenType:   "bearer",
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	"github.com/gin-gonic/gin"
+	jwt "github.com/golang-jwt/jwt/v4"
+)
+
+// helper function to set token lifetime policies
+func lifetimeTestConfig(t *testing.T, policies ...srvConfig.TokenPolicy) {
+	config := srvConfig.Config
+	t.Cleanup(func() { srvConfig.Config = config })
+	srvConfig.Config = &srvConfig.SrvConfig{}
+	srvConfig.Config.Authz.ClientID = "secret"
+	srvConfig.Config.Authz.TokenExpires = 2 * time.Hour
+	srvConfig.Config.Authz.TokenPolicies = policies
+}
+
+// TestTokenLifetimePolicy
+func TestTokenLifetimePolicy(t *testing.T) {
+	lifetimeTestConfig(t,
+		srvConfig.TokenPolicy{Client: "pipeline", Scope: "write", Expires: time.Minute},
+		srvConfig.TokenPolicy{Scope: "write", Expires: 10 * time.Minute, MaxLifetime: time.Hour},
+		srvConfig.TokenPolicy{Client: "pipeline", Expires: 24 * time.Hour, IdleTimeout: 5 * time.Minute},
+		srvConfig.TokenPolicy{MaxLifetime: 12 * time.Hour},
+	)
+	for _, tc := range []struct {
+		client, scope string
+		expect        TokenLifetime
+	}{
+		{"frontend", "read", TokenLifetime{Expires: 2 * time.Hour, MaxLifetime: 12 * time.Hour}},
+		{"frontend", "write", TokenLifetime{Expires: 10 * time.Minute, MaxLifetime: time.Hour}},
+		{"pipeline", "read", TokenLifetime{Expires: 24 * time.Hour, MaxLifetime: 12 * time.Hour, IdleTimeout: 5 * time.Minute}},
+		{"pipeline", "write", TokenLifetime{Expires: time.Minute, MaxLifetime: time.Hour, IdleTimeout: 5 * time.Minute}},
+	} {
+		if policy := TokenLifetimePolicy(tc.client, tc.scope); policy != tc.expect {
+			t.Errorf("client %s scope %s: expect policy %+v, got %+v", tc.client, tc.scope, tc.expect, policy)
+		}
+	}
+
+	// issued tokens are limited by policy
+	token, _ := JWTAccessToken("secret", 0, CustomClaims{User: "alice", Scope: "write", Application: "frontend"})
+	claims, err := TokenClaims(token, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl := claims.ExpiresAt.Sub(claims.IssuedAt.Time); ttl != 10*time.Minute {
+		t.Errorf("token of policy lifetime expires in %v", ttl)
+	}
+	token, _ = JWTAccessToken("secret", 86400, CustomClaims{User: "alice", Scope: "write", Application: "frontend"})
+	claims, _ = TokenClaims(token, "secret")
+	if ttl := claims.ExpiresAt.Sub(claims.IssuedAt.Time); ttl != time.Hour {
+		t.Errorf("token lifetime %v is not limited by maximum lifetime", ttl)
+	}
+	if claims.AuthTime == nil {
+		t.Error("authentication time is not set")
+	}
+}
+
+// TestRefreshAccessToken
+func TestRefreshAccessToken(t *testing.T) {
+	lifetimeTestConfig(t,
+		srvConfig.TokenPolicy{Scope: "read", Expires: 10 * time.Minute, MaxLifetime: time.Hour, IdleTimeout: 20 * time.Minute},
+	)
+	refresh := func(claims Claims) (*Claims, error) {
+		token, err := signedToken("secret", claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := httptest.NewRequest("POST", "/oauth/refresh", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		token, err = RefreshAccessToken(r, "secret")
+		if err != nil {
+			return nil, err
+		}
+		return TokenClaims(token, "secret")
+	}
+	// helper function to create claims issued and authenticated in the past
+	issued := func(iat, authTime time.Duration, expires int64) Claims {
+		claims := newClaims(expires, CustomClaims{User: "alice", Scope: "read"})
+		now := time.Now()
+		claims.IssuedAt = jwt.NewNumericDate(now.Add(-iat))
+		claims.NotBefore = claims.IssuedAt
+		claims.ExpiresAt = jwt.NewNumericDate(now.Add(-iat).Add(time.Duration(expires) * time.Second))
+		claims.AuthTime = jwt.NewNumericDate(now.Add(-authTime))
+		return claims
+	}
+
+	claims, err := refresh(issued(5*time.Minute, 30*time.Minute, 600))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl := time.Until(claims.ExpiresAt.Time); ttl < 9*time.Minute || ttl > 10*time.Minute {
+		t.Errorf("refreshed token expires in %v", ttl)
+	}
+	if since := time.Since(claims.AuthTime.Time); since < 29*time.Minute {
+		t.Errorf("authentication time is not kept, %v", claims.AuthTime.Time)
+	}
+	// refresh close to maximum lifetime
+	claims, err = refresh(issued(5*time.Minute, 55*time.Minute, 600))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl := time.Until(claims.ExpiresAt.Time); ttl > 5*time.Minute {
+		t.Errorf("refreshed token exceeds maximum lifetime, expires in %v", ttl)
+	}
+	// expired token within idle timeout
+	if _, err := refresh(issued(15*time.Minute, 15*time.Minute, 600)); err != nil {
+		t.Errorf("expired token within idle timeout is not refreshed, error %v", err)
+	}
+	if _, err := refresh(issued(25*time.Minute, 25*time.Minute, 600)); !errors.Is(err, ErrTokenIdle) {
+		t.Errorf("expected ErrTokenIdle, got %v", err)
+	}
+	if _, err := refresh(issued(5*time.Minute, 2*time.Hour, 600)); !errors.Is(err, ErrTokenMaxLifetime) {
+		t.Errorf("expected ErrTokenMaxLifetime, got %v", err)
+	}
+
+	// tokens without idle timeout can be refreshed only when valid
+	write := newClaims(600, CustomClaims{User: "alice", Scope: "write"})
+	if _, err := refresh(write); err != nil {
+		t.Errorf("valid token is not refreshed, error %v", err)
+	}
+	write.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Hour))
+	if _, err := refresh(write); !errors.Is(err, ErrTokenIdle) {
+		t.Errorf("expected ErrTokenIdle, got %v", err)
+	}
+
+	// invalid signature
+	r := httptest.NewRequest("POST", "/oauth/refresh", nil)
+	token, _ := JWTAccessToken("other", 60, CustomClaims{User: "alice", Scope: "read"})
+	r.Header.Set("Authorization", "Bearer "+token)
+	if _, err := RefreshAccessToken(r, "secret"); err == nil {
+		t.Error("token with invalid signature is refreshed")
+	}
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = r
+	RefreshHandler("secret")(c)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("unexpected code %d", w.Code)
+	}
+}
@@ -0,0 +1,227 @@
+package login
+
+// keyring module stores credentials of command line users in OS keychain,
+// i.e. macOS keychain via security tool, Secret Service (GNOME keyring,
+// KWallet) via secret-tool on Linux and Windows credential manager, or in
+// user file if OS keychain is not available.
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// errors of keyring module
+var (
+	ErrNotFound  = errors.New("credentials are not found in keyring")
+	ErrNoKeyring = errors.New("OS keychain is not available")
+)
+
+// Keyring represents store of secrets of service accounts
+type Keyring interface {
+	Get(service, account string) (string, error) // returns ErrNotFound if secret does not exist
+	Set(service, account, secret string) error
+	Delete(service, account string) error // returns ErrNotFound if secret does not exist
+}
+
+// SystemKeyring returns keyring of OS keychain or ErrNoKeyring if it is
+// not available, e.g. secret-tool is not installed
+func SystemKeyring() (Keyring, error) {
+	switch runtime.GOOS {
+	case "windows":
+		return osKeyring()
+	case "darwin":
+		if _, err := exec.LookPath("security"); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrNoKeyring, err)
+		}
+		return macKeyring{}, nil
+	default:
+		if _, err := exec.LookPath("secret-tool"); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrNoKeyring, err)
+		}
+		return secretToolKeyring{}, nil
+	}
+}
+
+// helper function to run keyring tool, exit code of the tool is returned
+// along with its error
+func runTool(stdin string, name string, args ...string) (string, int, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return stdout.String(), exitErr.ExitCode(), fmt.Errorf("%s failed: %s", name, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), 0, err
+}
+
+// macKeyring stores secrets as generic passwords of macOS keychain
+type macKeyring struct{}
+
+// exit code of security tool if item is not found
+const macNotFound = 44
+
+// Get implements Keyring interface
+func (macKeyring) Get(service, account string) (string, error) {
+	out, code, err := runTool("", "security", "find-generic-password", "-s", service, "-a", account, "-w")
+	if code == macNotFound {
+		return "", ErrNotFound
+	}
+	return strings.TrimSuffix(out, "\n"), err
+}
+
+// Set implements Keyring interface, secret is passed via standard input of
+// interactive mode to keep it out of process arguments
+func (macKeyring) Set(service, account, secret string) error {
+	cmd := fmt.Sprintf("add-generic-password -U -s %q -a %q -X %s\n", service, account, hex.EncodeToString([]byte(secret)))
+	_, _, err := runTool(cmd, "security", "-i")
+	return err
+}
+
+// Delete implements Keyring interface
+func (macKeyring) Delete(service, account string) error {
+	_, code, err := runTool("", "security", "delete-generic-password", "-s", service, "-a", account)
+	if code == macNotFound {
+		return ErrNotFound
+	}
+	return err
+}
+
+// secretToolKeyring stores secrets in Secret Service via secret-tool
+type secretToolKeyring struct{}
+
+// Get implements Keyring interface
+func (secretToolKeyring) Get(service, account string) (string, error) {
+	out, code, err := runTool("", "secret-tool", "lookup", "service", service, "account", account)
+	if code == 1 && out == "" {
+		return "", ErrNotFound
+	}
+	return out, err
+}
+
+// Set implements Keyring interface, secret is passed via standard input
+func (secretToolKeyring) Set(service, account, secret string) error {
+	label := fmt.Sprintf("--label=%s credentials of %s", service, account)
+	_, _, err := runTool(secret, "secret-tool", "store", label, "service", service, "account", account)
+	return err
+}
+
+// Delete implements Keyring interface
+func (k secretToolKeyring) Delete(service, account string) error {
+	if _, err := k.Get(service, account); err != nil {
+		return err
+	}
+	_, _, err := runTool("", "secret-tool", "clear", "service", service, "account", account)
+	return err
+}
+
+// FileKeyring stores secrets in JSON file readable only by its owner, it
+// is intended for hosts without OS keychain, e.g. headless servers
+type FileKeyring struct {
+	File string // keyring file
+	mu   sync.Mutex
+}
+
+// NewFileKeyring returns keyring of given file or of credentials file in
+// user configuration directory if file is empty
+func NewFileKeyring(fname string) (*FileKeyring, error) {
+	if fname == "" {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return nil, err
+		}
+		fname = filepath.Join(dir, "foxden", "credentials.json")
+	}
+	return &FileKeyring{File: fname}, nil
+}
+
+// helper function to read secrets of keyring file
+func (k *FileKeyring) read() (map[string]string, error) {
+	secrets := make(map[string]string)
+	data, err := os.ReadFile(k.File)
+	if errors.Is(err, fs.ErrNotExist) {
+		return secrets, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &secrets); err != nil {
+		return nil, fmt.Errorf("invalid keyring file %s, error %v", k.File, err)
+	}
+	return secrets, nil
+}
+
+// helper function to write secrets into keyring file
+func (k *FileKeyring) write(secrets map[string]string) error {
+	data, err := json.MarshalIndent(secrets, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(k.File), 0700); err != nil {
+		return err
+	}
+	tmp := k.File + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, k.File)
+}
+
+// helper function to provide key of service account
+func fileKey(service, account string) string {
+	return service + "|" + account
+}
+
+// Get implements Keyring interface
+func (k *FileKeyring) Get(service, account string) (string, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	secrets, err := k.read()
+	if err != nil {
+		return "", err
+	}
+	secret, ok := secrets[fileKey(service, account)]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return secret, nil
+}
+
+// Set implements Keyring interface
+func (k *FileKeyring) Set(service, account, secret string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	secrets, err := k.read()
+	if err != nil {
+		return err
+	}
+	secrets[fileKey(service, account)] = secret
+	return k.write(secrets)
+}
+
+// Delete implements Keyring interface
+func (k *FileKeyring) Delete(service, account string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	secrets, err := k.read()
+	if err != nil {
+		return err
+	}
+	key := fileKey(service, account)
+	if _, ok := secrets[key]; !ok {
+		return ErrNotFound
+	}
+	delete(secrets, key)
+	return k.write(secrets)
+}
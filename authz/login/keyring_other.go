@@ -0,0 +1,9 @@
+//go:build !windows
+
+package login
+
+// helper function to provide keyring of Windows credential manager, it is
+// not available on other systems
+func osKeyring() (Keyring, error) {
+	return nil, ErrNoKeyring
+}
@@ -0,0 +1,117 @@
+package login
+
+// keyring module of Windows credential manager, secrets are kept as
+// generic credentials with service:account target names
+
+import (
+	"errors"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	advapi32       = syscall.NewLazyDLL("advapi32.dll")
+	procCredRead   = advapi32.NewProc("CredReadW")
+	procCredWrite  = advapi32.NewProc("CredWriteW")
+	procCredDelete = advapi32.NewProc("CredDeleteW")
+	procCredFree   = advapi32.NewProc("CredFree")
+)
+
+// constants of credential manager API
+const (
+	credTypeGeneric         = 1
+	credPersistLocalMachine = 2
+	errorNotFound           = syscall.Errno(1168)
+)
+
+// credential represents CREDENTIALW structure
+type credential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        syscall.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+// winKeyring stores secrets in Windows credential manager
+type winKeyring struct{}
+
+// helper function to provide keyring of Windows credential manager
+func osKeyring() (Keyring, error) {
+	if err := advapi32.Load(); err != nil {
+		return nil, errors.Join(ErrNoKeyring, err)
+	}
+	return winKeyring{}, nil
+}
+
+// helper function to provide target name of service account
+func winTarget(service, account string) (*uint16, error) {
+	return syscall.UTF16PtrFromString(service + ":" + account)
+}
+
+// Get implements Keyring interface
+func (winKeyring) Get(service, account string) (string, error) {
+	target, err := winTarget(service, account)
+	if err != nil {
+		return "", err
+	}
+	var cred *credential
+	r, _, err := procCredRead.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0, uintptr(unsafe.Pointer(&cred)))
+	if r == 0 {
+		if errors.Is(err, errorNotFound) {
+			return "", ErrNotFound
+		}
+		return "", err
+	}
+	defer procCredFree.Call(uintptr(unsafe.Pointer(cred)))
+	return string(unsafe.Slice(cred.CredentialBlob, cred.CredentialBlobSize)), nil
+}
+
+// Set implements Keyring interface
+func (winKeyring) Set(service, account, secret string) error {
+	target, err := winTarget(service, account)
+	if err != nil {
+		return err
+	}
+	user, err := syscall.UTF16PtrFromString(account)
+	if err != nil {
+		return err
+	}
+	blob := []byte(secret)
+	cred := credential{
+		Type:               credTypeGeneric,
+		TargetName:         target,
+		CredentialBlobSize: uint32(len(blob)),
+		Persist:            credPersistLocalMachine,
+		UserName:           user,
+	}
+	if len(blob) > 0 {
+		cred.CredentialBlob = &blob[0]
+	}
+	if r, _, err := procCredWrite.Call(uintptr(unsafe.Pointer(&cred)), 0); r == 0 {
+		return err
+	}
+	return nil
+}
+
+// Delete implements Keyring interface
+func (winKeyring) Delete(service, account string) error {
+	target, err := winTarget(service, account)
+	if err != nil {
+		return err
+	}
+	if r, _, err := procCredDelete.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0); r == 0 {
+		if errors.Is(err, errorNotFound) {
+			return ErrNotFound
+		}
+		return err
+	}
+	return nil
+}
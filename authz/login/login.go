@@ -0,0 +1,380 @@
+package login
+
+// login module obtains access tokens of command line users from FOXDEN
+// Authz service via OAuth device authorization grant (RFC 8628) or
+// Kerberos ticket, stores them in keyring and refreshes them before they
+// expire, so CLI tools and typed clients do not require tokens in
+// environment variables.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	"gopkg.in/jcmturner/gokrb5.v7/credentials"
+)
+
+// login methods
+const (
+	MethodDevice   = "device"
+	MethodKerberos = "kerberos"
+)
+
+// DeviceGrantType defines grant type of device access token requests
+const DeviceGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+// Service defines keyring service of stored credentials
+var Service = "foxden"
+
+// RefreshMargin defines time before token expiration when it is refreshed
+var RefreshMargin = 5 * time.Minute
+
+// errors of login module
+var (
+	ErrLoginRequired = errors.New("login is required, run srvctl login")
+	ErrAccessDenied  = errors.New("authorization request is denied")
+	ErrDeviceExpired = errors.New("device code is expired, login again")
+)
+
+// Credentials represents stored credentials of the user
+type Credentials struct {
+	AccessToken string    `json:"access_token"`
+	Scope       string    `json:"scope,omitempty"`
+	Expires     time.Time `json:"expires"`
+	AuthzURL    string    `json:"authz_url"`
+	Method      string    `json:"method"`         // login method: device or kerberos
+	User        string    `json:"user,omitempty"` // user name of Kerberos login
+}
+
+// Expired reports whether token expires within given margin
+func (c Credentials) Expired(margin time.Duration) bool {
+	return !c.Expires.IsZero() && time.Now().Add(margin).After(c.Expires)
+}
+
+// helper function to create credentials of token response
+func newCredentials(token authz.Token, authzURL, method string) (Credentials, error) {
+	if token.AccessToken == "" {
+		return Credentials{}, errors.New("Authz response does not contain access token")
+	}
+	creds := Credentials{AccessToken: token.AccessToken, Scope: token.Scope, AuthzURL: authzURL, Method: method}
+	if token.Expires > 0 {
+		creds.Expires = time.Now().Add(time.Duration(token.Expires) * time.Second)
+	}
+	return creds, nil
+}
+
+// oauthError represents error response of OAuth endpoints
+type oauthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+// Error implements error interface
+func (e *oauthError) Error() string {
+	if e.Description != "" {
+		return e.Code + ": " + e.Description
+	}
+	return e.Code
+}
+
+// helper function to post request to Authz service and decode its JSON
+// response, error responses are returned as errors
+func post(ctx context.Context, client *http.Client, rurl, ctype string, body io.Reader, token string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", rurl, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", ctype)
+	req.Header.Set("Accept", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var oerr oauthError
+		if json.Unmarshal(data, &oerr) == nil && oerr.Code != "" {
+			return &oerr
+		}
+		return fmt.Errorf("%s responded with %s: %s", rurl, resp.Status, strings.TrimSpace(string(data)))
+	}
+	return json.Unmarshal(data, out)
+}
+
+// DeviceCode represents device authorization response
+type DeviceCode struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete,omitempty"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval,omitempty"`
+}
+
+// DeviceOptions represents options of device login
+type DeviceOptions struct {
+	AuthzURL string              // Authz service URL
+	ClientID string              // public client id of the CLI
+	Scope    string              // requested token scope
+	Client   *http.Client        // HTTP client, default http.DefaultClient
+	Prompt   func(DeviceCode)    // shows verification URI and user code to the user
+	sleep    func(time.Duration) // waits between token requests, used in tests
+}
+
+// DeviceLogin obtains token via device authorization grant: user code is
+// shown by prompt function, then token endpoint is polled until the user
+// approves or denies the request in browser or the device code expires
+func DeviceLogin(ctx context.Context, opts DeviceOptions) (Credentials, error) {
+	authzURL := strings.TrimSuffix(opts.AuthzURL, "/")
+	if authzURL == "" {
+		return Credentials{}, errors.New("Authz URL is not provided")
+	}
+	form := url.Values{"client_id": {opts.ClientID}, "scope": {opts.Scope}}
+	var code DeviceCode
+	err := post(ctx, opts.Client, authzURL+"/oauth/device/code", "application/x-www-form-urlencoded", strings.NewReader(form.Encode()), "", &code)
+	if err != nil {
+		log.Printf("ERROR: unable to obtain device code, error %v", err)
+		return Credentials{}, err
+	}
+	if code.DeviceCode == "" || code.UserCode == "" {
+		return Credentials{}, errors.New("Authz response does not contain device code")
+	}
+	if opts.Prompt != nil {
+		opts.Prompt(code)
+	}
+	interval := time.Duration(code.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	sleep := opts.sleep
+	if sleep == nil {
+		sleep = func(d time.Duration) {
+			select {
+			case <-ctx.Done():
+			case <-time.After(d):
+			}
+		}
+	}
+	deadline := time.Now().Add(time.Duration(code.ExpiresIn) * time.Second)
+	form = url.Values{"grant_type": {DeviceGrantType}, "device_code": {code.DeviceCode}, "client_id": {opts.ClientID}}
+	for {
+		sleep(interval)
+		if err := ctx.Err(); err != nil {
+			return Credentials{}, err
+		}
+		var token authz.Token
+		err := post(ctx, opts.Client, authzURL+"/oauth/token", "application/x-www-form-urlencoded", strings.NewReader(form.Encode()), "", &token)
+		if err == nil {
+			return newCredentials(token, authzURL, MethodDevice)
+		}
+		var oerr *oauthError
+		if !errors.As(err, &oerr) {
+			return Credentials{}, err
+		}
+		switch oerr.Code {
+		case "authorization_pending":
+		case "slow_down":
+			interval += 5 * time.Second
+		case "access_denied":
+			return Credentials{}, ErrAccessDenied
+		case "expired_token":
+			return Credentials{}, ErrDeviceExpired
+		default:
+			return Credentials{}, err
+		}
+		if code.ExpiresIn > 0 && time.Now().After(deadline) {
+			return Credentials{}, ErrDeviceExpired
+		}
+	}
+}
+
+// DefaultCCache returns location of Kerberos credentials cache, i.e.
+// KRB5CCNAME file or default cache file of the user
+func DefaultCCache() string {
+	if name := os.Getenv("KRB5CCNAME"); name != "" {
+		return strings.TrimPrefix(name, "FILE:")
+	}
+	return fmt.Sprintf("/tmp/krb5cc_%d", os.Getuid())
+}
+
+// KerberosLogin obtains token from Authz service in exchange for Kerberos
+// ticket of given credentials cache, e.g. obtained by kinit
+func KerberosLogin(ctx context.Context, authzURL, scope, ccache string, client *http.Client) (Credentials, error) {
+	authzURL = strings.TrimSuffix(authzURL, "/")
+	if authzURL == "" {
+		return Credentials{}, errors.New("Authz URL is not provided")
+	}
+	if ccache == "" {
+		ccache = DefaultCCache()
+	}
+	ticket, err := os.ReadFile(ccache)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("unable to read Kerberos credentials cache, run kinit: %w", err)
+	}
+	var cache credentials.CCache
+	if err := cache.Unmarshal(ticket); err != nil {
+		return Credentials{}, fmt.Errorf("invalid Kerberos credentials cache %s, error %v", ccache, err)
+	}
+	valid := false
+	for _, entry := range cache.GetEntries() {
+		valid = valid || entry.EndTime.After(time.Now())
+	}
+	if !valid {
+		return Credentials{}, fmt.Errorf("Kerberos tickets of %s are expired, run kinit", ccache)
+	}
+	user := cache.GetClientPrincipalName().PrincipalNameString()
+	data, err := json.Marshal(authz.Kerberos{User: user, Scope: scope, Ticket: ticket})
+	if err != nil {
+		return Credentials{}, err
+	}
+	var token authz.Token
+	if err := post(ctx, client, authzURL+"/oauth/authorize", "application/json", bytes.NewReader(data), "", &token); err != nil {
+		log.Printf("ERROR: unable to obtain token of Kerberos user %s, error %v", user, err)
+		return Credentials{}, err
+	}
+	creds, err := newCredentials(token, authzURL, MethodKerberos)
+	creds.User = user
+	return creds, err
+}
+
+// Refresh obtains new token for given credentials from Authz refresh
+// endpoint, see authz.RefreshHandler
+func Refresh(ctx context.Context, creds Credentials, client *http.Client) (Credentials, error) {
+	var token authz.Token
+	if err := post(ctx, client, creds.AuthzURL+"/oauth/refresh", "application/json", nil, creds.AccessToken, &token); err != nil {
+		return creds, err
+	}
+	refreshed, err := newCredentials(token, creds.AuthzURL, creds.Method)
+	if err != nil {
+		return creds, err
+	}
+	refreshed.User = creds.User
+	if refreshed.Scope == "" {
+		refreshed.Scope = creds.Scope
+	}
+	return refreshed, nil
+}
+
+// Session provides tokens of credentials stored in keyring for Authz
+// service and refreshes them before they expire, it is safe for
+// concurrent use
+type Session struct {
+	Keyring  Keyring      // keyring of credentials
+	AuthzURL string       // Authz service URL, keyring account of credentials
+	Client   *http.Client // HTTP client of refresh requests
+
+	mu    sync.Mutex
+	creds *Credentials
+}
+
+// NewSession returns session of credentials of Authz service
+func NewSession(keyring Keyring, authzURL string) *Session {
+	return &Session{Keyring: keyring, AuthzURL: strings.TrimSuffix(authzURL, "/")}
+}
+
+// Save stores credentials in keyring
+func (s *Session) Save(creds Credentials) error {
+	data, err := json.Marshal(creds)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.Keyring.Set(Service, s.AuthzURL, string(data)); err != nil {
+		log.Printf("ERROR: unable to store credentials in keyring, error %v", err)
+		return err
+	}
+	s.creds = &creds
+	return nil
+}
+
+// Credentials returns stored credentials, ErrLoginRequired is returned if
+// they are not found
+func (s *Session) Credentials() (Credentials, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.load()
+}
+
+// helper function to load credentials from keyring
+func (s *Session) load() (Credentials, error) {
+	if s.creds != nil {
+		return *s.creds, nil
+	}
+	data, err := s.Keyring.Get(Service, s.AuthzURL)
+	if errors.Is(err, ErrNotFound) {
+		return Credentials{}, ErrLoginRequired
+	} else if err != nil {
+		return Credentials{}, err
+	}
+	var creds Credentials
+	if err := json.Unmarshal([]byte(data), &creds); err != nil {
+		return Credentials{}, fmt.Errorf("invalid stored credentials, error %v", err)
+	}
+	s.creds = &creds
+	return creds, nil
+}
+
+// Token returns access token of stored credentials, token expiring within
+// RefreshMargin is refreshed and stored. ErrLoginRequired is returned if
+// token is expired and can not be refreshed.
+func (s *Session) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	creds, err := s.load()
+	if err != nil {
+		return "", err
+	}
+	if !creds.Expired(RefreshMargin) {
+		return creds.AccessToken, nil
+	}
+	refreshed, err := Refresh(ctx, creds, s.Client)
+	if err != nil {
+		if !creds.Expired(0) {
+			log.Printf("WARNING: unable to refresh token, error %v", err)
+			return creds.AccessToken, nil
+		}
+		return "", fmt.Errorf("%w: %v", ErrLoginRequired, err)
+	}
+	data, err := json.Marshal(refreshed)
+	if err != nil {
+		return "", err
+	}
+	if err := s.Keyring.Set(Service, s.AuthzURL, string(data)); err != nil {
+		log.Printf("WARNING: unable to store refreshed token, error %v", err)
+	}
+	s.creds = &refreshed
+	return refreshed.AccessToken, nil
+}
+
+// Logout removes stored credentials
+func (s *Session) Logout() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.creds = nil
+	if err := s.Keyring.Delete(Service, s.AuthzURL); err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	return nil
+}
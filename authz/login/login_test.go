@@ -0,0 +1,167 @@
+package login
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	authz "github.com/CHESSComputing/golib/authz"
+)
+
+// helper function to create test Authz server with device and refresh
+// endpoints, device request is approved after given number of polls
+func testAuthz(t *testing.T, pending int32, refreshed *int32) *httptest.Server {
+	var polls int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth/device/code", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("client_id") != "foxden-cli" || r.FormValue("scope") != "read" {
+			t.Errorf("wrong device code request %v", r.Form)
+		}
+		json.NewEncoder(w).Encode(DeviceCode{DeviceCode: "dev", UserCode: "ABCD-EFGH", VerificationURI: "https://authz/device", ExpiresIn: 600, Interval: 1})
+	})
+	mux.HandleFunc("/oauth/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("grant_type") != DeviceGrantType || r.FormValue("device_code") != "dev" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(oauthError{Code: "invalid_grant"})
+			return
+		}
+		if atomic.AddInt32(&polls, 1) <= pending {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(oauthError{Code: "authorization_pending"})
+			return
+		}
+		json.NewEncoder(w).Encode(authz.Token{AccessToken: "token-1", Expires: 60, Scope: "read", TokenType: "bearer"})
+	})
+	mux.HandleFunc("/oauth/refresh", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token-1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		atomic.AddInt32(refreshed, 1)
+		json.NewEncoder(w).Encode(authz.Token{AccessToken: "token-2", Expires: 3600, TokenType: "bearer"})
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// TestDeviceLogin
+func TestDeviceLogin(t *testing.T) {
+	var refreshed int32
+	srv := testAuthz(t, 2, &refreshed)
+	var prompt DeviceCode
+	var waits []time.Duration
+	opts := DeviceOptions{
+		AuthzURL: srv.URL + "/",
+		ClientID: "foxden-cli",
+		Scope:    "read",
+		Prompt:   func(code DeviceCode) { prompt = code },
+		sleep:    func(d time.Duration) { waits = append(waits, d) },
+	}
+	creds, err := DeviceLogin(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prompt.UserCode != "ABCD-EFGH" || len(waits) != 3 || waits[0] != time.Second {
+		t.Errorf("wrong prompt %+v or polls %v", prompt, waits)
+	}
+	if creds.AccessToken != "token-1" || creds.AuthzURL != srv.URL || creds.Method != MethodDevice || creds.Scope != "read" {
+		t.Errorf("wrong credentials %+v", creds)
+	}
+	if creds.Expired(0) || !creds.Expired(RefreshMargin) {
+		t.Errorf("wrong expiration %v", creds.Expires)
+	}
+}
+
+// TestSession
+func TestSession(t *testing.T) {
+	var refreshed int32
+	srv := testAuthz(t, 0, &refreshed)
+	keyring := &FileKeyring{File: filepath.Join(t.TempDir(), "credentials.json")}
+	session := NewSession(keyring, srv.URL)
+	ctx := context.Background()
+	if _, err := session.Token(ctx); !errors.Is(err, ErrLoginRequired) {
+		t.Errorf("token without login, error %v", err)
+	}
+	creds, err := DeviceLogin(ctx, DeviceOptions{AuthzURL: srv.URL, ClientID: "foxden-cli", Scope: "read", sleep: func(time.Duration) {}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := session.Save(creds); err != nil {
+		t.Fatal(err)
+	}
+
+	// token expiring within refresh margin is refreshed and stored
+	session = NewSession(keyring, srv.URL)
+	for i := 0; i < 2; i++ {
+		token, err := session.Token(ctx)
+		if err != nil || token != "token-2" {
+			t.Errorf("wrong token %s, error %v", token, err)
+		}
+	}
+	if refreshed != 1 {
+		t.Errorf("token is refreshed %d times", refreshed)
+	}
+	stored, err := NewSession(keyring, srv.URL).Credentials()
+	if err != nil || stored.AccessToken != "token-2" || stored.Scope != "read" || stored.Expired(RefreshMargin) {
+		t.Errorf("wrong stored credentials %+v, error %v", stored, err)
+	}
+
+	// expired token which can not be refreshed requires login
+	stored.Expires = time.Now().Add(-time.Minute)
+	session.Save(stored)
+	if _, err := session.Token(ctx); !errors.Is(err, ErrLoginRequired) {
+		t.Errorf("expired token is provided, error %v", err)
+	}
+	if err := session.Logout(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := session.Credentials(); !errors.Is(err, ErrLoginRequired) {
+		t.Errorf("credentials are kept after logout, error %v", err)
+	}
+}
+
+// TestDeviceLoginDenied
+func TestDeviceLoginDenied(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/oauth/device/code" {
+			json.NewEncoder(w).Encode(DeviceCode{DeviceCode: "dev", UserCode: "ABCD"})
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(oauthError{Code: "access_denied"})
+	}))
+	defer srv.Close()
+	_, err := DeviceLogin(context.Background(), DeviceOptions{AuthzURL: srv.URL, sleep: func(time.Duration) {}})
+	if !errors.Is(err, ErrAccessDenied) {
+		t.Errorf("wrong error %v", err)
+	}
+}
+
+// TestFileKeyring
+func TestFileKeyring(t *testing.T) {
+	keyring := &FileKeyring{File: filepath.Join(t.TempDir(), "foxden", "credentials.json")}
+	if _, err := keyring.Get("foxden", "a"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("wrong error %v", err)
+	}
+	keyring.Set("foxden", "a", "secret-a")
+	keyring.Set("foxden", "b", "secret-b")
+	if secret, err := keyring.Get("foxden", "a"); err != nil || secret != "secret-a" {
+		t.Errorf("wrong secret %s, error %v", secret, err)
+	}
+	if err := keyring.Delete("foxden", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := keyring.Delete("foxden", "a"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("wrong error %v", err)
+	}
+	if secret, err := keyring.Get("foxden", "b"); err != nil || secret != "secret-b" {
+		t.Errorf("wrong secret %s, error %v", secret, err)
+	}
+}
@@ -15,12 +15,19 @@ import (
 // _token is used across all authorized APIs
 var _token *Token
 
+// TokenMiddleware provides token validation, claims of the token are stored
+// in gin context under "claims" key and user name under "user" key.
 // gin cookies
 // https://gin-gonic.com/docs/examples/cookie/
 // more advanced use-case:
 // https://stackoverflow.com/questions/66289603/use-existing-session-cookie-in-gin-router
 func TokenMiddleware(clientId string, verbose int) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		// requests without credentials may be allowed as anonymous
+		if anonymousAccess(c, "read", verbose) {
+			c.Next()
+			return
+		}
 		// check if user request has valid token
 		tokenStr := RequestToken(c.Request)
 		token := &Token{AccessToken: tokenStr}
@@ -31,16 +38,37 @@ func TokenMiddleware(clientId string, verbose int) gin.HandlerFunc {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, rec)
 			return
 		}
+		claims, err := TokenClaims(tokenStr, clientId)
+		if err == nil {
+			err = VerifyTokenBinding(c.Request, tokenStr, claims)
+		}
+		if err != nil {
+			log.Printf("ERROR: TokenMiddleware: %v", err)
+			rec := services.Response("authz", http.StatusUnauthorized, services.TokenError, err)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, rec)
+			return
+		}
 		if verbose > 0 {
 			log.Println("INFO: token is validated")
 		}
+		c.Set("claims", claims)
+		c.Set("user", claims.CustomClaims.User)
 		c.Next()
 	}
 }
 
-// ScopeTokenMiddleware provides token validation with specific scope
+// ScopeTokenMiddleware provides token validation with specific scope,
+// requests without token are allowed if anonymous access is enabled and
+// scope is granted to anonymous user. Claims of the token are stored in gin
+// context under "claims" key and user name under "user" key.
 func ScopeTokenMiddleware(scope, clientId string, verbose int) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		// requests without credentials may be allowed as anonymous if
+		// scope is granted to anonymous user
+		if anonymousAccess(c, scope, verbose) {
+			c.Next()
+			return
+		}
 		// check if user request has valid token
 		tokenStr := RequestToken(c.Request)
 		token := &Token{AccessToken: tokenStr}
@@ -64,6 +92,12 @@ func ScopeTokenMiddleware(scope, clientId string, verbose int) gin.HandlerFunc {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, rec)
 			return
 		}
+		if err := VerifyTokenBinding(c.Request, tokenStr, claims); err != nil {
+			log.Printf("ERROR: ScopeTokenMiddleware: %v", err)
+			rec := services.Response("authz", http.StatusUnauthorized, services.TokenError, err)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, rec)
+			return
+		}
 		if claims.CustomClaims.Scope != scope {
 			msg := fmt.Sprintf("ScopeTokenMiddleware: token scope '%s' does not match with scope '%s'", token.Scope, scope)
 			log.Println("ERROR:", msg)
@@ -72,6 +106,8 @@ func ScopeTokenMiddleware(scope, clientId string, verbose int) gin.HandlerFunc {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, rec)
 			return
 		}
+		c.Set("claims", claims)
+		c.Set("user", claims.CustomClaims.User)
 		c.Next()
 	}
 }
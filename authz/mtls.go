@@ -0,0 +1,248 @@
+package auth
+
+// mtls module provides service-to-service authentication via mutual TLS.
+// Services present client certificates whose SPIFFE IDs (URI SANs) or DNS
+// names (DNS SANs) are mapped to service roles and scope by MutualTLS
+// configuration, so inter-service calls do not need tokens signed with
+// shared secret.
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	services "github.com/CHESSComputing/golib/services"
+	"github.com/gin-gonic/gin"
+)
+
+// ServiceKind defines kind of claims of requests authenticated by client
+// certificate of peer service
+const ServiceKind = "service"
+
+// errors of mtls module
+var (
+	ErrNoPeerCertificate = errors.New("request has no verified client certificate")
+	ErrUnknownService    = errors.New("client certificate does not match any service identity")
+	ErrInvalidSPIFFEID   = errors.New("invalid SPIFFE ID")
+)
+
+// SPIFFEID represents SPIFFE ID, i.e. spiffe://<trust domain>/<path>
+type SPIFFEID struct {
+	TrustDomain string
+	Path        string
+}
+
+// String implements Stringer interface
+func (s SPIFFEID) String() string {
+	return "spiffe://" + s.TrustDomain + s.Path
+}
+
+// ParseSPIFFEID parses SPIFFE ID, trust domain is lower case and path
+// should not contain query, fragment, empty or relative segments
+func ParseSPIFFEID(id string) (SPIFFEID, error) {
+	u, err := url.Parse(id)
+	if err != nil {
+		return SPIFFEID{}, fmt.Errorf("%w %s, error %v", ErrInvalidSPIFFEID, id, err)
+	}
+	if u.Scheme != "spiffe" || u.Host == "" || u.User != nil || u.Port() != "" || u.RawQuery != "" || u.Fragment != "" {
+		return SPIFFEID{}, fmt.Errorf("%w %s", ErrInvalidSPIFFEID, id)
+	}
+	if u.Host != strings.ToLower(u.Host) {
+		return SPIFFEID{}, fmt.Errorf("%w %s, trust domain should be lower case", ErrInvalidSPIFFEID, id)
+	}
+	if u.Path != "" && (u.Path == "/" || path.Clean(u.Path) != u.Path) {
+		return SPIFFEID{}, fmt.Errorf("%w %s, path should not have empty or relative segments", ErrInvalidSPIFFEID, id)
+	}
+	return SPIFFEID{TrustDomain: u.Host, Path: u.Path}, nil
+}
+
+// CertificateIdentities returns identities of certificate, i.e. SPIFFE IDs
+// of its URI SANs followed by its DNS SANs
+func CertificateIdentities(cert *x509.Certificate) []string {
+	var ids []string
+	for _, u := range cert.URIs {
+		if u.Scheme == "spiffe" {
+			ids = append(ids, u.String())
+		}
+	}
+	for _, name := range cert.DNSNames {
+		ids = append(ids, strings.ToLower(name))
+	}
+	return ids
+}
+
+// ServiceIdentities represents identities of services allowed to call the
+// service via mutual TLS
+type ServiceIdentities struct {
+	TrustDomain string // SPIFFE trust domain, SPIFFE IDs of other domains are rejected
+	Verbose     int
+	services    map[string]srvConfig.ServiceIdentity
+}
+
+// NewServiceIdentities returns service identities of MutualTLS
+// configuration
+func NewServiceIdentities(cfg srvConfig.MutualTLS) *ServiceIdentities {
+	ids := &ServiceIdentities{
+		TrustDomain: strings.ToLower(cfg.TrustDomain),
+		services:    make(map[string]srvConfig.ServiceIdentity),
+	}
+	for _, svc := range cfg.Services {
+		id := svc.ID
+		if !strings.HasPrefix(id, "spiffe://") {
+			id = strings.ToLower(id)
+		}
+		ids.services[id] = svc
+	}
+	return ids
+}
+
+// Identify returns service identity of peer certificate, SPIFFE IDs take
+// precedence over DNS names
+func (s *ServiceIdentities) Identify(cert *x509.Certificate) (srvConfig.ServiceIdentity, error) {
+	for _, id := range CertificateIdentities(cert) {
+		if strings.HasPrefix(id, "spiffe://") {
+			sid, err := ParseSPIFFEID(id)
+			if err != nil || (s.TrustDomain != "" && sid.TrustDomain != s.TrustDomain) {
+				continue
+			}
+		}
+		if svc, ok := s.services[id]; ok {
+			return svc, nil
+		}
+	}
+	return srvConfig.ServiceIdentity{}, fmt.Errorf("%w, subject %s", ErrUnknownService, cert.Subject)
+}
+
+// Claims returns claims of service identity, user of the claims is service
+// name and application is its identity
+func (s *ServiceIdentities) Claims(svc srvConfig.ServiceIdentity) *Claims {
+	name := svc.Name
+	if name == "" {
+		name = path.Base(strings.TrimPrefix(svc.ID, "spiffe://"))
+	}
+	scope := svc.Scope
+	if scope == "" {
+		scope = "read"
+	}
+	return &Claims{
+		CustomClaims: CustomClaims{User: name, Scope: scope, Kind: ServiceKind, Roles: svc.Roles, Application: svc.ID},
+	}
+}
+
+// PeerCertificate returns verified client certificate of TLS connection
+func PeerCertificate(state *tls.ConnectionState) (*x509.Certificate, error) {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil, ErrNoPeerCertificate
+	}
+	return state.VerifiedChains[0][0], nil
+}
+
+// ConnectionClaims returns claims of peer service of TLS connection, e.g.
+// of gRPC call
+func (s *ServiceIdentities) ConnectionClaims(state *tls.ConnectionState) (*Claims, error) {
+	cert, err := PeerCertificate(state)
+	if err != nil {
+		return nil, err
+	}
+	svc, err := s.Identify(cert)
+	if err != nil {
+		return nil, err
+	}
+	return s.Claims(svc), nil
+}
+
+// RequestClaims returns claims of peer service of HTTP request
+func (s *ServiceIdentities) RequestClaims(r *http.Request) (*Claims, error) {
+	return s.ConnectionClaims(r.TLS)
+}
+
+// IsService checks if claims belong to peer service authenticated by
+// client certificate
+func IsService(claims *Claims) bool {
+	return claims != nil && claims.CustomClaims.Kind == ServiceKind
+}
+
+// ServiceMiddleware authorizes requests with verified client certificates
+// of peer services with optional scope. Requests without certificate of
+// known service, e.g. of users with tokens, are passed to next middleware,
+// e.g. TokenMiddleware, if it is provided. Request claims are stored in
+// gin context under "claims" key and service name under "user" key.
+func ServiceMiddleware(ids *ServiceIdentities, scope string, next gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, err := ids.RequestClaims(c.Request)
+		if err != nil && next != nil {
+			if ids.Verbose > 0 && !errors.Is(err, ErrNoPeerCertificate) {
+				log.Printf("INFO: ServiceMiddleware: %v", err)
+			}
+			next(c)
+			return
+		}
+		if err != nil {
+			log.Printf("ERROR: ServiceMiddleware: unable to authorize request, error %v", err)
+			rec := services.Response("authz", http.StatusUnauthorized, services.TokenError, err)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, rec)
+			return
+		}
+		if scope != "" && claims.CustomClaims.Scope != scope {
+			msg := fmt.Sprintf("ServiceMiddleware: service scope '%s' does not match with scope '%s'", claims.CustomClaims.Scope, scope)
+			log.Println("ERROR:", msg)
+			rec := services.Response("authz", http.StatusForbidden, services.ScopeError, errors.New(msg))
+			c.AbortWithStatusJSON(http.StatusForbidden, rec)
+			return
+		}
+		if ids.Verbose > 0 {
+			log.Printf("INFO: request of service %s is authorized via %s", claims.CustomClaims.User, claims.CustomClaims.Application)
+		}
+		c.Set("claims", claims)
+		c.Set("user", claims.CustomClaims.User)
+		c.Next()
+	}
+}
+
+// helper function to load CA bundle
+func loadCertPool(fname string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("unable to load root CAs from %s", fname)
+	}
+	return pool, nil
+}
+
+// ServerTLSConfig returns TLS configuration of the server which verifies
+// client certificates of peer services. Client certificates are optional,
+// so users may still authenticate with tokens. Server certificate of
+// MutualTLS configuration is used if it is provided, otherwise the
+// certificate is loaded by the server, e.g. via ListenAndServeTLS.
+func ServerTLSConfig(cfg srvConfig.MutualTLS) (*tls.Config, error) {
+	pool, err := loadCertPool(cfg.RootCAs)
+	if err != nil {
+		log.Println("ERROR: unable to load mTLS root CAs", err)
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		ClientCAs:  pool,
+		ClientAuth: tls.VerifyClientCertIfGiven,
+		MinVersion: tls.VersionTLS12,
+	}
+	if cfg.ServerCert != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.ServerCert, cfg.ServerKey)
+		if err != nil {
+			log.Println("ERROR: unable to load mTLS server certificate", err)
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
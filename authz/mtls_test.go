@@ -0,0 +1,187 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	services "github.com/CHESSComputing/golib/services"
+	"github.com/gin-gonic/gin"
+)
+
+// testCA represents certificate authority of mTLS tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	dir  string
+}
+
+// helper function to create test CA and write its certificate into file
+func newTestCA(t *testing.T) *testCA {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "foxden test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	ca := &testCA{cert: cert, key: key, dir: t.TempDir()}
+	ca.write(t, "ca.pem", "CERTIFICATE", der)
+	return ca
+}
+
+// helper function to write PEM file
+func (ca *testCA) write(t *testing.T, name, kind string, der []byte) string {
+	fname := filepath.Join(ca.dir, name)
+	if err := os.WriteFile(fname, pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return fname
+}
+
+// helper function to issue certificate with given SANs, it returns
+// certificate and key files
+func (ca *testCA) issue(t *testing.T, name, spiffeID string, dnsNames ...string) (string, string) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		DNSNames:     dnsNames,
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if spiffeID != "" {
+		u, _ := url.Parse(spiffeID)
+		tmpl.URIs = []*url.URL{u}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kder, _ := x509.MarshalECPrivateKey(key)
+	return ca.write(t, name+".pem", "CERTIFICATE", der), ca.write(t, name+".key", "EC PRIVATE KEY", kder)
+}
+
+// TestParseSPIFFEID
+func TestParseSPIFFEID(t *testing.T) {
+	id, err := ParseSPIFFEID("spiffe://chess.cornell.edu/foxden/metadata")
+	if err != nil || id.TrustDomain != "chess.cornell.edu" || id.Path != "/foxden/metadata" {
+		t.Errorf("wrong SPIFFE ID %+v, error %v", id, err)
+	}
+	if id.String() != "spiffe://chess.cornell.edu/foxden/metadata" {
+		t.Errorf("wrong SPIFFE ID string %s", id)
+	}
+	for _, s := range []string{"https://chess.cornell.edu/foxden", "spiffe://CHESS/foxden", "spiffe://chess/foxden/../admin", "spiffe://chess:443/foxden", "spiffe://chess/foxden?x=1", "spiffe:///foxden"} {
+		if _, err := ParseSPIFFEID(s); !errors.Is(err, ErrInvalidSPIFFEID) {
+			t.Errorf("invalid SPIFFE ID %s is accepted", s)
+		}
+	}
+}
+
+// TestServiceMiddleware
+func TestServiceMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ca := newTestCA(t)
+	srvCert, srvKey := ca.issue(t, "server", "", "localhost")
+	metaCert, metaKey := ca.issue(t, "metadata", "spiffe://chess.cornell.edu/foxden/metadata")
+	dbsCert, dbsKey := ca.issue(t, "dbs", "", "DBS.chess.cornell.edu")
+	otherCert, otherKey := ca.issue(t, "other", "spiffe://other.org/foxden/metadata")
+	cfg := srvConfig.MutualTLS{
+		Enabled:     true,
+		TrustDomain: "chess.cornell.edu",
+		RootCAs:     filepath.Join(ca.dir, "ca.pem"),
+		ServerCert:  srvCert,
+		ServerKey:   srvKey,
+		Services: []srvConfig.ServiceIdentity{
+			{ID: "spiffe://chess.cornell.edu/foxden/metadata", Roles: []string{"foxden-service"}, Scope: "write"},
+			{ID: "dbs.chess.cornell.edu", Name: "dbs"},
+		},
+	}
+	ids := NewServiceIdentities(cfg)
+
+	// requests without service certificate fall back to next middleware
+	fallback := func(c *gin.Context) {
+		c.AbortWithStatus(http.StatusUnauthorized)
+	}
+	r := gin.New()
+	r.POST("/write", ServiceMiddleware(ids, "write", fallback), func(c *gin.Context) {
+		claims := c.MustGet("claims").(*Claims)
+		if !IsService(claims) {
+			t.Errorf("wrong claims %+v", claims)
+		}
+		c.String(http.StatusOK, "%s %v", c.GetString("user"), claims.CustomClaims.Roles)
+	})
+	srv := httptest.NewUnstartedServer(r)
+	tlsConfig, err := ServerTLSConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.TLS = tlsConfig
+	srv.StartTLS()
+	defer srv.Close()
+
+	post := func(cert, key string) (int, string) {
+		c := cfg
+		c.ClientCert, c.ClientKey = cert, key
+		client, err := services.MutualTLSClient(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := client.Post(srv.URL+"/write", "text/plain", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body := make([]byte, 100)
+		n, _ := resp.Body.Read(body)
+		return resp.StatusCode, string(body[:n])
+	}
+	if code, body := post(metaCert, metaKey); code != http.StatusOK || body != "metadata [foxden-service]" {
+		t.Errorf("wrong response of metadata service %d %s", code, body)
+	}
+	if code, _ := post(dbsCert, dbsKey); code != http.StatusForbidden {
+		t.Errorf("read only service is allowed to write, code %d", code)
+	}
+	if code, _ := post(otherCert, otherKey); code != http.StatusUnauthorized {
+		t.Errorf("service of other trust domain is allowed, code %d", code)
+	}
+
+	// client without certificate still connects and is passed to fallback
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	resp, err := client.Post(srv.URL+"/write", "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("request without certificate, code %d", resp.StatusCode)
+	}
+}
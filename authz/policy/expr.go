@@ -0,0 +1,455 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Rule expression language:
+//
+//	expr    := and (("OR" | "||") and)*
+//	and     := not (("AND" | "&&") not)*
+//	not     := ("NOT" | "!") not | cmp
+//	cmp     := operand [("==" | "=" | "!=" | "IN" | "CONTAINS") operand]
+//	operand := "(" expr ")" | string | number | true | false | name
+//
+// Names are dot separated paths within evaluation environment, e.g.
+// record.owner, and they should start with one of environment names.
+// Names which are not defined in environment, e.g. missing record fields,
+// are evaluated as nil, i.e. false. String literals should be quoted.
+// Comparison of list with a value checks list membership, e.g.
+// role='staff' is true if user roles include staff.
+
+// token kinds
+const (
+	tokEOF = iota
+	tokName
+	tokString
+	tokNumber
+	tokOp
+	tokLParen
+	tokRParen
+)
+
+// token represents lexical token of rule expression
+type token struct {
+	kind int
+	text string
+}
+
+// helper function to split rule expression into tokens
+func tokenize(expr string) ([]token, error) {
+	var tokens []token
+	runes := []rune(expr)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{tokLParen, "("})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{tokRParen, ")"})
+			i++
+		case r == '"' || r == '\'':
+			j := i + 1
+			for j < len(runes) && runes[j] != r {
+				j++
+			}
+			if j == len(runes) {
+				return nil, fmt.Errorf("unterminated string at position %d", i)
+			}
+			tokens = append(tokens, token{tokString, string(runes[i+1 : j])})
+			i = j + 1
+		case strings.ContainsRune("=!&|", r):
+			j := i + 1
+			if j < len(runes) && strings.ContainsRune("=&|", runes[j]) {
+				j++
+			}
+			op := string(runes[i:j])
+			switch op {
+			case "=", "==", "!=", "!", "&&", "||":
+			default:
+				return nil, fmt.Errorf("unknown operator %s at position %d", op, i)
+			}
+			tokens = append(tokens, token{tokOp, op})
+			i = j
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			j := i + 1
+			for j < len(runes) && (unicode.IsDigit(runes[j]) || runes[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, token{tokNumber, string(runes[i:j])})
+			i = j
+		case unicode.IsLetter(r) || r == '_':
+			j := i + 1
+			for j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j]) || strings.ContainsRune("_.-", runes[j])) {
+				j++
+			}
+			word := string(runes[i:j])
+			switch strings.ToUpper(word) {
+			case "AND":
+				tokens = append(tokens, token{tokOp, "&&"})
+			case "OR":
+				tokens = append(tokens, token{tokOp, "||"})
+			case "NOT":
+				tokens = append(tokens, token{tokOp, "!"})
+			case "IN", "CONTAINS":
+				tokens = append(tokens, token{tokOp, strings.ToLower(word)})
+			default:
+				tokens = append(tokens, token{tokName, word})
+			}
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected character %q at position %d", r, i)
+		}
+	}
+	return append(tokens, token{kind: tokEOF}), nil
+}
+
+// node represents node of parsed rule expression
+type node interface {
+	eval(env map[string]any) (any, error)
+}
+
+// literal represents literal value
+type literal struct {
+	value any
+}
+
+func (n literal) eval(env map[string]any) (any, error) {
+	return n.value, nil
+}
+
+// name represents variable within environment
+type name struct {
+	path string
+}
+
+func (n name) eval(env map[string]any) (any, error) {
+	if val, ok := lookup(env, n.path); ok {
+		return val, nil
+	}
+	return nil, nil
+}
+
+// unary represents negation
+type unary struct {
+	arg node
+}
+
+func (n unary) eval(env map[string]any) (any, error) {
+	val, err := n.arg.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	return !truthy(val), nil
+}
+
+// binary represents binary operation
+type binary struct {
+	op          string
+	left, right node
+}
+
+func (n binary) eval(env map[string]any) (any, error) {
+	left, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	// short circuit logical operations
+	switch n.op {
+	case "&&":
+		if !truthy(left) {
+			return false, nil
+		}
+	case "||":
+		if truthy(left) {
+			return true, nil
+		}
+	}
+	right, err := n.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "&&", "||":
+		return truthy(right), nil
+	case "=", "==":
+		return match(left, right), nil
+	case "!=":
+		return !match(left, right), nil
+	case "in":
+		return contains(right, left), nil
+	case "contains":
+		return contains(left, right), nil
+	}
+	return nil, fmt.Errorf("unknown operator %s", n.op)
+}
+
+// envNames defines names of evaluation environment (see Input.Env)
+var envNames = map[string]bool{
+	"action": true, "sub": true, "user": true, "role": true, "roles": true,
+	"scope": true, "kind": true, "record": true, "groups": true,
+}
+
+// parser represents recursive descent parser of rule expressions
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokOp && p.peek().text == "||" {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = binary{op: "||", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokOp && p.peek().text == "&&" {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = binary{op: "&&", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (node, error) {
+	if tok := p.peek(); tok.kind == tokOp && tok.text == "!" {
+		p.next()
+		arg, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return unary{arg: arg}, nil
+	}
+	return p.parseCmp()
+}
+
+func (p *parser) parseCmp() (node, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	tok := p.peek()
+	if tok.kind == tokOp {
+		switch tok.text {
+		case "=", "==", "!=", "in", "contains":
+			p.next()
+			right, err := p.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			return binary{op: tok.text, left: left, right: right}, nil
+		}
+	}
+	return left, nil
+}
+
+func (p *parser) parseOperand() (node, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokLParen:
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next().kind != tokRParen {
+			return nil, errors.New("missing closing parenthesis")
+		}
+		return expr, nil
+	case tokString:
+		return literal{tok.text}, nil
+	case tokNumber:
+		val, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s", tok.text)
+		}
+		return literal{val}, nil
+	case tokName:
+		switch tok.text {
+		case "true":
+			return literal{true}, nil
+		case "false":
+			return literal{false}, nil
+		}
+		root, _, _ := strings.Cut(tok.text, ".")
+		if !envNames[root] {
+			return nil, fmt.Errorf("unknown name %s, string literals should be quoted", tok.text)
+		}
+		return name{tok.text}, nil
+	case tokEOF:
+		return nil, errors.New("unexpected end of expression")
+	}
+	return nil, fmt.Errorf("unexpected token %s", tok.text)
+}
+
+// parse parses rule expression
+func parse(expr string) (node, error) {
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected token %s", tok.text)
+	}
+	return root, nil
+}
+
+// helper function to look up dot separated path within environment
+func lookup(env map[string]any, path string) (any, bool) {
+	var val any = env
+	for _, key := range strings.Split(path, ".") {
+		m, ok := val.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if val, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+	return val, true
+}
+
+// helper function to check truth of the value
+func truthy(val any) bool {
+	switch v := val.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case string:
+		return v != ""
+	}
+	if f, ok := toFloat(val); ok {
+		return f != 0
+	}
+	rv := reflect.ValueOf(val)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return rv.Len() > 0
+	}
+	return true
+}
+
+// helper function to convert numeric value into float64
+func toFloat(val any) (float64, bool) {
+	switch v := val.(type) {
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// helper function to compare scalar values, undefined (nil) values are
+// not equal to any value including other undefined values
+func equal(a, b any) bool {
+	if a == nil || b == nil {
+		return false
+	}
+	if x, ok := toFloat(a); ok {
+		if y, ok := toFloat(b); ok {
+			return x == y
+		}
+		if s, ok := b.(string); ok {
+			y, err := strconv.ParseFloat(s, 64)
+			return err == nil && x == y
+		}
+	}
+	if _, ok := toFloat(b); ok {
+		return equal(b, a)
+	}
+	return fmt.Sprintf("%v", a) == fmt.Sprintf("%v", b)
+}
+
+// helper function to check if value is a list
+func isList(val any) bool {
+	if val == nil {
+		return false
+	}
+	kind := reflect.ValueOf(val).Kind()
+	return kind == reflect.Slice || kind == reflect.Array
+}
+
+// helper function to compare values, comparison of list and value checks
+// list membership
+func match(a, b any) bool {
+	switch {
+	case a == nil || b == nil:
+		return false
+	case isList(a) && !isList(b):
+		return contains(a, b)
+	case isList(b) && !isList(a):
+		return contains(b, a)
+	case isList(a) && isList(b):
+		return reflect.DeepEqual(a, b)
+	}
+	return equal(a, b)
+}
+
+// helper function to check if container (list or string) contains value
+func contains(container, val any) bool {
+	if container == nil || val == nil {
+		return false
+	}
+	if s, ok := container.(string); ok {
+		return strings.Contains(s, fmt.Sprintf("%v", val))
+	}
+	if !isList(container) {
+		return equal(container, val)
+	}
+	rv := reflect.ValueOf(container)
+	for i := 0; i < rv.Len(); i++ {
+		if equal(rv.Index(i).Interface(), val) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	services "github.com/CHESSComputing/golib/services"
+	"github.com/gin-gonic/gin"
+)
+
+// ErrRecordNotFound should be returned by resource loaders when requested
+// record does not exist
+var ErrRecordNotFound = errors.New("record not found")
+
+// RecordKey defines gin context key of record loaded by PolicyMiddleware
+const RecordKey = "policy.record"
+
+// ResourceLoader loads record guarded by the policy, e.g. metadata record
+// identified by request parameters. Nil record is allowed for actions
+// which do not refer to existing records.
+type ResourceLoader func(c *gin.Context) (map[string]any, error)
+
+// helper function to get request claims either from gin context set by
+// authz middleware or from request token
+func requestClaims(c *gin.Context, clientId string) (*authz.Claims, error) {
+	if val, ok := c.Get("claims"); ok {
+		if claims, ok := val.(*authz.Claims); ok {
+			return claims, nil
+		}
+	}
+	tokenStr := authz.RequestToken(c.Request)
+	token := &authz.Token{AccessToken: tokenStr}
+	if err := token.Validate(clientId); err != nil {
+		return nil, err
+	}
+	return authz.TokenClaims(tokenStr, clientId)
+}
+
+// PolicyMiddleware guards given action with policy evaluator. The record
+// provided by loader is evaluated along with request token claims and it
+// is stored in gin context under RecordKey for use by handlers.
+func PolicyMiddleware(evaluator Evaluator, action string, loader ResourceLoader, clientId string, verbose int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, err := requestClaims(c, clientId)
+		if err != nil {
+			log.Printf("ERROR: PolicyMiddleware: invalid token, error %v", err)
+			rec := services.Response("authz", http.StatusUnauthorized, services.TokenError, err)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, rec)
+			return
+		}
+		var record map[string]any
+		if loader != nil {
+			record, err = loader(c)
+			if errors.Is(err, ErrRecordNotFound) {
+				rec := services.Response("authz", http.StatusNotFound, services.QueryError, err)
+				c.AbortWithStatusJSON(http.StatusNotFound, rec)
+				return
+			}
+			if err != nil {
+				rec := services.Response("authz", http.StatusInternalServerError, services.LoadError, err)
+				c.AbortWithStatusJSON(http.StatusInternalServerError, rec)
+				return
+			}
+		}
+		input := NewInput(action, claims, record)
+		allowed, err := evaluator.Allowed(c.Request.Context(), input)
+		if err != nil {
+			log.Printf("ERROR: PolicyMiddleware: unable to evaluate policy of %s, error %v", action, err)
+			rec := services.Response("authz", http.StatusInternalServerError, services.PolicyError, err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, rec)
+			return
+		}
+		if !allowed {
+			err := fmt.Errorf("user %s is not allowed to %s", input.Subject, action)
+			rec := services.Response("authz", http.StatusForbidden, services.PolicyError, err)
+			c.AbortWithStatusJSON(http.StatusForbidden, rec)
+			return
+		}
+		if verbose > 0 {
+			log.Printf("INFO: user %s is allowed to %s", input.Subject, action)
+		}
+		c.Set(RecordKey, record)
+		c.Next()
+	}
+}
@@ -0,0 +1,242 @@
+package policy
+
+// policy module provides fine-grained authorization of FOXDEN/CHESS
+// resources, e.g. metadata records guarded by beamline, proposal or
+// ownership. Policies are expressed as rules of small expression language,
+// e.g. "role='staff' OR record.owner==sub", or evaluated by external OPA
+// server.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	authz "github.com/CHESSComputing/golib/authz"
+)
+
+// Input represents input of policy evaluation
+type Input struct {
+	Action  string         `json:"action"`
+	Subject string         `json:"sub"`
+	Roles   []string       `json:"roles"`
+	Groups  []string       `json:"groups"`
+	Scope   string         `json:"scope"`
+	Kind    string         `json:"kind"`
+	Record  map[string]any `json:"record"`
+}
+
+// NewInput returns policy input for given action, token claims and record
+func NewInput(action string, claims *authz.Claims, record map[string]any) Input {
+	input := Input{Action: action, Record: record}
+	if claims != nil {
+		input.Subject = claims.CustomClaims.User
+		input.Roles = claims.CustomClaims.Roles
+		input.Scope = claims.CustomClaims.Scope
+		input.Kind = claims.CustomClaims.Kind
+	}
+	return input
+}
+
+// Env provides evaluation environment of rule expressions, roles are
+// available both as role and roles names
+func (i Input) Env() map[string]any {
+	return map[string]any{
+		"action": i.Action,
+		"sub":    i.Subject,
+		"user":   i.Subject,
+		"role":   i.Roles,
+		"roles":  i.Roles,
+		"groups": i.Groups,
+		"scope":  i.Scope,
+		"kind":   i.Kind,
+		"record": i.Record,
+	}
+}
+
+// Evaluator defines interface of policy evaluation
+type Evaluator interface {
+	// Allowed checks if input is allowed by the policy
+	Allowed(ctx context.Context, input Input) (bool, error)
+}
+
+// GroupResolver defines interface which provides groups of users, e.g.
+// groups module
+type GroupResolver interface {
+	// UserGroups returns groups of the user
+	UserGroups(ctx context.Context, user string) ([]string, error)
+}
+
+// ResolveGroups sets groups of input subject via given resolver unless
+// they are already set
+func ResolveGroups(ctx context.Context, resolver GroupResolver, input Input) (Input, error) {
+	if resolver == nil || input.Groups != nil || input.Subject == "" {
+		return input, nil
+	}
+	groups, err := resolver.UserGroups(ctx, input.Subject)
+	if err != nil {
+		return input, err
+	}
+	input.Groups = append([]string{}, groups...)
+	return input, nil
+}
+
+// GroupEvaluator provides groups of the subject to policy input evaluated
+// by Next evaluator, e.g. rules like "'p-1234' in groups"
+type GroupEvaluator struct {
+	Next     Evaluator
+	Resolver GroupResolver
+}
+
+// WithGroups returns evaluator which resolves groups of the subject before
+// evaluation by given evaluator
+func WithGroups(next Evaluator, resolver GroupResolver) *GroupEvaluator {
+	return &GroupEvaluator{Next: next, Resolver: resolver}
+}
+
+// Allowed implements Evaluator interface
+func (e *GroupEvaluator) Allowed(ctx context.Context, input Input) (bool, error) {
+	input, err := ResolveGroups(ctx, e.Resolver, input)
+	if err != nil {
+		log.Printf("ERROR: unable to resolve groups of %s, error %v", input.Subject, err)
+		return false, err
+	}
+	return e.Next.Allowed(ctx, input)
+}
+
+// Rule represents compiled rule expression
+type Rule struct {
+	Expr string
+	root node
+}
+
+// Compile compiles rule expression
+func Compile(expr string) (*Rule, error) {
+	root, err := parse(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rule '%s', error %v", expr, err)
+	}
+	return &Rule{Expr: expr, root: root}, nil
+}
+
+// Eval evaluates rule within given environment
+func (r *Rule) Eval(env map[string]any) (bool, error) {
+	val, err := r.root.eval(env)
+	if err != nil {
+		return false, err
+	}
+	return truthy(val), nil
+}
+
+// Engine represents set of rules per action. Action is allowed if any of
+// its rules is true, rules of "*" action apply to all actions. Actions
+// without rules are denied, rules which fail to evaluate are skipped.
+type Engine struct {
+	mu    sync.RWMutex
+	rules map[string][]*Rule
+}
+
+// NewEngine returns policy engine with given rules per action
+func NewEngine(rules map[string][]string) (*Engine, error) {
+	e := &Engine{rules: make(map[string][]*Rule)}
+	for action, exprs := range rules {
+		for _, expr := range exprs {
+			if err := e.Add(action, expr); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return e, nil
+}
+
+// Add adds rule for given action
+func (e *Engine) Add(action, expr string) error {
+	rule, err := Compile(expr)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.rules == nil {
+		e.rules = make(map[string][]*Rule)
+	}
+	e.rules[action] = append(e.rules[action], rule)
+	return nil
+}
+
+// Allowed implements Evaluator interface
+func (e *Engine) Allowed(ctx context.Context, input Input) (bool, error) {
+	e.mu.RLock()
+	rules := append(append([]*Rule{}, e.rules[input.Action]...), e.rules["*"]...)
+	e.mu.RUnlock()
+	env := input.Env()
+	for _, rule := range rules {
+		ok, err := rule.Eval(env)
+		if err != nil {
+			log.Printf("WARNING: unable to evaluate rule '%s' of action %s, error %v", rule.Expr, input.Action, err)
+			continue
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// OPA represents policy evaluation by Open Policy Agent server via its
+// data API, e.g. URL http://localhost:8181 and path foxden/authz/allow
+type OPA struct {
+	URL    string
+	Path   string
+	Client *http.Client
+}
+
+// NewOPA returns OPA evaluator
+func NewOPA(url, path string) *OPA {
+	return &OPA{URL: strings.TrimSuffix(url, "/"), Path: strings.Trim(path, "/"), Client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+// Allowed implements Evaluator interface
+func (o *OPA) Allowed(ctx context.Context, input Input) (bool, error) {
+	data, err := json.Marshal(map[string]any{"input": input})
+	if err != nil {
+		return false, err
+	}
+	rurl := fmt.Sprintf("%s/v1/data/%s", o.URL, o.Path)
+	req, err := http.NewRequestWithContext(ctx, "POST", rurl, bytes.NewReader(data))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := o.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return false, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("OPA request %s failed with status %d: %s", rurl, resp.StatusCode, string(body))
+	}
+	var out struct {
+		Result any `json:"result"`
+	}
+	if err := json.Unmarshal(body, &out); err != nil {
+		return false, err
+	}
+	// undefined decision is represented by missing result and means deny
+	allowed, _ := out.Result.(bool)
+	return allowed, nil
+}
@@ -0,0 +1,196 @@
+package policy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	"github.com/gin-gonic/gin"
+)
+
+// TestRules
+func TestRules(t *testing.T) {
+	env := Input{
+		Subject: "alice",
+		Roles:   []string{"staff", "id3a"},
+		Record:  map[string]any{"owner": "bob", "beamline": "id3a", "proposal": 123, "public": true},
+	}.Env()
+	tests := []struct {
+		expr   string
+		result bool
+	}{
+		{"role='staff'", true},
+		{"role='admin'", false},
+		{"record.owner==sub", false},
+		{"role='staff' OR record.owner==sub", true},
+		{"role='staff' AND record.owner==sub", false},
+		{"NOT record.owner==sub", true},
+		{"!(record.owner == 'alice')", true},
+		{"record.beamline in roles", true},
+		{"roles contains 'id3a' && record.proposal == 123", true},
+		{"record.proposal != 124", true},
+		{"record.public", true},
+		{"record.missing", false}, // undefined names are nil
+		{"record.missing OR record.owner == 'alice'", false},
+		{"NOT record.missing", true},
+		{"record.missing == 'record.missing'", false},
+		{"record.missing == record.other", false},
+		{"record.missing == '<nil>'", false},
+		{"record.missing in roles", false},
+		{"'owner <nil>' contains record.missing", false},
+		{"record.owner == \"bob\" || false", true},
+	}
+	for _, tt := range tests {
+		rule, err := Compile(tt.expr)
+		if err != nil {
+			t.Errorf("unable to compile %s, error %v", tt.expr, err)
+			continue
+		}
+		res, err := rule.Eval(env)
+		if err != nil {
+			t.Errorf("unable to evaluate %s, error %v", tt.expr, err)
+		}
+		if res != tt.result {
+			t.Errorf("rule %s result %v, expected %v", tt.expr, res, tt.result)
+		}
+	}
+	for _, expr := range []string{"", "role =", "(role='staff'", "role='staff')", "role === 'staff'", "a # b", "role=staff", "owner == sub"} {
+		if _, err := Compile(expr); err == nil {
+			t.Errorf("invalid rule %s is compiled", expr)
+		}
+	}
+}
+
+// TestEngine
+func TestEngine(t *testing.T) {
+	engine, err := NewEngine(map[string][]string{
+		"record.read": {"record.public", "record.owner == sub"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	// missing public field should not allow access
+	input := Input{Action: "record.read", Subject: "alice", Record: map[string]any{"owner": "bob"}}
+	if ok, err := engine.Allowed(ctx, input); err != nil || ok {
+		t.Errorf("record without public field is allowed, error %v", err)
+	}
+	input.Record["public"] = true
+	if ok, err := engine.Allowed(ctx, input); err != nil || !ok {
+		t.Errorf("public record is denied, error %v", err)
+	}
+	// failed rule is skipped and remaining rules are evaluated
+	engine.rules["record.read"] = append([]*Rule{{Expr: "broken", root: binary{op: "?", left: literal{1}, right: literal{2}}}}, engine.rules["record.read"]...)
+	input.Record = map[string]any{"owner": "alice"}
+	if ok, err := engine.Allowed(ctx, input); err != nil || !ok {
+		t.Errorf("owner is denied after failed rule, error %v", err)
+	}
+	input.Subject = "bob"
+	if ok, err := engine.Allowed(ctx, input); err != nil || ok {
+		t.Errorf("other user is allowed after failed rule, error %v", err)
+	}
+}
+
+// helper type to resolve groups of users from static map
+type staticGroups map[string][]string
+
+// UserGroups implements GroupResolver interface
+func (s staticGroups) UserGroups(ctx context.Context, user string) ([]string, error) {
+	return s[user], nil
+}
+
+// TestGroupEvaluator
+func TestGroupEvaluator(t *testing.T) {
+	engine, err := NewEngine(map[string][]string{"record.read": {"record.proposal in groups"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := WithGroups(engine, staticGroups{"alice": {"p-1234"}})
+	input := Input{Action: "record.read", Subject: "alice", Record: map[string]any{"proposal": "p-1234"}}
+	if ok, err := e.Allowed(context.Background(), input); err != nil || !ok {
+		t.Errorf("group member is denied, error %v", err)
+	}
+	input.Subject = "bob"
+	if ok, err := e.Allowed(context.Background(), input); err != nil || ok {
+		t.Errorf("other user is allowed, error %v", err)
+	}
+	// groups of the input are not resolved again
+	input.Groups = []string{"p-1234"}
+	if ok, err := e.Allowed(context.Background(), input); err != nil || !ok {
+		t.Errorf("input groups are ignored, error %v", err)
+	}
+}
+
+// TestPolicyMiddleware
+func TestPolicyMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	secret := "test-secret"
+	engine, err := NewEngine(map[string][]string{
+		"record.update": {"record.owner == sub", "role='staff'"},
+		"*":             {"role='admin'"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := map[string]map[string]any{"1": {"owner": "alice"}}
+	loader := func(c *gin.Context) (map[string]any, error) {
+		if rec, ok := records[c.Param("id")]; ok {
+			return rec, nil
+		}
+		return nil, ErrRecordNotFound
+	}
+	r := gin.New()
+	r.PUT("/record/:id", PolicyMiddleware(engine, "record.update", loader, secret, 0), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	call := func(path, user string, roles ...string) int {
+		token, _ := authz.JWTAccessToken(secret, 60, authz.CustomClaims{User: user, Roles: roles})
+		req := httptest.NewRequest("PUT", path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	if code := call("/record/1", "alice"); code != http.StatusOK {
+		t.Errorf("owner should be allowed, code %d", code)
+	}
+	if code := call("/record/1", "bob"); code != http.StatusForbidden {
+		t.Errorf("other user should be denied, code %d", code)
+	}
+	if code := call("/record/1", "bob", "staff"); code != http.StatusOK {
+		t.Errorf("staff should be allowed, code %d", code)
+	}
+	if code := call("/record/1", "root", "admin"); code != http.StatusOK {
+		t.Errorf("admin should be allowed, code %d", code)
+	}
+	if code := call("/record/2", "alice"); code != http.StatusNotFound {
+		t.Errorf("missing record should not be found, code %d", code)
+	}
+}
+
+// TestOPA
+func TestOPA(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/data/foxden/allow" {
+			http.NotFound(w, r)
+			return
+		}
+		var req struct {
+			Input Input `json:"input"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		json.NewEncoder(w).Encode(map[string]any{"result": req.Input.Subject == "alice"})
+	}))
+	defer srv.Close()
+	opa := NewOPA(srv.URL, "/foxden/allow")
+	ctx := context.Background()
+	if ok, err := opa.Allowed(ctx, Input{Subject: "alice"}); err != nil || !ok {
+		t.Errorf("alice should be allowed, error %v", err)
+	}
+	if ok, err := opa.Allowed(ctx, Input{Subject: "bob"}); err != nil || ok {
+		t.Errorf("bob should be denied, error %v", err)
+	}
+}
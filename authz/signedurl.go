@@ -0,0 +1,309 @@
+package auth
+
+// signedurl module mints and verifies signed URLs which grant browsers
+// time-limited access to resources, e.g. download links handed out by
+// frontend. Signature is HMAC-SHA256 over path, expiration, scope and user
+// of the URL, so links can not be extended, widened or moved to another
+// resource and bearer tokens are not kept in query strings.
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	services "github.com/CHESSComputing/golib/services"
+	"github.com/gin-gonic/gin"
+)
+
+// SignedURLKind defines kind of claims of requests authorized by signed URL
+const SignedURLKind = "signed-url"
+
+// query parameters of signed URLs
+const (
+	SignedURLExpires   = "foxden_expires"
+	SignedURLScope     = "foxden_scope"
+	SignedURLUser      = "foxden_user"
+	SignedURLRoles     = "foxden_roles"
+	SignedURLKey       = "foxden_key"
+	SignedURLSignature = "foxden_signature"
+)
+
+// default lifetimes of signed URLs
+const (
+	DefaultSignedURLExpires    = 15 * time.Minute
+	DefaultSignedURLMaxExpires = 24 * time.Hour
+)
+
+// errors of signed URLs
+var (
+	ErrInvalidSignedURL = errors.New("invalid URL signature")
+	ErrExpiredSignedURL = errors.New("signed URL is expired")
+	ErrSignedURLPath    = errors.New("path can not be signed")
+	ErrSignedURLScope   = errors.New("scope of signed URL is not allowed")
+)
+
+// urlKey represents HMAC key of single secret
+type urlKey struct {
+	id  string
+	key []byte
+}
+
+// helper function to derive HMAC key of the secret
+func newURLKey(secret string) urlKey {
+	key := sha256.Sum256([]byte("foxden signed url key:" + secret))
+	hash := sha256.Sum256(key[:])
+	return urlKey{id: hex.EncodeToString(hash[:4]), key: key[:]}
+}
+
+// URLSigner mints and verifies signed URLs, the first key is used to sign
+// URLs and all keys are used to verify them
+type URLSigner struct {
+	Paths      []string      // path prefixes which may be signed, all paths if empty
+	Expires    time.Duration // default lifetime of signed URLs
+	MaxExpires time.Duration // maximum lifetime of signed URLs
+	Verbose    int
+	keys       []urlKey
+}
+
+// NewURLSigner returns URL signer of SignedUrls configuration, Secret is
+// used to sign URLs and OldSecrets to verify URLs signed before rotation
+func NewURLSigner(cfg srvConfig.SignedURLs) (*URLSigner, error) {
+	if cfg.Secret == "" {
+		return nil, errors.New("signed URLs require secret")
+	}
+	signer := &URLSigner{Paths: cfg.Paths, Expires: cfg.Expires, MaxExpires: cfg.MaxExpires}
+	if signer.Expires <= 0 {
+		signer.Expires = DefaultSignedURLExpires
+	}
+	if signer.MaxExpires <= 0 {
+		signer.MaxExpires = DefaultSignedURLMaxExpires
+	}
+	for _, secret := range append([]string{cfg.Secret}, cfg.OldSecrets...) {
+		if secret != "" {
+			signer.keys = append(signer.keys, newURLKey(secret))
+		}
+	}
+	return signer, nil
+}
+
+// SignedURL represents verified signed URL
+type SignedURL struct {
+	Path    string    `json:"path"`
+	Scope   string    `json:"scope"`
+	User    string    `json:"user"`
+	Roles   []string  `json:"roles,omitempty"`
+	Expires time.Time `json:"expires"`
+}
+
+// Claims returns claims of requests authorized by signed URL
+func (s SignedURL) Claims() *Claims {
+	return &Claims{
+		CustomClaims: CustomClaims{User: s.User, Scope: s.Scope, Kind: SignedURLKind, Roles: s.Roles},
+	}
+}
+
+// helper function to check if path may be signed
+func (s *URLSigner) allowed(path string) bool {
+	if len(s.Paths) == 0 {
+		return true
+	}
+	for _, prefix := range s.Paths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// helper function to compute signature of URL path and its query without
+// signature parameter
+func (k urlKey) sign(path string, query url.Values) string {
+	q := url.Values{}
+	for key, vals := range query {
+		if key != SignedURLSignature {
+			q[key] = vals
+		}
+	}
+	mac := hmac.New(sha256.New, k.key)
+	mac.Write([]byte("FOXDEN-URL-V1\n" + path + "\n" + q.Encode()))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Sign returns signed URL of given resource URL, either absolute or
+// relative, which grants given scope of the user to its holder. Scope
+// other than read should be granted to the user, zero expiration means
+// default lifetime of signer.
+func (s *URLSigner) Sign(rurl, scope string, claims *Claims, expires time.Duration) (string, time.Time, error) {
+	if claims == nil || claims.CustomClaims.User == "" || IsAnonymous(claims) {
+		return "", time.Time{}, errors.New("signed URLs require authenticated user")
+	}
+	u, err := url.Parse(rurl)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if !s.allowed(u.Path) {
+		return "", time.Time{}, fmt.Errorf("%w: %s", ErrSignedURLPath, u.Path)
+	}
+	if scope == "" {
+		scope = "read"
+	}
+	if scope != "read" && scope != claims.CustomClaims.Scope {
+		return "", time.Time{}, fmt.Errorf("%w: %s", ErrSignedURLScope, scope)
+	}
+	if expires <= 0 {
+		expires = s.Expires
+	}
+	if expires > s.MaxExpires {
+		expires = s.MaxExpires
+	}
+	exp := time.Now().Add(expires).Truncate(time.Second)
+	query := u.Query()
+	query.Set(SignedURLExpires, strconv.FormatInt(exp.Unix(), 10))
+	query.Set(SignedURLScope, scope)
+	query.Set(SignedURLUser, claims.CustomClaims.User)
+	if len(claims.CustomClaims.Roles) > 0 {
+		query.Set(SignedURLRoles, strings.Join(claims.CustomClaims.Roles, ","))
+	} else {
+		query.Del(SignedURLRoles)
+	}
+	key := s.keys[0]
+	query.Set(SignedURLKey, key.id)
+	query.Set(SignedURLSignature, key.sign(u.EscapedPath(), query))
+	u.RawQuery = query.Encode()
+	return u.String(), exp, nil
+}
+
+// IsSigned checks if URL carries signature
+func IsSigned(u *url.URL) bool {
+	return u.Query().Get(SignedURLSignature) != ""
+}
+
+// Verify verifies signature and expiration of signed URL
+func (s *URLSigner) Verify(u *url.URL) (SignedURL, error) {
+	query := u.Query()
+	sig := query.Get(SignedURLSignature)
+	if sig == "" {
+		return SignedURL{}, ErrInvalidSignedURL
+	}
+	var valid bool
+	for _, key := range s.keys {
+		if key.id == query.Get(SignedURLKey) {
+			valid = hmac.Equal([]byte(sig), []byte(key.sign(u.EscapedPath(), query)))
+			break
+		}
+	}
+	if !valid {
+		return SignedURL{}, ErrInvalidSignedURL
+	}
+	sec, err := strconv.ParseInt(query.Get(SignedURLExpires), 10, 64)
+	if err != nil {
+		return SignedURL{}, ErrInvalidSignedURL
+	}
+	signed := SignedURL{
+		Path:    u.Path,
+		Scope:   query.Get(SignedURLScope),
+		User:    query.Get(SignedURLUser),
+		Expires: time.Unix(sec, 0),
+	}
+	if roles := query.Get(SignedURLRoles); roles != "" {
+		signed.Roles = strings.Split(roles, ",")
+	}
+	if time.Now().After(signed.Expires) {
+		return signed, ErrExpiredSignedURL
+	}
+	if !s.allowed(u.Path) {
+		return signed, fmt.Errorf("%w: %s", ErrSignedURLPath, u.Path)
+	}
+	return signed, nil
+}
+
+// SignedURLMiddleware authorizes requests of signed URLs with optional
+// scope. Requests without signature are passed to next middleware, e.g.
+// TokenMiddleware, if it is provided. Request claims are stored in gin
+// context under "claims" key and user name under "user" key.
+func SignedURLMiddleware(signer *URLSigner, scope string, next gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !IsSigned(c.Request.URL) && next != nil {
+			next(c)
+			return
+		}
+		signed, err := signer.Verify(c.Request.URL)
+		if err != nil {
+			log.Printf("ERROR: SignedURLMiddleware: unable to authorize request %s, error %v", c.Request.URL.Path, err)
+			rec := services.Response("authz", http.StatusUnauthorized, services.TokenError, err)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, rec)
+			return
+		}
+		if scope != "" && signed.Scope != scope {
+			msg := fmt.Sprintf("SignedURLMiddleware: URL scope '%s' does not match with scope '%s'", signed.Scope, scope)
+			log.Println("ERROR:", msg)
+			rec := services.Response("authz", http.StatusUnauthorized, services.ScopeError, errors.New(msg))
+			c.AbortWithStatusJSON(http.StatusUnauthorized, rec)
+			return
+		}
+		if signer.Verbose > 0 {
+			log.Printf("INFO: request %s of user %s is authorized via signed URL", signed.Path, signed.User)
+		}
+		claims := signed.Claims()
+		c.Set("claims", claims)
+		c.Set("user", claims.CustomClaims.User)
+		c.Next()
+	}
+}
+
+// SignURLRequest represents request to sign URL
+type SignURLRequest struct {
+	URL     string `json:"url"`
+	Scope   string `json:"scope"`
+	Expires int64  `json:"expires"` // lifetime in seconds, zero means default lifetime
+}
+
+// SignURLResponse represents signed URL
+type SignURLResponse struct {
+	URL     string    `json:"url"`
+	Expires time.Time `json:"expires"`
+}
+
+// SignURLHandler provides gin handler which signs URLs for authorized
+// users, e.g. download links of frontend. It should be preceded by
+// TokenMiddleware.
+func (s *URLSigner) SignURLHandler(clientId string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req SignURLRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			rec := services.Response("authz", http.StatusBadRequest, services.BindError, err)
+			c.JSON(http.StatusBadRequest, rec)
+			return
+		}
+		var claims *Claims
+		if val, ok := c.Get("claims"); ok {
+			claims, _ = val.(*Claims)
+		}
+		if claims == nil {
+			var err error
+			if claims, err = TokenClaims(RequestToken(c.Request), clientId); err != nil {
+				rec := services.Response("authz", http.StatusUnauthorized, services.TokenError, err)
+				c.JSON(http.StatusUnauthorized, rec)
+				return
+			}
+		}
+		rurl, exp, err := s.Sign(req.URL, req.Scope, claims, time.Duration(req.Expires)*time.Second)
+		if err != nil {
+			log.Printf("ERROR: unable to sign URL %s, error %v", req.URL, err)
+			rec := services.Response("authz", http.StatusForbidden, services.TokenError, err)
+			c.JSON(http.StatusForbidden, rec)
+			return
+		}
+		c.JSON(http.StatusOK, SignURLResponse{URL: rurl, Expires: exp})
+	}
+}
@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	"github.com/gin-gonic/gin"
+)
+
+// TestURLSigner
+func TestURLSigner(t *testing.T) {
+	cfg := srvConfig.SignedURLs{Secret: "new", OldSecrets: []string{"old"}, Paths: []string{"/download/"}, MaxExpires: time.Hour}
+	signer, err := NewURLSigner(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &Claims{CustomClaims: CustomClaims{User: "alice", Scope: "read", Roles: []string{"id3a", "staff"}}}
+	rurl, exp, err := signer.Sign("https://foxden/download/raw/scan%201.h5?offset=10", "", user, 2*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Until(exp); d > time.Hour || d < 59*time.Minute {
+		t.Errorf("lifetime %v is not limited by MaxExpires", d)
+	}
+	u, _ := url.Parse(rurl)
+	signed, err := signer.Verify(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signed.User != "alice" || signed.Scope != "read" || len(signed.Roles) != 2 || signed.Path != "/download/raw/scan 1.h5" || u.Query().Get("offset") != "10" {
+		t.Errorf("wrong signed URL %+v", signed)
+	}
+
+	// any change of signed parameters invalidates signature
+	for key, val := range map[string]string{SignedURLExpires: "9999999999", SignedURLScope: "write", SignedURLUser: "bob", SignedURLRoles: "admin", "offset": "0"} {
+		tampered, _ := url.Parse(rurl)
+		q := tampered.Query()
+		q.Set(key, val)
+		tampered.RawQuery = q.Encode()
+		if _, err := signer.Verify(tampered); !errors.Is(err, ErrInvalidSignedURL) {
+			t.Errorf("URL with tampered %s is accepted, error %v", key, err)
+		}
+	}
+	moved, _ := url.Parse(strings.Replace(rurl, "scan%201", "scan%202", 1))
+	if _, err := signer.Verify(moved); !errors.Is(err, ErrInvalidSignedURL) {
+		t.Errorf("signature of another path is accepted, error %v", err)
+	}
+
+	// URLs signed before key rotation are accepted
+	old, _ := NewURLSigner(srvConfig.SignedURLs{Secret: "old"})
+	rurl, _, _ = old.Sign("/download/raw/a.h5", "read", user, time.Minute)
+	u, _ = url.Parse(rurl)
+	if _, err := signer.Verify(u); err != nil {
+		t.Errorf("URL signed with old secret is rejected, error %v", err)
+	}
+	other, _ := NewURLSigner(srvConfig.SignedURLs{Secret: "other"})
+	if _, err := other.Verify(u); !errors.Is(err, ErrInvalidSignedURL) {
+		t.Errorf("URL signed with unknown secret is accepted, error %v", err)
+	}
+
+	// expired URL
+	rurl, _, _ = signer.Sign("/download/raw/a.h5", "read", user, time.Second)
+	u, _ = url.Parse(rurl)
+	q := u.Query()
+	q.Set(SignedURLExpires, "1000")
+	q.Set(SignedURLSignature, signer.keys[0].sign(u.EscapedPath(), q))
+	u.RawQuery = q.Encode()
+	if _, err := signer.Verify(u); !errors.Is(err, ErrExpiredSignedURL) {
+		t.Errorf("expired URL is accepted, error %v", err)
+	}
+
+	if _, _, err := signer.Sign("/meta/records", "read", user, 0); !errors.Is(err, ErrSignedURLPath) {
+		t.Errorf("path outside of signed paths is signed, error %v", err)
+	}
+	if _, _, err := signer.Sign("/download/raw/a.h5", "write", user, 0); !errors.Is(err, ErrSignedURLScope) {
+		t.Errorf("scope which is not granted is signed, error %v", err)
+	}
+	anonymous := &Claims{CustomClaims: CustomClaims{User: "anonymous", Kind: AnonymousKind}}
+	if _, _, err := signer.Sign("/download/raw/a.h5", "read", anonymous, 0); err == nil {
+		t.Error("URL is signed for anonymous user")
+	}
+}
+
+// TestSignedURLMiddleware
+func TestSignedURLMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	signer, _ := NewURLSigner(srvConfig.SignedURLs{Secret: "secret"})
+	user := &Claims{CustomClaims: CustomClaims{User: "alice", Scope: "write", Roles: []string{"id3a"}}}
+	r := gin.New()
+	r.POST("/sign", func(c *gin.Context) {
+		c.Set("claims", user)
+		c.Next()
+	}, signer.SignURLHandler("secret"))
+	fallback := func(c *gin.Context) {
+		c.AbortWithStatus(http.StatusTeapot)
+	}
+	r.GET("/download/*path", SignedURLMiddleware(signer, "read", fallback), func(c *gin.Context) {
+		claims := c.MustGet("claims").(*Claims)
+		c.String(http.StatusOK, "%s %s %s", claims.CustomClaims.User, claims.CustomClaims.Kind, c.Param("path"))
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/sign", strings.NewReader(`{"url":"/download/raw/a.h5","expires":60}`)))
+	var resp SignURLResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("wrong sign response %d %s", w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", resp.URL, nil))
+	if w.Code != http.StatusOK || w.Body.String() != "alice signed-url /raw/a.h5" {
+		t.Errorf("wrong response of signed URL %d %s", w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", strings.Replace(resp.URL, "a.h5", "b.h5", 1), nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("signed URL of another file is accepted, code %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/download/raw/a.h5", nil))
+	if w.Code != http.StatusTeapot {
+		t.Errorf("request without signature is not passed to next middleware, code %d", w.Code)
+	}
+}
@@ -17,6 +17,7 @@ import (
 	"strings"
 	"time"
 
+	srvConfig "github.com/CHESSComputing/golib/config"
 	jwt "github.com/golang-jwt/jwt/v4"
 	"github.com/google/uuid"
 )
@@ -34,6 +35,8 @@ type CustomClaims struct {
 	Kind        string   `json:"kind"`
 	Roles       []string `json:"roles"`
 	Application string   `json:"application"`
+	Tenant      string   `json:"tenant,omitempty"`
+	Audience    []string `json:"-"` // token audience, it overwrites configured audience of aud claim
 }
 
 // String provides string representations of Custom claims
@@ -54,13 +57,74 @@ func (c *CustomClaims) String() string {
 	if c.Application != "" {
 		out = append(out, fmt.Sprintf("Application:%s", c.Application))
 	}
+	if c.Tenant != "" {
+		out = append(out, fmt.Sprintf("Tenant:%s", c.Tenant))
+	}
 	return strings.Join(out, ", ")
 }
 
 // Claims defines our JWT claims
 type Claims struct {
 	jwt.RegisteredClaims
-	CustomClaims CustomClaims `json:"custom_claims"`
+	CustomClaims CustomClaims     `json:"custom_claims"`
+	Confirmation *Confirmation    `json:"cnf,omitempty"`       // confirmation of sender-constrained token
+	Actor        *Actor           `json:"act,omitempty"`       // actor of impersonation token
+	AuthTime     *jwt.NumericDate `json:"auth_time,omitempty"` // time of user authentication, it is kept by token refresh
+	AMR          []string         `json:"amr,omitempty"`       // authentication methods of the user (RFC 8176), it is kept by token refresh
+}
+
+// DefaultIssuer defines issuer of tokens if it is not configured
+const DefaultIssuer = "CHESS Authz server"
+
+// DefaultClockSkew defines tolerance of time based claims if it is not
+// configured
+const DefaultClockSkew = 60 * time.Second
+
+// helper function to get issuer, audience and clock skew of Authz
+// configuration
+func tokenConfig() (string, []string, time.Duration) {
+	issuer, skew := DefaultIssuer, DefaultClockSkew
+	if srvConfig.Config == nil {
+		return issuer, nil, skew
+	}
+	cfg := srvConfig.Config.Authz
+	if cfg.Issuer != "" {
+		issuer = cfg.Issuer
+	}
+	if cfg.ClockSkew > 0 {
+		skew = cfg.ClockSkew
+	} else if cfg.ClockSkew < 0 {
+		skew = 0
+	}
+	return issuer, cfg.Audience, skew
+}
+
+// Valid implements jwt.Claims interface, it verifies time based claims
+// with clock skew tolerance, issuer and audience of Authz configuration
+func (c Claims) Valid() error {
+	issuer, audience, skew := tokenConfig()
+	now := time.Now()
+	if c.ExpiresAt != nil && now.After(c.ExpiresAt.Add(skew)) {
+		return fmt.Errorf("%w: expired at %v", jwt.ErrTokenExpired, c.ExpiresAt.Time)
+	}
+	if c.NotBefore != nil && now.Add(skew).Before(c.NotBefore.Time) {
+		return fmt.Errorf("%w: not valid before %v", jwt.ErrTokenNotValidYet, c.NotBefore.Time)
+	}
+	if c.IssuedAt != nil && now.Add(skew).Before(c.IssuedAt.Time) {
+		return fmt.Errorf("%w: issued at %v", jwt.ErrTokenUsedBeforeIssued, c.IssuedAt.Time)
+	}
+	if c.Issuer != issuer {
+		return fmt.Errorf("%w: '%s'", jwt.ErrTokenInvalidIssuer, c.Issuer)
+	}
+	if len(audience) == 0 {
+		return nil
+	}
+	for _, aud := range audience {
+		if c.VerifyAudience(aud, true) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %v", jwt.ErrTokenInvalidAudience, c.Audience)
 }
 
 // Token represents access token structure
@@ -86,7 +150,7 @@ func (t *Token) Validate(clientId string) error {
 		}
 		return err
 	}
-	if !tkn.Valid {
+	if tkn == nil || !tkn.Valid {
 		return errors.New("invalid token")
 	}
 	return nil
@@ -105,48 +169,86 @@ func TokenClaims(accessToken, clientId string) (*Claims, error) {
 			//             log.Println("ERROR", err)
 		}
 	}
-	if !tkn.Valid {
-		err := errors.New("invalid token")
-		return claims, err
-		//         log.Println("ERROR", err)
+	if tkn == nil || !tkn.Valid {
+		if err != nil {
+			return claims, fmt.Errorf("invalid token: %w", err)
+		}
+		return claims, errors.New("invalid token")
 	}
 	return claims, nil
 }
 
-// JWTAccessToken generates JWT access token with custom claims
+// JWTAccessToken generates JWT access token with custom claims, token
+// expires in given number of seconds limited by token lifetime policy, zero
+// value means lifetime of the policy (see TokenLifetimePolicy)
 // https://blog.canopas.com/jwt-in-golang-how-to-implement-token-based-authentication-298c89a26ffd
 func JWTAccessToken(secretKey string, expiresAt int64, customClaims CustomClaims) (string, error) {
-	var sub, aud string
-	if uuid, err := uuid.NewRandom(); err == nil {
-		sub = hex.EncodeToString(uuid[:])
+	return BoundJWTAccessToken(secretKey, expiresAt, customClaims, nil)
+}
+
+// BoundJWTAccessToken generates sender-constrained JWT access token bound
+// to client certificate or DPoP key of given confirmation (see
+// RequestConfirmation), nil confirmation provides bearer token
+func BoundJWTAccessToken(secretKey string, expiresAt int64, customClaims CustomClaims, cnf *Confirmation) (string, error) {
+	claims := newClaims(expiresAt, customClaims)
+	claims.Confirmation = cnf
+	return signedToken(secretKey, claims)
+}
+
+// AuthenticatedJWTAccessToken generates JWT access token with custom claims
+// and authentication methods of the user (amr claim), e.g. pwd and otp
+// (see twofactor module)
+func AuthenticatedJWTAccessToken(secretKey string, expiresAt int64, customClaims CustomClaims, amr []string) (string, error) {
+	claims := newClaims(expiresAt, customClaims)
+	claims.AMR = amr
+	return signedToken(secretKey, claims)
+}
+
+// helper function to create token claims with standard claims expiring in
+// given number of seconds limited by lifetime policy of token client and
+// scope, zero value means lifetime of the policy
+func newClaims(expiresAt int64, customClaims CustomClaims) Claims {
+	issuer, audience, _ := tokenConfig()
+	policy := TokenLifetimePolicy(customClaims.Application, customClaims.Scope)
+	if len(customClaims.Audience) > 0 {
+		audience = customClaims.Audience
 	}
+	var jti string
 	if uuid, err := uuid.NewRandom(); err == nil {
-		aud = hex.EncodeToString(uuid[:])
+		jti = hex.EncodeToString(uuid[:])
 	}
+	now := time.Now()
 	claims := Claims{
 		RegisteredClaims: jwt.RegisteredClaims{
-			Issuer: "CHESS Authz server",
+			// the `iss` (Issuer) claim. See https://datatracker.ietf.org/doc/html/rfc7519#section-4.1.1
+			Issuer: issuer,
+
 			// the `sub` (Subject) claim. See https://datatracker.ietf.org/doc/html/rfc7519#section-4.1.2
-			Subject: sub,
+			Subject: customClaims.User,
 
 			// the `aud` (Audience) claim. See https://datatracker.ietf.org/doc/html/rfc7519#section-4.1.3
-			Audience: jwt.ClaimStrings{aud},
+			Audience: jwt.ClaimStrings(audience),
 
 			// the `exp` (Expiration Time) claim. See https://datatracker.ietf.org/doc/html/rfc7519#section-4.1.4
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Duration(expiresAt) * time.Second)),
+			ExpiresAt: jwt.NewNumericDate(now.Add(policy.Lifetime(expiresAt))),
 
 			// the `nbf` (Not Before) claim. See https://datatracker.ietf.org/doc/html/rfc7519#section-4.1.5
-			//             NotBefore *NumericDate `json:"nbf,omitempty"`
+			NotBefore: jwt.NewNumericDate(now),
 
 			// the `iat` (Issued At) claim. See https://datatracker.ietf.org/doc/html/rfc7519#section-4.1.6
-			IssuedAt: jwt.NewNumericDate(time.Now()),
+			IssuedAt: jwt.NewNumericDate(now),
 
 			// the `jti` (JWT ID) claim. See https://datatracker.ietf.org/doc/html/rfc7519#section-4.1.7
-			//             ID string `json:"jti,omitempty"`
+			ID: jti,
 		},
 		CustomClaims: customClaims,
+		AuthTime:     jwt.NewNumericDate(now),
 	}
+	return claims
+}
 
+// helper function to sign token claims
+func signedToken(secretKey string, claims Claims) (string, error) {
 	// generate a string using claims and HS256 algorithm
 	tokenString := jwt.NewWithClaims(jwt.SigningMethodHS512, claims)
 
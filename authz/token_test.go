@@ -1,7 +1,12 @@
 package auth
 
 import (
+	"errors"
 	"testing"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	jwt "github.com/golang-jwt/jwt/v4"
 )
 
 // TestToken
@@ -26,3 +31,84 @@ func TestToken(t *testing.T) {
 		t.Errorf(err.Error())
 	}
 }
+
+// helper function to sign claims
+func signClaims(t *testing.T, secretKey string, claims Claims) string {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS512, claims).SignedString([]byte(secretKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// TestTokenStandardClaims
+func TestTokenStandardClaims(t *testing.T) {
+	config := srvConfig.Config
+	defer func() { srvConfig.Config = config }()
+	srvConfig.Config = &srvConfig.SrvConfig{}
+	srvConfig.Config.Authz.Issuer = "https://foxden-authz.example.org"
+	srvConfig.Config.Authz.Audience = []string{"foxden", "metadata"}
+	secretKey := "lksjdlfkjsd"
+
+	tokenStr, err := JWTAccessToken(secretKey, 100, CustomClaims{User: "alice", Scope: "read"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := TokenClaims(tokenStr, secretKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Issuer != "https://foxden-authz.example.org" || claims.Subject != "alice" || claims.ID == "" ||
+		len(claims.Audience) != 2 || claims.NotBefore == nil || claims.IssuedAt == nil {
+		t.Errorf("standard claims are not set %+v", claims.RegisteredClaims)
+	}
+	other, _ := JWTAccessToken(secretKey, 100, CustomClaims{User: "alice"})
+	if oclaims, _ := TokenClaims(other, secretKey); oclaims.ID == claims.ID {
+		t.Error("tokens have the same jti")
+	}
+
+	// token specific audience
+	tokenStr, _ = JWTAccessToken(secretKey, 100, CustomClaims{User: "alice", Audience: []string{"metadata"}})
+	if claims, err := TokenClaims(tokenStr, secretKey); err != nil || len(claims.Audience) != 1 {
+		t.Errorf("unexpected audience %v, error %v", claims.Audience, err)
+	}
+	tokenStr, _ = JWTAccessToken(secretKey, 100, CustomClaims{User: "alice", Audience: []string{"discovery"}})
+	token := Token{AccessToken: tokenStr}
+	if err := token.Validate(secretKey); err == nil || !errors.Is(err, jwt.ErrTokenInvalidAudience) {
+		t.Errorf("token with unknown audience is accepted, error %v", err)
+	}
+
+	// issuer
+	now := time.Now()
+	rclaims := jwt.RegisteredClaims{Issuer: "other", Audience: jwt.ClaimStrings{"foxden"}, ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute))}
+	token = Token{AccessToken: signClaims(t, secretKey, Claims{RegisteredClaims: rclaims})}
+	if err := token.Validate(secretKey); err == nil || !errors.Is(err, jwt.ErrTokenInvalidIssuer) {
+		t.Errorf("token of another issuer is accepted, error %v", err)
+	}
+
+	// clock skew
+	rclaims.Issuer = srvConfig.Config.Authz.Issuer
+	for _, tc := range []struct {
+		exp, nbf time.Duration
+		valid    bool
+	}{
+		{-30 * time.Second, -time.Minute, true},
+		{-90 * time.Second, -2 * time.Minute, false},
+		{time.Minute, 30 * time.Second, true},
+		{2 * time.Minute, 90 * time.Second, false},
+	} {
+		rclaims.ExpiresAt = jwt.NewNumericDate(now.Add(tc.exp))
+		rclaims.NotBefore = jwt.NewNumericDate(now.Add(tc.nbf))
+		token = Token{AccessToken: signClaims(t, secretKey, Claims{RegisteredClaims: rclaims})}
+		if err := token.Validate(secretKey); (err == nil) != tc.valid {
+			t.Errorf("exp %v nbf %v: expect valid %v, error %v", tc.exp, tc.nbf, tc.valid, err)
+		}
+	}
+	srvConfig.Config.Authz.ClockSkew = -time.Second
+	rclaims.ExpiresAt = jwt.NewNumericDate(now.Add(-30 * time.Second))
+	rclaims.NotBefore = nil
+	token = Token{AccessToken: signClaims(t, secretKey, Claims{RegisteredClaims: rclaims})}
+	if err := token.Validate(secretKey); err == nil {
+		t.Error("expired token is accepted without clock skew")
+	}
+}
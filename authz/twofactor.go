@@ -0,0 +1,264 @@
+package auth
+
+// twofactor module provides building blocks of two-factor authentication
+// of users: TOTP secrets (RFC 6238) provisioned to authenticator apps via QR
+// codes, WebAuthn relying party of security keys, single use recovery codes
+// and enforcement policy of roles which must use second factor. Tokens
+// issued after second factor carry mfa in amr claim (RFC 8176).
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image/png"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	services "github.com/CHESSComputing/golib/services"
+	utils "github.com/CHESSComputing/golib/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+// methods of second factor
+const (
+	TOTPMethod     = "totp"
+	WebAuthnMethod = "webauthn"
+	RecoveryMethod = "recovery"
+)
+
+// authentication method references of amr claim (RFC 8176)
+const (
+	AMRPassword    = "pwd"
+	AMROTP         = "otp"
+	AMRHardwareKey = "hwk"
+	AMRMultiFactor = "mfa"
+)
+
+// defaults of two-factor authentication
+const (
+	DefaultTwoFactorIssuer  = "FOXDEN"
+	DefaultTOTPSkew         = 1
+	DefaultRecoveryCodes    = 10
+	DefaultTwoFactorExpires = 5 * time.Minute
+)
+
+// DefaultTwoFactorRoles defines roles which must use second factor if
+// RequiredRoles are not configured
+var DefaultTwoFactorRoles = []string{"foxden-admin"}
+
+// errors of two-factor authentication
+var (
+	ErrInvalidTOTP         = errors.New("invalid TOTP code")
+	ErrTwoFactorMissing    = errors.New("two-factor authentication is required")
+	ErrWebAuthnDisabled    = errors.New("WebAuthn is not configured")
+	ErrInvalidRecoveryCode = errors.New("invalid recovery code")
+)
+
+// TwoFactorConfig returns TwoFactor configuration with defaults
+func TwoFactorConfig() srvConfig.TwoFactor {
+	var cfg srvConfig.TwoFactor
+	if srvConfig.Config != nil {
+		cfg = srvConfig.Config.Authz.TwoFactor
+	}
+	if cfg.Issuer == "" {
+		cfg.Issuer = DefaultTwoFactorIssuer
+	}
+	if cfg.Skew == 0 {
+		cfg.Skew = DefaultTOTPSkew
+	}
+	if cfg.RecoveryCodes == 0 {
+		cfg.RecoveryCodes = DefaultRecoveryCodes
+	}
+	if cfg.Expires == 0 {
+		cfg.Expires = DefaultTwoFactorExpires
+	}
+	if cfg.RPName == "" {
+		cfg.RPName = cfg.Issuer
+	}
+	if cfg.RPID != "" && len(cfg.RPOrigins) == 0 {
+		cfg.RPOrigins = []string{"https://" + cfg.RPID}
+	}
+	return cfg
+}
+
+// TwoFactorRoles returns roles which must use second factor, i.e.
+// configured RequiredRoles (DefaultTwoFactorRoles if they are not set) and
+// roles of administrators allowed to impersonate users
+func TwoFactorRoles() []string {
+	roles := append([]string{}, DefaultTwoFactorRoles...)
+	if srvConfig.Config != nil {
+		if r := srvConfig.Config.Authz.TwoFactor.RequiredRoles; len(r) > 0 {
+			roles = append([]string{}, r...)
+		}
+		adminRoles, _ := impersonationConfig()
+		for _, r := range adminRoles {
+			if !utils.InList(r, roles) {
+				roles = append(roles, r)
+			}
+		}
+	}
+	return roles
+}
+
+// RequiresTwoFactor checks if user with given roles must use second factor
+func RequiresTwoFactor(roles []string) bool {
+	required := TwoFactorRoles()
+	for _, r := range roles {
+		if utils.InList(r, required) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasTwoFactor checks if token claims were issued after second factor
+func HasTwoFactor(claims *Claims) bool {
+	return claims != nil && utils.InList(AMRMultiFactor, claims.AMR)
+}
+
+// NewTOTPKey generates TOTP secret of given account, the key provides
+// otpauth URL of authenticator apps (see TOTPQRCode)
+func NewTOTPKey(account string) (*otp.Key, error) {
+	return totp.Generate(totp.GenerateOpts{Issuer: TwoFactorConfig().Issuer, AccountName: account})
+}
+
+// TOTPQRCode returns PNG image of QR code with otpauth URL of the key, it
+// is scanned by authenticator apps
+func TOTPQRCode(key *otp.Key, size int) ([]byte, error) {
+	img, err := key.Image(size, size)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// VerifyTOTP verifies TOTP code of given secret within configured skew of
+// periods, codes of periods up to last used one are rejected to prevent
+// replay. It returns period of the code which should be stored as last
+// used one.
+func VerifyTOTP(secret, code string, last int64) (int64, error) {
+	opts := totp.ValidateOpts{Period: 30, Digits: otp.DigitsSix, Algorithm: otp.AlgorithmSHA1}
+	code = strings.TrimSpace(code)
+	now := time.Now()
+	skew := TwoFactorConfig().Skew
+	for i := -skew; i <= skew; i++ {
+		t := now.Add(time.Duration(i) * 30 * time.Second)
+		period := t.Unix() / 30
+		if period <= last {
+			continue
+		}
+		expect, err := totp.GenerateCodeCustom(secret, t, opts)
+		if err != nil {
+			return 0, err
+		}
+		if subtle.ConstantTimeCompare([]byte(expect), []byte(code)) == 1 {
+			return period, nil
+		}
+	}
+	return 0, ErrInvalidTOTP
+}
+
+// helper function to normalize recovery code entered by user
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// RecoveryCodeHash returns hash of recovery code which is stored instead
+// of the code
+func RecoveryCodeHash(code string) string {
+	hash := sha256.Sum256([]byte("foxden recovery code:" + normalizeRecoveryCode(code)))
+	return hex.EncodeToString(hash[:])
+}
+
+// NewRecoveryCodes generates given number of single use recovery codes, it
+// returns codes shown to the user and their hashes
+func NewRecoveryCodes(n int) ([]string, []string, error) {
+	var codes, hashes []string
+	enc := base32.StdEncoding.WithPadding(base32.NoPadding)
+	for i := 0; i < n; i++ {
+		buf := make([]byte, 7)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(enc.EncodeToString(buf))
+		code = code[:6] + "-" + code[6:]
+		codes = append(codes, code)
+		hashes = append(hashes, RecoveryCodeHash(code))
+	}
+	return codes, hashes, nil
+}
+
+// UseRecoveryCode finds recovery code among hashes of unused codes, it
+// returns remaining hashes
+func UseRecoveryCode(code string, hashes []string) ([]string, error) {
+	hash := RecoveryCodeHash(code)
+	for i, h := range hashes {
+		if subtle.ConstantTimeCompare([]byte(h), []byte(hash)) == 1 {
+			remaining := append([]string{}, hashes[:i]...)
+			return append(remaining, hashes[i+1:]...), nil
+		}
+	}
+	return hashes, ErrInvalidRecoveryCode
+}
+
+// NewWebAuthn returns WebAuthn relying party of TwoFactor configuration
+func NewWebAuthn() (*webauthn.WebAuthn, error) {
+	cfg := TwoFactorConfig()
+	if cfg.RPID == "" {
+		return nil, ErrWebAuthnDisabled
+	}
+	return webauthn.New(&webauthn.Config{
+		RPID:          cfg.RPID,
+		RPDisplayName: cfg.RPName,
+		RPOrigins:     cfg.RPOrigins,
+	})
+}
+
+// TwoFactorMiddleware rejects requests of users whose roles require second
+// factor if their tokens were issued without it. It should follow token
+// validation middleware, e.g. on administrative routes, and requests
+// without claims of token middleware are rejected. Requests of peer
+// services and signed URLs are not affected.
+func TwoFactorMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var claims *Claims
+		if val, ok := c.Get("claims"); ok {
+			claims, _ = val.(*Claims)
+		}
+		if claims == nil {
+			err := errors.New("request is not authorized by token middleware")
+			log.Printf("ERROR: TwoFactorMiddleware: %s %s, error %v", c.Request.Method, c.Request.URL.Path, err)
+			rec := services.Response("authz", http.StatusUnauthorized, services.TokenError, err)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, rec)
+			return
+		}
+		if IsService(claims) || claims.CustomClaims.Kind == SignedURLKind {
+			c.Next()
+			return
+		}
+		if RequiresTwoFactor(claims.CustomClaims.Roles) && !HasTwoFactor(claims) {
+			err := fmt.Errorf("%w: roles %v", ErrTwoFactorMissing, claims.CustomClaims.Roles)
+			log.Printf("ERROR: TwoFactorMiddleware: user %s, error %v", claims.CustomClaims.User, err)
+			rec := services.Response("authz", http.StatusForbidden, services.CredentialsError, err)
+			c.AbortWithStatusJSON(http.StatusForbidden, rec)
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	"github.com/gin-gonic/gin"
+	"github.com/pquerna/otp/totp"
+)
+
+// TestTOTP
+func TestTOTP(t *testing.T) {
+	key, err := NewTOTPKey("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.Issuer() != DefaultTwoFactorIssuer || key.AccountName() != "alice" {
+		t.Errorf("wrong TOTP key %s", key.URL())
+	}
+	code, _ := totp.GenerateCode(key.Secret(), time.Now())
+	period, err := VerifyTOTP(key.Secret(), code, 0)
+	if err != nil || period < time.Now().Unix()/30-1 {
+		t.Fatalf("valid code is rejected, period %d error %v", period, err)
+	}
+	if _, err := VerifyTOTP(key.Secret(), code, period); !errors.Is(err, ErrInvalidTOTP) {
+		t.Errorf("replayed code is accepted, error %v", err)
+	}
+	if old, _ := totp.GenerateCode(key.Secret(), time.Now().Add(-10*time.Minute)); old != code {
+		if _, err := VerifyTOTP(key.Secret(), old, 0); !errors.Is(err, ErrInvalidTOTP) {
+			t.Errorf("code outside of skew is accepted, error %v", err)
+		}
+	}
+	img, err := TOTPQRCode(key, 128)
+	if err != nil || !bytes.HasPrefix(img, []byte("\x89PNG")) {
+		t.Errorf("wrong QR code image, error %v", err)
+	}
+}
+
+// TestRecoveryCodes
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes, err := NewRecoveryCodes(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != 3 || len(hashes) != 3 || len(codes[0]) != 13 || codes[0][6] != '-' {
+		t.Fatalf("wrong recovery codes %v", codes)
+	}
+	code := strings.ToUpper(strings.Replace(codes[1], "-", " ", 1))
+	remaining, err := UseRecoveryCode(code, hashes)
+	if err != nil || len(remaining) != 2 || remaining[1] != hashes[2] {
+		t.Errorf("recovery code is not used, remaining %v error %v", remaining, err)
+	}
+	if _, err := UseRecoveryCode(codes[1], remaining); !errors.Is(err, ErrInvalidRecoveryCode) {
+		t.Errorf("used recovery code is accepted, error %v", err)
+	}
+}
+
+// TestTwoFactorMiddleware
+func TestTwoFactorMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	config := srvConfig.Config
+	t.Cleanup(func() { srvConfig.Config = config })
+	srvConfig.Config = &srvConfig.SrvConfig{}
+	srvConfig.Config.Authz.TwoFactor.RequiredRoles = []string{"admin"}
+	srvConfig.Config.Authz.Impersonation.AdminRoles = []string{"support"}
+	if !RequiresTwoFactor([]string{"staff", "support"}) || RequiresTwoFactor([]string{"foxden-admin"}) {
+		t.Errorf("wrong roles requiring second factor %v", TwoFactorRoles())
+	}
+
+	var claims *Claims
+	r := gin.New()
+	r.GET("/admin", func(c *gin.Context) {
+		c.Set("claims", claims)
+		c.Next()
+	}, TwoFactorMiddleware(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	for _, tc := range []struct {
+		roles []string
+		amr   []string
+		code  int
+	}{
+		{[]string{"admin"}, []string{AMRPassword}, http.StatusForbidden},
+		{[]string{"admin"}, []string{AMRPassword, AMROTP, AMRMultiFactor}, http.StatusOK},
+		{[]string{"staff"}, []string{AMRPassword}, http.StatusOK},
+	} {
+		claims = &Claims{CustomClaims: CustomClaims{User: "alice", Roles: tc.roles}, AMR: tc.amr}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/admin", nil))
+		if w.Code != tc.code {
+			t.Errorf("wrong response code %d of roles %v and amr %v", w.Code, tc.roles, tc.amr)
+		}
+	}
+
+	// claims are provided by token middleware
+	srvConfig.Config.Authz.ClientID = "secret"
+	r.GET("/token", TokenMiddleware("secret", 0), TwoFactorMiddleware(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	r.GET("/nothing", TwoFactorMiddleware(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	custom := CustomClaims{User: "alice", Roles: []string{"admin"}}
+	pwd, _ := AuthenticatedJWTAccessToken("secret", 60, custom, []string{AMRPassword})
+	mfa, _ := AuthenticatedJWTAccessToken("secret", 60, custom, []string{AMRPassword, AMROTP, AMRMultiFactor})
+	for _, tc := range []struct {
+		path, token string
+		code        int
+	}{
+		{"/token", pwd, http.StatusForbidden},
+		{"/token", mfa, http.StatusOK},
+		{"/nothing", mfa, http.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest("GET", tc.path, nil)
+		req.Header.Set("Authorization", "Bearer "+tc.token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tc.code {
+			t.Errorf("wrong response code %d of %s", w.Code, tc.path)
+		}
+	}
+}
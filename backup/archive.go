@@ -0,0 +1,289 @@
+package backup
+
+// archive module provides encryption of archive streams and local or S3
+// locations of archives. Encrypted archives start with magic header and
+// consist of AES-GCM sealed chunks, nonce of every chunk contains its
+// sequence number and flag of the last chunk, therefore reordered or
+// truncated archives are detected.
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	s3 "github.com/CHESSComputing/golib/storage/s3"
+)
+
+// magic defines header of encrypted archives
+var magic = []byte("FOXDENBK")
+
+// size of plain text chunks of encrypted archives
+const chunkSize = 64 * 1024
+
+// helper function to provide AEAD cipher of archive secret
+func archiveCipher(secret string) (cipher.AEAD, error) {
+	key := sha256.Sum256([]byte("foxden backup key:" + secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// helper function to provide nonce of chunk with given sequence number
+func chunkNonce(aead cipher.AEAD, prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[len(prefix):], counter)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+// encryptWriter encrypts stream in chunks
+type encryptWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	header  []byte // archive header authenticated by every chunk
+	prefix  []byte // random nonce prefix of the archive
+	counter uint32
+	buf     []byte
+}
+
+// helper function to create encrypting writer, it writes archive header
+func newEncryptWriter(w io.Writer, secret string) (*encryptWriter, error) {
+	aead, err := archiveCipher(secret)
+	if err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, aead.NonceSize()-5)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+	header := append(append(append([]byte{}, magic...), archiveVersion), prefix...)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &encryptWriter{w: w, aead: aead, header: header, prefix: prefix, buf: make([]byte, 0, chunkSize)}, nil
+}
+
+// helper function to seal buffered chunk
+func (e *encryptWriter) seal(last bool) error {
+	out := e.aead.Seal(nil, chunkNonce(e.aead, e.prefix, e.counter, last), e.buf, e.header)
+	e.counter++
+	e.buf = e.buf[:0]
+	_, err := e.w.Write(out)
+	return err
+}
+
+// Write implements io.Writer interface, full chunk is sealed when next data
+// arrives since the last chunk is sealed by Close
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		if len(e.buf) == chunkSize {
+			if err := e.seal(false); err != nil {
+				return n, err
+			}
+		}
+		k := min(chunkSize-len(e.buf), len(p))
+		e.buf = append(e.buf, p[:k]...)
+		p = p[k:]
+		n += k
+	}
+	return n, nil
+}
+
+// Close seals the last chunk, underlying writer is not closed
+func (e *encryptWriter) Close() error {
+	return e.seal(true)
+}
+
+// decryptReader decrypts stream of encryptWriter
+type decryptReader struct {
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	header  []byte
+	prefix  []byte
+	counter uint32
+	chunk   []byte
+	buf     []byte
+	done    bool
+}
+
+// helper function to create decrypting reader, archive header should be
+// available in the reader
+func newDecryptReader(r *bufio.Reader, secret string) (*decryptReader, error) {
+	aead, err := archiveCipher(secret)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, len(magic)+1+aead.NonceSize()-5)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorrupted, err)
+	}
+	if !bytes.HasPrefix(header, magic) || header[len(magic)] != archiveVersion {
+		return nil, fmt.Errorf("%w: unsupported archive header", ErrCorrupted)
+	}
+	return &decryptReader{
+		r:      r,
+		aead:   aead,
+		header: header,
+		prefix: header[len(magic)+1:],
+		chunk:  make([]byte, chunkSize+aead.Overhead()),
+	}, nil
+}
+
+// helper function to read and open next chunk
+func (d *decryptReader) open() error {
+	n, err := io.ReadFull(d.r, d.chunk)
+	last := false
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		last = true
+	} else if err != nil {
+		return err
+	} else if _, err := d.r.Peek(1); errors.Is(err, io.EOF) {
+		last = true
+	} else if err != nil {
+		return err
+	}
+	plain, err := d.aead.Open(d.chunk[:0], chunkNonce(d.aead, d.prefix, d.counter, last), d.chunk[:n], d.header)
+	if err != nil {
+		return fmt.Errorf("%w: unable to decrypt chunk %d, wrong secret or modified archive", ErrCorrupted, d.counter)
+	}
+	d.counter++
+	d.buf = plain
+	d.done = last
+	return nil
+}
+
+// Read implements io.Reader interface
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
+
+// helper function to split s3://bucket/key location
+func s3Location(location string) (string, string, bool) {
+	path, ok := strings.CutPrefix(location, "s3://")
+	if !ok {
+		return "", "", false
+	}
+	bucket, key, _ := strings.Cut(path, "/")
+	return bucket, key, true
+}
+
+// ArchiveLocation returns location of archive with given name within base
+// location, i.e. local directory or s3://bucket/prefix
+func ArchiveLocation(base, name string) string {
+	if _, _, ok := s3Location(base); ok {
+		return strings.TrimSuffix(base, "/") + "/" + name
+	}
+	return filepath.Join(base, name)
+}
+
+// WriteArchive creates archive at given location, i.e. local file or
+// s3://bucket/key object of S3 client, and writes its content via given
+// function. Partial archive is removed if function fails.
+func WriteArchive(ctx context.Context, location string, client *s3.Client, fn func(w io.Writer) error) error {
+	if bucket, key, ok := s3Location(location); ok {
+		if client == nil {
+			return fmt.Errorf("S3 store of archive %s is not configured", location)
+		}
+		pr, pw := io.Pipe()
+		done := make(chan error, 1)
+		go func() {
+			err := client.PutObject(ctx, bucket, key, pr, "application/octet-stream")
+			pr.CloseWithError(err)
+			done <- err
+		}()
+		if err := fn(pw); err != nil {
+			pw.CloseWithError(err)
+			<-done
+			return err
+		}
+		pw.Close()
+		if err := <-done; err != nil {
+			log.Printf("ERROR: unable to upload archive %s, error %v", location, err)
+			return err
+		}
+		return nil
+	}
+	if dir := filepath.Dir(location); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	// archive is written into temporary file which is renamed on success
+	tmp := location + ".part"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	err = fn(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, location)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// ReadArchive opens archive at given location, i.e. local file or
+// s3://bucket/key object of S3 client, and reads its content via given
+// function
+func ReadArchive(ctx context.Context, location string, client *s3.Client, fn func(r io.Reader) error) error {
+	var rc io.ReadCloser
+	if bucket, key, ok := s3Location(location); ok {
+		if client == nil {
+			return fmt.Errorf("S3 store of archive %s is not configured", location)
+		}
+		obj, err := client.GetObject(ctx, bucket, key)
+		if err != nil {
+			return err
+		}
+		rc = obj
+	} else {
+		file, err := os.Open(location)
+		if err != nil {
+			return err
+		}
+		rc = file
+	}
+	defer rc.Close()
+	return fn(rc)
+}
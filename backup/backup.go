@@ -0,0 +1,345 @@
+package backup
+
+// backup module provides backups of collections of document stores, e.g.
+// metadata records, users and API keys. Archives are gzip compressed JSON
+// lines: manifest of the backup, records of collections in MongoDB
+// extended JSON and trailer with number of records and checksum of the
+// archive, which is verified on restore. Archives are optionally encrypted
+// and incremental backups contain only records modified since given time.
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"reflect"
+	"strings"
+	"time"
+
+	storage "github.com/CHESSComputing/golib/storage"
+	bson "go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+// version of archive format
+const archiveVersion = 1
+
+// DefaultBatchSize defines number of records read or written at once
+var DefaultBatchSize = 1000
+
+// errors of backup module
+var (
+	ErrCorrupted = errors.New("backup archive is corrupted")
+	ErrEncrypted = errors.New("backup archive is encrypted, secret is required")
+)
+
+// Options represents options of backups and restores
+type Options struct {
+	Collections   []string          // collections of backup, all collections of archive are restored if empty
+	TimestampKeys map[string]string // modification timestamp keys of collections, unix seconds or dates with :time suffix
+	Since         time.Time         // incremental backup of records modified since given time
+	Secret        string            // secret of archive encryption, archive is not encrypted if empty
+	BatchSize     int               // number of records read or written at once
+	Drop          bool              // remove records of collections before restore of full backup
+}
+
+// helper function to provide batch size of options
+func (o Options) batchSize() int {
+	if o.BatchSize > 0 {
+		return o.BatchSize
+	}
+	return DefaultBatchSize
+}
+
+// Manifest represents manifest of backup archive
+type Manifest struct {
+	Version     int              `json:"version"`
+	Created     time.Time        `json:"created"`           // start of backup, since time of next incremental backup
+	Since       *time.Time       `json:"since,omitempty"`   // records modified since given time
+	Collections []string         `json:"collections"`       // collections of backup
+	Encrypted   bool             `json:"encrypted"`         // archive is encrypted
+	Records     map[string]int64 `json:"records,omitempty"` // number of records of collections, provided by trailer
+	SHA256      string           `json:"sha256,omitempty"`  // checksum of archive content, provided by trailer
+}
+
+// Incremental reports whether archive contains incremental backup
+func (m Manifest) Incremental() bool {
+	return m.Since != nil
+}
+
+// entry represents line of archive
+type entry struct {
+	Manifest   *Manifest       `json:"manifest,omitempty"`
+	Collection string          `json:"collection,omitempty"`
+	Record     json.RawMessage `json:"record,omitempty"`
+	Trailer    *Manifest       `json:"trailer,omitempty"`
+}
+
+// registry decodes records of archive into plain Go types used by all
+// document stores
+var registry = func() *bsoncodec.Registry {
+	reg := bson.NewRegistry()
+	reg.RegisterTypeMapEntry(bsontype.EmbeddedDocument, reflect.TypeOf(map[string]any{}))
+	reg.RegisterTypeMapEntry(bsontype.Array, reflect.TypeOf([]any{}))
+	reg.RegisterTypeMapEntry(bsontype.DateTime, reflect.TypeOf(time.Time{}))
+	return reg
+}()
+
+// helper function to provide spec of records of collection modified since
+// time of the options
+func sinceSpec(collection string, opts Options) map[string]any {
+	key, ok := opts.TimestampKeys[collection]
+	if opts.Since.IsZero() || !ok {
+		return map[string]any{}
+	}
+	field, kind, _ := strings.Cut(key, ":")
+	if kind == "time" {
+		return map[string]any{field: map[string]any{"$gte": opts.Since}}
+	}
+	return map[string]any{field: map[string]any{"$gte": opts.Since.Unix()}}
+}
+
+// Backup writes records of collections of the store into archive. Records
+// of collections without timestamp key are fully written by incremental
+// backups. Backup is not a point-in-time snapshot, records modified during
+// backup may be written in either state.
+func Backup(ctx context.Context, store storage.Store, w io.Writer, opts Options) (Manifest, error) {
+	manifest := Manifest{
+		Version:     archiveVersion,
+		Created:     time.Now().UTC(),
+		Collections: opts.Collections,
+		Encrypted:   opts.Secret != "",
+	}
+	if len(opts.Collections) == 0 {
+		return manifest, errors.New("no collections to backup")
+	}
+	if !opts.Since.IsZero() {
+		since := opts.Since.UTC()
+		manifest.Since = &since
+	}
+	var enc *encryptWriter
+	out := w
+	if opts.Secret != "" {
+		var err error
+		if enc, err = newEncryptWriter(w, opts.Secret); err != nil {
+			return manifest, err
+		}
+		out = enc
+	}
+	zw := gzip.NewWriter(out)
+	hash := sha256.New()
+	bw := bufio.NewWriter(io.MultiWriter(zw, hash))
+	jw := json.NewEncoder(bw)
+	if err := jw.Encode(entry{Manifest: &manifest}); err != nil {
+		return manifest, err
+	}
+	batch := opts.batchSize()
+	records := make(map[string]int64)
+	for _, coll := range opts.Collections {
+		spec := sinceSpec(coll, opts)
+		records[coll] = 0
+		for skip := 0; ; skip += batch {
+			recs, err := store.Find(ctx, coll, spec, &storage.FindOptions{Skip: skip, Limit: batch, Sort: []string{"_id"}})
+			if err != nil {
+				log.Printf("ERROR: unable to read records of %s, error %v", coll, err)
+				return manifest, err
+			}
+			for _, rec := range recs {
+				data, err := bson.MarshalExtJSON(rec, true, false)
+				if err != nil {
+					return manifest, fmt.Errorf("unable to encode record of %s, error %w", coll, err)
+				}
+				if err := jw.Encode(entry{Collection: coll, Record: data}); err != nil {
+					return manifest, err
+				}
+				records[coll]++
+			}
+			if len(recs) < batch {
+				break
+			}
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return manifest, err
+	}
+	manifest.Records = records
+	manifest.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	if err := json.NewEncoder(zw).Encode(entry{Trailer: &manifest}); err != nil {
+		return manifest, err
+	}
+	if err := zw.Close(); err != nil {
+		return manifest, err
+	}
+	if enc != nil {
+		if err := enc.Close(); err != nil {
+			return manifest, err
+		}
+	}
+	return manifest, nil
+}
+
+// helper function to read archive, record function is called for every
+// record and archive is verified against its trailer at the end
+func readArchive(r io.Reader, secret string, header func(m Manifest) error, record func(coll string, rec map[string]any) error) (Manifest, error) {
+	var manifest Manifest
+	br := bufio.NewReader(r)
+	var in io.Reader = br
+	if prefix, _ := br.Peek(len(magic)); string(prefix) == string(magic) {
+		if secret == "" {
+			return manifest, ErrEncrypted
+		}
+		dr, err := newDecryptReader(br, secret)
+		if err != nil {
+			return manifest, err
+		}
+		in = dr
+	}
+	zr, err := gzip.NewReader(in)
+	if err != nil {
+		return manifest, fmt.Errorf("%w: %v", ErrCorrupted, err)
+	}
+	lines := bufio.NewReader(zr)
+	hash := sha256.New()
+	records := make(map[string]int64)
+	for n := 0; ; n++ {
+		line, err := lines.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			return manifest, fmt.Errorf("%w: archive is truncated", ErrCorrupted)
+		} else if err != nil {
+			return manifest, fmt.Errorf("%w: %v", ErrCorrupted, err)
+		}
+		var e entry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return manifest, fmt.Errorf("%w: invalid line %d, error %v", ErrCorrupted, n+1, err)
+		}
+		if n == 0 {
+			if e.Manifest == nil || e.Manifest.Version != archiveVersion {
+				return manifest, fmt.Errorf("%w: unsupported archive manifest", ErrCorrupted)
+			}
+			manifest = *e.Manifest
+			hash.Write(line)
+			if header != nil {
+				if err := header(manifest); err != nil {
+					return manifest, err
+				}
+			}
+			continue
+		}
+		if e.Trailer != nil {
+			if sum := hex.EncodeToString(hash.Sum(nil)); sum != e.Trailer.SHA256 {
+				return manifest, fmt.Errorf("%w: checksum %s, expected %s", ErrCorrupted, sum, e.Trailer.SHA256)
+			}
+			for coll, count := range e.Trailer.Records {
+				if records[coll] != count {
+					return manifest, fmt.Errorf("%w: %d records of %s, expected %d", ErrCorrupted, records[coll], coll, count)
+				}
+			}
+			if _, err := lines.ReadByte(); !errors.Is(err, io.EOF) {
+				return manifest, fmt.Errorf("%w: data after trailer", ErrCorrupted)
+			}
+			manifest.Records, manifest.SHA256 = e.Trailer.Records, e.Trailer.SHA256
+			return manifest, nil
+		}
+		hash.Write(line)
+		if e.Collection == "" || e.Record == nil {
+			return manifest, fmt.Errorf("%w: invalid line %d", ErrCorrupted, n+1)
+		}
+		var rec map[string]any
+		if err := bson.UnmarshalExtJSONWithRegistry(registry, e.Record, true, &rec); err != nil {
+			return manifest, fmt.Errorf("%w: invalid record at line %d, error %v", ErrCorrupted, n+1, err)
+		}
+		records[e.Collection]++
+		if record != nil {
+			if err := record(e.Collection, rec); err != nil {
+				return manifest, err
+			}
+		}
+	}
+}
+
+// Verify reads the whole archive and verifies its integrity, i.e.
+// encryption, format of records, number of records and checksum
+func Verify(r io.Reader, secret string) (Manifest, error) {
+	return readArchive(r, secret, nil, nil)
+}
+
+// Restore writes records of archive into the store, records of archive
+// replace records of the store with the same _id. Records are written
+// while archive is read, therefore archive should be verified via Verify
+// beforehand. Records of all collections of archive are restored unless
+// collections are given in options.
+func Restore(ctx context.Context, store storage.Store, r io.Reader, opts Options) (Manifest, error) {
+	selected := make(map[string]bool)
+	for _, coll := range opts.Collections {
+		selected[coll] = true
+	}
+	batch := opts.batchSize()
+	var pending []map[string]any
+	var pendingColl string
+	flush := func() error {
+		if len(pending) == 0 {
+			return nil
+		}
+		coll, recs := pendingColl, pending
+		pending = nil
+		err := storage.WithTransaction(ctx, store, func(ctx context.Context) error {
+			if !opts.Drop {
+				for _, rec := range recs {
+					if id, ok := rec["_id"]; ok {
+						if _, err := store.Remove(ctx, coll, map[string]any{"_id": id}); err != nil {
+							return err
+						}
+					}
+				}
+			}
+			return store.Insert(ctx, coll, recs...)
+		})
+		if err != nil {
+			log.Printf("ERROR: unable to restore records of %s, error %v", coll, err)
+		}
+		return err
+	}
+	header := func(m Manifest) error {
+		if !opts.Drop {
+			return nil
+		}
+		if m.Incremental() {
+			return errors.New("collections can not be dropped by restore of incremental backup")
+		}
+		for _, coll := range m.Collections {
+			if len(selected) > 0 && !selected[coll] {
+				continue
+			}
+			if _, err := store.Remove(ctx, coll, map[string]any{}); err != nil {
+				log.Printf("ERROR: unable to remove records of %s, error %v", coll, err)
+				return err
+			}
+		}
+		return nil
+	}
+	record := func(coll string, rec map[string]any) error {
+		if len(selected) > 0 && !selected[coll] {
+			return nil
+		}
+		if coll != pendingColl || len(pending) >= batch {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		pendingColl = coll
+		pending = append(pending, rec)
+		return nil
+	}
+	manifest, err := readArchive(r, opts.Secret, header, record)
+	if err != nil {
+		return manifest, err
+	}
+	return manifest, flush()
+}
@@ -0,0 +1,188 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	storage "github.com/CHESSComputing/golib/storage"
+)
+
+// helper function to create store with test records
+func testStore(t *testing.T, now time.Time) *storage.MemoryStore {
+	store := storage.NewMemoryStore()
+	ctx := context.Background()
+	err := store.Insert(ctx, "meta",
+		map[string]any{"_id": "1", "did": "/beamline=3a/btr=1", "date": now.Add(-2 * time.Hour).Unix(), "tags": []any{"a", "b"}},
+		map[string]any{"_id": "2", "did": "/beamline=3a/btr=2", "date": now.Unix(), "sample": map[string]any{"name": "Fe"}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Insert(ctx, "jobs",
+		map[string]any{"_id": "j1", "updated": now.Add(-time.Hour).UTC().Truncate(time.Millisecond)},
+		map[string]any{"_id": "j2", "updated": now.UTC().Truncate(time.Millisecond)},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+// TestBackupRestore
+func TestBackupRestore(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	store := testStore(t, now)
+	for _, secret := range []string{"", "secret"} {
+		var buf bytes.Buffer
+		opts := Options{Collections: []string{"meta", "jobs"}, Secret: secret, BatchSize: 1}
+		manifest, err := Backup(ctx, store, &buf, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if manifest.Records["meta"] != 2 || manifest.Records["jobs"] != 2 || manifest.Encrypted != (secret != "") {
+			t.Errorf("wrong manifest %+v", manifest)
+		}
+		archive := buf.Bytes()
+		if _, err := Verify(bytes.NewReader(archive), secret); err != nil {
+			t.Fatal(err)
+		}
+
+		// restore replaces existing records
+		target := storage.NewMemoryStore()
+		target.Insert(ctx, "meta", map[string]any{"_id": "1", "did": "old"})
+		if _, err := Restore(ctx, target, bytes.NewReader(archive), Options{Secret: secret}); err != nil {
+			t.Fatal(err)
+		}
+		recs, _ := target.Find(ctx, "meta", map[string]any{}, &storage.FindOptions{Sort: []string{"_id"}})
+		if len(recs) != 2 || recs[0]["did"] != "/beamline=3a/btr=1" || recs[0]["date"] != now.Add(-2*time.Hour).Unix() {
+			t.Errorf("wrong restored records %v", recs)
+		}
+		if sample, ok := recs[1]["sample"].(map[string]any); !ok || sample["name"] != "Fe" {
+			t.Errorf("wrong restored record %v", recs[1])
+		}
+		job, _ := storage.FindOne(ctx, target, "jobs", map[string]any{"_id": "j2"})
+		if updated, ok := job["updated"].(time.Time); !ok || !updated.Equal(now.UTC().Truncate(time.Millisecond)) {
+			t.Errorf("wrong restored job %v", job)
+		}
+
+		// modified archive is rejected
+		modified := bytes.Clone(archive)
+		modified[len(modified)/2] ^= 0xff
+		if _, err := Verify(bytes.NewReader(modified), secret); !errors.Is(err, ErrCorrupted) {
+			t.Errorf("modified archive is verified, error %v", err)
+		}
+		if _, err := Verify(bytes.NewReader(archive[:len(archive)-8]), secret); !errors.Is(err, ErrCorrupted) {
+			t.Errorf("truncated archive is verified, error %v", err)
+		}
+	}
+}
+
+// TestIncrementalBackup
+func TestIncrementalBackup(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	store := testStore(t, now)
+	var buf bytes.Buffer
+	opts := Options{
+		Collections:   []string{"meta", "jobs"},
+		TimestampKeys: map[string]string{"meta": "date", "jobs": "updated:time"},
+		Since:         now.Add(-90 * time.Minute),
+		Secret:        "secret",
+	}
+	manifest, err := Backup(ctx, store, &buf, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !manifest.Incremental() || manifest.Records["meta"] != 1 || manifest.Records["jobs"] != 2 {
+		t.Errorf("wrong manifest %+v", manifest)
+	}
+	if _, err := Verify(bytes.NewReader(buf.Bytes()), ""); !errors.Is(err, ErrEncrypted) {
+		t.Errorf("encrypted archive is verified without secret, error %v", err)
+	}
+	if _, err := Verify(bytes.NewReader(buf.Bytes()), "wrong"); !errors.Is(err, ErrCorrupted) {
+		t.Errorf("encrypted archive is verified with wrong secret, error %v", err)
+	}
+	_, err = Restore(ctx, storage.NewMemoryStore(), bytes.NewReader(buf.Bytes()), Options{Secret: "secret", Drop: true})
+	if err == nil {
+		t.Error("incremental backup is restored into dropped collections")
+	}
+}
+
+// TestArchiveLocation
+func TestArchiveLocation(t *testing.T) {
+	if loc := ArchiveLocation("s3://backups/foxden/", "a.backup"); loc != "s3://backups/foxden/a.backup" {
+		t.Errorf("wrong S3 location %s", loc)
+	}
+	ctx := context.Background()
+	location := ArchiveLocation(t.TempDir(), "sub/a.backup")
+	failure := errors.New("failure")
+	err := WriteArchive(ctx, location, nil, func(w io.Writer) error {
+		w.Write([]byte("partial"))
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Errorf("wrong error %v", err)
+	}
+	if files, _ := os.ReadDir(filepath.Dir(location)); len(files) != 0 {
+		t.Errorf("partial archive is kept %v", files)
+	}
+	err = WriteArchive(ctx, location, nil, func(w io.Writer) error {
+		_, err := w.Write([]byte("archive"))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data []byte
+	err = ReadArchive(ctx, location, nil, func(r io.Reader) error {
+		data, err = io.ReadAll(r)
+		return err
+	})
+	if err != nil || string(data) != "archive" {
+		t.Errorf("wrong archive %q, error %v", data, err)
+	}
+	if err := ReadArchive(ctx, "s3://backups/a.backup", nil, nil); err == nil || !strings.Contains(err.Error(), "not configured") {
+		t.Errorf("wrong error %v", err)
+	}
+}
+
+// TestEncryption
+func TestEncryption(t *testing.T) {
+	for _, size := range []int{0, chunkSize, 2*chunkSize + 10} {
+		data := bytes.Repeat([]byte("x"), size)
+		var buf bytes.Buffer
+		w, err := newEncryptWriter(&buf, "secret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data[:size/3])
+		w.Write(data[size/3:])
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		archive := buf.Bytes()
+		r, err := newDecryptReader(bufio.NewReader(bytes.NewReader(archive)), "secret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out, err := io.ReadAll(r); err != nil || !bytes.Equal(out, data) {
+			t.Errorf("wrong decrypted data of size %d, error %v", size, err)
+		}
+		// archive without the last chunk is rejected
+		if size > chunkSize {
+			r, _ := newDecryptReader(bufio.NewReader(bytes.NewReader(archive[:len(archive)-26])), "secret")
+			if _, err := io.ReadAll(r); !errors.Is(err, ErrCorrupted) {
+				t.Errorf("truncated archive is decrypted, error %v", err)
+			}
+		}
+	}
+}
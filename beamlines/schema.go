@@ -33,6 +33,9 @@ var _schemaKeys SchemaKeys
 // SchemaRenewInterval setup interal to update schema cache
 var SchemaRenewInterval time.Duration
 
+// SchemaReloads holds statistics of schema loads and renewals
+var SchemaReloads srvConfig.ReloadStats
+
 // SchemaObject holds current MetaData schema
 type SchemaObject struct {
 	Schema   *Schema
@@ -68,6 +71,7 @@ func (m *SchemaManager) Load(fname string) (*Schema, error) {
 	}
 	schema := &Schema{FileName: fname, Verbose: m.Verbose}
 	err := schema.Load()
+	SchemaReloads.Record(err)
 	if err != nil {
 		log.Println("unable to load schema from", fname, " error", err)
 		return schema, err
@@ -98,8 +102,22 @@ type SchemaRecord struct {
 	Value       any    `json:"value"`
 	Placeholder string `json:"placeholder"`
 	Description string `json:"description"`
+	Unit        string `json:"unit"`       // canonical unit of unit-aware field, e.g. eV
+	Vocabulary  string `json:"vocabulary"` // controlled vocabulary of field values
+	Sensitive   bool   `json:"sensitive"`  // field is encrypted at rest, e.g. PII
+	Searchable  bool   `json:"searchable"` // sensitive field is deterministically encrypted to allow equality queries
+	Index       bool   `json:"index"`      // field is indexed in MongoDB collection of metadata
+}
+
+// TermValidator represents controlled vocabularies of schema fields
+type TermValidator interface {
+	ValidateTerm(vocabulary string, value any) error
 }
 
+// Vocabularies holds controlled vocabularies used to validate schema fields
+// with vocabulary, e.g. vocab.Vocabularies
+var Vocabularies TermValidator
+
 // Schema provides structure of schema file
 type Schema struct {
 	FileName       string                  `json:"fileName`
@@ -162,6 +180,16 @@ func (s *Schema) Load() error {
 					smap.Description = v.(string)
 				} else if k == "placeholder" {
 					smap.Placeholder = v.(string)
+				} else if k == "unit" {
+					smap.Unit = v.(string)
+				} else if k == "vocabulary" {
+					smap.Vocabulary = v.(string)
+				} else if k == "sensitive" {
+					smap.Sensitive = v.(bool)
+				} else if k == "searchable" {
+					smap.Searchable = v.(bool)
+				} else if k == "index" {
+					smap.Index = v.(bool)
 				}
 			}
 			records = append(records, smap)
@@ -174,6 +202,13 @@ func (s *Schema) Load() error {
 	s.FileName = fname
 	smap := make(map[string]SchemaRecord)
 	for _, r := range records {
+		if r.Unit != "" {
+			if _, _, ok := lookupUnit(r.Unit); !ok {
+				msg := fmt.Sprintf("unknown unit '%s' of key %s in schema file %s", r.Unit, r.Key, fname)
+				log.Printf("ERROR: %s", msg)
+				return errors.New(msg)
+			}
+		}
 		smap[r.Key] = r
 	}
 	// update schema map
@@ -263,6 +298,16 @@ func (s *Schema) Validate(rec map[string]any) error {
 				log.Printf("ERROR: %s", msg)
 				return errors.New(msg)
 			}
+			// convert quantity of unit-aware field to canonical unit
+			if m.Unit != "" {
+				cv, err := canonicalValue(m, v)
+				if err != nil {
+					msg := fmt.Sprintf("invalid quantity for key=%s, value=%v, unit=%s, error=%v", k, v, m.Unit, err)
+					log.Printf("ERROR: %s", msg)
+					return errors.New(msg)
+				}
+				v = cv
+			}
 			// check data type
 			if !validSchemaType(m.Type, v, s.Verbose) {
 				// check if provided data type can be converted to m.Type
@@ -277,6 +322,19 @@ func (s *Schema) Validate(rec map[string]any) error {
 				log.Printf("ERROR: %s", msg)
 				return errors.New(msg)
 			}
+			// check controlled vocabulary terms
+			if m.Vocabulary != "" {
+				if Vocabularies == nil {
+					msg := fmt.Sprintf("vocabulary %s of key=%s is not configured", m.Vocabulary, k)
+					log.Printf("ERROR: %s", msg)
+					return errors.New(msg)
+				}
+				if err := Vocabularies.ValidateTerm(m.Vocabulary, v); err != nil {
+					msg := fmt.Sprintf("invalid term for key=%s, value=%v, error=%v", k, v, err)
+					log.Printf("ERROR: %s", msg)
+					return errors.New(msg)
+				}
+			}
 			// collect mandatory keys
 			if !m.Optional {
 				mkeys = append(mkeys, k)
@@ -304,6 +362,59 @@ func (s *Schema) Validate(rec map[string]any) error {
 	return nil
 }
 
+// Normalize converts quantities of unit-aware fields of given record, e.g.
+// {"value": 10, "unit": "keV"} or "10 keV", to values in canonical units of
+// the schema, it should be used at ingest before storing the record
+func (s *Schema) Normalize(rec map[string]any) error {
+	if err := s.Load(); err != nil {
+		return err
+	}
+	for k, v := range rec {
+		m, ok := s.Map[k]
+		if !ok || m.Unit == "" {
+			continue
+		}
+		cv, err := canonicalValue(m, v)
+		if err != nil {
+			msg := fmt.Sprintf("invalid quantity for key=%s, value=%v, unit=%s, error=%v", k, v, m.Unit, err)
+			log.Printf("ERROR: %s", msg)
+			return errors.New(msg)
+		}
+		rec[k] = cv
+	}
+	return nil
+}
+
+// SensitiveKeys provides sensitive keys of the schema, the value of the key
+// is true for searchable keys
+func (s *Schema) SensitiveKeys() (map[string]bool, error) {
+	keys := make(map[string]bool)
+	if err := s.Load(); err != nil {
+		return keys, err
+	}
+	for k, m := range s.Map {
+		if m.Sensitive {
+			keys[k] = m.Searchable
+		}
+	}
+	return keys, nil
+}
+
+// IndexKeys provides list of keys of the schema which should be indexed
+func (s *Schema) IndexKeys() ([]string, error) {
+	var keys []string
+	if err := s.Load(); err != nil {
+		return keys, err
+	}
+	for k, m := range s.Map {
+		if m.Index {
+			keys = append(keys, k)
+		}
+	}
+	sort.Sort(utils.StringList(keys))
+	return keys, nil
+}
+
 // Keys provides list of keys of the schema
 func (s *Schema) Keys() ([]string, error) {
 	var keys []string
@@ -1,8 +1,10 @@
 package beamlines
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -16,6 +18,7 @@ func TestSchemaYaml(t *testing.T) {
 - key: Pi
   optional: true
   type: string
+  index: true
 - key: BeamEnergy
   optional: false
   type: int
@@ -42,6 +45,13 @@ func TestSchemaYaml(t *testing.T) {
 		t.Fatal(err)
 	}
 	fmt.Println("Schema optional keys", okeys)
+	ikeys, err := s.IndexKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ikeys) != 1 || ikeys[0] != "Pi" {
+		t.Errorf("wrong index keys %v", ikeys)
+	}
 
 	rec := make(map[string]any)
 	rec["Pi"] = "person"
@@ -93,3 +103,76 @@ func TestSchemaJson(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+// TestConvertUnit tests units conversion table
+func TestConvertUnit(t *testing.T) {
+	expect := []struct {
+		value    float64
+		from, to string
+		result   float64
+	}{
+		{10, "keV", "eV", 10000},
+		{1500, "eV", "keV", 1.5},
+		{1, "mm", "µm", 1000},
+		{250, "um", "mm", 0.25},
+		{25, "°C", "K", 298.15},
+		{180, "deg", "mrad", 3141.59265359},
+	}
+	for _, e := range expect {
+		result, err := ConvertUnit(e.value, e.from, e.to)
+		if err != nil || result != e.result {
+			t.Errorf("%v %s in %s: expect %v, got %v, error %v", e.value, e.from, e.to, e.result, result, err)
+		}
+	}
+	if _, err := ConvertUnit(1, "keV", "mm"); !errors.Is(err, ErrUnit) {
+		t.Errorf("incompatible units are converted, error %v", err)
+	}
+	if _, err := ConvertUnit(1, "furlong", "mm"); !errors.Is(err, ErrUnit) {
+		t.Errorf("unknown unit is converted, error %v", err)
+	}
+	for val, q := range map[string]Quantity{"10 keV": {10, "keV"}, "10eV": {10, "eV"}, "1e3 µm": {1000, "µm"}, "-5°C": {-5, "°C"}, "7": {7, ""}} {
+		if res, err := ParseQuantity(val); err != nil || res != q {
+			t.Errorf("quantity %s: expect %v, got %v, error %v", val, q, res, err)
+		}
+	}
+}
+
+// TestSchemaUnits tests unit-aware schema fields
+func TestSchemaUnits(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "schema.json")
+	jsonData := `[
+    {"key": "BeamEnergy", "type": "float64", "optional": false, "unit": "keV"},
+    {"key": "AttenThickness", "type": "int64", "optional": true, "unit": "µm"}
+]`
+	if err := os.WriteFile(fname, []byte(jsonData), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := &Schema{FileName: fname}
+	rec := map[string]any{
+		"BeamEnergy":     map[string]any{"value": 41991.0, "unit": "eV"},
+		"AttenThickness": "1.5 mm",
+	}
+	if err := s.Validate(rec); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Normalize(rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec["BeamEnergy"] != 41.991 || rec["AttenThickness"] != int64(1500) {
+		t.Errorf("wrong normalized record %v", rec)
+	}
+	for _, val := range []any{"10 mm", map[string]any{"unit": "keV"}, "fast"} {
+		if err := s.Validate(map[string]any{"BeamEnergy": val}); err == nil {
+			t.Errorf("invalid quantity %v is accepted", val)
+		}
+	}
+	if err := s.Validate(map[string]any{"BeamEnergy": 41.991, "AttenThickness": "1.2345 µm"}); err == nil {
+		t.Error("fractional value of integer field is accepted")
+	}
+
+	// schema with unknown unit is rejected
+	os.WriteFile(fname, []byte(`[{"key": "BeamEnergy", "type": "float64", "unit": "parsec"}]`), 0644)
+	if err := s.Load(); err == nil {
+		t.Error("schema with unknown unit is loaded")
+	}
+}
@@ -0,0 +1,220 @@
+package beamlines
+
+// units module provides conversion table of physical units and parsing of
+// physical quantities of unit-aware schema fields
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ErrUnit represents error of unknown or incompatible unit
+var ErrUnit = errors.New("invalid unit")
+
+// Unit represents physical unit, value in unit is converted to base unit
+// of its dimension as value*Factor + Offset
+type Unit struct {
+	Dimension string
+	Factor    float64
+	Offset    float64
+}
+
+// Units represents conversion table of supported units, base units of
+// dimensions have factor 1
+var Units = map[string]Unit{
+	// energy
+	"meV": {"energy", 1e-3, 0},
+	"eV":  {"energy", 1, 0},
+	"keV": {"energy", 1e3, 0},
+	"MeV": {"energy", 1e6, 0},
+	"GeV": {"energy", 1e9, 0},
+	// length
+	"nm": {"length", 1e-9, 0},
+	"Å":  {"length", 1e-10, 0},
+	"µm": {"length", 1e-6, 0},
+	"mm": {"length", 1e-3, 0},
+	"cm": {"length", 1e-2, 0},
+	"m":  {"length", 1, 0},
+	// time
+	"ns":  {"time", 1e-9, 0},
+	"µs":  {"time", 1e-6, 0},
+	"ms":  {"time", 1e-3, 0},
+	"s":   {"time", 1, 0},
+	"min": {"time", 60, 0},
+	"h":   {"time", 3600, 0},
+	// angle
+	"µrad": {"angle", 1e-6, 0},
+	"mrad": {"angle", 1e-3, 0},
+	"rad":  {"angle", 1, 0},
+	"deg":  {"angle", 0.017453292519943295, 0},
+	// temperature
+	"K":  {"temperature", 1, 0},
+	"°C": {"temperature", 1, 273.15},
+	// electric current
+	"nA": {"current", 1e-9, 0},
+	"µA": {"current", 1e-6, 0},
+	"mA": {"current", 1e-3, 0},
+	"A":  {"current", 1, 0},
+	// pressure
+	"Pa":   {"pressure", 1, 0},
+	"kPa":  {"pressure", 1e3, 0},
+	"MPa":  {"pressure", 1e6, 0},
+	"GPa":  {"pressure", 1e9, 0},
+	"bar":  {"pressure", 1e5, 0},
+	"mbar": {"pressure", 1e2, 0},
+}
+
+// UnitAliases maps alternative spellings of units to units of Units table
+var UnitAliases = map[string]string{
+	"um":     "µm",
+	"μm":     "µm", // greek mu
+	"micron": "µm",
+	"Ang":    "Å",
+	"us":     "µs",
+	"μs":     "µs",
+	"sec":    "s",
+	"urad":   "µrad",
+	"μrad":   "µrad",
+	"degree": "deg",
+	"°":      "deg",
+	"C":      "°C",
+	"degC":   "°C",
+	"uA":     "µA",
+	"μA":     "µA",
+}
+
+// helper function to resolve unit name and its alias
+func lookupUnit(name string) (string, Unit, bool) {
+	name = strings.TrimSpace(name)
+	if alias, ok := UnitAliases[name]; ok {
+		name = alias
+	}
+	u, ok := Units[name]
+	return name, u, ok
+}
+
+// ConvertUnit converts value from one unit to another unit of the same
+// dimension, e.g. ConvertUnit(10, "keV", "eV") returns 10000
+func ConvertUnit(value float64, from, to string) (float64, error) {
+	fname, fu, ok := lookupUnit(from)
+	if !ok {
+		return 0, fmt.Errorf("%w: unknown unit '%s'", ErrUnit, from)
+	}
+	tname, tu, ok := lookupUnit(to)
+	if !ok {
+		return 0, fmt.Errorf("%w: unknown unit '%s'", ErrUnit, to)
+	}
+	if fu.Dimension != tu.Dimension {
+		return 0, fmt.Errorf("%w: unit '%s' of %s can not be converted to '%s' of %s", ErrUnit, from, fu.Dimension, to, tu.Dimension)
+	}
+	if fname == tname {
+		return value, nil
+	}
+	value = (value*fu.Factor + fu.Offset - tu.Offset) / tu.Factor
+	// round off floating point errors of conversion factors, e.g. 1 mm is
+	// 1000 µm rather than 999.9999999999999 µm
+	return strconv.ParseFloat(strconv.FormatFloat(value, 'g', 12, 64), 64)
+}
+
+// Quantity represents value with unit
+type Quantity struct {
+	Value float64 `json:"value"`
+	Unit  string  `json:"unit"`
+}
+
+// helper function to get float value of numeric record value
+func floatValue(v any) (float64, bool) {
+	switch val := v.(type) {
+	case int:
+		return float64(val), true
+	case int32:
+		return float64(val), true
+	case int64:
+		return float64(val), true
+	case float32:
+		return float64(val), true
+	case float64:
+		return val, true
+	case json.Number:
+		f, err := val.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// ParseQuantity parses quantity of record value, i.e. {"value": 10,
+// "unit": "keV"} object or "10 keV" string. Plain numbers are returned
+// with empty unit.
+func ParseQuantity(v any) (Quantity, error) {
+	if f, ok := floatValue(v); ok {
+		return Quantity{Value: f}, nil
+	}
+	switch val := v.(type) {
+	case Quantity:
+		return val, nil
+	case map[string]any:
+		f, ok := floatValue(val["value"])
+		if !ok {
+			return Quantity{}, fmt.Errorf("invalid value of quantity %v", v)
+		}
+		unit, _ := val["unit"].(string)
+		return Quantity{Value: f, Unit: strings.TrimSpace(unit)}, nil
+	case string:
+		s := strings.TrimSpace(val)
+		idx := strings.IndexFunc(s, func(r rune) bool {
+			return !unicode.IsDigit(r) && !strings.ContainsRune("+-.eE", r)
+		})
+		num, unit := s, ""
+		if idx >= 0 {
+			num, unit = s[:idx], s[idx:]
+		}
+		// exponent may be taken for the beginning of unit, e.g. 10eV
+		num = strings.TrimSpace(num)
+		f, err := strconv.ParseFloat(num, 64)
+		if err != nil && (strings.HasSuffix(num, "e") || strings.HasSuffix(num, "E")) {
+			unit = num[len(num)-1:] + unit
+			num = num[:len(num)-1]
+			f, err = strconv.ParseFloat(num, 64)
+		}
+		if err != nil {
+			return Quantity{}, fmt.Errorf("invalid quantity '%s'", val)
+		}
+		return Quantity{Value: f, Unit: strings.TrimSpace(unit)}, nil
+	}
+	return Quantity{}, fmt.Errorf("invalid quantity %v of type %T", v, v)
+}
+
+// helper function to convert record value of unit-aware field to value in
+// canonical unit of the schema record, values without unit are given in
+// canonical unit
+func canonicalValue(rec SchemaRecord, v any) (any, error) {
+	q, err := ParseQuantity(v)
+	if err != nil {
+		return nil, err
+	}
+	value := q.Value
+	if q.Unit != "" {
+		value, err = ConvertUnit(q.Value, q.Unit, rec.Unit)
+		if err != nil {
+			return nil, err
+		}
+	}
+	switch rec.Type {
+	case "int", "int32", "int64":
+		if value != float64(int64(value)) {
+			return nil, fmt.Errorf("value %v %s is not integer in %s", q.Value, q.Unit, rec.Unit)
+		}
+		switch rec.Type {
+		case "int":
+			return int(value), nil
+		case "int32":
+			return int32(value), nil
+		}
+		return int64(value), nil
+	}
+	return value, nil
+}
@@ -0,0 +1,345 @@
+package browse
+
+// browse module provides faceted browsing of metadata records. Facets of
+// every beamline schema are defined by its web section keys and facet
+// counts are cached to power filter sidebar of Discovery UI.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	beamlines "github.com/CHESSComputing/golib/beamlines"
+	srvConfig "github.com/CHESSComputing/golib/config"
+	mongo "github.com/CHESSComputing/golib/mongo"
+	pubsub "github.com/CHESSComputing/golib/pubsub"
+	redis "github.com/CHESSComputing/golib/redis"
+	bson "go.mongodb.org/mongo-driver/bson"
+)
+
+// FacetSection defines name of schema web section which lists facet keys
+var FacetSection = "Facets"
+
+// DefaultFacets defines facet keys of schemas without facet section
+var DefaultFacets = []string{"Beamline", "Technique", "SampleType"}
+
+// DateFacets defines date facets added to facets of every schema, facet
+// keys matching date facets are counted per time interval
+var DateFacets = []mongo.Facet{{Field: "Date", Interval: "month"}}
+
+// SchemaKey defines record key which holds schema name
+var SchemaKey = "schema"
+
+// DefaultTTL defines default time to keep facet counts in cache
+var DefaultTTL = 5 * time.Minute
+
+// DefaultLimit defines default number of buckets per facet
+var DefaultLimit = 20
+
+// ErrUnknownSchema is returned for schemas without schema file
+var ErrUnknownSchema = errors.New("unknown schema")
+
+// ErrInvalidFilter is returned for filters which can not be applied
+var ErrInvalidFilter = errors.New("invalid filter")
+
+// Counter counts facets of records matching the spec
+type Counter func(ctx context.Context, facets []mongo.Facet, spec bson.M, limit int) (map[string]mongo.Buckets, error)
+
+// MongoCounter returns counter of records in given MongoDB collection
+func MongoCounter(dbname, collname string) Counter {
+	return func(ctx context.Context, facets []mongo.Facet, spec bson.M, limit int) (map[string]mongo.Buckets, error) {
+		return mongo.Browse(dbname, collname, facets, spec, limit)
+	}
+}
+
+// FacetCounts represents counts of single facet
+type FacetCounts struct {
+	mongo.Buckets
+	Interval string `json:"interval,omitempty"`
+}
+
+// Result represents facet counts of schema records
+type Result struct {
+	Schema  string        `json:"schema"`
+	Facets  []FacetCounts `json:"facets"`
+	Updated time.Time     `json:"updated"`
+}
+
+// Cache represents cache of facet counts shared by replicas of the
+// service, e.g. redis.Cache
+type Cache interface {
+	Get(ctx context.Context, key string, val any) (bool, error)
+	Set(ctx context.Context, key string, val any, ttl time.Duration) error
+	Clear(ctx context.Context) error
+}
+
+// cacheEntry represents cached facet counts
+type cacheEntry struct {
+	result  Result
+	expires time.Time
+}
+
+// Browser represents faceted browser of metadata records
+type Browser struct {
+	Counter     Counter                  // counter of facets
+	Schemas     *beamlines.SchemaManager // manager of beamline schemas
+	SchemaFiles []string                 // beamline schema files
+	TTL         time.Duration            // time to keep counts in cache, zero disables cache
+	Limit       int                      // default number of buckets per facet
+	Shared      Cache                    // shared cache used instead of in-memory cache, optional
+
+	mu    sync.Mutex
+	cache map[string]cacheEntry
+}
+
+// Facets represents global faceted browser, it should be initialized via
+// Init function
+var Facets *Browser
+
+// NewBrowser returns faceted browser of given schema files with default
+// settings
+func NewBrowser(counter Counter, schemaFiles []string) *Browser {
+	return &Browser{
+		Counter:     counter,
+		Schemas:     &beamlines.SchemaManager{},
+		SchemaFiles: schemaFiles,
+		TTL:         DefaultTTL,
+		Limit:       DefaultLimit,
+		cache:       make(map[string]cacheEntry),
+	}
+}
+
+// Init initializes global faceted browser over records of CHESS MetaData
+// service
+func Init() error {
+	if srvConfig.Config == nil {
+		return errors.New("server configuration is not initialized")
+	}
+	cfg := srvConfig.Config.CHESSMetaData
+	if cfg.DBName == "" || cfg.DBColl == "" {
+		return errors.New("MetaData database is not configured")
+	}
+	Facets = NewBrowser(MongoCounter(cfg.DBName, cfg.DBColl), cfg.SchemaFiles)
+	if srvConfig.Config.Redis.Cache {
+		if redis.Redis == nil {
+			if err := redis.Init(); err != nil {
+				return err
+			}
+		}
+		Facets.Shared = redis.NewCache(redis.Redis, "browse")
+	}
+	return nil
+}
+
+// helper function to load schema of given name
+func (b *Browser) schema(name string) (*beamlines.Schema, error) {
+	for _, fname := range b.SchemaFiles {
+		if beamlines.SchemaName(fname) != name {
+			continue
+		}
+		if b.Schemas == nil {
+			b.Schemas = &beamlines.SchemaManager{}
+		}
+		schema, err := b.Schemas.Load(fname)
+		if err != nil {
+			log.Printf("ERROR: unable to load schema %s, error %v", name, err)
+			return nil, err
+		}
+		return schema, nil
+	}
+	return nil, fmt.Errorf("%w '%s'", ErrUnknownSchema, name)
+}
+
+// SchemaFacets returns facets of given schema. Facet keys are listed in
+// FacetSection of schema web section keys (DefaultFacets otherwise), keys
+// unknown to the schema are skipped and DateFacets are always added.
+func (b *Browser) SchemaFacets(name string) ([]mongo.Facet, error) {
+	schema, err := b.schema(name)
+	if err != nil {
+		return nil, err
+	}
+	return schemaFacets(schema), nil
+}
+
+// helper function to provide facets of the schema
+func schemaFacets(schema *beamlines.Schema) []mongo.Facet {
+	keys := schema.WebSectionKeys[FacetSection]
+	if len(keys) == 0 {
+		keys = DefaultFacets
+	}
+	dates := make(map[string]mongo.Facet)
+	for _, facet := range DateFacets {
+		dates[facet.Field] = facet
+	}
+	var facets []mongo.Facet
+	for _, key := range keys {
+		if facet, ok := dates[key]; ok {
+			facets = append(facets, facet)
+			delete(dates, key)
+			continue
+		}
+		if _, ok := schema.Map[key]; ok {
+			facets = append(facets, mongo.Facet{Field: key})
+		}
+	}
+	for _, facet := range DateFacets {
+		if _, ok := dates[facet.Field]; ok {
+			facets = append(facets, facet)
+		}
+	}
+	return facets
+}
+
+// helper function to provide cache key of the request
+func cacheKey(name string, spec map[string]any, limit int) string {
+	// JSON encoding sorts map keys, therefore equal specs have equal keys
+	data, _ := json.Marshal(spec)
+	return fmt.Sprintf("%s|%d|%s", name, limit, data)
+}
+
+// helper function to convert filters into MongoDB spec of schema records
+func filterSpec(name string, facets []mongo.Facet, filters map[string]any) (bson.M, error) {
+	spec := bson.M{SchemaKey: name}
+	for key, val := range filters {
+		var facet *mongo.Facet
+		for i := range facets {
+			if facets[i].Field == key {
+				facet = &facets[i]
+			}
+		}
+		if facet == nil {
+			return nil, fmt.Errorf("%w, unknown facet '%s'", ErrInvalidFilter, key)
+		}
+		if facet.Interval != "" {
+			return nil, fmt.Errorf("%w, date facet '%s' can not be used as filter", ErrInvalidFilter, key)
+		}
+		switch v := val.(type) {
+		case string, bool, float64, int, int64:
+			spec[key] = v
+		case []any:
+			for _, item := range v {
+				switch item.(type) {
+				case string, bool, float64, int, int64:
+				default:
+					return nil, fmt.Errorf("%w, wrong value of facet '%s'", ErrInvalidFilter, key)
+				}
+			}
+			spec[key] = bson.M{"$in": v}
+		default:
+			return nil, fmt.Errorf("%w, wrong value of facet '%s'", ErrInvalidFilter, key)
+		}
+	}
+	return spec, nil
+}
+
+// Count returns facet counts of records of given schema matching the
+// filters. Filters are equality conditions on facet keys, list values
+// match any of their items. Counts are taken from the cache if they are
+// not older than TTL.
+func (b *Browser) Count(ctx context.Context, name string, filters map[string]any, limit int) (Result, error) {
+	if limit <= 0 {
+		limit = b.Limit
+	}
+	facets, err := b.SchemaFacets(name)
+	if err != nil {
+		return Result{}, err
+	}
+	if len(facets) == 0 {
+		return Result{Schema: name, Facets: []FacetCounts{}, Updated: time.Now()}, nil
+	}
+	spec, err := filterSpec(name, facets, filters)
+	if err != nil {
+		return Result{}, err
+	}
+	key := cacheKey(name, filters, limit)
+	if res, ok := b.cached(ctx, key); ok {
+		return res, nil
+	}
+	if b.Counter == nil {
+		return Result{}, errors.New("facet counter is not configured")
+	}
+	counts, err := b.Counter(ctx, facets, spec, limit)
+	if err != nil {
+		log.Printf("ERROR: unable to count facets of schema %s, error %v", name, err)
+		return Result{}, err
+	}
+	res := Result{Schema: name, Updated: time.Now()}
+	for _, facet := range facets {
+		buckets, ok := counts[facet.Field]
+		if !ok {
+			buckets = mongo.Buckets{Field: facet.Field, Buckets: []mongo.Bucket{}}
+		}
+		res.Facets = append(res.Facets, FacetCounts{Buckets: buckets, Interval: facet.Interval})
+	}
+	b.store(ctx, key, res)
+	return res, nil
+}
+
+// helper function to get result from the cache, errors of shared cache are
+// logged and treated as cache misses
+func (b *Browser) cached(ctx context.Context, key string) (Result, bool) {
+	if b.Shared != nil {
+		var res Result
+		ok, err := b.Shared.Get(ctx, key, &res)
+		if err != nil {
+			log.Printf("WARNING: unable to read facet counts from shared cache, error %v", err)
+		}
+		return res, ok
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	entry, ok := b.cache[key]
+	if !ok || time.Now().After(entry.expires) {
+		delete(b.cache, key)
+		return Result{}, false
+	}
+	return entry.result, true
+}
+
+// helper function to put result into the cache
+func (b *Browser) store(ctx context.Context, key string, res Result) {
+	if b.TTL <= 0 {
+		return
+	}
+	if b.Shared != nil {
+		if err := b.Shared.Set(ctx, key, res, b.TTL); err != nil {
+			log.Printf("WARNING: unable to store facet counts in shared cache, error %v", err)
+		}
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.cache == nil {
+		b.cache = make(map[string]cacheEntry)
+	}
+	b.cache[key] = cacheEntry{result: res, expires: time.Now().Add(b.TTL)}
+}
+
+// Invalidate removes all facet counts from the cache
+func (b *Browser) Invalidate() {
+	if b.Shared != nil {
+		if err := b.Shared.Clear(context.Background()); err != nil {
+			log.Printf("WARNING: unable to clear shared cache of facet counts, error %v", err)
+		}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.cache = make(map[string]cacheEntry)
+}
+
+// EventHandler returns message bus handler which invalidates the cache on
+// record events, therefore counts reflect inserted, updated and deleted
+// records before TTL expires
+func (b *Browser) EventHandler() pubsub.Handler {
+	return func(ctx context.Context, env pubsub.Envelope) error {
+		switch env.Type {
+		case pubsub.EventRecordInserted, pubsub.EventRecordUpdated, pubsub.EventRecordDeleted:
+			b.Invalidate()
+		}
+		return nil
+	}
+}
@@ -0,0 +1,148 @@
+package browse
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	mongo "github.com/CHESSComputing/golib/mongo"
+	pubsub "github.com/CHESSComputing/golib/pubsub"
+	"github.com/gin-gonic/gin"
+	bson "go.mongodb.org/mongo-driver/bson"
+)
+
+// helper function to create schema files and browser with fake counter
+// which records its calls
+func testBrowser(t *testing.T) (*Browser, *[]bson.M) {
+	dir := t.TempDir()
+	schema := `[
+		{"key": "Beamline", "type": "string", "section": "User"},
+		{"key": "Technique", "type": "string", "section": "Experiment"},
+		{"key": "Cycle", "type": "string", "section": "User"}
+	]`
+	files := map[string]string{
+		"ID3A.json":     schema,
+		"ID3A_web.json": `{"Facets": ["Technique", "Unknown", "Cycle"]}`,
+		"ID1A3.json":    schema,
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var calls []bson.M
+	counter := func(ctx context.Context, facets []mongo.Facet, spec bson.M, limit int) (map[string]mongo.Buckets, error) {
+		calls = append(calls, spec)
+		out := make(map[string]mongo.Buckets)
+		for _, facet := range facets {
+			out[facet.Field] = mongo.Buckets{Field: facet.Field, Buckets: []mongo.Bucket{{Key: "x", Count: int64(len(calls))}}}
+		}
+		return out, nil
+	}
+	b := NewBrowser(counter, []string{filepath.Join(dir, "ID3A.json"), filepath.Join(dir, "ID1A3.json")})
+	return b, &calls
+}
+
+// TestSchemaFacets
+func TestSchemaFacets(t *testing.T) {
+	b, _ := testBrowser(t)
+	facets, err := b.SchemaFacets("ID3A")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []mongo.Facet{{Field: "Technique"}, {Field: "Cycle"}, {Field: "Date", Interval: "month"}}
+	if len(facets) != len(expect) {
+		t.Fatalf("wrong facets %+v", facets)
+	}
+	for i, facet := range facets {
+		if facet != expect[i] {
+			t.Errorf("expect facet %+v, got %+v", expect[i], facet)
+		}
+	}
+	// schema without facet section uses default facets
+	facets, err = b.SchemaFacets("ID1A3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(facets) != 3 || facets[0].Field != "Beamline" || facets[1].Field != "Technique" {
+		t.Errorf("wrong default facets %+v", facets)
+	}
+	if _, err := b.SchemaFacets("ID4B"); err == nil {
+		t.Error("unknown schema is accepted")
+	}
+}
+
+// TestCount
+func TestCount(t *testing.T) {
+	b, calls := testBrowser(t)
+	ctx := context.Background()
+	filters := map[string]any{"Technique": []any{"XRD", "SAXS"}, "Cycle": "2024-1"}
+	res, err := b.Count(ctx, "ID3A", filters, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Facets) != 3 || res.Facets[2].Interval != "month" || res.Facets[0].Buckets.Buckets[0].Count != 1 {
+		t.Errorf("wrong result %+v", res)
+	}
+	spec := (*calls)[0]
+	if spec[SchemaKey] != "ID3A" || spec["Cycle"] != "2024-1" || len(spec["Technique"].(bson.M)["$in"].([]any)) != 2 {
+		t.Errorf("wrong spec %v", spec)
+	}
+
+	// counts are cached until invalidated by record events
+	if _, err := b.Count(ctx, "ID3A", filters, 0); err != nil || len(*calls) != 1 {
+		t.Errorf("counts are not cached, calls %d, error %v", len(*calls), err)
+	}
+	if _, err := b.Count(ctx, "ID3A", filters, 5); err != nil || len(*calls) != 2 {
+		t.Errorf("counts of different limit are taken from cache, calls %d", len(*calls))
+	}
+	b.EventHandler()(ctx, pubsub.Envelope{Type: pubsub.EventRecordInserted})
+	if _, err := b.Count(ctx, "ID3A", filters, 0); err != nil || len(*calls) != 3 {
+		t.Errorf("cache is not invalidated, calls %d", len(*calls))
+	}
+
+	for _, f := range []map[string]any{{"Beamline": "3a"}, {"Date": "2024-01"}, {"Cycle": map[string]any{"$ne": 1}}, {"Cycle": []any{[]any{}}}} {
+		if _, err := b.Count(ctx, "ID3A", f, 0); err == nil {
+			t.Errorf("invalid filter %v is accepted", f)
+		}
+	}
+}
+
+// TestHandlers
+func TestHandlers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	b, _ := testBrowser(t)
+	r := gin.New()
+	for _, route := range b.Routes("/browse") {
+		r.Handle(route.Method, route.Path, route.Handler)
+	}
+	get := func(target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+		return w
+	}
+	w := get("/browse/ID3A?limit=10&filters=" + url.QueryEscape(`{"Technique": "XRD"}`))
+	var res Result
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("unexpected response %d %s", w.Code, w.Body.String())
+	}
+	if res.Schema != "ID3A" || len(res.Facets) != 3 || res.Facets[1].Field != "Cycle" {
+		t.Errorf("unexpected result %+v", res)
+	}
+	expect := map[string]int{
+		"/browse/ID4B": http.StatusNotFound,
+		"/browse/ID3A?filters=" + url.QueryEscape(`{"Unknown": 1}`): http.StatusBadRequest,
+		"/browse/ID3A?filters=[":                                    http.StatusBadRequest,
+		"/browse":                                                   http.StatusOK,
+	}
+	for target, code := range expect {
+		if w := get(target); w.Code != code {
+			t.Errorf("%s: expect %d, got %d %s", target, code, w.Code, w.Body.String())
+		}
+	}
+}
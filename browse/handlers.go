@@ -0,0 +1,72 @@
+package browse
+
+// handlers module provides HTTP endpoints of faceted browsing
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	beamlines "github.com/CHESSComputing/golib/beamlines"
+	server "github.com/CHESSComputing/golib/server"
+	services "github.com/CHESSComputing/golib/services"
+	"github.com/gin-gonic/gin"
+)
+
+// CountHandler provides facet counts of schema records, the schema is
+// passed via schema path parameter, filters via filters parameter (JSON
+// object of facet keys and values) and number of buckets via limit
+// parameter, e.g. /browse/ID3A?filters={"Technique":["XRD"]}&limit=10
+func (b *Browser) CountHandler(c *gin.Context) {
+	var filters map[string]any
+	if val := c.Query("filters"); val != "" {
+		if err := json.Unmarshal([]byte(val), &filters); err != nil {
+			c.JSON(http.StatusBadRequest, services.Response("browse", http.StatusBadRequest, services.ParametersError, err))
+			return
+		}
+	}
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	res, err := b.Count(c.Request.Context(), c.Param("schema"), filters, limit)
+	if err != nil {
+		code := http.StatusInternalServerError
+		srvCode := services.QueryError
+		if errors.Is(err, ErrUnknownSchema) {
+			code = http.StatusNotFound
+			srvCode = services.SchemaError
+		} else if errors.Is(err, ErrInvalidFilter) {
+			code = http.StatusBadRequest
+			srvCode = services.ParametersError
+		}
+		c.JSON(code, services.Response("browse", code, srvCode, err))
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// FacetsHandler provides facet definitions of all schemas, e.g. to build
+// filter sidebar before counts are loaded
+func (b *Browser) FacetsHandler(c *gin.Context) {
+	out := make(map[string]any)
+	for _, fname := range b.SchemaFiles {
+		name := beamlines.SchemaName(fname)
+		facets, err := b.SchemaFacets(name)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, services.Response("browse", http.StatusInternalServerError, services.SchemaError, err))
+			return
+		}
+		out[name] = facets
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// Routes returns server routes of faceted browsing under given path, e.g.
+// /browse
+func (b *Browser) Routes(path string) []server.Route {
+	return []server.Route{
+		{Method: "GET", Path: path, Authorized: true, Scope: "read", Handler: b.FacetsHandler,
+			Summary: "facets of beamline schemas"},
+		{Method: "GET", Path: path + "/:schema", Authorized: true, Scope: "read", Handler: b.CountHandler,
+			Summary: "facet counts of schema records"},
+	}
+}
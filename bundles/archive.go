@@ -0,0 +1,362 @@
+package bundles
+
+// archive module builds bundle archives, every archive holds records.json
+// with exported records, files/<source>/<path> with selected data files and
+// manifest.json with checksums of all archive entries
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"time"
+
+	datamgmt "github.com/CHESSComputing/golib/datamgmt"
+	jobs "github.com/CHESSComputing/golib/jobs"
+	pubsub "github.com/CHESSComputing/golib/pubsub"
+	storage "github.com/CHESSComputing/golib/storage"
+)
+
+// archive entries
+const (
+	RecordsEntry  = "records.json"
+	ManifestEntry = "manifest.json"
+	FilesPrefix   = "files"
+)
+
+// archiveWriter defines writer of archive entries
+type archiveWriter interface {
+	Add(name string, size int64, modTime time.Time, r io.Reader) error
+	Close() error
+}
+
+// zipWriter writes zip archives
+type zipWriter struct {
+	w *zip.Writer
+}
+
+// Add adds entry to zip archive
+func (z *zipWriter) Add(name string, size int64, modTime time.Time, r io.Reader) error {
+	hdr := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modTime}
+	hdr.SetMode(0644)
+	w, err := z.w.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+	nbytes, err := io.Copy(w, r)
+	if err == nil && nbytes != size {
+		err = fmt.Errorf("entry %s has %d bytes, expected %d", name, nbytes, size)
+	}
+	return err
+}
+
+// Close closes zip archive
+func (z *zipWriter) Close() error {
+	return z.w.Close()
+}
+
+// tarWriter writes tar archives, optionally gzip compressed
+type tarWriter struct {
+	w  *tar.Writer
+	gz *gzip.Writer
+}
+
+// Add adds entry to tar archive
+func (t *tarWriter) Add(name string, size int64, modTime time.Time, r io.Reader) error {
+	hdr := &tar.Header{Name: name, Mode: 0644, Size: size, ModTime: modTime, Typeflag: tar.TypeReg}
+	if err := t.w.WriteHeader(hdr); err != nil {
+		return err
+	}
+	nbytes, err := io.Copy(t.w, r)
+	if err == nil && nbytes != size {
+		err = fmt.Errorf("entry %s has %d bytes, expected %d", name, nbytes, size)
+	}
+	return err
+}
+
+// Close closes tar archive
+func (t *tarWriter) Close() error {
+	err := t.w.Close()
+	if t.gz != nil {
+		if gerr := t.gz.Close(); err == nil {
+			err = gerr
+		}
+	}
+	return err
+}
+
+// helper function to create archive writer of given format
+func newArchiveWriter(format string, w io.Writer) (archiveWriter, error) {
+	switch format {
+	case FormatZip:
+		return &zipWriter{w: zip.NewWriter(w)}, nil
+	case FormatTar:
+		return &tarWriter{w: tar.NewWriter(w)}, nil
+	case FormatTarGz:
+		gz := gzip.NewWriter(w)
+		return &tarWriter{w: tar.NewWriter(gz), gz: gz}, nil
+	}
+	return nil, fmt.Errorf("%w, unsupported format '%s'", ErrInvalid, format)
+}
+
+// progress tracks build progress of the bundle
+type progress struct {
+	exporter *Exporter
+	bundle   *Bundle
+	done     int64
+	total    int64
+}
+
+// helper function to account processed bytes and report progress once it
+// advances by ProgressStep
+func (p *progress) add(ctx context.Context, nbytes int64) {
+	p.done += nbytes
+	if p.total <= 0 {
+		return
+	}
+	// keep 100% for completed bundles
+	pct := int(p.done * 99 / p.total)
+	if pct-p.bundle.Progress < ProgressStep {
+		return
+	}
+	p.bundle.Progress = pct
+	p.exporter.report(ctx, *p.bundle, map[string]any{"progress": pct})
+}
+
+// progressReader reports progress of read bytes
+type progressReader struct {
+	ctx      context.Context
+	reader   io.Reader
+	progress *progress
+}
+
+// Read implements io.Reader interface
+func (r *progressReader) Read(buf []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := r.reader.Read(buf)
+	r.progress.add(r.ctx, int64(n))
+	return n, err
+}
+
+// helper function to update bundle record with given fields and publish
+// progress event to the owner of the bundle
+func (e *Exporter) report(ctx context.Context, b Bundle, fields map[string]any) {
+	b.Updated = time.Now().Unix()
+	fields["updated"] = b.Updated
+	if _, err := e.Store.Update(ctx, e.Collection, map[string]any{"_id": b.ID}, fields); err != nil {
+		log.Printf("WARNING: unable to update bundle %s, error %v", b.ID, err)
+	}
+	if e.Bus == nil {
+		return
+	}
+	env, err := pubsub.NewEnvelope(pubsub.EventBundleProgress, pubsub.Source, b)
+	if err == nil {
+		err = e.Bus.Publish(ctx, ProgressSubject+"."+b.Owner, env)
+	}
+	if err != nil {
+		log.Printf("WARNING: unable to publish progress of bundle %s, error %v", b.ID, err)
+	}
+}
+
+// helper function to add entry to the archive and its checksum to the
+// manifest
+func addEntry(w archiveWriter, manifest *datamgmt.Manifest, name string, size int64, modTime time.Time, r io.Reader) error {
+	hash := sha256.New()
+	if err := w.Add(name, size, modTime, io.TeeReader(r, hash)); err != nil {
+		return err
+	}
+	manifest.Entries = append(manifest.Entries, datamgmt.ManifestEntry{
+		Path:      name,
+		Size:      size,
+		ModTime:   modTime,
+		Checksums: map[string]string{datamgmt.SHA256: hex.EncodeToString(hash.Sum(nil))},
+	})
+	return nil
+}
+
+// helper function to write bundle archive into given writer, it returns
+// number of exported records
+func (e *Exporter) write(ctx context.Context, b *Bundle, out io.Writer) (int, error) {
+	opts := &storage.FindOptions{Limit: e.MaxRecords + 1}
+	records, err := e.Records.Find(ctx, e.RecordsCollection, b.Query, opts)
+	if err != nil {
+		return 0, err
+	}
+	if len(records) > e.MaxRecords {
+		return 0, fmt.Errorf("%w, query matches more than %d records", ErrInvalid, e.MaxRecords)
+	}
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	if len(b.Files) > 0 && e.Downloader == nil {
+		return 0, errors.New("sources of bundle files are not configured")
+	}
+	// stat files upfront to know total size of the bundle
+	prog := &progress{exporter: e, bundle: b, total: int64(len(data))}
+	var sizes []int64
+	var times []time.Time
+	for _, f := range b.Files {
+		src, ok := e.Downloader.Sources[f.Source]
+		if !ok {
+			return 0, fmt.Errorf("unknown source '%s' of bundle file", f.Source)
+		}
+		info, err := src.Stat(ctx, f.Path)
+		if err != nil {
+			return 0, err
+		}
+		sizes = append(sizes, info.Size)
+		times = append(times, info.ModTime)
+		prog.total += info.Size
+	}
+
+	w, err := newArchiveWriter(b.Format, out)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now().UTC()
+	manifest := datamgmt.Manifest{Root: ".", Created: now, Algorithms: []string{datamgmt.SHA256}}
+	reader := &progressReader{ctx: ctx, reader: bytes.NewReader(data), progress: prog}
+	if err := addEntry(w, &manifest, RecordsEntry, int64(len(data)), now, reader); err != nil {
+		return 0, err
+	}
+	for i, f := range b.Files {
+		src := e.Downloader.Sources[f.Source]
+		rc, err := src.Open(ctx, f.Path, 0, sizes[i])
+		if err != nil {
+			return 0, err
+		}
+		name := path.Join(FilesPrefix, f.Source, f.Path)
+		reader := &progressReader{ctx: ctx, reader: rc, progress: prog}
+		err = addEntry(w, &manifest, name, sizes[i], times[i], reader)
+		rc.Close()
+		if err != nil {
+			return 0, err
+		}
+	}
+	data, err = json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	if err := w.Add(ManifestEntry, int64(len(data)), now, bytes.NewReader(data)); err != nil {
+		return 0, err
+	}
+	return len(records), w.Close()
+}
+
+// Build builds archive of bundle with given id, the archive is written
+// into temporary file which is renamed once the archive is complete
+func (e *Exporter) Build(ctx context.Context, id string) error {
+	rec, err := storage.FindOne(ctx, e.Store, e.Collection, map[string]any{"_id": id})
+	if errors.Is(err, storage.ErrNotFound) {
+		return fmt.Errorf("%w '%s'", ErrNotFound, id)
+	}
+	if err != nil {
+		return err
+	}
+	b, err := fromRecord(rec)
+	if err != nil {
+		return err
+	}
+	if b.Status == StatusDone {
+		return nil
+	}
+	b.Status = StatusRunning
+	b.Progress = 0
+	e.report(ctx, b, map[string]any{"status": b.Status, "progress": 0, "error": ""})
+
+	fname := e.Path(b)
+	tmp, err := os.CreateTemp(e.Dir, b.FileName()+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	hash := sha256.New()
+	counter := &countWriter{}
+	nrec, err := e.write(ctx, &b, io.MultiWriter(tmp, hash, counter))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), fname); err != nil {
+		return err
+	}
+	now := time.Now()
+	b.Status = StatusDone
+	b.Progress = 100
+	b.Records = nrec
+	b.Size = counter.size
+	b.Checksum = hex.EncodeToString(hash.Sum(nil))
+	b.Expires = now.Add(e.TTL).Unix()
+	e.report(ctx, b, map[string]any{
+		"status":   b.Status,
+		"progress": b.Progress,
+		"records":  b.Records,
+		"size":     b.Size,
+		"checksum": b.Checksum,
+		"expires":  b.Expires,
+	})
+	return nil
+}
+
+// countWriter counts written bytes
+type countWriter struct {
+	size int64
+}
+
+// Write implements io.Writer interface
+func (w *countWriter) Write(buf []byte) (int, error) {
+	w.size += int64(len(buf))
+	return len(buf), nil
+}
+
+// helper function to build bundle of the queue task, failed bundles are
+// rescheduled until retry policy is exhausted
+func (e *Exporter) handle(ctx context.Context, task *jobs.Task) error {
+	var payload struct {
+		ID string `json:"id"`
+	}
+	if err := task.Decode(&payload); err != nil {
+		return err
+	}
+	err := e.Build(ctx, payload.ID)
+	if err == nil || errors.Is(err, ErrNotFound) {
+		// bundles removed before build are dropped
+		return nil
+	}
+	log.Printf("WARNING: unable to build bundle %s, attempt %d, error %v", payload.ID, task.Attempts, err)
+	rec, ferr := storage.FindOne(ctx, e.Store, e.Collection, map[string]any{"_id": payload.ID})
+	if ferr != nil {
+		return err
+	}
+	b, ferr := fromRecord(rec)
+	if ferr != nil {
+		return err
+	}
+	b.Status = StatusQueued
+	b.Error = err.Error()
+	// bundles with invalid requests are not retried
+	if task.Attempts >= task.MaxAttempts || errors.Is(err, ErrInvalid) {
+		b.Status = StatusFailed
+		b.Expires = time.Now().Add(e.TTL).Unix()
+	}
+	e.report(ctx, b, map[string]any{"status": b.Status, "error": b.Error, "expires": b.Expires})
+	if b.Status == StatusFailed {
+		return nil
+	}
+	return err
+}
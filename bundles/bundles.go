@@ -0,0 +1,446 @@
+package bundles
+
+// bundles module exports metadata records matching search query along with
+// selected data files into downloadable tar or zip archives with manifest
+// and checksums. Bundles are built asynchronously by jobs queue which
+// reports their progress to web clients via message bus.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	srvConfig "github.com/CHESSComputing/golib/config"
+	download "github.com/CHESSComputing/golib/download"
+	jobs "github.com/CHESSComputing/golib/jobs"
+	pubsub "github.com/CHESSComputing/golib/pubsub"
+	searches "github.com/CHESSComputing/golib/searches"
+	storage "github.com/CHESSComputing/golib/storage"
+	utils "github.com/CHESSComputing/golib/utils"
+	"github.com/google/uuid"
+)
+
+// TaskType defines jobs task type of bundle builds
+const TaskType = "bundles.export"
+
+// bundle statuses
+const (
+	StatusQueued  = "queued"  // bundle awaits build
+	StatusRunning = "running" // bundle is being built
+	StatusDone    = "done"    // bundle is ready for download
+	StatusFailed  = "failed"  // bundle build failed after all attempts
+)
+
+// archive formats
+const (
+	FormatZip   = "zip"
+	FormatTar   = "tar"
+	FormatTarGz = "tar.gz"
+)
+
+// DefaultCollection defines collection of bundle records
+var DefaultCollection = "bundles"
+
+// DefaultTTL defines default time to keep bundles
+var DefaultTTL = 24 * time.Hour
+
+// DefaultMaxRecords defines default maximum number of records per bundle
+var DefaultMaxRecords = 10000
+
+// ProgressSubject defines prefix of message bus subjects of progress
+// events, events of user bundles are published to <ProgressSubject>.<user>
+var ProgressSubject = "bundles"
+
+// ProgressStep defines minimal change of progress (in percent) reported
+// via store and message bus
+var ProgressStep = 5
+
+// PurgeInterval defines interval of expired bundles removal
+var PurgeInterval = time.Hour
+
+// DefaultRetryPolicy defines retry policy of bundle builds
+var DefaultRetryPolicy = jobs.RetryPolicy{
+	MaxAttempts: 3,
+	InitialWait: time.Minute,
+	MaxWait:     time.Hour,
+	Multiplier:  2,
+}
+
+// ErrNotFound is returned for unknown bundles
+var ErrNotFound = errors.New("bundle not found")
+
+// ErrInvalid is returned for invalid bundle requests
+var ErrInvalid = errors.New("invalid bundle request")
+
+// File represents data file selected for the bundle
+type File struct {
+	Source string `json:"source"` // name of download source
+	Path   string `json:"path"`   // path of the file within the source
+}
+
+// Request represents request of new bundle
+type Request struct {
+	Query  map[string]any `json:"query"`  // query of records, see searches.ValidateQuery
+	Format string         `json:"format"` // archive format: zip (default), tar or tar.gz
+	Files  []File         `json:"files"`  // selected data files
+}
+
+// Bundle represents archive bundle of search results
+type Bundle struct {
+	ID       string         `json:"id"`
+	Owner    string         `json:"owner"`
+	Status   string         `json:"status"`
+	Format   string         `json:"format"`
+	Query    map[string]any `json:"query"`
+	Files    []File         `json:"files,omitempty"`
+	Progress int            `json:"progress"`           // build progress in percent
+	Records  int            `json:"records"`            // number of exported records
+	Size     int64          `json:"size"`               // size of the archive
+	Checksum string         `json:"checksum,omitempty"` // sha256 checksum of the archive
+	Error    string         `json:"error,omitempty"`    // error of last build attempt
+	Created  int64          `json:"created"`
+	Updated  int64          `json:"updated"`
+	Expires  int64          `json:"expires,omitempty"`
+}
+
+// FileName returns file name of bundle archive
+func (b Bundle) FileName() string {
+	return fmt.Sprintf("foxden-%s.%s", b.ID, b.Format)
+}
+
+// Exporter creates archive bundles of search results
+type Exporter struct {
+	Store             storage.Store        // store of bundle records
+	Collection        string               // collection of bundle records
+	Records           storage.Store        // store of metadata records
+	RecordsCollection string               // collection of metadata records
+	Downloader        *download.Downloader // sources and policy of selected files, files can not be selected if nil
+	Queue             *jobs.Queue          // queue of bundle builds
+	Bus               pubsub.Bus           // message bus of progress events, optional
+	Dir               string               // directory of bundle archives
+	TTL               time.Duration        // time to keep bundles
+	MaxRecords        int                  // maximum number of records per bundle
+	MaxSize           int64                // maximum size of selected files, 0 means no limit
+}
+
+// Bundles represents global bundle exporter, it should be initialized via
+// Init function
+var Bundles *Exporter
+
+// NewExporter returns exporter of given configuration, queue handler of
+// bundle builds is registered in given queue
+func NewExporter(store, records storage.Store, recordsCollection string, cfg srvConfig.Bundles, queue *jobs.Queue) *Exporter {
+	e := &Exporter{
+		Store:             store,
+		Collection:        cfg.Collection,
+		Records:           records,
+		RecordsCollection: recordsCollection,
+		Queue:             queue,
+		Dir:               cfg.Dir,
+		TTL:               cfg.TTL,
+		MaxRecords:        cfg.MaxRecords,
+		MaxSize:           int64(cfg.MaxSize),
+	}
+	if e.Collection == "" {
+		e.Collection = DefaultCollection
+	}
+	if e.TTL <= 0 {
+		e.TTL = DefaultTTL
+	}
+	if e.MaxRecords <= 0 {
+		e.MaxRecords = DefaultMaxRecords
+	}
+	if queue != nil {
+		queue.Register(TaskType, e.handle, DefaultRetryPolicy)
+	}
+	return e
+}
+
+// Init initializes global bundle exporter over records of CHESS MetaData
+// service, bundles are built by given queue and their progress is
+// published to global message bus
+func Init(queue *jobs.Queue) error {
+	if srvConfig.Config == nil {
+		return errors.New("server configuration is not initialized")
+	}
+	cfg := srvConfig.Config.Bundles
+	meta := srvConfig.Config.CHESSMetaData
+	if cfg.Dir == "" {
+		return errors.New("bundles directory is not configured")
+	}
+	if meta.DBName == "" || meta.DBColl == "" {
+		return errors.New("MetaData database is not configured")
+	}
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		log.Printf("ERROR: unable to create bundles directory %s, error %v", cfg.Dir, err)
+		return err
+	}
+	dbname := cfg.DBName
+	if dbname == "" {
+		dbname = meta.DBName
+	}
+	e := NewExporter(storage.NewStore(dbname), storage.NewStore(meta.DBName), meta.DBColl, cfg, queue)
+	e.Bus = pubsub.MessageBus
+	Bundles = e
+	return nil
+}
+
+// helper function to convert bundle into storage record, query and files
+// are kept as JSON strings
+func toRecord(b Bundle) (map[string]any, error) {
+	query, err := json.Marshal(b.Query)
+	if err != nil {
+		return nil, err
+	}
+	files, err := json.Marshal(b.Files)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{
+		"_id":      b.ID,
+		"owner":    b.Owner,
+		"status":   b.Status,
+		"format":   b.Format,
+		"query":    string(query),
+		"files":    string(files),
+		"progress": b.Progress,
+		"records":  b.Records,
+		"size":     b.Size,
+		"checksum": b.Checksum,
+		"error":    b.Error,
+		"created":  b.Created,
+		"updated":  b.Updated,
+		"expires":  b.Expires,
+	}, nil
+}
+
+// helper function to convert storage record into bundle
+func fromRecord(rec map[string]any) (Bundle, error) {
+	var b Bundle
+	b.ID, _ = rec["_id"].(string)
+	b.Owner, _ = rec["owner"].(string)
+	b.Status, _ = rec["status"].(string)
+	b.Format, _ = rec["format"].(string)
+	b.Checksum, _ = rec["checksum"].(string)
+	b.Error, _ = rec["error"].(string)
+	b.Progress = int(utils.ToInt64(rec["progress"]))
+	b.Records = int(utils.ToInt64(rec["records"]))
+	b.Size = utils.ToInt64(rec["size"])
+	b.Created = utils.ToInt64(rec["created"])
+	b.Updated = utils.ToInt64(rec["updated"])
+	b.Expires = utils.ToInt64(rec["expires"])
+	if query, ok := rec["query"].(string); ok && query != "" {
+		if err := json.Unmarshal([]byte(query), &b.Query); err != nil {
+			return b, fmt.Errorf("unable to decode query of bundle %s, error %v", b.ID, err)
+		}
+	}
+	if files, ok := rec["files"].(string); ok && files != "" {
+		if err := json.Unmarshal([]byte(files), &b.Files); err != nil {
+			return b, fmt.Errorf("unable to decode files of bundle %s, error %v", b.ID, err)
+		}
+	}
+	return b, nil
+}
+
+// helper function to check selected files, every file should be allowed
+// by download policy for given claims
+func (e *Exporter) checkFiles(ctx context.Context, claims *authz.Claims, files []File) ([]File, error) {
+	if len(files) == 0 {
+		return nil, nil
+	}
+	if e.Downloader == nil {
+		return nil, fmt.Errorf("%w, selection of files is not enabled", ErrInvalid)
+	}
+	var out []File
+	var size int64
+	seen := make(map[File]bool)
+	for _, f := range files {
+		src, ok := e.Downloader.Sources[f.Source]
+		if !ok {
+			return nil, fmt.Errorf("%w, unknown source '%s'", ErrInvalid, f.Source)
+		}
+		fname, err := download.CleanPath(f.Path)
+		if err != nil {
+			return nil, fmt.Errorf("%w, %v", ErrInvalid, err)
+		}
+		f.Path = fname
+		if seen[f] {
+			continue
+		}
+		seen[f] = true
+		if _, err := e.Downloader.Authorize(ctx, claims, f.Source, fname); err != nil {
+			return nil, err
+		}
+		info, err := src.Stat(ctx, fname)
+		if err != nil {
+			return nil, fmt.Errorf("%w, unable to stat %s:%s, error %v", ErrInvalid, f.Source, fname, err)
+		}
+		size += info.Size
+		if e.MaxSize > 0 && size > e.MaxSize {
+			return nil, fmt.Errorf("%w, size of selected files exceeds limit of %d bytes", ErrInvalid, e.MaxSize)
+		}
+		out = append(out, f)
+	}
+	return out, nil
+}
+
+// Create validates bundle request of the user with given claims and
+// schedules its build. Selected files are authorized by download policy.
+func (e *Exporter) Create(ctx context.Context, owner string, claims *authz.Claims, req Request) (Bundle, error) {
+	if e.Queue == nil {
+		return Bundle{}, errors.New("bundles queue is not configured")
+	}
+	if owner == "" {
+		return Bundle{}, fmt.Errorf("%w, bundle without owner", ErrInvalid)
+	}
+	if req.Format == "" {
+		req.Format = FormatZip
+	}
+	if req.Format != FormatZip && req.Format != FormatTar && req.Format != FormatTarGz {
+		return Bundle{}, fmt.Errorf("%w, unsupported format '%s'", ErrInvalid, req.Format)
+	}
+	if req.Query == nil {
+		req.Query = make(map[string]any)
+	}
+	if err := searches.ValidateQuery(req.Query); err != nil {
+		return Bundle{}, fmt.Errorf("%w, %v", ErrInvalid, err)
+	}
+	nrec, err := e.Records.Count(ctx, e.RecordsCollection, req.Query)
+	if err != nil {
+		return Bundle{}, err
+	}
+	if nrec > int64(e.MaxRecords) {
+		return Bundle{}, fmt.Errorf("%w, query matches %d records, limit %d", ErrInvalid, nrec, e.MaxRecords)
+	}
+	files, err := e.checkFiles(ctx, claims, req.Files)
+	if err != nil {
+		return Bundle{}, err
+	}
+	now := time.Now().Unix()
+	b := Bundle{
+		ID:      uuid.NewString(),
+		Owner:   owner,
+		Status:  StatusQueued,
+		Format:  req.Format,
+		Query:   req.Query,
+		Files:   files,
+		Created: now,
+		Updated: now,
+	}
+	rec, err := toRecord(b)
+	if err != nil {
+		return b, err
+	}
+	if err := e.Store.Insert(ctx, e.Collection, rec); err != nil {
+		return b, err
+	}
+	if _, err := e.Queue.Enqueue(ctx, TaskType, map[string]string{"id": b.ID}, 0); err != nil {
+		log.Printf("ERROR: unable to schedule bundle %s, error %v", b.ID, err)
+		e.Store.Remove(ctx, e.Collection, map[string]any{"_id": b.ID})
+		return b, err
+	}
+	return b, nil
+}
+
+// Get returns bundle of given owner and id
+func (e *Exporter) Get(ctx context.Context, owner, id string) (Bundle, error) {
+	rec, err := storage.FindOne(ctx, e.Store, e.Collection, map[string]any{"_id": id, "owner": owner})
+	if errors.Is(err, storage.ErrNotFound) {
+		return Bundle{}, fmt.Errorf("%w '%s'", ErrNotFound, id)
+	}
+	if err != nil {
+		return Bundle{}, err
+	}
+	return fromRecord(rec)
+}
+
+// List returns bundles of given owner, the newest bundles come first
+func (e *Exporter) List(ctx context.Context, owner string) ([]Bundle, error) {
+	records, err := e.Store.Find(ctx, e.Collection, map[string]any{"owner": owner}, &storage.FindOptions{Sort: []string{"-created"}})
+	if err != nil {
+		return nil, err
+	}
+	out := []Bundle{}
+	for _, rec := range records {
+		b, err := fromRecord(rec)
+		if err != nil {
+			log.Println("ERROR:", err)
+			continue
+		}
+		out = append(out, b)
+	}
+	return out, nil
+}
+
+// Path returns path of bundle archive
+func (e *Exporter) Path(b Bundle) string {
+	return filepath.Join(e.Dir, b.FileName())
+}
+
+// Delete removes bundle of given owner and id along with its archive
+func (e *Exporter) Delete(ctx context.Context, owner, id string) error {
+	b, err := e.Get(ctx, owner, id)
+	if err != nil {
+		return err
+	}
+	return e.remove(ctx, b)
+}
+
+// helper function to remove bundle and its archive
+func (e *Exporter) remove(ctx context.Context, b Bundle) error {
+	if err := os.Remove(e.Path(b)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	_, err := e.Store.Remove(ctx, e.Collection, map[string]any{"_id": b.ID})
+	return err
+}
+
+// Purge removes bundles expired before given time and returns their number
+func (e *Exporter) Purge(ctx context.Context, now time.Time) (int, error) {
+	spec := map[string]any{"expires": map[string]any{"$gt": 0, "$lte": now.Unix()}}
+	records, err := e.Store.Find(ctx, e.Collection, spec, nil)
+	if err != nil {
+		return 0, err
+	}
+	var nrec int
+	for _, rec := range records {
+		b, err := fromRecord(rec)
+		if err != nil {
+			log.Println("ERROR:", err)
+			continue
+		}
+		if err := e.remove(ctx, b); err != nil {
+			log.Printf("ERROR: unable to remove bundle %s, error %v", b.ID, err)
+			return nrec, err
+		}
+		nrec++
+	}
+	return nrec, nil
+}
+
+// Run periodically removes expired bundles until context is cancelled
+func (e *Exporter) Run(ctx context.Context) {
+	ticker := time.NewTicker(PurgeInterval)
+	defer ticker.Stop()
+	for {
+		nrec, err := e.Purge(ctx, time.Now())
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("ERROR: purge of bundles failed, error %v", err)
+			}
+		} else if nrec > 0 {
+			log.Printf("purged %d expired bundles", nrec)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
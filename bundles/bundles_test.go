@@ -0,0 +1,317 @@
+package bundles
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	policy "github.com/CHESSComputing/golib/authz/policy"
+	srvConfig "github.com/CHESSComputing/golib/config"
+	datamgmt "github.com/CHESSComputing/golib/datamgmt"
+	download "github.com/CHESSComputing/golib/download"
+	jobs "github.com/CHESSComputing/golib/jobs"
+	pubsub "github.com/CHESSComputing/golib/pubsub"
+	storage "github.com/CHESSComputing/golib/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// testBus records published envelopes
+type testBus struct {
+	pubsub.Bus
+	mu       sync.Mutex
+	subjects []string
+	events   []Bundle
+}
+
+// Publish implements pubsub.Bus interface
+func (b *testBus) Publish(ctx context.Context, subject string, env pubsub.Envelope) error {
+	var bundle Bundle
+	if err := json.Unmarshal(env.Data, &bundle); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subjects = append(b.subjects, subject)
+	b.events = append(b.events, bundle)
+	return nil
+}
+
+// helper function to create exporter over memory stores and local source
+func testExporter(t *testing.T) (*Exporter, *jobs.Queue, *testBus) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "3a"), 0755)
+	os.WriteFile(filepath.Join(dir, "3a", "scan.dat"), bytes.Repeat([]byte("0123456789"), 100), 0644)
+	os.WriteFile(filepath.Join(dir, "3a", "secret.dat"), []byte("secret"), 0644)
+	engine, err := policy.NewEngine(map[string][]string{
+		download.Action: {"record.path != \"3a/secret.dat\""},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	downloader := download.NewDownloader(map[string]download.Source{"local": download.LocalSource{Root: dir}}, engine)
+	downloader.Loader = func(ctx context.Context, source, fname string) (map[string]any, error) {
+		return map[string]any{"beamline": "3a"}, nil
+	}
+
+	records := storage.NewMemoryStore()
+	records.Insert(context.Background(), "meta",
+		map[string]any{"did": "/a", "beamline": "3a"},
+		map[string]any{"did": "/b", "beamline": "3b"},
+		map[string]any{"did": "/c", "beamline": "3a"})
+	queue := jobs.NewQueue(jobs.NewMemoryStore())
+	cfg := srvConfig.Bundles{Dir: t.TempDir(), MaxRecords: 2}
+	e := NewExporter(storage.NewMemoryStore(), records, "meta", cfg, queue)
+	e.Downloader = downloader
+	bus := &testBus{}
+	e.Bus = bus
+	return e, queue, bus
+}
+
+// helper function to read entries of bundle archive
+func readArchive(t *testing.T, fname, format string) map[string][]byte {
+	entries := make(map[string][]byte)
+	if format == FormatZip {
+		r, err := zip.OpenReader(fname)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		for _, f := range r.File {
+			rc, err := f.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			entries[f.Name], _ = io.ReadAll(rc)
+			rc.Close()
+		}
+		return entries
+	}
+	file, err := os.Open(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var reader io.Reader = file
+	if format == FormatTarGz {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = gz
+	}
+	tr := tar.NewReader(reader)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[hdr.Name], _ = io.ReadAll(tr)
+	}
+	return entries
+}
+
+// TestBundle
+func TestBundle(t *testing.T) {
+	e, queue, bus := testExporter(t)
+	ctx := context.Background()
+	claims := &authz.Claims{CustomClaims: authz.CustomClaims{User: "alice"}}
+	for _, format := range []string{FormatZip, FormatTar, FormatTarGz} {
+		req := Request{
+			Query:  map[string]any{"beamline": "3a"},
+			Format: format,
+			Files:  []File{{Source: "local", Path: "/3a/scan.dat"}, {Source: "local", Path: "3a/scan.dat"}},
+		}
+		b, err := e.Create(ctx, "alice", claims, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b.Status != StatusQueued || len(b.Files) != 1 {
+			t.Errorf("wrong created bundle %+v", b)
+		}
+		if ok, err := queue.Process(ctx, "test"); !ok || err != nil {
+			t.Fatalf("bundle task is not processed, error %v", err)
+		}
+		b, err = e.Get(ctx, "alice", b.ID)
+		if err != nil || b.Status != StatusDone || b.Records != 2 || b.Progress != 100 || b.Expires == 0 {
+			t.Fatalf("wrong built bundle %+v, error %v", b, err)
+		}
+		data, err := os.ReadFile(e.Path(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum := sha256.Sum256(data)
+		if b.Checksum != hex.EncodeToString(sum[:]) || b.Size != int64(len(data)) {
+			t.Errorf("%s: wrong checksum or size of bundle %+v", format, b)
+		}
+
+		entries := readArchive(t, e.Path(b), format)
+		var records []map[string]any
+		if err := json.Unmarshal(entries[RecordsEntry], &records); err != nil || len(records) != 2 {
+			t.Errorf("%s: wrong records %s, error %v", format, entries[RecordsEntry], err)
+		}
+		if len(entries["files/local/3a/scan.dat"]) != 1000 {
+			t.Errorf("%s: selected file is not archived, entries %v", format, len(entries))
+		}
+		var manifest datamgmt.Manifest
+		if err := json.Unmarshal(entries[ManifestEntry], &manifest); err != nil || len(manifest.Entries) != 2 {
+			t.Fatalf("%s: wrong manifest %s, error %v", format, entries[ManifestEntry], err)
+		}
+		for _, entry := range manifest.Entries {
+			sum := sha256.Sum256(entries[entry.Path])
+			if entry.Checksums[datamgmt.SHA256] != hex.EncodeToString(sum[:]) {
+				t.Errorf("%s: wrong checksum of %s", format, entry.Path)
+			}
+		}
+	}
+
+	// progress is reported to the owner
+	bus.mu.Lock()
+	if len(bus.events) < 3 || bus.subjects[0] != "bundles.alice" || bus.events[len(bus.events)-1].Status != StatusDone {
+		t.Errorf("wrong progress events %v %+v", bus.subjects, bus.events)
+	}
+	bus.mu.Unlock()
+
+	// bundles are private
+	if list, err := e.List(ctx, "bob"); err != nil || len(list) != 0 {
+		t.Errorf("bundles of other user are listed %+v, error %v", list, err)
+	}
+	if list, err := e.List(ctx, "alice"); err != nil || len(list) != 3 {
+		t.Errorf("wrong list of bundles %+v, error %v", list, err)
+	}
+
+	invalid := []Request{
+		{Query: map[string]any{"$where": "1"}},
+		{Format: "rar"},
+		{Query: map[string]any{"beamline": "3a"}, Files: []File{{Source: "nfs", Path: "3a/scan.dat"}}},
+		{Query: map[string]any{"beamline": "3a"}, Files: []File{{Source: "local", Path: "../etc/passwd"}}},
+	}
+	for _, req := range invalid {
+		if _, err := e.Create(ctx, "alice", claims, req); !errors.Is(err, ErrInvalid) {
+			t.Errorf("invalid request %+v is accepted, error %v", req, err)
+		}
+	}
+	// query without constraints matches too many records
+	if _, err := e.Create(ctx, "alice", claims, Request{}); !errors.Is(err, ErrInvalid) {
+		t.Errorf("request exceeding records limit is accepted, error %v", err)
+	}
+	req := Request{Files: []File{{Source: "local", Path: "3a/secret.dat"}}, Query: map[string]any{"did": "/a"}}
+	if _, err := e.Create(ctx, "alice", claims, req); !errors.Is(err, download.ErrDenied) {
+		t.Errorf("file denied by policy is accepted, error %v", err)
+	}
+}
+
+// TestFailure
+func TestFailure(t *testing.T) {
+	e, queue, _ := testExporter(t)
+	ctx := context.Background()
+	claims := &authz.Claims{CustomClaims: authz.CustomClaims{User: "alice"}}
+	req := Request{Query: map[string]any{"did": "/a"}, Files: []File{{Source: "local", Path: "3a/scan.dat"}}}
+	b, err := e.Create(ctx, "alice", claims, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// selected file disappears before build
+	src := e.Downloader.Sources["local"].(download.LocalSource)
+	os.Remove(filepath.Join(src.Root, "3a", "scan.dat"))
+	if ok, err := queue.Process(ctx, "test"); !ok || err != nil {
+		t.Fatalf("bundle task is not processed, error %v", err)
+	}
+	b, err = e.Get(ctx, "alice", b.ID)
+	if err != nil || b.Status != StatusQueued || b.Error == "" {
+		t.Errorf("failed bundle is not rescheduled %+v, error %v", b, err)
+	}
+	task := &jobs.Task{Attempts: DefaultRetryPolicy.MaxAttempts, MaxAttempts: DefaultRetryPolicy.MaxAttempts}
+	task.Payload, _ = json.Marshal(map[string]string{"id": b.ID})
+	if err := e.handle(ctx, task); err != nil {
+		t.Errorf("last attempt returns error %v", err)
+	}
+	if b, err = e.Get(ctx, "alice", b.ID); err != nil || b.Status != StatusFailed {
+		t.Errorf("bundle is not failed %+v, error %v", b, err)
+	}
+	if _, err := os.Stat(e.Path(b)); !os.IsNotExist(err) {
+		t.Errorf("archive of failed bundle exists, error %v", err)
+	}
+
+	// expired bundles are purged
+	if nrec, err := e.Purge(ctx, time.Now().Add(e.TTL+time.Minute)); err != nil || nrec != 1 {
+		t.Errorf("expect one purged bundle, got %d, error %v", nrec, err)
+	}
+	if _, err := e.Get(ctx, "alice", b.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("purged bundle is found, error %v", err)
+	}
+}
+
+// TestHandlers
+func TestHandlers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	e, queue, _ := testExporter(t)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		if user := c.GetHeader("X-User"); user != "" {
+			c.Set("user", user)
+			c.Set("claims", &authz.Claims{CustomClaims: authz.CustomClaims{User: user}})
+		}
+	})
+	for _, route := range e.Routes("/bundles") {
+		r.Handle(route.Method, route.Path, route.Handler)
+	}
+	send := func(method, target, user, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("X-User", user)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	body := `{"query": {"beamline": "3a"}, "files": [{"source": "local", "path": "3a/scan.dat"}]}`
+	w := send("POST", "/bundles", "alice", body)
+	var b Bundle
+	if err := json.Unmarshal(w.Body.Bytes(), &b); err != nil || w.Code != http.StatusAccepted || w.Header().Get("Location") != "/bundles/"+b.ID {
+		t.Fatalf("unexpected response %d %s", w.Code, w.Body.String())
+	}
+	if w := send("GET", "/bundles/"+b.ID+"/download", "alice", ""); w.Code != http.StatusConflict {
+		t.Errorf("download of queued bundle: unexpected response %d %s", w.Code, w.Body.String())
+	}
+	if ok, err := queue.Process(context.Background(), "test"); !ok || err != nil {
+		t.Fatalf("bundle task is not processed, error %v", err)
+	}
+	expect := []struct {
+		method, target, user, body string
+		code                       int
+	}{
+		{"GET", "/bundles/" + b.ID, "alice", "", http.StatusOK},
+		{"GET", "/bundles/" + b.ID, "bob", "", http.StatusNotFound},
+		{"GET", "/bundles", "", "", http.StatusUnauthorized},
+		{"GET", "/bundles", "alice", "", http.StatusOK},
+		{"GET", "/bundles/" + b.ID + "/download", "bob", "", http.StatusNotFound},
+		{"GET", "/bundles/" + b.ID + "/download", "alice", "", http.StatusOK},
+		{"POST", "/bundles", "alice", `{"query": {"$where": 1}}`, http.StatusBadRequest},
+		{"POST", "/bundles", "alice", `{"query": {"did": "/a"}, "files": [{"source": "local", "path": "3a/secret.dat"}]}`, http.StatusForbidden},
+		{"POST", "/bundles", "alice", `[`, http.StatusBadRequest},
+		{"DELETE", "/bundles/" + b.ID, "bob", "", http.StatusNotFound},
+		{"DELETE", "/bundles/" + b.ID, "alice", "", http.StatusNoContent},
+	}
+	for _, e := range expect {
+		if w := send(e.method, e.target, e.user, e.body); w.Code != e.code {
+			t.Errorf("%s %s of %s: expect %d, got %d %s", e.method, e.target, e.user, e.code, w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package bundles
+
+// handlers module provides HTTP endpoints of archive bundles, every user
+// manages own bundles only
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	policy "github.com/CHESSComputing/golib/authz/policy"
+	download "github.com/CHESSComputing/golib/download"
+	server "github.com/CHESSComputing/golib/server"
+	services "github.com/CHESSComputing/golib/services"
+	"github.com/gin-gonic/gin"
+)
+
+// helper function to abort request with service error
+func abort(c *gin.Context, code, srvCode int, err error) {
+	rec := services.Response("bundles", code, srvCode, err)
+	c.AbortWithStatusJSON(code, rec)
+}
+
+// helper function to abort request with error of the exporter
+func abortWithError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrNotFound), errors.Is(err, policy.ErrRecordNotFound):
+		abort(c, http.StatusNotFound, services.QueryError, err)
+	case errors.Is(err, ErrInvalid):
+		abort(c, http.StatusBadRequest, services.ValidateError, err)
+	case errors.Is(err, download.ErrDenied):
+		abort(c, http.StatusForbidden, services.PolicyError, err)
+	default:
+		log.Printf("ERROR: bundles request failed, error %v", err)
+		abort(c, http.StatusInternalServerError, services.DatabaseError, err)
+	}
+}
+
+// helper function to provide user of the request
+func requestUser(c *gin.Context) (string, bool) {
+	user := c.GetString("user")
+	if user == "" {
+		abort(c, http.StatusUnauthorized, services.CredentialsError, errors.New("unknown user"))
+		return "", false
+	}
+	return user, true
+}
+
+// CreateHandler schedules new bundle of the user, the request body is JSON
+// bundle request with query, format and selected files
+func (e *Exporter) CreateHandler(c *gin.Context) {
+	user, ok := requestUser(c)
+	if !ok {
+		return
+	}
+	var req Request
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abort(c, http.StatusBadRequest, services.BindError, err)
+		return
+	}
+	var claims *authz.Claims
+	if val, ok := c.Get("claims"); ok {
+		claims, _ = val.(*authz.Claims)
+	}
+	if len(req.Files) > 0 && claims == nil {
+		abort(c, http.StatusUnauthorized, services.CredentialsError, errors.New("no token claims"))
+		return
+	}
+	b, err := e.Create(c.Request.Context(), user, claims, req)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Header("Location", c.FullPath()+"/"+b.ID)
+	c.JSON(http.StatusAccepted, b)
+}
+
+// ListHandler provides bundles of the user
+func (e *Exporter) ListHandler(c *gin.Context) {
+	user, ok := requestUser(c)
+	if !ok {
+		return
+	}
+	list, err := e.List(c.Request.Context(), user)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// GetHandler provides status of the bundle of the user
+func (e *Exporter) GetHandler(c *gin.Context) {
+	user, ok := requestUser(c)
+	if !ok {
+		return
+	}
+	b, err := e.Get(c.Request.Context(), user, c.Param("id"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, b)
+}
+
+// DownloadHandler provides archive of completed bundle of the user
+func (e *Exporter) DownloadHandler(c *gin.Context) {
+	user, ok := requestUser(c)
+	if !ok {
+		return
+	}
+	b, err := e.Get(c.Request.Context(), user, c.Param("id"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if b.Status != StatusDone {
+		abort(c, http.StatusConflict, services.ValidateError, errors.New("bundle is not ready, status "+b.Status))
+		return
+	}
+	c.Header("Digest", "sha-256="+b.Checksum)
+	c.FileAttachment(e.Path(b), b.FileName())
+}
+
+// DeleteHandler removes bundle of the user
+func (e *Exporter) DeleteHandler(c *gin.Context) {
+	user, ok := requestUser(c)
+	if !ok {
+		return
+	}
+	if err := e.Delete(c.Request.Context(), user, c.Param("id")); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// Routes returns server routes of bundles under given path, e.g. /bundles.
+// Bundles contain records readable by any user and files authorized by
+// download policy, therefore all routes require read scope only.
+func (e *Exporter) Routes(path string) []server.Route {
+	return []server.Route{
+		{Method: "GET", Path: path, Authorized: true, Scope: "read", Handler: e.ListHandler,
+			Summary: "list archive bundles"},
+		{Method: "POST", Path: path, Authorized: true, Scope: "read", Handler: e.CreateHandler,
+			Summary: "export search results to archive bundle"},
+		{Method: "GET", Path: path + "/:id", Authorized: true, Scope: "read", Handler: e.GetHandler,
+			Summary: "get status of archive bundle"},
+		{Method: "GET", Path: path + "/:id/download", Authorized: true, Scope: "read", Handler: e.DownloadHandler,
+			Summary: "download archive bundle"},
+		{Method: "DELETE", Path: path + "/:id", Authorized: true, Scope: "read", Handler: e.DeleteHandler,
+			Summary: "delete archive bundle"},
+	}
+}
@@ -0,0 +1,273 @@
+package catalog
+
+// catalog module walks directory trees (or remote listings) of data files
+// and produces metadata record skeletons for bulk ingestion into
+// CHESSMetaData service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	beamlines "github.com/CHESSComputing/golib/beamlines"
+	extractors "github.com/CHESSComputing/golib/extractors"
+)
+
+// FileInfo represents information about data file
+type FileInfo struct {
+	Path       string         `json:"path"` // path relative to the root
+	Size       int64          `json:"size"`
+	ModTime    time.Time      `json:"mod_time"`
+	Attributes map[string]any `json:"attributes,omitempty"` // attributes provided by extractors
+}
+
+// Lister defines interface to list files of a tree
+type Lister interface {
+	List(ctx context.Context, fn func(info FileInfo) error) error
+}
+
+// FSLister lists files of any fs.FS implementation, e.g. os.DirFS or SFTP
+// client adapter
+type FSLister struct {
+	FS fs.FS
+}
+
+// List implements Lister interface
+func (l FSLister) List(ctx context.Context, fn func(info FileInfo) error) error {
+	return fs.WalkDir(l.FS, ".", func(fpath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		finfo, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return fn(FileInfo{Path: fpath, Size: finfo.Size(), ModTime: finfo.ModTime().UTC()})
+	})
+}
+
+// GlobusLister lists files from JSON output of recursive Globus listing,
+// i.e. output of 'globus ls -r --format json' command
+type GlobusLister struct {
+	Reader io.Reader
+}
+
+// globusListing represents Globus ls JSON output
+type globusListing struct {
+	Data []struct {
+		Name         string `json:"name"`
+		Type         string `json:"type"`
+		Size         int64  `json:"size"`
+		LastModified string `json:"last_modified"`
+	} `json:"DATA"`
+}
+
+// List implements Lister interface
+func (l GlobusLister) List(ctx context.Context, fn func(info FileInfo) error) error {
+	var listing globusListing
+	if err := json.NewDecoder(l.Reader).Decode(&listing); err != nil {
+		return fmt.Errorf("unable to parse Globus listing, error %v", err)
+	}
+	for _, entry := range listing.Data {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if entry.Type != "file" {
+			continue
+		}
+		info := FileInfo{Path: entry.Name, Size: entry.Size}
+		if t, err := time.Parse("2006-01-02 15:04:05-07:00", entry.LastModified); err == nil {
+			info.ModTime = t.UTC()
+		}
+		if err := fn(info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Record represents metadata record skeleton of a dataset
+type Record struct {
+	Dataset  string         `json:"dataset"`  // dataset path relative to the root
+	Metadata map[string]any `json:"metadata"` // metadata record conforming to the schema
+	Files    []FileInfo     `json:"files"`    // list of dataset files
+	Size     int64          `json:"size"`     // total size of dataset files
+	Errors   []string       `json:"errors,omitempty"`
+}
+
+// Walker walks files of given lister and produces metadata records
+type Walker struct {
+	Lister     Lister                          // files lister
+	LocalRoot  string                          // local root directory used by extractors, if empty extractors are not used
+	Schema     *beamlines.Schema               // schema of metadata records
+	Extractors map[string]extractors.Extractor // extractors keyed by file extension, e.g. ".h5"
+	Registry   bool                            // use extractors registry for other files
+	Template   map[string]any                  // static metadata values, e.g. Beamline, Cycle
+	Mapping    map[string]string               // mapping of extracted attributes to schema keys
+	GroupBy    func(FileInfo) string
+	Verbose    int
+}
+
+// NewWalker returns walker of local directory
+func NewWalker(root string, schema *beamlines.Schema) *Walker {
+	return &Walker{
+		Lister:     FSLister{FS: os.DirFS(root)},
+		LocalRoot:  root,
+		Schema:     schema,
+		Extractors: make(map[string]extractors.Extractor),
+		Registry:   true,
+		Template:   make(map[string]any),
+		Mapping:    make(map[string]string),
+	}
+}
+
+// helper function to get dataset of given file, by default files are
+// grouped by their parent directory
+func (w *Walker) dataset(info FileInfo) string {
+	if w.GroupBy != nil {
+		return w.GroupBy(info)
+	}
+	return path.Dir(info.Path)
+}
+
+// helper function to find extractor of given file, extractors provided
+// to the walker take precedence over extractors registry
+func (w *Walker) extractor(fname string) extractors.Extractor {
+	if w.LocalRoot == "" {
+		return nil
+	}
+	if e, ok := w.Extractors[strings.ToLower(filepath.Ext(fname))]; ok {
+		return e
+	}
+	if w.Registry {
+		if e, err := extractors.Lookup(filepath.Join(w.LocalRoot, filepath.FromSlash(fname))); err == nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// Walk walks files and calls provided function for every dataset record.
+// Records are emitted once all files are listed, ordered by dataset name.
+func (w *Walker) Walk(ctx context.Context, emit func(rec Record) error) error {
+	records := make(map[string]*Record)
+	err := w.Lister.List(ctx, func(info FileInfo) error {
+		dataset := w.dataset(info)
+		rec, ok := records[dataset]
+		if !ok {
+			rec = &Record{Dataset: dataset, Metadata: make(map[string]any)}
+			records[dataset] = rec
+		}
+		if ext := w.extractor(info.Path); ext != nil {
+			fname := filepath.Join(w.LocalRoot, filepath.FromSlash(info.Path))
+			attrs, err := ext.Extract(fname)
+			if err != nil {
+				msg := fmt.Sprintf("unable to extract attributes from %s, error %v", info.Path, err)
+				if w.Verbose > 0 {
+					log.Println("WARNING:", msg)
+				}
+				rec.Errors = append(rec.Errors, msg)
+			}
+			info.Attributes = attrs
+			w.applyAttributes(rec.Metadata, attrs)
+		}
+		rec.Files = append(rec.Files, info)
+		rec.Size += info.Size
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	var datasets []string
+	for k := range records {
+		datasets = append(datasets, k)
+	}
+	sort.Strings(datasets)
+	for _, dataset := range datasets {
+		rec := records[dataset]
+		if err := w.skeleton(rec); err != nil {
+			return err
+		}
+		if err := emit(*rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// helper function to apply extracted attributes to metadata record, the
+// first file which provides given attribute wins
+func (w *Walker) applyAttributes(meta map[string]any, attrs map[string]any) {
+	for attr, key := range w.Mapping {
+		if val, ok := attrs[attr]; ok {
+			if _, exists := meta[key]; !exists {
+				meta[key] = val
+			}
+		}
+	}
+}
+
+// helper function to fill metadata record with template values and
+// schema defaults, and validate it against the schema
+func (w *Walker) skeleton(rec *Record) error {
+	for k, v := range w.Template {
+		rec.Metadata[k] = v
+	}
+	if w.Schema == nil {
+		return nil
+	}
+	if err := w.Schema.Load(); err != nil {
+		return err
+	}
+	if _, ok := w.Schema.Map["DataLocationRaw"]; ok && w.LocalRoot != "" {
+		if _, exists := rec.Metadata["DataLocationRaw"]; !exists {
+			rec.Metadata["DataLocationRaw"] = filepath.Join(w.LocalRoot, filepath.FromSlash(rec.Dataset))
+		}
+	}
+	for key, srec := range w.Schema.Map {
+		if _, ok := rec.Metadata[key]; ok {
+			continue
+		}
+		if !srec.Optional {
+			rec.Errors = append(rec.Errors, fmt.Sprintf("mandatory key %s is not set", key))
+		}
+		rec.Metadata[key] = defaultValue(srec)
+	}
+	sort.Strings(rec.Errors)
+	return nil
+}
+
+// helper function to provide default value of schema record
+func defaultValue(srec beamlines.SchemaRecord) any {
+	switch v := srec.Value.(type) {
+	case string, bool, float64, int, int64:
+		return v
+	}
+	if strings.HasPrefix(srec.Type, "list") {
+		return []any{}
+	}
+	switch {
+	case strings.HasPrefix(srec.Type, "int"):
+		return 0
+	case strings.HasPrefix(srec.Type, "float"):
+		return 0.0
+	case srec.Type == "bool":
+		return false
+	}
+	return ""
+}
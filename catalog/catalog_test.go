@@ -0,0 +1,89 @@
+package catalog
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	beamlines "github.com/CHESSComputing/golib/beamlines"
+	extractors "github.com/CHESSComputing/golib/extractors"
+)
+
+// TestWalker
+func TestWalker(t *testing.T) {
+	root := t.TempDir()
+	for _, fname := range []string{"scan1/a.dat", "scan1/b.txt", "scan2/c.dat"} {
+		fpath := filepath.Join(root, filepath.FromSlash(fname))
+		os.MkdirAll(filepath.Dir(fpath), 0755)
+		os.WriteFile(fpath, []byte(fname), 0644)
+	}
+	sfile := filepath.Join(root, "schema.yaml")
+	os.WriteFile(sfile, []byte(`
+- key: Beamline
+  optional: false
+  type: string
+- key: BeamEnergy
+  optional: false
+  type: int
+- key: DataLocationRaw
+  optional: true
+  type: string
+`), 0644)
+	schema := &beamlines.Schema{FileName: sfile}
+
+	walker := NewWalker(root, schema)
+	walker.GroupBy = func(info FileInfo) string {
+		if strings.HasSuffix(info.Path, ".yaml") {
+			return "schema"
+		}
+		return filepath.Dir(info.Path)
+	}
+	walker.Template["Beamline"] = "3a"
+	walker.Mapping["energy"] = "BeamEnergy"
+	walker.Extractors[".dat"] = extractors.ExtractorFunc(func(fname string) (map[string]any, error) {
+		return map[string]any{"energy": 42}, nil
+	})
+	var records []Record
+	err := walker.Walk(context.Background(), func(rec Record) error {
+		records = append(records, rec)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || records[0].Dataset != "scan1" {
+		t.Fatalf("wrong records %+v", records)
+	}
+	rec := records[0]
+	if len(rec.Files) != 2 || rec.Metadata["BeamEnergy"] != 42 || rec.Metadata["Beamline"] != "3a" || len(rec.Errors) != 0 {
+		t.Errorf("wrong scan1 record %+v", rec)
+	}
+	if rec.Metadata["DataLocationRaw"] != filepath.Join(root, "scan1") {
+		t.Errorf("wrong DataLocationRaw %v", rec.Metadata["DataLocationRaw"])
+	}
+	if err := schema.Validate(rec.Metadata); err != nil {
+		t.Errorf("record does not conform to the schema, error %v", err)
+	}
+	// schema group has no extracted energy
+	if len(records[2].Errors) != 1 {
+		t.Errorf("expect missing mandatory key error, got %v", records[2].Errors)
+	}
+}
+
+// TestGlobusLister
+func TestGlobusLister(t *testing.T) {
+	data := `{"DATA": [
+{"name": "raw/scan.h5", "type": "file", "size": 10, "last_modified": "2024-01-02 03:04:05+00:00"},
+{"name": "raw", "type": "dir", "size": 0, "last_modified": "2024-01-02 03:04:05+00:00"}]}`
+	var files []FileInfo
+	lister := GlobusLister{Reader: strings.NewReader(data)}
+	err := lister.List(context.Background(), func(info FileInfo) error {
+		files = append(files, info)
+		return nil
+	})
+	if err != nil || len(files) != 1 || files[0].Size != 10 || files[0].ModTime.Year() != 2024 {
+		t.Errorf("wrong Globus listing %+v, error %v", files, err)
+	}
+}
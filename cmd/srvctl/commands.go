@@ -0,0 +1,1191 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	audit "github.com/CHESSComputing/golib/audit"
+	authz "github.com/CHESSComputing/golib/authz"
+	login "github.com/CHESSComputing/golib/authz/login"
+	backup "github.com/CHESSComputing/golib/backup"
+	beamlines "github.com/CHESSComputing/golib/beamlines"
+	srvConfig "github.com/CHESSComputing/golib/config"
+	dbs "github.com/CHESSComputing/golib/dbs"
+	groups "github.com/CHESSComputing/golib/groups"
+	jobs "github.com/CHESSComputing/golib/jobs"
+	loadtest "github.com/CHESSComputing/golib/loadtest"
+	mail "github.com/CHESSComputing/golib/mail"
+	mongo "github.com/CHESSComputing/golib/mongo"
+	pubsub "github.com/CHESSComputing/golib/pubsub"
+	retention "github.com/CHESSComputing/golib/retention"
+	search "github.com/CHESSComputing/golib/search"
+	services "github.com/CHESSComputing/golib/services"
+	storage "github.com/CHESSComputing/golib/storage"
+	s3 "github.com/CHESSComputing/golib/storage/s3"
+)
+
+// helper function to print value as indented JSON
+func printJSON(w io.Writer, val any) error {
+	data, err := json.MarshalIndent(val, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(data))
+	return err
+}
+
+// helper function to split sub-command and its arguments
+func subCommand(args []string, usage string, names ...string) (string, []string, error) {
+	if len(args) > 0 {
+		for _, name := range names {
+			if args[0] == name {
+				return name, args[1:], nil
+			}
+		}
+	}
+	return "", nil, fmt.Errorf("usage: srvctl %s", usage)
+}
+
+// ValidateConfig checks server configuration via config.Validate and
+// constructors of library modules which read referenced files, e.g. DBS
+// file and mail templates. External services are not contacted.
+func ValidateConfig(cfg *srvConfig.SrvConfig) []error {
+	var errs []error
+	if err := srvConfig.Validate(*cfg); err != nil {
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			errs = append(errs, joined.Unwrap()...)
+		} else {
+			errs = append(errs, err)
+		}
+	}
+	if cfg.DataBookkeeping.DBFile != "" {
+		if _, _, err := dbs.ParseDBFile(cfg.DataBookkeeping.DBFile); err != nil {
+			errs = append(errs, fmt.Errorf("DataBookkeeping.DBFile: %v", err))
+		}
+	}
+	if cfg.SMTP.Host != "" && cfg.SMTP.TemplateDir != "" {
+		if _, err := mail.NewMailer(cfg.SMTP, &mail.MemorySender{}, nil); err != nil {
+			errs = append(errs, fmt.Errorf("SMTP.TemplateDir: %v", err))
+		}
+	}
+	return errs
+}
+
+// configCommand validates server configuration or dumps effective
+// configuration merged with profiles
+func configCommand(app *App, args []string) error {
+	sub, _, err := subCommand(args, "config validate|dump", "validate", "dump")
+	if err != nil {
+		return err
+	}
+	cfg, err := app.LoadConfig()
+	if err != nil {
+		return err
+	}
+	if sub == "dump" {
+		data, err := srvConfig.Dump(*cfg)
+		if err != nil {
+			return err
+		}
+		fmt.Fprint(app.Out, data)
+		return nil
+	}
+	for _, w := range srvConfig.Warnings {
+		fmt.Fprintln(app.Out, "WARNING:", w)
+	}
+	errs := ValidateConfig(cfg)
+	for _, err := range errs {
+		fmt.Fprintln(app.Out, "ERROR:", err)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("configuration has %d problem(s)", len(errs))
+	}
+	fmt.Fprintln(app.Out, "configuration is valid")
+	return nil
+}
+
+// tokenCommand issues or inspects JWT access tokens signed with Authz
+// client id
+func tokenCommand(app *App, args []string) error {
+	sub, args, err := subCommand(args, "token issue|inspect [options]", "issue", "inspect")
+	if err != nil {
+		return err
+	}
+	fset := newFlagSet(app, "token "+sub)
+	var claims authz.CustomClaims
+	var roles, certFile, jkt string
+	var expires int64
+	if sub == "issue" {
+		fset.StringVar(&claims.User, "user", "", "user name")
+		fset.StringVar(&claims.Scope, "scope", "read", "token scope: read, write or delete")
+		fset.StringVar(&roles, "roles", "", "comma separated list of roles")
+		fset.StringVar(&claims.Kind, "kind", "client_credentials", "token kind")
+		fset.StringVar(&claims.Application, "application", "srvctl", "application name")
+		fset.StringVar(&claims.Tenant, "tenant", "", "tenant name")
+		fset.Int64Var(&expires, "expires", 0, "token expiration in seconds, default is Authz token lifetime policy")
+		fset.StringVar(&certFile, "cert", "", "PEM client certificate to bind token to")
+		fset.StringVar(&jkt, "jkt", "", "thumbprint of DPoP key to bind token to")
+	}
+	if err := fset.Parse(args); err != nil {
+		return err
+	}
+	cfg, err := app.LoadConfig()
+	if err != nil {
+		return err
+	}
+	if cfg.Authz.ClientID == "" {
+		return errors.New("Authz ClientId is not configured")
+	}
+	if sub == "inspect" {
+		if fset.NArg() != 1 {
+			return errors.New("usage: srvctl token inspect <token>")
+		}
+		c, err := authz.TokenClaims(fset.Arg(0), cfg.Authz.ClientID)
+		if err != nil {
+			return err
+		}
+		return printJSON(app.Out, c)
+	}
+	if claims.User == "" {
+		return errors.New("token requires -user")
+	}
+	claims.Roles = splitList(roles)
+	var cnf *authz.Confirmation
+	if certFile != "" || jkt != "" {
+		cnf = &authz.Confirmation{JKT: jkt}
+	}
+	if certFile != "" {
+		data, err := os.ReadFile(certFile)
+		if err != nil {
+			return err
+		}
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return fmt.Errorf("%s does not contain PEM certificate", certFile)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return err
+		}
+		cnf.X5TS256 = authz.CertificateThumbprint(cert)
+	}
+	token, err := authz.BoundJWTAccessToken(cfg.Authz.ClientID, expires, claims, cnf)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(app.Out, token)
+	return nil
+}
+
+// helper function to provide session of credentials stored by login in
+// keyring of given kind: os, file or auto, i.e. OS keychain if it is
+// available or file otherwise
+func (a *App) loginSession(kind, authzURL string) (*login.Session, error) {
+	if authzURL == "" {
+		cfg, err := a.LoadConfig()
+		if err != nil {
+			return nil, err
+		}
+		authzURL = cfg.Services.AuthzURL
+	}
+	if authzURL == "" {
+		return nil, errors.New("Authz URL is not configured, use -authz flag")
+	}
+	var keyring login.Keyring
+	var err error
+	switch kind {
+	case "os":
+		keyring, err = login.SystemKeyring()
+	case "file":
+		keyring, err = login.NewFileKeyring("")
+	case "", "auto":
+		if keyring, err = login.SystemKeyring(); errors.Is(err, login.ErrNoKeyring) {
+			keyring, err = login.NewFileKeyring("")
+		}
+	default:
+		err = fmt.Errorf("unknown keyring '%s', supported keyrings: auto, os, file", kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return login.NewSession(keyring, authzURL), nil
+}
+
+// loginCommand obtains token of the user from Authz service via device
+// authorization or Kerberos ticket and stores it in keyring
+func loginCommand(app *App, args []string) error {
+	fset := newFlagSet(app, "login")
+	method := fset.String("method", login.MethodDevice, "login method: device or kerberos")
+	scope := fset.String("scope", "read", "token scope: read, write or delete")
+	clientID := fset.String("client", "foxden-cli", "client id of device authorization")
+	ccache := fset.String("ccache", "", "Kerberos credentials cache, default KRB5CCNAME or /tmp/krb5cc_<uid>")
+	authzURL := fset.String("authz", "", "Authz service URL, default Services AuthzUrl")
+	kind := fset.String("keyring", "auto", "keyring of credentials: auto, os (OS keychain) or file")
+	status := fset.Bool("status", false, "show stored credentials without login")
+	if err := fset.Parse(args); err != nil {
+		return err
+	}
+	session, err := app.loginSession(*kind, *authzURL)
+	if err != nil {
+		return err
+	}
+	if *status {
+		creds, err := session.Credentials()
+		if err != nil {
+			return err
+		}
+		state := "valid"
+		if creds.Expired(0) {
+			state = "expired"
+		}
+		fmt.Fprintf(app.Out, "logged in to %s via %s, scope %s, token is %s until %s\n",
+			creds.AuthzURL, creds.Method, creds.Scope, state, creds.Expires.Format(time.RFC3339))
+		return nil
+	}
+	ctx := context.Background()
+	var creds login.Credentials
+	switch *method {
+	case login.MethodDevice:
+		opts := login.DeviceOptions{
+			AuthzURL: session.AuthzURL,
+			ClientID: *clientID,
+			Scope:    *scope,
+			Prompt: func(code login.DeviceCode) {
+				if code.VerificationURIComplete != "" {
+					fmt.Fprintf(app.Out, "open %s in browser\n", code.VerificationURIComplete)
+				}
+				fmt.Fprintf(app.Out, "visit %s and enter code %s\n", code.VerificationURI, code.UserCode)
+			},
+		}
+		creds, err = login.DeviceLogin(ctx, opts)
+	case login.MethodKerberos:
+		creds, err = login.KerberosLogin(ctx, session.AuthzURL, *scope, *ccache, nil)
+	default:
+		return fmt.Errorf("unknown login method '%s', supported methods: device, kerberos", *method)
+	}
+	if err != nil {
+		return err
+	}
+	if err := session.Save(creds); err != nil {
+		return err
+	}
+	if keyring, ok := session.Keyring.(*login.FileKeyring); ok {
+		fmt.Fprintf(app.Out, "WARNING: OS keychain is not used, token is stored in %s\n", keyring.File)
+	}
+	fmt.Fprintf(app.Out, "logged in to %s, token expires at %s\n", creds.AuthzURL, creds.Expires.Format(time.RFC3339))
+	return nil
+}
+
+// logoutCommand removes credentials stored by login
+func logoutCommand(app *App, args []string) error {
+	fset := newFlagSet(app, "logout")
+	authzURL := fset.String("authz", "", "Authz service URL, default Services AuthzUrl")
+	kind := fset.String("keyring", "auto", "keyring of credentials: auto, os (OS keychain) or file")
+	if err := fset.Parse(args); err != nil {
+		return err
+	}
+	session, err := app.loginSession(*kind, *authzURL)
+	if err != nil {
+		return err
+	}
+	return session.Logout()
+}
+
+// apiKeyCommand issues, lists and revokes API keys stored in MongoDB
+func apiKeyCommand(app *App, args []string) error {
+	sub, args, err := subCommand(args, "apikey issue|list|revoke [options]", "issue", "list", "revoke")
+	if err != nil {
+		return err
+	}
+	fset := newFlagSet(app, "apikey "+sub)
+	dbname := fset.String("db", "foxden", "MongoDB database of API keys")
+	user := fset.String("user", "", "user name")
+	var name, scope, roles string
+	var ttl time.Duration
+	if sub == "issue" {
+		fset.StringVar(&name, "name", "", "description of the key, e.g. pipeline name")
+		fset.StringVar(&scope, "scope", "read", "key scope: read, write or delete")
+		fset.StringVar(&roles, "roles", "", "comma separated list of roles")
+		fset.DurationVar(&ttl, "ttl", 0, "key validity, e.g. 2160h, zero means key never expires")
+	}
+	if err := fset.Parse(args); err != nil {
+		return err
+	}
+	if err := app.initMongo(); err != nil {
+		return err
+	}
+	keys := authz.NewAPIKeys(storage.NewMongoStore(*dbname))
+	ctx := context.Background()
+	switch sub {
+	case "issue":
+		apiKey, rec, err := keys.Issue(ctx, *user, name, scope, splitList(roles), ttl)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(app.Out, "API key %s is issued for user %s, it is shown only once:\n%s\n", rec.ID, rec.User, apiKey)
+	case "list":
+		list, err := keys.List(ctx, *user)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(app.Out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tUSER\tNAME\tSCOPE\tEXPIRES\tREVOKED")
+		for _, k := range list {
+			expires := "never"
+			if k.Expires > 0 {
+				expires = time.Unix(k.Expires, 0).Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%v\n", k.ID, k.User, k.Name, k.Scope, expires, k.Revoked)
+		}
+		return w.Flush()
+	case "revoke":
+		if fset.NArg() != 1 {
+			return errors.New("usage: srvctl apikey revoke [options] <id>")
+		}
+		if err := keys.Revoke(ctx, fset.Arg(0)); err != nil {
+			return err
+		}
+		fmt.Fprintf(app.Out, "API key %s is revoked\n", fset.Arg(0))
+	}
+	return nil
+}
+
+// identityCommand links, unlinks or lists identities of OAuth providers and
+// Kerberos principals of canonical users
+func identityCommand(app *App, args []string) error {
+	sub, args, err := subCommand(args, "identity link|unlink|list [options]", "link", "unlink", "list")
+	if err != nil {
+		return err
+	}
+	fset := newFlagSet(app, "identity "+sub)
+	dbname := fset.String("db", "foxden", "MongoDB database of identities")
+	user := fset.String("user", "", "canonical user name")
+	var provider, subject, email string
+	if sub != "list" {
+		fset.StringVar(&provider, "provider", "", "identity provider, e.g. orcid, github or kerberos")
+		fset.StringVar(&subject, "subject", "", "provider subject id or Kerberos principal")
+	}
+	if sub == "link" {
+		fset.StringVar(&email, "email", "", "email of the identity")
+	}
+	if err := fset.Parse(args); err != nil {
+		return err
+	}
+	if err := app.initMongo(); err != nil {
+		return err
+	}
+	idents := authz.NewIdentities(storage.NewMongoStore(*dbname))
+	ctx := context.Background()
+	switch sub {
+	case "link":
+		ident, err := idents.Link(ctx, *user, provider, subject, email)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(app.Out, "identity %s is linked to user %s\n", ident.ID, ident.User)
+	case "unlink":
+		if err := idents.Unlink(ctx, *user, provider, subject); err != nil {
+			return err
+		}
+		fmt.Fprintf(app.Out, "identity %s:%s is unlinked from user %s\n", provider, subject, *user)
+	case "list":
+		list, err := idents.List(ctx, *user)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(app.Out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "USER\tPROVIDER\tSUBJECT\tEMAIL\tCREATED")
+		for _, i := range list {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", i.User, i.Provider, i.Subject, i.Email, time.Unix(i.Created, 0).Format(time.RFC3339))
+		}
+		return w.Flush()
+	}
+	return nil
+}
+
+// groupCommand manages groups of users stored in MongoDB and synchronizes
+// groups of LDAP directory of Groups configuration
+func groupCommand(app *App, args []string) error {
+	sub, args, err := subCommand(args, "group create|delete|list|show|add|remove|sync [options]",
+		"create", "delete", "list", "show", "add", "remove", "sync")
+	if err != nil {
+		return err
+	}
+	fset := newFlagSet(app, "group "+sub)
+	dbname := fset.String("db", "foxden", "MongoDB database of groups")
+	var description, members, nested string
+	switch sub {
+	case "create":
+		fset.StringVar(&description, "description", "", "description of the group")
+		fallthrough
+	case "add", "remove":
+		fset.StringVar(&members, "members", "", "comma separated list of user names")
+		fset.StringVar(&nested, "groups", "", "comma separated list of nested groups")
+	}
+	if err := fset.Parse(args); err != nil {
+		return err
+	}
+	name := fset.Arg(0)
+	if sub != "list" && sub != "sync" && (fset.NArg() != 1 || name == "") {
+		return fmt.Errorf("usage: srvctl group %s [options] <group>", sub)
+	}
+	if err := app.initMongo(); err != nil {
+		return err
+	}
+	var cfg srvConfig.Groups
+	if sub == "sync" {
+		c, err := app.LoadConfig()
+		if err != nil {
+			return err
+		}
+		cfg = c.Groups
+	}
+	m := groups.NewManager(storage.NewMongoStore(*dbname), cfg)
+	ctx := context.Background()
+	switch sub {
+	case "create":
+		g := groups.Group{Name: name, Description: description, Members: splitList(members), Groups: splitList(nested)}
+		if _, err := m.Create(ctx, g); err != nil {
+			return err
+		}
+		fmt.Fprintf(app.Out, "group %s is created\n", name)
+	case "delete":
+		if err := m.Delete(ctx, name); err != nil {
+			return err
+		}
+		fmt.Fprintf(app.Out, "group %s is deleted\n", name)
+	case "list":
+		list, err := m.List(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(app.Out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tSOURCE\tMEMBERS\tGROUPS\tDESCRIPTION")
+		for _, g := range list {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n", g.Name, g.Source, len(g.Members), strings.Join(g.Groups, ","), g.Description)
+		}
+		return w.Flush()
+	case "show":
+		g, err := m.Get(ctx, name)
+		if err != nil {
+			return err
+		}
+		all, err := m.Members(ctx, name)
+		if err != nil {
+			return err
+		}
+		return printJSON(app.Out, groups.GroupInfo{Group: g, AllMembers: all})
+	case "add", "remove":
+		if members == "" && nested == "" {
+			return fmt.Errorf("group %s requires -members or -groups", sub)
+		}
+		for _, user := range splitList(members) {
+			if sub == "add" {
+				err = m.AddMember(ctx, name, user)
+			} else {
+				err = m.RemoveMember(ctx, name, user)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		for _, g := range splitList(nested) {
+			if sub == "add" {
+				err = m.AddGroup(ctx, name, g)
+			} else {
+				err = m.RemoveGroup(ctx, name, g)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		fmt.Fprintf(app.Out, "group %s is updated\n", name)
+	case "sync":
+		if cfg.LDAP.URL == "" {
+			return errors.New("Groups LDAP URL is not configured")
+		}
+		res, err := m.Sync(ctx, groups.NewLDAPDirectory(cfg.LDAP))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(app.Out, "LDAP groups: %d created, %d updated, %d removed, %d skipped\n", res.Created, res.Updated, res.Removed, res.Skipped)
+	}
+	return nil
+}
+
+// migrationSets defines migration sets of library modules which can be
+// migrated along with DBS migrations
+var migrationSets = map[string]dbs.MigrationSet{
+	"tasks":  jobs.TasksMigrations,
+	"outbox": pubsub.OutboxMigrations,
+}
+
+// migrateCommand applies, reverts or shows migrations of DBS database
+func migrateCommand(app *App, args []string) error {
+	sub, args, err := subCommand(args, "migrate up|down|status [options]", "up", "down", "status")
+	if err != nil {
+		return err
+	}
+	fset := newFlagSet(app, "migrate "+sub)
+	dryRun := fset.Bool("dry-run", false, "print migrations without applying them")
+	names := fset.String("sets", "", "comma separated migration sets of library modules: outbox, tasks")
+	if err := fset.Parse(args); err != nil {
+		return err
+	}
+	var sets []dbs.MigrationSet
+	for _, name := range splitList(*names) {
+		set, ok := migrationSets[name]
+		if !ok {
+			return fmt.Errorf("unknown migration set '%s'", name)
+		}
+		sets = append(sets, set)
+	}
+	if _, err := app.LoadConfig(); err != nil {
+		return err
+	}
+	if err := dbs.InitDB(); err != nil {
+		return err
+	}
+	defer dbs.DB.DB.Close()
+	ctx := context.Background()
+	if sub != "status" {
+		srvConfig.MigrateAction = sub
+		srvConfig.MigrateDryRun = *dryRun
+		return dbs.Migrate(ctx, sets...)
+	}
+	w := tabwriter.NewWriter(app.Out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SET\tVERSION\tNAME\tAPPLIED")
+	for _, set := range append([]dbs.MigrationSet{dbs.DBSMigrations()}, sets...) {
+		migrations, err := set.Load(dbs.DB.Driver)
+		if err != nil {
+			return err
+		}
+		applied, err := set.Applied(ctx, dbs.DB)
+		if err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			fmt.Fprintf(w, "%s\t%d\t%s\t%v\n", set.Name, m.Version, m.Name, applied[m.Version])
+		}
+	}
+	return w.Flush()
+}
+
+// reindexCommand indexes records of MongoDB collection into search backend
+// of Search configuration
+func reindexCommand(app *App, args []string) error {
+	fset := newFlagSet(app, "reindex")
+	dbname := fset.String("db", "", "MongoDB database of records, default MetaData MongoDB DBName")
+	collection := fset.String("collection", "", "MongoDB collection of records, default MetaData MongoDB DBColl")
+	if err := fset.Parse(args); err != nil {
+		return err
+	}
+	cfg, err := app.LoadConfig()
+	if err != nil {
+		return err
+	}
+	index, err := search.New(cfg.Search)
+	if err != nil {
+		return err
+	}
+	if err := app.initMongo(); err != nil {
+		return err
+	}
+	ctx := context.Background()
+	switch idx := index.(type) {
+	case *search.MemoryIndex:
+		return errors.New("embedded search index is rebuilt by services at start")
+	case *search.MongoIndex:
+		// records are indexed by MongoDB text index
+		if err := idx.EnsureIndex(ctx); err != nil {
+			return err
+		}
+		fmt.Fprintf(app.Out, "text index of %s.%s is ensured\n", idx.DBName, idx.Collection)
+		return nil
+	case *search.OpenSearchIndex:
+		if err := idx.EnsureTemplate(ctx); err != nil {
+			return err
+		}
+		index = idx.NewBulkIndexer(cfg.Search.OpenSearch.BulkSize, cfg.Search.OpenSearch.FlushInterval)
+	}
+	if *dbname == "" {
+		*dbname = cfg.MetaData.MongoDB.DBName
+	}
+	if *collection == "" {
+		*collection = cfg.MetaData.MongoDB.DBColl
+	}
+	if *dbname == "" || *collection == "" {
+		return errors.New("records database and collection are not configured, use -db and -collection flags")
+	}
+	start := time.Now()
+	count, err := search.Reindex(ctx, index, storage.NewMongoStore(*dbname), *collection, cfg.Search.IDKey)
+	if bulk, ok := index.(*search.BulkIndexer); ok && err == nil {
+		err = bulk.Flush(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(app.Out, "%d records of %s.%s are indexed in %v\n", count, *dbname, *collection, time.Since(start))
+	return nil
+}
+
+// indexCommand creates missing or checks MongoDB indexes of MongoIndexes
+// configuration and indexed fields of CHESSMetaData schema files
+func indexCommand(app *App, args []string) error {
+	sub, args, err := subCommand(args, "index ensure|check [options]", "ensure", "check")
+	if err != nil {
+		return err
+	}
+	fset := newFlagSet(app, "index "+sub)
+	collections := fset.String("collections", "", "comma separated list of collections, default all configured collections")
+	if err := fset.Parse(args); err != nil {
+		return err
+	}
+	cfg, err := app.LoadConfig()
+	if err != nil {
+		return err
+	}
+	dbname := cfg.MongoIndexes.DBName
+	if dbname == "" {
+		dbname = cfg.CHESSMetaData.MongoDB.DBName
+	}
+	specs := mongo.IndexSpecs(cfg.MongoIndexes)
+	if coll := cfg.CHESSMetaData.MongoDB.DBColl; coll != "" {
+		indexed := make(map[string]bool)
+		for _, spec := range specs[coll] {
+			indexed[strings.Join(spec.Keys, ",")] = true
+		}
+		for _, fname := range cfg.CHESSMetaData.SchemaFiles {
+			schema := &beamlines.Schema{FileName: fname}
+			keys, err := schema.IndexKeys()
+			if err != nil {
+				return err
+			}
+			for _, key := range keys {
+				if !indexed[key] {
+					specs[coll] = append(specs[coll], mongo.IndexSpec{Keys: []string{key}})
+					indexed[key] = true
+				}
+			}
+		}
+	}
+	if only := splitList(*collections); len(only) > 0 {
+		selected := make(map[string][]mongo.IndexSpec)
+		for _, coll := range only {
+			if _, ok := specs[coll]; !ok {
+				return fmt.Errorf("indexes of collection '%s' are not configured", coll)
+			}
+			selected[coll] = specs[coll]
+		}
+		specs = selected
+	}
+	if len(specs) == 0 {
+		return errors.New("no indexes are configured, see MongoIndexes configuration")
+	}
+	if dbname == "" {
+		return errors.New("database of indexes is not configured, see MongoIndexes DBName")
+	}
+	if err := app.initMongo(); err != nil {
+		return err
+	}
+	var names []string
+	for coll := range specs {
+		names = append(names, coll)
+	}
+	sort.Strings(names)
+	ctx := context.Background()
+	ok := true
+	w := tabwriter.NewWriter(app.Out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "COLLECTION\tSTATUS\tINDEX")
+	for _, coll := range names {
+		report, err := mongo.EnsureIndexes(ctx, dbname, coll, specs[coll], sub == "ensure")
+		if err != nil {
+			return err
+		}
+		ok = ok && report.OK()
+		for _, name := range report.Created {
+			fmt.Fprintf(w, "%s\tcreated\t%s\n", coll, name)
+		}
+		for _, name := range report.Missing {
+			fmt.Fprintf(w, "%s\tmissing\t%s\n", coll, name)
+		}
+		for _, msg := range report.Divergent {
+			fmt.Fprintf(w, "%s\tdivergent\t%s\n", coll, msg)
+		}
+		for _, name := range report.Unknown {
+			fmt.Fprintf(w, "%s\tunknown\t%s\n", coll, name)
+		}
+		if report.OK() && len(report.Created) == 0 && len(report.Unknown) == 0 {
+			fmt.Fprintf(w, "%s\tok\t%d indexes\n", coll, len(specs[coll]))
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("indexes of collections do not match specification")
+	}
+	return nil
+}
+
+// helper function to provide S3 client of backup archives, S3 store of
+// DataManagement configuration is used if Backup S3 store is not configured
+func backupClient(cfg *srvConfig.SrvConfig) *s3.Client {
+	if cfg.Backup.S3.Endpoint != "" {
+		return s3.NewClient(cfg.Backup.S3)
+	}
+	if cfg.DataManagement.S3.Endpoint != "" {
+		return s3.NewClient(cfg.DataManagement.S3)
+	}
+	return nil
+}
+
+// helper function to initialize configured document storage backend and
+// provide store of given database
+func (a *App) documentStore(dbname string) (storage.Store, error) {
+	cfg, err := a.LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+	if strings.EqualFold(cfg.Storage.Backend, "postgres") {
+		if err := storage.Init(); err != nil {
+			return nil, err
+		}
+	} else if err := a.initMongo(); err != nil {
+		return nil, err
+	}
+	return storage.NewStore(dbname), nil
+}
+
+// backupCommand writes collections of metadata store into backup archive
+func backupCommand(app *App, args []string) error {
+	fset := newFlagSet(app, "backup")
+	dbname := fset.String("db", "", "database of collections, default CHESSMetaData MongoDB DBName")
+	collections := fset.String("collections", "", "comma separated list of collections, default Backup Collections")
+	output := fset.String("output", "", "location of archive, default new archive in Backup Location")
+	incremental := fset.String("incremental", "", "location of previous archive, records modified since previous backup are written")
+	since := fset.String("since", "", "write records modified since given RFC3339 time")
+	if err := fset.Parse(args); err != nil {
+		return err
+	}
+	cfg, err := app.LoadConfig()
+	if err != nil {
+		return err
+	}
+	if *dbname == "" {
+		*dbname = cfg.CHESSMetaData.MongoDB.DBName
+	}
+	opts := backup.Options{
+		Collections:   cfg.Backup.Collections,
+		TimestampKeys: cfg.Backup.TimestampKeys,
+		Secret:        cfg.Backup.Secret,
+		BatchSize:     cfg.Backup.BatchSize,
+	}
+	if list := splitList(*collections); len(list) > 0 {
+		opts.Collections = list
+	}
+	if *dbname == "" || len(opts.Collections) == 0 {
+		return errors.New("database and collections are not configured, use -db and -collections flags")
+	}
+	client := backupClient(cfg)
+	ctx := context.Background()
+	if *since != "" {
+		if opts.Since, err = time.Parse(time.RFC3339, *since); err != nil {
+			return fmt.Errorf("invalid since time, error %v", err)
+		}
+	} else if *incremental != "" {
+		// previous archive is verified and its start time is used
+		err := backup.ReadArchive(ctx, *incremental, client, func(r io.Reader) error {
+			manifest, err := backup.Verify(r, cfg.Backup.Secret)
+			opts.Since = manifest.Created
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	location := *output
+	if location == "" {
+		if cfg.Backup.Location == "" {
+			return errors.New("location of archives is not configured, use -output flag")
+		}
+		name := fmt.Sprintf("%s-%s", *dbname, time.Now().UTC().Format("20060102T150405Z"))
+		if !opts.Since.IsZero() {
+			name += "-incr"
+		}
+		location = backup.ArchiveLocation(cfg.Backup.Location, name+".backup")
+	}
+	store, err := app.documentStore(*dbname)
+	if err != nil {
+		return err
+	}
+	var manifest backup.Manifest
+	err = backup.WriteArchive(ctx, location, client, func(w io.Writer) error {
+		manifest, err = backup.Backup(ctx, store, w, opts)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(app.Out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "COLLECTION\tRECORDS")
+	for _, coll := range manifest.Collections {
+		fmt.Fprintf(w, "%s\t%d\n", coll, manifest.Records[coll])
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(app.Out, "backup of %s is written to %s in %v\n", *dbname, location, time.Since(manifest.Created).Round(time.Millisecond))
+	return nil
+}
+
+// restoreCommand verifies backup archive and restores its records into
+// metadata store
+func restoreCommand(app *App, args []string) error {
+	fset := newFlagSet(app, "restore")
+	dbname := fset.String("db", "", "database of collections, default CHESSMetaData MongoDB DBName")
+	collections := fset.String("collections", "", "comma separated list of restored collections, default all collections of archive")
+	drop := fset.Bool("drop", false, "remove records of collections before restore of full backup")
+	verify := fset.Bool("verify", false, "verify archive without restore")
+	if err := fset.Parse(args); err != nil {
+		return err
+	}
+	if fset.NArg() != 1 {
+		return errors.New("usage: srvctl restore [options] <archive>")
+	}
+	location := fset.Arg(0)
+	cfg, err := app.LoadConfig()
+	if err != nil {
+		return err
+	}
+	if *dbname == "" {
+		*dbname = cfg.CHESSMetaData.MongoDB.DBName
+	}
+	client := backupClient(cfg)
+	ctx := context.Background()
+	var manifest backup.Manifest
+	err = backup.ReadArchive(ctx, location, client, func(r io.Reader) error {
+		manifest, err = backup.Verify(r, cfg.Backup.Secret)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	kind := "full"
+	if manifest.Incremental() {
+		kind = "incremental since " + manifest.Since.Format(time.RFC3339)
+	}
+	fmt.Fprintf(app.Out, "archive %s is verified: %s backup of %s\n", location, kind, manifest.Created.Format(time.RFC3339))
+	if *verify {
+		return nil
+	}
+	if *dbname == "" {
+		return errors.New("database is not configured, use -db flag")
+	}
+	store, err := app.documentStore(*dbname)
+	if err != nil {
+		return err
+	}
+	opts := backup.Options{
+		Collections: splitList(*collections),
+		Secret:      cfg.Backup.Secret,
+		BatchSize:   cfg.Backup.BatchSize,
+		Drop:        *drop,
+	}
+	err = backup.ReadArchive(ctx, location, client, func(r io.Reader) error {
+		_, err := backup.Restore(ctx, store, r, opts)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(app.Out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "COLLECTION\tRECORDS")
+	for _, coll := range manifest.Collections {
+		if len(opts.Collections) == 0 || slices.Contains(opts.Collections, coll) {
+			fmt.Fprintf(w, "%s\t%d\n", coll, manifest.Records[coll])
+		}
+	}
+	return w.Flush()
+}
+
+// retentionCommand reports or applies retention policies of metadata
+// records
+func retentionCommand(app *App, args []string) error {
+	sub, args, err := subCommand(args, "retention report|apply [options]", "report", "apply")
+	if err != nil {
+		return err
+	}
+	fset := newFlagSet(app, "retention "+sub)
+	names := fset.String("policies", "", "comma separated list of applied policies, default all configured policies")
+	auditDB := fset.String("audit", "", "database of audit records of applied policies, default CHESSMetaData MongoDB DBName")
+	if err := fset.Parse(args); err != nil {
+		return err
+	}
+	cfg, err := app.LoadConfig()
+	if err != nil {
+		return err
+	}
+	if len(cfg.Retention.Policies) == 0 {
+		return errors.New("retention policies are not configured")
+	}
+	if *auditDB == "" {
+		*auditDB = cfg.CHESSMetaData.MongoDB.DBName
+	}
+	store, err := app.documentStore(*auditDB)
+	if err != nil {
+		return err
+	}
+	audit.Init(audit.NewDocumentStore(store), app.Verbose)
+	if err := retention.Init(); err != nil {
+		return err
+	}
+	manager := retention.Retention
+	if only := splitList(*names); len(only) > 0 {
+		var policies []retention.Policy
+		for _, name := range only {
+			idx := slices.IndexFunc(manager.Policies, func(p retention.Policy) bool { return p.Name == name })
+			if idx < 0 {
+				return fmt.Errorf("unknown retention policy %s", name)
+			}
+			policies = append(policies, manager.Policies[idx])
+		}
+		manager.Policies = policies
+	}
+	dryRun := sub == "report" || cfg.Retention.DryRun
+	report, err := manager.Apply(context.Background(), time.Now(), dryRun)
+	w := tabwriter.NewWriter(app.Out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "POLICY\tACTION\tCOLLECTION\tMATCHED\tPROCESSED\tFILES\tRECORDS")
+	for _, res := range report.Results {
+		records := strings.Join(res.IDs, ",")
+		if res.Error != "" {
+			records = "ERROR: " + res.Error
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%d\t%s\n", res.Policy, res.Action, res.Collection, res.Matched, res.Processed, res.Files, records)
+	}
+	if ferr := w.Flush(); ferr != nil && err == nil {
+		err = ferr
+	}
+	if dryRun && err == nil {
+		fmt.Fprintln(app.Out, "dry run: records are not modified")
+	}
+	return err
+}
+
+// queueCommand lists, shows and requeues tasks of jobs queue
+func queueCommand(app *App, args []string) error {
+	sub, args, err := subCommand(args, "queue list|show|requeue [options]", "list", "show", "requeue")
+	if err != nil {
+		return err
+	}
+	fset := newFlagSet(app, "queue "+sub)
+	dbname := fset.String("db", "", "MongoDB database of tasks")
+	dbfile := fset.String("dbfile", "", "SQL database of tasks, e.g. sqlite3:///path/tasks.db")
+	status := fset.String("status", jobs.StatusDead, "status of listed tasks: queued, running, done or dead")
+	limit := fset.Int("limit", 20, "maximum number of listed tasks")
+	if err := fset.Parse(args); err != nil {
+		return err
+	}
+	var store jobs.Store
+	switch {
+	case *dbfile != "":
+		conn, err := dbs.Open(*dbfile, 1, 1)
+		if err != nil {
+			return err
+		}
+		defer conn.DB.Close()
+		store = jobs.NewSQLStore(conn)
+	case *dbname != "":
+		if err := app.initMongo(); err != nil {
+			return err
+		}
+		store = jobs.NewMongoStore(*dbname)
+	default:
+		return errors.New("tasks database is not provided, use -db or -dbfile flag")
+	}
+	ctx := context.Background()
+	switch sub {
+	case "list":
+		tasks, err := store.List(ctx, *status, *limit)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(app.Out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tTYPE\tSTATUS\tATTEMPTS\tUPDATED\tLAST ERROR")
+		for _, t := range tasks {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d/%d\t%s\t%s\n", t.ID, t.Type, t.Status,
+				t.Attempts, t.MaxAttempts, t.Updated.Format(time.RFC3339), t.LastError)
+		}
+		return w.Flush()
+	case "show", "requeue":
+		if fset.NArg() != 1 {
+			return fmt.Errorf("usage: srvctl queue %s [options] <id>", sub)
+		}
+		id := fset.Arg(0)
+		if sub == "show" {
+			task, err := store.Get(ctx, id)
+			if err != nil {
+				return err
+			}
+			return printJSON(app.Out, task)
+		}
+		if err := jobs.NewQueue(store).Requeue(ctx, id); err != nil {
+			return err
+		}
+		fmt.Fprintf(app.Out, "task %s is requeued\n", id)
+	}
+	return nil
+}
+
+// helper function to provide URLs of services from configuration
+func serviceURLs(cfg *srvConfig.SrvConfig) map[string]string {
+	return map[string]string{
+		"frontend":        cfg.Services.FrontendURL,
+		"discovery":       cfg.Services.DiscoveryURL,
+		"metadata":        cfg.Services.MetaDataURL,
+		"datamanagement":  cfg.Services.DataManagementURL,
+		"databookkeeping": cfg.Services.DataBookkeepingURL,
+		"authz":           cfg.Services.AuthzURL,
+	}
+}
+
+// helper function to resolve URL of the service, explicit URL takes
+// precedence over service name looked up in configuration
+func serviceURL(app *App, name, rurl string) (string, error) {
+	if rurl != "" {
+		return rurl, nil
+	}
+	if name == "" {
+		return "", errors.New("service is not provided, use -service or -url flag")
+	}
+	cfg, err := app.LoadConfig()
+	if err != nil {
+		return "", err
+	}
+	urls := serviceURLs(cfg)
+	if _, ok := urls[name]; !ok {
+		var names []string
+		for n := range urls {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return "", fmt.Errorf("unknown service '%s', supported services: %s", name, strings.Join(names, ", "))
+	}
+	if urls[name] == "" {
+		return "", fmt.Errorf("URL of %s service is not configured", name)
+	}
+	return urls[name], nil
+}
+
+// metricsCommand dumps Prometheus metrics of the service
+func metricsCommand(app *App, args []string) error {
+	fset := newFlagSet(app, "metrics")
+	name := fset.String("service", "", "service name: authz, databookkeeping, datamanagement, discovery, frontend or metadata")
+	rurl := fset.String("url", "", "service URL, it takes precedence over -service")
+	filter := fset.String("filter", "", "print only metrics which contain given string")
+	if err := fset.Parse(args); err != nil {
+		return err
+	}
+	target, err := serviceURL(app, *name, *rurl)
+	if err != nil {
+		return err
+	}
+	resp, err := services.NewHttpRequest("read", app.Verbose).Get(strings.TrimSuffix(target, "/") + "/metrics")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("metrics request failed with status %s", resp.Status)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if *filter == "" || strings.Contains(line, *filter) {
+			fmt.Fprintln(app.Out, line)
+		}
+	}
+	return nil
+}
+
+// loadtestCommand replays recorded traffic or sends synthetic metadata
+// queries and inserts to the service and reports latency percentiles
+func loadtestCommand(app *App, args []string) error {
+	fset := newFlagSet(app, "loadtest")
+	name := fset.String("service", "", "service name: authz, databookkeeping, datamanagement, discovery, frontend or metadata")
+	rurl := fset.String("url", "", "service URL, it takes precedence over -service")
+	replay := fset.String("replay", "", "NDJSON file of recorded requests, synthetic requests are sent if not provided")
+	loop := fset.Bool("loop", false, "replay recorded requests in a loop")
+	insertRatio := fset.Float64("insert-ratio", 0, "fraction of synthetic insert requests, from 0 to 1")
+	schema := fset.String("schema", "", "schema of synthetic records")
+	seed := fset.Int64("seed", 0, "seed of synthetic requests")
+	token := fset.String("token", "", "bearer token of requests, default token stored by srvctl login")
+	asJSON := fset.Bool("json", false, "print report in JSON format")
+	var opts loadtest.Options
+	fset.IntVar(&opts.Concurrency, "concurrency", 1, "number of concurrent workers")
+	fset.IntVar(&opts.Requests, "requests", 0, "total number of requests, default 100 for synthetic or looped requests")
+	fset.DurationVar(&opts.Duration, "duration", 0, "duration of the test, e.g. 1m")
+	fset.Float64Var(&opts.Rate, "rate", 0, "maximum number of requests per second")
+	fset.DurationVar(&opts.Timeout, "timeout", 30*time.Second, "request timeout")
+	if err := fset.Parse(args); err != nil {
+		return err
+	}
+	target, err := serviceURL(app, *name, *rurl)
+	if err != nil {
+		return err
+	}
+	opts.Target = target
+	opts.Token = *token
+	if opts.Token == "" {
+		// token stored by login is used if it is available
+		if session, err := app.loginSession("auto", ""); err == nil {
+			opts.Token, _ = session.Token(context.Background())
+		}
+	}
+
+	var gen loadtest.Generator
+	if *replay != "" {
+		file, err := os.Open(*replay)
+		if err != nil {
+			return err
+		}
+		reqs, err := loadtest.ReadRequests(file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+		gen = &loadtest.Replay{Requests: reqs, Loop: *loop}
+	} else {
+		if *insertRatio < 0 || *insertRatio > 1 {
+			return fmt.Errorf("invalid insert ratio %v", *insertRatio)
+		}
+		gen = &loadtest.Synthetic{InsertRatio: *insertRatio, Schema: *schema, Seed: *seed}
+	}
+	// endless generators are limited by number of requests
+	if opts.Requests == 0 && opts.Duration == 0 && (*replay == "" || *loop) {
+		opts.Requests = 100
+	}
+	rep, err := loadtest.Run(context.Background(), opts, gen)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(app.Out, rep)
+	}
+	_, err = fmt.Fprintln(app.Out, rep.String())
+	return err
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+)
+
+//go:embed templates/srv.yaml.tmpl
+var templatesFS embed.FS
+
+// optional configuration sections which can be enabled by init command
+var initSections = []string{"messagebus", "search", "upload", "quota", "smtp"}
+
+// servicePorts represents ports of FOXDEN services
+type servicePorts struct {
+	Frontend        int
+	Discovery       int
+	MetaData        int
+	DataManagement  int
+	DataBookkeeping int
+	Authz           int
+}
+
+// initOptions represents parameters of generated configuration
+type initOptions struct {
+	Date             string
+	Host             string
+	HTTPS            bool
+	Ports            servicePorts
+	MongoURI         string
+	DBName           string
+	AuthzDB          string
+	ClientID         string
+	ClientSecret     string
+	EncryptionSecret string
+
+	// optional sections
+	MessageBus bool
+	NATSURL    string
+	Search     bool
+	Upload     bool
+	UploadDir  string
+	Quota      bool
+	SMTP       bool
+	SMTPHost   string
+	SMTPFrom   string
+	AdminEmail string
+}
+
+// helper function to generate random secret of given number of bytes
+func randomSecret(size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// helper function to enable optional section
+func (o *initOptions) enable(section string, on bool) error {
+	switch section {
+	case "messagebus":
+		o.MessageBus = on
+	case "search":
+		o.Search = on
+	case "upload":
+		o.Upload = on
+	case "quota":
+		o.Quota = on
+	case "smtp":
+		o.SMTP = on
+	default:
+		return fmt.Errorf("unknown section '%s', supported sections: %s", section, strings.Join(initSections, ", "))
+	}
+	return nil
+}
+
+// helper function to check if optional section is enabled
+func (o *initOptions) enabled(section string) bool {
+	switch section {
+	case "messagebus":
+		return o.MessageBus
+	case "search":
+		return o.Search
+	case "upload":
+		return o.Upload
+	case "quota":
+		return o.Quota
+	case "smtp":
+		return o.SMTP
+	}
+	return false
+}
+
+// Render renders configuration file of given options
+func (o *initOptions) Render() ([]byte, error) {
+	scheme := "http"
+	if o.HTTPS {
+		scheme = "https"
+	}
+	funcs := template.FuncMap{
+		"url": func(port int) string {
+			return fmt.Sprintf("%s://%s:%d", scheme, o.Host, port)
+		},
+		// JSON strings are valid YAML double quoted strings
+		"quote": func(val string) (string, error) {
+			data, err := json.Marshal(val)
+			return string(data), err
+		},
+	}
+	tmpl, err := template.New("srv.yaml.tmpl").Funcs(funcs).ParseFS(templatesFS, "templates/srv.yaml.tmpl")
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, o); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// prompter asks questions with default answers
+type prompter struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+// helper function to ask question, default value is returned on empty
+// answer
+func (p *prompter) ask(question, def string) (string, error) {
+	if def != "" {
+		fmt.Fprintf(p.out, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(p.out, "%s: ", question)
+	}
+	line, err := p.in.ReadString('\n')
+	if err != nil && !(errors.Is(err, io.EOF) && line != "") {
+		return "", fmt.Errorf("no answer to '%s', error %v", question, err)
+	}
+	if line = strings.TrimSpace(line); line == "" {
+		return def, nil
+	}
+	return line, nil
+}
+
+// helper function to ask yes/no question
+func (p *prompter) confirm(question string, def bool) (bool, error) {
+	hint := "y/N"
+	if def {
+		hint = "Y/n"
+	}
+	for {
+		answer, err := p.ask(question+" ("+hint+")", "")
+		if err != nil {
+			return false, err
+		}
+		switch strings.ToLower(answer) {
+		case "":
+			return def, nil
+		case "y", "yes":
+			return true, nil
+		case "n", "no":
+			return false, nil
+		}
+	}
+}
+
+// question represents question about configuration parameter
+type question struct {
+	text string
+	val  *string
+}
+
+// helper function to ask given questions
+func (p *prompter) askAll(questions []question) error {
+	for _, q := range questions {
+		answer, err := p.ask(q.text, *q.val)
+		if err != nil {
+			return err
+		}
+		*q.val = answer
+	}
+	return nil
+}
+
+// helper function to ask parameters of configuration interactively, flag
+// values are used as default answers
+func (o *initOptions) ask(p *prompter) error {
+	questions := []question{
+		{"Host name of FOXDEN services", &o.Host},
+		{"MongoDB URI", &o.MongoURI},
+		{"MongoDB database name", &o.DBName},
+		{"Authz database", &o.AuthzDB},
+	}
+	if err := p.askAll(questions); err != nil {
+		return err
+	}
+	var err error
+	if o.HTTPS, err = p.confirm("Are services served over HTTPS?", o.HTTPS); err != nil {
+		return err
+	}
+	for _, section := range initSections {
+		on, err := p.confirm("Enable "+section+" section?", o.enabled(section))
+		if err != nil {
+			return err
+		}
+		o.enable(section, on)
+	}
+	questions = nil
+	if o.MessageBus {
+		questions = append(questions, question{"NATS server URL", &o.NATSURL})
+	}
+	if o.Upload {
+		questions = append(questions, question{"Upload staging directory", &o.UploadDir})
+	}
+	if o.SMTP {
+		questions = append(questions,
+			question{"SMTP server host", &o.SMTPHost},
+			question{"Sender address", &o.SMTPFrom},
+			question{"Admin email", &o.AdminEmail})
+	}
+	return p.askAll(questions)
+}
+
+// helper function to write configuration file, the content is written
+// into temporary file in the same directory which is validated before it
+// is renamed to the target file
+func writeConfig(fname string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(fname), ".srvctl-*.yaml")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	cfg, err := srvConfig.ParseConfig(tmp.Name())
+	if err != nil {
+		return fmt.Errorf("unable to parse generated configuration, error %v", err)
+	}
+	if err := srvConfig.Validate(cfg); err != nil {
+		return fmt.Errorf("generated configuration is not valid:\n%v", err)
+	}
+	return os.Rename(tmp.Name(), fname)
+}
+
+// initCommand generates server configuration with random secrets
+func initCommand(app *App, args []string) error {
+	opts := initOptions{
+		Ports:     servicePorts{Frontend: 8344, Discovery: 8320, MetaData: 8300, DataManagement: 8340, DataBookkeeping: 8310, Authz: 8380},
+		NATSURL:   "nats://localhost:4222",
+		UploadDir: "/tmp/foxden/uploads",
+		SMTPHost:  "localhost",
+		SMTPFrom:  "FOXDEN <foxden@localhost>",
+	}
+	fset := newFlagSet(app, "init")
+	output := fset.String("output", ".srv.yaml", "output configuration file")
+	force := fset.Bool("force", false, "overwrite existing configuration file")
+	yes := fset.Bool("yes", false, "do not ask questions, use flag values")
+	enable := fset.String("enable", "", "comma separated optional sections: "+strings.Join(initSections, ", "))
+	fset.StringVar(&opts.Host, "host", "localhost", "host name of FOXDEN services")
+	fset.BoolVar(&opts.HTTPS, "https", false, "services are served over HTTPS")
+	fset.StringVar(&opts.DBName, "dbname", "foxden", "MongoDB database name")
+	fset.StringVar(&opts.AuthzDB, "authz-db", "./auth.db", "Authz database")
+	fset.StringVar(&opts.NATSURL, "nats-url", opts.NATSURL, "NATS server URL of messagebus section")
+	fset.StringVar(&opts.UploadDir, "upload-dir", opts.UploadDir, "staging directory of upload section")
+	fset.StringVar(&opts.SMTPHost, "smtp-host", opts.SMTPHost, "SMTP server of smtp section")
+	fset.StringVar(&opts.SMTPFrom, "smtp-from", opts.SMTPFrom, "sender address of smtp section")
+	fset.StringVar(&opts.AdminEmail, "admin-email", "", "admin email of smtp section")
+	if err := fset.Parse(args); err != nil {
+		return err
+	}
+	for _, section := range splitList(*enable) {
+		if err := opts.enable(section, true); err != nil {
+			return err
+		}
+	}
+	opts.MongoURI = app.mongoURI
+	if opts.MongoURI == "" {
+		opts.MongoURI = "mongodb://localhost:8230"
+	}
+	if _, err := os.Stat(*output); err == nil && !*force {
+		return fmt.Errorf("%s already exists, use -force flag to overwrite it", *output)
+	}
+	if !*yes {
+		in := app.In
+		if in == nil {
+			in = os.Stdin
+		}
+		if err := opts.ask(&prompter{in: bufio.NewReader(in), out: app.Out}); err != nil {
+			return err
+		}
+	}
+	var err error
+	for _, secret := range []*string{&opts.ClientID, &opts.ClientSecret, &opts.EncryptionSecret} {
+		if *secret, err = randomSecret(32); err != nil {
+			return err
+		}
+	}
+	opts.Date = time.Now().Format(time.RFC3339)
+	data, err := opts.Render()
+	if err != nil {
+		return err
+	}
+	if err := writeConfig(*output, data); err != nil {
+		return err
+	}
+	fmt.Fprintf(app.Out, "configuration is written to %s\n", *output)
+	return nil
+}
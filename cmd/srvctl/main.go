@@ -0,0 +1,170 @@
+package main
+
+// srvctl is command line tool to manage FOXDEN/CHESS deployment: it
+// generates and validates server configuration, issues and revokes tokens
+// and API keys, logs users in with tokens stored in OS keychain, manages
+// groups of users, runs database migrations, ensures MongoDB indexes,
+// backups and restores metadata stores, applies retention policies,
+// reindexes search records, inspects task queues, dumps service metrics and
+// runs load tests.
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	mongo "github.com/CHESSComputing/golib/mongo"
+)
+
+// command represents srvctl command
+type command struct {
+	usage   string // command arguments
+	summary string // command description
+	run     func(app *App, args []string) error
+}
+
+// commands defines srvctl commands
+var commands = map[string]command{
+	"init":      {"[options]", "generate server configuration", initCommand},
+	"config":    {"validate|dump", "validate or dump server configuration", configCommand},
+	"token":     {"issue|inspect [options]", "issue or inspect JWT access tokens", tokenCommand},
+	"login":     {"[options]", "obtain and store access token via device or Kerberos login", loginCommand},
+	"logout":    {"[options]", "remove access token stored by login", logoutCommand},
+	"apikey":    {"issue|list|revoke [options]", "manage API keys", apiKeyCommand},
+	"identity":  {"link|unlink|list [options]", "manage linked user identities", identityCommand},
+	"group":     {"create|delete|list|show|add|remove|sync", "manage groups of users", groupCommand},
+	"migrate":   {"up|down|status [options]", "run database migrations", migrateCommand},
+	"reindex":   {"[options]", "reindex records of search backend", reindexCommand},
+	"index":     {"ensure|check [options]", "ensure or check MongoDB indexes", indexCommand},
+	"backup":    {"[options]", "backup collections of metadata store", backupCommand},
+	"restore":   {"[options] <archive>", "verify and restore backup archive", restoreCommand},
+	"retention": {"report|apply [options]", "report or apply retention policies", retentionCommand},
+	"queue":     {"list|show|requeue [options]", "inspect task queue", queueCommand},
+	"metrics":   {"[options]", "dump service metrics", metricsCommand},
+	"loadtest":  {"[options]", "run load test against the service", loadtestCommand},
+}
+
+// App represents srvctl application state shared by commands
+type App struct {
+	ConfigFile string               // server configuration file
+	Verbose    int                  // verbosity level
+	In         io.Reader            // input of interactive commands
+	Out        io.Writer            // output of commands
+	Config     *srvConfig.SrvConfig // loaded server configuration
+
+	mongoURI string
+}
+
+// LoadConfig loads server configuration, it is loaded once and set as
+// global configuration used by library modules
+func (a *App) LoadConfig() (*srvConfig.SrvConfig, error) {
+	if a.Config != nil {
+		return a.Config, nil
+	}
+	cfile := a.ConfigFile
+	if cfile == "" {
+		cfile = os.Getenv("CHESS_FOXDEN_CONFIG")
+	}
+	cfg, err := srvConfig.ParseConfig(cfile)
+	if err != nil {
+		return nil, err
+	}
+	a.Config = &cfg
+	srvConfig.Config = a.Config
+	return a.Config, nil
+}
+
+// helper function to initialize MongoDB connection, URI given by -mongo
+// flag takes precedence over MetaData configuration
+func (a *App) initMongo() error {
+	uri := a.mongoURI
+	if uri == "" {
+		cfg, err := a.LoadConfig()
+		if err != nil {
+			return err
+		}
+		uri = cfg.MetaData.MongoDB.DBUri
+	}
+	if uri == "" {
+		return errors.New("MongoDB URI is not configured, use -mongo flag")
+	}
+	mongo.InitMongoDB(uri)
+	return nil
+}
+
+// helper function to print usage of srvctl
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: srvctl [-config file] [-profile profiles] [-mongo uri] [-verbose level] <command> [arguments]")
+	fmt.Fprintln(w, "Commands:")
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cmd := commands[name]
+		fmt.Fprintf(w, "  %-8s %-28s %s\n", name, cmd.usage, cmd.summary)
+	}
+	fmt.Fprintln(w, "Use 'srvctl <command> -h' to see command options")
+}
+
+// Run parses global flags and runs srvctl command
+func Run(args []string, out io.Writer) error {
+	app := &App{In: os.Stdin, Out: out}
+	fset := flag.NewFlagSet("srvctl", flag.ContinueOnError)
+	fset.SetOutput(out)
+	fset.StringVar(&app.ConfigFile, "config", "", "server config file, default $CHESS_FOXDEN_CONFIG or $HOME/.foxden.yaml")
+	fset.StringVar(&app.mongoURI, "mongo", "", "MongoDB URI, default MetaData MongoDB URI of configuration")
+	fset.StringVar(&srvConfig.Profile, "profile", "", "comma separated list of configuration profiles, default $CHESS_FOXDEN_PROFILE")
+	fset.IntVar(&app.Verbose, "verbose", 0, "verbosity level")
+	fset.Usage = func() { usage(out) }
+	if err := fset.Parse(args); err != nil {
+		return err
+	}
+	if fset.NArg() == 0 {
+		usage(out)
+		return errors.New("command is not provided")
+	}
+	name := fset.Arg(0)
+	cmd, ok := commands[name]
+	if !ok {
+		usage(out)
+		return fmt.Errorf("unknown command '%s'", name)
+	}
+	return cmd.run(app, fset.Args()[1:])
+}
+
+// helper function to create flag set of the command
+func newFlagSet(app *App, name string) *flag.FlagSet {
+	fset := flag.NewFlagSet("srvctl "+name, flag.ContinueOnError)
+	fset.SetOutput(app.Out)
+	return fset
+}
+
+// helper function to split comma separated list
+func splitList(val string) []string {
+	var out []string
+	for _, v := range strings.Split(val, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func main() {
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+	if err := Run(os.Args[1:], os.Stdout); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		fmt.Fprintln(os.Stderr, "ERROR:", err)
+		os.Exit(1)
+	}
+}
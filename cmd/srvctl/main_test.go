@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	login "github.com/CHESSComputing/golib/authz/login"
+	backup "github.com/CHESSComputing/golib/backup"
+	srvConfig "github.com/CHESSComputing/golib/config"
+	dbs "github.com/CHESSComputing/golib/dbs"
+	jobs "github.com/CHESSComputing/golib/jobs"
+	storage "github.com/CHESSComputing/golib/storage"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// helper function to write test configuration file
+func testConfig(t *testing.T, content string) string {
+	fname := filepath.Join(t.TempDir(), "foxden.yaml")
+	if err := os.WriteFile(fname, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := srvConfig.Config
+	t.Cleanup(func() { srvConfig.Config = config })
+	return fname
+}
+
+// helper function to run srvctl command
+func run(args ...string) (string, error) {
+	var out bytes.Buffer
+	err := Run(args, &out)
+	return out.String(), err
+}
+
+// TestRun
+func TestRun(t *testing.T) {
+	if _, err := run(); err == nil {
+		t.Error("missing command is accepted")
+	}
+	if out, err := run("unknown"); err == nil || !strings.Contains(out, "reindex") {
+		t.Errorf("unknown command is accepted, output %s", out)
+	}
+	if _, err := run("token", "revoke"); err == nil || !strings.Contains(err.Error(), "usage") {
+		t.Errorf("unknown sub-command is accepted, error %v", err)
+	}
+	if _, err := run("group", "add", "-members", "alice"); err == nil || !strings.Contains(err.Error(), "usage") {
+		t.Errorf("group without name is accepted, error %v", err)
+	}
+}
+
+// TestConfigValidate
+func TestConfigValidate(t *testing.T) {
+	fname := testConfig(t, `
+Authz:
+  ClientId: secret
+Services:
+  MetaDataUrl: localhost:8300
+MessageBus:
+  Backend: nats
+Quota:
+  Limits:
+    - Scope: beamline
+`)
+	out, err := run("-config", fname, "config", "validate")
+	if err == nil {
+		t.Fatal("invalid configuration is accepted")
+	}
+	for _, msg := range []string{"Services.MetaDataUrl", "nats backend requires", "unsupported quota scope"} {
+		if !strings.Contains(out, msg) {
+			t.Errorf("output does not report %q\n%s", msg, out)
+		}
+	}
+	fname = testConfig(t, "Authz:\n  ClientId: secret\n  ClientSecrett: xyz\nServices:\n  MetaDataUrl: http://localhost:8300\n")
+	if out, err := run("-config", fname, "config", "validate"); err != nil || !strings.Contains(out, "is valid") ||
+		!strings.Contains(out, "WARNING: Authz.clientsecrett: unknown key, use Authz.ClientSecret") {
+		t.Errorf("valid configuration is rejected, output %s error %v", out, err)
+	}
+
+	// configuration merged with profile
+	os.WriteFile(srvConfig.ProfileFile(fname, "prod"), []byte("Services:\n  MetaDataUrl: https://foxden-meta.example.org\n"), 0644)
+	out, err = run("-config", fname, "-profile", "prod", "config", "dump")
+	if err != nil || !strings.Contains(out, "MetaDataUrl: https://foxden-meta.example.org") || strings.Contains(out, "secret") {
+		t.Errorf("unexpected configuration dump %s, error %v", out, err)
+	}
+}
+
+// TestToken
+func TestToken(t *testing.T) {
+	fname := testConfig(t, "Authz:\n  ClientId: secret\n")
+	if _, err := run("-config", fname, "token", "issue"); err == nil {
+		t.Error("token without user is issued")
+	}
+	token, err := run("-config", fname, "token", "issue", "-user", "alice", "-roles", "admin, staff", "-scope", "write")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := run("-config", fname, "token", "inspect", strings.TrimSpace(token))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{`"user": "alice"`, `"scope": "write"`, `"staff"`} {
+		if !strings.Contains(out, s) {
+			t.Errorf("claims do not contain %s\n%s", s, out)
+		}
+	}
+	token, err = run("-config", fname, "token", "issue", "-user", "pipeline", "-scope", "write", "-jkt", "0ZcOCORZNYy-DWpqq30jZyJGHTN0d2HglBV3uiguA4I")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, err := run("-config", fname, "token", "inspect", strings.TrimSpace(token)); err != nil || !strings.Contains(out, `"jkt": "0ZcOCORZNYy-DWpqq30jZyJGHTN0d2HglBV3uiguA4I"`) {
+		t.Errorf("token is not bound, claims %s error %v", out, err)
+	}
+	other := testConfig(t, "Authz:\n  ClientId: other\n")
+	if _, err := run("-config", other, "token", "inspect", strings.TrimSpace(token)); err == nil {
+		t.Error("token signed with another secret is accepted")
+	}
+}
+
+// TestQueue
+func TestQueue(t *testing.T) {
+	ctx := context.Background()
+	dbfile := "sqlite3://" + filepath.Join(t.TempDir(), "tasks.db")
+	db, err := dbs.Open(dbfile, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.DB.Close()
+	if _, err := jobs.TasksMigrations.Up(ctx, db, false); err != nil {
+		t.Fatal(err)
+	}
+	queue := jobs.NewQueue(jobs.NewSQLStore(db))
+	id, err := queue.Enqueue(ctx, "transfer", map[string]string{"did": "/a/b/c"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := run("queue", "list", "-dbfile", dbfile, "-status", "queued")
+	if err != nil || !strings.Contains(out, id) || !strings.Contains(out, "transfer") {
+		t.Errorf("task is not listed, output %s error %v", out, err)
+	}
+	out, err = run("queue", "show", "-dbfile", dbfile, id)
+	if err != nil || !strings.Contains(out, `"type": "transfer"`) {
+		t.Errorf("task is not shown, output %s error %v", out, err)
+	}
+	if _, err := run("queue", "requeue", "-dbfile", dbfile, id); err == nil {
+		t.Error("queued task is requeued")
+	}
+	if _, err := run("queue", "list"); err == nil {
+		t.Error("queue without database is accepted")
+	}
+}
+
+// TestMetrics
+func TestMetrics(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/metrics" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprintln(w, "foxden_goroutines 10")
+		fmt.Fprintln(w, "foxden_memory_percent 2.5")
+	}))
+	defer srv.Close()
+	out, err := run("metrics", "-url", srv.URL, "-filter", "goroutines")
+	if err != nil || out != "foxden_goroutines 10\n" {
+		t.Errorf("unexpected metrics %q, error %v", out, err)
+	}
+	fname := testConfig(t, "Services:\n  MetaDataUrl: "+srv.URL+"/\n")
+	if out, err := run("-config", fname, "metrics", "-service", "metadata"); err != nil || !strings.Contains(out, "memory_percent") {
+		t.Errorf("unexpected metrics %q, error %v", out, err)
+	}
+	if _, err := run("-config", fname, "metrics", "-service", "beamline"); err == nil {
+		t.Error("unknown service is accepted")
+	}
+}
+
+// TestRestoreVerify
+func TestRestoreVerify(t *testing.T) {
+	store := storage.NewMemoryStore()
+	store.Insert(context.Background(), "meta", map[string]any{"_id": "1", "did": "/beamline=3a/btr=1"})
+	location := filepath.Join(t.TempDir(), "foxden.backup")
+	err := backup.WriteArchive(context.Background(), location, nil, func(w io.Writer) error {
+		_, err := backup.Backup(context.Background(), store, w, backup.Options{Collections: []string{"meta"}, Secret: "secret"})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fname := testConfig(t, "Backup:\n  Secret: secret\n")
+	if out, err := run("-config", fname, "restore", "-verify", location); err != nil || !strings.Contains(out, "is verified: full backup") {
+		t.Errorf("unexpected output %q, error %v", out, err)
+	}
+	fname = testConfig(t, "Backup:\n  Secret: wrong\n")
+	if _, err := run("-config", fname, "restore", "-verify", location); !errors.Is(err, backup.ErrCorrupted) {
+		t.Errorf("archive is verified with wrong secret, error %v", err)
+	}
+}
+
+// TestInit
+func TestInit(t *testing.T) {
+	testConfig(t, "")
+	fname := filepath.Join(t.TempDir(), "srv.yaml")
+	out, err := run("init", "-yes", "-output", fname, "-enable", "messagebus,search,upload,quota,smtp", "-admin-email", "admin@example.com")
+	if err != nil {
+		t.Fatal(err, out)
+	}
+	info, err := os.Stat(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("invalid permissions %v", info.Mode().Perm())
+	}
+	cfg, err := srvConfig.ParseConfig(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srvConfig.Validate(cfg); err != nil {
+		t.Errorf("generated configuration is not valid, error %v", err)
+	}
+	if len(cfg.Authz.ClientID) != 64 || cfg.Authz.ClientID == cfg.Authz.ClientSecret {
+		t.Errorf("invalid secrets %s %s", cfg.Authz.ClientID, cfg.Authz.ClientSecret)
+	}
+	if cfg.MessageBus.Backend != "nats" || cfg.Search.Backend != "mongo" || len(cfg.Quota.Limits) != 2 ||
+		cfg.SMTP.AdminEmails[0] != "admin@example.com" || cfg.Upload.Backend != "disk" {
+		t.Errorf("optional sections are not enabled %+v", cfg)
+	}
+	if _, err := run("init", "-yes", "-output", fname); err == nil {
+		t.Error("existing configuration is overwritten")
+	}
+	if _, err := run("init", "-yes", "-output", fname, "-force", "-enable", "kafka"); err == nil {
+		t.Error("unknown section is accepted")
+	}
+
+	// interactive mode
+	var buf bytes.Buffer
+	app := &App{In: strings.NewReader("foxden.example.org\n\nchess\n\ny\ny\nn\n\nn\nmaybe\nn\nnats://nats:4222\n"), Out: &buf}
+	if err := initCommand(app, []string{"-output", fname, "-force"}); err != nil {
+		t.Fatal(err, buf.String())
+	}
+	cfg, err = srvConfig.ParseConfig(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Services.FrontendURL != "https://foxden.example.org:8344" || cfg.MetaData.MongoDB.DBName != "chess" {
+		t.Errorf("answers are not used, services %+v", cfg.Services)
+	}
+	if cfg.MessageBus.URLs[0] != "nats://nats:4222" || cfg.Search.Backend != "" || cfg.SMTP.Host != "" {
+		t.Errorf("unexpected sections, message bus %+v search %+v", cfg.MessageBus, cfg.Search)
+	}
+}
+
+// TestLoadtest
+func TestLoadtest(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/search" && r.URL.Path != "/" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	out, err := run("loadtest", "-url", srv.URL, "-requests", "20", "-concurrency", "2", "-insert-ratio", "0.5", "-json")
+	if err != nil || !strings.Contains(out, `"requests": 20`) || !strings.Contains(out, `"200": 20`) {
+		t.Errorf("unexpected report %s, error %v", out, err)
+	}
+	fname := filepath.Join(t.TempDir(), "traffic.ndjson")
+	data := "{\"method\": \"GET\", \"path\": \"/search\"}\n{\"method\": \"GET\", \"path\": \"/unknown\"}\n"
+	if err := os.WriteFile(fname, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out, err = run("loadtest", "-url", srv.URL, "-replay", fname)
+	if err != nil || !strings.Contains(out, "requests:   2") || !strings.Contains(out, "404:1") {
+		t.Errorf("unexpected report %s, error %v", out, err)
+	}
+	if _, err := run("loadtest", "-url", srv.URL, "-insert-ratio", "2"); err == nil {
+		t.Error("invalid insert ratio is accepted")
+	}
+}
+
+// TestLogin
+func TestLogin(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/oauth/device/code":
+			fmt.Fprint(w, `{"device_code": "dev", "user_code": "ABCD-EFGH", "verification_uri": "https://authz/device", "expires_in": 60, "interval": 1}`)
+		case "/oauth/token":
+			fmt.Fprint(w, `{"access_token": "token", "expires_in": 3600, "scope": "read"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	fname := testConfig(t, fmt.Sprintf("Services:\n  AuthzUrl: %s\n", srv.URL))
+	out, err := run("-config", fname, "login", "-keyring", "file")
+	if err != nil || !strings.Contains(out, "enter code ABCD-EFGH") || !strings.Contains(out, "logged in to "+srv.URL) {
+		t.Errorf("unexpected output %q, error %v", out, err)
+	}
+	out, err = run("-config", fname, "login", "-keyring", "file", "-status")
+	if err != nil || !strings.Contains(out, "via device, scope read, token is valid") {
+		t.Errorf("unexpected output %q, error %v", out, err)
+	}
+	if _, err := run("-config", fname, "logout", "-keyring", "file"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := run("-config", fname, "login", "-keyring", "file", "-status"); !errors.Is(err, login.ErrLoginRequired) {
+		t.Errorf("credentials are kept after logout, error %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+package config
+
+// buildinfo module provides build information of services injected via
+// ldflags, e.g.
+// go build -ldflags "-X github.com/CHESSComputing/golib/config.GitCommit=$(git rev-parse --short HEAD)
+//   -X github.com/CHESSComputing/golib/config.GitTag=$(git describe --tags --abbrev=0)
+//   -X github.com/CHESSComputing/golib/config.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+// Version control information embedded by go build is used when ldflags
+// are not provided.
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// build information injected via ldflags
+var (
+	GitCommit string // git commit sha
+	GitTag    string // git tag
+	BuildDate string // build date
+)
+
+// BuildInfo represents build information of the service
+type BuildInfo struct {
+	Version   string `json:"version"`    // version of the service, git tag or module version
+	Commit    string `json:"commit"`     // git commit sha
+	Tag       string `json:"tag"`        // git tag
+	BuildDate string `json:"build_date"` // build date
+	GoVersion string `json:"go_version"` // version of Go compiler
+	Modified  bool   `json:"modified"`   // build has uncommitted changes
+}
+
+// Build returns build information of the service
+func Build() BuildInfo {
+	info := BuildInfo{
+		Commit:    GitCommit,
+		Tag:       GitTag,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+	}
+	if binfo, ok := debug.ReadBuildInfo(); ok {
+		if v := binfo.Main.Version; v != "" && v != "(devel)" {
+			info.Version = v
+		}
+		for _, s := range binfo.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = s.Value
+				}
+			case "vcs.time":
+				if info.BuildDate == "" {
+					info.BuildDate = s.Value
+				}
+			case "vcs.modified":
+				info.Modified = s.Value == "true"
+			}
+		}
+	}
+	if info.Tag != "" {
+		info.Version = info.Tag
+	}
+	if info.Version == "" {
+		info.Version = "devel"
+	}
+	return info
+}
+
+// Info provides build information string of the service
+func Info() string {
+	info := Build()
+	return fmt.Sprintf("version=%s git=%s go=%s date=%s", info.Version, info.Commit, info.GoVersion, info.BuildDate)
+}
@@ -0,0 +1,58 @@
+package config
+
+// include module assembles configuration from multiple files, e.g. main
+// service configuration and secrets kept in separately-permissioned file
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// IncludeFiles returns list of files of Include configuration, relative
+// paths are resolved against directory of configuration file. Glob
+// patterns (e.g. conf.d/*.yaml) are expanded in lexical order and may
+// match no files, other files should exist.
+func IncludeFiles(cfile string, includes []string) ([]string, error) {
+	var files []string
+	for _, pattern := range includes {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(cfile), pattern)
+		}
+		if !strings.ContainsAny(pattern, "*?[") {
+			if _, err := os.Stat(pattern); err != nil {
+				return nil, fmt.Errorf("include: %v", err)
+			}
+			files = append(files, pattern)
+			continue
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid include pattern %s, error %v", pattern, err)
+		}
+		sort.Strings(matches)
+		files = append(files, matches...)
+	}
+	return files, nil
+}
+
+// helper function to merge included files into configuration read by
+// viper in order, maps are merged recursively and other values are
+// replaced. Include lists of included files are not processed.
+func mergeIncludes(v *viper.Viper, cfile string, includes []string) error {
+	files, err := IncludeFiles(cfile, includes)
+	if err != nil {
+		return err
+	}
+	for _, fname := range files {
+		v.SetConfigFile(fname)
+		if err := v.MergeInConfig(); err != nil {
+			return fmt.Errorf("unable to merge included file %s, error %v", fname, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package config
+
+// profile module provides configuration profiles, e.g. dev, staging or
+// prod. Profile overlay files are deep-merged on top of base configuration
+// file, and effective configuration can be inspected via Dump.
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v2"
+)
+
+// Profile represents comma separated list of configuration profiles, it is
+// set via -profile flag and CHESS_FOXDEN_PROFILE environment variable is
+// used if it is empty
+var Profile string
+
+// ProfileFile returns name of profile overlay of configuration file, e.g.
+// foxden.prod.yaml for foxden.yaml configuration and prod profile
+func ProfileFile(cfile, profile string) string {
+	ext := filepath.Ext(cfile)
+	return strings.TrimSuffix(cfile, ext) + "." + profile + ext
+}
+
+// helper function to get list of profiles
+func profiles() []string {
+	profile := Profile
+	if profile == "" {
+		profile = os.Getenv("CHESS_FOXDEN_PROFILE")
+	}
+	var out []string
+	for _, p := range strings.Split(profile, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// helper function to merge profile overlays into configuration read by
+// viper, profiles are applied in order so later profiles overwrite earlier
+// ones. Maps are merged recursively and other values, including lists,
+// are replaced.
+func mergeProfiles(v *viper.Viper, cfile string, profiles []string) error {
+	for _, profile := range profiles {
+		pfile := ProfileFile(cfile, profile)
+		if _, err := os.Stat(pfile); err != nil {
+			return fmt.Errorf("profile %s: %v", profile, err)
+		}
+		v.SetConfigFile(pfile)
+		if err := v.MergeInConfig(); err != nil {
+			return fmt.Errorf("unable to merge profile %s file %s, error %v", profile, pfile, err)
+		}
+	}
+	return nil
+}
+
+// helper function to check if configuration key holds secret value, i.e.
+// secrets, passwords, client ids, tokens or key material
+func secretKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range []string{"secret", "password", "clientid", "token", "apikey", "accesskey", "privatekey"} {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// helper function to convert configuration value into YAML structure with
+// configuration keys, zero values are omitted and secrets are redacted
+func dumpValue(key string, v reflect.Value) (any, bool) {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil, false
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() || v.IsZero() {
+		return nil, false
+	}
+	switch val := v.Interface().(type) {
+	case time.Duration:
+		return val.String(), true
+	case ByteSize:
+		return val.String(), true
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		var out yaml.MapSlice
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			name, _, _ := strings.Cut(field.Tag.Get("mapstructure"), ",")
+			if name == "" {
+				name = field.Name
+			}
+			if val, ok := dumpValue(name, v.Field(i)); ok {
+				out = append(out, yaml.MapItem{Key: name, Value: val})
+			}
+		}
+		return out, len(out) > 0
+	case reflect.Slice, reflect.Array:
+		var out []any
+		for i := 0; i < v.Len(); i++ {
+			val, _ := dumpValue(key, v.Index(i))
+			out = append(out, val)
+		}
+		return out, true
+	case reflect.Map:
+		var keys []string
+		items := make(map[string]reflect.Value)
+		for _, k := range v.MapKeys() {
+			name := fmt.Sprintf("%v", k.Interface())
+			keys = append(keys, name)
+			items[name] = v.MapIndex(k)
+		}
+		sort.Strings(keys)
+		var out yaml.MapSlice
+		for _, name := range keys {
+			if val, ok := dumpValue(name, items[name]); ok {
+				out = append(out, yaml.MapItem{Key: name, Value: val})
+			}
+		}
+		return out, len(out) > 0
+	case reflect.String:
+		if secretKey(key) {
+			return "***", true
+		}
+		// redact passwords of URIs, e.g. MongoDB URIs
+		if u, err := url.Parse(v.String()); err == nil && u.User != nil {
+			return u.Redacted(), true
+		}
+	}
+	return v.Interface(), true
+}
+
+// Dump returns effective configuration in YAML format, e.g. configuration
+// merged with profiles. Keys with zero values are omitted and secrets,
+// e.g. client ids and passwords, are redacted.
+func Dump(c SrvConfig) (string, error) {
+	val, _ := dumpValue("", reflect.ValueOf(c))
+	data, err := yaml.Marshal(val)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
@@ -0,0 +1,54 @@
+package config
+
+// reload module keeps statistics of reloads, e.g. configuration reloads of
+// WatchConfig or schema renewals, exposed via server metrics
+
+import (
+	"sync"
+	"time"
+)
+
+// ReloadStats represents statistics of reloads
+type ReloadStats struct {
+	mu          sync.Mutex
+	count       uint64
+	failures    uint64
+	lastReload  time.Time
+	lastSuccess bool
+}
+
+// ReloadStatus represents snapshot of reload statistics
+type ReloadStatus struct {
+	Count       uint64    `json:"count"`        // total number of reloads
+	Failures    uint64    `json:"failures"`     // number of failed reloads
+	LastReload  time.Time `json:"last_reload"`  // time of last reload
+	LastSuccess bool      `json:"last_success"` // status of last reload
+}
+
+// ConfigReloads holds statistics of configuration reloads
+var ConfigReloads ReloadStats
+
+// Record records reload with given error, nil error represents successful
+// reload
+func (r *ReloadStats) Record(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.count++
+	if err != nil {
+		r.failures++
+	}
+	r.lastReload = time.Now()
+	r.lastSuccess = err == nil
+}
+
+// Status returns snapshot of reload statistics
+func (r *ReloadStats) Status() ReloadStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return ReloadStatus{
+		Count:       r.count,
+		Failures:    r.failures,
+		LastReload:  r.lastReload,
+		LastSuccess: r.lastSuccess,
+	}
+}
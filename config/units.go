@@ -0,0 +1,113 @@
+package config
+
+// units module provides human-friendly duration and size values of
+// configuration, e.g. 15m, 24h, 7d or 100MB, decoded via viper decode hooks
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+// ByteSize represents size in bytes, configuration values may use units,
+// e.g. 512KB, 100MB or 1GiB
+type ByteSize int64
+
+// size units, KB, MB, GB and TB are decimal units and KiB, MiB, GiB and TiB
+// are binary units
+var sizeUnits = []struct {
+	name string
+	size int64
+}{
+	{"TiB", 1 << 40},
+	{"GiB", 1 << 30},
+	{"MiB", 1 << 20},
+	{"KiB", 1 << 10},
+	{"TB", 1000 * 1000 * 1000 * 1000},
+	{"GB", 1000 * 1000 * 1000},
+	{"MB", 1000 * 1000},
+	{"KB", 1000},
+	{"B", 1},
+}
+
+// String provides string representation of size with the largest unit
+// which represents size exactly
+func (s ByteSize) String() string {
+	for _, u := range sizeUnits {
+		if s != 0 && int64(s)%u.size == 0 {
+			return fmt.Sprintf("%d%s", int64(s)/u.size, u.name)
+		}
+	}
+	return fmt.Sprintf("%dB", int64(s))
+}
+
+// ParseByteSize parses size value, e.g. 100MB, 1.5GiB or 1024, number
+// without unit is size in bytes
+func ParseByteSize(val string) (ByteSize, error) {
+	str := strings.TrimSpace(val)
+	for _, u := range sizeUnits {
+		if num, ok := strings.CutSuffix(str, u.name); ok {
+			str = strings.TrimSpace(num)
+			if n, err := strconv.ParseInt(str, 10, 64); err == nil {
+				return ByteSize(n * u.size), nil
+			}
+			f, err := strconv.ParseFloat(str, 64)
+			if err != nil {
+				break
+			}
+			return ByteSize(f * float64(u.size)), nil
+		}
+	}
+	if n, err := strconv.ParseInt(str, 10, 64); err == nil {
+		return ByteSize(n), nil
+	}
+	return 0, fmt.Errorf("invalid size '%s', use value like 512KB, 100MB or 1GiB", val)
+}
+
+// ParseDuration parses duration value, e.g. 90s, 15m, 24h or 7d, number
+// without unit is duration in seconds
+func ParseDuration(val string) (time.Duration, error) {
+	str := strings.TrimSpace(val)
+	if n, err := strconv.ParseFloat(str, 64); err == nil {
+		return time.Duration(n * float64(time.Second)), nil
+	}
+	if days, ok := strings.CutSuffix(str, "d"); ok {
+		if n, err := strconv.ParseFloat(days, 64); err == nil {
+			return time.Duration(n * float64(24*time.Hour)), nil
+		}
+	}
+	if d, err := time.ParseDuration(str); err == nil {
+		return d, nil
+	}
+	return 0, fmt.Errorf("invalid duration '%s', use value like 90s, 15m, 24h or 7d", val)
+}
+
+// DecodeHook returns mapstructure decode hook which decodes durations and
+// sizes of configuration. Numbers are durations in seconds and sizes in
+// bytes, strings are parsed via ParseDuration and ParseByteSize.
+func DecodeHook() mapstructure.DecodeHookFunc {
+	durationType := reflect.TypeOf(time.Duration(0))
+	sizeType := reflect.TypeOf(ByteSize(0))
+	return func(f reflect.Type, t reflect.Type, data any) (any, error) {
+		if t != durationType && t != sizeType {
+			return data, nil
+		}
+		val := fmt.Sprintf("%v", data)
+		switch f.Kind() {
+		case reflect.String:
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+		default:
+			return data, nil
+		}
+		if t == durationType {
+			return ParseDuration(val)
+		}
+		return ParseByteSize(val)
+	}
+}
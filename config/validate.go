@@ -0,0 +1,648 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ratePattern defines format of request limiter rate, e.g. 100-S
+var ratePattern = regexp.MustCompile(`^[0-9]+-[SMHDsmhd]$`)
+
+// patternStorageName defines format of collections and fields of storage
+// indexes, they are used as PostgreSQL table and column names
+var patternStorageName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// patternMongoIndexKey defines format of keys of MongoDB indexes, e.g.
+// did, -date or location:2dsphere
+var patternMongoIndexKey = regexp.MustCompile(`^-?[A-Za-z_][A-Za-z0-9_.]*(:(hashed|2d|2dsphere))?$`)
+
+// patternTimestampKey defines format of modification timestamp keys, e.g.
+// date for unix seconds or updated:time for dates
+var patternTimestampKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.]*(:(unix|time))?$`)
+
+// helper function to check URL of configuration parameter
+func checkURL(name, rurl string) error {
+	u, err := url.Parse(rurl)
+	if err != nil {
+		return fmt.Errorf("%s: invalid URL %s, error %v", name, rurl, err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("%s: invalid URL %s, URL should have scheme and host", name, rurl)
+	}
+	return nil
+}
+
+// helper function to check that file of configuration parameter exists
+func checkFile(name, fname string) error {
+	if fname == "" {
+		return nil
+	}
+	if _, err := os.Stat(fname); err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	return nil
+}
+
+// helper function to check value of enumerated parameter
+func checkValue(name, val string, values ...string) error {
+	var supported []string
+	for _, v := range values {
+		if strings.EqualFold(val, v) {
+			return nil
+		}
+		if v != "" {
+			supported = append(supported, v)
+		}
+	}
+	return fmt.Errorf("%s: unsupported value '%s', supported values: %s", name, val, strings.Join(supported, ", "))
+}
+
+// Validate checks consistency of server configuration without contacting
+// external services, it returns all found problems joined into single
+// error (see errors.Join) or nil if configuration is valid
+func Validate(c SrvConfig) error {
+	var errs []error
+	add := func(err error) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.Authz.ClientID == "" {
+		add(errors.New("Authz.ClientId: client id is required to sign tokens"))
+	}
+
+	for _, method := range c.Authz.TokenBinding.Methods {
+		add(checkValue("Authz.TokenBinding.Methods", method, "mtls", "dpop"))
+	}
+	if c.Encryption.Cipher != "" {
+		add(checkValue("Encryption.Cipher", c.Encryption.Cipher, "aes", "aes-gcm", "chacha20-poly1305"))
+	}
+	if c.Frontend.Cookie.Encrypt && c.Encryption.Secret == "" {
+		add(errors.New("Frontend.Cookie.Encrypt: encrypted cookies require Encryption.Secret"))
+	}
+	for _, proxy := range c.Frontend.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			add(fmt.Errorf("Frontend.TrustedProxies: invalid address or network '%s'", proxy))
+		}
+	}
+
+	policies := make(map[string]bool)
+	for _, p := range c.Authz.TokenPolicies {
+		name := fmt.Sprintf("Authz.TokenPolicies[client=%s scope=%s]", p.Client, p.Scope)
+		if policies[p.Client+"/"+p.Scope] {
+			add(fmt.Errorf("%s: duplicate policy", name))
+		}
+		policies[p.Client+"/"+p.Scope] = true
+		if p.Expires < 0 || p.MaxLifetime < 0 || p.IdleTimeout < 0 {
+			add(fmt.Errorf("%s: negative lifetime", name))
+		}
+		if p.MaxLifetime > 0 && p.Expires > p.MaxLifetime {
+			add(fmt.Errorf("%s: Expires %v exceeds MaxLifetime %v", name, p.Expires, p.MaxLifetime))
+		}
+	}
+	for _, scope := range c.Authz.Anonymous.Scopes {
+		if strings.EqualFold(scope, "write") {
+			add(errors.New("Authz.Anonymous.Scopes: write scope can not be granted to anonymous user"))
+		}
+	}
+	device := c.Authz.DeviceAuthorization
+	if len(device.Clients) > 0 {
+		if device.VerificationURI == "" {
+			add(errors.New("Authz.DeviceAuthorization.VerificationUri: verification page is required by device authorization"))
+		} else {
+			add(checkURL("Authz.DeviceAuthorization.VerificationUri", device.VerificationURI))
+		}
+	}
+	for _, scope := range device.Scopes {
+		add(checkValue("Authz.DeviceAuthorization.Scopes", scope, "read", "write", "delete"))
+	}
+	if device.CodeExpires < 0 || device.Interval < 0 {
+		add(errors.New("Authz.DeviceAuthorization: negative code lifetime or interval"))
+	}
+	mtls := c.Authz.MutualTLS
+	if mtls.Enabled {
+		if mtls.RootCAs == "" {
+			add(errors.New("Authz.MutualTLS.RootCAs: CA bundle is required to verify peer services"))
+		}
+		if (mtls.ClientCert == "") != (mtls.ClientKey == "") {
+			add(errors.New("Authz.MutualTLS: ClientCert and ClientKey should be provided together"))
+		}
+		if (mtls.ServerCert == "") != (mtls.ServerKey == "") {
+			add(errors.New("Authz.MutualTLS: ServerCert and ServerKey should be provided together"))
+		}
+	}
+	add(checkFile("Authz.MutualTLS.RootCAs", mtls.RootCAs))
+	add(checkFile("Authz.MutualTLS.ServerCert", mtls.ServerCert))
+	add(checkFile("Authz.MutualTLS.ServerKey", mtls.ServerKey))
+	add(checkFile("Authz.MutualTLS.ClientCert", mtls.ClientCert))
+	add(checkFile("Authz.MutualTLS.ClientKey", mtls.ClientKey))
+	identities := make(map[string]bool)
+	for i, svc := range mtls.Services {
+		name := fmt.Sprintf("Authz.MutualTLS.Services[%d]", i)
+		if svc.ID == "" {
+			add(fmt.Errorf("%s: empty service Id", name))
+			continue
+		}
+		if identities[svc.ID] {
+			add(fmt.Errorf("%s: duplicate service Id %s", name, svc.ID))
+		}
+		identities[svc.ID] = true
+		if strings.Contains(svc.ID, "://") && !strings.HasPrefix(svc.ID, "spiffe://") {
+			add(fmt.Errorf("%s: service Id %s should be SPIFFE ID or DNS name", name, svc.ID))
+		}
+		if svc.Scope != "" {
+			add(checkValue(name+".Scope", svc.Scope, "read", "write", "delete"))
+		}
+	}
+	signed := c.Authz.SignedURLs
+	if signed.Expires < 0 || signed.MaxExpires < 0 {
+		add(errors.New("Authz.SignedUrls: negative lifetime"))
+	}
+	if signed.MaxExpires > 0 && signed.Expires > signed.MaxExpires {
+		add(fmt.Errorf("Authz.SignedUrls: Expires %v exceeds MaxExpires %v", signed.Expires, signed.MaxExpires))
+	}
+	for _, p := range signed.Paths {
+		if !strings.HasPrefix(p, "/") {
+			add(fmt.Errorf("Authz.SignedUrls.Paths: path %s should start with /", p))
+		}
+	}
+	bf := c.Authz.BruteForce
+	if bf.MaxFailures < 0 || bf.IPMaxFailures < 0 || bf.IPCaptchaFailures < 0 {
+		add(errors.New("Authz.BruteForce: negative number of failures"))
+	}
+	if bf.Window < 0 || bf.Lockout < 0 || bf.MaxLockout < 0 {
+		add(errors.New("Authz.BruteForce: negative window or lockout period"))
+	}
+	if bf.MaxLockout > 0 && bf.Lockout > bf.MaxLockout {
+		add(fmt.Errorf("Authz.BruteForce: Lockout %v exceeds MaxLockout %v", bf.Lockout, bf.MaxLockout))
+	}
+	if bf.MaxFailures > 0 && bf.CaptchaFailures > bf.MaxFailures {
+		add(fmt.Errorf("Authz.BruteForce: CaptchaFailures %d exceeds MaxFailures %d", bf.CaptchaFailures, bf.MaxFailures))
+	}
+	tf := c.Authz.TwoFactor
+	if tf.Skew < 0 || tf.RecoveryCodes < 0 || tf.Expires < 0 {
+		add(errors.New("Authz.TwoFactor: negative Skew, RecoveryCodes or Expires"))
+	}
+	if tf.Skew > 10 {
+		add(fmt.Errorf("Authz.TwoFactor: Skew %d accepts too many TOTP periods", tf.Skew))
+	}
+	for _, origin := range tf.RPOrigins {
+		add(checkURL("Authz.TwoFactor.RPOrigins", origin))
+	}
+	if len(tf.RPOrigins) > 0 && tf.RPID == "" {
+		add(errors.New("Authz.TwoFactor: RPOrigins require RPID"))
+	}
+	pp := c.Authz.PasswordPolicy
+	if pp.MinLength < 0 || pp.MaxLength < 0 || pp.MinUpper < 0 || pp.MinLower < 0 || pp.MinDigits < 0 || pp.MinSymbols < 0 || pp.History < 0 || pp.MaxAge < 0 {
+		add(errors.New("Authz.PasswordPolicy: negative value"))
+	}
+	if pp.MaxLength > 72 {
+		add(fmt.Errorf("Authz.PasswordPolicy: MaxLength %d exceeds bcrypt limit of 72 bytes", pp.MaxLength))
+	}
+	maxLength := pp.MaxLength
+	if maxLength == 0 || maxLength > 72 {
+		maxLength = 72
+	}
+	if pp.MinLength > maxLength || pp.MinUpper+pp.MinLower+pp.MinDigits+pp.MinSymbols > maxLength {
+		add(fmt.Errorf("Authz.PasswordPolicy: required characters exceed maximum length %d", maxLength))
+	}
+
+	// services and their web servers
+	urls := map[string]string{
+		"FrontendUrl":        c.Services.FrontendURL,
+		"DiscoveryUrl":       c.Services.DiscoveryURL,
+		"MetaDataUrl":        c.Services.MetaDataURL,
+		"DataManagementUrl":  c.Services.DataManagementURL,
+		"DataBookkeepingUrl": c.Services.DataBookkeepingURL,
+		"AuthzUrl":           c.Services.AuthzURL,
+	}
+	for _, name := range []string{"FrontendUrl", "DiscoveryUrl", "MetaDataUrl", "DataManagementUrl", "DataBookkeepingUrl", "AuthzUrl"} {
+		if urls[name] != "" {
+			add(checkURL("Services."+name, urls[name]))
+		}
+	}
+	servers := []struct {
+		name string
+		srv  WebServer
+	}{
+		{"Frontend", c.Frontend.WebServer},
+		{"Discovery", c.Discovery.WebServer},
+		{"MetaData", c.MetaData.WebServer},
+		{"DataManagement", c.DataManagement.WebServer},
+		{"DataBookkeeping", c.DataBookkeeping.WebServer},
+		{"Authz", c.Authz.WebServer},
+	}
+	ports := make(map[int]string)
+	for _, s := range servers {
+		for _, port := range []int{s.srv.Port, s.srv.GRPCPort, s.srv.Pprof.Port} {
+			if port == 0 {
+				continue
+			}
+			if port < 0 || port > 65535 {
+				add(fmt.Errorf("%s.WebServer: invalid port %d", s.name, port))
+				continue
+			}
+			if other, ok := ports[port]; ok {
+				add(fmt.Errorf("%s.WebServer: port %d is already used by %s", s.name, port, other))
+			}
+			ports[port] = s.name
+		}
+		add(checkFile(s.name+".WebServer.ServerCert", s.srv.ServerCrt))
+		add(checkFile(s.name+".WebServer.ServerKey", s.srv.ServerKey))
+		add(checkFile(s.name+".WebServer.RootCAs", s.srv.RootCAs))
+		add(checkFile(s.name+".WebServer.Maintenance.Template", s.srv.Maintenance.Template))
+		if s.srv.LimiterPeriod != "" && !ratePattern.MatchString(s.srv.LimiterPeriod) {
+			add(fmt.Errorf("%s.WebServer.Rate: invalid rate '%s', use value like 100-S, 500-M or 1000-H", s.name, s.srv.LimiterPeriod))
+		}
+		if s.srv.Maintenance.RetryAfter < 0 {
+			add(fmt.Errorf("%s.WebServer.Maintenance: negative RetryAfter %v", s.name, s.srv.Maintenance.RetryAfter))
+		}
+		if b := s.srv.Bootstrap; b.Timeout < 0 || b.InitialWait < 0 || b.MaxWait < 0 {
+			add(fmt.Errorf("%s.WebServer.Bootstrap: negative timeout", s.name))
+		}
+		for _, rurl := range s.srv.Bootstrap.URLs {
+			add(checkURL(s.name+".WebServer.Bootstrap.URLs", rurl))
+		}
+		for _, uri := range s.srv.Bootstrap.Redis {
+			add(checkURL(s.name+".WebServer.Bootstrap.Redis", uri))
+		}
+		if m := s.srv.Monitor; m.Interval < 0 || m.MaxGoroutines < 0 || m.MaxRSS < 0 || m.RSSGrowth < 0 || m.ProfileInterval < 0 {
+			add(fmt.Errorf("%s.WebServer.Monitor: negative threshold", s.name))
+		}
+		add(checkFile(s.name+".WebServer.Monitor.ProfileDir", s.srv.Monitor.ProfileDir))
+		if p := s.srv.Pprof; p.Enabled && p.Port == 0 && len(p.AdminRoles) == 0 {
+			add(fmt.Errorf("%s.WebServer.Pprof: AdminRoles are required for pprof endpoints on server port", s.name))
+		}
+		for _, rule := range s.srv.Chaos.Rules {
+			if rule.ErrorRate < 0 || rule.ErrorRate > 1 || rule.DropRate < 0 || rule.DropRate > 1 {
+				add(fmt.Errorf("%s.WebServer.Chaos: rates of rule '%s' should be within [0, 1]", s.name, rule.Path))
+			}
+			if rule.ErrorCode != 0 && (rule.ErrorCode < 400 || rule.ErrorCode > 599) {
+				add(fmt.Errorf("%s.WebServer.Chaos: invalid error code %d of rule '%s'", s.name, rule.ErrorCode, rule.Path))
+			}
+			if rule.Latency < 0 || rule.Jitter < 0 {
+				add(fmt.Errorf("%s.WebServer.Chaos: negative latency of rule '%s'", s.name, rule.Path))
+			}
+		}
+	}
+	add(checkValue("Frontend.Cookie.SameSite", c.Frontend.Cookie.SameSite, "", "lax", "strict", "none"))
+
+	// message bus
+	if err := checkValue("MessageBus.Backend", c.MessageBus.Backend, "", "memory", "nats", "kafka"); err != nil {
+		add(err)
+	} else if b := strings.ToLower(c.MessageBus.Backend); (b == "nats" || b == "kafka") && len(c.MessageBus.URLs) == 0 {
+		add(fmt.Errorf("MessageBus.URLs: %s backend requires server URLs", b))
+	}
+	add(checkFile("MessageBus.RootCAs", c.MessageBus.RootCAs))
+	add(checkFile("MessageBus.ClientCert", c.MessageBus.ClientCert))
+	add(checkFile("MessageBus.ClientKey", c.MessageBus.ClientKey))
+
+	// search
+	switch strings.ToLower(c.Search.Backend) {
+	case "", "memory":
+	case "mongo":
+		if c.Search.DBName == "" || c.Search.Collection == "" {
+			add(errors.New("Search: mongo backend requires DBName and Collection"))
+		}
+	case "opensearch":
+		if c.Search.OpenSearch.URL == "" || c.Search.OpenSearch.IndexName == "" {
+			add(errors.New("Search.OpenSearch: opensearch backend requires URL and Index"))
+		} else {
+			add(checkURL("Search.OpenSearch.URL", c.Search.OpenSearch.URL))
+		}
+		add(checkFile("Search.OpenSearch.RootCAs", c.Search.OpenSearch.RootCAs))
+	default:
+		add(checkValue("Search.Backend", c.Search.Backend, "", "memory", "mongo", "opensearch"))
+	}
+
+	// tenancy
+	tenants := make(map[string]bool)
+	for _, t := range c.Tenancy.Tenants {
+		if t.Name == "" {
+			add(errors.New("Tenancy.Tenants: tenant without name"))
+		} else if tenants[t.Name] {
+			add(fmt.Errorf("Tenancy.Tenants: duplicate tenant %s", t.Name))
+		}
+		tenants[t.Name] = true
+	}
+	if c.Tenancy.Default != "" && !tenants[c.Tenancy.Default] {
+		add(fmt.Errorf("Tenancy.Default: unknown tenant %s", c.Tenancy.Default))
+	}
+
+	// upload and quotas
+	add(checkValue("Upload.Backend", c.Upload.Backend, "", "disk", "s3"))
+	for _, l := range c.Quota.Limits {
+		if l.Scope != "user" && l.Scope != "proposal" {
+			add(fmt.Errorf("Quota.Limits: unsupported quota scope '%s'", l.Scope))
+		}
+		if l.Storage < 0 || l.Requests < 0 {
+			add(fmt.Errorf("Quota.Limits: negative limit of %s %s", l.Scope, l.Name))
+		}
+	}
+
+	// idempotency keys
+	add(checkValue("Idempotency.Backend", c.Idempotency.Backend, "", "mongo", "redis"))
+	if strings.EqualFold(c.Idempotency.Backend, "redis") && c.Idempotency.RedisURI == "" && c.Redis.Addr == "" {
+		add(errors.New("Idempotency.RedisURI: redis backend requires redis URI or Redis section"))
+	}
+	if c.Idempotency.TTL < 0 {
+		add(fmt.Errorf("Idempotency.TTL: negative ttl %v", c.Idempotency.TTL))
+	}
+
+	// saved searches
+	if c.SavedSearches.Interval < 0 {
+		add(fmt.Errorf("SavedSearches.Interval: negative interval %v", c.SavedSearches.Interval))
+	}
+	if c.SavedSearches.MaxMatches < 0 {
+		add(fmt.Errorf("SavedSearches.MaxMatches: negative number of matches %d", c.SavedSearches.MaxMatches))
+	}
+
+	// archive bundles
+	if c.Bundles.TTL < 0 {
+		add(fmt.Errorf("Bundles.TTL: negative ttl %v", c.Bundles.TTL))
+	}
+	if c.Bundles.MaxRecords < 0 {
+		add(fmt.Errorf("Bundles.MaxRecords: negative number of records %d", c.Bundles.MaxRecords))
+	}
+
+	// proposal system
+	if p := c.Proposals; p.URL != "" {
+		if err := checkURL("Proposals.URL", p.URL); err != nil {
+			add(err)
+		}
+		if p.Path != "" && !strings.Contains(p.Path, "{id}") {
+			add(fmt.Errorf("Proposals.Path: path %s does not contain {id}", p.Path))
+		}
+	}
+	if c.Proposals.CacheTTL < 0 {
+		add(fmt.Errorf("Proposals.CacheTTL: negative ttl %v", c.Proposals.CacheTTL))
+	}
+
+	// run schedule
+	if sc := c.Schedule; sc.Source != "" {
+		if strings.Contains(sc.Source, "://") {
+			add(checkURL("Schedule.Source", sc.Source))
+		} else {
+			add(checkFile("Schedule.Source", sc.Source))
+		}
+		add(checkValue("Schedule.Format", sc.Format, "", "ical", "json"))
+	}
+	if c.Schedule.Refresh < 0 {
+		add(fmt.Errorf("Schedule.Refresh: negative interval %v", c.Schedule.Refresh))
+	}
+
+	// controlled vocabularies
+	vnames := make(map[string]bool)
+	for i, v := range c.Vocabularies.Sources {
+		name := fmt.Sprintf("Vocabularies.Sources[%d]", i)
+		if v.Name == "" {
+			add(fmt.Errorf("%s.Name: vocabulary name is required", name))
+		} else if vnames[v.Name] {
+			add(fmt.Errorf("%s.Name: duplicate vocabulary %s", name, v.Name))
+		}
+		vnames[v.Name] = true
+		add(checkValue(name+".Format", v.Format, "json", "text", "ols", "nxdl"))
+		if strings.Contains(v.Source, "://") {
+			add(checkURL(name+".Source", v.Source))
+		} else if v.Source == "" {
+			add(fmt.Errorf("%s.Source: vocabulary source is required", name))
+		} else {
+			add(checkFile(name+".Source", v.Source))
+		}
+		if v.Format == "ols" && v.Ontology == "" {
+			add(fmt.Errorf("%s.Ontology: ontology is required by ols format", name))
+		}
+	}
+	if c.Vocabularies.CacheTTL < 0 {
+		add(fmt.Errorf("Vocabularies.CacheTTL: negative ttl %v", c.Vocabularies.CacheTTL))
+	}
+
+	// duplicate records detection
+	if c.Dedup.Mode != "" {
+		add(checkValue("Dedup.Mode", c.Dedup.Mode, "flag", "reject"))
+	}
+
+	// identifiers
+	if c.IDs.Scheme != "" {
+		add(checkValue("IDs.Scheme", c.IDs.Scheme, "uuid7", "prefix"))
+	}
+	if c.IDs.Scheme == "prefix" && c.IDs.Prefix == "" {
+		add(errors.New("IDs.Prefix: prefix is required by prefix scheme"))
+	}
+	if c.IDs.Length < 0 {
+		add(fmt.Errorf("IDs.Length: negative length %d", c.IDs.Length))
+	}
+	if c.IDs.ResolverURL != "" {
+		add(checkURL("IDs.ResolverURL", c.IDs.ResolverURL))
+	}
+
+	// field encryption
+	if c.FieldEncryption.Enabled && c.Encryption.Secret == "" {
+		add(errors.New("FieldEncryption.Enabled: field encryption requires Encryption.Secret"))
+	}
+
+	// privacy
+	if c.Privacy.ConfirmTTL < 0 {
+		add(fmt.Errorf("Privacy.ConfirmTTL: negative duration %v", c.Privacy.ConfirmTTL))
+	}
+	for i, src := range c.Privacy.Sources {
+		name := fmt.Sprintf("Privacy.Sources[%d]", i)
+		if src.Name == "" || src.Collection == "" || len(src.SubjectKeys) == 0 {
+			add(fmt.Errorf("%s: source requires name, collection and subject keys", name))
+		}
+		if src.Action != "" {
+			add(checkValue(name+".Action", src.Action, "delete", "anonymize", "retain"))
+		}
+		keys := src.AnonymizeKeys
+		if len(keys) == 0 {
+			keys = src.SubjectKeys
+		}
+		for _, key := range keys {
+			if src.Action == "anonymize" && key == "_id" {
+				add(fmt.Errorf("%s: _id key can not be anonymized", name))
+			}
+		}
+	}
+
+	// consent
+	if c.Consent.CacheTTL < 0 {
+		add(fmt.Errorf("Consent.CacheTTL: negative duration %v", c.Consent.CacheTTL))
+	}
+	for _, path := range c.Consent.ExemptPaths {
+		if !strings.HasPrefix(path, "/") {
+			add(fmt.Errorf("Consent.ExemptPaths: path %s should start with /", path))
+		}
+	}
+
+	// redis
+	if r := c.Redis; r.Addr == "" {
+		if r.Sessions || r.RateLimiter || r.Cache {
+			add(errors.New("Redis.Addr: redis sessions, rate limiter or cache require redis address"))
+		}
+	} else {
+		if r.PoolSize < 0 || r.MaxIdle < 0 || r.DB < 0 {
+			add(errors.New("Redis: negative pool size, idle connections or database"))
+		}
+		if r.PoolSize > 0 && r.MaxIdle > r.PoolSize {
+			add(fmt.Errorf("Redis.MaxIdle: %d idle connections exceed pool size %d", r.MaxIdle, r.PoolSize))
+		}
+		if r.DialTimeout < 0 || r.Timeout < 0 {
+			add(errors.New("Redis: negative timeout"))
+		}
+		if (r.CertFile == "") != (r.KeyFile == "") {
+			add(errors.New("Redis.CertFile: client certificate requires both CertFile and KeyFile"))
+		}
+		if !r.TLS && (r.CAFile != "" || r.CertFile != "") {
+			add(errors.New("Redis.TLS: certificates require TLS"))
+		}
+		add(checkFile("Redis.CAFile", r.CAFile))
+		add(checkFile("Redis.CertFile", r.CertFile))
+		add(checkFile("Redis.KeyFile", r.KeyFile))
+	}
+
+	// document storage
+	add(checkValue("Storage.Backend", c.Storage.Backend, "", "mongo", "postgres"))
+	if strings.EqualFold(c.Storage.Backend, "postgres") {
+		if !strings.HasPrefix(c.Storage.PostgresURI, "postgres://") && !strings.HasPrefix(c.Storage.PostgresURI, "postgresql://") {
+			add(errors.New("Storage.PostgresURI: postgres backend requires postgres:// URI"))
+		}
+	}
+	add(checkValue("Storage.ReadPreference", c.Storage.ReadPreference,
+		"", "primary", "primaryPreferred", "secondary", "secondaryPreferred", "nearest"))
+	add(checkValue("Storage.ReadConcern", c.Storage.ReadConcern,
+		"", "local", "majority", "available", "linearizable", "snapshot"))
+	if w := c.Storage.WriteConcern; w != "" && !strings.EqualFold(w, "majority") {
+		if n, err := strconv.Atoi(w); err != nil || n < 0 {
+			add(fmt.Errorf("Storage.WriteConcern: unsupported value '%s', should be majority or number of servers", w))
+		}
+	}
+	for coll, fields := range c.Storage.Indexes {
+		for _, field := range fields {
+			if !patternStorageName.MatchString(coll) || !patternStorageName.MatchString(field) {
+				add(fmt.Errorf("Storage.Indexes: invalid index %s.%s, names should contain letters, digits and underscores", coll, field))
+			}
+		}
+	}
+
+	// mongo supervisor
+	if m := c.MongoSupervisor; m.InitialWait < 0 || m.MaxWait < 0 || m.OutageTimeout < 0 || m.ServerSelectionTimeout < 0 {
+		add(errors.New("MongoSupervisor: negative wait or timeout"))
+	} else if m.InitialWait > 0 && m.MaxWait > 0 && m.InitialWait > m.MaxWait {
+		add(fmt.Errorf("MongoSupervisor.InitialWait: %s exceeds maximum wait %s", m.InitialWait, m.MaxWait))
+	}
+
+	// mongo indexes
+	for coll, indexes := range c.MongoIndexes.Collections {
+		names := make(map[string]bool)
+		for i, idx := range indexes {
+			name := fmt.Sprintf("MongoIndexes.Collections.%s[%d]", coll, i)
+			if len(idx.Keys) == 0 {
+				add(fmt.Errorf("%s: index without keys", name))
+			}
+			for _, key := range idx.Keys {
+				if !patternMongoIndexKey.MatchString(key) {
+					add(fmt.Errorf("%s: invalid key '%s', it should be field with optional minus prefix or hashed, 2d or 2dsphere suffix", name, key))
+				}
+			}
+			if idx.TTL < 0 || (idx.TTL > 0 && len(idx.Keys) != 1) {
+				add(fmt.Errorf("%s: TTL index requires single key and positive TTL", name))
+			}
+			if idx.Name != "" {
+				if names[idx.Name] {
+					add(fmt.Errorf("%s: duplicate index name %s", name, idx.Name))
+				}
+				names[idx.Name] = true
+			}
+		}
+	}
+
+	// backups
+	if loc := c.Backup.Location; strings.HasPrefix(loc, "s3://") {
+		if strings.TrimPrefix(loc, "s3://") == "" {
+			add(fmt.Errorf("Backup.Location: bucket of location '%s' is not provided", loc))
+		}
+		if c.Backup.S3.Endpoint == "" && c.DataManagement.S3.Endpoint == "" {
+			add(errors.New("Backup.S3.Endpoint: S3 store of archives is not configured"))
+		}
+	}
+	for coll, key := range c.Backup.TimestampKeys {
+		if !patternTimestampKey.MatchString(key) {
+			add(fmt.Errorf("Backup.TimestampKeys.%s: invalid key '%s', it should be field with optional unix or time suffix", coll, key))
+		}
+	}
+	if c.Backup.BatchSize < 0 {
+		add(fmt.Errorf("Backup.BatchSize: negative batch size %d", c.Backup.BatchSize))
+	}
+
+	// retention policies
+	if c.Retention.Interval < 0 {
+		add(fmt.Errorf("Retention.Interval: negative interval %v", c.Retention.Interval))
+	}
+	if c.Retention.BatchSize < 0 {
+		add(fmt.Errorf("Retention.BatchSize: negative batch size %d", c.Retention.BatchSize))
+	}
+	if strings.HasPrefix(c.Retention.ColdStorage, "s3://") && c.DataManagement.S3.Endpoint == "" {
+		add(errors.New("Retention.ColdStorage: S3 cold storage requires DataManagement.S3 configuration"))
+	}
+	retentionPolicies := make(map[string]bool)
+	for i, p := range c.Retention.Policies {
+		name := fmt.Sprintf("Retention.Policies[%d]", i)
+		if p.Name == "" {
+			add(fmt.Errorf("%s: policy requires name", name))
+		} else if retentionPolicies[p.Name] {
+			add(fmt.Errorf("%s: duplicate policy name %s", name, p.Name))
+		}
+		retentionPolicies[p.Name] = true
+		if p.ArchiveAfter < 0 || p.PurgeAfter < 0 || (p.ArchiveAfter == 0 && p.PurgeAfter == 0) {
+			add(fmt.Errorf("%s: policy requires positive ArchiveAfter or PurgeAfter", name))
+		}
+		if p.ArchiveAfter > 0 && p.PurgeAfter > 0 && p.PurgeAfter <= p.ArchiveAfter {
+			add(fmt.Errorf("%s: PurgeAfter %v should be longer than ArchiveAfter %v", name, p.PurgeAfter, p.ArchiveAfter))
+		}
+		if p.Tenant != "" {
+			found := false
+			for _, t := range c.Tenancy.Tenants {
+				found = found || t.Name == p.Tenant
+			}
+			if !found {
+				add(fmt.Errorf("%s: unknown tenant %s", name, p.Tenant))
+			}
+		}
+	}
+
+	// groups
+	if c.Groups.SyncInterval < 0 {
+		add(fmt.Errorf("Groups.SyncInterval: negative interval %v", c.Groups.SyncInterval))
+	}
+	if ldap := c.Groups.LDAP; ldap.URL != "" {
+		if u, err := url.Parse(ldap.URL); err != nil || (u.Scheme != "ldap" && u.Scheme != "ldaps") {
+			add(fmt.Errorf("Groups.LDAP.URL: invalid LDAP URL '%s'", ldap.URL))
+		}
+		if ldap.BaseDN == "" {
+			add(errors.New("Groups.LDAP.BaseDN: base DN is required"))
+		}
+	}
+
+	// email notifications
+	if c.SMTP.Host != "" {
+		if _, err := mail.ParseAddress(c.SMTP.From); err != nil {
+			add(fmt.Errorf("SMTP.From: invalid sender address '%s', error %v", c.SMTP.From, err))
+		}
+		for _, addr := range c.SMTP.AdminEmails {
+			if _, err := mail.ParseAddress(addr); err != nil {
+				add(fmt.Errorf("SMTP.AdminEmails: invalid address '%s', error %v", addr, err))
+			}
+		}
+		if c.SMTP.BaseURL != "" {
+			add(checkURL("SMTP.BaseURL", c.SMTP.BaseURL))
+		}
+	}
+	return errors.Join(errs...)
+}
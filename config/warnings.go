@@ -0,0 +1,168 @@
+package config
+
+// warnings module reports unknown and renamed keys of configuration, e.g.
+// typo in ServerCert key which silently disables TLS
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Warning represents problem of configuration key found by ParseConfig
+type Warning struct {
+	Key        string `json:"key"`                  // configuration key, e.g. Frontend.WebServer.ServerCertt
+	Message    string `json:"message"`              // description of the problem
+	Suggestion string `json:"suggestion,omitempty"` // suggested key name
+}
+
+// String provides string representation of Warning
+func (w Warning) String() string {
+	if w.Suggestion != "" {
+		return fmt.Sprintf("%s: %s, use %s", w.Key, w.Message, w.Suggestion)
+	}
+	return fmt.Sprintf("%s: %s", w.Key, w.Message)
+}
+
+// Warnings holds warnings of the last parsed configuration
+var Warnings []Warning
+
+// StrictConfig makes ParseConfig fail on unknown or renamed keys instead
+// of reporting warnings
+var StrictConfig bool
+
+// RenamedKeys defines renamed or deprecated keys and their new names,
+// keys do not include section names and are case insensitive
+var RenamedKeys = map[string]string{
+	"LimiterPeriod": "Rate",
+	"ServerCrt":     "ServerCert",
+}
+
+// helper function to find struct type of configuration key path, e.g.
+// Frontend.WebServer, path elements are case insensitive
+func keyType(t reflect.Type, path []string) (reflect.Type, bool) {
+	for {
+		switch t.Kind() {
+		case reflect.Pointer, reflect.Slice, reflect.Array, reflect.Map:
+			t = t.Elem()
+			continue
+		}
+		break
+	}
+	if len(path) == 0 {
+		return t, t.Kind() == reflect.Struct
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, false
+	}
+	name, _, _ := strings.Cut(path[0], "[")
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if strings.EqualFold(keyName(field), name) {
+			return keyType(field.Type, path[1:])
+		}
+	}
+	return nil, false
+}
+
+// helper function to get configuration key of struct field
+func keyName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("mapstructure"), ",")
+	if name == "" {
+		name = field.Name
+	}
+	return name
+}
+
+// helper function to calculate edit distance of two strings
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// helper function to suggest key name of unknown key among keys of its
+// section, it returns empty string if there is no similar key
+func suggestKey(section reflect.Type, key string) string {
+	var best string
+	distance := len(key)/3 + 1
+	for i := 0; i < section.NumField(); i++ {
+		field := section.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := keyName(field)
+		if d := editDistance(strings.ToLower(key), strings.ToLower(name)); d < distance {
+			best, distance = name, d
+		}
+	}
+	return best
+}
+
+// UnknownKeys returns warnings of unknown keys of configuration, keys are
+// unused keys reported by mapstructure decoder, e.g.
+// Frontend.WebServer.servercertt. Renamed keys get their new names and
+// other keys get similar keys of their sections as suggestions.
+func UnknownKeys(keys []string) []Warning {
+	var warnings []Warning
+	root := reflect.TypeOf(SrvConfig{})
+	for _, key := range keys {
+		path := strings.Split(key, ".")
+		leaf, parent := path[len(path)-1], path[:len(path)-1]
+		suggestion := func(name string) string {
+			return strings.Join(append(parent[:len(parent):len(parent)], name), ".")
+		}
+		w := Warning{Key: key, Message: "unknown key"}
+		for old, name := range RenamedKeys {
+			if strings.EqualFold(old, leaf) {
+				w.Message = "key is renamed"
+				w.Suggestion = suggestion(name)
+			}
+		}
+		if w.Suggestion == "" {
+			if section, ok := keyType(root, parent); ok {
+				if name := suggestKey(section, leaf); name != "" {
+					w.Suggestion = suggestion(name)
+				}
+			}
+		}
+		warnings = append(warnings, w)
+	}
+	sort.Slice(warnings, func(i, j int) bool { return warnings[i].Key < warnings[j].Key })
+	return warnings
+}
+
+// helper function to report configuration warnings, in strict mode they
+// are returned as error
+func reportWarnings(cfile string, warnings []Warning) error {
+	Warnings = warnings
+	if len(warnings) == 0 {
+		return nil
+	}
+	var errs []error
+	for _, w := range warnings {
+		if StrictConfig {
+			errs = append(errs, errors.New(w.String()))
+			continue
+		}
+		log.Printf("WARNING: configuration %s: %s", cfile, w)
+	}
+	return errors.Join(errs...)
+}
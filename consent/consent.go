@@ -0,0 +1,361 @@
+package consent
+
+// consent module records acceptance of data policies and terms of use by
+// users. Policies are published in versions, every acceptance keeps
+// accepted version, time, IP address and user agent of the user, and
+// middleware blocks access of users who did not accept current versions.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	audit "github.com/CHESSComputing/golib/audit"
+	srvConfig "github.com/CHESSComputing/golib/config"
+	storage "github.com/CHESSComputing/golib/storage"
+	utils "github.com/CHESSComputing/golib/utils"
+	"github.com/google/uuid"
+)
+
+// audit actions of consent module
+const (
+	AuditPublish = "consent.publish"
+	AuditAccept  = "consent.accept"
+)
+
+// defaults of consent module
+var (
+	DefaultCollection       = "consents"
+	DefaultPolicyCollection = "consent_policies"
+	DefaultExemptPaths      = []string{"/consent"}
+	DefaultCacheTTL         = time.Minute
+)
+
+// errors of consent module
+var (
+	ErrInvalid  = errors.New("invalid consent request")
+	ErrNotFound = errors.New("policy not found")
+	ErrOutdated = errors.New("policy version is not current")
+	ErrConflict = errors.New("policy version is already published")
+	ErrRequired = errors.New("acceptance of current policies is required")
+)
+
+// patternPolicyName defines valid policy names
+var patternPolicyName = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,63}$`)
+
+// Policy represents published version of data policy or terms of use
+type Policy struct {
+	Name        string `json:"name"`    // policy name, e.g. terms or privacy
+	Version     int64  `json:"version"` // version number, incremented by every publication
+	Title       string `json:"title"`
+	URL         string `json:"url,omitempty"`     // location of full text of the policy
+	Summary     string `json:"summary,omitempty"` // summary of changes of the version
+	Published   int64  `json:"published"`
+	PublishedBy string `json:"published_by"`
+}
+
+// helper function to convert policy into storage record
+func (p Policy) record() map[string]any {
+	return map[string]any{
+		"_id":          fmt.Sprintf("%s:%d", p.Name, p.Version),
+		"name":         p.Name,
+		"version":      p.Version,
+		"title":        p.Title,
+		"url":          p.URL,
+		"summary":      p.Summary,
+		"published":    p.Published,
+		"published_by": p.PublishedBy,
+	}
+}
+
+// Acceptance represents acceptance of policy version by the user
+type Acceptance struct {
+	ID        string `json:"id"`
+	User      string `json:"user"`
+	Policy    string `json:"policy"`
+	Version   int64  `json:"version"`
+	Accepted  int64  `json:"accepted"`
+	IP        string `json:"ip"`
+	UserAgent string `json:"user_agent,omitempty"`
+}
+
+// helper function to convert acceptance into storage record
+func (a Acceptance) record() map[string]any {
+	return map[string]any{
+		"_id":        a.ID,
+		"user":       a.User,
+		"policy":     a.Policy,
+		"version":    a.Version,
+		"accepted":   a.Accepted,
+		"ip":         a.IP,
+		"user_agent": a.UserAgent,
+	}
+}
+
+// helper function to convert storage record into policy
+func policyRecord(rec map[string]any) Policy {
+	p := Policy{}
+	p.Name, _ = rec["name"].(string)
+	p.Version = utils.ToInt64(rec["version"])
+	p.Title, _ = rec["title"].(string)
+	p.URL, _ = rec["url"].(string)
+	p.Summary, _ = rec["summary"].(string)
+	p.Published = utils.ToInt64(rec["published"])
+	p.PublishedBy, _ = rec["published_by"].(string)
+	return p
+}
+
+// helper function to convert storage record into acceptance
+func acceptanceRecord(rec map[string]any) Acceptance {
+	a := Acceptance{}
+	a.ID, _ = rec["_id"].(string)
+	a.User, _ = rec["user"].(string)
+	a.Policy, _ = rec["policy"].(string)
+	a.Version = utils.ToInt64(rec["version"])
+	a.Accepted = utils.ToInt64(rec["accepted"])
+	a.IP, _ = rec["ip"].(string)
+	a.UserAgent, _ = rec["user_agent"].(string)
+	return a
+}
+
+// Manager represents policies and their acceptance by users
+type Manager struct {
+	Store            storage.Store // store of policies and acceptances
+	Collection       string        // collection of acceptances
+	PolicyCollection string        // collection of policy versions
+	ExemptPaths      []string      // path prefixes accessible without acceptance
+	CacheTTL         time.Duration // cache of current policy versions
+	ClientID         string        // secret of JWT tokens of requests without claims in gin context
+	Audit            *audit.Logger // audit trail, optional
+
+	mutex    sync.Mutex
+	current  []Policy
+	cachedAt time.Time
+}
+
+// Consent represents global consent manager, it should be initialized via
+// Init function
+var Consent *Manager
+
+// NewManager returns consent manager of given store and configuration
+func NewManager(store storage.Store, cfg srvConfig.Consent) *Manager {
+	m := &Manager{
+		Store:            store,
+		Collection:       cfg.Collection,
+		PolicyCollection: cfg.PolicyCollection,
+		ExemptPaths:      cfg.ExemptPaths,
+		CacheTTL:         cfg.CacheTTL,
+	}
+	if m.Collection == "" {
+		m.Collection = DefaultCollection
+	}
+	if m.PolicyCollection == "" {
+		m.PolicyCollection = DefaultPolicyCollection
+	}
+	if len(m.ExemptPaths) == 0 {
+		m.ExemptPaths = DefaultExemptPaths
+	}
+	if m.CacheTTL == 0 {
+		m.CacheTTL = DefaultCacheTTL
+	}
+	return m
+}
+
+// Init initializes global consent manager of Consent configuration, audit
+// trail is provided by global audit logger
+func Init() error {
+	if srvConfig.Config == nil {
+		return errors.New("server configuration is not initialized")
+	}
+	cfg := srvConfig.Config.Consent
+	dbname := cfg.DBName
+	if dbname == "" {
+		dbname = srvConfig.Config.CHESSMetaData.DBName
+	}
+	if dbname == "" {
+		return errors.New("consent database is not configured")
+	}
+	Consent = NewManager(storage.NewStore(dbname), cfg)
+	Consent.ClientID = srvConfig.Config.Authz.ClientID
+	Consent.Audit = audit.AuditLogger
+	return nil
+}
+
+// helper function to record audit entry of consent action
+func (m *Manager) record(ctx context.Context, rec audit.Record) error {
+	if m.Audit == nil {
+		return nil
+	}
+	return m.Audit.Record(ctx, rec)
+}
+
+// Publish publishes new version of the policy, its version follows current
+// version of the policy and all users should accept it
+func (m *Manager) Publish(ctx context.Context, actor string, p Policy) (Policy, error) {
+	if !patternPolicyName.MatchString(p.Name) {
+		return Policy{}, fmt.Errorf("%w: invalid policy name %q", ErrInvalid, p.Name)
+	}
+	if strings.TrimSpace(p.Title) == "" {
+		return Policy{}, fmt.Errorf("%w: policy title is required", ErrInvalid)
+	}
+	current, err := m.Current(ctx, p.Name)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return Policy{}, err
+	}
+	p.Version = current.Version + 1
+	p.Published = time.Now().Unix()
+	p.PublishedBy = actor
+	if err := m.Store.Insert(ctx, m.PolicyCollection, p.record()); err != nil {
+		if errors.Is(err, storage.ErrDuplicate) {
+			// concurrent publication of the same version
+			return Policy{}, fmt.Errorf("%w: %s version %d", ErrConflict, p.Name, p.Version)
+		}
+		log.Printf("ERROR: unable to publish policy %s, error %v", p.Name, err)
+		return Policy{}, err
+	}
+	m.mutex.Lock()
+	m.current = nil
+	m.mutex.Unlock()
+	details := map[string]any{"policy": p.Name, "version": p.Version}
+	err = m.record(ctx, audit.Record{Subject: actor, Action: AuditPublish, Resource: "consent/policies/" + p.Name, Details: details})
+	return p, err
+}
+
+// Current returns current version of the policy
+func (m *Manager) Current(ctx context.Context, name string) (Policy, error) {
+	opts := &storage.FindOptions{Limit: 1, Sort: []string{"-version"}}
+	records, err := m.Store.Find(ctx, m.PolicyCollection, map[string]any{"name": name}, opts)
+	if err != nil {
+		return Policy{}, err
+	}
+	if len(records) == 0 {
+		return Policy{}, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	return policyRecord(records[0]), nil
+}
+
+// Versions returns all versions of the policy, latest version first
+func (m *Manager) Versions(ctx context.Context, name string) ([]Policy, error) {
+	opts := &storage.FindOptions{Sort: []string{"-version"}}
+	records, err := m.Store.Find(ctx, m.PolicyCollection, map[string]any{"name": name}, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	var out []Policy
+	for _, rec := range records {
+		out = append(out, policyRecord(rec))
+	}
+	return out, nil
+}
+
+// Policies returns current versions of all published policies, they are
+// cached for CacheTTL
+func (m *Manager) Policies(ctx context.Context) ([]Policy, error) {
+	m.mutex.Lock()
+	if m.current != nil && time.Since(m.cachedAt) < m.CacheTTL {
+		current := m.current
+		m.mutex.Unlock()
+		return current, nil
+	}
+	m.mutex.Unlock()
+	records, err := m.Store.Find(ctx, m.PolicyCollection, map[string]any{}, &storage.FindOptions{Sort: []string{"name", "-version"}})
+	if err != nil {
+		return nil, err
+	}
+	current := []Policy{}
+	for _, rec := range records {
+		p := policyRecord(rec)
+		if n := len(current); n > 0 && current[n-1].Name == p.Name {
+			continue
+		}
+		current = append(current, p)
+	}
+	m.mutex.Lock()
+	m.current = current
+	m.cachedAt = time.Now()
+	m.mutex.Unlock()
+	return current, nil
+}
+
+// Accept records acceptance of policy version by the user, only current
+// version may be accepted and repeated acceptance returns existing one
+func (m *Manager) Accept(ctx context.Context, user, name string, version int64, ip, userAgent string) (Acceptance, error) {
+	if user == "" {
+		return Acceptance{}, fmt.Errorf("%w: user is required", ErrInvalid)
+	}
+	current, err := m.Current(ctx, name)
+	if err != nil {
+		return Acceptance{}, err
+	}
+	if version != current.Version {
+		return Acceptance{}, fmt.Errorf("%w: %s version %d, current version %d", ErrOutdated, name, version, current.Version)
+	}
+	spec := map[string]any{"user": user, "policy": name, "version": version}
+	rec, err := storage.FindOne(ctx, m.Store, m.Collection, spec)
+	if err == nil {
+		return acceptanceRecord(rec), nil
+	} else if !errors.Is(err, storage.ErrNotFound) {
+		return Acceptance{}, err
+	}
+	a := Acceptance{
+		ID:        uuid.NewString(),
+		User:      user,
+		Policy:    name,
+		Version:   version,
+		Accepted:  time.Now().Unix(),
+		IP:        ip,
+		UserAgent: userAgent,
+	}
+	if err := m.Store.Insert(ctx, m.Collection, a.record()); err != nil {
+		log.Printf("ERROR: unable to record acceptance of %s by %s, error %v", name, user, err)
+		return Acceptance{}, err
+	}
+	details := map[string]any{"policy": name, "version": version}
+	err = m.record(ctx, audit.Record{Subject: user, Action: AuditAccept, Resource: "consent/policies/" + name, Details: details, IP: ip})
+	return a, err
+}
+
+// Acceptances returns acceptances of the user, latest acceptance first
+func (m *Manager) Acceptances(ctx context.Context, user string) ([]Acceptance, error) {
+	opts := &storage.FindOptions{Sort: []string{"-accepted"}}
+	records, err := m.Store.Find(ctx, m.Collection, map[string]any{"user": user}, opts)
+	if err != nil {
+		return nil, err
+	}
+	out := []Acceptance{}
+	for _, rec := range records {
+		out = append(out, acceptanceRecord(rec))
+	}
+	return out, nil
+}
+
+// Pending returns current policy versions which the user did not accept
+func (m *Manager) Pending(ctx context.Context, user string) ([]Policy, error) {
+	policies, err := m.Policies(ctx)
+	if err != nil || len(policies) == 0 {
+		return []Policy{}, err
+	}
+	accepted, err := m.Acceptances(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	versions := make(map[string]bool, len(accepted))
+	for _, a := range accepted {
+		versions[fmt.Sprintf("%s:%d", a.Policy, a.Version)] = true
+	}
+	pending := []Policy{}
+	for _, p := range policies {
+		if !versions[fmt.Sprintf("%s:%d", p.Name, p.Version)] {
+			pending = append(pending, p)
+		}
+	}
+	return pending, nil
+}
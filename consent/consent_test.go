@@ -0,0 +1,145 @@
+package consent
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	srvConfig "github.com/CHESSComputing/golib/config"
+	storage "github.com/CHESSComputing/golib/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// TestConsent
+func TestConsent(t *testing.T) {
+	ctx := context.Background()
+	m := NewManager(storage.NewMemoryStore(), srvConfig.Consent{})
+	if _, err := m.Publish(ctx, "admin", Policy{Name: "Terms"}); !errors.Is(err, ErrInvalid) {
+		t.Errorf("invalid policy is published, error %v", err)
+	}
+	terms, err := m.Publish(ctx, "admin", Policy{Name: "terms", Title: "Terms of use"})
+	if err != nil || terms.Version != 1 {
+		t.Fatalf("wrong policy %+v, error %v", terms, err)
+	}
+	if _, err := m.Publish(ctx, "admin", Policy{Name: "privacy", Title: "Data policy"}); err != nil {
+		t.Fatal(err)
+	}
+	pending, err := m.Pending(ctx, "alice")
+	if err != nil || len(pending) != 2 {
+		t.Fatalf("wrong pending policies %+v, error %v", pending, err)
+	}
+	a, err := m.Accept(ctx, "alice", "terms", 1, "10.0.0.1", "test")
+	if err != nil || a.IP != "10.0.0.1" || a.Accepted == 0 {
+		t.Fatalf("wrong acceptance %+v, error %v", a, err)
+	}
+	if again, err := m.Accept(ctx, "alice", "terms", 1, "10.0.0.2", "test"); err != nil || again.ID != a.ID {
+		t.Errorf("repeated acceptance is recorded, error %v", err)
+	}
+	if _, err := m.Accept(ctx, "alice", "privacy", 2, "10.0.0.1", "test"); !errors.Is(err, ErrOutdated) {
+		t.Errorf("unknown version is accepted, error %v", err)
+	}
+	if pending, _ := m.Pending(ctx, "alice"); len(pending) != 1 || pending[0].Name != "privacy" {
+		t.Errorf("wrong pending policies %+v", pending)
+	}
+
+	// new version requires new acceptance
+	terms, err = m.Publish(ctx, "admin", Policy{Name: "terms", Title: "Terms of use", Summary: "data retention"})
+	if err != nil || terms.Version != 2 {
+		t.Fatalf("wrong policy %+v, error %v", terms, err)
+	}
+	if _, err := m.Accept(ctx, "alice", "terms", 1, "10.0.0.1", "test"); !errors.Is(err, ErrOutdated) {
+		t.Errorf("outdated version is accepted, error %v", err)
+	}
+	if pending, _ := m.Pending(ctx, "alice"); len(pending) != 2 || pending[1].Version != 2 {
+		t.Errorf("wrong pending policies %+v", pending)
+	}
+	if versions, err := m.Versions(ctx, "terms"); err != nil || len(versions) != 2 || versions[0].Version != 2 {
+		t.Errorf("wrong policy versions %+v, error %v", versions, err)
+	}
+	if accepted, _ := m.Acceptances(ctx, "alice"); len(accepted) != 1 {
+		t.Errorf("wrong acceptances %+v", accepted)
+	}
+}
+
+// TestMiddleware
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	m := NewManager(storage.NewMemoryStore(), srvConfig.Consent{})
+	m.ClientID = "secret"
+	token := func(roles ...string) string {
+		custom := authz.CustomClaims{User: "alice", Roles: roles}
+		token, err := authz.JWTAccessToken("secret", 3600, custom)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	userToken, adminToken := token(), token("admin")
+
+	// consent middleware follows token middleware of authorized routes
+	r := gin.New()
+	group := r.Group("/", authz.TokenMiddleware("secret", 0), m.Middleware())
+	for _, route := range m.Routes("/consent") {
+		group.Handle(route.Method, route.Path, route.Handler)
+	}
+	group.GET("/data", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	// consent middleware is used globally before token middleware
+	global := gin.New()
+	global.Use(m.Middleware())
+	global.GET("/data", authz.TokenMiddleware("secret", 0), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	call := func(r *gin.Engine, method, path, body, token string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := call(r, "GET", "/data", "", userToken); code != http.StatusOK {
+		t.Errorf("request without published policies, code %d", code)
+	}
+	if code := call(r, "GET", "/data", "", ""); code != http.StatusUnauthorized {
+		t.Errorf("request without token, code %d", code)
+	}
+	if code := call(r, "POST", "/consent/policies", `{"name":"terms","title":"Terms of use"}`, userToken); code != http.StatusForbidden {
+		t.Errorf("policy is published without administrator role, code %d", code)
+	}
+	if code := call(r, "POST", "/consent/policies", `{"name":"terms","title":"Terms of use"}`, adminToken); code != http.StatusCreated {
+		t.Errorf("policy is not published, code %d", code)
+	}
+	if code := call(r, "GET", "/data", "", userToken); code != http.StatusForbidden {
+		t.Errorf("request without acceptance, code %d", code)
+	}
+	if code := call(global, "GET", "/data", "", userToken); code != http.StatusForbidden {
+		t.Errorf("request without acceptance before token middleware, code %d", code)
+	}
+	if code := call(r, "POST", "/consent/accept", `{"policy":"terms","version":1}`, userToken); code != http.StatusOK {
+		t.Errorf("policy is not accepted, code %d", code)
+	}
+	if code := call(r, "GET", "/data", "", userToken); code != http.StatusOK {
+		t.Errorf("request after acceptance, code %d", code)
+	}
+	if code := call(global, "GET", "/data", "", userToken); code != http.StatusOK {
+		t.Errorf("request after acceptance before token middleware, code %d", code)
+	}
+	if code := call(r, "GET", "/consent/acceptances?user=bob", "", userToken); code != http.StatusForbidden {
+		t.Errorf("acceptances of other user, code %d", code)
+	}
+	if _, err := m.Publish(ctx, "admin", Policy{Name: "terms", Title: "Terms of use"}); err != nil {
+		t.Fatal(err)
+	}
+	if code := call(r, "GET", "/data", "", userToken); code != http.StatusForbidden {
+		t.Errorf("request without acceptance of new version, code %d", code)
+	}
+}
@@ -0,0 +1,233 @@
+package consent
+
+// handlers module provides HTTP endpoints of policies and their acceptance
+// and middleware which requires acceptance of current policy versions
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	server "github.com/CHESSComputing/golib/server"
+	services "github.com/CHESSComputing/golib/services"
+	utils "github.com/CHESSComputing/golib/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// AdminRoles defines roles which may publish policies and list
+// acceptances of other users
+var AdminRoles = []string{"admin"}
+
+// PublishRequest represents new version of the policy
+type PublishRequest struct {
+	Name    string `json:"name"`
+	Title   string `json:"title"`
+	URL     string `json:"url,omitempty"`
+	Summary string `json:"summary,omitempty"`
+}
+
+// AcceptRequest represents acceptance of policy version
+type AcceptRequest struct {
+	Policy  string `json:"policy"`
+	Version int64  `json:"version"`
+}
+
+// helper function to abort request with service error
+func abort(c *gin.Context, code, srvCode int, err error) {
+	rec := services.Response("consent", code, srvCode, err)
+	c.AbortWithStatusJSON(code, rec)
+}
+
+// helper function to abort request with error of the manager
+func abortWithError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		abort(c, http.StatusNotFound, services.QueryError, err)
+	case errors.Is(err, ErrOutdated), errors.Is(err, ErrConflict):
+		abort(c, http.StatusConflict, services.ConflictError, err)
+	case errors.Is(err, ErrInvalid):
+		abort(c, http.StatusBadRequest, services.ValidateError, err)
+	default:
+		log.Printf("ERROR: consent request failed, error %v", err)
+		abort(c, http.StatusInternalServerError, services.DatabaseError, err)
+	}
+}
+
+// helper function to provide user of the request and whether the user has
+// one of AdminRoles. Token claims are provided by token validation
+// middleware or, if middleware did not run yet, by JWT token of the
+// request. Invalid tokens provide no user, they are rejected by token
+// validation middleware.
+func (m *Manager) requestUser(c *gin.Context) (string, bool) {
+	var claims *authz.Claims
+	if val, ok := c.Get("claims"); ok {
+		claims, _ = val.(*authz.Claims)
+	}
+	if claims == nil && m.ClientID != "" {
+		if token := authz.RequestToken(c.Request); token != "" {
+			claims, _ = authz.TokenClaims(token, m.ClientID)
+		}
+	}
+	if claims == nil {
+		return "", false
+	}
+	for _, role := range claims.CustomClaims.Roles {
+		if utils.InList(role, AdminRoles) {
+			return claims.CustomClaims.User, true
+		}
+	}
+	return claims.CustomClaims.User, false
+}
+
+// helper function to wrap handler which requires one of AdminRoles
+func (m *Manager) admin(handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := m.requestUser(c); !ok {
+			abort(c, http.StatusForbidden, services.PolicyError, errors.New("policy publication requires administrator role"))
+			return
+		}
+		handler(c)
+	}
+}
+
+// Middleware provides gin middleware which rejects requests of users who
+// did not accept current versions of policies, requests without token
+// and requests of ExemptPaths are passed. It may be used before or after
+// token validation middleware.
+func (m *Manager) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for _, path := range m.ExemptPaths {
+			if strings.HasPrefix(c.Request.URL.Path, path) {
+				c.Next()
+				return
+			}
+		}
+		user, _ := m.requestUser(c)
+		if user == "" {
+			c.Next()
+			return
+		}
+		pending, err := m.Pending(c.Request.Context(), user)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		if len(pending) > 0 {
+			var names []string
+			for _, p := range pending {
+				names = append(names, fmt.Sprintf("%s version %d", p.Name, p.Version))
+			}
+			err := fmt.Errorf("%w: %s", ErrRequired, strings.Join(names, ", "))
+			abort(c, http.StatusForbidden, services.PolicyError, err)
+			return
+		}
+		c.Next()
+	}
+}
+
+// PoliciesHandler provides current versions of policies
+func (m *Manager) PoliciesHandler(c *gin.Context) {
+	policies, err := m.Policies(c.Request.Context())
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, policies)
+}
+
+// VersionsHandler provides all versions of the policy
+func (m *Manager) VersionsHandler(c *gin.Context) {
+	versions, err := m.Versions(c.Request.Context(), c.Param("name"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, versions)
+}
+
+// PublishHandler publishes new version of the policy with JSON
+// PublishRequest
+func (m *Manager) PublishHandler(c *gin.Context) {
+	var req PublishRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abort(c, http.StatusBadRequest, services.BindError, err)
+		return
+	}
+	user, _ := m.requestUser(c)
+	p := Policy{Name: req.Name, Title: req.Title, URL: req.URL, Summary: req.Summary}
+	p, err := m.Publish(c.Request.Context(), user, p)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, p)
+}
+
+// PendingHandler provides policies which user of the request should accept
+func (m *Manager) PendingHandler(c *gin.Context) {
+	user, _ := m.requestUser(c)
+	pending, err := m.Pending(c.Request.Context(), user)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, pending)
+}
+
+// AcceptHandler records acceptance of policy version with JSON
+// AcceptRequest by user of the request
+func (m *Manager) AcceptHandler(c *gin.Context) {
+	var req AcceptRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abort(c, http.StatusBadRequest, services.BindError, err)
+		return
+	}
+	user, _ := m.requestUser(c)
+	a, err := m.Accept(c.Request.Context(), user, req.Policy, req.Version, c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, a)
+}
+
+// AcceptancesHandler provides acceptances of user of the request,
+// administrators may query acceptances of other users via user parameter
+func (m *Manager) AcceptancesHandler(c *gin.Context) {
+	user, isAdmin := m.requestUser(c)
+	if other := c.Query("user"); other != "" && other != user {
+		if !isAdmin {
+			abort(c, http.StatusForbidden, services.PolicyError, errors.New("acceptances of other users require administrator role"))
+			return
+		}
+		user = other
+	}
+	accepted, err := m.Acceptances(c.Request.Context(), user)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, accepted)
+}
+
+// Routes returns authorized server routes of policies and acceptances
+// under given path, e.g. /consent, which should be one of ExemptPaths
+func (m *Manager) Routes(path string) []server.Route {
+	return []server.Route{
+		{Method: "GET", Path: path + "/policies", Authorized: true, Handler: m.PoliciesHandler,
+			Summary: "current versions of policies", Response: []Policy{}},
+		{Method: "GET", Path: path + "/policies/:name", Authorized: true, Handler: m.VersionsHandler,
+			Summary: "versions of the policy", Response: []Policy{}},
+		{Method: "POST", Path: path + "/policies", Authorized: true, Handler: m.admin(m.PublishHandler),
+			Summary: "publish new version of the policy", Request: PublishRequest{}, Response: Policy{}},
+		{Method: "GET", Path: path + "/pending", Authorized: true, Handler: m.PendingHandler,
+			Summary: "policies which user should accept", Response: []Policy{}},
+		{Method: "POST", Path: path + "/accept", Authorized: true, Handler: m.AcceptHandler,
+			Summary: "accept policy version", Request: AcceptRequest{}, Response: Acceptance{}},
+		{Method: "GET", Path: path + "/acceptances", Authorized: true, Handler: m.AcceptancesHandler,
+			Summary: "acceptances of the user", Response: []Acceptance{}},
+	}
+}
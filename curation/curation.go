@@ -0,0 +1,387 @@
+package curation
+
+// curation module provides merge and split operations of metadata records
+// to clean up messy early-ingest data. Operations are executed within a
+// transaction, history of records is preserved by versioned store, old
+// record ids are kept as redirect stubs and provenance edges link new
+// records to records they are derived from.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	provenance "github.com/CHESSComputing/golib/provenance"
+	storage "github.com/CHESSComputing/golib/storage"
+	utils "github.com/CHESSComputing/golib/utils"
+	"github.com/google/uuid"
+)
+
+// DefaultRedirects defines storage collection of redirect stubs
+var DefaultRedirects = "redirects"
+
+// redirect reasons
+const (
+	ReasonMerged = "merged"
+	ReasonSplit  = "split"
+)
+
+// errors of curation module
+var (
+	ErrInvalid  = errors.New("invalid curation request")
+	ErrNotFound = errors.New("record not found")
+	ErrConflict = errors.New("record id already exists")
+)
+
+// Redirect represents redirect stub of old record id to records which
+// replace it
+type Redirect struct {
+	ID      string   `json:"id"`      // old record id
+	Targets []string `json:"targets"` // ids of records which replace the old one
+	Reason  string   `json:"reason"`  // merged or split
+	Actor   string   `json:"actor"`
+	Comment string   `json:"comment,omitempty"`
+	Time    int64    `json:"time"`
+}
+
+// helper function to convert redirect into storage record
+func (r Redirect) record() map[string]any {
+	return map[string]any{
+		"_id":     r.ID,
+		"targets": r.Targets,
+		"reason":  r.Reason,
+		"actor":   r.Actor,
+		"comment": r.Comment,
+		"time":    r.Time,
+	}
+}
+
+// helper function to convert storage record into redirect
+func redirectRecord(rec map[string]any) Redirect {
+	r := Redirect{}
+	r.ID, _ = rec["_id"].(string)
+	r.Reason, _ = rec["reason"].(string)
+	r.Actor, _ = rec["actor"].(string)
+	r.Comment, _ = rec["comment"].(string)
+	if list, ok := utils.ListValues(rec["targets"]); ok {
+		for _, v := range list {
+			r.Targets = append(r.Targets, fmt.Sprintf("%v", v))
+		}
+	}
+	switch v := rec["time"].(type) {
+	case int64:
+		r.Time = v
+	case int32:
+		r.Time = int64(v)
+	case int:
+		r.Time = int64(v)
+	case float64:
+		r.Time = int64(v)
+	}
+	return r
+}
+
+// MergeRequest represents request to merge source record into target
+// record
+type MergeRequest struct {
+	Source  string         `json:"source"`           // id of merged record, it is removed
+	Target  string         `json:"target"`           // id of record which keeps merged keys
+	Fields  map[string]any `json:"fields,omitempty"` // values of conflicting keys, target values are kept otherwise
+	Comment string         `json:"comment,omitempty"`
+}
+
+// SplitPart represents new record produced by split
+type SplitPart struct {
+	ID     string         `json:"id,omitempty"`     // id of new record, generated if empty
+	Keys   []string       `json:"keys,omitempty"`   // keys copied from source record, all keys if empty
+	Fields map[string]any `json:"fields,omitempty"` // values of new record which override source values
+}
+
+// SplitRequest represents request to split source record into new records
+type SplitRequest struct {
+	Source  string      `json:"source"` // id of split record, it is removed
+	Parts   []SplitPart `json:"parts"`  // new records, at least two
+	Comment string      `json:"comment,omitempty"`
+}
+
+// Result represents result of merge or split operation
+type Result struct {
+	Records  []map[string]any  `json:"records"`  // records which replace source record
+	Redirect Redirect          `json:"redirect"` // redirect stub of source record
+	Edges    []provenance.Edge `json:"edges"`    // recorded provenance edges
+}
+
+// Manager represents merge and split operations of metadata records
+type Manager struct {
+	Store      *storage.VersionedStore // store of metadata records
+	Collection string                  // collection of metadata records
+	Redirects  string                  // collection of redirect stubs
+	Graph      *provenance.Graph       // provenance graph, optional
+	// Transaction executes function within a transaction, e.g.
+	// storage.Transaction of the store, operations use provided context.
+	// Nil value means that transactions are not supported.
+	Transaction func(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// Curation represents global curation manager, it should be initialized
+// via Init function
+var Curation *Manager
+
+// NewManager returns curation manager of records of given collection
+func NewManager(store *storage.VersionedStore, collection string, graph *provenance.Graph) *Manager {
+	return &Manager{Store: store, Collection: collection, Redirects: DefaultRedirects, Graph: graph}
+}
+
+// Init initializes global curation manager of records of CHESS MetaData
+// service, operations are executed within transactions of the store
+func Init() error {
+	if srvConfig.Config == nil {
+		return errors.New("server configuration is not initialized")
+	}
+	cfg := srvConfig.Config.CHESSMetaData
+	if cfg.DBName == "" || cfg.DBColl == "" {
+		return errors.New("MetaData database is not configured")
+	}
+	store := storage.NewStore(cfg.DBName)
+	m := NewManager(storage.NewVersionedStore(store), cfg.DBColl, provenance.NewGraph(store))
+	m.Transaction = storage.Transaction(store)
+	Curation = m
+	return nil
+}
+
+// helper function to execute function within transaction if it is
+// supported
+func (m *Manager) transaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if m.Transaction == nil {
+		return fn(ctx)
+	}
+	return m.Transaction(ctx, fn)
+}
+
+// helper function to find record of given id
+func (m *Manager) find(ctx context.Context, id string) (map[string]any, error) {
+	rec, err := storage.FindOne(ctx, m.Store.Store, m.Collection, map[string]any{"_id": id})
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	return rec, err
+}
+
+// helper function to check that id is not used by records or redirects
+func (m *Manager) available(ctx context.Context, id string) error {
+	for _, coll := range []string{m.Collection, m.Redirects} {
+		n, err := m.Store.Store.Count(ctx, coll, map[string]any{"_id": id})
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			return fmt.Errorf("%w: %s", ErrConflict, id)
+		}
+	}
+	return nil
+}
+
+// helper function to replace source record by redirect stub and record
+// provenance edges of new records
+func (m *Manager) replace(ctx context.Context, actor, comment, reason, source string, targets []string) (Redirect, []provenance.Edge, error) {
+	if err := m.Store.Delete(ctx, m.Collection, actor, comment, source); err != nil {
+		return Redirect{}, nil, err
+	}
+	now := time.Now().Unix()
+	r := Redirect{ID: source, Targets: targets, Reason: reason, Actor: actor, Comment: comment, Time: now}
+	if err := m.Store.Store.Insert(ctx, m.Redirects, r.record()); err != nil {
+		return r, nil, err
+	}
+	// redirects to the source record are moved to its targets, i.e.
+	// redirect chains of merged records stay short
+	if len(targets) == 1 {
+		spec := map[string]any{"targets": []string{source}}
+		if _, err := m.Store.Store.Update(ctx, m.Redirects, spec, map[string]any{"targets": targets}); err != nil {
+			return r, nil, err
+		}
+	}
+	var edges []provenance.Edge
+	if m.Graph == nil {
+		return r, edges, nil
+	}
+	for _, target := range targets {
+		e := provenance.Edge{
+			From:       target,
+			Type:       provenance.DerivedFrom,
+			To:         source,
+			Time:       now,
+			Attributes: map[string]any{"operation": reason, "actor": actor},
+		}
+		if err := m.Graph.Link(ctx, e); err != nil {
+			return r, edges, err
+		}
+		edges = append(edges, e)
+	}
+	return r, edges, nil
+}
+
+// Merge merges source record into target record. Keys of source record
+// which target record does not have are added to the target record,
+// conflicting keys keep target values unless they are given in request
+// fields. Source record is removed and its id redirects to the target.
+func (m *Manager) Merge(ctx context.Context, actor string, req MergeRequest) (Result, error) {
+	var res Result
+	if req.Source == "" || req.Target == "" || req.Source == req.Target {
+		return res, fmt.Errorf("%w: merge requires two different records", ErrInvalid)
+	}
+	comment := req.Comment
+	if comment == "" {
+		comment = fmt.Sprintf("merge %s into %s", req.Source, req.Target)
+	}
+	err := m.transaction(ctx, func(ctx context.Context) error {
+		source, err := m.find(ctx, req.Source)
+		if err != nil {
+			return err
+		}
+		target, err := m.find(ctx, req.Target)
+		if err != nil {
+			return err
+		}
+		fields := make(map[string]any)
+		for k, v := range source {
+			if k == "_id" || k == storage.VersionKey {
+				continue
+			}
+			if _, ok := target[k]; !ok {
+				fields[k] = v
+			}
+		}
+		for k, v := range req.Fields {
+			fields[k] = v
+		}
+		ver, err := m.Store.Update(ctx, m.Collection, actor, comment, req.Target, fields, 0)
+		if err != nil {
+			return err
+		}
+		res.Records = []map[string]any{ver.Record}
+		res.Redirect, res.Edges, err = m.replace(ctx, actor, comment, ReasonMerged, req.Source, []string{req.Target})
+		return err
+	})
+	if err != nil {
+		log.Printf("ERROR: unable to merge record %s into %s, error %v", req.Source, req.Target, err)
+		return Result{}, err
+	}
+	return res, nil
+}
+
+// Split splits source record into new records. Every part copies given
+// keys (all keys if none given) of source record and overrides them by
+// its fields. Source record is removed and its id redirects to new
+// records.
+func (m *Manager) Split(ctx context.Context, actor string, req SplitRequest) (Result, error) {
+	var res Result
+	if req.Source == "" || len(req.Parts) < 2 {
+		return res, fmt.Errorf("%w: split requires source record and at least two parts", ErrInvalid)
+	}
+	comment := req.Comment
+	if comment == "" {
+		comment = fmt.Sprintf("split %s into %d records", req.Source, len(req.Parts))
+	}
+	err := m.transaction(ctx, func(ctx context.Context) error {
+		source, err := m.find(ctx, req.Source)
+		if err != nil {
+			return err
+		}
+		// all new records are checked before any change
+		var records []map[string]any
+		var ids []string
+		for _, part := range req.Parts {
+			id := part.ID
+			if id == "" {
+				id = uuid.NewString()
+			}
+			if utils.InList(id, ids) || id == req.Source {
+				return fmt.Errorf("%w: duplicate part id %s", ErrInvalid, id)
+			}
+			if err := m.available(ctx, id); err != nil {
+				return err
+			}
+			rec := map[string]any{"_id": id}
+			for k, v := range source {
+				if k == "_id" || k == storage.VersionKey {
+					continue
+				}
+				if len(part.Keys) == 0 || utils.InList(k, part.Keys) {
+					rec[k] = v
+				}
+			}
+			for k, v := range part.Fields {
+				if k != "_id" {
+					rec[k] = v
+				}
+			}
+			records = append(records, rec)
+			ids = append(ids, id)
+		}
+		for _, rec := range records {
+			if err := m.Store.Insert(ctx, m.Collection, actor, rec); err != nil {
+				return err
+			}
+			rec[storage.VersionKey] = 1
+		}
+		res.Records = records
+		res.Redirect, res.Edges, err = m.replace(ctx, actor, comment, ReasonSplit, req.Source, ids)
+		return err
+	})
+	if err != nil {
+		log.Printf("ERROR: unable to split record %s, error %v", req.Source, err)
+		return Result{}, err
+	}
+	return res, nil
+}
+
+// GetRedirect returns redirect stub of old record id
+func (m *Manager) GetRedirect(ctx context.Context, id string) (Redirect, error) {
+	rec, err := storage.FindOne(ctx, m.Store.Store, m.Redirects, map[string]any{"_id": id})
+	if errors.Is(err, storage.ErrNotFound) {
+		return Redirect{}, fmt.Errorf("%w: redirect %s", ErrNotFound, id)
+	}
+	if err != nil {
+		return Redirect{}, err
+	}
+	return redirectRecord(rec), nil
+}
+
+// Resolve returns ids of current records of given record id, i.e. redirect
+// stubs of merged and split records are followed until existing records
+func (m *Manager) Resolve(ctx context.Context, id string) ([]string, error) {
+	var out []string
+	seen := make(map[string]bool)
+	queue := []string{id}
+	for len(queue) > 0 {
+		rid := queue[0]
+		queue = queue[1:]
+		if seen[rid] {
+			continue
+		}
+		seen[rid] = true
+		n, err := m.Store.Store.Count(ctx, m.Collection, map[string]any{"_id": rid})
+		if err != nil {
+			return nil, err
+		}
+		if n > 0 {
+			out = append(out, rid)
+			continue
+		}
+		r, err := m.GetRedirect(ctx, rid)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		queue = append(queue, r.Targets...)
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	return out, nil
+}
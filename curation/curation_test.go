@@ -0,0 +1,186 @@
+package curation
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	provenance "github.com/CHESSComputing/golib/provenance"
+	storage "github.com/CHESSComputing/golib/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// helper function to create curation manager of memory store with records
+func testManager(t *testing.T) *Manager {
+	store := storage.NewMemoryStore()
+	vs := storage.NewVersionedStore(store)
+	ctx := context.Background()
+	for _, rec := range []map[string]any{
+		{"_id": "a", "sample": "steel", "scan": 1},
+		{"_id": "b", "sample": "Steel", "scan": 1, "comment": "re-run"},
+		{"_id": "c", "sample": "steel", "scans": []int{1, 2}, "energy": 41},
+	} {
+		if err := vs.Insert(ctx, "meta", "ingest", rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return NewManager(vs, "meta", provenance.NewGraph(store))
+}
+
+// TestMerge
+func TestMerge(t *testing.T) {
+	ctx := context.Background()
+	m := testManager(t)
+	var transactions int
+	m.Transaction = func(ctx context.Context, fn func(ctx context.Context) error) error {
+		transactions++
+		return fn(ctx)
+	}
+	res, err := m.Merge(ctx, "alice", MergeRequest{Source: "b", Target: "a", Fields: map[string]any{"sample": "steel bar"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := res.Records[0]
+	if rec["sample"] != "steel bar" || rec["comment"] != "re-run" || rec["scan"] != 1 || transactions != 1 {
+		t.Errorf("wrong merged record %v", rec)
+	}
+	if _, err := m.find(ctx, "b"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("merged record is not removed, error %v", err)
+	}
+	if r, err := m.GetRedirect(ctx, "b"); err != nil || len(r.Targets) != 1 || r.Targets[0] != "a" || r.Reason != ReasonMerged {
+		t.Errorf("wrong redirect %+v, error %v", r, err)
+	}
+	// history of both records is preserved
+	if h, _ := m.Store.History(ctx, "meta", "b"); len(h) != 2 || h[1].Action != storage.ActionDelete || h[1].Actor != "alice" {
+		t.Errorf("wrong history of merged record %+v", h)
+	}
+	if h, _ := m.Store.History(ctx, "meta", "a"); len(h) != 2 || h[1].Comment != "merge b into a" {
+		t.Errorf("wrong history of target record %+v", h)
+	}
+	if l, _ := m.Graph.Ancestors(ctx, "a", 0); len(l.Edges) != 1 || l.Edges[0].To != "b" {
+		t.Errorf("wrong provenance of merged record %+v", l)
+	}
+
+	// redirects to merged records follow the merge
+	if _, err := m.Merge(ctx, "alice", MergeRequest{Source: "a", Target: "c"}); err != nil {
+		t.Fatal(err)
+	}
+	if r, _ := m.GetRedirect(ctx, "b"); len(r.Targets) != 1 || r.Targets[0] != "c" {
+		t.Errorf("redirect chain is not updated %+v", r)
+	}
+	if ids, err := m.Resolve(ctx, "b"); err != nil || len(ids) != 1 || ids[0] != "c" {
+		t.Errorf("wrong resolved ids %v, error %v", ids, err)
+	}
+
+	expect := []struct {
+		req MergeRequest
+		err error
+	}{
+		{MergeRequest{Source: "c", Target: "c"}, ErrInvalid},
+		{MergeRequest{Source: "b", Target: "c"}, ErrNotFound},
+		{MergeRequest{Source: "c", Target: "x"}, ErrNotFound},
+	}
+	for _, e := range expect {
+		if _, err := m.Merge(ctx, "alice", e.req); !errors.Is(err, e.err) {
+			t.Errorf("merge %+v: expect error %v, got %v", e.req, e.err, err)
+		}
+	}
+}
+
+// TestSplit
+func TestSplit(t *testing.T) {
+	ctx := context.Background()
+	m := testManager(t)
+	req := SplitRequest{Source: "c", Parts: []SplitPart{
+		{ID: "c1", Keys: []string{"sample", "energy"}, Fields: map[string]any{"scan": 1}},
+		{Keys: []string{"sample"}, Fields: map[string]any{"scan": 2}},
+	}}
+	res, err := m.Split(ctx, "bob", req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Records) != 2 || len(res.Edges) != 2 || len(res.Redirect.Targets) != 2 {
+		t.Fatalf("wrong split result %+v", res)
+	}
+	c1, err := m.find(ctx, "c1")
+	if err != nil || c1["energy"] != 41 || c1["scan"] != 1 || c1["scans"] != nil {
+		t.Errorf("wrong split record %v, error %v", c1, err)
+	}
+	c2, err := m.find(ctx, res.Redirect.Targets[1])
+	if err != nil || c2["sample"] != "steel" || c2["scan"] != 2 || c2["energy"] != nil {
+		t.Errorf("wrong split record %v, error %v", c2, err)
+	}
+	if ids, err := m.Resolve(ctx, "c"); err != nil || len(ids) != 2 || ids[0] != "c1" {
+		t.Errorf("wrong resolved ids %v, error %v", ids, err)
+	}
+	if _, err := m.Resolve(ctx, "x"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unknown id is resolved, error %v", err)
+	}
+	if l, _ := m.Graph.Descendants(ctx, "c", 0); len(l.Nodes) != 2 || len(l.Edges) != 2 {
+		t.Errorf("wrong provenance of split records %+v", l)
+	}
+
+	// conflicts are detected before any change
+	expect := []struct {
+		req SplitRequest
+		err error
+	}{
+		{SplitRequest{Source: "a", Parts: []SplitPart{{ID: "a1"}}}, ErrInvalid},
+		{SplitRequest{Source: "a", Parts: []SplitPart{{ID: "a1"}, {ID: "a1"}}}, ErrInvalid},
+		{SplitRequest{Source: "a", Parts: []SplitPart{{ID: "a1"}, {ID: "b"}}}, ErrConflict},
+		{SplitRequest{Source: "a", Parts: []SplitPart{{ID: "a1"}, {ID: "c"}}}, ErrConflict},
+		{SplitRequest{Source: "x", Parts: []SplitPart{{}, {}}}, ErrNotFound},
+	}
+	for _, e := range expect {
+		if _, err := m.Split(ctx, "bob", e.req); !errors.Is(err, e.err) {
+			t.Errorf("split %+v: expect error %v, got %v", e.req, e.err, err)
+		}
+	}
+	if _, err := m.find(ctx, "a"); err != nil {
+		t.Errorf("record is changed by failed split, error %v", err)
+	}
+}
+
+// TestHandlers
+func TestHandlers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	m := testManager(t)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		claims := &authz.Claims{CustomClaims: authz.CustomClaims{User: c.GetHeader("X-User"), Roles: c.Request.Header.Values("X-Role")}}
+		c.Set("claims", claims)
+	})
+	for _, route := range m.Routes("/curation") {
+		r.Handle(route.Method, route.Path, route.Handler)
+	}
+	expect := []struct {
+		method, target, role, body string
+		code                       int
+		resp                       string
+	}{
+		{"POST", "/curation/merge", "", `{"source": "b", "target": "a"}`, http.StatusForbidden, ""},
+		{"POST", "/curation/merge", "curator", `{"source": "b", "target": "a"}`, http.StatusOK, `"reason":"merged"`},
+		{"POST", "/curation/merge", "curator", `{"source": "b", "target": "a"}`, http.StatusNotFound, ""},
+		{"POST", "/curation/split", "curator", `{"source": "c", "parts": [{"id": "a"}, {"id": "c2"}]}`, http.StatusConflict, ""},
+		{"POST", "/curation/split", "curator", `{"source": "c", "parts": [{"id": "c1"}]}`, http.StatusBadRequest, ""},
+		{"POST", "/curation/split", "admin", `{"source": "c", "parts": [{"id": "c1"}, {"id": "c2"}]}`, http.StatusCreated, `"targets":["c1","c2"]`},
+		{"GET", "/curation/redirects/b", "", "", http.StatusOK, `"actor":"carol"`},
+		{"GET", "/curation/redirects/a", "", "", http.StatusNotFound, ""},
+	}
+	for _, e := range expect {
+		req := httptest.NewRequest(e.method, e.target, strings.NewReader(e.body))
+		req.Header.Set("X-User", "carol")
+		if e.role != "" {
+			req.Header.Set("X-Role", e.role)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != e.code || !strings.Contains(w.Body.String(), e.resp) {
+			t.Errorf("%s %s: expect %d %s, got %d %s", e.method, e.target, e.code, e.resp, w.Code, w.Body.String())
+		}
+	}
+}
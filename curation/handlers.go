@@ -0,0 +1,112 @@
+package curation
+
+// handlers module provides HTTP endpoints of merge and split operations
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	server "github.com/CHESSComputing/golib/server"
+	services "github.com/CHESSComputing/golib/services"
+	storage "github.com/CHESSComputing/golib/storage"
+	utils "github.com/CHESSComputing/golib/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// CuratorRoles defines roles which may merge and split records
+var CuratorRoles = []string{"admin", "curator"}
+
+// helper function to abort request with service error
+func abort(c *gin.Context, code, srvCode int, err error) {
+	rec := services.Response("curation", code, srvCode, err)
+	c.AbortWithStatusJSON(code, rec)
+}
+
+// helper function to abort request with error of the manager
+func abortWithError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		abort(c, http.StatusNotFound, services.QueryError, err)
+	case errors.Is(err, ErrConflict), errors.Is(err, storage.ErrVersionConflict), errors.Is(err, storage.ErrDuplicate):
+		abort(c, http.StatusConflict, services.ConflictError, err)
+	case errors.Is(err, ErrInvalid):
+		abort(c, http.StatusBadRequest, services.ValidateError, err)
+	default:
+		log.Printf("ERROR: curation request failed, error %v", err)
+		abort(c, http.StatusInternalServerError, services.DatabaseError, err)
+	}
+}
+
+// helper function to wrap handler which requires one of CuratorRoles and
+// passes user of the request to it, token claims are provided by token
+// validation middleware
+func curator(handler func(c *gin.Context, user string)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if val, ok := c.Get("claims"); ok {
+			if claims, ok := val.(*authz.Claims); ok {
+				for _, role := range claims.CustomClaims.Roles {
+					if utils.InList(role, CuratorRoles) {
+						handler(c, claims.CustomClaims.User)
+						return
+					}
+				}
+			}
+		}
+		abort(c, http.StatusForbidden, services.PolicyError, errors.New("records curation requires curator role"))
+	}
+}
+
+// MergeHandler merges two records
+func (m *Manager) MergeHandler(c *gin.Context, user string) {
+	var req MergeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abort(c, http.StatusBadRequest, services.BindError, err)
+		return
+	}
+	res, err := m.Merge(c.Request.Context(), user, req)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// SplitHandler splits record into new records
+func (m *Manager) SplitHandler(c *gin.Context, user string) {
+	var req SplitRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abort(c, http.StatusBadRequest, services.BindError, err)
+		return
+	}
+	res, err := m.Split(c.Request.Context(), user, req)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, res)
+}
+
+// RedirectHandler provides redirect stub of old record id
+func (m *Manager) RedirectHandler(c *gin.Context) {
+	r, err := m.GetRedirect(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, r)
+}
+
+// Routes returns routes of curation operations under given path, e.g.
+// /curation
+func (m *Manager) Routes(path string) []server.Route {
+	return []server.Route{
+		{Method: "POST", Path: path + "/merge", Authorized: true, Scope: "write", Handler: curator(m.MergeHandler),
+			Summary: "merge two records", Request: MergeRequest{}, Response: Result{}},
+		{Method: "POST", Path: path + "/split", Authorized: true, Scope: "write", Handler: curator(m.SplitHandler),
+			Summary: "split record into new records", Request: SplitRequest{}, Response: Result{}},
+		{Method: "GET", Path: path + "/redirects/:id", Authorized: true, Scope: "read", Handler: m.RedirectHandler,
+			Summary: "get redirect of old record id", Response: Redirect{}},
+	}
+}
@@ -0,0 +1,174 @@
+package datamgmt
+
+// checksum module computes checksums of large data files in a single pass
+// over the file, where every checksum algorithm runs in its own goroutine
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/adler32"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// supported checksum algorithms
+const (
+	Adler32 = "adler32"
+	MD5     = "md5"
+	SHA256  = "sha256"
+	XXHash  = "xxhash"
+)
+
+// Algorithms lists supported checksum algorithms
+var Algorithms = []string{Adler32, MD5, SHA256, XXHash}
+
+// ChunkSize defines size of chunks read from files
+var ChunkSize = 4 * 1024 * 1024
+
+// ProgressFunc is called with number of processed and total bytes
+type ProgressFunc func(done, total int64)
+
+// NewHash returns hash for given checksum algorithm
+func NewHash(alg string) (hash.Hash, error) {
+	switch strings.ToLower(alg) {
+	case Adler32:
+		return adler32.New(), nil
+	case MD5:
+		return md5.New(), nil
+	case SHA256:
+		return sha256.New(), nil
+	case XXHash, "xxh64", "xxhash64":
+		return NewXXHash64(), nil
+	}
+	return nil, fmt.Errorf("unsupported checksum algorithm '%s'", alg)
+}
+
+// Checksum computes checksums of given reader for provided algorithms. The
+// data is read once and every chunk is hashed concurrently by all
+// algorithms. The total size is only used in progress callback.
+func Checksum(reader io.Reader, total int64, algs []string, progress ProgressFunc) (map[string]string, error) {
+	if len(algs) == 0 {
+		algs = Algorithms
+	}
+	hashes := make(map[string]hash.Hash)
+	for _, alg := range algs {
+		h, err := NewHash(alg)
+		if err != nil {
+			return nil, err
+		}
+		hashes[strings.ToLower(alg)] = h
+	}
+
+	// each hash consumes chunks from its own channel, the chunk buffer is
+	// reused once all hashes processed it
+	var wg sync.WaitGroup
+	var chans []chan []byte
+	var done sync.WaitGroup
+	for _, h := range hashes {
+		ch := make(chan []byte)
+		chans = append(chans, ch)
+		done.Add(1)
+		go func(h hash.Hash, ch chan []byte) {
+			defer done.Done()
+			for chunk := range ch {
+				h.Write(chunk)
+				wg.Done()
+			}
+		}(h, ch)
+	}
+	closeAll := func() {
+		for _, ch := range chans {
+			close(ch)
+		}
+		done.Wait()
+	}
+
+	// use two buffers to read next chunk while hashes process current one
+	buffers := [2][]byte{make([]byte, ChunkSize), make([]byte, ChunkSize)}
+	var processed int64
+	for idx := 0; ; idx = 1 - idx {
+		n, err := io.ReadFull(reader, buffers[idx])
+		wg.Wait()
+		if n > 0 {
+			wg.Add(len(chans))
+			for _, ch := range chans {
+				ch <- buffers[idx][:n]
+			}
+			processed += int64(n)
+			if progress != nil {
+				progress(processed, total)
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			wg.Wait()
+			closeAll()
+			return nil, err
+		}
+	}
+	wg.Wait()
+	closeAll()
+
+	out := make(map[string]string)
+	for alg, h := range hashes {
+		out[alg] = hex.EncodeToString(h.Sum(nil))
+	}
+	return out, nil
+}
+
+// FileChecksum computes checksums of given file
+func FileChecksum(fname string, algs []string, progress ProgressFunc) (map[string]string, error) {
+	file, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return Checksum(file, info.Size(), algs, progress)
+}
+
+// ChecksumMismatch represents mismatch between expected and actual checksum
+type ChecksumMismatch struct {
+	Path      string `json:"path"`
+	Algorithm string `json:"algorithm"`
+	Expected  string `json:"expected"`
+	Actual    string `json:"actual"`
+}
+
+// Error implements error interface
+func (m ChecksumMismatch) Error() string {
+	return fmt.Sprintf("%s checksum mismatch for %s: expected %s got %s", m.Algorithm, m.Path, m.Expected, m.Actual)
+}
+
+// VerifyFile verifies checksums of given file against expected ones and
+// returns list of mismatches
+func VerifyFile(fname string, expected map[string]string, progress ProgressFunc) ([]ChecksumMismatch, error) {
+	var algs []string
+	for alg := range expected {
+		algs = append(algs, alg)
+	}
+	sort.Strings(algs)
+	actual, err := FileChecksum(fname, algs, progress)
+	if err != nil {
+		return nil, err
+	}
+	var mismatches []ChecksumMismatch
+	for _, alg := range algs {
+		exp := strings.ToLower(expected[alg])
+		if act := actual[strings.ToLower(alg)]; act != exp {
+			mismatches = append(mismatches, ChecksumMismatch{Path: fname, Algorithm: alg, Expected: exp, Actual: act})
+		}
+	}
+	return mismatches, nil
+}
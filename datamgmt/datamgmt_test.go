@@ -0,0 +1,93 @@
+package datamgmt
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestXXHash64
+func TestXXHash64(t *testing.T) {
+	tests := map[string]uint64{
+		"":    0xef46db3751d8e999,
+		"abc": 0x44bc2cf5ad770999,
+	}
+	for input, expect := range tests {
+		h := NewXXHash64()
+		h.Write([]byte(input))
+		if h.Sum64() != expect {
+			t.Errorf("xxhash of '%s' expect %x got %x", input, expect, h.Sum64())
+		}
+	}
+	// hash of data written in one or multiple chunks should be the same
+	data := []byte(strings.Repeat("FOXDEN data management ", 10))
+	h1 := NewXXHash64()
+	h1.Write(data)
+	h2 := NewXXHash64()
+	for i := 0; i < len(data); i += 7 {
+		end := i + 7
+		if end > len(data) {
+			end = len(data)
+		}
+		h2.Write(data[i:end])
+	}
+	if h1.Sum64() != h2.Sum64() {
+		t.Error("xxhash of chunked data differs")
+	}
+}
+
+// TestChecksum
+func TestChecksum(t *testing.T) {
+	ChunkSize = 2
+	defer func() { ChunkSize = 4 * 1024 * 1024 }()
+	var calls int
+	out, err := Checksum(bytes.NewReader([]byte("hello")), 5, nil, func(done, total int64) { calls++ })
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string]string{
+		MD5:     "5d41402abc4b2a76b9719d911017c592",
+		SHA256:  "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824",
+		Adler32: "062c0215",
+	}
+	for alg, val := range expect {
+		if out[alg] != val {
+			t.Errorf("%s checksum expect %s got %s", alg, val, out[alg])
+		}
+	}
+	if calls != 3 {
+		t.Errorf("wrong number of progress calls %d", calls)
+	}
+}
+
+// TestManifest
+func TestManifest(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "raw"), 0755)
+	for i := 0; i < 3; i++ {
+		fname := filepath.Join(root, "raw", fmt.Sprintf("scan%d.dat", i))
+		os.WriteFile(fname, []byte(strings.Repeat("x", i+1)), 0644)
+	}
+	manifest, err := BuildManifest(root, []string{MD5, XXHash}, nil)
+	if err != nil || len(manifest.Entries) != 3 || manifest.Entries[0].Path != "raw/scan0.dat" {
+		t.Fatalf("wrong manifest %+v, error %v", manifest, err)
+	}
+	var buf bytes.Buffer
+	WriteManifest(&buf, manifest)
+	manifest, err = ReadManifest(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mm, err := VerifyManifest("", manifest, nil); err != nil || len(mm) != 0 {
+		t.Errorf("unexpected mismatches %v, error %v", mm, err)
+	}
+	os.WriteFile(filepath.Join(root, "raw", "scan1.dat"), []byte("yy"), 0644)
+	os.Remove(filepath.Join(root, "raw", "scan2.dat"))
+	mm, _ := VerifyManifest(root, manifest, nil)
+	if len(mm) != 3 {
+		t.Errorf("expect 3 mismatches (md5, xxhash, missing file), got %v", mm)
+	}
+}
@@ -0,0 +1,142 @@
+package datamgmt
+
+// manifest module provides fixity manifest of data files
+
+import (
+	"encoding/json"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// ManifestEntry represents fixity information of single file
+type ManifestEntry struct {
+	Path      string            `json:"path"` // path relative to manifest root
+	Size      int64             `json:"size"`
+	ModTime   time.Time         `json:"mod_time"`
+	Checksums map[string]string `json:"checksums"`
+}
+
+// Manifest represents fixity manifest of files within root directory
+type Manifest struct {
+	Root       string          `json:"root"`
+	Created    time.Time       `json:"created"`
+	Algorithms []string        `json:"algorithms"`
+	Entries    []ManifestEntry `json:"entries"`
+}
+
+// BuildManifest walks given root directory and computes checksums of all
+// regular files. The progress callback receives number of processed and
+// total bytes of the whole tree.
+func BuildManifest(root string, algs []string, progress ProgressFunc) (Manifest, error) {
+	if len(algs) == 0 {
+		algs = Algorithms
+	}
+	manifest := Manifest{Root: root, Created: time.Now().UTC(), Algorithms: algs}
+	var files []string
+	var total int64
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files = append(files, path)
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return manifest, err
+	}
+	var processed int64
+	for _, path := range files {
+		info, err := os.Stat(path)
+		if err != nil {
+			return manifest, err
+		}
+		var fileProgress ProgressFunc
+		if progress != nil {
+			offset := processed
+			fileProgress = func(done, _ int64) {
+				progress(offset+done, total)
+			}
+		}
+		checksums, err := FileChecksum(path, algs, fileProgress)
+		if err != nil {
+			return manifest, err
+		}
+		processed += info.Size()
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return manifest, err
+		}
+		manifest.Entries = append(manifest.Entries, ManifestEntry{
+			Path:      filepath.ToSlash(rel),
+			Size:      info.Size(),
+			ModTime:   info.ModTime().UTC(),
+			Checksums: checksums,
+		})
+	}
+	sort.Slice(manifest.Entries, func(i, j int) bool {
+		return manifest.Entries[i].Path < manifest.Entries[j].Path
+	})
+	return manifest, nil
+}
+
+// WriteManifest writes manifest in JSON format
+func WriteManifest(w io.Writer, manifest Manifest) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(manifest)
+}
+
+// ReadManifest reads manifest in JSON format
+func ReadManifest(r io.Reader) (Manifest, error) {
+	var manifest Manifest
+	err := json.NewDecoder(r).Decode(&manifest)
+	return manifest, err
+}
+
+// VerifyManifest verifies files of given manifest located in root directory
+// (if root is empty manifest root is used) and returns list of mismatches.
+// Missing files and files with different size are reported as mismatches
+// of "file" and "size" algorithms, respectively.
+func VerifyManifest(root string, manifest Manifest, progress ProgressFunc) ([]ChecksumMismatch, error) {
+	if root == "" {
+		root = manifest.Root
+	}
+	var mismatches []ChecksumMismatch
+	for _, entry := range manifest.Entries {
+		path := filepath.Join(root, filepath.FromSlash(entry.Path))
+		info, err := os.Stat(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				mismatches = append(mismatches, ChecksumMismatch{Path: path, Algorithm: "file", Expected: "exists", Actual: "missing"})
+				continue
+			}
+			return mismatches, err
+		}
+		if info.Size() != entry.Size {
+			mismatches = append(mismatches, ChecksumMismatch{
+				Path: path, Algorithm: "size",
+				Expected: strconv.FormatInt(entry.Size, 10), Actual: strconv.FormatInt(info.Size(), 10),
+			})
+			continue
+		}
+		mm, err := VerifyFile(path, entry.Checksums, progress)
+		if err != nil {
+			return mismatches, err
+		}
+		mismatches = append(mismatches, mm...)
+	}
+	return mismatches, nil
+}
@@ -0,0 +1,137 @@
+package datamgmt
+
+// xxhash module provides pure Go implementation of XXH64 hash algorithm
+
+import (
+	"encoding/binary"
+	"hash"
+	"math/bits"
+)
+
+// XXH64 primes
+const (
+	prime64v1 uint64 = 11400714785074694791
+	prime64v2 uint64 = 14029467366897019727
+	prime64v3 uint64 = 1609587929392839161
+	prime64v4 uint64 = 9650029242287828579
+	prime64v5 uint64 = 2870177450012600261
+)
+
+// xxhash64 represents state of XXH64 digest
+type xxhash64 struct {
+	v1, v2, v3, v4 uint64
+	total          uint64
+	mem            [32]byte
+	n              int
+}
+
+// NewXXHash64 returns new XXH64 hash with zero seed
+func NewXXHash64() hash.Hash64 {
+	d := &xxhash64{}
+	d.Reset()
+	return d
+}
+
+// Reset implements hash.Hash interface
+func (d *xxhash64) Reset() {
+	// use variables since initial values overflow uint64 constants
+	p1, p2 := prime64v1, prime64v2
+	d.v1 = p1 + p2
+	d.v2 = p2
+	d.v3 = 0
+	d.v4 = -p1
+	d.total = 0
+	d.n = 0
+}
+
+// Size implements hash.Hash interface
+func (d *xxhash64) Size() int { return 8 }
+
+// BlockSize implements hash.Hash interface
+func (d *xxhash64) BlockSize() int { return 32 }
+
+// helper function to process single lane
+func xxround(acc, input uint64) uint64 {
+	acc += input * prime64v2
+	acc = bits.RotateLeft64(acc, 31)
+	return acc * prime64v1
+}
+
+// helper function to merge lane into accumulator
+func xxmerge(acc, val uint64) uint64 {
+	val = xxround(0, val)
+	acc ^= val
+	return acc*prime64v1 + prime64v4
+}
+
+// Write implements hash.Hash interface
+func (d *xxhash64) Write(b []byte) (int, error) {
+	n := len(b)
+	d.total += uint64(n)
+	if d.n+n < 32 {
+		copy(d.mem[d.n:], b)
+		d.n += n
+		return n, nil
+	}
+	if d.n > 0 {
+		c := copy(d.mem[d.n:], b)
+		d.v1 = xxround(d.v1, binary.LittleEndian.Uint64(d.mem[0:8]))
+		d.v2 = xxround(d.v2, binary.LittleEndian.Uint64(d.mem[8:16]))
+		d.v3 = xxround(d.v3, binary.LittleEndian.Uint64(d.mem[16:24]))
+		d.v4 = xxround(d.v4, binary.LittleEndian.Uint64(d.mem[24:32]))
+		b = b[c:]
+		d.n = 0
+	}
+	for ; len(b) >= 32; b = b[32:] {
+		d.v1 = xxround(d.v1, binary.LittleEndian.Uint64(b[0:8]))
+		d.v2 = xxround(d.v2, binary.LittleEndian.Uint64(b[8:16]))
+		d.v3 = xxround(d.v3, binary.LittleEndian.Uint64(b[16:24]))
+		d.v4 = xxround(d.v4, binary.LittleEndian.Uint64(b[24:32]))
+	}
+	d.n = copy(d.mem[:], b)
+	return n, nil
+}
+
+// Sum64 implements hash.Hash64 interface
+func (d *xxhash64) Sum64() uint64 {
+	var h uint64
+	if d.total >= 32 {
+		h = bits.RotateLeft64(d.v1, 1) + bits.RotateLeft64(d.v2, 7) +
+			bits.RotateLeft64(d.v3, 12) + bits.RotateLeft64(d.v4, 18)
+		h = xxmerge(h, d.v1)
+		h = xxmerge(h, d.v2)
+		h = xxmerge(h, d.v3)
+		h = xxmerge(h, d.v4)
+	} else {
+		h = d.v3 + prime64v5
+	}
+	h += d.total
+	b := d.mem[:d.n]
+	for ; len(b) >= 8; b = b[8:] {
+		k := xxround(0, binary.LittleEndian.Uint64(b[:8]))
+		h ^= k
+		h = bits.RotateLeft64(h, 27)*prime64v1 + prime64v4
+	}
+	if len(b) >= 4 {
+		h ^= uint64(binary.LittleEndian.Uint32(b[:4])) * prime64v1
+		h = bits.RotateLeft64(h, 23)*prime64v2 + prime64v3
+		b = b[4:]
+	}
+	for _, c := range b {
+		h ^= uint64(c) * prime64v5
+		h = bits.RotateLeft64(h, 11) * prime64v1
+	}
+	h ^= h >> 33
+	h *= prime64v2
+	h ^= h >> 29
+	h *= prime64v3
+	h ^= h >> 32
+	return h
+}
+
+// Sum implements hash.Hash interface
+func (d *xxhash64) Sum(b []byte) []byte {
+	var out [8]byte
+	binary.BigEndian.PutUint64(out[:], d.Sum64())
+	return append(b, out[:]...)
+}
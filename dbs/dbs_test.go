@@ -0,0 +1,198 @@
+package dbs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// TestParseDBFile
+func TestParseDBFile(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "dbfile")
+	os.WriteFile(fname, []byte("# comment\nsqlite3 /tmp/dbs.db\n"), 0600)
+	tests := map[string][]string{
+		fname:                         {"sqlite3", "/tmp/dbs.db"},
+		"sqlite3:///tmp/dbs.db":       {"sqlite3", "/tmp/dbs.db"},
+		"mysql://user:pass@/dbs":      {"mysql", "user:pass@/dbs"},
+		"postgres://user@host:5432/d": {"postgres", "postgres://user@host:5432/d"},
+	}
+	for dbfile, expect := range tests {
+		driver, uri, err := ParseDBFile(dbfile)
+		if err != nil || driver != expect[0] || uri != expect[1] {
+			t.Errorf("dbfile %s: expect %v got %s %s, error %v", dbfile, expect, driver, uri, err)
+		}
+	}
+	if _, _, err := ParseDBFile("bla"); err == nil {
+		t.Error("invalid DBFile should fail")
+	}
+}
+
+// TestLoadTemplate
+func TestLoadTemplate(t *testing.T) {
+	args := StatementArgs{Table: "datasets", Columns: []string{"did", "size"}, Where: []string{"did"}}
+	stm, err := LoadTemplate("update", args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := "UPDATE datasets\nSET did = ?, size = ?\nWHERE did = ?"
+	if stm != expect {
+		t.Errorf("wrong statement %q", stm)
+	}
+	stm = Placeholders("postgres", stm)
+	expect = "UPDATE datasets\nSET did = $1, size = $2\nWHERE did = $3"
+	if stm != expect {
+		t.Errorf("wrong postgres statement %q", stm)
+	}
+	stm, _ = LoadTemplate("insert", args)
+	if stm != "INSERT INTO datasets (did, size)\nVALUES (?, ?)" {
+		t.Errorf("wrong insert statement %q", stm)
+	}
+	if Placeholders("postgres", "SELECT '?' FROM t WHERE a = ?") != "SELECT '?' FROM t WHERE a = $1" {
+		t.Error("quoted placeholders should be preserved")
+	}
+	if err := checkIdentifiers("datasets", "did; DROP TABLE"); err == nil {
+		t.Error("invalid identifier should fail")
+	}
+}
+
+// TestMigrations
+func TestMigrations(t *testing.T) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range migrations {
+		if m.Up == "" || m.Down == "" {
+			t.Errorf("migration %s has no up/down statements", m)
+		}
+		if i > 0 && migrations[i-1].Version >= m.Version {
+			t.Errorf("migrations are not ordered %v", migrations)
+		}
+	}
+	set := MigrationSet{Files: fstest.MapFS{
+		"migrations/0001_a.up.sql":       {Data: []byte("CREATE INDEX IF NOT EXISTS")},
+		"migrations/0001_a.mysql.up.sql": {Data: []byte("CREATE TABLE a (INDEX)")},
+		"migrations/0001_a.down.sql":     {Data: []byte("DROP INDEX IF EXISTS")},
+	}}
+	for driver, expect := range map[string]string{"mysql": "CREATE TABLE a (INDEX)", "sqlite3": "CREATE INDEX IF NOT EXISTS"} {
+		migrations, err := set.Load(driver)
+		if err != nil || len(migrations) != 1 || migrations[0].Up != expect || migrations[0].Down != "DROP INDEX IF EXISTS" {
+			t.Errorf("wrong %s migrations %+v, error %v", driver, migrations, err)
+		}
+	}
+	script := "-- comment\nCREATE TABLE a (x VARCHAR(10) DEFAULT ';');\nDROP TABLE b;\n"
+	stms := SplitStatements(script)
+	if len(stms) != 2 || stms[0] != "CREATE TABLE a (x VARCHAR(10) DEFAULT ';')" {
+		t.Errorf("wrong statements %q", stms)
+	}
+}
+
+// helper function to open test sqlite database with datasets table
+func testConnection(t *testing.T) *Connection {
+	c, err := Open("sqlite3://"+filepath.Join(t.TempDir(), "dbs.db"), 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	stm := "CREATE TABLE datasets (did VARCHAR(255) PRIMARY KEY, site VARCHAR(255), size INTEGER)"
+	if _, err := c.DB.Exec(stm); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// TestConnection
+func TestConnection(t *testing.T) {
+	ctx := context.Background()
+	c := testConnection(t)
+	records := []map[string]any{
+		{"did": "/a", "site": "3a", "size": 10},
+		{"did": "/b", "site": "3a", "size": 20},
+		{"did": "/c", "site": "1b", "size": 30},
+	}
+	if err := c.Insert(ctx, "datasets", records); err != nil {
+		t.Fatal(err)
+	}
+	// failed insert rolls back the whole transaction
+	dup := []map[string]any{{"did": "/d", "site": "1b", "size": 40}, {"did": "/a", "site": "1b", "size": 50}}
+	if err := c.Insert(ctx, "datasets", dup); err == nil {
+		t.Error("duplicate insert should fail")
+	}
+	n, err := c.Update(ctx, "datasets", map[string]any{"size": 11}, map[string]any{"did": "/a"})
+	if err != nil || n != 1 {
+		t.Errorf("wrong number of updated rows %d, error %v", n, err)
+	}
+	if _, err := c.Update(ctx, "datasets", map[string]any{"size": 0}, nil); !errors.Is(err, ErrNoConditions) {
+		t.Errorf("update without conditions should fail, error %v", err)
+	}
+	if _, err := c.Delete(ctx, "datasets", map[string]any{}); !errors.Is(err, ErrNoConditions) {
+		t.Errorf("delete without conditions should fail, error %v", err)
+	}
+	if n, err := c.Delete(ctx, "datasets", map[string]any{"site": "1b"}); err != nil || n != 1 {
+		t.Errorf("wrong number of deleted rows %d, error %v", n, err)
+	}
+	args := StatementArgs{Table: "datasets", Columns: []string{"did", "size"}, OrderBy: "did desc"}
+	out, err := c.SelectTable(ctx, args, map[string]any{"site": "3a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 2 || out[0]["did"] != "/b" || out[1]["size"] != int64(11) {
+		t.Errorf("wrong selected records %v", out)
+	}
+	var dids []string
+	err = c.Query(ctx, "SELECT did FROM datasets ORDER BY did", func(rec map[string]any) error {
+		dids = append(dids, rec["did"].(string))
+		return nil
+	})
+	if err != nil || len(dids) != 2 || dids[0] != "/a" {
+		t.Errorf("wrong queried records %v, error %v", dids, err)
+	}
+	stop := errors.New("stop")
+	err = c.Query(ctx, "SELECT did FROM datasets", func(rec map[string]any) error { return stop })
+	if !errors.Is(err, stop) {
+		t.Errorf("query should return callback error, got %v", err)
+	}
+}
+
+// TestMigrate
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	c, err := Open("sqlite3://"+filepath.Join(t.TempDir(), "dbs.db"), 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	db, action := DB, srvConfig.MigrateAction
+	defer func() { DB, srvConfig.MigrateAction = db, action }()
+	DB = c
+	set := MigrationSet{Name: "test", Table: "test_migrations", Files: fstest.MapFS{
+		"migrations/0001_a.up.sql":   {Data: []byte("CREATE TABLE a (x INTEGER)")},
+		"migrations/0001_a.down.sql": {Data: []byte("DROP TABLE a")},
+	}}
+	count := func() int {
+		recs, err := c.Select(ctx, "SELECT name FROM sqlite_master WHERE type='table' AND name IN ('a', 'test_migrations')")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(recs)
+	}
+	// without migrate action database is not modified
+	srvConfig.MigrateAction = ""
+	if err := Migrate(ctx, set); err != nil || count() != 0 {
+		t.Errorf("migrate without action should not modify database, error %v", err)
+	}
+	srvConfig.MigrateAction = "up"
+	if err := Migrate(ctx, set); err != nil || count() != 2 {
+		t.Errorf("migrations are not applied, error %v", err)
+	}
+	srvConfig.MigrateAction = "down"
+	if err := Migrate(ctx, set); err != nil || count() != 1 {
+		t.Errorf("migration is not reverted, error %v", err)
+	}
+}
@@ -0,0 +1,357 @@
+package dbs
+
+// migrations module provides database schema migrations of DBS database
+//
+// Migrations are stored in migrations directory as pair of files
+// <version>_<name>.up.sql and <version>_<name>.down.sql, optionally with
+// dialect specific variants <version>_<name>.<dialect>.up.sql, and applied
+// versions are tracked in schema_migrations table. Other modules own their
+// migration sets tracked in separate tables.
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+)
+
+// MigrationsFS holds migrations shipped with the library
+//
+//go:embed migrations/*.sql
+var MigrationsFS embed.FS
+
+// MigrationsFiles represents file system with migrations, services may
+// replace it with their own file system which contains migrations directory
+var MigrationsFiles fs.FS = MigrationsFS
+
+// MigrationsTable defines name of the table which keeps applied migrations
+var MigrationsTable = "schema_migrations"
+
+// Migration represents single schema migration
+type Migration struct {
+	Version int    // migration version
+	Name    string // migration name
+	Up      string // SQL statements to apply migration
+	Down    string // SQL statements to revert migration
+}
+
+// String provides string representation of migration
+func (m Migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
+
+// MigrationSet represents set of schema migrations owned by a module, e.g.
+// DBS tables or outbox table of pubsub module. Migrations are stored in
+// migrations directory of the file system and applied versions of every
+// set are tracked in its own table.
+type MigrationSet struct {
+	Name  string // name of the set
+	Files fs.FS  // file system with migrations directory
+	Table string // table which keeps applied migrations
+}
+
+// DBSMigrations returns migration set of DBS tables
+func DBSMigrations() MigrationSet {
+	return MigrationSet{Name: "dbs", Files: MigrationsFiles, Table: MigrationsTable}
+}
+
+// Dialect returns SQL dialect of given driver used to select dialect
+// specific migration files
+func Dialect(driver string) string {
+	switch driver {
+	case "sqlite", "sqlite3":
+		return "sqlite"
+	case "postgres", "postgresql", "pgx":
+		return "postgres"
+	}
+	return driver
+}
+
+// LoadMigrations loads DBS migrations ordered by version
+func LoadMigrations() ([]Migration, error) {
+	return DBSMigrations().Load("")
+}
+
+// Load loads migrations of the set ordered by version for given driver.
+// Migration files <version>_<name>.<dialect>.up.sql (and .down.sql) take
+// precedence over generic ones for drivers of that dialect, e.g. mysql.
+func (s MigrationSet) Load(driver string) ([]Migration, error) {
+	files, err := fs.Glob(s.Files, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+	dialect := Dialect(driver)
+	mmap := make(map[int]*Migration)
+	specific := make(map[string]bool) // versions/directions with dialect specific files
+	for _, fname := range files {
+		base := filepath.Base(fname)
+		var direction string
+		if strings.HasSuffix(base, ".up.sql") {
+			direction = "up"
+		} else if strings.HasSuffix(base, ".down.sql") {
+			direction = "down"
+		} else {
+			return nil, fmt.Errorf("invalid migration file %s, expect .up.sql or .down.sql suffix", fname)
+		}
+		base = strings.TrimSuffix(base, "."+direction+".sql")
+		var fdialect string
+		if idx := strings.Index(base, "."); idx != -1 {
+			base, fdialect = base[:idx], base[idx+1:]
+		}
+		arr := strings.SplitN(base, "_", 2)
+		version, err := strconv.Atoi(arr[0])
+		if err != nil || len(arr) != 2 {
+			return nil, fmt.Errorf("invalid migration file %s, expect <version>_<name> name", fname)
+		}
+		if fdialect != "" && fdialect != dialect {
+			continue
+		}
+		key := fmt.Sprintf("%d.%s", version, direction)
+		if fdialect == "" && specific[key] {
+			continue
+		}
+		data, err := fs.ReadFile(s.Files, fname)
+		if err != nil {
+			return nil, err
+		}
+		m, ok := mmap[version]
+		if !ok {
+			m = &Migration{Version: version, Name: arr[1]}
+			mmap[version] = m
+		} else if m.Name != arr[1] {
+			return nil, fmt.Errorf("migration version %d is used by %s and %s", version, m.Name, arr[1])
+		}
+		if fdialect != "" {
+			specific[key] = true
+		}
+		if direction == "up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+	var migrations []Migration
+	for _, m := range mmap {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %s does not have up statements", m)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// SplitStatements splits SQL script into individual statements since not
+// all drivers support execution of multiple statements at once
+func SplitStatements(script string) []string {
+	var out []string
+	var stm strings.Builder
+	var quoted bool
+	for _, line := range strings.Split(script, "\n") {
+		if !quoted && strings.HasPrefix(strings.TrimSpace(line), "--") {
+			continue
+		}
+		for _, r := range line {
+			if r == '\'' {
+				quoted = !quoted
+			}
+			if r == ';' && !quoted {
+				if s := strings.TrimSpace(stm.String()); s != "" {
+					out = append(out, s)
+				}
+				stm.Reset()
+				continue
+			}
+			stm.WriteRune(r)
+		}
+		stm.WriteString("\n")
+	}
+	if s := strings.TrimSpace(stm.String()); s != "" {
+		out = append(out, s)
+	}
+	return out
+}
+
+// helper function to create migrations table
+func (c *Connection) createMigrationsTable(ctx context.Context, table string) error {
+	if err := checkIdentifiers(table); err != nil {
+		return err
+	}
+	stm := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (version INTEGER PRIMARY KEY, name VARCHAR(255), applied_at VARCHAR(64))", table)
+	ctx, cancel := c.context(ctx)
+	defer cancel()
+	_, err := c.DB.ExecContext(ctx, stm)
+	return err
+}
+
+// AppliedMigrations returns versions of applied DBS migrations
+func (c *Connection) AppliedMigrations(ctx context.Context) (map[int]bool, error) {
+	return DBSMigrations().Applied(ctx, c)
+}
+
+// Applied returns versions of applied migrations of the set
+func (s MigrationSet) Applied(ctx context.Context, c *Connection) (map[int]bool, error) {
+	if err := c.createMigrationsTable(ctx, s.Table); err != nil {
+		return nil, fmt.Errorf("unable to create %s table, error %v", s.Table, err)
+	}
+	applied := make(map[int]bool)
+	stm := fmt.Sprintf("SELECT version FROM %s", s.Table)
+	err := c.Query(ctx, stm, func(rec map[string]any) error {
+		version, err := strconv.Atoi(fmt.Sprintf("%v", rec["version"]))
+		if err != nil {
+			return err
+		}
+		applied[version] = true
+		return nil
+	})
+	return applied, err
+}
+
+// helper function to execute migration script and record its version
+func (c *Connection) applyMigration(ctx context.Context, table string, m Migration, up bool) error {
+	script := m.Up
+	if !up {
+		script = m.Down
+	}
+	return c.WithTx(ctx, func(tx *sql.Tx) error {
+		for _, stm := range SplitStatements(script) {
+			if _, err := tx.ExecContext(ctx, stm); err != nil {
+				return fmt.Errorf("migration %s failed, statement '%s', error %v", m, stm, err)
+			}
+		}
+		var stm string
+		var args []any
+		if up {
+			stm = fmt.Sprintf("INSERT INTO %s (version, name, applied_at) VALUES (?, ?, ?)", table)
+			args = []any{m.Version, m.Name, time.Now().UTC().Format(time.RFC3339)}
+		} else {
+			stm = fmt.Sprintf("DELETE FROM %s WHERE version = ?", table)
+			args = []any{m.Version}
+		}
+		_, err := tx.ExecContext(ctx, Placeholders(c.Driver, stm), args...)
+		return err
+	})
+}
+
+// MigrateUp applies all pending DBS migrations and returns list of them. In
+// dry run mode migrations are printed but not applied.
+func (c *Connection) MigrateUp(ctx context.Context, dryRun bool) ([]Migration, error) {
+	return DBSMigrations().Up(ctx, c, dryRun)
+}
+
+// MigrateDown reverts given number of applied DBS migrations starting from
+// the latest one and returns list of them
+func (c *Connection) MigrateDown(ctx context.Context, steps int, dryRun bool) ([]Migration, error) {
+	return DBSMigrations().Down(ctx, c, steps, dryRun)
+}
+
+// Up applies all pending migrations of the set and returns list of them. In
+// dry run mode migrations are printed but not applied.
+func (s MigrationSet) Up(ctx context.Context, c *Connection, dryRun bool) ([]Migration, error) {
+	migrations, err := s.Load(c.Driver)
+	if err != nil {
+		return nil, err
+	}
+	applied, err := s.Applied(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	var out []Migration
+	for _, m := range migrations {
+		if applied[m.Version] {
+			continue
+		}
+		if dryRun {
+			log.Printf("%s migration %s (dry-run)\n%s", s.Name, m, m.Up)
+		} else {
+			log.Printf("apply %s migration %s", s.Name, m)
+			if err := c.applyMigration(ctx, s.Table, m, true); err != nil {
+				return out, err
+			}
+		}
+		out = append(out, m)
+	}
+	return out, nil
+}
+
+// Down reverts given number of applied migrations of the set starting from
+// the latest one and returns list of them
+func (s MigrationSet) Down(ctx context.Context, c *Connection, steps int, dryRun bool) ([]Migration, error) {
+	migrations, err := s.Load(c.Driver)
+	if err != nil {
+		return nil, err
+	}
+	applied, err := s.Applied(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	var out []Migration
+	for i := len(migrations) - 1; i >= 0 && len(out) < steps; i-- {
+		m := migrations[i]
+		if !applied[m.Version] {
+			continue
+		}
+		if m.Down == "" {
+			return out, fmt.Errorf("migration %s does not have down statements", m)
+		}
+		if dryRun {
+			log.Printf("revert %s migration %s (dry-run)\n%s", s.Name, m, m.Down)
+		} else {
+			log.Printf("revert %s migration %s", s.Name, m)
+			if err := c.applyMigration(ctx, s.Table, m, false); err != nil {
+				return out, err
+			}
+		}
+		out = append(out, m)
+	}
+	return out, nil
+}
+
+// Migrate performs migration of global DBS database according to -migrate
+// and -migrate-dry-run flags of config.Init. DBS migrations are followed by
+// migrations of given sets owned by other modules, e.g.
+// pubsub.OutboxMigrations. Migrations are performed only on explicit
+// request: up action applies all pending migrations, down action reverts
+// the latest applied migration of the last set which has one, and without
+// migration action the call does nothing.
+func Migrate(ctx context.Context, sets ...MigrationSet) error {
+	if DB == nil {
+		return errors.New("DBS database is not initialized")
+	}
+	sets = append([]MigrationSet{DBSMigrations()}, sets...)
+	switch srvConfig.MigrateAction {
+	case "":
+		return nil
+	case "up":
+		for _, set := range sets {
+			if _, err := set.Up(ctx, DB, srvConfig.MigrateDryRun); err != nil {
+				return err
+			}
+		}
+	case "down":
+		for i := len(sets) - 1; i >= 0; i-- {
+			out, err := sets[i].Down(ctx, DB, 1, srvConfig.MigrateDryRun)
+			if err != nil {
+				return err
+			}
+			if len(out) > 0 {
+				break
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported migrate action '%s'", srvConfig.MigrateAction)
+	}
+	return nil
+}
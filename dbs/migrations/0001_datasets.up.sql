@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS datasets (
+    dataset_id INTEGER PRIMARY KEY,
+    did VARCHAR(700) NOT NULL UNIQUE,
+    cycle VARCHAR(255),
+    beamline VARCHAR(255),
+    btr VARCHAR(255),
+    sample_name VARCHAR(255),
+    create_by VARCHAR(255),
+    create_at INTEGER,
+    modify_by VARCHAR(255),
+    modify_at INTEGER
+);
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS files (
+    file_id INTEGER PRIMARY KEY,
+    dataset_id INTEGER NOT NULL REFERENCES datasets (dataset_id),
+    name VARCHAR(700) NOT NULL,
+    size INTEGER,
+    checksum VARCHAR(255),
+    is_file_valid INTEGER DEFAULT 1,
+    create_by VARCHAR(255),
+    create_at INTEGER,
+    modify_by VARCHAR(255),
+    modify_at INTEGER
+);
@@ -0,0 +1,4 @@
+DELETE FROM {{.Table}}
+{{- if .Where}}
+WHERE {{range $i, $c := .Where}}{{if $i}} AND {{end}}{{$c}} = ?{{end}}
+{{- end}}
@@ -0,0 +1,2 @@
+INSERT INTO {{.Table}} ({{join .Columns ", "}})
+VALUES ({{placeholders (len .Columns)}})
@@ -0,0 +1,11 @@
+SELECT {{if .Columns}}{{join .Columns ", "}}{{else}}*{{end}}
+FROM {{.Table}}
+{{- if .Where}}
+WHERE {{range $i, $c := .Where}}{{if $i}} AND {{end}}{{$c}} = ?{{end}}
+{{- end}}
+{{- if .OrderBy}}
+ORDER BY {{.OrderBy}}
+{{- end}}
+{{- if gt .Limit 0}}
+LIMIT {{.Limit}} OFFSET {{.Offset}}
+{{- end}}
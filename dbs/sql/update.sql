@@ -0,0 +1,5 @@
+UPDATE {{.Table}}
+SET {{range $i, $c := .Columns}}{{if $i}}, {{end}}{{$c}} = ?{{end}}
+{{- if .Where}}
+WHERE {{range $i, $c := .Where}}{{if $i}} AND {{end}}{{$c}} = ?{{end}}
+{{- end}}
@@ -0,0 +1,91 @@
+package dbs
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// SQLFS holds SQL templates shipped with the library
+//
+//go:embed sql/*.sql
+var SQLFS embed.FS
+
+// Templates represents file system with SQL templates, services may replace
+// it with their own file system (e.g. embed.FS) which contains sql directory
+var Templates fs.FS = SQLFS
+
+// StatementArgs represents arguments of generic SQL templates
+type StatementArgs struct {
+	Table   string   // name of the table
+	Columns []string // list of columns
+	Where   []string // list of columns used in WHERE clause
+	OrderBy string   // order by clause
+	Limit   int      // limit of records
+	Offset  int      // offset of records
+}
+
+// helper function to provide comma separated list of placeholders
+func placeholders(n int) string {
+	var out []string
+	for i := 0; i < n; i++ {
+		out = append(out, "?")
+	}
+	return strings.Join(out, ", ")
+}
+
+// template functions used by SQL templates
+var tmplFuncs = template.FuncMap{
+	"join":         strings.Join,
+	"placeholders": placeholders,
+}
+
+// LoadTemplate loads given SQL template from Templates file system and
+// renders it with provided data
+func LoadTemplate(tmpl string, data any) (string, error) {
+	if !strings.HasSuffix(tmpl, ".sql") {
+		tmpl += ".sql"
+	}
+	fname := filepath.Join("sql", tmpl)
+	content, err := fs.ReadFile(Templates, fname)
+	if err != nil {
+		return "", fmt.Errorf("unable to read SQL template %s, error %v", fname, err)
+	}
+	t, err := template.New(tmpl).Funcs(tmplFuncs).Parse(string(content))
+	if err != nil {
+		return "", fmt.Errorf("unable to parse SQL template %s, error %v", fname, err)
+	}
+	buf := new(bytes.Buffer)
+	if err := t.Execute(buf, data); err != nil {
+		return "", fmt.Errorf("unable to execute SQL template %s, error %v", fname, err)
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// Placeholders converts generic '?' placeholders of SQL statement into
+// driver specific ones, e.g. $1, $2 for PostgreSQL. Placeholders within
+// quoted literals are preserved.
+func Placeholders(driver, stm string) string {
+	if driver != "postgres" && driver != "pgx" {
+		return stm
+	}
+	var out strings.Builder
+	var quoted bool
+	idx := 0
+	for _, r := range stm {
+		if r == '\'' {
+			quoted = !quoted
+		}
+		if r == '?' && !quoted {
+			idx++
+			out.WriteString(fmt.Sprintf("$%d", idx))
+			continue
+		}
+		out.WriteRune(r)
+	}
+	return out.String()
+}
@@ -0,0 +1,393 @@
+package dedup
+
+// dedup module detects duplicate metadata records at ingest. Content
+// fingerprint is computed over configurable record keys, values are
+// normalized before hashing (case, white spaces, number formats and order
+// of lists) so near-duplicate submissions, e.g. of re-run ingestion
+// scripts, have the same fingerprint. Duplicates are either rejected or
+// inserted and flagged for review, flagged duplicates may be merged into
+// original records or dismissed.
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	storage "github.com/CHESSComputing/golib/storage"
+	utils "github.com/CHESSComputing/golib/utils"
+	"github.com/google/uuid"
+)
+
+// defaults of duplicate detection
+var (
+	DefaultCollection     = "duplicates"
+	DefaultIDKey          = "did"
+	DefaultFingerprintKey = "fingerprint"
+	DefaultDuplicateKey   = "duplicate_of"
+	DefaultExcludeKeys    = []string{"_id", "did", "Date", "User"}
+)
+
+// modes of duplicate detection
+const (
+	ModeFlag   = "flag"
+	ModeReject = "reject"
+)
+
+// statuses of detected duplicates
+const (
+	StatusOpen      = "open"
+	StatusMerged    = "merged"
+	StatusDismissed = "dismissed"
+)
+
+// errors of dedup module
+var (
+	ErrDuplicate = errors.New("duplicate record")
+	ErrNotFound  = errors.New("duplicate not found")
+	ErrResolved  = errors.New("duplicate is already resolved")
+)
+
+// Duplicate represents detected duplicate record
+type Duplicate struct {
+	ID          string `json:"id"`
+	Collection  string `json:"collection"`  // collection of records
+	Record      string `json:"record"`      // id of duplicate record
+	Original    string `json:"original"`    // id of original record
+	Fingerprint string `json:"fingerprint"` // common fingerprint of records
+	Status      string `json:"status"`      // open, merged or dismissed
+	ResolvedBy  string `json:"resolved_by,omitempty"`
+	Created     int64  `json:"created"`
+	Updated     int64  `json:"updated"`
+}
+
+// helper function to convert duplicate into storage record
+func (d Duplicate) record() map[string]any {
+	return map[string]any{
+		"_id":         d.ID,
+		"collection":  d.Collection,
+		"record":      d.Record,
+		"original":    d.Original,
+		"fingerprint": d.Fingerprint,
+		"status":      d.Status,
+		"resolved_by": d.ResolvedBy,
+		"created":     d.Created,
+		"updated":     d.Updated,
+	}
+}
+
+// helper function to convert storage record into duplicate
+func duplicateRecord(rec map[string]any) Duplicate {
+	str := func(key string) string {
+		v, _ := rec[key].(string)
+		return v
+	}
+	return Duplicate{
+		ID:          str("_id"),
+		Collection:  str("collection"),
+		Record:      str("record"),
+		Original:    str("original"),
+		Fingerprint: str("fingerprint"),
+		Status:      str("status"),
+		ResolvedBy:  str("resolved_by"),
+		Created:     utils.ToInt64(rec["created"]),
+		Updated:     utils.ToInt64(rec["updated"]),
+	}
+}
+
+// Manager represents duplicate detection of metadata records
+type Manager struct {
+	Store          storage.Store // storage of detected duplicates
+	Collection     string        // collection of detected duplicates
+	Meta           storage.Store // storage of metadata records
+	Keys           []string      // record keys of fingerprint
+	ExcludeKeys    []string      // record keys excluded from fingerprint
+	Mode           string        // flag or reject duplicates
+	IDKey          string        // record key of dataset id
+	FingerprintKey string        // record key of fingerprint
+	DuplicateKey   string        // record key of original record id of flagged duplicates
+}
+
+// Dedup represents global duplicate detection, it should be initialized
+// via Init function
+var Dedup *Manager
+
+// NewManager returns duplicate detection of given configuration
+func NewManager(store, meta storage.Store, cfg srvConfig.Dedup) *Manager {
+	m := &Manager{
+		Store:          store,
+		Collection:     cfg.Collection,
+		Meta:           meta,
+		Keys:           cfg.Keys,
+		ExcludeKeys:    cfg.ExcludeKeys,
+		Mode:           cfg.Mode,
+		IDKey:          cfg.IDKey,
+		FingerprintKey: cfg.FingerprintKey,
+		DuplicateKey:   cfg.DuplicateKey,
+	}
+	if m.Collection == "" {
+		m.Collection = DefaultCollection
+	}
+	if m.ExcludeKeys == nil {
+		m.ExcludeKeys = DefaultExcludeKeys
+	}
+	if m.Mode == "" {
+		m.Mode = ModeFlag
+	}
+	if m.IDKey == "" {
+		m.IDKey = DefaultIDKey
+	}
+	if m.FingerprintKey == "" {
+		m.FingerprintKey = DefaultFingerprintKey
+	}
+	if m.DuplicateKey == "" {
+		m.DuplicateKey = DefaultDuplicateKey
+	}
+	return m
+}
+
+// Init initializes global duplicate detection persisted in MongoDB
+func Init() error {
+	if srvConfig.Config == nil {
+		return errors.New("server configuration is not initialized")
+	}
+	cfg := srvConfig.Config.Dedup
+	meta := srvConfig.Config.CHESSMetaData
+	dbname := cfg.DBName
+	if dbname == "" {
+		dbname = meta.DBName
+	}
+	if dbname == "" {
+		return errors.New("duplicates database is not configured")
+	}
+	Dedup = NewManager(storage.NewStore(dbname), storage.NewStore(meta.DBName), cfg)
+	return nil
+}
+
+// helper function to normalize value of fingerprint, strings are lower
+// cased with collapsed white spaces, numbers have common format and lists
+// are sorted
+func normalizeValue(v any) any {
+	switch val := v.(type) {
+	case nil:
+		return nil
+	case string:
+		return strings.ToLower(strings.Join(strings.Fields(val), " "))
+	case bool:
+		return val
+	case int, int32, int64, float32, float64, json.Number:
+		if f, err := strconv.ParseFloat(fmt.Sprintf("%v", val), 64); err == nil {
+			return strconv.FormatFloat(f, 'g', -1, 64)
+		}
+	case map[string]any:
+		out := make(map[string]any, len(val))
+		for k, item := range val {
+			out[k] = normalizeValue(item)
+		}
+		return out
+	}
+	// MongoDB returns arrays as primitive.A
+	if list, ok := utils.ListValues(v); ok {
+		var items []string
+		for _, item := range list {
+			data, _ := json.Marshal(normalizeValue(item))
+			items = append(items, string(data))
+		}
+		sort.Strings(items)
+		return items
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// Fingerprint returns content fingerprint of the record, i.e. SHA-256 hash
+// of normalized values of Keys or of all keys except ExcludeKeys. It
+// returns empty string if record has none of the keys.
+func (m *Manager) Fingerprint(rec map[string]any) string {
+	keys := m.Keys
+	if len(keys) == 0 {
+		for k := range rec {
+			if !utils.InList(k, m.ExcludeKeys) {
+				keys = append(keys, k)
+			}
+		}
+	}
+	values := make(map[string]any)
+	var found bool
+	for _, k := range keys {
+		if k == m.FingerprintKey || k == m.DuplicateKey {
+			continue
+		}
+		v, ok := rec[k]
+		found = found || ok
+		values[k] = normalizeValue(v)
+	}
+	if !found {
+		return ""
+	}
+	// JSON encoding orders map keys
+	data, err := json.Marshal(values)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// helper function to get id of the record
+func (m *Manager) recordID(rec map[string]any) string {
+	if v, ok := rec[m.IDKey]; ok && v != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return ""
+}
+
+// Original returns id of original record of given fingerprint within
+// collection of metadata records, it returns false if there is no such
+// record
+func (m *Manager) Original(ctx context.Context, store storage.Store, collection, fingerprint string) (string, bool, error) {
+	spec := map[string]any{m.FingerprintKey: fingerprint}
+	records, err := store.Find(ctx, collection, spec, &storage.FindOptions{Limit: 1})
+	if err != nil || len(records) == 0 {
+		return "", false, err
+	}
+	rec := records[0]
+	// flagged duplicates refer to their original record
+	if v, ok := rec[m.DuplicateKey].(string); ok && v != "" {
+		return v, true, nil
+	}
+	return m.recordID(rec), true, nil
+}
+
+// List returns detected duplicates of given status, all duplicates are
+// returned if status is empty
+func (m *Manager) List(ctx context.Context, status string) ([]Duplicate, error) {
+	spec := make(map[string]any)
+	if status != "" {
+		spec["status"] = status
+	}
+	records, err := m.Store.Find(ctx, m.Collection, spec, &storage.FindOptions{Sort: []string{"-created"}})
+	if err != nil {
+		return nil, err
+	}
+	var out []Duplicate
+	for _, rec := range records {
+		out = append(out, duplicateRecord(rec))
+	}
+	return out, nil
+}
+
+// Get returns detected duplicate of given id
+func (m *Manager) Get(ctx context.Context, id string) (Duplicate, error) {
+	rec, err := storage.FindOne(ctx, m.Store, m.Collection, map[string]any{"_id": id})
+	if errors.Is(err, storage.ErrNotFound) {
+		return Duplicate{}, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	if err != nil {
+		return Duplicate{}, err
+	}
+	return duplicateRecord(rec), nil
+}
+
+// helper function to add detected duplicates for review
+func (m *Manager) add(ctx context.Context, dups []Duplicate) error {
+	if len(dups) == 0 {
+		return nil
+	}
+	var records []map[string]any
+	now := time.Now().Unix()
+	for _, d := range dups {
+		d.ID = uuid.NewString()
+		d.Status = StatusOpen
+		d.Created = now
+		d.Updated = now
+		records = append(records, d.record())
+	}
+	return m.Store.Insert(ctx, m.Collection, records...)
+}
+
+// helper function to get open duplicate
+func (m *Manager) open(ctx context.Context, id string) (Duplicate, error) {
+	d, err := m.Get(ctx, id)
+	if err != nil {
+		return d, err
+	}
+	if d.Status != StatusOpen {
+		return d, fmt.Errorf("%w: %s is %s", ErrResolved, id, d.Status)
+	}
+	return d, nil
+}
+
+// helper function to resolve duplicate with given status
+func (m *Manager) resolve(ctx context.Context, d Duplicate, status, user string) (Duplicate, error) {
+	d.Status = status
+	d.ResolvedBy = user
+	d.Updated = time.Now().Unix()
+	fields := map[string]any{"status": d.Status, "resolved_by": d.ResolvedBy, "updated": d.Updated}
+	if _, err := m.Store.Update(ctx, m.Collection, map[string]any{"_id": d.ID}, fields); err != nil {
+		return d, err
+	}
+	return d, nil
+}
+
+// Merge merges duplicate record into its original record, i.e. keys of
+// duplicate record which original record does not have are added to the
+// original record and duplicate record is removed
+func (m *Manager) Merge(ctx context.Context, id, user string) (Duplicate, error) {
+	d, err := m.open(ctx, id)
+	if err != nil {
+		return d, err
+	}
+	dup, err := storage.FindOne(ctx, m.Meta, d.Collection, map[string]any{m.IDKey: d.Record})
+	if errors.Is(err, storage.ErrNotFound) {
+		return d, fmt.Errorf("%w: record %s", ErrNotFound, d.Record)
+	}
+	if err != nil {
+		return d, err
+	}
+	orig, err := storage.FindOne(ctx, m.Meta, d.Collection, map[string]any{m.IDKey: d.Original})
+	if errors.Is(err, storage.ErrNotFound) {
+		return d, fmt.Errorf("%w: original record %s", ErrNotFound, d.Original)
+	}
+	if err != nil {
+		return d, err
+	}
+	fields := make(map[string]any)
+	for k, v := range dup {
+		if k == "_id" || k == m.IDKey || k == m.DuplicateKey {
+			continue
+		}
+		if _, ok := orig[k]; !ok {
+			fields[k] = v
+		}
+	}
+	if len(fields) > 0 {
+		if _, err := m.Meta.Update(ctx, d.Collection, map[string]any{m.IDKey: d.Original}, fields); err != nil {
+			return d, err
+		}
+	}
+	if _, err := m.Meta.Remove(ctx, d.Collection, map[string]any{m.IDKey: d.Record}); err != nil {
+		return d, err
+	}
+	return m.resolve(ctx, d, StatusMerged, user)
+}
+
+// Dismiss marks duplicate as false positive, duplicate record keeps its
+// fingerprint but it no longer refers to original record
+func (m *Manager) Dismiss(ctx context.Context, id, user string) (Duplicate, error) {
+	d, err := m.open(ctx, id)
+	if err != nil {
+		return d, err
+	}
+	spec := map[string]any{m.IDKey: d.Record}
+	if _, err := m.Meta.Update(ctx, d.Collection, spec, map[string]any{m.DuplicateKey: ""}); err != nil {
+		return d, err
+	}
+	return m.resolve(ctx, d, StatusDismissed, user)
+}
@@ -0,0 +1,174 @@
+package dedup
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	srvConfig "github.com/CHESSComputing/golib/config"
+	storage "github.com/CHESSComputing/golib/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// helper function to create duplicate detection of memory stores
+func testManager(cfg srvConfig.Dedup) *Manager {
+	meta := storage.NewMemoryStore()
+	return NewManager(storage.NewMemoryStore(), meta, cfg)
+}
+
+// TestFingerprint
+func TestFingerprint(t *testing.T) {
+	m := testManager(srvConfig.Dedup{})
+	a := map[string]any{"did": "/a", "Date": 1, "sample": "Steel  Bar", "energy": 41, "detectors": []any{"eiger", "pilatus"}}
+	b := map[string]any{"did": "/b", "Date": 2, "sample": "steel bar ", "energy": 41.0, "detectors": []string{"pilatus", "eiger"}}
+	c := map[string]any{"did": "/c", "Date": 3, "sample": "steel bar", "energy": 42, "detectors": []string{"eiger"}}
+	if m.Fingerprint(a) != m.Fingerprint(b) {
+		t.Error("near-duplicate records have different fingerprints")
+	}
+	if m.Fingerprint(a) == m.Fingerprint(c) {
+		t.Error("different records have the same fingerprint")
+	}
+	m.Keys = []string{"sample"}
+	if m.Fingerprint(a) != m.Fingerprint(c) {
+		t.Error("records with the same key fields have different fingerprints")
+	}
+	if fp := m.Fingerprint(map[string]any{"did": "/d"}); fp != "" {
+		t.Errorf("record without key fields has fingerprint %s", fp)
+	}
+}
+
+// TestStore
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	m := testManager(srvConfig.Dedup{Keys: []string{"sample", "scan"}})
+	store := NewStore(m.Meta, m)
+	records := []map[string]any{
+		{"did": "/a", "sample": "steel", "scan": 1},
+		{"did": "/b", "sample": "Steel", "scan": 1, "comment": "re-run"},
+		{"did": "/c", "sample": "steel", "scan": 2},
+	}
+	if err := store.Insert(ctx, "meta", records...); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Insert(ctx, "meta", map[string]any{"did": "/d", "sample": "steel", "scan": 1.0}); err != nil {
+		t.Fatal(err)
+	}
+	dups, err := m.List(ctx, StatusOpen)
+	if err != nil || len(dups) != 2 {
+		t.Fatalf("wrong duplicates %+v, error %v", dups, err)
+	}
+	for _, d := range dups {
+		if d.Original != "/a" || (d.Record != "/b" && d.Record != "/d") {
+			t.Errorf("wrong duplicate %+v", d)
+		}
+	}
+	if rec, _ := storage.FindOne(ctx, m.Meta, "meta", map[string]any{"did": "/b"}); rec["duplicate_of"] != "/a" {
+		t.Errorf("duplicate record is not flagged %v", rec)
+	}
+
+	// rejected duplicates are not inserted
+	m.Mode = ModeReject
+	err = store.Insert(ctx, "meta", map[string]any{"did": "/e", "sample": "other"}, map[string]any{"did": "/f", "sample": "steel", "scan": 2})
+	if !errors.Is(err, ErrDuplicate) {
+		t.Errorf("duplicate record is accepted, error %v", err)
+	}
+	if n, _ := m.Meta.Count(ctx, "meta", map[string]any{}); n != 4 {
+		t.Errorf("expect 4 records after rejected insert, got %d", n)
+	}
+}
+
+// TestMerge
+func TestMerge(t *testing.T) {
+	ctx := context.Background()
+	m := testManager(srvConfig.Dedup{Keys: []string{"sample"}})
+	store := NewStore(m.Meta, m)
+	store.Insert(ctx, "meta", map[string]any{"did": "/a", "sample": "steel", "scan": 1})
+	store.Insert(ctx, "meta", map[string]any{"did": "/b", "sample": "steel", "scan": 2, "comment": "re-run"})
+	store.Insert(ctx, "meta", map[string]any{"did": "/c", "sample": "steel"})
+	dups, _ := m.List(ctx, StatusOpen)
+	if len(dups) != 2 {
+		t.Fatalf("wrong duplicates %+v", dups)
+	}
+	ids := make(map[string]string)
+	for _, d := range dups {
+		ids[d.Record] = d.ID
+	}
+
+	d, err := m.Merge(ctx, ids["/b"], "admin")
+	if err != nil || d.Status != StatusMerged || d.ResolvedBy != "admin" {
+		t.Fatalf("wrong merged duplicate %+v, error %v", d, err)
+	}
+	orig, _ := storage.FindOne(ctx, m.Meta, "meta", map[string]any{"did": "/a"})
+	if orig["comment"] != "re-run" || orig["scan"] != 1 {
+		t.Errorf("wrong merged record %v", orig)
+	}
+	if _, err := storage.FindOne(ctx, m.Meta, "meta", map[string]any{"did": "/b"}); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("merged duplicate is not removed, error %v", err)
+	}
+	if _, err := m.Merge(ctx, ids["/b"], "admin"); !errors.Is(err, ErrResolved) {
+		t.Errorf("resolved duplicate is merged again, error %v", err)
+	}
+
+	if d, err := m.Dismiss(ctx, ids["/c"], "admin"); err != nil || d.Status != StatusDismissed {
+		t.Errorf("wrong dismissed duplicate %+v, error %v", d, err)
+	}
+	if rec, _ := storage.FindOne(ctx, m.Meta, "meta", map[string]any{"did": "/c"}); rec["duplicate_of"] != "" {
+		t.Errorf("dismissed duplicate is still flagged %v", rec)
+	}
+	if _, err := m.Get(ctx, "unknown"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unknown duplicate is found, error %v", err)
+	}
+}
+
+// TestHandlers
+func TestHandlers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	m := testManager(srvConfig.Dedup{Keys: []string{"sample"}})
+	store := NewStore(m.Meta, m)
+	store.Insert(ctx, "meta", map[string]any{"did": "/a", "sample": "steel"}, map[string]any{"did": "/b", "sample": "steel"})
+	dups, _ := m.List(ctx, StatusOpen)
+	if len(dups) != 1 {
+		t.Fatalf("wrong duplicates %+v", dups)
+	}
+	id := dups[0].ID
+
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		claims := &authz.Claims{CustomClaims: authz.CustomClaims{User: c.GetHeader("X-User"), Roles: c.Request.Header.Values("X-Role")}}
+		c.Set("claims", claims)
+	})
+	for _, route := range m.Routes("/duplicates") {
+		r.Handle(route.Method, route.Path, route.Handler)
+	}
+	expect := []struct {
+		method, target, role string
+		code                 int
+		body                 string
+	}{
+		{"GET", "/duplicates", "", http.StatusForbidden, ""},
+		{"GET", "/duplicates", "admin", http.StatusOK, `"original":"/a"`},
+		{"GET", "/duplicates/" + id, "admin", http.StatusOK, `"record":"/b"`},
+		{"GET", "/duplicates/unknown", "admin", http.StatusNotFound, ""},
+		{"POST", "/duplicates/" + id + "/dismiss", "admin", http.StatusOK, `"resolved_by":"root"`},
+		{"POST", "/duplicates/" + id + "/merge", "admin", http.StatusConflict, ""},
+		{"GET", "/duplicates", "admin", http.StatusOK, `[]`},
+		{"GET", "/duplicates?status=all", "admin", http.StatusOK, `"status":"dismissed"`},
+	}
+	for _, e := range expect {
+		req := httptest.NewRequest(e.method, e.target, nil)
+		req.Header.Set("X-User", "root")
+		if e.role != "" {
+			req.Header.Set("X-Role", e.role)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != e.code || !strings.Contains(w.Body.String(), e.body) {
+			t.Errorf("%s %s: expect %d %s, got %d %s", e.method, e.target, e.code, e.body, w.Code, w.Body.String())
+		}
+	}
+}
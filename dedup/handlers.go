@@ -0,0 +1,129 @@
+package dedup
+
+// handlers module provides admin HTTP endpoints to review and merge
+// detected duplicates
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	server "github.com/CHESSComputing/golib/server"
+	services "github.com/CHESSComputing/golib/services"
+	utils "github.com/CHESSComputing/golib/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// AdminRoles defines roles which may review duplicates
+var AdminRoles = []string{"admin"}
+
+// helper function to abort request with service error
+func abort(c *gin.Context, code, srvCode int, err error) {
+	rec := services.Response("dedup", code, srvCode, err)
+	c.AbortWithStatusJSON(code, rec)
+}
+
+// helper function to abort request with error of the manager
+func abortWithError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		abort(c, http.StatusNotFound, services.QueryError, err)
+	case errors.Is(err, ErrResolved):
+		abort(c, http.StatusConflict, services.ConflictError, err)
+	default:
+		log.Printf("ERROR: duplicates request failed, error %v", err)
+		abort(c, http.StatusInternalServerError, services.DatabaseError, err)
+	}
+}
+
+// helper function to wrap handler which requires one of AdminRoles, token
+// claims are provided by token validation middleware
+func admin(handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if val, ok := c.Get("claims"); ok {
+			if claims, ok := val.(*authz.Claims); ok {
+				for _, role := range claims.CustomClaims.Roles {
+					if utils.InList(role, AdminRoles) {
+						handler(c)
+						return
+					}
+				}
+			}
+		}
+		abort(c, http.StatusForbidden, services.PolicyError, errors.New("duplicates review requires administrator role"))
+	}
+}
+
+// helper function to provide user of the request
+func requestUser(c *gin.Context) string {
+	if val, ok := c.Get("claims"); ok {
+		if claims, ok := val.(*authz.Claims); ok {
+			return claims.CustomClaims.User
+		}
+	}
+	return ""
+}
+
+// ListHandler provides detected duplicates of status query parameter,
+// default is open
+func (m *Manager) ListHandler(c *gin.Context) {
+	status := c.DefaultQuery("status", StatusOpen)
+	if status == "all" {
+		status = ""
+	}
+	list, err := m.List(c.Request.Context(), status)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if list == nil {
+		list = []Duplicate{}
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// GetHandler provides detected duplicate
+func (m *Manager) GetHandler(c *gin.Context) {
+	d, err := m.Get(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, d)
+}
+
+// MergeHandler merges duplicate record into its original record
+func (m *Manager) MergeHandler(c *gin.Context) {
+	d, err := m.Merge(c.Request.Context(), c.Param("id"), requestUser(c))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, d)
+}
+
+// DismissHandler marks duplicate as false positive
+func (m *Manager) DismissHandler(c *gin.Context) {
+	d, err := m.Dismiss(c.Request.Context(), c.Param("id"), requestUser(c))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, d)
+}
+
+// Routes returns admin routes of duplicates review under given path, e.g.
+// /duplicates
+func (m *Manager) Routes(path string) []server.Route {
+	return []server.Route{
+		{Method: "GET", Path: path, Authorized: true, Scope: "read", Handler: admin(m.ListHandler),
+			Summary: "list detected duplicates", Response: []Duplicate{}},
+		{Method: "GET", Path: path + "/:id", Authorized: true, Scope: "read", Handler: admin(m.GetHandler),
+			Summary: "get detected duplicate", Response: Duplicate{}},
+		{Method: "POST", Path: path + "/:id/merge", Authorized: true, Scope: "write", Handler: admin(m.MergeHandler),
+			Summary: "merge duplicate into original record", Response: Duplicate{}},
+		{Method: "POST", Path: path + "/:id/dismiss", Authorized: true, Scope: "write", Handler: admin(m.DismissHandler),
+			Summary: "dismiss detected duplicate", Response: Duplicate{}},
+	}
+}
@@ -0,0 +1,86 @@
+package dedup
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	storage "github.com/CHESSComputing/golib/storage"
+)
+
+// Store represents storage which fingerprints inserted records and either
+// rejects duplicate records or flags them for review
+type Store struct {
+	Store   storage.Store
+	Manager *Manager
+}
+
+// NewStore returns duplicate detecting storage
+func NewStore(store storage.Store, m *Manager) *Store {
+	return &Store{Store: store, Manager: m}
+}
+
+// Insert implements storage.Store interface, in reject mode records are
+// not inserted if any of them is duplicate
+func (s *Store) Insert(ctx context.Context, collection string, records ...map[string]any) error {
+	m := s.Manager
+	var dups []Duplicate
+	seen := make(map[string]string)
+	for _, rec := range records {
+		fp := m.Fingerprint(rec)
+		if fp == "" {
+			continue
+		}
+		rec[m.FingerprintKey] = fp
+		original, found := seen[fp]
+		if !found {
+			var err error
+			original, found, err = m.Original(ctx, s.Store, collection, fp)
+			if err != nil {
+				return err
+			}
+		}
+		if !found {
+			seen[fp] = m.recordID(rec)
+			continue
+		}
+		if m.Mode == ModeReject {
+			return fmt.Errorf("%w: record %s duplicates %s", ErrDuplicate, m.recordID(rec), original)
+		}
+		rec[m.DuplicateKey] = original
+		dups = append(dups, Duplicate{
+			Collection:  collection,
+			Record:      m.recordID(rec),
+			Original:    original,
+			Fingerprint: fp,
+		})
+	}
+	if err := s.Store.Insert(ctx, collection, records...); err != nil {
+		return err
+	}
+	if err := m.add(ctx, dups); err != nil {
+		log.Printf("ERROR: unable to add %d duplicates for review, error %v", len(dups), err)
+		return err
+	}
+	return nil
+}
+
+// Find implements storage.Store interface
+func (s *Store) Find(ctx context.Context, collection string, spec map[string]any, opts *storage.FindOptions) ([]map[string]any, error) {
+	return s.Store.Find(ctx, collection, spec, opts)
+}
+
+// Update implements storage.Store interface
+func (s *Store) Update(ctx context.Context, collection string, spec, fields map[string]any) (int64, error) {
+	return s.Store.Update(ctx, collection, spec, fields)
+}
+
+// Count implements storage.Store interface
+func (s *Store) Count(ctx context.Context, collection string, spec map[string]any) (int64, error) {
+	return s.Store.Count(ctx, collection, spec)
+}
+
+// Remove implements storage.Store interface
+func (s *Store) Remove(ctx context.Context, collection string, spec map[string]any) (int64, error) {
+	return s.Store.Remove(ctx, collection, spec)
+}
@@ -0,0 +1,448 @@
+package doi
+
+// doi module mints and updates DOIs of published datasets via DataCite REST
+// API. Dataset records are mapped to DataCite metadata using configurable
+// mapping of DataCite attributes to record keys. In test mode DOIs are
+// minted via DataCite test API with test prefix.
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	utils "github.com/CHESSComputing/golib/utils"
+)
+
+// DataCite API URLs
+const (
+	DataCiteURL     = "https://api.datacite.org"
+	DataCiteTestURL = "https://api.test.datacite.org"
+)
+
+// JSONAPIContentType defines content type of DataCite requests
+const JSONAPIContentType = "application/vnd.api+json"
+
+// DOI events (states) supported by DataCite
+const (
+	EventDraft    = ""         // draft DOI, it can be deleted
+	EventPublish  = "publish"  // findable DOI
+	EventRegister = "register" // registered but not indexed DOI
+	EventHide     = "hide"     // move findable DOI to registered state
+)
+
+// DefaultFields defines default mapping of DataCite attributes to record keys
+var DefaultFields = map[string]string{
+	"title":       "title",
+	"creators":    "authors",
+	"description": "description",
+	"subjects":    "keywords",
+	"year":        "year",
+	"version":     "version",
+}
+
+// DefaultResourceType defines DataCite resourceTypeGeneral of datasets
+var DefaultResourceType = "Dataset"
+
+// ErrMissingField is returned when record lacks mandatory DataCite field
+var ErrMissingField = errors.New("missing mandatory DataCite field")
+
+// Creator represents DataCite creator
+type Creator struct {
+	Name            string           `json:"name"`
+	NameType        string           `json:"nameType,omitempty"`
+	GivenName       string           `json:"givenName,omitempty"`
+	FamilyName      string           `json:"familyName,omitempty"`
+	NameIdentifiers []NameIdentifier `json:"nameIdentifiers,omitempty"`
+	Affiliation     []string         `json:"affiliation,omitempty"`
+}
+
+// NameIdentifier represents DataCite name identifier, e.g. ORCID iD
+type NameIdentifier struct {
+	NameIdentifier       string `json:"nameIdentifier"`
+	NameIdentifierScheme string `json:"nameIdentifierScheme"`
+	SchemeURI            string `json:"schemeUri,omitempty"`
+}
+
+// Title represents DataCite title
+type Title struct {
+	Title string `json:"title"`
+}
+
+// Description represents DataCite description
+type Description struct {
+	Description     string `json:"description"`
+	DescriptionType string `json:"descriptionType"`
+}
+
+// Subject represents DataCite subject
+type Subject struct {
+	Subject string `json:"subject"`
+}
+
+// Types represents DataCite resource types
+type Types struct {
+	ResourceTypeGeneral string `json:"resourceTypeGeneral"`
+	ResourceType        string `json:"resourceType,omitempty"`
+}
+
+// Attributes represents DataCite DOI attributes
+type Attributes struct {
+	DOI             string        `json:"doi,omitempty"`
+	Prefix          string        `json:"prefix,omitempty"`
+	Event           string        `json:"event,omitempty"`
+	State           string        `json:"state,omitempty"`
+	URL             string        `json:"url,omitempty"`
+	Titles          []Title       `json:"titles,omitempty"`
+	Creators        []Creator     `json:"creators,omitempty"`
+	Publisher       string        `json:"publisher,omitempty"`
+	PublicationYear int           `json:"publicationYear,omitempty"`
+	Types           *Types        `json:"types,omitempty"`
+	Descriptions    []Description `json:"descriptions,omitempty"`
+	Subjects        []Subject     `json:"subjects,omitempty"`
+	Version         string        `json:"version,omitempty"`
+}
+
+// helper types of DataCite JSON:API payload
+type payloadData struct {
+	ID         string     `json:"id,omitempty"`
+	Type       string     `json:"type"`
+	Attributes Attributes `json:"attributes"`
+}
+type payload struct {
+	Data payloadData `json:"data"`
+}
+
+// Client represents DataCite client
+type Client struct {
+	URL        string            // DataCite API URL
+	Prefix     string            // DOI prefix of the repository
+	Username   string            // repository ID
+	Password   string            // repository password
+	Publisher  string            // publisher of datasets
+	LandingURL string            // landing page URL, {id} is replaced by dataset id
+	IDKey      string            // record key of dataset id
+	Fields     map[string]string // mapping of DataCite attributes to record keys
+	HttpClient *http.Client
+	Verbose    int
+}
+
+// DataCiteClient represents global DataCite client, it should be
+// initialized via Init function
+var DataCiteClient *Client
+
+// NewClient returns DataCite client for given configuration, test API URL
+// and test prefix are used in test mode
+func NewClient(cfg srvConfig.DataCite) *Client {
+	c := &Client{
+		URL:        cfg.URL,
+		Prefix:     cfg.Prefix,
+		Username:   cfg.Username,
+		Password:   cfg.Password,
+		Publisher:  cfg.Publisher,
+		LandingURL: cfg.LandingURL,
+		IDKey:      cfg.IDKey,
+		Fields:     make(map[string]string),
+		HttpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+	if cfg.TestMode {
+		c.URL = cfg.TestURL
+		if c.URL == "" {
+			c.URL = DataCiteTestURL
+		}
+		c.Prefix = cfg.TestPrefix
+	}
+	if c.URL == "" {
+		c.URL = DataCiteURL
+	}
+	if c.IDKey == "" {
+		c.IDKey = "did"
+	}
+	for k, v := range DefaultFields {
+		c.Fields[k] = v
+	}
+	for k, v := range cfg.Fields {
+		c.Fields[k] = v
+	}
+	if cfg.Timeout > 0 {
+		c.HttpClient.Timeout = cfg.Timeout
+	}
+	return c
+}
+
+// Init initializes global DataCite client from configuration
+func Init() error {
+	if srvConfig.Config == nil {
+		return errors.New("server configuration is not initialized")
+	}
+	cfg := srvConfig.Config.DataCite
+	if cfg.Username == "" || cfg.Password == "" {
+		return errors.New("DataCite credentials are not configured")
+	}
+	DataCiteClient = NewClient(cfg)
+	if cfg.TestMode {
+		log.Printf("INFO: DataCite test mode, DOIs are minted via %s with prefix %s", DataCiteClient.URL, DataCiteClient.Prefix)
+	}
+	return nil
+}
+
+// helper function to get record value of DataCite attribute
+func (c *Client) value(rec map[string]any, attr string) any {
+	key, ok := c.Fields[attr]
+	if !ok || key == "" {
+		return nil
+	}
+	var val any = rec
+	for _, k := range strings.Split(key, ".") {
+		m, ok := val.(map[string]any)
+		if !ok {
+			return nil
+		}
+		val = m[k]
+	}
+	return val
+}
+
+// helper function to convert value into list of strings
+func stringList(val any) []string {
+	switch v := val.(type) {
+	case string:
+		if v == "" {
+			return nil
+		}
+		return []string{v}
+	case []string:
+		return v
+	}
+	// MongoDB returns arrays as primitive.A
+	list, _ := utils.ListValues(val)
+	var out []string
+	for _, e := range list {
+		if s, ok := e.(string); ok && s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// helper function to convert author value into DataCite creator, authors
+// are either names or maps with name, given_name, family_name, orcid and
+// affiliations keys (see enrich.Author)
+func creator(val any) (Creator, bool) {
+	switch v := val.(type) {
+	case string:
+		if v == "" {
+			return Creator{}, false
+		}
+		return Creator{Name: v, NameType: "Personal"}, true
+	case map[string]any:
+		c := Creator{NameType: "Personal"}
+		c.Name, _ = v["name"].(string)
+		c.GivenName, _ = v["given_name"].(string)
+		c.FamilyName, _ = v["family_name"].(string)
+		if c.Name == "" {
+			c.Name = strings.TrimSpace(c.FamilyName + ", " + c.GivenName)
+			c.Name = strings.Trim(c.Name, ", ")
+		}
+		if orcid, ok := v["orcid"].(string); ok && orcid != "" {
+			c.NameIdentifiers = append(c.NameIdentifiers, NameIdentifier{
+				NameIdentifier:       "https://orcid.org/" + orcid,
+				NameIdentifierScheme: "ORCID",
+				SchemeURI:            "https://orcid.org",
+			})
+		}
+		c.Affiliation = stringList(v["affiliations"])
+		return c, c.Name != ""
+	}
+	return Creator{}, false
+}
+
+// helper function to convert value into integer
+func intValue(val any) int {
+	switch v := val.(type) {
+	case int:
+		return v
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case float64:
+		return int(v)
+	case string:
+		var n int
+		fmt.Sscanf(v, "%d", &n)
+		return n
+	}
+	return 0
+}
+
+// Metadata maps dataset record into DataCite attributes. Title, creators,
+// publisher and publication year are mandatory DataCite fields, the
+// publication year defaults to the current year.
+func (c *Client) Metadata(rec map[string]any) (Attributes, error) {
+	attrs := Attributes{
+		Publisher:       c.Publisher,
+		PublicationYear: intValue(c.value(rec, "year")),
+		Types:           &Types{ResourceTypeGeneral: DefaultResourceType},
+	}
+	for _, title := range stringList(c.value(rec, "title")) {
+		attrs.Titles = append(attrs.Titles, Title{Title: title})
+	}
+	authors := c.value(rec, "creators")
+	if list, ok := utils.ListValues(authors); ok {
+		for _, a := range list {
+			if cr, ok := creator(a); ok {
+				attrs.Creators = append(attrs.Creators, cr)
+			}
+		}
+	} else if cr, ok := creator(authors); ok {
+		attrs.Creators = append(attrs.Creators, cr)
+	}
+	if desc, ok := c.value(rec, "description").(string); ok && desc != "" {
+		attrs.Descriptions = []Description{{Description: desc, DescriptionType: "Abstract"}}
+	}
+	for _, s := range stringList(c.value(rec, "subjects")) {
+		attrs.Subjects = append(attrs.Subjects, Subject{Subject: s})
+	}
+	if v := c.value(rec, "version"); v != nil {
+		attrs.Version = fmt.Sprintf("%v", v)
+	}
+	if attrs.PublicationYear == 0 {
+		attrs.PublicationYear = time.Now().Year()
+	}
+	if c.LandingURL != "" && rec[c.IDKey] != nil {
+		id := url.PathEscape(fmt.Sprintf("%v", rec[c.IDKey]))
+		attrs.URL = strings.ReplaceAll(c.LandingURL, "{id}", id)
+	}
+	var missing []string
+	if len(attrs.Titles) == 0 {
+		missing = append(missing, "titles")
+	}
+	if len(attrs.Creators) == 0 {
+		missing = append(missing, "creators")
+	}
+	if attrs.Publisher == "" {
+		missing = append(missing, "publisher")
+	}
+	if len(missing) > 0 {
+		return attrs, fmt.Errorf("%w: %s", ErrMissingField, strings.Join(missing, ", "))
+	}
+	return attrs, nil
+}
+
+// helper function to send request to DataCite API
+func (c *Client) request(method, path string, body any) (Attributes, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return Attributes{}, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	rurl := strings.TrimSuffix(c.URL, "/") + path
+	req, err := http.NewRequest(method, rurl, reader)
+	if err != nil {
+		return Attributes{}, err
+	}
+	req.SetBasicAuth(c.Username, c.Password)
+	req.Header.Set("Accept", JSONAPIContentType)
+	if body != nil {
+		req.Header.Set("Content-Type", JSONAPIContentType)
+	}
+	client := c.HttpClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if c.Verbose > 0 {
+		log.Println("DataCite:", method, rurl)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Printf("ERROR: DataCite request %s %s failed, error %v", method, rurl, err)
+		return Attributes{}, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Attributes{}, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg := fmt.Sprintf("DataCite request %s %s failed, status %s", method, rurl, resp.Status)
+		var rec struct {
+			Errors []struct {
+				Source string `json:"source"`
+				Title  string `json:"title"`
+			} `json:"errors"`
+		}
+		if json.Unmarshal(data, &rec) == nil {
+			for _, e := range rec.Errors {
+				msg += fmt.Sprintf("; %s: %s", e.Source, e.Title)
+			}
+		}
+		log.Println("ERROR:", msg)
+		return Attributes{}, errors.New(msg)
+	}
+	if len(data) == 0 {
+		return Attributes{}, nil
+	}
+	var rec payload
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return Attributes{}, err
+	}
+	if rec.Data.Attributes.DOI == "" {
+		rec.Data.Attributes.DOI = rec.Data.ID
+	}
+	return rec.Data.Attributes, nil
+}
+
+// Mint mints new DOI of the dataset record with given event, e.g.
+// EventPublish, and returns registered DOI. DOI suffix is generated by
+// DataCite.
+func (c *Client) Mint(rec map[string]any, event string) (string, error) {
+	attrs, err := c.Metadata(rec)
+	if err != nil {
+		return "", err
+	}
+	if c.Prefix == "" {
+		return "", errors.New("DataCite DOI prefix is not configured")
+	}
+	attrs.Prefix = c.Prefix
+	attrs.Event = event
+	out, err := c.request("POST", "/dois", payload{Data: payloadData{Type: "dois", Attributes: attrs}})
+	if err != nil {
+		return "", err
+	}
+	return out.DOI, nil
+}
+
+// Update updates metadata of existing DOI from the dataset record, event
+// may be used to change DOI state, e.g. publish draft DOI
+func (c *Client) Update(doi string, rec map[string]any, event string) error {
+	attrs, err := c.Metadata(rec)
+	if err != nil {
+		return err
+	}
+	attrs.Event = event
+	data := payload{Data: payloadData{ID: doi, Type: "dois", Attributes: attrs}}
+	_, err = c.request("PUT", "/dois/"+doi, data)
+	return err
+}
+
+// Get returns DataCite attributes of given DOI
+func (c *Client) Get(doi string) (Attributes, error) {
+	return c.request("GET", "/dois/"+doi, nil)
+}
+
+// Delete deletes draft DOI, registered and findable DOIs can not be deleted
+func (c *Client) Delete(doi string) error {
+	_, err := c.request("DELETE", "/dois/"+doi, nil)
+	return err
+}
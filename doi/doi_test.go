@@ -0,0 +1,147 @@
+package doi
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	bson "go.mongodb.org/mongo-driver/bson"
+)
+
+// helper function to provide test record
+func testRecord() map[string]any {
+	return map[string]any{
+		"did":         "/beamline=3a/btr=test-123/cycle=2024-1",
+		"description": "Powder diffraction of Ni",
+		"authors": []any{
+			map[string]any{"given_name": "John", "family_name": "Doe", "orcid": "0000-0002-1825-0097", "affiliations": []any{"Cornell"}},
+			"Jane Roe",
+		},
+		"keywords": []any{"diffraction", "nickel"},
+		"year":     2024,
+	}
+}
+
+// TestMetadata
+func TestMetadata(t *testing.T) {
+	cfg := srvConfig.DataCite{
+		Publisher:  "CHESS",
+		LandingURL: "https://foxden/dataset/{id}",
+		Fields:     map[string]string{"title": "description"},
+	}
+	c := NewClient(cfg)
+	attrs, err := c.Metadata(testRecord())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attrs.Titles) != 1 || attrs.Titles[0].Title != "Powder diffraction of Ni" {
+		t.Errorf("invalid titles %+v", attrs.Titles)
+	}
+	if len(attrs.Creators) != 2 || attrs.Creators[0].Name != "Doe, John" || attrs.Creators[1].Name != "Jane Roe" {
+		t.Fatalf("invalid creators %+v", attrs.Creators)
+	}
+	if ids := attrs.Creators[0].NameIdentifiers; len(ids) != 1 || ids[0].NameIdentifierScheme != "ORCID" {
+		t.Errorf("invalid name identifiers %+v", ids)
+	}
+	if attrs.PublicationYear != 2024 || len(attrs.Subjects) != 2 || attrs.Types.ResourceTypeGeneral != "Dataset" {
+		t.Errorf("invalid attributes %+v", attrs)
+	}
+	if attrs.URL != "https://foxden/dataset/%2Fbeamline=3a%2Fbtr=test-123%2Fcycle=2024-1" {
+		t.Errorf("invalid landing URL %s", attrs.URL)
+	}
+	_, err = c.Metadata(map[string]any{"description": "no authors"})
+	if !errors.Is(err, ErrMissingField) {
+		t.Error("expect missing field error, got", err)
+	}
+}
+
+// TestMetadataBSON
+func TestMetadataBSON(t *testing.T) {
+	c := NewClient(srvConfig.DataCite{Publisher: "CHESS", Fields: map[string]string{"title": "description"}})
+	// MongoDB decodes arrays of records as primitive.A
+	data, err := bson.Marshal(testRecord())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rec map[string]any
+	if err := bson.Unmarshal(data, &rec); err != nil {
+		t.Fatal(err)
+	}
+	attrs, err := c.Metadata(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attrs.Creators) != 2 || len(attrs.Creators[0].Affiliation) != 1 || attrs.Creators[1].Name != "Jane Roe" {
+		t.Errorf("invalid creators %+v", attrs.Creators)
+	}
+	if len(attrs.Subjects) != 2 || attrs.PublicationYear != 2024 {
+		t.Errorf("invalid attributes %+v", attrs)
+	}
+}
+
+// TestMint
+func TestMint(t *testing.T) {
+	var received payload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "CHESS.TEST" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get("Content-Type") != JSONAPIContentType {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		json.Unmarshal(data, &received)
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/dois":
+			received.Data.ID = received.Data.Attributes.Prefix + "/abcd-1234"
+			received.Data.Attributes.DOI = ""
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(received)
+		case r.Method == "PUT" && r.URL.Path == "/dois/10.80000/abcd-1234":
+			json.NewEncoder(w).Encode(received)
+		default:
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			w.Write([]byte(`{"errors":[{"source":"url","title":"can't be blank"}]}`))
+		}
+	}))
+	defer server.Close()
+
+	cfg := srvConfig.DataCite{
+		Prefix:     "10.12345",
+		TestPrefix: "10.80000",
+		TestURL:    server.URL,
+		TestMode:   true,
+		Username:   "CHESS.TEST",
+		Password:   "secret",
+		Publisher:  "CHESS",
+		Fields:     map[string]string{"title": "description"},
+	}
+	c := NewClient(cfg)
+	doi, err := c.Mint(testRecord(), EventPublish)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doi != "10.80000/abcd-1234" {
+		t.Errorf("invalid DOI %s", doi)
+	}
+	if received.Data.Attributes.Event != EventPublish || received.Data.Attributes.Publisher != "CHESS" {
+		t.Errorf("invalid request %+v", received.Data.Attributes)
+	}
+	if err := c.Update(doi, testRecord(), ""); err != nil {
+		t.Fatal(err)
+	}
+	if received.Data.ID != doi {
+		t.Errorf("invalid update request %+v", received.Data)
+	}
+	err = c.Update("10.80000/unknown", testRecord(), "")
+	if err == nil || !strings.Contains(err.Error(), "url: can't be blank") {
+		t.Error("expect DataCite error, got", err)
+	}
+}
@@ -0,0 +1,359 @@
+package download
+
+// download module provides access-controlled download proxy of data files.
+// Files are streamed from local disk, S3 compatible storage or HTTPS
+// endpoints (e.g. Globus HTTPS) with HTTP Range support. Every download is
+// authorized by policy engine, optionally throttled and recorded in audit
+// log.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	audit "github.com/CHESSComputing/golib/audit"
+	authz "github.com/CHESSComputing/golib/authz"
+	policy "github.com/CHESSComputing/golib/authz/policy"
+	services "github.com/CHESSComputing/golib/services"
+	"github.com/gin-gonic/gin"
+)
+
+// Action defines policy and audit action of file downloads
+var Action = "file.download"
+
+// ErrNotFound is returned by sources when file does not exist
+var ErrNotFound = errors.New("file not found")
+
+// ErrDenied is returned when download policy does not allow the download
+var ErrDenied = errors.New("download is not allowed")
+
+// errLoad wraps errors of record loader
+var errLoad = errors.New("unable to load record")
+
+// FileInfo represents information about downloaded file
+type FileInfo struct {
+	Size    int64
+	ModTime time.Time
+	ETag    string
+}
+
+// Source defines interface of file sources
+type Source interface {
+	// Stat returns information about the file
+	Stat(ctx context.Context, fname string) (FileInfo, error)
+	// Open returns reader of the file starting at offset, negative length
+	// means till the end of the file
+	Open(ctx context.Context, fname string, offset, length int64) (io.ReadCloser, error)
+}
+
+// RecordLoader loads record guarded by download policy for given source
+// and file, e.g. metadata record of dataset which owns the file
+type RecordLoader func(ctx context.Context, source, fname string) (map[string]any, error)
+
+// Downloader represents download proxy
+type Downloader struct {
+	Sources   map[string]Source // file sources keyed by name
+	Evaluator policy.Evaluator  // policy evaluator, downloads are denied if it is not set
+	Loader    RecordLoader      // optional loader of guarded records
+	Audit     *audit.Logger     // optional audit logger
+	Bandwidth int64             // bandwidth limit of single download in bytes per second, 0 means no limit
+	ClientID  string            // client id used to validate request tokens
+	Signer    *authz.URLSigner  // optional signer of download links, signed URLs are accepted instead of tokens
+	Verbose   int
+}
+
+// NewDownloader returns download proxy of given sources
+func NewDownloader(sources map[string]Source, evaluator policy.Evaluator) *Downloader {
+	return &Downloader{Sources: sources, Evaluator: evaluator, Audit: audit.AuditLogger}
+}
+
+// helper function to get request claims either from gin context set by
+// authz middleware, from signed URL or from request token
+func (d *Downloader) claims(c *gin.Context) (*authz.Claims, error) {
+	if val, ok := c.Get("claims"); ok {
+		if claims, ok := val.(*authz.Claims); ok {
+			return claims, nil
+		}
+	}
+	if d.Signer != nil && authz.IsSigned(c.Request.URL) {
+		signed, err := d.Signer.Verify(c.Request.URL)
+		if err != nil {
+			return nil, err
+		}
+		return signed.Claims(), nil
+	}
+	tokenStr := authz.RequestToken(c.Request)
+	token := &authz.Token{AccessToken: tokenStr}
+	if err := token.Validate(d.ClientID); err != nil {
+		return nil, err
+	}
+	return authz.TokenClaims(tokenStr, d.ClientID)
+}
+
+// helper function to provide guarded record of the file, the record
+// contains source and path of the file along with loaded record keys
+func (d *Downloader) record(ctx context.Context, source, fname string) (map[string]any, error) {
+	rec := make(map[string]any)
+	if d.Loader != nil {
+		loaded, err := d.Loader(ctx, source, fname)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range loaded {
+			rec[k] = v
+		}
+	}
+	rec["source"] = source
+	rec["path"] = fname
+	return rec, nil
+}
+
+// Authorize evaluates download policy of the file of given source for given
+// claims and returns policy input of allowed download. The path should be
+// normalized via CleanPath. ErrDenied is returned if download is not
+// allowed.
+func (d *Downloader) Authorize(ctx context.Context, claims *authz.Claims, source, fname string) (policy.Input, error) {
+	rec, err := d.record(ctx, source, fname)
+	if err != nil {
+		if errors.Is(err, policy.ErrRecordNotFound) {
+			return policy.Input{}, err
+		}
+		return policy.Input{}, fmt.Errorf("%w of %s:%s, error %v", errLoad, source, fname, err)
+	}
+	input := policy.NewInput(Action, claims, rec)
+	if d.Evaluator == nil {
+		return input, fmt.Errorf("%w, download policy is not configured", ErrDenied)
+	}
+	allowed, err := d.Evaluator.Allowed(ctx, input)
+	if err != nil {
+		return input, err
+	}
+	if !allowed {
+		return input, fmt.Errorf("%w, user %s is not allowed to download %s:%s", ErrDenied, input.Subject, source, fname)
+	}
+	return input, nil
+}
+
+// helper function to abort request with service error
+func abort(c *gin.Context, code, srvCode int, err error) {
+	rec := services.Response("download", code, srvCode, err)
+	c.AbortWithStatusJSON(code, rec)
+}
+
+// CleanPath normalizes file path relative to source root, paths which
+// escape the root, e.g. 3a/../../etc/passwd, are rejected
+func CleanPath(fname string) (string, error) {
+	fname = strings.TrimPrefix(fname, "/")
+	if rel := path.Clean(fname); rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fmt.Errorf("path '%s' is outside of source root", fname)
+	}
+	clean := strings.TrimPrefix(path.Clean("/"+fname), "/")
+	if clean == "" {
+		return "", fmt.Errorf("invalid path '%s'", fname)
+	}
+	return clean, nil
+}
+
+// Handler provides gin handler of file downloads, source name and file
+// path are passed via source and path parameters, e.g.
+// /download/:source/*path. The path is normalized before authorization,
+// therefore policies and sources get the same path.
+func (d *Downloader) Handler(c *gin.Context) {
+	ctx := c.Request.Context()
+	name := c.Param("source")
+	src, ok := d.Sources[name]
+	if !ok {
+		abort(c, http.StatusNotFound, services.ParametersError, fmt.Errorf("unknown source '%s'", name))
+		return
+	}
+	fname, err := CleanPath(c.Param("path"))
+	if err != nil {
+		abort(c, http.StatusBadRequest, services.ParametersError, err)
+		return
+	}
+	claims, err := d.claims(c)
+	if err != nil {
+		abort(c, http.StatusUnauthorized, services.TokenError, err)
+		return
+	}
+	input, err := d.Authorize(ctx, claims, name, fname)
+	if err != nil {
+		switch {
+		case errors.Is(err, policy.ErrRecordNotFound):
+			abort(c, http.StatusNotFound, services.QueryError, err)
+		case errors.Is(err, ErrDenied):
+			abort(c, http.StatusForbidden, services.PolicyError, err)
+		case errors.Is(err, errLoad):
+			abort(c, http.StatusInternalServerError, services.LoadError, err)
+		default:
+			log.Printf("ERROR: unable to evaluate download policy of %s:%s, error %v", name, fname, err)
+			abort(c, http.StatusInternalServerError, services.PolicyError, err)
+		}
+		return
+	}
+	info, err := src.Stat(ctx, fname)
+	if errors.Is(err, ErrNotFound) {
+		abort(c, http.StatusNotFound, services.QueryError, err)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: unable to stat %s:%s, error %v", name, fname, err)
+		abort(c, http.StatusBadGateway, services.ReaderError, err)
+		return
+	}
+
+	rid := audit.RequestID(c)
+	writer := &countingWriter{ResponseWriter: c.Writer, limit: d.Bandwidth}
+	reader := &rangeReader{ctx: ctx, src: src, fname: fname, size: info.Size}
+	defer reader.Close()
+	base := path.Base(fname)
+	if ctype := mime.TypeByExtension(filepath.Ext(base)); ctype == "" {
+		writer.Header().Set("Content-Type", "application/octet-stream")
+	}
+	if info.ETag != "" {
+		writer.Header().Set("ETag", info.ETag)
+	}
+	writer.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": base}))
+	start := time.Now()
+	http.ServeContent(writer, c.Request, base, info.ModTime, reader)
+	if reader.err != nil {
+		log.Printf("ERROR: download of %s:%s failed, error %v", name, fname, reader.err)
+	}
+	if d.Verbose > 0 {
+		log.Printf("INFO: %s downloaded %d bytes of %s:%s in %v", input.Subject, writer.bytes, name, fname, time.Since(start))
+	}
+	if d.Audit != nil {
+		arec := audit.Record{
+			Subject:   input.Subject,
+			Kind:      input.Kind,
+			Action:    Action,
+			Resource:  name + ":" + fname,
+			Status:    writer.Status(),
+			IP:        c.ClientIP(),
+			RequestID: rid,
+			Details: map[string]any{
+				"bytes":    writer.bytes,
+				"size":     info.Size,
+				"range":    c.GetHeader("Range"),
+				"duration": time.Since(start).Seconds(),
+			},
+		}
+		if err := d.Audit.Record(context.Background(), arec); err != nil {
+			log.Printf("ERROR: unable to audit download %s, error %v", rid, err)
+		}
+	}
+}
+
+// rangeReader implements io.ReadSeeker on top of file source, the file is
+// (re-)opened at current offset on first read after seek. It allows to
+// serve HTTP Range requests via http.ServeContent.
+type rangeReader struct {
+	ctx    context.Context
+	src    Source
+	fname  string
+	size   int64
+	offset int64
+	rc     io.ReadCloser
+	err    error
+}
+
+// Read implements io.Reader interface
+func (r *rangeReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.rc == nil {
+		rc, err := r.src.Open(r.ctx, r.fname, r.offset, -1)
+		if err != nil {
+			r.err = err
+			return 0, err
+		}
+		r.rc = rc
+	}
+	n, err := r.rc.Read(p)
+	r.offset += int64(n)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
+
+// Seek implements io.Seeker interface
+func (r *rangeReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	}
+	if offset < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if offset != r.offset {
+		r.Close()
+		r.offset = offset
+	}
+	return offset, nil
+}
+
+// Close closes underlying reader
+func (r *rangeReader) Close() error {
+	if r.rc == nil {
+		return nil
+	}
+	err := r.rc.Close()
+	r.rc = nil
+	return err
+}
+
+// countingWriter counts written bytes and throttles response bandwidth
+type countingWriter struct {
+	gin.ResponseWriter
+	limit int64 // bytes per second, 0 means no limit
+	bytes int64
+	start time.Time
+}
+
+// Write implements io.Writer interface
+func (w *countingWriter) Write(data []byte) (int, error) {
+	if w.limit <= 0 {
+		n, err := w.ResponseWriter.Write(data)
+		w.bytes += int64(n)
+		return n, err
+	}
+	if w.start.IsZero() {
+		w.start = time.Now()
+	}
+	var written int
+	for len(data) > 0 {
+		// write chunks of at most 1/10 of the limit and sleep until
+		// average rate drops below the limit
+		chunk := int(w.limit / 10)
+		if chunk < 1 {
+			chunk = 1
+		}
+		if chunk > len(data) {
+			chunk = len(data)
+		}
+		n, err := w.ResponseWriter.Write(data[:chunk])
+		written += n
+		w.bytes += int64(n)
+		if err != nil {
+			return written, err
+		}
+		data = data[chunk:]
+		expect := time.Duration(float64(w.bytes) / float64(w.limit) * float64(time.Second))
+		if wait := expect - time.Since(w.start); wait > 0 {
+			time.Sleep(wait)
+		}
+	}
+	return written, nil
+}
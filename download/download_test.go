@@ -0,0 +1,194 @@
+package download
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	audit "github.com/CHESSComputing/golib/audit"
+	authz "github.com/CHESSComputing/golib/authz"
+	policy "github.com/CHESSComputing/golib/authz/policy"
+	srvConfig "github.com/CHESSComputing/golib/config"
+	storage "github.com/CHESSComputing/golib/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// helper function to setup test router with local and HTTPS sources
+func testRouter(t *testing.T, user string) (*gin.Engine, *Downloader) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "3a"), 0755)
+	os.WriteFile(filepath.Join(dir, "3a", "scan.dat"), []byte("0123456789"), 0644)
+	os.WriteFile(filepath.Join(dir, "3a", "secret.dat"), []byte("secret"), 0644)
+	remote := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	t.Cleanup(remote.Close)
+
+	engine, err := policy.NewEngine(map[string][]string{
+		Action: {"record.beamline == \"3a\" AND record.path != \"3a/secret.dat\""},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sources := map[string]Source{
+		"local":  LocalSource{Root: dir},
+		"globus": HTTPSource{BaseURL: remote.URL},
+	}
+	d := NewDownloader(sources, engine)
+	d.Audit = &audit.Logger{Store: audit.NewDocumentStore(storage.NewMemoryStore())}
+	d.Loader = func(ctx context.Context, source, fname string) (map[string]any, error) {
+		return map[string]any{"beamline": strings.Split(fname, "/")[0]}, nil
+	}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/download/:source/*path", func(c *gin.Context) {
+		if user != "" {
+			c.Set("claims", &authz.Claims{CustomClaims: authz.CustomClaims{User: user}})
+		}
+	}, d.Handler)
+	return r, d
+}
+
+// helper function to perform download request
+func get(r *gin.Engine, path, rng string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", path, nil)
+	if rng != "" {
+		req.Header.Set("Range", rng)
+	}
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// TestDownload
+func TestDownload(t *testing.T) {
+	r, d := testRouter(t, "alice")
+	for _, src := range []string{"local", "globus"} {
+		w := get(r, "/download/"+src+"/3a/scan.dat", "")
+		if w.Code != http.StatusOK || w.Body.String() != "0123456789" {
+			t.Errorf("%s: invalid response %d %s", src, w.Code, w.Body.String())
+		}
+		w = get(r, "/download/"+src+"/3a/scan.dat", "bytes=2-4")
+		if w.Code != http.StatusPartialContent || w.Body.String() != "234" {
+			t.Errorf("%s: invalid range response %d %s", src, w.Code, w.Body.String())
+		}
+		if cr := w.Header().Get("Content-Range"); cr != "bytes 2-4/10" {
+			t.Errorf("%s: invalid content range %s", src, cr)
+		}
+		w = get(r, "/download/"+src+"/3a/scan.dat", "bytes=-3")
+		if w.Body.String() != "789" {
+			t.Errorf("%s: invalid suffix range %s", src, w.Body.String())
+		}
+		w = get(r, "/download/"+src+"/3a/missing.dat", "")
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%s: invalid status of missing file %d", src, w.Code)
+		}
+	}
+	records, err := d.Audit.Query(context.Background(), audit.Query{Subject: "alice", Action: Action})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 6 {
+		t.Fatalf("invalid number of audit records %d", len(records))
+	}
+	var bytes float64
+	for _, rec := range records {
+		if n, ok := rec.Details["bytes"].(float64); ok {
+			bytes += n
+		}
+	}
+	if bytes != 2*16 {
+		t.Errorf("invalid number of audited bytes %v", bytes)
+	}
+}
+
+// TestCleanPath
+func TestCleanPath(t *testing.T) {
+	tests := map[string]string{
+		"/3a/scan.dat":      "3a/scan.dat",
+		"3a//x/../scan.dat": "3a/scan.dat",
+		"./3a/scan.dat":     "3a/scan.dat",
+	}
+	for fname, expect := range tests {
+		if out, err := CleanPath(fname); err != nil || out != expect {
+			t.Errorf("path %s: expect %s got %s, error %v", fname, expect, out, err)
+		}
+	}
+	for _, fname := range []string{"..", "/3a/../../etc/passwd", "../3a/scan.dat", "/", ""} {
+		if _, err := CleanPath(fname); err == nil {
+			t.Errorf("path %s should be rejected", fname)
+		}
+	}
+}
+
+// TestDownloadAuthorization
+func TestDownloadAuthorization(t *testing.T) {
+	r, _ := testRouter(t, "alice")
+	if w := get(r, "/download/local/3a/secret.dat", ""); w.Code != http.StatusForbidden {
+		t.Error("secret file is not protected", w.Code)
+	}
+	// path traversal can not escape root directory
+	if w := get(r, "/download/local/3a/../../etc/passwd", ""); w.Code != http.StatusBadRequest {
+		t.Error("path traversal is allowed", w.Code)
+	}
+	// policy is evaluated on normalized path
+	if w := get(r, "/download/local/3a/x/../secret.dat", ""); w.Code != http.StatusForbidden {
+		t.Error("secret file is available via non-normalized path", w.Code)
+	}
+	if w := get(r, "/download/ftp/3a/scan.dat", ""); w.Code != http.StatusNotFound {
+		t.Error("unknown source is allowed", w.Code)
+	}
+	r, _ = testRouter(t, "")
+	if w := get(r, "/download/local/3a/scan.dat", ""); w.Code != http.StatusUnauthorized {
+		t.Error("anonymous download is allowed", w.Code)
+	}
+}
+
+// TestSignedDownload
+func TestSignedDownload(t *testing.T) {
+	r, d := testRouter(t, "")
+	d.Signer, _ = authz.NewURLSigner(srvConfig.SignedURLs{Secret: "secret", Paths: []string{"/download/"}})
+	user := &authz.Claims{CustomClaims: authz.CustomClaims{User: "alice", Scope: "read"}}
+	rurl, _, err := d.Signer.Sign("/download/local/3a/scan.dat", "read", user, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := get(r, rurl, ""); w.Code != http.StatusOK || w.Body.String() != "0123456789" {
+		t.Error("signed download is not allowed", w.Code)
+	}
+	if w := get(r, strings.Replace(rurl, "scan.dat", "secret.dat", 1), ""); w.Code != http.StatusUnauthorized {
+		t.Error("signed URL of another file is allowed", w.Code)
+	}
+}
+
+// TestBandwidth
+func TestBandwidth(t *testing.T) {
+	r, d := testRouter(t, "alice")
+	d.Bandwidth = 40
+	start := time.Now()
+	w := get(r, "/download/local/3a/scan.dat", "")
+	if w.Body.String() != "0123456789" {
+		t.Fatal("invalid content", w.Body.String())
+	}
+	// 10 bytes at 40 bytes per second take at least 250ms
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Error("download is not throttled", elapsed)
+	}
+}
+
+// TestRangeReader
+func TestRangeReader(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "f"), []byte("abcdef"), 0644)
+	r := &rangeReader{ctx: context.Background(), src: LocalSource{Root: dir}, fname: "f", size: 6}
+	defer r.Close()
+	r.Seek(-2, io.SeekEnd)
+	data, _ := io.ReadAll(r)
+	if string(data) != "ef" {
+		t.Errorf("invalid data %s", data)
+	}
+}
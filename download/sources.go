@@ -0,0 +1,194 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	s3 "github.com/CHESSComputing/golib/storage/s3"
+)
+
+// LocalSource serves files of local directory tree
+type LocalSource struct {
+	Root string
+}
+
+// helper function to resolve file name within root directory, file names
+// can not escape the root
+func (s LocalSource) resolve(fname string) string {
+	return filepath.Join(s.Root, filepath.FromSlash(filepath.Clean("/"+fname)))
+}
+
+// Stat implements Source interface
+func (s LocalSource) Stat(ctx context.Context, fname string) (FileInfo, error) {
+	fi, err := os.Stat(s.resolve(fname))
+	if errors.Is(err, os.ErrNotExist) {
+		return FileInfo{}, ErrNotFound
+	}
+	if err != nil {
+		return FileInfo{}, err
+	}
+	if fi.IsDir() {
+		return FileInfo{}, fmt.Errorf("%w: %s is a directory", ErrNotFound, fname)
+	}
+	return FileInfo{Size: fi.Size(), ModTime: fi.ModTime()}, nil
+}
+
+// fileSection closes file of section reader
+type fileSection struct {
+	io.Reader
+	file *os.File
+}
+
+// Close implements io.Closer interface
+func (f fileSection) Close() error {
+	return f.file.Close()
+}
+
+// Open implements Source interface
+func (s LocalSource) Open(ctx context.Context, fname string, offset, length int64) (io.ReadCloser, error) {
+	file, err := os.Open(s.resolve(fname))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if length < 0 {
+		return file, nil
+	}
+	return fileSection{Reader: io.LimitReader(file, length), file: file}, nil
+}
+
+// S3Source serves objects of S3 bucket
+type S3Source struct {
+	Client *s3.Client
+	Bucket string // bucket name, default bucket of the client is used if empty
+	Prefix string // optional key prefix
+}
+
+// Stat implements Source interface
+func (s S3Source) Stat(ctx context.Context, fname string) (FileInfo, error) {
+	obj, err := s.Client.StatObject(ctx, s.Bucket, s.Prefix+fname)
+	if errors.Is(err, s3.ErrNotFound) {
+		return FileInfo{}, ErrNotFound
+	}
+	if err != nil {
+		return FileInfo{}, err
+	}
+	return FileInfo{Size: obj.Size, ModTime: obj.LastModified, ETag: obj.ETag}, nil
+}
+
+// Open implements Source interface
+func (s S3Source) Open(ctx context.Context, fname string, offset, length int64) (io.ReadCloser, error) {
+	rc, err := s.Client.GetObjectRange(ctx, s.Bucket, s.Prefix+fname, offset, length)
+	if errors.Is(err, s3.ErrNotFound) {
+		return nil, ErrNotFound
+	}
+	return rc, err
+}
+
+// HTTPSource serves files of HTTPS endpoint which supports Range requests,
+// e.g. Globus HTTPS access of guest collection
+type HTTPSource struct {
+	BaseURL    string       // endpoint URL
+	Token      string       // optional bearer token
+	HttpClient *http.Client // HTTP client
+}
+
+// helper function to perform request to HTTPS endpoint
+func (s HTTPSource) do(ctx context.Context, method, fname string, headers http.Header) (*http.Response, error) {
+	fname, err := CleanPath(fname)
+	if err != nil {
+		return nil, err
+	}
+	var parts []string
+	for _, p := range strings.Split(fname, "/") {
+		parts = append(parts, url.PathEscape(p))
+	}
+	rurl := strings.TrimSuffix(s.BaseURL, "/") + "/" + strings.Join(parts, "/")
+	req, err := http.NewRequestWithContext(ctx, method, rurl, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range headers {
+		req.Header[k] = v
+	}
+	if s.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.Token)
+	}
+	client := s.HttpClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unable to %s %s, status %s", method, rurl, resp.Status)
+	}
+	return resp, nil
+}
+
+// Stat implements Source interface
+func (s HTTPSource) Stat(ctx context.Context, fname string) (FileInfo, error) {
+	resp, err := s.do(ctx, "HEAD", fname, nil)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	resp.Body.Close()
+	info := FileInfo{Size: resp.ContentLength, ETag: resp.Header.Get("ETag")}
+	if info.Size < 0 {
+		return info, fmt.Errorf("unknown size of %s", fname)
+	}
+	if t, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.ModTime = t
+	}
+	return info, nil
+}
+
+// Open implements Source interface
+func (s HTTPSource) Open(ctx context.Context, fname string, offset, length int64) (io.ReadCloser, error) {
+	headers := http.Header{}
+	if offset > 0 || length >= 0 {
+		rng := fmt.Sprintf("bytes=%d-", offset)
+		if length >= 0 {
+			rng = fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)
+		}
+		headers.Set("Range", rng)
+	}
+	resp, err := s.do(ctx, "GET", fname, headers)
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 && resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return nil, fmt.Errorf("endpoint does not support range requests of %s", fname)
+	}
+	return resp.Body, nil
+}
+
+// NewHTTPSource returns HTTPS source, the HTTP client does not have overall
+// timeout since downloads of large files may take long time
+func NewHTTPSource(baseURL, token string) HTTPSource {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = 30 * time.Second
+	return HTTPSource{BaseURL: baseURL, Token: token, HttpClient: &http.Client{Transport: transport}}
+}
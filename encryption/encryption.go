@@ -0,0 +1,191 @@
+package encryption
+
+// encryption module provides field-level encryption of sensitive record
+// fields, e.g. personal information. Values are sealed with AEAD cipher of
+// Encryption configuration and field name is authenticated, so encrypted
+// value can not be moved to another field. Searchable fields are encrypted
+// deterministically, i.e. equal values have equal ciphertexts, which allows
+// equality queries of encrypted fields.
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Prefix defines prefix of encrypted values
+const Prefix = "enc:"
+
+// version of encrypted value format
+const codecVersion = 1
+
+// size of key id of encrypted values
+const keyIDSize = 4
+
+// encryption modes
+const (
+	modeRandom        = 'r'
+	modeDeterministic = 'd'
+)
+
+// errors of encryption module
+var (
+	ErrInvalid       = errors.New("invalid encrypted value")
+	ErrUnknownKey    = errors.New("encrypted value has unknown key")
+	ErrNotSearchable = errors.New("encrypted field is not searchable")
+)
+
+// fieldKey represents AEAD cipher and MAC key of single secret
+type fieldKey struct {
+	id   []byte
+	aead cipher.AEAD
+	mac  []byte
+}
+
+// Codec encrypts and decrypts field values, the first key is used to
+// encrypt values and all keys are used to decrypt them
+type Codec struct {
+	keys []fieldKey
+}
+
+// helper function to create field key of the secret
+func newFieldKey(secret, name string) (fieldKey, error) {
+	key := sha256.Sum256([]byte("foxden field key:" + secret))
+	mac := sha256.Sum256([]byte("foxden field mac:" + secret))
+	hash := sha256.Sum256(key[:])
+	var aead cipher.AEAD
+	var err error
+	switch strings.ToLower(name) {
+	case "", "aes", "aes-gcm":
+		var block cipher.Block
+		block, err = aes.NewCipher(key[:])
+		if err == nil {
+			aead, err = cipher.NewGCM(block)
+		}
+	case "chacha20-poly1305":
+		aead, err = chacha20poly1305.New(key[:])
+	default:
+		err = fmt.Errorf("unsupported cipher '%s'", name)
+	}
+	return fieldKey{id: hash[:keyIDSize], aead: aead, mac: mac[:]}, err
+}
+
+// NewCodec returns field codec of encryption configuration, Secret is used
+// to encrypt values and OldSecrets to decrypt values encrypted before key
+// rotation
+func NewCodec(cfg srvConfig.Encryption) (*Codec, error) {
+	if cfg.Secret == "" {
+		return nil, errors.New("field encryption requires encryption secret")
+	}
+	codec := &Codec{}
+	for _, secret := range append([]string{cfg.Secret}, cfg.OldSecrets...) {
+		if secret == "" {
+			continue
+		}
+		key, err := newFieldKey(secret, cfg.Cipher)
+		if err != nil {
+			return nil, err
+		}
+		codec.keys = append(codec.keys, key)
+	}
+	return codec, nil
+}
+
+// IsEncrypted checks if value is encrypted
+func IsEncrypted(v any) bool {
+	s, ok := v.(string)
+	return ok && strings.HasPrefix(s, Prefix)
+}
+
+// helper function to encrypt value with given key
+func (k fieldKey) encrypt(field string, data []byte, deterministic bool) (string, error) {
+	nonce := make([]byte, k.aead.NonceSize())
+	mode := byte(modeRandom)
+	if deterministic {
+		// synthetic nonce of field and value, i.e. equal values of the
+		// field have equal ciphertexts
+		mode = modeDeterministic
+		h := hmac.New(sha256.New, k.mac)
+		h.Write([]byte(field))
+		h.Write([]byte{0})
+		h.Write(data)
+		copy(nonce, h.Sum(nil))
+	} else if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	header := append([]byte{codecVersion, mode}, k.id...)
+	out := append(header, nonce...)
+	out = k.aead.Seal(out, nonce, data, []byte(field))
+	return Prefix + base64.RawURLEncoding.EncodeToString(out), nil
+}
+
+// Encrypt encrypts value of given field, deterministic encryption should
+// be used for searchable fields
+func (c *Codec) Encrypt(field string, value any, deterministic bool) (string, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return c.keys[0].encrypt(field, data, deterministic)
+}
+
+// Searchable returns deterministic ciphertexts of value of given field for
+// all keys, i.e. values encrypted before key rotation are matched as well
+func (c *Codec) Searchable(field string, value any) ([]string, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, k := range c.keys {
+		s, err := k.encrypt(field, data, true)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+// Decrypt decrypts encrypted value of given field
+func (c *Codec) Decrypt(field, value string) (any, error) {
+	if !strings.HasPrefix(value, Prefix) {
+		return nil, ErrInvalid
+	}
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(value, Prefix))
+	if err != nil || len(data) < 2+keyIDSize || data[0] != codecVersion {
+		return nil, ErrInvalid
+	}
+	kid := data[2 : 2+keyIDSize]
+	for _, k := range c.keys {
+		if !bytes.Equal(k.id, kid) {
+			continue
+		}
+		rest := data[2+keyIDSize:]
+		if len(rest) < k.aead.NonceSize() {
+			return nil, ErrInvalid
+		}
+		nonce, sealed := rest[:k.aead.NonceSize()], rest[k.aead.NonceSize():]
+		plain, err := k.aead.Open(nil, nonce, sealed, []byte(field))
+		if err != nil {
+			return nil, fmt.Errorf("%w: field %s", ErrInvalid, field)
+		}
+		var v any
+		if err := json.Unmarshal(plain, &v); err != nil {
+			return nil, fmt.Errorf("%w: field %s", ErrInvalid, field)
+		}
+		return v, nil
+	}
+	return nil, fmt.Errorf("%w: field %s", ErrUnknownKey, field)
+}
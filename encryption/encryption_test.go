@@ -0,0 +1,160 @@
+package encryption
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	beamlines "github.com/CHESSComputing/golib/beamlines"
+	srvConfig "github.com/CHESSComputing/golib/config"
+	storage "github.com/CHESSComputing/golib/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// TestCodec
+func TestCodec(t *testing.T) {
+	codec, err := NewCodec(srvConfig.Encryption{Secret: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, _ := codec.Encrypt("email", "pi@example.com", false)
+	b, _ := codec.Encrypt("email", "pi@example.com", false)
+	if a == b || !IsEncrypted(a) || strings.Contains(a, "example") {
+		t.Errorf("wrong random encryption %s %s", a, b)
+	}
+	c, _ := codec.Encrypt("email", "pi@example.com", true)
+	d, _ := codec.Encrypt("email", "pi@example.com", true)
+	e, _ := codec.Encrypt("phone", "pi@example.com", true)
+	if c != d || c == e {
+		t.Errorf("wrong deterministic encryption %s %s %s", c, d, e)
+	}
+	for _, v := range []string{a, c} {
+		if val, err := codec.Decrypt("email", v); err != nil || val != "pi@example.com" {
+			t.Errorf("wrong decrypted value %v, error %v", val, err)
+		}
+	}
+	// value can not be moved to another field
+	if _, err := codec.Decrypt("phone", a); !errors.Is(err, ErrInvalid) {
+		t.Errorf("value of another field is decrypted, error %v", err)
+	}
+	if _, err := codec.Decrypt("email", "enc:garbage"); !errors.Is(err, ErrInvalid) {
+		t.Errorf("invalid value is decrypted, error %v", err)
+	}
+
+	// values of rotated keys are decrypted and searched
+	if _, err := NewCodec(srvConfig.Encryption{Secret: "new", Cipher: "des"}); err == nil {
+		t.Error("unsupported cipher is accepted")
+	}
+	rotated, _ := NewCodec(srvConfig.Encryption{Secret: "new", OldSecrets: []string{"secret"}})
+	if val, err := rotated.Decrypt("email", a); err != nil || val != "pi@example.com" {
+		t.Errorf("wrong decrypted value of rotated key %v, error %v", val, err)
+	}
+	if values, _ := rotated.Searchable("email", "pi@example.com"); len(values) != 2 || values[1] != c {
+		t.Errorf("wrong searchable values %v", values)
+	}
+	other, _ := NewCodec(srvConfig.Encryption{Secret: "other"})
+	if _, err := other.Decrypt("email", a); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("value of unknown key is decrypted, error %v", err)
+	}
+}
+
+// TestStore
+func TestStore(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "schema.json")
+	jsonData := `[
+    {"key": "PI", "type": "string", "optional": false, "sensitive": true, "searchable": true},
+    {"key": "Phone", "type": "string", "optional": true, "sensitive": true},
+    {"key": "Sample", "type": "string", "optional": false}
+]`
+	if err := os.WriteFile(fname, []byte(jsonData), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fields, err := SchemaFields(&beamlines.Schema{FileName: fname})
+	if err != nil || len(fields) != 2 || !fields["PI"] || fields["Phone"] {
+		t.Fatalf("wrong sensitive fields %v, error %v", fields, err)
+	}
+	codec, _ := NewCodec(srvConfig.Encryption{Secret: "secret"})
+	mem := storage.NewMemoryStore()
+	store := NewStore(mem, codec, fields)
+	ctx := context.Background()
+	pii := WithScope(ctx, "pii")
+	store.Insert(ctx, "meta",
+		map[string]any{"_id": "a", "PI": "alice", "Phone": "555-0100", "Sample": "steel"},
+		map[string]any{"_id": "b", "PI": "bob", "Sample": "steel"})
+
+	// fields are encrypted at rest
+	rec, _ := storage.FindOne(ctx, mem, "meta", map[string]any{"_id": "a"})
+	if !IsEncrypted(rec["PI"]) || !IsEncrypted(rec["Phone"]) || rec["Sample"] != "steel" {
+		t.Errorf("wrong stored record %v", rec)
+	}
+	// and decrypted for authorized scope only
+	if rec, _ := storage.FindOne(ctx, store, "meta", map[string]any{"_id": "a"}); !IsEncrypted(rec["PI"]) {
+		t.Errorf("record is decrypted for unauthorized reader %v", rec)
+	}
+	if rec, _ := storage.FindOne(WithScope(ctx, "read"), store, "meta", map[string]any{"_id": "a"}); !IsEncrypted(rec["PI"]) {
+		t.Errorf("record is decrypted for unauthorized scope %v", rec)
+	}
+	if rec, _ := storage.FindOne(pii, store, "meta", map[string]any{"_id": "a"}); rec["PI"] != "alice" || rec["Phone"] != "555-0100" {
+		t.Errorf("wrong decrypted record %v", rec)
+	}
+
+	// searchable fields are queried by equality
+	if n, err := store.Count(ctx, "meta", map[string]any{"PI": "alice"}); err != nil || n != 1 {
+		t.Errorf("wrong number of records %d, error %v", n, err)
+	}
+	spec := map[string]any{"$or": []map[string]any{{"PI": "alice"}, {"PI": "bob"}}}
+	if n, err := store.Count(ctx, "meta", spec); err != nil || n != 2 {
+		t.Errorf("wrong number of records %d, error %v", n, err)
+	}
+	for _, spec := range []map[string]any{{"Phone": "555-0100"}, {"PI": map[string]any{"$gt": "a"}}} {
+		if _, err := store.Find(ctx, "meta", spec, nil); !errors.Is(err, ErrNotSearchable) {
+			t.Errorf("spec %v: expect not searchable error, got %v", spec, err)
+		}
+	}
+
+	// updated fields are encrypted and encrypted values are kept as is
+	if n, err := store.Update(ctx, "meta", map[string]any{"PI": "bob"}, map[string]any{"Phone": "555-0199"}); err != nil || n != 1 {
+		t.Errorf("wrong number of updated records %d, error %v", n, err)
+	}
+	rec, _ = storage.FindOne(ctx, store, "meta", map[string]any{"_id": "a"})
+	rec["_id"] = "c"
+	store.Insert(ctx, "meta", rec)
+	if rec, _ := storage.FindOne(pii, store, "meta", map[string]any{"_id": "c"}); rec["PI"] != "alice" {
+		t.Errorf("wrong copied record %v", rec)
+	}
+	if rec, _ := storage.FindOne(pii, store, "meta", map[string]any{"_id": "b"}); rec["Phone"] != "555-0199" {
+		t.Errorf("wrong updated record %v", rec)
+	}
+
+	// records of other collections are not encrypted
+	store.Collections = []string{"meta"}
+	store.Insert(ctx, "other", map[string]any{"_id": "a", "PI": "alice"})
+	if rec, _ := storage.FindOne(ctx, mem, "other", map[string]any{"PI": "alice"}); rec == nil {
+		t.Error("record of other collection is encrypted")
+	}
+	if n, _ := store.Remove(ctx, "meta", map[string]any{"PI": "alice"}); n != 2 {
+		t.Errorf("wrong number of removed records %d", n)
+	}
+}
+
+// TestScopeMiddleware
+func TestScopeMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var scope string
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set("claims", &authz.Claims{CustomClaims: authz.CustomClaims{Scope: "pii"}})
+	}, ScopeMiddleware())
+	r.GET("/", func(c *gin.Context) {
+		scope = ScopeFromContext(c.Request.Context())
+	})
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if scope != "pii" {
+		t.Errorf("wrong scope of request context '%s'", scope)
+	}
+}
@@ -0,0 +1,330 @@
+package encryption
+
+// store module provides storage wrapper which transparently encrypts
+// sensitive fields of records at rest and decrypts them only for
+// authorized scopes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	beamlines "github.com/CHESSComputing/golib/beamlines"
+	srvConfig "github.com/CHESSComputing/golib/config"
+	storage "github.com/CHESSComputing/golib/storage"
+	utils "github.com/CHESSComputing/golib/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultScopes defines token scopes which may read decrypted fields
+var DefaultScopes = []string{"pii"}
+
+// Fields represents sensitive fields of records, the value of the field is
+// true for searchable fields
+type Fields map[string]bool
+
+// SchemaFields returns sensitive fields of given schemas
+func SchemaFields(schemas ...*beamlines.Schema) (Fields, error) {
+	fields := make(Fields)
+	for _, s := range schemas {
+		keys, err := s.SensitiveKeys()
+		if err != nil {
+			return nil, err
+		}
+		for k, searchable := range keys {
+			if prev, ok := fields[k]; ok && prev != searchable {
+				return nil, fmt.Errorf("sensitive key %s of schema %s has different searchable flag in other schemas", k, s.FileName)
+			}
+			fields[k] = searchable
+		}
+	}
+	return fields, nil
+}
+
+// scopeKey defines context key of the reader scope
+type scopeKey struct{}
+
+// WithScope returns context with given token scope of the reader
+func WithScope(ctx context.Context, scope string) context.Context {
+	return context.WithValue(ctx, scopeKey{}, scope)
+}
+
+// ScopeFromContext returns token scope of the reader of the context
+func ScopeFromContext(ctx context.Context) string {
+	scope, _ := ctx.Value(scopeKey{}).(string)
+	return scope
+}
+
+// ScopeMiddleware passes token scope of the request to request context,
+// token claims are provided by token validation middleware
+func ScopeMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if val, ok := c.Get("claims"); ok {
+			if claims, ok := val.(*authz.Claims); ok {
+				c.Request = c.Request.WithContext(WithScope(c.Request.Context(), claims.CustomClaims.Scope))
+			}
+		}
+		c.Next()
+	}
+}
+
+// Store represents storage which encrypts sensitive fields of records,
+// readers of authorized scopes get decrypted values and other readers get
+// encrypted ones
+type Store struct {
+	storage.Store
+	Codec       *Codec
+	Fields      Fields   // sensitive fields
+	Scopes      []string // token scopes which may read decrypted fields
+	Collections []string // collections of encrypted records, all collections if empty
+}
+
+// FieldStore represents global storage wrapper of FieldEncryption
+// configuration, it should be initialized via Init function
+var FieldStore *Store
+
+// NewStore returns storage which encrypts given fields of records of
+// underlying store
+func NewStore(store storage.Store, codec *Codec, fields Fields) *Store {
+	return &Store{Store: store, Codec: codec, Fields: fields, Scopes: DefaultScopes}
+}
+
+// Init initializes global storage wrapper of CHESS MetaData records with
+// sensitive fields of CHESS MetaData schema files
+func Init() error {
+	if srvConfig.Config == nil {
+		return errors.New("server configuration is not initialized")
+	}
+	cfg := srvConfig.Config.FieldEncryption
+	if !cfg.Enabled {
+		return errors.New("field encryption is not enabled")
+	}
+	codec, err := NewCodec(srvConfig.Config.Encryption)
+	if err != nil {
+		return err
+	}
+	var schemas []*beamlines.Schema
+	for _, fname := range srvConfig.Config.CHESSMetaData.SchemaFiles {
+		schemas = append(schemas, &beamlines.Schema{FileName: utils.FullPath(fname)})
+	}
+	fields, err := SchemaFields(schemas...)
+	if err != nil {
+		return err
+	}
+	store := NewStore(storage.NewStore(srvConfig.Config.CHESSMetaData.DBName), codec, fields)
+	if len(cfg.Scopes) > 0 {
+		store.Scopes = cfg.Scopes
+	}
+	store.Collections = cfg.Collections
+	FieldStore = store
+	return nil
+}
+
+// helper function to check if records of collection are encrypted
+func (s *Store) encrypted(collection string) bool {
+	return len(s.Fields) > 0 && (len(s.Collections) == 0 || utils.InList(collection, s.Collections))
+}
+
+// Authorized checks if reader of the context may read decrypted fields
+func (s *Store) Authorized(ctx context.Context) bool {
+	scope := ScopeFromContext(ctx)
+	return scope != "" && utils.InList(scope, s.Scopes)
+}
+
+// helper function to encrypt sensitive fields of the record, encrypted
+// values, e.g. of records read by unauthorized reader, are kept as is
+func (s *Store) encryptRecord(rec map[string]any) (map[string]any, error) {
+	out := make(map[string]any, len(rec))
+	for k, v := range rec {
+		searchable, ok := s.Fields[k]
+		if !ok || v == nil || IsEncrypted(v) {
+			out[k] = v
+			continue
+		}
+		ev, err := s.Codec.Encrypt(k, v, searchable)
+		if err != nil {
+			return nil, err
+		}
+		out[k] = ev
+	}
+	return out, nil
+}
+
+// helper function to decrypt sensitive fields of the record
+func (s *Store) decryptRecord(rec map[string]any) error {
+	for k := range s.Fields {
+		ev, ok := rec[k].(string)
+		if !ok || !IsEncrypted(ev) {
+			continue
+		}
+		v, err := s.Codec.Decrypt(k, ev)
+		if err != nil {
+			return err
+		}
+		rec[k] = v
+	}
+	return nil
+}
+
+// helper function to convert value of $or and $and operators into list of
+// specs
+func specList(v any) ([]map[string]any, bool) {
+	switch val := v.(type) {
+	case []map[string]any:
+		return val, true
+	case []any:
+		var out []map[string]any
+		for _, item := range val {
+			spec, ok := item.(map[string]any)
+			if !ok {
+				return nil, false
+			}
+			out = append(out, spec)
+		}
+		return out, true
+	}
+	return nil, false
+}
+
+// helper function to rewrite conditions of sensitive fields of the spec
+// into conditions of their encrypted values, only equality conditions of
+// searchable fields are supported
+func (s *Store) encryptSpec(spec map[string]any) (map[string]any, error) {
+	out := make(map[string]any, len(spec))
+	var and []map[string]any
+	for k, v := range spec {
+		if k == "$or" || k == "$and" {
+			list, ok := specList(v)
+			if !ok {
+				return nil, fmt.Errorf("invalid %s condition %v", k, v)
+			}
+			var specs []map[string]any
+			for _, item := range list {
+				es, err := s.encryptSpec(item)
+				if err != nil {
+					return nil, err
+				}
+				specs = append(specs, es)
+			}
+			if k == "$and" {
+				and = append(and, specs...)
+			} else {
+				out[k] = specs
+			}
+			continue
+		}
+		searchable, ok := s.Fields[k]
+		if !ok || IsEncrypted(v) {
+			out[k] = v
+			continue
+		}
+		if _, isMap := v.(map[string]any); !searchable || isMap {
+			return nil, fmt.Errorf("%w: %s", ErrNotSearchable, k)
+		}
+		values, err := s.Codec.Searchable(k, v)
+		if err != nil {
+			return nil, err
+		}
+		if len(values) == 1 {
+			out[k] = values[0]
+			continue
+		}
+		// values encrypted with rotated keys are matched as well
+		var or []map[string]any
+		for _, ev := range values {
+			or = append(or, map[string]any{k: ev})
+		}
+		and = append(and, map[string]any{"$or": or})
+	}
+	if len(and) > 0 {
+		out["$and"] = and
+	}
+	return out, nil
+}
+
+// helper function to rewrite spec of collection
+func (s *Store) spec(collection string, spec map[string]any) (map[string]any, error) {
+	if !s.encrypted(collection) {
+		return spec, nil
+	}
+	out, err := s.encryptSpec(spec)
+	if err != nil {
+		log.Printf("ERROR: unable to query encrypted fields of %s, error %v", collection, err)
+	}
+	return out, err
+}
+
+// Insert implements storage.Store interface, sensitive fields of records
+// are encrypted
+func (s *Store) Insert(ctx context.Context, collection string, records ...map[string]any) error {
+	if !s.encrypted(collection) {
+		return s.Store.Insert(ctx, collection, records...)
+	}
+	var out []map[string]any
+	for _, rec := range records {
+		erec, err := s.encryptRecord(rec)
+		if err != nil {
+			log.Printf("ERROR: unable to encrypt record, error %v", err)
+			return err
+		}
+		out = append(out, erec)
+	}
+	return s.Store.Insert(ctx, collection, out...)
+}
+
+// Find implements storage.Store interface, sensitive fields are decrypted
+// for authorized readers
+func (s *Store) Find(ctx context.Context, collection string, spec map[string]any, opts *storage.FindOptions) ([]map[string]any, error) {
+	espec, err := s.spec(collection, spec)
+	if err != nil {
+		return nil, err
+	}
+	records, err := s.Store.Find(ctx, collection, espec, opts)
+	if err != nil || !s.encrypted(collection) || !s.Authorized(ctx) {
+		return records, err
+	}
+	for _, rec := range records {
+		if err := s.decryptRecord(rec); err != nil {
+			log.Printf("ERROR: unable to decrypt record %v, error %v", rec["_id"], err)
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+// Update implements storage.Store interface, sensitive fields are
+// encrypted
+func (s *Store) Update(ctx context.Context, collection string, spec, fields map[string]any) (int64, error) {
+	espec, err := s.spec(collection, spec)
+	if err != nil {
+		return 0, err
+	}
+	if s.encrypted(collection) {
+		fields, err = s.encryptRecord(fields)
+		if err != nil {
+			log.Printf("ERROR: unable to encrypt fields, error %v", err)
+			return 0, err
+		}
+	}
+	return s.Store.Update(ctx, collection, espec, fields)
+}
+
+// Count implements storage.Store interface
+func (s *Store) Count(ctx context.Context, collection string, spec map[string]any) (int64, error) {
+	espec, err := s.spec(collection, spec)
+	if err != nil {
+		return 0, err
+	}
+	return s.Store.Count(ctx, collection, espec)
+}
+
+// Remove implements storage.Store interface
+func (s *Store) Remove(ctx context.Context, collection string, spec map[string]any) (int64, error) {
+	espec, err := s.spec(collection, spec)
+	if err != nil {
+		return 0, err
+	}
+	return s.Store.Remove(ctx, collection, espec)
+}
@@ -0,0 +1,433 @@
+package enrich
+
+// enrich module resolves ORCID iDs and DOIs via ORCID, DataCite and Crossref
+// APIs and provides normalized author and publication records which can be
+// included into CHESS metadata records
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned when ORCID iD or DOI is not found
+var ErrNotFound = errors.New("record not found")
+
+// Author represents normalized author record
+type Author struct {
+	ORCID        string   `json:"orcid,omitempty"`
+	Name         string   `json:"name"`
+	GivenName    string   `json:"given_name,omitempty"`
+	FamilyName   string   `json:"family_name,omitempty"`
+	Affiliations []string `json:"affiliations,omitempty"`
+}
+
+// Publication represents normalized publication (or dataset) record
+type Publication struct {
+	DOI       string   `json:"doi"`
+	Title     string   `json:"title"`
+	Authors   []Author `json:"authors,omitempty"`
+	Publisher string   `json:"publisher,omitempty"`
+	Year      int      `json:"year,omitempty"`
+	Type      string   `json:"type,omitempty"`
+	URL       string   `json:"url,omitempty"`
+	Source    string   `json:"source"` // datacite or crossref
+}
+
+// cacheEntry represents cached record
+type cacheEntry struct {
+	record  any
+	expires time.Time
+}
+
+// Client represents enrichment client
+type Client struct {
+	OrcidURL    string        // ORCID public API URL
+	DataCiteURL string        // DataCite API URL
+	CrossrefURL string        // Crossref API URL
+	Mailto      string        // contact email used by Crossref polite pool
+	CacheTTL    time.Duration // time to keep resolved records in cache
+	Interval    time.Duration // minimal interval between API calls
+	HttpClient  *http.Client  // HTTP client
+	Verbose     int           // verbosity level
+
+	mu       sync.Mutex
+	cache    map[string]cacheEntry
+	lastCall time.Time
+}
+
+// NewClient returns new enrichment client with default settings
+func NewClient() *Client {
+	return &Client{
+		OrcidURL:    "https://pub.orcid.org/v3.0",
+		DataCiteURL: "https://api.datacite.org",
+		CrossrefURL: "https://api.crossref.org",
+		CacheTTL:    24 * time.Hour,
+		Interval:    100 * time.Millisecond,
+		HttpClient:  &http.Client{Timeout: 30 * time.Second},
+		cache:       make(map[string]cacheEntry),
+	}
+}
+
+// helper function to get record from the cache
+func (c *Client) cached(key string) (any, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.cache[key]
+	if !ok || time.Now().After(entry.expires) {
+		delete(c.cache, key)
+		return nil, false
+	}
+	return entry.record, true
+}
+
+// helper function to put record into the cache
+func (c *Client) store(key string, record any) {
+	if c.CacheTTL <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cache == nil {
+		c.cache = make(map[string]cacheEntry)
+	}
+	c.cache[key] = cacheEntry{record: record, expires: time.Now().Add(c.CacheTTL)}
+}
+
+// helper function to wait until next API call is allowed
+func (c *Client) wait() {
+	c.mu.Lock()
+	next := c.lastCall.Add(c.Interval)
+	now := time.Now()
+	if next.Before(now) {
+		next = now
+	}
+	c.lastCall = next
+	c.mu.Unlock()
+	time.Sleep(time.Until(next))
+}
+
+// helper function to fetch JSON record from given URL
+func (c *Client) fetch(rurl string, rec any) error {
+	c.wait()
+	req, err := http.NewRequest("GET", rurl, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.Mailto != "" {
+		req.Header.Set("User-Agent", fmt.Sprintf("FOXDEN (mailto:%s)", c.Mailto))
+	}
+	client := c.HttpClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if c.Verbose > 0 {
+		log.Println("enrich: fetch", rurl)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unable to fetch %s, status %s", rurl, resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, rec)
+}
+
+// NormalizeORCID returns ORCID iD without URL prefix
+func NormalizeORCID(orcid string) string {
+	orcid = strings.TrimSpace(orcid)
+	for _, prefix := range []string{"https://orcid.org/", "http://orcid.org/", "orcid:"} {
+		orcid = strings.TrimPrefix(orcid, prefix)
+	}
+	return strings.ToUpper(orcid)
+}
+
+// ValidORCID checks format and checksum (ISO 7064 11,2) of ORCID iD
+func ValidORCID(orcid string) bool {
+	orcid = NormalizeORCID(orcid)
+	digits := strings.ReplaceAll(orcid, "-", "")
+	if len(digits) != 16 || len(orcid) != 19 {
+		return false
+	}
+	total := 0
+	for _, r := range digits[:15] {
+		if r < '0' || r > '9' {
+			return false
+		}
+		total = (total + int(r-'0')) * 2
+	}
+	check := (12 - total%11) % 11
+	expect := byte('0' + check)
+	if check == 10 {
+		expect = 'X'
+	}
+	return digits[15] == expect
+}
+
+// NormalizeDOI returns lower-case DOI without resolver prefix
+func NormalizeDOI(doi string) string {
+	doi = strings.TrimSpace(doi)
+	for _, prefix := range []string{"https://doi.org/", "http://doi.org/", "https://dx.doi.org/", "doi:"} {
+		doi = strings.TrimPrefix(doi, prefix)
+	}
+	return strings.ToLower(doi)
+}
+
+// orcidRecord represents subset of ORCID record
+type orcidRecord struct {
+	Person struct {
+		Name struct {
+			GivenNames struct {
+				Value string `json:"value"`
+			} `json:"given-names"`
+			FamilyName struct {
+				Value string `json:"value"`
+			} `json:"family-name"`
+			CreditName *struct {
+				Value string `json:"value"`
+			} `json:"credit-name"`
+		} `json:"name"`
+	} `json:"person"`
+	Activities struct {
+		Employments struct {
+			Groups []struct {
+				Summaries []struct {
+					Employment struct {
+						Organization struct {
+							Name string `json:"name"`
+						} `json:"organization"`
+					} `json:"employment-summary"`
+				} `json:"summaries"`
+			} `json:"affiliation-group"`
+		} `json:"employments"`
+	} `json:"activities-summary"`
+}
+
+// ResolveORCID resolves ORCID iD into author record
+func (c *Client) ResolveORCID(orcid string) (Author, error) {
+	orcid = NormalizeORCID(orcid)
+	if !ValidORCID(orcid) {
+		return Author{}, fmt.Errorf("invalid ORCID iD '%s'", orcid)
+	}
+	key := "orcid:" + orcid
+	if rec, ok := c.cached(key); ok {
+		return rec.(Author), nil
+	}
+	var rec orcidRecord
+	rurl := fmt.Sprintf("%s/%s/record", strings.TrimSuffix(c.OrcidURL, "/"), orcid)
+	if err := c.fetch(rurl, &rec); err != nil {
+		return Author{}, err
+	}
+	name := rec.Person.Name
+	author := Author{
+		ORCID:      orcid,
+		GivenName:  name.GivenNames.Value,
+		FamilyName: name.FamilyName.Value,
+		Name:       strings.TrimSpace(name.GivenNames.Value + " " + name.FamilyName.Value),
+	}
+	if name.CreditName != nil && name.CreditName.Value != "" {
+		author.Name = name.CreditName.Value
+	}
+	for _, group := range rec.Activities.Employments.Groups {
+		for _, s := range group.Summaries {
+			if org := s.Employment.Organization.Name; org != "" {
+				author.Affiliations = appendUnique(author.Affiliations, org)
+			}
+		}
+	}
+	c.store(key, author)
+	return author, nil
+}
+
+// ResolveDOI resolves DOI into publication record. DataCite is queried
+// first since CHESS datasets are registered there, and Crossref is used as
+// a fallback for journal publications.
+func (c *Client) ResolveDOI(doi string) (Publication, error) {
+	doi = NormalizeDOI(doi)
+	if !strings.HasPrefix(doi, "10.") || !strings.Contains(doi, "/") {
+		return Publication{}, fmt.Errorf("invalid DOI '%s'", doi)
+	}
+	key := "doi:" + doi
+	if rec, ok := c.cached(key); ok {
+		return rec.(Publication), nil
+	}
+	pub, err := c.dataCite(doi)
+	if errors.Is(err, ErrNotFound) {
+		pub, err = c.crossref(doi)
+	}
+	if err != nil {
+		return Publication{}, err
+	}
+	c.store(key, pub)
+	return pub, nil
+}
+
+// dataCiteRecord represents subset of DataCite DOI record
+type dataCiteRecord struct {
+	Data struct {
+		Attributes struct {
+			DOI    string `json:"doi"`
+			Titles []struct {
+				Title string `json:"title"`
+			} `json:"titles"`
+			Creators []struct {
+				Name            string `json:"name"`
+				GivenName       string `json:"givenName"`
+				FamilyName      string `json:"familyName"`
+				NameIdentifiers []struct {
+					NameIdentifier       string `json:"nameIdentifier"`
+					NameIdentifierScheme string `json:"nameIdentifierScheme"`
+				} `json:"nameIdentifiers"`
+				Affiliation []any `json:"affiliation"`
+			} `json:"creators"`
+			Publisher       any `json:"publisher"`
+			PublicationYear int `json:"publicationYear"`
+			Types           struct {
+				ResourceTypeGeneral string `json:"resourceTypeGeneral"`
+			} `json:"types"`
+			URL string `json:"url"`
+		} `json:"attributes"`
+	} `json:"data"`
+}
+
+// helper function to resolve DOI via DataCite API
+func (c *Client) dataCite(doi string) (Publication, error) {
+	var rec dataCiteRecord
+	rurl := fmt.Sprintf("%s/dois/%s", strings.TrimSuffix(c.DataCiteURL, "/"), url.PathEscape(doi))
+	if err := c.fetch(rurl, &rec); err != nil {
+		return Publication{}, err
+	}
+	attrs := rec.Data.Attributes
+	pub := Publication{
+		DOI:       doi,
+		Publisher: nameOf(attrs.Publisher),
+		Year:      attrs.PublicationYear,
+		Type:      attrs.Types.ResourceTypeGeneral,
+		URL:       attrs.URL,
+		Source:    "datacite",
+	}
+	if len(attrs.Titles) > 0 {
+		pub.Title = attrs.Titles[0].Title
+	}
+	for _, creator := range attrs.Creators {
+		author := Author{Name: creator.Name, GivenName: creator.GivenName, FamilyName: creator.FamilyName}
+		for _, nid := range creator.NameIdentifiers {
+			if strings.EqualFold(nid.NameIdentifierScheme, "ORCID") {
+				author.ORCID = NormalizeORCID(nid.NameIdentifier)
+			}
+		}
+		for _, aff := range creator.Affiliation {
+			if name := nameOf(aff); name != "" {
+				author.Affiliations = appendUnique(author.Affiliations, name)
+			}
+		}
+		pub.Authors = append(pub.Authors, author)
+	}
+	return pub, nil
+}
+
+// crossrefRecord represents subset of Crossref works record
+type crossrefRecord struct {
+	Message struct {
+		DOI    string   `json:"DOI"`
+		Title  []string `json:"title"`
+		Author []struct {
+			Given       string `json:"given"`
+			Family      string `json:"family"`
+			Name        string `json:"name"`
+			ORCID       string `json:"ORCID"`
+			Affiliation []struct {
+				Name string `json:"name"`
+			} `json:"affiliation"`
+		} `json:"author"`
+		Publisher string `json:"publisher"`
+		Type      string `json:"type"`
+		URL       string `json:"URL"`
+		Issued    struct {
+			DateParts [][]int `json:"date-parts"`
+		} `json:"issued"`
+	} `json:"message"`
+}
+
+// helper function to resolve DOI via Crossref API
+func (c *Client) crossref(doi string) (Publication, error) {
+	var rec crossrefRecord
+	rurl := fmt.Sprintf("%s/works/%s", strings.TrimSuffix(c.CrossrefURL, "/"), url.PathEscape(doi))
+	if c.Mailto != "" {
+		rurl = fmt.Sprintf("%s?mailto=%s", rurl, url.QueryEscape(c.Mailto))
+	}
+	if err := c.fetch(rurl, &rec); err != nil {
+		return Publication{}, err
+	}
+	msg := rec.Message
+	pub := Publication{
+		DOI:       doi,
+		Publisher: msg.Publisher,
+		Type:      msg.Type,
+		URL:       msg.URL,
+		Source:    "crossref",
+	}
+	if len(msg.Title) > 0 {
+		pub.Title = msg.Title[0]
+	}
+	if len(msg.Issued.DateParts) > 0 && len(msg.Issued.DateParts[0]) > 0 {
+		pub.Year = msg.Issued.DateParts[0][0]
+	}
+	for _, a := range msg.Author {
+		author := Author{GivenName: a.Given, FamilyName: a.Family, Name: a.Name}
+		if author.Name == "" {
+			author.Name = strings.TrimSpace(a.Given + " " + a.Family)
+		}
+		if a.ORCID != "" {
+			author.ORCID = NormalizeORCID(a.ORCID)
+		}
+		for _, aff := range a.Affiliation {
+			author.Affiliations = appendUnique(author.Affiliations, aff.Name)
+		}
+		pub.Authors = append(pub.Authors, author)
+	}
+	return pub, nil
+}
+
+// helper function to get name from either string or object with name key
+func nameOf(val any) string {
+	switch v := val.(type) {
+	case string:
+		return v
+	case map[string]any:
+		if name, ok := v["name"].(string); ok {
+			return name
+		}
+	}
+	return ""
+}
+
+// helper function to append unique non-empty value to the list
+func appendUnique(list []string, val string) []string {
+	if val == "" {
+		return list
+	}
+	for _, v := range list {
+		if v == val {
+			return list
+		}
+	}
+	return append(list, val)
+}
@@ -0,0 +1,75 @@
+package enrich
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestValidORCID
+func TestValidORCID(t *testing.T) {
+	tests := map[string]bool{
+		"0000-0002-1825-0097":                   true,
+		"https://orcid.org/0000-0001-5109-3700": true,
+		"0000-0002-1694-233X":                   true,
+		"0000-0002-1825-0098":                   false,
+		"bla":                                   false,
+	}
+	for orcid, expect := range tests {
+		if ValidORCID(orcid) != expect {
+			t.Errorf("ORCID %s expect %v", orcid, expect)
+		}
+	}
+	if doi := NormalizeDOI("https://doi.org/10.18126/ABC"); doi != "10.18126/abc" {
+		t.Errorf("wrong DOI %s", doi)
+	}
+}
+
+// TestResolveDOI
+func TestResolveDOI(t *testing.T) {
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		switch r.URL.Path {
+		case "/dois/10.1234/data":
+			fmt.Fprint(w, `{"data":{"attributes":{"titles":[{"title":"Scan"}],"publisher":"CHESS","publicationYear":2023,
+"creators":[{"name":"Doe, John","givenName":"John","familyName":"Doe","affiliation":["Cornell"],
+"nameIdentifiers":[{"nameIdentifier":"https://orcid.org/0000-0002-1825-0097","nameIdentifierScheme":"ORCID"}]}]}}}`)
+		case "/works/10.1234/paper":
+			fmt.Fprint(w, `{"message":{"title":["Paper"],"publisher":"APS","type":"journal-article",
+"issued":{"date-parts":[[2021,5]]},"author":[{"given":"Jane","family":"Roe","affiliation":[{"name":"CHESS"}]}]}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	client := NewClient()
+	client.DataCiteURL = server.URL
+	client.CrossrefURL = server.URL
+	client.Interval = 0
+
+	pub, err := client.ResolveDOI("doi:10.1234/data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pub.Source != "datacite" || pub.Year != 2023 || len(pub.Authors) != 1 ||
+		pub.Authors[0].ORCID != "0000-0002-1825-0097" || pub.Authors[0].Affiliations[0] != "Cornell" {
+		t.Errorf("wrong DataCite publication %+v", pub)
+	}
+	pub, err = client.ResolveDOI("10.1234/paper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pub.Source != "crossref" || pub.Year != 2021 || pub.Authors[0].Name != "Jane Roe" {
+		t.Errorf("wrong Crossref publication %+v", pub)
+	}
+	ncalls := calls
+	client.ResolveDOI("10.1234/paper")
+	if calls != ncalls {
+		t.Error("DOI record should be served from the cache")
+	}
+	if _, err := client.ResolveDOI("10.1234/unknown"); err != ErrNotFound {
+		t.Errorf("expect ErrNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,319 @@
+package exporters
+
+// exporters module transforms FOXDEN/CHESS metadata records into standard
+// formats (DataCite XML, OAI Dublin Core and schema.org Dataset JSON-LD)
+// which allow records to be harvested by institutional repositories and
+// indexed by dataset search engines. Records are mapped into common Dataset
+// representation using configurable field mappings per schema.
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	utils "github.com/CHESSComputing/golib/utils"
+)
+
+// Creator represents dataset creator
+type Creator struct {
+	Name         string   `json:"name"`
+	GivenName    string   `json:"given_name,omitempty"`
+	FamilyName   string   `json:"family_name,omitempty"`
+	ORCID        string   `json:"orcid,omitempty"`
+	Affiliations []string `json:"affiliations,omitempty"`
+}
+
+// Dataset represents common representation of metadata record used by
+// exporters
+type Dataset struct {
+	ID          string    // dataset id, e.g. did
+	DOI         string    // dataset DOI
+	URL         string    // landing page URL
+	Title       string    // dataset title
+	Creators    []Creator // dataset creators
+	Description string    // dataset description
+	Subjects    []string  // keywords
+	Publisher   string    // dataset publisher
+	Year        int       // publication year
+	Date        string    // publication or creation date
+	Version     string    // dataset version
+	License     string    // license URL or name
+}
+
+// Mapping represents mapping of dataset fields (title, creators,
+// description, subjects, publisher, year, date, doi, version, license) to
+// record keys, nested keys are separated by dot
+type Mapping map[string]string
+
+// DefaultMapping defines default mapping of dataset fields to record keys
+var DefaultMapping = Mapping{
+	"title":       "title",
+	"creators":    "authors",
+	"description": "description",
+	"subjects":    "keywords",
+	"year":        "year",
+	"date":        "date",
+	"doi":         "doi",
+	"version":     "version",
+	"license":     "license",
+}
+
+// ErrUnknownFormat is returned for unsupported export formats
+var ErrUnknownFormat = errors.New("unknown export format")
+
+// Mapper maps metadata records into datasets
+type Mapper struct {
+	Publisher  string             // default publisher of datasets
+	LandingURL string             // landing page URL, {id} is replaced by dataset id
+	IDKey      string             // record key of dataset id
+	SchemaKey  string             // record key of schema name
+	Mappings   map[string]Mapping // mappings per schema name, "default" mapping is used for other schemas
+}
+
+// DefaultMapper represents global mapper, it should be initialized via Init
+// function
+var DefaultMapper = NewMapper(srvConfig.Export{})
+
+// NewMapper returns mapper for given configuration, configured mappings
+// extend DefaultMapping
+func NewMapper(cfg srvConfig.Export) *Mapper {
+	m := &Mapper{
+		Publisher:  cfg.Publisher,
+		LandingURL: cfg.LandingURL,
+		IDKey:      cfg.IDKey,
+		SchemaKey:  cfg.SchemaKey,
+		Mappings:   make(map[string]Mapping),
+	}
+	if m.IDKey == "" {
+		m.IDKey = "did"
+	}
+	if m.SchemaKey == "" {
+		m.SchemaKey = "schema"
+	}
+	for schema, fields := range cfg.Mappings {
+		mapping := make(Mapping)
+		for k, v := range fields {
+			mapping[strings.ToLower(k)] = v
+		}
+		m.Mappings[strings.ToLower(schema)] = mapping
+	}
+	return m
+}
+
+// Init initializes global mapper from configuration
+func Init() error {
+	if srvConfig.Config == nil {
+		return errors.New("server configuration is not initialized")
+	}
+	DefaultMapper = NewMapper(srvConfig.Config.Export)
+	return nil
+}
+
+// MappingFor returns mapping of given schema, schema names are case
+// insensitive since configuration keys are lower-cased
+func (m *Mapper) MappingFor(schema string) Mapping {
+	out := make(Mapping)
+	for k, v := range DefaultMapping {
+		out[k] = v
+	}
+	for _, name := range []string{"default", strings.ToLower(schema)} {
+		for k, v := range m.Mappings[name] {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// helper function to get value of nested record key
+func lookup(rec map[string]any, key string) any {
+	if key == "" {
+		return nil
+	}
+	var val any = rec
+	for _, k := range strings.Split(key, ".") {
+		m, ok := val.(map[string]any)
+		if !ok {
+			return nil
+		}
+		val = m[k]
+	}
+	return val
+}
+
+// helper function to convert value into string
+func stringValue(val any) string {
+	switch v := val.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case time.Time:
+		return v.Format("2006-01-02")
+	case float64:
+		return fmt.Sprintf("%v", v)
+	}
+	return fmt.Sprintf("%v", val)
+}
+
+// helper function to convert value into list of strings
+func stringList(val any) []string {
+	switch v := val.(type) {
+	case string:
+		if v == "" {
+			return nil
+		}
+		return []string{v}
+	case []string:
+		return v
+	}
+	// MongoDB returns arrays as primitive.A
+	list, _ := utils.ListValues(val)
+	var out []string
+	for _, e := range list {
+		if s := stringValue(e); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// helper function to convert author value into creator, authors are either
+// names or maps with name, given_name, family_name, orcid and affiliations
+// keys (see enrich.Author)
+func creator(val any) (Creator, bool) {
+	switch v := val.(type) {
+	case string:
+		return Creator{Name: v}, v != ""
+	case map[string]any:
+		c := Creator{}
+		c.Name, _ = v["name"].(string)
+		c.GivenName, _ = v["given_name"].(string)
+		c.FamilyName, _ = v["family_name"].(string)
+		c.ORCID, _ = v["orcid"].(string)
+		c.Affiliations = stringList(v["affiliations"])
+		if c.Name == "" {
+			c.Name = strings.Trim(c.FamilyName+", "+c.GivenName, ", ")
+		}
+		return c, c.Name != ""
+	}
+	return Creator{}, false
+}
+
+// helper function to extract year from value, e.g. 2024 or 2024-05-01
+func year(val any) int {
+	switch v := val.(type) {
+	case int:
+		return v
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case float64:
+		return int(v)
+	case time.Time:
+		return v.Year()
+	}
+	var y int
+	if s := stringValue(val); len(s) >= 4 {
+		fmt.Sscanf(s[:4], "%d", &y)
+	}
+	return y
+}
+
+// Dataset maps metadata record into dataset using mapping of record schema
+func (m *Mapper) Dataset(rec map[string]any) Dataset {
+	mapping := m.MappingFor(stringValue(rec[m.SchemaKey]))
+	get := func(field string) any {
+		return lookup(rec, mapping[field])
+	}
+	ds := Dataset{
+		ID:          stringValue(rec[m.IDKey]),
+		DOI:         stringValue(get("doi")),
+		Title:       stringValue(get("title")),
+		Description: stringValue(get("description")),
+		Subjects:    stringList(get("subjects")),
+		Publisher:   stringValue(get("publisher")),
+		Date:        stringValue(get("date")),
+		Version:     stringValue(get("version")),
+		License:     stringValue(get("license")),
+		Year:        year(get("year")),
+	}
+	if authors, ok := utils.ListValues(get("creators")); ok {
+		for _, a := range authors {
+			if c, ok := creator(a); ok {
+				ds.Creators = append(ds.Creators, c)
+			}
+		}
+	} else if c, ok := creator(get("creators")); ok {
+		ds.Creators = append(ds.Creators, c)
+	}
+	if ds.Publisher == "" {
+		ds.Publisher = m.Publisher
+	}
+	if ds.Year == 0 {
+		ds.Year = year(ds.Date)
+	}
+	if ds.Title == "" {
+		ds.Title = ds.ID
+	}
+	if m.LandingURL != "" && ds.ID != "" {
+		ds.URL = strings.ReplaceAll(m.LandingURL, "{id}", url.PathEscape(ds.ID))
+	}
+	return ds
+}
+
+// DOIURL returns resolver URL of dataset DOI
+func (d Dataset) DOIURL() string {
+	if d.DOI == "" {
+		return ""
+	}
+	return "https://doi.org/" + d.DOI
+}
+
+// Exporter defines interface of metadata exporters
+type Exporter interface {
+	// ContentType returns content type of exported records
+	ContentType() string
+	// Export converts dataset into export format
+	Export(ds Dataset) ([]byte, error)
+}
+
+// Exporters represents registry of exporters keyed by format name
+var Exporters = map[string]Exporter{
+	"datacite": DataCiteExporter{},
+	"oai_dc":   DublinCoreExporter{},
+	"jsonld":   JSONLDExporter{},
+}
+
+// Formats returns sorted names of registered export formats
+func Formats() []string {
+	var out []string
+	for name := range Exporters {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Export converts metadata record into given format and returns exported
+// data with its content type
+func (m *Mapper) Export(format string, rec map[string]any) ([]byte, string, error) {
+	exp, ok := Exporters[format]
+	if !ok {
+		return nil, "", fmt.Errorf("%w '%s'", ErrUnknownFormat, format)
+	}
+	data, err := exp.Export(m.Dataset(rec))
+	if err != nil {
+		return nil, "", err
+	}
+	return data, exp.ContentType(), nil
+}
+
+// Export converts metadata record into given format using global mapper
+func Export(format string, rec map[string]any) ([]byte, string, error) {
+	return DefaultMapper.Export(format, rec)
+}
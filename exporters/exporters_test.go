@@ -0,0 +1,165 @@
+package exporters
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"strings"
+	"testing"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	bson "go.mongodb.org/mongo-driver/bson"
+)
+
+// helper function to provide test mapper and record
+func testData() (*Mapper, map[string]any) {
+	cfg := srvConfig.Export{
+		Publisher:  "CHESS",
+		LandingURL: "https://foxden/dataset/{id}",
+		Mappings: map[string]map[string]string{
+			"default": {"title": "description"},
+			"id3a":    {"subjects": "sample.keywords"},
+		},
+	}
+	rec := map[string]any{
+		"did":         "/beamline=3a/btr=test-123",
+		"schema":      "ID3A",
+		"description": "Powder diffraction of Ni & Co",
+		"doi":         "10.80000/abcd",
+		"date":        "2024-05-01",
+		"authors": []any{
+			map[string]any{"given_name": "John", "family_name": "Doe", "orcid": "0000-0002-1825-0097", "affiliations": []any{"Cornell"}},
+			"Jane Roe",
+		},
+		"sample": map[string]any{"keywords": []any{"diffraction", "nickel"}},
+	}
+	return NewMapper(cfg), rec
+}
+
+// TestDataset
+func TestDataset(t *testing.T) {
+	m, rec := testData()
+	ds := m.Dataset(rec)
+	if ds.Title != "Powder diffraction of Ni & Co" || ds.Year != 2024 || ds.Publisher != "CHESS" {
+		t.Errorf("invalid dataset %+v", ds)
+	}
+	if len(ds.Subjects) != 2 || ds.Subjects[1] != "nickel" {
+		t.Errorf("schema mapping is not applied %+v", ds.Subjects)
+	}
+	if len(ds.Creators) != 2 || ds.Creators[0].Name != "Doe, John" || ds.Creators[0].ORCID == "" {
+		t.Errorf("invalid creators %+v", ds.Creators)
+	}
+	if ds.URL != "https://foxden/dataset/%2Fbeamline=3a%2Fbtr=test-123" {
+		t.Errorf("invalid URL %s", ds.URL)
+	}
+	// other schemas do not use ID3A mapping
+	rec["schema"] = "ID4B"
+	if ds := m.Dataset(rec); len(ds.Subjects) != 0 {
+		t.Errorf("invalid subjects of other schema %+v", ds.Subjects)
+	}
+}
+
+// TestDatasetBSON
+func TestDatasetBSON(t *testing.T) {
+	m, rec := testData()
+	// MongoDB decodes arrays of records as primitive.A
+	data, err := bson.Marshal(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mrec map[string]any
+	if err := bson.Unmarshal(data, &mrec); err != nil {
+		t.Fatal(err)
+	}
+	ds := m.Dataset(mrec)
+	if len(ds.Subjects) != 2 || ds.Subjects[1] != "nickel" {
+		t.Errorf("invalid subjects %+v", ds.Subjects)
+	}
+	if len(ds.Creators) != 2 || ds.Creators[0].Name != "Doe, John" || len(ds.Creators[0].Affiliations) != 1 || ds.Creators[1].Name != "Jane Roe" {
+		t.Errorf("invalid creators %+v", ds.Creators)
+	}
+}
+
+// TestDataCiteExporter
+func TestDataCiteExporter(t *testing.T) {
+	m, rec := testData()
+	data, ctype, err := m.Export("datacite", rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ctype != "application/xml" {
+		t.Error("invalid content type", ctype)
+	}
+	var res struct {
+		XMLName    xml.Name
+		Identifier string   `xml:"identifier"`
+		Creators   []string `xml:"creators>creator>creatorName"`
+		Title      string   `xml:"titles>title"`
+		Year       int      `xml:"publicationYear"`
+	}
+	if err := xml.Unmarshal(data, &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.XMLName.Space != DataCiteNamespace || res.XMLName.Local != "resource" {
+		t.Errorf("invalid root element %+v", res.XMLName)
+	}
+	if res.Identifier != "10.80000/abcd" || len(res.Creators) != 2 || res.Title != "Powder diffraction of Ni & Co" || res.Year != 2024 {
+		t.Errorf("invalid DataCite record %s", data)
+	}
+}
+
+// TestDublinCoreExporter
+func TestDublinCoreExporter(t *testing.T) {
+	m, rec := testData()
+	data, _, err := m.Export("oai_dc", rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dc struct {
+		XMLName     xml.Name
+		Titles      []string `xml:"http://purl.org/dc/elements/1.1/ title"`
+		Creators    []string `xml:"http://purl.org/dc/elements/1.1/ creator"`
+		Identifiers []string `xml:"http://purl.org/dc/elements/1.1/ identifier"`
+	}
+	if err := xml.Unmarshal(data, &dc); err != nil {
+		t.Fatal(err)
+	}
+	if dc.XMLName.Space != OAIDCNamespace || dc.XMLName.Local != "dc" {
+		t.Errorf("invalid root element %+v", dc.XMLName)
+	}
+	if len(dc.Titles) != 1 || len(dc.Creators) != 2 || len(dc.Identifiers) != 2 || dc.Identifiers[0] != "https://doi.org/10.80000/abcd" {
+		t.Errorf("invalid Dublin Core record %s", data)
+	}
+}
+
+// TestJSONLDExporter
+func TestJSONLDExporter(t *testing.T) {
+	m, rec := testData()
+	data, ctype, err := m.Export("jsonld", rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ctype != "application/ld+json" {
+		t.Error("invalid content type", ctype)
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc["@type"] != "Dataset" || doc["@id"] != "https://doi.org/10.80000/abcd" || doc["datePublished"] != "2024-05-01" {
+		t.Errorf("invalid JSON-LD record %s", data)
+	}
+	creators, _ := doc["creator"].([]any)
+	if len(creators) != 2 {
+		t.Fatalf("invalid creators %s", data)
+	}
+	if c := creators[0].(map[string]any); c["sameAs"] != "https://orcid.org/0000-0002-1825-0097" {
+		t.Errorf("invalid creator %+v", c)
+	}
+	if _, _, err := m.Export("marc", rec); !errors.Is(err, ErrUnknownFormat) {
+		t.Error("expect unknown format error, got", err)
+	}
+	if strings.Join(Formats(), ",") != "datacite,jsonld,oai_dc" {
+		t.Error("invalid formats", Formats())
+	}
+}
@@ -0,0 +1,269 @@
+package exporters
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+)
+
+// XML namespaces of export formats
+const (
+	DataCiteNamespace = "http://datacite.org/schema/kernel-4"
+	DataCiteSchema    = "http://schema.datacite.org/meta/kernel-4/metadata.xsd"
+	OAIDCNamespace    = "http://www.openarchives.org/OAI/2.0/oai_dc/"
+	OAIDCSchema       = "http://www.openarchives.org/OAI/2.0/oai_dc.xsd"
+	DCNamespace       = "http://purl.org/dc/elements/1.1/"
+	XSINamespace      = "http://www.w3.org/2001/XMLSchema-instance"
+)
+
+// helper function to marshal XML document with header
+func marshalXML(v any) ([]byte, error) {
+	data, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
+
+// DataCiteExporter exports datasets into DataCite metadata kernel 4 XML
+type DataCiteExporter struct{}
+
+// dataCite XML elements
+type dcIdentifier struct {
+	Type  string `xml:"identifierType,attr"`
+	Value string `xml:",chardata"`
+}
+type dcNameIdentifier struct {
+	Scheme    string `xml:"nameIdentifierScheme,attr"`
+	SchemeURI string `xml:"schemeURI,attr"`
+	Value     string `xml:",chardata"`
+}
+type dcCreator struct {
+	Name           string            `xml:"creatorName"`
+	GivenName      string            `xml:"givenName,omitempty"`
+	FamilyName     string            `xml:"familyName,omitempty"`
+	NameIdentifier *dcNameIdentifier `xml:"nameIdentifier,omitempty"`
+	Affiliations   []string          `xml:"affiliation,omitempty"`
+}
+type dcResourceType struct {
+	General string `xml:"resourceTypeGeneral,attr"`
+	Value   string `xml:",chardata"`
+}
+type dcDescription struct {
+	Type  string `xml:"descriptionType,attr"`
+	Value string `xml:",chardata"`
+}
+type dcRights struct {
+	Value string `xml:",chardata"`
+}
+type dcSubjects struct {
+	Subjects []string `xml:"subject"`
+}
+type dcAlternateIDs struct {
+	Identifiers []dcIdentifier `xml:"alternateIdentifier"`
+}
+type dcRightsList struct {
+	Rights []dcRights `xml:"rights"`
+}
+type dcDescriptions struct {
+	Descriptions []dcDescription `xml:"description"`
+}
+type dataCiteResource struct {
+	XMLName         xml.Name        `xml:"resource"`
+	Namespace       string          `xml:"xmlns,attr"`
+	XSI             string          `xml:"xmlns:xsi,attr"`
+	SchemaLocation  string          `xml:"xsi:schemaLocation,attr"`
+	Identifier      dcIdentifier    `xml:"identifier"`
+	Creators        []dcCreator     `xml:"creators>creator"`
+	Titles          []string        `xml:"titles>title"`
+	Publisher       string          `xml:"publisher"`
+	PublicationYear int             `xml:"publicationYear"`
+	ResourceType    dcResourceType  `xml:"resourceType"`
+	Subjects        *dcSubjects     `xml:"subjects,omitempty"`
+	AlternateIDs    *dcAlternateIDs `xml:"alternateIdentifiers,omitempty"`
+	Version         string          `xml:"version,omitempty"`
+	RightsList      *dcRightsList   `xml:"rightsList,omitempty"`
+	Descriptions    *dcDescriptions `xml:"descriptions,omitempty"`
+}
+
+// ContentType implements Exporter interface
+func (e DataCiteExporter) ContentType() string {
+	return "application/xml"
+}
+
+// Export implements Exporter interface
+func (e DataCiteExporter) Export(ds Dataset) ([]byte, error) {
+	res := dataCiteResource{
+		Namespace:       DataCiteNamespace,
+		XSI:             XSINamespace,
+		SchemaLocation:  DataCiteNamespace + " " + DataCiteSchema,
+		Identifier:      dcIdentifier{Type: "DOI", Value: ds.DOI},
+		Titles:          []string{ds.Title},
+		Publisher:       ds.Publisher,
+		PublicationYear: ds.Year,
+		ResourceType:    dcResourceType{General: "Dataset", Value: "Dataset"},
+		Version:         ds.Version,
+	}
+	if len(ds.Subjects) > 0 {
+		res.Subjects = &dcSubjects{Subjects: ds.Subjects}
+	}
+	if ds.DOI == "" {
+		// DataCite schema requires identifier, datasets without DOI are
+		// identified by their landing page
+		res.Identifier = dcIdentifier{Type: "URL", Value: ds.URL}
+	}
+	if ds.ID != "" {
+		res.AlternateIDs = &dcAlternateIDs{Identifiers: []dcIdentifier{{Type: "Local", Value: ds.ID}}}
+	}
+	for _, c := range ds.Creators {
+		cr := dcCreator{Name: c.Name, GivenName: c.GivenName, FamilyName: c.FamilyName, Affiliations: c.Affiliations}
+		if c.ORCID != "" {
+			cr.NameIdentifier = &dcNameIdentifier{Scheme: "ORCID", SchemeURI: "https://orcid.org", Value: c.ORCID}
+		}
+		res.Creators = append(res.Creators, cr)
+	}
+	if ds.License != "" {
+		res.RightsList = &dcRightsList{Rights: []dcRights{{Value: ds.License}}}
+	}
+	if ds.Description != "" {
+		res.Descriptions = &dcDescriptions{Descriptions: []dcDescription{{Type: "Abstract", Value: ds.Description}}}
+	}
+	return marshalXML(res)
+}
+
+// DublinCoreExporter exports datasets into OAI Dublin Core XML
+type DublinCoreExporter struct{}
+
+// oaiDC represents oai_dc:dc element
+type oaiDC struct {
+	XMLName        xml.Name `xml:"oai_dc:dc"`
+	OAIDC          string   `xml:"xmlns:oai_dc,attr"`
+	DC             string   `xml:"xmlns:dc,attr"`
+	XSI            string   `xml:"xmlns:xsi,attr"`
+	SchemaLocation string   `xml:"xsi:schemaLocation,attr"`
+	Titles         []string `xml:"dc:title"`
+	Creators       []string `xml:"dc:creator"`
+	Subjects       []string `xml:"dc:subject"`
+	Descriptions   []string `xml:"dc:description"`
+	Publishers     []string `xml:"dc:publisher"`
+	Dates          []string `xml:"dc:date"`
+	Types          []string `xml:"dc:type"`
+	Identifiers    []string `xml:"dc:identifier"`
+	Rights         []string `xml:"dc:rights"`
+}
+
+// ContentType implements Exporter interface
+func (e DublinCoreExporter) ContentType() string {
+	return "application/xml"
+}
+
+// helper function to provide list of non-empty values
+func nonEmpty(values ...string) []string {
+	var out []string
+	for _, v := range values {
+		if v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// Export implements Exporter interface
+func (e DublinCoreExporter) Export(ds Dataset) ([]byte, error) {
+	date := ds.Date
+	if date == "" && ds.Year > 0 {
+		date = fmt.Sprintf("%d", ds.Year)
+	}
+	dc := oaiDC{
+		OAIDC:          OAIDCNamespace,
+		DC:             DCNamespace,
+		XSI:            XSINamespace,
+		SchemaLocation: OAIDCNamespace + " " + OAIDCSchema,
+		Titles:         nonEmpty(ds.Title),
+		Subjects:       ds.Subjects,
+		Descriptions:   nonEmpty(ds.Description),
+		Publishers:     nonEmpty(ds.Publisher),
+		Dates:          nonEmpty(date),
+		Types:          []string{"Dataset"},
+		Identifiers:    nonEmpty(ds.DOIURL(), ds.URL),
+		Rights:         nonEmpty(ds.License),
+	}
+	for _, c := range ds.Creators {
+		dc.Creators = append(dc.Creators, c.Name)
+	}
+	return marshalXML(dc)
+}
+
+// JSONLDExporter exports datasets into schema.org Dataset JSON-LD used by
+// dataset search engines
+type JSONLDExporter struct{}
+
+// ContentType implements Exporter interface
+func (e JSONLDExporter) ContentType() string {
+	return "application/ld+json"
+}
+
+// Export implements Exporter interface
+func (e JSONLDExporter) Export(ds Dataset) ([]byte, error) {
+	doc := map[string]any{
+		"@context": "https://schema.org/",
+		"@type":    "Dataset",
+		"name":     ds.Title,
+	}
+	// search engines require description of the dataset
+	doc["description"] = ds.Description
+	if ds.Description == "" {
+		doc["description"] = ds.Title
+	}
+	if ds.DOIURL() != "" {
+		doc["@id"] = ds.DOIURL()
+		doc["identifier"] = ds.DOIURL()
+	} else if ds.ID != "" {
+		doc["identifier"] = ds.ID
+	}
+	if ds.URL != "" {
+		doc["url"] = ds.URL
+	}
+	if len(ds.Subjects) > 0 {
+		doc["keywords"] = ds.Subjects
+	}
+	var creators []map[string]any
+	for _, c := range ds.Creators {
+		person := map[string]any{"@type": "Person", "name": c.Name}
+		if c.GivenName != "" {
+			person["givenName"] = c.GivenName
+		}
+		if c.FamilyName != "" {
+			person["familyName"] = c.FamilyName
+		}
+		if c.ORCID != "" {
+			person["sameAs"] = "https://orcid.org/" + c.ORCID
+		}
+		var orgs []map[string]any
+		for _, a := range c.Affiliations {
+			orgs = append(orgs, map[string]any{"@type": "Organization", "name": a})
+		}
+		if len(orgs) > 0 {
+			person["affiliation"] = orgs
+		}
+		creators = append(creators, person)
+	}
+	if len(creators) > 0 {
+		doc["creator"] = creators
+	}
+	if ds.Publisher != "" {
+		doc["publisher"] = map[string]any{"@type": "Organization", "name": ds.Publisher}
+	}
+	if ds.Date != "" {
+		doc["datePublished"] = ds.Date
+	} else if ds.Year > 0 {
+		doc["datePublished"] = fmt.Sprintf("%d", ds.Year)
+	}
+	if ds.Version != "" {
+		doc["version"] = ds.Version
+	}
+	if ds.License != "" {
+		doc["license"] = ds.License
+	}
+	return json.MarshalIndent(doc, "", "  ")
+}
@@ -0,0 +1,176 @@
+package extractors
+
+// extractors module defines interface of metadata extractors and registry
+// which finds appropriate extractor of a file by its extension or magic bytes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Extractor defines interface to extract metadata attributes from files
+type Extractor interface {
+	Extract(fname string) (map[string]any, error)
+}
+
+// ExtractorFunc allows to use ordinary functions as extractors
+type ExtractorFunc func(fname string) (map[string]any, error)
+
+// Extract implements Extractor interface
+func (f ExtractorFunc) Extract(fname string) (map[string]any, error) {
+	return f(fname)
+}
+
+// ErrNoExtractor is returned when no extractor is registered for a file
+var ErrNoExtractor = errors.New("no extractor found")
+
+// Timeout defines timeout of external commands used by extractors
+var Timeout = 60 * time.Second
+
+// MagicSize defines number of bytes read from a file to match magic bytes
+const MagicSize = 512
+
+// Entry represents registry entry
+type Entry struct {
+	Name       string    // name of extractor
+	Extensions []string  // list of file extensions, e.g. .h5
+	Magic      [][]byte  // list of magic bytes at the beginning of a file
+	Extractor  Extractor // extractor implementation
+}
+
+// registry of extractors
+var (
+	registry []Entry
+	regMutex sync.RWMutex
+)
+
+// Register registers extractor with given name, file extensions and magic
+// bytes. The extractor registered later with the same name replaces the
+// previous one.
+func Register(name string, exts []string, magic [][]byte, e Extractor) {
+	regMutex.Lock()
+	defer regMutex.Unlock()
+	var lexts []string
+	for _, ext := range exts {
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		lexts = append(lexts, strings.ToLower(ext))
+	}
+	entry := Entry{Name: name, Extensions: lexts, Magic: magic, Extractor: e}
+	for i, r := range registry {
+		if r.Name == name {
+			registry[i] = entry
+			return
+		}
+	}
+	registry = append(registry, entry)
+}
+
+// Names returns names of registered extractors
+func Names() []string {
+	regMutex.RLock()
+	defer regMutex.RUnlock()
+	var names []string
+	for _, r := range registry {
+		names = append(names, r.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Lookup returns extractor of given file. The extractor is looked up by file
+// extension first and then by magic bytes of the file.
+func Lookup(fname string) (Extractor, error) {
+	regMutex.RLock()
+	defer regMutex.RUnlock()
+	ext := strings.ToLower(filepath.Ext(fname))
+	for _, r := range registry {
+		for _, e := range r.Extensions {
+			if e == ext {
+				return r.Extractor, nil
+			}
+		}
+	}
+	file, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	header := make([]byte, MagicSize)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	header = header[:n]
+	for _, r := range registry {
+		for _, magic := range r.Magic {
+			if len(magic) > 0 && bytes.HasPrefix(header, magic) {
+				return r.Extractor, nil
+			}
+		}
+	}
+	return nil, ErrNoExtractor
+}
+
+// Extract extracts attributes of given file using registered extractors
+func Extract(fname string) (map[string]any, error) {
+	e, err := Lookup(fname)
+	if err != nil {
+		return nil, err
+	}
+	return e.Extract(fname)
+}
+
+// CommandExtractor runs external command which prints JSON object with
+// file attributes, it allows facilities to provide extractors written in
+// any language. The file name is passed as the last command argument.
+type CommandExtractor struct {
+	Command []string
+}
+
+// Extract implements Extractor interface
+func (c CommandExtractor) Extract(fname string) (map[string]any, error) {
+	if len(c.Command) == 0 {
+		return nil, errors.New("empty extractor command")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+	args := append(append([]string{}, c.Command[1:]...), fname)
+	cmd := exec.CommandContext(ctx, c.Command[0], args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("extractor command %v failed, error %v, stderr %s", c.Command, err, stderr.String())
+	}
+	attrs := make(map[string]any)
+	if err := json.Unmarshal(out, &attrs); err != nil {
+		return nil, fmt.Errorf("extractor command %v provided invalid JSON, error %v", c.Command, err)
+	}
+	return attrs, nil
+}
+
+// RegisterCommand registers external command extractor for given file
+// extensions, e.g. RegisterCommand("cbf", []string{".cbf"}, "cbf2json --header")
+func RegisterCommand(name string, exts []string, command string) {
+	Register(name, exts, nil, CommandExtractor{Command: strings.Fields(command)})
+}
+
+func init() {
+	Register("hdf5", []string{".h5", ".hdf5", ".hdf"}, [][]byte{hdf5Signature}, ExtractorFunc(ExtractHDF5))
+	Register("nexus", []string{".nxs", ".nx5", ".nx"}, nil, ExtractorFunc(ExtractNeXus))
+	Register("tiff", []string{".tif", ".tiff"}, [][]byte{[]byte("II*\x00"), []byte("MM\x00*")}, ExtractorFunc(ExtractTIFF))
+	Register("spec", []string{".spec"}, [][]byte{[]byte("#F ")}, ExtractorFunc(ExtractSPEC))
+}
@@ -0,0 +1,163 @@
+package extractors
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestLookup
+func TestLookup(t *testing.T) {
+	dir := t.TempDir()
+	fname := filepath.Join(dir, "scan.dat")
+	os.WriteFile(fname, append(append([]byte{}, hdf5Signature...), 0, 0, 0, 0, 0, 8, 8, 0), 0644)
+	if _, err := Lookup(fname); err != nil {
+		t.Errorf("HDF5 file should be found by magic bytes, error %v", err)
+	}
+	RegisterCommand("json", []string{"json"}, "sh -c 'cat $0'")
+	fname = filepath.Join(dir, "attrs.json")
+	os.WriteFile(fname, []byte(`{"energy": 42}`), 0644)
+	attrs, err := Extract(fname)
+	if err == nil && attrs["energy"] != 42.0 {
+		t.Errorf("wrong command extractor attributes %v", attrs)
+	}
+	if _, err := Lookup(filepath.Join(dir, "unknown.txt")); err == nil {
+		t.Error("lookup of unknown file should fail")
+	}
+}
+
+// TestHDF5
+func TestHDF5(t *testing.T) {
+	// superblock version 2 with 8 bytes offsets and lengths after user block
+	data := make([]byte, 512)
+	data = append(data, hdf5Signature...)
+	data = append(data, 2, 8, 8, 0, 0, 0, 0, 0)
+	sb, err := ReadHDF5Superblock(bytes.NewReader(data), int64(len(data)))
+	if err != nil || sb.Version != 2 || sb.OffsetSize != 8 || sb.UserBlock != 512 {
+		t.Errorf("wrong superblock %+v, error %v", sb, err)
+	}
+	dump := `HDF5 "scan.nxs" {
+GROUP "/" {
+   ATTRIBUTE "NX_class" {
+      DATATYPE  H5T_STRING {
+         STRSIZE 6;
+      }
+      DATASPACE  SCALAR
+      DATA {
+      (0): "NXroot"
+      }
+   }
+   GROUP "entry" {
+      ATTRIBUTE "NX_class" {
+         DATATYPE  H5T_STRING {
+            STRSIZE 7;
+         }
+         DATASPACE  SCALAR
+         DATA {
+         (0): "NXentry"
+         }
+      }
+      DATASET "energy" {
+         DATATYPE  H5T_IEEE_F64LE
+         DATASPACE  SIMPLE { ( 3 ) / ( 3 ) }
+         ATTRIBUTE "units" {
+            DATATYPE  H5T_STRING {
+               STRSIZE 3;
+            }
+            DATASPACE  SCALAR
+            DATA {
+            (0): "keV"
+            }
+         }
+         ATTRIBUTE "range" {
+            DATATYPE  H5T_STD_I64LE
+            DATASPACE  SIMPLE { ( 2 ) / ( 2 ) }
+            DATA {
+            (0): 10, 20
+            }
+         }
+      }
+   }
+}
+}`
+	attrs, err := ParseH5Dump(strings.NewReader(dump))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs["@NX_class"] != "NXroot" || attrs["entry@NX_class"] != "NXentry" || attrs["entry/energy@units"] != "keV" {
+		t.Errorf("wrong HDF5 attributes %v", attrs)
+	}
+	if vals, ok := attrs["entry/energy@range"].([]any); !ok || len(vals) != 2 || vals[1] != int64(20) {
+		t.Errorf("wrong HDF5 list attribute %v", attrs["entry/energy@range"])
+	}
+}
+
+// TestTIFF
+func TestTIFF(t *testing.T) {
+	// little endian TIFF with single IFD of 3 entries
+	var buf bytes.Buffer
+	order := binary.LittleEndian
+	buf.WriteString("II")
+	binary.Write(&buf, order, uint16(42))
+	binary.Write(&buf, order, uint32(8))
+	binary.Write(&buf, order, uint16(3))
+	entry := func(tag, ftype uint16, count, value uint32) {
+		binary.Write(&buf, order, tag)
+		binary.Write(&buf, order, ftype)
+		binary.Write(&buf, order, count)
+		binary.Write(&buf, order, value)
+	}
+	entry(256, 3, 1, 2048)
+	entry(257, 4, 1, 1024)
+	entry(271, 2, 8, 8+2+3*12+4)
+	binary.Write(&buf, order, uint32(0))
+	buf.WriteString("Dectris\x00")
+	attrs, err := ReadTIFF(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs["image_width"] != int64(2048) || attrs["image_length"] != int64(1024) ||
+		attrs["make"] != "Dectris" || attrs["frames"] != 1 {
+		t.Errorf("wrong TIFF attributes %v", attrs)
+	}
+}
+
+// TestSPEC
+func TestSPEC(t *testing.T) {
+	spec := `#F /nfs/chess/id3a/scan.spec
+#E 1700000000
+#D Tue Nov 14 17:13:20 2023
+#C id3a  User = chess
+#O0 samx  samy  sample z
+
+#S 1  ascan  samx 0 1 2 0.1
+#D Tue Nov 14 17:14:00 2023
+#L samx  Detector  Seconds
+0 10 0.1
+1 12 0.1
+2 11 0.1
+
+#S 2  timescan 0.5
+#L Time  Detector
+0 1
+`
+	fname := filepath.Join(t.TempDir(), "scan.spec")
+	os.WriteFile(fname, []byte(spec), 0644)
+	attrs, err := Extract(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scans, ok := attrs["scans"].([]SpecScan)
+	if !ok || len(scans) != 2 || scans[0].Points != 3 || scans[0].Command != "ascan  samx 0 1 2 0.1" || scans[1].Points != 1 {
+		t.Errorf("wrong SPEC scans %+v", attrs["scans"])
+	}
+	if motors := attrs["motors"].([]string); len(motors) != 3 || motors[2] != "sample z" {
+		t.Errorf("wrong SPEC motors %v", motors)
+	}
+	if attrs["epoch"] != int64(1700000000) {
+		t.Errorf("wrong SPEC epoch %v", attrs["epoch"])
+	}
+}
@@ -0,0 +1,240 @@
+package extractors
+
+// hdf5 module provides HDF5 and NeXus extractors. The superblock is parsed
+// natively while attributes are obtained from h5dump tool (if available)
+// since HDF5 object headers are not practical to decode without libhdf5.
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// hdf5Signature represents HDF5 format signature
+var hdf5Signature = []byte("\x89HDF\r\n\x1a\n")
+
+// H5DumpCommand defines h5dump executable used to read HDF5 attributes
+var H5DumpCommand = "h5dump"
+
+// HDF5Superblock represents information from HDF5 superblock
+type HDF5Superblock struct {
+	Version    int   // superblock version
+	OffsetSize int   // size of file offsets in bytes
+	LengthSize int   // size of lengths in bytes
+	UserBlock  int64 // size of user block
+}
+
+// ReadHDF5Superblock reads HDF5 superblock, the signature may be located
+// at offsets 0, 512, 1024, 2048 and so on
+func ReadHDF5Superblock(r io.ReaderAt, size int64) (HDF5Superblock, error) {
+	var sb HDF5Superblock
+	buf := make([]byte, 16)
+	for offset := int64(0); offset+16 <= size; {
+		if _, err := r.ReadAt(buf, offset); err != nil {
+			return sb, err
+		}
+		if bytes.Equal(buf[:8], hdf5Signature) {
+			sb.UserBlock = offset
+			sb.Version = int(buf[8])
+			switch sb.Version {
+			case 0, 1:
+				sb.OffsetSize = int(buf[13])
+				sb.LengthSize = int(buf[14])
+			case 2, 3:
+				sb.OffsetSize = int(buf[9])
+				sb.LengthSize = int(buf[10])
+			default:
+				return sb, fmt.Errorf("unsupported HDF5 superblock version %d", sb.Version)
+			}
+			return sb, nil
+		}
+		if offset == 0 {
+			offset = 512
+		} else {
+			offset *= 2
+		}
+	}
+	return sb, errors.New("not HDF5 file")
+}
+
+// ExtractHDF5 extracts HDF5 superblock information and attributes of all
+// groups and datasets. Attribute keys have <path>@<attribute> form, e.g.
+// entry/instrument@NX_class, root attributes have @<attribute> form.
+func ExtractHDF5(fname string) (map[string]any, error) {
+	file, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	sb, err := ReadHDF5Superblock(file, info.Size())
+	if err != nil {
+		return nil, err
+	}
+	attrs := map[string]any{
+		"format":                 "HDF5",
+		"hdf5_superblock":        sb.Version,
+		"hdf5_offset_size":       sb.OffsetSize,
+		"hdf5_length_size":       sb.LengthSize,
+		"hdf5_userblock_size":    sb.UserBlock,
+		"hdf5_attributes_parsed": false,
+	}
+	if _, err := exec.LookPath(H5DumpCommand); err != nil {
+		// h5dump is not available, provide superblock information only
+		return attrs, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, H5DumpCommand, "-A", fname).Output()
+	if err != nil {
+		return attrs, fmt.Errorf("%s failed for %s, error %v", H5DumpCommand, fname, err)
+	}
+	dump, err := ParseH5Dump(bytes.NewReader(out))
+	if err != nil {
+		return attrs, err
+	}
+	for k, v := range dump {
+		attrs[k] = v
+	}
+	attrs["hdf5_attributes_parsed"] = true
+	return attrs, nil
+}
+
+// ExtractNeXus extracts HDF5 attributes and NeXus classes of the file, the
+// NeXus classes are provided as nexus_classes map of path to NX_class value
+func ExtractNeXus(fname string) (map[string]any, error) {
+	attrs, err := ExtractHDF5(fname)
+	if err != nil {
+		return attrs, err
+	}
+	classes := make(map[string]any)
+	for k, v := range attrs {
+		if strings.HasSuffix(k, "@NX_class") {
+			classes[strings.TrimSuffix(k, "@NX_class")] = v
+		}
+	}
+	attrs["format"] = "NeXus"
+	attrs["nexus_classes"] = classes
+	return attrs, nil
+}
+
+// patterns of h5dump DDL output
+var (
+	patternBlock = regexp.MustCompile(`^(GROUP|DATASET|ATTRIBUTE) "(.*)" \{$`)
+	patternIndex = regexp.MustCompile(`^\(\d+(,\d+)*\):\s*`)
+)
+
+// ParseH5Dump parses DDL output of 'h5dump -A' command and returns map of
+// attributes
+func ParseH5Dump(r io.Reader) (map[string]any, error) {
+	attrs := make(map[string]any)
+	type block struct {
+		kind string
+		name string
+	}
+	var stack []block
+	var values []string
+	var inData bool
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 1024*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if inData {
+			if line == "}" {
+				inData = false
+				stack = stack[:len(stack)-1]
+				continue
+			}
+			values = append(values, splitValues(patternIndex.ReplaceAllString(line, ""))...)
+			continue
+		}
+		if m := patternBlock.FindStringSubmatch(line); m != nil {
+			stack = append(stack, block{kind: m[1], name: m[2]})
+			values = nil
+			continue
+		}
+		if line == "DATA {" {
+			inData = true
+			stack = append(stack, block{kind: "DATA"})
+			continue
+		}
+		if strings.HasSuffix(line, "{") {
+			stack = append(stack, block{kind: "OTHER"})
+			continue
+		}
+		if line == "}" {
+			if len(stack) == 0 {
+				continue
+			}
+			last := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if last.kind == "ATTRIBUTE" {
+				var path []string
+				for _, b := range stack {
+					if (b.kind == "GROUP" || b.kind == "DATASET") && b.name != "/" {
+						path = append(path, b.name)
+					}
+				}
+				attrs[strings.Join(path, "/")+"@"+last.name] = attributeValue(values)
+				values = nil
+			}
+		}
+	}
+	return attrs, scanner.Err()
+}
+
+// helper function to split comma separated values respecting quotes
+func splitValues(line string) []string {
+	var out []string
+	var cur strings.Builder
+	var quoted bool
+	for _, r := range line {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			cur.WriteRune(r)
+		case r == ',' && !quoted:
+			if s := strings.TrimSpace(cur.String()); s != "" {
+				out = append(out, s)
+			}
+			cur.Reset()
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	if s := strings.TrimSpace(cur.String()); s != "" {
+		out = append(out, s)
+	}
+	return out
+}
+
+// helper function to convert h5dump values into attribute value
+func attributeValue(values []string) any {
+	var out []any
+	for _, v := range values {
+		if s, err := strconv.Unquote(v); err == nil {
+			out = append(out, s)
+		} else if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+			out = append(out, i)
+		} else if f, err := strconv.ParseFloat(v, 64); err == nil {
+			out = append(out, f)
+		} else {
+			out = append(out, v)
+		}
+	}
+	if len(out) == 1 {
+		return out[0]
+	}
+	return out
+}
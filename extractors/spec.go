@@ -0,0 +1,100 @@
+package extractors
+
+// spec module provides extractor of SPEC data files
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// SpecScan represents single scan of SPEC file
+type SpecScan struct {
+	Number  int      `json:"number"`
+	Command string   `json:"command"`
+	Date    string   `json:"date,omitempty"`
+	Columns []string `json:"columns,omitempty"`
+	Points  int      `json:"points"`
+}
+
+// ExtractSPEC extracts file header and list of scans of SPEC file
+func ExtractSPEC(fname string) (map[string]any, error) {
+	file, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	attrs := map[string]any{"format": "SPEC"}
+	var scans []SpecScan
+	var motors []string
+	var comments []string
+	var scan *SpecScan
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 1024*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "#") {
+			if scan != nil {
+				scan.Points++
+			}
+			continue
+		}
+		key, val, _ := strings.Cut(line, " ")
+		val = strings.TrimSpace(val)
+		switch {
+		case key == "#S":
+			num, cmd, _ := strings.Cut(val, " ")
+			n, _ := strconv.Atoi(num)
+			scans = append(scans, SpecScan{Number: n, Command: strings.TrimSpace(cmd)})
+			scan = &scans[len(scans)-1]
+		case key == "#D":
+			if scan != nil {
+				scan.Date = val
+			} else {
+				attrs["date"] = val
+			}
+		case key == "#L":
+			if scan != nil {
+				scan.Columns = splitSpecColumns(val)
+			}
+		case key == "#F" && scan == nil:
+			attrs["file"] = val
+		case key == "#E" && scan == nil:
+			if epoch, err := strconv.ParseInt(val, 10, 64); err == nil {
+				attrs["epoch"] = epoch
+			}
+		case key == "#C" && scan == nil:
+			comments = append(comments, val)
+		case strings.HasPrefix(key, "#O") && scan == nil:
+			motors = append(motors, splitSpecColumns(val)...)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return attrs, err
+	}
+	attrs["scans"] = scans
+	attrs["nscans"] = len(scans)
+	if len(motors) > 0 {
+		attrs["motors"] = motors
+	}
+	if len(comments) > 0 {
+		attrs["comments"] = comments
+	}
+	return attrs, nil
+}
+
+// helper function to split SPEC column names which are separated by two
+// spaces since names may contain single spaces
+func splitSpecColumns(line string) []string {
+	var out []string
+	for _, col := range strings.Split(line, "  ") {
+		if col = strings.TrimSpace(col); col != "" {
+			out = append(out, col)
+		}
+	}
+	return out
+}
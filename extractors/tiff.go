@@ -0,0 +1,157 @@
+package extractors
+
+// tiff module provides TIFF extractor which reads tags of the first image
+// and number of images (frames) stored in the file
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+)
+
+// TIFF tags provided by the extractor
+var tiffTags = map[uint16]string{
+	256: "image_width",
+	257: "image_length",
+	258: "bits_per_sample",
+	259: "compression",
+	262: "photometric",
+	270: "image_description",
+	271: "make",
+	272: "model",
+	277: "samples_per_pixel",
+	305: "software",
+	306: "datetime",
+	315: "artist",
+	339: "sample_format",
+}
+
+// MaxTIFFFrames limits number of IFDs scanned in a TIFF file
+var MaxTIFFFrames = 100000
+
+// sizes of TIFF field types
+var tiffTypeSize = map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8}
+
+// ExtractTIFF extracts tags of the first image and number of frames
+func ExtractTIFF(fname string) (map[string]any, error) {
+	file, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadTIFF(file)
+}
+
+// ReadTIFF reads TIFF tags from given reader
+func ReadTIFF(r io.ReaderAt) (map[string]any, error) {
+	header := make([]byte, 8)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return nil, err
+	}
+	var order binary.ByteOrder
+	switch string(header[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, errors.New("not TIFF file")
+	}
+	if version := order.Uint16(header[2:4]); version != 42 {
+		return nil, fmt.Errorf("unsupported TIFF version %d", version)
+	}
+	attrs := map[string]any{"format": "TIFF"}
+	offset := int64(order.Uint32(header[4:8]))
+	seen := make(map[int64]bool)
+	frames := 0
+	for offset != 0 && frames < MaxTIFFFrames {
+		if seen[offset] {
+			return attrs, errors.New("TIFF IFD loop detected")
+		}
+		seen[offset] = true
+		buf := make([]byte, 2)
+		if _, err := r.ReadAt(buf, offset); err != nil {
+			return attrs, err
+		}
+		count := int64(order.Uint16(buf))
+		entries := make([]byte, count*12+4)
+		if _, err := r.ReadAt(entries, offset+2); err != nil {
+			return attrs, err
+		}
+		if frames == 0 {
+			for i := int64(0); i < count; i++ {
+				readTIFFEntry(r, order, entries[i*12:i*12+12], attrs)
+			}
+		}
+		frames++
+		offset = int64(order.Uint32(entries[count*12:]))
+	}
+	attrs["frames"] = frames
+	return attrs, nil
+}
+
+// helper function to read single IFD entry
+func readTIFFEntry(r io.ReaderAt, order binary.ByteOrder, entry []byte, attrs map[string]any) {
+	tag := order.Uint16(entry[0:2])
+	name, ok := tiffTags[tag]
+	if !ok {
+		return
+	}
+	ftype := order.Uint16(entry[2:4])
+	count := int(order.Uint32(entry[4:8]))
+	size, ok := tiffTypeSize[ftype]
+	if !ok || count <= 0 || count > 1024*1024 {
+		return
+	}
+	data := entry[8:12]
+	if size*count > 4 {
+		data = make([]byte, size*count)
+		if _, err := r.ReadAt(data, int64(order.Uint32(entry[8:12]))); err != nil {
+			return
+		}
+	}
+	var values []any
+	for i := 0; i < count; i++ {
+		v := data[i*size : (i+1)*size]
+		switch ftype {
+		case 2:
+			attrs[name] = strings.TrimRight(string(data[:count]), "\x00 ")
+			return
+		case 1, 7:
+			values = append(values, int64(v[0]))
+		case 6:
+			values = append(values, int64(int8(v[0])))
+		case 3:
+			values = append(values, int64(order.Uint16(v)))
+		case 8:
+			values = append(values, int64(int16(order.Uint16(v))))
+		case 4:
+			values = append(values, int64(order.Uint32(v)))
+		case 9:
+			values = append(values, int64(int32(order.Uint32(v))))
+		case 5:
+			den := order.Uint32(v[4:])
+			if den != 0 {
+				values = append(values, float64(order.Uint32(v[:4]))/float64(den))
+			}
+		case 10:
+			den := int32(order.Uint32(v[4:]))
+			if den != 0 {
+				values = append(values, float64(int32(order.Uint32(v[:4])))/float64(den))
+			}
+		case 11:
+			values = append(values, float64(math.Float32frombits(order.Uint32(v))))
+		case 12:
+			values = append(values, math.Float64frombits(order.Uint64(v)))
+		}
+	}
+	if len(values) == 1 {
+		attrs[name] = values[0]
+	} else if len(values) > 1 {
+		attrs[name] = values
+	}
+}
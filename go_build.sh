@@ -2,11 +2,9 @@
 
 set -e
 
+# build every package by its import path, nested packages (e.g. storage/s3)
+# can't be built by changing into the last element of the path
 for d in $(go list ./... | grep -v vendor); do
     echo "Building $d"
-    bdir=`echo $d | awk '{z=split($0,a,"/"); print a[z]}'`
-    echo "cd $bdir"
-    cd $bdir
-    go build
-    cd - 2>&1 1>& /dev/null
+    go build $d
 done
@@ -0,0 +1,522 @@
+package graphql
+
+// execute module executes parsed queries against schema of resolvers.
+// Object types declare fields with resolvers, other fields of objects are
+// taken from keys of resolved records, therefore schema-less metadata
+// records may be queried without declaring all their keys.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// QueryType defines name of root query type
+const QueryType = "Query"
+
+// default limits of queries
+var (
+	DefaultMaxDepth      = 10
+	DefaultMaxComplexity = 10000
+	DefaultListSize      = 100
+)
+
+// ResolveParams represents parameters of field resolver
+type ResolveParams struct {
+	Context context.Context // request context
+	Source  any             // parent object, nil for root query fields
+	Args    map[string]any  // field arguments with applied variables and defaults
+}
+
+// Resolver resolves value of the field
+type Resolver func(p ResolveParams) (any, error)
+
+// Argument represents argument of the field
+type Argument struct {
+	Type        string // GraphQL type, e.g. String! or Int
+	Default     any    // default value
+	Description string
+}
+
+// Field represents field of object type
+type Field struct {
+	Type        string              // GraphQL type, e.g. [Record] or JSON
+	Description string              // field description
+	Args        map[string]Argument // field arguments
+	Resolve     Resolver            // resolver, nil means value of parent record key
+}
+
+// Object represents object type with declared fields
+type Object struct {
+	Description string
+	Fields      map[string]Field
+}
+
+// Schema represents GraphQL schema, it should contain Query object type
+type Schema struct {
+	Types         map[string]Object // object types
+	MaxDepth      int               // maximum depth of selections
+	MaxComplexity int               // maximum complexity of the query
+}
+
+// Request represents GraphQL request
+type Request struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName,omitempty"`
+	Variables     map[string]any `json:"variables,omitempty"`
+}
+
+// Error represents GraphQL error
+type Error struct {
+	Message string `json:"message"`
+	Path    []any  `json:"path,omitempty"`
+}
+
+// Error implements error interface
+func (e Error) Error() string {
+	return e.Message
+}
+
+// Response represents GraphQL response
+type Response struct {
+	Data   any     `json:"data,omitempty"`
+	Errors []Error `json:"errors,omitempty"`
+}
+
+// ordered represents result object which keeps order of selected fields
+type ordered struct {
+	keys   []string
+	values map[string]any
+}
+
+// helper function to set value of ordered object
+func (o *ordered) set(key string, val any) {
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = val
+}
+
+// MarshalJSON implements json.Marshaler interface
+func (o *ordered) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, _ := json.Marshal(key)
+		v, err := json.Marshal(o.values[key])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// helper function to get name of object type of GraphQL type, e.g. Record
+// for [Record!]!
+func baseType(t string) string {
+	return strings.Trim(t, "[]!")
+}
+
+// helper function to check if GraphQL type is list
+func isList(t string) bool {
+	return strings.HasPrefix(t, "[")
+}
+
+// executor represents execution of single operation
+type executor struct {
+	schema    *Schema
+	fragments map[string][]Selection
+	variables map[string]any
+	errors    []Error
+}
+
+// Execute parses and executes GraphQL request, errors of parsing,
+// validation and limits are returned as response errors without data
+func (s *Schema) Execute(ctx context.Context, req Request) *Response {
+	doc, err := Parse(req.Query)
+	if err != nil {
+		return &Response{Errors: []Error{{Message: err.Error()}}}
+	}
+	op, err := selectOperation(doc, req.OperationName)
+	if err != nil {
+		return &Response{Errors: []Error{{Message: err.Error()}}}
+	}
+	e := &executor{schema: s, fragments: doc.Fragments}
+	if e.variables, err = coerceVariables(op.Variables, req.Variables); err != nil {
+		return &Response{Errors: []Error{{Message: err.Error()}}}
+	}
+	sels, err := e.expand(op.Selections, nil)
+	if err != nil {
+		return &Response{Errors: []Error{{Message: err.Error()}}}
+	}
+	if err := s.checkLimits(sels); err != nil {
+		return &Response{Errors: []Error{{Message: err.Error()}}}
+	}
+	data := e.object(ctx, QueryType, nil, sels, nil)
+	return &Response{Data: data, Errors: e.errors}
+}
+
+// helper function to select operation of the document
+func selectOperation(doc *Document, name string) (Operation, error) {
+	if name == "" {
+		if len(doc.Operations) > 1 {
+			return Operation{}, errors.New("operation name is required for document with multiple operations")
+		}
+		return doc.Operations[0], nil
+	}
+	for _, op := range doc.Operations {
+		if op.Name == name {
+			return op, nil
+		}
+	}
+	return Operation{}, fmt.Errorf("unknown operation %s", name)
+}
+
+// helper function to apply defaults of variables and check required ones
+func coerceVariables(defs []VariableDefinition, values map[string]any) (map[string]any, error) {
+	out := make(map[string]any)
+	for _, def := range defs {
+		val, ok := values[def.Name]
+		if !ok {
+			val = def.Default
+		}
+		if val == nil && strings.HasSuffix(def.Type, "!") {
+			return nil, fmt.Errorf("variable $%s of type %s is required", def.Name, def.Type)
+		}
+		out[def.Name] = val
+	}
+	return out, nil
+}
+
+// helper function to replace variables within argument value
+func (e *executor) resolveValue(val any) (any, error) {
+	switch v := val.(type) {
+	case Variable:
+		res, ok := e.variables[string(v)]
+		if !ok {
+			return nil, fmt.Errorf("variable $%s is not defined", v)
+		}
+		return res, nil
+	case Enum:
+		return string(v), nil
+	case []any:
+		out := make([]any, len(v))
+		for i, item := range v {
+			res, err := e.resolveValue(item)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = res
+		}
+		return out, nil
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, item := range v {
+			res, err := e.resolveValue(item)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = res
+		}
+		return out, nil
+	}
+	return val, nil
+}
+
+// helper function to evaluate @include and @skip directives
+func (e *executor) included(dirs []Directive) (bool, error) {
+	for _, d := range dirs {
+		if d.Name != "include" && d.Name != "skip" {
+			return false, fmt.Errorf("unsupported directive @%s", d.Name)
+		}
+		val, err := e.resolveValue(d.Args["if"])
+		if err != nil {
+			return false, err
+		}
+		flag, ok := val.(bool)
+		if !ok {
+			return false, fmt.Errorf("directive @%s requires boolean if argument", d.Name)
+		}
+		if d.Name == "include" && !flag || d.Name == "skip" && flag {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// helper function to expand fragments and directives of selections and to
+// apply variables to arguments
+func (e *executor) expand(sels []Selection, visiting []string) ([]Selection, error) {
+	var out []Selection
+	for _, sel := range sels {
+		ok, err := e.included(sel.Directives)
+		if err != nil || !ok {
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		switch {
+		case sel.Fragment != "":
+			for _, name := range visiting {
+				if name == sel.Fragment {
+					return nil, fmt.Errorf("fragment %s is used recursively", name)
+				}
+			}
+			frag, ok := e.fragments[sel.Fragment]
+			if !ok {
+				return nil, fmt.Errorf("unknown fragment %s", sel.Fragment)
+			}
+			fsels, err := e.expand(frag, append(visiting, sel.Fragment))
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, fsels...)
+		case sel.Inline:
+			isels, err := e.expand(sel.Selections, visiting)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, isels...)
+		default:
+			args := make(map[string]any, len(sel.Args))
+			for k, v := range sel.Args {
+				if args[k], err = e.resolveValue(v); err != nil {
+					return nil, err
+				}
+			}
+			sel.Args = args
+			sel.Directives = nil
+			if sel.Selections, err = e.expand(sel.Selections, visiting); err != nil {
+				return nil, err
+			}
+			out = append(out, sel)
+		}
+	}
+	return out, nil
+}
+
+// helper function to provide depth and complexity of selections of given
+// object type. Every field costs one point, complexity of sub-selections
+// of list fields is multiplied by limit argument or DefaultListSize.
+func (s *Schema) measure(typeName string, sels []Selection) (int, int) {
+	depth, complexity := 0, 0
+	for _, sel := range sels {
+		field := s.Types[typeName].Fields[sel.Name]
+		d, c := s.measure(baseType(field.Type), sel.Selections)
+		if len(sel.Selections) > 0 && isList(field.Type) {
+			size := DefaultListSize
+			if limit, ok := toInt(sel.Args["limit"]); ok && limit > 0 {
+				size = limit
+			}
+			c *= size
+		}
+		complexity += 1 + c
+		if d+1 > depth {
+			depth = d + 1
+		}
+	}
+	return depth, complexity
+}
+
+// helper function to check depth and complexity limits of the query
+func (s *Schema) checkLimits(sels []Selection) error {
+	maxDepth, maxComplexity := s.MaxDepth, s.MaxComplexity
+	if maxDepth <= 0 {
+		maxDepth = DefaultMaxDepth
+	}
+	if maxComplexity <= 0 {
+		maxComplexity = DefaultMaxComplexity
+	}
+	depth, complexity := s.measure(QueryType, sels)
+	if depth > maxDepth {
+		return fmt.Errorf("query depth %d exceeds limit %d", depth, maxDepth)
+	}
+	if complexity > maxComplexity {
+		return fmt.Errorf("query complexity %d exceeds limit %d", complexity, maxComplexity)
+	}
+	return nil
+}
+
+// helper function to convert numeric argument into int
+func toInt(val any) (int, bool) {
+	switch v := val.(type) {
+	case int:
+		return v, true
+	case int64:
+		return int(v), true
+	case float64:
+		return int(v), v == float64(int(v))
+	}
+	return 0, false
+}
+
+// helper function to apply argument defaults and check required arguments
+func fieldArgs(field Field, args map[string]any) (map[string]any, error) {
+	out := make(map[string]any, len(args))
+	for name, val := range args {
+		if _, ok := field.Args[name]; !ok {
+			return nil, fmt.Errorf("unknown argument %s", name)
+		}
+		out[name] = val
+	}
+	for name, arg := range field.Args {
+		if _, ok := out[name]; !ok && arg.Default != nil {
+			out[name] = arg.Default
+		}
+		if out[name] == nil && strings.HasSuffix(arg.Type, "!") {
+			return nil, fmt.Errorf("argument %s of type %s is required", name, arg.Type)
+		}
+	}
+	return out, nil
+}
+
+// helper function to add field error
+func (e *executor) fail(path []any, err error) {
+	e.errors = append(e.errors, Error{Message: err.Error(), Path: append([]any{}, path...)})
+}
+
+// helper function to resolve selections of object
+func (e *executor) object(ctx context.Context, typeName string, source any, sels []Selection, path []any) *ordered {
+	out := &ordered{values: make(map[string]any)}
+	rec := toRecord(source)
+	for _, sel := range sels {
+		key := sel.Key()
+		fpath := append(append([]any{}, path...), key)
+		if sel.Name == "__typename" {
+			out.set(key, typeName)
+			continue
+		}
+		field, declared := e.schema.Types[typeName].Fields[sel.Name]
+		if !declared && typeName == QueryType {
+			e.fail(fpath, fmt.Errorf("unknown field %s of type %s", sel.Name, typeName))
+			out.set(key, nil)
+			continue
+		}
+		var val any
+		if declared && field.Resolve != nil {
+			args, err := fieldArgs(field, sel.Args)
+			if err == nil {
+				val, err = field.Resolve(ResolveParams{Context: ctx, Source: rec, Args: args})
+			}
+			if err != nil {
+				e.fail(fpath, err)
+				out.set(key, nil)
+				continue
+			}
+		} else {
+			if len(sel.Args) > 0 && !declared {
+				e.fail(fpath, fmt.Errorf("field %s does not accept arguments", sel.Name))
+				out.set(key, nil)
+				continue
+			}
+			val = rec[sel.Name]
+		}
+		out.set(key, e.complete(ctx, baseType(field.Type), val, sel.Selections, fpath))
+	}
+	return out
+}
+
+// helper function to complete value of the field with its sub-selections
+func (e *executor) complete(ctx context.Context, typeName string, val any, sels []Selection, path []any) any {
+	if val == nil || len(sels) == 0 {
+		return val
+	}
+	rv := reflect.ValueOf(val)
+	if rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array {
+		out := make([]any, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			out[i] = e.complete(ctx, typeName, rv.Index(i).Interface(), sels, append(path, i))
+		}
+		return out
+	}
+	if toRecord(val) == nil {
+		e.fail(path, fmt.Errorf("selection of scalar value of type %T", val))
+		return nil
+	}
+	return e.object(ctx, typeName, val, sels, path)
+}
+
+// helper function to convert resolved object into record, structures are
+// converted via their JSON representation
+func toRecord(val any) map[string]any {
+	switch v := val.(type) {
+	case nil:
+		return nil
+	case map[string]any:
+		return v
+	}
+	rv := reflect.Indirect(reflect.ValueOf(val))
+	if rv.Kind() != reflect.Struct && rv.Kind() != reflect.Map {
+		return nil
+	}
+	data, err := json.Marshal(val)
+	if err != nil {
+		return nil
+	}
+	var rec map[string]any
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil
+	}
+	return rec
+}
+
+// SDL returns schema definition of object types, e.g. to document the
+// gateway for clients
+func (s *Schema) SDL() string {
+	var names []string
+	for name := range s.Types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf strings.Builder
+	buf.WriteString("scalar JSON\n")
+	for _, name := range names {
+		obj := s.Types[name]
+		buf.WriteString("\n")
+		if obj.Description != "" {
+			fmt.Fprintf(&buf, "\"%s\"\n", obj.Description)
+		}
+		fmt.Fprintf(&buf, "type %s {\n", name)
+		var fields []string
+		for fname := range obj.Fields {
+			fields = append(fields, fname)
+		}
+		sort.Strings(fields)
+		for _, fname := range fields {
+			field := obj.Fields[fname]
+			if field.Description != "" {
+				fmt.Fprintf(&buf, "  \"%s\"\n", field.Description)
+			}
+			var args []string
+			for aname, arg := range field.Args {
+				a := aname + ": " + arg.Type
+				if arg.Default != nil {
+					def, _ := json.Marshal(arg.Default)
+					a += " = " + string(def)
+				}
+				args = append(args, a)
+			}
+			sort.Strings(args)
+			sig := fname
+			if len(args) > 0 {
+				sig += "(" + strings.Join(args, ", ") + ")"
+			}
+			fmt.Fprintf(&buf, "  %s: %s\n", sig, field.Type)
+		}
+		buf.WriteString("}\n")
+	}
+	return buf.String()
+}
@@ -0,0 +1,276 @@
+package graphql
+
+// gateway module provides GraphQL schema of FOXDEN metadata records,
+// discovery datasets, beamline schemas and provenance with resolvers
+// backed by typed gRPC clients of metadata and discovery services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	beamlines "github.com/CHESSComputing/golib/beamlines"
+	fgrpc "github.com/CHESSComputing/golib/grpc"
+	provenance "github.com/CHESSComputing/golib/provenance"
+	server "github.com/CHESSComputing/golib/server"
+	services "github.com/CHESSComputing/golib/services"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/metadata"
+)
+
+// Options represents backends of GraphQL gateway, resolvers of missing
+// backends return errors
+type Options struct {
+	MetaData    *fgrpc.MetaDataClient    // client of metadata service
+	Discovery   *fgrpc.DiscoveryClient   // client of discovery service
+	Provenance  *provenance.Graph        // provenance graph
+	Schemas     *beamlines.SchemaManager // manager of beamline schemas
+	SchemaFiles []string                 // beamline schema files
+}
+
+// Gateway represents GraphQL gateway over metadata and discovery APIs
+type Gateway struct {
+	Schema  *Schema
+	Options Options
+}
+
+// ErrNotConfigured is returned by resolvers of missing backends
+var ErrNotConfigured = errors.New("backend is not configured")
+
+// NewGateway returns GraphQL gateway with given backends
+func NewGateway(opts Options) *Gateway {
+	g := &Gateway{Options: opts}
+	if g.Options.Schemas == nil {
+		g.Options.Schemas = &beamlines.SchemaManager{}
+	}
+	searchArgs := map[string]Argument{
+		"query": {Type: "String", Default: "{}", Description: "query of the service, e.g. beamline:3a"},
+		"idx":   {Type: "Int", Default: int64(0), Description: "index of first record"},
+		"limit": {Type: "Int", Default: int64(DefaultListSize), Description: "maximum number of records"},
+	}
+	lineageArgs := map[string]Argument{
+		"depth":     {Type: "Int", Default: int64(10), Description: "maximum depth of traversal"},
+		"direction": {Type: "String", Default: "ancestors", Description: "ancestors or descendants"},
+	}
+	provenanceField := Field{
+		Type:        "Lineage",
+		Description: "provenance of the record identified by its did",
+		Args:        lineageArgs,
+		Resolve:     g.recordLineage,
+	}
+	g.Schema = &Schema{Types: map[string]Object{
+		QueryType: {Fields: map[string]Field{
+			"records": {Type: "[Record]", Description: "metadata records matching the query", Args: searchArgs, Resolve: g.records},
+			"record": {Type: "Record", Description: "metadata record of given did",
+				Args: map[string]Argument{"did": {Type: "String!"}}, Resolve: g.record},
+			"datasets": {Type: "[Dataset]", Description: "discovery datasets matching the query", Args: searchArgs, Resolve: g.datasets},
+			"schemas": {Type: "[Schema]", Description: "beamline schemas",
+				Args: map[string]Argument{"name": {Type: "String", Description: "schema name, e.g. ID3A"}}, Resolve: g.schemas},
+			"lineage": {Type: "Lineage", Description: "provenance graph of given node",
+				Args: map[string]Argument{
+					"id":        {Type: "String!"},
+					"depth":     lineageArgs["depth"],
+					"direction": lineageArgs["direction"],
+				}, Resolve: g.lineage},
+		}},
+		"Record": {
+			Description: "metadata record, record keys are queried as fields",
+			Fields:      map[string]Field{"provenance": provenanceField},
+		},
+		"Dataset": {
+			Description: "discovery dataset, dataset keys are queried as fields",
+			Fields:      map[string]Field{"provenance": provenanceField},
+		},
+		"Schema": {
+			Description: "beamline schema",
+			Fields: map[string]Field{
+				"name": {Type: "String"},
+				"file": {Type: "String"},
+				"keys": {Type: "[JSON]", Description: "schema keys with type, section and description"},
+			},
+		},
+		"Lineage": {
+			Description: "result of provenance graph traversal",
+			Fields: map[string]Field{
+				"root":  {Type: "String"},
+				"nodes": {Type: "[JSON]"},
+				"edges": {Type: "[JSON]"},
+			},
+		},
+	}}
+	return g
+}
+
+// helper function to search records via typed client of the service
+func search(ctx context.Context, searcher func(context.Context, map[string]any) (fgrpc.RecordStream, error), args map[string]any) ([]map[string]any, error) {
+	spec := map[string]any{"query": args["query"], "idx": args["idx"], "limit": args["limit"]}
+	stream, err := searcher(ctx, spec)
+	if err != nil {
+		return nil, err
+	}
+	return fgrpc.Records(stream)
+}
+
+// helper function to resolve records of metadata service
+func (g *Gateway) records(p ResolveParams) (any, error) {
+	if g.Options.MetaData == nil {
+		return nil, fmt.Errorf("metadata %w", ErrNotConfigured)
+	}
+	return search(p.Context, func(ctx context.Context, spec map[string]any) (fgrpc.RecordStream, error) {
+		query, err := fgrpc.ToStruct(spec)
+		if err != nil {
+			return nil, err
+		}
+		return g.Options.MetaData.Search(ctx, query)
+	}, p.Args)
+}
+
+// helper function to resolve single record of metadata service
+func (g *Gateway) record(p ResolveParams) (any, error) {
+	if g.Options.MetaData == nil {
+		return nil, fmt.Errorf("metadata %w", ErrNotConfigured)
+	}
+	spec, err := fgrpc.ToStruct(map[string]any{"did": p.Args["did"]})
+	if err != nil {
+		return nil, err
+	}
+	rec, err := g.Options.MetaData.Get(p.Context, spec)
+	if err != nil {
+		return nil, err
+	}
+	return rec.AsMap(), nil
+}
+
+// helper function to resolve datasets of discovery service
+func (g *Gateway) datasets(p ResolveParams) (any, error) {
+	if g.Options.Discovery == nil {
+		return nil, fmt.Errorf("discovery %w", ErrNotConfigured)
+	}
+	return search(p.Context, func(ctx context.Context, spec map[string]any) (fgrpc.RecordStream, error) {
+		query, err := fgrpc.ToStruct(spec)
+		if err != nil {
+			return nil, err
+		}
+		return g.Options.Discovery.Search(ctx, query)
+	}, p.Args)
+}
+
+// helper function to resolve beamline schemas
+func (g *Gateway) schemas(p ResolveParams) (any, error) {
+	name, _ := p.Args["name"].(string)
+	var out []map[string]any
+	for _, fname := range g.Options.SchemaFiles {
+		sname := strings.TrimSuffix(filepath.Base(fname), filepath.Ext(fname))
+		if name != "" && sname != name {
+			continue
+		}
+		schema, err := g.Options.Schemas.Load(fname)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load schema %s, error %v", sname, err)
+		}
+		var keys []string
+		for key := range schema.Map {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		var records []any
+		for _, key := range keys {
+			records = append(records, schema.Map[key])
+		}
+		out = append(out, map[string]any{"name": sname, "file": fname, "keys": records})
+	}
+	return out, nil
+}
+
+// helper function to traverse provenance graph
+func (g *Gateway) traverse(ctx context.Context, id string, args map[string]any) (any, error) {
+	if g.Options.Provenance == nil {
+		return nil, fmt.Errorf("provenance %w", ErrNotConfigured)
+	}
+	depth, _ := toInt(args["depth"])
+	switch args["direction"] {
+	case "ancestors":
+		return g.Options.Provenance.Ancestors(ctx, id, depth)
+	case "descendants":
+		return g.Options.Provenance.Descendants(ctx, id, depth)
+	}
+	return nil, fmt.Errorf("unsupported direction '%v', supported directions: ancestors, descendants", args["direction"])
+}
+
+// helper function to resolve lineage of given node
+func (g *Gateway) lineage(p ResolveParams) (any, error) {
+	id, _ := p.Args["id"].(string)
+	return g.traverse(p.Context, id, p.Args)
+}
+
+// helper function to resolve provenance of the record
+func (g *Gateway) recordLineage(p ResolveParams) (any, error) {
+	rec, _ := p.Source.(map[string]any)
+	did, _ := rec["did"].(string)
+	if did == "" {
+		return nil, errors.New("record without did")
+	}
+	return g.traverse(p.Context, did, p.Args)
+}
+
+// helper function to pass credentials of HTTP request to gRPC calls of
+// resolvers, therefore services authorize the user of the gateway
+func outgoingContext(r *http.Request) context.Context {
+	ctx := r.Context()
+	if val := r.Header.Get("Authorization"); val != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", val)
+	}
+	if val := r.Header.Get(authz.APIKeyHeader); val != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, strings.ToLower(authz.APIKeyHeader), val)
+	}
+	return ctx
+}
+
+// Handler provides GraphQL endpoint. It accepts POST requests with JSON
+// {"query", "operationName", "variables"} body and GET requests with query,
+// operationName and variables (JSON) parameters, GET request without query
+// returns schema definition.
+func (g *Gateway) Handler(c *gin.Context) {
+	var req Request
+	if c.Request.Method == "GET" {
+		req.Query = c.Query("query")
+		if req.Query == "" {
+			c.String(http.StatusOK, g.Schema.SDL())
+			return
+		}
+		req.OperationName = c.Query("operationName")
+		if vars := c.Query("variables"); vars != "" {
+			if err := json.Unmarshal([]byte(vars), &req.Variables); err != nil {
+				c.JSON(http.StatusBadRequest, services.Response("graphql", http.StatusBadRequest, services.ParametersError, err))
+				return
+			}
+		}
+	} else if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, services.Response("graphql", http.StatusBadRequest, services.BindError, err))
+		return
+	}
+	resp := g.Schema.Execute(outgoingContext(c.Request), req)
+	code := http.StatusOK
+	if resp.Data == nil {
+		// request is rejected before execution, e.g. syntax error
+		code = http.StatusBadRequest
+	}
+	c.JSON(code, resp)
+}
+
+// Routes returns server routes of GraphQL endpoint under given path, e.g.
+// /graphql
+func (g *Gateway) Routes(path string) []server.Route {
+	return []server.Route{
+		{Method: "GET", Path: path, Authorized: true, Scope: "read", Handler: g.Handler,
+			Summary: "GraphQL query or schema definition"},
+		{Method: "POST", Path: path, Authorized: true, Scope: "read", Handler: g.Handler,
+			Summary: "GraphQL query"},
+	}
+}
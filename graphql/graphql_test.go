@@ -0,0 +1,261 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	fgrpc "github.com/CHESSComputing/golib/grpc"
+	provenance "github.com/CHESSComputing/golib/provenance"
+	storage "github.com/CHESSComputing/golib/storage"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// TestParse
+func TestParse(t *testing.T) {
+	query := `
+	# records of beamline
+	query Records($limit: Int = 10, $flag: Boolean!) {
+		items: records(query: "beamline:3a", limit: $limit, sort: ASC, spec: {cycle: ["2024-1"]}) {
+			did
+			...recordFields @include(if: $flag)
+			... on Record { energy }
+		}
+	}
+	fragment recordFields on Record { beamline, cycle }`
+	doc, err := Parse(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	op := doc.Operations[0]
+	if op.Name != "Records" || len(op.Variables) != 2 || op.Variables[0].Default != int64(10) || op.Variables[1].Type != "Boolean!" {
+		t.Errorf("wrong operation %+v", op)
+	}
+	sel := op.Selections[0]
+	if sel.Key() != "items" || sel.Name != "records" || sel.Args["limit"] != Variable("limit") || sel.Args["sort"] != Enum("ASC") {
+		t.Errorf("wrong selection %+v", sel)
+	}
+	if spec, ok := sel.Args["spec"].(map[string]any); !ok || len(spec["cycle"].([]any)) != 1 {
+		t.Errorf("wrong object argument %v", sel.Args["spec"])
+	}
+	if len(sel.Selections) != 3 || sel.Selections[1].Fragment != "recordFields" || !sel.Selections[2].Inline {
+		t.Errorf("wrong sub-selections %+v", sel.Selections)
+	}
+	if len(doc.Fragments["recordFields"]) != 2 {
+		t.Errorf("wrong fragment %+v", doc.Fragments)
+	}
+	for _, q := range []string{`{ records(`, `mutation { insert }`, `{ a(x: "b) }`, `{}`, `{ a } fragment f on T { b } fragment f on T { c }`} {
+		var serr *SyntaxError
+		if _, err := Parse(q); !errors.As(err, &serr) {
+			t.Errorf("query %s should fail with syntax error, got %v", q, err)
+		}
+	}
+}
+
+// helper function to create test schema
+func testSchema() *Schema {
+	records := []map[string]any{
+		{"did": "/beamline=3a/cycle=1", "beamline": "3a", "energy": 10.5},
+		{"did": "/beamline=3a/cycle=2", "beamline": "3a", "energy": 12.0},
+	}
+	return &Schema{Types: map[string]Object{
+		QueryType: {Fields: map[string]Field{
+			"records": {Type: "[Record]", Args: map[string]Argument{"limit": {Type: "Int", Default: int64(10)}},
+				Resolve: func(p ResolveParams) (any, error) {
+					limit, _ := toInt(p.Args["limit"])
+					if limit < len(records) {
+						return records[:limit], nil
+					}
+					return records, nil
+				}},
+			"fail": {Type: "String", Resolve: func(p ResolveParams) (any, error) {
+				return nil, errors.New("backend is down")
+			}},
+		}},
+		"Record": {Fields: map[string]Field{
+			"short": {Type: "String", Resolve: func(p ResolveParams) (any, error) {
+				rec := p.Source.(map[string]any)
+				return strings.TrimPrefix(rec["did"].(string), "/beamline="), nil
+			}},
+		}},
+	}}
+}
+
+// helper function to execute query and encode response
+func execute(s *Schema, query string, vars map[string]any) (string, *Response) {
+	resp := s.Execute(context.Background(), Request{Query: query, Variables: vars})
+	data, _ := json.Marshal(resp)
+	return string(data), resp
+}
+
+// TestExecute
+func TestExecute(t *testing.T) {
+	s := testSchema()
+	out, _ := execute(s, `query ($n: Int) { recs: records(limit: $n) { short energy ...f } } fragment f on Record { __typename }`, map[string]any{"n": 1})
+	expect := `{"data":{"recs":[{"short":"3a/cycle=1","energy":10.5,"__typename":"Record"}]}}`
+	if out != expect {
+		t.Errorf("unexpected response\n%s\nexpect\n%s", out, expect)
+	}
+	out, _ = execute(s, `query ($all: Boolean = false) { records { did @skip(if: $all) beamline @include(if: $all) } }`, nil)
+	if !strings.Contains(out, `{"did":"/beamline=3a/cycle=2"}`) || strings.Contains(out, "beamline\"") {
+		t.Errorf("directives are not applied %s", out)
+	}
+
+	// field errors do not fail whole query
+	_, resp := execute(s, `{ fail records(limit: 1) { did } }`, nil)
+	if len(resp.Errors) != 1 || resp.Errors[0].Message != "backend is down" || resp.Errors[0].Path[0] != "fail" || resp.Data == nil {
+		t.Errorf("unexpected field error %+v", resp)
+	}
+	_, resp = execute(s, `{ records { did { name } } }`, nil)
+	if len(resp.Errors) != 2 {
+		t.Errorf("selection of scalar values should fail %+v", resp.Errors)
+	}
+
+	// request errors
+	for _, q := range []string{`{ unknown }`, `{ records(size: 1) { did } }`, `query ($n: Int!) { records(limit: $n) { did } }`, `{ ...f }`, `{ ...f } fragment f on Query { ...f }`} {
+		if _, resp := execute(s, q, nil); len(resp.Errors) == 0 {
+			t.Errorf("query %s should fail", q)
+		}
+	}
+}
+
+// TestLimits
+func TestLimits(t *testing.T) {
+	s := testSchema()
+	s.MaxDepth = 3
+	s.MaxComplexity = 100
+	if _, resp := execute(s, `{ records(limit: 10) { a { b } } }`, nil); len(resp.Errors) != 0 {
+		t.Errorf("query within limits is rejected %v", resp.Errors)
+	}
+	if _, resp := execute(s, `{ records { a { b { c } } } }`, nil); len(resp.Errors) != 1 || resp.Data != nil ||
+		resp.Errors[0].Message != "query depth 4 exceeds limit 3" {
+		t.Errorf("deep query is accepted %+v", resp)
+	}
+	// complexity of list fields is multiplied by limit argument
+	if _, resp := execute(s, `query ($n: Int) { records(limit: $n) { did beamline } }`, map[string]any{"n": 50}); len(resp.Errors) != 1 ||
+		resp.Errors[0].Message != "query complexity 101 exceeds limit 100" {
+		t.Errorf("complex query is accepted %+v", resp)
+	}
+}
+
+// fakeConn implements grpc.ClientConnInterface with metadata and discovery
+// services responses
+type fakeConn struct {
+	records []map[string]any
+	md      metadata.MD
+}
+
+// Invoke implements grpc.ClientConnInterface
+func (f *fakeConn) Invoke(ctx context.Context, method string, args, reply any, opts ...grpc.CallOption) error {
+	f.md, _ = metadata.FromOutgoingContext(ctx)
+	spec := args.(*structpb.Struct).AsMap()
+	for _, rec := range f.records {
+		if rec["did"] == spec["did"] {
+			out, _ := structpb.NewStruct(rec)
+			proto.Merge(reply.(proto.Message), out)
+			return nil
+		}
+	}
+	return errors.New("record not found")
+}
+
+// NewStream implements grpc.ClientConnInterface
+func (f *fakeConn) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	return &fakeStream{ctx: ctx, conn: f}, nil
+}
+
+// fakeStream implements grpc.ClientStream of search results
+type fakeStream struct {
+	grpc.ClientStream
+	ctx     context.Context
+	conn    *fakeConn
+	records []map[string]any
+}
+
+func (s *fakeStream) Context() context.Context { return s.ctx }
+func (s *fakeStream) CloseSend() error         { return nil }
+
+func (s *fakeStream) SendMsg(m any) error {
+	query := m.(*structpb.Struct).AsMap()
+	limit := int(query["limit"].(float64))
+	s.records = s.conn.records
+	if limit < len(s.records) {
+		s.records = s.records[:limit]
+	}
+	return nil
+}
+
+func (s *fakeStream) RecvMsg(m any) error {
+	if len(s.records) == 0 {
+		return io.EOF
+	}
+	out, _ := structpb.NewStruct(s.records[0])
+	s.records = s.records[1:]
+	proto.Merge(m.(proto.Message), out)
+	return nil
+}
+
+// TestGateway
+func TestGateway(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	conn := &fakeConn{records: []map[string]any{
+		{"did": "/beamline=3a/raw", "beamline": "3a"},
+		{"did": "/beamline=3a/reduced", "beamline": "3a"},
+	}}
+	graph := provenance.NewGraph(storage.NewMemoryStore())
+	err := graph.Link(context.Background(), provenance.Edge{From: "/beamline=3a/reduced", Type: provenance.DerivedFrom, To: "/beamline=3a/raw"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := NewGateway(Options{MetaData: fgrpc.NewMetaDataClient(conn), Provenance: graph})
+	r := gin.New()
+	for _, route := range g.Routes("/graphql") {
+		r.Handle(route.Method, route.Path, route.Handler)
+	}
+	send := func(req *http.Request) (int, Response) {
+		req.Header.Set("Authorization", "Bearer token")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var resp Response
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp
+	}
+
+	body := `{"query": "query ($did: String!) { record(did: $did) { beamline provenance { nodes { id depth } } } records(limit: 1) { did } }", "variables": {"did": "/beamline=3a/reduced"}}`
+	code, resp := send(httptest.NewRequest("POST", "/graphql", strings.NewReader(body)))
+	if code != http.StatusOK || len(resp.Errors) != 0 {
+		t.Fatalf("unexpected response %d %+v", code, resp)
+	}
+	data := resp.Data.(map[string]any)
+	rec := data["record"].(map[string]any)
+	nodes := rec["provenance"].(map[string]any)["nodes"].([]any)
+	if rec["beamline"] != "3a" || len(nodes) == 0 || len(data["records"].([]any)) != 1 {
+		t.Errorf("unexpected data %v", data)
+	}
+	if vals := conn.md.Get("authorization"); len(vals) != 1 || vals[0] != "Bearer token" {
+		t.Errorf("credentials are not passed to service %v", conn.md)
+	}
+
+	// missing backend and syntax errors
+	code, resp = send(httptest.NewRequest("GET", "/graphql?query={datasets{did}}", nil))
+	if code != http.StatusOK || len(resp.Errors) != 1 || !strings.Contains(resp.Errors[0].Message, "not configured") {
+		t.Errorf("expect error of missing discovery backend %d %+v", code, resp)
+	}
+	if code, _ := send(httptest.NewRequest("POST", "/graphql", strings.NewReader(`{"query": "{ records"}`))); code != http.StatusBadRequest {
+		t.Errorf("expect 400 for syntax error, got %d", code)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/graphql", nil))
+	if !strings.Contains(w.Body.String(), "records(idx: Int = 0, limit: Int = 100, query: String = \"{}\"): [Record]") {
+		t.Errorf("unexpected schema definition\n%s", w.Body.String())
+	}
+}
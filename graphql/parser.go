@@ -0,0 +1,534 @@
+package graphql
+
+// parser module provides parser of GraphQL query documents. It supports
+// query operations with variables, aliases, arguments, fragments, inline
+// fragments and @include/@skip directives, mutations and subscriptions are
+// not supported.
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Variable represents variable reference within argument values
+type Variable string
+
+// Enum represents enum value within argument values
+type Enum string
+
+// Directive represents directive of selection, e.g. @include(if: $flag)
+type Directive struct {
+	Name string
+	Args map[string]any
+}
+
+// Selection represents field, fragment spread or inline fragment
+type Selection struct {
+	Alias      string         // field alias
+	Name       string         // field name
+	Args       map[string]any // field arguments
+	Directives []Directive    // selection directives
+	Selections []Selection    // sub-selections of the field or inline fragment
+	Fragment   string         // name of fragment spread
+	Inline     bool           // inline fragment
+}
+
+// Key returns response key of the field, i.e. its alias or name
+func (s Selection) Key() string {
+	if s.Alias != "" {
+		return s.Alias
+	}
+	return s.Name
+}
+
+// VariableDefinition represents variable of operation
+type VariableDefinition struct {
+	Name    string
+	Type    string
+	Default any
+}
+
+// Operation represents query operation
+type Operation struct {
+	Name       string
+	Variables  []VariableDefinition
+	Selections []Selection
+}
+
+// Document represents parsed GraphQL document
+type Document struct {
+	Operations []Operation
+	Fragments  map[string][]Selection
+}
+
+// SyntaxError represents error of query parsing
+type SyntaxError struct {
+	Line    int
+	Column  int
+	Message string
+}
+
+// Error implements error interface
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("syntax error at %d:%d: %s", e.Line, e.Column, e.Message)
+}
+
+// token kinds
+const (
+	tokenEOF = iota
+	tokenPunct
+	tokenName
+	tokenInt
+	tokenFloat
+	tokenString
+)
+
+// token represents lexical token of the query
+type token struct {
+	kind  int
+	value string
+	pos   int
+}
+
+// parser represents recursive descent parser of GraphQL documents
+type parser struct {
+	src string
+	pos int
+	tok token
+}
+
+// Parse parses GraphQL query document
+func Parse(query string) (doc *Document, err error) {
+	p := &parser{src: strings.TrimPrefix(query, "\ufeff")}
+	defer func() {
+		if r := recover(); r != nil {
+			serr, ok := r.(*SyntaxError)
+			if !ok {
+				panic(r)
+			}
+			doc, err = nil, serr
+		}
+	}()
+	p.next()
+	doc = &Document{Fragments: make(map[string][]Selection)}
+	for p.tok.kind != tokenEOF {
+		switch {
+		case p.tok.kind == tokenPunct && p.tok.value == "{":
+			doc.Operations = append(doc.Operations, Operation{Selections: p.selectionSet()})
+		case p.tok.kind == tokenName && p.tok.value == "query":
+			doc.Operations = append(doc.Operations, p.operation())
+		case p.tok.kind == tokenName && p.tok.value == "fragment":
+			name, sels := p.fragment()
+			if _, ok := doc.Fragments[name]; ok {
+				p.fail("duplicate fragment %s", name)
+			}
+			doc.Fragments[name] = sels
+		case p.tok.kind == tokenName && (p.tok.value == "mutation" || p.tok.value == "subscription"):
+			p.fail("%s operations are not supported", p.tok.value)
+		default:
+			p.fail("unexpected %s", p.describe())
+		}
+	}
+	if len(doc.Operations) == 0 {
+		p.fail("document does not contain operations")
+	}
+	return doc, nil
+}
+
+// helper function to abort parsing with syntax error at current token
+func (p *parser) fail(format string, args ...any) {
+	line, col := 1, 1
+	for _, r := range p.src[:p.tok.pos] {
+		if r == '\n' {
+			line, col = line+1, 1
+		} else {
+			col++
+		}
+	}
+	panic(&SyntaxError{Line: line, Column: col, Message: fmt.Sprintf(format, args...)})
+}
+
+// helper function to describe current token in error messages
+func (p *parser) describe() string {
+	if p.tok.kind == tokenEOF {
+		return "end of document"
+	}
+	return fmt.Sprintf("'%s'", p.tok.value)
+}
+
+// helper function to read next token, white spaces, commas and comments are
+// ignored
+func (p *parser) next() {
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c == '#' {
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+			continue
+		}
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',' {
+			p.pos++
+			continue
+		}
+		break
+	}
+	start := p.pos
+	p.tok = token{pos: start}
+	if p.pos >= len(p.src) {
+		p.tok.kind = tokenEOF
+		return
+	}
+	c := p.src[p.pos]
+	switch {
+	case strings.HasPrefix(p.src[p.pos:], "..."):
+		p.pos += 3
+		p.tok.kind, p.tok.value = tokenPunct, "..."
+	case strings.IndexByte("!$()[]{}:=@|&", c) >= 0:
+		p.pos++
+		p.tok.kind, p.tok.value = tokenPunct, string(c)
+	case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+		for p.pos < len(p.src) && isNameChar(p.src[p.pos]) {
+			p.pos++
+		}
+		p.tok.kind, p.tok.value = tokenName, p.src[start:p.pos]
+	case c == '-' || c >= '0' && c <= '9':
+		p.number()
+	case c == '"':
+		p.string()
+	default:
+		r, _ := utf8.DecodeRuneInString(p.src[p.pos:])
+		p.fail("unexpected character '%c'", r)
+	}
+}
+
+// helper function to check characters of names
+func isNameChar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// helper function to read int or float token
+func (p *parser) number() {
+	start := p.pos
+	kind := tokenInt
+	if p.src[p.pos] == '-' {
+		p.pos++
+	}
+	digits := func() {
+		n := p.pos
+		for p.pos < len(p.src) && p.src[p.pos] >= '0' && p.src[p.pos] <= '9' {
+			p.pos++
+		}
+		if n == p.pos {
+			p.fail("invalid number")
+		}
+	}
+	digits()
+	if p.pos < len(p.src) && p.src[p.pos] == '.' {
+		kind = tokenFloat
+		p.pos++
+		digits()
+	}
+	if p.pos < len(p.src) && (p.src[p.pos] == 'e' || p.src[p.pos] == 'E') {
+		kind = tokenFloat
+		p.pos++
+		if p.pos < len(p.src) && (p.src[p.pos] == '+' || p.src[p.pos] == '-') {
+			p.pos++
+		}
+		digits()
+	}
+	p.tok.kind, p.tok.value = kind, p.src[start:p.pos]
+}
+
+// helper function to read string token, block strings keep their content
+// without escape processing
+func (p *parser) string() {
+	if strings.HasPrefix(p.src[p.pos:], `"""`) {
+		end := strings.Index(p.src[p.pos+3:], `"""`)
+		if end < 0 {
+			p.fail("unterminated block string")
+		}
+		p.tok.kind, p.tok.value = tokenString, strings.TrimSpace(p.src[p.pos+3:p.pos+3+end])
+		p.pos += end + 6
+		return
+	}
+	var buf strings.Builder
+	p.pos++
+	for {
+		if p.pos >= len(p.src) || p.src[p.pos] == '\n' {
+			p.fail("unterminated string")
+		}
+		c := p.src[p.pos]
+		if c == '"' {
+			p.pos++
+			break
+		}
+		if c != '\\' {
+			buf.WriteByte(c)
+			p.pos++
+			continue
+		}
+		if p.pos+1 >= len(p.src) {
+			p.fail("unterminated string")
+		}
+		esc := p.src[p.pos+1]
+		p.pos += 2
+		switch esc {
+		case '"', '\\', '/':
+			buf.WriteByte(esc)
+		case 'b':
+			buf.WriteByte('\b')
+		case 'f':
+			buf.WriteByte('\f')
+		case 'n':
+			buf.WriteByte('\n')
+		case 'r':
+			buf.WriteByte('\r')
+		case 't':
+			buf.WriteByte('\t')
+		case 'u':
+			if p.pos+4 > len(p.src) {
+				p.fail("invalid unicode escape")
+			}
+			code, err := strconv.ParseUint(p.src[p.pos:p.pos+4], 16, 32)
+			if err != nil {
+				p.fail("invalid unicode escape")
+			}
+			buf.WriteRune(rune(code))
+			p.pos += 4
+		default:
+			p.fail("invalid escape '\\%c'", esc)
+		}
+	}
+	p.tok.kind, p.tok.value = tokenString, buf.String()
+}
+
+// helper function to check if current token is given punctuator
+func (p *parser) peek(punct string) bool {
+	return p.tok.kind == tokenPunct && p.tok.value == punct
+}
+
+// helper function to consume given punctuator
+func (p *parser) expect(punct string) {
+	if !p.peek(punct) {
+		p.fail("expected '%s', got %s", punct, p.describe())
+	}
+	p.next()
+}
+
+// helper function to consume name token
+func (p *parser) name() string {
+	if p.tok.kind != tokenName {
+		p.fail("expected name, got %s", p.describe())
+	}
+	name := p.tok.value
+	p.next()
+	return name
+}
+
+// helper function to parse query operation
+func (p *parser) operation() Operation {
+	p.next() // query keyword
+	var op Operation
+	if p.tok.kind == tokenName {
+		op.Name = p.name()
+	}
+	if p.peek("(") {
+		p.next()
+		for !p.peek(")") {
+			p.expect("$")
+			v := VariableDefinition{Name: p.name()}
+			p.expect(":")
+			v.Type = p.typeRef()
+			if p.peek("=") {
+				p.next()
+				v.Default = p.value(true)
+			}
+			op.Variables = append(op.Variables, v)
+		}
+		p.next()
+	}
+	if p.peek("@") {
+		p.fail("directives of operations are not supported")
+	}
+	op.Selections = p.selectionSet()
+	return op
+}
+
+// helper function to parse type reference, e.g. [String!]!
+func (p *parser) typeRef() string {
+	var t string
+	if p.peek("[") {
+		p.next()
+		t = "[" + p.typeRef() + "]"
+		p.expect("]")
+	} else {
+		t = p.name()
+	}
+	if p.peek("!") {
+		p.next()
+		t += "!"
+	}
+	return t
+}
+
+// helper function to parse fragment definition
+func (p *parser) fragment() (string, []Selection) {
+	p.next() // fragment keyword
+	name := p.name()
+	if name == "on" {
+		p.fail("invalid fragment name 'on'")
+	}
+	if p.name() != "on" {
+		p.fail("expected type condition of fragment %s", name)
+	}
+	p.name()
+	return name, p.selectionSet()
+}
+
+// helper function to parse selection set
+func (p *parser) selectionSet() []Selection {
+	p.expect("{")
+	var sels []Selection
+	for !p.peek("}") {
+		if p.tok.kind == tokenEOF {
+			p.fail("unterminated selection set")
+		}
+		sels = append(sels, p.selection())
+	}
+	p.next()
+	if len(sels) == 0 {
+		p.fail("empty selection set")
+	}
+	return sels
+}
+
+// helper function to parse single selection
+func (p *parser) selection() Selection {
+	var sel Selection
+	if p.peek("...") {
+		p.next()
+		if p.tok.kind == tokenName && p.tok.value != "on" {
+			sel.Fragment = p.name()
+			sel.Directives = p.directives()
+			return sel
+		}
+		sel.Inline = true
+		if p.tok.kind == tokenName && p.tok.value == "on" {
+			p.next()
+			p.name()
+		}
+		sel.Directives = p.directives()
+		sel.Selections = p.selectionSet()
+		return sel
+	}
+	sel.Name = p.name()
+	if p.peek(":") {
+		p.next()
+		sel.Alias, sel.Name = sel.Name, p.name()
+	}
+	sel.Args = p.arguments()
+	sel.Directives = p.directives()
+	if p.peek("{") {
+		sel.Selections = p.selectionSet()
+	}
+	return sel
+}
+
+// helper function to parse arguments
+func (p *parser) arguments() map[string]any {
+	if !p.peek("(") {
+		return nil
+	}
+	p.next()
+	args := make(map[string]any)
+	for !p.peek(")") {
+		name := p.name()
+		if _, ok := args[name]; ok {
+			p.fail("duplicate argument %s", name)
+		}
+		p.expect(":")
+		args[name] = p.value(false)
+	}
+	p.next()
+	return args
+}
+
+// helper function to parse directives
+func (p *parser) directives() []Directive {
+	var out []Directive
+	for p.peek("@") {
+		p.next()
+		out = append(out, Directive{Name: p.name(), Args: p.arguments()})
+	}
+	return out
+}
+
+// helper function to parse argument value, constant values may not contain
+// variables
+func (p *parser) value(constant bool) any {
+	switch p.tok.kind {
+	case tokenInt:
+		n, err := strconv.ParseInt(p.tok.value, 10, 64)
+		if err != nil {
+			p.fail("invalid integer %s", p.tok.value)
+		}
+		p.next()
+		return n
+	case tokenFloat:
+		f, err := strconv.ParseFloat(p.tok.value, 64)
+		if err != nil {
+			p.fail("invalid float %s", p.tok.value)
+		}
+		p.next()
+		return f
+	case tokenString:
+		s := p.tok.value
+		p.next()
+		return s
+	case tokenName:
+		name := p.name()
+		switch name {
+		case "true":
+			return true
+		case "false":
+			return false
+		case "null":
+			return nil
+		}
+		return Enum(name)
+	}
+	switch {
+	case p.peek("$"):
+		if constant {
+			p.fail("variables are not allowed in constant values")
+		}
+		p.next()
+		return Variable(p.name())
+	case p.peek("["):
+		p.next()
+		list := []any{}
+		for !p.peek("]") {
+			if p.tok.kind == tokenEOF {
+				p.fail("unterminated list")
+			}
+			list = append(list, p.value(constant))
+		}
+		p.next()
+		return list
+	case p.peek("{"):
+		p.next()
+		obj := make(map[string]any)
+		for !p.peek("}") {
+			name := p.name()
+			p.expect(":")
+			obj[name] = p.value(constant)
+		}
+		p.next()
+		return obj
+	}
+	p.fail("unexpected %s", p.describe())
+	return nil
+}
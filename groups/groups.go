@@ -0,0 +1,412 @@
+package groups
+
+// groups module provides groups of users, e.g. proposal teams or beamline
+// staff. Groups are persisted via storage interface, they may contain
+// other groups and they are either managed locally or synchronized from
+// LDAP directory. Groups of users are referenced by ACLs and policy rules.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	storage "github.com/CHESSComputing/golib/storage"
+	utils "github.com/CHESSComputing/golib/utils"
+)
+
+// DefaultCollection defines storage collection of groups
+var DefaultCollection = "groups"
+
+// DefaultMaxDepth defines maximum depth of nested groups
+var DefaultMaxDepth = 10
+
+// group sources
+const (
+	SourceLocal = "local"
+	SourceLDAP  = "ldap"
+)
+
+// errors of groups module
+var (
+	ErrGroupExists   = errors.New("group already exists")
+	ErrGroupNotFound = errors.New("group not found")
+	ErrInvalid       = errors.New("invalid group")
+	ErrCycle         = errors.New("cycle of nested groups")
+	ErrReadOnly      = errors.New("group is managed by LDAP")
+)
+
+// patternName defines valid group names, e.g. p-1234 or beamline:id3a
+var patternName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.:-]{0,127}$`)
+
+// Group represents group of users and nested groups
+type Group struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Members     []string `json:"members"`          // user names
+	Groups      []string `json:"groups,omitempty"` // nested groups whose members belong to the group
+	Source      string   `json:"source"`           // local or ldap
+	Created     int64    `json:"created"`
+	Updated     int64    `json:"updated"`
+}
+
+// helper function to convert group into storage record
+func (g Group) record() map[string]any {
+	return map[string]any{
+		"_id":         g.Name,
+		"description": g.Description,
+		"members":     g.Members,
+		"groups":      g.Groups,
+		"source":      g.Source,
+		"created":     g.Created,
+		"updated":     g.Updated,
+	}
+}
+
+// helper function to convert storage list into strings
+func stringList(val any) []string {
+	var out []string
+	// MongoDB returns arrays as primitive.A
+	if list, ok := utils.ListValues(val); ok {
+		for _, v := range list {
+			out = append(out, fmt.Sprintf("%v", v))
+		}
+	}
+	return out
+}
+
+// helper function to convert storage record into group
+func groupRecord(rec map[string]any) Group {
+	str := func(key string) string {
+		v, _ := rec[key].(string)
+		return v
+	}
+	return Group{
+		Name:        str("_id"),
+		Description: str("description"),
+		Members:     stringList(rec["members"]),
+		Groups:      stringList(rec["groups"]),
+		Source:      str("source"),
+		Created:     utils.ToInt64(rec["created"]),
+		Updated:     utils.ToInt64(rec["updated"]),
+	}
+}
+
+// helper function to return sorted list without duplicates and empty names
+func normalize(list []string) []string {
+	var out []string
+	for _, v := range list {
+		if v != "" && !utils.InList(v, out) {
+			out = append(out, v)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Manager manages groups of users
+type Manager struct {
+	Store      storage.Store
+	Collection string
+	MaxDepth   int       // maximum depth of nested groups
+	Directory  Directory // directory of synchronized groups, e.g. LDAP
+}
+
+// Groups represents global groups manager, it should be initialized via
+// Init function
+var Groups *Manager
+
+// NewManager returns groups manager of given configuration
+func NewManager(store storage.Store, cfg srvConfig.Groups) *Manager {
+	m := &Manager{Store: store, Collection: cfg.Collection, MaxDepth: DefaultMaxDepth}
+	if m.Collection == "" {
+		m.Collection = DefaultCollection
+	}
+	return m
+}
+
+// Init initializes global groups manager persisted in MongoDB, groups of
+// configured LDAP directory are synchronized via Run method
+func Init() error {
+	if srvConfig.Config == nil {
+		return errors.New("server configuration is not initialized")
+	}
+	cfg := srvConfig.Config.Groups
+	dbname := cfg.DBName
+	if dbname == "" {
+		dbname = srvConfig.Config.CHESSMetaData.DBName
+	}
+	if dbname == "" {
+		return errors.New("groups database is not configured")
+	}
+	m := NewManager(storage.NewStore(dbname), cfg)
+	if cfg.LDAP.URL != "" {
+		m.Directory = NewLDAPDirectory(cfg.LDAP)
+	}
+	Groups = m
+	return nil
+}
+
+// helper function to validate group name
+func validName(name string) error {
+	if !patternName.MatchString(name) {
+		return fmt.Errorf("%w name '%s'", ErrInvalid, name)
+	}
+	return nil
+}
+
+// Create creates new group, nested groups should exist
+func (m *Manager) Create(ctx context.Context, g Group) (Group, error) {
+	if err := validName(g.Name); err != nil {
+		return g, err
+	}
+	if g.Source == "" {
+		g.Source = SourceLocal
+	}
+	if g.Source != SourceLocal && g.Source != SourceLDAP {
+		return g, fmt.Errorf("%w source '%s'", ErrInvalid, g.Source)
+	}
+	g.Members = normalize(g.Members)
+	g.Groups = normalize(g.Groups)
+	for _, sub := range g.Groups {
+		if sub == g.Name {
+			return g, fmt.Errorf("%w, group %s includes itself", ErrCycle, g.Name)
+		}
+		if _, err := m.Get(ctx, sub); err != nil {
+			return g, err
+		}
+	}
+	now := time.Now().Unix()
+	g.Created = now
+	g.Updated = now
+	if err := m.Store.Insert(ctx, m.Collection, g.record()); err != nil {
+		if errors.Is(err, storage.ErrDuplicate) {
+			return g, fmt.Errorf("%w: %s", ErrGroupExists, g.Name)
+		}
+		return g, err
+	}
+	return g, nil
+}
+
+// Get returns group with given name
+func (m *Manager) Get(ctx context.Context, name string) (Group, error) {
+	rec, err := storage.FindOne(ctx, m.Store, m.Collection, map[string]any{"_id": name})
+	if errors.Is(err, storage.ErrNotFound) {
+		return Group{}, fmt.Errorf("%w: %s", ErrGroupNotFound, name)
+	}
+	if err != nil {
+		return Group{}, err
+	}
+	return groupRecord(rec), nil
+}
+
+// List returns all groups ordered by name
+func (m *Manager) List(ctx context.Context) ([]Group, error) {
+	records, err := m.Store.Find(ctx, m.Collection, nil, &storage.FindOptions{Sort: []string{"_id"}})
+	if err != nil {
+		return nil, err
+	}
+	var out []Group
+	for _, rec := range records {
+		out = append(out, groupRecord(rec))
+	}
+	return out, nil
+}
+
+// Delete deletes the group and removes it from groups which include it
+func (m *Manager) Delete(ctx context.Context, name string) error {
+	n, err := m.Store.Remove(ctx, m.Collection, map[string]any{"_id": name})
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: %s", ErrGroupNotFound, name)
+	}
+	list, err := m.List(ctx)
+	if err != nil {
+		return err
+	}
+	for _, g := range list {
+		if utils.InList(name, g.Groups) {
+			if err := m.update(ctx, g.Name, map[string]any{"groups": remove(g.Groups, name)}); err != nil {
+				log.Printf("ERROR: unable to remove group %s from group %s, error %v", name, g.Name, err)
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// helper function to update group fields
+func (m *Manager) update(ctx context.Context, name string, fields map[string]any) error {
+	fields["updated"] = time.Now().Unix()
+	n, err := m.Store.Update(ctx, m.Collection, map[string]any{"_id": name}, fields)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: %s", ErrGroupNotFound, name)
+	}
+	return nil
+}
+
+// helper function to remove value from the list
+func remove(list []string, val string) []string {
+	var out []string
+	for _, v := range list {
+		if v != val {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// helper function to get local group which may be modified
+func (m *Manager) local(ctx context.Context, name string) (Group, error) {
+	g, err := m.Get(ctx, name)
+	if err != nil {
+		return g, err
+	}
+	if g.Source == SourceLDAP {
+		return g, fmt.Errorf("%w: %s", ErrReadOnly, name)
+	}
+	return g, nil
+}
+
+// AddMember adds user to the group
+func (m *Manager) AddMember(ctx context.Context, name, user string) error {
+	if user == "" {
+		return fmt.Errorf("%w member, empty user name", ErrInvalid)
+	}
+	g, err := m.local(ctx, name)
+	if err != nil {
+		return err
+	}
+	if utils.InList(user, g.Members) {
+		return nil
+	}
+	return m.update(ctx, name, map[string]any{"members": normalize(append(g.Members, user))})
+}
+
+// RemoveMember removes user from the group
+func (m *Manager) RemoveMember(ctx context.Context, name, user string) error {
+	g, err := m.local(ctx, name)
+	if err != nil {
+		return err
+	}
+	return m.update(ctx, name, map[string]any{"members": remove(g.Members, user)})
+}
+
+// AddGroup adds nested group to the group, groups which would include
+// themselves are rejected
+func (m *Manager) AddGroup(ctx context.Context, name, sub string) error {
+	g, err := m.local(ctx, name)
+	if err != nil {
+		return err
+	}
+	if _, err := m.Get(ctx, sub); err != nil {
+		return err
+	}
+	if utils.InList(sub, g.Groups) {
+		return nil
+	}
+	all, err := m.all(ctx)
+	if err != nil {
+		return err
+	}
+	if sub == name || utils.InList(name, m.descendants(all, sub)) {
+		return fmt.Errorf("%w, group %s includes %s", ErrCycle, sub, name)
+	}
+	return m.update(ctx, name, map[string]any{"groups": normalize(append(g.Groups, sub))})
+}
+
+// RemoveGroup removes nested group from the group
+func (m *Manager) RemoveGroup(ctx context.Context, name, sub string) error {
+	g, err := m.local(ctx, name)
+	if err != nil {
+		return err
+	}
+	return m.update(ctx, name, map[string]any{"groups": remove(g.Groups, sub)})
+}
+
+// helper function to load all groups by name
+func (m *Manager) all(ctx context.Context) (map[string]Group, error) {
+	list, err := m.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]Group, len(list))
+	for _, g := range list {
+		out[g.Name] = g
+	}
+	return out, nil
+}
+
+// helper function to provide nested groups of the group up to MaxDepth
+func (m *Manager) descendants(all map[string]Group, name string) []string {
+	var out []string
+	visited := map[string]bool{name: true}
+	level := []string{name}
+	for depth := 0; depth < m.MaxDepth && len(level) > 0; depth++ {
+		var next []string
+		for _, n := range level {
+			for _, sub := range all[n].Groups {
+				if !visited[sub] {
+					visited[sub] = true
+					out = append(out, sub)
+					next = append(next, sub)
+				}
+			}
+		}
+		level = next
+	}
+	return out
+}
+
+// Members returns users of the group including members of nested groups
+func (m *Manager) Members(ctx context.Context, name string) ([]string, error) {
+	all, err := m.all(ctx)
+	if err != nil {
+		return nil, err
+	}
+	g, ok := all[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrGroupNotFound, name)
+	}
+	members := append([]string{}, g.Members...)
+	for _, sub := range m.descendants(all, name) {
+		members = append(members, all[sub].Members...)
+	}
+	return normalize(members), nil
+}
+
+// UserGroups returns groups of the user including groups which include
+// them via nested groups. It implements policy.GroupResolver interface.
+func (m *Manager) UserGroups(ctx context.Context, user string) ([]string, error) {
+	if user == "" {
+		return nil, nil
+	}
+	all, err := m.all(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for name := range all {
+		if utils.InList(user, all[name].Members) {
+			out = append(out, name)
+			continue
+		}
+		for _, sub := range m.descendants(all, name) {
+			if utils.InList(user, all[sub].Members) {
+				out = append(out, name)
+				break
+			}
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
@@ -0,0 +1,236 @@
+package groups
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	srvConfig "github.com/CHESSComputing/golib/config"
+	storage "github.com/CHESSComputing/golib/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// helper function to create groups manager of memory store
+func testManager() *Manager {
+	return NewManager(storage.NewMemoryStore(), srvConfig.Groups{})
+}
+
+// TestGroups
+func TestGroups(t *testing.T) {
+	ctx := context.Background()
+	m := testManager()
+	if _, err := m.Create(ctx, Group{Name: "staff", Members: []string{"carol", "bob", "bob"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Create(ctx, Group{Name: "p-1234", Members: []string{"alice"}, Groups: []string{"staff"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Create(ctx, Group{Name: "staff"}); !errors.Is(err, ErrGroupExists) {
+		t.Errorf("duplicate group is created, error %v", err)
+	}
+	if _, err := m.Create(ctx, Group{Name: "bad name"}); !errors.Is(err, ErrInvalid) {
+		t.Errorf("group with invalid name is created, error %v", err)
+	}
+	if _, err := m.Create(ctx, Group{Name: "p-1", Groups: []string{"unknown"}}); !errors.Is(err, ErrGroupNotFound) {
+		t.Errorf("group with unknown nested group is created, error %v", err)
+	}
+	if g, err := m.Get(ctx, "staff"); err != nil || !reflect.DeepEqual(g.Members, []string{"bob", "carol"}) {
+		t.Errorf("wrong group %+v, error %v", g, err)
+	}
+
+	// members of nested groups belong to the group
+	if members, err := m.Members(ctx, "p-1234"); err != nil || !reflect.DeepEqual(members, []string{"alice", "bob", "carol"}) {
+		t.Errorf("wrong members %v, error %v", members, err)
+	}
+	if groups, err := m.UserGroups(ctx, "bob"); err != nil || !reflect.DeepEqual(groups, []string{"p-1234", "staff"}) {
+		t.Errorf("wrong groups of bob %v, error %v", groups, err)
+	}
+	if err := m.AddGroup(ctx, "staff", "p-1234"); !errors.Is(err, ErrCycle) {
+		t.Errorf("cycle of nested groups is accepted, error %v", err)
+	}
+
+	// membership changes
+	if err := m.AddMember(ctx, "staff", "dave"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.RemoveMember(ctx, "staff", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if groups, _ := m.UserGroups(ctx, "dave"); !reflect.DeepEqual(groups, []string{"p-1234", "staff"}) {
+		t.Errorf("wrong groups of dave %v", groups)
+	}
+	if groups, _ := m.UserGroups(ctx, "bob"); len(groups) != 0 {
+		t.Errorf("wrong groups of removed member %v", groups)
+	}
+	if err := m.AddMember(ctx, "unknown", "dave"); !errors.Is(err, ErrGroupNotFound) {
+		t.Errorf("member is added to unknown group, error %v", err)
+	}
+
+	// deleted group is removed from groups which include it
+	if err := m.Delete(ctx, "staff"); err != nil {
+		t.Fatal(err)
+	}
+	if g, err := m.Get(ctx, "p-1234"); err != nil || len(g.Groups) != 0 {
+		t.Errorf("deleted group is still nested %+v, error %v", g, err)
+	}
+	if err := m.Delete(ctx, "staff"); !errors.Is(err, ErrGroupNotFound) {
+		t.Errorf("unknown group is deleted, error %v", err)
+	}
+}
+
+// helper type of static directory
+type staticDirectory []Group
+
+// Groups implements Directory interface
+func (d staticDirectory) Groups(ctx context.Context) ([]Group, error) {
+	return d, nil
+}
+
+// TestSync
+func TestSync(t *testing.T) {
+	ctx := context.Background()
+	m := testManager()
+	m.Create(ctx, Group{Name: "staff", Members: []string{"carol"}})
+	dir := staticDirectory{
+		{Name: "p-1234", Members: []string{"alice", "bob"}},
+		{Name: "p-5678", Members: []string{"bob"}},
+		{Name: "staff", Members: []string{"eve"}},
+		{Name: "bad name"},
+	}
+	res, err := m.Sync(ctx, dir)
+	if err != nil || res != (SyncResult{Created: 2, Skipped: 2}) {
+		t.Errorf("wrong sync result %+v, error %v", res, err)
+	}
+	if g, _ := m.Get(ctx, "staff"); g.Source != SourceLocal || !reflect.DeepEqual(g.Members, []string{"carol"}) {
+		t.Errorf("local group is overwritten %+v", g)
+	}
+	if err := m.AddMember(ctx, "p-1234", "eve"); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("LDAP group is modified, error %v", err)
+	}
+	// local groups may include LDAP groups
+	if err := m.AddGroup(ctx, "staff", "p-5678"); err != nil {
+		t.Fatal(err)
+	}
+
+	dir = staticDirectory{{Name: "p-1234", Members: []string{"alice"}}}
+	res, err = m.Sync(ctx, dir)
+	if err != nil || res != (SyncResult{Updated: 1, Removed: 1}) {
+		t.Errorf("wrong sync result %+v, error %v", res, err)
+	}
+	if groups, _ := m.UserGroups(ctx, "bob"); len(groups) != 0 {
+		t.Errorf("wrong groups of bob after sync %v", groups)
+	}
+	if g, _ := m.Get(ctx, "staff"); len(g.Groups) != 0 {
+		t.Errorf("removed LDAP group is still nested %+v", g)
+	}
+}
+
+// TestLDAPDirectory
+func TestLDAPDirectory(t *testing.T) {
+	dir := t.TempDir()
+	// fake ldapsearch prints LDIF and checks that password is passed via file
+	script := `#!/bin/sh
+while [ $# -gt 0 ]; do
+  if [ "$1" = "-y" ]; then [ "$(cat $2)" = "secret" ] || exit 49; fi
+  shift
+done
+cat <<EOF
+# extended LDIF
+dn: cn=p-1234,ou=groups,dc=example,dc=org
+cn: p-1234
+memberUid: alice
+memberUid: b
+ ob
+
+dn: cn=staff,ou=groups,dc=example,dc=org
+cn:: c3RhZmY=
+memberUid: uid=carol,ou=people,dc=example,dc=org
+EOF
+`
+	fname := filepath.Join(dir, "ldapsearch")
+	if err := os.WriteFile(fname, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	cmd := LDAPSearchCommand
+	LDAPSearchCommand = fname
+	defer func() { LDAPSearchCommand = cmd }()
+
+	d := NewLDAPDirectory(srvConfig.LDAP{URL: "ldap://localhost", BaseDN: "dc=example,dc=org", BindDN: "cn=foxden", Password: "secret"})
+	groups, err := d.Groups(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []Group{
+		{Name: "p-1234", Members: []string{"alice", "bob"}, Source: SourceLDAP},
+		{Name: "staff", Members: []string{"carol"}, Source: SourceLDAP},
+	}
+	if !reflect.DeepEqual(groups, expect) {
+		t.Errorf("wrong LDAP groups %+v", groups)
+	}
+	d.Password = "wrong"
+	if _, err := d.Groups(context.Background()); err == nil {
+		t.Error("failed LDAP search is accepted")
+	}
+	if _, err := ParseLDIF([]byte("dn: cn=a\ninvalid line\n")); err == nil {
+		t.Error("invalid LDIF is accepted")
+	}
+}
+
+// TestHandlers
+func TestHandlers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	m := testManager()
+	m.Directory = staticDirectory{{Name: "p-1234", Members: []string{"alice"}}}
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		claims := &authz.Claims{CustomClaims: authz.CustomClaims{User: c.GetHeader("X-User"), Roles: c.Request.Header.Values("X-Role")}}
+		c.Set("claims", claims)
+	})
+	for _, route := range m.Routes("/groups") {
+		r.Handle(route.Method, route.Path, route.Handler)
+	}
+	expect := []struct {
+		method, target, role, body string
+		code                       int
+	}{
+		{"POST", "/groups", "admin", `{"name": "staff", "members": ["bob"]}`, http.StatusCreated},
+		{"POST", "/groups", "", `{"name": "other"}`, http.StatusForbidden},
+		{"POST", "/groups", "admin", `{"name": "staff"}`, http.StatusConflict},
+		{"POST", "/groups", "admin", `{"name": ""}`, http.StatusBadRequest},
+		{"POST", "/groups/sync", "admin", "", http.StatusOK},
+		{"PUT", "/groups/staff/groups/p-1234", "admin", "", http.StatusOK},
+		{"PUT", "/groups/staff/members/carol", "admin", "", http.StatusOK},
+		{"PUT", "/groups/p-1234/members/carol", "admin", "", http.StatusConflict},
+		{"PUT", "/groups/p-1234/groups/staff", "admin", "", http.StatusConflict},
+		{"DELETE", "/groups/staff/members/bob", "admin", "", http.StatusOK},
+		{"GET", "/groups/unknown", "admin", "", http.StatusNotFound},
+		{"GET", "/groups", "admin", "", http.StatusOK},
+		{"DELETE", "/groups/p-1234", "admin", "", http.StatusNoContent},
+	}
+	for _, e := range expect {
+		req := httptest.NewRequest(e.method, e.target, strings.NewReader(e.body))
+		req.Header.Set("X-User", "root")
+		if e.role != "" {
+			req.Header.Set("X-Role", e.role)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != e.code {
+			t.Errorf("%s %s: expect %d, got %d %s", e.method, e.target, e.code, w.Code, w.Body.String())
+		}
+	}
+	req := httptest.NewRequest("GET", "/groups/staff", nil)
+	req.Header.Set("X-Role", "admin")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if body := w.Body.String(); w.Code != http.StatusOK || !strings.Contains(body, `"all_members":["carol"]`) {
+		t.Errorf("wrong group %d %s", w.Code, body)
+	}
+}
@@ -0,0 +1,179 @@
+package groups
+
+// handlers module provides admin HTTP endpoints of groups
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	server "github.com/CHESSComputing/golib/server"
+	services "github.com/CHESSComputing/golib/services"
+	utils "github.com/CHESSComputing/golib/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// AdminRoles defines roles which may manage groups
+var AdminRoles = []string{"admin"}
+
+// GroupInfo represents group with members of its nested groups
+type GroupInfo struct {
+	Group
+	AllMembers []string `json:"all_members"`
+}
+
+// helper function to abort request with service error
+func abort(c *gin.Context, code, srvCode int, err error) {
+	rec := services.Response("groups", code, srvCode, err)
+	c.AbortWithStatusJSON(code, rec)
+}
+
+// helper function to abort request with error of the manager
+func abortWithError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrGroupNotFound):
+		abort(c, http.StatusNotFound, services.QueryError, err)
+	case errors.Is(err, ErrGroupExists), errors.Is(err, ErrReadOnly):
+		abort(c, http.StatusConflict, services.ConflictError, err)
+	case errors.Is(err, ErrInvalid), errors.Is(err, ErrCycle):
+		abort(c, http.StatusBadRequest, services.ValidateError, err)
+	default:
+		log.Printf("ERROR: groups request failed, error %v", err)
+		abort(c, http.StatusInternalServerError, services.DatabaseError, err)
+	}
+}
+
+// helper function to wrap handler which requires one of AdminRoles, token
+// claims are provided by token validation middleware
+func admin(handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if val, ok := c.Get("claims"); ok {
+			if claims, ok := val.(*authz.Claims); ok {
+				for _, role := range claims.CustomClaims.Roles {
+					if utils.InList(role, AdminRoles) {
+						handler(c)
+						return
+					}
+				}
+			}
+		}
+		abort(c, http.StatusForbidden, services.PolicyError, errors.New("groups management requires administrator role"))
+	}
+}
+
+// ListHandler provides list of groups
+func (m *Manager) ListHandler(c *gin.Context) {
+	list, err := m.List(c.Request.Context())
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if list == nil {
+		list = []Group{}
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// CreateHandler creates local group
+func (m *Manager) CreateHandler(c *gin.Context) {
+	var g Group
+	if err := c.ShouldBindJSON(&g); err != nil {
+		abort(c, http.StatusBadRequest, services.BindError, err)
+		return
+	}
+	g.Source = SourceLocal
+	g, err := m.Create(c.Request.Context(), g)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, g)
+}
+
+// GetHandler provides group with members of its nested groups
+func (m *Manager) GetHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+	g, err := m.Get(ctx, c.Param("name"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	members, err := m.Members(ctx, g.Name)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, GroupInfo{Group: g, AllMembers: members})
+}
+
+// DeleteHandler deletes the group
+func (m *Manager) DeleteHandler(c *gin.Context) {
+	if err := m.Delete(c.Request.Context(), c.Param("name")); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// helper function to provide handler of membership changes
+func (m *Manager) membership(change func(m *Manager, c *gin.Context) error) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := change(m, c); err != nil {
+			abortWithError(c, err)
+			return
+		}
+		m.GetHandler(c)
+	}
+}
+
+// SyncHandler synchronizes groups of LDAP directory
+func (m *Manager) SyncHandler(c *gin.Context) {
+	if m.Directory == nil {
+		abort(c, http.StatusNotImplemented, services.NotImplementedApiCode, errors.New("LDAP directory is not configured"))
+		return
+	}
+	res, err := m.Sync(c.Request.Context(), m.Directory)
+	if err != nil {
+		log.Printf("ERROR: synchronization of LDAP groups failed, error %v", err)
+		abort(c, http.StatusBadGateway, services.ServiceError, err)
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// Routes returns admin routes of groups under given path, e.g. /groups
+func (m *Manager) Routes(path string) []server.Route {
+	addMember := func(m *Manager, c *gin.Context) error {
+		return m.AddMember(c.Request.Context(), c.Param("name"), c.Param("member"))
+	}
+	removeMember := func(m *Manager, c *gin.Context) error {
+		return m.RemoveMember(c.Request.Context(), c.Param("name"), c.Param("member"))
+	}
+	addGroup := func(m *Manager, c *gin.Context) error {
+		return m.AddGroup(c.Request.Context(), c.Param("name"), c.Param("group"))
+	}
+	removeGroup := func(m *Manager, c *gin.Context) error {
+		return m.RemoveGroup(c.Request.Context(), c.Param("name"), c.Param("group"))
+	}
+	return []server.Route{
+		{Method: "GET", Path: path, Authorized: true, Scope: "read", Handler: admin(m.ListHandler),
+			Summary: "list groups", Response: []Group{}},
+		{Method: "POST", Path: path, Authorized: true, Scope: "write", Handler: admin(m.CreateHandler),
+			Summary: "create group", Request: Group{}, Response: Group{}},
+		{Method: "POST", Path: path + "/sync", Authorized: true, Scope: "write", Handler: admin(m.SyncHandler),
+			Summary: "synchronize groups of LDAP directory", Response: SyncResult{}},
+		{Method: "GET", Path: path + "/:name", Authorized: true, Scope: "read", Handler: admin(m.GetHandler),
+			Summary: "get group", Response: GroupInfo{}},
+		{Method: "DELETE", Path: path + "/:name", Authorized: true, Scope: "write", Handler: admin(m.DeleteHandler),
+			Summary: "delete group"},
+		{Method: "PUT", Path: path + "/:name/members/:member", Authorized: true, Scope: "write", Handler: admin(m.membership(addMember)),
+			Summary: "add member to the group", Response: GroupInfo{}},
+		{Method: "DELETE", Path: path + "/:name/members/:member", Authorized: true, Scope: "write", Handler: admin(m.membership(removeMember)),
+			Summary: "remove member from the group", Response: GroupInfo{}},
+		{Method: "PUT", Path: path + "/:name/groups/:group", Authorized: true, Scope: "write", Handler: admin(m.membership(addGroup)),
+			Summary: "add nested group to the group", Response: GroupInfo{}},
+		{Method: "DELETE", Path: path + "/:name/groups/:group", Authorized: true, Scope: "write", Handler: admin(m.membership(removeGroup)),
+			Summary: "remove nested group from the group", Response: GroupInfo{}},
+	}
+}
@@ -0,0 +1,263 @@
+package groups
+
+// ldap module synchronizes groups from LDAP directory. Directory is
+// queried via ldapsearch command of OpenLDAP client tools, synchronized
+// groups are read-only and local groups are never overwritten.
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+)
+
+// LDAPSearchCommand defines ldapsearch command of OpenLDAP client tools
+var LDAPSearchCommand = "ldapsearch"
+
+// defaults of LDAP directory
+var (
+	DefaultFilter       = "(objectClass=posixGroup)"
+	DefaultNameAttr     = "cn"
+	DefaultMemberAttr   = "memberUid"
+	DefaultSyncInterval = time.Hour
+)
+
+// Directory defines interface of external directory of groups
+type Directory interface {
+	// Groups returns groups of the directory with their members
+	Groups(ctx context.Context) ([]Group, error)
+}
+
+// LDAPDirectory represents LDAP directory of groups
+type LDAPDirectory struct {
+	URL        string // LDAP server URL, e.g. ldaps://ldap.example.org
+	BaseDN     string // search base, e.g. ou=groups,dc=example,dc=org
+	BindDN     string // bind DN, anonymous bind is used if empty
+	Password   string // bind password
+	Filter     string // search filter of groups
+	NameAttr   string // attribute of group names
+	MemberAttr string // attribute of group members, user names or DNs
+}
+
+// NewLDAPDirectory returns LDAP directory of given configuration
+func NewLDAPDirectory(cfg srvConfig.LDAP) *LDAPDirectory {
+	d := &LDAPDirectory{
+		URL:        cfg.URL,
+		BaseDN:     cfg.BaseDN,
+		BindDN:     cfg.BindDN,
+		Password:   cfg.Password,
+		Filter:     cfg.Filter,
+		NameAttr:   cfg.NameAttr,
+		MemberAttr: cfg.MemberAttr,
+	}
+	if d.Filter == "" {
+		d.Filter = DefaultFilter
+	}
+	if d.NameAttr == "" {
+		d.NameAttr = DefaultNameAttr
+	}
+	if d.MemberAttr == "" {
+		d.MemberAttr = DefaultMemberAttr
+	}
+	return d
+}
+
+// Groups implements Directory interface
+func (d *LDAPDirectory) Groups(ctx context.Context) ([]Group, error) {
+	if d.URL == "" || d.BaseDN == "" {
+		return nil, errors.New("LDAP URL and BaseDN are not configured")
+	}
+	args := []string{"-x", "-LLL", "-o", "ldif-wrap=no", "-H", d.URL, "-b", d.BaseDN}
+	if d.BindDN != "" {
+		// password is passed via file to not expose it in process list
+		file, err := os.CreateTemp("", "ldap-*")
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(file.Name())
+		_, err = file.WriteString(d.Password)
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "-D", d.BindDN, "-y", file.Name())
+	}
+	args = append(args, d.Filter, d.NameAttr, d.MemberAttr)
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, LDAPSearchCommand, args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		log.Printf("ERROR: LDAP search of %s failed, error %v: %s", d.URL, err, strings.TrimSpace(stderr.String()))
+		return nil, fmt.Errorf("LDAP search failed, error %v", err)
+	}
+	entries, err := ParseLDIF(out)
+	if err != nil {
+		return nil, err
+	}
+	var groups []Group
+	for _, e := range entries {
+		names := e[strings.ToLower(d.NameAttr)]
+		if len(names) == 0 {
+			continue
+		}
+		g := Group{Name: names[0], Source: SourceLDAP}
+		for _, member := range e[strings.ToLower(d.MemberAttr)] {
+			g.Members = append(g.Members, memberName(member))
+		}
+		groups = append(groups, g)
+	}
+	return groups, nil
+}
+
+// helper function to provide user name of the member given either by name
+// or DN, e.g. uid=alice,ou=people,dc=example,dc=org
+func memberName(member string) string {
+	rdn, _, _ := strings.Cut(member, ",")
+	if _, val, ok := strings.Cut(rdn, "="); ok && strings.Contains(member, ",") {
+		return strings.TrimSpace(val)
+	}
+	return strings.TrimSpace(member)
+}
+
+// ParseLDIF parses LDIF entries into maps of lower case attribute names
+// and their values
+func ParseLDIF(data []byte) ([]map[string][]string, error) {
+	var out []map[string][]string
+	var lines []string
+	// unfold continuation lines which start with single space
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.HasPrefix(line, " ") && len(lines) > 0 && lines[len(lines)-1] != "" {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	entry := make(map[string][]string)
+	for _, line := range append(lines, "") {
+		if line == "" {
+			if len(entry) > 0 {
+				out = append(out, entry)
+				entry = make(map[string][]string)
+			}
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		attr, val, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid LDIF line '%s'", line)
+		}
+		if strings.HasPrefix(val, ":") {
+			decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(val[1:]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid base64 value of LDIF attribute %s, error %v", attr, err)
+			}
+			val = string(decoded)
+		}
+		attr = strings.ToLower(attr)
+		entry[attr] = append(entry[attr], strings.TrimSpace(val))
+	}
+	return out, nil
+}
+
+// SyncResult represents result of groups synchronization
+type SyncResult struct {
+	Created int `json:"created"`
+	Updated int `json:"updated"`
+	Removed int `json:"removed"`
+	Skipped int `json:"skipped"` // invalid groups and groups which clash with local ones
+}
+
+// Sync synchronizes groups of the directory, LDAP groups which are no
+// longer present in the directory are removed and local groups with the
+// same name are kept
+func (m *Manager) Sync(ctx context.Context, dir Directory) (SyncResult, error) {
+	var res SyncResult
+	groups, err := dir.Groups(ctx)
+	if err != nil {
+		return res, err
+	}
+	all, err := m.all(ctx)
+	if err != nil {
+		return res, err
+	}
+	seen := make(map[string]bool)
+	for _, g := range groups {
+		if err := validName(g.Name); err != nil {
+			log.Printf("WARNING: skip LDAP group, error %v", err)
+			res.Skipped++
+			continue
+		}
+		seen[g.Name] = true
+		g.Members = normalize(g.Members)
+		old, ok := all[g.Name]
+		switch {
+		case !ok:
+			g.Source = SourceLDAP
+			g.Groups = nil
+			if _, err := m.Create(ctx, g); err != nil {
+				return res, err
+			}
+			res.Created++
+		case old.Source != SourceLDAP:
+			log.Printf("WARNING: skip LDAP group %s which clashes with local group", g.Name)
+			res.Skipped++
+		case !reflect.DeepEqual(old.Members, g.Members):
+			if err := m.update(ctx, g.Name, map[string]any{"members": g.Members}); err != nil {
+				return res, err
+			}
+			res.Updated++
+		}
+	}
+	for name, g := range all {
+		if g.Source == SourceLDAP && !seen[name] {
+			if err := m.Delete(ctx, name); err != nil {
+				return res, err
+			}
+			res.Removed++
+		}
+	}
+	return res, nil
+}
+
+// Run periodically synchronizes groups of the directory until context is
+// cancelled
+func (m *Manager) Run(ctx context.Context, dir Directory, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultSyncInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if res, err := m.Sync(ctx, dir); err != nil {
+			if ctx.Err() == nil {
+				log.Printf("ERROR: synchronization of LDAP groups failed, error %v", err)
+			}
+		} else if res.Created+res.Updated+res.Removed > 0 {
+			log.Printf("synchronized LDAP groups: %d created, %d updated, %d removed", res.Created, res.Updated, res.Removed)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package grpc
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	srvConfig "github.com/CHESSComputing/golib/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// ClientOptions represents options of gRPC client
+type ClientOptions struct {
+	Token       string                                    // JWT access token
+	TokenSource func(ctx context.Context) (string, error) // provides token of every call, e.g. Token of login.Session
+	APIKey      string                                    // API key used instead of token
+	RootCAs     string                                    // root CAs file used to verify server certificate
+	ClientCert  string                                    // client certificate of the service used for mutual TLS
+	ClientKey   string                                    // key of client certificate
+}
+
+// perRPCCredentials passes token or API key with every gRPC call
+type perRPCCredentials struct {
+	opts   ClientOptions
+	secure bool
+}
+
+// GetRequestMetadata implements credentials.PerRPCCredentials interface
+func (c perRPCCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	if c.opts.APIKey != "" {
+		return map[string]string{strings.ToLower(authz.APIKeyHeader): c.opts.APIKey}, nil
+	}
+	if c.opts.TokenSource != nil {
+		token, err := c.opts.TokenSource(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]string{"authorization": "Bearer " + token}, nil
+	}
+	return map[string]string{"authorization": "Bearer " + c.opts.Token}, nil
+}
+
+// RequireTransportSecurity implements credentials.PerRPCCredentials interface
+func (c perRPCCredentials) RequireTransportSecurity() bool {
+	return c.secure
+}
+
+// Dial connects to gRPC server with given address. Addresses with grpcs://
+// scheme use TLS, grpc:// scheme or plain host:port addresses use insecure
+// connection, e.g. within cluster.
+func Dial(addr string, opts ClientOptions, extra ...grpc.DialOption) (*grpc.ClientConn, error) {
+	if addr == "" {
+		return nil, errors.New("empty gRPC address")
+	}
+	var dialOpts []grpc.DialOption
+	secure := false
+	switch {
+	case strings.HasPrefix(addr, "grpcs://"):
+		secure = true
+		addr = strings.TrimPrefix(addr, "grpcs://")
+		tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+		if opts.RootCAs != "" {
+			data, err := os.ReadFile(opts.RootCAs)
+			if err != nil {
+				return nil, err
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(data) {
+				return nil, fmt.Errorf("unable to load root CAs from %s", opts.RootCAs)
+			}
+			tlsConfig.RootCAs = pool
+		}
+		if opts.ClientCert != "" {
+			cert, err := tls.LoadX509KeyPair(opts.ClientCert, opts.ClientKey)
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+		dialOpts = append(dialOpts, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	default:
+		addr = strings.TrimPrefix(addr, "grpc://")
+		dialOpts = append(dialOpts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	}
+	if opts.Token != "" || opts.TokenSource != nil || opts.APIKey != "" {
+		dialOpts = append(dialOpts, grpc.WithPerRPCCredentials(perRPCCredentials{opts: opts, secure: secure}))
+	}
+	return grpc.Dial(addr, append(dialOpts, extra...)...)
+}
+
+// MetaDataConn connects to metadata service using Services configuration
+func MetaDataConn(opts ClientOptions) (*grpc.ClientConn, error) {
+	if srvConfig.Config == nil {
+		return nil, errors.New("configuration is not initialized")
+	}
+	return Dial(srvConfig.Config.Services.MetaDataGRPC, opts)
+}
+
+// DiscoveryConn connects to discovery service using Services configuration
+func DiscoveryConn(opts ClientOptions) (*grpc.ClientConn, error) {
+	if srvConfig.Config == nil {
+		return nil, errors.New("configuration is not initialized")
+	}
+	return Dial(srvConfig.Config.Services.DiscoveryGRPC, opts)
+}
+
+// Records reads all records from the stream
+func Records(stream RecordStream) ([]map[string]any, error) {
+	var records []map[string]any
+	for {
+		rec, err := stream.Recv()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return records, err
+		}
+		records = append(records, rec.AsMap())
+	}
+}
+
+// ToStruct converts record into protobuf struct, record values are
+// normalized via their JSON representation, e.g. lists of strings
+func ToStruct(rec map[string]any) (*structpb.Struct, error) {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+	var out map[string]any
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, err
+	}
+	return structpb.NewStruct(out)
+}
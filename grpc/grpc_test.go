@@ -0,0 +1,146 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	srvConfig "github.com/CHESSComputing/golib/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// testMetaData implements MetaDataServer interface
+type testMetaData struct {
+	records []map[string]any
+}
+
+func (s *testMetaData) Search(query *structpb.Struct, stream RecordSender) error {
+	for _, rec := range s.records {
+		if rec["beamline"] != query.AsMap()["beamline"] {
+			continue
+		}
+		msg, err := ToStruct(rec)
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *testMetaData) Get(ctx context.Context, spec *structpb.Struct) (*structpb.Struct, error) {
+	claims, _ := ClaimsFromContext(ctx)
+	return ToStruct(map[string]any{"user": claims.CustomClaims.User})
+}
+
+func (s *testMetaData) Insert(stream RecordReceiver) error {
+	var n int
+	for {
+		rec, err := stream.Recv()
+		if err == io.EOF {
+			summary, _ := ToStruct(map[string]any{"inserted": n})
+			return stream.SendAndClose(summary)
+		}
+		if err != nil {
+			return err
+		}
+		s.records = append(s.records, rec.AsMap())
+		n++
+	}
+}
+
+// TestServer
+func TestServer(t *testing.T) {
+	secret := "test-secret"
+	opts := DefaultOptions()
+	opts.ClientID = secret
+	srv, err := NewServer(srvConfig.WebServer{}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	impl := &testMetaData{}
+	RegisterMetaDataServer(srv, impl)
+	listener := bufconn.Listen(1024 * 1024)
+	go srv.Serve(listener)
+	defer srv.Stop()
+
+	dialer := grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+		return listener.DialContext(ctx)
+	})
+	ctx := context.Background()
+	token, _ := authz.JWTAccessToken(secret, 60, authz.CustomClaims{User: "daq", Scope: "write"})
+	conn, err := Dial("bufnet", ClientOptions{Token: token}, dialer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := NewMetaDataClient(conn)
+
+	// stream records to the server
+	stream, err := client.Insert(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		rec, _ := ToStruct(map[string]any{"did": fmt.Sprintf("/beamline=3a/id=%d", i), "beamline": "3a", "tags": []string{"raw"}})
+		if err := stream.Send(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	summary, err := stream.CloseAndRecv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.AsMap()["inserted"] != float64(10) {
+		t.Errorf("unexpected insert summary %v", summary.AsMap())
+	}
+
+	// search records
+	query, _ := ToStruct(map[string]any{"beamline": "3a"})
+	results, err := client.Search(ctx, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := Records(results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 10 {
+		t.Errorf("unexpected number of records %d", len(records))
+	}
+
+	// claims are available to handlers
+	rec, err := client.Get(ctx, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.AsMap()["user"] != "daq" {
+		t.Errorf("unexpected user %v", rec.AsMap())
+	}
+
+	// read token of token source can not insert records
+	readToken, _ := authz.JWTAccessToken(secret, 60, authz.CustomClaims{User: "daq", Scope: "read"})
+	source := func(ctx context.Context) (string, error) { return readToken, nil }
+	readConn, _ := Dial("bufnet", ClientOptions{TokenSource: source}, dialer)
+	defer readConn.Close()
+	stream, _ = NewMetaDataClient(readConn).Insert(ctx)
+	if _, err := stream.CloseAndRecv(); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected permission denied, got %v", err)
+	}
+
+	// calls without token are rejected
+	anonConn, _ := Dial("bufnet", ClientOptions{}, dialer)
+	defer anonConn.Close()
+	if _, err := NewMetaDataClient(anonConn).Get(ctx, query); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected unauthenticated error, got %v", err)
+	}
+}
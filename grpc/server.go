@@ -0,0 +1,267 @@
+package grpc
+
+// grpc module provides gRPC servers and clients for FOXDEN/CHESS services,
+// e.g. for high-throughput DAQ ingestion via streaming. Servers are built
+// from WebServer configuration and use interceptors for authorization,
+// logging and metrics which mirror HTTP middleware of server module.
+// The module requires grpc build tag: go build -tags grpc
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	srvConfig "github.com/CHESSComputing/golib/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// TotalRequests counts total number of gRPC calls received by the server
+var TotalRequests uint64
+
+// TotalStreams counts total number of gRPC streams opened by clients
+var TotalStreams uint64
+
+// TotalErrors counts total number of failed gRPC calls
+var TotalErrors uint64
+
+// Options represents options of gRPC server
+type Options struct {
+	ClientID string                   // secret used to validate JWT tokens
+	APIKeys  *authz.APIKeys           // API keys accepted along with JWT tokens
+	Public   []string                 // full names of methods which do not require authorization
+	Scopes   map[string]string        // token scopes required by methods
+	Services *authz.ServiceIdentities // peer services authorized by client certificates
+	Verbose  int
+}
+
+// DefaultOptions returns server options from authz configuration, insert
+// methods require write scope
+func DefaultOptions() Options {
+	opts := Options{Scopes: map[string]string{MetaDataInsertMethod: "write"}}
+	if srvConfig.Config != nil {
+		opts.ClientID = srvConfig.Config.Authz.ClientID
+		if srvConfig.Config.Authz.MutualTLS.Enabled {
+			opts.Services = authz.NewServiceIdentities(srvConfig.Config.Authz.MutualTLS)
+		}
+	}
+	return opts
+}
+
+// claimsKey represents context key of token claims
+type claimsKey struct{}
+
+// ClaimsFromContext returns token claims of authorized gRPC call
+func ClaimsFromContext(ctx context.Context) (*authz.Claims, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(*authz.Claims)
+	return claims, ok
+}
+
+// helper function to get first value of metadata key
+func metadataValue(md metadata.MD, key string) string {
+	if vals := md.Get(key); len(vals) > 0 {
+		return vals[0]
+	}
+	return ""
+}
+
+// helper function to authorize gRPC call, it returns context with claims
+func (o Options) authorize(ctx context.Context, method string) (context.Context, error) {
+	for _, m := range o.Public {
+		if m == method {
+			return ctx, nil
+		}
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	var claims *authz.Claims
+	if c, ok := o.serviceClaims(ctx); ok {
+		claims = c
+	} else if apiKey := metadataValue(md, strings.ToLower(authz.APIKeyHeader)); apiKey != "" {
+		if o.APIKeys == nil {
+			return ctx, status.Error(codes.Unauthenticated, "API keys are not supported")
+		}
+		key, err := o.APIKeys.Validate(ctx, apiKey)
+		if err != nil {
+			return ctx, status.Error(codes.Unauthenticated, err.Error())
+		}
+		claims = key.Claims()
+	} else {
+		arr := strings.Split(metadataValue(md, "authorization"), " ")
+		tokenStr := arr[len(arr)-1]
+		token := &authz.Token{AccessToken: tokenStr}
+		if err := token.Validate(o.ClientID); err != nil {
+			return ctx, status.Errorf(codes.Unauthenticated, "invalid token, error %v", err)
+		}
+		var err error
+		if claims, err = authz.TokenClaims(tokenStr, o.ClientID); err != nil {
+			return ctx, status.Errorf(codes.Unauthenticated, "invalid token claims, error %v", err)
+		}
+	}
+	if scope, ok := o.Scopes[method]; ok && claims.CustomClaims.Scope != scope {
+		msg := fmt.Sprintf("token scope '%s' does not match with scope '%s'", claims.CustomClaims.Scope, scope)
+		return ctx, status.Error(codes.PermissionDenied, msg)
+	}
+	return context.WithValue(ctx, claimsKey{}, claims), nil
+}
+
+// helper function to get claims of peer service authenticated by client
+// certificate of the call
+func (o Options) serviceClaims(ctx context.Context) (*authz.Claims, bool) {
+	if o.Services == nil {
+		return nil, false
+	}
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, false
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return nil, false
+	}
+	claims, err := o.Services.ConnectionClaims(&info.State)
+	if err != nil {
+		if o.Verbose > 0 && !errors.Is(err, authz.ErrNoPeerCertificate) {
+			log.Printf("INFO: gRPC peer is not authorized as service, error %v", err)
+		}
+		return nil, false
+	}
+	return claims, true
+}
+
+// helper function to log gRPC call
+func (o Options) logCall(ctx context.Context, method string, time0 time.Time, err error) {
+	if err != nil {
+		atomic.AddUint64(&TotalErrors, 1)
+	}
+	if err == nil && o.Verbose == 0 {
+		return
+	}
+	addr := "unknown"
+	if p, ok := peer.FromContext(ctx); ok {
+		addr = p.Addr.String()
+	}
+	if err != nil {
+		log.Printf("ERROR: gRPC %s from %s code %s time %v error %v", method, addr, status.Code(err), time.Since(time0), err)
+		return
+	}
+	log.Printf("gRPC %s from %s code %s time %v", method, addr, codes.OK, time.Since(time0))
+}
+
+// UnaryInterceptor provides authorization, logging and metrics of unary
+// gRPC calls
+func (o Options) UnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	time0 := time.Now()
+	atomic.AddUint64(&TotalRequests, 1)
+	ctx, err := o.authorize(ctx, info.FullMethod)
+	if err != nil {
+		o.logCall(ctx, info.FullMethod, time0, err)
+		return nil, err
+	}
+	resp, err := handler(ctx, req)
+	o.logCall(ctx, info.FullMethod, time0, err)
+	return resp, err
+}
+
+// serverStream wraps server stream to provide context with claims
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+// StreamInterceptor provides authorization, logging and metrics of
+// streaming gRPC calls
+func (o Options) StreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	time0 := time.Now()
+	atomic.AddUint64(&TotalRequests, 1)
+	atomic.AddUint64(&TotalStreams, 1)
+	ctx, err := o.authorize(ss.Context(), info.FullMethod)
+	if err != nil {
+		o.logCall(ctx, info.FullMethod, time0, err)
+		return err
+	}
+	err = handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	o.logCall(ctx, info.FullMethod, time0, err)
+	return err
+}
+
+// helper function to load TLS configuration of the server
+func serverTLS(webServer srvConfig.WebServer) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(webServer.ServerCrt, webServer.ServerKey)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if webServer.RootCAs != "" {
+		data, err := os.ReadFile(webServer.RootCAs)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("unable to load root CAs from %s", webServer.RootCAs)
+		}
+		tlsConfig.ClientCAs = pool
+	}
+	return tlsConfig, nil
+}
+
+// NewServer builds gRPC server from WebServer configuration, TLS is used
+// if server key is provided
+func NewServer(webServer srvConfig.WebServer, opts Options, extra ...grpc.ServerOption) (*grpc.Server, error) {
+	if opts.Verbose == 0 {
+		opts.Verbose = webServer.Verbose
+	}
+	serverOpts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(opts.UnaryInterceptor),
+		grpc.ChainStreamInterceptor(opts.StreamInterceptor),
+	}
+	if webServer.ServerKey != "" {
+		tlsConfig, err := serverTLS(webServer)
+		if err != nil {
+			log.Println("ERROR: unable to load gRPC server certificates", err)
+			return nil, err
+		}
+		if opts.Services != nil {
+			// client certificates of peer services are verified if provided
+			mtls, err := authz.ServerTLSConfig(srvConfig.Config.Authz.MutualTLS)
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig.ClientCAs = mtls.ClientCAs
+			tlsConfig.ClientAuth = mtls.ClientAuth
+		}
+		serverOpts = append(serverOpts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	return grpc.NewServer(append(serverOpts, extra...)...), nil
+}
+
+// StartServer starts gRPC server on GrpcPort of WebServer configuration
+func StartServer(srv *grpc.Server, webServer srvConfig.WebServer) error {
+	if webServer.GRPCPort == 0 {
+		return errors.New("gRPC port is not configured")
+	}
+	sport := fmt.Sprintf(":%d", webServer.GRPCPort)
+	listener, err := net.Listen("tcp", sport)
+	if err != nil {
+		return err
+	}
+	log.Println("Start gRPC server on port", sport)
+	return srv.Serve(listener)
+}
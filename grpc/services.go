@@ -0,0 +1,252 @@
+package grpc
+
+// services module provides gRPC service definitions of FOXDEN metadata and
+// discovery APIs, see proto/metadata.proto and proto/discovery.proto.
+// Records and queries are schema-less documents represented by
+// google.protobuf.Struct messages, therefore service descriptors are
+// defined here without generated message code.
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// full names of gRPC methods
+const (
+	MetaDataSearchMethod  = "/foxden.metadata.v1.MetaData/Search"
+	MetaDataGetMethod     = "/foxden.metadata.v1.MetaData/Get"
+	MetaDataInsertMethod  = "/foxden.metadata.v1.MetaData/Insert"
+	DiscoverySearchMethod = "/foxden.discovery.v1.Discovery/Search"
+)
+
+// RecordSender represents server stream of records sent to the client
+type RecordSender interface {
+	Send(*structpb.Struct) error
+	grpc.ServerStream
+}
+
+// RecordReceiver represents server stream of records sent by the client,
+// the summary is sent back via SendAndClose
+type RecordReceiver interface {
+	Recv() (*structpb.Struct, error)
+	SendAndClose(*structpb.Struct) error
+	grpc.ServerStream
+}
+
+// MetaDataServer defines interface of metadata service
+type MetaDataServer interface {
+	Search(query *structpb.Struct, stream RecordSender) error
+	Get(ctx context.Context, spec *structpb.Struct) (*structpb.Struct, error)
+	Insert(stream RecordReceiver) error
+}
+
+// DiscoveryServer defines interface of discovery service
+type DiscoveryServer interface {
+	Search(query *structpb.Struct, stream RecordSender) error
+}
+
+// recordSender implements RecordSender interface
+type recordSender struct {
+	grpc.ServerStream
+}
+
+func (s *recordSender) Send(rec *structpb.Struct) error {
+	return s.ServerStream.SendMsg(rec)
+}
+
+// recordReceiver implements RecordReceiver interface
+type recordReceiver struct {
+	grpc.ServerStream
+}
+
+func (s *recordReceiver) Recv() (*structpb.Struct, error) {
+	rec := new(structpb.Struct)
+	if err := s.ServerStream.RecvMsg(rec); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+func (s *recordReceiver) SendAndClose(summary *structpb.Struct) error {
+	return s.ServerStream.SendMsg(summary)
+}
+
+// MetaDataServiceDesc represents gRPC descriptor of metadata service
+var MetaDataServiceDesc = grpc.ServiceDesc{
+	ServiceName: "foxden.metadata.v1.MetaData",
+	HandlerType: (*MetaDataServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "Get", Handler: metaDataGetHandler},
+	},
+	Streams: []grpc.StreamDesc{
+		{StreamName: "Search", Handler: metaDataSearchHandler, ServerStreams: true},
+		{StreamName: "Insert", Handler: metaDataInsertHandler, ClientStreams: true},
+	},
+	Metadata: "metadata.proto",
+}
+
+// DiscoveryServiceDesc represents gRPC descriptor of discovery service
+var DiscoveryServiceDesc = grpc.ServiceDesc{
+	ServiceName: "foxden.discovery.v1.Discovery",
+	HandlerType: (*DiscoveryServer)(nil),
+	Streams: []grpc.StreamDesc{
+		{StreamName: "Search", Handler: discoverySearchHandler, ServerStreams: true},
+	},
+	Metadata: "discovery.proto",
+}
+
+// RegisterMetaDataServer registers metadata service implementation
+func RegisterMetaDataServer(s grpc.ServiceRegistrar, srv MetaDataServer) {
+	s.RegisterService(&MetaDataServiceDesc, srv)
+}
+
+// RegisterDiscoveryServer registers discovery service implementation
+func RegisterDiscoveryServer(s grpc.ServiceRegistrar, srv DiscoveryServer) {
+	s.RegisterService(&DiscoveryServiceDesc, srv)
+}
+
+func metaDataGetHandler(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+	in := new(structpb.Struct)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetaDataServer).Get(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: MetaDataGetMethod}
+	handler := func(ctx context.Context, req any) (any, error) {
+		return srv.(MetaDataServer).Get(ctx, req.(*structpb.Struct))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func metaDataSearchHandler(srv any, stream grpc.ServerStream) error {
+	query := new(structpb.Struct)
+	if err := stream.RecvMsg(query); err != nil {
+		return err
+	}
+	return srv.(MetaDataServer).Search(query, &recordSender{stream})
+}
+
+func metaDataInsertHandler(srv any, stream grpc.ServerStream) error {
+	return srv.(MetaDataServer).Insert(&recordReceiver{stream})
+}
+
+func discoverySearchHandler(srv any, stream grpc.ServerStream) error {
+	query := new(structpb.Struct)
+	if err := stream.RecvMsg(query); err != nil {
+		return err
+	}
+	return srv.(DiscoveryServer).Search(query, &recordSender{stream})
+}
+
+// RecordStream represents client stream of records sent by the server
+type RecordStream interface {
+	Recv() (*structpb.Struct, error)
+	grpc.ClientStream
+}
+
+// InsertStream represents client stream of records sent to the server
+type InsertStream interface {
+	Send(*structpb.Struct) error
+	CloseAndRecv() (*structpb.Struct, error)
+	grpc.ClientStream
+}
+
+// recordStream implements RecordStream interface
+type recordStream struct {
+	grpc.ClientStream
+}
+
+func (s *recordStream) Recv() (*structpb.Struct, error) {
+	rec := new(structpb.Struct)
+	if err := s.ClientStream.RecvMsg(rec); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// insertStream implements InsertStream interface
+type insertStream struct {
+	grpc.ClientStream
+}
+
+func (s *insertStream) Send(rec *structpb.Struct) error {
+	return s.ClientStream.SendMsg(rec)
+}
+
+func (s *insertStream) CloseAndRecv() (*structpb.Struct, error) {
+	if err := s.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	summary := new(structpb.Struct)
+	if err := s.ClientStream.RecvMsg(summary); err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+// helper function to open server stream with given query
+func search(ctx context.Context, cc grpc.ClientConnInterface, desc *grpc.StreamDesc, method string, query *structpb.Struct, opts ...grpc.CallOption) (RecordStream, error) {
+	stream, err := cc.NewStream(ctx, desc, method, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := stream.SendMsg(query); err != nil {
+		return nil, err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return &recordStream{stream}, nil
+}
+
+// MetaDataClient represents client of metadata service
+type MetaDataClient struct {
+	cc grpc.ClientConnInterface
+}
+
+// NewMetaDataClient returns metadata service client
+func NewMetaDataClient(cc grpc.ClientConnInterface) *MetaDataClient {
+	return &MetaDataClient{cc: cc}
+}
+
+// Search returns stream of metadata records matching the query
+func (c *MetaDataClient) Search(ctx context.Context, query *structpb.Struct, opts ...grpc.CallOption) (RecordStream, error) {
+	return search(ctx, c.cc, &MetaDataServiceDesc.Streams[0], MetaDataSearchMethod, query, opts...)
+}
+
+// Get returns metadata record matching the spec
+func (c *MetaDataClient) Get(ctx context.Context, spec *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error) {
+	out := new(structpb.Struct)
+	if err := c.cc.Invoke(ctx, MetaDataGetMethod, spec, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Insert opens stream to insert metadata records
+func (c *MetaDataClient) Insert(ctx context.Context, opts ...grpc.CallOption) (InsertStream, error) {
+	stream, err := c.cc.NewStream(ctx, &MetaDataServiceDesc.Streams[1], MetaDataInsertMethod, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &insertStream{stream}, nil
+}
+
+// DiscoveryClient represents client of discovery service
+type DiscoveryClient struct {
+	cc grpc.ClientConnInterface
+}
+
+// NewDiscoveryClient returns discovery service client
+func NewDiscoveryClient(cc grpc.ClientConnInterface) *DiscoveryClient {
+	return &DiscoveryClient{cc: cc}
+}
+
+// Search returns stream of discovery records matching the query
+func (c *DiscoveryClient) Search(ctx context.Context, query *structpb.Struct, opts ...grpc.CallOption) (RecordStream, error) {
+	return search(ctx, c.cc, &DiscoveryServiceDesc.Streams[0], DiscoverySearchMethod, query, opts...)
+}
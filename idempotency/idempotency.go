@@ -0,0 +1,140 @@
+package idempotency
+
+// idempotency module provides Idempotency-Key support of write endpoints of
+// FOXDEN/CHESS services. Responses of completed requests are kept in the
+// store (MongoDB or redis) and replayed for retries of the same request
+// within TTL, e.g. when client retries request after timeout, therefore
+// retries do not create duplicate metadata records.
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	redis "github.com/CHESSComputing/golib/redis"
+	storage "github.com/CHESSComputing/golib/storage"
+)
+
+// HeaderName defines name of HTTP header with idempotency key
+const HeaderName = "Idempotency-Key"
+
+// ReplayHeader defines name of HTTP header set in replayed responses
+const ReplayHeader = "Idempotent-Replayed"
+
+// record statuses
+const (
+	StatusProcessing = "processing"
+	StatusCompleted  = "completed"
+)
+
+// DefaultCollection defines default collection of stored responses
+var DefaultCollection = "idempotency"
+
+// DefaultTTL defines default time to keep responses for retries
+var DefaultTTL = 24 * time.Hour
+
+// DefaultLockTTL defines default time after which key of unfinished
+// request, e.g. of crashed server, may be reused
+var DefaultLockTTL = 5 * time.Minute
+
+// MaxKeyLength defines maximum length of idempotency key
+var MaxKeyLength = 255
+
+// MaxResponseSize defines maximum size of stored response body, keys of
+// larger responses are released and such requests are not replayed
+var MaxResponseSize = 1 << 20
+
+// StoredHeaders defines response headers replayed with response body
+var StoredHeaders = []string{"Content-Type", "Location", "ETag"}
+
+// ErrConflict is returned when request with the same key is in progress
+var ErrConflict = errors.New("request with the same idempotency key is in progress")
+
+// ErrMismatch is returned when idempotency key is reused with different
+// request
+var ErrMismatch = errors.New("idempotency key is reused with different request")
+
+// Record represents stored request and its response
+type Record struct {
+	Key         string            `json:"key"`              // scoped idempotency key
+	Fingerprint string            `json:"fingerprint"`      // hash of request method, path and body
+	Status      string            `json:"status"`           // processing or completed
+	Code        int               `json:"code,omitempty"`   // HTTP status code of response
+	Header      map[string]string `json:"header,omitempty"` // stored response headers
+	Body        []byte            `json:"body,omitempty"`   // response body
+	Expires     time.Time         `json:"expires"`          // expiration of the record
+}
+
+// Store defines interface of stored responses
+type Store interface {
+	// Reserve stores record of new request if its key is not present or
+	// expired and returns nil, otherwise it returns existing record
+	Reserve(ctx context.Context, rec Record) (*Record, error)
+	// Save stores record of completed request
+	Save(ctx context.Context, rec Record) error
+	// Release removes record of given key, e.g. of failed request
+	Release(ctx context.Context, key string) error
+}
+
+// Keys represents idempotency manager, it should be initialized via Init
+// function
+var Keys *Manager
+
+// Manager reserves idempotency keys of write requests and replays stored
+// responses
+type Manager struct {
+	Store    Store         // store of responses
+	TTL      time.Duration // time to keep responses for retries
+	LockTTL  time.Duration // time to keep key of unfinished request
+	ClientID string        // client id used to validate tokens
+
+	now func() time.Time
+}
+
+// NewManager returns idempotency manager with given store and
+// configuration
+func NewManager(store Store, cfg srvConfig.Idempotency) *Manager {
+	m := &Manager{Store: store, TTL: cfg.TTL, LockTTL: DefaultLockTTL, now: time.Now}
+	if m.TTL <= 0 {
+		m.TTL = DefaultTTL
+	}
+	return m
+}
+
+// Init initializes idempotency manager from server configuration, MongoDB
+// backend requires connection initialized via mongo.InitMongoDB (or
+// PostgreSQL storage initialized via storage.Init), redis
+// backend without RedisURI uses shared client of Redis configuration
+func Init() error {
+	if srvConfig.Config == nil {
+		return errors.New("server configuration is not initialized")
+	}
+	cfg := srvConfig.Config.Idempotency
+	var store Store
+	if strings.EqualFold(cfg.Backend, "redis") && cfg.RedisURI == "" {
+		// use shared redis client of Redis configuration
+		if redis.Redis == nil {
+			if err := redis.Init(); err != nil {
+				log.Println("ERROR: unable to initialize idempotency store", err)
+				return err
+			}
+		}
+		store = NewRedisClientStore(redis.Redis)
+	} else if strings.EqualFold(cfg.Backend, "redis") {
+		rstore, err := NewRedisStore(cfg.RedisURI)
+		if err != nil {
+			log.Println("ERROR: unable to initialize idempotency store", err)
+			return err
+		}
+		store = rstore
+	} else {
+		store = NewDocumentStore(storage.NewStore(cfg.DBName), cfg.Collection)
+	}
+	m := NewManager(store, cfg)
+	m.ClientID = srvConfig.Config.Authz.ClientID
+	Keys = m
+	return nil
+}
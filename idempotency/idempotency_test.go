@@ -0,0 +1,227 @@
+package idempotency
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	srvConfig "github.com/CHESSComputing/golib/config"
+	storage "github.com/CHESSComputing/golib/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// helper function to create test router with idempotency middleware
+func testRouter(m *Manager, calls *int) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		if user := c.GetHeader("X-User"); user != "" {
+			c.Set("claims", &authz.Claims{CustomClaims: authz.CustomClaims{User: user}})
+		}
+	})
+	r.Use(m.Middleware())
+	r.POST("/records", func(c *gin.Context) {
+		*calls++
+		if c.Query("fail") != "" {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "unavailable"})
+			return
+		}
+		c.Header("Location", fmt.Sprintf("/records/%d", *calls))
+		c.JSON(http.StatusCreated, gin.H{"id": *calls})
+	})
+	return r
+}
+
+// helper function to send test request
+func send(r *gin.Engine, target, user, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", target, strings.NewReader(body))
+	if key != "" {
+		req.Header.Set(HeaderName, key)
+	}
+	if user != "" {
+		req.Header.Set("X-User", user)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// TestMiddleware
+func TestMiddleware(t *testing.T) {
+	store := NewDocumentStore(storage.NewMemoryStore(), "")
+	m := NewManager(store, srvConfig.Idempotency{TTL: time.Hour})
+	calls := 0
+	r := testRouter(m, &calls)
+
+	w := send(r, "/records", "alice", "key-1", `{"name": "sample"}`)
+	if w.Code != http.StatusCreated || calls != 1 || w.Header().Get(ReplayHeader) != "" {
+		t.Fatalf("unexpected first response %d %s", w.Code, w.Body.String())
+	}
+	// retry gets stored response without calling the handler
+	retry := send(r, "/records", "alice", "key-1", `{"name": "sample"}`)
+	if retry.Code != http.StatusCreated || calls != 1 || retry.Header().Get(ReplayHeader) != "true" {
+		t.Errorf("request is not replayed, code %d calls %d", retry.Code, calls)
+	}
+	if retry.Body.String() != w.Body.String() || retry.Header().Get("Location") != "/records/1" {
+		t.Errorf("wrong replayed response %s %v", retry.Body.String(), retry.Header())
+	}
+	// reuse of the key with different request
+	if w := send(r, "/records", "alice", "key-1", `{"name": "other"}`); w.Code != http.StatusUnprocessableEntity || calls != 1 {
+		t.Errorf("expect 422 for different request, got %d", w.Code)
+	}
+	// keys are scoped by user
+	if w := send(r, "/records", "bob", "key-1", `{"name": "sample"}`); w.Code != http.StatusCreated || calls != 2 {
+		t.Errorf("request of other user is not processed, code %d", w.Code)
+	}
+	// requests without key are not affected
+	send(r, "/records", "alice", "", `{"name": "sample"}`)
+	send(r, "/records", "alice", "", `{"name": "sample"}`)
+	if calls != 4 {
+		t.Errorf("requests without key should be processed, calls %d", calls)
+	}
+	// server errors are not stored
+	send(r, "/records?fail=1", "alice", "key-2", "")
+	send(r, "/records?fail=1", "alice", "key-2", "")
+	if calls != 6 {
+		t.Errorf("failed request should be retried, calls %d", calls)
+	}
+	// request in progress
+	rec := Record{Key: scopedKey("alice", "key-3"), Fingerprint: fingerprint(httptest.NewRequest("POST", "/records", nil), nil),
+		Status: StatusProcessing, Expires: time.Now().Add(time.Minute)}
+	if old, err := store.Reserve(context.Background(), rec); err != nil || old != nil {
+		t.Fatal(old, err)
+	}
+	if w := send(r, "/records", "alice", "key-3", ""); w.Code != http.StatusConflict || calls != 6 {
+		t.Errorf("expect 409 for request in progress, got %d", w.Code)
+	}
+	if w := send(r, "/records", "alice", strings.Repeat("k", MaxKeyLength+1), ""); w.Code != http.StatusBadRequest {
+		t.Errorf("long key is accepted, code %d", w.Code)
+	}
+}
+
+// TestDocumentStore
+func TestDocumentStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewDocumentStore(storage.NewMemoryStore(), "")
+	rec := Record{Key: "k", Fingerprint: "f", Status: StatusProcessing, Expires: time.Now().Add(-time.Second)}
+	if old, err := store.Reserve(ctx, rec); err != nil || old != nil {
+		t.Fatal(old, err)
+	}
+	// expired record is replaced
+	rec.Expires = time.Now().Add(time.Minute)
+	if old, err := store.Reserve(ctx, rec); err != nil || old != nil {
+		t.Fatalf("expired record is not replaced %v %v", old, err)
+	}
+	rec.Status, rec.Code, rec.Body, rec.Header = StatusCompleted, 201, []byte("{}"), map[string]string{"Content-Type": "application/json"}
+	if err := store.Save(ctx, rec); err != nil {
+		t.Fatal(err)
+	}
+	old, err := store.Reserve(ctx, Record{Key: "k", Expires: time.Now().Add(time.Minute)})
+	if err != nil || old == nil || old.Code != 201 || string(old.Body) != "{}" || old.Header["Content-Type"] != "application/json" {
+		t.Errorf("wrong stored record %+v %v", old, err)
+	}
+	if n, err := store.Purge(ctx, time.Now().Add(time.Hour)); err != nil || n != 1 {
+		t.Errorf("expect 1 purged record, got %d %v", n, err)
+	}
+}
+
+// fakeRedis represents minimal redis server which supports SET, GET and
+// DEL commands
+type fakeRedis struct {
+	mu   sync.Mutex
+	data map[string]string
+}
+
+// helper function to serve redis connection
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		var args []string
+		for i := 0; i < n; i++ {
+			r.ReadString('\n')
+			arg, _ := r.ReadString('\n')
+			args = append(args, strings.TrimSuffix(arg, "\r\n"))
+		}
+		f.mu.Lock()
+		switch strings.ToUpper(args[0]) {
+		case "SET":
+			if _, ok := f.data[args[1]]; ok && len(args) > 3 && args[3] == "NX" {
+				conn.Write([]byte("$-1\r\n"))
+			} else {
+				f.data[args[1]] = args[2]
+				conn.Write([]byte("+OK\r\n"))
+			}
+		case "GET":
+			if val, ok := f.data[args[1]]; ok {
+				fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(val), val)
+			} else {
+				conn.Write([]byte("$-1\r\n"))
+			}
+		case "DEL":
+			delete(f.data, args[1])
+			conn.Write([]byte(":1\r\n"))
+		default:
+			fmt.Fprintf(conn, "-ERR unknown command '%s'\r\n", args[0])
+		}
+		f.mu.Unlock()
+	}
+}
+
+// TestRedisStore
+func TestRedisStore(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	fake := &fakeRedis{data: make(map[string]string)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go fake.serve(conn)
+		}
+	}()
+	if _, err := NewRedisStore("http://localhost"); err == nil {
+		t.Error("unsupported scheme should fail")
+	}
+	store, err := NewRedisStore("redis://" + ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	m := NewManager(store, srvConfig.Idempotency{})
+	calls := 0
+	r := testRouter(m, &calls)
+	send(r, "/records", "alice", "key-1", `{}`)
+	w := send(r, "/records", "alice", "key-1", `{}`)
+	if calls != 1 || w.Code != http.StatusCreated || w.Header().Get(ReplayHeader) != "true" {
+		t.Errorf("request is not replayed from redis, code %d calls %d", w.Code, calls)
+	}
+	if err := store.Release(context.Background(), scopedKey("alice", "key-1")); err != nil {
+		t.Fatal(err)
+	}
+	if send(r, "/records", "alice", "key-1", `{}`); calls != 2 {
+		t.Errorf("released key should be processed again, calls %d", calls)
+	}
+	if _, err := store.do(context.Background(), "PING"); err == nil || !strings.Contains(err.Error(), "unknown command") {
+		t.Errorf("expect redis error, got %v", err)
+	}
+}
@@ -0,0 +1,203 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	services "github.com/CHESSComputing/golib/services"
+	"github.com/gin-gonic/gin"
+)
+
+// helper function to get user of HTTP request either from claims set by
+// authz middleware or from request token
+func requestUser(c *gin.Context, clientId string) string {
+	if val, ok := c.Get("claims"); ok {
+		if claims, ok := val.(*authz.Claims); ok {
+			return claims.CustomClaims.User
+		}
+	}
+	token := authz.RequestToken(c.Request)
+	if token != "" && clientId != "" {
+		if claims, err := authz.TokenClaims(token, clientId); err == nil {
+			return claims.CustomClaims.User
+		}
+	}
+	return c.GetString("user")
+}
+
+// helper function to check if request writes data
+func isWrite(method string) bool {
+	return method == "POST" || method == "PUT" || method == "PATCH" || method == "DELETE"
+}
+
+// helper function to abort request with service error
+func abort(c *gin.Context, code, srvCode int, err error) {
+	rec := services.Response("idempotency", code, srvCode, err)
+	c.AbortWithStatusJSON(code, rec)
+}
+
+// helper function to provide key scoped by user, therefore users can't
+// replay responses of other users
+func scopedKey(user, key string) string {
+	hash := sha256.Sum256([]byte(user + "\n" + key))
+	return hex.EncodeToString(hash[:])
+}
+
+// helper function to provide fingerprint of request method, URI and body
+func fingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n", r.Method, r.URL.RequestURI())
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// helper function to check if response of given status code should be
+// stored, server errors and throttled requests may be retried
+func storable(code int) bool {
+	return code < http.StatusInternalServerError && code != http.StatusTooManyRequests
+}
+
+// recorder captures response body written by handlers
+type recorder struct {
+	gin.ResponseWriter
+	body     bytes.Buffer
+	overflow bool
+}
+
+// helper function to capture part of response body
+func (w *recorder) capture(data []byte) {
+	if w.overflow {
+		return
+	}
+	if w.body.Len()+len(data) > MaxResponseSize {
+		w.overflow = true
+		w.body.Reset()
+		return
+	}
+	w.body.Write(data)
+}
+
+// Write implements http.ResponseWriter interface
+func (w *recorder) Write(data []byte) (int, error) {
+	w.capture(data)
+	return w.ResponseWriter.Write(data)
+}
+
+// WriteString implements io.StringWriter interface
+func (w *recorder) WriteString(s string) (int, error) {
+	w.capture([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+// helper function to replay stored response
+func replay(c *gin.Context, rec *Record) {
+	for key, val := range rec.Header {
+		c.Header(key, val)
+	}
+	c.Header(ReplayHeader, "true")
+	c.Status(rec.Code)
+	c.Writer.Write(rec.Body)
+	c.Abort()
+}
+
+// Middleware provides gin middleware which honors Idempotency-Key header
+// of write requests. The first request with given key is processed and its
+// response is stored, retries of the same request within TTL get stored
+// response with Idempotent-Replayed header. Keys are scoped by user,
+// reuse of the key with different request is rejected with 422 and
+// retries of request in progress with 409 status code. Responses of server
+// errors and throttled requests are not stored, i.e. such requests may be
+// retried with the same key.
+func (m *Manager) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(HeaderName)
+		if key == "" || !isWrite(c.Request.Method) {
+			c.Next()
+			return
+		}
+		if len(key) > MaxKeyLength {
+			abort(c, http.StatusBadRequest, services.IdempotencyError,
+				fmt.Errorf("%s header is longer than %d characters", HeaderName, MaxKeyLength))
+			return
+		}
+		var body []byte
+		if c.Request.Body != nil {
+			data, err := io.ReadAll(c.Request.Body)
+			if err != nil {
+				code := http.StatusBadRequest
+				var merr *http.MaxBytesError
+				if errors.As(err, &merr) {
+					code = http.StatusRequestEntityTooLarge
+				}
+				abort(c, code, services.ReaderError, err)
+				return
+			}
+			body = data
+			c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		rec := Record{
+			Key:         scopedKey(requestUser(c, m.ClientID), key),
+			Fingerprint: fingerprint(c.Request, body),
+			Status:      StatusProcessing,
+			Expires:     m.now().Add(m.LockTTL),
+		}
+		ctx := c.Request.Context()
+		old, err := m.Store.Reserve(ctx, rec)
+		if err != nil {
+			log.Printf("ERROR: unable to reserve idempotency key, error %v", err)
+			abort(c, http.StatusInternalServerError, services.DatabaseError, err)
+			return
+		}
+		if old != nil {
+			switch {
+			case old.Fingerprint != rec.Fingerprint:
+				abort(c, http.StatusUnprocessableEntity, services.IdempotencyError, ErrMismatch)
+			case old.Status != StatusCompleted:
+				abort(c, http.StatusConflict, services.IdempotencyError, ErrConflict)
+			default:
+				replay(c, old)
+			}
+			return
+		}
+
+		// release the key unless response is stored, e.g. if handler panics
+		saved := false
+		defer func() {
+			if !saved {
+				if err := m.Store.Release(context.Background(), rec.Key); err != nil {
+					log.Printf("ERROR: unable to release idempotency key, error %v", err)
+				}
+			}
+		}()
+		w := &recorder{ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Next()
+		c.Writer = w.ResponseWriter
+		if w.overflow || !storable(w.Status()) {
+			return
+		}
+		rec.Status = StatusCompleted
+		rec.Code = w.Status()
+		rec.Body = w.body.Bytes()
+		rec.Header = make(map[string]string)
+		for _, h := range StoredHeaders {
+			if val := w.Header().Get(h); val != "" {
+				rec.Header[h] = val
+			}
+		}
+		rec.Expires = m.now().Add(m.TTL)
+		if err := m.Store.Save(ctx, rec); err != nil {
+			log.Printf("ERROR: unable to store response of idempotency key, error %v", err)
+			return
+		}
+		saved = true
+	}
+}
@@ -0,0 +1,245 @@
+package idempotency
+
+// store module provides document (MongoDB) and redis stores of responses
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	redis "github.com/CHESSComputing/golib/redis"
+	storage "github.com/CHESSComputing/golib/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DocumentStore represents store of responses within collection of
+// document storage, e.g. MongoDB. Expired records are ignored, they may be
+// removed via Purge or MongoDB TTL index on expires field.
+type DocumentStore struct {
+	Store      storage.Store
+	Collection string
+}
+
+// NewDocumentStore returns store of responses within given collection of
+// document storage
+func NewDocumentStore(store storage.Store, collection string) *DocumentStore {
+	if collection == "" {
+		collection = DefaultCollection
+	}
+	return &DocumentStore{Store: store, Collection: collection}
+}
+
+// helper function to convert record into document
+func document(rec Record) (map[string]any, error) {
+	header, err := json.Marshal(rec.Header)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{
+		"_id":         rec.Key,
+		"fingerprint": rec.Fingerprint,
+		"status":      rec.Status,
+		"code":        rec.Code,
+		"header":      string(header),
+		"body":        base64.StdEncoding.EncodeToString(rec.Body),
+		"expires":     rec.Expires.UTC(),
+	}, nil
+}
+
+// helper function to convert document into record
+func fromDocument(doc map[string]any) (Record, error) {
+	var rec Record
+	rec.Key, _ = doc["_id"].(string)
+	rec.Fingerprint, _ = doc["fingerprint"].(string)
+	rec.Status, _ = doc["status"].(string)
+	switch v := doc["code"].(type) {
+	case int:
+		rec.Code = v
+	case int32:
+		rec.Code = int(v)
+	case int64:
+		rec.Code = int(v)
+	}
+	switch v := doc["expires"].(type) {
+	case time.Time:
+		rec.Expires = v
+	case primitive.DateTime:
+		rec.Expires = v.Time()
+	}
+	if header, _ := doc["header"].(string); header != "" {
+		if err := json.Unmarshal([]byte(header), &rec.Header); err != nil {
+			return rec, fmt.Errorf("invalid header of record %s, error %v", rec.Key, err)
+		}
+	}
+	body, _ := doc["body"].(string)
+	data, err := base64.StdEncoding.DecodeString(body)
+	if err != nil {
+		return rec, fmt.Errorf("invalid body of record %s, error %v", rec.Key, err)
+	}
+	rec.Body = data
+	return rec, nil
+}
+
+// helper function to find record of given key
+func (s *DocumentStore) find(ctx context.Context, key string) (*Record, error) {
+	doc, err := storage.FindOne(ctx, s.Store, s.Collection, map[string]any{"_id": key})
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	rec, err := fromDocument(doc)
+	return &rec, err
+}
+
+// Reserve implements Store interface
+func (s *DocumentStore) Reserve(ctx context.Context, rec Record) (*Record, error) {
+	doc, err := document(rec)
+	if err != nil {
+		return nil, err
+	}
+	old, err := s.find(ctx, rec.Key)
+	if err != nil {
+		return nil, err
+	}
+	if old != nil {
+		if old.Expires.After(time.Now()) {
+			return old, nil
+		}
+		spec := map[string]any{"_id": rec.Key, "expires": map[string]any{"$lte": time.Now().UTC()}}
+		if _, err := s.Store.Remove(ctx, s.Collection, spec); err != nil {
+			return nil, err
+		}
+	}
+	err = s.Store.Insert(ctx, s.Collection, doc)
+	if errors.Is(err, storage.ErrDuplicate) {
+		// concurrent request reserved the key
+		return s.find(ctx, rec.Key)
+	}
+	return nil, err
+}
+
+// Save implements Store interface
+func (s *DocumentStore) Save(ctx context.Context, rec Record) error {
+	doc, err := document(rec)
+	if err != nil {
+		return err
+	}
+	n, err := s.Store.Update(ctx, s.Collection, map[string]any{"_id": rec.Key}, doc)
+	if err != nil || n > 0 {
+		return err
+	}
+	return s.Store.Insert(ctx, s.Collection, doc)
+}
+
+// Release implements Store interface
+func (s *DocumentStore) Release(ctx context.Context, key string) error {
+	_, err := s.Store.Remove(ctx, s.Collection, map[string]any{"_id": key})
+	return err
+}
+
+// Purge removes records expired before given time and returns their number
+func (s *DocumentStore) Purge(ctx context.Context, now time.Time) (int64, error) {
+	return s.Store.Remove(ctx, s.Collection, map[string]any{"expires": map[string]any{"$lt": now.UTC()}})
+}
+
+// RedisPrefix defines prefix of redis keys of stored responses
+var RedisPrefix = "idempotency:"
+
+// RedisTimeout defines timeout of redis commands
+var RedisTimeout = 5 * time.Second
+
+// RedisStore represents store of responses within redis, records expire
+// via redis key expiration
+type RedisStore struct {
+	Client *redis.Client // redis client
+	Prefix string        // prefix of redis keys
+}
+
+// NewRedisStore returns redis store for given URI, e.g.
+// redis://:password@localhost:6379/0 or rediss://host:6380 for TLS
+func NewRedisStore(uri string) (*RedisStore, error) {
+	opts, err := redis.ParseURI(uri)
+	if err != nil {
+		return nil, err
+	}
+	opts.Timeout = RedisTimeout
+	return NewRedisClientStore(redis.NewClient(opts)), nil
+}
+
+// NewRedisClientStore returns redis store of given redis client, e.g.
+// shared client of server configuration
+func NewRedisClientStore(client *redis.Client) *RedisStore {
+	return &RedisStore{Client: client, Prefix: client.Key(RedisPrefix)}
+}
+
+// helper function to execute redis command
+func (s *RedisStore) do(ctx context.Context, args ...string) (any, error) {
+	return s.Client.Do(ctx, args...)
+}
+
+// helper function to provide expiration of record in milliseconds
+func expiration(rec Record) string {
+	ms := time.Until(rec.Expires).Milliseconds()
+	if ms < 1 {
+		ms = 1
+	}
+	return strconv.FormatInt(ms, 10)
+}
+
+// Reserve implements Store interface
+func (s *RedisStore) Reserve(ctx context.Context, rec Record) (*Record, error) {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+	key := s.Prefix + rec.Key
+	// retry if existing record expires between SET and GET commands
+	for i := 0; i < 3; i++ {
+		reply, err := s.do(ctx, "SET", key, string(data), "NX", "PX", expiration(rec))
+		if err != nil {
+			return nil, err
+		}
+		if reply != nil {
+			return nil, nil
+		}
+		reply, err = s.do(ctx, "GET", key)
+		if err != nil {
+			return nil, err
+		}
+		if val, ok := reply.(string); ok {
+			var old Record
+			if err := json.Unmarshal([]byte(val), &old); err != nil {
+				return nil, fmt.Errorf("invalid record %s, error %v", rec.Key, err)
+			}
+			return &old, nil
+		}
+	}
+	return nil, fmt.Errorf("unable to reserve key %s", rec.Key)
+}
+
+// Save implements Store interface
+func (s *RedisStore) Save(ctx context.Context, rec Record) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	_, err = s.do(ctx, "SET", s.Prefix+rec.Key, string(data), "PX", expiration(rec))
+	return err
+}
+
+// Release implements Store interface
+func (s *RedisStore) Release(ctx context.Context, key string) error {
+	_, err := s.do(ctx, "DEL", s.Prefix+key)
+	return err
+}
+
+// Close closes connections to redis server
+func (s *RedisStore) Close() error {
+	return s.Client.Close()
+}
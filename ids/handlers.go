@@ -0,0 +1,159 @@
+package ids
+
+// handlers module provides HTTP endpoints to mint, supersede and resolve
+// identifiers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	curation "github.com/CHESSComputing/golib/curation"
+	server "github.com/CHESSComputing/golib/server"
+	services "github.com/CHESSComputing/golib/services"
+	utils "github.com/CHESSComputing/golib/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// MintRequest represents request to mint identifier
+type MintRequest struct {
+	Kind   string `json:"kind"`   // kind of identified object, e.g. record or dataset
+	Target string `json:"target"` // id of identified record
+}
+
+// SupersedeRequest represents request to supersede identifier
+type SupersedeRequest struct {
+	By      string `json:"by"` // superseding identifier
+	Comment string `json:"comment,omitempty"`
+}
+
+// helper function to abort request with service error
+func abort(c *gin.Context, code, srvCode int, err error) {
+	rec := services.Response("ids", code, srvCode, err)
+	c.AbortWithStatusJSON(code, rec)
+}
+
+// helper function to abort request with error of the manager
+func abortWithError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		abort(c, http.StatusNotFound, services.QueryError, err)
+	case errors.Is(err, ErrGone):
+		abort(c, http.StatusGone, services.QueryError, err)
+	case errors.Is(err, ErrInvalid):
+		abort(c, http.StatusBadRequest, services.ValidateError, err)
+	default:
+		log.Printf("ERROR: identifiers request failed, error %v", err)
+		abort(c, http.StatusInternalServerError, services.DatabaseError, err)
+	}
+}
+
+// helper function to provide user of the request
+func requestUser(c *gin.Context) string {
+	if val, ok := c.Get("claims"); ok {
+		if claims, ok := val.(*authz.Claims); ok {
+			return claims.CustomClaims.User
+		}
+	}
+	return ""
+}
+
+// helper function to wrap handler which requires one of curation roles,
+// token claims are provided by token validation middleware
+func curator(handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if val, ok := c.Get("claims"); ok {
+			if claims, ok := val.(*authz.Claims); ok {
+				for _, role := range claims.CustomClaims.Roles {
+					if utils.InList(role, curation.CuratorRoles) {
+						handler(c)
+						return
+					}
+				}
+			}
+		}
+		abort(c, http.StatusForbidden, services.PolicyError, errors.New("superseding identifiers requires curator role"))
+	}
+}
+
+// MintHandler mints identifier of record, existing identifier of the record
+// is provided with 200 status code and new one with 201 status code
+func (m *Manager) MintHandler(c *gin.Context) {
+	var req MintRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abort(c, http.StatusBadRequest, services.BindError, err)
+		return
+	}
+	i, created, err := m.Mint(c.Request.Context(), requestUser(c), req.Kind, req.Target)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if created {
+		c.JSON(http.StatusCreated, i)
+		return
+	}
+	c.JSON(http.StatusOK, i)
+}
+
+// SupersedeHandler marks identifier as superseded by another identifier
+func (m *Manager) SupersedeHandler(c *gin.Context) {
+	var req SupersedeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abort(c, http.StatusBadRequest, services.BindError, err)
+		return
+	}
+	i, err := m.Supersede(c.Request.Context(), requestUser(c), c.Param("id"), req.By, req.Comment)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, i)
+}
+
+// ResolveHandler returns resolver handler of identifiers. If resolver URL
+// template is given identifiers of single record redirect to it, e.g.
+// https://foxden.example.com/record/{id}, unless format=json query
+// parameter is provided. Identifiers of split records are provided with
+// 300 (Multiple Choices) status code.
+func (m *Manager) ResolveHandler(rurl string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		res, err := m.Resolve(c.Request.Context(), id)
+		if errors.Is(err, ErrNotFound) {
+			// mistyped identifiers are reported as invalid ones
+			if cerr := m.Check(id); cerr != nil {
+				err = cerr
+			}
+		}
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		if len(res.Targets) > 1 {
+			c.JSON(http.StatusMultipleChoices, res)
+			return
+		}
+		if rurl != "" && c.Query("format") != "json" {
+			c.Redirect(http.StatusFound, strings.ReplaceAll(rurl, "{id}", res.Targets[0]))
+			return
+		}
+		c.JSON(http.StatusOK, res)
+	}
+}
+
+// Routes returns routes of identifiers service under given path, e.g.
+// /ids, resolved identifiers redirect to given URL template if it is not
+// empty
+func (m *Manager) Routes(path, rurl string) []server.Route {
+	return []server.Route{
+		{Method: "POST", Path: path, Authorized: true, Scope: "write", Handler: m.MintHandler,
+			Summary: "mint identifier of record", Request: MintRequest{}, Response: Identifier{}},
+		{Method: "GET", Path: path + "/:id", Handler: m.ResolveHandler(rurl),
+			Summary: "resolve identifier", Response: Resolution{}},
+		{Method: "POST", Path: path + "/:id/supersede", Authorized: true, Scope: "write", Handler: curator(m.SupersedeHandler),
+			Summary: "supersede identifier", Request: SupersedeRequest{}, Response: Identifier{}},
+	}
+}
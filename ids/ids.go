@@ -0,0 +1,358 @@
+package ids
+
+// ids module provides stable identifiers of records and datasets. Identifiers
+// are opaque, i.e. either UUIDv7 or configurable prefix followed by random
+// characters, and they are never reused. Resolver follows superseded
+// identifiers and redirects of merged and split records, so external
+// references never break.
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	curation "github.com/CHESSComputing/golib/curation"
+	storage "github.com/CHESSComputing/golib/storage"
+	utils "github.com/CHESSComputing/golib/utils"
+	"github.com/google/uuid"
+)
+
+// identifier schemes
+const (
+	SchemeUUID7  = "uuid7"
+	SchemePrefix = "prefix"
+)
+
+// identifier statuses
+const (
+	StatusActive     = "active"
+	StatusSuperseded = "superseded"
+)
+
+// defaults of identifiers service
+var (
+	DefaultCollection = "identifiers"
+	DefaultLength     = 10
+	MaxRedirects      = 32
+)
+
+// symbols of random characters (Crockford base32) and check character
+const (
+	alphabet     = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+	checkSymbols = alphabet + "*~$=U"
+)
+
+// errors of identifiers service
+var (
+	ErrInvalid  = errors.New("invalid identifier")
+	ErrNotFound = errors.New("identifier not found")
+	ErrGone     = errors.New("identified record no longer exists")
+)
+
+// Identifier represents stable identifier of record or dataset
+type Identifier struct {
+	ID           string `json:"id"`
+	Kind         string `json:"kind"`   // kind of identified object, e.g. record or dataset
+	Target       string `json:"target"` // id of identified record
+	Status       string `json:"status"` // active or superseded
+	SupersededBy string `json:"superseded_by,omitempty"`
+	Actor        string `json:"actor"`
+	Comment      string `json:"comment,omitempty"`
+	Created      int64  `json:"created"`
+	Updated      int64  `json:"updated"`
+}
+
+// helper function to convert identifier into storage record
+func (i Identifier) record() map[string]any {
+	return map[string]any{
+		"_id":           i.ID,
+		"kind":          i.Kind,
+		"target":        i.Target,
+		"status":        i.Status,
+		"superseded_by": i.SupersededBy,
+		"actor":         i.Actor,
+		"comment":       i.Comment,
+		"created":       i.Created,
+		"updated":       i.Updated,
+	}
+}
+
+// helper function to convert storage record into identifier
+func identifierRecord(rec map[string]any) Identifier {
+	i := Identifier{}
+	i.ID, _ = rec["_id"].(string)
+	i.Kind, _ = rec["kind"].(string)
+	i.Target, _ = rec["target"].(string)
+	i.Status, _ = rec["status"].(string)
+	i.SupersededBy, _ = rec["superseded_by"].(string)
+	i.Actor, _ = rec["actor"].(string)
+	i.Comment, _ = rec["comment"].(string)
+	i.Created = utils.ToInt64(rec["created"])
+	i.Updated = utils.ToInt64(rec["updated"])
+	return i
+}
+
+// Resolution represents resolved identifier
+type Resolution struct {
+	ID         string     `json:"id"`         // requested identifier
+	Identifier Identifier `json:"identifier"` // current identifier
+	Path       []string   `json:"path"`       // followed identifiers and record redirects
+	Targets    []string   `json:"targets"`    // ids of current records, several records after split
+}
+
+// Manager represents identifiers service
+type Manager struct {
+	Store      storage.Store     // store of identifiers
+	Collection string            // collection of identifiers
+	Scheme     string            // uuid7 or prefix
+	Prefix     string            // prefix of identifiers
+	Length     int               // number of random characters of prefix scheme
+	Redirects  *curation.Manager // redirects of merged and split records, optional
+}
+
+// IDs represents global identifiers service, it should be initialized via
+// Init function
+var IDs *Manager
+
+// NewManager returns identifiers service of given store and configuration
+func NewManager(store storage.Store, cfg srvConfig.IDs) *Manager {
+	m := &Manager{
+		Store:      store,
+		Collection: cfg.Collection,
+		Scheme:     cfg.Scheme,
+		Prefix:     cfg.Prefix,
+		Length:     cfg.Length,
+	}
+	if m.Collection == "" {
+		m.Collection = DefaultCollection
+	}
+	if m.Scheme == "" {
+		m.Scheme = SchemeUUID7
+	}
+	if m.Length == 0 {
+		m.Length = DefaultLength
+	}
+	return m
+}
+
+// Init initializes global identifiers service of IDs configuration,
+// redirects of records are provided by global curation manager if it is
+// initialized
+func Init() error {
+	if srvConfig.Config == nil {
+		return errors.New("server configuration is not initialized")
+	}
+	cfg := srvConfig.Config.IDs
+	dbname := cfg.DBName
+	if dbname == "" {
+		dbname = srvConfig.Config.CHESSMetaData.DBName
+	}
+	if dbname == "" {
+		return errors.New("identifiers database is not configured")
+	}
+	IDs = NewManager(storage.NewStore(dbname), cfg)
+	IDs.Redirects = curation.Curation
+	return nil
+}
+
+// helper function to compute check character of identifier characters
+func checkCharacter(chars string) (byte, error) {
+	var sum int
+	for _, c := range strings.ToUpper(chars) {
+		idx := strings.IndexRune(alphabet, c)
+		if idx < 0 {
+			return 0, fmt.Errorf("%w: character %q", ErrInvalid, c)
+		}
+		sum = (sum*len(alphabet) + idx) % len(checkSymbols)
+	}
+	return checkSymbols[sum], nil
+}
+
+// NewID generates new identifier of configured scheme
+func (m *Manager) NewID() (string, error) {
+	if m.Scheme == SchemeUUID7 {
+		id, err := uuid.NewV7()
+		if err != nil {
+			return "", err
+		}
+		return m.Prefix + id.String(), nil
+	}
+	if m.Scheme != SchemePrefix {
+		return "", fmt.Errorf("unsupported identifier scheme '%s'", m.Scheme)
+	}
+	chars := make([]byte, m.Length)
+	max := big.NewInt(int64(len(alphabet)))
+	for i := range chars {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		chars[i] = alphabet[n.Int64()]
+	}
+	check, err := checkCharacter(string(chars))
+	if err != nil {
+		return "", err
+	}
+	return m.Prefix + string(chars) + string(check), nil
+}
+
+// Check verifies syntax of identifier of configured scheme, i.e. check
+// character of prefix scheme detects mistyped identifiers
+func (m *Manager) Check(id string) error {
+	if !strings.HasPrefix(id, m.Prefix) {
+		return fmt.Errorf("%w: %s has no prefix %s", ErrInvalid, id, m.Prefix)
+	}
+	body := strings.TrimPrefix(id, m.Prefix)
+	if m.Scheme == SchemeUUID7 {
+		if _, err := uuid.Parse(body); err != nil {
+			return fmt.Errorf("%w: %s, error %v", ErrInvalid, id, err)
+		}
+		return nil
+	}
+	if len(body) != m.Length+1 {
+		return fmt.Errorf("%w: %s has wrong length", ErrInvalid, id)
+	}
+	check, err := checkCharacter(body[:m.Length])
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(string(check), body[m.Length:]) {
+		return fmt.Errorf("%w: %s has wrong check character", ErrInvalid, id)
+	}
+	return nil
+}
+
+// Get returns identifier of given id
+func (m *Manager) Get(ctx context.Context, id string) (Identifier, error) {
+	rec, err := storage.FindOne(ctx, m.Store, m.Collection, map[string]any{"_id": id})
+	if errors.Is(err, storage.ErrNotFound) {
+		return Identifier{}, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	if err != nil {
+		return Identifier{}, err
+	}
+	return identifierRecord(rec), nil
+}
+
+// Mint returns identifier of given kind of target record. Existing active
+// identifier of the target is returned if any, otherwise new identifier is
+// generated and created flag is set.
+func (m *Manager) Mint(ctx context.Context, actor, kind, target string) (Identifier, bool, error) {
+	if kind == "" || target == "" {
+		return Identifier{}, false, fmt.Errorf("%w: kind and target are required", ErrInvalid)
+	}
+	spec := map[string]any{"kind": kind, "target": target, "status": StatusActive}
+	rec, err := storage.FindOne(ctx, m.Store, m.Collection, spec)
+	if err == nil {
+		return identifierRecord(rec), false, nil
+	}
+	if !errors.Is(err, storage.ErrNotFound) {
+		return Identifier{}, false, err
+	}
+	now := time.Now().Unix()
+	i := Identifier{Kind: kind, Target: target, Status: StatusActive, Actor: actor, Created: now, Updated: now}
+	// random identifiers may collide, e.g. short prefix scheme identifiers
+	for attempt := 0; attempt < 3; attempt++ {
+		i.ID, err = m.NewID()
+		if err != nil {
+			break
+		}
+		err = m.Store.Insert(ctx, m.Collection, i.record())
+		if !errors.Is(err, storage.ErrDuplicate) {
+			break
+		}
+	}
+	if err != nil {
+		log.Printf("ERROR: unable to mint identifier of %s %s, error %v", kind, target, err)
+		return Identifier{}, false, err
+	}
+	return i, true, nil
+}
+
+// Supersede marks identifier as superseded by another identifier, e.g.
+// identifier of dataset which was re-processed
+func (m *Manager) Supersede(ctx context.Context, actor, id, by, comment string) (Identifier, error) {
+	if id == by {
+		return Identifier{}, fmt.Errorf("%w: identifier can not supersede itself", ErrInvalid)
+	}
+	i, err := m.Get(ctx, id)
+	if err != nil {
+		return i, err
+	}
+	if _, err := m.Get(ctx, by); err != nil {
+		return i, err
+	}
+	// superseding identifier should not lead back to superseded one
+	next := by
+	for n := 0; next != "" && n < MaxRedirects; n++ {
+		if next == id {
+			return i, fmt.Errorf("%w: %s is superseded by %s", ErrInvalid, by, id)
+		}
+		ni, err := m.Get(ctx, next)
+		if err != nil {
+			return i, err
+		}
+		next = ni.SupersededBy
+	}
+	i.Status = StatusSuperseded
+	i.SupersededBy = by
+	i.Actor = actor
+	i.Comment = comment
+	i.Updated = time.Now().Unix()
+	fields := map[string]any{
+		"status":        i.Status,
+		"superseded_by": i.SupersededBy,
+		"actor":         i.Actor,
+		"comment":       i.Comment,
+		"updated":       i.Updated,
+	}
+	if _, err := m.Store.Update(ctx, m.Collection, map[string]any{"_id": id}, fields); err != nil {
+		log.Printf("ERROR: unable to supersede identifier %s, error %v", id, err)
+		return i, err
+	}
+	return i, nil
+}
+
+// Resolve resolves identifier into ids of current records. Superseded
+// identifiers are followed to their successors and redirects of merged and
+// split records are followed to current records.
+func (m *Manager) Resolve(ctx context.Context, id string) (Resolution, error) {
+	res := Resolution{ID: id, Path: []string{id}}
+	i, err := m.Get(ctx, id)
+	if err != nil {
+		return res, err
+	}
+	for n := 0; i.Status == StatusSuperseded; n++ {
+		if n == MaxRedirects {
+			return res, fmt.Errorf("%w: too many redirects of %s", ErrInvalid, id)
+		}
+		i, err = m.Get(ctx, i.SupersededBy)
+		if err != nil {
+			return res, err
+		}
+		res.Path = append(res.Path, i.ID)
+	}
+	res.Identifier = i
+	if m.Redirects == nil {
+		res.Targets = []string{i.Target}
+		return res, nil
+	}
+	targets, err := m.Redirects.Resolve(ctx, i.Target)
+	if errors.Is(err, curation.ErrNotFound) {
+		return res, fmt.Errorf("%w: %s", ErrGone, i.Target)
+	}
+	if err != nil {
+		return res, err
+	}
+	if len(targets) != 1 || targets[0] != i.Target {
+		res.Path = append(res.Path, i.Target)
+	}
+	res.Targets = targets
+	return res, nil
+}
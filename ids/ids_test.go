@@ -0,0 +1,179 @@
+package ids
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	srvConfig "github.com/CHESSComputing/golib/config"
+	curation "github.com/CHESSComputing/golib/curation"
+	storage "github.com/CHESSComputing/golib/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// helper function to create identifiers service and curation manager of
+// memory store with records
+func testManager(t *testing.T, cfg srvConfig.IDs) *Manager {
+	store := storage.NewMemoryStore()
+	vs := storage.NewVersionedStore(store)
+	for _, id := range []string{"a", "b", "c"} {
+		if err := vs.Insert(context.Background(), "meta", "ingest", map[string]any{"_id": id, "sample": id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m := NewManager(store, cfg)
+	m.Redirects = curation.NewManager(vs, "meta", nil)
+	return m
+}
+
+// TestNewID
+func TestNewID(t *testing.T) {
+	m := NewManager(storage.NewMemoryStore(), srvConfig.IDs{})
+	id, err := m.NewID()
+	if err != nil || len(id) != 36 || id[14] != '7' {
+		t.Errorf("wrong UUIDv7 identifier %s, error %v", id, err)
+	}
+	if err := m.Check(id); err != nil {
+		t.Error(err)
+	}
+	if err := m.Check("chess:123"); !errors.Is(err, ErrInvalid) {
+		t.Errorf("invalid identifier is accepted, error %v", err)
+	}
+
+	m = NewManager(storage.NewMemoryStore(), srvConfig.IDs{Scheme: SchemePrefix, Prefix: "chess:", Length: 8})
+	id, err = m.NewID()
+	if err != nil || len(id) != 15 || !strings.HasPrefix(id, "chess:") {
+		t.Fatalf("wrong prefix identifier %s, error %v", id, err)
+	}
+	if err := m.Check(id); err != nil {
+		t.Error(err)
+	}
+	if err := m.Check(strings.ToLower(id)); err != nil {
+		t.Errorf("lower case identifier is rejected, error %v", err)
+	}
+	// single mistyped character is detected by check character
+	typo := []byte(id)
+	if typo[8] == '0' {
+		typo[8] = '1'
+	} else {
+		typo[8] = '0'
+	}
+	if err := m.Check(string(typo)); !errors.Is(err, ErrInvalid) {
+		t.Errorf("mistyped identifier %s of %s is accepted, error %v", typo, id, err)
+	}
+	if check, _ := checkCharacter("0000"); check != '0' {
+		t.Errorf("wrong check character %c", check)
+	}
+	if check, _ := checkCharacter("14"); check != 'U' {
+		t.Errorf("wrong check character %c", check)
+	}
+}
+
+// TestResolve
+func TestResolve(t *testing.T) {
+	ctx := context.Background()
+	m := testManager(t, srvConfig.IDs{Scheme: SchemePrefix, Prefix: "chess:"})
+	a, created, err := m.Mint(ctx, "alice", "record", "a")
+	if err != nil || !created || a.Status != StatusActive {
+		t.Fatalf("wrong identifier %+v, error %v", a, err)
+	}
+	if i, created, _ := m.Mint(ctx, "bob", "record", "a"); created || i.ID != a.ID {
+		t.Errorf("record has new identifier %+v", i)
+	}
+	if _, _, err := m.Mint(ctx, "bob", "record", ""); !errors.Is(err, ErrInvalid) {
+		t.Errorf("identifier without target is minted, error %v", err)
+	}
+	b, _, _ := m.Mint(ctx, "alice", "record", "b")
+	c, _, _ := m.Mint(ctx, "alice", "record", "c")
+
+	if res, err := m.Resolve(ctx, a.ID); err != nil || len(res.Targets) != 1 || res.Targets[0] != "a" {
+		t.Errorf("wrong resolution %+v, error %v", res, err)
+	}
+
+	// superseded identifiers and merged records are followed
+	if _, err := m.Supersede(ctx, "carol", a.ID, b.ID, "re-processed"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Supersede(ctx, "carol", b.ID, a.ID, ""); !errors.Is(err, ErrInvalid) {
+		t.Errorf("supersede loop is accepted, error %v", err)
+	}
+	if _, err := m.Redirects.Merge(ctx, "carol", curation.MergeRequest{Source: "b", Target: "c"}); err != nil {
+		t.Fatal(err)
+	}
+	res, err := m.Resolve(ctx, a.ID)
+	if err != nil || len(res.Targets) != 1 || res.Targets[0] != "c" || res.Identifier.ID != b.ID {
+		t.Errorf("wrong resolution %+v, error %v", res, err)
+	}
+	if strings.Join(res.Path, ",") != strings.Join([]string{a.ID, b.ID, "b"}, ",") {
+		t.Errorf("wrong resolution path %v", res.Path)
+	}
+
+	// split records are resolved into several records
+	split := curation.SplitRequest{Source: "c", Parts: []curation.SplitPart{{ID: "c1"}, {ID: "c2"}}}
+	if _, err := m.Redirects.Split(ctx, "carol", split); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := m.Resolve(ctx, c.ID); err != nil || len(res.Targets) != 2 {
+		t.Errorf("wrong resolution %+v, error %v", res, err)
+	}
+
+	// removed records are gone
+	m.Redirects.Store.Delete(ctx, "meta", "carol", "", "c1")
+	m.Redirects.Store.Delete(ctx, "meta", "carol", "", "c2")
+	if _, err := m.Resolve(ctx, c.ID); !errors.Is(err, ErrGone) {
+		t.Errorf("removed record is resolved, error %v", err)
+	}
+	if _, err := m.Resolve(ctx, "chess:unknown"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unknown identifier is resolved, error %v", err)
+	}
+}
+
+// TestHandlers
+func TestHandlers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	m := testManager(t, srvConfig.IDs{Scheme: SchemePrefix, Prefix: "chess:"})
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		claims := &authz.Claims{CustomClaims: authz.CustomClaims{User: c.GetHeader("X-User"), Roles: c.Request.Header.Values("X-Role")}}
+		c.Set("claims", claims)
+	})
+	for _, route := range m.Routes("/ids", "https://foxden.example.com/record/{id}") {
+		r.Handle(route.Method, route.Path, route.Handler)
+	}
+	a, _, _ := m.Mint(context.Background(), "alice", "record", "a")
+	id, _ := m.NewID()
+	expect := []struct {
+		method, target, role, body string
+		code                       int
+		resp                       string
+	}{
+		{"POST", "/ids", "", `{"kind": "record", "target": "a"}`, http.StatusOK, `"id":"` + a.ID},
+		{"POST", "/ids", "", `{"kind": "dataset", "target": "/beamline=3a/btr=1"}`, http.StatusCreated, `"actor":"dave"`},
+		{"POST", "/ids", "", `{"kind": "dataset"}`, http.StatusBadRequest, ""},
+		{"GET", "/ids/" + a.ID, "", "", http.StatusFound, ""},
+		{"GET", "/ids/" + a.ID + "?format=json", "", "", http.StatusOK, `"targets":["a"]`},
+		{"GET", "/ids/" + id, "", "", http.StatusNotFound, ""},
+		{"GET", "/ids/chess:123", "", "", http.StatusBadRequest, ""},
+		{"POST", "/ids/" + a.ID + "/supersede", "", `{"by": "` + id + `"}`, http.StatusForbidden, ""},
+		{"POST", "/ids/" + a.ID + "/supersede", "curator", `{"by": "` + id + `"}`, http.StatusNotFound, ""},
+	}
+	for _, e := range expect {
+		req := httptest.NewRequest(e.method, e.target, strings.NewReader(e.body))
+		req.Header.Set("X-User", "dave")
+		if e.role != "" {
+			req.Header.Set("X-Role", e.role)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != e.code || !strings.Contains(w.Body.String(), e.resp) {
+			t.Errorf("%s %s: expect %d %s, got %d %s", e.method, e.target, e.code, e.resp, w.Code, w.Body.String())
+		}
+		if e.code == http.StatusFound && w.Header().Get("Location") != "https://foxden.example.com/record/a" {
+			t.Errorf("wrong redirect location %s", w.Header().Get("Location"))
+		}
+	}
+}
@@ -0,0 +1,351 @@
+package jobs
+
+// jobs module provides persistent task queue used by FOXDEN/CHESS services
+// to run long running tasks (data transfers, checksums, etc.). Handlers are
+// registered for named task types, workers claim tasks with visibility
+// timeout and failed tasks are retried with backoff until they are moved to
+// dead-letter state.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// task statuses
+const (
+	StatusQueued  = "queued"  // task awaits execution
+	StatusRunning = "running" // task is claimed by a worker
+	StatusDone    = "done"    // task is successfully completed
+	StatusDead    = "dead"    // task exceeded maximum number of attempts
+)
+
+// ErrLeaseLost is returned when task is no longer owned by the worker, e.g.
+// its visibility timeout expired and it was claimed by another worker
+var ErrLeaseLost = errors.New("task lease is lost")
+
+// ErrNotFound is returned when task does not exist
+var ErrNotFound = errors.New("task not found")
+
+// Task represents persistent task
+type Task struct {
+	ID          string          `json:"id" bson:"_id"`
+	Type        string          `json:"type" bson:"type"`
+	Payload     json.RawMessage `json:"payload" bson:"payload"`
+	Status      string          `json:"status" bson:"status"`
+	Attempts    int             `json:"attempts" bson:"attempts"`
+	MaxAttempts int             `json:"max_attempts" bson:"max_attempts"`
+	RunAt       time.Time       `json:"run_at" bson:"run_at"`
+	LockedUntil time.Time       `json:"locked_until" bson:"locked_until"`
+	Worker      string          `json:"worker" bson:"worker"`
+	LastError   string          `json:"last_error" bson:"last_error"`
+	Created     time.Time       `json:"created" bson:"created"`
+	Updated     time.Time       `json:"updated" bson:"updated"`
+}
+
+// Decode decodes task payload into given value
+func (t *Task) Decode(v any) error {
+	return json.Unmarshal(t.Payload, v)
+}
+
+// Store defines interface of task storage
+type Store interface {
+	// Enqueue persists new task
+	Enqueue(ctx context.Context, task Task) error
+	// Claim atomically claims the oldest due task of given types (all types
+	// if empty) for given worker, it returns nil if there is no task
+	Claim(ctx context.Context, worker string, types []string, visibility time.Duration) (*Task, error)
+	// Extend extends visibility timeout of task claimed by given worker
+	Extend(ctx context.Context, id, worker string, visibility time.Duration) error
+	// Update updates task owned by task.Worker
+	Update(ctx context.Context, task Task) error
+	// Get returns task with given id
+	Get(ctx context.Context, id string) (Task, error)
+	// List returns tasks with given status
+	List(ctx context.Context, status string, limit int) ([]Task, error)
+}
+
+// RetryPolicy defines retry policy of task type
+type RetryPolicy struct {
+	MaxAttempts int           // maximum number of attempts
+	InitialWait time.Duration // wait time after first failure
+	MaxWait     time.Duration // maximum wait time
+	Multiplier  float64       // wait time multiplier applied after every failure
+}
+
+// DefaultRetryPolicy defines retry policy used when task type does not
+// provide one
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 5,
+	InitialWait: 10 * time.Second,
+	MaxWait:     time.Hour,
+	Multiplier:  2,
+}
+
+// Backoff returns wait time after given failed attempt
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	wait := float64(p.InitialWait)
+	mult := p.Multiplier
+	if mult < 1 {
+		mult = 1
+	}
+	for i := 1; i < attempt; i++ {
+		wait *= mult
+		if p.MaxWait > 0 && wait > float64(p.MaxWait) {
+			break
+		}
+	}
+	if p.MaxWait > 0 && wait > float64(p.MaxWait) {
+		return p.MaxWait
+	}
+	return time.Duration(wait)
+}
+
+// Handler processes the task, returned error causes task retry
+type Handler func(ctx context.Context, task *Task) error
+
+// registration represents registered task type
+type registration struct {
+	handler Handler
+	policy  RetryPolicy
+}
+
+// Queue represents task queue with pool of workers
+type Queue struct {
+	Store        Store
+	Name         string        // name of the queue used as workers prefix
+	Workers      int           // number of concurrent workers
+	Visibility   time.Duration // visibility timeout of claimed tasks
+	PollInterval time.Duration // polling interval of idle workers
+	Verbose      int
+
+	mu       sync.RWMutex
+	handlers map[string]registration
+}
+
+// NewQueue returns new queue with default settings
+func NewQueue(store Store) *Queue {
+	name, _ := os.Hostname()
+	return &Queue{
+		Store:        store,
+		Name:         fmt.Sprintf("%s-%d", name, os.Getpid()),
+		Workers:      4,
+		Visibility:   5 * time.Minute,
+		PollInterval: time.Second,
+		handlers:     make(map[string]registration),
+	}
+}
+
+// Register registers handler and retry policy of given task type
+func (q *Queue) Register(ttype string, handler Handler, policy RetryPolicy) {
+	if policy.MaxAttempts <= 0 {
+		policy.MaxAttempts = DefaultRetryPolicy.MaxAttempts
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.handlers == nil {
+		q.handlers = make(map[string]registration)
+	}
+	q.handlers[ttype] = registration{handler: handler, policy: policy}
+}
+
+// Types returns sorted list of registered task types
+func (q *Queue) Types() []string {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	var out []string
+	for ttype := range q.handlers {
+		out = append(out, ttype)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// helper function to get registration of task type
+func (q *Queue) registration(ttype string) (registration, bool) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	reg, ok := q.handlers[ttype]
+	return reg, ok
+}
+
+// Enqueue creates new task of given type and payload which will be executed
+// after given delay, it returns task id
+func (q *Queue) Enqueue(ctx context.Context, ttype string, payload any, delay time.Duration) (string, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	maxAttempts := DefaultRetryPolicy.MaxAttempts
+	if reg, ok := q.registration(ttype); ok {
+		maxAttempts = reg.policy.MaxAttempts
+	}
+	now := time.Now().UTC()
+	task := Task{
+		ID:          uuid.NewString(),
+		Type:        ttype,
+		Payload:     data,
+		Status:      StatusQueued,
+		MaxAttempts: maxAttempts,
+		RunAt:       now.Add(delay),
+		Created:     now,
+		Updated:     now,
+	}
+	if err := q.Store.Enqueue(ctx, task); err != nil {
+		return "", err
+	}
+	return task.ID, nil
+}
+
+// Requeue moves dead (or done) task back to the queue and resets its attempts
+func (q *Queue) Requeue(ctx context.Context, id string) error {
+	task, err := q.Store.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if task.Status == StatusRunning || task.Status == StatusQueued {
+		return fmt.Errorf("task %s is %s", id, task.Status)
+	}
+	task.Status = StatusQueued
+	task.Attempts = 0
+	task.LastError = ""
+	task.RunAt = time.Now().UTC()
+	task.Updated = task.RunAt
+	return q.Store.Update(ctx, task)
+}
+
+// helper function to call handler and recover from its panic
+func safeCall(ctx context.Context, handler Handler, task *Task) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("handler panic: %v", r)
+		}
+	}()
+	return handler(ctx, task)
+}
+
+// Process claims and processes single task by given worker, it returns
+// false if there was no task to process
+func (q *Queue) Process(ctx context.Context, worker string) (bool, error) {
+	task, err := q.Store.Claim(ctx, worker, q.Types(), q.Visibility)
+	if err != nil || task == nil {
+		return false, err
+	}
+	reg, ok := q.registration(task.Type)
+	if !ok {
+		// should not happen since only registered types are claimed
+		return true, fmt.Errorf("no handler for task type %s", task.Type)
+	}
+	maxAttempts := task.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = reg.policy.MaxAttempts
+	}
+	if task.Attempts > maxAttempts {
+		// worker which ran previous attempt died or lost the lease
+		task.Status = StatusDead
+		task.LastError = fmt.Sprintf("visibility timeout expired, %s", task.LastError)
+		task.Updated = time.Now().UTC()
+		return true, q.Store.Update(ctx, *task)
+	}
+
+	// keep extending visibility timeout while handler is running
+	hctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		interval := q.Visibility / 3
+		if interval <= 0 {
+			interval = time.Second
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-hctx.Done():
+				return
+			case <-ticker.C:
+				if err := q.Store.Extend(hctx, task.ID, worker, q.Visibility); err != nil {
+					if hctx.Err() == nil {
+						log.Printf("WARNING: unable to extend task %s, error %v", task.ID, err)
+					}
+					if errors.Is(err, ErrLeaseLost) {
+						cancel()
+						return
+					}
+				}
+			}
+		}
+	}()
+	start := time.Now()
+	err = safeCall(hctx, reg.handler, task)
+	cancel()
+	wg.Wait()
+
+	now := time.Now().UTC()
+	task.Updated = now
+	if err == nil {
+		task.Status = StatusDone
+		task.LastError = ""
+		if q.Verbose > 0 {
+			log.Printf("task %s of type %s is done in %v", task.ID, task.Type, time.Since(start))
+		}
+	} else if task.Attempts >= maxAttempts {
+		task.Status = StatusDead
+		task.LastError = err.Error()
+		log.Printf("ERROR: task %s of type %s failed after %d attempts, error %v", task.ID, task.Type, task.Attempts, err)
+	} else {
+		task.Status = StatusQueued
+		task.LastError = err.Error()
+		task.RunAt = now.Add(reg.policy.Backoff(task.Attempts))
+		if q.Verbose > 0 {
+			log.Printf("WARNING: task %s of type %s failed, attempt %d, error %v", task.ID, task.Type, task.Attempts, err)
+		}
+	}
+	if ctx.Err() != nil {
+		// use fresh context to record task state during shutdown
+		uctx, ucancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer ucancel()
+		return true, q.Store.Update(uctx, *task)
+	}
+	return true, q.Store.Update(ctx, *task)
+}
+
+// Run starts workers which process tasks until context is done
+func (q *Queue) Run(ctx context.Context) {
+	workers := q.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		worker := fmt.Sprintf("%s-%d", q.Name, i)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				ok, err := q.Process(ctx, worker)
+				if err != nil && ctx.Err() == nil {
+					log.Printf("ERROR: worker %s failed to process task, error %v", worker, err)
+				}
+				if ok && err == nil {
+					continue
+				}
+				select {
+				case <-ctx.Done():
+				case <-time.After(q.PollInterval):
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,197 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CHESSComputing/golib/dbs"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// helper function to wait for task status
+func waitStatus(t *testing.T, store Store, id, status string) Task {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		task, err := store.Get(context.Background(), id)
+		if err == nil && task.Status == status {
+			return task
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for task %s status %s, task %+v", id, status, task)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestRetryPolicy
+func TestRetryPolicy(t *testing.T) {
+	policy := RetryPolicy{InitialWait: time.Second, MaxWait: 5 * time.Second, Multiplier: 2}
+	expect := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, wait := range expect {
+		if policy.Backoff(i+1) != wait {
+			t.Errorf("attempt %d: expect %v got %v", i+1, wait, policy.Backoff(i+1))
+		}
+	}
+}
+
+// TestQueue
+func TestQueue(t *testing.T) {
+	store := NewMemoryStore()
+	queue := NewQueue(store)
+	queue.Workers = 2
+	queue.PollInterval = 10 * time.Millisecond
+	var mu sync.Mutex
+	var checksums []string
+	policy := RetryPolicy{MaxAttempts: 3, InitialWait: time.Millisecond}
+	queue.Register("checksum", func(ctx context.Context, task *Task) error {
+		var payload map[string]string
+		if err := task.Decode(&payload); err != nil {
+			return err
+		}
+		if task.Attempts < 2 {
+			return errors.New("temporary failure")
+		}
+		mu.Lock()
+		checksums = append(checksums, payload["file"])
+		mu.Unlock()
+		return nil
+	}, policy)
+	queue.Register("transfer", func(ctx context.Context, task *Task) error {
+		panic("transfer failure")
+	}, policy)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	id1, err := queue.Enqueue(ctx, "checksum", map[string]string{"file": "/a/b/c"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id2, _ := queue.Enqueue(ctx, "transfer", map[string]string{"file": "/a/b/c"}, 0)
+	id3, _ := queue.Enqueue(ctx, "unknown", nil, 0)
+	go queue.Run(ctx)
+
+	task := waitStatus(t, store, id1, StatusDone)
+	if task.Attempts != 2 || len(checksums) != 1 {
+		t.Errorf("wrong task %+v, checksums %v", task, checksums)
+	}
+	task = waitStatus(t, store, id2, StatusDead)
+	if task.Attempts != 3 || task.LastError == "" {
+		t.Errorf("wrong dead task %+v", task)
+	}
+	if task, _ := store.Get(ctx, id3); task.Status != StatusQueued {
+		t.Errorf("task of unknown type should stay queued %+v", task)
+	}
+	dead, _ := store.List(ctx, StatusDead, 0)
+	if len(dead) != 1 || dead[0].ID != id2 {
+		t.Errorf("wrong dead tasks %+v", dead)
+	}
+	if err := queue.Requeue(ctx, id1); err != nil {
+		t.Error(err)
+	}
+	waitStatus(t, store, id1, StatusDone)
+	if err := queue.Requeue(ctx, id3); err == nil {
+		t.Error("queued task should not be requeued")
+	}
+}
+
+// TestVisibilityTimeout
+func TestVisibilityTimeout(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+	queue := NewQueue(store)
+	queue.Register("transfer", func(ctx context.Context, task *Task) error { return nil }, RetryPolicy{MaxAttempts: 2})
+	id, _ := queue.Enqueue(ctx, "transfer", nil, 0)
+
+	// worker which died keeps the task until visibility timeout expires
+	task, err := store.Claim(ctx, "worker1", nil, 50*time.Millisecond)
+	if err != nil || task == nil || task.ID != id {
+		t.Fatalf("unable to claim task %+v, error %v", task, err)
+	}
+	if task, _ := store.Claim(ctx, "worker2", nil, time.Minute); task != nil {
+		t.Fatal("claimed task should not be visible")
+	}
+	time.Sleep(60 * time.Millisecond)
+	if err := store.Extend(ctx, id, "worker1", time.Minute); err != nil {
+		t.Errorf("unexpected extend error %v", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if task, _ := store.Claim(ctx, "worker2", nil, time.Minute); task != nil {
+		t.Fatal("extended task should not be visible")
+	}
+	store.Extend(ctx, id, "worker1", -time.Second)
+	ok, err := queue.Process(ctx, "worker2")
+	if !ok || err != nil {
+		t.Fatalf("task is not processed, error %v", err)
+	}
+	if err := store.Extend(ctx, id, "worker1", time.Minute); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("expect lease lost error, got %v", err)
+	}
+	task2, _ := store.Get(ctx, id)
+	if task2.Status != StatusDone || task2.Attempts != 2 || task2.Worker != "worker2" {
+		t.Errorf("wrong task %+v", task2)
+	}
+	if err := store.Update(ctx, *task); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("stale worker should not update task, error %v", err)
+	}
+}
+
+// TestSQLStore
+func TestSQLStore(t *testing.T) {
+	db, err := dbs.Open("sqlite3://"+filepath.Join(t.TempDir(), "jobs.db"), 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+	if _, err := TasksMigrations.Up(ctx, db, false); err != nil {
+		t.Fatal(err)
+	}
+	store := NewSQLStore(db)
+	now := time.Now().UTC()
+	task := Task{ID: "t1", Type: "transfer", Payload: []byte(`{}`), Status: StatusQueued, RunAt: now, Created: now, Updated: now}
+	if err := store.Enqueue(ctx, task); err != nil {
+		t.Fatal(err)
+	}
+	claimed, err := store.Claim(ctx, "w1", nil, time.Minute)
+	if err != nil || claimed == nil || claimed.Worker != "w1" || claimed.Attempts != 1 {
+		t.Fatalf("wrong claimed task %+v, error %v", claimed, err)
+	}
+	if other, err := store.Claim(ctx, "w2", nil, time.Minute); err != nil || other != nil {
+		t.Errorf("claimed task should not be claimed again %+v, error %v", other, err)
+	}
+	// updates which do not change values should not be reported as lost lease
+	for i := 0; i < 2; i++ {
+		if err := store.Update(ctx, *claimed); err != nil {
+			t.Errorf("update %d failed, error %v", i, err)
+		}
+	}
+	if err := store.Extend(ctx, "t1", "w1", time.Minute); err != nil {
+		t.Error(err)
+	}
+	if err := store.Extend(ctx, "t1", "w2", time.Minute); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("extend by another worker should fail, error %v", err)
+	}
+	stolen := *claimed
+	stolen.Worker = "w2"
+	if err := store.Update(ctx, stolen); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("update by another worker should fail, error %v", err)
+	}
+	claimed.Status = StatusDone
+	if err := store.Update(ctx, *claimed); err != nil {
+		t.Error(err)
+	}
+	if got, err := store.Get(ctx, "t1"); err != nil || got.Status != StatusDone {
+		t.Errorf("wrong task %+v, error %v", got, err)
+	}
+
+	// MySQL does not support IF NOT EXISTS indexes
+	migrations, err := TasksMigrations.Load("mysql")
+	if err != nil || len(migrations) != 1 || strings.Contains(migrations[0].Up, "CREATE INDEX") {
+		t.Errorf("wrong MySQL migrations %+v, error %v", migrations, err)
+	}
+}
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS tasks_status_idx;
+DROP TABLE IF EXISTS tasks;
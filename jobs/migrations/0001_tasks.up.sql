@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS tasks (
+    id VARCHAR(64) PRIMARY KEY,
+    type VARCHAR(255) NOT NULL,
+    payload TEXT,
+    status VARCHAR(16) NOT NULL,
+    attempts INTEGER DEFAULT 0,
+    max_attempts INTEGER DEFAULT 0,
+    run_at BIGINT,
+    locked_until BIGINT,
+    worker VARCHAR(255),
+    last_error TEXT,
+    created BIGINT,
+    updated BIGINT,
+    version BIGINT DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS tasks_status_idx ON tasks (status, run_at);
@@ -0,0 +1,497 @@
+package jobs
+
+// store module provides memory, MongoDB and SQL task stores
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CHESSComputing/golib/dbs"
+	"github.com/CHESSComputing/golib/mongo"
+	utils "github.com/CHESSComputing/golib/utils"
+	bson "go.mongodb.org/mongo-driver/bson"
+	mongoDriver "go.mongodb.org/mongo-driver/mongo"
+	mongoOptions "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TasksTable defines default name of tasks table (collection)
+var TasksTable = "tasks"
+
+// helper function to check if task of given types can be claimed
+func claimable(task Task, types []string, now time.Time) bool {
+	if len(types) > 0 {
+		found := false
+		for _, t := range types {
+			if t == task.Type {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	switch task.Status {
+	case StatusQueued:
+		return !task.RunAt.After(now)
+	case StatusRunning:
+		return task.LockedUntil.Before(now)
+	}
+	return false
+}
+
+// MemoryStore represents in-memory task store used by tests
+type MemoryStore struct {
+	mu    sync.Mutex
+	tasks map[string]Task
+}
+
+// NewMemoryStore returns new memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{tasks: make(map[string]Task)}
+}
+
+// Enqueue implements Store interface
+func (s *MemoryStore) Enqueue(ctx context.Context, task Task) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.tasks[task.ID]; ok {
+		return fmt.Errorf("task %s already exists", task.ID)
+	}
+	s.tasks[task.ID] = task
+	return nil
+}
+
+// Claim implements Store interface
+func (s *MemoryStore) Claim(ctx context.Context, worker string, types []string, visibility time.Duration) (*Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().UTC()
+	var task *Task
+	for _, t := range s.tasks {
+		if claimable(t, types, now) && (task == nil || t.RunAt.Before(task.RunAt)) {
+			t := t
+			task = &t
+		}
+	}
+	if task == nil {
+		return nil, nil
+	}
+	task.Status = StatusRunning
+	task.Worker = worker
+	task.Attempts++
+	task.LockedUntil = now.Add(visibility)
+	task.Updated = now
+	s.tasks[task.ID] = *task
+	return task, nil
+}
+
+// Extend implements Store interface
+func (s *MemoryStore) Extend(ctx context.Context, id, worker string, visibility time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	task, ok := s.tasks[id]
+	if !ok || task.Worker != worker || task.Status != StatusRunning {
+		return ErrLeaseLost
+	}
+	task.LockedUntil = time.Now().UTC().Add(visibility)
+	s.tasks[id] = task
+	return nil
+}
+
+// Update implements Store interface
+func (s *MemoryStore) Update(ctx context.Context, task Task) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.tasks[task.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	if old.Worker != task.Worker {
+		return ErrLeaseLost
+	}
+	s.tasks[task.ID] = task
+	return nil
+}
+
+// Get implements Store interface
+func (s *MemoryStore) Get(ctx context.Context, id string) (Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	task, ok := s.tasks[id]
+	if !ok {
+		return task, ErrNotFound
+	}
+	return task, nil
+}
+
+// List implements Store interface
+func (s *MemoryStore) List(ctx context.Context, status string, limit int) ([]Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []Task
+	for _, task := range s.tasks {
+		if status == "" || task.Status == status {
+			out = append(out, task)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Created.Before(out[j].Created) })
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	return out, nil
+}
+
+// MongoStore represents task store within MongoDB collection
+type MongoStore struct {
+	DBName     string
+	Collection string
+}
+
+// NewMongoStore returns MongoDB task store for given database name
+func NewMongoStore(dbname string) *MongoStore {
+	return &MongoStore{DBName: dbname, Collection: TasksTable}
+}
+
+// helper function to get MongoDB collection
+func (s *MongoStore) collection() *mongoDriver.Collection {
+	return mongo.Mongo.Connect().Database(s.DBName).Collection(s.Collection)
+}
+
+// helper function to provide MongoDB spec of claimable tasks
+func claimSpec(types []string, now time.Time) bson.M {
+	spec := bson.M{"$or": []bson.M{
+		{"status": StatusQueued, "run_at": bson.M{"$lte": now}},
+		{"status": StatusRunning, "locked_until": bson.M{"$lt": now}},
+	}}
+	if len(types) > 0 {
+		spec["type"] = bson.M{"$in": types}
+	}
+	return spec
+}
+
+// Enqueue implements Store interface
+func (s *MongoStore) Enqueue(ctx context.Context, task Task) error {
+	if _, err := s.collection().InsertOne(ctx, task); err != nil {
+		return fmt.Errorf("unable to insert task %s, error %v", task.ID, err)
+	}
+	return nil
+}
+
+// Claim implements Store interface
+func (s *MongoStore) Claim(ctx context.Context, worker string, types []string, visibility time.Duration) (*Task, error) {
+	now := time.Now().UTC()
+	update := bson.M{
+		"$set": bson.M{"status": StatusRunning, "worker": worker, "locked_until": now.Add(visibility), "updated": now},
+		"$inc": bson.M{"attempts": 1},
+	}
+	opts := mongoOptions.FindOneAndUpdate().
+		SetSort(bson.M{"run_at": 1}).
+		SetReturnDocument(mongoOptions.After)
+	var task Task
+	err := s.collection().FindOneAndUpdate(ctx, claimSpec(types, now), update, opts).Decode(&task)
+	if errors.Is(err, mongoDriver.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to claim task, error %v", err)
+	}
+	return &task, nil
+}
+
+// Extend implements Store interface
+func (s *MongoStore) Extend(ctx context.Context, id, worker string, visibility time.Duration) error {
+	spec := bson.M{"_id": id, "worker": worker, "status": StatusRunning}
+	update := bson.M{"$set": bson.M{"locked_until": time.Now().UTC().Add(visibility)}}
+	res, err := s.collection().UpdateOne(ctx, spec, update)
+	if err != nil {
+		return fmt.Errorf("unable to extend task %s, error %v", id, err)
+	}
+	if res.MatchedCount == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// Update implements Store interface
+func (s *MongoStore) Update(ctx context.Context, task Task) error {
+	spec := bson.M{"_id": task.ID, "worker": task.Worker}
+	res, err := s.collection().ReplaceOne(ctx, spec, task)
+	if err != nil {
+		return fmt.Errorf("unable to update task %s, error %v", task.ID, err)
+	}
+	if res.MatchedCount == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// Get implements Store interface
+func (s *MongoStore) Get(ctx context.Context, id string) (Task, error) {
+	var task Task
+	err := s.collection().FindOne(ctx, bson.M{"_id": id}).Decode(&task)
+	if errors.Is(err, mongoDriver.ErrNoDocuments) {
+		return task, ErrNotFound
+	}
+	return task, err
+}
+
+// List implements Store interface
+func (s *MongoStore) List(ctx context.Context, status string, limit int) ([]Task, error) {
+	spec := bson.M{}
+	if status != "" {
+		spec["status"] = status
+	}
+	opts := mongoOptions.Find().SetSort(bson.M{"created": 1})
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+	cur, err := s.collection().Find(ctx, spec, opts)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list tasks, error %v", err)
+	}
+	var out []Task
+	err = cur.All(ctx, &out)
+	return out, err
+}
+
+// tasksColumns defines columns of SQL tasks table
+var tasksColumns = []string{
+	"id", "type", "payload", "status", "attempts", "max_attempts",
+	"run_at", "locked_until", "worker", "last_error", "created", "updated",
+}
+
+// TasksMigrationsFS holds SQL migrations of tasks table
+//
+//go:embed migrations/*.sql
+var TasksMigrationsFS embed.FS
+
+// TasksMigrations represents migration set of SQL tasks table, services
+// apply it together with their own migrations, e.g.
+// dbs.Migrate(ctx, jobs.TasksMigrations)
+var TasksMigrations = dbs.MigrationSet{Name: "tasks", Files: TasksMigrationsFS, Table: "tasks_migrations"}
+
+// SQLStore represents task store within SQL table (e.g. SQLite) created by
+// TasksMigrations. Times are stored in milliseconds.
+type SQLStore struct {
+	DB    *dbs.Connection
+	Table string
+}
+
+// NewSQLStore returns SQL task store for given database connection
+func NewSQLStore(db *dbs.Connection) *SQLStore {
+	return &SQLStore{DB: db, Table: TasksTable}
+}
+
+// helper function to convert time into milliseconds, zero time is stored as 0
+func millis(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixMilli()
+}
+
+// helper function to convert milliseconds into time
+func fromMillis(v any) time.Time {
+	ms := utils.ToInt64(v)
+	if ms == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms).UTC()
+}
+
+// helper function to convert task into column values
+func taskValues(task Task) []any {
+	return []any{
+		task.ID, task.Type, string(task.Payload), task.Status, task.Attempts, task.MaxAttempts,
+		millis(task.RunAt), millis(task.LockedUntil), task.Worker, task.LastError,
+		millis(task.Created), millis(task.Updated),
+	}
+}
+
+// helper function to convert SQL record into task
+func taskRecord(rec map[string]any) Task {
+	str := func(key string) string {
+		if v, ok := rec[key].(string); ok {
+			return v
+		}
+		return ""
+	}
+	return Task{
+		ID:          str("id"),
+		Type:        str("type"),
+		Payload:     []byte(str("payload")),
+		Status:      str("status"),
+		Attempts:    int(utils.ToInt64(rec["attempts"])),
+		MaxAttempts: int(utils.ToInt64(rec["max_attempts"])),
+		RunAt:       fromMillis(rec["run_at"]),
+		LockedUntil: fromMillis(rec["locked_until"]),
+		Worker:      str("worker"),
+		LastError:   str("last_error"),
+		Created:     fromMillis(rec["created"]),
+		Updated:     fromMillis(rec["updated"]),
+	}
+}
+
+// helper function to build SQL condition of claimable tasks
+func (s *SQLStore) claimCondition(types []string, now time.Time) (string, []any) {
+	cond := "((status = ? AND run_at <= ?) OR (status = ? AND locked_until < ?))"
+	args := []any{StatusQueued, millis(now), StatusRunning, millis(now)}
+	if len(types) > 0 {
+		cond += fmt.Sprintf(" AND type IN (%s)", strings.TrimSuffix(strings.Repeat("?, ", len(types)), ", "))
+		for _, t := range types {
+			args = append(args, t)
+		}
+	}
+	return cond, args
+}
+
+// Enqueue implements Store interface
+func (s *SQLStore) Enqueue(ctx context.Context, task Task) error {
+	stm, err := s.DB.Statement("insert", dbs.StatementArgs{Table: s.Table, Columns: tasksColumns})
+	if err != nil {
+		return err
+	}
+	return s.DB.WithTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, stm, taskValues(task)...); err != nil {
+			return fmt.Errorf("unable to insert task %s, error %v", task.ID, err)
+		}
+		return nil
+	})
+}
+
+// Claim implements Store interface. The oldest claimable task is selected
+// and updated only if it is still claimable, therefore concurrent workers
+// never claim the same task.
+func (s *SQLStore) Claim(ctx context.Context, worker string, types []string, visibility time.Duration) (*Task, error) {
+	now := time.Now().UTC()
+	cond, args := s.claimCondition(types, now)
+	var id string
+	err := s.DB.WithTx(ctx, func(tx *sql.Tx) error {
+		stm := fmt.Sprintf("SELECT id FROM %s WHERE %s ORDER BY run_at LIMIT 1", s.Table, cond)
+		err := tx.QueryRowContext(ctx, dbs.Placeholders(s.DB.Driver, stm), args...).Scan(&id)
+		if errors.Is(err, sql.ErrNoRows) {
+			id = ""
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("unable to select task, error %v", err)
+		}
+		stm = fmt.Sprintf("UPDATE %s SET status = ?, worker = ?, locked_until = ?, attempts = attempts + 1, updated = ? WHERE id = ? AND %s", s.Table, cond)
+		uargs := append([]any{StatusRunning, worker, millis(now.Add(visibility)), millis(now), id}, args...)
+		res, err := tx.ExecContext(ctx, dbs.Placeholders(s.DB.Driver, stm), uargs...)
+		if err != nil {
+			return fmt.Errorf("unable to claim task %s, error %v", id, err)
+		}
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			// task is claimed by another worker
+			id = ""
+		}
+		return nil
+	})
+	if err != nil || id == "" {
+		return nil, err
+	}
+	task, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+// helper function to update columns of task owned by given worker, if
+// status is provided only task with such status is updated. Row version is incremented on every update since MySQL reports only rows
+// with changed values as affected ones, while zero affected rows means that
+// the lease is lost.
+func (s *SQLStore) update(ctx context.Context, rec map[string]any, id, worker, status string) error {
+	var cols []string
+	for col := range rec {
+		cols = append(cols, col)
+	}
+	sort.Strings(cols)
+	var set []string
+	var args []any
+	for _, col := range cols {
+		set = append(set, col+" = ?")
+		args = append(args, rec[col])
+	}
+	set = append(set, "version = version + 1")
+	args = append(args, id, worker)
+	stm := fmt.Sprintf("UPDATE %s SET %s WHERE id = ? AND worker = ?", s.Table, strings.Join(set, ", "))
+	if status != "" {
+		stm += " AND status = ?"
+		args = append(args, status)
+	}
+	var nrows int64
+	err := s.DB.WithTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, dbs.Placeholders(s.DB.Driver, stm), args...)
+		if err != nil {
+			return fmt.Errorf("unable to update task %s, error %v", id, err)
+		}
+		nrows, err = res.RowsAffected()
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if nrows == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// Extend implements Store interface
+func (s *SQLStore) Extend(ctx context.Context, id, worker string, visibility time.Duration) error {
+	rec := map[string]any{"locked_until": millis(time.Now().UTC().Add(visibility))}
+	return s.update(ctx, rec, id, worker, StatusRunning)
+}
+
+// Update implements Store interface
+func (s *SQLStore) Update(ctx context.Context, task Task) error {
+	rec := make(map[string]any)
+	vals := taskValues(task)
+	for i, col := range tasksColumns {
+		if col != "id" && col != "worker" && col != "created" {
+			rec[col] = vals[i]
+		}
+	}
+	return s.update(ctx, rec, task.ID, task.Worker, "")
+}
+
+// Get implements Store interface
+func (s *SQLStore) Get(ctx context.Context, id string) (Task, error) {
+	args := dbs.StatementArgs{Table: s.Table, Columns: tasksColumns}
+	records, err := s.DB.SelectTable(ctx, args, map[string]any{"id": id})
+	if err != nil {
+		return Task{}, err
+	}
+	if len(records) == 0 {
+		return Task{}, ErrNotFound
+	}
+	return taskRecord(records[0]), nil
+}
+
+// List implements Store interface
+func (s *SQLStore) List(ctx context.Context, status string, limit int) ([]Task, error) {
+	args := dbs.StatementArgs{Table: s.Table, Columns: tasksColumns, OrderBy: "created", Limit: limit}
+	where := make(map[string]any)
+	if status != "" {
+		where["status"] = status
+	}
+	records, err := s.DB.SelectTable(ctx, args, where)
+	if err != nil {
+		return nil, err
+	}
+	var out []Task
+	for _, rec := range records {
+		out = append(out, taskRecord(rec))
+	}
+	return out, nil
+}
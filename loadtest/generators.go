@@ -0,0 +1,162 @@
+package loadtest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	services "github.com/CHESSComputing/golib/services"
+	"github.com/gin-gonic/gin"
+)
+
+// RecordedHeaders defines HTTP headers kept in recorded traffic, tokens are
+// never recorded
+var RecordedHeaders = []string{"Accept", "Content-Type"}
+
+// Replay represents generator which replays recorded requests
+type Replay struct {
+	Requests []Request // recorded requests
+	Loop     bool      // start over when all requests are replayed
+}
+
+// Next implements Generator interface
+func (r *Replay) Next(i int) (Request, bool) {
+	if len(r.Requests) == 0 || (!r.Loop && i >= len(r.Requests)) {
+		return Request{}, false
+	}
+	return r.Requests[i%len(r.Requests)], true
+}
+
+// ReadRequests reads recorded requests in NDJSON format
+func ReadRequests(reader io.Reader) ([]Request, error) {
+	var reqs []Request
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		var req Request
+		if err := json.Unmarshal(data, &req); err != nil {
+			return nil, fmt.Errorf("invalid request at line %d, error %v", line, err)
+		}
+		reqs = append(reqs, req)
+	}
+	return reqs, scanner.Err()
+}
+
+// RecordMiddleware returns gin middleware which records requests of the
+// service into writer in NDJSON format suitable for Replay generator
+func RecordMiddleware(w io.Writer) gin.HandlerFunc {
+	var mu sync.Mutex
+	encoder := json.NewEncoder(w)
+	return func(c *gin.Context) {
+		req := Request{Method: c.Request.Method, Path: c.Request.URL.RequestURI()}
+		for _, key := range RecordedHeaders {
+			if val := c.Request.Header.Get(key); val != "" {
+				if req.Header == nil {
+					req.Header = make(map[string]string)
+				}
+				req.Header[key] = val
+			}
+		}
+		if c.Request.Body != nil {
+			body, err := io.ReadAll(c.Request.Body)
+			c.Request.Body.Close()
+			c.Request.Body = io.NopCloser(bytes.NewReader(body))
+			if err == nil {
+				req.Body = string(body)
+			}
+		}
+		mu.Lock()
+		encoder.Encode(req)
+		mu.Unlock()
+		c.Next()
+	}
+}
+
+// Synthetic represents generator of synthetic metadata queries and inserts
+// of MetaData service
+type Synthetic struct {
+	Queries     []string // queries to choose from, default queries match inserted records
+	InsertRatio float64  // fraction of insert requests, from 0 to 1
+	Schema      string   // schema of inserted records
+	SearchPath  string   // path of search API, default /search
+	InsertPath  string   // path of insert API, default /
+	Limit       int      // limit of query results, default 10
+	Seed        int64    // seed of random generator, default is random
+	Beamlines   []string // beamlines of inserted records
+
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+// helper function to initialize defaults of generator
+func (s *Synthetic) init() {
+	if s.rnd != nil {
+		return
+	}
+	seed := s.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	s.rnd = rand.New(rand.NewSource(seed))
+	if s.SearchPath == "" {
+		s.SearchPath = "/search"
+	}
+	if s.InsertPath == "" {
+		s.InsertPath = "/"
+	}
+	if s.Limit == 0 {
+		s.Limit = 10
+	}
+	if s.Schema == "" {
+		s.Schema = "loadtest"
+	}
+	if len(s.Beamlines) == 0 {
+		s.Beamlines = []string{"1a3", "3a", "4b", "id1a3", "id3a", "id4b"}
+	}
+	if len(s.Queries) == 0 {
+		for _, beamline := range s.Beamlines {
+			s.Queries = append(s.Queries, fmt.Sprintf(`{"beamline": "%s"}`, beamline))
+		}
+		s.Queries = append(s.Queries, `{"loadtest": true}`, "{}")
+	}
+}
+
+// Next implements Generator interface
+func (s *Synthetic) Next(i int) (Request, bool) {
+	s.mu.Lock()
+	s.init()
+	insert := s.rnd.Float64() < s.InsertRatio
+	query := s.Queries[s.rnd.Intn(len(s.Queries))]
+	beamline := s.Beamlines[s.rnd.Intn(len(s.Beamlines))]
+	s.mu.Unlock()
+	header := map[string]string{"Content-Type": "application/json", "Accept": "application/json"}
+	if insert {
+		rec := services.MetaRecord{
+			Schema: s.Schema,
+			Record: map[string]any{
+				"did":      fmt.Sprintf("/beamline=%s/loadtest=%d/run=%d", beamline, time.Now().UnixNano(), i),
+				"beamline": beamline,
+				"loadtest": true,
+				"date":     time.Now().Unix(),
+			},
+		}
+		data, _ := json.Marshal(rec)
+		return Request{Method: http.MethodPost, Path: s.InsertPath, Header: header, Body: string(data)}, true
+	}
+	sreq := services.ServiceRequest{
+		Client:       "loadtest",
+		ServiceQuery: services.ServiceQuery{Query: query, Idx: 0, Limit: s.Limit},
+	}
+	data, _ := json.Marshal(sreq)
+	return Request{Method: http.MethodPost, Path: s.SearchPath, Header: header, Body: string(data)}, true
+}
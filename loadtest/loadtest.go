@@ -0,0 +1,244 @@
+package loadtest
+
+// loadtest module replays recorded HTTP traffic or generates synthetic
+// metadata queries and inserts against FOXDEN services and reports latency
+// percentiles
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Request represents HTTP request sent to target service
+type Request struct {
+	Method string            `json:"method"`
+	Path   string            `json:"path"` // path and query relative to target URL
+	Header map[string]string `json:"header,omitempty"`
+	Body   string            `json:"body,omitempty"`
+}
+
+// Generator provides requests of load test, Next is called concurrently by
+// workers with sequential request number and returns false when there are
+// no more requests
+type Generator interface {
+	Next(i int) (Request, bool)
+}
+
+// Options represents load test parameters
+type Options struct {
+	Target      string        // base URL of target service
+	Concurrency int           // number of concurrent workers, default 1
+	Requests    int           // total number of requests, 0 means no limit
+	Duration    time.Duration // duration of the test, 0 means no limit
+	Rate        float64       // maximum number of requests per second, 0 means no limit
+	Timeout     time.Duration // request timeout, default 30 seconds
+	Token       string        // bearer token of requests
+	Client      *http.Client  // HTTP client, default client is used if not set
+}
+
+// Report represents load test results
+type Report struct {
+	Requests   int           `json:"requests"`   // number of sent requests
+	Errors     int           `json:"errors"`     // number of failed requests, i.e. transport errors and 5xx codes
+	Codes      map[int]int   `json:"codes"`      // number of responses per HTTP code
+	Elapsed    time.Duration `json:"elapsed"`    // test duration
+	Throughput float64       `json:"throughput"` // requests per second
+	Min        time.Duration `json:"min"`
+	Mean       time.Duration `json:"mean"`
+	P50        time.Duration `json:"p50"`
+	P90        time.Duration `json:"p90"`
+	P95        time.Duration `json:"p95"`
+	P99        time.Duration `json:"p99"`
+	Max        time.Duration `json:"max"`
+}
+
+// String returns human readable representation of the report
+func (r Report) String() string {
+	var codes []int
+	for code := range r.Codes {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	var scodes []string
+	for _, code := range codes {
+		scodes = append(scodes, fmt.Sprintf("%d:%d", code, r.Codes[code]))
+	}
+	return fmt.Sprintf(`requests:   %d
+errors:     %d
+codes:      %s
+elapsed:    %v
+throughput: %.2f req/s
+latency:    min %v mean %v p50 %v p90 %v p95 %v p99 %v max %v`,
+		r.Requests, r.Errors, strings.Join(scodes, " "), r.Elapsed.Round(time.Millisecond), r.Throughput,
+		r.Min, r.Mean, r.P50, r.P90, r.P95, r.P99, r.Max)
+}
+
+// Percentile returns p-th percentile (0-100) of sorted latencies using
+// nearest rank method
+func Percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+// result represents outcome of single request
+type result struct {
+	latency time.Duration
+	code    int
+	err     error
+}
+
+// helper function to send single request
+func send(ctx context.Context, client *http.Client, opts Options, req Request) result {
+	method := req.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	var body io.Reader
+	if req.Body != "" {
+		body = strings.NewReader(req.Body)
+	}
+	rurl := strings.TrimSuffix(opts.Target, "/") + "/" + strings.TrimPrefix(req.Path, "/")
+	hreq, err := http.NewRequestWithContext(ctx, method, rurl, body)
+	if err != nil {
+		return result{err: err}
+	}
+	for key, val := range req.Header {
+		hreq.Header.Set(key, val)
+	}
+	if opts.Token != "" {
+		hreq.Header.Set("Authorization", "Bearer "+opts.Token)
+	}
+	start := time.Now()
+	resp, err := client.Do(hreq)
+	if err != nil {
+		return result{latency: time.Since(start), err: err}
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return result{latency: time.Since(start), code: resp.StatusCode}
+}
+
+// Run runs load test against target service with requests provided by the
+// generator and returns its report. The test stops when given number of
+// requests is sent, duration expires, generator is exhausted or context is
+// cancelled.
+func Run(ctx context.Context, opts Options, gen Generator) (Report, error) {
+	if opts.Target == "" {
+		return Report{}, errors.New("target URL is not provided")
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 1
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 30 * time.Second
+	}
+	client := opts.Client
+	if client == nil {
+		client = &http.Client{Timeout: opts.Timeout}
+	}
+	if opts.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Duration)
+		defer cancel()
+	}
+
+	// rate limiter provides permissions to send requests
+	var ticks <-chan time.Time
+	if opts.Rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / opts.Rate))
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+
+	var counter int64
+	var mu sync.Mutex
+	var results []result
+	var wg sync.WaitGroup
+	start := time.Now()
+	for w := 0; w < opts.Concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(atomic.AddInt64(&counter, 1)) - 1
+				if opts.Requests > 0 && i >= opts.Requests {
+					return
+				}
+				req, ok := gen.Next(i)
+				if !ok {
+					return
+				}
+				if ticks != nil {
+					select {
+					case <-ticks:
+					case <-ctx.Done():
+						return
+					}
+				}
+				if ctx.Err() != nil {
+					return
+				}
+				res := send(ctx, client, opts, req)
+				// requests interrupted by the end of the test are not counted
+				if res.err != nil && ctx.Err() != nil {
+					return
+				}
+				mu.Lock()
+				results = append(results, res)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return report(results, time.Since(start)), nil
+}
+
+// helper function to build report of request results
+func report(results []result, elapsed time.Duration) Report {
+	rep := Report{Requests: len(results), Codes: make(map[int]int), Elapsed: elapsed}
+	if len(results) == 0 {
+		return rep
+	}
+	latencies := make([]time.Duration, 0, len(results))
+	var total time.Duration
+	for _, res := range results {
+		if res.err != nil || res.code >= 500 {
+			rep.Errors++
+		}
+		if res.err == nil {
+			rep.Codes[res.code]++
+		}
+		latencies = append(latencies, res.latency)
+		total += res.latency
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	rep.Min = latencies[0]
+	rep.Max = latencies[len(latencies)-1]
+	rep.Mean = total / time.Duration(len(latencies))
+	rep.P50 = Percentile(latencies, 50)
+	rep.P90 = Percentile(latencies, 90)
+	rep.P95 = Percentile(latencies, 95)
+	rep.P99 = Percentile(latencies, 99)
+	if elapsed > 0 {
+		rep.Throughput = float64(len(results)) / elapsed.Seconds()
+	}
+	return rep
+}
@@ -0,0 +1,155 @@
+package loadtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	services "github.com/CHESSComputing/golib/services"
+	"github.com/gin-gonic/gin"
+)
+
+// TestPercentile
+func TestPercentile(t *testing.T) {
+	var latencies []time.Duration
+	for i := 1; i <= 100; i++ {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+	for p, expect := range map[float64]time.Duration{50: 50, 90: 90, 99: 99, 100: 100, 0: 1} {
+		if val := Percentile(latencies, p); val != expect*time.Millisecond {
+			t.Errorf("p%v: expect %v, got %v", p, expect*time.Millisecond, val)
+		}
+	}
+	if Percentile(nil, 50) != 0 {
+		t.Error("percentile of empty list is not zero")
+	}
+}
+
+// TestRunReplay
+func TestRunReplay(t *testing.T) {
+	var mu sync.Mutex
+	paths := make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths[r.Method+" "+r.URL.RequestURI()]++
+		mu.Unlock()
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+	data := `{"method": "GET", "path": "/records?did=/a/b/c"}
+
+{"method": "POST", "path": "/search", "body": "{}"}
+{"method": "GET", "path": "/fail"}
+`
+	reqs, err := ReadRequests(strings.NewReader(data))
+	if err != nil || len(reqs) != 3 {
+		t.Fatalf("unable to read requests %+v, error %v", reqs, err)
+	}
+	opts := Options{Target: srv.URL, Concurrency: 4, Token: "token"}
+	rep, err := Run(context.Background(), opts, &Replay{Requests: reqs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Requests != 3 || rep.Errors != 1 || rep.Codes[200] != 2 || rep.Codes[500] != 1 {
+		t.Errorf("unexpected report %+v", rep)
+	}
+	if rep.Min > rep.P50 || rep.P50 > rep.P99 || rep.P99 > rep.Max {
+		t.Errorf("inconsistent latencies\n%s", rep)
+	}
+
+	// looped replay limited by number of requests
+	opts.Requests = 10
+	rep, err = Run(context.Background(), opts, &Replay{Requests: reqs, Loop: true})
+	if err != nil || rep.Requests != 10 {
+		t.Errorf("unexpected report %+v, error %v", rep, err)
+	}
+	if paths["GET /records?did=/a/b/c"] != 5 {
+		t.Errorf("unexpected requests %v", paths)
+	}
+
+	// rate limited test with duration
+	opts = Options{Target: srv.URL, Duration: 200 * time.Millisecond, Rate: 20}
+	rep, err = Run(context.Background(), opts, &Replay{Requests: reqs, Loop: true})
+	if err != nil || rep.Requests < 2 || rep.Requests > 5 {
+		t.Errorf("rate is not limited, report %+v, error %v", rep, err)
+	}
+	if _, err := Run(context.Background(), Options{}, &Replay{}); err == nil {
+		t.Error("test without target is run")
+	}
+}
+
+// TestSynthetic
+func TestSynthetic(t *testing.T) {
+	gen := &Synthetic{InsertRatio: 0.3, Seed: 1}
+	var inserts int
+	for i := 0; i < 100; i++ {
+		req, ok := gen.Next(i)
+		if !ok {
+			t.Fatal("synthetic generator is exhausted")
+		}
+		switch req.Path {
+		case "/":
+			inserts++
+			var rec services.MetaRecord
+			if err := json.Unmarshal([]byte(req.Body), &rec); err != nil || rec.Schema != "loadtest" || rec.Record["did"] == "" {
+				t.Errorf("invalid insert request %s, error %v", req.Body, err)
+			}
+		case "/search":
+			var sreq services.ServiceRequest
+			if err := json.Unmarshal([]byte(req.Body), &sreq); err != nil || sreq.ServiceQuery.Limit != 10 {
+				t.Errorf("invalid search request %s, error %v", req.Body, err)
+			}
+		default:
+			t.Errorf("unexpected request %+v", req)
+		}
+	}
+	if inserts < 15 || inserts > 45 {
+		t.Errorf("unexpected number of inserts %d", inserts)
+	}
+}
+
+// TestRecordMiddleware
+func TestRecordMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var buf bytes.Buffer
+	r := gin.New()
+	r.Use(RecordMiddleware(&buf))
+	r.POST("/search", func(c *gin.Context) {
+		var body map[string]any
+		if err := c.BindJSON(&body); err != nil {
+			return
+		}
+		c.JSON(http.StatusOK, body)
+	})
+	req := httptest.NewRequest("POST", "/search?idx=1", strings.NewReader(`{"query": "{}"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("request body is not passed to handler, code %d", w.Code)
+	}
+	reqs, err := ReadRequests(&buf)
+	if err != nil || len(reqs) != 1 {
+		t.Fatalf("unexpected recorded requests %+v, error %v", reqs, err)
+	}
+	rec := reqs[0]
+	if rec.Path != "/search?idx=1" || rec.Body != `{"query": "{}"}` || rec.Header["Content-Type"] != "application/json" {
+		t.Errorf("unexpected recorded request %+v", rec)
+	}
+	if _, ok := rec.Header["Authorization"]; ok {
+		t.Error("token is recorded")
+	}
+}
@@ -0,0 +1,263 @@
+package mail
+
+// mail module provides email notifications of FOXDEN/CHESS services, e.g.
+// account verification, password resets, transfer completion notices and
+// admin alerts. Messages are rendered from templates and sent via SMTP
+// server asynchronously by jobs queue which retries failed deliveries.
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	netMail "net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+)
+
+// Message represents email message
+type Message struct {
+	To      []string `json:"to"`             // recipients
+	Subject string   `json:"subject"`        // message subject
+	Text    string   `json:"text"`           // plain text body
+	HTML    string   `json:"html,omitempty"` // optional HTML body
+}
+
+// Validate checks message recipients and subject
+func (m Message) Validate() error {
+	if len(m.To) == 0 {
+		return errors.New("message has no recipients")
+	}
+	for _, to := range m.To {
+		if _, err := netMail.ParseAddress(to); err != nil {
+			return fmt.Errorf("invalid recipient '%s', error %v", to, err)
+		}
+	}
+	if strings.ContainsAny(m.Subject, "\r\n") {
+		return errors.New("message subject contains line breaks")
+	}
+	return nil
+}
+
+// helper function to write quoted-printable encoded body part
+func writePart(w *bytes.Buffer, body string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(body)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// Bytes returns RFC 5322 representation of the message sent from given
+// address, messages with HTML body are encoded as multipart/alternative
+func (m Message) Bytes(from string) ([]byte, error) {
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	if strings.ContainsAny(from, "\r\n") {
+		return nil, errors.New("sender address contains line breaks")
+	}
+	var buf bytes.Buffer
+	buf.WriteString("From: " + from + "\r\n")
+	buf.WriteString("To: " + strings.Join(m.To, ", ") + "\r\n")
+	buf.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", m.Subject) + "\r\n")
+	buf.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	buf.WriteString("Message-ID: " + messageID(from) + "\r\n")
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	if m.HTML == "" {
+		buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+		buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+		err := writePart(&buf, m.Text)
+		return buf.Bytes(), err
+	}
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	buf.WriteString("Content-Type: multipart/alternative; boundary=" + mw.Boundary() + "\r\n\r\n")
+	for _, part := range []struct{ ctype, body string }{{"text/plain", m.Text}, {"text/html", m.HTML}} {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", part.ctype+"; charset=utf-8")
+		header.Set("Content-Transfer-Encoding", "quoted-printable")
+		pw, err := mw.CreatePart(header)
+		if err != nil {
+			return nil, err
+		}
+		var pbuf bytes.Buffer
+		if err := writePart(&pbuf, part.body); err != nil {
+			return nil, err
+		}
+		if _, err := pw.Write(pbuf.Bytes()); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	buf.Write(body.Bytes())
+	return buf.Bytes(), nil
+}
+
+// helper function to generate message id within domain of sender address
+func messageID(from string) string {
+	domain := "localhost"
+	if addr, err := netMail.ParseAddress(from); err == nil {
+		if idx := strings.LastIndex(addr.Address, "@"); idx > 0 {
+			domain = addr.Address[idx+1:]
+		}
+	}
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return fmt.Sprintf("<%s@%s>", hex.EncodeToString(buf), domain)
+}
+
+// Sender defines interface of message delivery
+type Sender interface {
+	// Send sends message from given address
+	Send(ctx context.Context, from string, msg Message) error
+}
+
+// SMTPSender sends messages via SMTP server. Connection uses implicit TLS
+// if TLS is set, otherwise it is upgraded via STARTTLS if server supports
+// it. Credentials are sent only over TLS connections.
+type SMTPSender struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	TLS      bool
+	Timeout  time.Duration
+	// TLSConfig overwrites TLS configuration of the connection, e.g. in tests
+	TLSConfig *tls.Config
+}
+
+// NewSMTPSender returns SMTP sender of given configuration
+func NewSMTPSender(cfg srvConfig.SMTP) *SMTPSender {
+	port := cfg.Port
+	if port == 0 {
+		port = 587
+		if cfg.TLS {
+			port = 465
+		}
+	}
+	return &SMTPSender{
+		Host:     cfg.Host,
+		Port:     port,
+		Username: cfg.Username,
+		Password: cfg.Password,
+		TLS:      cfg.TLS,
+		Timeout:  30 * time.Second,
+	}
+}
+
+// helper function to provide TLS configuration of the connection
+func (s *SMTPSender) tlsConfig() *tls.Config {
+	if s.TLSConfig != nil {
+		return s.TLSConfig
+	}
+	return &tls.Config{ServerName: s.Host, MinVersion: tls.VersionTLS12}
+}
+
+// Send implements Sender interface
+func (s *SMTPSender) Send(ctx context.Context, from string, msg Message) error {
+	data, err := msg.Bytes(from)
+	if err != nil {
+		return err
+	}
+	sender, err := netMail.ParseAddress(from)
+	if err != nil {
+		return fmt.Errorf("invalid sender '%s', error %v", from, err)
+	}
+	addr := net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+	dialer := &net.Dialer{Timeout: s.Timeout}
+	var conn net.Conn
+	if s.TLS {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: s.tlsConfig()}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to connect to SMTP server %s, error %v", addr, err)
+	}
+	deadline := time.Now().Add(s.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+	client, err := smtp.NewClient(conn, s.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("unable to connect to SMTP server %s, error %v", addr, err)
+	}
+	defer client.Close()
+	if !s.TLS {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(s.tlsConfig()); err != nil {
+				return fmt.Errorf("unable to start TLS with SMTP server %s, error %v", addr, err)
+			}
+		}
+	}
+	if s.Username != "" {
+		// PlainAuth refuses to send credentials over unencrypted connection
+		// to remote servers
+		if err := client.Auth(smtp.PlainAuth("", s.Username, s.Password, s.Host)); err != nil {
+			return fmt.Errorf("SMTP authentication failed, error %v", err)
+		}
+	}
+	if err := client.Mail(sender.Address); err != nil {
+		return err
+	}
+	for _, to := range msg.To {
+		addr, _ := netMail.ParseAddress(to)
+		if err := client.Rcpt(addr.Address); err != nil {
+			return fmt.Errorf("recipient %s is rejected, error %v", to, err)
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// MemorySender keeps sent messages in memory, it is used by tests and
+// development deployments
+type MemorySender struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+// Send implements Sender interface
+func (s *MemorySender) Send(ctx context.Context, from string, msg Message) error {
+	if err := msg.Validate(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = append(s.messages, msg)
+	return nil
+}
+
+// Messages returns sent messages
+func (s *MemorySender) Messages() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Message{}, s.messages...)
+}
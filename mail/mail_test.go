@@ -0,0 +1,255 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	jobs "github.com/CHESSComputing/golib/jobs"
+	pubsub "github.com/CHESSComputing/golib/pubsub"
+	storage "github.com/CHESSComputing/golib/storage"
+	users "github.com/CHESSComputing/golib/users"
+)
+
+// TestMessage
+func TestMessage(t *testing.T) {
+	msg := Message{To: []string{"alice@example.com"}, Subject: "Transfer é", Text: "done"}
+	data, err := msg.Bytes("FOXDEN <foxden@example.com>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(data)
+	for _, s := range []string{"To: alice@example.com\r\n", "Subject: =?utf-8?q?Transfer_=C3=A9?=\r\n", "@example.com>\r\n", "text/plain"} {
+		if !strings.Contains(out, s) {
+			t.Errorf("message does not contain %q\n%s", s, out)
+		}
+	}
+	msg.HTML = "<p>done</p>"
+	data, _ = msg.Bytes("foxden@example.com")
+	if !strings.Contains(string(data), "multipart/alternative") || !strings.Contains(string(data), "<p>done</p>") {
+		t.Errorf("invalid multipart message\n%s", data)
+	}
+	for _, m := range []Message{{Subject: "no recipients"}, {To: []string{"bad"}}, {To: []string{"a@b.c"}, Subject: "a\r\nBcc: x@y.z"}} {
+		if _, err := m.Bytes("foxden@example.com"); err == nil {
+			t.Errorf("invalid message %+v is accepted", m)
+		}
+	}
+}
+
+// helper function to run fake SMTP server which accepts single message
+func smtpServer(t *testing.T) (string, chan string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	out := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		tp := textproto.NewConn(conn)
+		tp.PrintfLine("220 localhost ESMTP")
+		var rcpt []string
+		for {
+			line, err := tp.ReadLine()
+			if err != nil {
+				return
+			}
+			cmd := strings.ToUpper(strings.Fields(line + " ")[0])
+			switch cmd {
+			case "EHLO", "HELO":
+				tp.PrintfLine("250 localhost")
+			case "RCPT":
+				rcpt = append(rcpt, line)
+				tp.PrintfLine("250 OK")
+			case "DATA":
+				tp.PrintfLine("354 go ahead")
+				data, _ := tp.ReadDotBytes()
+				out <- strings.Join(rcpt, "\n") + "\n" + string(data)
+				tp.PrintfLine("250 OK")
+			case "QUIT":
+				tp.PrintfLine("221 bye")
+				return
+			default:
+				tp.PrintfLine("250 OK")
+			}
+		}
+	}()
+	return ln.Addr().String(), out
+}
+
+// TestSMTPSender
+func TestSMTPSender(t *testing.T) {
+	addr, out := smtpServer(t)
+	host, port, _ := net.SplitHostPort(addr)
+	sender := NewSMTPSender(srvConfig.SMTP{Host: host})
+	if sender.Port != 587 {
+		t.Errorf("invalid default port %d", sender.Port)
+	}
+	sender.Port, _ = strconv.Atoi(port)
+	msg := Message{To: []string{"Alice <alice@example.com>"}, Subject: "test", Text: "hello"}
+	if err := sender.Send(context.Background(), "foxden@example.com", msg); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case data := <-out:
+		if !strings.Contains(data, "RCPT TO:<alice@example.com>") || !strings.Contains(data, "hello") {
+			t.Errorf("invalid message\n%s", data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("message is not received")
+	}
+}
+
+// failingSender fails given number of deliveries
+type failingSender struct {
+	MemorySender
+	failures int
+}
+
+// Send implements Sender interface
+func (s *failingSender) Send(ctx context.Context, from string, msg Message) error {
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("server is unavailable")
+	}
+	return s.MemorySender.Send(ctx, from, msg)
+}
+
+// TestMailer
+func TestMailer(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	custom := `{{define "subject"}}Quota {{.Usage}}{{end}}{{define "text"}}see {{.BaseURL}}{{end}}`
+	if err := os.WriteFile(filepath.Join(dir, "quota.tmpl"), []byte(custom), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sender := &failingSender{failures: 1}
+	queue := jobs.NewQueue(jobs.NewMemoryStore())
+	cfg := srvConfig.SMTP{
+		From:        "foxden@example.com",
+		BaseURL:     "https://foxden.example.com/",
+		TemplateDir: dir,
+		AdminEmails: []string{"admin@example.com"},
+		MaxAttempts: 2,
+	}
+	m, err := NewMailer(cfg, sender, queue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := m.Render("quota", map[string]any{"Usage": "90%"})
+	if err != nil || msg.Subject != "Quota 90%" || msg.Text != "see https://foxden.example.com\n" {
+		t.Errorf("invalid message %+v, error %v", msg, err)
+	}
+	if _, err := m.Render("unknown", nil); err == nil {
+		t.Error("unknown template is rendered")
+	}
+
+	// user tokens are delivered via queue and retried
+	user := users.User{Name: "alice", FullName: "Alice Smith", Email: "alice@example.com"}
+	notify := m.UserNotifier()
+	if err := notify(ctx, users.TokenResetPassword, user, "abc", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := notify(ctx, users.TokenVerifyEmail, users.User{Name: "bob"}, "abc", time.Now()); err == nil {
+		t.Error("user without email is notified")
+	}
+	if _, err := queue.Process(ctx, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if len(sender.Messages()) != 0 {
+		t.Fatal("failed delivery is recorded")
+	}
+	tasks, _ := queue.Store.List(ctx, jobs.StatusQueued, 0)
+	if len(tasks) != 1 || tasks[0].MaxAttempts != 2 {
+		t.Fatalf("failed delivery is not retried %+v", tasks)
+	}
+	tasks[0].RunAt = time.Now()
+	queue.Store.Update(ctx, tasks[0])
+	if _, err := queue.Process(ctx, "test"); err != nil {
+		t.Fatal(err)
+	}
+	msgs := sender.Messages()
+	if len(msgs) != 1 {
+		t.Fatalf("message is not delivered, %+v", msgs)
+	}
+	if msgs[0].To[0] != "alice@example.com" || !strings.Contains(msgs[0].Text, "Dear Alice Smith") ||
+		!strings.Contains(msgs[0].Text, "https://foxden.example.com/reset?token=abc") ||
+		!strings.Contains(msgs[0].HTML, `href="https://foxden.example.com/reset?token=abc"`) {
+		t.Errorf("invalid message %+v", msgs[0])
+	}
+
+	// messages are sent synchronously without queue
+	m.Queue = nil
+	if err := m.Alert(ctx, "disk is full", "no space left"); err != nil {
+		t.Fatal(err)
+	}
+	env, _ := pubsub.NewEnvelope(pubsub.EventDatasetTransferred, "datamgmt", map[string]any{"did": "/a/b/c", "email": []string{"bob@example.com"}})
+	handler := m.EventHandler(pubsub.EventDatasetTransferred, TemplateTransferCompleted, nil)
+	if err := handler(ctx, env); err != nil {
+		t.Fatal(err)
+	}
+	other, _ := pubsub.NewEnvelope(pubsub.EventRecordInserted, "datamgmt", map[string]any{"email": "bob@example.com"})
+	handler(ctx, other)
+	msgs = sender.Messages()
+	if len(msgs) != 3 {
+		t.Fatalf("unexpected messages %+v", msgs)
+	}
+	if msgs[1].To[0] != "admin@example.com" || msgs[1].Subject != "[FOXDEN alert] disk is full" {
+		t.Errorf("invalid alert %+v", msgs[1])
+	}
+	if msgs[2].To[0] != "bob@example.com" || !strings.Contains(msgs[2].Subject, "/a/b/c") {
+		t.Errorf("invalid transfer notice %+v", msgs[2])
+	}
+}
+
+// TestNotificationPreferences
+func TestNotificationPreferences(t *testing.T) {
+	ctx := context.Background()
+	sender := &MemorySender{}
+	m, err := NewMailer(srvConfig.SMTP{From: "foxden@example.com"}, sender, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.NotifyUser(ctx, "alice", pubsub.EventDatasetTransferred, TemplateTransferCompleted, nil); err == nil {
+		t.Error("user is notified without users manager")
+	}
+	m.Users = users.NewManager(storage.NewMemoryStore())
+	m.Users.BcryptCost = 4
+	for _, name := range []string{"alice", "bob"} {
+		if _, err := m.Users.Create(ctx, users.User{Name: name, Email: name + "@example.com"}, "secret-password"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	prefs := users.Notifications{pubsub.EventDatasetTransferred: {users.ChannelSSE}}
+	if _, err := m.Users.SetNotifications(ctx, "alice", prefs); err != nil {
+		t.Fatal(err)
+	}
+	data := map[string]any{"Data": map[string]any{"did": "/a/b/c"}}
+	if err := m.NotifyUser(ctx, "alice", pubsub.EventDatasetTransferred, TemplateTransferCompleted, data); err != nil {
+		t.Fatal(err)
+	}
+	to := []string{"alice@example.com", "bob@example.com", "carol@example.com"}
+	env, _ := pubsub.NewEnvelope(pubsub.EventDatasetTransferred, "datamgmt", map[string]any{"did": "/a/b/c"})
+	handler := m.EventHandler(pubsub.EventDatasetTransferred, TemplateTransferCompleted, func(env pubsub.Envelope, data map[string]any) []string {
+		return to
+	})
+	if err := handler(ctx, env); err != nil {
+		t.Fatal(err)
+	}
+	msgs := sender.Messages()
+	if len(msgs) != 1 || strings.Join(msgs[0].To, ",") != "bob@example.com,carol@example.com" {
+		t.Errorf("preferences are not honored, messages %+v", msgs)
+	}
+}
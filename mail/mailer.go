@@ -0,0 +1,352 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	htmlTemplate "html/template"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"strings"
+	textTemplate "text/template"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	jobs "github.com/CHESSComputing/golib/jobs"
+	pubsub "github.com/CHESSComputing/golib/pubsub"
+	users "github.com/CHESSComputing/golib/users"
+)
+
+// TaskType defines jobs task type of message deliveries
+const TaskType = "mail.send"
+
+// names of default templates
+const (
+	TemplateVerifyEmail       = users.TokenVerifyEmail
+	TemplateResetPassword     = users.TokenResetPassword
+	TemplateTransferCompleted = "transfer_completed"
+	TemplateAdminAlert        = "admin_alert"
+	TemplateSearchMatches     = "search_matches"
+)
+
+// DefaultRetryPolicy defines retry policy of message deliveries
+var DefaultRetryPolicy = jobs.RetryPolicy{
+	MaxAttempts: 5,
+	InitialWait: time.Minute,
+	MaxWait:     time.Hour,
+	Multiplier:  2,
+}
+
+//go:embed templates/*.tmpl
+var templatesFS embed.FS
+
+// template represents message template, subject and text are rendered
+// via text/template and optional HTML body via html/template
+type template struct {
+	text *textTemplate.Template
+	html *htmlTemplate.Template
+}
+
+// helper function to load templates with given pattern from file system,
+// template name is file name without extension
+func loadTemplates(fsys fs.FS, pattern string, tmpls map[string]template) error {
+	files, err := fs.Glob(fsys, pattern)
+	if err != nil {
+		return err
+	}
+	for _, fname := range files {
+		data, err := fs.ReadFile(fsys, fname)
+		if err != nil {
+			return err
+		}
+		name := strings.TrimSuffix(path.Base(fname), path.Ext(fname))
+		text, err := textTemplate.New(name).Parse(string(data))
+		if err != nil {
+			return fmt.Errorf("unable to parse template %s, error %v", fname, err)
+		}
+		if text.Lookup("subject") == nil || text.Lookup("text") == nil {
+			return fmt.Errorf("template %s should define subject and text", fname)
+		}
+		tmpl := template{text: text}
+		if text.Lookup("html") != nil {
+			tmpl.html, err = htmlTemplate.New(name).Parse(string(data))
+			if err != nil {
+				return fmt.Errorf("unable to parse template %s, error %v", fname, err)
+			}
+		}
+		tmpls[name] = tmpl
+	}
+	return nil
+}
+
+// DefaultMailer represents mailer of the service, it should be initialized
+// via Init function
+var DefaultMailer *Mailer
+
+// Mailer renders messages from templates and sends them via jobs queue
+type Mailer struct {
+	Sender      Sender      // message delivery
+	From        string      // sender address
+	BaseURL     string      // base URL of links in messages
+	AdminEmails []string    // recipients of admin alerts
+	Queue       *jobs.Queue // queue of deliveries, messages are sent synchronously if nil
+
+	// Users provides notification preferences of recipients, email
+	// channel of event types is honored if it is set
+	Users *users.Manager
+
+	templates map[string]template
+}
+
+// NewMailer returns mailer of given configuration, sender and queue. Queue
+// handler of message deliveries is registered with retry policy of
+// configuration.
+func NewMailer(cfg srvConfig.SMTP, sender Sender, queue *jobs.Queue) (*Mailer, error) {
+	m := &Mailer{
+		Sender:      sender,
+		From:        cfg.From,
+		BaseURL:     strings.TrimSuffix(cfg.BaseURL, "/"),
+		AdminEmails: cfg.AdminEmails,
+		Queue:       queue,
+		templates:   make(map[string]template),
+	}
+	if m.From == "" {
+		return nil, errors.New("sender address is not configured")
+	}
+	if err := loadTemplates(templatesFS, "templates/*.tmpl", m.templates); err != nil {
+		return nil, err
+	}
+	if cfg.TemplateDir != "" {
+		if err := loadTemplates(os.DirFS(cfg.TemplateDir), "*.tmpl", m.templates); err != nil {
+			return nil, err
+		}
+	}
+	if queue != nil {
+		policy := DefaultRetryPolicy
+		if cfg.MaxAttempts > 0 {
+			policy.MaxAttempts = cfg.MaxAttempts
+		}
+		queue.Register(TaskType, m.handle, policy)
+	}
+	return m, nil
+}
+
+// Init initializes default mailer with SMTP sender from server
+// configuration, messages are delivered via given queue
+func Init(queue *jobs.Queue) error {
+	if srvConfig.Config == nil {
+		return errors.New("server configuration is not initialized")
+	}
+	cfg := srvConfig.Config.SMTP
+	m, err := NewMailer(cfg, NewSMTPSender(cfg), queue)
+	if err != nil {
+		log.Println("ERROR: unable to initialize mailer", err)
+		return err
+	}
+	DefaultMailer = m
+	return nil
+}
+
+// helper function to execute text template
+func executeText(tmpl *textTemplate.Template, name string, data any) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&buf, name, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// Render renders message of given template, data is extended with BaseURL
+// of the mailer. Returned message has no recipients.
+func (m *Mailer) Render(name string, data map[string]any) (Message, error) {
+	var msg Message
+	tmpl, ok := m.templates[name]
+	if !ok {
+		return msg, fmt.Errorf("unknown template '%s'", name)
+	}
+	vars := map[string]any{"BaseURL": m.BaseURL}
+	for k, v := range data {
+		vars[k] = v
+	}
+	subject, err := executeText(tmpl.text, "subject", vars)
+	if err != nil {
+		return msg, fmt.Errorf("unable to render subject of %s, error %v", name, err)
+	}
+	msg.Subject = strings.Join(strings.Fields(subject), " ")
+	text, err := executeText(tmpl.text, "text", vars)
+	if err != nil {
+		return msg, fmt.Errorf("unable to render text of %s, error %v", name, err)
+	}
+	msg.Text = strings.TrimSpace(text) + "\n"
+	if tmpl.html != nil {
+		var buf bytes.Buffer
+		if err := tmpl.html.ExecuteTemplate(&buf, "html", vars); err != nil {
+			return msg, fmt.Errorf("unable to render html of %s, error %v", name, err)
+		}
+		msg.HTML = strings.TrimSpace(buf.String()) + "\n"
+	}
+	return msg, nil
+}
+
+// Send sends message synchronously
+func (m *Mailer) Send(ctx context.Context, msg Message) error {
+	return m.Sender.Send(ctx, m.From, msg)
+}
+
+// helper function to deliver message of the queue task
+func (m *Mailer) handle(ctx context.Context, task *jobs.Task) error {
+	var msg Message
+	if err := task.Decode(&msg); err != nil {
+		return err
+	}
+	if err := m.Send(ctx, msg); err != nil {
+		log.Printf("WARNING: unable to send message %q to %v, attempt %d, error %v",
+			msg.Subject, msg.To, task.Attempts, err)
+		return err
+	}
+	return nil
+}
+
+// Enqueue schedules delivery of the message via jobs queue, failed
+// deliveries are retried according to retry policy of the queue. Message is
+// sent synchronously if mailer has no queue.
+func (m *Mailer) Enqueue(ctx context.Context, msg Message) error {
+	if err := msg.Validate(); err != nil {
+		return err
+	}
+	if m.Queue == nil {
+		return m.Send(ctx, msg)
+	}
+	_, err := m.Queue.Enqueue(ctx, TaskType, msg, 0)
+	return err
+}
+
+// Notify renders message of given template and schedules its delivery to
+// given recipients
+func (m *Mailer) Notify(ctx context.Context, name string, to []string, data map[string]any) error {
+	msg, err := m.Render(name, data)
+	if err != nil {
+		log.Println("ERROR: unable to render message", err)
+		return err
+	}
+	msg.To = to
+	return m.Enqueue(ctx, msg)
+}
+
+// NotifyUser renders message of given template and schedules its delivery
+// to email of the user, message is skipped if the user disabled email
+// notifications about event type
+func (m *Mailer) NotifyUser(ctx context.Context, name, event, tmpl string, data map[string]any) error {
+	if m.Users == nil {
+		return errors.New("users manager is not configured")
+	}
+	user, err := m.Users.Get(ctx, name)
+	if err != nil {
+		return err
+	}
+	if user.Email == "" {
+		return fmt.Errorf("user %s has no email", user.Name)
+	}
+	if !user.Notifications.Notifies(event, users.ChannelEmail) {
+		return nil
+	}
+	return m.Notify(ctx, tmpl, []string{user.Email}, data)
+}
+
+// helper function to remove recipients who disabled email notifications
+// about event type, recipients without local account are kept
+func (m *Mailer) subscribed(ctx context.Context, event string, to []string) []string {
+	if m.Users == nil {
+		return to
+	}
+	var out []string
+	for _, email := range to {
+		user, err := m.Users.GetByEmail(ctx, email)
+		if err == nil && !user.Notifications.Notifies(event, users.ChannelEmail) {
+			continue
+		}
+		if err != nil && !errors.Is(err, users.ErrUserNotFound) {
+			log.Printf("ERROR: unable to get notification preferences of %s, error %v", email, err)
+		}
+		out = append(out, email)
+	}
+	return out
+}
+
+// Alert sends alert with given subject and text to administrators, alerts
+// are skipped if no admin emails are configured
+func (m *Mailer) Alert(ctx context.Context, subject, text string) error {
+	if len(m.AdminEmails) == 0 {
+		log.Printf("WARNING: no admin emails to send alert %q", subject)
+		return nil
+	}
+	host, _ := os.Hostname()
+	data := map[string]any{
+		"Subject": subject,
+		"Text":    text,
+		"Time":    time.Now(),
+		"Source":  strings.TrimSpace(pubsub.Source + " " + host),
+	}
+	return m.Notify(ctx, TemplateAdminAlert, m.AdminEmails, data)
+}
+
+// UserNotifier returns notification function of users manager which sends
+// verification and password reset tokens to user email, e.g.
+// manager.Notify = mailer.UserNotifier()
+func (m *Mailer) UserNotifier() func(ctx context.Context, kind string, user users.User, token string, expires time.Time) error {
+	return func(ctx context.Context, kind string, user users.User, token string, expires time.Time) error {
+		if user.Email == "" {
+			return fmt.Errorf("user %s has no email", user.Name)
+		}
+		data := map[string]any{"User": user, "Token": token, "Expires": expires}
+		return m.Notify(ctx, kind, []string{user.Email}, data)
+	}
+}
+
+// helper function to provide recipients from email field of event data
+func eventRecipients(env pubsub.Envelope, data map[string]any) []string {
+	var out []string
+	switch v := data["email"].(type) {
+	case string:
+		out = append(out, v)
+	case []any:
+		for _, e := range v {
+			if s, ok := e.(string); ok {
+				out = append(out, s)
+			}
+		}
+	}
+	return out
+}
+
+// EventHandler returns pubsub handler which notifies recipients about events
+// of given type using given template, other events are ignored. Template
+// gets Event envelope and decoded event Data. Recipients are provided by
+// given function or by email field of event data if it is nil, recipients
+// who disabled email notifications about event type are skipped, e.g.
+// pubsub.Subscribe(subject, "mail", mailer.EventHandler(pubsub.EventDatasetTransferred, mail.TemplateTransferCompleted, nil))
+func (m *Mailer) EventHandler(etype, name string, recipients func(env pubsub.Envelope, data map[string]any) []string) pubsub.Handler {
+	if recipients == nil {
+		recipients = eventRecipients
+	}
+	return func(ctx context.Context, env pubsub.Envelope) error {
+		if env.Type != etype {
+			return nil
+		}
+		var data map[string]any
+		if err := env.Decode(&data); err != nil {
+			log.Printf("ERROR: unable to decode event %s, error %v", env.ID, err)
+			return err
+		}
+		to := m.subscribed(ctx, env.Type, recipients(env, data))
+		if len(to) == 0 {
+			log.Printf("WARNING: no recipients of event %s of type %s", env.ID, env.Type)
+			return nil
+		}
+		return m.Notify(ctx, name, to, map[string]any{"Event": env, "Data": data})
+	}
+}
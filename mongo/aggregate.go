@@ -0,0 +1,359 @@
+package mongo
+
+// aggregate module provides builders of common aggregation pipelines used
+// by statistics endpoints, e.g. group-by counts, date histograms, distinct
+// values with counts and faceted search. Number of groups returned by every
+// pipeline is limited by MaxGroups to protect the server against unbounded
+// group cardinality.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	bson "go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MaxGroups defines maximum number of groups returned by aggregations
+var MaxGroups = 1000
+
+// AggregateTimeout defines maximum execution time of aggregations
+var AggregateTimeout = 30 * time.Second
+
+// date formats of histogram intervals
+var histogramFormats = map[string]string{
+	"hour":  "%Y-%m-%dT%H",
+	"day":   "%Y-%m-%d",
+	"week":  "%G-W%V",
+	"month": "%Y-%m",
+	"year":  "%Y",
+}
+
+// Bucket represents single group of aggregation results
+type Bucket struct {
+	Key   any   `json:"key"`
+	Count int64 `json:"count"`
+}
+
+// Buckets represents groups of aggregation results of given field,
+// truncated flag is set if number of groups exceeds the limit
+type Buckets struct {
+	Field     string   `json:"field"`
+	Buckets   []Bucket `json:"buckets"`
+	Truncated bool     `json:"truncated"`
+}
+
+// helper function to validate field name, it prevents use of aggregation
+// expressions in place of field names
+func checkField(field string) error {
+	if field == "" || strings.HasPrefix(field, "$") || strings.ContainsAny(field, "{}") {
+		return fmt.Errorf("invalid aggregation field '%s'", field)
+	}
+	return nil
+}
+
+// helper function to provide group limit within MaxGroups
+func groupLimit(limit int) int {
+	if limit <= 0 || limit > MaxGroups {
+		return MaxGroups
+	}
+	return limit
+}
+
+// helper function to build match stage
+func matchStage(spec bson.M) bson.D {
+	if spec == nil {
+		spec = bson.M{}
+	}
+	return bson.D{{Key: "$match", Value: spec}}
+}
+
+// helper function to build stages which count groups of given key
+// expression, one extra group is requested to detect truncation
+func countStages(key any, sortKey string, sortOrder, limit int) []bson.D {
+	return []bson.D{
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: key},
+			{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: sortKey, Value: sortOrder}, {Key: "_id", Value: 1}}}},
+		{{Key: "$limit", Value: groupLimit(limit) + 1}},
+	}
+}
+
+// GroupCountPipeline provides pipeline which counts records matching the
+// spec grouped by values of given field, groups are sorted by count
+func GroupCountPipeline(field string, spec bson.M, limit int) (mongo.Pipeline, error) {
+	if err := checkField(field); err != nil {
+		return nil, err
+	}
+	pipeline := mongo.Pipeline{matchStage(spec)}
+	pipeline = append(pipeline, countStages("$"+field, "count", -1, limit)...)
+	return pipeline, nil
+}
+
+// DistinctCountPipeline provides pipeline which counts distinct values of
+// given field, values of list fields are counted individually
+func DistinctCountPipeline(field string, spec bson.M, limit int) (mongo.Pipeline, error) {
+	if err := checkField(field); err != nil {
+		return nil, err
+	}
+	pipeline := mongo.Pipeline{
+		matchStage(spec),
+		{{Key: "$unwind", Value: "$" + field}},
+	}
+	pipeline = append(pipeline, countStages("$"+field, "count", -1, limit)...)
+	return pipeline, nil
+}
+
+// DateHistogramPipeline provides pipeline which counts records per time
+// interval (hour, day, week, month or year) of given date field, groups
+// are sorted by time
+func DateHistogramPipeline(field, interval string, spec bson.M, limit int) (mongo.Pipeline, error) {
+	if err := checkField(field); err != nil {
+		return nil, err
+	}
+	format, ok := histogramFormats[interval]
+	if !ok {
+		return nil, fmt.Errorf("unsupported histogram interval '%s'", interval)
+	}
+	key := bson.D{{Key: "$dateToString", Value: bson.D{
+		{Key: "format", Value: format},
+		{Key: "date", Value: "$" + field},
+	}}}
+	// records without date field would be grouped under null key
+	match := bson.M{}
+	for k, v := range spec {
+		match[k] = v
+	}
+	if _, ok := match[field]; !ok {
+		match[field] = bson.M{"$type": "date"}
+	}
+	pipeline := mongo.Pipeline{matchStage(match)}
+	pipeline = append(pipeline, countStages(key, "_id", 1, limit)...)
+	return pipeline, nil
+}
+
+// helper function to provide facet name of the field, facet names can not
+// contain dots
+func facetName(field string) string {
+	return strings.ReplaceAll(field, ".", "_")
+}
+
+// FacetPipeline provides pipeline which counts distinct values of given
+// fields for records matching the spec in a single query
+func FacetPipeline(fields []string, spec bson.M, limit int) (mongo.Pipeline, error) {
+	var facets []Facet
+	for _, field := range fields {
+		facets = append(facets, Facet{Field: field})
+	}
+	return BrowsePipeline(facets, spec, limit)
+}
+
+// Facet represents facet of faceted search, facet with interval counts
+// records per time interval of date field instead of distinct values
+type Facet struct {
+	Field    string `json:"field"`
+	Interval string `json:"interval,omitempty"`
+}
+
+// helper function to build facet stages which count records per time
+// interval of date field, the field may hold dates or unix timestamps in
+// seconds
+func dateFacetStages(field, interval string, limit int) ([]bson.D, error) {
+	format, ok := histogramFormats[interval]
+	if !ok {
+		return nil, fmt.Errorf("unsupported histogram interval '%s'", interval)
+	}
+	date := bson.D{{Key: "$cond", Value: bson.A{
+		bson.D{{Key: "$eq", Value: bson.A{bson.D{{Key: "$type", Value: "$" + field}}, "date"}}},
+		"$" + field,
+		bson.D{{Key: "$toDate", Value: bson.D{{Key: "$multiply", Value: bson.A{"$" + field, 1000}}}}},
+	}}}
+	key := bson.D{{Key: "$dateToString", Value: bson.D{
+		{Key: "format", Value: format},
+		{Key: "date", Value: date},
+	}}}
+	stages := []bson.D{matchStage(bson.M{field: bson.M{"$type": bson.A{"date", "number"}}})}
+	return append(stages, countStages(key, "_id", 1, limit)...), nil
+}
+
+// BrowsePipeline provides pipeline which counts distinct values of facet
+// fields and records per time interval of date facets for records matching
+// the spec in a single query
+func BrowsePipeline(facets []Facet, spec bson.M, limit int) (mongo.Pipeline, error) {
+	if len(facets) == 0 {
+		return nil, errors.New("no facet fields")
+	}
+	out := bson.D{}
+	for _, facet := range facets {
+		if err := checkField(facet.Field); err != nil {
+			return nil, err
+		}
+		var stages []bson.D
+		if facet.Interval != "" {
+			var err error
+			stages, err = dateFacetStages(facet.Field, facet.Interval, limit)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			stages = []bson.D{{{Key: "$unwind", Value: "$" + facet.Field}}}
+			stages = append(stages, countStages("$"+facet.Field, "count", -1, limit)...)
+		}
+		out = append(out, bson.E{Key: facetName(facet.Field), Value: stages})
+	}
+	return mongo.Pipeline{matchStage(spec), {{Key: "$facet", Value: out}}}, nil
+}
+
+// Aggregate executes aggregation pipeline and returns its results
+func Aggregate(dbname, collname string, pipeline mongo.Pipeline) ([]map[string]any, error) {
+	client := Mongo.Connect()
+	ctx, cancel := context.WithTimeout(context.Background(), AggregateTimeout)
+	defer cancel()
+	c := client.Database(dbname).Collection(collname)
+	opts := options.Aggregate().SetAllowDiskUse(true).SetMaxTime(AggregateTimeout)
+	var out []map[string]any
+	err := Mongo.Do(ctx, func(ctx context.Context) error {
+		cur, err := c.Aggregate(ctx, pipeline, opts)
+		if err != nil {
+			return err
+		}
+		return cur.All(ctx, &out)
+	})
+	if err != nil {
+		log.Printf("ERROR: unable to aggregate records of %s.%s, error %v", dbname, collname, err)
+		return nil, err
+	}
+	return out, nil
+}
+
+// helper function to convert count value of aggregation results
+func countValue(val any) int64 {
+	switch v := val.(type) {
+	case int32:
+		return int64(v)
+	case int64:
+		return v
+	case int:
+		return int64(v)
+	case float64:
+		return int64(v)
+	}
+	return 0
+}
+
+// ToBuckets converts aggregation results into buckets of given field, the
+// results beyond the limit set truncated flag
+func ToBuckets(field string, records []map[string]any, limit int) Buckets {
+	limit = groupLimit(limit)
+	out := Buckets{Field: field, Buckets: []Bucket{}}
+	for _, rec := range records {
+		if len(out.Buckets) == limit {
+			out.Truncated = true
+			break
+		}
+		out.Buckets = append(out.Buckets, Bucket{Key: rec["_id"], Count: countValue(rec["count"])})
+	}
+	return out
+}
+
+// helper function to execute pipeline and convert its results into buckets
+func buckets(dbname, collname, field string, pipeline mongo.Pipeline, limit int) (Buckets, error) {
+	records, err := Aggregate(dbname, collname, pipeline)
+	if err != nil {
+		return Buckets{Field: field}, err
+	}
+	return ToBuckets(field, records, limit), nil
+}
+
+// GroupCount counts records grouped by values of given field
+func GroupCount(dbname, collname, field string, spec bson.M, limit int) (Buckets, error) {
+	pipeline, err := GroupCountPipeline(field, spec, limit)
+	if err != nil {
+		return Buckets{Field: field}, err
+	}
+	return buckets(dbname, collname, field, pipeline, limit)
+}
+
+// DistinctCount counts distinct values of given field
+func DistinctCount(dbname, collname, field string, spec bson.M, limit int) (Buckets, error) {
+	pipeline, err := DistinctCountPipeline(field, spec, limit)
+	if err != nil {
+		return Buckets{Field: field}, err
+	}
+	return buckets(dbname, collname, field, pipeline, limit)
+}
+
+// DateHistogram counts records per time interval of given date field
+func DateHistogram(dbname, collname, field, interval string, spec bson.M, limit int) (Buckets, error) {
+	pipeline, err := DateHistogramPipeline(field, interval, spec, limit)
+	if err != nil {
+		return Buckets{Field: field}, err
+	}
+	return buckets(dbname, collname, field, pipeline, limit)
+}
+
+// Facets counts distinct values of given fields for records matching the
+// spec, e.g. to show filters of faceted search
+func Facets(dbname, collname string, fields []string, spec bson.M, limit int) (map[string]Buckets, error) {
+	var facets []Facet
+	for _, field := range fields {
+		facets = append(facets, Facet{Field: field})
+	}
+	return Browse(dbname, collname, facets, spec, limit)
+}
+
+// Browse counts distinct values of facet fields and records per time
+// interval of date facets for records matching the spec, e.g. to show
+// filter sidebar of faceted browsing
+func Browse(dbname, collname string, facets []Facet, spec bson.M, limit int) (map[string]Buckets, error) {
+	pipeline, err := BrowsePipeline(facets, spec, limit)
+	if err != nil {
+		return nil, err
+	}
+	records, err := Aggregate(dbname, collname, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]Buckets)
+	for _, facet := range facets {
+		var groups []map[string]any
+		if len(records) > 0 {
+			groups = facetGroups(records[0][facetName(facet.Field)])
+		}
+		out[facet.Field] = ToBuckets(facet.Field, groups, limit)
+	}
+	return out, nil
+}
+
+// helper function to convert facet results into list of records
+func facetGroups(val any) []map[string]any {
+	var out []map[string]any
+	switch v := val.(type) {
+	case bson.A:
+		for _, e := range v {
+			if rec, ok := e.(map[string]any); ok {
+				out = append(out, rec)
+			} else if rec, ok := e.(bson.M); ok {
+				out = append(out, rec)
+			} else if doc, ok := e.(bson.D); ok {
+				rec := make(map[string]any, len(doc))
+				for _, elem := range doc {
+					rec[elem.Key] = elem.Value
+				}
+				out = append(out, rec)
+			}
+		}
+	case []any:
+		return facetGroups(bson.A(v))
+	case []map[string]any:
+		out = v
+	}
+	return out
+}
@@ -0,0 +1,92 @@
+package mongo
+
+import (
+	"testing"
+
+	bson "go.mongodb.org/mongo-driver/bson"
+)
+
+// TestGroupCountPipeline
+func TestGroupCountPipeline(t *testing.T) {
+	pipeline, err := GroupCountPipeline("beamline", bson.M{"cycle": "2024-1"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pipeline) != 4 || pipeline[0][0].Key != "$match" || pipeline[1][0].Key != "$group" {
+		t.Fatalf("invalid pipeline %+v", pipeline)
+	}
+	if limit := pipeline[3][0].Value; limit != MaxGroups+1 {
+		t.Error("invalid group limit", limit)
+	}
+	if _, err := GroupCountPipeline("$where", nil, 10); err == nil {
+		t.Error("expression is accepted as field name")
+	}
+}
+
+// TestDateHistogramPipeline
+func TestDateHistogramPipeline(t *testing.T) {
+	pipeline, err := DateHistogramPipeline("date", "month", nil, 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	match := pipeline[0][0].Value.(bson.M)
+	if _, ok := match["date"]; !ok {
+		t.Error("histogram should match date records only", match)
+	}
+	if limit := pipeline[3][0].Value; limit != 13 {
+		t.Error("invalid group limit", limit)
+	}
+	if _, err := DateHistogramPipeline("date", "century", nil, 12); err == nil {
+		t.Error("invalid interval is accepted")
+	}
+}
+
+// TestFacetPipeline
+func TestFacetPipeline(t *testing.T) {
+	pipeline, err := FacetPipeline([]string{"beamline", "sample.name"}, nil, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	facets := pipeline[1][0].Value.(bson.D)
+	if len(facets) != 2 || facets[1].Key != "sample_name" {
+		t.Errorf("invalid facets %+v", facets)
+	}
+}
+
+// TestBrowsePipeline
+func TestBrowsePipeline(t *testing.T) {
+	facets := []Facet{{Field: "beamline"}, {Field: "Date", Interval: "year"}}
+	pipeline, err := BrowsePipeline(facets, bson.M{"cycle": "2024-1"}, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stages := pipeline[1][0].Value.(bson.D)
+	if len(stages) != 2 || stages[1].Key != "Date" {
+		t.Fatalf("invalid facets %+v", stages)
+	}
+	// date facet matches dates and timestamps only and sorts groups by time
+	date := stages[1].Value.([]bson.D)
+	if date[0][0].Key != "$match" || date[1][0].Key != "$group" || date[2][0].Value.(bson.D)[0].Key != "_id" {
+		t.Errorf("invalid date facet %+v", date)
+	}
+	if _, err := BrowsePipeline([]Facet{{Field: "Date", Interval: "century"}}, nil, 5); err == nil {
+		t.Error("invalid interval is accepted")
+	}
+}
+
+// TestToBuckets
+func TestToBuckets(t *testing.T) {
+	records := []map[string]any{
+		{"_id": "3a", "count": int32(10)},
+		{"_id": "1b", "count": int64(5)},
+		{"_id": "4b", "count": int32(1)},
+	}
+	out := ToBuckets("beamline", records, 2)
+	if len(out.Buckets) != 2 || !out.Truncated || out.Buckets[1].Count != 5 {
+		t.Errorf("invalid buckets %+v", out)
+	}
+	out = ToBuckets("beamline", records, 3)
+	if len(out.Buckets) != 3 || out.Truncated {
+		t.Errorf("invalid buckets %+v", out)
+	}
+}
@@ -0,0 +1,108 @@
+package mongo
+
+// concerns module provides read preferences, read and write concerns given
+// by their names, e.g. in configuration, and multi-document transactions
+// of replica sets and sharded clusters
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	bson "go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/description"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+// ReadPreference returns read preference of given mode, e.g. primary,
+// primaryPreferred, secondary, secondaryPreferred or nearest, empty mode
+// returns nil, i.e. preference of the client
+func ReadPreference(mode string) (*readpref.ReadPref, error) {
+	if mode == "" {
+		return nil, nil
+	}
+	m, err := readpref.ModeFromString(mode)
+	if err != nil {
+		return nil, err
+	}
+	return readpref.New(m)
+}
+
+// ReadConcern returns read concern of given level, e.g. local, majority,
+// available, linearizable or snapshot, empty level returns nil, i.e.
+// concern of the client
+func ReadConcern(level string) (*readconcern.ReadConcern, error) {
+	level = strings.ToLower(level)
+	switch level {
+	case "":
+		return nil, nil
+	case "local", "majority", "available", "linearizable", "snapshot":
+		return &readconcern.ReadConcern{Level: level}, nil
+	}
+	return nil, fmt.Errorf("unknown read concern %s", level)
+}
+
+// WriteConcern returns write concern of given value, e.g. majority or
+// number of acknowledging servers, empty value returns nil, i.e. concern
+// of the client
+func WriteConcern(w string) (*writeconcern.WriteConcern, error) {
+	if w == "" {
+		return nil, nil
+	}
+	if strings.EqualFold(w, "majority") {
+		return writeconcern.Majority(), nil
+	}
+	n, err := strconv.Atoi(w)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("unknown write concern %s", w)
+	}
+	return &writeconcern.WriteConcern{W: n}, nil
+}
+
+// helper function to report whether server supports transactions
+func transactional(kind description.ServerKind) bool {
+	switch kind {
+	case description.RSPrimary, description.RSSecondary, description.Mongos, description.LoadBalancer:
+		return true
+	}
+	return false
+}
+
+// Transactions reports whether MongoDB deployment supports multi-document
+// transactions, i.e. it is replica set or sharded cluster. Topology of
+// supervised connection is used, otherwise server is asked.
+func (m *Connection) Transactions(ctx context.Context) (bool, error) {
+	if m.Supervisor != nil {
+		if status := m.Supervisor.Status(); status.State != StateUnknown {
+			return status.Transactions, nil
+		}
+	}
+	var hello bson.M
+	cmd := bson.D{{Key: "hello", Value: 1}}
+	if err := m.Connect().Database("admin").RunCommand(ctx, cmd).Decode(&hello); err != nil {
+		return false, err
+	}
+	_, replicaSet := hello["setName"]
+	return replicaSet || hello["msg"] == "isdbgrid", nil
+}
+
+// WithTransactionOptions executes given function within MongoDB
+// transaction of given options, e.g. read and write concerns, see
+// WithTransaction. Transactions are rejected during extended outages.
+func WithTransactionOptions(ctx context.Context, opts *options.TransactionOptions, fn func(ctx context.Context) error) error {
+	if err := Mongo.Check(); err != nil {
+		return err
+	}
+	client := Mongo.Connect()
+	return client.UseSession(ctx, func(sctx mongo.SessionContext) error {
+		_, err := sctx.WithTransaction(sctx, func(tctx mongo.SessionContext) (interface{}, error) {
+			return nil, fn(tctx)
+		}, opts)
+		return err
+	})
+}
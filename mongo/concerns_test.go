@@ -0,0 +1,38 @@
+package mongo
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// TestConcerns
+func TestConcerns(t *testing.T) {
+	if rp, err := ReadPreference("secondaryPreferred"); err != nil || rp.Mode() != readpref.SecondaryPreferredMode {
+		t.Errorf("wrong read preference %v, error %v", rp, err)
+	}
+	if rc, err := ReadConcern("Majority"); err != nil || rc.Level != "majority" {
+		t.Errorf("wrong read concern %v, error %v", rc, err)
+	}
+	if wc, err := WriteConcern("2"); err != nil || wc.W != 2 {
+		t.Errorf("wrong write concern %v, error %v", wc, err)
+	}
+	if wc, err := WriteConcern("majority"); err != nil || wc.W != "majority" {
+		t.Errorf("wrong write concern %v, error %v", wc, err)
+	}
+	rp, _ := ReadPreference("")
+	rc, _ := ReadConcern("")
+	wc, _ := WriteConcern("")
+	if rp != nil || rc != nil || wc != nil {
+		t.Errorf("empty values should provide client defaults, got %v %v %v", rp, rc, wc)
+	}
+	if _, err := ReadPreference("fastest"); err == nil {
+		t.Error("unknown read preference is accepted")
+	}
+	if _, err := ReadConcern("eventual"); err == nil {
+		t.Error("unknown read concern is accepted")
+	}
+	if _, err := WriteConcern("all"); err == nil {
+		t.Error("unknown write concern is accepted")
+	}
+}
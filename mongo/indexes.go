@@ -0,0 +1,259 @@
+package mongo
+
+// indexes module provides declarative specification of MongoDB indexes of
+// collections, EnsureIndexes creates missing indexes and reports divergent
+// ones, e.g. indexes with the same name and different keys or options.
+// Divergent indexes are not modified since rebuild of large collections
+// should be planned by administrators.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	bson "go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// IndexSpec represents specification of MongoDB index, keys are field
+// names where fields prefixed with minus sign are indexed in descending
+// order and suffix defines index type, e.g. did, -date or loc:2dsphere
+type IndexSpec struct {
+	Name   string        `json:"name,omitempty"`
+	Keys   []string      `json:"keys"`
+	Unique bool          `json:"unique,omitempty"`
+	Sparse bool          `json:"sparse,omitempty"`
+	TTL    time.Duration `json:"ttl,omitempty"` // expiration of documents of TTL index
+}
+
+// IndexReport represents result of index check of collection
+type IndexReport struct {
+	DBName     string   `json:"db"`
+	Collection string   `json:"collection"`
+	Missing    []string `json:"missing,omitempty"`   // specified indexes which do not exist
+	Created    []string `json:"created,omitempty"`   // created indexes
+	Divergent  []string `json:"divergent,omitempty"` // existing indexes which differ from specification
+	Unknown    []string `json:"unknown,omitempty"`   // existing indexes which are not specified
+}
+
+// OK reports whether all specified indexes exist as specified
+func (r IndexReport) OK() bool {
+	return len(r.Missing) == 0 && len(r.Divergent) == 0
+}
+
+// IndexSpecs returns index specifications of collections of given
+// configuration
+func IndexSpecs(cfg srvConfig.MongoIndexes) map[string][]IndexSpec {
+	out := make(map[string][]IndexSpec)
+	for coll, indexes := range cfg.Collections {
+		for _, idx := range indexes {
+			out[coll] = append(out[coll], IndexSpec{
+				Name:   idx.Name,
+				Keys:   idx.Keys,
+				Unique: idx.Unique,
+				Sparse: idx.Sparse,
+				TTL:    idx.TTL,
+			})
+		}
+	}
+	return out
+}
+
+// helper function to split index key into field and its index value
+func indexKey(key string) (string, any) {
+	if field, kind, ok := strings.Cut(key, ":"); ok {
+		return field, kind
+	}
+	if field, ok := strings.CutPrefix(key, "-"); ok {
+		return field, -1
+	}
+	return key, 1
+}
+
+// IndexName returns name of the index, MongoDB default name is generated
+// from keys if name is not specified, e.g. did_1_date_-1
+func (s IndexSpec) IndexName() string {
+	if s.Name != "" {
+		return s.Name
+	}
+	var parts []string
+	for _, key := range s.Keys {
+		field, val := indexKey(key)
+		parts = append(parts, fmt.Sprintf("%s_%v", field, val))
+	}
+	return strings.Join(parts, "_")
+}
+
+// helper function to provide MongoDB index model of specification
+func (s IndexSpec) model() mongo.IndexModel {
+	var keys bson.D
+	for _, key := range s.Keys {
+		field, val := indexKey(key)
+		keys = append(keys, bson.E{Key: field, Value: val})
+	}
+	opts := options.Index().SetName(s.IndexName())
+	if s.Unique {
+		opts.SetUnique(true)
+	}
+	if s.Sparse {
+		opts.SetSparse(true)
+	}
+	if s.TTL > 0 {
+		opts.SetExpireAfterSeconds(int32(s.TTL.Seconds()))
+	}
+	return mongo.IndexModel{Keys: keys, Options: opts}
+}
+
+// indexInfo represents existing index of collection
+type indexInfo struct {
+	Name               string `bson:"name"`
+	Key                bson.D `bson:"key"`
+	Unique             bool   `bson:"unique"`
+	Sparse             bool   `bson:"sparse"`
+	ExpireAfterSeconds *int64 `bson:"expireAfterSeconds"`
+}
+
+// helper function to convert keys of existing index into keys of
+// specification
+func (i indexInfo) keys() []string {
+	var keys []string
+	for _, e := range i.Key {
+		switch v := e.Value.(type) {
+		case string:
+			keys = append(keys, e.Key+":"+v)
+		default:
+			if n, ok := number(v); ok && n < 0 {
+				keys = append(keys, "-"+e.Key)
+			} else {
+				keys = append(keys, e.Key)
+			}
+		}
+	}
+	return keys
+}
+
+// helper function to convert numeric value of index key
+func number(v any) (float64, bool) {
+	switch n := v.(type) {
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// helper function to describe differences between specified and existing
+// index
+func (s IndexSpec) diff(i indexInfo) []string {
+	var out []string
+	if keys := i.keys(); strings.Join(keys, ",") != strings.Join(s.Keys, ",") {
+		out = append(out, fmt.Sprintf("keys %v, expected %v", keys, s.Keys))
+	}
+	if i.Name != s.IndexName() {
+		out = append(out, fmt.Sprintf("name %s, expected %s", i.Name, s.IndexName()))
+	}
+	if i.Unique != s.Unique {
+		out = append(out, fmt.Sprintf("unique %v, expected %v", i.Unique, s.Unique))
+	}
+	if i.Sparse != s.Sparse {
+		out = append(out, fmt.Sprintf("sparse %v, expected %v", i.Sparse, s.Sparse))
+	}
+	var ttl time.Duration
+	if i.ExpireAfterSeconds != nil {
+		ttl = time.Duration(*i.ExpireAfterSeconds) * time.Second
+	}
+	if ttl != s.TTL.Truncate(time.Second) {
+		out = append(out, fmt.Sprintf("ttl %v, expected %v", ttl, s.TTL))
+	}
+	return out
+}
+
+// helper function to compare specified and existing indexes, existing
+// index matches specification by name or by keys
+func compareIndexes(specs []IndexSpec, existing []indexInfo, report *IndexReport) []IndexSpec {
+	var missing []IndexSpec
+	matched := make(map[string]bool)
+	for _, spec := range specs {
+		found := -1
+		for i, idx := range existing {
+			if idx.Name == spec.IndexName() {
+				found = i
+				break
+			}
+		}
+		if found < 0 {
+			for i, idx := range existing {
+				if !matched[idx.Name] && strings.Join(idx.keys(), ",") == strings.Join(spec.Keys, ",") {
+					found = i
+					break
+				}
+			}
+		}
+		if found < 0 {
+			missing = append(missing, spec)
+			report.Missing = append(report.Missing, spec.IndexName())
+			continue
+		}
+		idx := existing[found]
+		matched[idx.Name] = true
+		if diff := spec.diff(idx); len(diff) > 0 {
+			report.Divergent = append(report.Divergent, fmt.Sprintf("%s: %s", idx.Name, strings.Join(diff, ", ")))
+		}
+	}
+	for _, idx := range existing {
+		if !matched[idx.Name] && idx.Name != "_id_" {
+			report.Unknown = append(report.Unknown, idx.Name)
+		}
+	}
+	sort.Strings(report.Unknown)
+	return missing
+}
+
+// EnsureIndexes compares specified indexes of collection with existing
+// ones and creates missing indexes if create flag is set
+func EnsureIndexes(ctx context.Context, dbname, collection string, specs []IndexSpec, create bool) (IndexReport, error) {
+	report := IndexReport{DBName: dbname, Collection: collection}
+	c := Mongo.Connect().Database(dbname).Collection(collection)
+	var existing []indexInfo
+	err := Mongo.Do(ctx, func(ctx context.Context) error {
+		cur, err := c.Indexes().List(ctx)
+		if err != nil {
+			return err
+		}
+		existing = nil
+		return cur.All(ctx, &existing)
+	})
+	// listIndexes of non-existing collection fails with NamespaceNotFound
+	var serr mongo.ServerError
+	if err != nil && !(errors.As(err, &serr) && serr.HasErrorCode(26)) {
+		log.Printf("ERROR: unable to list indexes of %s.%s, error %v", dbname, collection, err)
+		return report, err
+	}
+	missing := compareIndexes(specs, existing, &report)
+	if !create || len(missing) == 0 {
+		return report, nil
+	}
+	var models []mongo.IndexModel
+	for _, spec := range missing {
+		models = append(models, spec.model())
+	}
+	err = Mongo.Do(ctx, func(ctx context.Context) error {
+		_, err := c.Indexes().CreateMany(ctx, models)
+		return err
+	})
+	if err != nil {
+		log.Printf("ERROR: unable to create indexes of %s.%s, error %v", dbname, collection, err)
+		return report, err
+	}
+	report.Created, report.Missing = report.Missing, nil
+	return report, nil
+}
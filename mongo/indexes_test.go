@@ -0,0 +1,64 @@
+package mongo
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	bson "go.mongodb.org/mongo-driver/bson"
+)
+
+// TestIndexName
+func TestIndexName(t *testing.T) {
+	tests := []struct {
+		spec   IndexSpec
+		expect string
+	}{
+		{IndexSpec{Keys: []string{"did"}}, "did_1"},
+		{IndexSpec{Keys: []string{"beamline", "-date"}}, "beamline_1_date_-1"},
+		{IndexSpec{Keys: []string{"loc:2dsphere"}}, "loc_2dsphere"},
+		{IndexSpec{Name: "expire", Keys: []string{"created"}}, "expire"},
+	}
+	for _, tt := range tests {
+		if name := tt.spec.IndexName(); name != tt.expect {
+			t.Errorf("wrong index name %s, expect %s", name, tt.expect)
+		}
+	}
+	model := IndexSpec{Keys: []string{"created"}, TTL: time.Hour}.model()
+	if *model.Options.ExpireAfterSeconds != 3600 || *model.Options.Name != "created_1" {
+		t.Errorf("wrong index model %+v", model.Options)
+	}
+}
+
+// TestCompareIndexes
+func TestCompareIndexes(t *testing.T) {
+	ttl := int64(60)
+	existing := []indexInfo{
+		{Name: "_id_", Key: bson.D{{Key: "_id", Value: int32(1)}}},
+		{Name: "did_1", Key: bson.D{{Key: "did", Value: int32(1)}}},
+		{Name: "date_idx", Key: bson.D{{Key: "beamline", Value: int32(1)}, {Key: "date", Value: -1.0}}},
+		{Name: "expire", Key: bson.D{{Key: "created", Value: int64(1)}}, ExpireAfterSeconds: &ttl},
+		{Name: "legacy", Key: bson.D{{Key: "cycle", Value: "hashed"}}},
+	}
+	cfg := srvConfig.MongoIndexes{Collections: map[string][]srvConfig.MongoIndex{
+		"meta": {
+			{Keys: []string{"did"}, Unique: true},
+			{Keys: []string{"beamline", "-date"}},
+			{Name: "expire", Keys: []string{"created"}, TTL: time.Minute},
+			{Keys: []string{"sample"}},
+		},
+	}}
+	var report IndexReport
+	missing := compareIndexes(IndexSpecs(cfg)["meta"], existing, &report)
+	if len(missing) != 1 || strings.Join(report.Missing, ",") != "sample_1" {
+		t.Errorf("wrong missing indexes %v", report.Missing)
+	}
+	if len(report.Divergent) != 2 || !strings.HasPrefix(report.Divergent[0], "did_1: unique false") ||
+		!strings.HasPrefix(report.Divergent[1], "date_idx: name date_idx, expected beamline_1_date_-1") {
+		t.Errorf("wrong divergent indexes %v", report.Divergent)
+	}
+	if strings.Join(report.Unknown, ",") != "legacy" || report.OK() {
+		t.Errorf("wrong report %+v", report)
+	}
+}
@@ -14,12 +14,14 @@ package mongo
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"html"
 	"log"
 	"strings"
 	"time"
 
+	srvConfig "github.com/CHESSComputing/golib/config"
 	bson "go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -183,13 +185,19 @@ func GetInt64Value(rec map[string]any, key string) (int64, error) {
 
 // Connection defines connection to MongoDB
 type Connection struct {
-	Client *mongo.Client
-	URI    string
+	Client     *mongo.Client
+	URI        string
+	Supervisor *Supervisor // supervisor of connection state, optional
 }
 
-// InitMongoDB initializes MongoDB connection object
+// InitMongoDB initializes MongoDB connection object supervised according
+// to MongoSupervisor configuration
 func InitMongoDB(uri string) {
-	Mongo = Connection{URI: uri}
+	var cfg srvConfig.MongoSupervisor
+	if srvConfig.Config != nil {
+		cfg = srvConfig.Config.MongoSupervisor
+	}
+	Mongo = Connection{URI: uri, Supervisor: NewSupervisor(cfg)}
 }
 
 // Connect provides connection to MongoDB
@@ -198,7 +206,15 @@ func (m *Connection) Connect() *mongo.Client {
 	if m.Client != nil {
 		return m.Client
 	}
-	client, err := mongo.NewClient(options.Client().ApplyURI(m.URI))
+	opts := options.Client().ApplyURI(m.URI)
+	if m.Supervisor != nil {
+		opts.SetServerMonitor(m.Supervisor.ServerMonitor())
+		// server selection timeout of URI takes precedence
+		if opts.ServerSelectionTimeout == nil {
+			opts.SetServerSelectionTimeout(m.Supervisor.ServerSelectionTimeout)
+		}
+	}
+	client, err := mongo.NewClient(opts)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -211,11 +227,42 @@ func (m *Connection) Connect() *mongo.Client {
 	return client
 }
 
+// Do executes operation via connection supervisor which retries transient
+// errors and rejects operations during extended outages, operation is
+// executed once if connection is not supervised. Operations of
+// transactions are not retried, the driver retries whole transactions.
+func (m *Connection) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	if m.Supervisor == nil {
+		return fn(ctx)
+	}
+	if sess := mongo.SessionFromContext(ctx); sess != nil {
+		if err := m.Supervisor.Check(); err != nil {
+			return err
+		}
+		return fn(ctx)
+	}
+	return m.Supervisor.Do(ctx, fn)
+}
+
+// Check returns ErrUnavailable if MongoDB is down longer than outage
+// timeout of connection supervisor
+func (m *Connection) Check() error {
+	if m.Supervisor == nil {
+		return nil
+	}
+	return m.Supervisor.Check()
+}
+
 // Mongo holds MongoDB connection
 var Mongo Connection
 
-// Insert records into MongoDB
+// Insert records into MongoDB, inserts are not retried but rejected
+// during extended outages
 func Insert(dbname, collname string, records []map[string]any) {
+	if err := Mongo.Check(); err != nil {
+		log.Printf("Fail to insert %d records, error %v\n", len(records), err)
+		return
+	}
 	client := Mongo.Connect()
 	ctx := context.TODO()
 	c := client.Database(dbname).Collection(collname)
@@ -239,7 +286,11 @@ func Upsert(dbname, collname, attr string, records []map[string]any) error {
 		spec := bson.M{attr: value}
 		update := bson.D{{"$set", rec}}
 		opts := options.Update().SetUpsert(true)
-		if _, err := c.UpdateOne(ctx, spec, update, opts); err != nil {
+		err := Mongo.Do(ctx, func(ctx context.Context) error {
+			_, err := c.UpdateOne(ctx, spec, update, opts)
+			return err
+		})
+		if err != nil {
 			log.Printf("Fail to insert record %v, error %v\n", rec, err)
 			return err
 		}
@@ -253,51 +304,59 @@ func Get(dbname, collname string, spec bson.M, idx, limit int) []map[string]any
 	client := Mongo.Connect()
 	ctx := context.TODO()
 	c := client.Database(dbname).Collection(collname)
-	var err error
+	opts := options.Find().SetSkip(int64(idx))
 	if limit > 0 {
-		opts := options.Find().SetSkip(int64(idx)).SetLimit(int64(limit))
-		cur, err := c.Find(ctx, spec, opts)
-		if err != nil {
-			log.Printf("ERROR: spec=%+v, error=%v", spec, err)
-		}
-		cur.All(ctx, &out)
-	} else {
-		opts := options.Find().SetSkip(int64(idx))
+		opts.SetLimit(int64(limit))
+	}
+	err := Mongo.Do(ctx, func(ctx context.Context) error {
 		cur, err := c.Find(ctx, spec, opts)
 		if err != nil {
-			log.Printf("ERROR: spec=%+v, error=%v", spec, err)
+			return err
 		}
-		cur.All(ctx, &out)
-	}
+		return cur.All(ctx, &out)
+	})
 	if err != nil {
-		log.Printf("Unable to get records, error %v\n", err)
+		log.Printf("ERROR: spec=%+v, error=%v", spec, err)
 	}
 	return out
 }
 
+// helper function to find all records of given spec
+func findAll(ctx context.Context, c *mongo.Collection, spec bson.M, out *[]map[string]any, opts ...*options.FindOptions) error {
+	return Mongo.Do(ctx, func(ctx context.Context) error {
+		cur, err := c.Find(ctx, spec, opts...)
+		if err != nil {
+			return err
+		}
+		return cur.All(ctx, out)
+	})
+}
+
 // GetSorted records from MongoDB sorted by given key
 func GetSorted(dbname, collname string, spec bson.M, skeys []string) []map[string]any {
 	out := []map[string]any{}
 	client := Mongo.Connect()
 	ctx := context.TODO()
 	c := client.Database(dbname).Collection(collname)
-	var sortSpec bson.M
+	sortSpec := bson.M{}
 	for _, s := range skeys {
 		sortSpec[s] = 1
 	}
 	opts := options.Find().SetSort(sortSpec)
-	cur, err := c.Find(ctx, spec, opts)
-	cur.All(ctx, &out)
+	err := findAll(ctx, c, spec, &out, opts)
+	if errors.Is(err, ErrUnavailable) {
+		log.Printf("Unable to find records, error %v\n", err)
+		out = append(out, ErrorRecord(fmt.Sprintf("%v", err), DBErrorName, DBError))
+		return out
+	}
 	if err != nil {
 		log.Printf("Unable to sort records, error %v\n", err)
 		// try to fetch all unsorted data
-		cur, err := c.Find(ctx, spec)
-		if err != nil {
+		if err := findAll(ctx, c, spec, &out); err != nil {
 			log.Printf("Unable to find records, error %v\n", err)
 			out = append(out, ErrorRecord(fmt.Sprintf("%v", err), DBErrorName, DBError))
 			return out
 		}
-		cur.All(ctx, &out)
 	}
 	return out
 }
@@ -316,7 +375,10 @@ func Update(dbname, collname string, spec, newdata bson.M) {
 	client := Mongo.Connect()
 	ctx := context.TODO()
 	c := client.Database(dbname).Collection(collname)
-	_, err := c.UpdateOne(ctx, spec, newdata)
+	err := Mongo.Do(ctx, func(ctx context.Context) error {
+		_, err := c.UpdateOne(ctx, spec, newdata)
+		return err
+	})
 	if err != nil {
 		log.Printf("Unable to update record, spec %v, data %v, error %v\n", spec, newdata, err)
 	}
@@ -327,7 +389,12 @@ func Count(dbname, collname string, spec bson.M) int {
 	client := Mongo.Connect()
 	ctx := context.TODO()
 	c := client.Database(dbname).Collection(collname)
-	nrec, err := c.CountDocuments(ctx, spec)
+	var nrec int64
+	err := Mongo.Do(ctx, func(ctx context.Context) error {
+		var err error
+		nrec, err = c.CountDocuments(ctx, spec)
+		return err
+	})
 	if err != nil {
 		log.Printf("Unable to count records, spec %v, error %v\n", spec, err)
 	}
@@ -339,8 +406,19 @@ func Remove(dbname, collname string, spec bson.M) {
 	client := Mongo.Connect()
 	ctx := context.TODO()
 	c := client.Database(dbname).Collection(collname)
-	_, err := c.DeleteMany(ctx, spec)
+	err := Mongo.Do(ctx, func(ctx context.Context) error {
+		_, err := c.DeleteMany(ctx, spec)
+		return err
+	})
 	if err != nil {
 		log.Printf("Unable to remove records, spec %v, error %v\n", spec, err)
 	}
 }
+
+// WithTransaction executes given function within MongoDB transaction. The
+// function should pass provided context to all operations which should be
+// part of the transaction, e.g. InsertOne(ctx, rec). Transactions are
+// rejected during extended outages.
+func WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return WithTransactionOptions(ctx, nil, fn)
+}
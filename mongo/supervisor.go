@@ -0,0 +1,309 @@
+package mongo
+
+// supervisor module monitors state of MongoDB connection via topology
+// events of the driver, retries transient errors with exponential backoff
+// and rejects operations with clear error during extended outages instead
+// of waiting for server selection timeouts in every request handler.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/description"
+)
+
+// default supervisor parameters used when they are not configured
+var (
+	DefaultRetries                = 3
+	DefaultInitialWait            = 100 * time.Millisecond
+	DefaultMaxWait                = 2 * time.Second
+	DefaultOutageTimeout          = 30 * time.Second
+	DefaultServerSelectionTimeout = 10 * time.Second
+)
+
+// ErrUnavailable represents error of operations rejected or failed due to
+// MongoDB outage
+var ErrUnavailable = errors.New("MongoDB is unavailable")
+
+// State represents state of MongoDB connection
+type State string
+
+// states of MongoDB connection
+const (
+	StateUnknown   State = "unknown"   // no topology information yet
+	StateConnected State = "connected" // all servers are available
+	StateDegraded  State = "degraded"  // some servers are available
+	StateDown      State = "down"      // no servers are available
+)
+
+// Status represents status of MongoDB connection reported by readiness
+// endpoint and server metrics
+type Status struct {
+	State             State      `json:"state"`                  // connection state
+	Since             time.Time  `json:"since"`                  // time of last state change
+	OutageStart       *time.Time `json:"outage_start,omitempty"` // start of current outage
+	LastError         string     `json:"last_error,omitempty"`   // last error of servers
+	Servers           int        `json:"servers"`                // number of known servers
+	AvailableServers  int        `json:"available_servers"`      // number of available servers
+	Transactions      bool       `json:"transactions"`           // deployment supports multi-document transactions
+	Outages           uint64     `json:"outages"`                // total number of outages
+	HeartbeatFailures uint64     `json:"heartbeat_failures"`     // total number of failed heartbeats
+	Retries           uint64     `json:"retries"`                // total number of retried operations
+	Rejected          uint64     `json:"rejected"`               // total number of operations rejected or failed due to outage
+}
+
+// Ready reports whether MongoDB can serve requests
+func (s Status) Ready() bool {
+	return s.State == StateConnected || s.State == StateDegraded
+}
+
+// Supervisor represents supervisor of MongoDB connection
+type Supervisor struct {
+	Retries                int           // number of retries of transient errors
+	InitialWait            time.Duration // initial wait between retries
+	MaxWait                time.Duration // maximum wait between retries
+	OutageTimeout          time.Duration // duration of outage after which operations fail fast
+	ServerSelectionTimeout time.Duration // timeout of server selection of operations
+
+	mu                sync.RWMutex
+	state             State
+	since             time.Time
+	outageStart       time.Time
+	lastError         error
+	servers           int
+	available         int
+	transactions      bool
+	outages           uint64
+	heartbeatFailures uint64
+	retries           uint64
+	rejected          uint64
+}
+
+// NewSupervisor returns supervisor of given configuration, zero values of
+// configuration are replaced by defaults
+func NewSupervisor(cfg srvConfig.MongoSupervisor) *Supervisor {
+	s := &Supervisor{
+		Retries:                cfg.Retries,
+		InitialWait:            cfg.InitialWait,
+		MaxWait:                cfg.MaxWait,
+		OutageTimeout:          cfg.OutageTimeout,
+		ServerSelectionTimeout: cfg.ServerSelectionTimeout,
+		state:                  StateUnknown,
+		since:                  time.Now(),
+	}
+	if s.Retries == 0 {
+		s.Retries = DefaultRetries
+	} else if s.Retries < 0 {
+		s.Retries = 0
+	}
+	if s.InitialWait == 0 {
+		s.InitialWait = DefaultInitialWait
+	}
+	if s.MaxWait == 0 {
+		s.MaxWait = DefaultMaxWait
+	}
+	if s.OutageTimeout == 0 {
+		s.OutageTimeout = DefaultOutageTimeout
+	}
+	if s.ServerSelectionTimeout == 0 {
+		s.ServerSelectionTimeout = DefaultServerSelectionTimeout
+	}
+	return s
+}
+
+// ServerMonitor returns monitor of driver events which should be set in
+// client options
+func (s *Supervisor) ServerMonitor() *event.ServerMonitor {
+	return &event.ServerMonitor{
+		TopologyDescriptionChanged: func(evt *event.TopologyDescriptionChangedEvent) {
+			s.topologyChanged(evt.NewDescription)
+		},
+		ServerHeartbeatFailed: func(evt *event.ServerHeartbeatFailedEvent) {
+			atomic.AddUint64(&s.heartbeatFailures, 1)
+			s.mu.Lock()
+			s.lastError = evt.Failure
+			s.mu.Unlock()
+		},
+	}
+}
+
+// helper function to update state from topology description, it is called
+// by the driver with locked topology and should not run any operation
+func (s *Supervisor) topologyChanged(topo description.Topology) {
+	var available int
+	var lastError error
+	var transactions bool
+	for _, srv := range topo.Servers {
+		if srv.Kind != description.Unknown {
+			available++
+			transactions = transactions || transactional(srv.Kind)
+		} else if srv.LastError != nil {
+			lastError = srv.LastError
+		}
+	}
+	state := StateConnected
+	if len(topo.Servers) == 0 || available == 0 {
+		state = StateDown
+	} else if available < len(topo.Servers) {
+		state = StateDegraded
+	}
+	s.setState(state, len(topo.Servers), available, lastError)
+	// kind of deployment is kept during outages
+	if available > 0 {
+		s.mu.Lock()
+		s.transactions = transactions
+		s.mu.Unlock()
+	}
+}
+
+// helper function to set state of the connection
+func (s *Supervisor) setState(state State, servers, available int, lastError error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.servers = servers
+	s.available = available
+	if lastError != nil {
+		s.lastError = lastError
+	}
+	if state == s.state {
+		return
+	}
+	now := time.Now()
+	if state == StateDown {
+		s.outageStart = now
+		s.outages++
+		log.Printf("ERROR: MongoDB is down, error %v", s.lastError)
+	} else if s.state == StateDown {
+		log.Printf("MongoDB is %s after outage of %s", state, now.Sub(s.outageStart).Round(time.Millisecond))
+		s.outageStart = time.Time{}
+	}
+	if state == StateConnected {
+		s.lastError = nil
+	}
+	s.state = state
+	s.since = now
+}
+
+// Status returns current status of the connection
+func (s *Supervisor) Status() Status {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	status := Status{
+		State:             s.state,
+		Since:             s.since,
+		Servers:           s.servers,
+		AvailableServers:  s.available,
+		Transactions:      s.transactions,
+		Outages:           s.outages,
+		HeartbeatFailures: atomic.LoadUint64(&s.heartbeatFailures),
+		Retries:           atomic.LoadUint64(&s.retries),
+		Rejected:          atomic.LoadUint64(&s.rejected),
+	}
+	if !s.outageStart.IsZero() {
+		start := s.outageStart
+		status.OutageStart = &start
+	}
+	if s.lastError != nil {
+		status.LastError = s.lastError.Error()
+	}
+	return status
+}
+
+// Check returns ErrUnavailable if MongoDB is down longer than outage
+// timeout, operations should not be attempted in this case
+func (s *Supervisor) Check() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.state != StateDown {
+		return nil
+	}
+	if outage := time.Since(s.outageStart); outage >= s.OutageTimeout {
+		atomic.AddUint64(&s.rejected, 1)
+		return fmt.Errorf("%w: no servers are reachable for %s, last error %v", ErrUnavailable, outage.Round(time.Second), s.lastError)
+	}
+	return nil
+}
+
+// Do executes given operation and retries its transient errors with
+// exponential backoff. Operations are rejected with ErrUnavailable during
+// extended outages, transient error of the last attempt is wrapped with
+// ErrUnavailable too. Operation should be idempotent, e.g. find, update
+// via $set or delete.
+func (s *Supervisor) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	wait := s.InitialWait
+	for attempt := 0; ; attempt++ {
+		if err := s.Check(); err != nil {
+			return err
+		}
+		err := fn(ctx)
+		if err == nil || !IsTransient(err) || ctx.Err() != nil {
+			return err
+		}
+		if attempt >= s.Retries {
+			atomic.AddUint64(&s.rejected, 1)
+			return fmt.Errorf("%w after %d attempts: %w", ErrUnavailable, attempt+1, err)
+		}
+		atomic.AddUint64(&s.retries, 1)
+		log.Printf("WARNING: transient MongoDB error %v, retry in %s", err, wait)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%w: %w", ctx.Err(), err)
+		case <-timer.C:
+		}
+		wait *= 2
+		if wait > s.MaxWait {
+			wait = s.MaxWait
+		}
+	}
+}
+
+// codes of MongoDB server errors which indicate elections or shutdown of
+// servers, see https://github.com/mongodb/mongo/blob/master/src/mongo/base/error_codes.yml
+var transientCodes = []int{
+	6,     // HostUnreachable
+	7,     // HostNotFound
+	89,    // NetworkTimeout
+	91,    // ShutdownInProgress
+	189,   // PrimarySteppedDown
+	9001,  // SocketException
+	10107, // NotWritablePrimary
+	11600, // InterruptedAtShutdown
+	11602, // InterruptedDueToReplStateChange
+	13435, // NotPrimaryNoSecondaryOk
+	13436, // NotPrimaryOrSecondary
+}
+
+// IsTransient reports whether error is transient, e.g. network error,
+// server selection timeout or election of new primary, and operation may
+// succeed if retried
+func IsTransient(err error) bool {
+	if err == nil || errors.Is(err, ErrUnavailable) ||
+		errors.Is(err, context.Canceled) || errors.Is(err, mongo.ErrClientDisconnected) {
+		return false
+	}
+	if mongo.IsNetworkError(err) || mongo.IsTimeout(err) {
+		return true
+	}
+	var serr mongo.ServerError
+	if errors.As(err, &serr) {
+		if serr.HasErrorLabel("RetryableWriteError") || serr.HasErrorLabel("TransientTransactionError") {
+			return true
+		}
+		for _, code := range transientCodes {
+			if serr.HasErrorCode(code) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,119 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/address"
+	"go.mongodb.org/mongo-driver/mongo/description"
+)
+
+// helper function to create topology description with given server kinds
+func topology(kinds ...description.ServerKind) description.Topology {
+	var topo description.Topology
+	for _, kind := range kinds {
+		srv := description.Server{Addr: address.Address("localhost:27017"), Kind: kind}
+		if kind == description.Unknown {
+			srv.LastError = errors.New("connection refused")
+		}
+		topo.Servers = append(topo.Servers, srv)
+	}
+	return topo
+}
+
+// TestSupervisorState
+func TestSupervisorState(t *testing.T) {
+	s := NewSupervisor(srvConfig.MongoSupervisor{OutageTimeout: time.Hour})
+	if status := s.Status(); status.State != StateUnknown || status.Ready() {
+		t.Errorf("wrong initial status %+v", status)
+	}
+	s.topologyChanged(topology(description.RSPrimary, description.RSSecondary))
+	if status := s.Status(); status.State != StateConnected || !status.Ready() || status.AvailableServers != 2 || !status.Transactions {
+		t.Errorf("wrong connected status %+v", status)
+	}
+	s.topologyChanged(topology(description.RSPrimary, description.Unknown))
+	if status := s.Status(); status.State != StateDegraded || !status.Ready() || status.LastError != "connection refused" {
+		t.Errorf("wrong degraded status %+v", status)
+	}
+	s.topologyChanged(topology(description.Unknown, description.Unknown))
+	status := s.Status()
+	if status.State != StateDown || status.Ready() || status.OutageStart == nil || status.Outages != 1 {
+		t.Errorf("wrong down status %+v", status)
+	}
+	// outage is shorter than outage timeout
+	if err := s.Check(); err != nil {
+		t.Error(err)
+	}
+	s.OutageTimeout = time.Millisecond
+	time.Sleep(2 * time.Millisecond)
+	if err := s.Check(); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("expect unavailable error, got %v", err)
+	}
+	calls := 0
+	err := s.Do(context.Background(), func(ctx context.Context) error { calls++; return nil })
+	if !errors.Is(err, ErrUnavailable) || calls != 0 {
+		t.Errorf("operation is not rejected, error %v calls %d", err, calls)
+	}
+	s.topologyChanged(topology(description.Standalone))
+	if status := s.Status(); status.State != StateConnected || status.OutageStart != nil || status.LastError != "" || status.Rejected != 2 || status.Transactions {
+		t.Errorf("wrong status after outage %+v", status)
+	}
+}
+
+// TestSupervisorRetries
+func TestSupervisorRetries(t *testing.T) {
+	s := NewSupervisor(srvConfig.MongoSupervisor{Retries: 2, InitialWait: time.Millisecond, MaxWait: 2 * time.Millisecond})
+	transient := mongo.CommandError{Message: "connection reset", Labels: []string{"NetworkError"}}
+	calls := 0
+	err := s.Do(context.Background(), func(ctx context.Context) error {
+		calls++
+		if calls < 3 {
+			return transient
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("wrong result of retries, error %v calls %d", err, calls)
+	}
+	calls = 0
+	err = s.Do(context.Background(), func(ctx context.Context) error { calls++; return transient })
+	var cerr mongo.CommandError
+	if !errors.Is(err, ErrUnavailable) || !errors.As(err, &cerr) || calls != 3 {
+		t.Errorf("wrong result of exhausted retries, error %v calls %d", err, calls)
+	}
+	calls = 0
+	err = s.Do(context.Background(), func(ctx context.Context) error { calls++; return mongo.ErrNoDocuments })
+	if !errors.Is(err, mongo.ErrNoDocuments) || calls != 1 {
+		t.Errorf("permanent error is retried, error %v calls %d", err, calls)
+	}
+	if status := s.Status(); status.Retries != 4 || status.Rejected != 1 {
+		t.Errorf("wrong counters %+v", status)
+	}
+}
+
+// TestIsTransient
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		err    error
+		expect bool
+	}{
+		{nil, false},
+		{errors.New("some error"), false},
+		{context.Canceled, false},
+		{context.DeadlineExceeded, true},
+		{mongo.CommandError{Labels: []string{"NetworkError"}}, true},
+		{mongo.CommandError{Code: 10107, Name: "NotWritablePrimary"}, true},
+		{mongo.CommandError{Code: 11000}, false},
+		{mongo.WriteException{Labels: []string{"RetryableWriteError"}}, true},
+		{ErrUnavailable, false},
+	}
+	for _, tt := range tests {
+		if IsTransient(tt.err) != tt.expect {
+			t.Errorf("wrong transient flag of %v, expect %v", tt.err, tt.expect)
+		}
+	}
+}
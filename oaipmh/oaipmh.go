@@ -0,0 +1,622 @@
+package oaipmh
+
+// oaipmh module implements OAI-PMH 2.0 provider on top of metadata store
+// and metadata exporters. It supports Identify, ListMetadataFormats,
+// ListSets, ListIdentifiers, ListRecords and GetRecord verbs with
+// resumption tokens, which allows library aggregators to harvest metadata
+// records.
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	exporters "github.com/CHESSComputing/golib/exporters"
+	storage "github.com/CHESSComputing/golib/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// OAI-PMH namespaces
+const (
+	Namespace      = "http://www.openarchives.org/OAI/2.0/"
+	SchemaLocation = "http://www.openarchives.org/OAI/2.0/ http://www.openarchives.org/OAI/2.0/OAI-PMH.xsd"
+	XSINamespace   = "http://www.w3.org/2001/XMLSchema-instance"
+)
+
+// Granularity defines datestamp granularity of the repository
+const Granularity = "YYYY-MM-DDThh:mm:ssZ"
+
+// datestamp formats
+const (
+	secondsFormat = "2006-01-02T15:04:05Z"
+	dayFormat     = "2006-01-02"
+)
+
+// OAI-PMH error codes
+const (
+	BadArgument             = "badArgument"
+	BadResumptionToken      = "badResumptionToken"
+	BadVerb                 = "badVerb"
+	CannotDisseminateFormat = "cannotDisseminateFormat"
+	IDDoesNotExist          = "idDoesNotExist"
+	NoRecordsMatch          = "noRecordsMatch"
+	NoSetHierarchy          = "noSetHierarchy"
+)
+
+// MetadataFormat represents metadata format supported by the provider
+type MetadataFormat struct {
+	Prefix    string `xml:"metadataPrefix"`
+	Schema    string `xml:"schema"`
+	Namespace string `xml:"metadataNamespace"`
+	Exporter  string `xml:"-"` // name of exporter in exporters registry
+}
+
+// MetadataFormats defines metadata formats supported by the provider,
+// oai_dc is mandatory for OAI-PMH repositories
+var MetadataFormats = []MetadataFormat{
+	{Prefix: "oai_dc", Schema: exporters.OAIDCSchema, Namespace: exporters.OAIDCNamespace, Exporter: "oai_dc"},
+	{Prefix: "datacite", Schema: exporters.DataCiteSchema, Namespace: exporters.DataCiteNamespace, Exporter: "datacite"},
+}
+
+// Error represents OAI-PMH error
+type Error struct {
+	Code    string `xml:"code,attr"`
+	Message string `xml:",chardata"`
+}
+
+// Error implements error interface
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// helper function to create OAI-PMH error
+func oaiError(code, format string, args ...any) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// Provider represents OAI-PMH provider
+type Provider struct {
+	Store                storage.Store
+	Collection           string
+	Mapper               *exporters.Mapper
+	RepositoryName       string
+	RepositoryIdentifier string
+	BaseURL              string
+	AdminEmails          []string
+	DateKey              string // record key of modification time
+	PageSize             int    // number of records per response
+}
+
+// NewProvider returns OAI-PMH provider for records of given collection
+func NewProvider(store storage.Store, collection string, mapper *exporters.Mapper, cfg srvConfig.OAIPMH) *Provider {
+	p := &Provider{
+		Store:                store,
+		Collection:           collection,
+		Mapper:               mapper,
+		RepositoryName:       cfg.RepositoryName,
+		RepositoryIdentifier: cfg.RepositoryIdentifier,
+		BaseURL:              cfg.BaseURL,
+		AdminEmails:          cfg.AdminEmails,
+		DateKey:              cfg.DateKey,
+		PageSize:             cfg.PageSize,
+	}
+	if p.DateKey == "" {
+		p.DateKey = "date"
+	}
+	if p.PageSize <= 0 {
+		p.PageSize = 100
+	}
+	if p.RepositoryIdentifier == "" {
+		p.RepositoryIdentifier = "foxden"
+	}
+	return p
+}
+
+// header represents OAI-PMH record header
+type header struct {
+	Status     string `xml:"status,attr,omitempty"`
+	Identifier string `xml:"identifier"`
+	Datestamp  string `xml:"datestamp"`
+}
+
+// metadata represents exported metadata of the record
+type metadata struct {
+	Data string `xml:",innerxml"`
+}
+
+// record represents OAI-PMH record
+type record struct {
+	Header   header    `xml:"header"`
+	Metadata *metadata `xml:"metadata,omitempty"`
+}
+
+// resumptionToken represents OAI-PMH resumption token element
+type resumptionToken struct {
+	CompleteListSize int    `xml:"completeListSize,attr"`
+	Cursor           int    `xml:"cursor,attr"`
+	Token            string `xml:",chardata"`
+}
+
+// identify represents Identify response
+type identify struct {
+	RepositoryName    string   `xml:"repositoryName"`
+	BaseURL           string   `xml:"baseURL"`
+	ProtocolVersion   string   `xml:"protocolVersion"`
+	AdminEmails       []string `xml:"adminEmail"`
+	EarliestDatestamp string   `xml:"earliestDatestamp"`
+	DeletedRecord     string   `xml:"deletedRecord"`
+	Granularity       string   `xml:"granularity"`
+}
+
+// list represents response of list verbs
+type list struct {
+	Headers []header         `xml:"header,omitempty"`
+	Records []record         `xml:"record,omitempty"`
+	Token   *resumptionToken `xml:"resumptionToken,omitempty"`
+}
+
+// request represents request element of OAI-PMH response
+type request struct {
+	Verb           string `xml:"verb,attr,omitempty"`
+	Identifier     string `xml:"identifier,attr,omitempty"`
+	MetadataPrefix string `xml:"metadataPrefix,attr,omitempty"`
+	From           string `xml:"from,attr,omitempty"`
+	Until          string `xml:"until,attr,omitempty"`
+	Set            string `xml:"set,attr,omitempty"`
+	Token          string `xml:"resumptionToken,attr,omitempty"`
+	URL            string `xml:",chardata"`
+}
+
+// Response represents OAI-PMH response
+type Response struct {
+	XMLName             xml.Name `xml:"OAI-PMH"`
+	Namespace           string   `xml:"xmlns,attr"`
+	XSI                 string   `xml:"xmlns:xsi,attr"`
+	SchemaLocation      string   `xml:"xsi:schemaLocation,attr"`
+	ResponseDate        string   `xml:"responseDate"`
+	Request             request  `xml:"request"`
+	Errors              []*Error `xml:"error,omitempty"`
+	Identify            *identify
+	ListMetadataFormats *struct {
+		Formats []MetadataFormat `xml:"metadataFormat"`
+	} `xml:"ListMetadataFormats,omitempty"`
+	GetRecord *struct {
+		Record record `xml:"record"`
+	} `xml:"GetRecord,omitempty"`
+	ListIdentifiers *list `xml:"ListIdentifiers,omitempty"`
+	ListRecords     *list `xml:"ListRecords,omitempty"`
+}
+
+// token represents state of list request encoded in resumption token
+type token struct {
+	Prefix string `json:"p"`
+	From   string `json:"f,omitempty"`
+	Until  string `json:"u,omitempty"`
+	Cursor int    `json:"c"`
+}
+
+// helper function to encode resumption token
+func (t token) encode() string {
+	data, _ := json.Marshal(t)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// helper function to decode resumption token
+func decodeToken(val string) (token, error) {
+	var t token
+	data, err := base64.RawURLEncoding.DecodeString(val)
+	if err != nil {
+		return t, err
+	}
+	err = json.Unmarshal(data, &t)
+	return t, err
+}
+
+// helper function to parse from and until arguments, until of day
+// granularity includes the whole day
+func parseDate(val string, until bool) (time.Time, error) {
+	if t, err := time.Parse(secondsFormat, val); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(dayFormat, val)
+	if err != nil {
+		return t, err
+	}
+	if until {
+		t = t.Add(24*time.Hour - time.Second)
+	}
+	return t, nil
+}
+
+// helper function to get datestamp of the record
+func datestamp(val any) time.Time {
+	switch v := val.(type) {
+	case time.Time:
+		return v.UTC()
+	case interface{ Time() time.Time }:
+		// e.g. MongoDB DateTime
+		return v.Time().UTC()
+	case int64:
+		return time.Unix(v, 0).UTC()
+	case int32:
+		return time.Unix(int64(v), 0).UTC()
+	case int:
+		return time.Unix(int64(v), 0).UTC()
+	case float64:
+		return time.Unix(int64(v), 0).UTC()
+	case string:
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			return t.UTC()
+		}
+		if t, err := time.Parse(dayFormat, v); err == nil {
+			return t
+		}
+	}
+	return time.Unix(0, 0).UTC()
+}
+
+// helper function to build store spec of records within from and until
+// datestamps. Datestamps are converted into representation of DateKey
+// values (time, unix seconds or string) which is taken from one of the
+// records, therefore the filter is applied by the store itself.
+func (p *Provider) dateSpec(ctx context.Context, from, until time.Time) (map[string]any, error) {
+	if from.IsZero() && until.IsZero() {
+		return nil, nil
+	}
+	records, err := p.Store.Find(ctx, p.Collection, nil, &storage.FindOptions{Sort: []string{p.DateKey}, Limit: 1})
+	if err != nil || len(records) == 0 {
+		return nil, err
+	}
+	sample := records[0][p.DateKey]
+	cond := make(map[string]any)
+	if !from.IsZero() {
+		cond["$gte"] = dateValue(sample, from)
+	}
+	if !until.IsZero() {
+		cond["$lte"] = dateValue(sample, until)
+	}
+	return map[string]any{p.DateKey: cond}, nil
+}
+
+// helper function to convert time into representation of given datestamp
+func dateValue(sample any, t time.Time) any {
+	switch v := sample.(type) {
+	case int64, int32, int:
+		return t.Unix()
+	case float64:
+		return float64(t.Unix())
+	case string:
+		if _, err := time.Parse(time.RFC3339, v); err != nil {
+			return t.Format(dayFormat)
+		}
+		return t.Format(time.RFC3339)
+	}
+	return t
+}
+
+// Identifier returns OAI identifier of dataset id
+func (p *Provider) Identifier(id string) string {
+	return fmt.Sprintf("oai:%s:%s", p.RepositoryIdentifier, id)
+}
+
+// helper function to get dataset id of OAI identifier
+func (p *Provider) datasetID(identifier string) (string, bool) {
+	prefix := fmt.Sprintf("oai:%s:", p.RepositoryIdentifier)
+	if !strings.HasPrefix(identifier, prefix) || len(identifier) == len(prefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(identifier, prefix), true
+}
+
+// helper function to find metadata format
+func format(prefix string) (MetadataFormat, bool) {
+	for _, f := range MetadataFormats {
+		if f.Prefix == prefix {
+			return f, true
+		}
+	}
+	return MetadataFormat{}, false
+}
+
+// helper function to build header of the record
+func (p *Provider) header(rec map[string]any) header {
+	id := fmt.Sprintf("%v", rec[p.Mapper.IDKey])
+	return header{
+		Identifier: p.Identifier(id),
+		Datestamp:  datestamp(rec[p.DateKey]).Format(secondsFormat),
+	}
+}
+
+// helper function to build record in given metadata format
+func (p *Provider) record(rec map[string]any, f MetadataFormat) (record, error) {
+	data, _, err := p.Mapper.Export(f.Exporter, rec)
+	if err != nil {
+		return record{}, err
+	}
+	// exported document is embedded without XML declaration
+	body := strings.TrimPrefix(string(data), xml.Header)
+	return record{Header: p.header(rec), Metadata: &metadata{Data: body}}, nil
+}
+
+// helper function to check request arguments, required arguments should
+// be present and other arguments should be allowed
+func checkArgs(args map[string]string, required, allowed []string) error {
+	known := map[string]bool{"verb": true}
+	for _, a := range required {
+		if args[a] == "" {
+			return oaiError(BadArgument, "missing required argument '%s'", a)
+		}
+		known[a] = true
+	}
+	for _, a := range allowed {
+		known[a] = true
+	}
+	for a := range args {
+		if !known[a] {
+			return oaiError(BadArgument, "illegal argument '%s'", a)
+		}
+	}
+	return nil
+}
+
+// Identify implements Identify verb
+func (p *Provider) Identify(ctx context.Context) (*identify, error) {
+	earliest := time.Unix(0, 0).UTC()
+	records, err := p.Store.Find(ctx, p.Collection, nil, &storage.FindOptions{Sort: []string{p.DateKey}, Limit: 1})
+	if err != nil {
+		return nil, err
+	}
+	if len(records) > 0 {
+		earliest = datestamp(records[0][p.DateKey])
+	}
+	return &identify{
+		RepositoryName:    p.RepositoryName,
+		BaseURL:           p.BaseURL,
+		ProtocolVersion:   "2.0",
+		AdminEmails:       p.AdminEmails,
+		EarliestDatestamp: earliest.Format(secondsFormat),
+		DeletedRecord:     "no",
+		Granularity:       Granularity,
+	}, nil
+}
+
+// GetRecord implements GetRecord verb
+func (p *Provider) GetRecord(ctx context.Context, identifier, prefix string) (record, error) {
+	f, ok := format(prefix)
+	if !ok {
+		return record{}, oaiError(CannotDisseminateFormat, "unsupported metadata format '%s'", prefix)
+	}
+	id, ok := p.datasetID(identifier)
+	if !ok {
+		return record{}, oaiError(IDDoesNotExist, "unknown identifier '%s'", identifier)
+	}
+	rec, err := storage.FindOne(ctx, p.Store, p.Collection, map[string]any{p.Mapper.IDKey: id})
+	if errors.Is(err, storage.ErrNotFound) {
+		return record{}, oaiError(IDDoesNotExist, "unknown identifier '%s'", identifier)
+	}
+	if err != nil {
+		return record{}, err
+	}
+	return p.record(rec, f)
+}
+
+// List implements ListIdentifiers (withMetadata is false) and ListRecords
+// verbs. Records are ordered by their datestamp and split into pages of
+// PageSize records, next page is requested via resumption token.
+func (p *Provider) List(ctx context.Context, args map[string]string, withMetadata bool) (*list, error) {
+	var t token
+	if val := args["resumptionToken"]; val != "" {
+		var err error
+		if t, err = decodeToken(val); err != nil || t.Cursor < 0 {
+			return nil, oaiError(BadResumptionToken, "invalid resumption token")
+		}
+	} else {
+		t = token{Prefix: args["metadataPrefix"], From: args["from"], Until: args["until"]}
+	}
+	if args["set"] != "" {
+		return nil, oaiError(NoSetHierarchy, "repository does not support sets")
+	}
+	f, ok := format(t.Prefix)
+	if !ok {
+		return nil, oaiError(CannotDisseminateFormat, "unsupported metadata format '%s'", t.Prefix)
+	}
+	var from, until time.Time
+	var err error
+	if t.From != "" {
+		if from, err = parseDate(t.From, false); err != nil {
+			return nil, oaiError(BadArgument, "invalid from argument '%s'", t.From)
+		}
+	}
+	if t.Until != "" {
+		if until, err = parseDate(t.Until, true); err != nil {
+			return nil, oaiError(BadArgument, "invalid until argument '%s'", t.Until)
+		}
+	}
+	if t.From != "" && t.Until != "" && len(t.From) != len(t.Until) {
+		return nil, oaiError(BadArgument, "from and until arguments have different granularity")
+	}
+	spec, err := p.dateSpec(ctx, from, until)
+	if err != nil {
+		log.Printf("ERROR: unable to find OAI-PMH records, error %v", err)
+		return nil, err
+	}
+	total, err := p.Store.Count(ctx, p.Collection, spec)
+	if err != nil {
+		log.Printf("ERROR: unable to count OAI-PMH records, error %v", err)
+		return nil, err
+	}
+	if total == 0 {
+		return nil, oaiError(NoRecordsMatch, "no records match the request")
+	}
+	size := int(total)
+	if t.Cursor >= size {
+		return nil, oaiError(BadResumptionToken, "resumption token is out of range")
+	}
+	opts := &storage.FindOptions{
+		Sort:  []string{p.DateKey, p.Mapper.IDKey},
+		Skip:  t.Cursor,
+		Limit: p.PageSize,
+	}
+	records, err := p.Store.Find(ctx, p.Collection, spec, opts)
+	if err != nil {
+		log.Printf("ERROR: unable to find OAI-PMH records, error %v", err)
+		return nil, err
+	}
+	end := t.Cursor + len(records)
+	out := &list{}
+	for _, rec := range records {
+		if !withMetadata {
+			out.Headers = append(out.Headers, p.header(rec))
+			continue
+		}
+		r, err := p.record(rec, f)
+		if err != nil {
+			return nil, err
+		}
+		out.Records = append(out.Records, r)
+	}
+	if end < size || t.Cursor > 0 {
+		// the last page has empty resumption token
+		rt := &resumptionToken{CompleteListSize: size, Cursor: t.Cursor}
+		if end < size {
+			next := t
+			next.Cursor = end
+			rt.Token = next.encode()
+		}
+		out.Token = rt
+	}
+	return out, nil
+}
+
+// helper function to create OAI-PMH response
+func (p *Provider) response() *Response {
+	return &Response{
+		Namespace:      Namespace,
+		XSI:            XSINamespace,
+		SchemaLocation: SchemaLocation,
+		ResponseDate:   time.Now().UTC().Format(secondsFormat),
+		Request:        request{URL: p.BaseURL},
+	}
+}
+
+// Handle processes OAI-PMH request with given arguments
+func (p *Provider) Handle(ctx context.Context, args map[string]string) *Response {
+	resp := p.response()
+	verb := args["verb"]
+	var err error
+	switch verb {
+	case "Identify":
+		if err = checkArgs(args, nil, nil); err == nil {
+			resp.Identify, err = p.Identify(ctx)
+		}
+	case "ListMetadataFormats":
+		if err = checkArgs(args, nil, []string{"identifier"}); err == nil && args["identifier"] != "" {
+			// check that the record exists
+			_, err = p.GetRecord(ctx, args["identifier"], MetadataFormats[0].Prefix)
+		}
+		if err == nil {
+			resp.ListMetadataFormats = &struct {
+				Formats []MetadataFormat `xml:"metadataFormat"`
+			}{Formats: MetadataFormats}
+		}
+	case "ListSets":
+		if err = checkArgs(args, nil, []string{"resumptionToken"}); err == nil {
+			err = oaiError(NoSetHierarchy, "repository does not support sets")
+		}
+	case "GetRecord":
+		if err = checkArgs(args, []string{"identifier", "metadataPrefix"}, nil); err == nil {
+			var rec record
+			if rec, err = p.GetRecord(ctx, args["identifier"], args["metadataPrefix"]); err == nil {
+				resp.GetRecord = &struct {
+					Record record `xml:"record"`
+				}{Record: rec}
+			}
+		}
+	case "ListIdentifiers", "ListRecords":
+		if args["resumptionToken"] != "" {
+			err = checkArgs(args, []string{"resumptionToken"}, nil)
+		} else {
+			err = checkArgs(args, []string{"metadataPrefix"}, []string{"from", "until", "set"})
+		}
+		if err == nil {
+			var l *list
+			if l, err = p.List(ctx, args, verb == "ListRecords"); err == nil {
+				if verb == "ListRecords" {
+					resp.ListRecords = l
+				} else {
+					resp.ListIdentifiers = l
+				}
+			}
+		}
+	default:
+		err = oaiError(BadVerb, "illegal OAI verb '%s'", verb)
+	}
+	if err == nil {
+		resp.Request = p.requestOf(args)
+		return resp
+	}
+	var oerr *Error
+	if !errors.As(err, &oerr) {
+		log.Printf("ERROR: OAI-PMH %s request failed, error %v", verb, err)
+		oerr = oaiError(BadArgument, "unable to process request")
+	}
+	resp.Errors = []*Error{oerr}
+	// request attributes are not reported for badVerb and badArgument errors
+	if oerr.Code != BadVerb && oerr.Code != BadArgument {
+		resp.Request = p.requestOf(args)
+	}
+	return resp
+}
+
+// helper function to build request element from request arguments
+func (p *Provider) requestOf(args map[string]string) request {
+	return request{
+		Verb:           args["verb"],
+		Identifier:     args["identifier"],
+		MetadataPrefix: args["metadataPrefix"],
+		From:           args["from"],
+		Until:          args["until"],
+		Set:            args["set"],
+		Token:          args["resumptionToken"],
+		URL:            p.BaseURL,
+	}
+}
+
+// Handler provides gin handler of OAI-PMH endpoint, arguments are accepted
+// via GET query or POST form
+func (p *Provider) Handler(c *gin.Context) {
+	if err := c.Request.ParseForm(); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	args := make(map[string]string)
+	for k, v := range c.Request.Form {
+		if len(v) > 1 {
+			resp := p.response()
+			resp.Errors = []*Error{oaiError(BadArgument, "repeated argument '%s'", k)}
+			p.write(c, resp)
+			return
+		}
+		args[k] = v[0]
+	}
+	p.write(c, p.Handle(c.Request.Context(), args))
+}
+
+// helper function to write OAI-PMH response
+func (p *Provider) write(c *gin.Context, resp *Response) {
+	data, err := xml.MarshalIndent(resp, "", "  ")
+	if err != nil {
+		log.Println("ERROR: unable to marshal OAI-PMH response", err)
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.Data(http.StatusOK, "text/xml; charset=utf-8", append([]byte(xml.Header), data...))
+}
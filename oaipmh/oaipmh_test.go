@@ -0,0 +1,224 @@
+package oaipmh
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	exporters "github.com/CHESSComputing/golib/exporters"
+	storage "github.com/CHESSComputing/golib/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// helper function to create test provider with given number of records
+func testProvider(t *testing.T, nrec int) *Provider {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	for i := 0; i < nrec; i++ {
+		rec := map[string]any{
+			"_id":         i,
+			"did":         fmt.Sprintf("did-%d", i),
+			"description": fmt.Sprintf("dataset %d", i),
+			"authors":     []any{"John Doe"},
+			"date":        int64(1704067200 + i*86400), // 2024-01-01 + i days
+		}
+		if err := store.Insert(ctx, "meta", rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mapper := exporters.NewMapper(srvConfig.Export{
+		Publisher: "CHESS",
+		Mappings:  map[string]map[string]string{"default": {"title": "description"}},
+	})
+	cfg := srvConfig.OAIPMH{
+		RepositoryName:       "FOXDEN",
+		RepositoryIdentifier: "foxden.test",
+		BaseURL:              "http://localhost/oai",
+		PageSize:             2,
+	}
+	return NewProvider(store, "meta", mapper, cfg)
+}
+
+// helper function to perform OAI-PMH request
+func oaiRequest(t *testing.T, p *Provider, args url.Values) testResponse {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/oai", p.Handler)
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/oai?"+args.Encode(), nil)
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatal("invalid status code", w.Code)
+	}
+	var resp testResponse
+	if err := xml.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err, w.Body.String())
+	}
+	return resp
+}
+
+// testResponse represents parsed OAI-PMH response
+type testResponse struct {
+	Request struct {
+		Verb string `xml:"verb,attr"`
+	} `xml:"request"`
+	Error struct {
+		Code string `xml:"code,attr"`
+	} `xml:"error"`
+	Identify struct {
+		Name     string `xml:"repositoryName"`
+		Earliest string `xml:"earliestDatestamp"`
+	} `xml:"Identify"`
+	Identifiers []string `xml:"ListIdentifiers>header>identifier"`
+	Records     []struct {
+		Identifier string `xml:"header>identifier"`
+		Datestamp  string `xml:"header>datestamp"`
+		Title      string `xml:"metadata>dc>title"`
+	} `xml:"ListRecords>record"`
+	Token struct {
+		Size  int    `xml:"completeListSize,attr"`
+		Value string `xml:",chardata"`
+	} `xml:"ListRecords>resumptionToken"`
+	Record struct {
+		Identifier string `xml:"header>identifier"`
+		Title      string `xml:"metadata>resource>titles>title"`
+	} `xml:"GetRecord>record"`
+	Formats []string `xml:"ListMetadataFormats>metadataFormat>metadataPrefix"`
+}
+
+// TestIdentify
+func TestIdentify(t *testing.T) {
+	p := testProvider(t, 3)
+	resp := oaiRequest(t, p, url.Values{"verb": {"Identify"}})
+	if resp.Identify.Name != "FOXDEN" || resp.Identify.Earliest != "2024-01-01T00:00:00Z" {
+		t.Errorf("invalid Identify response %+v", resp.Identify)
+	}
+	resp = oaiRequest(t, p, url.Values{"verb": {"Identify"}, "bla": {"1"}})
+	if resp.Error.Code != BadArgument || resp.Request.Verb != "" {
+		t.Errorf("expect badArgument error %+v", resp)
+	}
+	resp = oaiRequest(t, p, url.Values{"verb": {"Harvest"}})
+	if resp.Error.Code != BadVerb {
+		t.Errorf("expect badVerb error %+v", resp)
+	}
+	resp = oaiRequest(t, p, url.Values{"verb": {"ListMetadataFormats"}})
+	if len(resp.Formats) != 2 || resp.Formats[0] != "oai_dc" {
+		t.Errorf("invalid metadata formats %+v", resp.Formats)
+	}
+}
+
+// TestListRecords
+func TestListRecords(t *testing.T) {
+	p := testProvider(t, 5)
+	args := url.Values{"verb": {"ListRecords"}, "metadataPrefix": {"oai_dc"}, "from": {"2024-01-02"}}
+	resp := oaiRequest(t, p, args)
+	if len(resp.Records) != 2 || resp.Token.Size != 4 || resp.Token.Value == "" {
+		t.Fatalf("invalid first page %+v", resp)
+	}
+	if resp.Records[0].Identifier != "oai:foxden.test:did-1" || resp.Records[0].Title != "dataset 1" {
+		t.Errorf("invalid record %+v", resp.Records[0])
+	}
+	if resp.Records[0].Datestamp != "2024-01-02T00:00:00Z" {
+		t.Errorf("invalid datestamp %s", resp.Records[0].Datestamp)
+	}
+	var ids []string
+	for resp.Token.Value != "" {
+		for _, r := range resp.Records {
+			ids = append(ids, r.Identifier)
+		}
+		resp = oaiRequest(t, p, url.Values{"verb": {"ListRecords"}, "resumptionToken": {resp.Token.Value}})
+	}
+	for _, r := range resp.Records {
+		ids = append(ids, r.Identifier)
+	}
+	if len(ids) != 4 || ids[3] != "oai:foxden.test:did-4" {
+		t.Errorf("invalid harvested records %v", ids)
+	}
+
+	args = url.Values{"verb": {"ListIdentifiers"}, "metadataPrefix": {"oai_dc"}, "until": {"2024-01-01"}}
+	resp = oaiRequest(t, p, args)
+	if len(resp.Identifiers) != 1 || resp.Identifiers[0] != "oai:foxden.test:did-0" {
+		t.Errorf("invalid identifiers %+v", resp.Identifiers)
+	}
+	args = url.Values{"verb": {"ListRecords"}, "metadataPrefix": {"oai_dc"}, "from": {"2025-01-01"}}
+	if resp = oaiRequest(t, p, args); resp.Error.Code != NoRecordsMatch {
+		t.Errorf("expect noRecordsMatch error %+v", resp.Error)
+	}
+	args = url.Values{"verb": {"ListRecords"}, "metadataPrefix": {"marc"}}
+	if resp = oaiRequest(t, p, args); resp.Error.Code != CannotDisseminateFormat {
+		t.Errorf("expect cannotDisseminateFormat error %+v", resp.Error)
+	}
+	args = url.Values{"verb": {"ListRecords"}, "resumptionToken": {"bla"}}
+	if resp = oaiRequest(t, p, args); resp.Error.Code != BadResumptionToken {
+		t.Errorf("expect badResumptionToken error %+v", resp.Error)
+	}
+}
+
+// findStore records find options of the wrapped store
+type findStore struct {
+	storage.Store
+	specs []map[string]any
+	opts  []*storage.FindOptions
+}
+
+// Find implements storage.Store interface
+func (s *findStore) Find(ctx context.Context, collection string, spec map[string]any, opts *storage.FindOptions) ([]map[string]any, error) {
+	s.specs = append(s.specs, spec)
+	s.opts = append(s.opts, opts)
+	return s.Store.Find(ctx, collection, spec, opts)
+}
+
+// TestListQuery
+func TestListQuery(t *testing.T) {
+	p := testProvider(t, 5)
+	store := &findStore{Store: p.Store}
+	p.Store = store
+	args := map[string]string{"metadataPrefix": "oai_dc", "from": "2024-01-02", "until": "2024-01-04"}
+	out, err := p.List(context.Background(), args, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Headers) != 2 || out.Token == nil || out.Token.CompleteListSize != 3 {
+		t.Fatalf("invalid list %+v", out)
+	}
+	// records are filtered, sorted and paginated by the store
+	n := len(store.opts) - 1
+	opts, spec := store.opts[n], store.specs[n]
+	if opts.Limit != p.PageSize || opts.Skip != 0 || len(opts.Sort) != 2 {
+		t.Errorf("invalid find options %+v", opts)
+	}
+	cond, ok := spec["date"].(map[string]any)
+	if !ok || cond["$gte"] != int64(1704153600) || cond["$lte"] != int64(1704412799) {
+		t.Errorf("invalid find spec %+v", spec)
+	}
+	args = map[string]string{"resumptionToken": out.Token.Token}
+	if out, err = p.List(context.Background(), args, false); err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Headers) != 1 || store.opts[len(store.opts)-1].Skip != 2 {
+		t.Errorf("invalid second page %+v", out)
+	}
+}
+
+// TestGetRecord
+func TestGetRecord(t *testing.T) {
+	p := testProvider(t, 2)
+	args := url.Values{"verb": {"GetRecord"}, "identifier": {"oai:foxden.test:did-1"}, "metadataPrefix": {"datacite"}}
+	resp := oaiRequest(t, p, args)
+	if resp.Record.Identifier != "oai:foxden.test:did-1" || resp.Record.Title != "dataset 1" {
+		t.Errorf("invalid record %+v", resp)
+	}
+	args.Set("identifier", "oai:foxden.test:did-7")
+	if resp = oaiRequest(t, p, args); resp.Error.Code != IDDoesNotExist {
+		t.Errorf("expect idDoesNotExist error %+v", resp.Error)
+	}
+	args.Del("identifier")
+	if resp = oaiRequest(t, p, args); resp.Error.Code != BadArgument {
+		t.Errorf("expect badArgument error %+v", resp.Error)
+	}
+}
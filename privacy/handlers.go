@@ -0,0 +1,212 @@
+package privacy
+
+// handlers module provides HTTP endpoints of personal data export and
+// erasure workflow
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	server "github.com/CHESSComputing/golib/server"
+	services "github.com/CHESSComputing/golib/services"
+	utils "github.com/CHESSComputing/golib/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// OfficerRoles defines roles of data protection officers which may export
+// and erase personal data of any user and confirm erasure requests
+var OfficerRoles = []string{"admin", "dpo"}
+
+// ErasureRequest represents request to erase personal data
+type ErasureRequest struct {
+	Subject string `json:"subject"` // user name, user of the request by default
+	Reason  string `json:"reason,omitempty"`
+}
+
+// ConfirmRequest represents confirmation of erasure request
+type ConfirmRequest struct {
+	Subject string `json:"subject"` // user name of erasure request
+}
+
+// helper function to abort request with service error
+func abort(c *gin.Context, code, srvCode int, err error) {
+	rec := services.Response("privacy", code, srvCode, err)
+	c.AbortWithStatusJSON(code, rec)
+}
+
+// helper function to abort request with error of the manager
+func abortWithError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		abort(c, http.StatusNotFound, services.QueryError, err)
+	case errors.Is(err, ErrState), errors.Is(err, ErrExpired):
+		abort(c, http.StatusConflict, services.ConflictError, err)
+	case errors.Is(err, ErrInvalid):
+		abort(c, http.StatusBadRequest, services.ValidateError, err)
+	default:
+		log.Printf("ERROR: privacy request failed, error %v", err)
+		abort(c, http.StatusInternalServerError, services.DatabaseError, err)
+	}
+}
+
+// helper function to provide user of the request and whether the user is
+// data protection officer, token claims are provided by token validation
+// middleware
+func requestUser(c *gin.Context) (string, bool) {
+	if val, ok := c.Get("claims"); ok {
+		if claims, ok := val.(*authz.Claims); ok {
+			for _, role := range claims.CustomClaims.Roles {
+				if utils.InList(role, OfficerRoles) {
+					return claims.CustomClaims.User, true
+				}
+			}
+			return claims.CustomClaims.User, false
+		}
+	}
+	return "", false
+}
+
+// helper function to check that user of the request may access personal
+// data of the subject
+func authorized(c *gin.Context, subject string) (string, bool) {
+	user, officer := requestUser(c)
+	if user == "" || (!officer && user != subject) {
+		abort(c, http.StatusForbidden, services.PolicyError, errors.New("personal data of other users requires data protection officer role"))
+		return user, false
+	}
+	return user, true
+}
+
+// ExportHandler provides personal data of the subject as JSON bundle
+func (m *Manager) ExportHandler(c *gin.Context) {
+	subject := c.Param("subject")
+	user, ok := authorized(c, subject)
+	if !ok {
+		return
+	}
+	bundle, err := m.Export(c.Request.Context(), user, subject)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", subject+"-export.json"))
+	c.JSON(http.StatusOK, bundle)
+}
+
+// RequestErasureHandler creates erasure request
+func (m *Manager) RequestErasureHandler(c *gin.Context) {
+	var req ErasureRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abort(c, http.StatusBadRequest, services.BindError, err)
+		return
+	}
+	if req.Subject == "" {
+		req.Subject, _ = requestUser(c)
+	}
+	user, ok := authorized(c, req.Subject)
+	if !ok {
+		return
+	}
+	e, err := m.RequestErasure(c.Request.Context(), user, req.Subject, req.Reason)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, e)
+}
+
+// ListErasuresHandler provides erasure requests of status query parameter,
+// default is pending
+func (m *Manager) ListErasuresHandler(c *gin.Context) {
+	if _, officer := requestUser(c); !officer {
+		abort(c, http.StatusForbidden, services.PolicyError, errors.New("erasure requests require data protection officer role"))
+		return
+	}
+	status := c.DefaultQuery("status", StatusPending)
+	if status == "all" {
+		status = ""
+	}
+	list, err := m.ListErasures(c.Request.Context(), status)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if list == nil {
+		list = []Erasure{}
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// GetErasureHandler provides erasure request
+func (m *Manager) GetErasureHandler(c *gin.Context) {
+	e, err := m.GetErasure(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if _, ok := authorized(c, e.Subject); !ok {
+		return
+	}
+	c.JSON(http.StatusOK, e)
+}
+
+// ConfirmErasureHandler confirms erasure request and erases personal data
+func (m *Manager) ConfirmErasureHandler(c *gin.Context) {
+	user, officer := requestUser(c)
+	if !officer {
+		abort(c, http.StatusForbidden, services.PolicyError, errors.New("erasure confirmation requires data protection officer role"))
+		return
+	}
+	var req ConfirmRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abort(c, http.StatusBadRequest, services.BindError, err)
+		return
+	}
+	e, err := m.ConfirmErasure(c.Request.Context(), user, c.Param("id"), req.Subject)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, e)
+}
+
+// CancelErasureHandler cancels erasure request
+func (m *Manager) CancelErasureHandler(c *gin.Context) {
+	e, err := m.GetErasure(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	user, ok := authorized(c, e.Subject)
+	if !ok {
+		return
+	}
+	e, err = m.CancelErasure(c.Request.Context(), user, e.ID)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, e)
+}
+
+// Routes returns routes of personal data export and erasure under given
+// path, e.g. /privacy
+func (m *Manager) Routes(path string) []server.Route {
+	return []server.Route{
+		{Method: "GET", Path: path + "/export/:subject", Authorized: true, Scope: "read", Handler: m.ExportHandler,
+			Summary: "export personal data of user", Response: Bundle{}},
+		{Method: "POST", Path: path + "/erasures", Authorized: true, Scope: "write", Handler: m.RequestErasureHandler,
+			Summary: "request erasure of personal data", Request: ErasureRequest{}, Response: Erasure{}},
+		{Method: "GET", Path: path + "/erasures", Authorized: true, Scope: "read", Handler: m.ListErasuresHandler,
+			Summary: "list erasure requests", Response: []Erasure{}},
+		{Method: "GET", Path: path + "/erasures/:id", Authorized: true, Scope: "read", Handler: m.GetErasureHandler,
+			Summary: "get erasure request", Response: Erasure{}},
+		{Method: "POST", Path: path + "/erasures/:id/confirm", Authorized: true, Scope: "write", Handler: m.ConfirmErasureHandler,
+			Summary: "confirm erasure request", Request: ConfirmRequest{}, Response: Erasure{}},
+		{Method: "POST", Path: path + "/erasures/:id/cancel", Authorized: true, Scope: "write", Handler: m.CancelErasureHandler,
+			Summary: "cancel erasure request", Response: Erasure{}},
+	}
+}
@@ -0,0 +1,461 @@
+package privacy
+
+// privacy module provides export and erasure of personal data of users
+// (data subjects) as required by data protection regulations, e.g. GDPR.
+// Export provides all records and audit entries of the subject as JSON
+// bundle. Erasure is two-step workflow, erasure request should be
+// confirmed by another user before records are deleted or anonymized, and
+// every step is recorded in audit trail.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	audit "github.com/CHESSComputing/golib/audit"
+	authz "github.com/CHESSComputing/golib/authz"
+	srvConfig "github.com/CHESSComputing/golib/config"
+	consent "github.com/CHESSComputing/golib/consent"
+	searches "github.com/CHESSComputing/golib/searches"
+	storage "github.com/CHESSComputing/golib/storage"
+	users "github.com/CHESSComputing/golib/users"
+	utils "github.com/CHESSComputing/golib/utils"
+	workflow "github.com/CHESSComputing/golib/workflow"
+	"github.com/google/uuid"
+)
+
+// erasure actions of sources
+const (
+	ActionDelete    = "delete"
+	ActionAnonymize = "anonymize"
+	ActionRetain    = "retain"
+)
+
+// statuses of erasure requests
+const (
+	StatusPending   = "pending"
+	StatusCompleted = "completed"
+	StatusCancelled = "cancelled"
+	StatusExpired   = "expired"
+)
+
+// audit actions of privacy module
+const (
+	AuditExport          = "privacy.export"
+	AuditErasureRequest  = "privacy.erasure.request"
+	AuditErasureConfirm  = "privacy.erasure.confirm"
+	AuditErasureCancel   = "privacy.erasure.cancel"
+	AuditErasureComplete = "privacy.erasure.complete"
+)
+
+// defaults of privacy module
+var (
+	DefaultCollection = "erasures"
+	DefaultConfirmTTL = 7 * 24 * time.Hour
+	DefaultAnonymous  = "anonymized"
+)
+
+// errors of privacy module
+var (
+	ErrInvalid  = errors.New("invalid privacy request")
+	ErrNotFound = errors.New("erasure request not found")
+	ErrState    = errors.New("erasure request is not pending")
+	ErrExpired  = errors.New("erasure request is expired")
+)
+
+// Source represents collection of personal data of users
+type Source struct {
+	Name          string        // name of the source in exported bundles
+	Store         storage.Store // store of the collection
+	Collection    string        // collection of records
+	SubjectKeys   []string      // record keys which hold user name
+	Action        string        // erasure action: delete, anonymize or retain
+	AnonymizeKeys []string      // keys replaced by anonymize action, default SubjectKeys
+	ExcludeKeys   []string      // keys omitted from exported records
+}
+
+// helper function to provide spec of records of the subject
+func (s Source) spec(subject string) map[string]any {
+	if len(s.SubjectKeys) == 1 {
+		return map[string]any{s.SubjectKeys[0]: subject}
+	}
+	var or []map[string]any
+	for _, key := range s.SubjectKeys {
+		or = append(or, map[string]any{key: subject})
+	}
+	return map[string]any{"$or": or}
+}
+
+// Bundle represents exported personal data of the subject
+type Bundle struct {
+	Subject string                      `json:"subject"`
+	Created int64                       `json:"created"`
+	Records map[string][]map[string]any `json:"records"` // records of every source
+	Audit   []audit.Record              `json:"audit"`   // audit entries of the subject
+}
+
+// Erasure represents erasure request of personal data of the subject
+type Erasure struct {
+	ID          string           `json:"id"`
+	Subject     string           `json:"subject"`
+	Reason      string           `json:"reason,omitempty"`
+	Status      string           `json:"status"`
+	RequestedBy string           `json:"requested_by"`
+	ConfirmedBy string           `json:"confirmed_by,omitempty"`
+	Created     int64            `json:"created"`
+	Expires     int64            `json:"expires"`
+	Updated     int64            `json:"updated"`
+	Results     map[string]int64 `json:"results,omitempty"` // number of erased records of every source
+}
+
+// helper function to convert erasure request into storage record
+func (e Erasure) record() map[string]any {
+	results := make(map[string]any, len(e.Results))
+	for k, v := range e.Results {
+		results[k] = v
+	}
+	return map[string]any{
+		"_id":          e.ID,
+		"subject":      e.Subject,
+		"reason":       e.Reason,
+		"status":       e.Status,
+		"requested_by": e.RequestedBy,
+		"confirmed_by": e.ConfirmedBy,
+		"created":      e.Created,
+		"expires":      e.Expires,
+		"updated":      e.Updated,
+		"results":      results,
+	}
+}
+
+// helper function to convert storage record into erasure request
+func erasureRecord(rec map[string]any) Erasure {
+	e := Erasure{}
+	e.ID, _ = rec["_id"].(string)
+	e.Subject, _ = rec["subject"].(string)
+	e.Reason, _ = rec["reason"].(string)
+	e.Status, _ = rec["status"].(string)
+	e.RequestedBy, _ = rec["requested_by"].(string)
+	e.ConfirmedBy, _ = rec["confirmed_by"].(string)
+	e.Created = utils.ToInt64(rec["created"])
+	e.Expires = utils.ToInt64(rec["expires"])
+	e.Updated = utils.ToInt64(rec["updated"])
+	if results, ok := rec["results"].(map[string]any); ok && len(results) > 0 {
+		e.Results = make(map[string]int64, len(results))
+		for k, v := range results {
+			e.Results[k] = utils.ToInt64(v)
+		}
+	}
+	return e
+}
+
+// Manager represents personal data export and erasure
+type Manager struct {
+	Store      storage.Store // store of erasure requests
+	Collection string        // collection of erasure requests
+	Sources    []Source      // sources of personal data
+	Audit      *audit.Logger // audit trail, optional
+	ConfirmTTL time.Duration // validity of unconfirmed erasure requests
+	Anonymous  string        // replacement of user name by anonymize action
+}
+
+// Privacy represents global privacy manager, it should be initialized via
+// Init function
+var Privacy *Manager
+
+// NewManager returns privacy manager of given sources
+func NewManager(store storage.Store, sources []Source, cfg srvConfig.Privacy) *Manager {
+	m := &Manager{
+		Store:      store,
+		Collection: cfg.Collection,
+		Sources:    sources,
+		ConfirmTTL: cfg.ConfirmTTL,
+		Anonymous:  cfg.Anonymous,
+	}
+	if m.Collection == "" {
+		m.Collection = DefaultCollection
+	}
+	if m.ConfirmTTL == 0 {
+		m.ConfirmTTL = DefaultConfirmTTL
+	}
+	if m.Anonymous == "" {
+		m.Anonymous = DefaultAnonymous
+	}
+	return m
+}
+
+// DefaultSources returns default sources of personal data: user accounts,
+// one-time tokens, API keys, linked identities, saved searches and policy
+// acceptances of given database and metadata records of given database and collection
+func DefaultSources(dbname, metaDB, metaColl string) []srvConfig.PrivacySource {
+	sources := []srvConfig.PrivacySource{
+		{Name: "users", DBName: dbname, Collection: users.UsersCollection, SubjectKeys: []string{"_id"},
+			Action: ActionDelete, ExcludeKeys: []string{"password_hash", "password_history", "totp_secret", "recovery_codes", "credentials"}},
+		{Name: "tokens", DBName: dbname, Collection: users.TokensCollection, SubjectKeys: []string{"user"},
+			Action: ActionDelete, ExcludeKeys: []string{"_id", "session"}},
+		{Name: "api_keys", DBName: dbname, Collection: authz.APIKeysCollection, SubjectKeys: []string{"user"},
+			Action: ActionDelete, ExcludeKeys: []string{"hash"}},
+		{Name: "identities", DBName: dbname, Collection: authz.IdentitiesCollection, SubjectKeys: []string{"user"},
+			Action: ActionDelete},
+		{Name: "searches", DBName: dbname, Collection: searches.DefaultCollection, SubjectKeys: []string{"owner"},
+			Action: ActionDelete},
+		{Name: "consents", DBName: dbname, Collection: consent.DefaultCollection, SubjectKeys: []string{"user"},
+			Action: ActionAnonymize, AnonymizeKeys: []string{"user", "ip", "user_agent"}},
+	}
+	if metaDB != "" && metaColl != "" {
+		sources = append(sources, srvConfig.PrivacySource{Name: "metadata", DBName: metaDB, Collection: metaColl,
+			SubjectKeys: []string{workflow.DefaultOwnerKey}, Action: ActionAnonymize})
+	}
+	return sources
+}
+
+// Init initializes global privacy manager of Privacy configuration, audit
+// trail is provided by global audit logger
+func Init() error {
+	if srvConfig.Config == nil {
+		return errors.New("server configuration is not initialized")
+	}
+	cfg := srvConfig.Config.Privacy
+	meta := srvConfig.Config.CHESSMetaData
+	dbname := cfg.DBName
+	if dbname == "" {
+		dbname = meta.DBName
+	}
+	if dbname == "" {
+		return errors.New("privacy database is not configured")
+	}
+	configured := cfg.Sources
+	if len(configured) == 0 {
+		configured = DefaultSources(dbname, meta.DBName, meta.DBColl)
+	}
+	var sources []Source
+	for _, src := range configured {
+		db := src.DBName
+		if db == "" {
+			db = dbname
+		}
+		sources = append(sources, Source{
+			Name:          src.Name,
+			Store:         storage.NewStore(db),
+			Collection:    src.Collection,
+			SubjectKeys:   src.SubjectKeys,
+			Action:        src.Action,
+			AnonymizeKeys: src.AnonymizeKeys,
+			ExcludeKeys:   src.ExcludeKeys,
+		})
+	}
+	Privacy = NewManager(storage.NewStore(dbname), sources, cfg)
+	Privacy.Audit = audit.AuditLogger
+	return nil
+}
+
+// helper function to record audit entry of privacy action of the actor
+func (m *Manager) record(ctx context.Context, actor, action, resource string, details map[string]any) error {
+	if m.Audit == nil {
+		return nil
+	}
+	return m.Audit.Record(ctx, audit.Record{Subject: actor, Action: action, Resource: resource, Details: details})
+}
+
+// Export returns personal data of the subject, i.e. records of all
+// sources and audit entries of the subject
+func (m *Manager) Export(ctx context.Context, actor, subject string) (Bundle, error) {
+	if subject == "" {
+		return Bundle{}, fmt.Errorf("%w: subject is required", ErrInvalid)
+	}
+	bundle := Bundle{Subject: subject, Created: time.Now().Unix(), Records: make(map[string][]map[string]any)}
+	for _, src := range m.Sources {
+		records, err := src.Store.Find(ctx, src.Collection, src.spec(subject), nil)
+		if err != nil {
+			log.Printf("ERROR: unable to export %s records of %s, error %v", src.Name, subject, err)
+			return Bundle{}, err
+		}
+		for _, rec := range records {
+			for _, key := range src.ExcludeKeys {
+				delete(rec, key)
+			}
+		}
+		if records == nil {
+			records = []map[string]any{}
+		}
+		bundle.Records[src.Name] = records
+	}
+	if m.Audit != nil {
+		entries, err := m.Audit.Query(ctx, audit.Query{Subject: subject})
+		if err != nil {
+			log.Printf("ERROR: unable to export audit entries of %s, error %v", subject, err)
+			return Bundle{}, err
+		}
+		bundle.Audit = entries
+	}
+	if bundle.Audit == nil {
+		bundle.Audit = []audit.Record{}
+	}
+	err := m.record(ctx, actor, AuditExport, "privacy/export/"+subject, nil)
+	return bundle, err
+}
+
+// RequestErasure creates erasure request of personal data of the subject,
+// it should be confirmed via ConfirmErasure within confirmation TTL
+func (m *Manager) RequestErasure(ctx context.Context, actor, subject, reason string) (Erasure, error) {
+	if subject == "" {
+		return Erasure{}, fmt.Errorf("%w: subject is required", ErrInvalid)
+	}
+	now := time.Now()
+	e := Erasure{
+		ID:          uuid.NewString(),
+		Subject:     subject,
+		Reason:      reason,
+		Status:      StatusPending,
+		RequestedBy: actor,
+		Created:     now.Unix(),
+		Expires:     now.Add(m.ConfirmTTL).Unix(),
+		Updated:     now.Unix(),
+	}
+	if err := m.Store.Insert(ctx, m.Collection, e.record()); err != nil {
+		log.Printf("ERROR: unable to create erasure request of %s, error %v", subject, err)
+		return Erasure{}, err
+	}
+	err := m.record(ctx, actor, AuditErasureRequest, "privacy/erasure/"+e.ID, map[string]any{"subject": subject, "reason": reason})
+	return e, err
+}
+
+// GetErasure returns erasure request of given id
+func (m *Manager) GetErasure(ctx context.Context, id string) (Erasure, error) {
+	rec, err := storage.FindOne(ctx, m.Store, m.Collection, map[string]any{"_id": id})
+	if errors.Is(err, storage.ErrNotFound) {
+		return Erasure{}, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	if err != nil {
+		return Erasure{}, err
+	}
+	return erasureRecord(rec), nil
+}
+
+// ListErasures returns erasure requests of given status, all requests are
+// returned if status is empty
+func (m *Manager) ListErasures(ctx context.Context, status string) ([]Erasure, error) {
+	spec := make(map[string]any)
+	if status != "" {
+		spec["status"] = status
+	}
+	records, err := m.Store.Find(ctx, m.Collection, spec, &storage.FindOptions{Sort: []string{"-created"}})
+	if err != nil {
+		return nil, err
+	}
+	var out []Erasure
+	for _, rec := range records {
+		out = append(out, erasureRecord(rec))
+	}
+	return out, nil
+}
+
+// helper function to update erasure request
+func (m *Manager) update(ctx context.Context, e *Erasure) error {
+	e.Updated = time.Now().Unix()
+	rec := e.record()
+	delete(rec, "_id")
+	_, err := m.Store.Update(ctx, m.Collection, map[string]any{"_id": e.ID}, rec)
+	return err
+}
+
+// helper function to get pending erasure request, expired requests are
+// marked as expired
+func (m *Manager) pending(ctx context.Context, id string) (Erasure, error) {
+	e, err := m.GetErasure(ctx, id)
+	if err != nil {
+		return e, err
+	}
+	if e.Status != StatusPending {
+		return e, fmt.Errorf("%w: %s is %s", ErrState, id, e.Status)
+	}
+	if e.Expires > 0 && time.Now().Unix() > e.Expires {
+		e.Status = StatusExpired
+		if err := m.update(ctx, &e); err != nil {
+			return e, err
+		}
+		return e, fmt.Errorf("%w: %s", ErrExpired, id)
+	}
+	return e, nil
+}
+
+// CancelErasure cancels pending erasure request
+func (m *Manager) CancelErasure(ctx context.Context, actor, id string) (Erasure, error) {
+	e, err := m.pending(ctx, id)
+	if err != nil {
+		return e, err
+	}
+	e.Status = StatusCancelled
+	if err := m.update(ctx, &e); err != nil {
+		return e, err
+	}
+	err = m.record(ctx, actor, AuditErasureCancel, "privacy/erasure/"+e.ID, map[string]any{"subject": e.Subject})
+	return e, err
+}
+
+// ConfirmErasure confirms erasure request and erases personal data of the
+// subject. Subject should be repeated by confirming user, who should not
+// be the requester of the erasure. Records of sources are deleted or their
+// subject keys are anonymized, failed erasure may be confirmed again.
+func (m *Manager) ConfirmErasure(ctx context.Context, actor, id, subject string) (Erasure, error) {
+	e, err := m.pending(ctx, id)
+	if err != nil {
+		return e, err
+	}
+	if subject != e.Subject {
+		return e, fmt.Errorf("%w: confirmed subject does not match erasure request", ErrInvalid)
+	}
+	if actor == e.RequestedBy {
+		return e, fmt.Errorf("%w: erasure request should be confirmed by another user", ErrInvalid)
+	}
+	e.ConfirmedBy = actor
+	if err := m.record(ctx, actor, AuditErasureConfirm, "privacy/erasure/"+e.ID, map[string]any{"subject": e.Subject}); err != nil {
+		return e, err
+	}
+	if e.Results == nil {
+		e.Results = make(map[string]int64)
+	}
+	for _, src := range m.Sources {
+		n, err := m.erase(ctx, src, e.Subject)
+		e.Results[src.Name] += n
+		if err != nil {
+			log.Printf("ERROR: unable to erase %s records of %s, error %v", src.Name, e.Subject, err)
+			if uerr := m.update(ctx, &e); uerr != nil {
+				log.Printf("ERROR: unable to update erasure request %s, error %v", e.ID, uerr)
+			}
+			return e, err
+		}
+	}
+	e.Status = StatusCompleted
+	if err := m.update(ctx, &e); err != nil {
+		return e, err
+	}
+	details := map[string]any{"subject": e.Subject, "results": e.Results}
+	err = m.record(ctx, actor, AuditErasureComplete, "privacy/erasure/"+e.ID, details)
+	return e, err
+}
+
+// helper function to erase records of the subject in given source
+func (m *Manager) erase(ctx context.Context, src Source, subject string) (int64, error) {
+	switch src.Action {
+	case ActionRetain:
+		return 0, nil
+	case ActionAnonymize:
+		keys := src.AnonymizeKeys
+		if len(keys) == 0 {
+			keys = src.SubjectKeys
+		}
+		var total int64
+		for _, key := range keys {
+			n, err := src.Store.Update(ctx, src.Collection, map[string]any{key: subject}, map[string]any{key: m.Anonymous})
+			total += n
+			if err != nil {
+				return total, err
+			}
+		}
+		return total, nil
+	}
+	return src.Store.Remove(ctx, src.Collection, src.spec(subject))
+}
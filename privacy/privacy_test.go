@@ -0,0 +1,191 @@
+package privacy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	audit "github.com/CHESSComputing/golib/audit"
+	authz "github.com/CHESSComputing/golib/authz"
+	srvConfig "github.com/CHESSComputing/golib/config"
+	storage "github.com/CHESSComputing/golib/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// helper function to create privacy manager of memory store with personal
+// data of alice and bob
+func testManager(t *testing.T) (*Manager, storage.Store) {
+	store := storage.NewMemoryStore()
+	ctx := context.Background()
+	data := map[string][]map[string]any{
+		"users": {
+			{"_id": "alice", "email": "alice@example.com", "password_hash": "hash"},
+			{"_id": "bob", "email": "bob@example.com", "password_hash": "hash"},
+		},
+		"api_keys": {{"_id": "k1", "user": "alice", "hash": "hash"}},
+		"meta": {
+			{"_id": "r1", "user": "alice", "reviewer": "bob", "sample": "steel"},
+			{"_id": "r2", "user": "bob", "reviewer": "alice", "sample": "iron"},
+		},
+		"proposals": {{"_id": "p1", "pi": "alice"}},
+	}
+	for coll, records := range data {
+		if err := store.Insert(ctx, coll, records...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sources := []Source{
+		{Name: "users", Store: store, Collection: "users", SubjectKeys: []string{"_id"}, Action: ActionDelete, ExcludeKeys: []string{"password_hash"}},
+		{Name: "api_keys", Store: store, Collection: "api_keys", SubjectKeys: []string{"user"}, Action: ActionDelete, ExcludeKeys: []string{"hash"}},
+		{Name: "metadata", Store: store, Collection: "meta", SubjectKeys: []string{"user", "reviewer"}, Action: ActionAnonymize},
+		{Name: "proposals", Store: store, Collection: "proposals", SubjectKeys: []string{"pi"}, Action: ActionRetain},
+	}
+	m := NewManager(store, sources, srvConfig.Privacy{})
+	m.Audit = &audit.Logger{Store: audit.NewDocumentStore(store)}
+	m.Audit.Record(ctx, audit.Record{Subject: "alice", Action: "login", Resource: "session"})
+	return m, store
+}
+
+// TestExport
+func TestExport(t *testing.T) {
+	ctx := context.Background()
+	m, _ := testManager(t)
+	bundle, err := m.Export(ctx, "alice", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bundle.Records["users"]) != 1 || bundle.Records["users"][0]["password_hash"] != nil {
+		t.Errorf("wrong exported users %v", bundle.Records["users"])
+	}
+	if len(bundle.Records["api_keys"]) != 1 || len(bundle.Records["metadata"]) != 2 || len(bundle.Records["proposals"]) != 1 {
+		t.Errorf("wrong exported records %v", bundle.Records)
+	}
+	if len(bundle.Audit) != 1 || bundle.Audit[0].Action != "login" {
+		t.Errorf("wrong exported audit entries %v", bundle.Audit)
+	}
+	if records, _ := m.Audit.Query(ctx, audit.Query{Action: AuditExport}); len(records) != 1 {
+		t.Errorf("export is not audited %v", records)
+	}
+	if b, _ := m.Export(ctx, "dpo", "carol"); len(b.Records["users"]) != 0 || b.Records["users"] == nil {
+		t.Errorf("wrong export of unknown user %v", b.Records)
+	}
+	if _, err := m.Export(ctx, "alice", ""); !errors.Is(err, ErrInvalid) {
+		t.Errorf("export without subject, error %v", err)
+	}
+}
+
+// TestErasure
+func TestErasure(t *testing.T) {
+	ctx := context.Background()
+	m, store := testManager(t)
+	e, err := m.RequestErasure(ctx, "alice", "alice", "leaving")
+	if err != nil || e.Status != StatusPending {
+		t.Fatalf("wrong erasure request %+v, error %v", e, err)
+	}
+	if _, err := m.ConfirmErasure(ctx, "dpo", e.ID, "bob"); !errors.Is(err, ErrInvalid) {
+		t.Errorf("erasure of wrong subject is confirmed, error %v", err)
+	}
+	if _, err := m.ConfirmErasure(ctx, "alice", e.ID, "alice"); !errors.Is(err, ErrInvalid) {
+		t.Errorf("erasure is confirmed by requester, error %v", err)
+	}
+	e, err = m.ConfirmErasure(ctx, "dpo", e.ID, "alice")
+	if err != nil || e.Status != StatusCompleted || e.ConfirmedBy != "dpo" {
+		t.Fatalf("wrong confirmed erasure %+v, error %v", e, err)
+	}
+	if e.Results["users"] != 1 || e.Results["api_keys"] != 1 || e.Results["metadata"] != 2 || e.Results["proposals"] != 0 {
+		t.Errorf("wrong erasure results %v", e.Results)
+	}
+	if n, _ := store.Count(ctx, "users", map[string]any{}); n != 1 {
+		t.Errorf("user is not deleted")
+	}
+	r1, _ := storage.FindOne(ctx, store, "meta", map[string]any{"_id": "r1"})
+	r2, _ := storage.FindOne(ctx, store, "meta", map[string]any{"_id": "r2"})
+	if r1["user"] != DefaultAnonymous || r1["reviewer"] != "bob" || r2["reviewer"] != DefaultAnonymous || r2["sample"] != "iron" {
+		t.Errorf("wrong anonymized records %v %v", r1, r2)
+	}
+	if n, _ := store.Count(ctx, "proposals", map[string]any{"pi": "alice"}); n != 1 {
+		t.Errorf("retained record is erased")
+	}
+	if stored, _ := m.GetErasure(ctx, e.ID); stored.Status != StatusCompleted || stored.Results["metadata"] != 2 {
+		t.Errorf("wrong stored erasure %+v", stored)
+	}
+	if _, err := m.ConfirmErasure(ctx, "dpo", e.ID, "alice"); !errors.Is(err, ErrState) {
+		t.Errorf("completed erasure is confirmed again, error %v", err)
+	}
+	for _, action := range []string{AuditErasureRequest, AuditErasureConfirm, AuditErasureComplete} {
+		if records, _ := m.Audit.Query(ctx, audit.Query{Action: action}); len(records) != 1 {
+			t.Errorf("%s is not audited %v", action, records)
+		}
+	}
+
+	// cancelled and expired requests can not be confirmed
+	e, _ = m.RequestErasure(ctx, "bob", "bob", "")
+	if e, err := m.CancelErasure(ctx, "bob", e.ID); err != nil || e.Status != StatusCancelled {
+		t.Errorf("wrong cancelled erasure %+v, error %v", e, err)
+	}
+	if _, err := m.ConfirmErasure(ctx, "dpo", e.ID, "bob"); !errors.Is(err, ErrState) {
+		t.Errorf("cancelled erasure is confirmed, error %v", err)
+	}
+	m.ConfirmTTL = -time.Second
+	e, _ = m.RequestErasure(ctx, "bob", "bob", "")
+	if _, err := m.ConfirmErasure(ctx, "dpo", e.ID, "bob"); !errors.Is(err, ErrExpired) {
+		t.Errorf("expired erasure is confirmed, error %v", err)
+	}
+	if e, _ := m.GetErasure(ctx, e.ID); e.Status != StatusExpired {
+		t.Errorf("wrong status of expired erasure %s", e.Status)
+	}
+	if list, _ := m.ListErasures(ctx, ""); len(list) != 3 {
+		t.Errorf("wrong erasure requests %+v", list)
+	}
+}
+
+// TestHandlers
+func TestHandlers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	m, _ := testManager(t)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		claims := &authz.Claims{CustomClaims: authz.CustomClaims{User: c.GetHeader("X-User"), Roles: c.Request.Header.Values("X-Role")}}
+		c.Set("claims", claims)
+	})
+	for _, route := range m.Routes("/privacy") {
+		r.Handle(route.Method, route.Path, route.Handler)
+	}
+	e, _ := m.RequestErasure(context.Background(), "alice", "alice", "")
+	expect := []struct {
+		method, target, user, role, body string
+		code                             int
+		resp                             string
+	}{
+		{"GET", "/privacy/export/alice", "alice", "", "", http.StatusOK, `"subject":"alice"`},
+		{"GET", "/privacy/export/alice", "bob", "", "", http.StatusForbidden, ""},
+		{"GET", "/privacy/export/alice", "carol", "dpo", "", http.StatusOK, `"email":"alice@example.com"`},
+		{"POST", "/privacy/erasures", "bob", "", `{"reason": "leaving"}`, http.StatusCreated, `"subject":"bob"`},
+		{"POST", "/privacy/erasures", "bob", "", `{"subject": "alice"}`, http.StatusForbidden, ""},
+		{"GET", "/privacy/erasures", "bob", "", "", http.StatusForbidden, ""},
+		{"GET", "/privacy/erasures", "carol", "dpo", "", http.StatusOK, `"requested_by":"bob"`},
+		{"GET", "/privacy/erasures/" + e.ID, "alice", "", "", http.StatusOK, `"status":"pending"`},
+		{"GET", "/privacy/erasures/" + e.ID, "bob", "", "", http.StatusForbidden, ""},
+		{"POST", "/privacy/erasures/" + e.ID + "/confirm", "alice", "", `{"subject": "alice"}`, http.StatusForbidden, ""},
+		{"POST", "/privacy/erasures/" + e.ID + "/confirm", "carol", "dpo", `{"subject": "bob"}`, http.StatusBadRequest, ""},
+		{"POST", "/privacy/erasures/" + e.ID + "/confirm", "carol", "dpo", `{"subject": "alice"}`, http.StatusOK, `"status":"completed"`},
+		{"POST", "/privacy/erasures/" + e.ID + "/cancel", "carol", "dpo", "", http.StatusConflict, ""},
+		{"GET", "/privacy/erasures/unknown", "carol", "dpo", "", http.StatusNotFound, ""},
+	}
+	for _, e := range expect {
+		req := httptest.NewRequest(e.method, e.target, strings.NewReader(e.body))
+		req.Header.Set("X-User", e.user)
+		if e.role != "" {
+			req.Header.Set("X-Role", e.role)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != e.code || !strings.Contains(w.Body.String(), e.resp) {
+			t.Errorf("%s %s by %s: expect %d %s, got %d %s", e.method, e.target, e.user, e.code, e.resp, w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package proposals
+
+// handlers module provides HTTP endpoint which resolves proposals
+
+import (
+	"errors"
+	"net/http"
+
+	server "github.com/CHESSComputing/golib/server"
+	services "github.com/CHESSComputing/golib/services"
+	"github.com/gin-gonic/gin"
+)
+
+// helper function to abort request with service error
+func abort(c *gin.Context, code, srvCode int, err error) {
+	rec := services.Response("proposals", code, srvCode, err)
+	c.AbortWithStatusJSON(code, rec)
+}
+
+// GetHandler resolves proposal given by number parameter
+func (c *Client) GetHandler(ctx *gin.Context) {
+	p, err := c.Get(ctx.Request.Context(), ctx.Param("number"))
+	switch {
+	case errors.Is(err, ErrNotFound):
+		abort(ctx, http.StatusNotFound, services.QueryError, err)
+	case err != nil:
+		abort(ctx, http.StatusBadGateway, services.ServiceError, err)
+	default:
+		ctx.JSON(http.StatusOK, p)
+	}
+}
+
+// Routes returns server routes of proposals under given path, e.g.
+// /proposals
+func (c *Client) Routes(path string) []server.Route {
+	return []server.Route{
+		{Method: "GET", Path: path + "/:number", Authorized: true, Scope: "read", Handler: c.GetHandler,
+			Summary: "resolve proposal number", Response: Proposal{}},
+	}
+}
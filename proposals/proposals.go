@@ -0,0 +1,305 @@
+package proposals
+
+// proposals module provides client of facility proposal system (BTR). It
+// resolves proposal numbers to proposal titles, principal investigators
+// and team members, caches resolved proposals and validates that metadata
+// records reference existing proposals.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+)
+
+// defaults of proposal client
+var (
+	DefaultPath        = "/proposals/{id}"
+	DefaultProposalKey = "proposal"
+	DefaultCacheTTL    = time.Hour
+	DefaultTimeout     = 10 * time.Second
+	NegativeCacheTTL   = 5 * time.Minute // time to keep unknown proposal numbers
+)
+
+// errors of proposals module
+var (
+	ErrNotFound    = errors.New("proposal not found")
+	ErrInvalid     = errors.New("invalid proposal reference")
+	ErrUnavailable = errors.New("proposal system is unavailable")
+)
+
+// Person represents member of proposal team
+type Person struct {
+	Name     string `json:"name"`
+	Email    string `json:"email,omitempty"`
+	Username string `json:"username,omitempty"`
+	ORCID    string `json:"orcid,omitempty"`
+}
+
+// Proposal represents proposal (beamtime request) of the facility
+type Proposal struct {
+	Number   string   `json:"number"`
+	Title    string   `json:"title"`
+	PI       Person   `json:"pi"`
+	Members  []Person `json:"members,omitempty"`
+	Beamline string   `json:"beamline,omitempty"`
+	Cycle    string   `json:"cycle,omitempty"`
+}
+
+// Usernames returns user names of PI and team members, e.g. to create
+// groups of proposal teams
+func (p Proposal) Usernames() []string {
+	var out []string
+	for _, person := range append([]Person{p.PI}, p.Members...) {
+		if person.Username != "" {
+			out = append(out, person.Username)
+		}
+	}
+	return out
+}
+
+// cacheEntry represents cached proposal, nil proposal represents unknown
+// proposal number
+type cacheEntry struct {
+	proposal *Proposal
+	expires  time.Time
+}
+
+// Client represents client of proposal system
+type Client struct {
+	URL         string        // base URL of proposal system API
+	Path        string        // path of proposal API, {id} is replaced by proposal number
+	Username    string        // user name of basic authentication
+	Password    string        // password of basic authentication
+	Token       string        // bearer token
+	CacheTTL    time.Duration // time to keep resolved proposals
+	ProposalKey string        // record key of proposal number
+	Required    bool          // records without proposal number are invalid
+	FailOpen    bool          // records are valid when proposal system is unavailable
+	HttpClient  *http.Client  // HTTP client
+	Verbose     int           // verbosity level
+
+	mu    sync.Mutex
+	cache map[string]cacheEntry
+}
+
+// Proposals represents global proposal client, it should be initialized
+// via Init function
+var Proposals *Client
+
+// NewClient returns proposal client of given configuration
+func NewClient(cfg srvConfig.Proposals) *Client {
+	c := &Client{
+		URL:         strings.TrimSuffix(cfg.URL, "/"),
+		Path:        cfg.Path,
+		Username:    cfg.Username,
+		Password:    cfg.Password,
+		Token:       cfg.Token,
+		CacheTTL:    cfg.CacheTTL,
+		ProposalKey: cfg.ProposalKey,
+		Required:    cfg.Required,
+		FailOpen:    cfg.FailOpen,
+		cache:       make(map[string]cacheEntry),
+	}
+	if c.Path == "" {
+		c.Path = DefaultPath
+	}
+	if c.CacheTTL == 0 {
+		c.CacheTTL = DefaultCacheTTL
+	}
+	if c.ProposalKey == "" {
+		c.ProposalKey = DefaultProposalKey
+	}
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	c.HttpClient = &http.Client{Timeout: timeout}
+	return c
+}
+
+// Init initializes global proposal client of Proposals configuration
+func Init() error {
+	if srvConfig.Config == nil {
+		return errors.New("server configuration is not initialized")
+	}
+	cfg := srvConfig.Config.Proposals
+	if cfg.URL == "" {
+		return errors.New("proposal system URL is not configured")
+	}
+	Proposals = NewClient(cfg)
+	return nil
+}
+
+// Number returns proposal number of record value, numeric values are
+// converted to integer strings
+func Number(val any) string {
+	switch v := val.(type) {
+	case string:
+		return strings.TrimSpace(v)
+	case int:
+		return strconv.Itoa(v)
+	case int32:
+		return strconv.FormatInt(int64(v), 10)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case json.Number:
+		return v.String()
+	}
+	return ""
+}
+
+// helper function to get proposal from the cache
+func (c *Client) cached(number string) (cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.cache[number]
+	if !ok || time.Now().After(entry.expires) {
+		delete(c.cache, number)
+		return entry, false
+	}
+	return entry, true
+}
+
+// helper function to put proposal into the cache
+func (c *Client) store(number string, p *Proposal) {
+	ttl := c.CacheTTL
+	if p == nil && NegativeCacheTTL < ttl {
+		ttl = NegativeCacheTTL
+	}
+	if ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cache == nil {
+		c.cache = make(map[string]cacheEntry)
+	}
+	c.cache[number] = cacheEntry{proposal: p, expires: time.Now().Add(ttl)}
+}
+
+// proposalRecord represents proposal of proposal system API, numbers may
+// be given either as strings or numbers
+type proposalRecord struct {
+	Proposal
+	Number any `json:"number"`
+}
+
+// helper function to fetch proposal from proposal system
+func (c *Client) fetch(ctx context.Context, number string) (Proposal, error) {
+	rurl := c.URL + strings.ReplaceAll(c.Path, "{id}", url.PathEscape(number))
+	req, err := http.NewRequestWithContext(ctx, "GET", rurl, nil)
+	if err != nil {
+		return Proposal{}, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	} else if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+	client := c.HttpClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if c.Verbose > 0 {
+		log.Println("proposals: fetch", rurl)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return Proposal{}, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return Proposal{}, fmt.Errorf("%w: %s", ErrNotFound, number)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Proposal{}, fmt.Errorf("%w: proposal %s, status %s", ErrUnavailable, number, resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Proposal{}, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	var rec proposalRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return Proposal{}, fmt.Errorf("unable to parse proposal %s, error %v", number, err)
+	}
+	p := rec.Proposal
+	p.Number = Number(rec.Number)
+	if p.Number == "" {
+		p.Number = number
+	}
+	return p, nil
+}
+
+// Get resolves proposal number to proposal, resolved and unknown proposal
+// numbers are cached
+func (c *Client) Get(ctx context.Context, number string) (Proposal, error) {
+	number = strings.TrimSpace(number)
+	if number == "" {
+		return Proposal{}, fmt.Errorf("%w: empty proposal number", ErrNotFound)
+	}
+	if entry, ok := c.cached(number); ok {
+		if entry.proposal == nil {
+			return Proposal{}, fmt.Errorf("%w: %s", ErrNotFound, number)
+		}
+		return *entry.proposal, nil
+	}
+	p, err := c.fetch(ctx, number)
+	if errors.Is(err, ErrNotFound) {
+		c.store(number, nil)
+		return p, err
+	}
+	if err != nil {
+		log.Printf("ERROR: unable to resolve proposal %s, error %v", number, err)
+		return p, err
+	}
+	c.store(number, &p)
+	return p, nil
+}
+
+// ValidateNumber checks that proposal number refers to existing proposal.
+// Proposal system failures are ignored if client fails open.
+func (c *Client) ValidateNumber(ctx context.Context, number string) error {
+	if number == "" {
+		if c.Required {
+			return fmt.Errorf("%w, record has no proposal number", ErrInvalid)
+		}
+		return nil
+	}
+	_, err := c.Get(ctx, number)
+	if errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("%w, unknown proposal %s", ErrInvalid, number)
+	}
+	if err != nil {
+		if c.FailOpen {
+			log.Printf("WARNING: proposal %s is not validated, error %v", number, err)
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// Validate checks that record references existing proposal via
+// ProposalKey, e.g. at insert time
+func (c *Client) Validate(ctx context.Context, rec map[string]any) error {
+	val, ok := rec[c.ProposalKey]
+	number := Number(val)
+	if ok && val != nil && number == "" {
+		return fmt.Errorf("%w, proposal number %v of unsupported type %T", ErrInvalid, val, val)
+	}
+	return c.ValidateNumber(ctx, number)
+}
@@ -0,0 +1,133 @@
+package proposals
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	storage "github.com/CHESSComputing/golib/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// helper function to start fake proposal system
+func testServer(t *testing.T, calls *int) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*calls++
+		if user, pass, ok := r.BasicAuth(); !ok || user != "foxden" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/btr/1234":
+			fmt.Fprint(w, `{"number": 1234, "title": "Strain mapping", "beamline": "id3a",
+"pi": {"name": "Alice Doe", "username": "alice"}, "members": [{"name": "Bob Roe", "username": "bob"}]}`)
+		case "/api/btr/9999":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// TestClient
+func TestClient(t *testing.T) {
+	var calls int
+	server := testServer(t, &calls)
+	c := NewClient(srvConfig.Proposals{URL: server.URL, Path: "/api/btr/{id}", Username: "foxden", Password: "secret"})
+	ctx := context.Background()
+	p, err := c.Get(ctx, "1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Number != "1234" || p.Title != "Strain mapping" || p.PI.Name != "Alice Doe" || len(p.Members) != 1 {
+		t.Errorf("wrong proposal %+v", p)
+	}
+	if users := p.Usernames(); len(users) != 2 || users[0] != "alice" || users[1] != "bob" {
+		t.Errorf("wrong user names %v", users)
+	}
+
+	// resolved and unknown proposals are cached
+	c.Get(ctx, "1234")
+	if _, err := c.Get(ctx, "42"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unknown proposal is found, error %v", err)
+	}
+	c.Get(ctx, "42")
+	if calls != 2 {
+		t.Errorf("expect 2 calls of proposal system, got %d", calls)
+	}
+	if _, err := c.Get(ctx, "9999"); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("failure of proposal system is not reported, error %v", err)
+	}
+}
+
+// TestValidate
+func TestValidate(t *testing.T) {
+	var calls int
+	server := testServer(t, &calls)
+	c := NewClient(srvConfig.Proposals{URL: server.URL, Path: "/api/btr/{id}", Username: "foxden", Password: "secret"})
+	ctx := context.Background()
+	expect := []struct {
+		rec map[string]any
+		err error
+	}{
+		{map[string]any{"proposal": 1234.0}, nil},
+		{map[string]any{"proposal": "1234"}, nil},
+		{map[string]any{}, nil},
+		{map[string]any{"proposal": "42"}, ErrInvalid},
+		{map[string]any{"proposal": []string{"1234"}}, ErrInvalid},
+		{map[string]any{"proposal": "9999"}, ErrUnavailable},
+	}
+	for _, e := range expect {
+		if err := c.Validate(ctx, e.rec); !errors.Is(err, e.err) {
+			t.Errorf("record %v: expect error %v, got %v", e.rec, e.err, err)
+		}
+	}
+	c.Required = true
+	c.FailOpen = true
+	if err := c.Validate(ctx, map[string]any{}); !errors.Is(err, ErrInvalid) {
+		t.Errorf("record without proposal is accepted, error %v", err)
+	}
+	if err := c.Validate(ctx, map[string]any{"proposal": "9999"}); err != nil {
+		t.Errorf("record is rejected by unavailable proposal system, error %v", err)
+	}
+
+	// validating store
+	store := NewStore(storage.NewMemoryStore(), c)
+	if err := store.Insert(ctx, "meta", map[string]any{"_id": "a", "proposal": "1234"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Insert(ctx, "meta", map[string]any{"_id": "b", "proposal": "42"}); !errors.Is(err, ErrInvalid) {
+		t.Errorf("record of unknown proposal is inserted, error %v", err)
+	}
+	if _, err := store.Update(ctx, "meta", map[string]any{"_id": "a"}, map[string]any{"proposal": "42"}); !errors.Is(err, ErrInvalid) {
+		t.Errorf("record is updated with unknown proposal, error %v", err)
+	}
+	if n, err := store.Update(ctx, "meta", map[string]any{"_id": "a"}, map[string]any{"title": "scan"}); err != nil || n != 1 {
+		t.Errorf("record is not updated, error %v", err)
+	}
+}
+
+// TestGetHandler
+func TestGetHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var calls int
+	server := testServer(t, &calls)
+	c := NewClient(srvConfig.Proposals{URL: server.URL, Path: "/api/btr/{id}", Username: "foxden", Password: "secret"})
+	r := gin.New()
+	for _, route := range c.Routes("/proposals") {
+		r.Handle(route.Method, route.Path, route.Handler)
+	}
+	for target, code := range map[string]int{"/proposals/1234": http.StatusOK, "/proposals/42": http.StatusNotFound, "/proposals/9999": http.StatusBadGateway} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+		if w.Code != code {
+			t.Errorf("%s: expect %d, got %d %s", target, code, w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package proposals
+
+import (
+	"context"
+
+	storage "github.com/CHESSComputing/golib/storage"
+)
+
+// Store represents storage which rejects records referencing unknown
+// proposals, i.e. inserted records and updated proposal numbers are
+// validated by the proposal client
+type Store struct {
+	Store  storage.Store
+	Client *Client
+}
+
+// NewStore returns proposal validating storage
+func NewStore(store storage.Store, client *Client) *Store {
+	return &Store{Store: store, Client: client}
+}
+
+// Insert implements storage.Store interface
+func (s *Store) Insert(ctx context.Context, collection string, records ...map[string]any) error {
+	for _, rec := range records {
+		if err := s.Client.Validate(ctx, rec); err != nil {
+			return err
+		}
+	}
+	return s.Store.Insert(ctx, collection, records...)
+}
+
+// Find implements storage.Store interface
+func (s *Store) Find(ctx context.Context, collection string, spec map[string]any, opts *storage.FindOptions) ([]map[string]any, error) {
+	return s.Store.Find(ctx, collection, spec, opts)
+}
+
+// Update implements storage.Store interface
+func (s *Store) Update(ctx context.Context, collection string, spec, fields map[string]any) (int64, error) {
+	if _, ok := fields[s.Client.ProposalKey]; ok {
+		if err := s.Client.Validate(ctx, fields); err != nil {
+			return 0, err
+		}
+	}
+	return s.Store.Update(ctx, collection, spec, fields)
+}
+
+// Count implements storage.Store interface
+func (s *Store) Count(ctx context.Context, collection string, spec map[string]any) (int64, error) {
+	return s.Store.Count(ctx, collection, spec)
+}
+
+// Remove implements storage.Store interface
+func (s *Store) Remove(ctx context.Context, collection string, spec map[string]any) (int64, error) {
+	return s.Store.Remove(ctx, collection, spec)
+}
@@ -0,0 +1,45 @@
+package provenance
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	services "github.com/CHESSComputing/golib/services"
+	"github.com/gin-gonic/gin"
+)
+
+// LineageHandler provides gin handler of graph traversal queries, node id
+// is passed via id path parameter, e.g. /provenance/:id. Query parameters:
+//   - direction: ancestors (default) or descendants
+//   - depth: maximum depth of traversal
+//   - format: json (default) or prov for PROV-JSON document
+func LineageHandler(g *Graph) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		depth, _ := strconv.Atoi(c.Query("depth"))
+		var lineage Lineage
+		var err error
+		switch c.DefaultQuery("direction", "ancestors") {
+		case "ancestors":
+			lineage, err = g.Ancestors(c.Request.Context(), id, depth)
+		case "descendants":
+			lineage, err = g.Descendants(c.Request.Context(), id, depth)
+		default:
+			err = fmt.Errorf("unsupported direction '%s'", c.Query("direction"))
+			rec := services.Response("provenance", http.StatusBadRequest, services.ParametersError, err)
+			c.JSON(http.StatusBadRequest, rec)
+			return
+		}
+		if err != nil {
+			rec := services.Response("provenance", http.StatusInternalServerError, services.QueryError, err)
+			c.JSON(http.StatusInternalServerError, rec)
+			return
+		}
+		if c.Query("format") == "prov" {
+			c.JSON(http.StatusOK, ProvJSON(lineage.Edges))
+			return
+		}
+		c.JSON(http.StatusOK, lineage)
+	}
+}
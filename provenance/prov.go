@@ -0,0 +1,96 @@
+package provenance
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Prefix defines namespace prefix of node identifiers in PROV-JSON
+var Prefix = "foxden"
+
+// Namespace defines namespace of node identifiers in PROV-JSON
+var Namespace = "urn:foxden:"
+
+// helper function to provide qualified name of the node
+func qname(id string) string {
+	return Prefix + ":" + id
+}
+
+// ProvJSON converts edges into W3C PROV-JSON document. Targets of
+// processed_by and input_of edges are represented as activities, all other
+// nodes as entities. Edges are mapped as follows:
+//   - derived_from to wasDerivedFrom
+//   - processed_by to wasGeneratedBy
+//   - input_of to used
+func ProvJSON(edges []Edge) map[string]any {
+	entities := make(map[string]any)
+	activities := make(map[string]any)
+	derived := make(map[string]any)
+	generated := make(map[string]any)
+	used := make(map[string]any)
+	for _, e := range edges {
+		switch e.Type {
+		case ProcessedBy, InputOf:
+			activities[qname(e.To)] = map[string]any{}
+		}
+	}
+	addEntity := func(id string) {
+		if _, ok := activities[qname(id)]; !ok {
+			entities[qname(id)] = map[string]any{}
+		}
+	}
+	for i, e := range edges {
+		addEntity(e.From)
+		rel := make(map[string]any)
+		for k, v := range e.Attributes {
+			rel[Prefix+":"+k] = v
+		}
+		var tstamp string
+		if e.Time > 0 {
+			tstamp = time.Unix(e.Time, 0).UTC().Format(time.RFC3339)
+		}
+		key := fmt.Sprintf("_:%s%d", e.Type, i+1)
+		switch e.Type {
+		case DerivedFrom:
+			addEntity(e.To)
+			rel["prov:generatedEntity"] = qname(e.From)
+			rel["prov:usedEntity"] = qname(e.To)
+			derived[key] = rel
+		case ProcessedBy:
+			rel["prov:entity"] = qname(e.From)
+			rel["prov:activity"] = qname(e.To)
+			if tstamp != "" {
+				rel["prov:time"] = tstamp
+			}
+			generated[key] = rel
+		case InputOf:
+			rel["prov:activity"] = qname(e.To)
+			rel["prov:entity"] = qname(e.From)
+			if tstamp != "" {
+				rel["prov:time"] = tstamp
+			}
+			used[key] = rel
+		}
+	}
+	doc := map[string]any{
+		"prefix": map[string]any{Prefix: Namespace},
+	}
+	for k, v := range map[string]map[string]any{
+		"entity":         entities,
+		"activity":       activities,
+		"wasDerivedFrom": derived,
+		"wasGeneratedBy": generated,
+		"used":           used,
+	} {
+		if len(v) > 0 {
+			doc[k] = v
+		}
+	}
+	return doc
+}
+
+// ProvJSON returns PROV-JSON representation of the lineage
+func (l Lineage) ProvJSON() ([]byte, error) {
+	return json.MarshalIndent(ProvJSON(l.Edges), "", "  ")
+}
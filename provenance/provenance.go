@@ -0,0 +1,254 @@
+package provenance
+
+// provenance module links datasets, files and processing steps by typed
+// edges stored alongside metadata records. It provides graph traversal
+// queries which allow to trace reduced data back to raw detector files and
+// export of provenance graph to W3C PROV-JSON format.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/CHESSComputing/golib/storage"
+	bson "go.mongodb.org/mongo-driver/bson"
+)
+
+// edge types
+const (
+	DerivedFrom = "derived_from" // entity is derived from another entity
+	ProcessedBy = "processed_by" // entity is produced by processing step
+	InputOf     = "input_of"     // entity is input of processing step
+)
+
+// Collection defines storage collection of provenance edges
+var Collection = "provenance"
+
+// MaxDepth defines maximum depth of graph traversal
+var MaxDepth = 100
+
+// ErrInvalidEdge is returned for edges with unknown type or without nodes
+var ErrInvalidEdge = errors.New("invalid provenance edge")
+
+// Edge represents typed edge between two nodes, e.g. dataset derived_from
+// raw file or reduced file processed_by processing step
+type Edge struct {
+	From       string         `json:"from"`
+	Type       string         `json:"type"`
+	To         string         `json:"to"`
+	Time       int64          `json:"time"` // unix time in seconds
+	Attributes map[string]any `json:"attributes,omitempty"`
+}
+
+// ID returns edge identifier
+func (e Edge) ID() string {
+	return fmt.Sprintf("%s|%s|%s", e.From, e.Type, e.To)
+}
+
+// Validate checks edge type and nodes
+func (e Edge) Validate() error {
+	if e.From == "" || e.To == "" {
+		return fmt.Errorf("%w: edge without nodes", ErrInvalidEdge)
+	}
+	switch e.Type {
+	case DerivedFrom, ProcessedBy, InputOf:
+		return nil
+	}
+	return fmt.Errorf("%w: unknown edge type '%s'", ErrInvalidEdge, e.Type)
+}
+
+// upstream returns node the edge points to in ancestors direction, i.e.
+// node which given node originates from
+func (e Edge) upstream() (child, parent string) {
+	if e.Type == InputOf {
+		// input file is ancestor of processing step
+		return e.To, e.From
+	}
+	return e.From, e.To
+}
+
+// Node represents node visited by graph traversal
+type Node struct {
+	ID    string `json:"id"`
+	Depth int    `json:"depth"`
+}
+
+// Lineage represents result of graph traversal
+type Lineage struct {
+	Root  string `json:"root"`
+	Nodes []Node `json:"nodes"`
+	Edges []Edge `json:"edges"`
+}
+
+// Graph represents provenance graph kept in document storage
+type Graph struct {
+	Store      storage.Store
+	Collection string
+}
+
+// NewGraph returns provenance graph within given document storage
+func NewGraph(store storage.Store) *Graph {
+	return &Graph{Store: store, Collection: Collection}
+}
+
+// helper function to convert edge into storage record
+func toRecord(e Edge) map[string]any {
+	rec := map[string]any{
+		"_id":  e.ID(),
+		"from": e.From,
+		"type": e.Type,
+		"to":   e.To,
+		"time": e.Time,
+	}
+	if len(e.Attributes) > 0 {
+		rec["attributes"] = e.Attributes
+	}
+	return rec
+}
+
+// helper function to convert storage record into edge
+func toEdge(rec map[string]any) Edge {
+	e := Edge{}
+	e.From, _ = rec["from"].(string)
+	e.Type, _ = rec["type"].(string)
+	e.To, _ = rec["to"].(string)
+	switch v := rec["time"].(type) {
+	case int64:
+		e.Time = v
+	case int32:
+		e.Time = int64(v)
+	case int:
+		e.Time = int64(v)
+	case float64:
+		e.Time = int64(v)
+	}
+	switch v := rec["attributes"].(type) {
+	case map[string]any:
+		e.Attributes = v
+	case bson.M:
+		e.Attributes = v
+	}
+	return e
+}
+
+// Link adds edge to the graph, linking existing edge is no-op
+func (g *Graph) Link(ctx context.Context, e Edge) error {
+	if err := e.Validate(); err != nil {
+		return err
+	}
+	if e.Time == 0 {
+		e.Time = time.Now().Unix()
+	}
+	err := g.Store.Insert(ctx, g.Collection, toRecord(e))
+	if errors.Is(err, storage.ErrDuplicate) {
+		return nil
+	}
+	if err != nil {
+		log.Printf("ERROR: unable to link %s, error %v", e.ID(), err)
+	}
+	return err
+}
+
+// Unlink removes edge from the graph
+func (g *Graph) Unlink(ctx context.Context, from, etype, to string) error {
+	e := Edge{From: from, Type: etype, To: to}
+	_, err := g.Store.Remove(ctx, g.Collection, map[string]any{"_id": e.ID()})
+	return err
+}
+
+// helper function to find edges matching the spec
+func (g *Graph) find(ctx context.Context, spec map[string]any) ([]Edge, error) {
+	records, err := g.Store.Find(ctx, g.Collection, spec, &storage.FindOptions{Sort: []string{"_id"}})
+	if err != nil {
+		return nil, err
+	}
+	var edges []Edge
+	for _, rec := range records {
+		edges = append(edges, toEdge(rec))
+	}
+	return edges, nil
+}
+
+// Edges returns all edges of given node, both outgoing and incoming
+func (g *Graph) Edges(ctx context.Context, id string) ([]Edge, error) {
+	out, err := g.find(ctx, map[string]any{"from": id})
+	if err != nil {
+		return nil, err
+	}
+	in, err := g.find(ctx, map[string]any{"to": id})
+	if err != nil {
+		return nil, err
+	}
+	return append(out, in...), nil
+}
+
+// helper function to find edges connecting node with its parents (up is
+// true) or children
+func (g *Graph) neighbours(ctx context.Context, id string, up bool) ([]Edge, error) {
+	var out []Edge
+	edges, err := g.Edges(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range edges {
+		child, parent := e.upstream()
+		if (up && child == id) || (!up && parent == id) {
+			out = append(out, e)
+		}
+	}
+	return out, nil
+}
+
+// helper function to traverse graph in breadth-first order
+func (g *Graph) traverse(ctx context.Context, root string, depth int, up bool) (Lineage, error) {
+	if depth <= 0 || depth > MaxDepth {
+		depth = MaxDepth
+	}
+	lineage := Lineage{Root: root, Nodes: []Node{}, Edges: []Edge{}}
+	visited := map[string]bool{root: true}
+	seen := make(map[string]bool)
+	level := []string{root}
+	for d := 1; d <= depth && len(level) > 0; d++ {
+		var next []string
+		for _, id := range level {
+			edges, err := g.neighbours(ctx, id, up)
+			if err != nil {
+				return lineage, err
+			}
+			for _, e := range edges {
+				if !seen[e.ID()] {
+					seen[e.ID()] = true
+					lineage.Edges = append(lineage.Edges, e)
+				}
+				child, parent := e.upstream()
+				node := parent
+				if !up {
+					node = child
+				}
+				if visited[node] {
+					continue
+				}
+				visited[node] = true
+				lineage.Nodes = append(lineage.Nodes, Node{ID: node, Depth: d})
+				next = append(next, node)
+			}
+		}
+		level = next
+	}
+	return lineage, nil
+}
+
+// Ancestors returns nodes which given node originates from, e.g. raw files
+// of reduced dataset, up to given depth (MaxDepth if depth is not positive)
+func (g *Graph) Ancestors(ctx context.Context, id string, depth int) (Lineage, error) {
+	return g.traverse(ctx, id, depth, true)
+}
+
+// Descendants returns nodes which originate from given node, e.g. datasets
+// produced from raw file, up to given depth (MaxDepth if depth is not
+// positive)
+func (g *Graph) Descendants(ctx context.Context, id string, depth int) (Lineage, error) {
+	return g.traverse(ctx, id, depth, false)
+}
@@ -0,0 +1,190 @@
+package provenance
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/CHESSComputing/golib/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// helper function to build test graph:
+// raw1, raw2 input_of reduce; reduced processed_by reduce; reduced
+// derived_from raw1; figure derived_from reduced
+func testGraph(t *testing.T) *Graph {
+	ctx := context.Background()
+	g := NewGraph(storage.NewMemoryStore())
+	edges := []Edge{
+		{From: "raw1", Type: InputOf, To: "reduce"},
+		{From: "raw2", Type: InputOf, To: "reduce"},
+		{From: "reduced", Type: ProcessedBy, To: "reduce", Attributes: map[string]any{"version": "1.2"}},
+		{From: "reduced", Type: DerivedFrom, To: "raw1"},
+		{From: "figure", Type: DerivedFrom, To: "reduced"},
+	}
+	for _, e := range edges {
+		if err := g.Link(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// linking the same edge is no-op
+	if err := g.Link(ctx, edges[0]); err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+// helper function to get node depths of lineage
+func depths(l Lineage) map[string]int {
+	out := make(map[string]int)
+	for _, n := range l.Nodes {
+		out[n.ID] = n.Depth
+	}
+	return out
+}
+
+// TestLink
+func TestLink(t *testing.T) {
+	g := NewGraph(storage.NewMemoryStore())
+	err := g.Link(context.Background(), Edge{From: "a", Type: "copied_from", To: "b"})
+	if !errors.Is(err, ErrInvalidEdge) {
+		t.Error("expect invalid edge error, got", err)
+	}
+	err = g.Link(context.Background(), Edge{From: "a", Type: DerivedFrom})
+	if !errors.Is(err, ErrInvalidEdge) {
+		t.Error("expect invalid edge error, got", err)
+	}
+}
+
+// TestAncestors
+func TestAncestors(t *testing.T) {
+	ctx := context.Background()
+	g := testGraph(t)
+	lineage, err := g.Ancestors(ctx, "figure", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := depths(lineage)
+	expect := map[string]int{"reduced": 1, "reduce": 2, "raw1": 2, "raw2": 3}
+	if len(d) != len(expect) {
+		t.Fatalf("invalid ancestors %+v", lineage.Nodes)
+	}
+	for k, v := range expect {
+		if d[k] != v {
+			t.Errorf("invalid depth of %s: %d, expect %d", k, d[k], v)
+		}
+	}
+	if len(lineage.Edges) != 5 {
+		t.Errorf("invalid number of edges %d", len(lineage.Edges))
+	}
+	lineage, err = g.Ancestors(ctx, "figure", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lineage.Nodes) != 1 || lineage.Nodes[0].ID != "reduced" {
+		t.Errorf("depth limit is not applied %+v", lineage.Nodes)
+	}
+}
+
+// TestDescendants
+func TestDescendants(t *testing.T) {
+	g := testGraph(t)
+	lineage, err := g.Descendants(context.Background(), "raw2", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := depths(lineage)
+	expect := map[string]int{"reduce": 1, "reduced": 2, "figure": 3}
+	if len(d) != len(expect) {
+		t.Fatalf("invalid descendants %+v", lineage.Nodes)
+	}
+	for k, v := range expect {
+		if d[k] != v {
+			t.Errorf("invalid depth of %s: %d, expect %d", k, d[k], v)
+		}
+	}
+	if err := g.Unlink(context.Background(), "raw2", InputOf, "reduce"); err != nil {
+		t.Fatal(err)
+	}
+	lineage, _ = g.Descendants(context.Background(), "raw2", 0)
+	if len(lineage.Nodes) != 0 {
+		t.Errorf("edge is not removed %+v", lineage.Nodes)
+	}
+}
+
+// TestProvJSON
+func TestProvJSON(t *testing.T) {
+	g := testGraph(t)
+	lineage, err := g.Ancestors(context.Background(), "figure", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := lineage.ProvJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]map[string]map[string]any
+	var prefix struct {
+		Prefix map[string]string `json:"prefix"`
+	}
+	if err := json.Unmarshal(data, &prefix); err != nil {
+		t.Fatal(err)
+	}
+	if prefix.Prefix[Prefix] != Namespace {
+		t.Errorf("invalid prefix %+v", prefix)
+	}
+	var relations map[string]json.RawMessage
+	if err := json.Unmarshal(data, &relations); err != nil {
+		t.Fatal(err)
+	}
+	delete(relations, "prefix")
+	data, _ = json.Marshal(relations)
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc["entity"]) != 4 || len(doc["activity"]) != 1 {
+		t.Errorf("invalid nodes %+v %+v", doc["entity"], doc["activity"])
+	}
+	if _, ok := doc["activity"]["foxden:reduce"]; !ok {
+		t.Error("processing step is not an activity")
+	}
+	if len(doc["wasDerivedFrom"]) != 2 || len(doc["wasGeneratedBy"]) != 1 || len(doc["used"]) != 2 {
+		t.Errorf("invalid relations %s", data)
+	}
+	for _, r := range doc["wasGeneratedBy"] {
+		if r["prov:entity"] != "foxden:reduced" || r["prov:activity"] != "foxden:reduce" || r["foxden:version"] != "1.2" {
+			t.Errorf("invalid generation %+v", r)
+		}
+	}
+}
+
+// TestLineageHandler
+func TestLineageHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/provenance/:id", LineageHandler(testGraph(t)))
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/provenance/raw1?direction=descendants&depth=1", nil)
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatal("invalid status code", w.Code, w.Body.String())
+	}
+	var lineage Lineage
+	if err := json.Unmarshal(w.Body.Bytes(), &lineage); err != nil {
+		t.Fatal(err)
+	}
+	if len(lineage.Nodes) != 2 {
+		t.Errorf("invalid descendants %+v", lineage.Nodes)
+	}
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest("GET", "/provenance/raw1?direction=sideways", nil)
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Error("invalid status code", w.Code)
+	}
+}
@@ -0,0 +1,292 @@
+package pubsub
+
+// kafka module provides Kafka message bus backend which talks to Kafka
+// via REST proxy (Confluent REST Proxy API v2). Consumer offsets are
+// committed only after handler processed the message which provides
+// at-least-once delivery.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	"github.com/google/uuid"
+)
+
+// Kafka REST proxy content types
+const (
+	kafkaJSONType = "application/vnd.kafka.json.v2+json"
+	kafkaV2Type   = "application/vnd.kafka.v2+json"
+)
+
+// KafkaPollInterval defines interval between polls of empty topics
+var KafkaPollInterval = time.Second
+
+// KafkaBus represents Kafka message bus
+type KafkaBus struct {
+	Options Options
+	URLs    []string // Kafka REST proxy URLs
+	Name    string   // client name
+
+	client *http.Client
+	user   string
+	pass   string
+	token  string
+	mu     sync.Mutex
+	server int
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// kafkaSub represents Kafka subscription
+type kafkaSub struct {
+	bus    *KafkaBus
+	cancel context.CancelFunc
+	once   sync.Once
+}
+
+// NewKafkaBus returns new Kafka message bus
+func NewKafkaBus(cfg srvConfig.MessageBus, opts Options) (*KafkaBus, error) {
+	if len(cfg.URLs) == 0 {
+		return nil, errors.New("no Kafka REST proxy URLs provided")
+	}
+	tlsConfig, err := tlsConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Timeout: opts.Timeout + 30*time.Second}
+	if tlsConfig != nil {
+		client.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	var urls []string
+	for _, u := range cfg.URLs {
+		urls = append(urls, strings.TrimSuffix(u, "/"))
+	}
+	return &KafkaBus{
+		Options: opts,
+		URLs:    urls,
+		Name:    cfg.ClientID,
+		client:  client,
+		user:    cfg.Username,
+		pass:    cfg.Password,
+		token:   cfg.Token,
+		ctx:     ctx,
+		cancel:  cancel,
+	}, nil
+}
+
+// helper function to perform HTTP request to REST proxy
+func (b *KafkaBus) request(ctx context.Context, method, rurl, ctype string, body any, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, rurl, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", ctype)
+	}
+	req.Header.Set("Accept", ctype)
+	if b.token != "" {
+		req.Header.Set("Authorization", "Bearer "+b.token)
+	} else if b.user != "" {
+		req.SetBasicAuth(b.user, b.pass)
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("Kafka REST proxy %s %s failed, status %s, response %s", method, rurl, resp.Status, data)
+	}
+	if out != nil && len(data) > 0 {
+		return json.Unmarshal(data, out)
+	}
+	return nil
+}
+
+// helper function to provide current REST proxy URL
+func (b *KafkaBus) url() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.URLs[b.server]
+}
+
+// helper function to switch to next REST proxy URL
+func (b *KafkaBus) failover() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.server = (b.server + 1) % len(b.URLs)
+}
+
+// helper function to wait for reconnect, it returns false if context is done
+func (b *KafkaBus) wait(ctx context.Context) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(b.Options.ReconnectWait):
+		return true
+	}
+}
+
+// Publish implements Bus interface, subject is used as Kafka topic and
+// message id as record key. The request is retried on all REST proxies.
+func (b *KafkaBus) Publish(ctx context.Context, subject string, env Envelope) error {
+	if b.ctx.Err() != nil {
+		return errors.New("message bus is closed")
+	}
+	env.Subject = subject
+	body := map[string]any{
+		"records": []map[string]any{{"key": env.ID, "value": env}},
+	}
+	ctx, cancel := context.WithTimeout(ctx, b.Options.Timeout)
+	defer cancel()
+	var err error
+	for attempt := 0; attempt < len(b.URLs)*2; attempt++ {
+		rurl := fmt.Sprintf("%s/topics/%s", b.url(), subject)
+		if err = b.request(ctx, "POST", rurl, kafkaJSONType, body, nil); err == nil {
+			return nil
+		}
+		log.Printf("WARNING: unable to publish to Kafka topic %s, error %v", subject, err)
+		b.failover()
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return err
+}
+
+// kafkaRecord represents record returned by REST proxy
+type kafkaRecord struct {
+	Topic     string   `json:"topic"`
+	Value     Envelope `json:"value"`
+	Partition int      `json:"partition"`
+	Offset    int64    `json:"offset"`
+}
+
+// Subscribe implements Bus interface. Every subscription creates its own
+// consumer instance within given consumer group, subscriber without a group
+// gets unique consumer group and receives all messages.
+func (b *KafkaBus) Subscribe(subject, group string, handler Handler) (Subscription, error) {
+	if b.ctx.Err() != nil {
+		return nil, errors.New("message bus is closed")
+	}
+	// new consumer groups start from the earliest message, while unique
+	// groups of subscribers without a group receive only new messages
+	reset := "earliest"
+	if group == "" {
+		// Kafka requires consumer group, every subscriber without a group
+		// gets its own one to receive all messages
+		group = fmt.Sprintf("%s-%s", b.Name, uuid.NewString())
+		reset = "latest"
+	}
+	ctx, cancel := context.WithCancel(b.ctx)
+	sub := &kafkaSub{bus: b, cancel: cancel}
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		for ctx.Err() == nil {
+			err := b.consume(ctx, subject, group, reset, handler)
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("WARNING: Kafka consumer of %s failed, error %v, reconnecting", subject, err)
+			b.failover()
+			if !b.wait(ctx) {
+				return
+			}
+		}
+	}()
+	return sub, nil
+}
+
+// helper function to create consumer instance and consume messages until
+// context is done or an error occurred
+func (b *KafkaBus) consume(ctx context.Context, subject, group, reset string, handler Handler) error {
+	var instance struct {
+		InstanceID string `json:"instance_id"`
+		BaseURI    string `json:"base_uri"`
+	}
+	spec := map[string]any{
+		"name":               fmt.Sprintf("%s-%s", b.Name, uuid.NewString()),
+		"format":             "json",
+		"auto.offset.reset":  reset,
+		"auto.commit.enable": "false",
+	}
+	rurl := fmt.Sprintf("%s/consumers/%s", b.url(), group)
+	if err := b.request(ctx, "POST", rurl, kafkaV2Type, spec, &instance); err != nil {
+		return err
+	}
+	defer func() {
+		// consumer instance should be removed even if context is done
+		dctx, cancel := context.WithTimeout(context.Background(), b.Options.Timeout)
+		defer cancel()
+		b.request(dctx, "DELETE", instance.BaseURI, kafkaV2Type, nil, nil)
+	}()
+	topics := map[string]any{"topics": []string{subject}}
+	if err := b.request(ctx, "POST", instance.BaseURI+"/subscription", kafkaV2Type, topics, nil); err != nil {
+		return err
+	}
+	for {
+		var records []kafkaRecord
+		if err := b.request(ctx, "GET", instance.BaseURI+"/records", kafkaJSONType, nil, &records); err != nil {
+			return err
+		}
+		for _, rec := range records {
+			if err := deliver(ctx, b, b.Options, subject, rec.Value, handler); err != nil {
+				// offset is not committed, the message will be redelivered
+				return err
+			}
+			offsets := map[string]any{
+				"offsets": []map[string]any{
+					{"topic": rec.Topic, "partition": rec.Partition, "offset": rec.Offset},
+				},
+			}
+			if err := b.request(ctx, "POST", instance.BaseURI+"/offsets", kafkaV2Type, offsets, nil); err != nil {
+				return err
+			}
+		}
+		if len(records) == 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(KafkaPollInterval):
+			}
+		}
+	}
+}
+
+// Unsubscribe implements Subscription interface
+func (s *kafkaSub) Unsubscribe() error {
+	s.once.Do(s.cancel)
+	return nil
+}
+
+// Close implements Bus interface
+func (b *KafkaBus) Close() error {
+	b.cancel()
+	b.wg.Wait()
+	return nil
+}
@@ -0,0 +1,141 @@
+package pubsub
+
+// memory module provides in-process message bus, it is used by tests and
+// single node deployments
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// MemoryQueueSize defines size of subscription queues of memory bus
+var MemoryQueueSize = 1024
+
+// MemoryBus represents in-process message bus
+type MemoryBus struct {
+	Options Options
+
+	mu     sync.RWMutex
+	subs   map[string][]*memorySub
+	next   map[string]int // round-robin index of group subscribers
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// memorySub represents subscription of memory bus
+type memorySub struct {
+	bus     *MemoryBus
+	subject string
+	group   string
+	handler Handler
+	queue   chan Envelope
+	done    chan struct{}
+	once    sync.Once
+}
+
+// NewMemoryBus returns new memory bus
+func NewMemoryBus(opts Options) *MemoryBus {
+	if opts.MaxRetries <= 0 {
+		opts.MaxRetries = 1
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &MemoryBus{
+		Options: opts,
+		subs:    make(map[string][]*memorySub),
+		next:    make(map[string]int),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+}
+
+// Publish implements Bus interface
+func (b *MemoryBus) Publish(ctx context.Context, subject string, env Envelope) error {
+	if b.ctx.Err() != nil {
+		return errors.New("message bus is closed")
+	}
+	env.Subject = subject
+	b.mu.Lock()
+	// deliver message to all subscribers without group and to one
+	// subscriber of every group
+	groups := make(map[string][]*memorySub)
+	var targets []*memorySub
+	for _, s := range b.subs[subject] {
+		if s.group == "" {
+			targets = append(targets, s)
+		} else {
+			groups[s.group] = append(groups[s.group], s)
+		}
+	}
+	for group, subs := range groups {
+		key := subject + "/" + group
+		idx := b.next[key] % len(subs)
+		b.next[key] = idx + 1
+		targets = append(targets, subs[idx])
+	}
+	b.mu.Unlock()
+	for _, s := range targets {
+		select {
+		case s.queue <- env:
+		case <-s.done:
+			// subscription is cancelled
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-b.ctx.Done():
+			return errors.New("message bus is closed")
+		}
+	}
+	return nil
+}
+
+// Subscribe implements Bus interface
+func (b *MemoryBus) Subscribe(subject, group string, handler Handler) (Subscription, error) {
+	if b.ctx.Err() != nil {
+		return nil, errors.New("message bus is closed")
+	}
+	s := &memorySub{bus: b, subject: subject, group: group, handler: handler, queue: make(chan Envelope, MemoryQueueSize), done: make(chan struct{})}
+	b.mu.Lock()
+	b.subs[subject] = append(b.subs[subject], s)
+	b.mu.Unlock()
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		for {
+			select {
+			case env := <-s.queue:
+				deliver(b.ctx, b, b.Options, subject, env, handler)
+			case <-s.done:
+				return
+			case <-b.ctx.Done():
+				return
+			}
+		}
+	}()
+	return s, nil
+}
+
+// Unsubscribe implements Subscription interface
+func (s *memorySub) Unsubscribe() error {
+	s.once.Do(func() {
+		b := s.bus
+		b.mu.Lock()
+		var subs []*memorySub
+		for _, sub := range b.subs[s.subject] {
+			if sub != s {
+				subs = append(subs, sub)
+			}
+		}
+		b.subs[s.subject] = subs
+		b.mu.Unlock()
+		close(s.done)
+	})
+	return nil
+}
+
+// Close implements Bus interface
+func (b *MemoryBus) Close() error {
+	b.cancel()
+	b.wg.Wait()
+	return nil
+}
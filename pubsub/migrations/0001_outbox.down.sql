@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS outbox_status_idx;
+DROP TABLE IF EXISTS outbox;
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS outbox (
+    id VARCHAR(64) PRIMARY KEY,
+    subject VARCHAR(255) NOT NULL,
+    envelope TEXT NOT NULL,
+    status VARCHAR(16) NOT NULL,
+    attempts INTEGER DEFAULT 0,
+    next_attempt BIGINT,
+    last_error TEXT,
+    created BIGINT,
+    INDEX outbox_status_idx (status, next_attempt)
+);
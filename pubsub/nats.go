@@ -0,0 +1,253 @@
+package pubsub
+
+// nats module provides NATS message bus backend based on NATS JetStream
+// (https://docs.nats.io/nats-concepts/jetstream). Messages are persisted in
+// the stream, publish waits for stream acknowledgement and messages are
+// acknowledged only after handler processed them which provides
+// at-least-once delivery.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	"github.com/nats-io/nats.go"
+)
+
+// NATSStream defines default name of JetStream stream
+var NATSStream = "FOXDEN"
+
+// NATSAckWait defines time the server waits for message acknowledgement
+// before redelivery, it is extended before every delivery attempt
+var NATSAckWait = 30 * time.Second
+
+// NATSDuplicateWindow defines time window of message de-duplication based
+// on message ids
+var NATSDuplicateWindow = 2 * time.Minute
+
+// NATSBus represents NATS JetStream message bus
+type NATSBus struct {
+	Options Options
+	Servers []string // list of NATS servers, e.g. nats://host:4222
+	Name    string   // client name
+	Stream  string   // JetStream stream name, it is also used as subject prefix
+
+	conn   *nats.Conn
+	js     nats.JetStreamContext
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// natsSub represents NATS subscription
+type natsSub struct {
+	sub  *nats.Subscription
+	once sync.Once
+}
+
+// NewNATSBus returns new NATS message bus connected to one of configured
+// servers, the stream is created if it does not exist
+func NewNATSBus(cfg srvConfig.MessageBus, opts Options) (*NATSBus, error) {
+	if len(cfg.URLs) == 0 {
+		return nil, errors.New("no NATS servers provided")
+	}
+	stream := cfg.Stream
+	if stream == "" {
+		stream = NATSStream
+	}
+	// messages are not buffered while connection is re-established, publish
+	// fails instead and callers (e.g. outbox dispatcher) retry it
+	natsOpts := []nats.Option{
+		nats.Name(cfg.ClientID),
+		nats.Timeout(opts.Timeout),
+		nats.MaxReconnects(-1),
+		nats.ReconnectWait(opts.ReconnectWait),
+		nats.ReconnectBufSize(-1),
+		nats.DisconnectErrHandler(func(nc *nats.Conn, err error) {
+			if err != nil {
+				log.Printf("WARNING: lost connection to NATS server, error %v", err)
+			}
+		}),
+		nats.ReconnectHandler(func(nc *nats.Conn) {
+			log.Println("reconnected to NATS server", nc.ConnectedUrl())
+		}),
+	}
+	if cfg.Username != "" {
+		natsOpts = append(natsOpts, nats.UserInfo(cfg.Username, cfg.Password))
+	}
+	if cfg.Token != "" {
+		natsOpts = append(natsOpts, nats.Token(cfg.Token))
+	}
+	tlsConfig, err := tlsConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		natsOpts = append(natsOpts, nats.Secure(tlsConfig))
+	}
+	conn, err := nats.Connect(strings.Join(cfg.URLs, ","), natsOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to NATS servers %v, error %v", cfg.URLs, err)
+	}
+	js, err := conn.JetStream(nats.MaxWait(opts.Timeout))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if _, err := js.StreamInfo(stream); errors.Is(err, nats.ErrStreamNotFound) {
+		_, err = js.AddStream(&nats.StreamConfig{
+			Name:       stream,
+			Subjects:   []string{stream + ".>"},
+			Storage:    nats.FileStorage,
+			Duplicates: NATSDuplicateWindow,
+		})
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("unable to create NATS stream %s, error %v", stream, err)
+		}
+	} else if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("unable to get NATS stream %s, error %v", stream, err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &NATSBus{
+		Options: opts,
+		Servers: cfg.URLs,
+		Name:    cfg.ClientID,
+		Stream:  stream,
+		conn:    conn,
+		js:      js,
+		ctx:     ctx,
+		cancel:  cancel,
+	}, nil
+}
+
+// helper function to provide stream subject of given bus subject
+func (b *NATSBus) subject(subject string) string {
+	return b.Stream + "." + subject
+}
+
+// NATSConsumerName returns durable consumer name of given group and
+// subject, consumer names can't contain '.', '*', '>' or spaces
+func NATSConsumerName(group, subject string) string {
+	replacer := strings.NewReplacer(".", "_", "*", "_", ">", "_", " ", "_", "/", "_", "\\", "_")
+	return replacer.Replace(group + "_" + subject)
+}
+
+// Publish implements Bus interface. The call returns only when the message
+// is persisted by the stream, it fails if connection to the server is lost.
+// Message id is used for de-duplication of re-published messages.
+func (b *NATSBus) Publish(ctx context.Context, subject string, env Envelope) error {
+	if b.ctx.Err() != nil {
+		return errors.New("message bus is closed")
+	}
+	env.Subject = subject
+	data, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, b.Options.Timeout)
+	defer cancel()
+	msgID := subject + ":" + env.ID
+	if _, err := b.js.Publish(b.subject(subject), data, nats.MsgId(msgID), nats.Context(ctx)); err != nil {
+		return fmt.Errorf("unable to publish message %s to NATS subject %s, error %v", env.ID, subject, err)
+	}
+	return nil
+}
+
+// Subscribe implements Bus interface. Subscribers of the same group share
+// durable consumer, i.e. messages are load balanced among them and
+// delivered after restart. Subscriber without a group gets ephemeral
+// consumer which receives all new messages.
+func (b *NATSBus) Subscribe(subject, group string, handler Handler) (Subscription, error) {
+	if b.ctx.Err() != nil {
+		return nil, errors.New("message bus is closed")
+	}
+	callback := func(msg *nats.Msg) {
+		var env Envelope
+		if err := json.Unmarshal(msg.Data, &env); err != nil {
+			log.Printf("ERROR: unable to decode NATS message on %s, error %v", subject, err)
+			msg.Term()
+			return
+		}
+		// extend acknowledgement timer before every delivery attempt
+		progress := func(ctx context.Context, env Envelope) error {
+			msg.InProgress()
+			return handler(ctx, env)
+		}
+		if err := deliver(b.ctx, b, b.Options, subject, env, progress); err != nil {
+			msg.Nak()
+			return
+		}
+		if err := msg.Ack(); err != nil {
+			log.Printf("WARNING: unable to acknowledge NATS message %s on %s, error %v", env.ID, subject, err)
+		}
+	}
+	var sub *nats.Subscription
+	var err error
+	if group == "" {
+		sub, err = b.js.Subscribe(
+			b.subject(subject), callback,
+			nats.DeliverNew(), nats.ManualAck(), nats.AckExplicit(), nats.AckWait(NATSAckWait))
+	} else {
+		// durable consumer is created explicitly and subscription is bound
+		// to it, therefore it is not deleted when subscriber unsubscribes
+		name := NATSConsumerName(group, subject)
+		if err = b.addConsumer(name, subject); err != nil {
+			return nil, err
+		}
+		sub, err = b.js.QueueSubscribe(
+			b.subject(subject), name, callback,
+			nats.Bind(b.Stream, name), nats.ManualAck())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to subscribe to NATS subject %s, error %v", subject, err)
+	}
+	return &natsSub{sub: sub}, nil
+}
+
+// helper function to create durable push consumer of given group
+func (b *NATSBus) addConsumer(name, subject string) error {
+	_, err := b.js.ConsumerInfo(b.Stream, name)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, nats.ErrConsumerNotFound) {
+		return fmt.Errorf("unable to get NATS consumer %s, error %v", name, err)
+	}
+	_, err = b.js.AddConsumer(b.Stream, &nats.ConsumerConfig{
+		Durable:        name,
+		DeliverSubject: fmt.Sprintf("_deliver.%s.%s", b.Stream, name),
+		DeliverGroup:   name,
+		DeliverPolicy:  nats.DeliverNewPolicy,
+		AckPolicy:      nats.AckExplicitPolicy,
+		AckWait:        NATSAckWait,
+		FilterSubject:  b.subject(subject),
+	})
+	if err != nil && !errors.Is(err, nats.ErrConsumerNameAlreadyInUse) {
+		return fmt.Errorf("unable to create NATS consumer %s, error %v", name, err)
+	}
+	return nil
+}
+
+// Unsubscribe implements Subscription interface
+func (s *natsSub) Unsubscribe() error {
+	var err error
+	s.once.Do(func() {
+		err = s.sub.Unsubscribe()
+	})
+	return err
+}
+
+// Close implements Bus interface, pending deliveries are interrupted and
+// not acknowledged messages are redelivered by the server
+func (b *NATSBus) Close() error {
+	b.cancel()
+	b.conn.Close()
+	return nil
+}
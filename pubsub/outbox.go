@@ -0,0 +1,506 @@
+package pubsub
+
+// outbox module implements transactional outbox pattern. Services persist
+// events into outbox table (collection) within the same transaction as
+// their data, and the Dispatcher publishes them to message bus in the
+// background with retries. It guarantees that events are not lost when
+// message bus is temporarily unavailable, while they may be published more
+// than once (at-least-once delivery).
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CHESSComputing/golib/dbs"
+	"github.com/CHESSComputing/golib/mongo"
+	utils "github.com/CHESSComputing/golib/utils"
+	bson "go.mongodb.org/mongo-driver/bson"
+	mongoOptions "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// outbox message statuses
+const (
+	OutboxPending = "pending" // message awaits publication
+	OutboxSent    = "sent"    // message is published
+	OutboxFailed  = "failed"  // message exceeded maximum number of attempts
+)
+
+// OutboxTable defines default name of outbox table (collection)
+var OutboxTable = "outbox"
+
+// OutboxMigrationsFS holds SQL migrations of outbox table
+//
+//go:embed migrations/*.sql
+var OutboxMigrationsFS embed.FS
+
+// OutboxMigrations represents migration set of SQL outbox table, services
+// apply it together with their own migrations, e.g.
+// dbs.Migrate(ctx, pubsub.OutboxMigrations)
+var OutboxMigrations = dbs.MigrationSet{Name: "outbox", Files: OutboxMigrationsFS, Table: "outbox_migrations"}
+
+// OutboxMessage represents message persisted in outbox
+type OutboxMessage struct {
+	ID          string    `json:"id"`
+	Subject     string    `json:"subject"`
+	Envelope    Envelope  `json:"envelope"`
+	Status      string    `json:"status"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+	LastError   string    `json:"last_error"`
+	Created     time.Time `json:"created"`
+}
+
+// NewOutboxMessage returns pending outbox message for given subject, event
+// type and data
+func NewOutboxMessage(subject, etype string, data any) (OutboxMessage, error) {
+	env, err := NewEnvelope(etype, Source, data)
+	if err != nil {
+		return OutboxMessage{}, err
+	}
+	now := time.Now().UTC()
+	return OutboxMessage{
+		ID:          env.ID,
+		Subject:     subject,
+		Envelope:    env,
+		Status:      OutboxPending,
+		NextAttempt: now,
+		Created:     now,
+	}, nil
+}
+
+// OutboxStore defines interface of outbox storage
+type OutboxStore interface {
+	// Add persists given messages
+	Add(ctx context.Context, msgs ...OutboxMessage) error
+	// Pending returns up to limit pending messages due for publication
+	Pending(ctx context.Context, limit int) ([]OutboxMessage, error)
+	// Update updates status, attempts, next attempt and error of the message
+	Update(ctx context.Context, msg OutboxMessage) error
+	// Purge removes sent messages created before given time
+	Purge(ctx context.Context, before time.Time) (int64, error)
+}
+
+// helper function to convert outbox message into storage record
+func outboxRecord(msg OutboxMessage) (map[string]any, error) {
+	data, err := json.Marshal(msg.Envelope)
+	if err != nil {
+		return nil, err
+	}
+	status := msg.Status
+	if status == "" {
+		status = OutboxPending
+	}
+	return map[string]any{
+		"id":           msg.ID,
+		"subject":      msg.Subject,
+		"envelope":     string(data),
+		"status":       status,
+		"attempts":     msg.Attempts,
+		"next_attempt": msg.NextAttempt.Unix(),
+		"last_error":   msg.LastError,
+		"created":      msg.Created.Unix(),
+	}, nil
+}
+
+// helper function to convert storage record into outbox message
+func outboxMessage(rec map[string]any) (OutboxMessage, error) {
+	var msg OutboxMessage
+	msg.ID = fmt.Sprintf("%v", rec["id"])
+	msg.Subject = fmt.Sprintf("%v", rec["subject"])
+	msg.Status = fmt.Sprintf("%v", rec["status"])
+	if v, ok := rec["last_error"].(string); ok {
+		msg.LastError = v
+	}
+	msg.Attempts = int(utils.ToInt64(rec["attempts"]))
+	msg.NextAttempt = time.Unix(utils.ToInt64(rec["next_attempt"]), 0).UTC()
+	msg.Created = time.Unix(utils.ToInt64(rec["created"]), 0).UTC()
+	envelope := fmt.Sprintf("%v", rec["envelope"])
+	if err := json.Unmarshal([]byte(envelope), &msg.Envelope); err != nil {
+		return msg, fmt.Errorf("unable to decode envelope of outbox message %s, error %v", msg.ID, err)
+	}
+	return msg, nil
+}
+
+// MemoryOutbox represents in-memory outbox store used by tests
+type MemoryOutbox struct {
+	mu       sync.Mutex
+	messages map[string]OutboxMessage
+}
+
+// NewMemoryOutbox returns new memory outbox
+func NewMemoryOutbox() *MemoryOutbox {
+	return &MemoryOutbox{messages: make(map[string]OutboxMessage)}
+}
+
+// Add implements OutboxStore interface
+func (s *MemoryOutbox) Add(ctx context.Context, msgs ...OutboxMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, msg := range msgs {
+		if _, ok := s.messages[msg.ID]; ok {
+			return fmt.Errorf("outbox message %s already exists", msg.ID)
+		}
+		s.messages[msg.ID] = msg
+	}
+	return nil
+}
+
+// Pending implements OutboxStore interface
+func (s *MemoryOutbox) Pending(ctx context.Context, limit int) ([]OutboxMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	var out []OutboxMessage
+	for _, msg := range s.messages {
+		if msg.Status == OutboxPending && !msg.NextAttempt.After(now) {
+			out = append(out, msg)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Created.Before(out[j].Created) })
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	return out, nil
+}
+
+// Update implements OutboxStore interface
+func (s *MemoryOutbox) Update(ctx context.Context, msg OutboxMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.messages[msg.ID]; !ok {
+		return fmt.Errorf("outbox message %s not found", msg.ID)
+	}
+	s.messages[msg.ID] = msg
+	return nil
+}
+
+// Purge implements OutboxStore interface
+func (s *MemoryOutbox) Purge(ctx context.Context, before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var nrec int64
+	for id, msg := range s.messages {
+		if msg.Status == OutboxSent && msg.Created.Before(before) {
+			delete(s.messages, id)
+			nrec++
+		}
+	}
+	return nrec, nil
+}
+
+// Messages returns all messages of memory outbox
+func (s *MemoryOutbox) Messages() []OutboxMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []OutboxMessage
+	for _, msg := range s.messages {
+		out = append(out, msg)
+	}
+	return out
+}
+
+// outboxColumns defines columns of SQL outbox table
+var outboxColumns = []string{"id", "subject", "envelope", "status", "attempts", "next_attempt", "last_error", "created"}
+
+// SQLOutbox represents outbox stored in SQL table created by
+// OutboxMigrations
+type SQLOutbox struct {
+	DB    *dbs.Connection
+	Table string
+}
+
+// NewSQLOutbox returns SQL outbox for given database connection
+func NewSQLOutbox(db *dbs.Connection) *SQLOutbox {
+	return &SQLOutbox{DB: db, Table: OutboxTable}
+}
+
+// AddTx persists messages within given transaction, it should be used
+// within dbs.Connection.WithTx together with data modifications
+func (s *SQLOutbox) AddTx(ctx context.Context, tx *sql.Tx, msgs ...OutboxMessage) error {
+	stm, err := s.DB.Statement("insert", dbs.StatementArgs{Table: s.Table, Columns: outboxColumns})
+	if err != nil {
+		return err
+	}
+	for _, msg := range msgs {
+		rec, err := outboxRecord(msg)
+		if err != nil {
+			return err
+		}
+		var vals []any
+		for _, col := range outboxColumns {
+			vals = append(vals, rec[col])
+		}
+		if _, err := tx.ExecContext(ctx, stm, vals...); err != nil {
+			return fmt.Errorf("unable to insert outbox message %s, error %v", msg.ID, err)
+		}
+	}
+	return nil
+}
+
+// Add implements OutboxStore interface
+func (s *SQLOutbox) Add(ctx context.Context, msgs ...OutboxMessage) error {
+	return s.DB.WithTx(ctx, func(tx *sql.Tx) error {
+		return s.AddTx(ctx, tx, msgs...)
+	})
+}
+
+// Pending implements OutboxStore interface
+func (s *SQLOutbox) Pending(ctx context.Context, limit int) ([]OutboxMessage, error) {
+	stm := fmt.Sprintf("SELECT %s FROM %s WHERE status = ? AND next_attempt <= ? ORDER BY created",
+		strings.Join(outboxColumns, ", "), s.Table)
+	if limit > 0 {
+		stm = fmt.Sprintf("%s LIMIT %d", stm, limit)
+	}
+	records, err := s.DB.Select(ctx, dbs.Placeholders(s.DB.Driver, stm), OutboxPending, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	var out []OutboxMessage
+	for _, rec := range records {
+		msg, err := outboxMessage(rec)
+		if err != nil {
+			return out, err
+		}
+		out = append(out, msg)
+	}
+	return out, nil
+}
+
+// Update implements OutboxStore interface
+func (s *SQLOutbox) Update(ctx context.Context, msg OutboxMessage) error {
+	rec := map[string]any{
+		"status":       msg.Status,
+		"attempts":     msg.Attempts,
+		"next_attempt": msg.NextAttempt.Unix(),
+		"last_error":   msg.LastError,
+	}
+	_, err := s.DB.Update(ctx, s.Table, rec, map[string]any{"id": msg.ID})
+	return err
+}
+
+// Purge implements OutboxStore interface
+func (s *SQLOutbox) Purge(ctx context.Context, before time.Time) (int64, error) {
+	stm := fmt.Sprintf("DELETE FROM %s WHERE status = ? AND created < ?", s.Table)
+	res, err := s.DB.DB.ExecContext(ctx, dbs.Placeholders(s.DB.Driver, stm), OutboxSent, before.Unix())
+	if err != nil {
+		return 0, fmt.Errorf("unable to purge outbox, error %v", err)
+	}
+	return res.RowsAffected()
+}
+
+// MongoOutbox represents outbox stored in MongoDB collection. To persist
+// messages atomically with data use Add with context provided by
+// mongo.WithTransaction.
+type MongoOutbox struct {
+	DBName     string
+	Collection string
+}
+
+// NewMongoOutbox returns MongoDB outbox for given database name
+func NewMongoOutbox(dbname string) *MongoOutbox {
+	return &MongoOutbox{DBName: dbname, Collection: OutboxTable}
+}
+
+// Add implements OutboxStore interface
+func (s *MongoOutbox) Add(ctx context.Context, msgs ...OutboxMessage) error {
+	c := mongo.Mongo.Connect().Database(s.DBName).Collection(s.Collection)
+	for _, msg := range msgs {
+		rec, err := outboxRecord(msg)
+		if err != nil {
+			return err
+		}
+		rec["_id"] = rec["id"]
+		delete(rec, "id")
+		if _, err := c.InsertOne(ctx, rec); err != nil {
+			return fmt.Errorf("unable to insert outbox message %s, error %v", msg.ID, err)
+		}
+	}
+	return nil
+}
+
+// Pending implements OutboxStore interface
+func (s *MongoOutbox) Pending(ctx context.Context, limit int) ([]OutboxMessage, error) {
+	c := mongo.Mongo.Connect().Database(s.DBName).Collection(s.Collection)
+	spec := bson.M{"status": OutboxPending, "next_attempt": bson.M{"$lte": time.Now().Unix()}}
+	opts := mongoOptions.Find().SetSort(bson.M{"created": 1})
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+	cur, err := c.Find(ctx, spec, opts)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find outbox messages, error %v", err)
+	}
+	var records []map[string]any
+	if err := cur.All(ctx, &records); err != nil {
+		return nil, err
+	}
+	var out []OutboxMessage
+	for _, rec := range records {
+		rec["id"] = rec["_id"]
+		msg, err := outboxMessage(rec)
+		if err != nil {
+			return out, err
+		}
+		out = append(out, msg)
+	}
+	return out, nil
+}
+
+// Update implements OutboxStore interface
+func (s *MongoOutbox) Update(ctx context.Context, msg OutboxMessage) error {
+	c := mongo.Mongo.Connect().Database(s.DBName).Collection(s.Collection)
+	update := bson.M{"$set": bson.M{
+		"status":       msg.Status,
+		"attempts":     msg.Attempts,
+		"next_attempt": msg.NextAttempt.Unix(),
+		"last_error":   msg.LastError,
+	}}
+	if _, err := c.UpdateOne(ctx, bson.M{"_id": msg.ID}, update); err != nil {
+		return fmt.Errorf("unable to update outbox message %s, error %v", msg.ID, err)
+	}
+	return nil
+}
+
+// Purge implements OutboxStore interface
+func (s *MongoOutbox) Purge(ctx context.Context, before time.Time) (int64, error) {
+	c := mongo.Mongo.Connect().Database(s.DBName).Collection(s.Collection)
+	spec := bson.M{"status": OutboxSent, "created": bson.M{"$lt": before.Unix()}}
+	res, err := c.DeleteMany(ctx, spec)
+	if err != nil {
+		return 0, fmt.Errorf("unable to purge outbox, error %v", err)
+	}
+	return res.DeletedCount, nil
+}
+
+// Dispatcher publishes pending outbox messages to message bus
+type Dispatcher struct {
+	Store        OutboxStore
+	Bus          Bus
+	Interval     time.Duration // polling interval
+	BatchSize    int           // maximum number of messages processed per poll
+	MaxAttempts  int           // maximum number of publish attempts, 0 means no limit
+	RetryWait    time.Duration // initial wait between attempts, it doubles on every failure
+	MaxRetryWait time.Duration // maximum wait between attempts
+	Retention    time.Duration // how long sent messages are kept, 0 means forever
+	Verbose      int
+
+	notify chan struct{}
+}
+
+// NewDispatcher returns new dispatcher with default settings
+func NewDispatcher(store OutboxStore, bus Bus) *Dispatcher {
+	return &Dispatcher{
+		Store:        store,
+		Bus:          bus,
+		Interval:     time.Second,
+		BatchSize:    100,
+		RetryWait:    time.Second,
+		MaxRetryWait: 5 * time.Minute,
+		Retention:    24 * time.Hour,
+		notify:       make(chan struct{}, 1),
+	}
+}
+
+// Notify wakes up dispatcher to publish new messages without waiting for
+// the next poll
+func (d *Dispatcher) Notify() {
+	select {
+	case d.notify <- struct{}{}:
+	default:
+	}
+}
+
+// helper function to provide wait time before given attempt
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	wait := d.RetryWait
+	for i := 1; i < attempts && wait < d.MaxRetryWait; i++ {
+		wait *= 2
+	}
+	if d.MaxRetryWait > 0 && wait > d.MaxRetryWait {
+		wait = d.MaxRetryWait
+	}
+	return wait
+}
+
+// Dispatch publishes single batch of pending messages and returns number
+// of published ones
+func (d *Dispatcher) Dispatch(ctx context.Context) (int, error) {
+	if d.Store == nil || d.Bus == nil {
+		return 0, errors.New("outbox dispatcher requires store and message bus")
+	}
+	msgs, err := d.Store.Pending(ctx, d.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+	var nsent int
+	for _, msg := range msgs {
+		if ctx.Err() != nil {
+			return nsent, ctx.Err()
+		}
+		msg.Attempts++
+		if err := d.Bus.Publish(ctx, msg.Subject, msg.Envelope); err != nil {
+			msg.LastError = err.Error()
+			msg.NextAttempt = time.Now().Add(d.backoff(msg.Attempts)).UTC()
+			if d.MaxAttempts > 0 && msg.Attempts >= d.MaxAttempts {
+				msg.Status = OutboxFailed
+				log.Printf("ERROR: unable to publish outbox message %s to %s after %d attempts, error %v", msg.ID, msg.Subject, msg.Attempts, err)
+			} else if d.Verbose > 0 {
+				log.Printf("WARNING: unable to publish outbox message %s to %s, attempt %d, error %v", msg.ID, msg.Subject, msg.Attempts, err)
+			}
+		} else {
+			msg.Status = OutboxSent
+			msg.LastError = ""
+			nsent++
+		}
+		if err := d.Store.Update(ctx, msg); err != nil {
+			// message stays pending and will be published again
+			log.Printf("ERROR: unable to update outbox message %s, error %v", msg.ID, err)
+		}
+	}
+	return nsent, nil
+}
+
+// Run dispatches outbox messages until context is done
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.Interval)
+	defer ticker.Stop()
+	lastPurge := time.Now()
+	for {
+		for {
+			// keep dispatching while there are full batches of messages
+			n, err := d.Dispatch(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Println("ERROR: outbox dispatch failed", err)
+				}
+				break
+			}
+			if d.BatchSize <= 0 || n < d.BatchSize {
+				break
+			}
+		}
+		if d.Retention > 0 && time.Since(lastPurge) > d.Retention/24 {
+			if n, err := d.Store.Purge(ctx, time.Now().Add(-d.Retention)); err != nil {
+				log.Println("ERROR: unable to purge outbox", err)
+			} else if d.Verbose > 0 && n > 0 {
+				log.Printf("purged %d outbox messages", n)
+			}
+			lastPurge = time.Now()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.notify:
+		}
+	}
+}
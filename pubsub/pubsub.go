@@ -0,0 +1,239 @@
+package pubsub
+
+// pubsub module provides message bus abstraction used by FOXDEN/CHESS
+// services to emit events consumed by downstream pipelines
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	"github.com/google/uuid"
+)
+
+// common event types
+const (
+	EventRecordInserted     = "record.inserted"
+	EventRecordUpdated      = "record.updated"
+	EventRecordDeleted      = "record.deleted"
+	EventRecordState        = "record.state"
+	EventDatasetRegistered  = "dataset.registered"
+	EventDatasetTransferred = "dataset.transferred"
+	EventTokenRevoked       = "token.revoked"
+	EventUploadCompleted    = "upload.completed"
+	EventBundleProgress     = "bundle.progress"
+)
+
+// DeadLetterSuffix defines suffix of subjects where messages are published
+// once all delivery attempts failed
+const DeadLetterSuffix = ".dlq"
+
+// Envelope represents typed message envelope
+type Envelope struct {
+	ID      string          `json:"id"`      // unique message id
+	Type    string          `json:"type"`    // event type, e.g. record.inserted
+	Source  string          `json:"source"`  // name of the service which emitted the event
+	Subject string          `json:"subject"` // subject (topic) of the message
+	Time    time.Time       `json:"time"`    // time of the event
+	Attempt int             `json:"attempt"` // delivery attempt
+	Data    json.RawMessage `json:"data"`    // event payload
+}
+
+// NewEnvelope returns new envelope with given event type, source and data
+func NewEnvelope(etype, source string, data any) (Envelope, error) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return Envelope{}, err
+	}
+	return Envelope{
+		ID:     uuid.NewString(),
+		Type:   etype,
+		Source: source,
+		Time:   time.Now().UTC(),
+		Data:   payload,
+	}, nil
+}
+
+// Decode decodes envelope data into given value
+func (e Envelope) Decode(v any) error {
+	return json.Unmarshal(e.Data, v)
+}
+
+// Handler processes envelope, if it returns an error the message is
+// redelivered until maximum number of attempts is reached
+type Handler func(ctx context.Context, env Envelope) error
+
+// Subscription represents subscription to a subject
+type Subscription interface {
+	Unsubscribe() error
+}
+
+// Bus defines interface of message bus backends
+type Bus interface {
+	// Publish publishes envelope to given subject, it returns nil only if
+	// the message is accepted by the broker, i.e. backends do not buffer
+	// messages while connection is lost
+	Publish(ctx context.Context, subject string, env Envelope) error
+	// Subscribe subscribes handler to given subject, messages are load
+	// balanced among subscribers of the same group
+	Subscribe(subject, group string, handler Handler) (Subscription, error)
+	// Close closes connection to message bus
+	Close() error
+}
+
+// Options represents common options of message bus backends
+type Options struct {
+	MaxRetries    int           // maximum number of delivery attempts
+	RetryWait     time.Duration // wait time between delivery attempts
+	ReconnectWait time.Duration // wait time between reconnect attempts
+	Timeout       time.Duration // publish timeout
+	Verbose       int           // verbosity level
+}
+
+// helper function to provide options from message bus configuration
+func options(cfg srvConfig.MessageBus) Options {
+	opts := Options{
+		MaxRetries:    cfg.MaxRetries,
+		RetryWait:     time.Second,
+		ReconnectWait: cfg.ReconnectWait,
+		Timeout:       cfg.Timeout,
+	}
+	if opts.MaxRetries <= 0 {
+		opts.MaxRetries = 5
+	}
+	if opts.ReconnectWait <= 0 {
+		opts.ReconnectWait = 2 * time.Second
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	return opts
+}
+
+// helper function to deliver envelope to the handler with retries. If all
+// attempts fail the envelope is published to dead letter subject. It returns
+// an error if message was neither processed nor published to dead letter
+// subject, in that case backends should not acknowledge the message.
+func deliver(ctx context.Context, bus Bus, opts Options, subject string, env Envelope, handler Handler) error {
+	var err error
+	for attempt := env.Attempt + 1; attempt <= opts.MaxRetries; attempt++ {
+		env.Attempt = attempt
+		if err = safeCall(ctx, handler, env); err == nil {
+			return nil
+		}
+		if opts.Verbose > 0 {
+			log.Printf("WARNING: delivery of message %s on %s failed, attempt %d, error %v", env.ID, subject, attempt, err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(opts.RetryWait * time.Duration(attempt)):
+		}
+	}
+	log.Printf("ERROR: unable to deliver message %s on %s after %d attempts, error %v", env.ID, subject, opts.MaxRetries, err)
+	if strings.HasSuffix(subject, DeadLetterSuffix) {
+		return nil
+	}
+	env.Attempt = 0
+	if err := bus.Publish(ctx, subject+DeadLetterSuffix, env); err != nil {
+		log.Printf("ERROR: unable to publish message %s to dead letter subject, error %v", env.ID, err)
+		return err
+	}
+	return nil
+}
+
+// helper function to call handler and recover from its panic
+func safeCall(ctx context.Context, handler Handler, env Envelope) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("handler panic: %v", r)
+		}
+	}()
+	return handler(ctx, env)
+}
+
+// helper function to provide TLS configuration of message bus, it returns
+// nil if neither root CAs nor client certificate are configured
+func tlsConfig(cfg srvConfig.MessageBus) (*tls.Config, error) {
+	if cfg.RootCAs == "" && cfg.ClientCert == "" {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.RootCAs != "" {
+		data, err := os.ReadFile(cfg.RootCAs)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("unable to load root CAs from %s", cfg.RootCAs)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.ClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.ClientCert, cfg.ClientKey)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// New returns message bus for given configuration
+func New(cfg srvConfig.MessageBus) (Bus, error) {
+	opts := options(cfg)
+	switch strings.ToLower(cfg.Backend) {
+	case "", "memory":
+		return NewMemoryBus(opts), nil
+	case "nats":
+		return NewNATSBus(cfg, opts)
+	case "kafka":
+		return NewKafkaBus(cfg, opts)
+	}
+	return nil, fmt.Errorf("unsupported message bus backend '%s'", cfg.Backend)
+}
+
+// MessageBus represents global message bus
+var MessageBus Bus
+
+// Source defines name of the service used in published envelopes
+var Source string
+
+// InitMessageBus initializes global message bus from configuration
+func InitMessageBus() error {
+	bus, err := New(srvConfig.Config.MessageBus)
+	if err != nil {
+		return err
+	}
+	MessageBus = bus
+	return nil
+}
+
+// Publish publishes event of given type and data to global message bus
+func Publish(ctx context.Context, subject, etype string, data any) error {
+	if MessageBus == nil {
+		return errors.New("message bus is not initialized")
+	}
+	env, err := NewEnvelope(etype, Source, data)
+	if err != nil {
+		return err
+	}
+	return MessageBus.Publish(ctx, subject, env)
+}
+
+// Subscribe subscribes handler to given subject of global message bus
+func Subscribe(subject, group string, handler Handler) (Subscription, error) {
+	if MessageBus == nil {
+		return nil, errors.New("message bus is not initialized")
+	}
+	return MessageBus.Subscribe(subject, group, handler)
+}
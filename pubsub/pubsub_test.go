@@ -0,0 +1,321 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+)
+
+// helper function to wait for condition
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for condition")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestMemoryBus
+func TestMemoryBus(t *testing.T) {
+	bus := NewMemoryBus(Options{MaxRetries: 3, RetryWait: time.Millisecond})
+	defer bus.Close()
+	var mu sync.Mutex
+	var attempts, group1, group2, dlq int
+	bus.Subscribe("records", "", func(ctx context.Context, env Envelope) error {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		if env.Attempt < 2 {
+			return errors.New("temporary failure")
+		}
+		return nil
+	})
+	bus.Subscribe("records", "indexer", func(ctx context.Context, env Envelope) error {
+		mu.Lock()
+		group1++
+		mu.Unlock()
+		return nil
+	})
+	bus.Subscribe("records", "indexer", func(ctx context.Context, env Envelope) error {
+		mu.Lock()
+		group2++
+		mu.Unlock()
+		return errors.New("permanent failure")
+	})
+	bus.Subscribe("records"+DeadLetterSuffix, "", func(ctx context.Context, env Envelope) error {
+		mu.Lock()
+		dlq++
+		mu.Unlock()
+		return nil
+	})
+	for i := 0; i < 2; i++ {
+		env, _ := NewEnvelope(EventRecordInserted, "test", map[string]any{"did": i})
+		if err := bus.Publish(context.Background(), "records", env); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return attempts == 4 && group1 == 1 && group2 == 3 && dlq == 1
+	})
+}
+
+// TestNATSBus requires NATS server with enabled JetStream, e.g.
+// nats-server -js, its URL is provided via NATS_URL environment
+func TestNATSBus(t *testing.T) {
+	rurl := os.Getenv("NATS_URL")
+	if rurl == "" {
+		t.Skip("NATS_URL is not set")
+	}
+	cfg := srvConfig.MessageBus{Backend: "nats", URLs: []string{rurl}, Stream: "FOXDEN_TEST"}
+	bus, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bus.Close()
+	received := make(chan Envelope, 10)
+	var mu sync.Mutex
+	var group1, group2 int
+	bus.Subscribe("tokens", "", func(ctx context.Context, env Envelope) error {
+		received <- env
+		return nil
+	})
+	bus.Subscribe("tokens", "revoker", func(ctx context.Context, env Envelope) error {
+		mu.Lock()
+		group1++
+		mu.Unlock()
+		return nil
+	})
+	bus.Subscribe("tokens", "revoker", func(ctx context.Context, env Envelope) error {
+		mu.Lock()
+		group2++
+		mu.Unlock()
+		return nil
+	})
+	env, _ := NewEnvelope(EventTokenRevoked, "authz", map[string]string{"jti": "123"})
+	if err := bus.Publish(context.Background(), "tokens", env); err != nil {
+		t.Fatal(err)
+	}
+	// re-published message is de-duplicated by the stream
+	if err := bus.Publish(context.Background(), "tokens", env); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-received:
+		var data map[string]string
+		msg.Decode(&data)
+		if msg.ID != env.ID || msg.Type != EventTokenRevoked || data["jti"] != "123" {
+			t.Errorf("wrong envelope %+v", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("message is not received")
+	}
+	waitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return group1+group2 == 1
+	})
+	select {
+	case msg := <-received:
+		t.Errorf("duplicate message is received %+v", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// TestNATSConsumerName
+func TestNATSConsumerName(t *testing.T) {
+	if name := NATSConsumerName("search-host1", "records.dlq"); name != "search-host1_records_dlq" {
+		t.Errorf("wrong consumer name %s", name)
+	}
+}
+
+// TestKafkaBus
+func TestKafkaBus(t *testing.T) {
+	var mu sync.Mutex
+	var records []map[string]any
+	var committed int64 = -1
+	var proxy *httptest.Server
+	proxy = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/topics/datasets":
+			var body struct {
+				Records []map[string]any `json:"records"`
+			}
+			json.NewDecoder(r.Body).Decode(&body)
+			records = append(records, body.Records...)
+			fmt.Fprint(w, `{"offsets":[]}`)
+		case r.Method == "POST" && r.URL.Path == "/consumers/pipeline":
+			fmt.Fprintf(w, `{"instance_id":"c1","base_uri":"%s/consumers/pipeline/instances/c1"}`, proxy.URL)
+		case strings.HasSuffix(r.URL.Path, "/records"):
+			var out []map[string]any
+			for i, rec := range records {
+				if int64(i) > committed {
+					out = append(out, map[string]any{"topic": "datasets", "partition": 0, "offset": i, "value": rec["value"]})
+				}
+			}
+			json.NewEncoder(w).Encode(out)
+		case strings.HasSuffix(r.URL.Path, "/offsets"):
+			var body struct {
+				Offsets []struct {
+					Offset int64 `json:"offset"`
+				} `json:"offsets"`
+			}
+			json.NewDecoder(r.Body).Decode(&body)
+			committed = body.Offsets[0].Offset
+		}
+	}))
+	defer proxy.Close()
+	KafkaPollInterval = 10 * time.Millisecond
+	cfg := srvConfig.MessageBus{Backend: "kafka", URLs: []string{proxy.URL}}
+	bus, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bus.Close()
+	received := make(chan Envelope, 10)
+	bus.Subscribe("datasets", "pipeline", func(ctx context.Context, env Envelope) error {
+		received <- env
+		return nil
+	})
+	env, _ := NewEnvelope(EventDatasetTransferred, "datamgmt", map[string]string{"did": "/a/b/c"})
+	if err := bus.Publish(context.Background(), "datasets", env); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-received:
+		if msg.ID != env.ID || msg.Subject != "datasets" {
+			t.Errorf("wrong envelope %+v", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("message is not received")
+	}
+	waitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return committed == 0
+	})
+}
+
+// failingBus represents message bus which fails to publish
+type failingBus struct {
+	Bus
+	mu    sync.Mutex
+	fails int
+}
+
+func (b *failingBus) Publish(ctx context.Context, subject string, env Envelope) error {
+	b.mu.Lock()
+	if b.fails > 0 {
+		b.fails--
+		b.mu.Unlock()
+		return errors.New("broker is down")
+	}
+	b.mu.Unlock()
+	return b.Bus.Publish(ctx, subject, env)
+}
+
+// TestOutbox
+func TestOutbox(t *testing.T) {
+	mbus := NewMemoryBus(Options{MaxRetries: 1})
+	defer mbus.Close()
+	bus := &failingBus{Bus: mbus, fails: 2}
+	received := make(chan Envelope, 10)
+	mbus.Subscribe("datasets", "", func(ctx context.Context, env Envelope) error {
+		received <- env
+		return nil
+	})
+	store := NewMemoryOutbox()
+	msg, err := NewOutboxMessage("datasets", EventDatasetRegistered, map[string]string{"did": "/a/b/c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Add(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Add(context.Background(), msg); err == nil {
+		t.Error("duplicate outbox message should fail")
+	}
+	dispatcher := NewDispatcher(store, bus)
+	dispatcher.Interval = 10 * time.Millisecond
+	dispatcher.RetryWait = time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		dispatcher.Run(ctx)
+		close(done)
+	}()
+	select {
+	case env := <-received:
+		if env.ID != msg.ID || env.Type != EventDatasetRegistered {
+			t.Errorf("wrong envelope %+v", env)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("outbox message is not published")
+	}
+	waitFor(t, func() bool {
+		msgs := store.Messages()
+		return len(msgs) == 1 && msgs[0].Status == OutboxSent && msgs[0].Attempts == 3
+	})
+
+	// message exceeding maximum number of attempts should be marked as failed
+	cancel()
+	<-done
+	bus.mu.Lock()
+	bus.fails = 10
+	bus.mu.Unlock()
+	dispatcher = NewDispatcher(store, bus)
+	dispatcher.MaxAttempts = 1
+	msg, _ = NewOutboxMessage("datasets", EventDatasetRegistered, nil)
+	store.Add(context.Background(), msg)
+	if n, err := dispatcher.Dispatch(context.Background()); err != nil || n != 0 {
+		t.Errorf("unexpected dispatch result %d, error %v", n, err)
+	}
+	pending, _ := store.Pending(context.Background(), 0)
+	if len(pending) != 0 {
+		t.Errorf("failed message should not be pending %+v", pending)
+	}
+	if n, _ := store.Purge(context.Background(), time.Now().Add(time.Hour)); n != 1 {
+		t.Errorf("wrong number of purged messages %d", n)
+	}
+
+	// storage records should be converted back to outbox messages
+	rec, _ := outboxRecord(msg)
+	rec["attempts"] = "2"
+	out, err := outboxMessage(rec)
+	if err != nil || out.Envelope.ID != msg.ID || out.Attempts != 2 {
+		t.Errorf("wrong outbox message %+v, error %v", out, err)
+	}
+}
+
+// TestOutboxMigrations
+func TestOutboxMigrations(t *testing.T) {
+	for _, driver := range []string{"mysql", "postgres", "sqlite3"} {
+		migrations, err := OutboxMigrations.Load(driver)
+		if err != nil || len(migrations) != 1 {
+			t.Fatalf("wrong %s migrations %+v, error %v", driver, migrations, err)
+		}
+		up, down := migrations[0].Up, migrations[0].Down
+		if driver == "mysql" && (strings.Contains(up, "CREATE INDEX") || strings.Contains(down, "DROP INDEX")) {
+			t.Errorf("MySQL does not support IF NOT EXISTS indexes\n%s\n%s", up, down)
+		}
+		if !strings.Contains(up, "outbox_status_idx") || !strings.Contains(down, "DROP TABLE") {
+			t.Errorf("wrong %s migration\n%s\n%s", driver, up, down)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package quota
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	server "github.com/CHESSComputing/golib/server"
+	services "github.com/CHESSComputing/golib/services"
+	"github.com/gin-gonic/gin"
+)
+
+// ProposalParam defines name of path or query parameter with proposal id
+var ProposalParam = "proposal"
+
+// AccountFunc defines function which provides account charged by HTTP
+// request
+type AccountFunc func(c *gin.Context, clientId string) Account
+
+// helper function to get request claims either from gin context set by
+// authz middleware or from request token
+func requestClaims(c *gin.Context, clientId string) *authz.Claims {
+	if val, ok := c.Get("claims"); ok {
+		if claims, ok := val.(*authz.Claims); ok {
+			return claims
+		}
+	}
+	token := authz.RequestToken(c.Request)
+	if token == "" || clientId == "" {
+		return nil
+	}
+	claims, err := authz.TokenClaims(token, clientId)
+	if err != nil {
+		return nil
+	}
+	return claims
+}
+
+// DefaultAccount provides account of HTTP request: user is taken from
+// token claims and proposal from ProposalParam path or query parameter
+func DefaultAccount(c *gin.Context, clientId string) Account {
+	var acc Account
+	if claims := requestClaims(c, clientId); claims != nil {
+		acc.User = claims.CustomClaims.User
+	} else {
+		acc.User = c.GetString("user")
+	}
+	acc.Proposal = c.Param(ProposalParam)
+	if acc.Proposal == "" {
+		acc.Proposal = c.Query(ProposalParam)
+	}
+	return acc
+}
+
+// helper function to check if request writes data
+func isWrite(method string) bool {
+	return method == "POST" || method == "PUT" || method == "PATCH"
+}
+
+// helper function to abort request with service error
+func abort(c *gin.Context, code, srvCode int, err error) {
+	rec := services.Response("quota", code, srvCode, err)
+	c.AbortWithStatusJSON(code, rec)
+}
+
+// Middleware provides gin middleware which enforces quotas of write
+// requests. Requests exceeding request quota are rejected with 429 and
+// requests exceeding storage quota with 413 status code. Request body size
+// is added to storage usage of successful requests, services should
+// release storage via AddStorage when data is deleted.
+func (m *Manager) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !isWrite(c.Request.Method) {
+			c.Next()
+			return
+		}
+		acc := m.Account(c, m.ClientID)
+		size := c.Request.ContentLength
+		if size < 0 {
+			size = 0
+		}
+		ctx := c.Request.Context()
+		if err := m.Reserve(ctx, acc, size); err != nil {
+			var qerr *Error
+			if !errors.As(err, &qerr) {
+				log.Printf("ERROR: unable to check quota of %+v, error %v", acc, err)
+				abort(c, http.StatusInternalServerError, services.DatabaseError, err)
+				return
+			}
+			code := http.StatusTooManyRequests
+			if qerr.Resource == StorageResource {
+				code = http.StatusRequestEntityTooLarge
+			}
+			abort(c, code, services.QuotaError, err)
+			return
+		}
+		c.Next()
+		if size > 0 && c.Writer.Status() < http.StatusMultipleChoices {
+			if err := m.AddStorage(ctx, acc, size); err != nil {
+				log.Printf("ERROR: unable to account storage of %+v, error %v", acc, err)
+			}
+		}
+	}
+}
+
+// helper function to check if request claims have one of admin roles
+func (m *Manager) isAdmin(claims *authz.Claims) bool {
+	if claims == nil {
+		return false
+	}
+	for _, role := range claims.CustomClaims.Roles {
+		for _, r := range m.AdminRoles {
+			if role == r {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ReportHandler provides gin handler of usage report of all users and
+// proposals, it accepts optional scope query parameter. The report is
+// available to administrators only.
+func (m *Manager) ReportHandler(c *gin.Context) {
+	if !m.isAdmin(requestClaims(c, m.ClientID)) {
+		abort(c, http.StatusForbidden, services.PolicyError, errors.New("quota report requires administrator role"))
+		return
+	}
+	scope := c.Query("scope")
+	if scope != "" && scope != UserScope && scope != ProposalScope {
+		abort(c, http.StatusBadRequest, services.ParametersError, fmt.Errorf("unsupported quota scope '%s'", scope))
+		return
+	}
+	report, err := m.Report(c.Request.Context(), scope)
+	if err != nil {
+		abort(c, http.StatusInternalServerError, services.QueryError, err)
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// UsageHandler provides gin handler of usage of single user or proposal
+// given by scope and name path parameters. Users may get their own usage,
+// other usage is available to administrators only.
+func (m *Manager) UsageHandler(c *gin.Context) {
+	scope, name := c.Param("scope"), c.Param("name")
+	if scope != UserScope && scope != ProposalScope {
+		abort(c, http.StatusBadRequest, services.ParametersError, fmt.Errorf("unsupported quota scope '%s'", scope))
+		return
+	}
+	claims := requestClaims(c, m.ClientID)
+	own := claims != nil && scope == UserScope && claims.CustomClaims.User == name
+	if !own && !m.isAdmin(claims) {
+		abort(c, http.StatusForbidden, services.PolicyError, fmt.Errorf("usage of %s %s is not allowed", scope, name))
+		return
+	}
+	usage, err := m.Usage(c.Request.Context(), scope, name)
+	if err != nil {
+		abort(c, http.StatusInternalServerError, services.QueryError, err)
+		return
+	}
+	c.JSON(http.StatusOK, usage)
+}
+
+// Routes returns server routes of quota reporting APIs under given base
+// path, e.g. /quota
+func (m *Manager) Routes(basePath string) []server.Route {
+	return []server.Route{
+		{Method: "GET", Path: basePath, Authorized: true, Handler: m.ReportHandler,
+			Summary: "usage report of users and proposals"},
+		{Method: "GET", Path: basePath + "/:scope/:name", Authorized: true, Handler: m.UsageHandler,
+			Summary: "usage of user or proposal"},
+	}
+}
@@ -0,0 +1,334 @@
+package quota
+
+// quota module provides per-user and per-proposal storage and request
+// quotas of FOXDEN/CHESS services. Usage counters are persisted in the
+// storage layer, middleware rejects over-quota writes and reporting APIs
+// provide usage of accounts to administrators.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	storage "github.com/CHESSComputing/golib/storage"
+	utils "github.com/CHESSComputing/golib/utils"
+)
+
+// quota scopes
+const (
+	UserScope     = "user"
+	ProposalScope = "proposal"
+)
+
+// quota resources
+const (
+	StorageResource  = "storage"
+	RequestsResource = "requests"
+)
+
+// DefaultCollection defines default collection of usage counters
+var DefaultCollection = "quota"
+
+// DefaultPeriod defines default request accounting period
+var DefaultPeriod = 24 * time.Hour
+
+// ErrQuotaExceeded is returned when request exceeds quota
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// Error represents quota violation of user or proposal
+type Error struct {
+	Scope     string // quota scope: user or proposal
+	Name      string // user name or proposal id
+	Resource  string // storage or requests
+	Used      int64  // used amount of resource
+	Limit     int64  // limit of resource
+	Requested int64  // requested amount of resource
+}
+
+// Error implements error interface
+func (e *Error) Error() string {
+	if e.Resource == StorageResource {
+		return fmt.Sprintf("storage quota of %s %s is exceeded: %d of %d bytes are used, %d bytes are requested",
+			e.Scope, e.Name, e.Used, e.Limit, e.Requested)
+	}
+	return fmt.Sprintf("request quota of %s %s is exceeded: %d of %d requests are used within accounting period",
+		e.Scope, e.Name, e.Used, e.Limit)
+}
+
+// Unwrap allows to match quota errors with ErrQuotaExceeded
+func (e *Error) Unwrap() error {
+	return ErrQuotaExceeded
+}
+
+// Limit represents quota limits, zero values mean unlimited
+type Limit struct {
+	Storage  int64 `json:"storage"`  // storage quota in bytes
+	Requests int64 `json:"requests"` // number of requests within accounting period
+}
+
+// Usage represents usage counters of user or proposal
+type Usage struct {
+	Scope    string `json:"scope"`    // quota scope
+	Name     string `json:"name"`     // user name or proposal id
+	Storage  int64  `json:"storage"`  // used storage in bytes
+	Requests int64  `json:"requests"` // number of requests within current period
+	Period   int64  `json:"period"`   // start of current period, unix seconds
+	Limit    Limit  `json:"limit"`    // quota limits
+}
+
+// Account represents user and proposal charged by the request, empty
+// names are not accounted
+type Account struct {
+	User     string
+	Proposal string
+}
+
+// helper function to provide scope and name pairs of the account
+func (a Account) subjects() [][2]string {
+	var out [][2]string
+	if a.User != "" {
+		out = append(out, [2]string{UserScope, a.User})
+	}
+	if a.Proposal != "" {
+		out = append(out, [2]string{ProposalScope, a.Proposal})
+	}
+	return out
+}
+
+// Quotas represents quota manager, it should be initialized via Init
+// function
+var Quotas *Manager
+
+// Manager keeps usage counters in the store and enforces quota limits.
+// Counters are updated under manager lock, therefore single manager should
+// account usage of the store.
+type Manager struct {
+	Store      storage.Store // store of usage counters
+	Collection string        // collection of usage counters
+	Period     time.Duration // request accounting period
+	AdminRoles []string      // roles allowed to use reporting APIs
+	ClientID   string        // client id used to validate tokens
+	Account    AccountFunc   // function which provides account of HTTP request
+
+	mu     sync.Mutex
+	limits map[string]Limit
+	now    func() time.Time
+}
+
+// helper function to provide key of limits and usage records
+func key(scope, name string) string {
+	if name == "" {
+		return scope
+	}
+	return scope + ":" + name
+}
+
+// NewManager returns quota manager with given store and configuration
+func NewManager(store storage.Store, cfg srvConfig.Quota) (*Manager, error) {
+	m := &Manager{
+		Store:      store,
+		Collection: cfg.Collection,
+		Period:     time.Duration(cfg.Period) * time.Hour,
+		AdminRoles: []string{"admin"},
+		Account:    DefaultAccount,
+		limits:     make(map[string]Limit),
+		now:        time.Now,
+	}
+	if m.Collection == "" {
+		m.Collection = DefaultCollection
+	}
+	if m.Period <= 0 {
+		m.Period = DefaultPeriod
+	}
+	for _, l := range cfg.Limits {
+		if l.Scope != UserScope && l.Scope != ProposalScope {
+			return nil, fmt.Errorf("unsupported quota scope '%s'", l.Scope)
+		}
+		if l.Storage < 0 || l.Requests < 0 {
+			return nil, fmt.Errorf("negative quota limit of %s", key(l.Scope, l.Name))
+		}
+		k := key(l.Scope, l.Name)
+		if _, ok := m.limits[k]; ok {
+			return nil, fmt.Errorf("duplicate quota limit of %s", k)
+		}
+		m.limits[k] = Limit{Storage: int64(l.Storage), Requests: l.Requests}
+	}
+	return m, nil
+}
+
+// Init initializes quota manager from server configuration, usage counters
+// are kept in MongoDB which should be initialized via mongo.InitMongoDB or
+// in PostgreSQL storage initialized via storage.Init
+func Init() error {
+	if srvConfig.Config == nil {
+		return errors.New("server configuration is not initialized")
+	}
+	cfg := srvConfig.Config.Quota
+	m, err := NewManager(storage.NewStore(cfg.DBName), cfg)
+	if err != nil {
+		log.Println("ERROR: unable to initialize quota manager", err)
+		return err
+	}
+	m.ClientID = srvConfig.Config.Authz.ClientID
+	Quotas = m
+	return nil
+}
+
+// Limit returns quota limits of given user or proposal, named limits take
+// precedence over default limits of the scope
+func (m *Manager) Limit(scope, name string) Limit {
+	if l, ok := m.limits[key(scope, name)]; ok {
+		return l
+	}
+	return m.limits[scope]
+}
+
+// helper function to provide start of current accounting period
+func (m *Manager) period() int64 {
+	return m.now().UTC().Truncate(m.Period).Unix()
+}
+
+// helper function to provide usage of given record, request counter is
+// reset when accounting period is over
+func (m *Manager) usage(scope, name string, rec map[string]any) Usage {
+	u := Usage{Scope: scope, Name: name, Period: m.period(), Limit: m.Limit(scope, name)}
+	u.Storage = utils.ToInt64(rec["storage"])
+	if utils.ToInt64(rec["period"]) == u.Period {
+		u.Requests = utils.ToInt64(rec["requests"])
+	}
+	return u
+}
+
+// helper function to load usage counters
+func (m *Manager) load(ctx context.Context, scope, name string) (Usage, error) {
+	rec, err := storage.FindOne(ctx, m.Store, m.Collection, map[string]any{"_id": key(scope, name)})
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return Usage{}, err
+	}
+	return m.usage(scope, name, rec), nil
+}
+
+// helper function to save usage counters
+func (m *Manager) save(ctx context.Context, u Usage) error {
+	id := key(u.Scope, u.Name)
+	fields := map[string]any{
+		"scope":    u.Scope,
+		"name":     u.Name,
+		"storage":  u.Storage,
+		"requests": u.Requests,
+		"period":   u.Period,
+	}
+	n, err := m.Store.Update(ctx, m.Collection, map[string]any{"_id": id}, fields)
+	if err != nil || n > 0 {
+		return err
+	}
+	fields["_id"] = id
+	return m.Store.Insert(ctx, m.Collection, fields)
+}
+
+// helper function to check usage against its limits
+func check(u Usage, requests, size int64) error {
+	if requests > 0 && u.Limit.Requests > 0 && u.Requests+requests > u.Limit.Requests {
+		return &Error{Scope: u.Scope, Name: u.Name, Resource: RequestsResource, Used: u.Requests, Limit: u.Limit.Requests, Requested: requests}
+	}
+	if size > 0 && u.Limit.Storage > 0 && u.Storage+size > u.Limit.Storage {
+		return &Error{Scope: u.Scope, Name: u.Name, Resource: StorageResource, Used: u.Storage, Limit: u.Limit.Storage, Requested: size}
+	}
+	return nil
+}
+
+// Check checks if account can store given number of bytes and perform
+// one more request, it returns Error if any quota is exceeded
+func (m *Manager) Check(ctx context.Context, acc Account, size int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, s := range acc.subjects() {
+		u, err := m.load(ctx, s[0], s[1])
+		if err != nil {
+			return err
+		}
+		if err := check(u, 1, size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reserve checks quotas of the account and counts the request, the request
+// is not counted if any quota of the account is exceeded
+func (m *Manager) Reserve(ctx context.Context, acc Account, size int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var usages []Usage
+	for _, s := range acc.subjects() {
+		u, err := m.load(ctx, s[0], s[1])
+		if err != nil {
+			return err
+		}
+		if err := check(u, 1, size); err != nil {
+			return err
+		}
+		usages = append(usages, u)
+	}
+	for _, u := range usages {
+		u.Requests++
+		if err := m.save(ctx, u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddStorage adds given number of bytes to storage usage of the account,
+// negative size releases storage, e.g. when data is deleted
+func (m *Manager) AddStorage(ctx context.Context, acc Account, size int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, s := range acc.subjects() {
+		u, err := m.load(ctx, s[0], s[1])
+		if err != nil {
+			return err
+		}
+		u.Storage += size
+		if u.Storage < 0 {
+			u.Storage = 0
+		}
+		if err := m.save(ctx, u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Usage returns usage counters and limits of given user or proposal
+func (m *Manager) Usage(ctx context.Context, scope, name string) (Usage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.load(ctx, scope, name)
+}
+
+// Report returns usage of all accounted users and proposals of given scope
+// (all scopes if scope is empty) ordered by scope and name
+func (m *Manager) Report(ctx context.Context, scope string) ([]Usage, error) {
+	var spec map[string]any
+	if scope != "" {
+		spec = map[string]any{"scope": scope}
+	}
+	opts := &storage.FindOptions{Sort: []string{"scope", "name"}}
+	records, err := m.Store.Find(ctx, m.Collection, spec, opts)
+	if err != nil {
+		log.Printf("ERROR: unable to find quota usage, error %v", err)
+		return nil, err
+	}
+	out := make([]Usage, 0, len(records))
+	for _, rec := range records {
+		s, _ := rec["scope"].(string)
+		name, _ := rec["name"].(string)
+		out = append(out, m.usage(s, name, rec))
+	}
+	return out, nil
+}
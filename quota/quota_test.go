@@ -0,0 +1,163 @@
+package quota
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	srvConfig "github.com/CHESSComputing/golib/config"
+	storage "github.com/CHESSComputing/golib/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// helper function to create test quota manager
+func testManager(t *testing.T) *Manager {
+	cfg := srvConfig.Quota{
+		Period: 1,
+		Limits: []srvConfig.QuotaLimit{
+			{Scope: UserScope, Storage: 100, Requests: 3},
+			{Scope: UserScope, Name: "pipeline"},
+			{Scope: ProposalScope, Storage: 150},
+		},
+	}
+	m, err := NewManager(storage.NewMemoryStore(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// TestManager
+func TestManager(t *testing.T) {
+	ctx := context.Background()
+	m := testManager(t)
+	now := time.Date(2024, 1, 1, 10, 30, 0, 0, time.UTC)
+	m.now = func() time.Time { return now }
+	if _, err := NewManager(storage.NewMemoryStore(), srvConfig.Quota{Limits: []srvConfig.QuotaLimit{{Scope: "beamline"}}}); err == nil {
+		t.Error("unsupported scope should fail")
+	}
+	if l := m.Limit(UserScope, "pipeline"); l.Storage != 0 || l.Requests != 0 {
+		t.Errorf("invalid named limit %+v", l)
+	}
+	alice := Account{User: "alice", Proposal: "1234"}
+	for i := 0; i < 3; i++ {
+		if err := m.Reserve(ctx, alice, 10); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var qerr *Error
+	err := m.Reserve(ctx, alice, 10)
+	if !errors.Is(err, ErrQuotaExceeded) || !errors.As(err, &qerr) || qerr.Resource != RequestsResource {
+		t.Fatalf("expect request quota error, got %v", err)
+	}
+	// request counter is reset in the next period
+	now = now.Add(time.Hour)
+	if err := m.Reserve(ctx, alice, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddStorage(ctx, alice, 90); err != nil {
+		t.Fatal(err)
+	}
+	err = m.Check(ctx, alice, 20)
+	if !errors.As(err, &qerr) || qerr.Resource != StorageResource || qerr.Scope != UserScope || qerr.Used != 90 {
+		t.Fatalf("expect storage quota error, got %v", err)
+	}
+	// proposal quota is shared by its users
+	bob := Account{User: "bob", Proposal: "1234"}
+	m.AddStorage(ctx, bob, 50)
+	err = m.Check(ctx, bob, 20)
+	if !errors.As(err, &qerr) || qerr.Scope != ProposalScope || qerr.Name != "1234" {
+		t.Fatalf("expect proposal quota error, got %v", err)
+	}
+	if err := m.Reserve(ctx, Account{User: "pipeline"}, 1000); err != nil {
+		t.Error("unlimited user is rejected", err)
+	}
+	m.AddStorage(ctx, alice, -200)
+	if u, _ := m.Usage(ctx, UserScope, "alice"); u.Storage != 0 || u.Requests != 1 || u.Limit.Requests != 3 {
+		t.Errorf("invalid usage %+v", u)
+	}
+	report, err := m.Report(ctx, "")
+	if err != nil || len(report) != 4 || report[0].Scope != ProposalScope || report[1].Name != "alice" {
+		t.Errorf("invalid report %+v, error %v", report, err)
+	}
+	if report, _ := m.Report(ctx, UserScope); len(report) != 3 {
+		t.Errorf("invalid user report %+v", report)
+	}
+}
+
+// helper function to create test router with given user and roles
+func testRouter(m *Manager, user string, roles ...string) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		if user != "" {
+			c.Set("claims", &authz.Claims{CustomClaims: authz.CustomClaims{User: user, Roles: roles}})
+		}
+	})
+	r.Use(m.Middleware())
+	r.POST("/data/:proposal", func(c *gin.Context) { c.Status(http.StatusCreated) })
+	r.PUT("/fail", func(c *gin.Context) { c.Status(http.StatusBadRequest) })
+	for _, route := range m.Routes("/quota") {
+		r.Handle(route.Method, route.Path, route.Handler)
+	}
+	return r
+}
+
+// helper function to perform HTTP request
+func call(r *gin.Engine, method, path, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return w
+}
+
+// TestMiddleware
+func TestMiddleware(t *testing.T) {
+	m := testManager(t)
+	r := testRouter(m, "alice")
+	if w := call(r, "POST", "/data/1234", strings.Repeat("x", 60)); w.Code != http.StatusCreated {
+		t.Fatal("write is rejected", w.Code, w.Body.String())
+	}
+	// failed requests are counted but do not use storage
+	if w := call(r, "PUT", "/fail", strings.Repeat("x", 30)); w.Code != http.StatusBadRequest {
+		t.Fatal("unexpected status", w.Code)
+	}
+	w := call(r, "POST", "/data/1234", strings.Repeat("x", 60))
+	if w.Code != http.StatusRequestEntityTooLarge || !strings.Contains(w.Body.String(), "storage quota of user alice is exceeded") {
+		t.Fatal("over-quota write is accepted", w.Code, w.Body.String())
+	}
+	if w := call(r, "POST", "/data/1234", "x"); w.Code != http.StatusCreated {
+		t.Fatal("write is rejected", w.Code, w.Body.String())
+	}
+	if w := call(r, "POST", "/data/1234", "x"); w.Code != http.StatusTooManyRequests {
+		t.Fatal("request quota is not enforced", w.Code)
+	}
+	u, _ := m.Usage(context.Background(), ProposalScope, "1234")
+	if u.Storage != 61 || u.Requests != 2 {
+		t.Errorf("invalid proposal usage %+v", u)
+	}
+
+	if w := call(r, "GET", "/quota", ""); w.Code != http.StatusForbidden {
+		t.Error("report is available to non-admin user", w.Code)
+	}
+	if w := call(r, "GET", "/quota/user/alice", ""); w.Code != http.StatusOK {
+		t.Error("own usage is not available", w.Code)
+	}
+	if w := call(r, "GET", "/quota/proposal/1234", ""); w.Code != http.StatusForbidden {
+		t.Error("proposal usage is available to non-admin user", w.Code)
+	}
+	r = testRouter(m, "root", "admin")
+	w = call(r, "GET", "/quota?scope=proposal", "")
+	var report []Usage
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil || len(report) != 1 || report[0].Limit.Storage != 150 {
+		t.Errorf("invalid report %s, error %v", w.Body.String(), err)
+	}
+	if w := call(r, "GET", "/quota?scope=beamline", ""); w.Code != http.StatusBadRequest {
+		t.Error("unsupported scope is accepted", w.Code)
+	}
+}
@@ -0,0 +1,88 @@
+package redis
+
+// cache module provides JSON cache within redis shared by replicas of the
+// service, e.g. cache of facet counts
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// CachePrefix defines default prefix of redis keys of caches
+var CachePrefix = "cache:"
+
+// DefaultCacheTTL defines expiration of cache entries stored without ttl
+var DefaultCacheTTL = time.Hour
+
+// Cache represents named cache of JSON values within redis. Cache is
+// cleared by increment of its generation counter, entries of previous
+// generations are not used and expire via redis key expiration.
+type Cache struct {
+	Client *Client
+	Prefix string
+}
+
+// NewCache returns cache of given name within redis
+func NewCache(client *Client, name string) *Cache {
+	return &Cache{Client: client, Prefix: client.Key(CachePrefix + name + ":")}
+}
+
+// helper function to provide redis key of cache entry of current
+// generation, keys are hashed since cache keys may be long, e.g. JSON specs
+func (c *Cache) key(ctx context.Context, key string) (string, error) {
+	gen, err := c.Client.Get(ctx, c.Prefix+"generation")
+	if errors.Is(err, ErrNil) {
+		gen = "0"
+	} else if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256([]byte(key))
+	return c.Prefix + gen + ":" + hex.EncodeToString(hash[:]), nil
+}
+
+// Get decodes cached value of the key into val and reports whether key is
+// found
+func (c *Cache) Get(ctx context.Context, key string, val any) (bool, error) {
+	rkey, err := c.key(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	data, err := c.Client.Get(ctx, rkey)
+	if errors.Is(err, ErrNil) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal([]byte(data), val); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Set stores JSON encoding of the value, entry expires after ttl or
+// DefaultCacheTTL
+func (c *Cache) Set(ctx context.Context, key string, val any, ttl time.Duration) error {
+	data, err := json.Marshal(val)
+	if err != nil {
+		return err
+	}
+	rkey, err := c.key(ctx, key)
+	if err != nil {
+		return err
+	}
+	if ttl <= 0 {
+		ttl = DefaultCacheTTL
+	}
+	return c.Client.Set(ctx, rkey, string(data), ttl)
+}
+
+// Clear removes all entries from the cache
+func (c *Cache) Clear(ctx context.Context) error {
+	_, err := c.Client.Incr(ctx, c.Prefix+"generation", 1)
+	return err
+}
@@ -0,0 +1,82 @@
+package redis
+
+// limiter module provides redis store of rate limiter counters shared by
+// replicas of the service
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	limiter "github.com/ulule/limiter/v3"
+	"github.com/ulule/limiter/v3/drivers/store/common"
+)
+
+// LimiterPrefix defines default prefix of redis keys of limiter counters
+var LimiterPrefix = "limiter:"
+
+// LimiterStore represents rate limiter store of counters within redis, it
+// implements limiter.Store interface. Counters are created with expiration
+// of the rate period and incremented atomically within transaction.
+type LimiterStore struct {
+	Client *Client
+	Prefix string
+}
+
+// NewLimiterStore returns rate limiter store of given redis client
+func NewLimiterStore(client *Client) *LimiterStore {
+	return &LimiterStore{Client: client, Prefix: client.Key(LimiterPrefix)}
+}
+
+// helper function to convert replies of counter and its ttl into limiter
+// context
+func limiterContext(rate limiter.Rate, count, ttl int64) limiter.Context {
+	now := time.Now()
+	expiration := now.Add(rate.Period)
+	if ttl > 0 {
+		expiration = now.Add(time.Duration(ttl) * time.Millisecond)
+	}
+	return common.GetContextFromState(now, rate, expiration, count)
+}
+
+// helper function to execute transaction of commands followed by GET and
+// PTTL commands of the counter
+func (s *LimiterStore) counter(ctx context.Context, key string, rate limiter.Rate, cmds ...[]string) (limiter.Context, error) {
+	cmds = append(cmds, []string{"GET", key}, []string{"PTTL", key})
+	replies, err := s.Client.Transaction(ctx, cmds...)
+	if err != nil {
+		return limiter.Context{}, err
+	}
+	var count, ttl int64
+	if val, ok := replies[len(replies)-2].(string); ok {
+		count, _ = strconv.ParseInt(val, 10, 64)
+	}
+	ttl, _ = replies[len(replies)-1].(int64)
+	return limiterContext(rate, count, ttl), nil
+}
+
+// Get implements limiter.Store interface, it increments counter by one
+func (s *LimiterStore) Get(ctx context.Context, key string, rate limiter.Rate) (limiter.Context, error) {
+	return s.Increment(ctx, key, 1, rate)
+}
+
+// Peek implements limiter.Store interface
+func (s *LimiterStore) Peek(ctx context.Context, key string, rate limiter.Rate) (limiter.Context, error) {
+	return s.counter(ctx, s.Prefix+key, rate)
+}
+
+// Reset implements limiter.Store interface
+func (s *LimiterStore) Reset(ctx context.Context, key string, rate limiter.Rate) (limiter.Context, error) {
+	key = s.Prefix + key
+	return s.counter(ctx, key, rate, []string{"DEL", key})
+}
+
+// Increment implements limiter.Store interface, counter is created with
+// expiration of rate period
+func (s *LimiterStore) Increment(ctx context.Context, key string, count int64, rate limiter.Rate) (limiter.Context, error) {
+	key = s.Prefix + key
+	period := strconv.FormatInt(rate.Period.Milliseconds(), 10)
+	return s.counter(ctx, key, rate,
+		[]string{"SET", key, "0", "NX", "PX", period},
+		[]string{"INCRBY", key, strconv.FormatInt(count, 10)})
+}
@@ -0,0 +1,527 @@
+package redis
+
+// redis module provides redis client with connection pool. Redis is an
+// optional backend of state shared by replicas of FOXDEN/CHESS services:
+// web sessions, rate limiter counters, caches and idempotency keys. Client
+// implements subset of RESP protocol required by these backends.
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+)
+
+// default client parameters used when they are not configured
+var (
+	DefaultPoolSize    = 10
+	DefaultMaxIdle     = 2
+	DefaultDialTimeout = 5 * time.Second
+	DefaultTimeout     = 5 * time.Second
+)
+
+// Redis represents redis client of server configuration
+var Redis *Client
+
+// ErrNil is returned when key does not exist
+var ErrNil = errors.New("redis: nil reply")
+
+// ErrClosed is returned when client is closed
+var ErrClosed = errors.New("redis: client is closed")
+
+// Error represents error reply of redis server
+type Error string
+
+// Error implements error interface
+func (e Error) Error() string {
+	return string(e)
+}
+
+// Options represents parameters of redis client
+type Options struct {
+	Addr        string        // address of redis server, e.g. localhost:6379
+	Username    string        // redis user (ACL), optional
+	Password    string        // redis password, optional
+	DB          int           // redis database number
+	TLS         *tls.Config   // TLS configuration, plain connection is used if nil
+	PoolSize    int           // maximum number of open connections
+	MaxIdle     int           // maximum number of idle connections
+	DialTimeout time.Duration // timeout of new connections
+	Timeout     time.Duration // timeout of redis commands
+	Prefix      string        // prefix of keys provided by Key method
+}
+
+// ParseURI returns client options for given URI, e.g.
+// redis://:password@localhost:6379/0 or rediss://host:6380 for TLS
+func ParseURI(uri string) (Options, error) {
+	var opts Options
+	u, err := url.Parse(uri)
+	if err != nil {
+		return opts, fmt.Errorf("invalid redis URI, error %v", err)
+	}
+	if u.Scheme != "redis" && u.Scheme != "rediss" {
+		return opts, fmt.Errorf("unsupported redis URI scheme '%s'", u.Scheme)
+	}
+	opts.Addr = u.Host
+	if u.Port() == "" {
+		opts.Addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		opts.Username = u.User.Username()
+		opts.Password, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if opts.DB, err = strconv.Atoi(db); err != nil {
+			return opts, fmt.Errorf("invalid redis database '%s'", db)
+		}
+	}
+	if u.Scheme == "rediss" {
+		opts.TLS = &tls.Config{ServerName: u.Hostname()}
+	}
+	return opts, nil
+}
+
+// ConfigOptions returns client options of redis configuration, TLS
+// certificates are loaded from configured files
+func ConfigOptions(cfg srvConfig.Redis) (Options, error) {
+	opts := Options{
+		Addr:        cfg.Addr,
+		Username:    cfg.Username,
+		Password:    cfg.Password,
+		DB:          cfg.DB,
+		PoolSize:    cfg.PoolSize,
+		MaxIdle:     cfg.MaxIdle,
+		DialTimeout: cfg.DialTimeout,
+		Timeout:     cfg.Timeout,
+		Prefix:      cfg.Prefix,
+	}
+	if !cfg.TLS {
+		return opts, nil
+	}
+	host, _, err := net.SplitHostPort(cfg.Addr)
+	if err != nil {
+		host = cfg.Addr
+	}
+	opts.TLS = &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}
+	if cfg.CAFile != "" {
+		data, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return opts, fmt.Errorf("unable to read redis CA file, error %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return opts, fmt.Errorf("no certificates in redis CA file %s", cfg.CAFile)
+		}
+		opts.TLS.RootCAs = pool
+	}
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return opts, fmt.Errorf("unable to load redis client certificate, error %v", err)
+		}
+		opts.TLS.Certificates = []tls.Certificate{cert}
+	}
+	return opts, nil
+}
+
+// Client represents redis client with pool of connections, it is safe for
+// concurrent use. Connections are opened on demand and broken connections
+// are discarded.
+type Client struct {
+	opts  Options
+	slots chan struct{}
+
+	mu     sync.Mutex
+	idle   []*conn
+	closed bool
+}
+
+// NewClient returns redis client with given options
+func NewClient(opts Options) *Client {
+	if opts.PoolSize <= 0 {
+		opts.PoolSize = DefaultPoolSize
+	}
+	if opts.MaxIdle <= 0 {
+		opts.MaxIdle = DefaultMaxIdle
+	}
+	if opts.MaxIdle > opts.PoolSize {
+		opts.MaxIdle = opts.PoolSize
+	}
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = DefaultDialTimeout
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	return &Client{opts: opts, slots: make(chan struct{}, opts.PoolSize)}
+}
+
+// Init initializes redis client of server configuration, connections are
+// opened on demand
+func Init() error {
+	if srvConfig.Config == nil {
+		return errors.New("server configuration is not initialized")
+	}
+	cfg := srvConfig.Config.Redis
+	if cfg.Addr == "" {
+		return errors.New("redis address is not configured")
+	}
+	opts, err := ConfigOptions(cfg)
+	if err != nil {
+		log.Println("ERROR: unable to initialize redis client", err)
+		return err
+	}
+	Redis = NewClient(opts)
+	return nil
+}
+
+// Addr returns address of redis server
+func (c *Client) Addr() string {
+	return c.opts.Addr
+}
+
+// Key returns key with prefix of the client, parts are joined by colon
+func (c *Client) Key(parts ...string) string {
+	return c.opts.Prefix + strings.Join(parts, ":")
+}
+
+// conn represents connection to redis server
+type conn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+// helper function to send command and read its reply
+func (cn *conn) command(args ...string) (any, error) {
+	if err := cn.write(args...); err != nil {
+		return nil, err
+	}
+	return readReply(cn.reader)
+}
+
+// helper function to send command
+func (cn *conn) write(args ...string) error {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&buf, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	_, err := cn.Write([]byte(buf.String()))
+	return err
+}
+
+// helper function to open new connection, authenticate and select database
+func (c *Client) dial(ctx context.Context) (*conn, error) {
+	dialer := &net.Dialer{Timeout: c.opts.DialTimeout}
+	var nc net.Conn
+	var err error
+	if c.opts.TLS != nil {
+		nc, err = (&tls.Dialer{NetDialer: dialer, Config: c.opts.TLS}).DialContext(ctx, "tcp", c.opts.Addr)
+	} else {
+		nc, err = dialer.DialContext(ctx, "tcp", c.opts.Addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to redis %s, error %v", c.opts.Addr, err)
+	}
+	cn := &conn{Conn: nc, reader: bufio.NewReader(nc)}
+	cn.SetDeadline(time.Now().Add(c.opts.Timeout))
+	if c.opts.Password != "" {
+		args := []string{"AUTH", c.opts.Password}
+		if c.opts.Username != "" {
+			args = []string{"AUTH", c.opts.Username, c.opts.Password}
+		}
+		if _, err := cn.command(args...); err != nil {
+			cn.Close()
+			return nil, fmt.Errorf("unable to authenticate to redis %s, error %v", c.opts.Addr, err)
+		}
+	}
+	if c.opts.DB > 0 {
+		if _, err := cn.command("SELECT", strconv.Itoa(c.opts.DB)); err != nil {
+			cn.Close()
+			return nil, fmt.Errorf("unable to select redis database %d, error %v", c.opts.DB, err)
+		}
+	}
+	return cn, nil
+}
+
+// helper function to get connection from the pool, it waits for free slot
+// if all PoolSize connections are in use
+func (c *Client) get(ctx context.Context) (*conn, error) {
+	select {
+	case c.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		<-c.slots
+		return nil, ErrClosed
+	}
+	if n := len(c.idle); n > 0 {
+		cn := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.mu.Unlock()
+		return cn, nil
+	}
+	c.mu.Unlock()
+	cn, err := c.dial(ctx)
+	if err != nil {
+		<-c.slots
+		return nil, err
+	}
+	return cn, nil
+}
+
+// helper function to return connection to the pool, broken connections and
+// connections above MaxIdle are closed
+func (c *Client) put(cn *conn, broken bool) {
+	c.mu.Lock()
+	if broken || c.closed || len(c.idle) >= c.opts.MaxIdle {
+		cn.Close()
+	} else {
+		c.idle = append(c.idle, cn)
+	}
+	c.mu.Unlock()
+	<-c.slots
+}
+
+// helper function to set deadline of connection from context and timeout
+func (c *Client) deadline(ctx context.Context, cn *conn) {
+	deadline := time.Now().Add(c.opts.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	cn.SetDeadline(deadline)
+}
+
+// Do executes redis command and returns its reply: string, int64, nil or
+// []any for arrays. Error replies are returned as Error.
+func (c *Client) Do(ctx context.Context, args ...string) (any, error) {
+	if len(args) == 0 {
+		return nil, errors.New("empty redis command")
+	}
+	cn, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.deadline(ctx, cn)
+	reply, err := cn.command(args...)
+	var rerr Error
+	c.put(cn, err != nil && !errors.As(err, &rerr))
+	if err != nil {
+		return nil, fmt.Errorf("redis %s command failed, error %w", args[0], err)
+	}
+	return reply, nil
+}
+
+// Transaction executes commands atomically within MULTI/EXEC block and
+// returns their replies, error replies of individual commands are returned
+// as Error values of replies
+func (c *Client) Transaction(ctx context.Context, cmds ...[]string) ([]any, error) {
+	cn, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.deadline(ctx, cn)
+	replies, err := c.transaction(cn, cmds)
+	var rerr Error
+	c.put(cn, err != nil && !errors.As(err, &rerr))
+	if err != nil {
+		return nil, fmt.Errorf("redis transaction failed, error %w", err)
+	}
+	return replies, nil
+}
+
+// helper function to execute commands within MULTI/EXEC block, commands are
+// pipelined and their QUEUED replies are read afterwards
+func (c *Client) transaction(cn *conn, cmds [][]string) ([]any, error) {
+	if err := cn.write("MULTI"); err != nil {
+		return nil, err
+	}
+	for _, args := range cmds {
+		if err := cn.write(args...); err != nil {
+			return nil, err
+		}
+	}
+	if err := cn.write("EXEC"); err != nil {
+		return nil, err
+	}
+	// replies of MULTI and queued commands, the first error is reported
+	// after all replies are read to keep connection usable
+	var qerr error
+	for i := 0; i <= len(cmds); i++ {
+		if _, err := readReply(cn.reader); err != nil {
+			var rerr Error
+			if !errors.As(err, &rerr) {
+				return nil, err
+			}
+			if qerr == nil {
+				qerr = err
+			}
+		}
+	}
+	reply, err := readReply(cn.reader)
+	if qerr != nil {
+		// EXEC of transaction with queue errors returns EXECABORT error
+		return nil, qerr
+	}
+	if err != nil {
+		return nil, err
+	}
+	replies, ok := reply.([]any)
+	if !ok {
+		return nil, errors.New("transaction is aborted")
+	}
+	return replies, nil
+}
+
+// Ping checks connection to redis server
+func (c *Client) Ping(ctx context.Context) error {
+	_, err := c.Do(ctx, "PING")
+	return err
+}
+
+// Get returns value of the key or ErrNil if key does not exist
+func (c *Client) Get(ctx context.Context, key string) (string, error) {
+	reply, err := c.Do(ctx, "GET", key)
+	if err != nil {
+		return "", err
+	}
+	val, ok := reply.(string)
+	if !ok {
+		return "", ErrNil
+	}
+	return val, nil
+}
+
+// helper function to provide expiration arguments of SET command
+func expireArgs(ttl time.Duration) []string {
+	if ttl <= 0 {
+		return nil
+	}
+	ms := ttl.Milliseconds()
+	if ms < 1 {
+		ms = 1
+	}
+	return []string{"PX", strconv.FormatInt(ms, 10)}
+}
+
+// Set sets value of the key, key expires after ttl if it is positive
+func (c *Client) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	args := append([]string{"SET", key, value}, expireArgs(ttl)...)
+	_, err := c.Do(ctx, args...)
+	return err
+}
+
+// SetNX sets value of the key if it does not exist and reports whether
+// value is set
+func (c *Client) SetNX(ctx context.Context, key, value string, ttl time.Duration) (bool, error) {
+	args := append([]string{"SET", key, value, "NX"}, expireArgs(ttl)...)
+	reply, err := c.Do(ctx, args...)
+	if err != nil {
+		return false, err
+	}
+	return reply != nil, nil
+}
+
+// Del removes keys and returns number of removed keys
+func (c *Client) Del(ctx context.Context, keys ...string) (int64, error) {
+	reply, err := c.Do(ctx, append([]string{"DEL"}, keys...)...)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := reply.(int64)
+	return n, nil
+}
+
+// Incr increments value of the key by n and returns new value
+func (c *Client) Incr(ctx context.Context, key string, n int64) (int64, error) {
+	reply, err := c.Do(ctx, "INCRBY", key, strconv.FormatInt(n, 10))
+	if err != nil {
+		return 0, err
+	}
+	val, _ := reply.(int64)
+	return val, nil
+}
+
+// Close closes idle connections, connections in use are closed when they
+// are returned to the pool
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	for _, cn := range c.idle {
+		cn.Close()
+	}
+	c.idle = nil
+	return nil
+}
+
+// helper function to read redis reply, nil bulk string and nil array are
+// returned as nil, error replies are returned as Error
+func readReply(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("empty redis reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, Error(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid redis bulk size '%s'", line[1:])
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return string(data[:size]), nil
+	case '*':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid redis array size '%s'", line[1:])
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		items := make([]any, 0, size)
+		for i := 0; i < size; i++ {
+			item, err := readReply(r)
+			var rerr Error
+			if errors.As(err, &rerr) {
+				item = rerr
+			} else if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("unsupported redis reply '%s'", line)
+}
@@ -0,0 +1,148 @@
+package redis
+
+// sessions module provides redis store of web sessions shared by replicas
+// of the service, session cookie holds signed session id only
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gorilla/securecookie"
+	gsessions "github.com/gorilla/sessions"
+)
+
+// SessionPrefix defines default prefix of redis keys of sessions
+var SessionPrefix = "session:"
+
+// DefaultSessionMaxAge defines lifetime of sessions whose cookies do not
+// have Max-Age, e.g. browser sessions
+var DefaultSessionMaxAge = 24 * time.Hour
+
+// SessionStore represents store of web sessions within redis, it
+// implements gin-contrib sessions.Store interface. Session values are
+// encoded and signed with codecs of given key pairs, the same key pairs
+// should be used by all replicas.
+type SessionStore struct {
+	Client  *Client
+	Prefix  string
+	Codecs  []securecookie.Codec
+	options *gsessions.Options
+}
+
+// NewSessionStore returns session store of given redis client, key pairs
+// are authentication and optional encryption keys, see
+// securecookie.CodecsFromPairs
+func NewSessionStore(client *Client, keyPairs ...[]byte) *SessionStore {
+	return &SessionStore{
+		Client:  client,
+		Prefix:  client.Key(SessionPrefix),
+		Codecs:  securecookie.CodecsFromPairs(keyPairs...),
+		options: &gsessions.Options{Path: "/"},
+	}
+}
+
+// Options implements sessions.Store interface
+func (s *SessionStore) Options(opts sessions.Options) {
+	s.options = opts.ToGorillaOptions()
+}
+
+// Get implements sessions.Store interface, sessions are cached within
+// request registry
+func (s *SessionStore) Get(r *http.Request, name string) (*gsessions.Session, error) {
+	return gsessions.GetRegistry(r).Get(s, name)
+}
+
+// New implements sessions.Store interface, it loads session of request
+// cookie or returns new session
+func (s *SessionStore) New(r *http.Request, name string) (*gsessions.Session, error) {
+	session := gsessions.NewSession(s, name)
+	opts := *s.options
+	session.Options = &opts
+	session.IsNew = true
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+	if err := securecookie.DecodeMulti(name, cookie.Value, &session.ID, s.Codecs...); err != nil {
+		return session, err
+	}
+	found, err := s.load(r.Context(), session)
+	if err != nil {
+		return session, err
+	}
+	session.IsNew = !found
+	return session, nil
+}
+
+// Save implements sessions.Store interface, session with negative MaxAge is
+// removed
+func (s *SessionStore) Save(r *http.Request, w http.ResponseWriter, session *gsessions.Session) error {
+	if session.Options.MaxAge < 0 {
+		if session.ID != "" {
+			if _, err := s.Client.Del(r.Context(), s.Prefix+session.ID); err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, gsessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+	if session.ID == "" {
+		id, err := newSessionID()
+		if err != nil {
+			return err
+		}
+		session.ID = id
+	}
+	if err := s.save(r.Context(), session); err != nil {
+		return err
+	}
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.Codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, gsessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+// helper function to generate random session id
+func newSessionID() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return strings.TrimRight(base32.StdEncoding.EncodeToString(buf), "="), nil
+}
+
+// helper function to store session values with expiration of the session
+func (s *SessionStore) save(ctx context.Context, session *gsessions.Session) error {
+	data, err := securecookie.EncodeMulti(session.Name(), session.Values, s.Codecs...)
+	if err != nil {
+		return err
+	}
+	ttl := DefaultSessionMaxAge
+	if session.Options.MaxAge > 0 {
+		ttl = time.Duration(session.Options.MaxAge) * time.Second
+	}
+	return s.Client.Set(ctx, s.Prefix+session.ID, data, ttl)
+}
+
+// helper function to load session values, it reports whether session exists
+func (s *SessionStore) load(ctx context.Context, session *gsessions.Session) (bool, error) {
+	data, err := s.Client.Get(ctx, s.Prefix+session.ID)
+	if errors.Is(err, ErrNil) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := securecookie.DecodeMulti(session.Name(), data, &session.Values, s.Codecs...); err != nil {
+		return false, err
+	}
+	return true, nil
+}
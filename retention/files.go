@@ -0,0 +1,221 @@
+package retention
+
+// files module provides cold storage of data files of archived records,
+// i.e. local directory (e.g. tape backed file system) or S3 bucket. Files
+// and directories keep their paths within cold storage.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	s3 "github.com/CHESSComputing/golib/storage/s3"
+)
+
+// ColdStore moves data files of archived records to cold storage and
+// removes files of purged records
+type ColdStore interface {
+	// Archive moves file or directory at given path into cold storage and
+	// returns its new location
+	Archive(ctx context.Context, path string) (string, error)
+	// Remove removes file or directory at given location, either local
+	// path or location within cold storage
+	Remove(ctx context.Context, location string) error
+}
+
+// NewColdStore returns cold store of given location, i.e. local directory
+// or s3://bucket/prefix of S3 client
+func NewColdStore(location string, client *s3.Client) (ColdStore, error) {
+	if loc, ok := strings.CutPrefix(location, "s3://"); ok {
+		bucket, prefix, _ := strings.Cut(loc, "/")
+		if bucket == "" {
+			return nil, fmt.Errorf("bucket of cold storage %s is not provided", location)
+		}
+		if client == nil {
+			return nil, fmt.Errorf("S3 store of cold storage %s is not configured", location)
+		}
+		return &S3Store{Client: client, Bucket: bucket, Prefix: strings.Trim(prefix, "/")}, nil
+	}
+	if location == "" {
+		return nil, errors.New("cold storage location is not provided")
+	}
+	return &DirStore{Dir: location}, nil
+}
+
+// helper function to remove local file or directory, missing files are
+// ignored
+func removeLocal(location string) error {
+	if err := os.RemoveAll(location); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("ERROR: unable to remove %s, error %v", location, err)
+		return err
+	}
+	return nil
+}
+
+// DirStore represents cold storage in local directory
+type DirStore struct {
+	Dir string // root directory of archived files
+}
+
+// helper function to copy file
+func copyFile(src, dst string, mode fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// Archive implements ColdStore interface, files are renamed or copied if
+// cold storage is on another file system
+func (d *DirStore) Archive(ctx context.Context, fname string) (string, error) {
+	root := filepath.Clean(d.Dir)
+	if strings.HasPrefix(filepath.Clean(fname), root+string(filepath.Separator)) {
+		return fname, nil
+	}
+	dst := filepath.Join(root, filepath.Clean(string(filepath.Separator)+fname))
+	if _, err := os.Stat(fname); errors.Is(err, fs.ErrNotExist) {
+		// file was moved by previous run which did not update the record
+		if _, err := os.Stat(dst); err == nil {
+			return dst, nil
+		}
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return "", err
+	}
+	if err := os.Rename(fname, dst); err == nil {
+		return dst, nil
+	}
+	err := filepath.WalkDir(fname, func(src string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(fname, src)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		}
+		return copyFile(src, target, info.Mode().Perm())
+	})
+	if err != nil {
+		log.Printf("ERROR: unable to copy %s to cold storage, error %v", fname, err)
+		return "", err
+	}
+	return dst, removeLocal(fname)
+}
+
+// Remove implements ColdStore interface
+func (d *DirStore) Remove(ctx context.Context, location string) error {
+	return removeLocal(location)
+}
+
+// S3Store represents cold storage in S3 bucket
+type S3Store struct {
+	Client *s3.Client // S3 client
+	Bucket string     // bucket of archived files
+	Prefix string     // prefix of object keys
+}
+
+// helper function to provide object key of local path
+func (s *S3Store) key(fname string) string {
+	return path.Join(s.Prefix, filepath.ToSlash(strings.TrimPrefix(fname, string(filepath.Separator))))
+}
+
+// helper function to upload file
+func (s *S3Store) upload(ctx context.Context, fname, key string) error {
+	file, err := os.Open(fname)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return s.Client.PutObject(ctx, s.Bucket, key, file, "application/octet-stream")
+}
+
+// Archive implements ColdStore interface, directories are uploaded as
+// objects with common prefix and their location ends with slash
+func (s *S3Store) Archive(ctx context.Context, fname string) (string, error) {
+	if strings.HasPrefix(fname, "s3://") {
+		return fname, nil
+	}
+	key := s.key(fname)
+	info, err := os.Stat(fname)
+	if errors.Is(err, fs.ErrNotExist) {
+		// file was moved by previous run which did not update the record
+		if _, serr := s.Client.StatObject(ctx, s.Bucket, key); serr == nil {
+			return fmt.Sprintf("s3://%s/%s", s.Bucket, key), nil
+		}
+		if objects, lerr := s.Client.ListObjects(ctx, s.Bucket, key+"/"); lerr == nil && len(objects) > 0 {
+			return fmt.Sprintf("s3://%s/%s/", s.Bucket, key), nil
+		}
+		return "", err
+	} else if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		if err := s.upload(ctx, fname, key); err != nil {
+			log.Printf("ERROR: unable to upload %s to cold storage, error %v", fname, err)
+			return "", err
+		}
+		return fmt.Sprintf("s3://%s/%s", s.Bucket, key), removeLocal(fname)
+	}
+	err = filepath.WalkDir(fname, func(src string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		return s.upload(ctx, src, s.key(src))
+	})
+	if err != nil {
+		log.Printf("ERROR: unable to upload %s to cold storage, error %v", fname, err)
+		return "", err
+	}
+	return fmt.Sprintf("s3://%s/%s/", s.Bucket, key), removeLocal(fname)
+}
+
+// Remove implements ColdStore interface
+func (s *S3Store) Remove(ctx context.Context, location string) error {
+	loc, ok := strings.CutPrefix(location, "s3://")
+	if !ok {
+		return removeLocal(location)
+	}
+	bucket, key, _ := strings.Cut(loc, "/")
+	if !strings.HasSuffix(key, "/") {
+		if err := s.Client.DeleteObject(ctx, bucket, key); err != nil && !errors.Is(err, s3.ErrNotFound) {
+			return err
+		}
+		return nil
+	}
+	objects, err := s.Client.ListObjects(ctx, bucket, key)
+	if err != nil {
+		return err
+	}
+	for _, obj := range objects {
+		if err := s.Client.DeleteObject(ctx, bucket, obj.Key); err != nil && !errors.Is(err, s3.ErrNotFound) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,399 @@
+package retention
+
+// retention module executes retention and archival policies of metadata
+// records of schemas or tenants. Records older than archival age move to
+// archive collection and their data files move to cold storage, records
+// older than purge age are removed along with their files. Policies are
+// periodically executed by Run, dry run reports matching records without
+// changes and executed actions are recorded in audit trail.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	audit "github.com/CHESSComputing/golib/audit"
+	srvConfig "github.com/CHESSComputing/golib/config"
+	storage "github.com/CHESSComputing/golib/storage"
+	s3 "github.com/CHESSComputing/golib/storage/s3"
+	tenancy "github.com/CHESSComputing/golib/tenancy"
+)
+
+// actions of retention policies
+const (
+	ActionArchive = "archive"
+	ActionPurge   = "purge"
+)
+
+// audit actions of retention module
+const (
+	AuditArchive = "retention.archive"
+	AuditPurge   = "retention.purge"
+)
+
+// ArchivedAtKey defines record key of archival time (unix seconds) of
+// archived records
+const ArchivedAtKey = "_archived_at"
+
+// defaults of retention module
+var (
+	DefaultArchiveSuffix = "_archive"
+	DefaultDateKey       = "Date"
+	DefaultSchemaKey     = "schema"
+	DefaultIDKey         = "did"
+	DefaultInterval      = 24 * time.Hour
+	DefaultBatchSize     = 1000
+)
+
+// MaxReportIDs defines maximum number of record ids listed in results
+var MaxReportIDs = 20
+
+// Policy represents retention policy of records of collection
+type Policy struct {
+	Name         string        // policy name
+	Store        storage.Store // store of records
+	Collection   string        // collection of records
+	Tenant       string        // tenant of records, records are not filtered by tenant if empty
+	Schema       string        // schema of records, records are not filtered by schema if empty
+	ArchiveAfter time.Duration // age after which records are archived, 0 disables archival
+	PurgeAfter   time.Duration // age after which records are purged, 0 disables purge
+}
+
+// Result represents result of action of retention policy
+type Result struct {
+	Policy     string   `json:"policy"`
+	Action     string   `json:"action"`
+	Collection string   `json:"collection"`
+	Cutoff     int64    `json:"cutoff"`          // records older than cutoff (unix seconds) match the policy
+	Matched    int64    `json:"matched"`         // number of matching records
+	Processed  int64    `json:"processed"`       // number of archived or purged records
+	Files      int      `json:"files"`           // number of moved or removed files
+	IDs        []string `json:"ids,omitempty"`   // ids of first matching records
+	Error      string   `json:"error,omitempty"` // error of the action
+}
+
+// Report represents report of execution of retention policies
+type Report struct {
+	Time    int64    `json:"time"`
+	DryRun  bool     `json:"dry_run"`
+	Results []Result `json:"results"`
+}
+
+// Manager executes retention policies
+type Manager struct {
+	Policies      []Policy      // policies applied in given order
+	ArchiveSuffix string        // suffix of collections of archived records
+	DateKey       string        // record key of record time in unix seconds
+	SchemaKey     string        // record key of schema name
+	IDKey         string        // record key of record ids listed in reports
+	FileKeys      []string      // record keys of paths of data files
+	Cold          ColdStore     // cold storage of files of archived records, files are not moved if nil
+	Interval      time.Duration // interval of policies execution by Run
+	BatchSize     int           // maximum number of records processed per policy action and run
+	DryRun        bool          // Run only reports matching records
+	Audit         *audit.Logger // audit trail, optional
+}
+
+// Retention represents global retention manager, it should be initialized
+// via Init function
+var Retention *Manager
+
+// NewManager returns retention manager of given policies
+func NewManager(policies []Policy, cfg srvConfig.Retention) *Manager {
+	m := &Manager{
+		Policies:      policies,
+		ArchiveSuffix: cfg.ArchiveSuffix,
+		DateKey:       cfg.DateKey,
+		SchemaKey:     cfg.SchemaKey,
+		IDKey:         cfg.IDKey,
+		FileKeys:      cfg.FileKeys,
+		Interval:      cfg.Interval,
+		BatchSize:     cfg.BatchSize,
+		DryRun:        cfg.DryRun,
+	}
+	if m.ArchiveSuffix == "" {
+		m.ArchiveSuffix = DefaultArchiveSuffix
+	}
+	if m.DateKey == "" {
+		m.DateKey = DefaultDateKey
+	}
+	if m.SchemaKey == "" {
+		m.SchemaKey = DefaultSchemaKey
+	}
+	if m.IDKey == "" {
+		m.IDKey = DefaultIDKey
+	}
+	if m.Interval <= 0 {
+		m.Interval = DefaultInterval
+	}
+	if m.BatchSize <= 0 {
+		m.BatchSize = DefaultBatchSize
+	}
+	return m
+}
+
+// Init initializes global retention manager of Retention configuration,
+// records of tenant policies are kept in tenant database and collection,
+// files are moved to S3 cold storage via S3 store of DataManagement
+// configuration and audit trail is provided by global audit logger
+func Init() error {
+	if srvConfig.Config == nil {
+		return errors.New("server configuration is not initialized")
+	}
+	cfg := srvConfig.Config.Retention
+	meta := srvConfig.Config.CHESSMetaData
+	dbname, coll := cfg.DBName, cfg.Collection
+	if dbname == "" {
+		dbname = meta.DBName
+	}
+	if coll == "" {
+		coll = meta.DBColl
+	}
+	if dbname == "" || coll == "" {
+		return errors.New("retention database and collection are not configured")
+	}
+	registry := tenancy.Tenants
+	if registry == nil {
+		var err error
+		if registry, err = tenancy.NewRegistry(srvConfig.Config.Tenancy); err != nil {
+			return err
+		}
+	}
+	var policies []Policy
+	for _, p := range cfg.Policies {
+		db, c := dbname, coll
+		if p.Tenant != "" {
+			t, err := registry.Tenant(p.Tenant)
+			if err != nil {
+				return fmt.Errorf("retention policy %s: %w", p.Name, err)
+			}
+			db, c = tenancy.DBName(t, dbname), tenancy.Collection(t, coll)
+		}
+		policies = append(policies, Policy{
+			Name:         p.Name,
+			Store:        storage.NewStore(db),
+			Collection:   c,
+			Tenant:       p.Tenant,
+			Schema:       p.Schema,
+			ArchiveAfter: p.ArchiveAfter,
+			PurgeAfter:   p.PurgeAfter,
+		})
+	}
+	m := NewManager(policies, cfg)
+	if cfg.ColdStorage != "" {
+		var client *s3.Client
+		if srvConfig.Config.DataManagement.S3.Endpoint != "" {
+			client = s3.NewClient(srvConfig.Config.DataManagement.S3)
+		}
+		cold, err := NewColdStore(cfg.ColdStorage, client)
+		if err != nil {
+			return err
+		}
+		m.Cold = cold
+	}
+	m.Audit = audit.AuditLogger
+	Retention = m
+	return nil
+}
+
+// helper function to provide spec of records of the policy older than
+// cutoff time
+func (m *Manager) spec(p Policy, cutoff time.Time) map[string]any {
+	spec := map[string]any{m.DateKey: map[string]any{"$lt": cutoff.Unix()}}
+	if p.Schema != "" {
+		spec[m.SchemaKey] = p.Schema
+	}
+	if p.Tenant != "" {
+		spec[tenancy.TenantKey] = p.Tenant
+	}
+	return spec
+}
+
+// helper function to provide id of the record used in reports
+func (m *Manager) recordID(rec map[string]any) string {
+	if id, ok := rec[m.IDKey]; ok && id != nil {
+		return fmt.Sprintf("%v", id)
+	}
+	return fmt.Sprintf("%v", rec["_id"])
+}
+
+// helper function to remove files of purged record
+func (m *Manager) removeFiles(ctx context.Context, rec map[string]any) (int, error) {
+	var files int
+	for _, key := range m.FileKeys {
+		location, ok := rec[key].(string)
+		if !ok || location == "" {
+			continue
+		}
+		var err error
+		if m.Cold != nil {
+			err = m.Cold.Remove(ctx, location)
+		} else {
+			err = removeLocal(location)
+		}
+		if err != nil {
+			return files, err
+		}
+		files++
+	}
+	return files, nil
+}
+
+// helper function to purge record of collection along with its files
+func (m *Manager) purge(ctx context.Context, p Policy, collection string, rec map[string]any) (int, error) {
+	files, err := m.removeFiles(ctx, rec)
+	if err != nil {
+		return files, err
+	}
+	_, err = p.Store.Remove(ctx, collection, map[string]any{"_id": rec["_id"]})
+	return files, err
+}
+
+// helper function to move record into archive collection and its files
+// into cold storage
+func (m *Manager) archive(ctx context.Context, p Policy, rec map[string]any) (int, error) {
+	var files int
+	if m.Cold != nil {
+		for _, key := range m.FileKeys {
+			fname, ok := rec[key].(string)
+			if !ok || fname == "" {
+				continue
+			}
+			location, err := m.Cold.Archive(ctx, fname)
+			if err != nil {
+				return files, err
+			}
+			if location != fname {
+				rec[key] = location
+				files++
+			}
+		}
+	}
+	rec[ArchivedAtKey] = time.Now().Unix()
+	archive := p.Collection + m.ArchiveSuffix
+	spec := map[string]any{"_id": rec["_id"]}
+	err := storage.WithTransaction(ctx, p.Store, func(ctx context.Context) error {
+		// record of previous interrupted run is replaced
+		if _, err := p.Store.Remove(ctx, archive, spec); err != nil {
+			return err
+		}
+		if err := p.Store.Insert(ctx, archive, rec); err != nil {
+			return err
+		}
+		_, err := p.Store.Remove(ctx, p.Collection, spec)
+		return err
+	})
+	return files, err
+}
+
+// helper function to execute action of the policy on records of collection
+func (m *Manager) apply(ctx context.Context, p Policy, action, collection string, cutoff time.Time, dryRun bool) (Result, error) {
+	res := Result{Policy: p.Name, Action: action, Collection: collection, Cutoff: cutoff.Unix()}
+	spec := m.spec(p, cutoff)
+	matched, err := p.Store.Count(ctx, collection, spec)
+	if err != nil {
+		return res, err
+	}
+	res.Matched = matched
+	if matched == 0 {
+		return res, nil
+	}
+	limit := m.BatchSize
+	if dryRun {
+		limit = MaxReportIDs
+	}
+	records, err := p.Store.Find(ctx, collection, spec, &storage.FindOptions{Limit: limit, Sort: []string{m.DateKey}})
+	if err != nil {
+		return res, err
+	}
+	for i, rec := range records {
+		if i < MaxReportIDs {
+			res.IDs = append(res.IDs, m.recordID(rec))
+		}
+	}
+	if dryRun {
+		return res, nil
+	}
+	var ids []string
+	for _, rec := range records {
+		var files int
+		if action == ActionArchive {
+			files, err = m.archive(ctx, p, rec)
+		} else {
+			files, err = m.purge(ctx, p, collection, rec)
+		}
+		res.Files += files
+		if err != nil {
+			log.Printf("ERROR: unable to %s record %s of %s, policy %s, error %v", action, m.recordID(rec), collection, p.Name, err)
+			break
+		}
+		res.Processed++
+		ids = append(ids, m.recordID(rec))
+	}
+	if m.Audit != nil && res.Processed > 0 {
+		auditAction := AuditArchive
+		if action == ActionPurge {
+			auditAction = AuditPurge
+		}
+		details := map[string]any{"records": ids, "files": res.Files, "cutoff": res.Cutoff}
+		rec := audit.Record{Subject: "retention", Action: auditAction, Resource: "retention/" + p.Name + "/" + collection, Details: details}
+		if aerr := m.Audit.Record(ctx, rec); aerr != nil && err == nil {
+			err = aerr
+		}
+	}
+	return res, err
+}
+
+// Apply executes retention policies: records older than purge age are
+// removed from collection and its archive, then records older than
+// archival age are archived. At most BatchSize records are processed by
+// every action, remaining ones are processed by next runs. Records are
+// only counted and reported in dry run mode.
+func (m *Manager) Apply(ctx context.Context, now time.Time, dryRun bool) (Report, error) {
+	report := Report{Time: now.Unix(), DryRun: dryRun, Results: []Result{}}
+	var errs []error
+	add := func(res Result, err error) {
+		if err != nil {
+			res.Error = err.Error()
+			errs = append(errs, fmt.Errorf("policy %s: %s of %s: %w", res.Policy, res.Action, res.Collection, err))
+		}
+		report.Results = append(report.Results, res)
+	}
+	for _, p := range m.Policies {
+		if p.PurgeAfter > 0 {
+			cutoff := now.Add(-p.PurgeAfter)
+			add(m.apply(ctx, p, ActionPurge, p.Collection, cutoff, dryRun))
+			add(m.apply(ctx, p, ActionPurge, p.Collection+m.ArchiveSuffix, cutoff, dryRun))
+		}
+		if p.ArchiveAfter > 0 {
+			add(m.apply(ctx, p, ActionArchive, p.Collection, now.Add(-p.ArchiveAfter), dryRun))
+		}
+	}
+	return report, errors.Join(errs...)
+}
+
+// Run periodically executes retention policies until context is cancelled
+func (m *Manager) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		report, err := m.Apply(ctx, time.Now(), m.DryRun)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("ERROR: retention policies failed, error %v", err)
+		}
+		for _, res := range report.Results {
+			if m.DryRun && res.Matched > 0 {
+				log.Printf("retention policy %s: %d records of %s should be %sd, e.g. %v", res.Policy, res.Matched, res.Collection, res.Action, res.IDs)
+			} else if res.Processed > 0 {
+				log.Printf("retention policy %s: %d records of %s are %sd", res.Policy, res.Processed, res.Collection, res.Action)
+			}
+		}
+	}
+}
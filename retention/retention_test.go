@@ -0,0 +1,154 @@
+package retention
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	audit "github.com/CHESSComputing/golib/audit"
+	srvConfig "github.com/CHESSComputing/golib/config"
+	storage "github.com/CHESSComputing/golib/storage"
+)
+
+// helper function to create manager with store of test records
+func testManager(t *testing.T, now time.Time) (*Manager, *storage.MemoryStore, string) {
+	dir := t.TempDir()
+	data := filepath.Join(dir, "data")
+	if err := os.MkdirAll(filepath.Join(data, "btr2"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(data, "btr1.h5"), []byte("1"), 0644)
+	os.WriteFile(filepath.Join(data, "btr2", "scan.h5"), []byte("2"), 0644)
+	day := 24 * time.Hour
+	store := storage.NewMemoryStore()
+	err := store.Insert(context.Background(), "meta",
+		map[string]any{"_id": "1", "did": "/btr=1", "schema": "ID3A", "Date": now.Add(-400 * day).Unix(), "path": filepath.Join(data, "btr1.h5")},
+		map[string]any{"_id": "2", "did": "/btr=2", "schema": "ID3A", "Date": now.Add(-40 * day).Unix(), "path": filepath.Join(data, "btr2")},
+		map[string]any{"_id": "3", "did": "/btr=3", "schema": "ID3A", "Date": now.Unix()},
+		map[string]any{"_id": "4", "did": "/btr=4", "schema": "ID4B", "Date": now.Add(-400 * day).Unix()},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policies := []Policy{{Name: "id3a", Store: store, Collection: "meta", Schema: "ID3A", ArchiveAfter: 30 * day, PurgeAfter: 365 * day}}
+	m := NewManager(policies, srvConfig.Retention{FileKeys: []string{"path"}})
+	m.Cold = &DirStore{Dir: filepath.Join(dir, "cold")}
+	m.Audit = &audit.Logger{Store: audit.NewDocumentStore(storage.NewMemoryStore())}
+	return m, store, filepath.Join(dir, "cold")
+}
+
+// TestDryRun
+func TestDryRun(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	m, store, _ := testManager(t, now)
+	report, err := m.Apply(ctx, now, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.DryRun || len(report.Results) != 3 {
+		t.Fatalf("wrong report %+v", report)
+	}
+	purge, archive := report.Results[0], report.Results[2]
+	if purge.Action != ActionPurge || purge.Matched != 1 || purge.Processed != 0 || len(purge.IDs) != 1 || purge.IDs[0] != "/btr=1" {
+		t.Errorf("wrong purge result %+v", purge)
+	}
+	if archive.Action != ActionArchive || archive.Matched != 2 || archive.Processed != 0 {
+		t.Errorf("wrong archive result %+v", archive)
+	}
+	if count, _ := store.Count(ctx, "meta", map[string]any{}); count != 4 {
+		t.Errorf("dry run modified records, %d records", count)
+	}
+	if records, _ := m.Audit.Query(ctx, audit.Query{}); len(records) != 0 {
+		t.Errorf("dry run is audited %v", records)
+	}
+}
+
+// TestApply
+func TestApply(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	m, store, cold := testManager(t, now)
+	report, err := m.Apply(ctx, now, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purge := report.Results[0]; purge.Processed != 1 || purge.Files != 1 {
+		t.Errorf("wrong purge result %+v", purge)
+	}
+	if archive := report.Results[2]; archive.Processed != 1 || archive.Files != 1 {
+		t.Errorf("wrong archive result %+v", archive)
+	}
+
+	// purged record and its file are removed
+	if _, err := storage.FindOne(ctx, store, "meta", map[string]any{"_id": "1"}); err == nil {
+		t.Error("purged record is kept")
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(cold), "data", "btr1.h5")); !os.IsNotExist(err) {
+		t.Errorf("file of purged record is kept, error %v", err)
+	}
+
+	// archived record is moved to archive collection along with its files
+	rec, err := storage.FindOne(ctx, store, "meta_archive", map[string]any{"_id": "2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	location, _ := rec["path"].(string)
+	if data, err := os.ReadFile(filepath.Join(location, "scan.h5")); err != nil || string(data) != "2" || !strings.HasPrefix(location, cold) {
+		t.Errorf("wrong archived files %s, error %v", location, err)
+	}
+	if _, ok := rec[ArchivedAtKey]; !ok {
+		t.Errorf("archived record without archival time %v", rec)
+	}
+	if count, _ := store.Count(ctx, "meta", map[string]any{}); count != 2 {
+		t.Errorf("wrong number of records %d", count)
+	}
+
+	// archived records are purged from archive collection
+	records, _ := m.Audit.Query(ctx, audit.Query{})
+	if len(records) != 2 {
+		t.Errorf("wrong audit records %v", records)
+	}
+	report, err = m.Apply(ctx, now.Add(365*24*time.Hour), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purge := report.Results[1]; purge.Collection != "meta_archive" || purge.Processed != 1 || purge.Files != 1 {
+		t.Errorf("wrong purge result %+v", purge)
+	}
+	if _, err := os.Stat(location); !os.IsNotExist(err) {
+		t.Errorf("files of purged record are kept, error %v", err)
+	}
+}
+
+// TestDirStore
+func TestDirStore(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	fname := filepath.Join(dir, "data", "a.h5")
+	os.MkdirAll(filepath.Dir(fname), 0755)
+	os.WriteFile(fname, []byte("a"), 0644)
+	cold := &DirStore{Dir: filepath.Join(dir, "cold")}
+	location, err := cold.Archive(ctx, fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if location != filepath.Join(dir, "cold", fname) {
+		t.Errorf("wrong location %s", location)
+	}
+	// archival of moved file is idempotent
+	for _, path := range []string{fname, location} {
+		if loc, err := cold.Archive(ctx, path); err != nil || loc != location {
+			t.Errorf("wrong location %s of %s, error %v", loc, path, err)
+		}
+	}
+	if err := cold.Remove(ctx, location); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cold.Archive(ctx, fname); err == nil {
+		t.Error("missing file is archived")
+	}
+}
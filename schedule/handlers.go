@@ -0,0 +1,104 @@
+package schedule
+
+// handlers module provides HTTP endpoints of run schedule, e.g. to let
+// frontend filter records by run cycle or beamtime window
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	server "github.com/CHESSComputing/golib/server"
+	services "github.com/CHESSComputing/golib/services"
+	"github.com/gin-gonic/gin"
+)
+
+// Current represents run cycle and beamtime window at given time
+type Current struct {
+	Time  time.Time `json:"time"`
+	Cycle *Cycle    `json:"cycle,omitempty"`
+	Run   *Run      `json:"run,omitempty"`
+}
+
+// helper function to abort request with service error
+func abort(c *gin.Context, code, srvCode int, err error) {
+	rec := services.Response("schedule", code, srvCode, err)
+	c.AbortWithStatusJSON(code, rec)
+}
+
+// helper function to get schedule of the request
+func (m *Manager) requestSchedule(c *gin.Context) (Schedule, bool) {
+	s, err := m.Schedule()
+	if err != nil {
+		abort(c, http.StatusServiceUnavailable, services.ServiceError, err)
+		return s, false
+	}
+	return s, true
+}
+
+// CyclesHandler provides run cycles
+func (m *Manager) CyclesHandler(c *gin.Context) {
+	s, ok := m.requestSchedule(c)
+	if !ok {
+		return
+	}
+	cycles := s.Cycles
+	if cycles == nil {
+		cycles = []Cycle{}
+	}
+	c.JSON(http.StatusOK, cycles)
+}
+
+// RunsHandler provides beamtime windows filtered by beamline and cycle
+// query parameters
+func (m *Manager) RunsHandler(c *gin.Context) {
+	s, ok := m.requestSchedule(c)
+	if !ok {
+		return
+	}
+	runs := s.Filter(c.Query("beamline"), c.Query("cycle"))
+	if runs == nil {
+		runs = []Run{}
+	}
+	c.JSON(http.StatusOK, runs)
+}
+
+// CurrentHandler provides run cycle and beamtime window of beamline query
+// parameter at time parameter (RFC 3339), default is current time
+func (m *Manager) CurrentHandler(c *gin.Context) {
+	s, ok := m.requestSchedule(c)
+	if !ok {
+		return
+	}
+	t := time.Now()
+	if val := c.Query("time"); val != "" {
+		var err error
+		if t, err = time.Parse(time.RFC3339, val); err != nil {
+			abort(c, http.StatusBadRequest, services.QueryError, errors.New("invalid time, expect RFC 3339 format"))
+			return
+		}
+	}
+	rec := Current{Time: t}
+	if cycle, ok := s.Cycle(t); ok {
+		rec.Cycle = &cycle
+	}
+	if beamline := c.Query("beamline"); beamline != "" {
+		if run, ok := s.Run(beamline, t); ok {
+			rec.Run = &run
+		}
+	}
+	c.JSON(http.StatusOK, rec)
+}
+
+// Routes returns server routes of run schedule under given path, e.g.
+// /schedule
+func (m *Manager) Routes(path string) []server.Route {
+	return []server.Route{
+		{Method: "GET", Path: path + "/cycles", Authorized: true, Scope: "read", Handler: m.CyclesHandler,
+			Summary: "list run cycles", Response: []Cycle{}},
+		{Method: "GET", Path: path + "/runs", Authorized: true, Scope: "read", Handler: m.RunsHandler,
+			Summary: "list beamtime windows of beamline and cycle", Response: []Run{}},
+		{Method: "GET", Path: path + "/current", Authorized: true, Scope: "read", Handler: m.CurrentHandler,
+			Summary: "get run cycle and beamtime window at given time", Response: Current{}},
+	}
+}
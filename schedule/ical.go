@@ -0,0 +1,164 @@
+package schedule
+
+// ical module parses beamline run schedules given either as iCalendar
+// (RFC 5545) feeds or JSON documents of facility scheduling API
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// iCalendar date-time layouts
+const (
+	icalDateTime    = "20060102T150405"
+	icalDateTimeUTC = "20060102T150405Z"
+	icalDate        = "20060102"
+)
+
+// helper function to unfold iCalendar content lines, continuation lines
+// start with space or tab
+func unfold(data []byte) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// helper function to unescape iCalendar text value
+func unescape(val string) string {
+	r := strings.NewReplacer(`\n`, "\n", `\N`, "\n", `\,`, ",", `\;`, ";", `\\`, `\`)
+	return r.Replace(val)
+}
+
+// helper function to parse iCalendar date or date-time with parameters of
+// the property, e.g. TZID=America/New_York or VALUE=DATE
+func parseICalTime(params map[string]string, val string) (time.Time, error) {
+	if params["VALUE"] == "DATE" || len(val) == len(icalDate) {
+		return time.ParseInLocation(icalDate, val, time.UTC)
+	}
+	if strings.HasSuffix(val, "Z") {
+		return time.Parse(icalDateTimeUTC, val)
+	}
+	loc := time.UTC
+	if tzid := params["TZID"]; tzid != "" {
+		l, err := time.LoadLocation(strings.Trim(tzid, `"`))
+		if err != nil {
+			return time.Time{}, fmt.Errorf("unknown time zone %s, error %v", tzid, err)
+		}
+		loc = l
+	}
+	return time.ParseInLocation(icalDateTime, val, loc)
+}
+
+// ParseICal parses iCalendar feed of run schedule. Events with LOCATION
+// are beamtime windows of beamline given by LOCATION, their CATEGORIES
+// provide run cycle and DESCRIPTION may provide proposal number. Events
+// without LOCATION are run cycles named by SUMMARY.
+func ParseICal(data []byte) (Schedule, error) {
+	var s Schedule
+	lines, err := unfold(data)
+	if err != nil {
+		return s, err
+	}
+	var event map[string]string
+	var params map[string]map[string]string
+	for i, line := range lines {
+		name, val, ok := strings.Cut(line, ":")
+		if !ok {
+			return s, fmt.Errorf("invalid iCalendar line %d '%s'", i+1, line)
+		}
+		parts := strings.Split(name, ";")
+		name = strings.ToUpper(parts[0])
+		switch {
+		case name == "BEGIN" && strings.EqualFold(val, "VEVENT"):
+			event = make(map[string]string)
+			params = make(map[string]map[string]string)
+			continue
+		case name == "END" && strings.EqualFold(val, "VEVENT"):
+			if event == nil {
+				return s, fmt.Errorf("unexpected END:VEVENT at line %d", i+1)
+			}
+			if err := s.addEvent(event, params); err != nil {
+				return s, err
+			}
+			event = nil
+			continue
+		}
+		if event == nil {
+			continue
+		}
+		p := make(map[string]string)
+		for _, param := range parts[1:] {
+			if k, v, ok := strings.Cut(param, "="); ok {
+				p[strings.ToUpper(k)] = v
+			}
+		}
+		event[name] = val
+		params[name] = p
+	}
+	if event != nil {
+		return s, fmt.Errorf("unterminated VEVENT")
+	}
+	s.sort()
+	return s, nil
+}
+
+// helper function to add iCalendar event to the schedule
+func (s *Schedule) addEvent(event map[string]string, params map[string]map[string]string) error {
+	start, err := parseICalTime(params["DTSTART"], event["DTSTART"])
+	if err != nil {
+		return fmt.Errorf("invalid DTSTART of event %s, error %v", event["UID"], err)
+	}
+	end, err := parseICalTime(params["DTEND"], event["DTEND"])
+	if err != nil {
+		return fmt.Errorf("invalid DTEND of event %s, error %v", event["UID"], err)
+	}
+	summary := unescape(event["SUMMARY"])
+	location := strings.TrimSpace(unescape(event["LOCATION"]))
+	if location == "" {
+		s.Cycles = append(s.Cycles, Cycle{Name: summary, Start: start, End: end})
+		return nil
+	}
+	cycle, _, _ := strings.Cut(unescape(event["CATEGORIES"]), ",")
+	run := Run{
+		ID:       event["UID"],
+		Beamline: location,
+		Cycle:    strings.TrimSpace(cycle),
+		Title:    summary,
+		Start:    start,
+		End:      end,
+	}
+	for _, line := range strings.Split(unescape(event["DESCRIPTION"]), "\n") {
+		if k, v, ok := strings.Cut(line, ":"); ok && strings.EqualFold(strings.TrimSpace(k), "proposal") {
+			run.Proposal = strings.TrimSpace(v)
+		}
+	}
+	s.Runs = append(s.Runs, run)
+	return nil
+}
+
+// ParseJSON parses JSON document of facility scheduling API, e.g.
+// {"cycles": [{"name": "2024-2", "start": ..., "end": ...}], "runs": [...]}
+// with RFC 3339 times
+func ParseJSON(data []byte) (Schedule, error) {
+	var s Schedule
+	if err := json.Unmarshal(data, &s); err != nil {
+		return s, fmt.Errorf("unable to parse schedule, error %v", err)
+	}
+	s.sort()
+	return s, nil
+}
@@ -0,0 +1,382 @@
+package schedule
+
+// schedule module ingests beamline run schedule, either iCalendar feed or
+// facility scheduling API, and resolves run cycle and beamtime window of
+// beamline at given time. Services use it to tag incoming records with run
+// cycle and beamtime window and frontend to filter records by run.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+)
+
+// defaults of run schedule
+var (
+	DefaultRefresh     = time.Hour
+	DefaultTimeout     = 30 * time.Second
+	DefaultBeamlineKey = "beamline"
+	DefaultDateKey     = "Date"
+	DefaultCycleKey    = "run_cycle"
+	DefaultBeamtimeKey = "beamtime"
+)
+
+// supported formats of run schedule
+const (
+	FormatICal = "ical"
+	FormatJSON = "json"
+)
+
+// errors of schedule module
+var (
+	ErrNotLoaded = errors.New("run schedule is not loaded")
+	ErrFormat    = errors.New("unsupported run schedule format")
+)
+
+// Cycle represents run cycle of the facility
+type Cycle struct {
+	Name  string    `json:"name"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// Run represents beamtime window of the beamline
+type Run struct {
+	ID       string    `json:"id"`
+	Beamline string    `json:"beamline"`
+	Cycle    string    `json:"cycle,omitempty"`
+	Proposal string    `json:"proposal,omitempty"`
+	Title    string    `json:"title,omitempty"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+}
+
+// Schedule represents run schedule of the facility
+type Schedule struct {
+	Cycles []Cycle `json:"cycles"`
+	Runs   []Run   `json:"runs"`
+}
+
+// helper function to check if time belongs to [start, end) window
+func within(t, start, end time.Time) bool {
+	return !t.Before(start) && t.Before(end)
+}
+
+// helper function to sort schedule and fill missing run attributes
+func (s *Schedule) sort() {
+	sort.SliceStable(s.Cycles, func(i, j int) bool { return s.Cycles[i].Start.Before(s.Cycles[j].Start) })
+	sort.SliceStable(s.Runs, func(i, j int) bool { return s.Runs[i].Start.Before(s.Runs[j].Start) })
+	for i := range s.Runs {
+		run := &s.Runs[i]
+		if run.Cycle == "" {
+			if c, ok := s.Cycle(run.Start); ok {
+				run.Cycle = c.Name
+			}
+		}
+		if run.ID == "" {
+			run.ID = fmt.Sprintf("%s-%s", run.Beamline, run.Start.UTC().Format("20060102T150405Z"))
+		}
+	}
+}
+
+// Cycle returns run cycle at given time
+func (s Schedule) Cycle(t time.Time) (Cycle, bool) {
+	for _, c := range s.Cycles {
+		if within(t, c.Start, c.End) {
+			return c, true
+		}
+	}
+	return Cycle{}, false
+}
+
+// Run returns beamtime window of beamline at given time
+func (s Schedule) Run(beamline string, t time.Time) (Run, bool) {
+	for _, r := range s.Runs {
+		if strings.EqualFold(r.Beamline, beamline) && within(t, r.Start, r.End) {
+			return r, true
+		}
+	}
+	return Run{}, false
+}
+
+// Filter returns beamtime windows of given beamline and cycle, empty
+// values match all beamlines or cycles
+func (s Schedule) Filter(beamline, cycle string) []Run {
+	var out []Run
+	for _, r := range s.Runs {
+		if beamline != "" && !strings.EqualFold(r.Beamline, beamline) {
+			continue
+		}
+		if cycle != "" && r.Cycle != cycle {
+			continue
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+// Parse parses run schedule of given format
+func Parse(data []byte, format string) (Schedule, error) {
+	switch format {
+	case FormatICal:
+		return ParseICal(data)
+	case FormatJSON:
+		return ParseJSON(data)
+	}
+	return Schedule{}, fmt.Errorf("%w: %s", ErrFormat, format)
+}
+
+// Manager represents run schedule loaded from its source
+type Manager struct {
+	Source      string        // URL or file of run schedule
+	Format      string        // format of run schedule, guessed from the source if empty
+	Token       string        // bearer token of facility scheduling API
+	Refresh     time.Duration // interval of schedule reload
+	BeamlineKey string        // record key of beamline
+	DateKey     string        // record key of record date in seconds since epoch
+	CycleKey    string        // record key of assigned run cycle
+	BeamtimeKey string        // record key of assigned beamtime window
+	HttpClient  *http.Client  // HTTP client
+	Verbose     int           // verbosity level
+
+	mu       sync.RWMutex
+	schedule *Schedule
+}
+
+// Runs represents global run schedule, it should be initialized via Init
+// function
+var Runs *Manager
+
+// NewManager returns run schedule manager of given configuration
+func NewManager(cfg srvConfig.Schedule) *Manager {
+	m := &Manager{
+		Source:      cfg.Source,
+		Format:      strings.ToLower(cfg.Format),
+		Token:       cfg.Token,
+		Refresh:     cfg.Refresh,
+		BeamlineKey: cfg.BeamlineKey,
+		DateKey:     cfg.DateKey,
+		CycleKey:    cfg.CycleKey,
+		BeamtimeKey: cfg.BeamtimeKey,
+		HttpClient:  &http.Client{Timeout: DefaultTimeout},
+	}
+	if m.Refresh == 0 {
+		m.Refresh = DefaultRefresh
+	}
+	if m.BeamlineKey == "" {
+		m.BeamlineKey = DefaultBeamlineKey
+	}
+	if m.DateKey == "" {
+		m.DateKey = DefaultDateKey
+	}
+	if m.CycleKey == "" {
+		m.CycleKey = DefaultCycleKey
+	}
+	if m.BeamtimeKey == "" {
+		m.BeamtimeKey = DefaultBeamtimeKey
+	}
+	return m
+}
+
+// Init initializes global run schedule of Schedule configuration and loads
+// it from its source
+func Init() error {
+	if srvConfig.Config == nil {
+		return errors.New("server configuration is not initialized")
+	}
+	cfg := srvConfig.Config.Schedule
+	if cfg.Source == "" {
+		return errors.New("run schedule source is not configured")
+	}
+	m := NewManager(cfg)
+	if err := m.Load(context.Background()); err != nil {
+		return err
+	}
+	Runs = m
+	return nil
+}
+
+// helper function to read run schedule from the source, it returns data
+// and content type of the source
+func (m *Manager) read(ctx context.Context) ([]byte, string, error) {
+	if !strings.HasPrefix(m.Source, "http://") && !strings.HasPrefix(m.Source, "https://") {
+		data, err := os.ReadFile(m.Source)
+		return data, "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", m.Source, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Accept", "text/calendar, application/json")
+	if m.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+m.Token)
+	}
+	client := m.HttpClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("unable to fetch run schedule %s, status %s", m.Source, resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	return data, resp.Header.Get("Content-Type"), err
+}
+
+// helper function to guess format of run schedule
+func (m *Manager) format(contentType string, data []byte) string {
+	if m.Format != "" {
+		return m.Format
+	}
+	switch {
+	case strings.HasPrefix(contentType, "text/calendar"):
+		return FormatICal
+	case strings.HasPrefix(contentType, "application/json"):
+		return FormatJSON
+	}
+	switch strings.ToLower(filepath.Ext(m.Source)) {
+	case ".ics", ".ical":
+		return FormatICal
+	case ".json":
+		return FormatJSON
+	}
+	if json.Valid(data) {
+		return FormatJSON
+	}
+	return FormatICal
+}
+
+// Load loads run schedule from its source, previous schedule is kept if
+// schedule can not be loaded
+func (m *Manager) Load(ctx context.Context) error {
+	data, contentType, err := m.read(ctx)
+	if err != nil {
+		log.Printf("ERROR: unable to read run schedule %s, error %v", m.Source, err)
+		return err
+	}
+	s, err := Parse(data, m.format(contentType, data))
+	if err != nil {
+		log.Printf("ERROR: unable to parse run schedule %s, error %v", m.Source, err)
+		return err
+	}
+	m.Set(s)
+	if m.Verbose > 0 {
+		log.Printf("schedule: loaded %d cycles and %d runs of %s", len(s.Cycles), len(s.Runs), m.Source)
+	}
+	return nil
+}
+
+// Set replaces run schedule of the manager
+func (m *Manager) Set(s Schedule) {
+	s.sort()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.schedule = &s
+}
+
+// Schedule returns current run schedule
+func (m *Manager) Schedule() (Schedule, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.schedule == nil {
+		return Schedule{}, ErrNotLoaded
+	}
+	return *m.schedule, nil
+}
+
+// Run reloads run schedule periodically until context is canceled
+func (m *Manager) Run(ctx context.Context) {
+	if m.Refresh <= 0 {
+		return
+	}
+	ticker := time.NewTicker(m.Refresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.Load(ctx)
+		}
+	}
+}
+
+// helper function to get time of record date value, numbers are seconds
+// since epoch
+func dateValue(val any) (time.Time, bool) {
+	switch v := val.(type) {
+	case time.Time:
+		return v, true
+	case int:
+		return time.Unix(int64(v), 0), true
+	case int32:
+		return time.Unix(int64(v), 0), true
+	case int64:
+		return time.Unix(v, 0), true
+	case float64:
+		return time.Unix(int64(v), 0), true
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return time.Unix(n, 0), true
+		}
+	case string:
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			return t, true
+		}
+	case interface{ Time() time.Time }:
+		// e.g. MongoDB DateTime
+		return v.Time(), true
+	}
+	return time.Time{}, false
+}
+
+// Tag assigns run cycle and beamtime window to the record according to its
+// beamline and date, records without date are tagged by current time.
+// Existing tags are kept. It returns true if record is tagged.
+func (m *Manager) Tag(rec map[string]any) bool {
+	s, err := m.Schedule()
+	if err != nil {
+		return false
+	}
+	t := time.Now()
+	if val, ok := rec[m.DateKey]; ok {
+		if d, ok := dateValue(val); ok {
+			t = d
+		}
+	}
+	var tagged bool
+	beamline, _ := rec[m.BeamlineKey].(string)
+	if run, ok := s.Run(beamline, t); beamline != "" && ok {
+		if _, ok := rec[m.BeamtimeKey]; !ok {
+			rec[m.BeamtimeKey] = run.ID
+			tagged = true
+		}
+		if _, ok := rec[m.CycleKey]; !ok && run.Cycle != "" {
+			rec[m.CycleKey] = run.Cycle
+			tagged = true
+		}
+	}
+	if c, ok := s.Cycle(t); ok {
+		if _, ok := rec[m.CycleKey]; !ok {
+			rec[m.CycleKey] = c.Name
+			tagged = true
+		}
+	}
+	return tagged
+}
@@ -0,0 +1,182 @@
+package schedule
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	storage "github.com/CHESSComputing/golib/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// test run schedule in iCalendar format
+var testICal = strings.ReplaceAll(`BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//CHESS//Run Schedule//EN
+BEGIN:VEVENT
+UID:cycle-2024-2
+DTSTART;VALUE=DATE:20240601
+DTEND;VALUE=DATE:20240901
+SUMMARY:2024-2
+END:VEVENT
+BEGIN:VEVENT
+UID:bt-1
+DTSTART:20240610T120000Z
+DTEND:20240614T120000Z
+SUMMARY:Strain mapping\, in situ
+LOCATION:id3a
+CATEGORIES:2024-2
+DESCRIPTION:Proposal: 1234\nPI: Alice Doe
+END:VEVENT
+BEGIN:VEVENT
+UID:bt-2
+DTSTART;TZID=America/New_York:20240614T080000
+DTEND;TZID=America/New_York:20240618T080000
+SUMMARY:Powder diffraction of long
+  titled sample
+LOCATION:id3a
+END:VEVENT
+END:VCALENDAR
+`, "\n", "\r\n")
+
+// test run schedule of facility scheduling API
+var testJSON = `{"cycles": [{"name": "2024-2", "start": "2024-06-01T00:00:00Z", "end": "2024-09-01T00:00:00Z"}],
+"runs": [{"beamline": "id1a3", "proposal": "5678", "start": "2024-06-10T12:00:00Z", "end": "2024-06-12T12:00:00Z"}]}`
+
+// TestParseICal
+func TestParseICal(t *testing.T) {
+	s, err := ParseICal([]byte(testICal))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Cycles) != 1 || s.Cycles[0].Name != "2024-2" || len(s.Runs) != 2 {
+		t.Fatalf("wrong schedule %+v", s)
+	}
+	run := s.Runs[0]
+	if run.ID != "bt-1" || run.Beamline != "id3a" || run.Cycle != "2024-2" || run.Proposal != "1234" || run.Title != "Strain mapping, in situ" {
+		t.Errorf("wrong run %+v", run)
+	}
+	run = s.Runs[1]
+	// cycle of runs without categories is resolved by their start
+	if run.Cycle != "2024-2" || run.Title != "Powder diffraction of long titled sample" {
+		t.Errorf("wrong run %+v", run)
+	}
+	if start := time.Date(2024, 6, 14, 12, 0, 0, 0, time.UTC); !run.Start.Equal(start) {
+		t.Errorf("wrong start of run in local time zone %v", run.Start)
+	}
+	if r, ok := s.Run("ID3A", time.Date(2024, 6, 14, 11, 0, 0, 0, time.UTC)); !ok || r.ID != "bt-1" {
+		t.Errorf("wrong run of id3a %+v", r)
+	}
+	if _, ok := s.Run("id3a", time.Date(2024, 6, 20, 0, 0, 0, 0, time.UTC)); ok {
+		t.Error("run is found outside of beamtime windows")
+	}
+	if _, err := ParseICal([]byte("BEGIN:VEVENT\nDTSTART:bad\nDTEND:bad\nEND:VEVENT\n")); err == nil {
+		t.Error("invalid event is accepted")
+	}
+	if _, err := ParseICal([]byte("BEGIN:VEVENT\nSUMMARY:run\n")); err == nil {
+		t.Error("unterminated event is accepted")
+	}
+}
+
+// TestTag
+func TestTag(t *testing.T) {
+	dir := t.TempDir()
+	fname := filepath.Join(dir, "schedule.json")
+	if err := os.WriteFile(fname, []byte(testJSON), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m := NewManager(srvConfig.Schedule{Source: fname})
+	if m.Tag(map[string]any{"beamline": "id1a3"}) {
+		t.Error("record is tagged without schedule")
+	}
+	if err := m.Load(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	date := time.Date(2024, 6, 11, 0, 0, 0, 0, time.UTC).Unix()
+	ctx := context.Background()
+	store := NewStore(storage.NewMemoryStore(), m)
+	records := []map[string]any{
+		{"did": "a", "beamline": "id1a3", "Date": float64(date)},
+		{"did": "b", "beamline": "id3a", "Date": date},
+		{"did": "c", "beamline": "id1a3", "Date": date, "run_cycle": "2023-3"},
+		{"did": "d", "beamline": "id1a3"},
+	}
+	if err := store.Insert(ctx, "meta", records...); err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string][2]any{
+		"a": {"2024-2", "id1a3-20240610T120000Z"},
+		"b": {"2024-2", nil},
+		"c": {"2023-3", "id1a3-20240610T120000Z"},
+		"d": {nil, nil},
+	}
+	for did, tags := range expect {
+		rec, err := storage.FindOne(ctx, store, "meta", map[string]any{"did": did})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec["run_cycle"] != tags[0] || rec["beamtime"] != tags[1] {
+			t.Errorf("record %s: expect tags %v, got %v %v", did, tags, rec["run_cycle"], rec["beamtime"])
+		}
+	}
+
+	// records may be filtered by run
+	if n, err := store.Count(ctx, "meta", map[string]any{"run_cycle": "2024-2"}); err != nil || n != 2 {
+		t.Errorf("expect 2 records of cycle, got %d, error %v", n, err)
+	}
+}
+
+// TestHandlers
+func TestHandlers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "text/calendar")
+		w.Write([]byte(testICal))
+	}))
+	defer server.Close()
+	m := NewManager(srvConfig.Schedule{Source: server.URL + "/schedule"})
+	r := gin.New()
+	for _, route := range m.Routes("/schedule") {
+		r.Handle(route.Method, route.Path, route.Handler)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/schedule/cycles", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expect %d without schedule, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+	if err := m.Load(context.Background()); err == nil {
+		t.Error("schedule is loaded without token")
+	}
+	m.Token = "secret"
+	if err := m.Load(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	expect := []struct {
+		target string
+		code   int
+		body   string
+	}{
+		{"/schedule/cycles", http.StatusOK, `"name":"2024-2"`},
+		{"/schedule/runs?beamline=id3a&cycle=2024-2", http.StatusOK, `"id":"bt-2"`},
+		{"/schedule/runs?beamline=id1a3", http.StatusOK, `[]`},
+		{"/schedule/current?beamline=id3a&time=2024-06-11T00:00:00Z", http.StatusOK, `"proposal":"1234"`},
+		{"/schedule/current?time=yesterday", http.StatusBadRequest, ""},
+	}
+	for _, e := range expect {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", e.target, nil))
+		if w.Code != e.code || !strings.Contains(w.Body.String(), e.body) {
+			t.Errorf("%s: expect %d %s, got %d %s", e.target, e.code, e.body, w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package schedule
+
+import (
+	"context"
+
+	storage "github.com/CHESSComputing/golib/storage"
+)
+
+// Store represents storage which tags inserted records with run cycle and
+// beamtime window of the run schedule
+type Store struct {
+	Store   storage.Store
+	Manager *Manager
+}
+
+// NewStore returns run tagging storage
+func NewStore(store storage.Store, m *Manager) *Store {
+	return &Store{Store: store, Manager: m}
+}
+
+// Insert implements storage.Store interface
+func (s *Store) Insert(ctx context.Context, collection string, records ...map[string]any) error {
+	for _, rec := range records {
+		s.Manager.Tag(rec)
+	}
+	return s.Store.Insert(ctx, collection, records...)
+}
+
+// Find implements storage.Store interface
+func (s *Store) Find(ctx context.Context, collection string, spec map[string]any, opts *storage.FindOptions) ([]map[string]any, error) {
+	return s.Store.Find(ctx, collection, spec, opts)
+}
+
+// Update implements storage.Store interface
+func (s *Store) Update(ctx context.Context, collection string, spec, fields map[string]any) (int64, error) {
+	return s.Store.Update(ctx, collection, spec, fields)
+}
+
+// Count implements storage.Store interface
+func (s *Store) Count(ctx context.Context, collection string, spec map[string]any) (int64, error) {
+	return s.Store.Count(ctx, collection, spec)
+}
+
+// Remove implements storage.Store interface
+func (s *Store) Remove(ctx context.Context, collection string, spec map[string]any) (int64, error) {
+	return s.Store.Remove(ctx, collection, spec)
+}
@@ -0,0 +1,167 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+
+	storage "github.com/CHESSComputing/golib/storage"
+)
+
+// BM25 ranking parameters of embedded index
+var (
+	BM25K1 = 1.2
+	BM25B  = 0.75
+)
+
+// document represents indexed record
+type document struct {
+	record map[string]any
+	terms  map[string]int // term frequencies
+	length int            // number of terms
+}
+
+// MemoryIndex represents embedded in-memory inverted index, records are
+// ranked by BM25 score
+type MemoryIndex struct {
+	IDKey    string   // record key used as document id
+	Fields   []string // indexed fields, all string fields if empty
+	mu       sync.RWMutex
+	docs     map[string]*document
+	postings map[string]map[string]bool // term to document ids
+	total    int                        // total number of terms of all documents
+}
+
+// NewMemoryIndex returns new embedded index, records are identified by
+// idKey ("did" if it is empty)
+func NewMemoryIndex(idKey string, fields []string) *MemoryIndex {
+	if idKey == "" {
+		idKey = "did"
+	}
+	return &MemoryIndex{
+		IDKey:    idKey,
+		Fields:   fields,
+		docs:     make(map[string]*document),
+		postings: make(map[string]map[string]bool),
+	}
+}
+
+// helper function to remove document from index, it should be called
+// with acquired lock
+func (m *MemoryIndex) remove(id string) {
+	doc, ok := m.docs[id]
+	if !ok {
+		return
+	}
+	for term := range doc.terms {
+		delete(m.postings[term], id)
+		if len(m.postings[term]) == 0 {
+			delete(m.postings, term)
+		}
+	}
+	m.total -= doc.length
+	delete(m.docs, id)
+}
+
+// Index implements Index interface
+func (m *MemoryIndex) Index(ctx context.Context, id string, rec map[string]any) error {
+	if id == "" {
+		return fmt.Errorf("record without %s", m.IDKey)
+	}
+	doc := &document{record: rec, terms: make(map[string]int)}
+	for _, text := range TextFields(rec, m.Fields) {
+		for _, term := range Tokenize(text) {
+			doc.terms[term]++
+			doc.length++
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.remove(id)
+	m.docs[id] = doc
+	m.total += doc.length
+	for term := range doc.terms {
+		if m.postings[term] == nil {
+			m.postings[term] = make(map[string]bool)
+		}
+		m.postings[term][id] = true
+	}
+	return nil
+}
+
+// Delete implements Index interface
+func (m *MemoryIndex) Delete(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.remove(id)
+	return nil
+}
+
+// Len returns number of indexed records
+func (m *MemoryIndex) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.docs)
+}
+
+// helper function to check if record matches equality spec
+func matchSpec(rec, spec map[string]any) bool {
+	for k, v := range spec {
+		if fmt.Sprintf("%v", rec[k]) != fmt.Sprintf("%v", v) {
+			return false
+		}
+	}
+	return true
+}
+
+// Search implements Index interface, records which contain any of query
+// terms are ranked by BM25 score
+func (m *MemoryIndex) Search(ctx context.Context, q Query) (Results, error) {
+	terms := Tokenize(q.Text)
+	if len(terms) == 0 {
+		return Results{}, ErrEmptyQuery
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	ndocs := float64(len(m.docs))
+	avgLength := 0.0
+	if ndocs > 0 {
+		avgLength = float64(m.total) / ndocs
+	}
+	scores := make(map[string]float64)
+	for _, term := range terms {
+		ids := m.postings[term]
+		if len(ids) == 0 {
+			continue
+		}
+		df := float64(len(ids))
+		idf := math.Log(1 + (ndocs-df+0.5)/(df+0.5))
+		for id := range ids {
+			doc := m.docs[id]
+			tf := float64(doc.terms[term])
+			norm := 1 - BM25B + BM25B*float64(doc.length)/avgLength
+			scores[id] += idf * tf * (BM25K1 + 1) / (tf + BM25K1*norm)
+		}
+	}
+	var hits []Hit
+	for id, score := range scores {
+		doc := m.docs[id]
+		if !matchSpec(doc.record, q.Spec) {
+			continue
+		}
+		hits = append(hits, Hit{ID: id, Score: score, Record: doc.record})
+	}
+	results := pageHits(hits, q)
+	for i := range results.Hits {
+		results.Hits[i].Highlights = Highlights(results.Hits[i].Record, m.Fields, terms)
+	}
+	return results, nil
+}
+
+// Rebuild indexes all records of storage collection, e.g. at service start
+// before index is kept in sync via message bus events
+func (m *MemoryIndex) Rebuild(ctx context.Context, store storage.Store, collection string) error {
+	_, err := Reindex(ctx, m, store, collection, m.IDKey)
+	return err
+}
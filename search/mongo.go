@@ -0,0 +1,128 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	mongo "github.com/CHESSComputing/golib/mongo"
+	bson "go.mongodb.org/mongo-driver/bson"
+	mongoDriver "go.mongodb.org/mongo-driver/mongo"
+	mongoOptions "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TextIndexName defines name of MongoDB text index
+const TextIndexName = "search_text"
+
+// MongoIndex represents search backend based on MongoDB text index. The
+// index is maintained by MongoDB itself, therefore Index and Delete
+// methods do not modify records.
+type MongoIndex struct {
+	DBName     string
+	Collection string
+	IDKey      string         // record key used as document id
+	Fields     []string       // indexed fields, all string fields if empty
+	Weights    map[string]int // optional weights of indexed fields
+	Language   string         // default language of text index
+}
+
+// NewMongoIndex returns MongoDB text index backend, records are identified
+// by idKey ("did" if it is empty)
+func NewMongoIndex(dbname, collection, idKey string, fields []string) *MongoIndex {
+	if idKey == "" {
+		idKey = "did"
+	}
+	return &MongoIndex{DBName: dbname, Collection: collection, IDKey: idKey, Fields: fields}
+}
+
+// helper function to get MongoDB collection
+func (m *MongoIndex) collection() *mongoDriver.Collection {
+	return mongo.Mongo.Connect().Database(m.DBName).Collection(m.Collection)
+}
+
+// EnsureIndex creates text index of configured fields, wildcard text index
+// is used if fields are not provided. MongoDB allows single text index per
+// collection.
+func (m *MongoIndex) EnsureIndex(ctx context.Context) error {
+	keys := bson.D{}
+	if len(m.Fields) == 0 {
+		keys = append(keys, bson.E{Key: "$**", Value: "text"})
+	}
+	for _, f := range m.Fields {
+		keys = append(keys, bson.E{Key: f, Value: "text"})
+	}
+	opts := mongoOptions.Index().SetName(TextIndexName)
+	if len(m.Weights) > 0 {
+		weights := bson.M{}
+		for k, v := range m.Weights {
+			weights[k] = v
+		}
+		opts = opts.SetWeights(weights)
+	}
+	if m.Language != "" {
+		opts = opts.SetDefaultLanguage(m.Language)
+	}
+	model := mongoDriver.IndexModel{Keys: keys, Options: opts}
+	if _, err := m.collection().Indexes().CreateOne(ctx, model); err != nil {
+		log.Printf("ERROR: unable to create text index of %s.%s, error %v", m.DBName, m.Collection, err)
+		return err
+	}
+	return nil
+}
+
+// Index implements Index interface, records are indexed by MongoDB
+func (m *MongoIndex) Index(ctx context.Context, id string, rec map[string]any) error {
+	return nil
+}
+
+// Delete implements Index interface, records are removed from index by
+// MongoDB
+func (m *MongoIndex) Delete(ctx context.Context, id string) error {
+	return nil
+}
+
+// Search implements Index interface, records are ranked by MongoDB text
+// score and highlights are computed from returned records
+func (m *MongoIndex) Search(ctx context.Context, q Query) (Results, error) {
+	terms := Tokenize(q.Text)
+	if len(terms) == 0 {
+		return Results{}, ErrEmptyQuery
+	}
+	filter := bson.M{}
+	for k, v := range q.Spec {
+		filter[k] = v
+	}
+	filter["$text"] = bson.M{"$search": q.Text}
+	c := m.collection()
+	total, err := c.CountDocuments(ctx, filter)
+	if err != nil {
+		log.Printf("ERROR: unable to count search hits, error %v", err)
+		return Results{}, err
+	}
+	score := bson.M{"$meta": "textScore"}
+	opts := mongoOptions.Find().
+		SetProjection(bson.M{"score": score}).
+		SetSort(bson.D{{Key: "score", Value: score}}).
+		SetSkip(int64(q.Skip)).
+		SetLimit(int64(queryLimit(q.Limit)))
+	cur, err := c.Find(ctx, filter, opts)
+	if err != nil {
+		log.Printf("ERROR: unable to search records, error %v", err)
+		return Results{}, err
+	}
+	var records []map[string]any
+	if err := cur.All(ctx, &records); err != nil {
+		return Results{}, err
+	}
+	out := Results{Total: int(total), Hits: []Hit{}}
+	for _, rec := range records {
+		hit := Hit{ID: fmt.Sprintf("%v", rec[m.IDKey]), Record: rec}
+		if s, ok := rec["score"].(float64); ok {
+			hit.Score = s
+		}
+		delete(rec, "score")
+		hit.Highlights = Highlights(rec, m.Fields, terms)
+		out.Hits = append(out.Hits, hit)
+	}
+	return out, nil
+}
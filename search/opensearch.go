@@ -0,0 +1,413 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+)
+
+// OpenSearchIndex represents search backend based on OpenSearch or
+// Elasticsearch REST API
+type OpenSearchIndex struct {
+	URL       string
+	IndexName string
+	Username  string
+	Password  string
+	IDKey     string   // record key used as document id
+	Fields    []string // searched fields, all fields if empty
+	Shards    int
+	Replicas  int
+	Client    *http.Client
+	Verbose   int
+}
+
+// NewOpenSearchIndex returns OpenSearch backend for given configuration,
+// records are identified by idKey ("did" if it is empty)
+func NewOpenSearchIndex(cfg srvConfig.OpenSearch, idKey string, fields []string) (*OpenSearchIndex, error) {
+	if cfg.URL == "" || cfg.IndexName == "" {
+		return nil, errors.New("opensearch backend requires URL and Index")
+	}
+	if idKey == "" {
+		idKey = "did"
+	}
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	client := &http.Client{Timeout: timeout}
+	if cfg.RootCAs != "" {
+		data, err := os.ReadFile(cfg.RootCAs)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("unable to load root CAs from %s", cfg.RootCAs)
+		}
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}}
+	}
+	return &OpenSearchIndex{
+		URL:       strings.TrimSuffix(cfg.URL, "/"),
+		IndexName: cfg.IndexName,
+		Username:  cfg.Username,
+		Password:  cfg.Password,
+		IDKey:     idKey,
+		Fields:    fields,
+		Shards:    cfg.Shards,
+		Replicas:  cfg.Replicas,
+		Client:    client,
+	}, nil
+}
+
+// helper function to perform request to OpenSearch, it returns response
+// body and error for non 2xx responses
+func (o *OpenSearchIndex) do(ctx context.Context, method, path, ctype string, body []byte) ([]byte, int, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, o.URL+path, reader)
+	if err != nil {
+		return nil, 0, err
+	}
+	if ctype != "" {
+		req.Header.Set("Content-Type", ctype)
+	}
+	if o.Username != "" {
+		req.SetBasicAuth(o.Username, o.Password)
+	}
+	resp, err := o.Client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, resp.StatusCode, err
+	}
+	if o.Verbose > 1 {
+		log.Printf("opensearch %s %s status %d", method, path, resp.StatusCode)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return data, resp.StatusCode, fmt.Errorf("opensearch %s %s failed with status %d: %s", method, path, resp.StatusCode, string(data))
+	}
+	return data, resp.StatusCode, nil
+}
+
+// helper function to send JSON request
+func (o *OpenSearchIndex) doJSON(ctx context.Context, method, path string, payload any) ([]byte, int, error) {
+	var body []byte
+	if payload != nil {
+		var err error
+		if body, err = json.Marshal(payload); err != nil {
+			return nil, 0, err
+		}
+	}
+	return o.do(ctx, method, path, "application/json", body)
+}
+
+// helper function to provide document path
+func (o *OpenSearchIndex) docPath(id string) string {
+	return fmt.Sprintf("/%s/_doc/%s", url.PathEscape(o.IndexName), url.PathEscape(id))
+}
+
+// Template returns index template of the index, string fields are indexed
+// as text with keyword sub-field used by exact filters
+func (o *OpenSearchIndex) Template() map[string]any {
+	settings := map[string]any{}
+	if o.Shards > 0 {
+		settings["number_of_shards"] = o.Shards
+	}
+	if o.Replicas > 0 {
+		settings["number_of_replicas"] = o.Replicas
+	}
+	mappings := map[string]any{
+		"dynamic_templates": []any{
+			map[string]any{"strings": map[string]any{
+				"match_mapping_type": "string",
+				"mapping": map[string]any{
+					"type":   "text",
+					"fields": map[string]any{"keyword": map[string]any{"type": "keyword", "ignore_above": 256}},
+				},
+			}},
+		},
+		"properties": map[string]any{
+			o.IDKey: map[string]any{"type": "keyword"},
+		},
+	}
+	return map[string]any{
+		"index_patterns": []string{o.IndexName + "*"},
+		"template":       map[string]any{"settings": settings, "mappings": mappings},
+	}
+}
+
+// EnsureTemplate creates or updates index template of the index, it should
+// be called before the index is created
+func (o *OpenSearchIndex) EnsureTemplate(ctx context.Context) error {
+	path := fmt.Sprintf("/_index_template/%s-template", url.PathEscape(o.IndexName))
+	if _, _, err := o.doJSON(ctx, "PUT", path, o.Template()); err != nil {
+		log.Println("ERROR: unable to create index template", err)
+		return err
+	}
+	return nil
+}
+
+// Index implements Index interface
+func (o *OpenSearchIndex) Index(ctx context.Context, id string, rec map[string]any) error {
+	if id == "" {
+		return fmt.Errorf("record without %s", o.IDKey)
+	}
+	_, _, err := o.doJSON(ctx, "PUT", o.docPath(id), rec)
+	return err
+}
+
+// Delete implements Index interface, missing documents are ignored
+func (o *OpenSearchIndex) Delete(ctx context.Context, id string) error {
+	_, code, err := o.doJSON(ctx, "DELETE", o.docPath(id), nil)
+	if code == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
+
+// SearchQuery returns OpenSearch query of given search query
+func (o *OpenSearchIndex) SearchQuery(q Query) map[string]any {
+	fields := o.Fields
+	if len(fields) == 0 {
+		fields = []string{"*"}
+	}
+	must := []any{
+		map[string]any{"multi_match": map[string]any{"query": q.Text, "fields": fields}},
+	}
+	var filters []any
+	for k, v := range q.Spec {
+		key := k
+		if _, ok := v.(string); ok && k != o.IDKey {
+			key = k + ".keyword"
+		}
+		filters = append(filters, map[string]any{"term": map[string]any{key: v}})
+	}
+	boolQuery := map[string]any{"must": must}
+	if len(filters) > 0 {
+		boolQuery["filter"] = filters
+	}
+	highlightFields := map[string]any{}
+	for _, f := range fields {
+		highlightFields[f] = map[string]any{}
+	}
+	return map[string]any{
+		"from":             q.Skip,
+		"size":             queryLimit(q.Limit),
+		"track_total_hits": true,
+		"query":            map[string]any{"bool": boolQuery},
+		"highlight": map[string]any{
+			"pre_tags":      []string{HighlightPre},
+			"post_tags":     []string{HighlightPost},
+			"fragment_size": FragmentSize,
+			"fields":        highlightFields,
+		},
+	}
+}
+
+// searchResponse represents response of OpenSearch search API
+type searchResponse struct {
+	Hits struct {
+		Total struct {
+			Value int `json:"value"`
+		} `json:"total"`
+		Hits []struct {
+			ID        string              `json:"_id"`
+			Score     float64             `json:"_score"`
+			Source    map[string]any      `json:"_source"`
+			Highlight map[string][]string `json:"highlight"`
+		} `json:"hits"`
+	} `json:"hits"`
+}
+
+// Search implements Index interface
+func (o *OpenSearchIndex) Search(ctx context.Context, q Query) (Results, error) {
+	if len(Tokenize(q.Text)) == 0 {
+		return Results{}, ErrEmptyQuery
+	}
+	path := fmt.Sprintf("/%s/_search", url.PathEscape(o.IndexName))
+	data, _, err := o.doJSON(ctx, "POST", path, o.SearchQuery(q))
+	if err != nil {
+		log.Println("ERROR: unable to search records", err)
+		return Results{}, err
+	}
+	var resp searchResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return Results{}, err
+	}
+	out := Results{Total: resp.Hits.Total.Value, Hits: []Hit{}}
+	for _, h := range resp.Hits.Hits {
+		out.Hits = append(out.Hits, Hit{ID: h.ID, Score: h.Score, Record: h.Source, Highlights: h.Highlight})
+	}
+	return out, nil
+}
+
+// BulkError represents failures of bulk request, it maps document ids to
+// their error messages
+type BulkError struct {
+	Failures map[string]string
+}
+
+// Error implements error interface
+func (e *BulkError) Error() string {
+	return fmt.Sprintf("%d documents failed in bulk request", len(e.Failures))
+}
+
+// BulkIndexer buffers index and delete operations and sends them to
+// OpenSearch via bulk API. It implements Index interface, therefore it can
+// be used by Sync to keep the index up to date.
+type BulkIndexer struct {
+	Backend       *OpenSearchIndex
+	Size          int           // number of operations per bulk request
+	FlushInterval time.Duration // periodic flush interval of Run
+	mu            sync.Mutex
+	buf           bytes.Buffer
+	nops          int
+}
+
+// NewBulkIndexer returns bulk indexer of the index
+func (o *OpenSearchIndex) NewBulkIndexer(size int, interval time.Duration) *BulkIndexer {
+	if size <= 0 {
+		size = 500
+	}
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	return &BulkIndexer{Backend: o, Size: size, FlushInterval: interval}
+}
+
+// helper function to add bulk action and optional document
+func (b *BulkIndexer) add(ctx context.Context, action string, id string, doc map[string]any) error {
+	meta := map[string]any{action: map[string]any{"_index": b.Backend.IndexName, "_id": id}}
+	line, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	var body []byte
+	if doc != nil {
+		if body, err = json.Marshal(doc); err != nil {
+			return err
+		}
+	}
+	b.mu.Lock()
+	b.buf.Write(line)
+	b.buf.WriteByte('\n')
+	if body != nil {
+		b.buf.Write(body)
+		b.buf.WriteByte('\n')
+	}
+	b.nops++
+	full := b.nops >= b.Size
+	b.mu.Unlock()
+	if full {
+		return b.Flush(ctx)
+	}
+	return nil
+}
+
+// Index implements Index interface, document is sent with next flush
+func (b *BulkIndexer) Index(ctx context.Context, id string, rec map[string]any) error {
+	if id == "" {
+		return fmt.Errorf("record without %s", b.Backend.IDKey)
+	}
+	return b.add(ctx, "index", id, rec)
+}
+
+// Delete implements Index interface, deletion is sent with next flush
+func (b *BulkIndexer) Delete(ctx context.Context, id string) error {
+	return b.add(ctx, "delete", id, nil)
+}
+
+// Search implements Index interface
+func (b *BulkIndexer) Search(ctx context.Context, q Query) (Results, error) {
+	return b.Backend.Search(ctx, q)
+}
+
+// bulkResponse represents response of OpenSearch bulk API
+type bulkResponse struct {
+	Errors bool                        `json:"errors"`
+	Items  []map[string]bulkItemResult `json:"items"`
+}
+
+// bulkItemResult represents result of single bulk operation
+type bulkItemResult struct {
+	ID     string         `json:"_id"`
+	Status int            `json:"status"`
+	Error  map[string]any `json:"error"`
+}
+
+// Flush sends buffered operations to OpenSearch, it returns BulkError if
+// some operations failed (deletion of missing documents is not an error)
+func (b *BulkIndexer) Flush(ctx context.Context) error {
+	b.mu.Lock()
+	if b.nops == 0 {
+		b.mu.Unlock()
+		return nil
+	}
+	body := make([]byte, b.buf.Len())
+	copy(body, b.buf.Bytes())
+	b.buf.Reset()
+	b.nops = 0
+	b.mu.Unlock()
+
+	data, _, err := b.Backend.do(ctx, "POST", "/_bulk", "application/x-ndjson", body)
+	if err != nil {
+		log.Println("ERROR: bulk request failed", err)
+		return err
+	}
+	var resp bulkResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return err
+	}
+	if !resp.Errors {
+		return nil
+	}
+	berr := &BulkError{Failures: make(map[string]string)}
+	for _, item := range resp.Items {
+		for action, res := range item {
+			if res.Status < 300 || (action == "delete" && res.Status == http.StatusNotFound) {
+				continue
+			}
+			berr.Failures[res.ID] = fmt.Sprintf("%s failed with status %d: %v", action, res.Status, res.Error["reason"])
+		}
+	}
+	if len(berr.Failures) == 0 {
+		return nil
+	}
+	log.Printf("ERROR: %v", berr)
+	return berr
+}
+
+// Run flushes buffered operations periodically until context is done
+func (b *BulkIndexer) Run(ctx context.Context) {
+	ticker := time.NewTicker(b.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			// flush remaining operations with fresh context
+			b.Flush(context.Background())
+			return
+		case <-ticker.C:
+			b.Flush(ctx)
+		}
+	}
+}
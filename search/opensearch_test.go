@@ -0,0 +1,158 @@
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+)
+
+// fakeOpenSearch represents minimal OpenSearch server used by tests
+type fakeOpenSearch struct {
+	mu       sync.Mutex
+	docs     map[string]map[string]any
+	template map[string]any
+	bulks    int
+	lastBody map[string]any
+}
+
+func (f *fakeOpenSearch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, _ := io.ReadAll(r.Body)
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/_index_template/"):
+		json.Unmarshal(data, &f.template)
+		w.Write([]byte(`{"acknowledged":true}`))
+	case r.URL.Path == "/_bulk":
+		f.bulks++
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		var items []string
+		for i := 0; i < len(lines); i++ {
+			var meta map[string]map[string]string
+			json.Unmarshal([]byte(lines[i]), &meta)
+			for action, m := range meta {
+				status := 200
+				if action == "index" {
+					i++
+					var doc map[string]any
+					json.Unmarshal([]byte(lines[i]), &doc)
+					if doc["bad"] != nil {
+						status = 400
+					} else {
+						f.docs[m["_id"]] = doc
+					}
+				} else if _, ok := f.docs[m["_id"]]; ok {
+					delete(f.docs, m["_id"])
+				} else {
+					status = 404
+				}
+				items = append(items, `{"`+action+`":{"_id":"`+m["_id"]+`","status":`+strconv.Itoa(status)+`,"error":{"reason":"mapper_parsing_exception"}}}`)
+			}
+		}
+		w.Write([]byte(`{"errors":true,"items":[` + strings.Join(items, ",") + `]}`))
+	case strings.HasSuffix(r.URL.Path, "/_search"):
+		json.Unmarshal(data, &f.lastBody)
+		resp := `{"hits":{"total":{"value":1},"hits":[{"_id":"/beamline=3a","_score":1.5,"_source":{"did":"/beamline=3a"},"highlight":{"description":["<em>steel</em> sample"]}}]}}`
+		w.Write([]byte(resp))
+	case strings.Contains(r.URL.Path, "/_doc/"):
+		id := r.URL.Path[strings.Index(r.URL.Path, "/_doc/")+6:]
+		if r.Method == "DELETE" {
+			if _, ok := f.docs[id]; !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			delete(f.docs, id)
+			return
+		}
+		var doc map[string]any
+		json.Unmarshal(data, &doc)
+		f.docs[id] = doc
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// TestOpenSearchIndex
+func TestOpenSearchIndex(t *testing.T) {
+	fake := &fakeOpenSearch{docs: make(map[string]map[string]any)}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	cfg := srvConfig.OpenSearch{URL: srv.URL, IndexName: "foxden-meta", Shards: 2}
+	index, err := NewOpenSearchIndex(cfg, "did", []string{"description"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := index.EnsureTemplate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if patterns := fake.template["index_patterns"].([]any); patterns[0] != "foxden-meta*" {
+		t.Error("invalid index template", fake.template)
+	}
+	if err := index.Index(ctx, "/beamline=3a", testRecords[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fake.docs["/beamline=3a"]; !ok {
+		t.Errorf("document is not indexed, docs %v", fake.docs)
+	}
+	if err := index.Delete(ctx, "/beamline=3a"); err != nil {
+		t.Error(err)
+	}
+	if err := index.Delete(ctx, "/missing"); err != nil {
+		t.Error("missing document deletion should not fail", err)
+	}
+	results, err := index.Search(ctx, Query{Text: "steel", Spec: map[string]any{"beamline": "3a"}, Limit: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results.Total != 1 || results.Hits[0].Score != 1.5 || len(results.Hits[0].Highlights["description"]) != 1 {
+		t.Errorf("invalid results %+v", results)
+	}
+	query := fake.lastBody["query"].(map[string]any)["bool"].(map[string]any)
+	filter := query["filter"].([]any)[0].(map[string]any)["term"].(map[string]any)
+	if filter["beamline.keyword"] != "3a" || fake.lastBody["size"].(float64) != 5 {
+		t.Errorf("invalid search request %v", fake.lastBody)
+	}
+}
+
+// TestBulkIndexer
+func TestBulkIndexer(t *testing.T) {
+	fake := &fakeOpenSearch{docs: make(map[string]map[string]any)}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	index, err := NewOpenSearchIndex(srvConfig.OpenSearch{URL: srv.URL, IndexName: "meta"}, "did", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	bulk := index.NewBulkIndexer(3, time.Second)
+	bulk.Index(ctx, "a", map[string]any{"did": "a"})
+	bulk.Index(ctx, "b", map[string]any{"did": "b"})
+	if fake.bulks != 0 {
+		t.Error("bulk request is sent before batch is full")
+	}
+	bulk.Delete(ctx, "missing")
+	if fake.bulks != 1 || len(fake.docs) != 2 {
+		t.Errorf("bulk request is not sent, bulks %d docs %v", fake.bulks, fake.docs)
+	}
+	bulk.Index(ctx, "c", map[string]any{"did": "c", "bad": true})
+	err = bulk.Flush(ctx)
+	var berr *BulkError
+	if !errors.As(err, &berr) || len(berr.Failures) != 1 || berr.Failures["c"] == "" {
+		t.Errorf("expect bulk error for document c, got %v", err)
+	}
+	if err := bulk.Flush(ctx); err != nil || fake.bulks != 2 {
+		t.Error("empty flush should not send request", err, fake.bulks)
+	}
+}
@@ -0,0 +1,325 @@
+package search
+
+// search module provides ranked free-text search with highlighting over
+// metadata records. Records are indexed either by embedded in-memory index
+// kept in sync via message bus events, by MongoDB text index or by
+// OpenSearch/Elasticsearch cluster.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	services "github.com/CHESSComputing/golib/services"
+	utils "github.com/CHESSComputing/golib/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultLimit defines default number of search hits
+var DefaultLimit = 20
+
+// MaxLimit defines maximum number of search hits
+var MaxLimit = 1000
+
+// HighlightPre and HighlightPost define markers of matched terms in
+// highlighted fragments
+var (
+	HighlightPre  = "<em>"
+	HighlightPost = "</em>"
+)
+
+// FragmentSize defines number of characters around matched term in
+// highlighted fragments
+var FragmentSize = 60
+
+// ErrEmptyQuery is returned for queries without search terms
+var ErrEmptyQuery = errors.New("empty search query")
+
+// Query represents free-text search query
+type Query struct {
+	Text  string         // free-text query
+	Spec  map[string]any // additional equality conditions on record keys
+	Skip  int            // number of hits to skip
+	Limit int            // number of hits to return
+}
+
+// Hit represents single search hit
+type Hit struct {
+	ID         string              `json:"id"`
+	Score      float64             `json:"score"`
+	Record     map[string]any      `json:"record"`
+	Highlights map[string][]string `json:"highlights,omitempty"`
+}
+
+// Results represents ranked search results
+type Results struct {
+	Total int   `json:"total"`
+	Hits  []Hit `json:"hits"`
+}
+
+// Index defines interface of full-text search backends
+type Index interface {
+	// Index adds or replaces record with given id
+	Index(ctx context.Context, id string, rec map[string]any) error
+	// Delete removes record with given id
+	Delete(ctx context.Context, id string) error
+	// Search returns ranked hits matching the query
+	Search(ctx context.Context, q Query) (Results, error)
+}
+
+// Searcher represents global search index, it should be initialized via
+// Init function
+var Searcher Index
+
+// New returns search index for given configuration
+func New(cfg srvConfig.Search) (Index, error) {
+	switch strings.ToLower(cfg.Backend) {
+	case "", "memory":
+		return NewMemoryIndex(cfg.IDKey, cfg.Fields), nil
+	case "mongo":
+		if cfg.DBName == "" || cfg.Collection == "" {
+			return nil, errors.New("mongo search backend requires DBName and Collection")
+		}
+		return NewMongoIndex(cfg.DBName, cfg.Collection, cfg.IDKey, cfg.Fields), nil
+	case "opensearch", "elasticsearch":
+		return NewOpenSearchIndex(cfg.OpenSearch, cfg.IDKey, cfg.Fields)
+	}
+	return nil, fmt.Errorf("unsupported search backend '%s'", cfg.Backend)
+}
+
+// Init initializes global search index from configuration
+func Init() error {
+	if srvConfig.Config == nil {
+		return errors.New("server configuration is not initialized")
+	}
+	index, err := New(srvConfig.Config.Search)
+	if err != nil {
+		log.Println("ERROR: unable to initialize search index", err)
+		return err
+	}
+	Searcher = index
+	return nil
+}
+
+// common English words which are not indexed
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true,
+	"be": true, "by": true, "for": true, "from": true, "in": true, "is": true,
+	"it": true, "of": true, "on": true, "or": true, "the": true, "to": true,
+	"with": true,
+}
+
+// Tokenize splits text into lower case terms, stop words are dropped
+func Tokenize(text string) []string {
+	var terms []string
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, f := range fields {
+		if !stopWords[f] {
+			terms = append(terms, f)
+		}
+	}
+	return terms
+}
+
+// helper function to collect string values of record fields, nested keys
+// are joined by dot, e.g. sample.name
+func textFields(prefix string, val any, out map[string]string) {
+	switch v := val.(type) {
+	case string:
+		if out[prefix] != "" {
+			out[prefix] += " " + v
+		} else {
+			out[prefix] = v
+		}
+	case map[string]any:
+		for k, e := range v {
+			key := k
+			if prefix != "" {
+				key = prefix + "." + k
+			}
+			textFields(key, e, out)
+		}
+	default:
+		// MongoDB returns arrays as primitive.A
+		if list, ok := utils.ListValues(v); ok {
+			for _, e := range list {
+				textFields(prefix, e, out)
+			}
+		}
+	}
+}
+
+// TextFields returns text of given record fields, all string fields are
+// used if fields are not provided
+func TextFields(rec map[string]any, fields []string) map[string]string {
+	all := make(map[string]string)
+	textFields("", rec, all)
+	if len(fields) == 0 {
+		return all
+	}
+	out := make(map[string]string)
+	for _, f := range fields {
+		if text, ok := all[f]; ok {
+			out[f] = text
+		}
+	}
+	return out
+}
+
+// match represents position of matched term in the text
+type match struct {
+	start, end int
+}
+
+// Highlight returns fragments of text with matched terms wrapped into
+// HighlightPre and HighlightPost markers
+func Highlight(text string, terms []string) []string {
+	if len(terms) == 0 || text == "" {
+		return nil
+	}
+	want := make(map[string]bool)
+	for _, t := range terms {
+		want[strings.ToLower(t)] = true
+	}
+	// find positions of matched words in original text
+	var matches []match
+	start := -1
+	for i, r := range text + " " {
+		isWord := i < len(text) && (unicode.IsLetter(r) || unicode.IsDigit(r))
+		if isWord && start < 0 {
+			start = i
+		} else if !isWord && start >= 0 {
+			if want[strings.ToLower(text[start:i])] {
+				matches = append(matches, match{start, i})
+			}
+			start = -1
+		}
+	}
+	if len(matches) == 0 {
+		return nil
+	}
+	// group matches into fragments
+	var fragments []string
+	for i := 0; i < len(matches); {
+		from := matches[i].start - FragmentSize/2
+		if from < 0 {
+			from = 0
+		}
+		to := matches[i].end + FragmentSize/2
+		j := i
+		for j+1 < len(matches) && matches[j+1].start < to {
+			j++
+			if end := matches[j].end + FragmentSize/2; end > to {
+				to = end
+			}
+		}
+		if to > len(text) {
+			to = len(text)
+		}
+		from, to = runeBoundary(text, from), runeBoundary(text, to)
+		var sb strings.Builder
+		pos := from
+		for _, m := range matches[i : j+1] {
+			sb.WriteString(text[pos:m.start])
+			sb.WriteString(HighlightPre + text[m.start:m.end] + HighlightPost)
+			pos = m.end
+		}
+		sb.WriteString(text[pos:to])
+		frag := sb.String()
+		if from > 0 {
+			frag = "..." + frag
+		}
+		if to < len(text) {
+			frag += "..."
+		}
+		fragments = append(fragments, frag)
+		i = j + 1
+	}
+	return fragments
+}
+
+// helper function to move index to the start of UTF-8 character
+func runeBoundary(text string, idx int) int {
+	for idx > 0 && idx < len(text) && !isRuneStart(text[idx]) {
+		idx--
+	}
+	return idx
+}
+
+// helper function to check if byte starts UTF-8 character
+func isRuneStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
+
+// Highlights returns highlighted fragments of record fields
+func Highlights(rec map[string]any, fields []string, terms []string) map[string][]string {
+	out := make(map[string][]string)
+	for field, text := range TextFields(rec, fields) {
+		if frags := Highlight(text, terms); len(frags) > 0 {
+			out[field] = frags
+		}
+	}
+	return out
+}
+
+// helper function to sort hits by score and id and apply skip and limit
+func pageHits(hits []Hit, q Query) Results {
+	sort.SliceStable(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].ID < hits[j].ID
+	})
+	out := Results{Total: len(hits), Hits: []Hit{}}
+	skip, limit := q.Skip, queryLimit(q.Limit)
+	if skip >= len(hits) {
+		return out
+	}
+	hits = hits[skip:]
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	out.Hits = hits
+	return out
+}
+
+// helper function to provide query limit
+func queryLimit(limit int) int {
+	if limit <= 0 {
+		return DefaultLimit
+	}
+	if limit > MaxLimit {
+		return MaxLimit
+	}
+	return limit
+}
+
+// SearchHandler provides gin handler of free-text search, query is passed
+// via q parameter, hits are paginated via skip and limit parameters
+func SearchHandler(index Index) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		q := Query{Text: c.Query("q")}
+		q.Skip, _ = strconv.Atoi(c.Query("skip"))
+		q.Limit, _ = strconv.Atoi(c.Query("limit"))
+		results, err := index.Search(c.Request.Context(), q)
+		if err != nil {
+			code := http.StatusInternalServerError
+			if errors.Is(err, ErrEmptyQuery) {
+				code = http.StatusBadRequest
+			}
+			rec := services.Response("search", code, services.QueryError, err)
+			c.JSON(code, rec)
+			return
+		}
+		c.JSON(http.StatusOK, results)
+	}
+}
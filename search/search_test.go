@@ -0,0 +1,199 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	pubsub "github.com/CHESSComputing/golib/pubsub"
+	storage "github.com/CHESSComputing/golib/storage"
+	bson "go.mongodb.org/mongo-driver/bson"
+)
+
+var testRecords = []map[string]any{
+	{"did": "/beamline=3a/btr=t1", "beamline": "3a", "description": "Tomography of steel sample under tensile load"},
+	{"did": "/beamline=1b/btr=t2", "beamline": "1b", "description": "Powder diffraction of ceramic powder, powder bed"},
+	{"did": "/beamline=3a/btr=t3", "beamline": "3a", "description": "Diffraction study", "sample": map[string]any{"name": "steel alloy"}},
+}
+
+// TestTokenize
+func TestTokenize(t *testing.T) {
+	terms := Tokenize("The Steel-sample, at 300K!")
+	expect := []string{"steel", "sample", "300k"}
+	if strings.Join(terms, ",") != strings.Join(expect, ",") {
+		t.Errorf("invalid terms %v, expect %v", terms, expect)
+	}
+}
+
+// TestHighlight
+func TestHighlight(t *testing.T) {
+	frags := Highlight("Tomography of steel sample under tensile load", []string{"steel"})
+	if len(frags) != 1 || !strings.Contains(frags[0], "<em>steel</em>") {
+		t.Errorf("invalid fragments %v", frags)
+	}
+	long := strings.Repeat("x ", 100) + "steel " + strings.Repeat("y ", 100)
+	frags = Highlight(long, []string{"steel"})
+	if len(frags) != 1 || !strings.HasPrefix(frags[0], "...") || !strings.HasSuffix(frags[0], "...") {
+		t.Errorf("invalid fragments %v", frags)
+	}
+	if frags := Highlight("no match here", []string{"steel"}); frags != nil {
+		t.Errorf("unexpected fragments %v", frags)
+	}
+}
+
+// TestMemoryIndex
+func TestMemoryIndex(t *testing.T) {
+	ctx := context.Background()
+	index := NewMemoryIndex("did", nil)
+	for _, rec := range testRecords {
+		if err := index.Index(ctx, rec["did"].(string), rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	results, err := index.Search(ctx, Query{Text: "powder"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results.Total != 1 || results.Hits[0].ID != "/beamline=1b/btr=t2" {
+		t.Fatalf("invalid results %+v", results)
+	}
+	if frags := results.Hits[0].Highlights["description"]; len(frags) != 1 || strings.Count(frags[0], "<em>") != 3 {
+		t.Errorf("invalid highlights %v", results.Hits[0].Highlights)
+	}
+
+	// ranking: record with more occurrences of terms is ranked first
+	results, _ = index.Search(ctx, Query{Text: "steel diffraction"})
+	if results.Total != 3 || results.Hits[0].ID != "/beamline=3a/btr=t3" {
+		t.Errorf("invalid ranking %+v", results.Hits)
+	}
+	if _, ok := results.Hits[0].Highlights["sample.name"]; !ok {
+		t.Errorf("nested field is not highlighted %v", results.Hits[0].Highlights)
+	}
+
+	// spec and pagination
+	results, _ = index.Search(ctx, Query{Text: "steel diffraction", Spec: map[string]any{"beamline": "3a"}, Limit: 1})
+	if results.Total != 2 || len(results.Hits) != 1 {
+		t.Errorf("invalid filtered results %+v", results)
+	}
+
+	// updates replace terms of previous record version
+	index.Index(ctx, "/beamline=1b/btr=t2", map[string]any{"did": "/beamline=1b/btr=t2", "description": "empty run"})
+	if results, _ := index.Search(ctx, Query{Text: "powder"}); results.Total != 0 {
+		t.Errorf("stale terms found %+v", results)
+	}
+	index.Delete(ctx, "/beamline=3a/btr=t1")
+	if index.Len() != 2 {
+		t.Error("invalid index size", index.Len())
+	}
+	if _, err := index.Search(ctx, Query{Text: "the"}); !errors.Is(err, ErrEmptyQuery) {
+		t.Error("expect empty query error, got", err)
+	}
+}
+
+// TestSync
+func TestSync(t *testing.T) {
+	bus := pubsub.NewMemoryBus(pubsub.Options{})
+	defer bus.Close()
+	index := NewMemoryIndex("did", []string{"description"})
+	if _, err := bus.Subscribe("records", "search", EventHandler(index, "did")); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	publish := func(etype string, data any) {
+		env, err := pubsub.NewEnvelope(etype, "test", data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := bus.Publish(ctx, "records", env); err != nil {
+			t.Fatal(err)
+		}
+	}
+	publish(pubsub.EventRecordInserted, testRecords[0])
+	publish(pubsub.EventRecordInserted, testRecords[1])
+	publish(pubsub.EventRecordDeleted, map[string]any{"did": testRecords[1]["did"]})
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		results, _ := index.Search(ctx, Query{Text: "tomography powder"})
+		if index.Len() == 1 && results.Total == 1 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("index is not in sync, size %d", index.Len())
+}
+
+// TestSyncFromConfig
+func TestSyncFromConfig(t *testing.T) {
+	bus := pubsub.NewMemoryBus(pubsub.Options{})
+	defer bus.Close()
+	pubsub.MessageBus = bus
+	defer func() { pubsub.MessageBus = nil }()
+	config := srvConfig.Config
+	srvConfig.Config = &srvConfig.SrvConfig{}
+	srvConfig.Config.Search = srvConfig.Search{IDKey: "did", Subject: "records", Group: "search"}
+	defer func() { srvConfig.Config = config }()
+	defer func() { Searcher = nil }()
+	// every service instance keeps its own embedded index which should
+	// receive all events even if consumer group is configured
+	var indexes []*MemoryIndex
+	for i := 0; i < 2; i++ {
+		index := NewMemoryIndex("did", []string{"description"})
+		Searcher = index
+		sub, err := SyncFromConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sub.Unsubscribe()
+		indexes = append(indexes, index)
+	}
+	if err := pubsub.Publish(context.Background(), "records", pubsub.EventRecordInserted, testRecords[0]); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if indexes[0].Len() == 1 && indexes[1].Len() == 1 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("embedded indexes are not in sync, sizes %d and %d", indexes[0].Len(), indexes[1].Len())
+}
+
+// TestReindex
+func TestReindex(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	for _, rec := range testRecords {
+		store.Insert(ctx, "meta", rec)
+	}
+	store.Insert(ctx, "meta", map[string]any{"description": "record without did"})
+	size := ReindexPageSize
+	ReindexPageSize = 2
+	defer func() { ReindexPageSize = size }()
+	index := NewMemoryIndex("did", []string{"description"})
+	n, err := Reindex(ctx, index, store, "meta", "did")
+	if err != nil || n != 3 || index.Len() != 3 {
+		t.Errorf("unexpected reindex of %d records, index size %d, error %v", n, index.Len(), err)
+	}
+}
+
+// TestTextFieldsBSON
+func TestTextFieldsBSON(t *testing.T) {
+	rec := map[string]any{"keywords": []any{"steel", "alloy"}, "sample": map[string]any{"tags": []string{"bulk"}}}
+	// MongoDB decodes arrays of records as primitive.A
+	data, err := bson.Marshal(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mrec map[string]any
+	if err := bson.Unmarshal(data, &mrec); err != nil {
+		t.Fatal(err)
+	}
+	fields := TextFields(mrec, nil)
+	if fields["keywords"] != "steel alloy" || fields["sample.tags"] != "bulk" {
+		t.Errorf("wrong text fields %+v", fields)
+	}
+}
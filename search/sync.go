@@ -0,0 +1,102 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	pubsub "github.com/CHESSComputing/golib/pubsub"
+	storage "github.com/CHESSComputing/golib/storage"
+)
+
+// ReindexPageSize defines number of records read by single storage query
+// of Reindex
+var ReindexPageSize = 1000
+
+// EventHandler returns message bus handler which keeps index in sync with
+// record events: inserted and updated records are (re-)indexed and deleted
+// ones are removed. Payload of events is the record, deleted events may
+// carry record id only.
+func EventHandler(index Index, idKey string) pubsub.Handler {
+	if idKey == "" {
+		idKey = "did"
+	}
+	return func(ctx context.Context, env pubsub.Envelope) error {
+		switch env.Type {
+		case pubsub.EventRecordInserted, pubsub.EventRecordUpdated, pubsub.EventRecordDeleted:
+		default:
+			return nil
+		}
+		var rec map[string]any
+		if err := env.Decode(&rec); err != nil {
+			// malformed events can not be processed on redelivery
+			log.Printf("ERROR: unable to decode %s event %s, error %v", env.Type, env.ID, err)
+			return nil
+		}
+		if rec[idKey] == nil {
+			log.Printf("WARNING: %s event %s without %s", env.Type, env.ID, idKey)
+			return nil
+		}
+		id := fmt.Sprintf("%v", rec[idKey])
+		if env.Type == pubsub.EventRecordDeleted {
+			return index.Delete(ctx, id)
+		}
+		return index.Index(ctx, id, rec)
+	}
+}
+
+// Sync subscribes index to record events of message bus subject
+func Sync(index Index, idKey, subject, group string) (pubsub.Subscription, error) {
+	return pubsub.Subscribe(subject, group, EventHandler(index, idKey))
+}
+
+// SyncFromConfig subscribes global search index to record events using
+// Search configuration. Embedded index is subscribed without consumer group
+// since every service instance keeps its own index which should receive all
+// events, while shared index (e.g. OpenSearch) uses configured group.
+func SyncFromConfig() (pubsub.Subscription, error) {
+	if Searcher == nil {
+		return nil, errors.New("search index is not initialized")
+	}
+	cfg := srvConfig.Config.Search
+	if cfg.Subject == "" {
+		return nil, errors.New("search subject is not configured")
+	}
+	group := cfg.Group
+	if _, ok := Searcher.(*MemoryIndex); ok {
+		group = ""
+	}
+	return Sync(Searcher, cfg.IDKey, cfg.Subject, group)
+}
+
+// Reindex indexes all records of storage collection, records are read page
+// by page ordered by idKey and records without idKey are skipped. It
+// returns number of indexed records.
+func Reindex(ctx context.Context, index Index, store storage.Store, collection, idKey string) (int, error) {
+	if idKey == "" {
+		idKey = "did"
+	}
+	var count int
+	for skip := 0; ; skip += ReindexPageSize {
+		opts := &storage.FindOptions{Sort: []string{idKey}, Skip: skip, Limit: ReindexPageSize}
+		records, err := store.Find(ctx, collection, nil, opts)
+		if err != nil {
+			log.Printf("ERROR: unable to read records of %s, error %v", collection, err)
+			return count, err
+		}
+		for _, rec := range records {
+			if rec[idKey] == nil {
+				continue
+			}
+			if err := index.Index(ctx, fmt.Sprintf("%v", rec[idKey]), rec); err != nil {
+				return count, err
+			}
+			count++
+		}
+		if len(records) < ReindexPageSize {
+			return count, nil
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package searches
+
+// handlers module provides HTTP endpoints of saved searches, every user
+// manages own saved searches only
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	server "github.com/CHESSComputing/golib/server"
+	services "github.com/CHESSComputing/golib/services"
+	"github.com/gin-gonic/gin"
+)
+
+// helper function to abort request with service error
+func abort(c *gin.Context, code, srvCode int, err error) {
+	rec := services.Response("searches", code, srvCode, err)
+	c.AbortWithStatusJSON(code, rec)
+}
+
+// helper function to abort request with error of the manager
+func abortWithError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		abort(c, http.StatusNotFound, services.QueryError, err)
+	case errors.Is(err, ErrInvalid):
+		abort(c, http.StatusBadRequest, services.ValidateError, err)
+	case errors.Is(err, ErrLimit):
+		abort(c, http.StatusConflict, services.ValidateError, err)
+	default:
+		log.Printf("ERROR: saved searches request failed, error %v", err)
+		abort(c, http.StatusInternalServerError, services.DatabaseError, err)
+	}
+}
+
+// helper function to provide user of the request
+func requestUser(c *gin.Context) (string, bool) {
+	user := c.GetString("user")
+	if user == "" {
+		abort(c, http.StatusUnauthorized, services.CredentialsError, errors.New("unknown user"))
+		return "", false
+	}
+	return user, true
+}
+
+// helper function to hide webhook secret of saved search
+func redact(s Search) Search {
+	if s.Subscription != nil && s.Subscription.Secret != "" {
+		sub := *s.Subscription
+		sub.Secret = RedactedSecret
+		s.Subscription = &sub
+	}
+	return s
+}
+
+// ListHandler provides saved searches of the user
+func (m *Manager) ListHandler(c *gin.Context) {
+	user, ok := requestUser(c)
+	if !ok {
+		return
+	}
+	list, err := m.List(c.Request.Context(), user)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	for i := range list {
+		list[i] = redact(list[i])
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// GetHandler provides saved search of the user
+func (m *Manager) GetHandler(c *gin.Context) {
+	user, ok := requestUser(c)
+	if !ok {
+		return
+	}
+	s, err := m.Get(c.Request.Context(), user, c.Param("name"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, redact(s))
+}
+
+// SaveHandler creates or replaces saved search of the user, the request
+// body is JSON search with name, query and optional subscription
+func (m *Manager) SaveHandler(c *gin.Context) {
+	user, ok := requestUser(c)
+	if !ok {
+		return
+	}
+	var s Search
+	if err := c.ShouldBindJSON(&s); err != nil {
+		abort(c, http.StatusBadRequest, services.BindError, err)
+		return
+	}
+	s.Owner = user
+	s, err := m.Save(c.Request.Context(), s)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, redact(s))
+}
+
+// DeleteHandler removes saved search of the user
+func (m *Manager) DeleteHandler(c *gin.Context) {
+	user, ok := requestUser(c)
+	if !ok {
+		return
+	}
+	if err := m.Delete(c.Request.Context(), user, c.Param("name")); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// RecordsHandler provides records matching saved search of the user,
+// records are paginated via skip and limit parameters
+func (m *Manager) RecordsHandler(c *gin.Context) {
+	user, ok := requestUser(c)
+	if !ok {
+		return
+	}
+	skip, _ := strconv.Atoi(c.Query("skip"))
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	records, err := m.Execute(c.Request.Context(), user, c.Param("name"), skip, limit)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, records)
+}
+
+// Routes returns server routes of saved searches under given path, e.g.
+// /searches. Saved searches are private settings of users, therefore all
+// routes require read scope only.
+func (m *Manager) Routes(path string) []server.Route {
+	return []server.Route{
+		{Method: "GET", Path: path, Authorized: true, Scope: "read", Handler: m.ListHandler,
+			Summary: "list saved searches"},
+		{Method: "POST", Path: path, Authorized: true, Scope: "read", Handler: m.SaveHandler,
+			Summary: "create or replace saved search"},
+		{Method: "GET", Path: path + "/:name", Authorized: true, Scope: "read", Handler: m.GetHandler,
+			Summary: "get saved search"},
+		{Method: "DELETE", Path: path + "/:name", Authorized: true, Scope: "read", Handler: m.DeleteHandler,
+			Summary: "delete saved search"},
+		{Method: "GET", Path: path + "/:name/records", Authorized: true, Scope: "read", Handler: m.RecordsHandler,
+			Summary: "run saved search"},
+	}
+}
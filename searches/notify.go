@@ -0,0 +1,254 @@
+package searches
+
+// notify module evaluates subscriptions of saved searches and delivers
+// notifications about new matching records via email or webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	jobs "github.com/CHESSComputing/golib/jobs"
+	mail "github.com/CHESSComputing/golib/mail"
+	storage "github.com/CHESSComputing/golib/storage"
+	users "github.com/CHESSComputing/golib/users"
+)
+
+// WebhookTaskType defines jobs task type of webhook deliveries
+const WebhookTaskType = "searches.webhook"
+
+// SignatureHeader defines header of webhook payload signature, it holds
+// sha256=<hex HMAC-SHA256 of the payload> if subscription has secret
+const SignatureHeader = "X-Foxden-Signature"
+
+// DefaultRetryPolicy defines retry policy of webhook deliveries
+var DefaultRetryPolicy = jobs.RetryPolicy{
+	MaxAttempts: 5,
+	InitialWait: time.Minute,
+	MaxWait:     time.Hour,
+	Multiplier:  2,
+}
+
+// Notification represents notification about new records matching saved
+// search
+type Notification struct {
+	Search    string   `json:"search"`
+	Owner     string   `json:"owner"`
+	Since     int64    `json:"since"`     // records inserted after this time
+	Until     int64    `json:"until"`     // records inserted before or at this time
+	Count     int64    `json:"count"`     // number of new records
+	Records   []string `json:"records"`   // ids of new records, at most MaxMatches
+	Truncated bool     `json:"truncated"` // set if not all records are listed
+}
+
+// webhookTask represents payload of webhook delivery task
+type webhookTask struct {
+	ID           string       `json:"id"` // id of saved search
+	Notification Notification `json:"notification"`
+}
+
+// helper function to evaluate subscription of saved search, it returns nil
+// notification if there are no new records or other instance has already
+// evaluated the subscription
+func (m *Manager) evaluate(ctx context.Context, s Search, now int64) (*Notification, error) {
+	spec := make(map[string]any, len(s.Query)+1)
+	for k, v := range s.Query {
+		spec[k] = v
+	}
+	spec[m.DateKey] = map[string]any{"$gt": s.LastChecked, "$lte": now}
+	opts := &storage.FindOptions{Limit: m.MaxMatches + 1, Sort: []string{m.DateKey}}
+	records, err := m.Records.Find(ctx, m.RecordsCollection, spec, opts)
+	if err != nil {
+		return nil, err
+	}
+	// claim evaluation window, only one of service instances succeeds
+	id := searchID(s.Owner, s.Name)
+	claim := map[string]any{"_id": id, "last_checked": s.LastChecked}
+	nrec, err := m.Store.Update(ctx, m.Collection, claim, map[string]any{"last_checked": now})
+	if err != nil || nrec == 0 || len(records) == 0 {
+		return nil, err
+	}
+	n := &Notification{Search: s.Name, Owner: s.Owner, Since: s.LastChecked, Until: now, Count: int64(len(records))}
+	if len(records) > m.MaxMatches {
+		records = records[:m.MaxMatches]
+		n.Truncated = true
+		if n.Count, err = m.Records.Count(ctx, m.RecordsCollection, spec); err != nil {
+			log.Printf("WARNING: unable to count records of saved search %s, error %v", s.Name, err)
+			n.Count = int64(m.MaxMatches + 1)
+		}
+	}
+	for _, rec := range records {
+		n.Records = append(n.Records, fmt.Sprintf("%v", rec[m.IDKey]))
+	}
+	return n, nil
+}
+
+// Evaluate evaluates subscriptions of all saved searches against records
+// inserted since their last check and sends notifications about new
+// matching records, it returns number of sent notifications
+func (m *Manager) Evaluate(ctx context.Context, now time.Time) (int, error) {
+	records, err := m.Store.Find(ctx, m.Collection, map[string]any{"subscribed": true}, nil)
+	if err != nil {
+		return 0, err
+	}
+	var sent int
+	var errs []error
+	for _, rec := range records {
+		s, err := fromRecord(rec)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		n, err := m.evaluate(ctx, s, now.Unix())
+		if err != nil {
+			log.Printf("ERROR: unable to evaluate saved search %s of %s, error %v", s.Name, s.Owner, err)
+			errs = append(errs, err)
+			continue
+		}
+		if n == nil {
+			continue
+		}
+		if err := m.Notify(ctx, s, *n); err != nil {
+			log.Printf("ERROR: unable to notify %s about saved search %s, error %v", s.Owner, s.Name, err)
+			errs = append(errs, err)
+			continue
+		}
+		sent++
+	}
+	return sent, errors.Join(errs...)
+}
+
+// Run periodically evaluates subscriptions until context is cancelled
+func (m *Manager) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if sent, err := m.Evaluate(ctx, time.Now()); err != nil {
+			if ctx.Err() == nil {
+				log.Printf("ERROR: evaluation of saved searches failed, error %v", err)
+			}
+		} else if sent > 0 {
+			log.Printf("sent %d notifications of saved searches", sent)
+		}
+	}
+}
+
+// helper function to check notification preferences of owner of saved
+// search
+func (m *Manager) notifies(ctx context.Context, owner, channel string) bool {
+	return m.Users == nil || m.Users.Notifies(ctx, owner, users.EventSearchMatches, channel)
+}
+
+// Notify sends notification via email and webhook of the subscription,
+// channels disabled in notification preferences of the owner are skipped
+func (m *Manager) Notify(ctx context.Context, s Search, n Notification) error {
+	sub := s.Subscription
+	if sub == nil {
+		return nil
+	}
+	var errs []error
+	if sub.Email != "" && m.notifies(ctx, s.Owner, users.ChannelEmail) {
+		if m.Mailer == nil {
+			errs = append(errs, errors.New("mailer is not configured"))
+		} else {
+			data := map[string]any{
+				"Search":    n.Search,
+				"Count":     n.Count,
+				"Records":   n.Records,
+				"Truncated": n.Truncated,
+			}
+			errs = append(errs, m.Mailer.Notify(ctx, mail.TemplateSearchMatches, []string{sub.Email}, data))
+		}
+	}
+	if sub.Webhook != "" && m.notifies(ctx, s.Owner, users.ChannelWebhook) {
+		task := webhookTask{ID: searchID(s.Owner, s.Name), Notification: n}
+		if m.Queue != nil {
+			_, err := m.Queue.Enqueue(ctx, WebhookTaskType, task, 0)
+			errs = append(errs, err)
+		} else {
+			errs = append(errs, m.deliver(ctx, task))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// helper function to deliver webhook of the queue task
+func (m *Manager) handleWebhook(ctx context.Context, task *jobs.Task) error {
+	var wt webhookTask
+	if err := task.Decode(&wt); err != nil {
+		return err
+	}
+	if err := m.deliver(ctx, wt); err != nil {
+		log.Printf("WARNING: unable to deliver webhook of saved search %s, attempt %d, error %v",
+			wt.Notification.Search, task.Attempts, err)
+		return err
+	}
+	return nil
+}
+
+// Signature returns signature of webhook payload with given secret
+func Signature(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// helper function to post notification to webhook of saved search, the
+// search is loaded at delivery time, therefore deliveries of deleted
+// searches or removed webhooks are dropped
+func (m *Manager) deliver(ctx context.Context, task webhookTask) error {
+	rec, err := storage.FindOne(ctx, m.Store, m.Collection, map[string]any{"_id": task.ID})
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s, err := fromRecord(rec)
+	if err != nil {
+		return err
+	}
+	if s.Subscription == nil || s.Subscription.Webhook == "" {
+		return nil
+	}
+	payload, err := json.Marshal(task.Notification)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", s.Subscription.Webhook, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.Subscription.Secret != "" {
+		req.Header.Set(SignatureHeader, Signature(s.Subscription.Secret, payload))
+	}
+	client := m.HttpClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s responded with status %d", s.Subscription.Webhook, resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,400 @@
+package searches
+
+// searches module lets authenticated users save named queries of metadata
+// records, run them later and subscribe to email or webhook notifications
+// about newly inserted records matching the queries. Subscriptions are
+// periodically evaluated against records inserted since their last check.
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	netMail "net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	jobs "github.com/CHESSComputing/golib/jobs"
+	mail "github.com/CHESSComputing/golib/mail"
+	storage "github.com/CHESSComputing/golib/storage"
+	users "github.com/CHESSComputing/golib/users"
+	utils "github.com/CHESSComputing/golib/utils"
+)
+
+// DefaultCollection defines collection of saved searches
+var DefaultCollection = "searches"
+
+// DefaultInterval defines interval of subscriptions evaluation
+var DefaultInterval = 10 * time.Minute
+
+// DefaultDateKey defines record key of insertion time in unix seconds
+var DefaultDateKey = "Date"
+
+// DefaultIDKey defines record key of record ids listed in notifications
+var DefaultIDKey = "did"
+
+// DefaultMaxMatches defines maximum number of records per notification
+var DefaultMaxMatches = 100
+
+// MaxSearches defines maximum number of saved searches per user
+var MaxSearches = 100
+
+// MaxResults defines maximum number of records returned by saved search
+var MaxResults = 1000
+
+// range operators of query conditions
+var rangeOperators = []string{"$gt", "$gte", "$lt", "$lte"}
+
+// ErrNotFound is returned for unknown saved searches
+var ErrNotFound = errors.New("saved search not found")
+
+// ErrInvalid is returned for invalid saved searches
+var ErrInvalid = errors.New("invalid saved search")
+
+// ErrInvalidQuery is returned for queries with unsupported conditions
+var ErrInvalidQuery = errors.New("invalid query")
+
+// ErrLimit is returned when user exceeds MaxSearches
+var ErrLimit = errors.New("too many saved searches")
+
+// RedactedSecret replaces webhook secrets in responses, saving search with
+// redacted secret keeps its current secret
+const RedactedSecret = "***"
+
+// Subscription represents notification settings of saved search
+type Subscription struct {
+	Email   string `json:"email,omitempty"`   // recipient of email notifications
+	Webhook string `json:"webhook,omitempty"` // URL of webhook notifications
+	Secret  string `json:"secret,omitempty"`  // key of webhook payload signature
+}
+
+// Search represents saved search. Query holds equality conditions on record
+// keys or range conditions given as map of $gt, $gte, $lt and $lte
+// operators, e.g. {"beamline": "3a", "energy": {"$gte": 10}}.
+type Search struct {
+	Name         string         `json:"name"`
+	Owner        string         `json:"owner"`
+	Query        map[string]any `json:"query"`
+	Subscription *Subscription  `json:"subscription,omitempty"`
+	Created      int64          `json:"created"`
+	LastChecked  int64          `json:"last_checked,omitempty"`
+}
+
+// Manager manages saved searches and evaluates their subscriptions
+type Manager struct {
+	Store             storage.Store // store of saved searches
+	Collection        string        // collection of saved searches
+	Records           storage.Store // store of metadata records
+	RecordsCollection string        // collection of metadata records
+	DateKey           string        // record key of insertion time in unix seconds
+	IDKey             string        // record key of record ids listed in notifications
+	MaxMatches        int           // maximum number of records per notification
+	Interval          time.Duration // interval of subscriptions evaluation
+	Mailer            *mail.Mailer  // mailer of email notifications
+	Queue             *jobs.Queue   // queue of webhook deliveries, webhooks are called synchronously if nil
+	HttpClient        *http.Client  // HTTP client of webhook deliveries
+
+	// Users provides notification preferences of owners of saved searches,
+	// email and webhook channels of search.matches events are honored if it
+	// is set
+	Users *users.Manager
+}
+
+// Searches represents global manager of saved searches, it should be
+// initialized via Init function
+var Searches *Manager
+
+// NewManager returns manager of saved searches of given configuration.
+// Queue handler of webhook deliveries is registered if queue is given.
+func NewManager(store storage.Store, records storage.Store, recordsCollection string, cfg srvConfig.SavedSearches, queue *jobs.Queue) *Manager {
+	m := &Manager{
+		Store:             store,
+		Collection:        cfg.Collection,
+		Records:           records,
+		RecordsCollection: recordsCollection,
+		DateKey:           cfg.DateKey,
+		IDKey:             cfg.IDKey,
+		MaxMatches:        cfg.MaxMatches,
+		Interval:          cfg.Interval,
+		Queue:             queue,
+		HttpClient:        &http.Client{Timeout: 30 * time.Second},
+	}
+	if m.Collection == "" {
+		m.Collection = DefaultCollection
+	}
+	if m.DateKey == "" {
+		m.DateKey = DefaultDateKey
+	}
+	if m.IDKey == "" {
+		m.IDKey = DefaultIDKey
+	}
+	if m.MaxMatches <= 0 {
+		m.MaxMatches = DefaultMaxMatches
+	}
+	if m.Interval <= 0 {
+		m.Interval = DefaultInterval
+	}
+	if queue != nil {
+		queue.Register(WebhookTaskType, m.handleWebhook, DefaultRetryPolicy)
+	}
+	return m
+}
+
+// Init initializes global manager of saved searches over records of CHESS
+// MetaData service, email notifications are sent via default mailer and
+// webhooks are delivered via given queue
+func Init(queue *jobs.Queue) error {
+	if srvConfig.Config == nil {
+		return errors.New("server configuration is not initialized")
+	}
+	cfg := srvConfig.Config.SavedSearches
+	meta := srvConfig.Config.CHESSMetaData
+	if meta.DBName == "" || meta.DBColl == "" {
+		return errors.New("MetaData database is not configured")
+	}
+	dbname := cfg.DBName
+	if dbname == "" {
+		dbname = meta.DBName
+	}
+	m := NewManager(storage.NewStore(dbname), storage.NewStore(meta.DBName), meta.DBColl, cfg, queue)
+	m.Mailer = mail.DefaultMailer
+	Searches = m
+	return nil
+}
+
+// helper function to provide id of saved search
+func searchID(owner, name string) string {
+	sum := sha256.Sum256([]byte(owner + "\x00" + name))
+	return hex.EncodeToString(sum[:])
+}
+
+// helper function to check value of query condition
+func checkScalar(key string, val any) error {
+	switch val.(type) {
+	case string, bool, float64, float32, int, int32, int64:
+		return nil
+	}
+	return fmt.Errorf("%w, unsupported value of query key '%s'", ErrInvalidQuery, key)
+}
+
+// ValidateQuery checks query conditions, queries are passed to storage
+// and should not contain operators other than range ones
+func ValidateQuery(query map[string]any) error {
+	for key, val := range query {
+		if key == "" || strings.HasPrefix(key, "$") {
+			return fmt.Errorf("%w, invalid query key '%s'", ErrInvalidQuery, key)
+		}
+		cond, ok := val.(map[string]any)
+		if !ok {
+			if err := checkScalar(key, val); err != nil {
+				return err
+			}
+			continue
+		}
+		if len(cond) == 0 {
+			return fmt.Errorf("%w, empty condition of query key '%s'", ErrInvalidQuery, key)
+		}
+		for op, v := range cond {
+			if !utils.InList(op, rangeOperators) {
+				return fmt.Errorf("%w, unsupported operator '%s' of query key '%s'", ErrInvalidQuery, op, key)
+			}
+			if err := checkScalar(key, v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Validate checks saved search of the manager
+func (m *Manager) Validate(s Search) error {
+	if s.Owner == "" {
+		return fmt.Errorf("%w, search without owner", ErrInvalid)
+	}
+	if s.Name == "" || len(s.Name) > 128 || strings.ContainsAny(s.Name, "/?#") {
+		return fmt.Errorf("%w, invalid name '%s'", ErrInvalid, s.Name)
+	}
+	if err := ValidateQuery(s.Query); err != nil {
+		return fmt.Errorf("%w, %v", ErrInvalid, err)
+	}
+	sub := s.Subscription
+	if sub == nil {
+		return nil
+	}
+	if sub.Email == "" && sub.Webhook == "" {
+		return fmt.Errorf("%w, subscription without email or webhook", ErrInvalid)
+	}
+	if sub.Email != "" {
+		if _, err := netMail.ParseAddress(sub.Email); err != nil {
+			return fmt.Errorf("%w, invalid email '%s'", ErrInvalid, sub.Email)
+		}
+	}
+	if sub.Webhook != "" {
+		u, err := url.Parse(sub.Webhook)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%w, invalid webhook URL '%s'", ErrInvalid, sub.Webhook)
+		}
+	}
+	// new records are selected by insertion time, therefore subscribed
+	// queries can not constrain it
+	if _, ok := s.Query[m.DateKey]; ok {
+		return fmt.Errorf("%w, query of subscription can not use '%s' key", ErrInvalid, m.DateKey)
+	}
+	return nil
+}
+
+// helper function to convert saved search into storage record
+func toRecord(s Search) (map[string]any, error) {
+	query, err := json.Marshal(s.Query)
+	if err != nil {
+		return nil, err
+	}
+	rec := map[string]any{
+		"_id":          searchID(s.Owner, s.Name),
+		"owner":        s.Owner,
+		"name":         s.Name,
+		"query":        string(query),
+		"email":        "",
+		"webhook":      "",
+		"secret":       "",
+		"subscribed":   s.Subscription != nil,
+		"created":      s.Created,
+		"last_checked": s.LastChecked,
+	}
+	if sub := s.Subscription; sub != nil {
+		rec["email"] = sub.Email
+		rec["webhook"] = sub.Webhook
+		rec["secret"] = sub.Secret
+	}
+	return rec, nil
+}
+
+// helper function to convert storage record into saved search
+func fromRecord(rec map[string]any) (Search, error) {
+	s := Search{Query: make(map[string]any)}
+	s.Owner, _ = rec["owner"].(string)
+	s.Name, _ = rec["name"].(string)
+	s.Created = utils.ToInt64(rec["created"])
+	s.LastChecked = utils.ToInt64(rec["last_checked"])
+	if query, ok := rec["query"].(string); ok && query != "" {
+		if err := json.Unmarshal([]byte(query), &s.Query); err != nil {
+			return s, fmt.Errorf("unable to decode query of saved search %s, error %v", s.Name, err)
+		}
+	}
+	if subscribed, _ := rec["subscribed"].(bool); subscribed {
+		s.Subscription = &Subscription{}
+		s.Subscription.Email, _ = rec["email"].(string)
+		s.Subscription.Webhook, _ = rec["webhook"].(string)
+		s.Subscription.Secret, _ = rec["secret"].(string)
+	}
+	return s, nil
+}
+
+// Get returns saved search of given owner and name
+func (m *Manager) Get(ctx context.Context, owner, name string) (Search, error) {
+	rec, err := storage.FindOne(ctx, m.Store, m.Collection, map[string]any{"_id": searchID(owner, name)})
+	if errors.Is(err, storage.ErrNotFound) {
+		return Search{}, fmt.Errorf("%w '%s'", ErrNotFound, name)
+	}
+	if err != nil {
+		return Search{}, err
+	}
+	return fromRecord(rec)
+}
+
+// List returns saved searches of given owner sorted by name
+func (m *Manager) List(ctx context.Context, owner string) ([]Search, error) {
+	records, err := m.Store.Find(ctx, m.Collection, map[string]any{"owner": owner}, &storage.FindOptions{Sort: []string{"name"}})
+	if err != nil {
+		return nil, err
+	}
+	out := []Search{}
+	for _, rec := range records {
+		s, err := fromRecord(rec)
+		if err != nil {
+			log.Println("ERROR:", err)
+			continue
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+// Save creates saved search or replaces query and subscription of existing
+// one. New subscriptions notify about records inserted after they are
+// saved.
+func (m *Manager) Save(ctx context.Context, s Search) (Search, error) {
+	if s.Query == nil {
+		s.Query = make(map[string]any)
+	}
+	if err := m.Validate(s); err != nil {
+		return s, err
+	}
+	now := time.Now().Unix()
+	old, err := m.Get(ctx, s.Owner, s.Name)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return s, err
+	}
+	if err == nil {
+		s.Created = old.Created
+		s.LastChecked = old.LastChecked
+		if old.Subscription == nil {
+			s.LastChecked = now
+		} else if s.Subscription != nil && s.Subscription.Secret == RedactedSecret {
+			s.Subscription.Secret = old.Subscription.Secret
+		}
+		rec, err := toRecord(s)
+		if err != nil {
+			return s, err
+		}
+		delete(rec, "_id")
+		_, err = m.Store.Update(ctx, m.Collection, map[string]any{"_id": searchID(s.Owner, s.Name)}, rec)
+		return s, err
+	}
+	nrec, err := m.Store.Count(ctx, m.Collection, map[string]any{"owner": s.Owner})
+	if err != nil {
+		return s, err
+	}
+	if nrec >= int64(MaxSearches) {
+		return s, fmt.Errorf("%w, user %s has %d saved searches", ErrLimit, s.Owner, nrec)
+	}
+	s.Created = now
+	s.LastChecked = now
+	rec, err := toRecord(s)
+	if err != nil {
+		return s, err
+	}
+	return s, m.Store.Insert(ctx, m.Collection, rec)
+}
+
+// Delete removes saved search of given owner and name
+func (m *Manager) Delete(ctx context.Context, owner, name string) error {
+	nrec, err := m.Store.Remove(ctx, m.Collection, map[string]any{"_id": searchID(owner, name)})
+	if err != nil {
+		return err
+	}
+	if nrec == 0 {
+		return fmt.Errorf("%w '%s'", ErrNotFound, name)
+	}
+	return nil
+}
+
+// Execute returns records matching saved search of given owner and name,
+// the newest records come first
+func (m *Manager) Execute(ctx context.Context, owner, name string, skip, limit int) ([]map[string]any, error) {
+	s, err := m.Get(ctx, owner, name)
+	if err != nil {
+		return nil, err
+	}
+	if limit <= 0 || limit > MaxResults {
+		limit = MaxResults
+	}
+	opts := &storage.FindOptions{Skip: skip, Limit: limit, Sort: []string{"-" + m.DateKey}}
+	return m.Records.Find(ctx, m.RecordsCollection, s.Query, opts)
+}
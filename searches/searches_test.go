@@ -0,0 +1,209 @@
+package searches
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	jobs "github.com/CHESSComputing/golib/jobs"
+	mail "github.com/CHESSComputing/golib/mail"
+	storage "github.com/CHESSComputing/golib/storage"
+	users "github.com/CHESSComputing/golib/users"
+	"github.com/gin-gonic/gin"
+)
+
+// helper function to create manager over memory stores
+func testManager(t *testing.T, queue *jobs.Queue) (*Manager, storage.Store, *mail.MemorySender) {
+	records := storage.NewMemoryStore()
+	m := NewManager(storage.NewMemoryStore(), records, "meta", srvConfig.SavedSearches{MaxMatches: 2}, queue)
+	sender := &mail.MemorySender{}
+	mailer, err := mail.NewMailer(srvConfig.SMTP{From: "foxden@example.com", BaseURL: "https://foxden"}, sender, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Mailer = mailer
+	return m, records, sender
+}
+
+// TestSave
+func TestSave(t *testing.T) {
+	m, records, _ := testManager(t, nil)
+	ctx := context.Background()
+	query := map[string]any{"beamline": "3a", "energy": map[string]any{"$gte": 10.0}}
+	s, err := m.Save(ctx, Search{Owner: "alice", Name: "3a", Query: query})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Created == 0 || s.LastChecked != s.Created {
+		t.Errorf("wrong timestamps of saved search %+v", s)
+	}
+	records.Insert(ctx, "meta",
+		map[string]any{"did": "/a", "beamline": "3a", "energy": 12, "Date": 1},
+		map[string]any{"did": "/b", "beamline": "3a", "energy": 5, "Date": 2},
+		map[string]any{"did": "/c", "beamline": "3a", "energy": 15, "Date": 3})
+	recs, err := m.Execute(ctx, "alice", "3a", 0, 0)
+	if err != nil || len(recs) != 2 || recs[0]["did"] != "/c" {
+		t.Errorf("wrong records of saved search %v, error %v", recs, err)
+	}
+
+	// searches are private
+	if _, err := m.Execute(ctx, "bob", "3a", 0, 0); !errors.Is(err, ErrNotFound) {
+		t.Errorf("search of other user is executed, error %v", err)
+	}
+	if list, err := m.List(ctx, "alice"); err != nil || len(list) != 1 || list[0].Query["beamline"] != "3a" {
+		t.Errorf("wrong list of saved searches %+v, error %v", list, err)
+	}
+	if err := m.Delete(ctx, "alice", "3a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Delete(ctx, "alice", "3a"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("deleted search is found, error %v", err)
+	}
+
+	invalid := []Search{
+		{Owner: "alice", Name: "a/b"},
+		{Owner: "alice", Name: "op", Query: map[string]any{"$where": "1"}},
+		{Owner: "alice", Name: "op", Query: map[string]any{"a": map[string]any{"$ne": 1}}},
+		{Owner: "alice", Name: "list", Query: map[string]any{"a": []any{1}}},
+		{Owner: "alice", Name: "sub", Subscription: &Subscription{}},
+		{Owner: "alice", Name: "sub", Subscription: &Subscription{Email: "alice"}},
+		{Owner: "alice", Name: "sub", Subscription: &Subscription{Webhook: "file:///etc/passwd"}},
+		{Owner: "alice", Name: "sub", Query: map[string]any{"Date": 1}, Subscription: &Subscription{Email: "alice@example.com"}},
+	}
+	for _, s := range invalid {
+		if _, err := m.Save(ctx, s); !errors.Is(err, ErrInvalid) {
+			t.Errorf("invalid search %+v is saved, error %v", s, err)
+		}
+	}
+}
+
+// TestEvaluate
+func TestEvaluate(t *testing.T) {
+	var payload []byte
+	var signature string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload, _ = io.ReadAll(r.Body)
+		signature = r.Header.Get(SignatureHeader)
+	}))
+	defer srv.Close()
+	queue := jobs.NewQueue(jobs.NewMemoryStore())
+	m, records, sender := testManager(t, queue)
+	ctx := context.Background()
+	sub := &Subscription{Email: "alice@example.com", Webhook: srv.URL, Secret: "secret"}
+	s, err := m.Save(ctx, Search{Owner: "alice", Name: "3a", Query: map[string]any{"beamline": "3a"}, Subscription: sub})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(s.LastChecked, 0)
+	// records inserted before subscription are not notified
+	records.Insert(ctx, "meta",
+		map[string]any{"did": "/old", "beamline": "3a", "Date": now.Unix()},
+		map[string]any{"did": "/a", "beamline": "3a", "Date": now.Unix() + 1},
+		map[string]any{"did": "/b", "beamline": "3b", "Date": now.Unix() + 2},
+		map[string]any{"did": "/c", "beamline": "3a", "Date": now.Unix() + 3},
+		map[string]any{"did": "/d", "beamline": "3a", "Date": now.Unix() + 4})
+	sent, err := m.Evaluate(ctx, now.Add(10*time.Second))
+	if err != nil || sent != 1 {
+		t.Fatalf("expect one notification, got %d, error %v", sent, err)
+	}
+	msgs := sender.Messages()
+	if len(msgs) != 1 || !strings.Contains(msgs[0].Subject, "has 3 new records") ||
+		!strings.Contains(msgs[0].Text, "https://foxden/record?did=/a") || !strings.Contains(msgs[0].Text, "Only first 2 records") {
+		t.Errorf("wrong email notification %+v", msgs)
+	}
+
+	// webhook is delivered by queue with signed payload
+	if ok, err := queue.Process(ctx, "test"); !ok || err != nil {
+		t.Fatalf("webhook task is not processed, error %v", err)
+	}
+	var n Notification
+	if err := json.Unmarshal(payload, &n); err != nil {
+		t.Fatal(err)
+	}
+	if n.Count != 3 || !n.Truncated || len(n.Records) != 2 || n.Records[1] != "/c" || signature != Signature("secret", payload) {
+		t.Errorf("wrong webhook notification %+v, signature %s", n, signature)
+	}
+
+	// evaluated window is not notified again
+	if sent, err := m.Evaluate(ctx, now.Add(20*time.Second)); err != nil || sent != 0 {
+		t.Errorf("records are notified twice, sent %d, error %v", sent, err)
+	}
+
+	// owner disabled email notifications about saved searches
+	m.Users = users.NewManager(storage.NewMemoryStore())
+	m.Users.BcryptCost = 4
+	if _, err := m.Users.Create(ctx, users.User{Name: "alice", Email: "alice@example.com"}, "secret-password"); err != nil {
+		t.Fatal(err)
+	}
+	prefs := users.Notifications{users.EventSearchMatches: {users.ChannelWebhook}}
+	if _, err := m.Users.SetNotifications(ctx, "alice", prefs); err != nil {
+		t.Fatal(err)
+	}
+	records.Insert(ctx, "meta", map[string]any{"did": "/e", "beamline": "3a", "Date": now.Unix() + 25})
+	if sent, err := m.Evaluate(ctx, now.Add(30*time.Second)); err != nil || sent != 1 {
+		t.Fatalf("expect one notification, got %d, error %v", sent, err)
+	}
+	if msgs := sender.Messages(); len(msgs) != 1 {
+		t.Errorf("email is sent despite preferences, messages %+v", msgs)
+	}
+	if ok, err := queue.Process(ctx, "test"); !ok || err != nil {
+		t.Errorf("webhook task is not processed, error %v", err)
+	}
+}
+
+// TestHandlers
+func TestHandlers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	m, _, _ := testManager(t, nil)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set("user", c.GetHeader("X-User"))
+	})
+	for _, route := range m.Routes("/searches") {
+		r.Handle(route.Method, route.Path, route.Handler)
+	}
+	send := func(method, target, user, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("X-User", user)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	body := `{"name": "3a", "query": {"beamline": "3a"}, "subscription": {"webhook": "https://example.com/hook", "secret": "secret"}}`
+	if w := send("POST", "/searches", "alice", body); w.Code != http.StatusOK || strings.Contains(w.Body.String(), `"secret":"secret"`) {
+		t.Errorf("unexpected response %d %s", w.Code, w.Body.String())
+	}
+	// saving search with redacted secret keeps the secret
+	body = `{"name": "3a", "query": {"beamline": "3b"}, "subscription": {"webhook": "https://example.com/hook", "secret": "***"}}`
+	if w := send("POST", "/searches", "alice", body); w.Code != http.StatusOK {
+		t.Errorf("unexpected response %d %s", w.Code, w.Body.String())
+	}
+	if s, err := m.Get(context.Background(), "alice", "3a"); err != nil || s.Subscription.Secret != "secret" || s.Query["beamline"] != "3b" {
+		t.Errorf("wrong saved search %+v, error %v", s, err)
+	}
+	expect := []struct {
+		method, target, user, body string
+		code                       int
+	}{
+		{"GET", "/searches/3a", "alice", "", http.StatusOK},
+		{"GET", "/searches/3a", "bob", "", http.StatusNotFound},
+		{"GET", "/searches", "", "", http.StatusUnauthorized},
+		{"GET", "/searches/3a/records?limit=5", "alice", "", http.StatusOK},
+		{"POST", "/searches", "alice", `{"name": "x", "query": {"$where": 1}}`, http.StatusBadRequest},
+		{"POST", "/searches", "alice", `[`, http.StatusBadRequest},
+		{"DELETE", "/searches/3a", "bob", "", http.StatusNotFound},
+		{"DELETE", "/searches/3a", "alice", "", http.StatusNoContent},
+	}
+	for _, e := range expect {
+		if w := send(e.method, e.target, e.user, e.body); w.Code != e.code {
+			t.Errorf("%s %s of %s: expect %d, got %d %s", e.method, e.target, e.user, e.code, w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	"github.com/gin-gonic/gin"
+)
+
+// TestAnonymousRoutes
+func TestAnonymousRoutes(t *testing.T) {
+	config := srvConfig.Config
+	defer func() { srvConfig.Config = config }()
+	srvConfig.Config = &srvConfig.SrvConfig{}
+	srvConfig.Config.Authz.ClientID = "secret"
+	srvConfig.Config.Authz.Anonymous = srvConfig.Anonymous{Enabled: true}
+	gin.SetMode(gin.TestMode)
+
+	handler := func(c *gin.Context) { c.String(http.StatusOK, "ok") }
+	routes := []Route{
+		{Method: "GET", Path: "/records", Authorized: true, Handler: handler},
+		{Method: "POST", Path: "/records", Authorized: true, Handler: handler},
+		{Method: "DELETE", Path: "/records", Authorized: true, Handler: handler},
+	}
+	r := Router(routes, nil, "", srvConfig.WebServer{})
+	for _, tc := range []struct {
+		method string
+		code   int
+	}{
+		{"GET", http.StatusOK},
+		{"POST", http.StatusUnauthorized},
+		{"DELETE", http.StatusUnauthorized},
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(tc.method, "/records", nil))
+		if w.Code != tc.code {
+			t.Errorf("anonymous %s request, code %d expected %d", tc.method, w.Code, tc.code)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package server
+
+// assets module provides static assets server which works with local
+// directories and embedded filesystems. It handles ETag and Last-Modified
+// validation, sets immutable cache headers for fingerprinted assets and
+// provides asset URLs with cache busting for templates.
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AssetManifestFile defines name of asset manifest which maps logical asset
+// names to fingerprinted ones, e.g. {"js/app.js": "js/app.3f2a1b9c.js"}
+var AssetManifestFile = "manifest.json"
+
+// ImmutableCacheControl defines Cache-Control header of fingerprinted assets
+var ImmutableCacheControl = "public, max-age=31536000, immutable"
+
+// patternFingerprint matches fingerprinted file names, e.g. app.3f2a1b9c.js
+var patternFingerprint = regexp.MustCompile(`\.[0-9a-fA-F]{8,}\.[A-Za-z0-9]+$`)
+
+// StaticAssets represents static assets served by the Router
+var StaticAssets *Assets
+
+// Assets represents static assets server
+type Assets struct {
+	FS           fs.FS             // filesystem with assets
+	Prefix       string            // URL prefix of assets, e.g. /static
+	CacheControl string            // Cache-Control header of non fingerprinted assets
+	Manifest     map[string]string // asset manifest
+
+	mu    sync.Mutex
+	etags map[string]assetInfo
+}
+
+// assetInfo represents cached asset hash
+type assetInfo struct {
+	size    int64
+	modTime time.Time
+	hash    string
+}
+
+// NewAssets returns assets server for given filesystem and URL prefix, it
+// loads asset manifest if it exists in the filesystem
+func NewAssets(fsys fs.FS, prefix string) *Assets {
+	a := &Assets{
+		FS:           fsys,
+		Prefix:       strings.TrimSuffix(prefix, "/"),
+		CacheControl: "no-cache",
+		etags:        make(map[string]assetInfo),
+	}
+	if data, err := fs.ReadFile(fsys, AssetManifestFile); err == nil {
+		if err := json.Unmarshal(data, &a.Manifest); err != nil {
+			log.Printf("ERROR: unable to parse asset manifest %s, error %v", AssetManifestFile, err)
+		}
+	}
+	return a
+}
+
+// helper function to provide hash of asset content, hashes are cached
+// until file size or modification time change
+func (a *Assets) hash(name string, fi fs.FileInfo) (string, error) {
+	a.mu.Lock()
+	info, ok := a.etags[name]
+	a.mu.Unlock()
+	if ok && info.size == fi.Size() && info.modTime.Equal(fi.ModTime()) {
+		return info.hash, nil
+	}
+	file, err := a.FS.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	info = assetInfo{size: fi.Size(), modTime: fi.ModTime(), hash: hex.EncodeToString(h.Sum(nil))[:16]}
+	a.mu.Lock()
+	if a.etags == nil {
+		a.etags = make(map[string]assetInfo)
+	}
+	a.etags[name] = info
+	a.mu.Unlock()
+	return info.hash, nil
+}
+
+// URL returns URL of given asset with cache busting. Fingerprinted name is
+// used if asset is listed in manifest, otherwise content hash is added as
+// v query parameter.
+func (a *Assets) URL(name string) string {
+	name = strings.TrimPrefix(name, "/")
+	if fname, ok := a.Manifest[name]; ok {
+		return fmt.Sprintf("%s/%s", a.Prefix, fname)
+	}
+	fi, err := fs.Stat(a.FS, name)
+	if err != nil {
+		log.Printf("WARNING: unable to find asset %s, error %v", name, err)
+		return fmt.Sprintf("%s/%s", a.Prefix, name)
+	}
+	hash, err := a.hash(name, fi)
+	if err != nil {
+		return fmt.Sprintf("%s/%s", a.Prefix, name)
+	}
+	return fmt.Sprintf("%s/%s?v=%s", a.Prefix, name, hash[:8])
+}
+
+// helper function to check if asset is fingerprinted
+func (a *Assets) fingerprinted(name, version, hash string) bool {
+	if version != "" {
+		return strings.HasPrefix(hash, version)
+	}
+	for _, fname := range a.Manifest {
+		if fname == name {
+			return true
+		}
+	}
+	return patternFingerprint.MatchString(name)
+}
+
+// ServeFile serves asset with given name
+func (a *Assets) ServeFile(w http.ResponseWriter, r *http.Request, name string) {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	fi, err := fs.Stat(a.FS, name)
+	if err != nil || fi.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	hash, err := a.hash(name, fi)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	file, err := a.FS.Open(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer file.Close()
+	w.Header().Set("ETag", fmt.Sprintf(`"%s"`, hash))
+	if a.fingerprinted(name, r.URL.Query().Get("v"), hash) {
+		w.Header().Set("Cache-Control", ImmutableCacheControl)
+	} else if a.CacheControl != "" {
+		w.Header().Set("Cache-Control", a.CacheControl)
+	}
+	// embedded files do not have modification time, use server start time
+	modTime := fi.ModTime()
+	if modTime.IsZero() {
+		modTime = StartTime
+	}
+	if reader, ok := file.(io.ReadSeeker); ok {
+		// ServeContent handles If-None-Match, If-Modified-Since and ranges
+		http.ServeContent(w, r, name, modTime, reader)
+		return
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.ServeContent(w, r, name, modTime, bytes.NewReader(data))
+}
+
+// ServeHTTP implements http.Handler interface, request path should include
+// assets prefix
+func (a *Assets) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.ServeFile(w, r, strings.TrimPrefix(r.URL.Path, a.Prefix))
+}
+
+// Handler returns gin handler which serves assets of given directory, it
+// should be used with routes ending with /*filepath
+func (a *Assets) Handler(dir string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		a.ServeFile(c.Writer, c.Request, path.Join(dir, c.Param("filepath")))
+	}
+}
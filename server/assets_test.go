@@ -0,0 +1,65 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	"github.com/gin-gonic/gin"
+)
+
+// TestAssets
+func TestAssets(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	fsys := fstest.MapFS{
+		"static/css/main.css":         {Data: []byte("body {}"), ModTime: time.Now()},
+		"static/js/app.3f2a1b9c.js":   {Data: []byte("console.log(1)")},
+		"static/manifest.json":        {Data: []byte(`{"js/app.js": "js/app.3f2a1b9c.js"}`)},
+		"static/templates/index.tmpl": {Data: []byte("index")},
+	}
+	webServer := srvConfig.WebServer{Base: "/app", StaticDir: "static"}
+	r := Router(nil, fsys, "static", webServer)
+	if StaticAssets.URL("js/app.js") != "/app/js/app.3f2a1b9c.js" {
+		t.Errorf("wrong manifest asset URL %s", StaticAssets.URL("js/app.js"))
+	}
+	cssURL := StaticAssets.URL("css/main.css")
+	if !strings.HasPrefix(cssURL, "/app/css/main.css?v=") {
+		t.Errorf("wrong asset URL %s", cssURL)
+	}
+
+	// non fingerprinted asset should be re-validated
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/app/css/main.css", nil))
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || w.Body.String() != "body {}" || etag == "" || w.Header().Get("Last-Modified") == "" {
+		t.Fatalf("wrong response %d %s %v", w.Code, w.Body.String(), w.Header())
+	}
+	if w.Header().Get("Cache-Control") != "no-cache" {
+		t.Errorf("wrong cache control %s", w.Header().Get("Cache-Control"))
+	}
+	req := httptest.NewRequest("GET", "/app/css/main.css", nil)
+	req.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("expect not modified, got %d", w.Code)
+	}
+
+	// fingerprinted assets are immutable
+	for _, rurl := range []string{cssURL, "/app/js/app.3f2a1b9c.js"} {
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", rurl, nil))
+		if w.Code != http.StatusOK || w.Header().Get("Cache-Control") != ImmutableCacheControl {
+			t.Errorf("%s: wrong response %d %v", rurl, w.Code, w.Header())
+		}
+	}
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/app/css/../../static/manifest.json", nil))
+	if w.Code == http.StatusOK {
+		t.Error("path outside of asset directories should not be served")
+	}
+}
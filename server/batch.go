@@ -0,0 +1,223 @@
+package server
+
+// batch module provides batch API endpoint which executes list of
+// sub-requests with authentication of the batch request and returns their
+// results, e.g. for bulk edits of the frontend. Sub-requests of atomic
+// batches are executed within a transaction if it is supported.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	services "github.com/CHESSComputing/golib/services"
+	"github.com/gin-gonic/gin"
+)
+
+// BatchPath defines default path of batch endpoint
+const BatchPath = "/batch"
+
+// MaxBatchSize defines maximum number of sub-requests of the batch
+var MaxBatchSize = 100
+
+// BatchHeaders defines headers of batch request passed to sub-requests,
+// i.e. sub-requests share authentication of the batch request
+var BatchHeaders = []string{"Authorization", "Cookie", "X-API-Key", "X-Request-ID", "X-Tenant"}
+
+// BatchTransaction executes function within a transaction, e.g.
+// storage.Transaction of the store, sub-requests should use provided
+// context. Nil value means that transactions are not supported.
+var BatchTransaction func(ctx context.Context, fn func(ctx context.Context) error) error
+
+// BatchRequest represents single sub-request of the batch
+type BatchRequest struct {
+	ID      string            `json:"id,omitempty"`      // optional id of sub-request reported in its result
+	Method  string            `json:"method"`            // HTTP method
+	Path    string            `json:"path"`              // path with query, e.g. /meta/123?comment=fix
+	Headers map[string]string `json:"headers,omitempty"` // additional headers, e.g. If-Match
+	Body    json.RawMessage   `json:"body,omitempty"`    // JSON body
+}
+
+// BatchResult represents result of single sub-request
+type BatchResult struct {
+	ID      string            `json:"id,omitempty"`      // id of sub-request
+	Status  int               `json:"status"`            // HTTP status code
+	Headers map[string]string `json:"headers,omitempty"` // response headers, e.g. ETag or Location
+	Body    json.RawMessage   `json:"body,omitempty"`    // response body, non JSON responses are encoded as JSON string
+}
+
+// BatchResponse represents response of batch endpoint
+type BatchResponse struct {
+	Results     []BatchResult `json:"results"`               // results in order of sub-requests
+	Atomic      bool          `json:"atomic"`                // batch is executed until first failure
+	Transaction bool          `json:"transaction"`           // batch is executed within transaction
+	RolledBack  bool          `json:"rolled_back,omitempty"` // transaction is rolled back
+}
+
+// errBatchFailed is used to roll back transaction of failed atomic batch
+var errBatchFailed = errors.New("batch sub-request failed")
+
+// batchWriter represents response writer of sub-request
+type batchWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+// Header implements http.ResponseWriter interface
+func (w *batchWriter) Header() http.Header {
+	return w.header
+}
+
+// Write implements http.ResponseWriter interface
+func (w *batchWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(data)
+}
+
+// WriteHeader implements http.ResponseWriter interface
+func (w *batchWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+// helper function to check sub-requests of the batch
+func checkBatch(reqs []BatchRequest) error {
+	if len(reqs) == 0 {
+		return errors.New("empty batch")
+	}
+	if len(reqs) > MaxBatchSize {
+		return fmt.Errorf("batch of %d requests exceeds limit of %d requests", len(reqs), MaxBatchSize)
+	}
+	for i, r := range reqs {
+		switch {
+		case r.Method == "":
+			return fmt.Errorf("request %d: empty method", i)
+		case !strings.HasPrefix(r.Path, "/"):
+			return fmt.Errorf("request %d: path should start with /", i)
+		case strings.HasPrefix(r.Path, BatchPath):
+			return fmt.Errorf("request %d: nested batches are not allowed", i)
+		}
+	}
+	return nil
+}
+
+// helper function to execute sub-request
+func execBatchRequest(ctx context.Context, h http.Handler, parent *http.Request, breq BatchRequest) BatchResult {
+	res := BatchResult{ID: breq.ID}
+	req, err := http.NewRequestWithContext(ctx, strings.ToUpper(breq.Method), breq.Path, bytes.NewReader(breq.Body))
+	if err != nil {
+		res.Status = http.StatusBadRequest
+		res.Body, _ = json.Marshal(err.Error())
+		return res
+	}
+	req.RemoteAddr = parent.RemoteAddr
+	req.Host = parent.Host
+	for _, key := range BatchHeaders {
+		if val := parent.Header.Get(key); val != "" {
+			req.Header.Set(key, val)
+		}
+	}
+	if len(breq.Body) > 0 {
+		req.Header.Set("Content-Type", gin.MIMEJSON)
+	}
+	for key, val := range breq.Headers {
+		req.Header.Set(key, val)
+	}
+	w := &batchWriter{header: make(http.Header)}
+	h.ServeHTTP(w, req)
+	res.Status = w.status
+	if res.Status == 0 {
+		res.Status = http.StatusOK
+	}
+	for _, key := range []string{"ETag", "Location"} {
+		if val := w.header.Get(key); val != "" {
+			if res.Headers == nil {
+				res.Headers = make(map[string]string)
+			}
+			res.Headers[key] = val
+		}
+	}
+	if data := w.body.Bytes(); len(data) > 0 {
+		if json.Valid(data) {
+			res.Body = json.RawMessage(append([]byte{}, data...))
+		} else {
+			res.Body, _ = json.Marshal(string(data))
+		}
+	}
+	return res
+}
+
+// helper function to execute sub-requests, execution of atomic batch stops
+// at first failed sub-request and the rest of sub-requests get 424 status
+func execBatch(ctx context.Context, h http.Handler, parent *http.Request, reqs []BatchRequest, atomic bool) ([]BatchResult, bool) {
+	results := make([]BatchResult, 0, len(reqs))
+	failed := false
+	for _, breq := range reqs {
+		if failed {
+			results = append(results, BatchResult{ID: breq.ID, Status: http.StatusFailedDependency})
+			continue
+		}
+		res := execBatchRequest(ctx, h, parent, breq)
+		results = append(results, res)
+		if atomic && res.Status >= http.StatusBadRequest {
+			failed = true
+		}
+	}
+	return results, failed
+}
+
+// BatchHandler provides batch endpoint which executes JSON array of
+// sub-requests via given handler (router of the service), e.g.
+//
+//	r.POST(server.BatchPath, server.BatchHandler(r))
+//
+// Sub-requests are executed in order with authentication headers of the
+// batch request. With atomic=true query parameter execution stops at first
+// failed sub-request and, if BatchTransaction is set, all sub-requests are
+// executed within single transaction which is rolled back on failure.
+func BatchHandler(h http.Handler) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var reqs []BatchRequest
+		if err := c.ShouldBindJSON(&reqs); err != nil {
+			c.JSON(http.StatusBadRequest, services.Response("server", http.StatusBadRequest, services.BindError, err))
+			return
+		}
+		if err := checkBatch(reqs); err != nil {
+			c.JSON(http.StatusBadRequest, services.Response("server", http.StatusBadRequest, services.ParametersError, err))
+			return
+		}
+		resp := BatchResponse{Atomic: c.Query("atomic") == "true"}
+		ctx := c.Request.Context()
+		if !resp.Atomic || BatchTransaction == nil {
+			resp.Results, _ = execBatch(ctx, h, c.Request, reqs, resp.Atomic)
+			c.JSON(http.StatusOK, resp)
+			return
+		}
+		resp.Transaction = true
+		err := BatchTransaction(ctx, func(tctx context.Context) error {
+			// transaction function may be retried, results are reset
+			var failed bool
+			resp.Results, failed = execBatch(tctx, h, c.Request, reqs, true)
+			if failed {
+				return errBatchFailed
+			}
+			return nil
+		})
+		if err != nil {
+			resp.RolledBack = true
+			if !errors.Is(err, errBatchFailed) {
+				c.JSON(http.StatusInternalServerError, services.Response("server", http.StatusInternalServerError, services.TransactionError, err))
+				return
+			}
+		}
+		c.JSON(http.StatusOK, resp)
+	}
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// helper function to create router of batch tests, records are kept in
+// given map and updates require Authorization header
+func batchRouter(records map[string]string) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST(BatchPath, BatchHandler(r))
+	r.GET("/records/:id", func(c *gin.Context) {
+		val, ok := records[c.Param("id")]
+		if !ok {
+			c.String(http.StatusNotFound, "not found")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"id": c.Param("id"), "value": val})
+	})
+	r.PUT("/records/:id", func(c *gin.Context) {
+		if c.GetHeader("Authorization") != "Bearer token" {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		var rec map[string]string
+		if err := c.ShouldBindJSON(&rec); err != nil || rec["value"] == "" {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		records[c.Param("id")] = rec["value"]
+		c.Header("Location", "/records/"+c.Param("id"))
+		c.JSON(http.StatusOK, rec)
+	})
+	return r
+}
+
+// helper function to send batch request
+func sendBatch(t *testing.T, r http.Handler, target, body string) (int, BatchResponse) {
+	req := httptest.NewRequest("POST", target, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer token")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	var resp BatchResponse
+	if w.Code == http.StatusOK {
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return w.Code, resp
+}
+
+// TestBatchHandler
+func TestBatchHandler(t *testing.T) {
+	records := map[string]string{"1": "a"}
+	r := batchRouter(records)
+	body := `[
+		{"id": "get", "method": "GET", "path": "/records/1"},
+		{"id": "put", "method": "PUT", "path": "/records/2", "body": {"value": "b"}},
+		{"id": "missing", "method": "GET", "path": "/records/3"},
+		{"id": "after", "method": "PUT", "path": "/records/4", "body": {"value": "d"}}
+	]`
+	code, resp := sendBatch(t, r, BatchPath, body)
+	if code != http.StatusOK || len(resp.Results) != 4 || resp.Atomic {
+		t.Fatalf("unexpected batch response %d %+v", code, resp)
+	}
+	expect := []int{http.StatusOK, http.StatusOK, http.StatusNotFound, http.StatusOK}
+	for i, res := range resp.Results {
+		if res.Status != expect[i] {
+			t.Errorf("result %s: expect status %d, got %d", res.ID, expect[i], res.Status)
+		}
+	}
+	if string(resp.Results[0].Body) != `{"id":"1","value":"a"}` || string(resp.Results[2].Body) != `"not found"` {
+		t.Errorf("wrong result bodies %s %s", resp.Results[0].Body, resp.Results[2].Body)
+	}
+	// sub-requests share authentication of the batch request
+	if records["2"] != "b" || resp.Results[1].Headers["Location"] != "/records/2" {
+		t.Errorf("sub-request is not executed %v %v", records, resp.Results[1])
+	}
+
+	// atomic batch without transactions stops at first failure
+	body = `[{"method": "PUT", "path": "/records/5", "body": {}}, {"method": "PUT", "path": "/records/6", "body": {"value": "f"}}]`
+	_, resp = sendBatch(t, r, BatchPath+"?atomic=true", body)
+	if !resp.Atomic || resp.Transaction || resp.Results[0].Status != http.StatusBadRequest || resp.Results[1].Status != http.StatusFailedDependency {
+		t.Errorf("unexpected atomic batch response %+v", resp)
+	}
+	if _, ok := records["6"]; ok {
+		t.Error("sub-request after failure is executed")
+	}
+
+	// invalid batches
+	for _, body := range []string{`{}`, `[]`, `[{"method": "GET", "path": "records"}]`, `[{"method": "POST", "path": "/batch"}]`} {
+		if code, _ := sendBatch(t, r, BatchPath, body); code != http.StatusBadRequest {
+			t.Errorf("invalid batch %s is accepted, code %d", body, code)
+		}
+	}
+}
+
+// TestBatchTransaction
+func TestBatchTransaction(t *testing.T) {
+	records := map[string]string{}
+	r := batchRouter(records)
+	// test transaction keeps copy of records and restores it on failure
+	defer func() { BatchTransaction = nil }()
+	BatchTransaction = func(ctx context.Context, fn func(ctx context.Context) error) error {
+		saved := make(map[string]string)
+		for k, v := range records {
+			saved[k] = v
+		}
+		err := fn(ctx)
+		if err != nil {
+			for k := range records {
+				delete(records, k)
+			}
+			for k, v := range saved {
+				records[k] = v
+			}
+		}
+		return err
+	}
+	body := `[{"method": "PUT", "path": "/records/1", "body": {"value": "a"}}, {"method": "PUT", "path": "/records/2", "body": {}}]`
+	_, resp := sendBatch(t, r, BatchPath+"?atomic=true", body)
+	if !resp.Transaction || !resp.RolledBack || len(records) != 0 {
+		t.Errorf("failed batch is not rolled back %+v %v", resp, records)
+	}
+	body = `[{"method": "PUT", "path": "/records/1", "body": {"value": "a"}}, {"method": "PUT", "path": "/records/2", "body": {"value": "b"}}]`
+	_, resp = sendBatch(t, r, BatchPath+"?atomic=true", body)
+	if !resp.Transaction || resp.RolledBack || len(records) != 2 {
+		t.Errorf("batch is not committed %+v %v", resp, records)
+	}
+	BatchTransaction = func(ctx context.Context, fn func(ctx context.Context) error) error {
+		return errors.New("transaction is not available")
+	}
+	if code, _ := sendBatch(t, r, BatchPath+"?atomic=true", body); code != http.StatusInternalServerError {
+		t.Errorf("expect 500 for transaction error, got %d", code)
+	}
+}
@@ -0,0 +1,354 @@
+package server
+
+// bind module decodes JSON body, form or query parameters of requests into
+// structures and validates them via struct tags, e.g.
+//
+//	type SearchRequest struct {
+//	    Query string `json:"query" binding:"required,max=256"`
+//	    Limit int    `json:"limit" form:"limit" binding:"min=1,max=1000"`
+//	    Sort  string `json:"sort" binding:"oneof=asc desc"`
+//	    Did   string `json:"did" regexp:"^/beamline=[a-z0-9]+"`
+//	}
+//
+// Supported rules of binding tag are required, min, max (value of numbers
+// or length of strings, slices and maps) and oneof (space separated
+// values), regexp tag defines pattern of strings and enum tag comma
+// separated allowed values. Rules except required are not applied to
+// omitted (zero) values.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	services "github.com/CHESSComputing/golib/services"
+	"github.com/gin-gonic/gin"
+)
+
+// cache of compiled regexp tags
+var bindPatterns sync.Map
+
+// Bind decodes request into given structure pointer and validates it, JSON
+// body is decoded for JSON requests, form values for form requests and
+// query parameters otherwise. It returns list of validation errors.
+func Bind(c *gin.Context, v any) []ValidationError {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return []ValidationError{{Message: "bind target should be non nil pointer"}}
+	}
+	r := c.Request
+	ctype, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	tag := "form"
+	switch {
+	case ctype == "application/x-www-form-urlencoded" || ctype == "multipart/form-data":
+		if err := r.ParseMultipartForm(32 << 20); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+			return []ValidationError{{Message: fmt.Sprintf("unable to parse form, error %v", err)}}
+		}
+		if errs := decodeValues(r.PostForm, rv.Elem()); len(errs) > 0 {
+			return errs
+		}
+	case hasBody(r.Method) || ctype == gin.MIMEJSON:
+		tag = "json"
+		if r.Body == nil || r.ContentLength == 0 {
+			return []ValidationError{{Message: "request body is empty"}}
+		}
+		if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+			var terr *json.UnmarshalTypeError
+			if errors.As(err, &terr) {
+				return []ValidationError{{Field: terr.Field, Message: fmt.Sprintf("expected %s, got %s", terr.Type, terr.Value)}}
+			}
+			return []ValidationError{{Message: fmt.Sprintf("invalid JSON, error %v", err)}}
+		}
+	default:
+		if errs := decodeValues(r.URL.Query(), rv.Elem()); len(errs) > 0 {
+			return errs
+		}
+	}
+	return validateValue(rv, "", tag)
+}
+
+// BindRequest decodes and validates request via Bind, invalid requests are
+// answered with 400 code and list of validation errors. It returns false
+// if request is invalid and handler should return.
+func BindRequest(c *gin.Context, v any) bool {
+	errs := Bind(c, v)
+	if len(errs) == 0 {
+		return true
+	}
+	c.AbortWithStatusJSON(http.StatusBadRequest, validationResponse(errs))
+	return false
+}
+
+// ValidateStruct validates given structure via its binding, regexp and
+// enum tags, field names are taken from json tags
+func ValidateStruct(v any) []ValidationError {
+	return validateValue(reflect.ValueOf(v), "", "json")
+}
+
+// helper function to create response of invalid request
+func validationResponse(errs []ValidationError) ValidationResponse {
+	msgs := make([]string, 0, len(errs))
+	for _, e := range errs {
+		msgs = append(msgs, e.Error())
+	}
+	err := errors.New(strings.Join(msgs, "; "))
+	return ValidationResponse{
+		ServiceResponse: services.Response("server", http.StatusBadRequest, services.ValidateError, err),
+		Errors:          errs,
+	}
+}
+
+// helper function to get name of struct field used by given tag, form
+// fields fall back to json names
+func bindName(f reflect.StructField, tag string) string {
+	if tag == "form" {
+		if _, ok := f.Tag.Lookup("form"); ok {
+			return fieldName(f, "form")
+		}
+	}
+	return fieldName(f, "json")
+}
+
+// helper function to decode form or query values into structure
+func decodeValues(values url.Values, v reflect.Value) []ValidationError {
+	var errs []ValidationError
+	t := v.Type()
+	if t.Kind() != reflect.Struct {
+		return []ValidationError{{Message: fmt.Sprintf("unable to decode parameters into %s", t)}}
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			errs = append(errs, decodeValues(values, v.Field(i))...)
+			continue
+		}
+		name := bindName(f, "form")
+		vals, ok := values[name]
+		if name == "" || !ok || len(vals) == 0 {
+			continue
+		}
+		field := v.Field(i)
+		if field.Kind() == reflect.Slice && field.Type().Elem().Kind() != reflect.Uint8 {
+			slice := reflect.MakeSlice(field.Type(), len(vals), len(vals))
+			for j, val := range vals {
+				if err := setValue(slice.Index(j), val); err != nil {
+					errs = append(errs, ValidationError{Field: name, Message: err.Error()})
+				}
+			}
+			field.Set(slice)
+			continue
+		}
+		if err := setValue(field, vals[0]); err != nil {
+			errs = append(errs, ValidationError{Field: name, Message: err.Error()})
+		}
+	}
+	return errs
+}
+
+// helper function to set value of field from string
+func setValue(v reflect.Value, val string) error {
+	if v.Kind() == reflect.Pointer {
+		ptr := reflect.New(v.Type().Elem())
+		if err := setValue(ptr.Elem(), val); err != nil {
+			return err
+		}
+		v.Set(ptr)
+		return nil
+	}
+	switch v.Interface().(type) {
+	case time.Duration:
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			return fmt.Errorf("expected duration, got '%s'", val)
+		}
+		v.SetInt(int64(d))
+		return nil
+	case time.Time:
+		ts, err := time.Parse(time.RFC3339, val)
+		if err != nil {
+			return fmt.Errorf("expected RFC3339 date-time, got '%s'", val)
+		}
+		v.Set(reflect.ValueOf(ts))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(val)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			return fmt.Errorf("expected boolean, got '%s'", val)
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(val, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("expected integer, got '%s'", val)
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(val, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("expected non negative integer, got '%s'", val)
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(val, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("expected number, got '%s'", val)
+		}
+		v.SetFloat(n)
+	default:
+		return fmt.Errorf("unsupported parameter type %s", v.Type())
+	}
+	return nil
+}
+
+// helper function to validate value and its nested structures
+func validateValue(v reflect.Value, path, tag string) []ValidationError {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	var errs []ValidationError
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			errs = append(errs, validateValue(v.Index(i), fmt.Sprintf("%s[%d]", path, i), tag)...)
+		}
+	case reflect.Struct:
+		if v.Type() == reflect.TypeOf(time.Time{}) {
+			return nil
+		}
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			if f.Anonymous && f.Tag.Get("json") == "" {
+				errs = append(errs, validateValue(v.Field(i), path, tag)...)
+				continue
+			}
+			name := bindName(f, tag)
+			if name == "" {
+				continue
+			}
+			fpath := fieldPath(path, name)
+			ferrs := validateField(f, v.Field(i), fpath)
+			if len(ferrs) == 0 {
+				ferrs = validateValue(v.Field(i), fpath, tag)
+			}
+			errs = append(errs, ferrs...)
+		}
+	}
+	return errs
+}
+
+// helper function to validate struct field via its tags
+func validateField(f reflect.StructField, v reflect.Value, path string) []ValidationError {
+	var rules []string
+	if tag := f.Tag.Get("binding"); tag != "" {
+		rules = strings.Split(tag, ",")
+	}
+	if enum := f.Tag.Get("enum"); enum != "" {
+		rules = append(rules, "oneof="+strings.ReplaceAll(enum, ",", " "))
+	}
+	pattern := f.Tag.Get("regexp")
+	if v.IsZero() {
+		for _, rule := range rules {
+			if rule == "required" {
+				return []ValidationError{{Field: path, Message: "required field is missing"}}
+			}
+		}
+		return nil
+	}
+	for v.Kind() == reflect.Pointer {
+		v = v.Elem()
+	}
+	var errs []ValidationError
+	for _, rule := range rules {
+		name, arg, _ := strings.Cut(rule, "=")
+		var msg string
+		switch name {
+		case "min", "max":
+			msg = checkLimit(v, name, arg)
+		case "oneof":
+			values := strings.Fields(arg)
+			if val := fmt.Sprintf("%v", v.Interface()); !inList(val, values) {
+				msg = fmt.Sprintf("value '%s' is not one of %s", val, strings.Join(values, ", "))
+			}
+		}
+		if msg != "" {
+			errs = append(errs, ValidationError{Field: path, Message: msg})
+		}
+	}
+	if pattern != "" && v.Kind() == reflect.String {
+		re, err := bindPattern(pattern)
+		if err != nil {
+			errs = append(errs, ValidationError{Field: path, Message: fmt.Sprintf("invalid pattern %s, error %v", pattern, err)})
+		} else if !re.MatchString(v.String()) {
+			errs = append(errs, ValidationError{Field: path, Message: fmt.Sprintf("value '%s' does not match pattern %s", v.String(), pattern)})
+		}
+	}
+	return errs
+}
+
+// helper function to check min or max limit of value, numbers are
+// compared by value and strings, slices and maps by length
+func checkLimit(v reflect.Value, rule, arg string) string {
+	limit, err := strconv.ParseFloat(arg, 64)
+	if err != nil {
+		return fmt.Sprintf("invalid %s rule '%s'", rule, arg)
+	}
+	var val float64
+	what := "value"
+	switch v.Kind() {
+	case reflect.String:
+		val, what = float64(len([]rune(v.String()))), "length"
+	case reflect.Slice, reflect.Array, reflect.Map:
+		val, what = float64(v.Len()), "length"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		val = float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		val = float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		val = v.Float()
+	default:
+		return ""
+	}
+	if rule == "min" && val < limit {
+		return fmt.Sprintf("%s %v is less than minimum %v", what, val, limit)
+	}
+	if rule == "max" && val > limit {
+		return fmt.Sprintf("%s %v is greater than maximum %v", what, val, limit)
+	}
+	return ""
+}
+
+// helper function to get compiled pattern of regexp tag
+func bindPattern(pattern string) (*regexp.Regexp, error) {
+	if re, ok := bindPatterns.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	bindPatterns.Store(pattern, re)
+	return re, nil
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// bindItem represents nested structure of bind tests
+type bindItem struct {
+	Name string `json:"name" binding:"required"`
+}
+
+// bindRequest represents request structure of bind tests
+type bindRequest struct {
+	Query   string        `json:"query" binding:"required,max=8"`
+	Limit   int           `json:"limit" form:"n" binding:"min=1,max=100"`
+	Sort    string        `json:"sort" binding:"oneof=asc desc"`
+	Format  string        `json:"format" enum:"json,xml"`
+	Did     string        `json:"did" regexp:"^/beamline=[a-z0-9]+$"`
+	Tags    []string      `json:"tags" binding:"max=2"`
+	Timeout time.Duration `json:"timeout"`
+	Items   []bindItem    `json:"items"`
+}
+
+// TestBind
+func TestBind(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	bind := func(method, target, ctype, body string) (bindRequest, []ValidationError) {
+		var req bindRequest
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(method, target, strings.NewReader(body))
+		if ctype != "" {
+			c.Request.Header.Set("Content-Type", ctype)
+		}
+		errs := Bind(c, &req)
+		return req, errs
+	}
+
+	// valid JSON request
+	req, errs := bind("POST", "/search", "application/json", `{"query": "x", "limit": 10, "sort": "asc", "did": "/beamline=3a", "items": [{"name": "a"}]}`)
+	if len(errs) != 0 || req.Query != "x" || req.Limit != 10 || len(req.Items) != 1 {
+		t.Errorf("valid request is rejected %+v %v", req, errs)
+	}
+
+	// invalid JSON request with per-field errors
+	_, errs = bind("POST", "/search", "application/json", `{"query": "long query", "limit": 0, "sort": "up", "format": "csv", "did": "/x", "tags": ["a", "b", "c"], "items": [{"name": ""}]}`)
+	expect := map[string]string{
+		"query":         "length 10 is greater than maximum 8",
+		"sort":          "value 'up' is not one of asc, desc",
+		"format":        "value 'csv' is not one of json, xml",
+		"did":           "value '/x' does not match pattern ^/beamline=[a-z0-9]+$",
+		"tags":          "length 3 is greater than maximum 2",
+		"items[0].name": "required field is missing",
+	}
+	if len(errs) != len(expect) {
+		t.Errorf("expect %d errors, got %v", len(expect), errs)
+	}
+	for _, e := range errs {
+		if expect[e.Field] != e.Message {
+			t.Errorf("unexpected error %s, expect %s", e, expect[e.Field])
+		}
+	}
+	if _, errs = bind("POST", "/search", "application/json", `{"limit": "ten"}`); len(errs) != 1 || errs[0].Field != "limit" {
+		t.Errorf("wrong type is accepted %v", errs)
+	}
+	if _, errs = bind("POST", "/search", "application/json", ""); len(errs) != 1 || errs[0].Message != "request body is empty" {
+		t.Errorf("empty body is accepted %v", errs)
+	}
+
+	// query and form parameters, form tags overwrite json names
+	req, errs = bind("GET", "/search?query=x&n=5&tags=a&tags=b&timeout=1m", "", "")
+	if len(errs) != 0 || req.Limit != 5 || len(req.Tags) != 2 || req.Timeout != time.Minute {
+		t.Errorf("wrong query binding %+v %v", req, errs)
+	}
+	if _, errs = bind("GET", "/search?query=x&n=many", "", ""); len(errs) != 1 || errs[0].Field != "n" || errs[0].Message != "expected integer, got 'many'" {
+		t.Errorf("invalid query parameter is accepted %v", errs)
+	}
+	if _, errs = bind("GET", "/search?n=500", "", ""); len(errs) != 2 {
+		t.Errorf("expect missing query and limit errors, got %v", errs)
+	}
+	req, errs = bind("POST", "/search", "application/x-www-form-urlencoded", "query=x&n=7&sort=desc")
+	if len(errs) != 0 || req.Limit != 7 || req.Sort != "desc" {
+		t.Errorf("wrong form binding %+v %v", req, errs)
+	}
+}
+
+// TestBindRequest
+func TestBindRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/search", func(c *gin.Context) {
+		var req bindRequest
+		if !BindRequest(c, &req) {
+			return
+		}
+		c.JSON(http.StatusOK, req)
+	})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/search", strings.NewReader(`{"limit": 5}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("invalid request is accepted, code %d", w.Code)
+	}
+	var resp ValidationResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Errors) != 1 || resp.Errors[0].Field != "query" || resp.Status != "error" {
+		t.Errorf("wrong validation response %+v", resp)
+	}
+}
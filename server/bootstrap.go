@@ -0,0 +1,200 @@
+package server
+
+// bootstrap module waits for dependencies of the server, e.g. MongoDB,
+// Authz service or schema files, with exponential backoff before server
+// starts accepting requests. It prevents crash loops of services started
+// before their dependencies, e.g. in Kubernetes.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	redis "github.com/CHESSComputing/golib/redis"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// default bootstrap parameters used when they are not configured
+var (
+	DefaultBootstrapTimeout     = 5 * time.Minute
+	DefaultBootstrapInitialWait = 500 * time.Millisecond
+	DefaultBootstrapMaxWait     = 30 * time.Second
+)
+
+// BootstrapCheckTimeout defines timeout of single dependency check
+var BootstrapCheckTimeout = 10 * time.Second
+
+// Dependencies represents dependencies StartServer waits for in addition to
+// dependencies of Bootstrap configuration
+var Dependencies []Dependency
+
+// Dependency represents dependency of the server
+type Dependency struct {
+	Name  string                          // name of dependency used in logs
+	Check func(ctx context.Context) error // check returns nil if dependency is available
+}
+
+// MongoDependency returns dependency on MongoDB server with given URI, the
+// server should answer ping request
+func MongoDependency(uri string) Dependency {
+	return Dependency{
+		Name: "mongodb " + redactURI(uri),
+		Check: func(ctx context.Context) error {
+			client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+			if err != nil {
+				return err
+			}
+			defer client.Disconnect(context.Background())
+			return client.Ping(ctx, nil)
+		},
+	}
+}
+
+// HTTPDependency returns dependency on HTTP service, its health URL should
+// return 2xx code
+func HTTPDependency(rurl string) Dependency {
+	return Dependency{
+		Name: "url " + redactURI(rurl),
+		Check: func(ctx context.Context) error {
+			req, err := http.NewRequestWithContext(ctx, "GET", rurl, nil)
+			if err != nil {
+				return err
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode < 200 || resp.StatusCode > 299 {
+				return fmt.Errorf("unexpected status %s", resp.Status)
+			}
+			return nil
+		},
+	}
+}
+
+// RedisDependency returns dependency on redis server of given client, the
+// server should answer ping command
+func RedisDependency(client *redis.Client) Dependency {
+	return Dependency{Name: "redis " + client.Addr(), Check: client.Ping}
+}
+
+// FileDependency returns dependency on file, e.g. schema file, which should
+// exist
+func FileDependency(fname string) Dependency {
+	return Dependency{
+		Name: "file " + fname,
+		Check: func(ctx context.Context) error {
+			_, err := os.Stat(fname)
+			return err
+		},
+	}
+}
+
+// helper function to remove credentials from URI used in logs
+func redactURI(uri string) string {
+	scheme, rest, ok := strings.Cut(uri, "://")
+	if !ok {
+		return uri
+	}
+	if idx := strings.Index(rest, "@"); idx >= 0 && !strings.Contains(rest[:idx], "/") {
+		return scheme + "://***@" + rest[idx+1:]
+	}
+	return uri
+}
+
+// BootstrapDependencies returns dependencies of bootstrap configuration
+func BootstrapDependencies(cfg srvConfig.Bootstrap) []Dependency {
+	var deps []Dependency
+	for _, uri := range cfg.MongoDB {
+		deps = append(deps, MongoDependency(uri))
+	}
+	for _, rurl := range cfg.URLs {
+		deps = append(deps, HTTPDependency(rurl))
+	}
+	for _, fname := range cfg.Files {
+		deps = append(deps, FileDependency(fname))
+	}
+	for _, uri := range cfg.Redis {
+		opts, err := redis.ParseURI(uri)
+		if err != nil {
+			deps = append(deps, Dependency{Name: "redis " + redactURI(uri), Check: func(ctx context.Context) error { return err }})
+			continue
+		}
+		// single connection is enough for ping
+		opts.PoolSize = 1
+		deps = append(deps, RedisDependency(redis.NewClient(opts)))
+	}
+	return deps
+}
+
+// helper function to get timeouts of bootstrap configuration
+func bootstrapTimeouts(cfg srvConfig.Bootstrap) (time.Duration, time.Duration, time.Duration) {
+	timeout := DefaultBootstrapTimeout
+	if cfg.Timeout > 0 {
+		timeout = cfg.Timeout
+	}
+	initialWait := DefaultBootstrapInitialWait
+	if cfg.InitialWait > 0 {
+		initialWait = cfg.InitialWait
+	}
+	maxWait := DefaultBootstrapMaxWait
+	if cfg.MaxWait > 0 {
+		maxWait = cfg.MaxWait
+	}
+	return timeout, initialWait, maxWait
+}
+
+// WaitForDependencies waits until all dependencies are available. Failed
+// dependencies are checked again with exponential backoff, it returns error
+// with unavailable dependencies when deadline of bootstrap configuration is
+// exceeded or context is cancelled.
+func WaitForDependencies(ctx context.Context, cfg srvConfig.Bootstrap, deps ...Dependency) error {
+	if len(deps) == 0 {
+		return nil
+	}
+	timeout, wait, maxWait := bootstrapTimeouts(cfg)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	start := time.Now()
+	pending := deps
+	for attempt := 1; ; attempt++ {
+		var failed []Dependency
+		var errs []error
+		for _, dep := range pending {
+			cctx, ccancel := context.WithTimeout(ctx, BootstrapCheckTimeout)
+			err := dep.Check(cctx)
+			ccancel()
+			if err != nil {
+				log.Printf("WARNING: dependency %s is not available, attempt %d, error %v", dep.Name, attempt, err)
+				failed = append(failed, dep)
+				errs = append(errs, fmt.Errorf("%s: %w", dep.Name, err))
+				continue
+			}
+			log.Printf("dependency %s is available", dep.Name)
+		}
+		if len(failed) == 0 {
+			log.Printf("all %d dependencies are available after %v", len(deps), time.Since(start))
+			return nil
+		}
+		pending = failed
+		select {
+		case <-ctx.Done():
+			err := fmt.Errorf("dependencies are not available after %v: %w", time.Since(start), errors.Join(errs...))
+			log.Printf("ERROR: %v", err)
+			return err
+		case <-time.After(wait):
+		}
+		wait *= 2
+		if wait > maxWait {
+			wait = maxWait
+		}
+	}
+}
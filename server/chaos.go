@@ -0,0 +1,117 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	services "github.com/CHESSComputing/golib/services"
+	"github.com/gin-gonic/gin"
+)
+
+// ChaosHeader defines response header which reports injected fault
+const ChaosHeader = "X-Chaos-Fault"
+
+// chaos represents state of fault injection middleware
+type chaos struct {
+	mu    sync.Mutex
+	rnd   *rand.Rand
+	rules []srvConfig.ChaosRule
+}
+
+// helper function to get random number within [0, 1)
+func (ch *chaos) random() float64 {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	return ch.rnd.Float64()
+}
+
+// helper function to find first rule matching the request
+func (ch *chaos) match(c *gin.Context) (srvConfig.ChaosRule, bool) {
+	for _, rule := range ch.rules {
+		if rule.Method != "" && !strings.EqualFold(rule.Method, c.Request.Method) {
+			continue
+		}
+		if rule.Path == "" || rule.Path == c.FullPath() || rule.Path == c.Request.URL.Path {
+			return rule, true
+		}
+		if prefix, ok := strings.CutSuffix(rule.Path, "*"); ok && strings.HasPrefix(c.Request.URL.Path, prefix) {
+			return rule, true
+		}
+	}
+	return srvConfig.ChaosRule{}, false
+}
+
+// helper function to drop client connection, connections which can not be
+// hijacked (e.g. HTTP/2 or test recorders) are answered with 503 code
+func dropConnection(c *gin.Context) {
+	c.Header(ChaosHeader, "drop")
+	if hj, ok := c.Writer.(http.Hijacker); ok {
+		if conn, _, err := hj.Hijack(); err == nil {
+			conn.Close()
+			c.Abort()
+			return
+		}
+	}
+	c.AbortWithStatus(http.StatusServiceUnavailable)
+}
+
+// ChaosMiddleware injects latency, errors and dropped connections into
+// requests matching configured rules to test resiliency of clients, e.g.
+// retries and circuit breakers. Faults are injected only when gin runs in
+// test mode, otherwise requests pass through untouched.
+func ChaosMiddleware(cfg srvConfig.Chaos) gin.HandlerFunc {
+	if !cfg.Enabled || len(cfg.Rules) == 0 {
+		return func(c *gin.Context) { c.Next() }
+	}
+	if gin.Mode() != gin.TestMode {
+		log.Printf("WARNING: chaos middleware is disabled in %s mode", gin.Mode())
+		return func(c *gin.Context) { c.Next() }
+	}
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	log.Printf("WARNING: chaos middleware injects faults with seed %d, rules %+v", seed, cfg.Rules)
+	ch := &chaos{rnd: rand.New(rand.NewSource(seed)), rules: cfg.Rules}
+	return func(c *gin.Context) {
+		rule, ok := ch.match(c)
+		if !ok {
+			c.Next()
+			return
+		}
+		delay := time.Duration(rule.Latency) * time.Millisecond
+		if rule.Jitter > 0 {
+			delay += time.Duration(ch.random() * float64(time.Duration(rule.Jitter)*time.Millisecond))
+		}
+		if delay > 0 {
+			c.Header(ChaosHeader, "latency")
+			select {
+			case <-time.After(delay):
+			case <-c.Request.Context().Done():
+				c.Abort()
+				return
+			}
+		}
+		if rule.DropRate > 0 && ch.random() < rule.DropRate {
+			dropConnection(c)
+			return
+		}
+		if rule.ErrorRate > 0 && ch.random() < rule.ErrorRate {
+			code := rule.ErrorCode
+			if code == 0 {
+				code = http.StatusServiceUnavailable
+			}
+			c.Header(ChaosHeader, "error")
+			err := fmt.Errorf("injected fault of %s %s", c.Request.Method, c.Request.URL.Path)
+			c.AbortWithStatusJSON(code, services.Response("chaos", code, services.ServiceError, err))
+			return
+		}
+		c.Next()
+	}
+}
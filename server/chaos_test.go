@@ -0,0 +1,87 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	"github.com/gin-gonic/gin"
+)
+
+// helper function to create router with chaos middleware
+func chaosRouter(cfg srvConfig.Chaos) *gin.Engine {
+	r := gin.New()
+	r.Use(ChaosMiddleware(cfg))
+	handler := func(c *gin.Context) { c.String(http.StatusOK, "ok") }
+	r.GET("/record/:did", handler)
+	r.GET("/search", handler)
+	r.POST("/search", handler)
+	r.GET("/files/list", handler)
+	return r
+}
+
+// TestChaosMiddleware
+func TestChaosMiddleware(t *testing.T) {
+	mode := gin.Mode()
+	defer gin.SetMode(mode)
+	gin.SetMode(gin.TestMode)
+	cfg := srvConfig.Chaos{
+		Enabled: true,
+		Seed:    1,
+		Rules: []srvConfig.ChaosRule{
+			{Path: "/record/:did", Latency: 20},
+			{Path: "/search", Method: "POST", ErrorRate: 1, ErrorCode: http.StatusBadGateway},
+			{Path: "/files/*", ErrorRate: 0.5},
+		},
+	}
+	r := chaosRouter(cfg)
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+
+	start := time.Now()
+	w := serve("GET", "/record/abc")
+	if w.Code != http.StatusOK || time.Since(start) < 20*time.Millisecond || w.Header().Get(ChaosHeader) != "latency" {
+		t.Errorf("latency is not injected, code %d elapsed %v", w.Code, time.Since(start))
+	}
+	if w := serve("POST", "/search"); w.Code != http.StatusBadGateway || w.Header().Get(ChaosHeader) != "error" {
+		t.Errorf("error is not injected, code %d", w.Code)
+	}
+	if w := serve("GET", "/search"); w.Code != http.StatusOK || w.Header().Get(ChaosHeader) != "" {
+		t.Errorf("fault is injected into unmatched request, code %d", w.Code)
+	}
+	var failed int
+	for i := 0; i < 100; i++ {
+		if serve("GET", "/files/list").Code != http.StatusOK {
+			failed++
+		}
+	}
+	if failed < 20 || failed > 80 {
+		t.Errorf("unexpected number of failed requests %d", failed)
+	}
+
+	// faults are not injected outside of test mode
+	gin.SetMode(gin.ReleaseMode)
+	r = chaosRouter(cfg)
+	if w := serve("POST", "/search"); w.Code != http.StatusOK {
+		t.Errorf("fault is injected in release mode, code %d", w.Code)
+	}
+}
+
+// TestChaosDrop
+func TestChaosDrop(t *testing.T) {
+	mode := gin.Mode()
+	defer gin.SetMode(mode)
+	gin.SetMode(gin.TestMode)
+	cfg := srvConfig.Chaos{Enabled: true, Rules: []srvConfig.ChaosRule{{DropRate: 1}}}
+	srv := httptest.NewServer(chaosRouter(cfg))
+	defer srv.Close()
+	if resp, err := http.Get(srv.URL + "/search"); err == nil {
+		resp.Body.Close()
+		t.Errorf("connection is not dropped, code %d", resp.StatusCode)
+	}
+}
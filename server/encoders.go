@@ -0,0 +1,334 @@
+package server
+
+// encoders module provides streaming encoders for large result sets
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// content types supported by streaming encoders
+const (
+	JSONContentType   = "application/json"
+	NDJSONContentType = "application/x-ndjson"
+	CSVContentType    = "text/csv"
+)
+
+// FlushInterval defines number of records after which encoders flush
+// data to the client
+var FlushInterval = 100
+
+// RecordEncoder defines interface for streaming encoders
+type RecordEncoder interface {
+	Encode(rec map[string]any) error // encode single record
+	Close() error                    // finalize the stream
+	ContentType() string             // content type of the stream
+}
+
+// helper function to check if client is gone, it allows producers to stop
+// generating records as soon as connection is closed
+func clientGone(r *http.Request) error {
+	if r == nil {
+		return nil
+	}
+	select {
+	case <-r.Context().Done():
+		return r.Context().Err()
+	default:
+		return nil
+	}
+}
+
+// helper function to flush writer data if it supports http.Flusher
+func flush(w http.ResponseWriter) {
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// NDJSONEncoder streams records as new-line delimited JSON
+type NDJSONEncoder struct {
+	Writer  http.ResponseWriter
+	Request *http.Request
+	encoder *json.Encoder
+	nrec    int
+}
+
+// NewNDJSONEncoder returns new instance of NDJSONEncoder
+func NewNDJSONEncoder(w http.ResponseWriter, r *http.Request) *NDJSONEncoder {
+	w.Header().Set("Content-Type", NDJSONContentType)
+	return &NDJSONEncoder{Writer: w, Request: r, encoder: json.NewEncoder(w)}
+}
+
+// ContentType implements RecordEncoder interface
+func (e *NDJSONEncoder) ContentType() string {
+	return NDJSONContentType
+}
+
+// Encode implements RecordEncoder interface
+func (e *NDJSONEncoder) Encode(rec map[string]any) error {
+	if err := clientGone(e.Request); err != nil {
+		return err
+	}
+	// json.Encoder adds new line after each record
+	if err := e.encoder.Encode(rec); err != nil {
+		return err
+	}
+	e.nrec++
+	if FlushInterval > 0 && e.nrec%FlushInterval == 0 {
+		flush(e.Writer)
+	}
+	return nil
+}
+
+// Close implements RecordEncoder interface
+func (e *NDJSONEncoder) Close() error {
+	flush(e.Writer)
+	return nil
+}
+
+// JSONEncoder streams records as JSON list without buffering them
+type JSONEncoder struct {
+	Writer  http.ResponseWriter
+	Request *http.Request
+	nrec    int
+}
+
+// NewJSONEncoder returns new instance of JSONEncoder
+func NewJSONEncoder(w http.ResponseWriter, r *http.Request) *JSONEncoder {
+	w.Header().Set("Content-Type", JSONContentType)
+	return &JSONEncoder{Writer: w, Request: r}
+}
+
+// ContentType implements RecordEncoder interface
+func (e *JSONEncoder) ContentType() string {
+	return JSONContentType
+}
+
+// Encode implements RecordEncoder interface
+func (e *JSONEncoder) Encode(rec map[string]any) error {
+	if err := clientGone(e.Request); err != nil {
+		return err
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	sep := ",\n"
+	if e.nrec == 0 {
+		sep = "[\n"
+	}
+	if _, err := e.Writer.Write([]byte(sep)); err != nil {
+		return err
+	}
+	if _, err := e.Writer.Write(data); err != nil {
+		return err
+	}
+	e.nrec++
+	if FlushInterval > 0 && e.nrec%FlushInterval == 0 {
+		flush(e.Writer)
+	}
+	return nil
+}
+
+// Close implements RecordEncoder interface
+func (e *JSONEncoder) Close() error {
+	end := "\n]\n"
+	if e.nrec == 0 {
+		end = "[]\n"
+	}
+	_, err := e.Writer.Write([]byte(end))
+	flush(e.Writer)
+	return err
+}
+
+// CSVEncoder streams records as CSV, the header is either provided via
+// Columns or taken from keys of the first record
+type CSVEncoder struct {
+	Writer  http.ResponseWriter
+	Request *http.Request
+	Columns []string
+	writer  *csv.Writer
+	nrec    int
+}
+
+// NewCSVEncoder returns new instance of CSVEncoder
+func NewCSVEncoder(w http.ResponseWriter, r *http.Request, columns []string) *CSVEncoder {
+	w.Header().Set("Content-Type", CSVContentType)
+	return &CSVEncoder{Writer: w, Request: r, Columns: columns, writer: csv.NewWriter(w)}
+}
+
+// ContentType implements RecordEncoder interface
+func (e *CSVEncoder) ContentType() string {
+	return CSVContentType
+}
+
+// Encode implements RecordEncoder interface
+func (e *CSVEncoder) Encode(rec map[string]any) error {
+	if err := clientGone(e.Request); err != nil {
+		return err
+	}
+	if e.nrec == 0 {
+		if len(e.Columns) == 0 {
+			for k := range rec {
+				e.Columns = append(e.Columns, k)
+			}
+			sort.Strings(e.Columns)
+		}
+		if err := e.writer.Write(e.Columns); err != nil {
+			return err
+		}
+	}
+	var row []string
+	for _, col := range e.Columns {
+		row = append(row, csvValue(rec[col]))
+	}
+	if err := e.writer.Write(row); err != nil {
+		return err
+	}
+	e.nrec++
+	if FlushInterval > 0 && e.nrec%FlushInterval == 0 {
+		e.writer.Flush()
+		if err := e.writer.Error(); err != nil {
+			return err
+		}
+		flush(e.Writer)
+	}
+	return nil
+}
+
+// Close implements RecordEncoder interface
+func (e *CSVEncoder) Close() error {
+	if e.nrec == 0 && len(e.Columns) > 0 {
+		e.writer.Write(e.Columns)
+	}
+	e.writer.Flush()
+	flush(e.Writer)
+	return e.writer.Error()
+}
+
+// helper function to convert record value to CSV cell
+func csvValue(val any) string {
+	switch v := val.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case int, int32, int64, bool:
+		return fmt.Sprintf("%v", v)
+	default:
+		// nested structures are represented as JSON
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprintf("%v", v)
+		}
+		return string(data)
+	}
+}
+
+// acceptEntry represents single media range of Accept HTTP header
+type acceptEntry struct {
+	mediaType string
+	quality   float64
+}
+
+// helper function to parse Accept HTTP header and return media types
+// ordered by their quality values
+func parseAccept(accept string) []acceptEntry {
+	var entries []acceptEntry
+	for _, part := range strings.Split(accept, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		arr := strings.Split(part, ";")
+		entry := acceptEntry{mediaType: strings.ToLower(strings.TrimSpace(arr[0])), quality: 1.0}
+		for _, param := range arr[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64); err == nil {
+					entry.quality = q
+				}
+			}
+		}
+		if entry.quality > 0 {
+			entries = append(entries, entry)
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].quality > entries[j].quality
+	})
+	return entries
+}
+
+// NegotiateContentType returns best content type from offers which matches
+// given Accept HTTP header value. If Accept header is empty the first offer
+// is returned, and empty string is returned if nothing matches.
+func NegotiateContentType(accept string, offers []string) string {
+	if len(offers) == 0 {
+		return ""
+	}
+	if strings.TrimSpace(accept) == "" {
+		return offers[0]
+	}
+	for _, entry := range parseAccept(accept) {
+		for _, offer := range offers {
+			if entry.mediaType == "*/*" || entry.mediaType == offer {
+				return offer
+			}
+			if strings.HasSuffix(entry.mediaType, "/*") &&
+				strings.HasPrefix(offer, strings.TrimSuffix(entry.mediaType, "*")) {
+				return offer
+			}
+		}
+	}
+	return ""
+}
+
+// ErrNotAcceptable is returned when client Accept header does not match
+// any of supported encoders
+var ErrNotAcceptable = errors.New("not acceptable content type")
+
+// NewRecordEncoder returns streaming encoder based on Accept HTTP header of
+// given request. CSV columns are optional and used only by CSV encoder.
+func NewRecordEncoder(w http.ResponseWriter, r *http.Request, columns []string) (RecordEncoder, error) {
+	offers := []string{JSONContentType, NDJSONContentType, CSVContentType}
+	switch NegotiateContentType(r.Header.Get("Accept"), offers) {
+	case NDJSONContentType:
+		return NewNDJSONEncoder(w, r), nil
+	case CSVContentType:
+		return NewCSVEncoder(w, r, columns), nil
+	case JSONContentType:
+		return NewJSONEncoder(w, r), nil
+	}
+	return nil, ErrNotAcceptable
+}
+
+// StreamRecords streams records from given channel to the client using
+// encoder negotiated via Accept HTTP header. It returns number of streamed
+// records. If client closes the connection the function stops reading from
+// the channel and returns an error, therefore producers should watch request
+// context to stop generating records.
+func StreamRecords(w http.ResponseWriter, r *http.Request, records <-chan map[string]any, columns []string) (int, error) {
+	enc, err := NewRecordEncoder(w, r, columns)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotAcceptable)
+		return 0, err
+	}
+	var nrec int
+	for rec := range records {
+		if err := enc.Encode(rec); err != nil {
+			return nrec, err
+		}
+		nrec++
+	}
+	return nrec, enc.Close()
+}
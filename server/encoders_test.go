@@ -0,0 +1,76 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestNegotiateContentType
+func TestNegotiateContentType(t *testing.T) {
+	offers := []string{JSONContentType, NDJSONContentType, CSVContentType}
+	tests := map[string]string{
+		"":                                     JSONContentType,
+		"*/*":                                  JSONContentType,
+		"text/csv":                             CSVContentType,
+		"text/*":                               CSVContentType,
+		"application/x-ndjson":                 NDJSONContentType,
+		"text/csv;q=0.5, application/x-ndjson": NDJSONContentType,
+		"application/xml":                      "",
+	}
+	for accept, expect := range tests {
+		if ctype := NegotiateContentType(accept, offers); ctype != expect {
+			t.Errorf("accept '%s' expect '%s' got '%s'", accept, expect, ctype)
+		}
+	}
+}
+
+// TestStreamRecords
+func TestStreamRecords(t *testing.T) {
+	records := []map[string]any{
+		{"dataset": "/a/b/c", "size": 1},
+		{"dataset": "/a/b/d", "size": 2},
+	}
+	produce := func() chan map[string]any {
+		ch := make(chan map[string]any)
+		go func() {
+			for _, rec := range records {
+				ch <- rec
+			}
+			close(ch)
+		}()
+		return ch
+	}
+
+	// NDJSON output
+	r := httptest.NewRequest("GET", "/datasets", nil)
+	r.Header.Set("Accept", NDJSONContentType)
+	w := httptest.NewRecorder()
+	nrec, err := StreamRecords(w, r, produce(), nil)
+	if err != nil || nrec != 2 {
+		t.Fatalf("unable to stream records, nrec=%d error=%v", nrec, err)
+	}
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if len(lines) != 2 {
+		t.Errorf("wrong number of NDJSON lines %d", len(lines))
+	}
+
+	// JSON output
+	r.Header.Set("Accept", JSONContentType)
+	w = httptest.NewRecorder()
+	StreamRecords(w, r, produce(), nil)
+	var out []map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil || len(out) != 2 {
+		t.Errorf("invalid JSON stream %s, error %v", w.Body.String(), err)
+	}
+
+	// CSV output
+	r.Header.Set("Accept", CSVContentType)
+	w = httptest.NewRecorder()
+	StreamRecords(w, r, produce(), nil)
+	expect := "dataset,size\n/a/b/c,1\n/a/b/d,2\n"
+	if w.Body.String() != expect {
+		t.Errorf("wrong CSV output %q", w.Body.String())
+	}
+}
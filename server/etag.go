@@ -0,0 +1,152 @@
+package server
+
+// etag module provides optimistic concurrency control of record update
+// APIs. Records of versioned store carry their version (revision) which is
+// reported as ETag, updates with If-Match header of stale version are
+// rejected with 412 status code instead of overwriting concurrent edits.
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	services "github.com/CHESSComputing/golib/services"
+	storage "github.com/CHESSComputing/golib/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// RequireIfMatch defines if record updates without If-Match header are
+// rejected with 428 status code
+var RequireIfMatch bool
+
+// ErrInvalidIfMatch is returned when If-Match header does not contain
+// record version
+var ErrInvalidIfMatch = errors.New("If-Match header should contain single record ETag")
+
+// VersionETag returns ETag of given record version
+func VersionETag(version int) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+// RecordVersion returns version of record of versioned store
+func RecordVersion(rec map[string]any) int {
+	switch v := rec[storage.VersionKey].(type) {
+	case int:
+		return v
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case float64:
+		return int(v)
+	}
+	return 0
+}
+
+// SetVersionETag sets ETag header of given record version
+func SetVersionETag(c *gin.Context, version int) {
+	if version > 0 {
+		c.Header("ETag", VersionETag(version))
+	}
+}
+
+// IfMatchVersion returns record version of If-Match header, zero means that
+// header is not provided or matches any version (*)
+func IfMatchVersion(c *gin.Context) (int, error) {
+	match := strings.TrimSpace(c.GetHeader("If-Match"))
+	if match == "" || match == "*" {
+		return 0, nil
+	}
+	// weak comparison is sufficient, version is unique within the record
+	match = strings.TrimPrefix(match, "W/")
+	if len(match) < 2 || match[0] != '"' || match[len(match)-1] != '"' {
+		return 0, ErrInvalidIfMatch
+	}
+	version, err := strconv.Atoi(match[1 : len(match)-1])
+	if err != nil || version < 1 {
+		return 0, ErrInvalidIfMatch
+	}
+	return version, nil
+}
+
+// UpdateVersionedRecord updates fields of the record honoring If-Match
+// header of the request. Stale writes are rejected with 412 and missing
+// records with 404 status code, ETag of new version is set on success. It
+// returns false if request is answered with error and handler should
+// return.
+func UpdateVersionedRecord(c *gin.Context, vs *storage.VersionedStore, collection string, id any, fields map[string]any, comment string) (storage.Version, bool) {
+	abort := func(code, srvCode int, err error) (storage.Version, bool) {
+		c.AbortWithStatusJSON(code, services.Response("server", code, srvCode, err))
+		return storage.Version{}, false
+	}
+	if _, ok := c.Request.Header["If-Match"]; !ok && RequireIfMatch {
+		return abort(http.StatusPreconditionRequired, services.ConflictError,
+			errors.New("record update requires If-Match header with record ETag"))
+	}
+	expected, err := IfMatchVersion(c)
+	if err != nil {
+		return abort(http.StatusBadRequest, services.ParametersError, err)
+	}
+	ver, err := vs.Update(c.Request.Context(), collection, c.GetString("user"), comment, id, fields, expected)
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		return abort(http.StatusNotFound, services.QueryError, fmt.Errorf("record %v is not found", id))
+	case errors.Is(err, storage.ErrVersionConflict) && expected > 0:
+		return abort(http.StatusPreconditionFailed, services.ConflictError, err)
+	case errors.Is(err, storage.ErrVersionConflict):
+		// concurrent update without If-Match header
+		return abort(http.StatusConflict, services.ConflictError, err)
+	case err != nil:
+		log.Printf("ERROR: unable to update record %v of %s, error %v", id, collection, err)
+		return abort(http.StatusInternalServerError, services.UpdateError, err)
+	}
+	SetVersionETag(c, ver.Version)
+	return ver, true
+}
+
+// VersionedRecordRoutes returns routes of record APIs of versioned store
+// collection under given base path: GET <path>/:id provides record with
+// its ETag (and 304 for matching If-None-Match header) and PATCH <path>/:id
+// updates JSON fields of the record honoring If-Match header
+func VersionedRecordRoutes(basePath string, vs *storage.VersionedStore, collection string) []Route {
+	get := func(c *gin.Context) {
+		rec, err := storage.FindOne(c.Request.Context(), vs.Store, collection, map[string]any{"_id": c.Param("id")})
+		if errors.Is(err, storage.ErrNotFound) {
+			err = fmt.Errorf("record %s is not found", c.Param("id"))
+			c.JSON(http.StatusNotFound, services.Response("server", http.StatusNotFound, services.QueryError, err))
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, services.Response("server", http.StatusInternalServerError, services.QueryError, err))
+			return
+		}
+		version := RecordVersion(rec)
+		SetVersionETag(c, version)
+		if match := c.GetHeader("If-None-Match"); version > 0 && strings.Contains(match, VersionETag(version)) {
+			c.Status(http.StatusNotModified)
+			return
+		}
+		c.JSON(http.StatusOK, rec)
+	}
+	patch := func(c *gin.Context) {
+		var fields map[string]any
+		if err := c.ShouldBindJSON(&fields); err != nil {
+			c.JSON(http.StatusBadRequest, services.Response("server", http.StatusBadRequest, services.BindError, err))
+			return
+		}
+		ver, ok := UpdateVersionedRecord(c, vs, collection, c.Param("id"), fields, c.Query("comment"))
+		if !ok {
+			return
+		}
+		c.JSON(http.StatusOK, ver.Record)
+	}
+	return []Route{
+		{Method: "GET", Path: basePath + "/:id", Authorized: true, Scope: "read", Handler: get,
+			Summary: "record with its ETag"},
+		{Method: "PATCH", Path: basePath + "/:id", Authorized: true, Scope: "write", Handler: patch,
+			Summary: "update record fields, If-Match header rejects stale writes"},
+	}
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	storage "github.com/CHESSComputing/golib/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// TestIfMatchVersion
+func TestIfMatchVersion(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := map[string]int{"": 0, "*": 0, `"3"`: 3, `W/"12"`: 12}
+	for header, expect := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("PATCH", "/meta/1", nil)
+		c.Request.Header.Set("If-Match", header)
+		if version, err := IfMatchVersion(c); err != nil || version != expect {
+			t.Errorf("If-Match %s: expect version %d, got %d %v", header, expect, version, err)
+		}
+	}
+	for _, header := range []string{"3", `"abc"`, `"1", "2"`, `"0"`} {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("PATCH", "/meta/1", nil)
+		c.Request.Header.Set("If-Match", header)
+		if _, err := IfMatchVersion(c); err != ErrInvalidIfMatch {
+			t.Errorf("If-Match %s should be invalid, got %v", header, err)
+		}
+	}
+}
+
+// TestVersionedRecordRoutes
+func TestVersionedRecordRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	vs := storage.NewVersionedStore(storage.NewMemoryStore())
+	if err := vs.Insert(context.Background(), "meta", "alice", map[string]any{"_id": "did1", "beamline": "3a"}); err != nil {
+		t.Fatal(err)
+	}
+	r := gin.New()
+	for _, route := range VersionedRecordRoutes("/meta", vs, "meta") {
+		r.Handle(route.Method, route.Path, route.Handler)
+	}
+	send := func(method, body string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/meta/did1", strings.NewReader(body))
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send("GET", "", nil)
+	if w.Code != http.StatusOK || w.Header().Get("ETag") != `"1"` {
+		t.Fatalf("unexpected record response %d %v", w.Code, w.Header())
+	}
+	if w := send("GET", "", map[string]string{"If-None-Match": `"1"`}); w.Code != http.StatusNotModified {
+		t.Errorf("expect 304 for matching If-None-Match, got %d", w.Code)
+	}
+
+	// web UI and ingestion script edit the same version of the record
+	w = send("PATCH", `{"beamline": "3b"}`, map[string]string{"If-Match": `"1"`})
+	if w.Code != http.StatusOK || w.Header().Get("ETag") != `"2"` {
+		t.Fatalf("update is rejected %d %s", w.Code, w.Body.String())
+	}
+	w = send("PATCH", `{"beamline": "1a"}`, map[string]string{"If-Match": `"1"`})
+	if w.Code != http.StatusPreconditionFailed {
+		t.Errorf("stale write should be rejected with 412, got %d", w.Code)
+	}
+	rec, _ := storage.FindOne(context.Background(), vs.Store, "meta", map[string]any{"_id": "did1"})
+	if rec["beamline"] != "3b" || RecordVersion(rec) != 2 {
+		t.Errorf("stale write overwrites record %v", rec)
+	}
+	if w := send("PATCH", `{"beamline": "1a"}`, map[string]string{"If-Match": "2"}); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid If-Match should be rejected with 400, got %d", w.Code)
+	}
+
+	// updates without If-Match header
+	if w := send("PATCH", `{"energy": 10}`, nil); w.Code != http.StatusOK || w.Header().Get("ETag") != `"3"` {
+		t.Errorf("update without If-Match is rejected %d", w.Code)
+	}
+	RequireIfMatch = true
+	defer func() { RequireIfMatch = false }()
+	if w := send("PATCH", `{"energy": 20}`, nil); w.Code != http.StatusPreconditionRequired {
+		t.Errorf("expect 428 without If-Match header, got %d", w.Code)
+	}
+	req := httptest.NewRequest("PATCH", "/meta/did2", strings.NewReader(`{}`))
+	req.Header.Set("If-Match", "*")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expect 404 for missing record, got %d", w.Code)
+	}
+}
@@ -0,0 +1,476 @@
+package server
+
+// hub module provides notification hub which delivers message bus events to
+// web clients via Server-Sent Events and WebSocket endpoints. Clients may
+// subscribe only to topics allowed by their JWT claims.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	srvConfig "github.com/CHESSComputing/golib/config"
+	"github.com/CHESSComputing/golib/pubsub"
+	services "github.com/CHESSComputing/golib/services"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/websocket"
+)
+
+// TopicRule defines which users may subscribe to topics matching the
+// pattern. Pattern segments are separated by dots, '*' matches any segment
+// and '{user}' matches only user name from token claims, e.g.
+// "transfers.{user}" or "ingestion.*". Empty roles allow any authenticated
+// user.
+type TopicRule struct {
+	Pattern string
+	Roles   []string
+}
+
+// helper function to check if topic matches pattern for given user
+func (r TopicRule) match(topic, user string) bool {
+	parts := strings.Split(r.Pattern, ".")
+	segments := strings.Split(topic, ".")
+	if len(parts) != len(segments) {
+		return false
+	}
+	for i, p := range parts {
+		switch p {
+		case "*":
+			continue
+		case "{user}":
+			if user == "" || segments[i] != user {
+				return false
+			}
+		default:
+			if p != segments[i] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// TopicAuthorizer decides if claims allow subscription to given topic
+type TopicAuthorizer func(claims *authz.Claims, topic string) bool
+
+// EventFilter decides if event should be delivered to client with given
+// claims, e.g. according to notification preferences of the user
+type EventFilter func(ctx context.Context, claims *authz.Claims, env pubsub.Envelope) bool
+
+// Hub represents notification hub
+type Hub struct {
+	Bus        pubsub.Bus
+	ClientID   string          // JWT secret used to validate tokens
+	Rules      []TopicRule     // topic authorization rules
+	Authorize  TopicAuthorizer // custom authorization, overrides rules
+	Filter     EventFilter     // filter of delivered events, optional
+	Origins    []string        // allowed origins of WebSocket clients, empty allows all
+	KeepAlive  time.Duration   // interval of keep-alive messages
+	BufferSize int             // size of client buffer, slow clients lose events
+	Verbose    int
+
+	mu     sync.Mutex
+	topics map[string]*hubTopic
+}
+
+// hubTopic represents bus subscription shared by hub clients
+type hubTopic struct {
+	sub     pubsub.Subscription
+	clients map[*hubClient]struct{}
+}
+
+// hubClient represents connected web client
+type hubClient struct {
+	events chan pubsub.Envelope
+	topics map[string]bool
+}
+
+// NewHub returns new notification hub for given message bus
+func NewHub(bus pubsub.Bus) *Hub {
+	hub := &Hub{
+		Bus:        bus,
+		KeepAlive:  30 * time.Second,
+		BufferSize: 64,
+		topics:     make(map[string]*hubTopic),
+	}
+	if srvConfig.Config != nil {
+		hub.ClientID = srvConfig.Config.Authz.ClientID
+	}
+	return hub
+}
+
+// Allowed checks if given claims allow subscription to the topic
+func (h *Hub) Allowed(claims *authz.Claims, topic string) bool {
+	if claims == nil || topic == "" {
+		return false
+	}
+	if h.Authorize != nil {
+		return h.Authorize(claims, topic)
+	}
+	for _, rule := range h.Rules {
+		if !rule.match(topic, claims.CustomClaims.User) {
+			continue
+		}
+		if len(rule.Roles) == 0 {
+			return true
+		}
+		for _, role := range rule.Roles {
+			for _, r := range claims.CustomClaims.Roles {
+				if r == role {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// helper function to obtain claims of the request, browsers can not set
+// headers of EventSource and WebSocket requests therefore token can be
+// passed via access_token query parameter
+func (h *Hub) claims(r *http.Request) (*authz.Claims, error) {
+	token := authz.RequestToken(r)
+	if token == "" {
+		token = r.URL.Query().Get("access_token")
+	}
+	if token == "" {
+		return nil, errors.New("no access token")
+	}
+	t := &authz.Token{AccessToken: token}
+	if err := t.Validate(h.ClientID); err != nil {
+		return nil, err
+	}
+	return authz.TokenClaims(token, h.ClientID)
+}
+
+// helper function to get topics from request query
+func requestTopics(r *http.Request) []string {
+	var topics []string
+	for _, val := range r.URL.Query()["topic"] {
+		for _, t := range strings.Split(val, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				topics = append(topics, t)
+			}
+		}
+	}
+	return topics
+}
+
+// helper function to fan out envelope to all clients of the topic
+func (h *Hub) broadcast(topic string, env pubsub.Envelope) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	t, ok := h.topics[topic]
+	if !ok {
+		return
+	}
+	for c := range t.clients {
+		select {
+		case c.events <- env:
+		default:
+			if h.Verbose > 0 {
+				log.Printf("WARNING: hub client buffer is full, drop event %s on %s", env.ID, topic)
+			}
+		}
+	}
+}
+
+// helper function to subscribe client to the topic
+func (h *Hub) subscribe(c *hubClient, topic string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if c.topics[topic] {
+		return nil
+	}
+	if h.topics == nil {
+		h.topics = make(map[string]*hubTopic)
+	}
+	t, ok := h.topics[topic]
+	if !ok {
+		// every hub instance should receive all events, therefore
+		// subscription uses empty group, i.e. no queue group or shared
+		// consumer, and bus delivers every event to each instance
+		sub, err := h.Bus.Subscribe(topic, "", func(ctx context.Context, env pubsub.Envelope) error {
+			h.broadcast(topic, env)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		t = &hubTopic{sub: sub, clients: make(map[*hubClient]struct{})}
+		h.topics[topic] = t
+	}
+	t.clients[c] = struct{}{}
+	c.topics[topic] = true
+	return nil
+}
+
+// helper function to unsubscribe client from the topic
+func (h *Hub) unsubscribe(c *hubClient, topic string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(c.topics, topic)
+	t, ok := h.topics[topic]
+	if !ok {
+		return
+	}
+	delete(t.clients, c)
+	if len(t.clients) == 0 {
+		if err := t.sub.Unsubscribe(); err != nil {
+			log.Printf("ERROR: unable to unsubscribe from %s, error %v", topic, err)
+		}
+		delete(h.topics, topic)
+	}
+}
+
+// helper function to create client subscribed to given topics
+func (h *Hub) connect(topics []string) (*hubClient, error) {
+	size := h.BufferSize
+	if size <= 0 {
+		size = 64
+	}
+	c := &hubClient{events: make(chan pubsub.Envelope, size), topics: make(map[string]bool)}
+	for _, topic := range topics {
+		if err := h.subscribe(c, topic); err != nil {
+			h.disconnect(c)
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// helper function to remove client from all topics
+func (h *Hub) disconnect(c *hubClient) {
+	h.mu.Lock()
+	var topics []string
+	for topic := range c.topics {
+		topics = append(topics, topic)
+	}
+	h.mu.Unlock()
+	for _, topic := range topics {
+		h.unsubscribe(c, topic)
+	}
+}
+
+// helper function to authorize request and its topics, it writes error
+// response and returns nil claims if request is not authorized
+func (h *Hub) authorize(c *gin.Context, topics []string) *authz.Claims {
+	claims, err := h.claims(c.Request)
+	if err != nil {
+		rec := services.Response("hub", http.StatusUnauthorized, services.TokenError, err)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, rec)
+		return nil
+	}
+	if len(topics) == 0 {
+		rec := services.Response("hub", http.StatusBadRequest, services.ParametersError, errors.New("no topics provided"))
+		c.AbortWithStatusJSON(http.StatusBadRequest, rec)
+		return nil
+	}
+	for _, topic := range topics {
+		if !h.Allowed(claims, topic) {
+			err := fmt.Errorf("user '%s' is not allowed to subscribe to topic '%s'", claims.CustomClaims.User, topic)
+			rec := services.Response("hub", http.StatusForbidden, services.ScopeError, err)
+			c.AbortWithStatusJSON(http.StatusForbidden, rec)
+			return nil
+		}
+	}
+	return claims
+}
+
+// helper function to check if event should be delivered to the client
+func (h *Hub) deliverable(ctx context.Context, claims *authz.Claims, env pubsub.Envelope) bool {
+	return h.Filter == nil || h.Filter(ctx, claims, env)
+}
+
+// SSEHandler provides Server-Sent Events endpoint, topics are passed via
+// topic query parameter, e.g. /events?topic=transfers.alice
+func (h *Hub) SSEHandler(c *gin.Context) {
+	topics := requestTopics(c.Request)
+	claims := h.authorize(c, topics)
+	if claims == nil {
+		return
+	}
+	client, err := h.connect(topics)
+	if err != nil {
+		rec := services.Response("hub", http.StatusInternalServerError, services.GenericError, err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, rec)
+		return
+	}
+	defer h.disconnect(client)
+
+	w := c.Writer
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	w.Flush()
+
+	keepAlive := h.KeepAlive
+	if keepAlive <= 0 {
+		keepAlive = 30 * time.Second
+	}
+	ticker := time.NewTicker(keepAlive)
+	defer ticker.Stop()
+	ctx := c.Request.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			w.Flush()
+		case env := <-client.events:
+			if !h.deliverable(ctx, claims, env) {
+				continue
+			}
+			data, err := json.Marshal(env)
+			if err != nil {
+				log.Printf("ERROR: unable to encode event %s, error %v", env.ID, err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", env.ID, env.Type, data); err != nil {
+				return
+			}
+			w.Flush()
+		}
+	}
+}
+
+// WebSocketRequest represents message sent by WebSocket client to change
+// its subscriptions
+type WebSocketRequest struct {
+	Action string `json:"action"` // subscribe or unsubscribe
+	Topic  string `json:"topic"`
+}
+
+// WebSocketResponse represents message sent to WebSocket client, it
+// contains either event or error
+type WebSocketResponse struct {
+	Event *pubsub.Envelope `json:"event,omitempty"`
+	Error string           `json:"error,omitempty"`
+}
+
+// helper function to check WebSocket origin
+func (h *Hub) checkOrigin(config *websocket.Config, r *http.Request) error {
+	if len(h.Origins) == 0 {
+		return nil
+	}
+	origin := r.Header.Get("Origin")
+	for _, o := range h.Origins {
+		if o == origin {
+			return nil
+		}
+	}
+	return fmt.Errorf("origin '%s' is not allowed", origin)
+}
+
+// WebSocketHandler provides WebSocket endpoint, initial topics are passed
+// via topic query parameter and clients may change subscriptions by sending
+// WebSocketRequest messages
+func (h *Hub) WebSocketHandler(c *gin.Context) {
+	topics := requestTopics(c.Request)
+	claims, err := h.claims(c.Request)
+	if err != nil {
+		rec := services.Response("hub", http.StatusUnauthorized, services.TokenError, err)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, rec)
+		return
+	}
+	if len(topics) > 0 && h.authorize(c, topics) == nil {
+		return
+	}
+	server := websocket.Server{
+		Handshake: h.checkOrigin,
+		Handler: func(ws *websocket.Conn) {
+			defer ws.Close()
+			client, err := h.connect(topics)
+			if err != nil {
+				websocket.JSON.Send(ws, WebSocketResponse{Error: err.Error()})
+				return
+			}
+			defer h.disconnect(client)
+			done := make(chan struct{})
+			var mu sync.Mutex
+			send := func(resp WebSocketResponse) error {
+				mu.Lock()
+				defer mu.Unlock()
+				return websocket.JSON.Send(ws, resp)
+			}
+			go func() {
+				defer close(done)
+				for {
+					var req WebSocketRequest
+					if err := websocket.JSON.Receive(ws, &req); err != nil {
+						return
+					}
+					switch req.Action {
+					case "subscribe":
+						if !h.Allowed(claims, req.Topic) {
+							send(WebSocketResponse{Error: fmt.Sprintf("topic '%s' is not allowed", req.Topic)})
+						} else if err := h.subscribe(client, req.Topic); err != nil {
+							send(WebSocketResponse{Error: err.Error()})
+						}
+					case "unsubscribe":
+						h.unsubscribe(client, req.Topic)
+					default:
+						send(WebSocketResponse{Error: fmt.Sprintf("unsupported action '%s'", req.Action)})
+					}
+				}
+			}()
+			keepAlive := h.KeepAlive
+			if keepAlive <= 0 {
+				keepAlive = 30 * time.Second
+			}
+			ticker := time.NewTicker(keepAlive)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+					if err := send(WebSocketResponse{}); err != nil {
+						return
+					}
+				case env := <-client.events:
+					if !h.deliverable(ws.Request().Context(), claims, env) {
+						continue
+					}
+					if err := send(WebSocketResponse{Event: &env}); err != nil {
+						return
+					}
+				}
+			}
+		},
+	}
+	server.ServeHTTP(c.Writer, c.Request)
+}
+
+// Routes returns hub routes, /events SSE and /ws WebSocket endpoints under
+// given base path. The routes perform their own token validation.
+func (h *Hub) Routes(base string) []Route {
+	base = strings.TrimSuffix(base, "/")
+	return []Route{
+		{Method: "GET", Path: base + "/events", Handler: h.SSEHandler},
+		{Method: "GET", Path: base + "/ws", Handler: h.WebSocketHandler},
+	}
+}
+
+// Close unsubscribes hub from all topics
+func (h *Hub) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for topic, t := range h.topics {
+		t.sub.Unsubscribe()
+		delete(h.topics, topic)
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	"github.com/CHESSComputing/golib/pubsub"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/websocket"
+)
+
+// helper function to setup hub test server
+func hubServer(t *testing.T) (*Hub, *pubsub.MemoryBus, *httptest.Server, string) {
+	gin.SetMode(gin.TestMode)
+	bus := pubsub.NewMemoryBus(pubsub.Options{MaxRetries: 1})
+	hub := NewHub(bus)
+	hub.ClientID = "secret"
+	hub.Rules = []TopicRule{
+		{Pattern: "transfers.{user}"},
+		{Pattern: "ingestion.*", Roles: []string{"operator"}},
+	}
+	r := gin.New()
+	for _, route := range hub.Routes("/notify") {
+		r.GET(route.Path, route.Handler)
+	}
+	ts := httptest.NewServer(r)
+	token, err := authz.JWTAccessToken("secret", 60, authz.CustomClaims{User: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hub, bus, ts, token
+}
+
+// TestHubInstances
+func TestHubInstances(t *testing.T) {
+	bus := pubsub.NewMemoryBus(pubsub.Options{MaxRetries: 1})
+	defer bus.Close()
+	var clients []*hubClient
+	for i := 0; i < 2; i++ {
+		c, err := NewHub(bus).connect([]string{"transfers.alice"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		clients = append(clients, c)
+	}
+	env, _ := pubsub.NewEnvelope(pubsub.EventDatasetTransferred, "test", map[string]string{"did": "/a/b/c"})
+	if err := bus.Publish(context.Background(), "transfers.alice", env); err != nil {
+		t.Fatal(err)
+	}
+	for i, c := range clients {
+		select {
+		case msg := <-c.events:
+			if msg.ID != env.ID {
+				t.Errorf("wrong event %+v", msg)
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("event is not received by hub instance %d", i)
+		}
+	}
+}
+
+// TestHubAllowed
+func TestHubAllowed(t *testing.T) {
+	hub := NewHub(nil)
+	hub.Rules = []TopicRule{
+		{Pattern: "transfers.{user}"},
+		{Pattern: "ingestion.*", Roles: []string{"operator"}},
+	}
+	alice := &authz.Claims{CustomClaims: authz.CustomClaims{User: "alice"}}
+	operator := &authz.Claims{CustomClaims: authz.CustomClaims{User: "bob", Roles: []string{"operator"}}}
+	tests := []struct {
+		claims *authz.Claims
+		topic  string
+		expect bool
+	}{
+		{alice, "transfers.alice", true},
+		{alice, "transfers.bob", false},
+		{alice, "ingestion.id3a", false},
+		{operator, "ingestion.id3a", true},
+		{operator, "ingestion.id3a.files", false},
+		{nil, "transfers.alice", false},
+	}
+	for _, test := range tests {
+		if hub.Allowed(test.claims, test.topic) != test.expect {
+			t.Errorf("topic %s claims %+v: expect %v", test.topic, test.claims, test.expect)
+		}
+	}
+}
+
+// TestSSEHandler
+func TestSSEHandler(t *testing.T) {
+	hub, bus, ts, token := hubServer(t)
+	defer ts.Close()
+	defer bus.Close()
+	hub.Filter = func(ctx context.Context, claims *authz.Claims, env pubsub.Envelope) bool {
+		return claims.CustomClaims.User == "alice" && env.Type != pubsub.EventUploadCompleted
+	}
+
+	resp, err := http.Get(ts.URL + "/notify/events?topic=transfers.bob&access_token=" + token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("wrong status of forbidden topic %d", resp.StatusCode)
+	}
+	resp, _ = http.Get(ts.URL + "/notify/events?topic=transfers.alice")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("wrong status of request without token %d", resp.StatusCode)
+	}
+
+	req, _ := http.NewRequest("GET", ts.URL+"/notify/events?topic=transfers.alice", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ctype := resp.Header.Get("Content-Type"); ctype != "text/event-stream" {
+		t.Fatalf("wrong content type %s", ctype)
+	}
+	// filtered events are not delivered
+	dropped, _ := pubsub.NewEnvelope(pubsub.EventUploadCompleted, "upload", nil)
+	if err := bus.Publish(context.Background(), "transfers.alice", dropped); err != nil {
+		t.Fatal(err)
+	}
+	env, _ := pubsub.NewEnvelope(pubsub.EventDatasetTransferred, "datamgmt", map[string]string{"did": "/a/b/c"})
+	if err := bus.Publish(context.Background(), "transfers.alice", env); err != nil {
+		t.Fatal(err)
+	}
+	reader := bufio.NewReader(resp.Body)
+	var lines []string
+	for len(lines) < 3 {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if lines[0] != "id: "+env.ID || lines[1] != "event: "+env.Type || !strings.Contains(lines[2], `"did":"/a/b/c"`) {
+		t.Errorf("wrong event %v", lines)
+	}
+	resp.Body.Close()
+	waitHub := time.Now().Add(5 * time.Second)
+	for {
+		hub.mu.Lock()
+		ntopics := len(hub.topics)
+		hub.mu.Unlock()
+		if ntopics == 0 {
+			break
+		}
+		if time.Now().After(waitHub) {
+			t.Fatal("hub topic is not released after client disconnect")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestWebSocketHandler
+func TestWebSocketHandler(t *testing.T) {
+	_, bus, ts, token := hubServer(t)
+	defer ts.Close()
+	defer bus.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(ts.URL, "http") + "/notify/ws?access_token=" + token
+	ws, err := websocket.Dial(wsURL, "", ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	websocket.JSON.Send(ws, WebSocketRequest{Action: "subscribe", Topic: "ingestion.id3a"})
+	var resp WebSocketResponse
+	if err := websocket.JSON.Receive(ws, &resp); err != nil || resp.Error == "" {
+		t.Errorf("subscription to forbidden topic should fail, response %+v, error %v", resp, err)
+	}
+	websocket.JSON.Send(ws, WebSocketRequest{Action: "subscribe", Topic: "transfers.alice"})
+	env, _ := pubsub.NewEnvelope(pubsub.EventDatasetTransferred, "datamgmt", nil)
+	// subscription is asynchronous, publish until event is received
+	received := make(chan WebSocketResponse, 1)
+	go func() {
+		var resp WebSocketResponse
+		if err := websocket.JSON.Receive(ws, &resp); err == nil {
+			received <- resp
+		}
+	}()
+	deadline := time.After(5 * time.Second)
+	for {
+		bus.Publish(context.Background(), "transfers.alice", env)
+		select {
+		case resp := <-received:
+			if resp.Event == nil || resp.Event.ID != env.ID {
+				t.Errorf("wrong response %+v", resp)
+			}
+			return
+		case <-deadline:
+			t.Fatal("event is not received")
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
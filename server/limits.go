@@ -0,0 +1,92 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	services "github.com/CHESSComputing/golib/services"
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultHeaderTimeout defines timeout of reading request headers if it is
+// not configured, it protects server from slowloris-style clients
+var DefaultHeaderTimeout = 10 * time.Second
+
+// DefaultIdleTimeout defines timeout of idle keep-alive connections if it
+// is not configured
+var DefaultIdleTimeout = 120 * time.Second
+
+// helper function to get configured timeout, zero value means default
+// timeout and negative value means no timeout
+func timeout(d, def time.Duration) time.Duration {
+	if d < 0 {
+		return 0
+	}
+	if d == 0 {
+		return def
+	}
+	return d
+}
+
+// NewHTTPServer returns HTTP server of the handler with timeouts of web
+// server configuration
+func NewHTTPServer(handler http.Handler, webServer srvConfig.WebServer) *http.Server {
+	return &http.Server{
+		Addr:              fmt.Sprintf(":%d", webServer.Port),
+		Handler:           handler,
+		ReadHeaderTimeout: timeout(webServer.HeaderTimeout, DefaultHeaderTimeout),
+		ReadTimeout:       timeout(webServer.ReadTimeout, 0),
+		WriteTimeout:      timeout(webServer.WriteTimeout, 0),
+		IdleTimeout:       timeout(webServer.IdleTimeout, DefaultIdleTimeout),
+	}
+}
+
+// LimitsMiddleware limits size of request body and sets read and write
+// deadlines of the request connection. Zero values keep server settings,
+// negative values remove the limit, e.g. for upload endpoints.
+func LimitsMiddleware(maxBodySize int64, timeout time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if maxBodySize > 0 && c.Request.Body != nil {
+			if c.Request.ContentLength > maxBodySize {
+				msg := fmt.Sprintf("request body of %d bytes exceeds limit of %d bytes", c.Request.ContentLength, maxBodySize)
+				log.Printf("ERROR: %s %s: %s", c.Request.Method, c.Request.URL.Path, msg)
+				rec := services.Response("server", http.StatusRequestEntityTooLarge, services.ReaderError, errors.New(msg))
+				c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, rec)
+				return
+			}
+			c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBodySize)
+		}
+		if timeout != 0 {
+			// zero deadline removes server timeouts
+			var deadline time.Time
+			if timeout > 0 {
+				deadline = time.Now().Add(timeout)
+			}
+			rc := http.NewResponseController(c.Writer)
+			if err := rc.SetReadDeadline(deadline); err != nil && !errors.Is(err, http.ErrNotSupported) {
+				log.Printf("WARNING: unable to set read deadline, error %v", err)
+			}
+			if err := rc.SetWriteDeadline(deadline); err != nil && !errors.Is(err, http.ErrNotSupported) {
+				log.Printf("WARNING: unable to set write deadline, error %v", err)
+			}
+		}
+		c.Next()
+	}
+}
+
+// helper function to provide limits middleware of the route, route limits
+// overwrite limits of web server configuration
+func routeLimits(route Route, webServer srvConfig.WebServer) gin.HandlerFunc {
+	maxBodySize := int64(webServer.MaxRequestBodySize)
+	if route.MaxBodySize != 0 {
+		maxBodySize = route.MaxBodySize
+	}
+	if maxBodySize <= 0 && route.Timeout == 0 {
+		return nil
+	}
+	return LimitsMiddleware(maxBodySize, route.Timeout)
+}
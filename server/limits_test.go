@@ -0,0 +1,118 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	"github.com/gin-gonic/gin"
+)
+
+// TestNewHTTPServer
+func TestNewHTTPServer(t *testing.T) {
+	srv := NewHTTPServer(nil, srvConfig.WebServer{Port: 8300, ReadTimeout: 30 * time.Second, IdleTimeout: -1})
+	if srv.Addr != ":8300" || srv.ReadHeaderTimeout != DefaultHeaderTimeout || srv.ReadTimeout != 30*time.Second ||
+		srv.WriteTimeout != 0 || srv.IdleTimeout != 0 {
+		t.Errorf("unexpected server timeouts %+v", srv)
+	}
+}
+
+// TestLimitsMiddleware
+func TestLimitsMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	webServer := srvConfig.WebServer{MaxRequestBodySize: 16}
+	upload := Route{Method: "POST", Path: "/upload", MaxBodySize: -1}
+	meta := Route{Method: "POST", Path: "/meta"}
+	r := gin.New()
+	handler := func(c *gin.Context) {
+		data, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		c.String(http.StatusOK, "%d", len(data))
+	}
+	for _, route := range []Route{upload, meta} {
+		if limits := routeLimits(route, webServer); limits != nil {
+			r.POST(route.Path, limits, handler)
+		} else {
+			r.POST(route.Path, handler)
+		}
+	}
+	serve := func(path string, body io.Reader) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, body)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	if w := serve("/meta", strings.NewReader("small")); w.Code != http.StatusOK {
+		t.Errorf("small request is rejected, code %d", w.Code)
+	}
+	large := strings.Repeat("x", 64)
+	if w := serve("/meta", strings.NewReader(large)); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("large request is accepted, code %d", w.Code)
+	}
+	// request without content length is limited while reading
+	if w := serve("/meta", io.MultiReader(strings.NewReader(large))); w.Code != http.StatusBadRequest {
+		t.Errorf("large streamed request is accepted, code %d", w.Code)
+	}
+	if w := serve("/upload", strings.NewReader(large)); w.Code != http.StatusOK || w.Body.String() != "64" {
+		t.Errorf("upload is limited, code %d %s", w.Code, w.Body.String())
+	}
+}
+
+// TestRouteTimeout
+func TestRouteTimeout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	handler := func(c *gin.Context) {
+		data, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		c.String(http.StatusOK, "%d", len(data))
+	}
+	r.POST("/meta", handler)
+	r.POST("/upload", LimitsMiddleware(0, -1), handler)
+	srv := NewHTTPServer(r, srvConfig.WebServer{ReadTimeout: time.Second})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	// slow client sends body after server read timeout
+	post := func(path string) string {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		fmt.Fprintf(conn, "POST %s HTTP/1.1\r\nHost: localhost\r\nContent-Length: 4\r\n\r\nab", path)
+		time.Sleep(1500 * time.Millisecond)
+		fmt.Fprint(conn, "cd")
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			return "no response"
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Sprintf("%d %s", resp.StatusCode, body)
+	}
+	if out := post("/meta"); out == "200 4" {
+		t.Error("slow request is not interrupted by read timeout")
+	}
+	if out := post("/upload"); out != "200 4" {
+		t.Errorf("slow upload is interrupted, response %s", out)
+	}
+}
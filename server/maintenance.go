@@ -0,0 +1,233 @@
+package server
+
+// maintenance module provides maintenance mode of web server. In
+// maintenance mode all requests except allowed paths are answered with
+// 503 code and maintenance page or JSON body, the mode can be toggled at
+// runtime by administrators via maintenance API.
+
+import (
+	"bytes"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	srvConfig "github.com/CHESSComputing/golib/config"
+	services "github.com/CHESSComputing/golib/services"
+	utils "github.com/CHESSComputing/golib/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// MaintenancePath defines path of maintenance API, it is always served in
+// maintenance mode
+var MaintenancePath = "/maintenance"
+
+// DefaultMaintenanceMessage defines message shown to users if it is not
+// configured
+var DefaultMaintenanceMessage = "Service is under maintenance, please try again later"
+
+// defaultMaintenancePage defines built-in maintenance page
+const defaultMaintenancePage = `<!DOCTYPE html>
+<html>
+<head><title>Maintenance</title></head>
+<body>
+<h1>Service maintenance</h1>
+<p>{{.Message}}</p>
+{{if .Since}}<p>Maintenance started at {{.Since.Format "2006-01-02 15:04:05 MST"}}</p>{{end}}
+</body>
+</html>
+`
+
+// MaintenanceStatus represents state of maintenance mode
+type MaintenanceStatus struct {
+	Enabled    bool       `json:"enabled"`
+	Message    string     `json:"message,omitempty"`
+	RetryAfter int        `json:"retry_after,omitempty"` // value of Retry-After header in seconds
+	Since      *time.Time `json:"since,omitempty"`       // time when maintenance mode was enabled
+	User       string     `json:"user,omitempty"`        // user who toggled maintenance mode
+}
+
+// Maintenance represents maintenance mode of web server
+type Maintenance struct {
+	AllowPaths []string // paths served in maintenance mode, or path prefixes ending with *
+	AdminRoles []string // roles allowed to toggle maintenance mode
+
+	mu     sync.RWMutex
+	status MaintenanceStatus
+	page   *template.Template
+}
+
+// NewMaintenance returns maintenance mode of given configuration, the
+// maintenance page is loaded from configured template file or built-in
+// page is used
+func NewMaintenance(cfg srvConfig.Maintenance) (*Maintenance, error) {
+	m := &Maintenance{AllowPaths: cfg.AllowPaths, AdminRoles: cfg.AdminRoles}
+	var err error
+	if cfg.Template != "" {
+		m.page, err = template.ParseFiles(cfg.Template)
+	} else {
+		m.page, err = template.New("maintenance").Parse(defaultMaintenancePage)
+	}
+	if err != nil {
+		log.Printf("ERROR: unable to load maintenance template %s, error %v", cfg.Template, err)
+		return nil, err
+	}
+	if cfg.Enabled {
+		m.Set(MaintenanceStatus{Enabled: true, Message: cfg.Message, RetryAfter: int(cfg.RetryAfter / time.Second)})
+	} else {
+		m.status = MaintenanceStatus{Message: cfg.Message, RetryAfter: int(cfg.RetryAfter / time.Second)}
+	}
+	return m, nil
+}
+
+// Set changes state of maintenance mode, empty message and zero retry
+// after value keep current values
+func (m *Maintenance) Set(status MaintenanceStatus) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if status.Message == "" {
+		status.Message = m.status.Message
+	}
+	if status.RetryAfter == 0 {
+		status.RetryAfter = m.status.RetryAfter
+	}
+	if status.Enabled {
+		if m.status.Enabled && m.status.Since != nil {
+			status.Since = m.status.Since
+		} else {
+			now := time.Now()
+			status.Since = &now
+		}
+	} else {
+		status.Since = nil
+	}
+	m.status = status
+	log.Printf("maintenance mode %+v", status)
+}
+
+// Status returns current state of maintenance mode
+func (m *Maintenance) Status() MaintenanceStatus {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	status := m.status
+	if status.Message == "" {
+		status.Message = DefaultMaintenanceMessage
+	}
+	return status
+}
+
+// Enabled returns true if server is in maintenance mode
+func (m *Maintenance) Enabled() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.status.Enabled
+}
+
+// helper function to check if path is served in maintenance mode
+func (m *Maintenance) allowed(path string) bool {
+	if path == MaintenancePath {
+		return true
+	}
+	for _, p := range m.AllowPaths {
+		if p == path {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(p, "*"); ok && strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// MaintenanceMiddleware answers requests with 503 code when server is in
+// maintenance mode, browsers get maintenance page and other clients get
+// JSON body. Allowed paths and maintenance API are served as usual.
+func MaintenanceMiddleware(m *Maintenance) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if m == nil || !m.Enabled() || m.allowed(c.Request.URL.Path) {
+			c.Next()
+			return
+		}
+		status := m.Status()
+		if status.RetryAfter > 0 {
+			c.Header("Retry-After", strconv.Itoa(status.RetryAfter))
+		}
+		offers := []string{JSONContentType, "text/html"}
+		if NegotiateContentType(c.Request.Header.Get("Accept"), offers) == "text/html" {
+			var buf bytes.Buffer
+			err := m.page.Execute(&buf, status)
+			if err == nil {
+				c.Data(http.StatusServiceUnavailable, "text/html; charset=utf-8", buf.Bytes())
+				c.Abort()
+				return
+			}
+			log.Printf("ERROR: unable to render maintenance page, error %v", err)
+		}
+		// do not use services.Response to avoid logging every rejected request
+		rec := services.ServiceResponse{
+			HttpCode:  http.StatusServiceUnavailable,
+			SrvCode:   services.ServiceError,
+			Service:   "server",
+			Status:    "error",
+			Error:     status.Message,
+			Timestamp: time.Now().String(),
+		}
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, rec)
+	}
+}
+
+// helper function to get claims of administrator request, claims are taken
+// from gin context or request API key or token. It returns nil if request
+// does not belong to user with one of admin roles or token is impersonated.
+func adminClaims(c *gin.Context, roles []string) *authz.Claims {
+	var claims *authz.Claims
+	if val, ok := c.Get("claims"); ok {
+		claims, _ = val.(*authz.Claims)
+	} else if srvConfig.Config != nil {
+		// API key store handles tokens and reports API keys if it is not set
+		claims, _ = APIKeyStore.RequestClaims(c.Request, srvConfig.Config.Authz.ClientID)
+	}
+	if claims == nil || authz.IsImpersonated(claims) {
+		return nil
+	}
+	for _, role := range claims.CustomClaims.Roles {
+		if utils.InList(role, roles) {
+			return claims
+		}
+	}
+	return nil
+}
+
+// MaintenanceHandler provides maintenance API, GET request returns state
+// of maintenance mode and PUT request with MaintenanceStatus JSON body
+// toggles it. It should be used after authorization middleware, only users
+// with admin roles are allowed.
+func MaintenanceHandler(m *Maintenance) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := adminClaims(c, m.AdminRoles)
+		if claims == nil {
+			err := errors.New("maintenance mode can be changed only by administrators")
+			rec := services.Response("server", http.StatusForbidden, services.PolicyError, err)
+			c.AbortWithStatusJSON(http.StatusForbidden, rec)
+			return
+		}
+		if c.Request.Method == http.MethodGet {
+			c.JSON(http.StatusOK, m.Status())
+			return
+		}
+		var status MaintenanceStatus
+		if err := c.BindJSON(&status); err != nil {
+			rec := services.Response("server", http.StatusBadRequest, services.BindError, err)
+			c.JSON(http.StatusBadRequest, rec)
+			return
+		}
+		status.User = claims.CustomClaims.User
+		m.Set(status)
+		c.JSON(http.StatusOK, m.Status())
+	}
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	srvConfig "github.com/CHESSComputing/golib/config"
+	"github.com/gin-gonic/gin"
+)
+
+// TestMaintenanceMode
+func TestMaintenanceMode(t *testing.T) {
+	config := srvConfig.Config
+	defer func() { srvConfig.Config = config }()
+	srvConfig.Config = &srvConfig.SrvConfig{}
+	srvConfig.Config.Authz.ClientID = "secret"
+	gin.SetMode(gin.TestMode)
+
+	handler := func(c *gin.Context) { c.String(http.StatusOK, "ok") }
+	routes := []Route{
+		{Method: "GET", Path: "/records", Handler: handler},
+		{Method: "GET", Path: "/status/db", Handler: handler},
+	}
+	webServer := srvConfig.WebServer{
+		Maintenance: srvConfig.Maintenance{
+			Message:    "MongoDB upgrade",
+			RetryAfter: 10 * time.Minute,
+			AllowPaths: []string{"/status/*"},
+			AdminRoles: []string{"foxden-admin"},
+		},
+	}
+	r := Router(routes, nil, "", webServer)
+	serve := func(method, path, accept, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	if w := serve("GET", "/records", "", "", ""); w.Code != http.StatusOK {
+		t.Fatalf("request is rejected without maintenance, code %d", w.Code)
+	}
+
+	admin, _ := authz.JWTAccessToken("secret", 60, authz.CustomClaims{User: "admin", Scope: "write", Roles: []string{"foxden-admin"}})
+	user, _ := authz.JWTAccessToken("secret", 60, authz.CustomClaims{User: "alice", Scope: "write"})
+	if w := serve("PUT", MaintenancePath, "", user, `{"enabled": true}`); w.Code != http.StatusForbidden {
+		t.Errorf("maintenance mode is changed by user, code %d", w.Code)
+	}
+	if w := serve("PUT", MaintenancePath, "", admin, `{"enabled": true}`); w.Code != http.StatusOK {
+		t.Fatalf("maintenance mode is not changed by admin, code %d %s", w.Code, w.Body.String())
+	}
+	status := MaintenanceMode.Status()
+	if !status.Enabled || status.Message != "MongoDB upgrade" || status.Since == nil || status.User != "admin" {
+		t.Errorf("unexpected maintenance status %+v", status)
+	}
+
+	w := serve("GET", "/records", "", "", "")
+	var rec map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &rec); err != nil || w.Code != http.StatusServiceUnavailable || rec["error"] != "MongoDB upgrade" {
+		t.Errorf("unexpected maintenance response %d %s", w.Code, w.Body.String())
+	}
+	if w.Header().Get("Retry-After") != "600" {
+		t.Errorf("wrong Retry-After header %v", w.Header())
+	}
+	w = serve("GET", "/records", "text/html,application/xhtml+xml,*/*;q=0.8", "", "")
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Header().Get("Content-Type"), "text/html") ||
+		!strings.Contains(w.Body.String(), "MongoDB upgrade") {
+		t.Errorf("unexpected maintenance page %d %s", w.Code, w.Body.String())
+	}
+	if w := serve("GET", "/status/db", "", "", ""); w.Code != http.StatusOK {
+		t.Errorf("allowed path is rejected, code %d", w.Code)
+	}
+	if w := serve("GET", MaintenancePath, "", admin, ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"enabled":true`) {
+		t.Errorf("unexpected maintenance status %d %s", w.Code, w.Body.String())
+	}
+
+	if w := serve("PUT", MaintenancePath, "", admin, `{"enabled": false}`); w.Code != http.StatusOK {
+		t.Fatalf("maintenance mode is not disabled, code %d", w.Code)
+	}
+	if w := serve("GET", "/records", "", "", ""); w.Code != http.StatusOK {
+		t.Errorf("request is rejected after maintenance, code %d", w.Code)
+	}
+}
@@ -8,8 +8,13 @@ import (
 	"fmt"
 	"os"
 	"runtime"
+	"sync/atomic"
 	"time"
 
+	authz "github.com/CHESSComputing/golib/authz"
+	beamlines "github.com/CHESSComputing/golib/beamlines"
+	srvConfig "github.com/CHESSComputing/golib/config"
+	mongo "github.com/CHESSComputing/golib/mongo"
 	"github.com/shirou/gopsutil/cpu"
 	"github.com/shirou/gopsutil/load"
 	"github.com/shirou/gopsutil/mem"
@@ -112,6 +117,24 @@ type Metrics struct {
 	MigrationCompleted  uint64 `json:"migrationCompleted"`  // total number of completed migration requests across all services
 	MigrationQueued     uint64 `json:"migrationQueued"`     // total number of queued migration requests across all services
 	MigrationExistInDB  uint64 `json:"migrationExistInDB"`  // total number of exist in db migration requests across all services
+
+	// recovered handler panics
+	Panics uint64 `json:"panics"` // total number of recovered handler panics
+
+	// brute-force protection of logins
+	LoginFailures     uint64 `json:"loginFailures"`     // total number of failed logins
+	LoginLockouts     uint64 `json:"loginLockouts"`     // total number of lockouts of accounts and IP addresses
+	CaptchaChallenges uint64 `json:"captchaChallenges"` // total number of issued CAPTCHA challenges
+
+	// reload metrics
+	ConfigReloads srvConfig.ReloadStatus `json:"configReloads"` // configuration reloads of configuration source
+	SchemaReloads srvConfig.ReloadStatus `json:"schemaReloads"` // schema loads and renewals
+
+	// process monitor metrics
+	Process *ProcessStats `json:"process,omitempty"` // last sample of process monitor
+
+	// MongoDB connection metrics
+	MongoDB *mongo.Status `json:"mongodb,omitempty"` // status of MongoDB connection supervisor
 }
 
 func metrics() Metrics {
@@ -169,6 +192,21 @@ func metrics() Metrics {
 	metrics.RPSLogical = float64(rstat.NumLogicalCores-NumLogicalCores) / lapse
 	metrics.RPSPhysical = float64(rstat.NumPhysicalCores-NumPhysicalCores) / lapse
 
+	metrics.Panics = atomic.LoadUint64(&TotalPanics)
+	metrics.LoginFailures = atomic.LoadUint64(&authz.TotalLoginFailures)
+	metrics.LoginLockouts = atomic.LoadUint64(&authz.TotalLoginLockouts)
+	metrics.CaptchaChallenges = atomic.LoadUint64(&authz.TotalCaptchaChallenges)
+	metrics.ConfigReloads = srvConfig.ConfigReloads.Status()
+	metrics.SchemaReloads = beamlines.SchemaReloads.Status()
+	if SelfMonitor != nil {
+		stats := SelfMonitor.Stats()
+		metrics.Process = &stats
+	}
+	if sup := mongo.Mongo.Supervisor; sup != nil {
+		status := sup.Status()
+		metrics.MongoDB = &status
+	}
+
 	rstat.Update()
 
 	return metrics
@@ -368,6 +406,139 @@ func promMetrics(prefix string) string {
 	out += fmt.Sprintf("# HELP %s_exist_in_db reports total number of exist in db migration requests\n", prefix)
 	out += fmt.Sprintf("# TYPE %s_exist_in_db counter\n", prefix)
 	out += fmt.Sprintf("%s_exist_in_db %v\n", prefix, data.MigrationExistInDB)
+
+	// recovered panics
+	out += fmt.Sprintf("# HELP %s_panics reports total number of recovered handler panics\n", prefix)
+	out += fmt.Sprintf("# TYPE %s_panics counter\n", prefix)
+	out += fmt.Sprintf("%s_panics %v\n", prefix, data.Panics)
+
+	// login protection
+	out += fmt.Sprintf("# HELP %s_login_failures reports total number of failed logins\n", prefix)
+	out += fmt.Sprintf("# TYPE %s_login_failures counter\n", prefix)
+	out += fmt.Sprintf("%s_login_failures %v\n", prefix, data.LoginFailures)
+	out += fmt.Sprintf("# HELP %s_login_lockouts reports total number of lockouts of accounts and IP addresses\n", prefix)
+	out += fmt.Sprintf("# TYPE %s_login_lockouts counter\n", prefix)
+	out += fmt.Sprintf("%s_login_lockouts %v\n", prefix, data.LoginLockouts)
+	out += fmt.Sprintf("# HELP %s_captcha_challenges reports total number of issued CAPTCHA challenges\n", prefix)
+	out += fmt.Sprintf("# TYPE %s_captcha_challenges counter\n", prefix)
+	out += fmt.Sprintf("%s_captcha_challenges %v\n", prefix, data.CaptchaChallenges)
+
+	// reload metrics
+	out += promReloadMetrics(prefix+"_config", "configuration", data.ConfigReloads)
+	out += promReloadMetrics(prefix+"_schema", "schema", data.SchemaReloads)
+
+	// process monitor metrics
+	if data.Process != nil {
+		out += promProcessMetrics(prefix+"_process", *data.Process)
+	}
+
+	// MongoDB connection metrics
+	if data.MongoDB != nil {
+		out += promMongoMetrics(prefix+"_mongodb", *data.MongoDB)
+	}
+	return out
+}
+
+// helper function to generate process monitor metrics in prometheus format
+func promProcessMetrics(prefix string, stats ProcessStats) string {
+	var out string
+	gauges := []struct {
+		name, help string
+		value      any
+	}{
+		{"goroutines", "number of goroutines", stats.Goroutines},
+		{"heap_alloc", "bytes of allocated heap objects", stats.HeapAlloc},
+		{"heap_objects", "number of allocated heap objects", stats.HeapObjects},
+		{"sys", "bytes of memory obtained from OS", stats.Sys},
+		{"rss", "resident memory in bytes", stats.RSS},
+		{"open_fds", "number of open file descriptors", stats.OpenFDs},
+		{"last_gc_pause", "pause time of last GC cycle in seconds", stats.LastGCPause.Seconds()},
+	}
+	for _, g := range gauges {
+		out += fmt.Sprintf("# HELP %s_%s reports %s\n", prefix, g.name, g.help)
+		out += fmt.Sprintf("# TYPE %s_%s gauge\n", prefix, g.name)
+		out += fmt.Sprintf("%s_%s %v\n", prefix, g.name, g.value)
+	}
+	counters := []struct {
+		name, help string
+		value      any
+	}{
+		{"gc_cycles", "total number of completed GC cycles", stats.NumGC},
+		{"gc_pause_total", "total GC pause time in seconds", stats.GCPauseTotal.Seconds()},
+		{"anomalies", "total number of anomalies detected by process monitor", stats.AnomalyCount},
+	}
+	for _, c := range counters {
+		out += fmt.Sprintf("# HELP %s_%s reports %s\n", prefix, c.name, c.help)
+		out += fmt.Sprintf("# TYPE %s_%s counter\n", prefix, c.name)
+		out += fmt.Sprintf("%s_%s %v\n", prefix, c.name, c.value)
+	}
+	return out
+}
+
+// helper function to generate MongoDB connection metrics in prometheus format
+func promMongoMetrics(prefix string, status mongo.Status) string {
+	var up, outage float64
+	if status.Ready() {
+		up = 1
+	}
+	if status.OutageStart != nil {
+		outage = time.Since(*status.OutageStart).Seconds()
+	}
+	var out string
+	gauges := []struct {
+		name, help string
+		value      any
+	}{
+		{"up", "if MongoDB can serve requests", up},
+		{"outage_seconds", "duration of current MongoDB outage in seconds", outage},
+		{"servers", "number of known MongoDB servers", status.Servers},
+		{"available_servers", "number of available MongoDB servers", status.AvailableServers},
+	}
+	for _, g := range gauges {
+		out += fmt.Sprintf("# HELP %s_%s reports %s\n", prefix, g.name, g.help)
+		out += fmt.Sprintf("# TYPE %s_%s gauge\n", prefix, g.name)
+		out += fmt.Sprintf("%s_%s %v\n", prefix, g.name, g.value)
+	}
+	counters := []struct {
+		name, help string
+		value      any
+	}{
+		{"outages", "total number of MongoDB outages", status.Outages},
+		{"heartbeat_failures", "total number of failed heartbeats of MongoDB servers", status.HeartbeatFailures},
+		{"retries", "total number of retried MongoDB operations", status.Retries},
+		{"rejected", "total number of MongoDB operations rejected or failed due to outage", status.Rejected},
+	}
+	for _, c := range counters {
+		out += fmt.Sprintf("# HELP %s_%s reports %s\n", prefix, c.name, c.help)
+		out += fmt.Sprintf("# TYPE %s_%s counter\n", prefix, c.name)
+		out += fmt.Sprintf("%s_%s %v\n", prefix, c.name, c.value)
+	}
+	return out
+}
+
+// helper function to generate reload metrics in prometheus format
+func promReloadMetrics(prefix, name string, status srvConfig.ReloadStatus) string {
+	var out string
+	out += fmt.Sprintf("# HELP %s_reloads reports total number of %s reloads\n", prefix, name)
+	out += fmt.Sprintf("# TYPE %s_reloads counter\n", prefix)
+	out += fmt.Sprintf("%s_reloads %v\n", prefix, status.Count)
+	out += fmt.Sprintf("# HELP %s_reload_failures reports total number of failed %s reloads\n", prefix, name)
+	out += fmt.Sprintf("# TYPE %s_reload_failures counter\n", prefix)
+	out += fmt.Sprintf("%s_reload_failures %v\n", prefix, status.Failures)
+	var timestamp int64
+	if !status.LastReload.IsZero() {
+		timestamp = status.LastReload.Unix()
+	}
+	out += fmt.Sprintf("# HELP %s_last_reload_timestamp reports unix time of last %s reload\n", prefix, name)
+	out += fmt.Sprintf("# TYPE %s_last_reload_timestamp gauge\n", prefix)
+	out += fmt.Sprintf("%s_last_reload_timestamp %v\n", prefix, timestamp)
+	var success int
+	if status.LastSuccess {
+		success = 1
+	}
+	out += fmt.Sprintf("# HELP %s_last_reload_success reports if last %s reload was successful\n", prefix, name)
+	out += fmt.Sprintf("# TYPE %s_last_reload_success gauge\n", prefix)
+	out += fmt.Sprintf("%s_last_reload_success %v\n", prefix, success)
 	return out
 }
 
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+)
+
+// TestReloadMetrics
+func TestReloadMetrics(t *testing.T) {
+	var stats srvConfig.ReloadStats
+	out := promReloadMetrics("foxden_config", "configuration", stats.Status())
+	for _, line := range []string{"foxden_config_reloads 0\n", "foxden_config_last_reload_timestamp 0\n", "foxden_config_last_reload_success 0\n"} {
+		if !strings.Contains(out, line) {
+			t.Errorf("metrics does not contain %q\n%s", line, out)
+		}
+	}
+	stats.Record(nil)
+	stats.Record(errors.New("invalid configuration"))
+	out = promReloadMetrics("foxden_config", "configuration", stats.Status())
+	for _, line := range []string{"foxden_config_reloads 2\n", "foxden_config_reload_failures 1\n", "foxden_config_last_reload_success 0\n", "# TYPE foxden_config_reload_failures counter\n"} {
+		if !strings.Contains(out, line) {
+			t.Errorf("metrics does not contain %q\n%s", line, out)
+		}
+	}
+	if strings.Contains(out, "foxden_config_last_reload_timestamp 0\n") {
+		t.Errorf("last reload time is not reported\n%s", out)
+	}
+	stats.Record(nil)
+	if out = promReloadMetrics("foxden_config", "configuration", stats.Status()); !strings.Contains(out, "foxden_config_last_reload_success 1\n") {
+		t.Errorf("successful reload is not reported\n%s", out)
+	}
+}
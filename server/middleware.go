@@ -11,6 +11,7 @@ import (
 	"time"
 
 	srvConfig "github.com/CHESSComputing/golib/config"
+	redis "github.com/CHESSComputing/golib/redis"
 	"github.com/gin-gonic/gin"
 	limiter "github.com/ulule/limiter/v3"
 	mgin "github.com/ulule/limiter/v3/drivers/middleware/gin"
@@ -57,7 +58,11 @@ func initLimiter(period, header string) {
 	if err != nil {
 		panic(err)
 	}
+	// counters are kept in redis if they are shared by replicas
 	store := memory.NewStore()
+	if redis.Redis != nil && srvConfig.Config != nil && srvConfig.Config.Redis.RateLimiter {
+		store = redis.NewLimiterStore(redis.Redis)
+	}
 	instance := limiter.New(store, rate)
 	if header != "" {
 		instance = limiter.New(
@@ -0,0 +1,206 @@
+package server
+
+// monitor module provides self-monitoring of server process. It
+// periodically samples memory, goroutines, GC stats and open file
+// descriptors, logs anomalies, e.g. goroutine leaks or RSS growth, and
+// optionally writes heap profiles when anomalies are detected.
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"sync"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	"github.com/prometheus/procfs"
+)
+
+// default monitor parameters used when they are not configured
+var (
+	DefaultMonitorInterval        = time.Minute
+	DefaultMonitorMaxGoroutines   = 10000
+	DefaultMonitorProfileInterval = time.Hour
+)
+
+// GoroutineLeakSamples defines number of consecutive samples with growing
+// number of goroutines reported as possible goroutine leak
+var GoroutineLeakSamples = 10
+
+// SelfMonitor represents process monitor of the server, it is nil if
+// monitoring is disabled
+var SelfMonitor *ProcessMonitor
+
+// ProcessStats represents sample of server process stats
+type ProcessStats struct {
+	Time         time.Time     `json:"time"`                // time of the sample
+	Goroutines   int           `json:"goroutines"`          // number of goroutines
+	HeapAlloc    uint64        `json:"heap_alloc"`          // bytes of allocated heap objects
+	HeapObjects  uint64        `json:"heap_objects"`        // number of allocated heap objects
+	Sys          uint64        `json:"sys"`                 // bytes of memory obtained from OS
+	RSS          uint64        `json:"rss"`                 // resident memory in bytes
+	OpenFDs      int           `json:"open_fds"`            // number of open file descriptors
+	NumGC        uint32        `json:"num_gc"`              // number of completed GC cycles
+	GCPauseTotal time.Duration `json:"gc_pause_total"`      // total GC pause time
+	LastGCPause  time.Duration `json:"last_gc_pause"`       // pause time of last GC cycle
+	Anomalies    []string      `json:"anomalies,omitempty"` // anomalies of the sample
+	AnomalyCount uint64        `json:"anomaly_count"`       // total number of anomalies
+	Profiles     []string      `json:"profiles,omitempty"`  // heap profiles written by monitor
+}
+
+// ProcessMonitor represents self-monitoring of server process
+type ProcessMonitor struct {
+	Interval        time.Duration // sampling interval
+	MaxGoroutines   int           // number of goroutines reported as anomaly
+	MaxRSS          uint64        // resident memory reported as anomaly, 0 means no limit
+	RSSGrowth       float64       // RSS growth relative to first sample reported as anomaly
+	ProfileDir      string        // directory of heap profiles
+	ProfileInterval time.Duration // minimal interval between heap profiles
+
+	mu          sync.Mutex
+	first       *ProcessStats
+	last        ProcessStats
+	growth      int
+	lastProfile time.Time
+}
+
+// NewProcessMonitor creates process monitor from monitor configuration
+func NewProcessMonitor(cfg srvConfig.Monitor) *ProcessMonitor {
+	m := &ProcessMonitor{
+		Interval:        cfg.Interval,
+		MaxGoroutines:   cfg.MaxGoroutines,
+		MaxRSS:          uint64(cfg.MaxRSS),
+		RSSGrowth:       cfg.RSSGrowth,
+		ProfileDir:      cfg.ProfileDir,
+		ProfileInterval: cfg.ProfileInterval,
+	}
+	if m.Interval <= 0 {
+		m.Interval = DefaultMonitorInterval
+	}
+	if m.MaxGoroutines <= 0 {
+		m.MaxGoroutines = DefaultMonitorMaxGoroutines
+	}
+	if m.ProfileInterval <= 0 {
+		m.ProfileInterval = DefaultMonitorProfileInterval
+	}
+	return m
+}
+
+// helper function to collect stats of server process
+func processStats() ProcessStats {
+	var mstats runtime.MemStats
+	runtime.ReadMemStats(&mstats)
+	stats := ProcessStats{
+		Time:         time.Now(),
+		Goroutines:   runtime.NumGoroutine(),
+		HeapAlloc:    mstats.HeapAlloc,
+		HeapObjects:  mstats.HeapObjects,
+		Sys:          mstats.Sys,
+		NumGC:        mstats.NumGC,
+		GCPauseTotal: time.Duration(mstats.PauseTotalNs),
+	}
+	if mstats.NumGC > 0 {
+		stats.LastGCPause = time.Duration(mstats.PauseNs[(mstats.NumGC+255)%256])
+	}
+	if proc, err := procfs.NewProc(os.Getpid()); err == nil {
+		if stat, err := proc.Stat(); err == nil {
+			stats.RSS = uint64(stat.ResidentMemory())
+		}
+		if fds, err := proc.FileDescriptorsLen(); err == nil {
+			stats.OpenFDs = fds
+		}
+	}
+	return stats
+}
+
+// helper function to check sample for anomalies, it returns anomalies of
+// the sample
+func (m *ProcessMonitor) observe(stats ProcessStats) ProcessStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.first == nil {
+		first := stats
+		m.first = &first
+	} else if stats.Goroutines > m.last.Goroutines {
+		m.growth++
+	} else {
+		m.growth = 0
+	}
+	var anomalies []string
+	if stats.Goroutines > m.MaxGoroutines {
+		anomalies = append(anomalies, fmt.Sprintf("%d goroutines exceed limit %d", stats.Goroutines, m.MaxGoroutines))
+	}
+	if m.growth > 0 && m.growth%GoroutineLeakSamples == 0 {
+		anomalies = append(anomalies, fmt.Sprintf("goroutines grow for %d samples, possible goroutine leak", m.growth))
+	}
+	if m.MaxRSS > 0 && stats.RSS > m.MaxRSS {
+		anomalies = append(anomalies, fmt.Sprintf("RSS %v exceeds limit %v", srvConfig.ByteSize(stats.RSS), srvConfig.ByteSize(m.MaxRSS)))
+	}
+	if m.RSSGrowth > 0 && m.first.RSS > 0 && float64(stats.RSS) > float64(m.first.RSS)*m.RSSGrowth {
+		anomalies = append(anomalies, fmt.Sprintf("RSS %v grows more than %v times since start", srvConfig.ByteSize(stats.RSS), m.RSSGrowth))
+	}
+	stats.Anomalies = anomalies
+	stats.AnomalyCount = m.last.AnomalyCount + uint64(len(anomalies))
+	stats.Profiles = m.last.Profiles
+	if len(anomalies) > 0 {
+		log.Printf("WARNING: process monitor: %s", strings.Join(anomalies, ", "))
+		if m.ProfileDir != "" && time.Since(m.lastProfile) > m.ProfileInterval {
+			if fname, err := writeHeapProfile(m.ProfileDir, stats.Time); err == nil {
+				log.Println("process monitor: heap profile", fname)
+				m.lastProfile = stats.Time
+				stats.Profiles = append(append([]string{}, stats.Profiles...), fname)
+			} else {
+				log.Printf("ERROR: process monitor: unable to write heap profile, error %v", err)
+			}
+		}
+	}
+	m.last = stats
+	return stats
+}
+
+// helper function to write heap profile into given directory
+func writeHeapProfile(dir string, tstamp time.Time) (string, error) {
+	fname := filepath.Join(dir, fmt.Sprintf("heap-%s.pprof", tstamp.Format("20060102-150405")))
+	file, err := os.Create(fname)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	if err := pprof.Lookup("heap").WriteTo(file, 0); err != nil {
+		return "", err
+	}
+	return fname, nil
+}
+
+// Sample samples stats of server process and checks them for anomalies
+func (m *ProcessMonitor) Sample() ProcessStats {
+	return m.observe(processStats())
+}
+
+// Stats returns last sample of process stats
+func (m *ProcessMonitor) Stats() ProcessStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.last
+}
+
+// Run samples stats of server process with monitor interval until context
+// is cancelled
+func (m *ProcessMonitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.Interval)
+	defer ticker.Stop()
+	m.Sample()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.Sample()
+		}
+	}
+}
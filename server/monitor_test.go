@@ -0,0 +1,72 @@
+package server
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+)
+
+// TestProcessMonitor
+func TestProcessMonitor(t *testing.T) {
+	dir := t.TempDir()
+	m := NewProcessMonitor(srvConfig.Monitor{Enabled: true, MaxGoroutines: 1, ProfileDir: dir})
+	if m.Interval != DefaultMonitorInterval || m.ProfileInterval != DefaultMonitorProfileInterval {
+		t.Errorf("default parameters are not set %+v", m)
+	}
+	stats := m.Sample()
+	if stats.Goroutines == 0 || stats.HeapAlloc == 0 || stats.Sys == 0 {
+		t.Errorf("process stats are not sampled %+v", stats)
+	}
+	if len(stats.Anomalies) != 1 || !strings.Contains(stats.Anomalies[0], "exceed limit 1") || stats.AnomalyCount != 1 {
+		t.Errorf("goroutine limit is not reported %+v", stats.Anomalies)
+	}
+	if len(stats.Profiles) != 1 {
+		t.Fatalf("heap profile is not written %+v", stats.Profiles)
+	}
+	if info, err := os.Stat(stats.Profiles[0]); err != nil || info.Size() == 0 {
+		t.Errorf("invalid heap profile %v", err)
+	}
+	// profiles are rate limited
+	if stats = m.Sample(); len(stats.Profiles) != 1 || stats.AnomalyCount != 2 {
+		t.Errorf("unexpected profiles %v, anomalies %d", stats.Profiles, stats.AnomalyCount)
+	}
+	if m.Stats().Time != stats.Time {
+		t.Error("last sample is not kept")
+	}
+}
+
+// TestProcessMonitorAnomalies
+func TestProcessMonitorAnomalies(t *testing.T) {
+	m := NewProcessMonitor(srvConfig.Monitor{MaxRSS: 300, RSSGrowth: 2})
+	now := time.Now()
+	var stats ProcessStats
+	for i := 0; i <= GoroutineLeakSamples; i++ {
+		stats = m.observe(ProcessStats{Time: now, Goroutines: 10 + i, RSS: 100})
+		if i < GoroutineLeakSamples && len(stats.Anomalies) != 0 {
+			t.Fatalf("unexpected anomalies at sample %d: %v", i, stats.Anomalies)
+		}
+	}
+	if len(stats.Anomalies) != 1 || !strings.Contains(stats.Anomalies[0], "possible goroutine leak") {
+		t.Errorf("goroutine leak is not reported %v", stats.Anomalies)
+	}
+	stats = m.observe(ProcessStats{Time: now, Goroutines: 10, RSS: 250})
+	if len(stats.Anomalies) != 1 || !strings.Contains(stats.Anomalies[0], "grows more than 2 times") {
+		t.Errorf("RSS growth is not reported %v", stats.Anomalies)
+	}
+	stats = m.observe(ProcessStats{Time: now, Goroutines: 10, RSS: 400})
+	if len(stats.Anomalies) != 2 || !strings.Contains(stats.Anomalies[0], "exceeds limit 300B") {
+		t.Errorf("RSS limit is not reported %v", stats.Anomalies)
+	}
+	if stats.AnomalyCount != 4 {
+		t.Errorf("wrong anomaly count %d", stats.AnomalyCount)
+	}
+	out := promProcessMetrics("foxden_process", stats)
+	for _, line := range []string{"foxden_process_rss 400\n", "foxden_process_anomalies 4\n", "# TYPE foxden_process_anomalies counter\n"} {
+		if !strings.Contains(out, line) {
+			t.Errorf("metrics does not contain %q\n%s", line, out)
+		}
+	}
+}
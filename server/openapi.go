@@ -0,0 +1,673 @@
+package server
+
+// openapi module generates OpenAPI 3 document from server routes which
+// declare typed request/response structures and validates incoming
+// requests against generated schemas.
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	services "github.com/CHESSComputing/golib/services"
+	utils "github.com/CHESSComputing/golib/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// OpenAPIVersion defines version of OpenAPI specification
+const OpenAPIVersion = "3.0.3"
+
+// APIInfo represents info section of OpenAPI document, services should
+// set it before calling Router
+var APIInfo = OpenAPIInfo{Title: "FOXDEN service", Version: "1.0.0"}
+
+// OpenAPIInfo represents OpenAPI info object
+type OpenAPIInfo struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+// Schema represents OpenAPI schema object
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+}
+
+// Parameter represents OpenAPI parameter object
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required"`
+	Schema      *Schema `json:"schema"`
+}
+
+// MediaType represents OpenAPI media type object
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// RequestBody represents OpenAPI request body object
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+// ResponseObject represents OpenAPI response object
+type ResponseObject struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+// Operation represents OpenAPI operation object
+type Operation struct {
+	Summary     string                    `json:"summary,omitempty"`
+	OperationID string                    `json:"operationId,omitempty"`
+	Parameters  []Parameter               `json:"parameters,omitempty"`
+	RequestBody *RequestBody              `json:"requestBody,omitempty"`
+	Responses   map[string]ResponseObject `json:"responses"`
+	Security    []map[string][]string     `json:"security,omitempty"`
+}
+
+// Components represents OpenAPI components object
+type Components struct {
+	Schemas         map[string]*Schema        `json:"schemas,omitempty"`
+	SecuritySchemes map[string]map[string]any `json:"securitySchemes,omitempty"`
+}
+
+// OpenAPIDoc represents OpenAPI document
+type OpenAPIDoc struct {
+	OpenAPI    string                           `json:"openapi"`
+	Info       OpenAPIInfo                      `json:"info"`
+	Paths      map[string]map[string]*Operation `json:"paths"`
+	Components Components                       `json:"components"`
+}
+
+// openAPIDoc holds OpenAPI document of the server routes
+var openAPIDoc *OpenAPIDoc
+
+// OpenAPIHandler provides OpenAPI document of server routes
+func OpenAPIHandler(c *gin.Context) {
+	if openAPIDoc == nil {
+		c.JSON(http.StatusNotFound, services.Response("server", http.StatusNotFound, services.NotImplementedApiCode, errors.New("OpenAPI document is not available")))
+		return
+	}
+	c.JSON(http.StatusOK, openAPIDoc)
+}
+
+// helper function to convert gin path into OpenAPI path and its parameters
+func openAPIPath(path string) (string, []string) {
+	var params []string
+	parts := strings.Split(path, "/")
+	for i, p := range parts {
+		if strings.HasPrefix(p, ":") || strings.HasPrefix(p, "*") {
+			params = append(params, p[1:])
+			parts[i] = "{" + p[1:] + "}"
+		}
+	}
+	return strings.Join(parts, "/"), params
+}
+
+// helper function to check if route carries request body
+func hasBody(method string) bool {
+	return method == "POST" || method == "PUT" || method == "PATCH"
+}
+
+// pattern of characters which are not allowed in component names
+var patternComponent = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// helper function to get element type of pointers and slices
+func elemType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	return t
+}
+
+// helper function to get type name used in components, names are qualified
+// by package name, e.g. server.ValidationResponse, or by full package path
+// if fullPath is set
+func typeName(t reflect.Type, fullPath bool) string {
+	t = elemType(t)
+	if t.Name() == "" {
+		return ""
+	}
+	pkg := t.PkgPath()
+	if !fullPath {
+		pkg = path.Base(pkg)
+	}
+	name := t.Name()
+	if pkg != "" && pkg != "." {
+		name = pkg + "." + name
+	}
+	return patternComponent.ReplaceAllString(strings.ReplaceAll(name, "/", "."), "_")
+}
+
+// NewOpenAPI generates OpenAPI document from given routes, routes without
+// Request and Response structures are described by their path parameters
+// and generic responses.
+func NewOpenAPI(routes []Route, info OpenAPIInfo) *OpenAPIDoc {
+	doc := &OpenAPIDoc{
+		OpenAPI: OpenAPIVersion,
+		Info:    info,
+		Paths:   make(map[string]map[string]*Operation),
+		Components: Components{
+			Schemas: make(map[string]*Schema),
+		},
+	}
+	// component reference of given value or inline schema for unnamed
+	// types, types of different packages with the same package name are
+	// named by their full package path
+	types := make(map[string]reflect.Type)
+	ref := func(val any) *Schema {
+		t := reflect.TypeOf(val)
+		schema := SchemaOf(val)
+		name := typeName(t, false)
+		if name == "" {
+			return schema
+		}
+		if other, ok := types[name]; ok && other != elemType(t) {
+			name = typeName(t, true)
+		}
+		types[name] = elemType(t)
+		if t.Kind() == reflect.Slice {
+			doc.Components.Schemas[name] = schema.Items
+			return &Schema{Type: "array", Items: &Schema{Ref: "#/components/schemas/" + name}}
+		}
+		doc.Components.Schemas[name] = schema
+		return &Schema{Ref: "#/components/schemas/" + name}
+	}
+	for _, route := range routes {
+		path, params := openAPIPath(route.Path)
+		op := &Operation{
+			Summary:     route.Summary,
+			OperationID: operationID(route.Method, route.Path),
+			Responses:   make(map[string]ResponseObject),
+		}
+		for _, p := range params {
+			op.Parameters = append(op.Parameters, Parameter{Name: p, In: "path", Required: true, Schema: &Schema{Type: "string"}})
+		}
+		if route.Request != nil {
+			if hasBody(route.Method) {
+				op.RequestBody = &RequestBody{
+					Required: true,
+					Content:  map[string]MediaType{"application/json": {Schema: ref(route.Request)}},
+				}
+			} else {
+				op.Parameters = append(op.Parameters, queryParameters(route.Request)...)
+			}
+		}
+		resp := ResponseObject{Description: "successful response"}
+		if route.Response != nil {
+			resp.Content = map[string]MediaType{"application/json": {Schema: ref(route.Response)}}
+		}
+		op.Responses["200"] = resp
+		errResp := ResponseObject{
+			Description: "error response",
+			Content:     map[string]MediaType{"application/json": {Schema: ref(ValidationResponse{})}},
+		}
+		if route.Request != nil {
+			op.Responses["400"] = errResp
+		}
+		if route.Authorized {
+			op.Security = []map[string][]string{{"bearerAuth": {}}}
+			op.Responses["401"] = ResponseObject{Description: "unauthorized"}
+			if doc.Components.SecuritySchemes == nil {
+				doc.Components.SecuritySchemes = map[string]map[string]any{
+					"bearerAuth": {"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+				}
+			}
+		}
+		ops, ok := doc.Paths[path]
+		if !ok {
+			ops = make(map[string]*Operation)
+			doc.Paths[path] = ops
+		}
+		ops[strings.ToLower(route.Method)] = op
+	}
+	return doc
+}
+
+// helper function to build operation id from method and path
+func operationID(method, path string) string {
+	id := strings.ToLower(method)
+	for _, p := range strings.Split(path, "/") {
+		p = strings.Trim(p, ":*{}")
+		p = strings.Map(func(r rune) rune {
+			if r == '-' || r == '.' || r == '_' {
+				return -1
+			}
+			return r
+		}, p)
+		if p == "" {
+			continue
+		}
+		id += strings.ToUpper(p[:1]) + p[1:]
+	}
+	return id
+}
+
+// helper function to get JSON name of struct field and its omitempty flag,
+// empty name means the field is not serialized
+func fieldName(f reflect.StructField, tag string) string {
+	if !f.IsExported() {
+		return ""
+	}
+	name := f.Name
+	if val, ok := f.Tag.Lookup(tag); ok {
+		if val == "-" {
+			return ""
+		}
+		if n := strings.Split(val, ",")[0]; n != "" {
+			name = n
+		}
+	}
+	return name
+}
+
+// helper function to check if struct field is required
+func fieldRequired(f reflect.StructField) bool {
+	for _, v := range strings.Split(f.Tag.Get("binding"), ",") {
+		if v == "required" {
+			return true
+		}
+	}
+	return false
+}
+
+// helper function to provide schema of struct field with its tags
+func fieldSchema(f reflect.StructField, seen map[reflect.Type]bool) *Schema {
+	schema := typeSchema(f.Type, seen)
+	if desc := f.Tag.Get("doc"); desc != "" {
+		// do not modify schema shared via pointer of nested types
+		copied := *schema
+		copied.Description = desc
+		schema = &copied
+	}
+	if enum := f.Tag.Get("enum"); enum != "" {
+		copied := *schema
+		copied.Enum = strings.Split(enum, ",")
+		schema = &copied
+	}
+	return schema
+}
+
+// SchemaOf returns OpenAPI schema of given value type. Struct fields use
+// json tags for their names, binding:"required" tag for required fields,
+// doc tag for descriptions and enum tag for comma separated allowed values.
+func SchemaOf(val any) *Schema {
+	if val == nil {
+		return &Schema{}
+	}
+	return typeSchema(reflect.TypeOf(val), make(map[reflect.Type]bool))
+}
+
+// helper function to provide schema of given type, seen map protects
+// against recursive types
+func typeSchema(t reflect.Type, seen map[reflect.Type]bool) *Schema {
+	nullable := false
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+		nullable = true
+	}
+	if t == reflect.TypeOf(time.Time{}) {
+		return &Schema{Type: "string", Format: "date-time", Nullable: nullable}
+	}
+	var schema *Schema
+	switch t.Kind() {
+	case reflect.Bool:
+		schema = &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		schema = &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		schema = &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32:
+		schema = &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		schema = &Schema{Type: "number", Format: "double"}
+	case reflect.String:
+		schema = &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			schema = &Schema{Type: "string", Format: "byte"}
+		} else {
+			schema = &Schema{Type: "array", Items: typeSchema(t.Elem(), seen)}
+		}
+	case reflect.Map:
+		schema = &Schema{Type: "object", AdditionalProperties: typeSchema(t.Elem(), seen)}
+	case reflect.Struct:
+		if seen[t] {
+			return &Schema{Type: "object", Nullable: nullable}
+		}
+		seen[t] = true
+		schema = &Schema{Type: "object", Properties: make(map[string]*Schema)}
+		structSchema(t, schema, seen)
+		delete(seen, t)
+	default:
+		// interfaces represent any value
+		schema = &Schema{}
+	}
+	schema.Nullable = nullable
+	return schema
+}
+
+// helper function to fill object schema with struct fields, fields of
+// embedded structs are promoted as encoding/json does
+func structSchema(t reflect.Type, schema *Schema, seen map[reflect.Type]bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous && f.Tag.Get("json") == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				structSchema(ft, schema, seen)
+				continue
+			}
+		}
+		name := fieldName(f, "json")
+		if name == "" {
+			continue
+		}
+		schema.Properties[name] = fieldSchema(f, seen)
+		if fieldRequired(f) {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+}
+
+// helper function to provide query parameters of request structure, names
+// are taken from form tags (used by gin binding) or json tags
+func queryParameters(val any) []Parameter {
+	t := reflect.TypeOf(val)
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	var params []Parameter
+	if t.Kind() != reflect.Struct {
+		return params
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := fieldName(f, "form")
+		if _, ok := f.Tag.Lookup("form"); !ok {
+			name = fieldName(f, "json")
+		}
+		if name == "" {
+			continue
+		}
+		schema := fieldSchema(f, make(map[reflect.Type]bool))
+		params = append(params, Parameter{
+			Name:        name,
+			In:          "query",
+			Description: schema.Description,
+			Required:    fieldRequired(f),
+			Schema:      schema,
+		})
+	}
+	return params
+}
+
+// ValidationError represents single validation error of request field
+type ValidationError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Error implements error interface
+func (e ValidationError) Error() string {
+	if e.Field == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+// ValidationResponse represents response of invalid request
+type ValidationResponse struct {
+	services.ServiceResponse
+	Errors []ValidationError `json:"errors"`
+}
+
+// helper function to join field path
+func fieldPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// Validate validates decoded JSON value against the schema, numbers are
+// expected to be decoded as float64 or json.Number
+func (s *Schema) Validate(val any, path string) []ValidationError {
+	var errs []ValidationError
+	if s == nil || s.Ref != "" {
+		return errs
+	}
+	if val == nil {
+		if s.Type != "" && !s.Nullable {
+			errs = append(errs, ValidationError{Field: path, Message: fmt.Sprintf("expected %s, got null", s.Type)})
+		}
+		return errs
+	}
+	typeErr := func(got any) []ValidationError {
+		msg := fmt.Sprintf("expected %s, got %T", s.Type, got)
+		return append(errs, ValidationError{Field: path, Message: msg})
+	}
+	switch s.Type {
+	case "boolean":
+		if _, ok := val.(bool); !ok {
+			return typeErr(val)
+		}
+	case "integer", "number":
+		var num float64
+		switch v := val.(type) {
+		case float64:
+			num = v
+		case json.Number:
+			f, err := v.Float64()
+			if err != nil {
+				return typeErr(val)
+			}
+			num = f
+		default:
+			return typeErr(val)
+		}
+		if s.Type == "integer" && num != float64(int64(num)) {
+			return append(errs, ValidationError{Field: path, Message: fmt.Sprintf("expected integer, got %v", num)})
+		}
+	case "string":
+		str, ok := val.(string)
+		if !ok {
+			return typeErr(val)
+		}
+		if s.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339, str); err != nil {
+				return append(errs, ValidationError{Field: path, Message: "expected RFC3339 date-time"})
+			}
+		}
+		if len(s.Enum) > 0 && !inList(str, s.Enum) {
+			msg := fmt.Sprintf("value '%s' is not one of %s", str, strings.Join(s.Enum, ", "))
+			errs = append(errs, ValidationError{Field: path, Message: msg})
+		}
+	case "array":
+		arr, ok := utils.ListValues(val)
+		if !ok {
+			return typeErr(val)
+		}
+		for i, v := range arr {
+			errs = append(errs, s.Items.Validate(v, fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	case "object":
+		obj, ok := val.(map[string]any)
+		if !ok {
+			return typeErr(val)
+		}
+		for _, name := range s.Required {
+			if _, ok := obj[name]; !ok {
+				errs = append(errs, ValidationError{Field: fieldPath(path, name), Message: "required field is missing"})
+			}
+		}
+		keys := make([]string, 0, len(obj))
+		for k := range obj {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if prop, ok := s.Properties[k]; ok {
+				errs = append(errs, prop.Validate(obj[k], fieldPath(path, k))...)
+			} else if s.AdditionalProperties != nil {
+				errs = append(errs, s.AdditionalProperties.Validate(obj[k], fieldPath(path, k))...)
+			}
+		}
+	}
+	return errs
+}
+
+// helper function to check if value is in the list
+func inList(val string, list []string) bool {
+	for _, v := range list {
+		if v == val {
+			return true
+		}
+	}
+	return false
+}
+
+// helper function to validate query parameters
+func validateQuery(params []Parameter, r *http.Request) []ValidationError {
+	var errs []ValidationError
+	query := r.URL.Query()
+	for _, p := range params {
+		vals, ok := query[p.Name]
+		if !ok || len(vals) == 0 {
+			if p.Required {
+				errs = append(errs, ValidationError{Field: p.Name, Message: "required parameter is missing"})
+			}
+			continue
+		}
+		schema := p.Schema
+		if schema.Type != "array" {
+			vals = vals[:1]
+		} else {
+			schema = schema.Items
+		}
+		for _, v := range vals {
+			var val any = v
+			switch schema.Type {
+			case "integer", "number":
+				f, err := strconv.ParseFloat(v, 64)
+				if err != nil {
+					errs = append(errs, ValidationError{Field: p.Name, Message: fmt.Sprintf("expected %s, got '%s'", schema.Type, v)})
+					continue
+				}
+				val = f
+			case "boolean":
+				b, err := strconv.ParseBool(v)
+				if err != nil {
+					errs = append(errs, ValidationError{Field: p.Name, Message: fmt.Sprintf("expected boolean, got '%s'", v)})
+					continue
+				}
+				val = b
+			}
+			errs = append(errs, schema.Validate(val, p.Name)...)
+		}
+	}
+	return errs
+}
+
+// ValidateRequest validates HTTP request against request structure of the
+// route, request body is restored for subsequent handlers
+func ValidateRequest(route Route, r *http.Request) []ValidationError {
+	if route.Request == nil {
+		return nil
+	}
+	return newRequestValidator(route).validate(r)
+}
+
+// requestValidator validates requests against schema of request structure
+// which is generated once per route
+type requestValidator struct {
+	schema *Schema     // schema of request body
+	params []Parameter // query parameters
+}
+
+// helper function to create request validator of the route
+func newRequestValidator(route Route) *requestValidator {
+	if hasBody(route.Method) {
+		return &requestValidator{schema: SchemaOf(route.Request)}
+	}
+	return &requestValidator{params: queryParameters(route.Request)}
+}
+
+// helper function to validate HTTP request
+func (v *requestValidator) validate(r *http.Request) []ValidationError {
+	if v.schema == nil {
+		return validateQuery(v.params, r)
+	}
+	var data []byte
+	if r.Body != nil {
+		var err error
+		data, err = io.ReadAll(r.Body)
+		if err != nil {
+			return []ValidationError{{Message: fmt.Sprintf("unable to read request body, error %v", err)}}
+		}
+		r.Body = io.NopCloser(bytes.NewReader(data))
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return []ValidationError{{Message: "request body is empty"}}
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var val any
+	if err := dec.Decode(&val); err != nil {
+		return []ValidationError{{Message: fmt.Sprintf("invalid JSON, error %v", err)}}
+	}
+	return v.schema.Validate(val, "")
+}
+
+// ValidationMiddleware validates requests against request structure of
+// the route and responds with 400 and list of validation errors, request
+// schema is generated once when middleware is created
+func ValidationMiddleware(route Route) gin.HandlerFunc {
+	if route.Request == nil {
+		return func(c *gin.Context) { c.Next() }
+	}
+	validator := newRequestValidator(route)
+	return func(c *gin.Context) {
+		errs := validator.validate(c.Request)
+		if len(errs) == 0 {
+			c.Next()
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusBadRequest, validationResponse(errs))
+	}
+}
+
+// helper function to provide handlers of the route, routes with request
+// structure are validated before their handler is called
+func routeHandlers(route Route) []gin.HandlerFunc {
+	if route.Request == nil {
+		return []gin.HandlerFunc{route.Handler}
+	}
+	return []gin.HandlerFunc{ValidationMiddleware(route), route.Handler}
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type testSample struct {
+	Name     string            `json:"name" binding:"required" doc:"sample name"`
+	Beamline string            `json:"beamline" enum:"3a,1b"`
+	Runs     []int             `json:"runs"`
+	Meta     map[string]string `json:"meta,omitempty"`
+	Date     *time.Time        `json:"date,omitempty"`
+	Parent   *testSample       `json:"parent,omitempty"`
+	secret   string
+}
+
+type testQuery struct {
+	Limit int    `form:"limit" binding:"required"`
+	Sort  string `form:"sort" enum:"asc,desc"`
+}
+
+// TestSchemaOf
+func TestSchemaOf(t *testing.T) {
+	schema := SchemaOf(testSample{})
+	if schema.Type != "object" || len(schema.Properties) != 6 {
+		t.Fatalf("invalid schema %+v", schema)
+	}
+	if len(schema.Required) != 1 || schema.Required[0] != "name" {
+		t.Error("invalid required fields", schema.Required)
+	}
+	if schema.Properties["runs"].Items.Type != "integer" {
+		t.Error("invalid array schema", schema.Properties["runs"])
+	}
+	if schema.Properties["date"].Format != "date-time" || !schema.Properties["date"].Nullable {
+		t.Error("invalid date schema", schema.Properties["date"])
+	}
+	if schema.Properties["parent"].Type != "object" {
+		t.Error("invalid recursive schema", schema.Properties["parent"])
+	}
+	if schema.Properties["name"].Description != "sample name" {
+		t.Error("invalid description", schema.Properties["name"])
+	}
+}
+
+// TestSchemaValidate
+func TestSchemaValidate(t *testing.T) {
+	schema := SchemaOf(testSample{})
+	var val any
+	json.Unmarshal([]byte(`{"name":"s1","beamline":"3a","runs":[1,2]}`), &val)
+	if errs := schema.Validate(val, ""); len(errs) != 0 {
+		t.Error("unexpected errors", errs)
+	}
+	json.Unmarshal([]byte(`{"beamline":"9z","runs":[1,"2",3.5]}`), &val)
+	errs := schema.Validate(val, "")
+	if len(errs) != 4 {
+		t.Fatal("unexpected errors", errs)
+	}
+	fields := []string{"name", "beamline", "runs[1]", "runs[2]"}
+	for i, e := range errs {
+		if e.Field != fields[i] {
+			t.Errorf("invalid error field %s, expect %s", e.Field, fields[i])
+		}
+	}
+}
+
+// TestOpenAPI
+func TestOpenAPI(t *testing.T) {
+	routes := []Route{
+		{Method: "GET", Path: "/samples", Request: testQuery{}, Response: []testSample{}},
+		{Method: "POST", Path: "/samples", Authorized: true, Scope: "write", Request: testSample{}, Summary: "create sample"},
+		{Method: "GET", Path: "/samples/:name", Response: testSample{}},
+	}
+	doc := NewOpenAPI(routes, OpenAPIInfo{Title: "test", Version: "0.1"})
+	if doc.OpenAPI != OpenAPIVersion {
+		t.Error("invalid version", doc.OpenAPI)
+	}
+	get := doc.Paths["/samples"]["get"]
+	if get == nil || len(get.Parameters) != 2 || !get.Parameters[0].Required || get.Parameters[0].In != "query" {
+		t.Fatalf("invalid GET operation %+v", get)
+	}
+	post := doc.Paths["/samples"]["post"]
+	if post == nil || post.RequestBody == nil || post.Summary != "create sample" || len(post.Security) != 1 {
+		t.Fatalf("invalid POST operation %+v", post)
+	}
+	if ref := post.RequestBody.Content["application/json"].Schema.Ref; ref != "#/components/schemas/server.testSample" {
+		t.Error("invalid request body reference", ref)
+	}
+	item := doc.Paths["/samples/{name}"]["get"]
+	if item == nil || len(item.Parameters) != 1 || item.Parameters[0].In != "path" {
+		t.Fatalf("invalid path operation %+v", item)
+	}
+	if _, ok := doc.Components.Schemas["server.testSample"]; !ok {
+		t.Error("missing component schema")
+	}
+	if name := typeName(reflect.TypeOf([]*testSample{}), true); name != "github.com.CHESSComputing.golib.server.testSample" {
+		t.Error("invalid full component name", name)
+	}
+	if _, err := json.Marshal(doc); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestValidationMiddleware
+func TestValidationMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ok := func(c *gin.Context) {
+		var rec testSample
+		if err := c.ShouldBindJSON(&rec); err != nil {
+			c.JSON(http.StatusInternalServerError, err.Error())
+			return
+		}
+		c.JSON(http.StatusOK, rec)
+	}
+	post := Route{Method: "POST", Path: "/samples", Request: testSample{}, Handler: ok}
+	list := Route{Method: "GET", Path: "/samples", Request: testQuery{}, Handler: func(c *gin.Context) { c.Status(http.StatusOK) }}
+	r := gin.New()
+	r.POST(post.Path, routeHandlers(post)...)
+	r.GET(list.Path, routeHandlers(list)...)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/samples", strings.NewReader(`{"name":"s1","runs":[1]}`)))
+	if w.Code != http.StatusOK {
+		t.Error("valid request is rejected", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/samples", strings.NewReader(`{"runs":["a"]}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Fatal("invalid request is accepted", w.Code)
+	}
+	var rec ValidationResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.Errors) != 2 || rec.Errors[0].Field != "name" || rec.HttpCode != http.StatusBadRequest {
+		t.Errorf("invalid validation response %+v", rec)
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/samples?limit=x&sort=up", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Error("invalid query is accepted", w.Code)
+	}
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/samples?limit=10&sort=asc", nil))
+	if w.Code != http.StatusOK {
+		t.Error("valid query is rejected", w.Code, w.Body.String())
+	}
+}
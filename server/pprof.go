@@ -0,0 +1,88 @@
+package server
+
+// pprof module provides net/http/pprof endpoints of web server to profile
+// production services. On server port endpoints are accessible only to
+// administrators (via token or API key), separate pprof port is bound to
+// localhost only.
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	services "github.com/CHESSComputing/golib/services"
+	"github.com/gin-gonic/gin"
+)
+
+// PprofPath defines path of pprof endpoints
+const PprofPath = "/debug/pprof"
+
+// PprofMiddleware allows access to pprof endpoints only to users with one
+// of admin roles, all requests are allowed if roles are empty, e.g. on
+// localhost pprof port
+func PprofMiddleware(roles []string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if len(roles) == 0 {
+			c.Next()
+			return
+		}
+		claims := adminClaims(c, roles)
+		if claims == nil {
+			err := errors.New("pprof endpoints are accessible only to administrators")
+			rec := services.Response("server", http.StatusForbidden, services.PolicyError, err)
+			c.AbortWithStatusJSON(http.StatusForbidden, rec)
+			return
+		}
+		log.Printf("pprof %s is requested by %s", c.Request.URL.Path, claims.CustomClaims.User)
+		c.Next()
+	}
+}
+
+// PprofHandler provides pprof endpoints, e.g. /debug/pprof/heap or
+// /debug/pprof/profile?seconds=30
+func PprofHandler(c *gin.Context) {
+	switch strings.TrimPrefix(c.Param("profile"), "/") {
+	case "cmdline":
+		pprof.Cmdline(c.Writer, c.Request)
+	case "profile":
+		pprof.Profile(c.Writer, c.Request)
+	case "symbol":
+		pprof.Symbol(c.Writer, c.Request)
+	case "trace":
+		pprof.Trace(c.Writer, c.Request)
+	default:
+		// index page and named profiles, e.g. heap or goroutine
+		pprof.Index(c.Writer, c.Request)
+	}
+}
+
+// helper function to register pprof endpoints with given middleware
+func registerPprof(r gin.IRoutes, middleware gin.HandlerFunc) {
+	r.GET(PprofPath+"/*profile", middleware, PprofHandler)
+	r.POST(PprofPath+"/symbol", middleware, gin.WrapF(pprof.Symbol))
+}
+
+// PprofServer returns HTTP server of pprof endpoints bound to localhost
+// pprof port, administrators roles are checked if they are configured
+func PprofServer(cfg srvConfig.Pprof) *http.Server {
+	r := gin.New()
+	r.Use(gin.Recovery())
+	registerPprof(r, PprofMiddleware(cfg.AdminRoles))
+	return &http.Server{
+		Addr:    fmt.Sprintf("127.0.0.1:%d", cfg.Port),
+		Handler: r,
+	}
+}
+
+// StartPprofServer starts pprof server on localhost pprof port
+func StartPprofServer(cfg srvConfig.Pprof) {
+	srv := PprofServer(cfg)
+	log.Println("Start pprof server on", srv.Addr)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Printf("ERROR: pprof server on %s is stopped, error %v", srv.Addr, err)
+	}
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	srvConfig "github.com/CHESSComputing/golib/config"
+	"github.com/gin-gonic/gin"
+)
+
+// TestPprof
+func TestPprof(t *testing.T) {
+	config := srvConfig.Config
+	defer func() { srvConfig.Config = config }()
+	srvConfig.Config = &srvConfig.SrvConfig{}
+	srvConfig.Config.Authz.ClientID = "secret"
+	gin.SetMode(gin.TestMode)
+
+	webServer := srvConfig.WebServer{Pprof: srvConfig.Pprof{Enabled: true, AdminRoles: []string{"foxden-admin"}}}
+	r := Router(nil, nil, "", webServer)
+	serve := func(path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	admin, _ := authz.JWTAccessToken("secret", 60, authz.CustomClaims{User: "admin", Scope: "read", Roles: []string{"foxden-admin"}})
+	user, _ := authz.JWTAccessToken("secret", 60, authz.CustomClaims{User: "alice", Scope: "read"})
+	if w := serve(PprofPath+"/", ""); w.Code != http.StatusForbidden {
+		t.Errorf("pprof is accessible without token, code %d", w.Code)
+	}
+	if w := serve(PprofPath+"/heap", user); w.Code != http.StatusForbidden {
+		t.Errorf("pprof is accessible to user, code %d", w.Code)
+	}
+	if w := serve(PprofPath+"/", admin); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "goroutine") {
+		t.Errorf("pprof index is not accessible to admin, code %d", w.Code)
+	}
+	if w := serve(PprofPath+"/goroutine?debug=1", admin); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "goroutine profile") {
+		t.Errorf("goroutine profile is not accessible to admin, code %d", w.Code)
+	}
+	if w := serve(PprofPath+"/cmdline", admin); w.Code != http.StatusOK || w.Body.Len() == 0 {
+		t.Errorf("cmdline is not accessible to admin, code %d", w.Code)
+	}
+
+	// pprof endpoints without admin roles are not registered on server port
+	r = Router(nil, nil, "", srvConfig.WebServer{Pprof: srvConfig.Pprof{Enabled: true}})
+	for _, route := range r.Routes() {
+		if strings.HasPrefix(route.Path, PprofPath) {
+			t.Errorf("pprof is registered without admin roles, route %s", route.Path)
+		}
+	}
+
+	// localhost pprof server
+	srv := PprofServer(srvConfig.Pprof{Enabled: true, Port: 8399})
+	if host, _, _ := net.SplitHostPort(srv.Addr); host != "127.0.0.1" {
+		t.Errorf("pprof server is not bound to localhost %s", srv.Addr)
+	}
+	w := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(w, httptest.NewRequest("GET", PprofPath+"/heap?debug=1", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "heap profile") {
+		t.Errorf("heap profile is not served by pprof server, code %d", w.Code)
+	}
+}
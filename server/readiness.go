@@ -0,0 +1,90 @@
+package server
+
+// readiness module provides readiness endpoint of the server used by load
+// balancers and orchestrators, e.g. Kubernetes readiness probes. Server is
+// ready if its MongoDB connection, redis server and additional readiness
+// checks are available.
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	mongo "github.com/CHESSComputing/golib/mongo"
+	redis "github.com/CHESSComputing/golib/redis"
+	"github.com/gin-gonic/gin"
+)
+
+// ReadyzPath defines path of readiness endpoint
+const ReadyzPath = "/readyz"
+
+// ReadinessTimeout defines timeout of single readiness check
+var ReadinessTimeout = 2 * time.Second
+
+// ReadinessChecks represents additional checks of readiness endpoint, e.g.
+// services may add dependencies on other services
+var ReadinessChecks []Dependency
+
+// Readiness represents response of readiness endpoint
+type Readiness struct {
+	Ready   bool              `json:"ready"`             // server can serve requests
+	Checks  map[string]string `json:"checks"`            // results of checks, ok or error message
+	MongoDB *mongo.Status     `json:"mongodb,omitempty"` // status of MongoDB connection supervisor
+}
+
+// helper function to check readiness of MongoDB connection, state of
+// supervised connection is used, otherwise server is pinged
+func mongoReadiness(ctx context.Context) (*mongo.Status, error) {
+	client := mongo.Mongo.Connect()
+	sup := mongo.Mongo.Supervisor
+	if sup == nil {
+		return nil, client.Ping(ctx, nil)
+	}
+	status := sup.Status()
+	if status.Ready() {
+		return &status, nil
+	}
+	if status.LastError != "" {
+		return &status, fmt.Errorf("%w: connection is %s, last error %s", mongo.ErrUnavailable, status.State, status.LastError)
+	}
+	return &status, fmt.Errorf("%w: connection is %s", mongo.ErrUnavailable, status.State)
+}
+
+// Ready returns readiness of the server
+func Ready(ctx context.Context) Readiness {
+	rec := Readiness{Ready: true, Checks: make(map[string]string)}
+	check := func(name string, err error) {
+		if err != nil {
+			rec.Ready = false
+			rec.Checks[name] = err.Error()
+			return
+		}
+		rec.Checks[name] = "ok"
+	}
+	ctx, cancel := context.WithTimeout(ctx, ReadinessTimeout)
+	defer cancel()
+	if mongo.Mongo.URI != "" {
+		status, err := mongoReadiness(ctx)
+		rec.MongoDB = status
+		check("mongodb", err)
+	}
+	if redis.Redis != nil {
+		check("redis", redis.Redis.Ping(ctx))
+	}
+	for _, dep := range ReadinessChecks {
+		check(dep.Name, dep.Check(ctx))
+	}
+	return rec
+}
+
+// ReadyzHandler provides readiness of the server, it answers with 503 code
+// if server is not ready
+func ReadyzHandler(c *gin.Context) {
+	rec := Ready(c.Request.Context())
+	if !rec.Ready {
+		c.JSON(http.StatusServiceUnavailable, rec)
+		return
+	}
+	c.JSON(http.StatusOK, rec)
+}
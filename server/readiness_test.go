@@ -0,0 +1,70 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	mongo "github.com/CHESSComputing/golib/mongo"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/event"
+	mongoDriver "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/description"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TestReadyz
+func TestReadyz(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	conn, checks := mongo.Mongo, ReadinessChecks
+	defer func() { mongo.Mongo, ReadinessChecks = conn, checks }()
+
+	// client is not connected and is used only to avoid connection attempts
+	client, err := mongoDriver.NewClient(options.Client().ApplyURI("mongodb://localhost:27017"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sup := mongo.NewSupervisor(srvConfig.MongoSupervisor{})
+	mongo.Mongo = mongo.Connection{URI: "mongodb://localhost:27017", Client: client, Supervisor: sup}
+	ReadinessChecks = []Dependency{{Name: "authz", Check: func(ctx context.Context) error { return nil }}}
+
+	r := gin.New()
+	r.GET(ReadyzPath, ReadyzHandler)
+	serve := func() (int, Readiness) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", ReadyzPath, nil))
+		var rec Readiness
+		if err := json.Unmarshal(w.Body.Bytes(), &rec); err != nil {
+			t.Fatal(err)
+		}
+		return w.Code, rec
+	}
+
+	// no topology information yet
+	if code, rec := serve(); code != http.StatusServiceUnavailable || rec.Ready || rec.MongoDB == nil ||
+		!strings.Contains(rec.Checks["mongodb"], "unknown") || rec.Checks["authz"] != "ok" {
+		t.Errorf("wrong readiness %d %+v", code, rec)
+	}
+
+	// topology events of the driver
+	topo := description.Topology{Servers: []description.Server{{Addr: "localhost:27017", Kind: description.Standalone}}}
+	sup.ServerMonitor().TopologyDescriptionChanged(&event.TopologyDescriptionChangedEvent{NewDescription: topo})
+	if code, rec := serve(); code != http.StatusOK || !rec.Ready || rec.MongoDB.State != mongo.StateConnected {
+		t.Errorf("wrong readiness %d %+v", code, rec)
+	}
+	if out := promMongoMetrics("foxden_mongodb", sup.Status()); !strings.Contains(out, "foxden_mongodb_up 1\n") ||
+		!strings.Contains(out, "foxden_mongodb_available_servers 1\n") {
+		t.Errorf("wrong metrics\n%s", out)
+	}
+
+	// failed readiness check
+	ReadinessChecks = append(ReadinessChecks, Dependency{Name: "schema", Check: func(ctx context.Context) error { return errors.New("no schema") }})
+	if code, rec := serve(); code != http.StatusServiceUnavailable || rec.Checks["schema"] != "no schema" || rec.Checks["mongodb"] != "ok" {
+		t.Errorf("wrong readiness %d %+v", code, rec)
+	}
+}
@@ -0,0 +1,59 @@
+package server
+
+// recovery module provides recovery middleware which catches panics of
+// handlers, logs them with request context and answers with standard JSON
+// error instead of closing the connection
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+
+	audit "github.com/CHESSComputing/golib/audit"
+	services "github.com/CHESSComputing/golib/services"
+	"github.com/gin-gonic/gin"
+)
+
+// TotalPanics represents total number of recovered handler panics
+var TotalPanics uint64
+
+// RecoveryMiddleware recovers panics of handlers. Panic is logged with
+// request method, path, user, request ID and stack, counted in TotalPanics
+// and client gets 500 code with JSON error if response is not written yet.
+func RecoveryMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			// aborted handlers should be handled by http server
+			if err, ok := rec.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+				panic(rec)
+			}
+			atomic.AddUint64(&TotalPanics, 1)
+			rid := audit.RequestID(c)
+			log.Printf("ERROR: panic in %s %s, request %s, user '%s', error %v\n%s",
+				c.Request.Method, c.Request.URL.Path, rid, c.GetString("user"), rec, debug.Stack())
+			if c.Writer.Written() {
+				c.Abort()
+				return
+			}
+			// stack is already logged, therefore services.Response is not used
+			resp := services.ServiceResponse{
+				HttpCode:  http.StatusInternalServerError,
+				SrvCode:   services.PanicError,
+				Service:   "server",
+				Status:    "error",
+				Error:     fmt.Sprintf("internal server error, request %s", rid),
+				Timestamp: time.Now().String(),
+			}
+			c.AbortWithStatusJSON(http.StatusInternalServerError, resp)
+		}()
+		c.Next()
+	}
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	services "github.com/CHESSComputing/golib/services"
+	"github.com/gin-gonic/gin"
+)
+
+// TestRecoveryMiddleware
+func TestRecoveryMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	routes := []Route{
+		{Method: "GET", Path: "/panic", Handler: func(c *gin.Context) {
+			var m map[string]int
+			m["key"] = 1
+		}},
+		{Method: "GET", Path: "/partial", Handler: func(c *gin.Context) {
+			c.String(http.StatusOK, "partial")
+			panic("failure after response")
+		}},
+		{Method: "GET", Path: "/abort", Handler: func(c *gin.Context) {
+			panic(http.ErrAbortHandler)
+		}},
+	}
+	r := Router(routes, nil, "", srvConfig.WebServer{})
+	panics := atomic.LoadUint64(&TotalPanics)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/panic", nil)
+	req.Header.Set("X-Request-ID", "rid-1")
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusInternalServerError || w.Header().Get("X-Request-ID") != "rid-1" {
+		t.Fatalf("wrong response %d %v", w.Code, w.Header())
+	}
+	var resp services.ServiceResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.SrvCode != services.PanicError || resp.Status != "error" || !strings.Contains(resp.Error, "rid-1") {
+		t.Errorf("wrong error response %+v", resp)
+	}
+
+	// response is not changed if it is already written
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/partial", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "partial" {
+		t.Errorf("written response is changed %d %s", w.Code, w.Body.String())
+	}
+	if n := atomic.LoadUint64(&TotalPanics) - panics; n != 2 {
+		t.Errorf("expect 2 recovered panics, got %d", n)
+	}
+
+	// aborted handler is propagated to http server
+	func() {
+		defer func() {
+			if rec := recover(); rec != http.ErrAbortHandler {
+				t.Errorf("aborted handler is recovered %v", rec)
+			}
+		}()
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/abort", nil))
+	}()
+}
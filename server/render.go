@@ -0,0 +1,290 @@
+package server
+
+// render module provides html/template rendering helpers shared by
+// FOXDEN/CHESS frontends. Templates are loaded from static directory or
+// embedded filesystem, pages are composed with layouts and partials and
+// re-loaded on every request in test mode.
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	srvConfig "github.com/CHESSComputing/golib/config"
+	"github.com/gin-gonic/gin"
+)
+
+// Renderer represents set of html templates
+type Renderer struct {
+	FS       fs.FS            // filesystem with templates
+	Dir      string           // templates directory within filesystem
+	Partials []string         // glob patterns of layouts and partials shared by all pages
+	Funcs    template.FuncMap // template functions, see TemplateFuncs
+	Reload   bool             // reload templates on every render, e.g. in test mode
+
+	mu    sync.Mutex
+	base  *template.Template
+	pages map[string]*template.Template
+}
+
+// NewRenderer returns renderer of templates located in templates directory
+// of given filesystem
+func NewRenderer(fsys fs.FS, reload bool) *Renderer {
+	return &Renderer{
+		FS:       fsys,
+		Dir:      "templates",
+		Partials: []string{"layouts/*.tmpl", "partials/*.tmpl"},
+		Funcs:    TemplateFuncs(),
+		Reload:   reload,
+	}
+}
+
+// FrontendRenderer returns renderer for frontend configuration. Templates
+// are loaded from StaticDir if it exists, otherwise from embedded
+// filesystem, and re-loaded on every request in test mode.
+func FrontendRenderer(cfg srvConfig.Frontend, embedded fs.FS) *Renderer {
+	fsys := embedded
+	if cfg.StaticDir != "" {
+		if fi, err := os.Stat(cfg.StaticDir); err == nil && fi.IsDir() {
+			fsys = os.DirFS(cfg.StaticDir)
+		} else if embedded != nil {
+			if sub, err := fs.Sub(embedded, cfg.StaticDir); err == nil {
+				fsys = sub
+			}
+		}
+	}
+	return NewRenderer(fsys, cfg.TestMode)
+}
+
+// helper function to load layouts and partials, it should be called
+// under lock
+func (r *Renderer) load() error {
+	if r.FS == nil {
+		return errors.New("renderer filesystem is not set")
+	}
+	base := template.New("").Funcs(r.Funcs)
+	for _, pattern := range r.Partials {
+		files, err := fs.Glob(r.FS, path.Join(r.Dir, pattern))
+		if err != nil {
+			return err
+		}
+		if len(files) == 0 {
+			continue
+		}
+		if base, err = base.ParseFS(r.FS, files...); err != nil {
+			return err
+		}
+	}
+	r.base = base
+	r.pages = make(map[string]*template.Template)
+	return nil
+}
+
+// Template returns template of given page composed with layouts and
+// partials
+func (r *Renderer) Template(page string) (*template.Template, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.base == nil || r.Reload {
+		if err := r.load(); err != nil {
+			return nil, err
+		}
+	}
+	if tmpl, ok := r.pages[page]; ok {
+		return tmpl, nil
+	}
+	tmpl, err := r.base.Clone()
+	if err != nil {
+		return nil, err
+	}
+	fname := path.Join(r.Dir, page)
+	data, err := fs.ReadFile(r.FS, fname)
+	if err != nil {
+		return nil, err
+	}
+	// page template is named after the page, it may include layout and
+	// re-define its blocks
+	if tmpl, err = tmpl.New(page).Parse(string(data)); err != nil {
+		return nil, fmt.Errorf("unable to parse template %s, error %v", fname, err)
+	}
+	r.pages[page] = tmpl
+	return tmpl, nil
+}
+
+// Render renders given page with data
+func (r *Renderer) Render(w io.Writer, page string, data any) error {
+	tmpl, err := r.Template(page)
+	if err != nil {
+		return err
+	}
+	// render into buffer to avoid partial pages on errors
+	buf := new(bytes.Buffer)
+	if err := tmpl.ExecuteTemplate(buf, page, data); err != nil {
+		return err
+	}
+	_, err = buf.WriteTo(w)
+	return err
+}
+
+// HTML renders given page as HTTP response with provided status code
+func (r *Renderer) HTML(c *gin.Context, code int, page string, data any) {
+	buf := new(bytes.Buffer)
+	if err := r.Render(buf, page, data); err != nil {
+		c.String(http.StatusInternalServerError, fmt.Sprintf("unable to render %s, error %v", page, err))
+		return
+	}
+	c.Data(code, "text/html; charset=utf-8", buf.Bytes())
+}
+
+// TemplateFuncs returns common template functions:
+//   - bytes formats size in bytes, e.g. 1.5 MB
+//   - time formats time (time.Time or unix seconds) with optional layout
+//   - since provides elapsed time since given time
+//   - user provides user display name from token claims
+//   - asset provides URL of static asset with cache busting
+//   - join, lower, upper, contains are strings functions
+//   - dict builds map from key-value pairs, e.g. to pass data to partials
+func TemplateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"bytes":    FormatBytes,
+		"time":     FormatTime,
+		"since":    FormatSince,
+		"user":     UserDisplayName,
+		"asset":    AssetURL,
+		"join":     strings.Join,
+		"lower":    strings.ToLower,
+		"upper":    strings.ToUpper,
+		"contains": strings.Contains,
+		"dict":     dict,
+	}
+}
+
+// FormatBytes provides human readable representation of size in bytes
+func FormatBytes(size any) string {
+	var val float64
+	switch v := size.(type) {
+	case int:
+		val = float64(v)
+	case int32:
+		val = float64(v)
+	case int64:
+		val = float64(v)
+	case uint64:
+		val = float64(v)
+	case float64:
+		val = v
+	default:
+		return fmt.Sprintf("%v", size)
+	}
+	units := []string{"B", "KB", "MB", "GB", "TB", "PB"}
+	idx := 0
+	for val >= 1024 && idx < len(units)-1 {
+		val /= 1024
+		idx++
+	}
+	if idx == 0 {
+		return fmt.Sprintf("%d %s", int64(val), units[idx])
+	}
+	return fmt.Sprintf("%.1f %s", val, units[idx])
+}
+
+// helper function to convert value to time
+func toTime(val any) (time.Time, bool) {
+	switch v := val.(type) {
+	case time.Time:
+		return v, true
+	case *time.Time:
+		if v != nil {
+			return *v, true
+		}
+	case int64:
+		return time.Unix(v, 0), true
+	case int:
+		return time.Unix(int64(v), 0), true
+	case float64:
+		return time.Unix(int64(v), 0), true
+	}
+	return time.Time{}, false
+}
+
+// FormatTime formats given time or unix seconds with optional layout, by
+// default time.RFC3339 is used
+func FormatTime(val any, layout ...string) string {
+	t, ok := toTime(val)
+	if !ok {
+		return fmt.Sprintf("%v", val)
+	}
+	if t.IsZero() {
+		return ""
+	}
+	if len(layout) > 0 {
+		return t.Format(layout[0])
+	}
+	return t.Format(time.RFC3339)
+}
+
+// FormatSince provides elapsed time since given time rounded to seconds
+func FormatSince(val any) string {
+	t, ok := toTime(val)
+	if !ok {
+		return fmt.Sprintf("%v", val)
+	}
+	return time.Since(t).Round(time.Second).String()
+}
+
+// UserDisplayName provides user name from token claims
+func UserDisplayName(val any) string {
+	switch v := val.(type) {
+	case *authz.Claims:
+		if v != nil && v.CustomClaims.User != "" {
+			return v.CustomClaims.User
+		}
+	case authz.Claims:
+		if v.CustomClaims.User != "" {
+			return v.CustomClaims.User
+		}
+	case authz.CustomClaims:
+		if v.User != "" {
+			return v.User
+		}
+	case string:
+		if v != "" {
+			return v
+		}
+	}
+	return "anonymous"
+}
+
+// AssetURL provides URL of static asset served by the Router
+func AssetURL(name string) string {
+	if StaticAssets == nil {
+		return name
+	}
+	return StaticAssets.URL(name)
+}
+
+// helper function to build map from key-value pairs
+func dict(pairs ...any) (map[string]any, error) {
+	if len(pairs)%2 != 0 {
+		return nil, errors.New("dict requires even number of arguments")
+	}
+	out := make(map[string]any, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		key, ok := pairs[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("dict key %v is not a string", pairs[i])
+		}
+		out[key] = pairs[i+1]
+	}
+	return out, nil
+}
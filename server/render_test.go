@@ -0,0 +1,85 @@
+package server
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	srvConfig "github.com/CHESSComputing/golib/config"
+)
+
+// TestRenderer
+func TestRenderer(t *testing.T) {
+	fsys := fstest.MapFS{
+		"templates/layouts/base.tmpl":   {Data: []byte(`{{define "base"}}<html>{{block "content" .}}default{{end}}</html>{{end}}`)},
+		"templates/partials/user.tmpl":  {Data: []byte(`{{define "user"}}<b>{{user .}}</b>{{end}}`)},
+		"templates/index.tmpl":          {Data: []byte(`{{template "base" .}}{{define "content"}}{{template "user" .Claims}} {{bytes .Size}}{{end}}`)},
+		"templates/plain.tmpl":          {Data: []byte(`{{template "base" .}}`)},
+		"templates/broken.tmpl":         {Data: []byte(`{{.Missing`)},
+		"templates/partials/other.tmpl": {Data: []byte(`{{define "other"}}{{with dict "a" 1}}{{.a}}{{end}}{{end}}`)},
+	}
+	r := NewRenderer(fsys, false)
+	claims := &authz.Claims{CustomClaims: authz.CustomClaims{User: "alice"}}
+	var buf bytes.Buffer
+	if err := r.Render(&buf, "index.tmpl", map[string]any{"Claims": claims, "Size": 1536}); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "<html><b>alice</b> 1.5 KB</html>" {
+		t.Errorf("wrong page %q", buf.String())
+	}
+	buf.Reset()
+	// pages should not share re-defined blocks
+	r.Render(&buf, "plain.tmpl", nil)
+	if buf.String() != "<html>default</html>" {
+		t.Errorf("wrong page %q", buf.String())
+	}
+	if err := r.Render(&buf, "broken.tmpl", nil); err == nil {
+		t.Error("broken template should fail")
+	}
+}
+
+// TestRendererReload
+func TestRendererReload(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "templates"), 0755)
+	fname := filepath.Join(dir, "templates", "page.tmpl")
+	os.WriteFile(fname, []byte("v1"), 0644)
+	cfg := srvConfig.Frontend{TestMode: true}
+	cfg.StaticDir = dir
+	r := FrontendRenderer(cfg, nil)
+	var buf bytes.Buffer
+	r.Render(&buf, "page.tmpl", nil)
+	os.WriteFile(fname, []byte("v2"), 0644)
+	r.Render(&buf, "page.tmpl", nil)
+	if buf.String() != "v1v2" {
+		t.Errorf("template is not reloaded %q", buf.String())
+	}
+}
+
+// TestTemplateFuncs
+func TestTemplateFuncs(t *testing.T) {
+	sizes := map[any]string{0: "0 B", int64(1023): "1023 B", 1024 * 1024 * 3: "3.0 MB", "n/a": "n/a"}
+	for size, expect := range sizes {
+		if val := FormatBytes(size); val != expect {
+			t.Errorf("size %v: expect %s got %s", size, expect, val)
+		}
+	}
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	if val := FormatTime(ts.Unix(), "2006-01-02"); val != "2024-01-02" {
+		t.Errorf("wrong time %s", val)
+	}
+	if val := FormatTime(ts); !strings.HasPrefix(val, "2024-01-02T03:04:05") {
+		t.Errorf("wrong time %s", val)
+	}
+	if UserDisplayName(nil) != "anonymous" || UserDisplayName(authz.CustomClaims{User: "bob"}) != "bob" {
+		t.Error("wrong user display name")
+	}
+	if _, err := dict("a"); err == nil {
+		t.Error("dict with odd arguments should fail")
+	}
+}
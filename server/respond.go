@@ -0,0 +1,349 @@
+package server
+
+// respond module provides content negotiation of HTTP responses, payload is
+// encoded as JSON, XML, NDJSON or msgpack based on Accept HTTP header
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	utils "github.com/CHESSComputing/golib/utils"
+	"github.com/ugorji/go/codec"
+)
+
+// content types supported by Respond
+const (
+	XMLContentType      = "application/xml"
+	TextXMLContentType  = "text/xml"
+	MsgpackContentType  = "application/msgpack"
+	XMsgpackContentType = "application/x-msgpack"
+)
+
+// XMLRootName defines name of XML root element of responses, list
+// elements are wrapped into XMLItemName elements
+var XMLRootName = "response"
+
+// XMLItemName defines name of XML elements of list items
+var XMLItemName = "item"
+
+// RespondOffers defines content types offered by Respond in order of
+// preference, the first one is used if client does not provide Accept header
+var RespondOffers = []string{
+	JSONContentType,
+	XMLContentType,
+	TextXMLContentType,
+	NDJSONContentType,
+	MsgpackContentType,
+	XMsgpackContentType,
+}
+
+// helper function to check pretty query flag, e.g. ?pretty or ?pretty=true
+func prettyFlag(r *http.Request) bool {
+	if r == nil || r.URL == nil {
+		return false
+	}
+	vals, ok := r.URL.Query()["pretty"]
+	if !ok {
+		return false
+	}
+	if len(vals) == 0 || vals[0] == "" {
+		return true
+	}
+	flag, err := strconv.ParseBool(vals[0])
+	return err == nil && flag
+}
+
+// Respond encodes given payload with content type negotiated via Accept
+// HTTP header and writes it with 200 status code
+func Respond(w http.ResponseWriter, r *http.Request, v any) error {
+	return RespondWithStatus(w, r, http.StatusOK, v)
+}
+
+// RespondWithStatus encodes given payload with content type negotiated via
+// Accept HTTP header and writes it with given status code. Clients which
+// do not accept any of supported content types get 406 Not Acceptable.
+func RespondWithStatus(w http.ResponseWriter, r *http.Request, code int, v any) error {
+	accept := ""
+	if r != nil {
+		accept = r.Header.Get("Accept")
+	}
+	ctype := NegotiateContentType(accept, RespondOffers)
+	if ctype == "" {
+		http.Error(w, ErrNotAcceptable.Error(), http.StatusNotAcceptable)
+		return ErrNotAcceptable
+	}
+	data, err := EncodePayload(ctype, v, prettyFlag(r))
+	if err != nil {
+		log.Printf("ERROR: unable to encode payload as %s, error %v", ctype, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+	w.Header().Set("Content-Type", ctype)
+	w.Header().Add("Vary", "Accept")
+	w.WriteHeader(code)
+	_, err = w.Write(data)
+	return err
+}
+
+// EncodePayload encodes payload with given content type
+func EncodePayload(ctype string, v any, pretty bool) ([]byte, error) {
+	switch ctype {
+	case JSONContentType:
+		if pretty {
+			data, err := json.MarshalIndent(v, "", "  ")
+			return append(data, '\n'), err
+		}
+		data, err := json.Marshal(v)
+		return append(data, '\n'), err
+	case NDJSONContentType:
+		return encodeNDJSON(v)
+	case XMLContentType, TextXMLContentType:
+		return encodeXML(v, pretty)
+	case MsgpackContentType, XMsgpackContentType:
+		return encodeMsgpack(v)
+	}
+	return nil, fmt.Errorf("unsupported content type %s", ctype)
+}
+
+// helper function to encode payload as new-line delimited JSON, lists
+// are encoded as one record per line
+func encodeNDJSON(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	rv := reflect.ValueOf(v)
+	if v != nil && (rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array) && rv.Type().Elem().Kind() != reflect.Uint8 {
+		for i := 0; i < rv.Len(); i++ {
+			if err := enc.Encode(rv.Index(i).Interface()); err != nil {
+				return nil, err
+			}
+		}
+		return buf.Bytes(), nil
+	}
+	err := enc.Encode(v)
+	return buf.Bytes(), err
+}
+
+// helper function to encode payload as msgpack
+func encodeMsgpack(v any) ([]byte, error) {
+	// use JSON representation of the payload to respect json tags of
+	// structures and produce the same keys as JSON responses
+	val, err := genericValue(v)
+	if err != nil {
+		return nil, err
+	}
+	var handle codec.MsgpackHandle
+	handle.WriteExt = true
+	var data []byte
+	err = codec.NewEncoderBytes(&data, &handle).Encode(val)
+	return data, err
+}
+
+// helper function to convert payload to generic value via its JSON
+// representation, numbers are kept as json.Number
+func genericValue(v any) (any, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var val any
+	err = dec.Decode(&val)
+	return numberValues(val), err
+}
+
+// helper function to convert json.Number values into int64 or float64
+func numberValues(val any) any {
+	switch v := val.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]any:
+		for k, e := range v {
+			v[k] = numberValues(e)
+		}
+	default:
+		if list, ok := utils.ListValues(val); ok {
+			for i, e := range list {
+				list[i] = numberValues(e)
+			}
+			return list
+		}
+	}
+	return val
+}
+
+// helper function to encode payload as XML. Values implementing
+// xml.Marshaler, structures and lists of structures are encoded by
+// encoding/xml, list items are wrapped into XMLItemName elements of
+// XMLRootName element. Other values, e.g. map records, are converted via
+// their JSON representation: objects become elements named by their keys
+// and list items become XMLItemName elements.
+func encodeXML(v any, pretty bool) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	if kind := xmlKind(v); kind != reflect.Invalid {
+		err := marshalXML(&buf, v, kind, pretty)
+		var uerr *xml.UnsupportedTypeError
+		if !errors.As(err, &uerr) {
+			if err != nil {
+				return nil, err
+			}
+			buf.WriteString("\n")
+			return buf.Bytes(), nil
+		}
+		// structures with values not supported by encoding/xml, e.g. maps,
+		// are converted via their JSON representation
+		buf.Reset()
+		buf.WriteString(xml.Header)
+	}
+	val, err := genericValue(v)
+	if err != nil {
+		return nil, err
+	}
+	indent := ""
+	if pretty {
+		indent = "  "
+	}
+	writeXML(&buf, XMLRootName, val, indent, 0)
+	buf.WriteString("\n")
+	return buf.Bytes(), nil
+}
+
+// helper function to encode structure or list of structures by
+// encoding/xml
+func marshalXML(buf *bytes.Buffer, v any, kind reflect.Kind, pretty bool) error {
+	enc := xml.NewEncoder(buf)
+	if pretty {
+		enc.Indent("", "  ")
+	}
+	if kind == reflect.Struct {
+		return enc.Encode(v)
+	}
+	root := xml.StartElement{Name: xml.Name{Local: XMLRootName}}
+	if err := enc.EncodeToken(root); err != nil {
+		return err
+	}
+	rv := reflect.ValueOf(v)
+	for i := 0; i < rv.Len(); i++ {
+		item := xml.StartElement{Name: xml.Name{Local: XMLItemName}}
+		if err := enc.EncodeElement(rv.Index(i).Interface(), item); err != nil {
+			return err
+		}
+	}
+	if err := enc.EncodeToken(root.End()); err != nil {
+		return err
+	}
+	return enc.Flush()
+}
+
+// helper function to check if value can be encoded by encoding/xml, it
+// returns reflect.Struct for xml.Marshaler and structures, reflect.Slice
+// for lists of them and reflect.Invalid for other values
+func xmlKind(v any) reflect.Kind {
+	if _, ok := v.(xml.Marshaler); ok {
+		return reflect.Struct
+	}
+	if v == nil {
+		return reflect.Invalid
+	}
+	isStruct := func(t reflect.Type) bool {
+		if t.Implements(reflect.TypeOf((*xml.Marshaler)(nil)).Elem()) {
+			return true
+		}
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		return t.Kind() == reflect.Struct
+	}
+	t := reflect.TypeOf(v)
+	if isStruct(t) {
+		return reflect.Struct
+	}
+	if (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) && isStruct(t.Elem()) {
+		return reflect.Slice
+	}
+	return reflect.Invalid
+}
+
+// XMLName converts key into valid XML element name, invalid characters
+// are replaced by underscore
+func XMLName(key string) string {
+	var sb strings.Builder
+	for i, r := range key {
+		valid := unicode.IsLetter(r) || r == '_' ||
+			(i > 0 && (unicode.IsDigit(r) || r == '-' || r == '.'))
+		if !valid {
+			if i == 0 && (unicode.IsDigit(r) || r == '-' || r == '.') {
+				sb.WriteRune('_')
+				sb.WriteRune(r)
+				continue
+			}
+			r = '_'
+		}
+		sb.WriteRune(r)
+	}
+	name := sb.String()
+	if name == "" || strings.HasPrefix(strings.ToLower(name), "xml") {
+		name = "_" + name
+	}
+	return name
+}
+
+// helper function to write generic value as XML element
+func writeXML(buf *bytes.Buffer, name string, val any, indent string, level int) {
+	pad := ""
+	newline := ""
+	if indent != "" {
+		pad = strings.Repeat(indent, level)
+		newline = "\n"
+	}
+	switch v := val.(type) {
+	case nil:
+		fmt.Fprintf(buf, "%s<%s/>", pad, name)
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		fmt.Fprintf(buf, "%s<%s>", pad, name)
+		for _, k := range keys {
+			buf.WriteString(newline)
+			writeXML(buf, XMLName(k), v[k], indent, level+1)
+		}
+		if len(keys) > 0 {
+			buf.WriteString(newline + pad)
+		}
+		fmt.Fprintf(buf, "</%s>", name)
+	default:
+		if list, ok := utils.ListValues(v); ok {
+			fmt.Fprintf(buf, "%s<%s>", pad, name)
+			for _, e := range list {
+				buf.WriteString(newline)
+				writeXML(buf, XMLItemName, e, indent, level+1)
+			}
+			if len(list) > 0 {
+				buf.WriteString(newline + pad)
+			}
+			fmt.Fprintf(buf, "</%s>", name)
+			return
+		}
+		fmt.Fprintf(buf, "%s<%s>", pad, name)
+		xml.EscapeText(buf, []byte(fmt.Sprintf("%v", v)))
+		fmt.Fprintf(buf, "</%s>", name)
+	}
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ugorji/go/codec"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type testRecord struct {
+	Did  string   `json:"did" xml:"did"`
+	Runs []int    `json:"runs" xml:"runs>run"`
+	Tags []string `json:"tags,omitempty" xml:"tags,omitempty"`
+}
+
+// helper function to perform request with given accept header
+func respond(t *testing.T, accept, query string, v any) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", "/records"+query, nil)
+	if accept != "" {
+		r.Header.Set("Accept", accept)
+	}
+	w := httptest.NewRecorder()
+	Respond(w, r, v)
+	return w
+}
+
+// TestRespondJSON
+func TestRespondJSON(t *testing.T) {
+	recs := []testRecord{{Did: "/beamline=3a", Runs: []int{1, 2}}}
+	w := respond(t, "", "", recs)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != JSONContentType {
+		t.Fatal("invalid response", w.Code, w.Header())
+	}
+	if strings.Contains(w.Body.String(), "\n  ") {
+		t.Error("unexpected pretty output", w.Body.String())
+	}
+	w = respond(t, "application/json", "?pretty", recs)
+	if !strings.Contains(w.Body.String(), "\n  ") {
+		t.Error("expect pretty output", w.Body.String())
+	}
+	w = respond(t, "application/json", "?pretty=false", recs)
+	if strings.Contains(w.Body.String(), "\n  ") {
+		t.Error("unexpected pretty output", w.Body.String())
+	}
+}
+
+// TestRespondXML
+func TestRespondXML(t *testing.T) {
+	recs := []testRecord{{Did: "/beamline=3a&x<1", Runs: []int{1, 2}}}
+	w := respond(t, "text/xml;q=0.9, application/json;q=0.1", "", recs)
+	if w.Header().Get("Content-Type") != TextXMLContentType {
+		t.Fatal("invalid content type", w.Header())
+	}
+	// structures are encoded by encoding/xml
+	expect := `<response><item><did>/beamline=3a&amp;x&lt;1</did><runs><run>1</run><run>2</run></runs></item></response>`
+	if !strings.Contains(w.Body.String(), expect) {
+		t.Errorf("invalid XML\n%s\nexpect\n%s", w.Body.String(), expect)
+	}
+	w = respond(t, "application/xml", "", recs[0])
+	expect = `<testRecord><did>/beamline=3a&amp;x&lt;1</did>`
+	if !strings.Contains(w.Body.String(), expect) {
+		t.Errorf("invalid XML\n%s\nexpect\n%s", w.Body.String(), expect)
+	}
+	// map records, e.g. MongoDB records, are converted via JSON
+	rec := map[string]any{"did": "/beamline=3a", "runs": primitive.A{1, 2}}
+	w = respond(t, "application/xml", "", []map[string]any{rec})
+	expect = `<response><item><did>/beamline=3a</did><runs><item>1</item><item>2</item></runs></item></response>`
+	if !strings.Contains(w.Body.String(), expect) {
+		t.Errorf("invalid XML\n%s\nexpect\n%s", w.Body.String(), expect)
+	}
+	// structures with maps fall back to JSON conversion
+	w = respond(t, "application/xml", "", struct {
+		Meta map[string]any `json:"meta"`
+	}{Meta: rec})
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "<response><meta><did>") {
+		t.Errorf("invalid XML of structure with map\n%s", w.Body.String())
+	}
+	if name := XMLName("1 bad key"); name != "_1_bad_key" {
+		t.Error("invalid XML name", name)
+	}
+}
+
+// TestRespondNDJSON
+func TestRespondNDJSON(t *testing.T) {
+	recs := []testRecord{{Did: "a"}, {Did: "b"}, {Did: "c"}}
+	w := respond(t, NDJSONContentType, "", recs)
+	scanner := bufio.NewScanner(w.Body)
+	var nrec int
+	for scanner.Scan() {
+		var rec testRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			t.Fatal(err)
+		}
+		nrec++
+	}
+	if nrec != len(recs) {
+		t.Error("invalid number of records", nrec)
+	}
+}
+
+// TestRespondMsgpack
+func TestRespondMsgpack(t *testing.T) {
+	rec := testRecord{Did: "/beamline=3a", Runs: []int{1, 2}}
+	w := respond(t, MsgpackContentType, "", rec)
+	if w.Header().Get("Content-Type") != MsgpackContentType {
+		t.Fatal("invalid content type", w.Header())
+	}
+	var handle codec.MsgpackHandle
+	var out map[string]any
+	if err := codec.NewDecoderBytes(w.Body.Bytes(), &handle).Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+	if string(out["did"].([]byte)) != rec.Did && out["did"] != rec.Did {
+		t.Error("invalid record", out)
+	}
+	if runs, ok := out["runs"].([]any); !ok || len(runs) != 2 {
+		t.Error("invalid runs", out["runs"])
+	}
+}
+
+// TestRespondNotAcceptable
+func TestRespondNotAcceptable(t *testing.T) {
+	w := respond(t, "image/png", "", map[string]any{"a": 1})
+	if w.Code != http.StatusNotAcceptable {
+		t.Error("expect 406, got", w.Code)
+	}
+}
@@ -1,16 +1,18 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	"io/fs"
 	"log"
 	"net/http"
 	"os"
-	"path/filepath"
 	"time"
 
 	authz "github.com/CHESSComputing/golib/authz"
 	srvConfig "github.com/CHESSComputing/golib/config"
+	redis "github.com/CHESSComputing/golib/redis"
+	services "github.com/CHESSComputing/golib/services"
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-contrib/sessions/cookie"
 	"github.com/gin-gonic/gin"
@@ -24,6 +26,18 @@ var metricsPrefix string
 // StartTime represents initial time when we started the server
 var StartTime time.Time
 
+// APIKeyStore represents API keys accepted by authorized routes along with
+// JWT tokens, if it is not set only JWT tokens are accepted
+var APIKeyStore *authz.APIKeys
+
+// ServiceIdentities represents identities of peer services which are
+// authorized on authorized routes by their client certificates, it is set
+// by InitServer if mutual TLS is enabled in Authz configuration
+var ServiceIdentities *authz.ServiceIdentities
+
+// MaintenanceMode represents maintenance mode of the server router
+var MaintenanceMode *Maintenance
+
 // Route represents routes structure
 type Route struct {
 	Method     string
@@ -31,19 +45,59 @@ type Route struct {
 	Scope      string
 	Authorized bool
 	Handler    gin.HandlerFunc
+	Summary    string // summary of the route used in OpenAPI document
+	Request    any    // request structure, JSON body of POST/PUT or query of GET/DELETE
+	Response   any    // response structure
+
+	// route limits overwrite limits of web server configuration, negative
+	// values remove the limits, e.g. for upload endpoints
+	MaxBodySize int64         // maximum size of request body in bytes
+	Timeout     time.Duration // read and write timeout of the request
 }
 
-// StartServer starts HTTP(s) server
+// StartServer starts HTTP(s) server with timeouts of web server
+// configuration. It waits for dependencies of Bootstrap configuration and
+// Dependencies before accepting requests and returns if they are not
+// available until bootstrap deadline. Process monitor and pprof server are
+// started if they are enabled.
 func StartServer(r *gin.Engine, webServer srvConfig.WebServer) {
-	sport := fmt.Sprintf(":%d", webServer.Port)
-	if webServer.ServerKey != "" {
-		certFile := webServer.ServerCrt
-		ckeyFile := webServer.ServerKey
-		log.Println("Start HTTPs server on port", sport)
-		r.RunTLS(sport, certFile, ckeyFile)
+	deps := append(BootstrapDependencies(webServer.Bootstrap), Dependencies...)
+	if redis.Redis != nil {
+		deps = append(deps, RedisDependency(redis.Redis))
+	}
+	if err := WaitForDependencies(context.Background(), webServer.Bootstrap, deps...); err != nil {
+		return
+	}
+	if webServer.Monitor.Enabled {
+		SelfMonitor = NewProcessMonitor(webServer.Monitor)
+		go SelfMonitor.Run(context.Background())
+	}
+	if webServer.Pprof.Enabled && webServer.Pprof.Port > 0 {
+		go StartPprofServer(webServer.Pprof)
+	}
+	srv := NewHTTPServer(r, webServer)
+	certFile := webServer.ServerCrt
+	ckeyFile := webServer.ServerKey
+	var err error
+	if ServiceIdentities != nil {
+		// server verifies client certificates of peer services
+		mtls := srvConfig.Config.Authz.MutualTLS
+		if srv.TLSConfig, err = authz.ServerTLSConfig(mtls); err != nil {
+			return
+		}
+		if mtls.ServerCert != "" {
+			certFile, ckeyFile = "", ""
+		}
+	}
+	if srv.TLSConfig != nil || ckeyFile != "" {
+		log.Println("Start HTTPs server on port", srv.Addr)
+		err = srv.ListenAndServeTLS(certFile, ckeyFile)
 	} else {
-		log.Println("Start HTTP server on port", sport)
-		r.Run(sport)
+		log.Println("Start HTTP server on port", srv.Addr)
+		err = srv.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
+		log.Printf("ERROR: server on port %s is stopped, error %v", srv.Addr, err)
 	}
 }
 
@@ -59,6 +113,23 @@ func InitServer(webServer srvConfig.WebServer) {
 		log.SetFlags(log.LstdFlags | log.Llongfile)
 	}
 
+	// setup shared redis client used by limiter and sessions
+	if srvConfig.Config != nil && srvConfig.Config.Redis.Addr != "" && redis.Redis == nil {
+		if err := redis.Init(); err != nil {
+			log.Println("WARNING: redis is not used, error", err)
+		}
+	}
+
+	// setup mutual TLS of inter-service calls
+	if srvConfig.Config != nil && srvConfig.Config.Authz.MutualTLS.Enabled {
+		mtls := srvConfig.Config.Authz.MutualTLS
+		ServiceIdentities = authz.NewServiceIdentities(mtls)
+		ServiceIdentities.Verbose = webServer.Verbose
+		if err := services.InitMutualTLS(mtls); err != nil {
+			log.Println("WARNING: inter-service calls do not use client certificate, error", err)
+		}
+	}
+
 	// setup limiter
 	if webServer.LimiterPeriod == "" {
 		// default 100 request per second
@@ -97,16 +168,62 @@ func Router(routes []Route, fsys fs.FS, static string, webServer srvConfig.WebSe
 
 	InitServer(webServer)
 
-	// setup gin router
+	// setup gin router, recovery middleware should be the first one to
+	// recover panics of other middlewares
 	r := gin.New()
+	r.Use(RecoveryMiddleware())
 
-	// initialize cookie store (used by authz module and oauth)
-	store := cookie.NewStore([]byte("secret"))
+	// initialize cookie store (used by authz module and oauth), sessions
+	// are kept in redis if they are shared by replicas
+	var store sessions.Store = cookie.NewStore([]byte("secret"))
+	if redis.Redis != nil && srvConfig.Config != nil && srvConfig.Config.Redis.Sessions {
+		store = redis.NewSessionStore(redis.Redis, []byte("secret"))
+	}
+	store.Options(authz.SessionOptions())
 	r.Use(sessions.Sessions("server_session", store))
 
+	// fault injection should precede routes to apply to them
+	if webServer.Chaos.Enabled {
+		r.Use(ChaosMiddleware(webServer.Chaos))
+	}
+
+	// maintenance mode should precede routes to apply to them, it can be
+	// toggled at runtime by administrators via maintenance API
+	if mode, err := NewMaintenance(webServer.Maintenance); err == nil {
+		MaintenanceMode = mode
+		r.Use(MaintenanceMiddleware(mode))
+		if len(mode.AdminRoles) > 0 && srvConfig.Config != nil {
+			auth := authz.ScopeTokenMiddleware("write", srvConfig.Config.Authz.ClientID, verbose)
+			r.GET(MaintenancePath, auth, MaintenanceHandler(mode))
+			r.PUT(MaintenancePath, auth, MaintenanceHandler(mode))
+		}
+	}
+
+	// profiling endpoints on server port are accessible only to administrators
+	if pcfg := webServer.Pprof; pcfg.Enabled && pcfg.Port == 0 {
+		if len(pcfg.AdminRoles) > 0 {
+			registerPprof(r, PprofMiddleware(pcfg.AdminRoles))
+		} else {
+			log.Println("ERROR: pprof endpoints on server port require AdminRoles, they are disabled")
+		}
+	}
+
 	// GET routes
 	r.GET("/apis", ApisHandler)
 	r.GET("/metrics", MetricsHandler)
+	r.GET(ReadyzPath, ReadyzHandler)
+	r.GET(ServerInfoPath, ServerInfoHandler)
+	serverFeatures = webServerFeatures(webServer)
+	r.GET("/openapi.json", OpenAPIHandler)
+	openAPIDoc = NewOpenAPI(routes, APIInfo)
+
+	// route handlers preceded by request limits
+	handlers := func(route Route) []gin.HandlerFunc {
+		if limits := routeLimits(route, webServer); limits != nil {
+			return append([]gin.HandlerFunc{limits}, routeHandlers(route)...)
+		}
+		return routeHandlers(route)
+	}
 
 	// loop over routes and creates necessary router structure
 	var authGroup bool
@@ -122,70 +239,61 @@ func Router(routes []Route, fsys fs.FS, static string, webServer srvConfig.WebSe
 			continue
 		}
 		log.Printf("method %s path %s auth %v scope '%s'", route.Method, route.Path, route.Authorized, route.Scope)
-		if route.Method == "GET" {
-			r.GET(route.Path, route.Handler)
-		} else if route.Method == "POST" {
-			r.POST(route.Path, route.Handler)
-		} else if route.Method == "PUT" {
-			r.PUT(route.Path, route.Handler)
-		} else if route.Method == "DELETE" {
-			r.DELETE(route.Path, route.Handler)
-		}
+		r.Handle(route.Method, route.Path, handlers(route)...)
 	}
 
 	// all authorized routes
 	if authGroup {
 		authorizedRead := r.Group("/")
-		authorizedRead.Use(authz.TokenMiddleware(srvConfig.Config.Authz.ClientID, verbose))
+		authorizedRead.Use(authMiddleware("", verbose))
 		{
 			for _, route := range readRoutes {
 				if !route.Authorized {
 					continue
 				}
 				log.Printf("method %s path %s auth %v scope '%s'", route.Method, route.Path, route.Authorized, route.Scope)
-				if route.Method == "GET" {
-					authorizedRead.GET(route.Path, route.Handler)
-				} else if route.Method == "POST" {
-					authorizedRead.POST(route.Path, route.Handler)
-				} else if route.Method == "PUT" {
-					authorizedRead.PUT(route.Path, route.Handler)
-				} else if route.Method == "DELETE" {
-					authorizedRead.DELETE(route.Path, route.Handler)
-				}
+				authorizedRead.Handle(route.Method, route.Path, handlers(route)...)
 			}
 		}
 		authorizedWrite := r.Group("/")
-		authorizedWrite.Use(authz.ScopeTokenMiddleware("write", srvConfig.Config.Authz.ClientID, verbose))
+		authorizedWrite.Use(authMiddleware("write", verbose))
 		{
 			for _, route := range writeRoutes {
 				if !route.Authorized {
 					continue
 				}
 				log.Printf("method %s path %s auth %v scope '%s'", route.Method, route.Path, route.Authorized, route.Scope)
-				if route.Method == "GET" {
-					authorizedWrite.GET(route.Path, route.Handler)
-				} else if route.Method == "POST" {
-					authorizedWrite.POST(route.Path, route.Handler)
-				} else if route.Method == "PUT" {
-					authorizedWrite.PUT(route.Path, route.Handler)
-				} else if route.Method == "DELETE" {
-					authorizedWrite.DELETE(route.Path, route.Handler)
-				}
+				authorizedWrite.Handle(route.Method, route.Path, handlers(route)...)
 			}
 		}
 	}
 
-	// static files
-	if fsys != nil {
-		if entries, err := os.ReadDir(static); err == nil {
-			for _, e := range entries {
-				dir := e.Name()
-				filesFS, err := fs.Sub(fsys, filepath.Join(static, dir))
-				if err != nil {
-					panic(err)
+	// static files, they can be served either from embedded filesystem or
+	// from local static directory
+	if static != "" {
+		var staticFS fs.FS
+		if fsys != nil {
+			if sub, err := fs.Sub(fsys, static); err == nil {
+				staticFS = sub
+			}
+		} else if _, err := os.Stat(static); err == nil {
+			staticFS = os.DirFS(static)
+		}
+		if staticFS != nil {
+			StaticAssets = NewAssets(staticFS, base)
+			if webServer.CacheControl != "" {
+				StaticAssets.CacheControl = webServer.CacheControl
+			}
+			if entries, err := fs.ReadDir(staticFS, "."); err == nil {
+				for _, e := range entries {
+					if !e.IsDir() {
+						continue
+					}
+					dir := e.Name()
+					m := fmt.Sprintf("%s/%s/*filepath", base, dir)
+					r.GET(m, StaticAssets.Handler(dir))
+					r.HEAD(m, StaticAssets.Handler(dir))
 				}
-				m := fmt.Sprintf("%s/%s", base, dir)
-				r.StaticFS(m, http.FS(filesFS))
 			}
 		}
 	}
@@ -199,6 +307,25 @@ func Router(routes []Route, fsys fs.FS, static string, webServer srvConfig.WebSe
 	return r
 }
 
+// helper function to provide authorization middleware of routes with
+// given scope, requests are authorized by API key or JWT token and by
+// client certificate of peer service if mutual TLS is enabled
+func authMiddleware(scope string, verbose int) gin.HandlerFunc {
+	clientId := srvConfig.Config.Authz.ClientID
+	var mw gin.HandlerFunc
+	if APIKeyStore != nil {
+		mw = authz.KeyOrTokenMiddleware(APIKeyStore, scope, clientId, verbose)
+	} else if scope != "" {
+		mw = authz.ScopeTokenMiddleware(scope, clientId, verbose)
+	} else {
+		mw = authz.TokenMiddleware(clientId, verbose)
+	}
+	if ServiceIdentities != nil {
+		mw = authz.ServiceMiddleware(ServiceIdentities, scope, mw)
+	}
+	return mw
+}
+
 // helper function to rotate logs
 func rotateLogs(srvLogName string) {
 	if srvLogName != "" {
@@ -0,0 +1,102 @@
+package server
+
+// serverinfo module provides information about the server, e.g. its build
+// information, uptime and enabled features
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	redis "github.com/CHESSComputing/golib/redis"
+	"github.com/gin-gonic/gin"
+)
+
+// ServerInfoPath defines path of server info endpoint
+const ServerInfoPath = "/serverinfo"
+
+// ServiceName represents name of the service reported by server info
+// endpoint, name of executable is used by default
+var ServiceName = filepath.Base(os.Args[0])
+
+// Features represents additional features of the service reported by
+// server info endpoint, e.g. services may add "oai-pmh" or "doi"
+var Features []string
+
+// features enabled by web server configuration
+var serverFeatures []string
+
+// ServerInfo represents information about the server
+type ServerInfo struct {
+	Service   string              `json:"service"`           // name of the service
+	Build     srvConfig.BuildInfo `json:"build"`             // build information
+	StartTime time.Time           `json:"start_time"`        // start time of the server
+	Uptime    float64             `json:"uptime"`            // uptime in seconds
+	Features  []string            `json:"features"`          // enabled features
+	Process   *ProcessStats       `json:"process,omitempty"` // last sample of process monitor
+}
+
+// helper function to get features enabled by web server configuration
+func webServerFeatures(webServer srvConfig.WebServer) []string {
+	var features []string
+	if webServer.ServerKey != "" {
+		features = append(features, "tls")
+	}
+	if webServer.GRPCPort > 0 {
+		features = append(features, "grpc")
+	}
+	if webServer.Chaos.Enabled {
+		features = append(features, "chaos")
+	}
+	if webServer.Maintenance.Enabled || len(webServer.Maintenance.AdminRoles) > 0 {
+		features = append(features, "maintenance")
+	}
+	if webServer.MaxRequestBodySize > 0 || webServer.ReadTimeout > 0 || webServer.WriteTimeout > 0 {
+		features = append(features, "request-limits")
+	}
+	if len(BootstrapDependencies(webServer.Bootstrap)) > 0 {
+		features = append(features, "bootstrap")
+	}
+	if redis.Redis != nil {
+		features = append(features, "redis")
+	}
+	if webServer.Monitor.Enabled {
+		features = append(features, "monitor")
+	}
+	if webServer.Pprof.Enabled {
+		features = append(features, "pprof")
+	}
+	if APIKeyStore != nil {
+		features = append(features, "api-keys")
+	}
+	if ServiceIdentities != nil {
+		features = append(features, "mtls")
+	}
+	return features
+}
+
+// Info returns information about the server
+func Info() ServerInfo {
+	features := append(append([]string{}, serverFeatures...), Features...)
+	sort.Strings(features)
+	info := ServerInfo{
+		Service:   ServiceName,
+		Build:     srvConfig.Build(),
+		StartTime: StartTime,
+		Uptime:    time.Since(StartTime).Seconds(),
+		Features:  features,
+	}
+	if SelfMonitor != nil {
+		stats := SelfMonitor.Stats()
+		info.Process = &stats
+	}
+	return info
+}
+
+// ServerInfoHandler provides server info JSON
+func ServerInfoHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, Info())
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	"github.com/gin-gonic/gin"
+)
+
+// TestServerInfo
+func TestServerInfo(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tag, name := srvConfig.GitTag, ServiceName
+	defer func() { srvConfig.GitTag, ServiceName, Features = tag, name, nil }()
+	srvConfig.GitTag, ServiceName, Features = "v1.2.3", "MetaData", []string{"doi"}
+
+	webServer := srvConfig.WebServer{Chaos: srvConfig.Chaos{Enabled: true}, MaxRequestBodySize: 1024}
+	r := Router(nil, fstest.MapFS{}, "static", webServer)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", ServerInfoPath, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("wrong status %d %s", w.Code, w.Body.String())
+	}
+	var info ServerInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil {
+		t.Fatal(err)
+	}
+	if info.Service != "MetaData" || info.Build.Version != "v1.2.3" || info.Build.GoVersion == "" || info.StartTime.IsZero() {
+		t.Errorf("wrong server info %+v", info)
+	}
+	expect := []string{"chaos", "doi", "request-limits"}
+	if len(info.Features) != len(expect) {
+		t.Fatalf("expect features %v, got %v", expect, info.Features)
+	}
+	for i, f := range expect {
+		if info.Features[i] != f {
+			t.Errorf("expect features %v, got %v", expect, info.Features)
+		}
+	}
+}
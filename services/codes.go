@@ -34,4 +34,9 @@ const (
 	CredentialsError                   // 129 credentials error
 	TokenError                         // 130 token error
 	ScopeError                         // 131 token scope error
+	PolicyError                        // 132 authorization policy error
+	QuotaError                         // 133 quota error
+	PanicError                         // 134 recovered handler panic
+	IdempotencyError                   // 135 idempotency key error
+	ConflictError                      // 136 record version conflict
 )
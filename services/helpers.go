@@ -59,8 +59,12 @@ func Response(srv string, httpCode, srvCode int, err error) ServiceResponse {
 	}
 }
 
-// GetToken obtains token from OAuth server
+// GetToken obtains token from OAuth server, it is not needed if requests
+// are authenticated by client certificate of the service
 func (h *HttpRequest) GetToken() {
+	if ServiceClient != nil {
+		return
+	}
 	if h.Token == "" || h.Expires.Before(time.Now()) {
 		// make a call to Authz service to obtain access token
 		rurl := fmt.Sprintf(
@@ -100,7 +104,7 @@ func (h *HttpRequest) Get(rurl string) (*http.Response, error) {
 		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", h.Token))
 	}
 	req.Header.Add("Accept-Encoding", "")
-	client := &http.Client{}
+	client := httpClient()
 	if h.Verbose > 2 {
 		dump, err := httputil.DumpRequestOut(req, true)
 		log.Println("HttpRequest: GET request", string(dump), err)
@@ -124,7 +128,7 @@ func (h *HttpRequest) Post(rurl, contentType string, buffer *bytes.Buffer) (*htt
 	}
 	req.Header.Add("Content-Type", contentType)
 	req.Header.Add("Accept", contentType)
-	client := &http.Client{}
+	client := httpClient()
 	if h.Verbose > 2 {
 		dump, err := httputil.DumpRequestOut(req, true)
 		log.Println("HttpRequest: POST request", string(dump), err)
@@ -147,7 +151,7 @@ func (h *HttpRequest) PostForm(rurl string, formData url.Values) (*http.Response
 		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", h.Token))
 	}
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-	client := &http.Client{}
+	client := httpClient()
 	if h.Verbose > 2 {
 		dump, err := httputil.DumpRequestOut(req, true)
 		log.Println("HttpRequest: POST form request", string(dump), err)
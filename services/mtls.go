@@ -0,0 +1,69 @@
+package services
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+)
+
+// ServiceClient represents HTTP client of inter-service calls which
+// presents client certificate of the service, it is set by InitMutualTLS.
+// HttpRequest uses it instead of obtaining access tokens.
+var ServiceClient *http.Client
+
+// MutualTLSClient returns HTTP client which presents client certificate
+// of MutualTLS configuration and verifies servers with its root CAs
+func MutualTLSClient(cfg srvConfig.MutualTLS) (*http.Client, error) {
+	if cfg.ClientCert == "" || cfg.ClientKey == "" {
+		return nil, errors.New("mTLS client certificate and key are not provided")
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.ClientCert, cfg.ClientKey)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if cfg.RootCAs != "" {
+		data, err := os.ReadFile(cfg.RootCAs)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("unable to load root CAs from %s", cfg.RootCAs)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{Transport: transport}, nil
+}
+
+// InitMutualTLS initializes ServiceClient if mutual TLS is enabled and
+// client certificate is provided
+func InitMutualTLS(cfg srvConfig.MutualTLS) error {
+	if !cfg.Enabled || cfg.ClientCert == "" {
+		ServiceClient = nil
+		return nil
+	}
+	client, err := MutualTLSClient(cfg)
+	if err != nil {
+		log.Println("ERROR: unable to initialize mTLS client", err)
+		return err
+	}
+	ServiceClient = client
+	return nil
+}
+
+// helper function to provide HTTP client of requests
+func httpClient() *http.Client {
+	if ServiceClient != nil {
+		return ServiceClient
+	}
+	return &http.Client{}
+}
@@ -0,0 +1,259 @@
+package storage
+
+// bulk module provides bulk insert of large record sets, records are split
+// into batches which are inserted concurrently and results are reported per
+// record in order of input records.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/CHESSComputing/golib/mongo"
+	bson "go.mongodb.org/mongo-driver/bson"
+	mongoDriver "go.mongodb.org/mongo-driver/mongo"
+	mongoOptions "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// duplicateKeyCode represents MongoDB duplicate key error code
+const duplicateKeyCode = 11000
+
+// ErrBulkInsert is returned by BulkInsert if some records are not inserted
+var ErrBulkInsert = errors.New("bulk insert failure")
+
+// DefaultBatchSize defines default number of records per batch
+var DefaultBatchSize = 1000
+
+// ErrRolledBack is reported by results of records whose insert is rolled
+// back by atomic bulk insert
+var ErrRolledBack = errors.New("insert is rolled back")
+
+// DefaultBulkWorkers defines default number of concurrent batches
+var DefaultBulkWorkers = 4
+
+// BulkOptions represents options of bulk insert
+type BulkOptions struct {
+	BatchSize int  // number of records per batch
+	Workers   int  // number of batches inserted concurrently
+	Atomic    bool // insert all records or none within transaction of the store, batches are inserted sequentially
+	Verbose   int
+}
+
+// BulkResult represents insert result of single record
+type BulkResult struct {
+	Index int   // index of the record in input records
+	ID    any   // record _id if it is provided
+	Error error // insert error, nil on success
+}
+
+// MarshalJSON provides JSON representation of the result with error message
+func (r BulkResult) MarshalJSON() ([]byte, error) {
+	rec := map[string]any{"index": r.Index}
+	if r.ID != nil {
+		rec["id"] = r.ID
+	}
+	if r.Error != nil {
+		rec["error"] = r.Error.Error()
+	}
+	return json.Marshal(rec)
+}
+
+// BatchInserter is implemented by stores which insert batch of records in
+// single operation, it returns insert error of every record (nil on success)
+type BatchInserter interface {
+	InsertBatch(ctx context.Context, collection string, records []map[string]any) []error
+}
+
+// helper function to insert batch of records one by one via Store interface
+func insertBatch(ctx context.Context, s Store, collection string, records []map[string]any) []error {
+	if b, ok := s.(BatchInserter); ok {
+		return b.InsertBatch(ctx, collection, records)
+	}
+	errs := make([]error, len(records))
+	for i, rec := range records {
+		if err := ctx.Err(); err != nil {
+			errs[i] = err
+			continue
+		}
+		errs[i] = s.Insert(ctx, collection, rec)
+	}
+	return errs
+}
+
+// BulkInsert inserts records into collection of the store in batches
+// processed by limited number of workers. It returns results of all records
+// in order of input records and ErrBulkInsert if any record failed, e.g.
+// records which are not processed due to context cancellation. Atomic
+// bulk insert stores all records or none, results of records which are
+// rolled back report ErrRolledBack.
+func BulkInsert(ctx context.Context, s Store, collection string, records []map[string]any, opts BulkOptions) ([]BulkResult, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = DefaultBulkWorkers
+	}
+	results := make([]BulkResult, len(records))
+	for i, rec := range records {
+		results[i] = BulkResult{Index: i, ID: rec["_id"]}
+	}
+	if opts.Atomic {
+		return bulkInsertAtomic(ctx, s, collection, records, results, opts)
+	}
+
+	// batches are defined by their start index, workers write results of
+	// their own batches only, therefore results do not require locking
+	starts := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < opts.Workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for start := range starts {
+				end := start + opts.BatchSize
+				if end > len(records) {
+					end = len(records)
+				}
+				var errs []error
+				if err := ctx.Err(); err != nil {
+					errs = make([]error, end-start)
+					for i := range errs {
+						errs[i] = err
+					}
+				} else {
+					errs = insertBatch(ctx, s, collection, records[start:end])
+				}
+				for i, err := range errs {
+					results[start+i].Error = err
+				}
+				if opts.Verbose > 0 {
+					log.Printf("bulk insert of records %d-%d into %s", start, end, collection)
+				}
+			}
+		}()
+	}
+	for start := 0; start < len(records); start += opts.BatchSize {
+		starts <- start
+	}
+	close(starts)
+	wg.Wait()
+
+	var nfail int
+	for _, r := range results {
+		if r.Error != nil {
+			nfail++
+		}
+	}
+	if nfail > 0 {
+		log.Printf("ERROR: %d of %d records failed to insert into %s", nfail, len(records), collection)
+		return results, fmt.Errorf("%w: %d of %d records failed", ErrBulkInsert, nfail, len(records))
+	}
+	return results, nil
+}
+
+// helper function to insert records in batches within single transaction
+// of the store, it stops at first failed batch and rolls back all records
+func bulkInsertAtomic(ctx context.Context, s Store, collection string, records []map[string]any, results []BulkResult, opts BulkOptions) ([]BulkResult, error) {
+	t, ok := s.(Transactor)
+	if !ok {
+		return results, fmt.Errorf("%w: atomic bulk insert requires %w", ErrBulkInsert, ErrNoTransactions)
+	}
+	var nfail int
+	err := t.WithTransaction(ctx, func(ctx context.Context) error {
+		// transaction may be executed again after transient errors
+		nfail = 0
+		for i := range results {
+			results[i].Error = nil
+		}
+		for start := 0; start < len(records); start += opts.BatchSize {
+			end := start + opts.BatchSize
+			if end > len(records) {
+				end = len(records)
+			}
+			for i, err := range insertBatch(ctx, s, collection, records[start:end]) {
+				if err != nil {
+					results[start+i].Error = err
+					nfail++
+				}
+			}
+			if nfail > 0 {
+				return ErrBulkInsert
+			}
+			if opts.Verbose > 0 {
+				log.Printf("atomic bulk insert of records %d-%d into %s", start, end, collection)
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		return results, nil
+	}
+	for i := range results {
+		if results[i].Error == nil {
+			results[i].Error = ErrRolledBack
+		}
+	}
+	log.Printf("ERROR: atomic bulk insert of %d records into %s is rolled back, error %v", len(records), collection, err)
+	if errors.Is(err, ErrBulkInsert) {
+		return results, fmt.Errorf("%w: %d of %d records failed, all records are rolled back", ErrBulkInsert, nfail, len(records))
+	}
+	return results, fmt.Errorf("%w: %w", ErrBulkInsert, err)
+}
+
+// FailedResults returns results of records which failed to insert
+func FailedResults(results []BulkResult) []BulkResult {
+	var out []BulkResult
+	for _, r := range results {
+		if r.Error != nil {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// InsertBatch implements BatchInserter interface, batch is inserted via
+// unordered InsertMany, i.e. failure of one record does not stop others
+func (s *MongoStore) InsertBatch(ctx context.Context, collection string, records []map[string]any) []error {
+	errs := make([]error, len(records))
+	docs := make([]any, len(records))
+	for i, rec := range records {
+		docs[i] = bson.M(rec)
+	}
+	c, err := s.collection(collection, "")
+	if err == nil {
+		err = mongo.Mongo.Check()
+	}
+	if err != nil {
+		for i := range errs {
+			errs[i] = fmt.Errorf("unable to insert record into %s, error %w", collection, err)
+		}
+		return errs
+	}
+	opts := mongoOptions.InsertMany().SetOrdered(false)
+	_, err = c.InsertMany(ctx, docs, opts)
+	if err == nil {
+		return errs
+	}
+	var bwe mongoDriver.BulkWriteException
+	if !errors.As(err, &bwe) || len(bwe.WriteErrors) == 0 {
+		// whole batch failed, e.g. connection error
+		for i := range errs {
+			errs[i] = fmt.Errorf("unable to insert record into %s, error %v", collection, err)
+		}
+		return errs
+	}
+	for _, we := range bwe.WriteErrors {
+		if we.Index < 0 || we.Index >= len(errs) {
+			continue
+		}
+		if we.Code == duplicateKeyCode {
+			errs[we.Index] = fmt.Errorf("%w: _id %v", ErrDuplicate, records[we.Index]["_id"])
+		} else {
+			errs[we.Index] = fmt.Errorf("unable to insert record into %s, error %s", collection, we.Message)
+		}
+	}
+	return errs
+}
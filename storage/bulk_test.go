@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// TestBulkInsert
+func TestBulkInsert(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	if err := s.Insert(ctx, "meta", map[string]any{"_id": "rec-7"}); err != nil {
+		t.Fatal(err)
+	}
+	var records []map[string]any
+	for i := 0; i < 25; i++ {
+		records = append(records, map[string]any{"_id": fmt.Sprintf("rec-%d", i), "run": i})
+	}
+	// duplicate within input records
+	records = append(records, map[string]any{"_id": "rec-3"})
+	results, err := BulkInsert(ctx, s, "meta", records, BulkOptions{BatchSize: 4, Workers: 3})
+	if !errors.Is(err, ErrBulkInsert) {
+		t.Fatal("expect bulk insert error, got", err)
+	}
+	if len(results) != len(records) {
+		t.Fatal("invalid number of results", len(results))
+	}
+	for i, r := range results {
+		if r.Index != i || r.ID != records[i]["_id"] {
+			t.Errorf("invalid result order %+v", r)
+		}
+	}
+	failed := FailedResults(results)
+	if len(failed) != 2 {
+		t.Fatalf("expect 2 failed records, got %+v", failed)
+	}
+	if failed[0].Index != 7 || !errors.Is(failed[0].Error, ErrDuplicate) {
+		t.Errorf("invalid failed record %+v", failed[0])
+	}
+	if nrec, _ := s.Count(ctx, "meta", nil); nrec != 25 {
+		t.Error("invalid number of stored records", nrec)
+	}
+	data, err := json.Marshal(failed[1])
+	if err != nil || !strings.Contains(string(data), `"error":"duplicate record`) {
+		t.Error("invalid JSON result", string(data), err)
+	}
+}
+
+// TestBulkInsertCancel
+func TestBulkInsertCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	records := []map[string]any{{"run": 1}, {"run": 2}}
+	results, err := BulkInsert(ctx, NewMemoryStore(), "meta", records, BulkOptions{})
+	if err == nil {
+		t.Fatal("expect error for cancelled context")
+	}
+	for _, r := range results {
+		if !errors.Is(r.Error, context.Canceled) {
+			t.Errorf("invalid result %+v", r)
+		}
+	}
+}
+
+// txStore represents memory store with transactions of tests, collections
+// are restored if transaction fails
+type txStore struct {
+	*MemoryStore
+	calls int
+}
+
+// WithTransaction implements Transactor interface
+func (s *txStore) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	s.calls++
+	s.mu.Lock()
+	snapshot := make(map[string][]map[string]any)
+	for coll, records := range s.collections {
+		snapshot[coll] = append([]map[string]any{}, records...)
+	}
+	s.mu.Unlock()
+	if err := fn(ctx); err != nil {
+		s.mu.Lock()
+		s.collections = snapshot
+		s.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// TestBulkInsertAtomic
+func TestBulkInsertAtomic(t *testing.T) {
+	ctx := context.Background()
+	var records []map[string]any
+	for i := 0; i < 10; i++ {
+		records = append(records, map[string]any{"_id": fmt.Sprintf("rec-%d", i)})
+	}
+	opts := BulkOptions{BatchSize: 3, Atomic: true}
+
+	// stores without transactions do not insert records
+	m := NewMemoryStore()
+	if _, err := BulkInsert(ctx, m, "meta", records, opts); !errors.Is(err, ErrNoTransactions) {
+		t.Errorf("expect no transactions error, got %v", err)
+	}
+	if n, _ := m.Count(ctx, "meta", nil); n != 0 {
+		t.Errorf("records are inserted without transaction %d", n)
+	}
+
+	s := &txStore{MemoryStore: NewMemoryStore()}
+	if _, err := BulkInsert(ctx, s, "meta", records, opts); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := s.Count(ctx, "meta", nil); n != 10 || s.calls != 1 {
+		t.Errorf("wrong number of records %d or transactions %d", n, s.calls)
+	}
+
+	// duplicate of fifth record rolls back all records
+	s = &txStore{MemoryStore: NewMemoryStore()}
+	s.Insert(ctx, "meta", map[string]any{"_id": "rec-4"})
+	results, err := BulkInsert(ctx, s, "meta", records, opts)
+	if !errors.Is(err, ErrBulkInsert) || !strings.Contains(err.Error(), "1 of 10 records failed") {
+		t.Fatalf("expect bulk insert error, got %v", err)
+	}
+	if !errors.Is(results[4].Error, ErrDuplicate) || !errors.Is(results[0].Error, ErrRolledBack) || !errors.Is(results[9].Error, ErrRolledBack) {
+		t.Errorf("wrong results %+v", results)
+	}
+	if n, _ := s.Count(ctx, "meta", nil); n != 1 {
+		t.Errorf("records are not rolled back, %d records", n)
+	}
+}
@@ -0,0 +1,652 @@
+package storage
+
+// postgres module provides document store within PostgreSQL JSONB columns
+// for sites which do not operate MongoDB. Every collection is a table with
+// single JSONB column of records, GIN index serves equality conditions and
+// hot query fields get generated columns with btree indexes. The module
+// relies on database/sql package, therefore the service should register
+// PostgreSQL driver via blank import, e.g.
+//
+//	import _ "github.com/lib/pq"
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	"github.com/CHESSComputing/golib/dbs"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// PostgresDateKey defines key of JSON object which holds encoded time
+// values, e.g. {"$date": "2024-01-02T03:04:05.000000000Z"}
+var PostgresDateKey = "$date"
+
+// layout of encoded time values, fixed width keeps their order
+const postgresDateLayout = "2006-01-02T15:04:05.000000000Z"
+
+// pattern to validate names of schemas, tables and generated columns
+var patternPostgresName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Postgres represents connection to PostgreSQL database of postgres
+// backend, it should be initialized via Init function
+var Postgres *dbs.Connection
+
+// PostgresStore represents document store within PostgreSQL database.
+// Collections are tables of given schema which are created on first use.
+type PostgresStore struct {
+	DB      *dbs.Connection     // connection to PostgreSQL database
+	Schema  string              // schema of collection tables, e.g. MongoDB database name
+	Indexes map[string][]string // hot query fields of collections with generated columns and indexes
+
+	mu     sync.Mutex
+	tables map[string]bool
+}
+
+// NewPostgresStore returns PostgreSQL store of given schema, indexes define
+// hot query fields of collections
+func NewPostgresStore(db *dbs.Connection, schema string, indexes map[string][]string) *PostgresStore {
+	return &PostgresStore{DB: db, Schema: schema, Indexes: indexes, tables: make(map[string]bool)}
+}
+
+// Init initializes connection to PostgreSQL database if postgres backend is
+// configured in Storage section of server configuration
+func Init() error {
+	if srvConfig.Config == nil {
+		return errors.New("server configuration is not initialized")
+	}
+	cfg := srvConfig.Config.Storage
+	if !strings.EqualFold(cfg.Backend, "postgres") {
+		return nil
+	}
+	conn, err := dbs.Open(cfg.PostgresURI, cfg.MaxConnections, cfg.MaxIdleConnections)
+	if err != nil {
+		log.Println("ERROR: unable to connect to PostgreSQL storage", err)
+		return err
+	}
+	Postgres = conn
+	return nil
+}
+
+// NewStore returns document store of given database name within configured
+// backend: PostgreSQL store with database name as schema if postgres
+// backend is initialized via Init, otherwise MongoDB store
+func NewStore(dbname string) Store {
+	if srvConfig.Config == nil {
+		return NewMongoStore(dbname)
+	}
+	cfg := srvConfig.Config.Storage
+	if Postgres != nil {
+		return NewPostgresStore(Postgres, dbname, cfg.Indexes)
+	}
+	return &MongoStore{
+		DBName:         dbname,
+		ReadPreference: cfg.ReadPreference,
+		ReadConcern:    cfg.ReadConcern,
+		WriteConcern:   cfg.WriteConcern,
+	}
+}
+
+// helper function to quote identifier
+func quote(name string) string {
+	return `"` + name + `"`
+}
+
+// helper function to provide name of generated column of the field
+func fieldColumn(field string) string {
+	return quote("f_" + field)
+}
+
+// helper function to provide qualified name of collection table
+func (s *PostgresStore) table(collection string) (string, error) {
+	if !patternPostgresName.MatchString(collection) {
+		return "", fmt.Errorf("invalid collection name '%s'", collection)
+	}
+	if s.Schema == "" {
+		return quote(collection), nil
+	}
+	if !patternPostgresName.MatchString(s.Schema) {
+		return "", fmt.Errorf("invalid schema name '%s'", s.Schema)
+	}
+	return quote(s.Schema) + "." + quote(collection), nil
+}
+
+// helper function to provide indexed fields of collection
+func (s *PostgresStore) fields(collection string) []string {
+	var out []string
+	for _, field := range s.Indexes[collection] {
+		if patternPostgresName.MatchString(field) {
+			out = append(out, field)
+		}
+	}
+	return out
+}
+
+// helper function to provide statements which create collection table, its
+// indexes and generated columns of hot fields
+func (s *PostgresStore) tableStatements(collection string) ([]string, error) {
+	table, err := s.table(collection)
+	if err != nil {
+		return nil, err
+	}
+	var stms []string
+	if s.Schema != "" {
+		stms = append(stms, fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %s", quote(s.Schema)))
+	}
+	stms = append(stms,
+		fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (doc JSONB NOT NULL)", table),
+		fmt.Sprintf("CREATE UNIQUE INDEX IF NOT EXISTS %s ON %s ((doc->'_id'))", quote(collection+"_id_idx"), table),
+		fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s USING GIN (doc jsonb_path_ops)", quote(collection+"_doc_idx"), table))
+	for _, field := range s.fields(collection) {
+		stms = append(stms,
+			fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s JSONB GENERATED ALWAYS AS (doc->'%s') STORED", table, fieldColumn(field), field),
+			fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (%s)", quote(collection+"_"+field+"_idx"), table, fieldColumn(field)))
+	}
+	return stms, nil
+}
+
+// helper function to create collection table on first use
+func (s *PostgresStore) ensure(ctx context.Context, collection string) (string, error) {
+	table, err := s.table(collection)
+	if err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tables[collection] {
+		return table, nil
+	}
+	stms, err := s.tableStatements(collection)
+	if err != nil {
+		return "", err
+	}
+	for _, stm := range stms {
+		if _, err := s.DB.DB.ExecContext(ctx, stm); err != nil {
+			log.Printf("ERROR: unable to create table of %s collection, statement %s, error %v", collection, stm, err)
+			return "", fmt.Errorf("unable to create table of %s collection, error %v", collection, err)
+		}
+	}
+	if s.tables == nil {
+		s.tables = make(map[string]bool)
+	}
+	s.tables[collection] = true
+	return table, nil
+}
+
+// helper function to convert value into JSON compatible value, time values
+// are encoded as objects with PostgresDateKey
+func encodeValue(v any) any {
+	switch val := v.(type) {
+	case nil:
+		return nil
+	case time.Time:
+		return map[string]any{PostgresDateKey: val.UTC().Format(postgresDateLayout)}
+	case primitive.DateTime:
+		return encodeValue(val.Time())
+	case primitive.ObjectID:
+		return val.Hex()
+	case map[string]any:
+		out := make(map[string]any, len(val))
+		for k, item := range val {
+			out[k] = encodeValue(item)
+		}
+		return out
+	case []any:
+		out := make([]any, len(val))
+		for i, item := range val {
+			out[i] = encodeValue(item)
+		}
+		return out
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Map, reflect.Slice:
+		if rv.IsNil() {
+			return nil
+		}
+	}
+	switch rv.Kind() {
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return v
+		}
+		out := make(map[string]any, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			out[iter.Key().String()] = encodeValue(iter.Value().Interface())
+		}
+		return out
+	case reflect.Slice, reflect.Array:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			return v
+		}
+		out := make([]any, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			out[i] = encodeValue(rv.Index(i).Interface())
+		}
+		return out
+	}
+	return v
+}
+
+// helper function to encode value into JSON
+func encodeJSON(v any) (string, error) {
+	data, err := json.Marshal(encodeValue(v))
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// helper function to convert decoded JSON value back into record value,
+// integral numbers become int64, other numbers float64 and encoded time
+// values time.Time
+func decodeValue(v any) any {
+	switch val := v.(type) {
+	case json.Number:
+		if n, err := val.Int64(); err == nil {
+			return n
+		}
+		f, _ := val.Float64()
+		return f
+	case map[string]any:
+		if s, ok := val[PostgresDateKey].(string); ok && len(val) == 1 {
+			if t, err := time.Parse(postgresDateLayout, s); err == nil {
+				return t
+			}
+		}
+		for k, item := range val {
+			val[k] = decodeValue(item)
+		}
+		return val
+	case []any:
+		for i, item := range val {
+			val[i] = decodeValue(item)
+		}
+		return val
+	}
+	return v
+}
+
+// helper function to decode JSONB record
+func decodeRecord(data []byte) (map[string]any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var rec map[string]any
+	if err := dec.Decode(&rec); err != nil {
+		return nil, err
+	}
+	return decodeValue(rec).(map[string]any), nil
+}
+
+// pgQuery represents SQL conditions of the spec and their arguments
+type pgQuery struct {
+	fields []string
+	args   []any
+}
+
+// helper function to add argument and return its placeholder
+func (q *pgQuery) arg(v any) string {
+	q.args = append(q.args, v)
+	return fmt.Sprintf("$%d", len(q.args))
+}
+
+// helper function to add JSON argument and return its placeholder
+func (q *pgQuery) jsonArg(v any) (string, error) {
+	data, err := encodeJSON(v)
+	if err != nil {
+		return "", err
+	}
+	return q.arg(data) + "::jsonb", nil
+}
+
+// helper function to provide JSONB expression of record key, generated
+// columns are used for hot fields
+func (q *pgQuery) key(key string) string {
+	for _, field := range q.fields {
+		if field == key {
+			return fieldColumn(field)
+		}
+	}
+	return "(doc->" + q.arg(key) + "::text)"
+}
+
+// helper function to check if condition is range condition
+func isRange(cond any) (map[string]any, bool) {
+	ops, ok := cond.(map[string]any)
+	if !ok || len(ops) == 0 {
+		return nil, false
+	}
+	for op := range ops {
+		if _, ok := rangeOps[op]; !ok && op != "$ne" {
+			return nil, false
+		}
+	}
+	return ops, true
+}
+
+// sqlRangeOps defines SQL operators of range operators of the spec
+var sqlRangeOps = map[string]string{"$gt": ">", "$gte": ">=", "$lt": "<", "$lte": "<="}
+
+// helper function to convert condition on record key into SQL
+func (q *pgQuery) condition(key string, cond any) (string, error) {
+	if ops, ok := isRange(cond); ok {
+		var conds []string
+		for _, op := range sortedKeys(ops) {
+			expr := q.key(key)
+			if op == "$ne" {
+				if ops[op] == nil {
+					conds = append(conds, fmt.Sprintf("(%s IS NOT NULL AND %s <> 'null'::jsonb)", expr, expr))
+					continue
+				}
+				val, err := q.jsonArg(ops[op])
+				if err != nil {
+					return "", err
+				}
+				conds = append(conds, fmt.Sprintf("(%s IS NULL OR %s <> %s)", expr, expr, val))
+				continue
+			}
+			val, err := q.jsonArg(ops[op])
+			if err != nil {
+				return "", err
+			}
+			// values of different JSON types are not ordered
+			conds = append(conds, fmt.Sprintf("(%s %s %s AND jsonb_typeof(%s) = jsonb_typeof(%s))", expr, sqlRangeOps[op], val, expr, val))
+		}
+		return strings.Join(conds, " AND "), nil
+	}
+	if cond == nil {
+		expr := q.key(key)
+		return fmt.Sprintf("(%s IS NULL OR %s = 'null'::jsonb)", expr, expr), nil
+	}
+	for _, field := range q.fields {
+		if field == key {
+			val, err := q.jsonArg(cond)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%s = %s", fieldColumn(field), val), nil
+		}
+	}
+	// containment condition is served by GIN index
+	val, err := q.jsonArg(map[string]any{key: cond})
+	if err != nil {
+		return "", err
+	}
+	return "doc @> " + val, nil
+}
+
+// helper function to provide sorted keys of the map
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// helper function to convert spec into SQL where clause, empty spec
+// matches all records
+func (q *pgQuery) where(spec map[string]any) (string, error) {
+	var conds []string
+	for _, key := range sortedKeys(spec) {
+		cond := spec[key]
+		switch key {
+		case "$or", "$and":
+			var subs []string
+			for _, s := range specList(cond) {
+				sub, err := q.where(s)
+				if err != nil {
+					return "", err
+				}
+				subs = append(subs, "("+sub+")")
+			}
+			if len(subs) == 0 {
+				if key == "$or" {
+					subs = []string{"FALSE"}
+				} else {
+					subs = []string{"TRUE"}
+				}
+			}
+			sep := " OR "
+			if key == "$and" {
+				sep = " AND "
+			}
+			conds = append(conds, "("+strings.Join(subs, sep)+")")
+			continue
+		}
+		c, err := q.condition(key, cond)
+		if err != nil {
+			return "", err
+		}
+		conds = append(conds, c)
+	}
+	if len(conds) == 0 {
+		return "TRUE", nil
+	}
+	return strings.Join(conds, " AND "), nil
+}
+
+// helper function to check if error is unique constraint violation
+func isUniqueViolation(err error) bool {
+	var state interface{ SQLState() string }
+	if errors.As(err, &state) {
+		return state.SQLState() == "23505"
+	}
+	return strings.Contains(err.Error(), "duplicate key")
+}
+
+// executor represents PostgreSQL connection or transaction
+type executor interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// key of context value of transaction of given connection
+type postgresTxKey struct {
+	db *dbs.Connection
+}
+
+// helper function to provide transaction of the context or connection
+func (s *PostgresStore) executor(ctx context.Context) executor {
+	if tx, ok := ctx.Value(postgresTxKey{s.DB}).(*sql.Tx); ok {
+		return tx
+	}
+	return s.DB.DB
+}
+
+// WithTransaction implements Transactor interface, tables of collections
+// are created outside of transactions
+func (s *PostgresStore) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	// nested transactions are part of outer transaction
+	if _, ok := ctx.Value(postgresTxKey{s.DB}).(*sql.Tx); ok {
+		return fn(ctx)
+	}
+	return s.DB.WithTx(ctx, func(tx *sql.Tx) error {
+		return fn(context.WithValue(ctx, postgresTxKey{s.DB}, tx))
+	})
+}
+
+// helper function to provide context with timeout of the connection
+func (s *PostgresStore) context(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.DB.Timeout > 0 {
+		return context.WithTimeout(ctx, s.DB.Timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// Insert implements Store interface, records without _id get ObjectID hex
+// string
+func (s *PostgresStore) Insert(ctx context.Context, collection string, records ...map[string]any) error {
+	ctx, cancel := s.context(ctx)
+	defer cancel()
+	table, err := s.ensure(ctx, collection)
+	if err != nil {
+		return err
+	}
+	stm := fmt.Sprintf("INSERT INTO %s (doc) VALUES ($1::jsonb)", table)
+	for _, rec := range records {
+		if _, ok := rec["_id"]; !ok {
+			rec = copyRecord(rec)
+			rec["_id"] = primitive.NewObjectID().Hex()
+		}
+		doc, err := encodeJSON(rec)
+		if err != nil {
+			return fmt.Errorf("unable to encode record of %s, error %v", collection, err)
+		}
+		if _, err := s.executor(ctx).ExecContext(ctx, stm, doc); err != nil {
+			if isUniqueViolation(err) {
+				return fmt.Errorf("%w: _id %v", ErrDuplicate, rec["_id"])
+			}
+			return fmt.Errorf("unable to insert record into %s, error %v", collection, err)
+		}
+	}
+	return nil
+}
+
+// helper function to provide find statement and its arguments
+func (s *PostgresStore) findStatement(table, collection string, spec map[string]any, opts *FindOptions) (string, []any, error) {
+	q := &pgQuery{fields: s.fields(collection)}
+	where, err := q.where(spec)
+	if err != nil {
+		return "", nil, err
+	}
+	stm := fmt.Sprintf("SELECT doc FROM %s WHERE %s", table, where)
+	if opts == nil {
+		return stm, q.args, nil
+	}
+	if len(opts.Sort) > 0 {
+		// missing keys are ordered first as in MongoDB
+		var order []string
+		for _, key := range opts.Sort {
+			if strings.HasPrefix(key, "-") {
+				order = append(order, q.key(strings.TrimPrefix(key, "-"))+" DESC NULLS LAST")
+			} else {
+				order = append(order, q.key(key)+" ASC NULLS FIRST")
+			}
+		}
+		stm += " ORDER BY " + strings.Join(order, ", ")
+	}
+	if opts.Limit > 0 {
+		stm += " LIMIT " + q.arg(opts.Limit)
+	}
+	if opts.Skip > 0 {
+		stm += " OFFSET " + q.arg(opts.Skip)
+	}
+	return stm, q.args, nil
+}
+
+// Find implements Store interface
+func (s *PostgresStore) Find(ctx context.Context, collection string, spec map[string]any, opts *FindOptions) ([]map[string]any, error) {
+	ctx, cancel := s.context(ctx)
+	defer cancel()
+	table, err := s.ensure(ctx, collection)
+	if err != nil {
+		return nil, err
+	}
+	stm, args, err := s.findStatement(table, collection, spec, opts)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.executor(ctx).QueryContext(ctx, stm, args...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find records in %s, error %v", collection, err)
+	}
+	defer rows.Close()
+	var out []map[string]any
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		rec, err := decodeRecord(data)
+		if err != nil {
+			return nil, fmt.Errorf("unable to decode record of %s, error %v", collection, err)
+		}
+		out = append(out, rec)
+	}
+	return out, rows.Err()
+}
+
+// Update implements Store interface, fields are merged into top level keys
+// of matching records
+func (s *PostgresStore) Update(ctx context.Context, collection string, spec, fields map[string]any) (int64, error) {
+	ctx, cancel := s.context(ctx)
+	defer cancel()
+	table, err := s.ensure(ctx, collection)
+	if err != nil {
+		return 0, err
+	}
+	q := &pgQuery{fields: s.fields(collection)}
+	doc, err := q.jsonArg(fields)
+	if err != nil {
+		return 0, err
+	}
+	where, err := q.where(spec)
+	if err != nil {
+		return 0, err
+	}
+	stm := fmt.Sprintf("UPDATE %s SET doc = doc || %s WHERE %s", table, doc, where)
+	res, err := s.executor(ctx).ExecContext(ctx, stm, q.args...)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return 0, fmt.Errorf("%w: _id %v", ErrDuplicate, fields["_id"])
+		}
+		return 0, fmt.Errorf("unable to update records in %s, error %v", collection, err)
+	}
+	return res.RowsAffected()
+}
+
+// Count implements Store interface
+func (s *PostgresStore) Count(ctx context.Context, collection string, spec map[string]any) (int64, error) {
+	ctx, cancel := s.context(ctx)
+	defer cancel()
+	table, err := s.ensure(ctx, collection)
+	if err != nil {
+		return 0, err
+	}
+	q := &pgQuery{fields: s.fields(collection)}
+	where, err := q.where(spec)
+	if err != nil {
+		return 0, err
+	}
+	var count int64
+	stm := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s", table, where)
+	if err := s.executor(ctx).QueryRowContext(ctx, stm, q.args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("unable to count records in %s, error %v", collection, err)
+	}
+	return count, nil
+}
+
+// Remove implements Store interface
+func (s *PostgresStore) Remove(ctx context.Context, collection string, spec map[string]any) (int64, error) {
+	ctx, cancel := s.context(ctx)
+	defer cancel()
+	table, err := s.ensure(ctx, collection)
+	if err != nil {
+		return 0, err
+	}
+	q := &pgQuery{fields: s.fields(collection)}
+	where, err := q.where(spec)
+	if err != nil {
+		return 0, err
+	}
+	res, err := s.executor(ctx).ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE %s", table, where), q.args...)
+	if err != nil {
+		return 0, fmt.Errorf("unable to remove records from %s, error %v", collection, err)
+	}
+	return res.RowsAffected()
+}
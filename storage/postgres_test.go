@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CHESSComputing/golib/dbs"
+)
+
+// TestPostgresQuery
+func TestPostgresQuery(t *testing.T) {
+	s := NewPostgresStore(nil, "chess", map[string][]string{"meta": {"did"}})
+	from := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	spec := map[string]any{
+		"did":      "/beamline=3a",
+		"beamline": "3a",
+		"date":     map[string]any{"$gte": from},
+		"$or":      []map[string]any{{"state": map[string]any{"$ne": "embargoed"}}, {"user": nil}},
+	}
+	stm, args, err := s.findStatement(`"chess"."meta"`, "meta", spec, &FindOptions{Sort: []string{"-date"}, Skip: 10, Limit: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := `SELECT doc FROM "chess"."meta" WHERE ((((doc->$1::text) IS NULL OR (doc->$1::text) <> $2::jsonb)) OR ` +
+		`(((doc->$3::text) IS NULL OR (doc->$3::text) = 'null'::jsonb))) AND doc @> $4::jsonb AND ` +
+		`((doc->$5::text) >= $6::jsonb AND jsonb_typeof((doc->$5::text)) = jsonb_typeof($6::jsonb)) AND "f_did" = $7::jsonb ` +
+		`ORDER BY (doc->$8::text) DESC NULLS LAST LIMIT $9 OFFSET $10`
+	if stm != expect {
+		t.Errorf("wrong statement\n%s\nexpect\n%s", stm, expect)
+	}
+	expectArgs := []any{"state", `"embargoed"`, "user", `{"beamline":"3a"}`, "date",
+		`{"$date":"2024-01-02T03:04:05.000000000Z"}`, `"/beamline=3a"`, "date", 5, 10}
+	if !reflect.DeepEqual(args, expectArgs) {
+		t.Errorf("wrong arguments %v", args)
+	}
+	if _, _, err := s.findStatement(`"chess"."meta"`, "meta", nil, nil); err != nil {
+		t.Error(err)
+	}
+	if _, err := s.table("meta; drop table users"); err == nil {
+		t.Error("invalid collection name is accepted")
+	}
+	stms, err := s.tableStatements("meta")
+	if err != nil || len(stms) != 6 || !strings.Contains(stms[4], `"f_did" JSONB GENERATED ALWAYS AS (doc->'did') STORED`) {
+		t.Errorf("wrong table statements %v, error %v", stms, err)
+	}
+}
+
+// TestPostgresEncoding
+func TestPostgresEncoding(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	rec := map[string]any{
+		"_id":     "a",
+		"size":    10,
+		"ratio":   0.5,
+		"created": now,
+		"tags":    []string{"x", "y"},
+		"nested":  map[string]any{"date": now, "n": int64(3)},
+	}
+	data, err := encodeJSON(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := decodeRecord([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out["size"] != int64(10) || out["ratio"] != 0.5 || out["created"] != now {
+		t.Errorf("wrong decoded record %v", out)
+	}
+	nested := out["nested"].(map[string]any)
+	if nested["date"] != now || nested["n"] != int64(3) || len(out["tags"].([]any)) != 2 {
+		t.Errorf("wrong decoded nested values %v", out)
+	}
+}
+
+// TestPostgresStore runs against PostgreSQL server given by
+// FOXDEN_TEST_POSTGRES_URI environment variable, PostgreSQL driver should
+// be registered by test binary
+func TestPostgresStore(t *testing.T) {
+	uri := os.Getenv("FOXDEN_TEST_POSTGRES_URI")
+	found := false
+	for _, name := range sql.Drivers() {
+		found = found || name == "postgres"
+	}
+	if uri == "" || !found {
+		t.Skip("PostgreSQL server or driver is not available")
+	}
+	conn, err := dbs.Open(uri, 2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ctx := context.Background()
+	schema := "test_" + strings.ReplaceAll(time.Now().Format("150405.000000"), ".", "")
+	defer conn.DB.Exec(`DROP SCHEMA "` + schema + `" CASCADE`)
+	s := NewPostgresStore(conn, schema, map[string][]string{"datasets": {"beamline"}})
+	records := []map[string]any{
+		{"_id": "a", "beamline": "3a", "size": 10},
+		{"_id": "b", "beamline": "3a", "size": int64(30)},
+		{"_id": "c", "beamline": "1b", "size": 20.0},
+	}
+	if err := s.Insert(ctx, "datasets", records...); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Insert(ctx, "datasets", map[string]any{"_id": "a"}); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("expect duplicate error, got %v", err)
+	}
+	out, _ := s.Find(ctx, "datasets", nil, &FindOptions{Sort: []string{"-size"}, Skip: 1, Limit: 1})
+	if len(out) != 1 || out[0]["_id"] != "c" {
+		t.Errorf("wrong sorted records %v", out)
+	}
+	if n, _ := s.Count(ctx, "datasets", map[string]any{"beamline": "3a", "size": map[string]any{"$gt": 15}}); n != 1 {
+		t.Errorf("wrong count %d", n)
+	}
+	if n, _ := s.Update(ctx, "datasets", map[string]any{"size": 30}, map[string]any{"beamline": "4b"}); n != 1 {
+		t.Errorf("wrong number of updated records %d", n)
+	}
+	if n, _ := s.Remove(ctx, "datasets", map[string]any{"$or": []any{map[string]any{"beamline": "4b"}, map[string]any{"_id": "c"}}}); n != 2 {
+		t.Errorf("wrong number of removed records %d", n)
+	}
+	fail := errors.New("failure")
+	err = s.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := s.Insert(ctx, "datasets", map[string]any{"_id": "d"}); err != nil {
+			return err
+		}
+		return fail
+	})
+	if n, _ := s.Count(ctx, "datasets", map[string]any{"_id": "d"}); err != fail || n != 0 {
+		t.Errorf("transaction is not rolled back, error %v records %d", err, n)
+	}
+}
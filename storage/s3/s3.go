@@ -0,0 +1,451 @@
+package s3
+
+// s3 module provides client for S3 compatible object stores, e.g. MinIO,
+// which is used to stage data files referenced by metadata records
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+)
+
+// DefaultPartSize defines default size of multipart upload parts
+const DefaultPartSize int64 = 16 * 1024 * 1024
+
+// MinPartSize defines minimal size of multipart upload parts allowed by S3
+const MinPartSize int64 = 5 * 1024 * 1024
+
+// server-side encryption options
+const (
+	SSES3  = "AES256"  // encryption with S3 managed keys
+	SSEKMS = "aws:kms" // encryption with KMS managed keys
+)
+
+// Client represents S3 client
+type Client struct {
+	Endpoint    string       // endpoint URL, e.g. https://minio.host:9000
+	Region      string       // region used to sign requests
+	AccessKey   string       // access key
+	SecretKey   string       // secret key
+	Bucket      string       // default bucket
+	PartSize    int64        // size of multipart upload parts
+	SSE         string       // server-side encryption: AES256 or aws:kms
+	KMSKeyID    string       // KMS key id used with aws:kms encryption
+	VirtualHost bool         // use virtual-hosted style URLs
+	HttpClient  *http.Client // HTTP client
+	Verbose     int          // verbosity level
+}
+
+// Object represents S3 object info
+type Object struct {
+	Key          string    `xml:"Key" json:"key"`
+	Size         int64     `xml:"Size" json:"size"`
+	ETag         string    `xml:"ETag" json:"etag"`
+	LastModified time.Time `xml:"LastModified" json:"last_modified"`
+}
+
+// Error represents S3 error response
+type Error struct {
+	StatusCode int    `xml:"-"`
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+	Resource   string `xml:"Resource"`
+}
+
+// Error implements error interface
+func (e *Error) Error() string {
+	return fmt.Sprintf("s3 error: status %d code %s message %s resource %s",
+		e.StatusCode, e.Code, e.Message, e.Resource)
+}
+
+// ErrNotFound is returned when object or bucket does not exist
+var ErrNotFound = errors.New("object not found")
+
+// NewClient returns new S3 client based on S3 configuration
+func NewClient(cfg srvConfig.S3) *Client {
+	endpoint := cfg.Endpoint
+	if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+		if cfg.UseSSL {
+			endpoint = "https://" + endpoint
+		} else {
+			endpoint = "http://" + endpoint
+		}
+	}
+	region := cfg.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	partSize := int64(cfg.PartSize)
+	if partSize == 0 {
+		partSize = DefaultPartSize
+	}
+	return &Client{
+		Endpoint:    strings.TrimSuffix(endpoint, "/"),
+		Region:      region,
+		AccessKey:   cfg.AccessKey,
+		SecretKey:   cfg.AccessSecret,
+		Bucket:      cfg.Bucket,
+		PartSize:    partSize,
+		SSE:         cfg.SSE,
+		KMSKeyID:    cfg.KMSKeyID,
+		VirtualHost: cfg.VirtualHost,
+		HttpClient:  &http.Client{},
+	}
+}
+
+// helper function to build object URL
+func (c *Client) objectURL(bucket, key string, query url.Values) (*url.URL, error) {
+	if bucket == "" {
+		bucket = c.Bucket
+	}
+	if bucket == "" {
+		return nil, errors.New("no bucket provided")
+	}
+	u, err := url.Parse(c.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	key = strings.TrimPrefix(key, "/")
+	if c.VirtualHost {
+		u.Host = bucket + "." + u.Host
+		u.Path = "/" + key
+	} else {
+		u.Path = "/" + bucket + "/" + key
+	}
+	u.RawPath = uriEncode(u.Path, false)
+	if query != nil {
+		u.RawQuery = canonicalQuery(query)
+	}
+	return u, nil
+}
+
+// helper function to add server-side encryption headers
+func (c *Client) encryptionHeaders(headers http.Header) {
+	switch c.SSE {
+	case SSES3:
+		headers.Set("X-Amz-Server-Side-Encryption", SSES3)
+	case SSEKMS:
+		headers.Set("X-Amz-Server-Side-Encryption", SSEKMS)
+		if c.KMSKeyID != "" {
+			headers.Set("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id", c.KMSKeyID)
+		}
+	}
+}
+
+// helper function to perform signed S3 request
+func (c *Client) do(ctx context.Context, method string, u *url.URL, body []byte, headers http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	for k, v := range headers {
+		req.Header[k] = v
+	}
+	c.sign(req, sha256Hex(body))
+	if c.Verbose > 1 {
+		log.Printf("s3: %s %s", method, u.String())
+	}
+	client := c.HttpClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, ErrNotFound
+		}
+		s3err := &Error{StatusCode: resp.StatusCode}
+		data, _ := io.ReadAll(resp.Body)
+		xml.Unmarshal(data, s3err)
+		return nil, s3err
+	}
+	return resp, nil
+}
+
+// PutObject uploads object from given reader. Objects larger than PartSize are
+// uploaded via multipart upload.
+func (c *Client) PutObject(ctx context.Context, bucket, key string, reader io.Reader, contentType string) error {
+	partSize := c.PartSize
+	if partSize < MinPartSize {
+		partSize = MinPartSize
+	}
+	buf := make([]byte, partSize)
+	n, err := io.ReadFull(reader, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return c.putSingle(ctx, bucket, key, buf[:n], contentType)
+	}
+	if err != nil {
+		return err
+	}
+	return c.putMultipart(ctx, bucket, key, buf, reader, contentType)
+}
+
+// helper function to upload object in a single request
+func (c *Client) putSingle(ctx context.Context, bucket, key string, data []byte, contentType string) error {
+	u, err := c.objectURL(bucket, key, nil)
+	if err != nil {
+		return err
+	}
+	headers := http.Header{}
+	if contentType != "" {
+		headers.Set("Content-Type", contentType)
+	}
+	c.encryptionHeaders(headers)
+	resp, err := c.do(ctx, "PUT", u, data, headers)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// multipart upload XML structures
+type initiateMultipartUploadResult struct {
+	UploadID string `xml:"UploadId"`
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+// helper function to upload object via multipart upload, the first part
+// is already read from the reader
+func (c *Client) putMultipart(ctx context.Context, bucket, key string, first []byte, reader io.Reader, contentType string) error {
+	// initiate multipart upload
+	u, err := c.objectURL(bucket, key, url.Values{"uploads": {""}})
+	if err != nil {
+		return err
+	}
+	headers := http.Header{}
+	if contentType != "" {
+		headers.Set("Content-Type", contentType)
+	}
+	c.encryptionHeaders(headers)
+	resp, err := c.do(ctx, "POST", u, nil, headers)
+	if err != nil {
+		return err
+	}
+	var initResult initiateMultipartUploadResult
+	err = xml.NewDecoder(resp.Body).Decode(&initResult)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("unable to initiate multipart upload, error %v", err)
+	}
+	uploadID := initResult.UploadID
+
+	// upload parts and abort upload on any error
+	var parts []completedPart
+	buf := first
+	for partNumber := 1; ; partNumber++ {
+		etag, err := c.uploadPart(ctx, bucket, key, uploadID, partNumber, buf)
+		if err != nil {
+			c.abortMultipart(bucket, key, uploadID)
+			return err
+		}
+		parts = append(parts, completedPart{PartNumber: partNumber, ETag: etag})
+		next := make([]byte, len(first))
+		n, err := io.ReadFull(reader, next)
+		if n == 0 && (err == io.EOF || err == io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			c.abortMultipart(bucket, key, uploadID)
+			return err
+		}
+		buf = next[:n]
+	}
+
+	// complete multipart upload
+	body, err := xml.Marshal(completeMultipartUpload{Parts: parts})
+	if err != nil {
+		c.abortMultipart(bucket, key, uploadID)
+		return err
+	}
+	u, _ = c.objectURL(bucket, key, url.Values{"uploadId": {uploadID}})
+	resp, err = c.do(ctx, "POST", u, body, http.Header{"Content-Type": {"application/xml"}})
+	if err != nil {
+		c.abortMultipart(bucket, key, uploadID)
+		return err
+	}
+	defer resp.Body.Close()
+	// S3 may return an error within 200 OK response of complete request
+	data, _ := io.ReadAll(resp.Body)
+	if bytes.Contains(data, []byte("<Error>")) {
+		s3err := &Error{StatusCode: resp.StatusCode}
+		xml.Unmarshal(data, s3err)
+		return s3err
+	}
+	return nil
+}
+
+// helper function to upload single part of multipart upload
+func (c *Client) uploadPart(ctx context.Context, bucket, key, uploadID string, partNumber int, data []byte) (string, error) {
+	query := url.Values{"partNumber": {fmt.Sprintf("%d", partNumber)}, "uploadId": {uploadID}}
+	u, err := c.objectURL(bucket, key, query)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.do(ctx, "PUT", u, data, nil)
+	if err != nil {
+		return "", fmt.Errorf("unable to upload part %d, error %v", partNumber, err)
+	}
+	resp.Body.Close()
+	return resp.Header.Get("ETag"), nil
+}
+
+// helper function to abort multipart upload, it uses its own context since
+// the request context may already be cancelled
+func (c *Client) abortMultipart(bucket, key, uploadID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	u, err := c.objectURL(bucket, key, url.Values{"uploadId": {uploadID}})
+	if err != nil {
+		return
+	}
+	resp, err := c.do(ctx, "DELETE", u, nil, nil)
+	if err != nil {
+		log.Printf("ERROR: unable to abort multipart upload %s of %s, error %v", uploadID, key, err)
+		return
+	}
+	resp.Body.Close()
+}
+
+// GetObject returns reader of given object, the caller should close it
+func (c *Client) GetObject(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+	u, err := c.objectURL(bucket, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(ctx, "GET", u, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// GetObjectRange returns reader of given object range starting at offset,
+// negative length means till the end of the object. The caller should
+// close the reader.
+func (c *Client) GetObjectRange(ctx context.Context, bucket, key string, offset, length int64) (io.ReadCloser, error) {
+	u, err := c.objectURL(bucket, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	rng := fmt.Sprintf("bytes=%d-", offset)
+	if length >= 0 {
+		rng = fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)
+	}
+	headers := http.Header{}
+	headers.Set("Range", rng)
+	resp, err := c.do(ctx, "GET", u, nil, headers)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// StatObject returns info about given object
+func (c *Client) StatObject(ctx context.Context, bucket, key string) (Object, error) {
+	u, err := c.objectURL(bucket, key, nil)
+	if err != nil {
+		return Object{}, err
+	}
+	resp, err := c.do(ctx, "HEAD", u, nil, nil)
+	if err != nil {
+		return Object{}, err
+	}
+	resp.Body.Close()
+	obj := Object{Key: key, Size: resp.ContentLength, ETag: resp.Header.Get("ETag")}
+	if t, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		obj.LastModified = t
+	}
+	return obj, nil
+}
+
+// DeleteObject deletes given object
+func (c *Client) DeleteObject(ctx context.Context, bucket, key string) error {
+	u, err := c.objectURL(bucket, key, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(ctx, "DELETE", u, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// listBucketResult represents response of ListObjectsV2 request
+type listBucketResult struct {
+	Contents              []Object `xml:"Contents"`
+	IsTruncated           bool     `xml:"IsTruncated"`
+	NextContinuationToken string   `xml:"NextContinuationToken"`
+}
+
+// ListObjects returns list of objects with given prefix
+func (c *Client) ListObjects(ctx context.Context, bucket, prefix string) ([]Object, error) {
+	var objects []Object
+	var token string
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		u, err := c.objectURL(bucket, "", query)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.do(ctx, "GET", u, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, result.Contents...)
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+	return objects, nil
+}
+
+// PresignedURL returns presigned URL for given HTTP method (GET or PUT) of
+// the object which is valid for provided duration
+func (c *Client) PresignedURL(method, bucket, key string, expires time.Duration) (string, error) {
+	if expires <= 0 || expires > 7*24*time.Hour {
+		return "", errors.New("presigned URL expiration should be between 1s and 7 days")
+	}
+	u, err := c.objectURL(bucket, key, nil)
+	if err != nil {
+		return "", err
+	}
+	req := &http.Request{Method: method, URL: u}
+	return c.presign(req, expires), nil
+}
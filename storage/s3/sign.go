@@ -0,0 +1,153 @@
+package s3
+
+// sign module implements AWS Signature Version 4 used by S3 compatible
+// object stores, e.g. MinIO
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// signature constants
+const (
+	signAlgorithm    = "AWS4-HMAC-SHA256"
+	unsignedPayload  = "UNSIGNED-PAYLOAD"
+	amzDateFormat    = "20060102T150405Z"
+	amzShortDateForm = "20060102"
+)
+
+// now is used to obtain current time, it is replaced in tests
+var now = time.Now
+
+// helper function to compute hex encoded sha256 of given data
+func sha256Hex(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+// helper function to compute HMAC-SHA256
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// uriEncode encodes string according to AWS rules, slash is preserved only
+// if encodeSlash is false
+func uriEncode(s string, encodeSlash bool) string {
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			out.WriteByte(c)
+		} else {
+			out.WriteString(fmt.Sprintf("%%%02X", c))
+		}
+	}
+	return out.String()
+}
+
+// helper function to build canonical query string
+func canonicalQuery(query url.Values) string {
+	var keys []string
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var out []string
+	for _, k := range keys {
+		vals := query[k]
+		sort.Strings(vals)
+		for _, v := range vals {
+			out = append(out, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	return strings.Join(out, "&")
+}
+
+// helper function to build canonical headers and list of signed headers
+func canonicalHeaders(req *http.Request) (string, string) {
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		k = strings.ToLower(k)
+		if strings.HasPrefix(k, "x-amz-") || k == "content-type" || k == "content-md5" {
+			headers[k] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	var keys []string
+	for k := range headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var out strings.Builder
+	for _, k := range keys {
+		out.WriteString(k + ":" + headers[k] + "\n")
+	}
+	return out.String(), strings.Join(keys, ";")
+}
+
+// helper function to compute signing key
+func (c *Client) signingKey(date string) []byte {
+	key := hmacSHA256([]byte("AWS4"+c.SecretKey), date)
+	key = hmacSHA256(key, c.Region)
+	key = hmacSHA256(key, "s3")
+	return hmacSHA256(key, "aws4_request")
+}
+
+// helper function to compute request signature
+func (c *Client) signature(req *http.Request, query url.Values, payloadHash string, t time.Time) (string, string) {
+	headers, signed := canonicalHeaders(req)
+	creq := strings.Join([]string{
+		req.Method,
+		uriEncode(req.URL.Path, false),
+		canonicalQuery(query),
+		headers,
+		signed,
+		payloadHash,
+	}, "\n")
+	date := t.Format(amzShortDateForm)
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", date, c.Region)
+	sts := strings.Join([]string{signAlgorithm, t.Format(amzDateFormat), scope, sha256Hex([]byte(creq))}, "\n")
+	sig := hex.EncodeToString(hmacSHA256(c.signingKey(date), sts))
+	return sig, signed
+}
+
+// sign adds AWS Signature Version 4 authorization header to the request
+func (c *Client) sign(req *http.Request, payloadHash string) {
+	t := now().UTC()
+	req.Header.Set("X-Amz-Date", t.Format(amzDateFormat))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	sig, signed := c.signature(req, req.URL.Query(), payloadHash, t)
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", t.Format(amzShortDateForm), c.Region)
+	auth := fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		signAlgorithm, c.AccessKey, scope, signed, sig)
+	req.Header.Set("Authorization", auth)
+}
+
+// presign returns presigned URL of given request which is valid for
+// provided duration
+func (c *Client) presign(req *http.Request, expires time.Duration) string {
+	t := now().UTC()
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", t.Format(amzShortDateForm), c.Region)
+	query := req.URL.Query()
+	query.Set("X-Amz-Algorithm", signAlgorithm)
+	query.Set("X-Amz-Credential", c.AccessKey+"/"+scope)
+	query.Set("X-Amz-Date", t.Format(amzDateFormat))
+	query.Set("X-Amz-Expires", fmt.Sprintf("%d", int64(expires.Seconds())))
+	query.Set("X-Amz-SignedHeaders", "host")
+	// only host header is signed for presigned URLs
+	preq := &http.Request{Method: req.Method, URL: req.URL, Header: http.Header{}}
+	sig, _ := c.signature(preq, query, unsignedPayload, t)
+	query.Set("X-Amz-Signature", sig)
+	u := *req.URL
+	u.RawQuery = canonicalQuery(query)
+	return u.String()
+}
@@ -0,0 +1,574 @@
+package storage
+
+// storage module provides document storage interface used by FOXDEN/CHESS
+// libraries (users, API keys, audit records, etc.) along with MongoDB and
+// in-memory implementations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CHESSComputing/golib/mongo"
+	bson "go.mongodb.org/mongo-driver/bson"
+	mongoDriver "go.mongodb.org/mongo-driver/mongo"
+	mongoOptions "go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// ErrNotFound is returned when record is not found
+var ErrNotFound = errors.New("record not found")
+
+// ErrDuplicate is returned when record with the same _id already exists
+var ErrDuplicate = errors.New("duplicate record")
+
+// ErrNoTransactions is returned when store does not support transactions,
+// e.g. standalone MongoDB server
+var ErrNoTransactions = errors.New("transactions are not supported")
+
+// FindOptions represents options of find operation
+type FindOptions struct {
+	Skip  int      // number of records to skip
+	Limit int      // maximum number of records, 0 means no limit
+	Sort  []string // sort keys, keys prefixed with '-' are sorted in descending order
+	// read preference of MongoDB store, e.g. secondaryPreferred for
+	// reports, it is ignored by other stores
+	ReadPreference string
+}
+
+// Store defines interface of document storage. Specs are equality
+// conditions on record keys or range conditions given as map of $gt, $gte,
+// $lt and $lte operators, e.g. {"date": {"$gte": t}}, empty spec matches
+// all records.
+type Store interface {
+	// Insert inserts records into collection
+	Insert(ctx context.Context, collection string, records ...map[string]any) error
+	// Find returns records matching the spec
+	Find(ctx context.Context, collection string, spec map[string]any, opts *FindOptions) ([]map[string]any, error)
+	// Update sets given fields of records matching the spec and returns
+	// number of matched records
+	Update(ctx context.Context, collection string, spec, fields map[string]any) (int64, error)
+	// Count returns number of records matching the spec
+	Count(ctx context.Context, collection string, spec map[string]any) (int64, error)
+	// Remove removes records matching the spec and returns their number
+	Remove(ctx context.Context, collection string, spec map[string]any) (int64, error)
+}
+
+// Transactor is implemented by stores which support multi-document
+// transactions
+type Transactor interface {
+	// WithTransaction executes function within transaction which is
+	// committed if function returns nil, store operations of the function
+	// should use provided context. Function may be executed again if
+	// transaction fails due to transient error.
+	WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// WithTransaction executes function within transaction of the store, the
+// function is executed directly if store does not support transactions
+func WithTransaction(ctx context.Context, s Store, fn func(ctx context.Context) error) error {
+	t, ok := s.(Transactor)
+	if !ok {
+		return fn(ctx)
+	}
+	err := t.WithTransaction(ctx, fn)
+	if errors.Is(err, ErrNoTransactions) {
+		return fn(ctx)
+	}
+	return err
+}
+
+// Transaction returns function which executes functions within
+// transactions of the store, e.g. for curation.Manager.Transaction or
+// server.BatchTransaction, nil is returned if store does not support
+// transactions
+func Transaction(s Store) func(ctx context.Context, fn func(ctx context.Context) error) error {
+	if t, ok := s.(Transactor); ok {
+		return t.WithTransaction
+	}
+	return nil
+}
+
+// FindOne returns single record matching the spec or ErrNotFound
+func FindOne(ctx context.Context, s Store, collection string, spec map[string]any) (map[string]any, error) {
+	records, err := s.Find(ctx, collection, spec, &FindOptions{Limit: 1})
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, ErrNotFound
+	}
+	return records[0], nil
+}
+
+// MemoryStore represents in-memory document store used by tests and
+// single node deployments
+type MemoryStore struct {
+	mu          sync.RWMutex
+	collections map[string][]map[string]any
+}
+
+// NewMemoryStore returns new memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{collections: make(map[string][]map[string]any)}
+}
+
+// helper function to copy record
+func copyRecord(rec map[string]any) map[string]any {
+	out := make(map[string]any, len(rec))
+	for k, v := range rec {
+		out[k] = v
+	}
+	return out
+}
+
+// helper function to convert numeric value into float64
+func number(v any) (float64, bool) {
+	switch val := v.(type) {
+	case int:
+		return float64(val), true
+	case int32:
+		return float64(val), true
+	case int64:
+		return float64(val), true
+	case float32:
+		return float64(val), true
+	case float64:
+		return val, true
+	}
+	return 0, false
+}
+
+// helper function to convert time value into time.Time
+func timeValue(v any) (time.Time, bool) {
+	switch val := v.(type) {
+	case time.Time:
+		return val, true
+	case interface{ Time() time.Time }:
+		// e.g. MongoDB DateTime
+		return val.Time(), true
+	}
+	return time.Time{}, false
+}
+
+// helper function to compare values, numbers of different types are equal
+// if their values are equal
+func equal(a, b any) bool {
+	if x, ok := number(a); ok {
+		if y, ok := number(b); ok {
+			return x == y
+		}
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// helper function to order values, it returns negative number if a < b
+func compare(a, b any) int {
+	if x, ok := number(a); ok {
+		if y, ok := number(b); ok {
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
+			}
+			return 0
+		}
+	}
+	if x, ok := timeValue(a); ok {
+		if y, ok := timeValue(b); ok {
+			return x.Compare(y)
+		}
+	}
+	return strings.Compare(fmt.Sprintf("%v", a), fmt.Sprintf("%v", b))
+}
+
+// rangeOps defines supported range operators of the spec
+var rangeOps = map[string]func(c int) bool{
+	"$gt":  func(c int) bool { return c > 0 },
+	"$gte": func(c int) bool { return c >= 0 },
+	"$lt":  func(c int) bool { return c < 0 },
+	"$lte": func(c int) bool { return c <= 0 },
+}
+
+// helper function to check if value satisfies range condition, the second
+// return value is false if condition is not a range condition. Condition
+// may also contain $ne operator which is satisfied by missing values.
+func inRange(val, cond any) (bool, bool) {
+	ops, ok := cond.(map[string]any)
+	if !ok || len(ops) == 0 {
+		return false, false
+	}
+	for op := range ops {
+		if _, ok := rangeOps[op]; !ok && op != "$ne" {
+			return false, false
+		}
+	}
+	for op, v := range ops {
+		if op == "$ne" {
+			if equal(val, v) {
+				return false, true
+			}
+			continue
+		}
+		if val == nil || !rangeOps[op](compare(val, v)) {
+			return false, true
+		}
+	}
+	return true, true
+}
+
+// helper function to provide specs of $or and $and conditions
+func specList(cond any) []map[string]any {
+	switch val := cond.(type) {
+	case []map[string]any:
+		return val
+	case []any:
+		var out []map[string]any
+		for _, v := range val {
+			if spec, ok := v.(map[string]any); ok {
+				out = append(out, spec)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// helper function to check if record matches the spec
+func matches(rec, spec map[string]any) bool {
+	for k, v := range spec {
+		switch k {
+		case "$or":
+			found := false
+			for _, s := range specList(v) {
+				if matches(rec, s) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+			continue
+		case "$and":
+			for _, s := range specList(v) {
+				if !matches(rec, s) {
+					return false
+				}
+			}
+			continue
+		}
+		if ok, isRange := inRange(rec[k], v); isRange {
+			if !ok {
+				return false
+			}
+			continue
+		}
+		if !equal(rec[k], v) {
+			return false
+		}
+	}
+	return true
+}
+
+// Insert implements Store interface
+func (s *MemoryStore) Insert(ctx context.Context, collection string, records ...map[string]any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, rec := range records {
+		if id, ok := rec["_id"]; ok {
+			for _, r := range s.collections[collection] {
+				if equal(r["_id"], id) {
+					return fmt.Errorf("%w: _id %v", ErrDuplicate, id)
+				}
+			}
+		}
+		s.collections[collection] = append(s.collections[collection], copyRecord(rec))
+	}
+	return nil
+}
+
+// Find implements Store interface
+func (s *MemoryStore) Find(ctx context.Context, collection string, spec map[string]any, opts *FindOptions) ([]map[string]any, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []map[string]any
+	for _, rec := range s.collections[collection] {
+		if matches(rec, spec) {
+			out = append(out, copyRecord(rec))
+		}
+	}
+	if opts == nil {
+		return out, nil
+	}
+	if len(opts.Sort) > 0 {
+		sort.SliceStable(out, func(i, j int) bool {
+			for _, key := range opts.Sort {
+				desc := strings.HasPrefix(key, "-")
+				key = strings.TrimPrefix(key, "-")
+				if c := compare(out[i][key], out[j][key]); c != 0 {
+					return (c < 0) != desc
+				}
+			}
+			return false
+		})
+	}
+	if opts.Skip > 0 {
+		if opts.Skip >= len(out) {
+			return nil, nil
+		}
+		out = out[opts.Skip:]
+	}
+	if opts.Limit > 0 && len(out) > opts.Limit {
+		out = out[:opts.Limit]
+	}
+	return out, nil
+}
+
+// Update implements Store interface
+func (s *MemoryStore) Update(ctx context.Context, collection string, spec, fields map[string]any) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var nrec int64
+	for _, rec := range s.collections[collection] {
+		if matches(rec, spec) {
+			for k, v := range fields {
+				rec[k] = v
+			}
+			nrec++
+		}
+	}
+	return nrec, nil
+}
+
+// Count implements Store interface
+func (s *MemoryStore) Count(ctx context.Context, collection string, spec map[string]any) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var nrec int64
+	for _, rec := range s.collections[collection] {
+		if matches(rec, spec) {
+			nrec++
+		}
+	}
+	return nrec, nil
+}
+
+// Remove implements Store interface
+func (s *MemoryStore) Remove(ctx context.Context, collection string, spec map[string]any) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var nrec int64
+	var records []map[string]any
+	for _, rec := range s.collections[collection] {
+		if matches(rec, spec) {
+			nrec++
+			continue
+		}
+		records = append(records, rec)
+	}
+	s.collections[collection] = records
+	return nrec, nil
+}
+
+// MongoStore represents document store within MongoDB database, its
+// operations are supervised by supervisor of mongo.Mongo connection
+type MongoStore struct {
+	DBName         string
+	ReadPreference string // read preference, e.g. secondaryPreferred, client preference if empty
+	ReadConcern    string // read concern, e.g. majority, client concern if empty
+	WriteConcern   string // write concern, majority or number of servers, client concern if empty
+}
+
+// NewMongoStore returns MongoDB store for given database name, MongoDB
+// connection should be initialized via mongo.InitMongoDB
+func NewMongoStore(dbname string) *MongoStore {
+	return &MongoStore{DBName: dbname}
+}
+
+// helper function to get MongoDB collection with read preference, read and
+// write concerns of the store, read preference may be overwritten
+func (s *MongoStore) collection(name, readPreference string) (*mongoDriver.Collection, error) {
+	if readPreference == "" {
+		readPreference = s.ReadPreference
+	}
+	opts := mongoOptions.Collection()
+	rp, err := mongo.ReadPreference(readPreference)
+	if err != nil {
+		return nil, err
+	}
+	if rp != nil {
+		opts.SetReadPreference(rp)
+	}
+	rc, err := mongo.ReadConcern(s.ReadConcern)
+	if err != nil {
+		return nil, err
+	}
+	if rc != nil {
+		opts.SetReadConcern(rc)
+	}
+	wc, err := mongo.WriteConcern(s.WriteConcern)
+	if err != nil {
+		return nil, err
+	}
+	if wc != nil {
+		opts.SetWriteConcern(wc)
+	}
+	return mongo.Mongo.Connect().Database(s.DBName).Collection(name, opts), nil
+}
+
+// WithTransaction implements Transactor interface, transactions require
+// MongoDB replica set or sharded cluster and use read and write concerns
+// of the store, ErrNoTransactions is returned for standalone servers
+func (s *MongoStore) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	// nested transactions are part of outer transaction
+	if mongoDriver.SessionFromContext(ctx) != nil {
+		return fn(ctx)
+	}
+	supported, err := mongo.Mongo.Transactions(ctx)
+	if err != nil {
+		return err
+	}
+	if !supported {
+		return ErrNoTransactions
+	}
+	opts := mongoOptions.Transaction().SetReadPreference(readpref.Primary())
+	if rc, err := mongo.ReadConcern(s.ReadConcern); err != nil {
+		return err
+	} else if rc != nil {
+		opts.SetReadConcern(rc)
+	}
+	if wc, err := mongo.WriteConcern(s.WriteConcern); err != nil {
+		return err
+	} else if wc != nil {
+		opts.SetWriteConcern(wc)
+	}
+	return mongo.WithTransactionOptions(ctx, opts, fn)
+}
+
+// helper function to convert spec into MongoDB filter
+func filter(spec map[string]any) bson.M {
+	if spec == nil {
+		return bson.M{}
+	}
+	return bson.M(spec)
+}
+
+// Insert implements Store interface
+func (s *MongoStore) Insert(ctx context.Context, collection string, records ...map[string]any) error {
+	if err := mongo.Mongo.Check(); err != nil {
+		return err
+	}
+	c, err := s.collection(collection, "")
+	if err != nil {
+		return err
+	}
+	for _, rec := range records {
+		if _, err := c.InsertOne(ctx, bson.M(rec)); err != nil {
+			if mongoDriver.IsDuplicateKeyError(err) {
+				return fmt.Errorf("%w: _id %v", ErrDuplicate, rec["_id"])
+			}
+			return fmt.Errorf("unable to insert record into %s, error %w", collection, err)
+		}
+	}
+	return nil
+}
+
+// Find implements Store interface
+func (s *MongoStore) Find(ctx context.Context, collection string, spec map[string]any, opts *FindOptions) ([]map[string]any, error) {
+	fopts := mongoOptions.Find()
+	var readPreference string
+	if opts != nil {
+		readPreference = opts.ReadPreference
+		if opts.Skip > 0 {
+			fopts.SetSkip(int64(opts.Skip))
+		}
+		if opts.Limit > 0 {
+			fopts.SetLimit(int64(opts.Limit))
+		}
+		if len(opts.Sort) > 0 {
+			var sortSpec bson.D
+			for _, key := range opts.Sort {
+				order := 1
+				if strings.HasPrefix(key, "-") {
+					order = -1
+				}
+				sortSpec = append(sortSpec, bson.E{Key: strings.TrimPrefix(key, "-"), Value: order})
+			}
+			fopts.SetSort(sortSpec)
+		}
+	}
+	c, err := s.collection(collection, readPreference)
+	if err != nil {
+		return nil, err
+	}
+	var out []map[string]any
+	err = mongo.Mongo.Do(ctx, func(ctx context.Context) error {
+		cur, err := c.Find(ctx, filter(spec), fopts)
+		if err != nil {
+			return err
+		}
+		return cur.All(ctx, &out)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to find records in %s, error %w", collection, err)
+	}
+	return out, nil
+}
+
+// Update implements Store interface
+func (s *MongoStore) Update(ctx context.Context, collection string, spec, fields map[string]any) (int64, error) {
+	c, err := s.collection(collection, "")
+	if err != nil {
+		return 0, err
+	}
+	var res *mongoDriver.UpdateResult
+	err = mongo.Mongo.Do(ctx, func(ctx context.Context) error {
+		var err error
+		res, err = c.UpdateMany(ctx, filter(spec), bson.M{"$set": bson.M(fields)})
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("unable to update records in %s, error %w", collection, err)
+	}
+	return res.MatchedCount, nil
+}
+
+// Count implements Store interface
+func (s *MongoStore) Count(ctx context.Context, collection string, spec map[string]any) (int64, error) {
+	c, err := s.collection(collection, "")
+	if err != nil {
+		return 0, err
+	}
+	var nrec int64
+	err = mongo.Mongo.Do(ctx, func(ctx context.Context) error {
+		var err error
+		nrec, err = c.CountDocuments(ctx, filter(spec))
+		return err
+	})
+	return nrec, err
+}
+
+// Remove implements Store interface
+func (s *MongoStore) Remove(ctx context.Context, collection string, spec map[string]any) (int64, error) {
+	c, err := s.collection(collection, "")
+	if err != nil {
+		return 0, err
+	}
+	var res *mongoDriver.DeleteResult
+	err = mongo.Mongo.Do(ctx, func(ctx context.Context) error {
+		var err error
+		res, err = c.DeleteMany(ctx, filter(spec))
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("unable to remove records from %s, error %w", collection, err)
+	}
+	return res.DeletedCount, nil
+}
@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestMemoryStore
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	records := []map[string]any{
+		{"_id": "a", "beamline": "3a", "size": 10},
+		{"_id": "b", "beamline": "3a", "size": int64(30)},
+		{"_id": "c", "beamline": "1b", "size": 20.0},
+	}
+	if err := s.Insert(ctx, "datasets", records...); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Insert(ctx, "datasets", map[string]any{"_id": "a"}); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("expect duplicate error, got %v", err)
+	}
+	out, _ := s.Find(ctx, "datasets", nil, &FindOptions{Sort: []string{"-size"}, Skip: 1, Limit: 1})
+	if len(out) != 1 || out[0]["_id"] != "c" {
+		t.Errorf("wrong sorted records %v", out)
+	}
+	if n, _ := s.Count(ctx, "datasets", map[string]any{"beamline": "3a"}); n != 2 {
+		t.Errorf("wrong count %d", n)
+	}
+	if n, _ := s.Update(ctx, "datasets", map[string]any{"size": 30}, map[string]any{"beamline": "4b"}); n != 1 {
+		t.Errorf("wrong number of updated records %d", n)
+	}
+	rec, err := FindOne(ctx, s, "datasets", map[string]any{"_id": "b"})
+	if err != nil || rec["beamline"] != "4b" {
+		t.Errorf("wrong record %v, error %v", rec, err)
+	}
+	// returned records should not modify the store
+	rec["beamline"] = "5c"
+	if rec, _ := FindOne(ctx, s, "datasets", map[string]any{"_id": "b"}); rec["beamline"] != "4b" {
+		t.Error("store record is modified")
+	}
+	if n, _ := s.Remove(ctx, "datasets", map[string]any{"beamline": "3a"}); n != 1 {
+		t.Errorf("wrong number of removed records %d", n)
+	}
+	if _, err := FindOne(ctx, s, "datasets", map[string]any{"_id": "a"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expect not found error, got %v", err)
+	}
+}
+
+// TestMemoryStoreRange
+func TestMemoryStoreRange(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, id := range []string{"a", "b", "c", "d"} {
+		rec := map[string]any{"_id": id, "size": i * 10, "date": day.AddDate(0, 0, i)}
+		if err := s.Insert(ctx, "datasets", rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	spec := map[string]any{"date": map[string]any{"$gte": day.AddDate(0, 0, 1), "$lt": day.AddDate(0, 0, 3)}}
+	out, _ := s.Find(ctx, "datasets", spec, &FindOptions{Sort: []string{"-date"}})
+	if len(out) != 2 || out[0]["_id"] != "c" || out[1]["_id"] != "b" {
+		t.Errorf("wrong records in date range %v", out)
+	}
+	if n, _ := s.Count(ctx, "datasets", map[string]any{"size": map[string]any{"$gt": int64(10)}}); n != 2 {
+		t.Errorf("wrong count of size range %d", n)
+	}
+	if n, _ := s.Count(ctx, "datasets", map[string]any{"missing": map[string]any{"$lte": 1}}); n != 0 {
+		t.Errorf("records without key should not match range, got %d", n)
+	}
+}
+
+// TestMemoryStoreLogical
+func TestMemoryStoreLogical(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	s.Insert(ctx, "meta",
+		map[string]any{"_id": "a", "state": "published", "user": "alice"},
+		map[string]any{"_id": "b", "state": "embargoed", "user": "alice"},
+		map[string]any{"_id": "c", "state": "embargoed", "user": "bob"},
+		map[string]any{"_id": "d", "user": "bob"})
+	if n, _ := s.Count(ctx, "meta", map[string]any{"state": map[string]any{"$ne": "embargoed"}}); n != 2 {
+		t.Errorf("wrong count of $ne condition %d", n)
+	}
+	spec := map[string]any{"$or": []map[string]any{
+		{"state": map[string]any{"$ne": "embargoed"}},
+		{"user": "alice"},
+	}}
+	out, _ := s.Find(ctx, "meta", spec, &FindOptions{Sort: []string{"_id"}})
+	if len(out) != 3 || out[2]["_id"] != "d" {
+		t.Errorf("wrong records of $or condition %v", out)
+	}
+	spec = map[string]any{"$and": []any{spec, map[string]any{"user": "bob"}}}
+	if out, _ := s.Find(ctx, "meta", spec, nil); len(out) != 1 || out[0]["_id"] != "d" {
+		t.Errorf("wrong records of $and condition %v", out)
+	}
+}
+
+// TestWithTransaction
+func TestWithTransaction(t *testing.T) {
+	ctx := context.Background()
+	fail := errors.New("failure")
+	insert := func(s Store) func(ctx context.Context) error {
+		return func(ctx context.Context) error {
+			if err := s.Insert(ctx, "meta", map[string]any{"_id": 1}); err != nil {
+				return err
+			}
+			return fail
+		}
+	}
+
+	// memory store executes function directly
+	m := NewMemoryStore()
+	if err := WithTransaction(ctx, m, insert(m)); err != fail {
+		t.Errorf("wrong error %v", err)
+	}
+	if n, _ := m.Count(ctx, "meta", nil); n != 1 || Transaction(m) != nil {
+		t.Errorf("wrong number of records %d", n)
+	}
+
+	s := &txStore{MemoryStore: NewMemoryStore()}
+	if err := WithTransaction(ctx, s, insert(s)); err != fail {
+		t.Errorf("wrong error %v", err)
+	}
+	if n, _ := s.Count(ctx, "meta", nil); n != 0 || s.calls != 1 {
+		t.Errorf("transaction is not rolled back, %d records", n)
+	}
+	if tx := Transaction(s); tx == nil || tx(ctx, func(ctx context.Context) error { return nil }) != nil || s.calls != 2 {
+		t.Error("wrong transaction function of the store")
+	}
+}
@@ -0,0 +1,153 @@
+package storage
+
+// trash module provides soft deletion of records. Deleted records are
+// flagged with deletion metadata and kept in the trash until retention
+// window expires, they can be restored within this window.
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// soft deletion keys of the record
+const (
+	DeletedKey    = "_deleted"     // deletion flag
+	DeletedAtKey  = "_deleted_at"  // deletion time (unix seconds)
+	DeletedByKey  = "_deleted_by"  // user who deleted the record
+	PurgeAfterKey = "_purge_after" // time (unix seconds) after which record is purged
+)
+
+// DefaultRetention defines default retention window of deleted records
+var DefaultRetention = 30 * 24 * time.Hour
+
+// SoftDeleteStore implements Store interface on top of another store where
+// records are flagged as deleted instead of being removed. Find, Count and
+// Update skip deleted records unless spec explicitly contains DeletedKey.
+// Records are flagged as not deleted on insert, therefore records inserted
+// directly into underlying store are not visible.
+type SoftDeleteStore struct {
+	Store       Store
+	Retention   time.Duration // how long deleted records are kept
+	Interval    time.Duration // purge interval of Run
+	Collections []string      // collections purged by Run
+}
+
+// NewSoftDeleteStore returns soft delete store with default retention
+func NewSoftDeleteStore(s Store) *SoftDeleteStore {
+	return &SoftDeleteStore{Store: s, Retention: DefaultRetention, Interval: time.Hour}
+}
+
+// helper function to add deletion flag to the spec
+func withDeleted(spec map[string]any, deleted bool) map[string]any {
+	out := copyRecord(spec)
+	if _, ok := out[DeletedKey]; !ok {
+		out[DeletedKey] = deleted
+	}
+	return out
+}
+
+// Insert implements Store interface, records are flagged as not deleted
+func (s *SoftDeleteStore) Insert(ctx context.Context, collection string, records ...map[string]any) error {
+	var recs []map[string]any
+	for _, rec := range records {
+		recs = append(recs, withDeleted(rec, false))
+	}
+	return s.Store.Insert(ctx, collection, recs...)
+}
+
+// Find implements Store interface, deleted records are excluded
+func (s *SoftDeleteStore) Find(ctx context.Context, collection string, spec map[string]any, opts *FindOptions) ([]map[string]any, error) {
+	return s.Store.Find(ctx, collection, withDeleted(spec, false), opts)
+}
+
+// Update implements Store interface, deleted records are not updated
+func (s *SoftDeleteStore) Update(ctx context.Context, collection string, spec, fields map[string]any) (int64, error) {
+	return s.Store.Update(ctx, collection, withDeleted(spec, false), fields)
+}
+
+// Count implements Store interface, deleted records are not counted
+func (s *SoftDeleteStore) Count(ctx context.Context, collection string, spec map[string]any) (int64, error) {
+	return s.Store.Count(ctx, collection, withDeleted(spec, false))
+}
+
+// Remove implements Store interface, records are moved to the trash
+func (s *SoftDeleteStore) Remove(ctx context.Context, collection string, spec map[string]any) (int64, error) {
+	return s.Delete(ctx, collection, "", spec)
+}
+
+// Delete moves records matching the spec to the trash on behalf of given
+// user and returns their number
+func (s *SoftDeleteStore) Delete(ctx context.Context, collection, actor string, spec map[string]any) (int64, error) {
+	now := time.Now()
+	fields := map[string]any{
+		DeletedKey:    true,
+		DeletedAtKey:  now.Unix(),
+		DeletedByKey:  actor,
+		PurgeAfterKey: now.Add(s.Retention).Unix(),
+	}
+	return s.Store.Update(ctx, collection, withDeleted(spec, false), fields)
+}
+
+// Trash returns deleted records matching the spec
+func (s *SoftDeleteStore) Trash(ctx context.Context, collection string, spec map[string]any, opts *FindOptions) ([]map[string]any, error) {
+	return s.Store.Find(ctx, collection, withDeleted(spec, true), opts)
+}
+
+// Restore restores deleted records matching the spec and returns their
+// number
+func (s *SoftDeleteStore) Restore(ctx context.Context, collection string, spec map[string]any) (int64, error) {
+	fields := map[string]any{
+		DeletedKey:    false,
+		DeletedAtKey:  nil,
+		DeletedByKey:  nil,
+		PurgeAfterKey: nil,
+	}
+	return s.Store.Update(ctx, collection, withDeleted(spec, true), fields)
+}
+
+// Purge permanently removes deleted records of collection whose retention
+// window expired before given time and returns their number
+func (s *SoftDeleteStore) Purge(ctx context.Context, collection string, now time.Time) (int64, error) {
+	records, err := s.Store.Find(ctx, collection, map[string]any{DeletedKey: true}, nil)
+	if err != nil {
+		return 0, err
+	}
+	var nrec int64
+	for _, rec := range records {
+		if after, ok := number(rec[PurgeAfterKey]); ok && int64(after) > now.Unix() {
+			continue
+		}
+		n, err := s.Store.Remove(ctx, collection, map[string]any{"_id": rec["_id"], DeletedKey: true})
+		if err != nil {
+			log.Printf("ERROR: unable to purge record %v of %s, error %v", rec["_id"], collection, err)
+			return nrec, err
+		}
+		nrec += n
+	}
+	return nrec, nil
+}
+
+// Run periodically purges expired records of configured collections until
+// context is cancelled
+func (s *SoftDeleteStore) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	for {
+		for _, coll := range s.Collections {
+			nrec, err := s.Purge(ctx, coll, time.Now())
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("ERROR: purge of %s failed, error %v", coll, err)
+				}
+			} else if nrec > 0 {
+				log.Printf("purged %d deleted records of %s", nrec, coll)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
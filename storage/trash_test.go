@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// TestSoftDeleteStore
+func TestSoftDeleteStore(t *testing.T) {
+	ctx := context.Background()
+	s := NewSoftDeleteStore(NewMemoryStore())
+	s.Retention = time.Hour
+	for _, id := range []string{"a", "b", "c"} {
+		if err := s.Insert(ctx, "meta", map[string]any{"_id": id, "beamline": "3a"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	nrec, err := s.Delete(ctx, "meta", "alice", map[string]any{"_id": "b"})
+	if err != nil || nrec != 1 {
+		t.Fatal("unable to delete record", nrec, err)
+	}
+	records, err := s.Find(ctx, "meta", map[string]any{"beamline": "3a"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Errorf("deleted record is listed %+v", records)
+	}
+	if n, _ := s.Count(ctx, "meta", nil); n != 2 {
+		t.Error("invalid number of records", n)
+	}
+	if n, _ := s.Update(ctx, "meta", map[string]any{"_id": "b"}, map[string]any{"beamline": "3b"}); n != 0 {
+		t.Error("deleted record is updated")
+	}
+	trash, err := s.Trash(ctx, "meta", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trash) != 1 || trash[0]["_id"] != "b" || trash[0][DeletedByKey] != "alice" {
+		t.Errorf("invalid trash %+v", trash)
+	}
+
+	// restore record
+	if n, err := s.Restore(ctx, "meta", map[string]any{"_id": "b"}); err != nil || n != 1 {
+		t.Fatal("unable to restore record", n, err)
+	}
+	if n, _ := s.Count(ctx, "meta", nil); n != 3 {
+		t.Error("record is not restored", n)
+	}
+
+	// purge honors retention window
+	if _, err := s.Remove(ctx, "meta", map[string]any{"_id": "c"}); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := s.Purge(ctx, "meta", time.Now()); n != 0 {
+		t.Error("record is purged within retention window")
+	}
+	if n, _ := s.Purge(ctx, "meta", time.Now().Add(2*time.Hour)); n != 1 {
+		t.Error("expired record is not purged", n)
+	}
+	if n, _ := s.Store.Count(ctx, "meta", nil); n != 2 {
+		t.Error("invalid number of stored records", n)
+	}
+}
@@ -0,0 +1,234 @@
+package storage
+
+// versions module provides immutable record versions. Every change of the
+// record appends new version with timestamp and actor to history
+// collection, therefore metadata corrections do not overwrite the only copy
+// of the record.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	bson "go.mongodb.org/mongo-driver/bson"
+)
+
+// VersionKey defines record key which holds current version of the record
+const VersionKey = "_version"
+
+// HistorySuffix defines suffix of collections with record versions
+var HistorySuffix = "_history"
+
+// ErrVersionConflict is returned when record was modified concurrently
+var ErrVersionConflict = errors.New("record version conflict")
+
+// version actions
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+// Version represents single immutable version of the record
+type Version struct {
+	RecordID any            `json:"record_id"`
+	Version  int            `json:"version"`
+	Time     time.Time      `json:"time"`
+	Actor    string         `json:"actor"`
+	Action   string         `json:"action"`
+	Comment  string         `json:"comment,omitempty"`
+	Record   map[string]any `json:"record"`
+}
+
+// Change represents change of single record key between two versions
+type Change struct {
+	Before any `json:"before"`
+	After  any `json:"after"`
+}
+
+// VersionedStore provides versioned records on top of document store
+type VersionedStore struct {
+	Store Store
+}
+
+// NewVersionedStore returns versioned store on top of given store
+func NewVersionedStore(s Store) *VersionedStore {
+	return &VersionedStore{Store: s}
+}
+
+// HistoryCollection returns name of history collection of given collection
+func HistoryCollection(collection string) string {
+	return collection + HistorySuffix
+}
+
+// helper function to convert version number of the record
+func versionNumber(val any) int {
+	if n, ok := number(val); ok {
+		return int(n)
+	}
+	return 0
+}
+
+// helper function to append version into history collection
+func (v *VersionedStore) appendVersion(ctx context.Context, collection string, ver Version) error {
+	rec := map[string]any{
+		"_id":       fmt.Sprintf("%v@%d", ver.RecordID, ver.Version),
+		"record_id": ver.RecordID,
+		"version":   ver.Version,
+		"time":      ver.Time.Unix(),
+		"actor":     ver.Actor,
+		"action":    ver.Action,
+		"comment":   ver.Comment,
+		"record":    ver.Record,
+	}
+	if err := v.Store.Insert(ctx, HistoryCollection(collection), rec); err != nil {
+		log.Printf("ERROR: unable to store version %d of record %v, error %v", ver.Version, ver.RecordID, err)
+		return err
+	}
+	return nil
+}
+
+// helper function to convert history record into version
+func toVersion(rec map[string]any) Version {
+	ver := Version{
+		RecordID: rec["record_id"],
+		Version:  versionNumber(rec["version"]),
+	}
+	if sec, ok := number(rec["time"]); ok {
+		ver.Time = time.Unix(int64(sec), 0).UTC()
+	}
+	ver.Actor, _ = rec["actor"].(string)
+	ver.Action, _ = rec["action"].(string)
+	ver.Comment, _ = rec["comment"].(string)
+	switch r := rec["record"].(type) {
+	case map[string]any:
+		ver.Record = r
+	case bson.M:
+		ver.Record = r
+	}
+	return ver
+}
+
+// Insert inserts new record as its first version, record should have _id
+func (v *VersionedStore) Insert(ctx context.Context, collection, actor string, rec map[string]any) error {
+	id, ok := rec["_id"]
+	if !ok {
+		return errors.New("versioned record without _id")
+	}
+	rec = copyRecord(rec)
+	rec[VersionKey] = 1
+	if err := v.Store.Insert(ctx, collection, rec); err != nil {
+		return err
+	}
+	ver := Version{RecordID: id, Version: 1, Time: time.Now().UTC(), Actor: actor, Action: ActionCreate, Record: rec}
+	return v.appendVersion(ctx, collection, ver)
+}
+
+// Update sets given fields of the record and appends new version. If
+// expected version is positive the update fails with ErrVersionConflict
+// when the record has different version, i.e. it was modified by others.
+func (v *VersionedStore) Update(ctx context.Context, collection, actor, comment string, id any, fields map[string]any, expected int) (Version, error) {
+	current, err := FindOne(ctx, v.Store, collection, map[string]any{"_id": id})
+	if err != nil {
+		return Version{}, err
+	}
+	cur := versionNumber(current[VersionKey])
+	if expected > 0 && expected != cur {
+		return Version{}, fmt.Errorf("%w: record %v has version %d, expected %d", ErrVersionConflict, id, cur, expected)
+	}
+	next := copyRecord(current)
+	for k, val := range fields {
+		if k == "_id" || k == VersionKey {
+			continue
+		}
+		next[k] = val
+	}
+	next[VersionKey] = cur + 1
+	set := copyRecord(next)
+	delete(set, "_id")
+	// version condition protects against concurrent updates
+	nrec, err := v.Store.Update(ctx, collection, map[string]any{"_id": id, VersionKey: current[VersionKey]}, set)
+	if err != nil {
+		return Version{}, err
+	}
+	if nrec == 0 {
+		return Version{}, fmt.Errorf("%w: record %v was modified concurrently", ErrVersionConflict, id)
+	}
+	ver := Version{RecordID: id, Version: cur + 1, Time: time.Now().UTC(), Actor: actor, Action: ActionUpdate, Comment: comment, Record: next}
+	return ver, v.appendVersion(ctx, collection, ver)
+}
+
+// Delete removes the record and appends deletion version, history of the
+// record is preserved
+func (v *VersionedStore) Delete(ctx context.Context, collection, actor, comment string, id any) error {
+	current, err := FindOne(ctx, v.Store, collection, map[string]any{"_id": id})
+	if err != nil {
+		return err
+	}
+	if _, err := v.Store.Remove(ctx, collection, map[string]any{"_id": id}); err != nil {
+		return err
+	}
+	ver := Version{
+		RecordID: id,
+		Version:  versionNumber(current[VersionKey]) + 1,
+		Time:     time.Now().UTC(),
+		Actor:    actor,
+		Action:   ActionDelete,
+		Comment:  comment,
+	}
+	return v.appendVersion(ctx, collection, ver)
+}
+
+// History returns all versions of the record, oldest first
+func (v *VersionedStore) History(ctx context.Context, collection string, id any) ([]Version, error) {
+	records, err := v.Store.Find(ctx, HistoryCollection(collection), map[string]any{"record_id": id}, &FindOptions{Sort: []string{"version"}})
+	if err != nil {
+		return nil, err
+	}
+	var out []Version
+	for _, rec := range records {
+		out = append(out, toVersion(rec))
+	}
+	return out, nil
+}
+
+// GetVersion returns given version of the record or ErrNotFound
+func (v *VersionedStore) GetVersion(ctx context.Context, collection string, id any, version int) (Version, error) {
+	rec, err := FindOne(ctx, v.Store, HistoryCollection(collection), map[string]any{"record_id": id, "version": version})
+	if err != nil {
+		return Version{}, err
+	}
+	return toVersion(rec), nil
+}
+
+// DiffRecords returns changes of record keys between two records
+func DiffRecords(before, after map[string]any) map[string]Change {
+	diff := make(map[string]Change)
+	for k, val := range before {
+		if nv, ok := after[k]; !ok || !equal(val, nv) {
+			diff[k] = Change{Before: val, After: after[k]}
+		}
+	}
+	for k, val := range after {
+		if _, ok := before[k]; !ok {
+			diff[k] = Change{After: val}
+		}
+	}
+	delete(diff, VersionKey)
+	return diff
+}
+
+// Diff returns changes of the record between two versions
+func (v *VersionedStore) Diff(ctx context.Context, collection string, id any, from, to int) (map[string]Change, error) {
+	v1, err := v.GetVersion(ctx, collection, id, from)
+	if err != nil {
+		return nil, fmt.Errorf("version %d: %w", from, err)
+	}
+	v2, err := v.GetVersion(ctx, collection, id, to)
+	if err != nil {
+		return nil, fmt.Errorf("version %d: %w", to, err)
+	}
+	return DiffRecords(v1.Record, v2.Record), nil
+}
@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// TestVersionedStore
+func TestVersionedStore(t *testing.T) {
+	ctx := context.Background()
+	vs := NewVersionedStore(NewMemoryStore())
+	rec := map[string]any{"_id": "did-1", "beamline": "3a", "cycle": "2024-1"}
+	if err := vs.Insert(ctx, "meta", "alice", rec); err != nil {
+		t.Fatal(err)
+	}
+	ver, err := vs.Update(ctx, "meta", "bob", "fix beamline", "did-1", map[string]any{"beamline": "3b"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ver.Version != 2 || ver.Record["beamline"] != "3b" {
+		t.Errorf("invalid version %+v", ver)
+	}
+	// stale version should be rejected
+	_, err = vs.Update(ctx, "meta", "carol", "", "did-1", map[string]any{"beamline": "3c"}, 1)
+	if !errors.Is(err, ErrVersionConflict) {
+		t.Error("expect version conflict, got", err)
+	}
+	cur, err := FindOne(ctx, vs.Store, "meta", map[string]any{"_id": "did-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cur["beamline"] != "3b" || versionNumber(cur[VersionKey]) != 2 {
+		t.Errorf("invalid current record %+v", cur)
+	}
+
+	history, err := vs.History(ctx, "meta", "did-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("invalid history %+v", history)
+	}
+	if history[0].Actor != "alice" || history[0].Action != ActionCreate {
+		t.Errorf("invalid first version %+v", history[0])
+	}
+	if history[1].Actor != "bob" || history[1].Comment != "fix beamline" {
+		t.Errorf("invalid second version %+v", history[1])
+	}
+	v1, err := vs.GetVersion(ctx, "meta", "did-1", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v1.Record["beamline"] != "3a" {
+		t.Error("first version was modified", v1.Record)
+	}
+	if _, err := vs.GetVersion(ctx, "meta", "did-1", 5); !errors.Is(err, ErrNotFound) {
+		t.Error("expect not found error, got", err)
+	}
+
+	diff, err := vs.Diff(ctx, "meta", "did-1", 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff) != 1 || diff["beamline"].Before != "3a" || diff["beamline"].After != "3b" {
+		t.Errorf("invalid diff %+v", diff)
+	}
+
+	if err := vs.Delete(ctx, "meta", "carol", "duplicate", "did-1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := FindOne(ctx, vs.Store, "meta", map[string]any{"_id": "did-1"}); !errors.Is(err, ErrNotFound) {
+		t.Error("record was not removed", err)
+	}
+	history, _ = vs.History(ctx, "meta", "did-1")
+	if len(history) != 3 || history[2].Action != ActionDelete || history[2].Version != 3 {
+		t.Errorf("invalid history after delete %+v", history)
+	}
+}
+
+// TestDiffRecords
+func TestDiffRecords(t *testing.T) {
+	before := map[string]any{"a": 1, "b": "x", "c": true, VersionKey: 1}
+	after := map[string]any{"a": 1.0, "b": "y", "d": "new", VersionKey: 2}
+	diff := DiffRecords(before, after)
+	if len(diff) != 3 {
+		t.Fatalf("invalid diff %+v", diff)
+	}
+	if diff["c"].Before != true || diff["c"].After != nil {
+		t.Errorf("invalid removed key %+v", diff["c"])
+	}
+	if diff["d"].Before != nil || diff["d"].After != "new" {
+		t.Errorf("invalid added key %+v", diff["d"])
+	}
+}
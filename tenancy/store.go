@@ -0,0 +1,105 @@
+package tenancy
+
+import (
+	"context"
+
+	"github.com/CHESSComputing/golib/storage"
+)
+
+// Store represents storage which scopes all queries by tenant of the
+// context. Tenant name is added to records and specs under TenantKey and
+// tenant collection suffix is applied to collection names.
+type Store struct {
+	Store storage.Store
+}
+
+// NewStore returns tenant scoped storage
+func NewStore(store storage.Store) *Store {
+	return &Store{Store: store}
+}
+
+// helper function to provide tenant collection and spec
+func (s *Store) scope(ctx context.Context, collection string, spec map[string]any) (string, map[string]any, error) {
+	t, ok := FromContext(ctx)
+	if !ok {
+		return "", nil, ErrNoTenant
+	}
+	scoped := make(map[string]any, len(spec)+1)
+	for k, v := range spec {
+		scoped[k] = v
+	}
+	scoped[TenantKey] = t.Name
+	return Collection(t, collection), scoped, nil
+}
+
+// Insert implements storage.Store interface
+func (s *Store) Insert(ctx context.Context, collection string, records ...map[string]any) error {
+	coll, _, err := s.scope(ctx, collection, nil)
+	if err != nil {
+		return err
+	}
+	t, _ := FromContext(ctx)
+	var scoped []map[string]any
+	for _, rec := range records {
+		r := make(map[string]any, len(rec)+1)
+		for k, v := range rec {
+			r[k] = v
+		}
+		r[TenantKey] = t.Name
+		scoped = append(scoped, r)
+	}
+	return s.Store.Insert(ctx, coll, scoped...)
+}
+
+// Find implements storage.Store interface
+func (s *Store) Find(ctx context.Context, collection string, spec map[string]any, opts *storage.FindOptions) ([]map[string]any, error) {
+	coll, scoped, err := s.scope(ctx, collection, spec)
+	if err != nil {
+		return nil, err
+	}
+	return s.Store.Find(ctx, coll, scoped, opts)
+}
+
+// Update implements storage.Store interface, tenant of records can not be
+// changed
+func (s *Store) Update(ctx context.Context, collection string, spec, fields map[string]any) (int64, error) {
+	coll, scoped, err := s.scope(ctx, collection, spec)
+	if err != nil {
+		return 0, err
+	}
+	if _, ok := fields[TenantKey]; ok {
+		f := make(map[string]any, len(fields))
+		for k, v := range fields {
+			if k != TenantKey {
+				f[k] = v
+			}
+		}
+		fields = f
+	}
+	return s.Store.Update(ctx, coll, scoped, fields)
+}
+
+// Count implements storage.Store interface
+func (s *Store) Count(ctx context.Context, collection string, spec map[string]any) (int64, error) {
+	coll, scoped, err := s.scope(ctx, collection, spec)
+	if err != nil {
+		return 0, err
+	}
+	return s.Store.Count(ctx, coll, scoped)
+}
+
+// Remove implements storage.Store interface
+func (s *Store) Remove(ctx context.Context, collection string, spec map[string]any) (int64, error) {
+	coll, scoped, err := s.scope(ctx, collection, spec)
+	if err != nil {
+		return 0, err
+	}
+	return s.Store.Remove(ctx, coll, scoped)
+}
+
+// Filter adds tenant of the context to MongoDB filter, it is used by
+// services which query MongoDB directly
+func Filter(ctx context.Context, spec map[string]any) (map[string]any, error) {
+	_, scoped, err := (&Store{}).scope(ctx, "", spec)
+	return scoped, err
+}
@@ -0,0 +1,232 @@
+package tenancy
+
+// tenancy module allows single FOXDEN/CHESS deployment to serve multiple
+// CHESS partner facilities or beamlines. Tenant is derived from request
+// host or JWT claim, it is carried within request context and storage
+// helpers scope all queries by tenant.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	srvConfig "github.com/CHESSComputing/golib/config"
+	services "github.com/CHESSComputing/golib/services"
+	"github.com/gin-gonic/gin"
+)
+
+// TenantKey defines record key and gin context key of tenant name
+const TenantKey = "tenant"
+
+// ErrNoTenant is returned when request or context does not have tenant
+var ErrNoTenant = errors.New("tenant is not defined")
+
+// ErrUnknownTenant is returned for tenants which are not configured
+var ErrUnknownTenant = errors.New("unknown tenant")
+
+// ErrNoTenantClaim is returned for tokens of authenticated users without
+// tenant claim when tenants are configured
+var ErrNoTenantClaim = errors.New("token does not have tenant claim")
+
+// Tenants represents tenants configuration, it should be initialized via
+// Init function
+var Tenants *Registry
+
+// Registry represents set of configured tenants
+type Registry struct {
+	Default string
+	Header  string
+	tenants map[string]srvConfig.Tenant
+	hosts   map[string]string
+}
+
+// NewRegistry returns registry of tenants from tenancy configuration
+func NewRegistry(cfg srvConfig.Tenancy) (*Registry, error) {
+	r := &Registry{
+		Default: cfg.Default,
+		Header:  cfg.Header,
+		tenants: make(map[string]srvConfig.Tenant),
+		hosts:   make(map[string]string),
+	}
+	for _, t := range cfg.Tenants {
+		if t.Name == "" {
+			return nil, errors.New("tenant without name")
+		}
+		if _, ok := r.tenants[t.Name]; ok {
+			return nil, fmt.Errorf("duplicate tenant %s", t.Name)
+		}
+		r.tenants[t.Name] = t
+		for _, host := range t.Hosts {
+			host = strings.ToLower(host)
+			if other, ok := r.hosts[host]; ok {
+				return nil, fmt.Errorf("host %s is used by tenants %s and %s", host, other, t.Name)
+			}
+			r.hosts[host] = t.Name
+		}
+	}
+	if r.Default != "" {
+		if _, ok := r.tenants[r.Default]; !ok {
+			return nil, fmt.Errorf("%w: default tenant %s", ErrUnknownTenant, r.Default)
+		}
+	}
+	return r, nil
+}
+
+// Init initializes tenants from server configuration
+func Init() error {
+	if srvConfig.Config == nil {
+		return errors.New("server configuration is not initialized")
+	}
+	registry, err := NewRegistry(srvConfig.Config.Tenancy)
+	if err != nil {
+		log.Println("ERROR: unable to initialize tenants", err)
+		return err
+	}
+	Tenants = registry
+	return nil
+}
+
+// Tenant returns configuration of tenant with given name
+func (r *Registry) Tenant(name string) (srvConfig.Tenant, error) {
+	t, ok := r.tenants[name]
+	if !ok {
+		return t, fmt.Errorf("%w: %s", ErrUnknownTenant, name)
+	}
+	return t, nil
+}
+
+// Names returns sorted names of all tenants
+func (r *Registry) Names() []string {
+	var names []string
+	for name := range r.tenants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ByHost returns tenant name of given host, port is ignored
+func (r *Registry) ByHost(host string) (string, bool) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	name, ok := r.hosts[strings.ToLower(host)]
+	return name, ok
+}
+
+// Resolve derives tenant of HTTP request. Tenant JWT claim takes
+// precedence, then request host and default tenant. Tokens of
+// authenticated users require tenant claim when tenants are configured, and
+// it is an error if host tenant differs from tenant of JWT claim. Request
+// host is taken from X-Forwarded-Host header only behind trusted proxy.
+// Tenant header does not select tenant, it is honored only if it matches
+// the resolved tenant, i.e. the tenant of JWT claim or request host.
+func (r *Registry) Resolve(req *http.Request, claims *authz.Claims) (srvConfig.Tenant, error) {
+	hostTenant, hasHost := r.ByHost(authz.RequestHost(req))
+	name := ""
+	if claims != nil && !authz.IsAnonymous(claims) && claims.CustomClaims.Tenant == "" && len(r.tenants) > 0 {
+		return srvConfig.Tenant{}, fmt.Errorf("%w of user %s", ErrNoTenantClaim, claims.CustomClaims.User)
+	}
+	if claims != nil && claims.CustomClaims.Tenant != "" {
+		name = claims.CustomClaims.Tenant
+		if hasHost && hostTenant != name {
+			return srvConfig.Tenant{}, fmt.Errorf("token of tenant %s is used with host of tenant %s", name, hostTenant)
+		}
+	} else if hasHost {
+		name = hostTenant
+	} else {
+		name = r.Default
+	}
+	if r.Header != "" {
+		if val := req.Header.Get(r.Header); val != "" && val != name {
+			return srvConfig.Tenant{}, fmt.Errorf("tenant %s is requested with token or host of tenant '%s'", val, name)
+		}
+	}
+	if name == "" {
+		return srvConfig.Tenant{}, ErrNoTenant
+	}
+	return r.Tenant(name)
+}
+
+// tenantKey represents context key of tenant
+type tenantKey struct{}
+
+// WithTenant returns context with given tenant
+func WithTenant(ctx context.Context, t srvConfig.Tenant) context.Context {
+	return context.WithValue(ctx, tenantKey{}, t)
+}
+
+// FromContext returns tenant of the context
+func FromContext(ctx context.Context) (srvConfig.Tenant, bool) {
+	t, ok := ctx.Value(tenantKey{}).(srvConfig.Tenant)
+	return t, ok
+}
+
+// DBName returns tenant database name for given base name
+func DBName(t srvConfig.Tenant, dbname string) string {
+	return dbname + t.DBSuffix
+}
+
+// Collection returns tenant collection name for given base name
+func Collection(t srvConfig.Tenant, coll string) string {
+	return coll + t.CollSuffix
+}
+
+// SchemaFiles returns tenant schema files or default ones if tenant does
+// not define them
+func SchemaFiles(t srvConfig.Tenant, defaults []string) []string {
+	if len(t.SchemaFiles) > 0 {
+		return t.SchemaFiles
+	}
+	return defaults
+}
+
+// helper function to get request claims either from gin context set by
+// authz middleware or from request token, requests with invalid tokens are
+// rejected
+func requestClaims(c *gin.Context, clientId string) (*authz.Claims, error) {
+	if val, ok := c.Get("claims"); ok {
+		if claims, ok := val.(*authz.Claims); ok {
+			return claims, nil
+		}
+	}
+	token := authz.RequestToken(c.Request)
+	if token == "" || clientId == "" {
+		return nil, nil
+	}
+	return authz.TokenClaims(token, clientId)
+}
+
+// Middleware provides gin middleware which derives tenant of the request
+// and stores it in request context and in gin context under TenantKey
+func Middleware(registry *Registry, clientId string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, err := requestClaims(c, clientId)
+		if err != nil {
+			log.Printf("ERROR: invalid token of request %s, error %v", c.Request.URL.Path, err)
+			rec := services.Response("tenancy", http.StatusUnauthorized, services.TokenError, err)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, rec)
+			return
+		}
+		t, err := registry.Resolve(c.Request, claims)
+		if err != nil {
+			log.Printf("ERROR: unable to resolve tenant of request %s, error %v", c.Request.URL.Path, err)
+			code := http.StatusBadRequest
+			if !errors.Is(err, ErrNoTenant) && !errors.Is(err, ErrUnknownTenant) {
+				code = http.StatusForbidden
+			}
+			rec := services.Response("tenancy", code, services.ParametersError, err)
+			c.AbortWithStatusJSON(code, rec)
+			return
+		}
+		c.Request = c.Request.WithContext(WithTenant(c.Request.Context(), t))
+		c.Set(TenantKey, t.Name)
+		c.Next()
+	}
+}
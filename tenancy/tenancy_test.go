@@ -0,0 +1,174 @@
+package tenancy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	srvConfig "github.com/CHESSComputing/golib/config"
+	"github.com/CHESSComputing/golib/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// helper function to create test registry
+func testRegistry(t *testing.T) *Registry {
+	cfg := srvConfig.Tenancy{
+		Default: "chess",
+		Header:  "X-Tenant",
+		Tenants: []srvConfig.Tenant{
+			{Name: "chess", Hosts: []string{"foxden.chess.cornell.edu"}},
+			{Name: "partner", Hosts: []string{"foxden.partner.org"}, DBSuffix: "_partner", CollSuffix: "_p"},
+		},
+	}
+	r, err := NewRegistry(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// TestResolve
+func TestResolve(t *testing.T) {
+	r := testRegistry(t)
+	req := httptest.NewRequest("GET", "http://foxden.partner.org:8344/meta", nil)
+	if tenant, err := r.Resolve(req, nil); err != nil || tenant.Name != "partner" {
+		t.Errorf("unexpected tenant %+v error %v", tenant, err)
+	}
+	claims := &authz.Claims{CustomClaims: authz.CustomClaims{Tenant: "chess"}}
+	if _, err := r.Resolve(req, claims); err == nil {
+		t.Error("token of other tenant should be rejected")
+	}
+	req = httptest.NewRequest("GET", "http://localhost/meta", nil)
+	if tenant, err := r.Resolve(req, nil); err != nil || tenant.Name != "chess" {
+		t.Errorf("expected default tenant, got %+v error %v", tenant, err)
+	}
+	// tenant header can not select tenant without matching token or host
+	req.Header.Set("X-Tenant", "partner")
+	if _, err := r.Resolve(req, nil); err == nil {
+		t.Error("tenant header without matching token or host should be rejected")
+	}
+	partnerClaims := &authz.Claims{CustomClaims: authz.CustomClaims{Tenant: "partner"}}
+	if tenant, err := r.Resolve(req, partnerClaims); err != nil || tenant.Name != "partner" {
+		t.Errorf("tenant header matching token claim should be accepted, got %+v error %v", tenant, err)
+	}
+	if _, err := r.Resolve(req, claims); err == nil {
+		t.Error("tenant header of other tenant than token claim should be rejected")
+	}
+	// host behind trusted proxy
+	orig := srvConfig.Config
+	defer func() { srvConfig.Config = orig }()
+	srvConfig.Config = &srvConfig.SrvConfig{}
+	srvConfig.Config.Frontend.TrustedProxies = []string{"192.0.2.1"}
+	req.Header.Set("X-Forwarded-Host", "foxden.partner.org")
+	if tenant, err := r.Resolve(req, nil); err != nil || tenant.Name != "partner" {
+		t.Errorf("tenant header matching forwarded host should be accepted, got %+v error %v", tenant, err)
+	}
+	// forwarded host spoofed by direct client is ignored
+	req = httptest.NewRequest("GET", "http://localhost/meta", nil)
+	req.RemoteAddr = "203.0.113.7:4321"
+	req.Header.Set("X-Forwarded-Host", "foxden.partner.org")
+	if tenant, err := r.Resolve(req, nil); err != nil || tenant.Name != "chess" {
+		t.Errorf("spoofed forwarded host selects tenant %+v error %v", tenant, err)
+	}
+	anonymous := &authz.Claims{CustomClaims: authz.CustomClaims{User: "guest", Kind: authz.AnonymousKind}}
+	if tenant, err := r.Resolve(req, anonymous); err != nil || tenant.Name != "chess" {
+		t.Errorf("spoofed forwarded host selects tenant %+v of anonymous user, error %v", tenant, err)
+	}
+	// authenticated users require tenant claim
+	userClaims := &authz.Claims{CustomClaims: authz.CustomClaims{User: "alice"}}
+	if _, err := r.Resolve(req, userClaims); !errors.Is(err, ErrNoTenantClaim) {
+		t.Errorf("token without tenant claim should be rejected, error %v", err)
+	}
+	claims = &authz.Claims{CustomClaims: authz.CustomClaims{Tenant: "unknown"}}
+	req = httptest.NewRequest("GET", "http://localhost/meta", nil)
+	if _, err := r.Resolve(req, claims); !errors.Is(err, ErrUnknownTenant) {
+		t.Errorf("expected ErrUnknownTenant, got %v", err)
+	}
+	if _, err := NewRegistry(srvConfig.Tenancy{Tenants: []srvConfig.Tenant{{Name: "a"}, {Name: "a"}}}); err == nil {
+		t.Error("duplicate tenants should be rejected")
+	}
+}
+
+// TestStore
+func TestStore(t *testing.T) {
+	mem := storage.NewMemoryStore()
+	store := NewStore(mem)
+	r := testRegistry(t)
+	chess, _ := r.Tenant("chess")
+	partner, _ := r.Tenant("partner")
+	ctxChess := WithTenant(context.Background(), chess)
+	ctxPartner := WithTenant(context.Background(), partner)
+
+	if err := store.Insert(context.Background(), "meta", map[string]any{"did": 1}); !errors.Is(err, ErrNoTenant) {
+		t.Errorf("expected ErrNoTenant, got %v", err)
+	}
+	store.Insert(ctxChess, "meta", map[string]any{"did": 1})
+	store.Insert(ctxPartner, "meta", map[string]any{"did": 1}, map[string]any{"did": 2})
+	if n, _ := store.Count(ctxChess, "meta", nil); n != 1 {
+		t.Errorf("unexpected number of chess records %d", n)
+	}
+	if n, _ := store.Count(ctxPartner, "meta", nil); n != 2 {
+		t.Errorf("unexpected number of partner records %d", n)
+	}
+	if n, _ := mem.Count(context.Background(), "meta_p", map[string]any{TenantKey: "partner"}); n != 2 {
+		t.Errorf("partner records should be stored in suffixed collection, found %d", n)
+	}
+	if n, _ := store.Update(ctxChess, "meta", map[string]any{"did": 2}, map[string]any{"owner": "alice"}); n != 0 {
+		t.Errorf("update should not cross tenants, matched %d", n)
+	}
+	if n, _ := store.Remove(ctxPartner, "meta", map[string]any{"did": 1}); n != 1 {
+		t.Errorf("unexpected number of removed records %d", n)
+	}
+	if DBName(partner, "chess") != "chess_partner" {
+		t.Errorf("unexpected tenant database name %s", DBName(partner, "chess"))
+	}
+}
+
+// TestMiddleware
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Middleware(testRegistry(t), "secret"))
+	r.GET("/tenant", func(c *gin.Context) {
+		tenant, _ := FromContext(c.Request.Context())
+		c.String(http.StatusOK, tenant.Name)
+	})
+	req := httptest.NewRequest("GET", "http://foxden.partner.org/tenant", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Body.String() != "partner" {
+		t.Errorf("unexpected tenant %s", w.Body.String())
+	}
+	token, _ := authz.JWTAccessToken("secret", 60, authz.CustomClaims{User: "alice", Tenant: "chess"})
+	req.Header.Set("Authorization", "Bearer "+token)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("cross tenant token should be forbidden, code %d", w.Code)
+	}
+	req.Header.Set("Authorization", "Bearer invalid")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("invalid token should be rejected, code %d", w.Code)
+	}
+	req = httptest.NewRequest("GET", "http://localhost/tenant", nil)
+	req.Header.Set("X-Tenant", "partner")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("tenant header without token or host should be forbidden, code %d", w.Code)
+	}
+	token, _ = authz.JWTAccessToken("secret", 60, authz.CustomClaims{User: "alice"})
+	req = httptest.NewRequest("GET", "http://localhost/tenant", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("X-Forwarded-Host", "foxden.partner.org")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("token without tenant claim should be forbidden, code %d", w.Code)
+	}
+}
@@ -0,0 +1,207 @@
+package testutil
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	mongo "github.com/CHESSComputing/golib/mongo"
+	storage "github.com/CHESSComputing/golib/storage"
+	bson "go.mongodb.org/mongo-driver/bson"
+	mongoDriver "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoImage defines docker image of MongoDB test containers
+var MongoImage = "mongo:7.0"
+
+// MongoStartTimeout defines how long to wait for MongoDB server to start
+var MongoStartTimeout = 60 * time.Second
+
+// MongoURIEnv defines environment variable with URI of MongoDB server to use
+// instead of starting new one, every test still gets its own database
+const MongoURIEnv = "FOXDEN_TEST_MONGO_URI"
+
+// MongodEnv defines environment variable with path of mongod binary
+const MongodEnv = "FOXDEN_TEST_MONGOD"
+
+// MongoIndex represents index created before test runs, keys are field
+// names and fields prefixed with minus sign are indexed in descending order
+type MongoIndex struct {
+	Collection string
+	Keys       []string
+	Unique     bool
+}
+
+// model returns MongoDB index model of the index
+func (i MongoIndex) model() mongoDriver.IndexModel {
+	var keys bson.D
+	for _, key := range i.Keys {
+		if strings.HasPrefix(key, "-") {
+			keys = append(keys, bson.E{Key: strings.TrimPrefix(key, "-"), Value: -1})
+		} else {
+			keys = append(keys, bson.E{Key: key, Value: 1})
+		}
+	}
+	return mongoDriver.IndexModel{Keys: keys, Options: options.Index().SetUnique(i.Unique)}
+}
+
+// MongoDB represents MongoDB database of integration test
+type MongoDB struct {
+	URI    string              // URI of MongoDB server
+	DBName string              // name of test database
+	Client *mongoDriver.Client // connected client
+}
+
+// Database returns test database
+func (m *MongoDB) Database() *mongoDriver.Database {
+	return m.Client.Database(m.DBName)
+}
+
+// Store returns document store of test database
+func (m *MongoDB) Store() *storage.MongoStore {
+	return storage.NewMongoStore(m.DBName)
+}
+
+// helper function to get free TCP port of local host
+func freePort() (int, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port, nil
+}
+
+// helper function to start mongod binary with temporary database path
+func startMongod(t testing.TB, binary string) (string, error) {
+	port, err := freePort()
+	if err != nil {
+		return "", err
+	}
+	cmd := exec.Command(binary,
+		"--dbpath", t.TempDir(),
+		"--bind_ip", "127.0.0.1",
+		"--port", fmt.Sprintf("%d", port))
+	if err := cmd.Start(); err != nil {
+		return "", err
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	return fmt.Sprintf("mongodb://127.0.0.1:%d", port), nil
+}
+
+// helper function to start MongoDB docker container
+func startContainer(t testing.TB, docker string) (string, error) {
+	out, err := exec.Command(docker, "run", "-d", "--rm", "-p", "127.0.0.1::27017", MongoImage).Output()
+	if err != nil {
+		return "", fmt.Errorf("unable to start %s container, error %v", MongoImage, err)
+	}
+	cid := strings.TrimSpace(string(out))
+	t.Cleanup(func() { exec.Command(docker, "rm", "-f", cid).Run() })
+	out, err = exec.Command(docker, "port", cid, "27017/tcp").Output()
+	if err != nil {
+		return "", fmt.Errorf("unable to get port of container %s, error %v", cid, err)
+	}
+	// docker may report several addresses, e.g. for IPv4 and IPv6
+	addr := strings.Fields(string(out))
+	if len(addr) == 0 {
+		return "", fmt.Errorf("container %s does not expose MongoDB port", cid)
+	}
+	return "mongodb://" + addr[0], nil
+}
+
+// helper function to provide MongoDB server of the test: URI of existing
+// server, mongod binary or docker container, in that order
+func mongoServer(t testing.TB) string {
+	if uri := os.Getenv(MongoURIEnv); uri != "" {
+		return uri
+	}
+	binary := os.Getenv(MongodEnv)
+	if binary == "" {
+		binary, _ = exec.LookPath("mongod")
+	}
+	if binary != "" {
+		uri, err := startMongod(t, binary)
+		if err != nil {
+			t.Fatalf("unable to start %s, error %v", binary, err)
+		}
+		return uri
+	}
+	if docker, err := exec.LookPath("docker"); err == nil {
+		uri, err := startContainer(t, docker)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return uri
+	}
+	t.Skipf("MongoDB is not available: set %s, install mongod or docker", MongoURIEnv)
+	return ""
+}
+
+// helper function to connect to MongoDB server, the server is pinged until
+// it accepts connections
+func connect(uri string) (*mongoDriver.Client, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), MongoStartTimeout)
+	defer cancel()
+	client, err := mongoDriver.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		return nil, err
+	}
+	for {
+		err = client.Ping(ctx, nil)
+		if err == nil {
+			return client, nil
+		}
+		select {
+		case <-ctx.Done():
+			client.Disconnect(context.Background())
+			return nil, fmt.Errorf("MongoDB %s is not available, error %v", uri, err)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// WithMongo runs given function against MongoDB database of the test. The
+// database has unique name, given indexes are applied before the function
+// runs and database is dropped afterwards. The mongo module connection is
+// set to the test server for the duration of the function, therefore tests
+// using WithMongo should not run in parallel. Tests are skipped if MongoDB
+// server can not be provided.
+func WithMongo(t testing.TB, fn func(db *MongoDB), indexes ...MongoIndex) {
+	t.Helper()
+	uri := mongoServer(t)
+	client, err := connect(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	suffix := make([]byte, 6)
+	rand.Read(suffix)
+	db := &MongoDB{URI: uri, DBName: "test_" + hex.EncodeToString(suffix), Client: client}
+	ctx := context.Background()
+	for _, idx := range indexes {
+		if _, err := db.Database().Collection(idx.Collection).Indexes().CreateOne(ctx, idx.model()); err != nil {
+			t.Fatalf("unable to create index %+v, error %v", idx, err)
+		}
+	}
+
+	prev := mongo.Mongo
+	mongo.Mongo = mongo.Connection{URI: uri, Client: client}
+	defer func() {
+		mongo.Mongo = prev
+		if err := db.Database().Drop(ctx); err != nil {
+			t.Errorf("unable to drop database %s, error %v", db.DBName, err)
+		}
+		client.Disconnect(ctx)
+	}()
+	fn(db)
+}
@@ -0,0 +1,166 @@
+package testutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	services "github.com/CHESSComputing/golib/services"
+)
+
+// names of fake FOXDEN services
+const (
+	Frontend        = "frontend"
+	Discovery       = "discovery"
+	MetaData        = "metadata"
+	DataManagement  = "datamanagement"
+	DataBookkeeping = "databookkeeping"
+	Authz           = "authz"
+)
+
+// Request represents HTTP request received by fake service
+type Request struct {
+	Service string
+	Method  string
+	Path    string
+	Query   string
+	Header  http.Header
+	Body    []byte
+}
+
+// Services represents fake FOXDEN services served by httptest servers,
+// every service responds with registered handlers and records received
+// requests; Authz service issues tokens on /oauth/token endpoint
+type Services struct {
+	Issuer *TokenIssuer // issuer of Authz service tokens
+
+	mu       sync.Mutex
+	servers  map[string]*httptest.Server
+	handlers map[string]http.HandlerFunc
+	requests []Request
+}
+
+// NewServices starts fake FOXDEN services, they are closed when test
+// completes
+func NewServices(t testing.TB) *Services {
+	t.Helper()
+	s := &Services{
+		Issuer:   NewTokenIssuer(),
+		servers:  make(map[string]*httptest.Server),
+		handlers: make(map[string]http.HandlerFunc),
+	}
+	for _, name := range []string{Frontend, Discovery, MetaData, DataManagement, DataBookkeeping, Authz} {
+		srv := httptest.NewServer(s.handler(name))
+		s.servers[name] = srv
+		t.Cleanup(srv.Close)
+	}
+	s.Handle(Authz, "", "/oauth/token", s.tokenHandler)
+	return s
+}
+
+// URL returns URL of given fake service
+func (s *Services) URL(service string) string {
+	if srv, ok := s.servers[service]; ok {
+		return srv.URL
+	}
+	return ""
+}
+
+// Handle registers handler of fake service, empty method matches any HTTP
+// method
+func (s *Services) Handle(service, method, path string, handler http.HandlerFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[service+" "+method+" "+path] = handler
+}
+
+// HandleJSON registers handler which responds with given HTTP code and JSON
+// representation of data
+func (s *Services) HandleJSON(service, method, path string, code int, data any) {
+	s.Handle(service, method, path, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, code, data)
+	})
+}
+
+// helper function to write JSON response
+func writeJSON(w http.ResponseWriter, code int, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(data)
+}
+
+// Requests returns requests received by given fake service, empty service
+// name returns requests of all services
+func (s *Services) Requests(service string) []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []Request
+	for _, r := range s.requests {
+		if service == "" || r.Service == service {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// helper function to lookup registered handler of the request
+func (s *Services) lookup(service string, r *http.Request) (http.HandlerFunc, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if h, ok := s.handlers[service+" "+r.Method+" "+r.URL.Path]; ok {
+		return h, true
+	}
+	h, ok := s.handlers[service+" "+" "+r.URL.Path]
+	return h, ok
+}
+
+// helper function to create HTTP handler of fake service
+func (s *Services) handler(service string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		r.Body.Close()
+		s.mu.Lock()
+		s.requests = append(s.requests, Request{
+			Service: service,
+			Method:  r.Method,
+			Path:    r.URL.Path,
+			Query:   r.URL.RawQuery,
+			Header:  r.Header.Clone(),
+			Body:    body,
+		})
+		s.mu.Unlock()
+		h, ok := s.lookup(service, r)
+		if !ok {
+			err := fmt.Errorf("%s %s is not handled by fake %s service", r.Method, r.URL.Path, service)
+			writeJSON(w, http.StatusNotFound, services.Response(service, http.StatusNotFound, services.NotImplementedApiCode, err))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		h(w, r)
+	}
+}
+
+// helper function to issue token of client credentials, the request should
+// provide client id and secret of test configuration
+func (s *Services) tokenHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("client_id") != s.Issuer.ClientID || query.Get("client_secret") != ClientSecret {
+		err := errors.New("invalid client credentials")
+		writeJSON(w, http.StatusUnauthorized, services.Response(Authz, http.StatusUnauthorized, services.CredentialsError, err))
+		return
+	}
+	claims := authz.CustomClaims{User: "testutil", Scope: query.Get("scope"), Kind: "client_credentials"}
+	token, err := authz.JWTAccessToken(s.Issuer.ClientID, s.Issuer.Expires, claims)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, services.Response(Authz, http.StatusInternalServerError, services.TokenError, err))
+		return
+	}
+	writeJSON(w, http.StatusOK, services.Token{AccessToken: token, TokenType: "bearer", Expires: s.Issuer.Expires})
+}
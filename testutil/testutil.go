@@ -0,0 +1,99 @@
+package testutil
+
+// testutil module provides fixtures and fakes to write unit tests of
+// FOXDEN services without MongoDB or real Authz server
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	storage "github.com/CHESSComputing/golib/storage"
+)
+
+// ClientID defines deterministic secret used to sign test tokens
+const ClientID = "testutil-client-id"
+
+// ClientSecret defines client secret of test configuration
+const ClientSecret = "testutil-client-secret"
+
+// Fixtures represents records of document store keyed by collection name
+type Fixtures map[string][]map[string]any
+
+// NewStore returns in-memory document store seeded with given fixtures
+func NewStore(t testing.TB, fixtures Fixtures) *storage.MemoryStore {
+	t.Helper()
+	store := storage.NewMemoryStore()
+	Seed(t, store, fixtures)
+	return store
+}
+
+// Seed inserts given fixtures into document store
+func Seed(t testing.TB, store storage.Store, fixtures Fixtures) {
+	t.Helper()
+	for collection, records := range fixtures {
+		if err := store.Insert(context.Background(), collection, records...); err != nil {
+			t.Fatalf("unable to insert fixtures into %s, error %v", collection, err)
+		}
+	}
+}
+
+// LoadFixtures reads fixtures from JSON file, the file should contain
+// object with collection names as keys and lists of records as values
+func LoadFixtures(t testing.TB, fname string) Fixtures {
+	t.Helper()
+	data, err := os.ReadFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fixtures Fixtures
+	if err := json.Unmarshal(data, &fixtures); err != nil {
+		t.Fatalf("unable to parse fixtures %s, error %v", fname, err)
+	}
+	return fixtures
+}
+
+// NewConfig returns server configuration of tests with deterministic Authz
+// credentials, given functions are applied to configuration in order
+func NewConfig(opts ...func(*srvConfig.SrvConfig)) *srvConfig.SrvConfig {
+	cfg := &srvConfig.SrvConfig{}
+	cfg.Authz.ClientID = ClientID
+	cfg.Authz.ClientSecret = ClientSecret
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+}
+
+// WithServices sets Services URLs of configuration to fake services
+func WithServices(s *Services) func(*srvConfig.SrvConfig) {
+	return func(cfg *srvConfig.SrvConfig) {
+		cfg.Services.FrontendURL = s.URL(Frontend)
+		cfg.Services.DiscoveryURL = s.URL(Discovery)
+		cfg.Services.MetaDataURL = s.URL(MetaData)
+		cfg.Services.DataManagementURL = s.URL(DataManagement)
+		cfg.Services.DataBookkeepingURL = s.URL(DataBookkeeping)
+		cfg.Services.AuthzURL = s.URL(Authz)
+	}
+}
+
+// WithMetaDataDB sets MongoDB parameters of MetaData configuration
+func WithMetaDataDB(uri, dbname, collection string) func(*srvConfig.SrvConfig) {
+	return func(cfg *srvConfig.SrvConfig) {
+		cfg.MetaData.MongoDB.DBUri = uri
+		cfg.MetaData.MongoDB.DBName = dbname
+		cfg.MetaData.MongoDB.DBColl = collection
+	}
+}
+
+// SetConfig sets global server configuration used by library modules,
+// previous configuration is restored when test completes
+func SetConfig(t testing.TB, cfg *srvConfig.SrvConfig) *srvConfig.SrvConfig {
+	t.Helper()
+	prev := srvConfig.Config
+	srvConfig.Config = cfg
+	t.Cleanup(func() { srvConfig.Config = prev })
+	return cfg
+}
@@ -0,0 +1,185 @@
+package testutil
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	srvConfig "github.com/CHESSComputing/golib/config"
+	services "github.com/CHESSComputing/golib/services"
+	"github.com/gin-gonic/gin"
+	bson "go.mongodb.org/mongo-driver/bson"
+)
+
+// TestStore
+func TestStore(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "fixtures.json")
+	data := `{"meta": [{"did": "/a/b/c", "cycle": "2024-1"}, {"did": "/a/b/d", "cycle": "2024-2"}]}`
+	if err := os.WriteFile(fname, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	store := NewStore(t, LoadFixtures(t, fname))
+	Seed(t, store, Fixtures{"files": {{"did": "/a/b/c", "file": "/data/file1"}}})
+	ctx := context.Background()
+	if count, err := store.Count(ctx, "meta", map[string]any{"cycle": "2024-1"}); err != nil || count != 1 {
+		t.Errorf("unexpected count %d, error %v", count, err)
+	}
+	if count, err := store.Count(ctx, "files", map[string]any{}); err != nil || count != 1 {
+		t.Errorf("unexpected count %d, error %v", count, err)
+	}
+}
+
+// TestConfig
+func TestConfig(t *testing.T) {
+	prev := srvConfig.Config
+	t.Run("set", func(t *testing.T) {
+		cfg := SetConfig(t, NewConfig(WithMetaDataDB("mongodb://localhost:8230", "foxden", "meta")))
+		if srvConfig.Config != cfg || cfg.Authz.ClientID != ClientID || cfg.MetaData.MongoDB.DBName != "foxden" {
+			t.Errorf("unexpected configuration %+v", srvConfig.Config)
+		}
+	})
+	if srvConfig.Config != prev {
+		t.Error("configuration is not restored")
+	}
+}
+
+// TestTokenIssuer
+func TestTokenIssuer(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	issuer := NewTokenIssuer()
+	token := issuer.UserToken(t, "alice", "write", "admin")
+	claims, err := issuer.Verify(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.CustomClaims.User != "alice" || claims.CustomClaims.Scope != "write" || claims.CustomClaims.Roles[0] != "admin" {
+		t.Errorf("unexpected claims %+v", claims.CustomClaims)
+	}
+	if _, err := (&TokenIssuer{ClientID: "other"}).Verify(token); err == nil {
+		t.Error("token is verified with another key")
+	}
+
+	// tokens are accepted by authz middleware with test configuration
+	SetConfig(t, NewConfig())
+	r := gin.New()
+	r.GET("/data", authz.ScopeTokenMiddleware("write", ClientID, 0), func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+	req := httptest.NewRequest(http.MethodGet, "/data", nil)
+	issuer.Authorize(t, req, "alice", "write")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("authorized request is rejected with %d", w.Code)
+	}
+	req = httptest.NewRequest(http.MethodGet, "/data", nil)
+	issuer.Authorize(t, req, "alice", "read")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code == http.StatusOK {
+		t.Error("token with wrong scope is accepted")
+	}
+
+	// claims middleware
+	r = gin.New()
+	r.GET("/user", ClaimsMiddleware("bob", "read"), func(c *gin.Context) {
+		claims := c.MustGet("claims").(*authz.Claims)
+		c.String(http.StatusOK, claims.CustomClaims.User)
+	})
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/user", nil))
+	if w.Body.String() != "bob" {
+		t.Errorf("unexpected user %s", w.Body.String())
+	}
+}
+
+// TestServices
+func TestServices(t *testing.T) {
+	fake := NewServices(t)
+	SetConfig(t, NewConfig(WithServices(fake)))
+	fake.HandleJSON(MetaData, http.MethodGet, "/meta", http.StatusOK, []map[string]any{{"did": "/a/b/c"}})
+
+	// token is obtained from fake Authz service
+	hreq := services.NewHttpRequest("read", 0)
+	hreq.GetToken()
+	claims, err := fake.Issuer.Verify(hreq.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.CustomClaims.Scope != "read" {
+		t.Errorf("unexpected claims %+v", claims.CustomClaims)
+	}
+
+	resp, err := hreq.Get(srvConfig.Config.Services.MetaDataURL + "/meta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []map[string]any
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err := json.Unmarshal(data, &records); err != nil || len(records) != 1 {
+		t.Errorf("unexpected response %s, error %v", data, err)
+	}
+	resp, err = hreq.Get(srvConfig.Config.Services.DiscoveryURL + "/search")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unhandled request returns %d", resp.StatusCode)
+	}
+
+	reqs := fake.Requests(MetaData)
+	if len(reqs) != 1 || reqs[0].Header.Get("Authorization") != "Bearer "+hreq.Token {
+		t.Errorf("unexpected requests %+v", reqs)
+	}
+	if len(fake.Requests("")) != 3 {
+		t.Errorf("unexpected number of requests %d", len(fake.Requests("")))
+	}
+
+	// wrong credentials are rejected
+	srvConfig.Config.Authz.ClientSecret = "wrong"
+	hreq = services.NewHttpRequest("read", 0)
+	hreq.GetToken()
+	if hreq.Token != "" {
+		t.Error("token is issued with wrong credentials")
+	}
+}
+
+// TestMongoIndex
+func TestMongoIndex(t *testing.T) {
+	model := MongoIndex{Collection: "meta", Keys: []string{"did", "-date"}, Unique: true}.model()
+	keys := model.Keys.(bson.D)
+	if len(keys) != 2 || keys[0].Key != "did" || keys[0].Value != 1 || keys[1].Key != "date" || keys[1].Value != -1 {
+		t.Errorf("unexpected index keys %+v", keys)
+	}
+	if !*model.Options.Unique {
+		t.Error("index is not unique")
+	}
+}
+
+// TestWithMongo requires MongoDB server, mongod binary or docker
+func TestWithMongo(t *testing.T) {
+	ctx := context.Background()
+	var dbname string
+	WithMongo(t, func(db *MongoDB) {
+		dbname = db.DBName
+		store := db.Store()
+		Seed(t, store, Fixtures{"meta": {{"did": "/a/b/c"}}})
+		if err := store.Insert(ctx, "meta", map[string]any{"did": "/a/b/c"}); err == nil {
+			t.Error("unique index is not applied")
+		}
+		if count, err := store.Count(ctx, "meta", map[string]any{}); err != nil || count != 1 {
+			t.Errorf("unexpected count %d, error %v", count, err)
+		}
+	}, MongoIndex{Collection: "meta", Keys: []string{"did"}, Unique: true})
+	if dbname == "" {
+		t.Fatal("function is not called")
+	}
+}
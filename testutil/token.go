@@ -0,0 +1,59 @@
+package testutil
+
+import (
+	"net/http"
+	"testing"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	"github.com/gin-gonic/gin"
+)
+
+// TokenIssuer issues and verifies JWT tokens signed with deterministic key,
+// tokens are accepted by authz middlewares configured with the same client id
+type TokenIssuer struct {
+	ClientID string // secret used to sign tokens
+	Expires  int64  // token lifetime in seconds
+}
+
+// NewTokenIssuer returns token issuer which signs tokens with ClientID
+func NewTokenIssuer() *TokenIssuer {
+	return &TokenIssuer{ClientID: ClientID, Expires: 3600}
+}
+
+// Issue returns signed token with given claims
+func (i *TokenIssuer) Issue(t testing.TB, claims authz.CustomClaims) string {
+	t.Helper()
+	token, err := authz.JWTAccessToken(i.ClientID, i.Expires, claims)
+	if err != nil {
+		t.Fatalf("unable to issue token, error %v", err)
+	}
+	return token
+}
+
+// UserToken returns signed token of given user, scope and roles
+func (i *TokenIssuer) UserToken(t testing.TB, user, scope string, roles ...string) string {
+	t.Helper()
+	return i.Issue(t, authz.CustomClaims{User: user, Scope: scope, Kind: "user", Roles: roles})
+}
+
+// Verify returns claims of given token if it is signed by the issuer
+func (i *TokenIssuer) Verify(token string) (*authz.Claims, error) {
+	return authz.TokenClaims(token, i.ClientID)
+}
+
+// Authorize sets bearer token of given user to HTTP request
+func (i *TokenIssuer) Authorize(t testing.TB, r *http.Request, user, scope string, roles ...string) {
+	t.Helper()
+	r.Header.Set("Authorization", "Bearer "+i.UserToken(t, user, scope, roles...))
+}
+
+// ClaimsMiddleware returns gin middleware which sets claims of given user
+// to gin context in the same way as authz middlewares do, it can be used to
+// test handlers without tokens
+func ClaimsMiddleware(user, scope string, roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := &authz.Claims{CustomClaims: authz.CustomClaims{User: user, Scope: scope, Roles: roles}}
+		c.Set("claims", claims)
+		c.Next()
+	}
+}
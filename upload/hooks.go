@@ -0,0 +1,55 @@
+package upload
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+
+	extractors "github.com/CHESSComputing/golib/extractors"
+	pubsub "github.com/CHESSComputing/golib/pubsub"
+)
+
+// FilenameKey defines upload metadata key of original file name
+var FilenameKey = "filename"
+
+// ExtractHook returns hook which extracts metadata attributes of completed
+// uploads staged on local disk and passes them to given function. The
+// extractor is looked up by extension of original file name and then by
+// magic bytes of the file, uploads without extractor are skipped.
+func ExtractHook(fn func(ctx context.Context, info Info, attrs map[string]any) error) Hook {
+	return func(ctx context.Context, info Info) error {
+		var extractor extractors.Extractor
+		var err error
+		if name := info.Metadata[FilenameKey]; name != "" {
+			// original file does not exist, i.e. lookup by magic bytes fails
+			// for unknown extensions
+			extractor, err = extractors.Lookup(filepath.Join(filepath.Dir(info.Location), filepath.Base(name)))
+		}
+		if extractor == nil {
+			extractor, err = extractors.Lookup(info.Location)
+		}
+		if errors.Is(err, extractors.ErrNoExtractor) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		attrs, err := extractor.Extract(info.Location)
+		if err != nil {
+			return err
+		}
+		return fn(ctx, info, attrs)
+	}
+}
+
+// PublishHook returns hook which publishes upload.completed event with
+// upload info to given subject of message bus
+func PublishHook(bus pubsub.Bus, subject, source string) Hook {
+	return func(ctx context.Context, info Info) error {
+		env, err := pubsub.NewEnvelope(pubsub.EventUploadCompleted, source, info)
+		if err != nil {
+			return err
+		}
+		return bus.Publish(ctx, subject, env)
+	}
+}
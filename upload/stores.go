@@ -0,0 +1,390 @@
+package upload
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	s3 "github.com/CHESSComputing/golib/storage/s3"
+)
+
+// upload ids are generated by handler, other ids are rejected to prevent
+// access outside of staging area
+var idPattern = regexp.MustCompile(`^[0-9a-zA-Z_-]+$`)
+
+// helper function to validate upload id
+func validID(id string) error {
+	if !idPattern.MatchString(id) {
+		return fmt.Errorf("%w: invalid id '%s'", ErrNotFound, id)
+	}
+	return nil
+}
+
+// countingReader counts number of read bytes
+type countingReader struct {
+	io.Reader
+	n int64
+}
+
+// Read implements io.Reader interface
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// helper function to copy upload content into sha256 hash and optional
+// writer, it returns hex digest of the content
+func digest(r io.Reader, w io.Writer) (string, error) {
+	sum := sha256.New()
+	var writer io.Writer = sum
+	if w != nil {
+		writer = io.MultiWriter(sum, w)
+	}
+	if _, err := io.Copy(writer, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(sum.Sum(nil)), nil
+}
+
+// DiskStore stages uploads in local directory, every upload consists of
+// <id>.bin data file and <id>.info state file
+type DiskStore struct {
+	Dir string
+}
+
+// NewDiskStore returns disk store of given directory, the directory is
+// created if it does not exist
+func NewDiskStore(dir string) (*DiskStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &DiskStore{Dir: dir}, nil
+}
+
+// helper functions to provide file names of the upload
+func (s *DiskStore) dataFile(id string) string {
+	return filepath.Join(s.Dir, id+".bin")
+}
+func (s *DiskStore) infoFile(id string) string {
+	return filepath.Join(s.Dir, id+".info")
+}
+
+// helper function to save upload state, state file is replaced atomically
+func (s *DiskStore) save(info Info) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	tmp := s.infoFile(info.ID) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.infoFile(info.ID))
+}
+
+// Create implements Store interface
+func (s *DiskStore) Create(ctx context.Context, info Info) error {
+	if err := validID(info.ID); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(s.dataFile(info.ID), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	file.Close()
+	return s.save(info)
+}
+
+// Info implements Store interface
+func (s *DiskStore) Info(ctx context.Context, id string) (Info, error) {
+	var info Info
+	if err := validID(id); err != nil {
+		return info, err
+	}
+	data, err := os.ReadFile(s.infoFile(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return info, ErrNotFound
+	}
+	if err != nil {
+		return info, err
+	}
+	err = json.Unmarshal(data, &info)
+	return info, err
+}
+
+// Write implements Store interface, data received before read error is
+// kept unless verify function is provided
+func (s *DiskStore) Write(ctx context.Context, info Info, r io.Reader, verify func() error) (int64, error) {
+	file, err := os.OpenFile(s.dataFile(info.ID), os.O_WRONLY, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	if _, err := file.Seek(info.Offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(file, r)
+	if err == nil && verify != nil {
+		err = verify()
+	}
+	if err != nil && verify != nil {
+		file.Truncate(info.Offset)
+		return 0, err
+	}
+	if n > 0 {
+		info.Offset += n
+		if serr := s.save(info); serr != nil {
+			return 0, serr
+		}
+	}
+	return n, err
+}
+
+// Finish implements Store interface, location of the upload is its data
+// file
+func (s *DiskStore) Finish(ctx context.Context, info Info, w io.Writer) (Info, error) {
+	fname := s.dataFile(info.ID)
+	// remove stale data beyond upload size, e.g. after interrupted writes
+	if err := os.Truncate(fname, info.Size); err != nil {
+		return info, err
+	}
+	file, err := os.Open(fname)
+	if err != nil {
+		return info, err
+	}
+	defer file.Close()
+	sum, err := digest(file, w)
+	if err != nil {
+		return info, err
+	}
+	info.Completed = true
+	info.Checksum = sum
+	info.Location = fname
+	return info, s.save(info)
+}
+
+// Delete implements Store interface
+func (s *DiskStore) Delete(ctx context.Context, id string) error {
+	if err := validID(id); err != nil {
+		return err
+	}
+	if err := os.Remove(s.infoFile(id)); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return ErrNotFound
+		}
+		return err
+	}
+	if err := os.Remove(s.dataFile(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// List implements Store interface
+func (s *DiskStore) List(ctx context.Context) ([]Info, error) {
+	files, err := filepath.Glob(filepath.Join(s.Dir, "*.info"))
+	if err != nil {
+		return nil, err
+	}
+	var uploads []Info
+	for _, fname := range files {
+		info, err := s.Info(ctx, strings.TrimSuffix(filepath.Base(fname), ".info"))
+		if err != nil {
+			continue
+		}
+		uploads = append(uploads, info)
+	}
+	return uploads, nil
+}
+
+// S3Store stages uploads in S3 bucket. Every received chunk is stored as
+// <prefix><id>/parts/<offset> object which are concatenated into
+// <prefix><id>/data object on completion, the state of the upload is
+// stored in <prefix><id>.info object. Chunks are stored as a whole, i.e.
+// interrupted chunks are discarded.
+type S3Store struct {
+	Client *s3.Client
+	Bucket string // bucket name, default bucket of the client is used if empty
+	Prefix string // key prefix of uploads
+}
+
+// helper functions to provide object keys of the upload
+func (s *S3Store) infoKey(id string) string {
+	return s.Prefix + id + ".info"
+}
+func (s *S3Store) partsPrefix(id string) string {
+	return s.Prefix + id + "/parts/"
+}
+func (s *S3Store) dataKey(id string) string {
+	return s.Prefix + id + "/data"
+}
+
+// helper function to save upload state
+func (s *S3Store) save(ctx context.Context, info Info) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	return s.Client.PutObject(ctx, s.Bucket, s.infoKey(info.ID), bytes.NewReader(data), "application/json")
+}
+
+// Create implements Store interface
+func (s *S3Store) Create(ctx context.Context, info Info) error {
+	if err := validID(info.ID); err != nil {
+		return err
+	}
+	return s.save(ctx, info)
+}
+
+// Info implements Store interface
+func (s *S3Store) Info(ctx context.Context, id string) (Info, error) {
+	var info Info
+	if err := validID(id); err != nil {
+		return info, err
+	}
+	rc, err := s.Client.GetObject(ctx, s.Bucket, s.infoKey(id))
+	if errors.Is(err, s3.ErrNotFound) {
+		return info, ErrNotFound
+	}
+	if err != nil {
+		return info, err
+	}
+	defer rc.Close()
+	err = json.NewDecoder(rc).Decode(&info)
+	return info, err
+}
+
+// Write implements Store interface
+func (s *S3Store) Write(ctx context.Context, info Info, r io.Reader, verify func() error) (int64, error) {
+	// zero padded offsets keep lexicographic order of parts
+	key := fmt.Sprintf("%s%020d", s.partsPrefix(info.ID), info.Offset)
+	reader := &countingReader{Reader: r}
+	if err := s.Client.PutObject(ctx, s.Bucket, key, reader, "application/octet-stream"); err != nil {
+		return 0, err
+	}
+	var err error
+	if verify != nil {
+		err = verify()
+	}
+	if err != nil || reader.n == 0 {
+		s.Client.DeleteObject(ctx, s.Bucket, key)
+		return 0, err
+	}
+	info.Offset += reader.n
+	if err := s.save(ctx, info); err != nil {
+		return 0, err
+	}
+	return reader.n, nil
+}
+
+// helper function to list part keys of the upload in order of their
+// offsets
+func (s *S3Store) parts(ctx context.Context, id string) ([]string, error) {
+	objects, err := s.Client.ListObjects(ctx, s.Bucket, s.partsPrefix(id))
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, obj := range objects {
+		keys = append(keys, obj.Key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Finish implements Store interface, location of the upload is key of
+// concatenated object
+func (s *S3Store) Finish(ctx context.Context, info Info, w io.Writer) (Info, error) {
+	keys, err := s.parts(ctx, info.ID)
+	if err != nil {
+		return info, err
+	}
+	// stream parts into data object and checksum writers
+	pr, pw := io.Pipe()
+	go func() {
+		for _, key := range keys {
+			rc, err := s.Client.GetObject(ctx, s.Bucket, key)
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			_, err = io.Copy(pw, rc)
+			rc.Close()
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		pw.Close()
+	}()
+	sum := sha256.New()
+	var writer io.Writer = sum
+	if w != nil {
+		writer = io.MultiWriter(sum, w)
+	}
+	reader := &countingReader{Reader: io.TeeReader(pr, writer)}
+	err = s.Client.PutObject(ctx, s.Bucket, s.dataKey(info.ID), reader, "application/octet-stream")
+	pr.CloseWithError(err)
+	if err != nil {
+		return info, err
+	}
+	if reader.n != info.Size {
+		return info, fmt.Errorf("size of upload %s parts %d does not match upload size %d", info.ID, reader.n, info.Size)
+	}
+	for _, key := range keys {
+		s.Client.DeleteObject(ctx, s.Bucket, key)
+	}
+	info.Completed = true
+	info.Checksum = hex.EncodeToString(sum.Sum(nil))
+	info.Location = s.dataKey(info.ID)
+	return info, s.save(ctx, info)
+}
+
+// Delete implements Store interface
+func (s *S3Store) Delete(ctx context.Context, id string) error {
+	if _, err := s.Info(ctx, id); err != nil {
+		return err
+	}
+	objects, err := s.Client.ListObjects(ctx, s.Bucket, s.Prefix+id+"/")
+	if err != nil {
+		return err
+	}
+	for _, obj := range objects {
+		if err := s.Client.DeleteObject(ctx, s.Bucket, obj.Key); err != nil {
+			return err
+		}
+	}
+	return s.Client.DeleteObject(ctx, s.Bucket, s.infoKey(id))
+}
+
+// List implements Store interface
+func (s *S3Store) List(ctx context.Context) ([]Info, error) {
+	objects, err := s.Client.ListObjects(ctx, s.Bucket, s.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	var uploads []Info
+	for _, obj := range objects {
+		id := strings.TrimPrefix(obj.Key, s.Prefix)
+		if !strings.HasSuffix(id, ".info") || strings.Contains(id, "/") {
+			continue
+		}
+		info, err := s.Info(ctx, strings.TrimSuffix(id, ".info"))
+		if err != nil {
+			continue
+		}
+		uploads = append(uploads, info)
+	}
+	return uploads, nil
+}
@@ -0,0 +1,612 @@
+package upload
+
+// upload module implements resumable uploads of data files via tus
+// protocol (https://tus.io/protocols/resumable-upload). Uploads are staged
+// on local disk or S3 compatible storage, interrupted uploads are resumed
+// from the last stored offset, checksums are verified on completion and
+// hooks are triggered for completed uploads, e.g. to extract metadata.
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	server "github.com/CHESSComputing/golib/server"
+	services "github.com/CHESSComputing/golib/services"
+	s3 "github.com/CHESSComputing/golib/storage/s3"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// tus protocol constants
+const (
+	TusVersion        = "1.0.0"
+	TusExtensions     = "creation,creation-with-upload,termination,checksum,expiration"
+	OffsetContentType = "application/offset+octet-stream"
+)
+
+// StatusChecksumMismatch defines HTTP status code of checksum mismatch
+// defined by tus checksum extension
+const StatusChecksumMismatch = 460
+
+// ChecksumKey defines upload metadata key of expected checksum of the
+// whole file, e.g. "sha256 <hex digest>"
+var ChecksumKey = "checksum"
+
+// upload errors
+var (
+	ErrNotFound         = errors.New("upload not found")
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+	ErrUnknownAlgorithm = errors.New("unsupported checksum algorithm")
+)
+
+// Info represents state of an upload
+type Info struct {
+	ID        string            `json:"id"`
+	Size      int64             `json:"size"`
+	Offset    int64             `json:"offset"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	Owner     string            `json:"owner,omitempty"`
+	Created   time.Time         `json:"created"`
+	Expires   time.Time         `json:"expires,omitempty"`
+	Completed bool              `json:"completed"`
+	Checksum  string            `json:"checksum,omitempty"` // sha256 digest of completed upload
+	Location  string            `json:"location,omitempty"` // file name or object key of completed upload
+}
+
+// Expired checks if incomplete upload is expired
+func (i Info) Expired(now time.Time) bool {
+	return !i.Completed && !i.Expires.IsZero() && now.After(i.Expires)
+}
+
+// Store defines interface of upload staging backends
+type Store interface {
+	// Create creates new upload
+	Create(ctx context.Context, info Info) error
+	// Info returns state of the upload
+	Info(ctx context.Context, id string) (Info, error)
+	// Write appends data of reader at current offset of the upload and
+	// returns number of stored bytes. If verify function is provided it is
+	// called after all data is read and written data is discarded if it
+	// fails or if reading fails.
+	Write(ctx context.Context, info Info, r io.Reader, verify func() error) (int64, error)
+	// Finish finalizes completed upload and sets its location and sha256
+	// checksum, the content of the upload is also written to w if it is
+	// not nil, e.g. to calculate other checksums
+	Finish(ctx context.Context, info Info, w io.Writer) (Info, error)
+	// Delete deletes the upload
+	Delete(ctx context.Context, id string) error
+	// List returns all uploads
+	List(ctx context.Context) ([]Info, error)
+}
+
+// Hook defines function triggered for completed uploads
+type Hook func(ctx context.Context, info Info) error
+
+// Handler represents tus upload endpoint
+type Handler struct {
+	Store      Store         // staging backend
+	BasePath   string        // base path of upload endpoint used in Location header
+	MaxSize    int64         // maximum upload size, 0 means no limit
+	Expiration time.Duration // expiration of incomplete uploads, 0 means no expiration
+	Hooks      []Hook        // hooks triggered for completed uploads
+	Interval   time.Duration // interval of expired uploads purge used by Run
+	Verbose    int
+
+	locks sync.Map
+	hooks sync.WaitGroup
+}
+
+// NewHandler returns upload handler for given store
+func NewHandler(store Store, basePath string) *Handler {
+	return &Handler{Store: store, BasePath: strings.TrimSuffix(basePath, "/"), Interval: time.Hour}
+}
+
+// New returns upload handler based on upload configuration
+func New(cfg srvConfig.Upload) (*Handler, error) {
+	var store Store
+	switch cfg.Backend {
+	case "", "disk":
+		if cfg.Dir == "" {
+			return nil, errors.New("upload staging directory is not configured")
+		}
+		dstore, err := NewDiskStore(cfg.Dir)
+		if err != nil {
+			log.Printf("ERROR: unable to create upload staging area %s, error %v", cfg.Dir, err)
+			return nil, err
+		}
+		store = dstore
+	case "s3":
+		if srvConfig.Config == nil {
+			return nil, errors.New("server configuration is not initialized")
+		}
+		client := s3.NewClient(srvConfig.Config.DataManagement.S3)
+		store = &S3Store{Client: client, Prefix: cfg.Prefix}
+	default:
+		return nil, fmt.Errorf("unsupported upload backend '%s'", cfg.Backend)
+	}
+	basePath := cfg.BasePath
+	if basePath == "" {
+		basePath = "/upload"
+	}
+	h := NewHandler(store, basePath)
+	h.MaxSize = int64(cfg.MaxSize)
+	h.Expiration = time.Duration(cfg.Expiration) * time.Hour
+	return h, nil
+}
+
+// Routes returns server routes of upload endpoint, all routes except
+// OPTIONS require token with write scope
+func (h *Handler) Routes() []server.Route {
+	path := h.BasePath
+	return []server.Route{
+		{Method: "OPTIONS", Path: path, Handler: h.OptionsHandler,
+			Summary: "report tus protocol version and extensions"},
+		{Method: "POST", Path: path, Authorized: true, Scope: "write", Handler: h.CreateHandler,
+			Summary: "create resumable upload"},
+		{Method: "HEAD", Path: path + "/:id", Authorized: true, Scope: "write", Handler: h.HeadHandler,
+			Summary: "get offset of resumable upload"},
+		{Method: "PATCH", Path: path + "/:id", Authorized: true, Scope: "write", Handler: h.PatchHandler,
+			Summary: "append data to resumable upload"},
+		{Method: "DELETE", Path: path + "/:id", Authorized: true, Scope: "write", Handler: h.DeleteHandler,
+			Summary: "terminate resumable upload"},
+	}
+}
+
+// helper function to lock the upload, it returns false if upload is locked
+// by another request
+func (h *Handler) lock(id string) (*sync.Mutex, bool) {
+	val, _ := h.locks.LoadOrStore(id, &sync.Mutex{})
+	mtx := val.(*sync.Mutex)
+	return mtx, mtx.TryLock()
+}
+
+// helper function to abort request with service error
+func abort(c *gin.Context, code, srvCode int, err error) {
+	if c.Request.Method == "HEAD" {
+		c.AbortWithStatus(code)
+		return
+	}
+	rec := services.Response("upload", code, srvCode, err)
+	c.AbortWithStatusJSON(code, rec)
+}
+
+// helper function to set common tus headers and check protocol version of
+// the request
+func (h *Handler) checkVersion(c *gin.Context) bool {
+	c.Header("Tus-Resumable", TusVersion)
+	if c.Request.Method == "OPTIONS" {
+		return true
+	}
+	if v := c.GetHeader("Tus-Resumable"); v != TusVersion {
+		c.Header("Tus-Version", TusVersion)
+		abort(c, http.StatusPreconditionFailed, services.ParametersError,
+			fmt.Errorf("unsupported tus protocol version '%s'", v))
+		return false
+	}
+	return true
+}
+
+// OptionsHandler reports tus protocol version and supported extensions
+func (h *Handler) OptionsHandler(c *gin.Context) {
+	h.checkVersion(c)
+	c.Header("Tus-Version", TusVersion)
+	c.Header("Tus-Extension", TusExtensions)
+	c.Header("Tus-Checksum-Algorithm", strings.Join(Algorithms(), ","))
+	if h.MaxSize > 0 {
+		c.Header("Tus-Max-Size", strconv.FormatInt(h.MaxSize, 10))
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// CreateHandler creates new upload, the upload may contain initial data
+// (creation-with-upload extension)
+func (h *Handler) CreateHandler(c *gin.Context) {
+	if !h.checkVersion(c) {
+		return
+	}
+	if c.GetHeader("Upload-Defer-Length") != "" {
+		abort(c, http.StatusBadRequest, services.ParametersError, errors.New("deferred upload length is not supported"))
+		return
+	}
+	size, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || size < 0 {
+		abort(c, http.StatusBadRequest, services.ParametersError, errors.New("invalid Upload-Length header"))
+		return
+	}
+	if h.MaxSize > 0 && size > h.MaxSize {
+		abort(c, http.StatusRequestEntityTooLarge, services.ParametersError,
+			fmt.Errorf("upload size %d exceeds maximum size %d", size, h.MaxSize))
+		return
+	}
+	meta, err := ParseMetadata(c.GetHeader("Upload-Metadata"))
+	if err != nil {
+		abort(c, http.StatusBadRequest, services.ParametersError, err)
+		return
+	}
+	if val, ok := meta[ChecksumKey]; ok {
+		if _, _, err := parseChecksum(val, hex.DecodeString); err != nil {
+			abort(c, http.StatusBadRequest, services.ParametersError, err)
+			return
+		}
+	}
+	now := time.Now()
+	info := Info{
+		ID:       strings.ReplaceAll(uuid.NewString(), "-", ""),
+		Size:     size,
+		Metadata: meta,
+		Owner:    c.GetString("user"),
+		Created:  now,
+	}
+	if h.Expiration > 0 {
+		info.Expires = now.Add(h.Expiration)
+	}
+	if err := h.Store.Create(c.Request.Context(), info); err != nil {
+		log.Printf("ERROR: unable to create upload, error %v", err)
+		abort(c, http.StatusInternalServerError, services.WriterError, err)
+		return
+	}
+	if h.Verbose > 0 {
+		log.Printf("INFO: %s created upload %s of %d bytes", info.Owner, info.ID, size)
+	}
+	c.Header("Location", h.BasePath+"/"+info.ID)
+	if !info.Expires.IsZero() {
+		c.Header("Upload-Expires", info.Expires.UTC().Format(http.TimeFormat))
+	}
+	if c.ContentType() == OffsetContentType && c.Request.ContentLength != 0 {
+		mtx, _ := h.lock(info.ID)
+		defer mtx.Unlock()
+		if _, ok := h.write(c, info); !ok {
+			return
+		}
+	} else if size == 0 {
+		if _, ok := h.complete(c, info); !ok {
+			return
+		}
+	} else {
+		c.Header("Upload-Offset", "0")
+	}
+	c.Status(http.StatusCreated)
+}
+
+// helper function to load the upload of the request and check its owner
+func (h *Handler) info(c *gin.Context) (Info, bool) {
+	info, err := h.Store.Info(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, ErrNotFound) {
+		abort(c, http.StatusNotFound, services.QueryError, err)
+		return info, false
+	}
+	if err != nil {
+		log.Printf("ERROR: unable to load upload %s, error %v", c.Param("id"), err)
+		abort(c, http.StatusInternalServerError, services.ReaderError, err)
+		return info, false
+	}
+	if info.Owner != "" && info.Owner != c.GetString("user") {
+		abort(c, http.StatusForbidden, services.ParametersError,
+			fmt.Errorf("upload %s belongs to another user", info.ID))
+		return info, false
+	}
+	if info.Expired(time.Now()) {
+		abort(c, http.StatusGone, services.QueryError, fmt.Errorf("upload %s is expired", info.ID))
+		return info, false
+	}
+	return info, true
+}
+
+// helper function to set upload headers of HEAD and PATCH responses
+func setHeaders(c *gin.Context, info Info) {
+	c.Header("Upload-Offset", strconv.FormatInt(info.Offset, 10))
+	if !info.Expires.IsZero() && !info.Completed {
+		c.Header("Upload-Expires", info.Expires.UTC().Format(http.TimeFormat))
+	}
+}
+
+// HeadHandler reports offset of the upload
+func (h *Handler) HeadHandler(c *gin.Context) {
+	if !h.checkVersion(c) {
+		return
+	}
+	info, ok := h.info(c)
+	if !ok {
+		return
+	}
+	setHeaders(c, info)
+	c.Header("Upload-Length", strconv.FormatInt(info.Size, 10))
+	if len(info.Metadata) > 0 {
+		c.Header("Upload-Metadata", FormatMetadata(info.Metadata))
+	}
+	c.Header("Cache-Control", "no-store")
+	c.Status(http.StatusOK)
+}
+
+// PatchHandler appends data to the upload at offset given by Upload-Offset
+// header
+func (h *Handler) PatchHandler(c *gin.Context) {
+	if !h.checkVersion(c) {
+		return
+	}
+	if c.ContentType() != OffsetContentType {
+		abort(c, http.StatusUnsupportedMediaType, services.ContentTypeError,
+			fmt.Errorf("content type should be %s", OffsetContentType))
+		return
+	}
+	mtx, ok := h.lock(c.Param("id"))
+	if !ok {
+		abort(c, http.StatusLocked, services.WriterError,
+			fmt.Errorf("upload %s is in use by another request", c.Param("id")))
+		return
+	}
+	defer mtx.Unlock()
+	info, ok := h.info(c)
+	if !ok {
+		return
+	}
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil {
+		abort(c, http.StatusBadRequest, services.ParametersError, errors.New("invalid Upload-Offset header"))
+		return
+	}
+	if offset != info.Offset || info.Completed {
+		setHeaders(c, info)
+		abort(c, http.StatusConflict, services.ParametersError,
+			fmt.Errorf("upload offset %d does not match current offset %d", offset, info.Offset))
+		return
+	}
+	if _, ok := h.write(c, info); !ok {
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// helper function to write request body into the upload and complete it
+// if all data is received
+func (h *Handler) write(c *gin.Context, info Info) (Info, bool) {
+	ctx := c.Request.Context()
+	body := io.LimitReader(c.Request.Body, info.Size-info.Offset)
+	var verify func() error
+	if val := c.GetHeader("Upload-Checksum"); val != "" {
+		hsh, expect, err := parseChecksum(val, base64.StdEncoding.DecodeString)
+		if err != nil {
+			abort(c, http.StatusBadRequest, services.ParametersError, err)
+			return info, false
+		}
+		body = io.TeeReader(body, hsh)
+		verify = func() error {
+			if got := hsh.Sum(nil); string(got) != string(expect) {
+				return ErrChecksumMismatch
+			}
+			return nil
+		}
+	}
+	n, err := h.Store.Write(ctx, info, body, verify)
+	info.Offset += n
+	if errors.Is(err, ErrChecksumMismatch) {
+		abort(c, StatusChecksumMismatch, services.ValidateError, err)
+		return info, false
+	}
+	if err != nil {
+		// the client is expected to resume interrupted upload from stored
+		// offset
+		log.Printf("WARNING: upload %s is interrupted at offset %d, error %v", info.ID, info.Offset, err)
+		setHeaders(c, info)
+		abort(c, http.StatusBadRequest, services.WriterError, err)
+		return info, false
+	}
+	if h.Verbose > 1 {
+		log.Printf("INFO: upload %s received %d bytes, offset %d of %d", info.ID, n, info.Offset, info.Size)
+	}
+	if info.Offset == info.Size {
+		return h.complete(c, info)
+	}
+	setHeaders(c, info)
+	return info, true
+}
+
+// helper function to finalize completed upload, verify its checksum and
+// trigger hooks
+func (h *Handler) complete(c *gin.Context, info Info) (Info, bool) {
+	ctx := c.Request.Context()
+	var expect []byte
+	var hsh hash.Hash
+	var writer io.Writer
+	if val, ok := info.Metadata[ChecksumKey]; ok {
+		hsh, expect, _ = parseChecksum(val, hex.DecodeString)
+		writer = hsh
+	}
+	info, err := h.Store.Finish(ctx, info, writer)
+	if err != nil {
+		log.Printf("ERROR: unable to finish upload %s, error %v", info.ID, err)
+		abort(c, http.StatusInternalServerError, services.WriterError, err)
+		return info, false
+	}
+	if hsh != nil && string(hsh.Sum(nil)) != string(expect) {
+		// corrupted uploads are deleted since they can not be resumed
+		log.Printf("ERROR: checksum mismatch of upload %s, the upload is deleted", info.ID)
+		if err := h.Store.Delete(ctx, info.ID); err != nil {
+			log.Printf("ERROR: unable to delete upload %s, error %v", info.ID, err)
+		}
+		abort(c, StatusChecksumMismatch, services.ValidateError,
+			fmt.Errorf("%w of upload %s", ErrChecksumMismatch, info.ID))
+		return info, false
+	}
+	if h.Verbose > 0 {
+		log.Printf("INFO: upload %s of %d bytes is completed, location %s", info.ID, info.Size, info.Location)
+	}
+	setHeaders(c, info)
+	h.trigger(info)
+	return info, true
+}
+
+// helper function to trigger hooks of completed upload, hooks run in
+// background and their errors are logged
+func (h *Handler) trigger(info Info) {
+	if len(h.Hooks) == 0 {
+		return
+	}
+	h.hooks.Add(1)
+	go func() {
+		defer h.hooks.Done()
+		for _, hook := range h.Hooks {
+			if err := hook(context.Background(), info); err != nil {
+				log.Printf("ERROR: hook of upload %s failed, error %v", info.ID, err)
+			}
+		}
+	}()
+}
+
+// Wait waits for running hooks of completed uploads
+func (h *Handler) Wait() {
+	h.hooks.Wait()
+}
+
+// DeleteHandler terminates the upload (termination extension)
+func (h *Handler) DeleteHandler(c *gin.Context) {
+	if !h.checkVersion(c) {
+		return
+	}
+	mtx, ok := h.lock(c.Param("id"))
+	if !ok {
+		abort(c, http.StatusLocked, services.WriterError,
+			fmt.Errorf("upload %s is in use by another request", c.Param("id")))
+		return
+	}
+	defer mtx.Unlock()
+	info, ok := h.info(c)
+	if !ok {
+		return
+	}
+	if err := h.Store.Delete(c.Request.Context(), info.ID); err != nil {
+		log.Printf("ERROR: unable to delete upload %s, error %v", info.ID, err)
+		abort(c, http.StatusInternalServerError, services.RemoveError, err)
+		return
+	}
+	h.locks.Delete(info.ID)
+	c.Status(http.StatusNoContent)
+}
+
+// Purge deletes expired incomplete uploads and returns number of deleted
+// uploads
+func (h *Handler) Purge(ctx context.Context, now time.Time) (int, error) {
+	uploads, err := h.Store.List(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var count int
+	for _, info := range uploads {
+		if !info.Expired(now) {
+			continue
+		}
+		if err := h.Store.Delete(ctx, info.ID); err != nil {
+			log.Printf("ERROR: unable to delete expired upload %s, error %v", info.ID, err)
+			continue
+		}
+		h.locks.Delete(info.ID)
+		count++
+	}
+	return count, nil
+}
+
+// Run periodically purges expired uploads until context is cancelled
+func (h *Handler) Run(ctx context.Context) {
+	interval := h.Interval
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if n, err := h.Purge(ctx, now); err != nil {
+				log.Printf("ERROR: unable to purge expired uploads, error %v", err)
+			} else if n > 0 && h.Verbose > 0 {
+				log.Printf("INFO: purged %d expired uploads", n)
+			}
+		}
+	}
+}
+
+// ParseMetadata parses Upload-Metadata header, i.e. comma separated list
+// of keys and base64 encoded values
+func ParseMetadata(header string) (map[string]string, error) {
+	meta := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, val, _ := strings.Cut(pair, " ")
+		data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(val))
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of metadata key '%s', error %v", key, err)
+		}
+		meta[key] = string(data)
+	}
+	return meta, nil
+}
+
+// FormatMetadata formats metadata into Upload-Metadata header
+func FormatMetadata(meta map[string]string) string {
+	var keys []string
+	for k := range meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var pairs []string
+	for _, k := range keys {
+		pairs = append(pairs, k+" "+base64.StdEncoding.EncodeToString([]byte(meta[k])))
+	}
+	return strings.Join(pairs, ",")
+}
+
+// checksum algorithms
+var algorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+}
+
+// Algorithms returns names of supported checksum algorithms
+func Algorithms() []string {
+	var out []string
+	for name := range algorithms {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// helper function to parse checksum "<algorithm> <digest>" (or
+// "<algorithm>:<digest>") into hash and expected digest
+func parseChecksum(val string, decode func(string) ([]byte, error)) (hash.Hash, []byte, error) {
+	algo, digest, ok := strings.Cut(strings.TrimSpace(val), " ")
+	if !ok {
+		algo, digest, ok = strings.Cut(val, ":")
+	}
+	newHash, found := algorithms[strings.ToLower(algo)]
+	if !ok || !found {
+		return nil, nil, fmt.Errorf("%w '%s'", ErrUnknownAlgorithm, algo)
+	}
+	expect, err := decode(strings.TrimSpace(digest))
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid %s checksum, error %v", algo, err)
+	}
+	return newHash(), expect, nil
+}
@@ -0,0 +1,331 @@
+package upload
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	s3 "github.com/CHESSComputing/golib/storage/s3"
+	"github.com/gin-gonic/gin"
+)
+
+// helper function to setup test router of upload handler
+func testRouter(h *Handler) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		if user := c.GetHeader("X-Test-User"); user != "" {
+			c.Set("user", user)
+		}
+	})
+	for _, route := range h.Routes() {
+		r.Handle(route.Method, route.Path, route.Handler)
+	}
+	return r
+}
+
+// helper function to perform tus request
+func tus(r *gin.Engine, method, path, user string, headers map[string]string, body []byte) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, bytes.NewReader(body))
+	req.Header.Set("Tus-Resumable", TusVersion)
+	if user != "" {
+		req.Header.Set("X-Test-User", user)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// helper function to provide sha1 checksum of Upload-Checksum header
+func chunkChecksum(data []byte) string {
+	sum := sha1.Sum(data)
+	return "sha1 " + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// helper function to test upload protocol with given store
+func testUpload(t *testing.T, store Store) {
+	data := []byte("0123456789abcdefghij")
+	sum := sha256.Sum256(data)
+	digest := hex.EncodeToString(sum[:])
+
+	h := NewHandler(store, "/upload/")
+	var mtx sync.Mutex
+	var completed []Info
+	h.Hooks = []Hook{func(ctx context.Context, info Info) error {
+		mtx.Lock()
+		defer mtx.Unlock()
+		completed = append(completed, info)
+		return nil
+	}}
+	r := testRouter(h)
+
+	w := tus(r, "OPTIONS", "/upload", "", nil, nil)
+	if w.Code != http.StatusNoContent || w.Header().Get("Tus-Version") != TusVersion {
+		t.Fatalf("unexpected OPTIONS response %d %v", w.Code, w.Header())
+	}
+	if !strings.Contains(w.Header().Get("Tus-Checksum-Algorithm"), "sha256") {
+		t.Error("sha256 checksum algorithm is not reported")
+	}
+
+	meta := FormatMetadata(map[string]string{"filename": "scan.dat", ChecksumKey: "sha256 " + digest})
+	w = tus(r, "POST", "/upload", "alice", map[string]string{"Upload-Length": "20", "Upload-Metadata": meta}, nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("unable to create upload, status %d body %s", w.Code, w.Body.String())
+	}
+	location := w.Header().Get("Location")
+	if !strings.HasPrefix(location, "/upload/") {
+		t.Fatalf("unexpected location %s", location)
+	}
+
+	// first chunk
+	headers := map[string]string{"Content-Type": OffsetContentType, "Upload-Offset": "0"}
+	w = tus(r, "PATCH", location, "alice", headers, data[:8])
+	if w.Code != http.StatusNoContent || w.Header().Get("Upload-Offset") != "8" {
+		t.Fatalf("unexpected PATCH response %d offset %s", w.Code, w.Header().Get("Upload-Offset"))
+	}
+
+	// resume upload after interruption
+	w = tus(r, "HEAD", location, "alice", nil, nil)
+	if w.Code != http.StatusOK || w.Header().Get("Upload-Offset") != "8" || w.Header().Get("Upload-Length") != "20" {
+		t.Fatalf("unexpected HEAD response %d %v", w.Code, w.Header())
+	}
+	if meta, _ := ParseMetadata(w.Header().Get("Upload-Metadata")); meta["filename"] != "scan.dat" {
+		t.Errorf("unexpected metadata %v", meta)
+	}
+
+	// other users can not access the upload
+	w = tus(r, "HEAD", location, "bob", nil, nil)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("upload is accessible by other user, status %d", w.Code)
+	}
+
+	// offset mismatch
+	w = tus(r, "PATCH", location, "alice", headers, data[:8])
+	if w.Code != http.StatusConflict {
+		t.Errorf("expected conflict of offset, status %d", w.Code)
+	}
+
+	// chunk checksum mismatch is rejected and offset is kept
+	headers = map[string]string{"Content-Type": OffsetContentType, "Upload-Offset": "8", "Upload-Checksum": chunkChecksum([]byte("wrong"))}
+	w = tus(r, "PATCH", location, "alice", headers, data[8:])
+	if w.Code != StatusChecksumMismatch {
+		t.Errorf("expected checksum mismatch, status %d", w.Code)
+	}
+	w = tus(r, "HEAD", location, "alice", nil, nil)
+	if w.Header().Get("Upload-Offset") != "8" {
+		t.Errorf("offset is changed by rejected chunk, %s", w.Header().Get("Upload-Offset"))
+	}
+
+	// last chunk
+	headers["Upload-Checksum"] = chunkChecksum(data[8:])
+	w = tus(r, "PATCH", location, "alice", headers, data[8:])
+	if w.Code != http.StatusNoContent || w.Header().Get("Upload-Offset") != "20" {
+		t.Fatalf("unexpected PATCH response %d %s", w.Code, w.Body.String())
+	}
+	h.Wait()
+	if len(completed) != 1 {
+		t.Fatalf("hook is called %d times", len(completed))
+	}
+	info := completed[0]
+	if !info.Completed || info.Checksum != digest || info.Owner != "alice" || info.Location == "" {
+		t.Errorf("unexpected info of completed upload %+v", info)
+	}
+	stored, err := store.Info(context.Background(), info.ID)
+	if err != nil || !stored.Completed || stored.Location != info.Location {
+		t.Errorf("unexpected stored info %+v, error %v", stored, err)
+	}
+
+	// completed upload can not be extended
+	headers = map[string]string{"Content-Type": OffsetContentType, "Upload-Offset": "20"}
+	if w = tus(r, "PATCH", location, "alice", headers, []byte("x")); w.Code != http.StatusConflict {
+		t.Errorf("completed upload is extended, status %d", w.Code)
+	}
+
+	// termination
+	if w = tus(r, "DELETE", location, "alice", nil, nil); w.Code != http.StatusNoContent {
+		t.Errorf("unable to delete upload, status %d", w.Code)
+	}
+	if w = tus(r, "HEAD", location, "alice", nil, nil); w.Code != http.StatusNotFound {
+		t.Errorf("deleted upload is found, status %d", w.Code)
+	}
+}
+
+// TestDiskUpload tests upload protocol with disk store
+func TestDiskUpload(t *testing.T) {
+	store, err := NewDiskStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testUpload(t, store)
+}
+
+// TestS3Upload tests upload protocol with S3 store
+func TestS3Upload(t *testing.T) {
+	server := newFakeS3()
+	defer server.Close()
+	client := &s3.Client{Endpoint: server.URL, Bucket: "uploads", HttpClient: server.Client()}
+	testUpload(t, &S3Store{Client: client, Prefix: "staging/"})
+	if keys := server.keys(); len(keys) != 0 {
+		t.Errorf("objects of deleted upload are left in bucket %v", keys)
+	}
+}
+
+// TestChecksumMismatch tests deletion of corrupted uploads
+func TestChecksumMismatch(t *testing.T) {
+	dir := t.TempDir()
+	store, _ := NewDiskStore(dir)
+	h := NewHandler(store, "/upload")
+	r := testRouter(h)
+	meta := FormatMetadata(map[string]string{ChecksumKey: "sha256:" + strings.Repeat("0", 64)})
+	w := tus(r, "POST", "/upload", "", map[string]string{
+		"Upload-Length": "4", "Upload-Metadata": meta, "Content-Type": OffsetContentType,
+	}, []byte("data"))
+	if w.Code != StatusChecksumMismatch {
+		t.Errorf("expected checksum mismatch, status %d", w.Code)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Errorf("corrupted upload is not deleted, %v", files)
+	}
+
+	w = tus(r, "POST", "/upload", "", map[string]string{
+		"Upload-Length": "4", "Upload-Metadata": FormatMetadata(map[string]string{ChecksumKey: "crc32 1234"}),
+	}, nil)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("unsupported algorithm is accepted, status %d", w.Code)
+	}
+	h.MaxSize = 2
+	if w = tus(r, "POST", "/upload", "", map[string]string{"Upload-Length": "4"}, nil); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("upload exceeding maximum size is accepted, status %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/upload", nil))
+	if w.Code != http.StatusPreconditionFailed {
+		t.Errorf("request without Tus-Resumable header is accepted, status %d", w.Code)
+	}
+}
+
+// TestPurge tests purge of expired uploads
+func TestPurge(t *testing.T) {
+	store, _ := NewDiskStore(t.TempDir())
+	h := NewHandler(store, "/upload")
+	h.Expiration = time.Hour
+	r := testRouter(h)
+	w := tus(r, "POST", "/upload", "", map[string]string{"Upload-Length": "10"}, nil)
+	if w.Code != http.StatusCreated || w.Header().Get("Upload-Expires") == "" {
+		t.Fatalf("unexpected response %d %v", w.Code, w.Header())
+	}
+	ctx := context.Background()
+	if n, err := h.Purge(ctx, time.Now()); err != nil || n != 0 {
+		t.Errorf("active upload is purged, %d %v", n, err)
+	}
+	if n, err := h.Purge(ctx, time.Now().Add(2*time.Hour)); err != nil || n != 1 {
+		t.Errorf("expired upload is not purged, %d %v", n, err)
+	}
+	if uploads, _ := store.List(ctx); len(uploads) != 0 {
+		t.Errorf("unexpected uploads %v", uploads)
+	}
+}
+
+// TestMetadata tests parsing of Upload-Metadata header
+func TestMetadata(t *testing.T) {
+	meta, err := ParseMetadata("filename c2Nhbi5oNQ==, is_confidential")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta["filename"] != "scan.h5" || len(meta) != 2 {
+		t.Errorf("unexpected metadata %v", meta)
+	}
+	if _, err := ParseMetadata("filename %%%"); err == nil {
+		t.Error("invalid metadata value is accepted")
+	}
+	if _, err := NewDiskStore(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	if err := validID("../secret"); err == nil {
+		t.Error("invalid upload id is accepted")
+	}
+}
+
+// fakeS3 represents in-memory S3 server
+type fakeS3 struct {
+	*httptest.Server
+	mtx     sync.Mutex
+	objects map[string][]byte
+}
+
+// helper function to start fake S3 server which supports single part
+// uploads, downloads, deletion and listing of objects
+func newFakeS3() *fakeS3 {
+	f := &fakeS3{objects: make(map[string][]byte)}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mtx.Lock()
+		defer f.mtx.Unlock()
+		key := strings.TrimPrefix(r.URL.Path, "/uploads/")
+		switch {
+		case r.Method == "GET" && r.URL.Query().Get("list-type") == "2":
+			type object struct {
+				Key  string `xml:"Key"`
+				Size int64  `xml:"Size"`
+			}
+			var result struct {
+				XMLName  xml.Name `xml:"ListBucketResult"`
+				Contents []object `xml:"Contents"`
+			}
+			for _, k := range f.sortedKeys() {
+				if strings.HasPrefix(k, r.URL.Query().Get("prefix")) {
+					result.Contents = append(result.Contents, object{Key: k, Size: int64(len(f.objects[k]))})
+				}
+			}
+			xml.NewEncoder(w).Encode(result)
+		case r.Method == "PUT":
+			data, _ := io.ReadAll(r.Body)
+			f.objects[key] = data
+		case r.Method == "GET":
+			data, ok := f.objects[key]
+			if !ok {
+				http.Error(w, "<Error><Code>NoSuchKey</Code></Error>", http.StatusNotFound)
+				return
+			}
+			w.Write(data)
+		case r.Method == "DELETE":
+			delete(f.objects, key)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotImplemented)
+		}
+	}))
+	return f
+}
+
+// helper function to provide sorted object keys
+func (f *fakeS3) sortedKeys() []string {
+	var keys []string
+	for k := range f.objects {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// helper function to provide object keys
+func (f *fakeS3) keys() []string {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	return f.sortedKeys()
+}
@@ -0,0 +1,500 @@
+package users
+
+// handlers module provides HTTP endpoints of login, password management,
+// notification preferences and two-factor authentication of local users. Enrollment endpoints are authorized by
+// token of the user or, for users without second factor whose roles
+// require it, by login challenge passed in X-Two-Factor-Challenge header.
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	utils "github.com/CHESSComputing/golib/utils"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	server "github.com/CHESSComputing/golib/server"
+	services "github.com/CHESSComputing/golib/services"
+	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/protocol"
+)
+
+// TwoFactorChallengeHeader defines header with login challenge which
+// authorizes enrollment of the first second factor
+const TwoFactorChallengeHeader = "X-Two-Factor-Challenge"
+
+// LoginRequest represents login request of the user
+type LoginRequest struct {
+	User     string `json:"user"`
+	Password string `json:"password"`
+}
+
+// TwoFactorRequest represents second step of login or confirmation of
+// TOTP enrollment
+type TwoFactorRequest struct {
+	Challenge  string          `json:"challenge"`
+	Method     string          `json:"method"`               // totp, webauthn or recovery
+	Code       string          `json:"code,omitempty"`       // TOTP or recovery code
+	Credential json.RawMessage `json:"credential,omitempty"` // WebAuthn assertion of navigator.credentials.get
+}
+
+// TOTPEnrollment represents TOTP secret of authenticator apps
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URL    string `json:"url"`     // otpauth URL
+	QRCode []byte `json:"qr_code"` // PNG image of QR code with otpauth URL
+}
+
+// EnrollmentResponse represents response of enabled second factor, token
+// is issued if enrollment is authorized by login challenge
+type EnrollmentResponse struct {
+	RecoveryCodes []string     `json:"recovery_codes,omitempty"`
+	Token         *authz.Token `json:"token,omitempty"`
+}
+
+// PasswordRequest represents change of password of the user
+type PasswordRequest struct {
+	User        string `json:"user"`
+	Password    string `json:"password"`
+	NewPassword string `json:"new_password"`
+}
+
+// ResetRequest represents password reset forced by administrator, with
+// notify the user gets password reset token
+type ResetRequest struct {
+	Notify bool `json:"notify"`
+}
+
+// NotificationsResponse represents notification preferences of the user
+// with event types and channels which frontends may offer
+type NotificationsResponse struct {
+	Events        []string      `json:"events"`
+	Channels      []string      `json:"channels"`
+	Notifications Notifications `json:"notifications"`
+}
+
+// helper function to abort request with service error
+func abort(c *gin.Context, code, srvCode int, err error) {
+	rec := services.Response("users", code, srvCode, err)
+	c.AbortWithStatusJSON(code, rec)
+}
+
+// helper function to abort request with error of the manager
+func abortWithError(c *gin.Context, err error) {
+	var perr *protocol.Error
+	switch {
+	case errors.Is(err, ErrInvalidCredentials), errors.Is(err, ErrInvalidToken),
+		errors.Is(err, authz.ErrInvalidTOTP), errors.Is(err, authz.ErrInvalidRecoveryCode), errors.As(err, &perr):
+		abort(c, http.StatusUnauthorized, services.CredentialsError, err)
+	case errors.Is(err, ErrUserDisabled), errors.Is(err, ErrEmailNotVerified), errors.Is(err, authz.ErrTwoFactorMissing),
+		errors.Is(err, ErrPasswordExpired):
+		abort(c, http.StatusForbidden, services.CredentialsError, err)
+	case errors.Is(err, ErrTwoFactorEnabled):
+		abort(c, http.StatusConflict, services.ValidateError, err)
+	case errors.Is(err, ErrTwoFactorNotEnrolled), errors.Is(err, authz.ErrWebAuthnDisabled), errors.Is(err, ErrWeakPassword),
+		errors.Is(err, ErrInvalidNotifications):
+		abort(c, http.StatusBadRequest, services.ValidateError, err)
+	case errors.Is(err, ErrUserNotFound):
+		abort(c, http.StatusNotFound, services.QueryError, err)
+	default:
+		log.Printf("ERROR: users request failed, error %v", err)
+		abort(c, http.StatusInternalServerError, services.DatabaseError, err)
+	}
+}
+
+// helper function to provide response of issued access token
+func (m *Manager) tokenResponse(token string) (*authz.Token, error) {
+	claims, err := authz.TokenClaims(token, m.Secret)
+	if err != nil {
+		return nil, err
+	}
+	return &authz.Token{
+		AccessToken: token,
+		Expires:     claims.ExpiresAt.Unix() - time.Now().Unix(),
+		Scope:       claims.CustomClaims.Scope,
+		TokenType:   "bearer",
+	}, nil
+}
+
+// helper function to respond with access token
+func (m *Manager) respondToken(c *gin.Context, token string) {
+	rec, err := m.tokenResponse(token)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, rec)
+}
+
+// helper function to provide claims of token of the request
+func (m *Manager) requestClaims(c *gin.Context) (*authz.Claims, bool) {
+	if val, ok := c.Get("claims"); ok {
+		if claims, ok := val.(*authz.Claims); ok && claims != nil {
+			return claims, true
+		}
+	}
+	claims, err := authz.TokenClaims(authz.RequestToken(c.Request), m.Secret)
+	if err != nil {
+		abort(c, http.StatusUnauthorized, services.TokenError, err)
+		return nil, false
+	}
+	return claims, true
+}
+
+// helper function to allow handler to administrators only, they should
+// use second factor if their roles require it
+func (m *Manager) admin(handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, ok := m.requestClaims(c)
+		if !ok {
+			return
+		}
+		for _, role := range claims.CustomClaims.Roles {
+			if utils.InList(role, m.AdminRoles) {
+				if authz.RequiresTwoFactor(claims.CustomClaims.Roles) && !authz.HasTwoFactor(claims) {
+					abort(c, http.StatusForbidden, services.CredentialsError, authz.ErrTwoFactorMissing)
+					return
+				}
+				handler(c)
+				return
+			}
+		}
+		abort(c, http.StatusForbidden, services.PolicyError, errors.New("users administration requires administrator role"))
+	}
+}
+
+// helper function to provide user of the request authorized by token or,
+// if enrollment is allowed, by login challenge of user without second
+// factor. Users with enabled second factor should use tokens issued after
+// it. It returns the challenge if request is authorized by it.
+func (m *Manager) requestUser(c *gin.Context, enroll bool) (User, string, bool) {
+	ctx := c.Request.Context()
+	if challenge := c.GetHeader(TwoFactorChallengeHeader); challenge != "" && enroll {
+		user, err := m.ChallengeUser(ctx, challenge)
+		if err != nil {
+			abortWithError(c, err)
+			return user, "", false
+		}
+		if user.TwoFactorEnabled() {
+			abort(c, http.StatusForbidden, services.CredentialsError, errors.New("login challenge allows enrollment of the first second factor only"))
+			return user, "", false
+		}
+		return user, challenge, true
+	}
+	claims, ok := m.requestClaims(c)
+	if !ok {
+		return User{}, "", false
+	}
+	user, err := m.Get(ctx, claims.CustomClaims.User)
+	if err != nil {
+		abortWithError(c, err)
+		return user, "", false
+	}
+	if user.TwoFactorEnabled() && !authz.HasTwoFactor(claims) {
+		abort(c, http.StatusForbidden, services.CredentialsError, authz.ErrTwoFactorMissing)
+		return user, "", false
+	}
+	return user, "", true
+}
+
+// helper function to respond to enabled second factor, login authorized by
+// challenge is completed
+func (m *Manager) respondEnrollment(c *gin.Context, user User, challenge string, codes []string, amr []string) {
+	resp := EnrollmentResponse{RecoveryCodes: codes}
+	if challenge != "" {
+		if _, err := m.consumeToken(c.Request.Context(), challenge, TokenTwoFactor); err != nil {
+			abortWithError(c, err)
+			return
+		}
+		token, err := m.login(c, user, amr)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		if resp.Token, err = m.tokenResponse(token); err != nil {
+			abortWithError(c, err)
+			return
+		}
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// LoginHandler authenticates user with JSON LoginRequest, it responds with
+// access token or, if second factor is required, with TwoFactorChallenge
+// and 202 status code
+func (m *Manager) LoginHandler(c *gin.Context) {
+	var req LoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abort(c, http.StatusBadRequest, services.BindError, err)
+		return
+	}
+	token, err := m.Login(c, req.User, req.Password)
+	var challenge *TwoFactorChallenge
+	if errors.As(err, &challenge) {
+		c.JSON(http.StatusAccepted, challenge)
+		return
+	} else if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	m.respondToken(c, token)
+}
+
+// TwoFactorLoginHandler completes login with JSON TwoFactorRequest
+func (m *Manager) TwoFactorLoginHandler(c *gin.Context) {
+	var req TwoFactorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abort(c, http.StatusBadRequest, services.BindError, err)
+		return
+	}
+	token, err := m.LoginTwoFactor(c, req.Challenge, req.Method, req.Code, req.Credential)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	m.respondToken(c, token)
+}
+
+// WebAuthnLoginHandler starts WebAuthn assertion of login challenge given
+// in JSON TwoFactorRequest, it responds with options of
+// navigator.credentials.get
+func (m *Manager) WebAuthnLoginHandler(c *gin.Context) {
+	var req TwoFactorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abort(c, http.StatusBadRequest, services.BindError, err)
+		return
+	}
+	assertion, err := m.BeginWebAuthnLogin(c.Request.Context(), req.Challenge)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, assertion)
+}
+
+// EnrollTOTPHandler generates TOTP secret of the user, it responds with
+// TOTPEnrollment
+func (m *Manager) EnrollTOTPHandler(c *gin.Context) {
+	user, _, ok := m.requestUser(c, true)
+	if !ok {
+		return
+	}
+	key, err := m.EnrollTOTP(c.Request.Context(), user.Name)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	qr, err := authz.TOTPQRCode(key, 256)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, TOTPEnrollment{Secret: key.Secret(), URL: key.URL(), QRCode: qr})
+}
+
+// ConfirmTOTPHandler enables TOTP secret of the user with code of JSON
+// TwoFactorRequest, it responds with EnrollmentResponse
+func (m *Manager) ConfirmTOTPHandler(c *gin.Context) {
+	user, challenge, ok := m.requestUser(c, true)
+	if !ok {
+		return
+	}
+	var req TwoFactorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abort(c, http.StatusBadRequest, services.BindError, err)
+		return
+	}
+	codes, err := m.ConfirmTOTP(c.Request.Context(), user.Name, req.Code)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	amr := []string{authz.AMRPassword, authz.AMROTP, authz.AMRMultiFactor}
+	m.respondEnrollment(c, user, challenge, codes, amr)
+}
+
+// WebAuthnRegisterHandler starts registration of security key of the user,
+// it responds with options of navigator.credentials.create
+func (m *Manager) WebAuthnRegisterHandler(c *gin.Context) {
+	user, _, ok := m.requestUser(c, true)
+	if !ok {
+		return
+	}
+	creation, err := m.BeginWebAuthnRegistration(c.Request.Context(), user.Name)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, creation)
+}
+
+// WebAuthnConfirmHandler completes registration of security key with
+// response of navigator.credentials.create in request body, it responds
+// with EnrollmentResponse
+func (m *Manager) WebAuthnConfirmHandler(c *gin.Context) {
+	user, challenge, ok := m.requestUser(c, true)
+	if !ok {
+		return
+	}
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		abort(c, http.StatusBadRequest, services.ReaderError, err)
+		return
+	}
+	codes, err := m.FinishWebAuthnRegistration(c.Request.Context(), user.Name, body)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	amr := []string{authz.AMRPassword, authz.AMRHardwareKey, authz.AMRMultiFactor}
+	m.respondEnrollment(c, user, challenge, codes, amr)
+}
+
+// RecoveryCodesHandler replaces recovery codes of the user, it responds
+// with EnrollmentResponse
+func (m *Manager) RecoveryCodesHandler(c *gin.Context) {
+	user, _, ok := m.requestUser(c, false)
+	if !ok {
+		return
+	}
+	codes, err := m.RegenerateRecoveryCodes(c.Request.Context(), user.Name)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, EnrollmentResponse{RecoveryCodes: codes})
+}
+
+// DisableTwoFactorHandler removes second factors of the user
+func (m *Manager) DisableTwoFactorHandler(c *gin.Context) {
+	user, _, ok := m.requestUser(c, false)
+	if !ok {
+		return
+	}
+	if err := m.DisableTwoFactor(c.Request.Context(), user.Name); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// ChangePasswordHandler changes password of the user with JSON
+// PasswordRequest, it is allowed for expired passwords
+func (m *Manager) ChangePasswordHandler(c *gin.Context) {
+	var req PasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abort(c, http.StatusBadRequest, services.BindError, err)
+		return
+	}
+	if err := m.ChangePassword(c.Request.Context(), req.User, req.Password, req.NewPassword); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// PasswordStatusHandler provides password status of the user
+func (m *Manager) PasswordStatusHandler(c *gin.Context) {
+	status, err := m.PasswordStatus(c.Request.Context(), c.Param("name"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// ForceResetHandler forces password reset of the user with optional JSON
+// ResetRequest
+func (m *Manager) ForceResetHandler(c *gin.Context) {
+	var req ResetRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			abort(c, http.StatusBadRequest, services.BindError, err)
+			return
+		}
+	}
+	name := c.Param("name")
+	if err := m.ForcePasswordReset(c.Request.Context(), name, req.Notify); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	log.Printf("INFO: password reset of user %s is forced", name)
+	c.Status(http.StatusNoContent)
+}
+
+// helper function to respond with notification preferences
+func (m *Manager) respondNotifications(c *gin.Context, n Notifications) {
+	if n == nil {
+		n = Notifications{}
+	}
+	c.JSON(http.StatusOK, NotificationsResponse{Events: m.NotificationEvents, Channels: Channels, Notifications: n})
+}
+
+// NotificationsHandler provides notification preferences of the user
+func (m *Manager) NotificationsHandler(c *gin.Context) {
+	user, _, ok := m.requestUser(c, false)
+	if !ok {
+		return
+	}
+	m.respondNotifications(c, user.Notifications)
+}
+
+// SetNotificationsHandler replaces notification preferences of the user
+// with JSON Notifications, e.g. {"search.matches": ["email"], "*": ["sse"]}
+func (m *Manager) SetNotificationsHandler(c *gin.Context) {
+	user, _, ok := m.requestUser(c, false)
+	if !ok {
+		return
+	}
+	var n Notifications
+	if err := c.ShouldBindJSON(&n); err != nil {
+		abort(c, http.StatusBadRequest, services.BindError, err)
+		return
+	}
+	n, err := m.SetNotifications(c.Request.Context(), user.Name, n)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	m.respondNotifications(c, n)
+}
+
+// Routes returns server routes of login, password management, notification
+// preferences and two-factor authentication under given path, e.g. /users. Routes
+// authorize requests themselves since enrollment is allowed by login
+// challenge of users without second factor.
+func (m *Manager) Routes(path string) []server.Route {
+	return []server.Route{
+		{Method: "POST", Path: path + "/login", Handler: m.LoginHandler,
+			Summary: "login of local user", Request: LoginRequest{}, Response: authz.Token{}},
+		{Method: "POST", Path: path + "/login/2fa", Handler: m.TwoFactorLoginHandler,
+			Summary: "complete login with second factor", Request: TwoFactorRequest{}, Response: authz.Token{}},
+		{Method: "POST", Path: path + "/login/2fa/webauthn", Handler: m.WebAuthnLoginHandler,
+			Summary: "start WebAuthn assertion of login", Request: TwoFactorRequest{}},
+		{Method: "POST", Path: path + "/2fa/totp", Handler: m.EnrollTOTPHandler,
+			Summary: "enroll TOTP authenticator app", Response: TOTPEnrollment{}},
+		{Method: "POST", Path: path + "/2fa/totp/confirm", Handler: m.ConfirmTOTPHandler,
+			Summary: "confirm TOTP enrollment", Request: TwoFactorRequest{}, Response: EnrollmentResponse{}},
+		{Method: "POST", Path: path + "/2fa/webauthn", Handler: m.WebAuthnRegisterHandler,
+			Summary: "start registration of WebAuthn security key"},
+		{Method: "POST", Path: path + "/2fa/webauthn/confirm", Handler: m.WebAuthnConfirmHandler,
+			Summary: "complete registration of WebAuthn security key", Response: EnrollmentResponse{}},
+		{Method: "POST", Path: path + "/2fa/recovery", Handler: m.RecoveryCodesHandler,
+			Summary: "regenerate recovery codes", Response: EnrollmentResponse{}},
+		{Method: "DELETE", Path: path + "/2fa", Handler: m.DisableTwoFactorHandler,
+			Summary: "disable two-factor authentication"},
+		{Method: "POST", Path: path + "/password", Handler: m.ChangePasswordHandler,
+			Summary: "change password of the user", Request: PasswordRequest{}},
+		{Method: "GET", Path: path + "/password/:name", Handler: m.admin(m.PasswordStatusHandler),
+			Summary: "password status of the user", Response: PasswordStatus{}},
+		{Method: "POST", Path: path + "/password/:name/reset", Handler: m.admin(m.ForceResetHandler),
+			Summary: "force password reset of the user", Request: ResetRequest{}},
+		{Method: "GET", Path: path + "/notifications", Handler: m.NotificationsHandler,
+			Summary: "notification preferences of the user", Response: NotificationsResponse{}},
+		{Method: "PUT", Path: path + "/notifications", Handler: m.SetNotificationsHandler,
+			Summary: "set notification preferences of the user", Request: Notifications{}, Response: NotificationsResponse{}},
+	}
+}
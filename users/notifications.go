@@ -0,0 +1,146 @@
+package users
+
+// notifications module keeps notification preferences of users, i.e.
+// channels enabled for every event type. Preferences are stored with user
+// record and they are honored by mailer, webhooks of saved searches and
+// notification hub (SSE and WebSocket clients).
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	pubsub "github.com/CHESSComputing/golib/pubsub"
+	utils "github.com/CHESSComputing/golib/utils"
+)
+
+// notification channels
+const (
+	ChannelEmail   = "email"
+	ChannelWebhook = "webhook"
+	ChannelSSE     = "sse" // events of notification hub, i.e. SSE and WebSocket clients
+)
+
+// AnyEvent defines preference of event types without their own preference
+const AnyEvent = "*"
+
+// EventSearchMatches defines event type of notifications about new records
+// matching saved searches
+const EventSearchMatches = "search.matches"
+
+// Channels defines notification channels of preferences
+var Channels = []string{ChannelEmail, ChannelWebhook, ChannelSSE}
+
+// DefaultNotificationEvents defines event types of notification preferences
+var DefaultNotificationEvents = []string{
+	EventSearchMatches,
+	pubsub.EventDatasetRegistered,
+	pubsub.EventDatasetTransferred,
+	pubsub.EventUploadCompleted,
+	pubsub.EventBundleProgress,
+	pubsub.EventRecordState,
+}
+
+// ErrInvalidNotifications represents invalid notification preferences
+var ErrInvalidNotifications = errors.New("invalid notification preferences")
+
+// Notifications represents notification preferences of the user, i.e.
+// enabled channels of event types. Event types without preference use
+// preference of AnyEvent, all channels are enabled if neither is set.
+type Notifications map[string][]string
+
+// Notifies checks if notifications about event type are enabled on the
+// channel
+func (n Notifications) Notifies(event, channel string) bool {
+	channels, ok := n[event]
+	if !ok {
+		if channels, ok = n[AnyEvent]; !ok {
+			return true
+		}
+	}
+	return utils.InList(channel, channels)
+}
+
+// helper function to convert notification preferences into storage value
+func notificationsValue(n Notifications) string {
+	if len(n) == 0 {
+		return ""
+	}
+	data, err := json.Marshal(n)
+	if err != nil {
+		log.Printf("ERROR: unable to encode notification preferences, error %v", err)
+		return ""
+	}
+	return string(data)
+}
+
+// helper function to convert storage value into notification preferences
+func notificationsRecord(val string) Notifications {
+	var n Notifications
+	if val != "" {
+		if err := json.Unmarshal([]byte(val), &n); err != nil {
+			log.Printf("ERROR: unable to decode notification preferences, error %v", err)
+		}
+	}
+	return n
+}
+
+// ValidateNotifications checks that preferences refer to known event types
+// and channels, it returns preferences without duplicate channels
+func (m *Manager) ValidateNotifications(n Notifications) (Notifications, error) {
+	out := make(Notifications, len(n))
+	for event, channels := range n {
+		if event != AnyEvent && !utils.InList(event, m.NotificationEvents) {
+			return nil, fmt.Errorf("%w: unknown event type '%s'", ErrInvalidNotifications, event)
+		}
+		enabled := []string{}
+		for _, channel := range channels {
+			if !utils.InList(channel, Channels) {
+				return nil, fmt.Errorf("%w: unknown channel '%s'", ErrInvalidNotifications, channel)
+			}
+			if !utils.InList(channel, enabled) {
+				enabled = append(enabled, channel)
+			}
+		}
+		out[event] = enabled
+	}
+	return out, nil
+}
+
+// SetNotifications replaces notification preferences of the user
+func (m *Manager) SetNotifications(ctx context.Context, name string, n Notifications) (Notifications, error) {
+	n, err := m.ValidateNotifications(n)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.update(ctx, name, map[string]any{"notifications": notificationsValue(n)}); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// Notifies checks if the user enabled notifications about event type on
+// the channel. Users without local account, e.g. users of institutional
+// SSO, get all notifications, and failed lookups do not suppress them.
+func (m *Manager) Notifies(ctx context.Context, name, event, channel string) bool {
+	user, err := m.Get(ctx, name)
+	if err != nil {
+		if !errors.Is(err, ErrUserNotFound) {
+			log.Printf("ERROR: unable to get notification preferences of %s, error %v", name, err)
+		}
+		return true
+	}
+	return user.Notifications.Notifies(event, channel)
+}
+
+// HubFilter returns event filter of notification hub which drops events
+// of users who disabled SSE channel of event type, e.g.
+// hub.Filter = manager.HubFilter()
+func (m *Manager) HubFilter() func(ctx context.Context, claims *authz.Claims, env pubsub.Envelope) bool {
+	return func(ctx context.Context, claims *authz.Claims, env pubsub.Envelope) bool {
+		return m.Notifies(ctx, claims.CustomClaims.User, env.Type, ChannelSSE)
+	}
+}
@@ -0,0 +1,104 @@
+package users
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	pubsub "github.com/CHESSComputing/golib/pubsub"
+	"github.com/gin-gonic/gin"
+)
+
+// TestNotifications
+func TestNotifications(t *testing.T) {
+	ctx := context.Background()
+	m := testManager()
+	if _, err := m.Create(ctx, User{Name: "alice", Email: "alice@example.com"}, "secret-password"); err != nil {
+		t.Fatal(err)
+	}
+	if !m.Notifies(ctx, "alice", EventSearchMatches, ChannelEmail) || !m.Notifies(ctx, "nobody", EventSearchMatches, ChannelEmail) {
+		t.Error("notifications are disabled by default")
+	}
+	if _, err := m.SetNotifications(ctx, "alice", Notifications{"unknown": {ChannelEmail}}); !errors.Is(err, ErrInvalidNotifications) {
+		t.Errorf("unknown event type is accepted, error %v", err)
+	}
+	if _, err := m.SetNotifications(ctx, "alice", Notifications{AnyEvent: {"sms"}}); !errors.Is(err, ErrInvalidNotifications) {
+		t.Errorf("unknown channel is accepted, error %v", err)
+	}
+	prefs := Notifications{EventSearchMatches: {ChannelWebhook, ChannelWebhook}, AnyEvent: {ChannelSSE}}
+	prefs, err := m.SetNotifications(ctx, "alice", prefs)
+	if err != nil || len(prefs[EventSearchMatches]) != 1 {
+		t.Fatalf("wrong preferences %v, error %v", prefs, err)
+	}
+	for _, tc := range []struct {
+		event, channel string
+		expect         bool
+	}{
+		{EventSearchMatches, ChannelWebhook, true},
+		{EventSearchMatches, ChannelEmail, false},
+		{pubsub.EventUploadCompleted, ChannelSSE, true},
+		{pubsub.EventUploadCompleted, ChannelEmail, false},
+	} {
+		if m.Notifies(ctx, "alice", tc.event, tc.channel) != tc.expect {
+			t.Errorf("wrong preference of %s on %s channel", tc.event, tc.channel)
+		}
+	}
+	filter := m.HubFilter()
+	claims := &authz.Claims{CustomClaims: authz.CustomClaims{User: "alice"}}
+	if filter(ctx, claims, pubsub.Envelope{Type: EventSearchMatches}) {
+		t.Error("hub event is delivered despite preferences")
+	}
+}
+
+// TestNotificationsHandlers
+func TestNotificationsHandlers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	m := testManager()
+	user, err := m.Create(ctx, User{Name: "alice", Email: "alice@example.com"}, "secret-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := m.AccessToken(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := gin.New()
+	for _, route := range m.Routes("/users") {
+		r.Handle(route.Method, route.Path, route.Handler)
+	}
+	call := func(method, body, token string, out any) int {
+		req := httptest.NewRequest(method, "/users/notifications", strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if out != nil {
+			json.Unmarshal(w.Body.Bytes(), out)
+		}
+		return w.Code
+	}
+
+	if code := call("GET", "", "", nil); code != http.StatusUnauthorized {
+		t.Errorf("preferences without token, code %d", code)
+	}
+	var resp NotificationsResponse
+	if code := call("GET", "", token, &resp); code != http.StatusOK || len(resp.Events) == 0 || len(resp.Channels) != 3 {
+		t.Errorf("wrong preferences response %d %+v", code, resp)
+	}
+	if code := call("PUT", `{"search.matches": ["pager"]}`, token, nil); code != http.StatusBadRequest {
+		t.Errorf("invalid preferences are accepted, code %d", code)
+	}
+	if code := call("PUT", `{"search.matches": []}`, token, &resp); code != http.StatusOK || resp.Notifications[EventSearchMatches] == nil {
+		t.Errorf("preferences are not set, code %d %+v", code, resp)
+	}
+	if m.Notifies(ctx, "alice", EventSearchMatches, ChannelEmail) {
+		t.Error("disabled notifications are enabled")
+	}
+}
@@ -0,0 +1,229 @@
+package users
+
+// password module enforces password policy of local users: complexity
+// rules checked when passwords are set or changed, history of previous
+// passwords which can not be reused and expiry of passwords, including
+// resets forced by administrators
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// DefaultMaxPasswordLength defines maximum length of passwords in bytes,
+// bcrypt ignores longer input
+const DefaultMaxPasswordLength = 72
+
+// errors of password policy
+var (
+	ErrWeakPassword    = errors.New("password does not satisfy password policy")
+	ErrPasswordExpired = errors.New("password is expired and should be changed")
+)
+
+// PasswordError represents violations of password policy
+type PasswordError struct {
+	Violations []string `json:"violations"`
+}
+
+// Error implements error interface
+func (e *PasswordError) Error() string {
+	return fmt.Sprintf("%s: password %s", ErrWeakPassword, strings.Join(e.Violations, ", "))
+}
+
+// Unwrap provides ErrWeakPassword
+func (e *PasswordError) Unwrap() error {
+	return ErrWeakPassword
+}
+
+// PasswordStatus represents status of password of the user
+type PasswordStatus struct {
+	User      string     `json:"user"`
+	Changed   time.Time  `json:"changed"`
+	Expires   *time.Time `json:"expires,omitempty"` // expiration of the password, nil means never
+	MustReset bool       `json:"must_reset"`        // reset is forced by administrator
+	Expired   bool       `json:"expired"`
+}
+
+// ValidatePassword checks password of the user against password policy
+// and history of previous passwords, it returns *PasswordError with all
+// violated rules which wraps ErrWeakPassword
+func (m *Manager) ValidatePassword(user User, password string) error {
+	p := m.Policy
+	maxLength := p.MaxLength
+	if maxLength <= 0 || maxLength > DefaultMaxPasswordLength {
+		maxLength = DefaultMaxPasswordLength
+	}
+	var violations []string
+	if n := len([]rune(password)); n < m.MinPasswordLength {
+		violations = append(violations, fmt.Sprintf("should have at least %d characters", m.MinPasswordLength))
+	}
+	if len(password) > maxLength {
+		violations = append(violations, fmt.Sprintf("should have at most %d bytes", maxLength))
+	}
+	var upper, lower, digits, symbols int
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper++
+		case unicode.IsLower(r):
+			lower++
+		case unicode.IsDigit(r):
+			digits++
+		default:
+			symbols++
+		}
+	}
+	for _, rule := range []struct {
+		count, min int
+		kind       string
+	}{
+		{upper, p.MinUpper, "upper case letters"},
+		{lower, p.MinLower, "lower case letters"},
+		{digits, p.MinDigits, "digits"},
+		{symbols, p.MinSymbols, "symbols"},
+	} {
+		if rule.count < rule.min {
+			violations = append(violations, fmt.Sprintf("should have at least %d %s", rule.min, rule.kind))
+		}
+	}
+	lpassword := strings.ToLower(password)
+	for _, word := range p.Forbidden {
+		if lpassword == strings.ToLower(word) {
+			violations = append(violations, "is too common")
+			break
+		}
+	}
+	if p.NoUserInfo {
+		local, _, _ := strings.Cut(strings.ToLower(user.Email), "@")
+		for _, info := range []string{strings.ToLower(user.Name), local} {
+			if len(info) > 2 && strings.Contains(lpassword, info) {
+				violations = append(violations, "should not contain user name or email")
+				break
+			}
+		}
+	}
+	if p.History > 0 && user.PasswordHash != "" {
+		for _, hash := range append([]string{user.PasswordHash}, user.PasswordHistory...) {
+			if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil {
+				violations = append(violations, fmt.Sprintf("should differ from last %d passwords", p.History))
+				break
+			}
+		}
+	}
+	if len(violations) > 0 {
+		return &PasswordError{Violations: violations}
+	}
+	return nil
+}
+
+// helper function to set validated password of the user, previous password
+// is kept in history and forced reset is cleared
+func (m *Manager) setPassword(ctx context.Context, user User, password string) error {
+	if err := m.ValidatePassword(user, password); err != nil {
+		return err
+	}
+	hash, err := m.hashPassword(password)
+	if err != nil {
+		return err
+	}
+	var history []string
+	if m.Policy.History > 0 && user.PasswordHash != "" {
+		history = append([]string{user.PasswordHash}, user.PasswordHistory...)
+		// current password is checked separately, history keeps previous ones
+		if len(history) > m.Policy.History-1 {
+			history = history[:m.Policy.History-1]
+		}
+	}
+	fields := map[string]any{
+		"password_hash":    hash,
+		"password_history": history,
+		"password_changed": time.Now().Unix(),
+		"must_reset":       false,
+	}
+	return m.update(ctx, user.Name, fields)
+}
+
+// PasswordExpires returns expiration of password of the user, zero time
+// means that password does not expire
+func (m *Manager) PasswordExpires(user User) time.Time {
+	if m.Policy.MaxAge <= 0 {
+		return time.Time{}
+	}
+	changed := user.PasswordChanged
+	if changed == 0 {
+		// accounts created before password policy
+		changed = user.Created
+	}
+	return time.Unix(changed, 0).Add(m.Policy.MaxAge)
+}
+
+// PasswordExpired checks if password of the user is expired or its reset
+// is forced by administrator
+func (m *Manager) PasswordExpired(user User) bool {
+	if user.MustReset {
+		return true
+	}
+	expires := m.PasswordExpires(user)
+	return !expires.IsZero() && time.Now().After(expires)
+}
+
+// PasswordStatus returns status of password of the user
+func (m *Manager) PasswordStatus(ctx context.Context, name string) (PasswordStatus, error) {
+	user, err := m.Get(ctx, name)
+	if err != nil {
+		return PasswordStatus{}, err
+	}
+	status := PasswordStatus{
+		User:      user.Name,
+		Changed:   time.Unix(user.PasswordChanged, 0),
+		MustReset: user.MustReset,
+		Expired:   m.PasswordExpired(user),
+	}
+	if user.PasswordChanged == 0 {
+		status.Changed = time.Unix(user.Created, 0)
+	}
+	if expires := m.PasswordExpires(user); !expires.IsZero() {
+		status.Expires = &expires
+	}
+	return status, nil
+}
+
+// ChangePassword changes password of the user who provides current
+// password, it is allowed for expired passwords
+func (m *Manager) ChangePassword(ctx context.Context, name, password, newPassword string) error {
+	user, err := m.authenticate(ctx, name, password)
+	if err != nil {
+		return err
+	}
+	return m.setPassword(ctx, user, newPassword)
+}
+
+// ForcePasswordReset expires password of the user, the user should change
+// it before login. If notify is set, password reset token is issued and
+// sent to the user via Notify function.
+func (m *Manager) ForcePasswordReset(ctx context.Context, name string, notify bool) error {
+	if err := m.update(ctx, name, map[string]any{"must_reset": true}); err != nil {
+		return err
+	}
+	if notify {
+		if _, err := m.IssueToken(ctx, name, TokenResetPassword); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// helper function to provide password policy of Authz configuration
+func passwordPolicy() srvConfig.PasswordPolicy {
+	if srvConfig.Config == nil {
+		return srvConfig.PasswordPolicy{}
+	}
+	return srvConfig.Config.Authz.PasswordPolicy
+}
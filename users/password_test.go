@@ -0,0 +1,164 @@
+package users
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	srvConfig "github.com/CHESSComputing/golib/config"
+	"github.com/gin-gonic/gin"
+)
+
+// TestPasswordPolicy
+func TestPasswordPolicy(t *testing.T) {
+	ctx := context.Background()
+	m := testManager()
+	m.MinPasswordLength = 10
+	m.Policy = srvConfig.PasswordPolicy{
+		MinUpper: 1, MinDigits: 1, MinSymbols: 1,
+		Forbidden: []string{"Password123!"}, NoUserInfo: true, History: 2,
+	}
+	user := User{Name: "alice", Email: "alice@example.com"}
+	_, err := m.Create(ctx, user, "short")
+	var perr *PasswordError
+	if !errors.As(err, &perr) || !errors.Is(err, ErrWeakPassword) || len(perr.Violations) != 4 {
+		t.Fatalf("weak password is accepted, error %v", err)
+	}
+	for _, password := range []string{"password123!", "Alice-secret-1", "Password123!", strings.Repeat("Aa1!", 20)} {
+		if err := m.ValidatePassword(user, password); !errors.Is(err, ErrWeakPassword) {
+			t.Errorf("password %s is accepted", password)
+		}
+	}
+	if _, err := m.Create(ctx, user, "Secret-pass-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.ChangePassword(ctx, "alice", "wrong-password", "Secret-pass-2"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("password is changed with wrong password, error %v", err)
+	}
+	if err := m.ChangePassword(ctx, "alice", "Secret-pass-1", "Secret-pass-1"); !errors.Is(err, ErrWeakPassword) {
+		t.Errorf("current password is reused, error %v", err)
+	}
+	if err := m.ChangePassword(ctx, "alice", "Secret-pass-1", "Secret-pass-2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.ChangePassword(ctx, "alice", "Secret-pass-2", "Secret-pass-1"); !errors.Is(err, ErrWeakPassword) {
+		t.Errorf("password from history is reused, error %v", err)
+	}
+	if err := m.SetPassword(ctx, "alice", "Secret-pass-3"); err != nil {
+		t.Fatal(err)
+	}
+	// history keeps last two passwords only
+	if err := m.SetPassword(ctx, "alice", "Secret-pass-1"); err != nil {
+		t.Errorf("old password is not removed from history, error %v", err)
+	}
+	user, _ = m.Get(ctx, "alice")
+	if len(user.PasswordHistory) != 1 {
+		t.Errorf("wrong password history %v", user.PasswordHistory)
+	}
+}
+
+// TestPasswordExpiry
+func TestPasswordExpiry(t *testing.T) {
+	ctx := context.Background()
+	m := testManager()
+	m.Policy.MaxAge = time.Hour
+	if _, err := m.Create(ctx, User{Name: "alice", Email: "alice@example.com"}, "secret-password"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Authenticate(ctx, "alice", "secret-password"); err != nil {
+		t.Fatal(err)
+	}
+	m.update(ctx, "alice", map[string]any{"password_changed": time.Now().Add(-2 * time.Hour).Unix()})
+	if _, err := m.Authenticate(ctx, "alice", "secret-password"); !errors.Is(err, ErrPasswordExpired) {
+		t.Errorf("expired password is accepted, error %v", err)
+	}
+	if err := m.ChangePassword(ctx, "alice", "secret-password", "new-secret-password"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Authenticate(ctx, "alice", "new-secret-password"); err != nil {
+		t.Errorf("changed password is rejected, error %v", err)
+	}
+
+	var issued string
+	m.Notify = func(ctx context.Context, kind string, user User, token string, expires time.Time) error {
+		issued = token
+		return nil
+	}
+	if err := m.ForcePasswordReset(ctx, "alice", true); err != nil || issued == "" {
+		t.Fatalf("password reset is not forced, error %v", err)
+	}
+	status, err := m.PasswordStatus(ctx, "alice")
+	if err != nil || !status.MustReset || !status.Expired || status.Expires == nil {
+		t.Errorf("wrong password status %+v, error %v", status, err)
+	}
+	if _, err := m.Authenticate(ctx, "alice", "new-secret-password"); !errors.Is(err, ErrPasswordExpired) {
+		t.Errorf("password is accepted after forced reset, error %v", err)
+	}
+	if err := m.ResetPassword(ctx, issued, "reset-secret-password"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Authenticate(ctx, "alice", "reset-secret-password"); err != nil {
+		t.Errorf("reset password is rejected, error %v", err)
+	}
+}
+
+// TestPasswordHandlers
+func TestPasswordHandlers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	m := testManager()
+	m.AdminRoles = []string{"support"}
+	if _, err := m.Create(ctx, User{Name: "alice", Email: "alice@example.com"}, "secret-password"); err != nil {
+		t.Fatal(err)
+	}
+	r := gin.New()
+	for _, route := range m.Routes("/users") {
+		r.Handle(route.Method, route.Path, route.Handler)
+	}
+	call := func(method, path string, body any, token string) int {
+		data, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, strings.NewReader(string(data)))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	token := func(roles ...string) string {
+		custom := authz.CustomClaims{User: "bob", Roles: roles}
+		tkn, err := authz.JWTAccessToken(m.Secret, 60, custom)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tkn
+	}
+
+	req := PasswordRequest{User: "alice", Password: "secret-password", NewPassword: "short"}
+	if code := call("POST", "/users/password", req, ""); code != http.StatusBadRequest {
+		t.Errorf("weak password is accepted, code %d", code)
+	}
+	req.NewPassword = "new-secret-password"
+	if code := call("POST", "/users/password", req, ""); code != http.StatusNoContent {
+		t.Errorf("password is not changed, code %d", code)
+	}
+	if code := call("POST", "/users/password/alice/reset", nil, token("staff")); code != http.StatusForbidden {
+		t.Errorf("reset is forced without administrator role, code %d", code)
+	}
+	if code := call("POST", "/users/password/alice/reset", nil, token("support")); code != http.StatusNoContent {
+		t.Errorf("reset is not forced, code %d", code)
+	}
+	login := LoginRequest{User: "alice", Password: "new-secret-password"}
+	if code := call("POST", "/users/login", login, ""); code != http.StatusForbidden {
+		t.Errorf("login with expired password, code %d", code)
+	}
+	if code := call("GET", "/users/password/nobody", nil, token("support")); code != http.StatusNotFound {
+		t.Errorf("status of unknown user, code %d", code)
+	}
+}
@@ -0,0 +1,453 @@
+package users
+
+// twofactor module provides two-factor authentication of local users via
+// TOTP authenticator apps, WebAuthn security keys and recovery codes. Users
+// of roles which require second factor (see Authz.TwoFactor configuration),
+// e.g. administrators, can not obtain tokens without it, their login
+// without enrolled second factor provides challenge which allows enrollment
+// only.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	"github.com/CHESSComputing/golib/storage"
+	utils "github.com/CHESSComputing/golib/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/pquerna/otp"
+)
+
+// kinds of one-time user tokens of two-factor authentication
+const (
+	TokenTwoFactor        = "two_factor"        // second step of login
+	TokenWebAuthnRegister = "webauthn_register" // session of WebAuthn registration
+	TokenWebAuthnLogin    = "webauthn_login"    // session of WebAuthn login
+)
+
+// errors of two-factor authentication
+var (
+	ErrTwoFactorRequired    = errors.New("second factor is required")
+	ErrTwoFactorEnabled     = errors.New("second factor is already enabled")
+	ErrTwoFactorNotEnrolled = errors.New("second factor is not enrolled")
+)
+
+// TwoFactorChallenge represents login which requires second factor, it is
+// returned as error by Login
+type TwoFactorChallenge struct {
+	Challenge string    `json:"challenge"` // one-time token of second step of login
+	Methods   []string  `json:"methods"`   // enabled methods of second factor
+	Enroll    bool      `json:"enroll"`    // user must enroll second factor, challenge allows enrollment
+	Expires   time.Time `json:"expires"`   // expiration of the challenge
+}
+
+// Error implements error interface
+func (c *TwoFactorChallenge) Error() string {
+	if c.Enroll {
+		return ErrTwoFactorRequired.Error() + ", it should be enrolled"
+	}
+	return fmt.Sprintf("%s, methods %v", ErrTwoFactorRequired, c.Methods)
+}
+
+// Unwrap provides ErrTwoFactorRequired
+func (c *TwoFactorChallenge) Unwrap() error {
+	return ErrTwoFactorRequired
+}
+
+// TwoFactorEnabled checks if the user has enabled second factor
+func (u User) TwoFactorEnabled() bool {
+	return u.TOTPEnabled || len(u.Credentials) > 0
+}
+
+// TwoFactorMethods returns enabled methods of second factor
+func (u User) TwoFactorMethods() []string {
+	var methods []string
+	if u.TOTPEnabled {
+		methods = append(methods, authz.TOTPMethod)
+	}
+	if len(u.Credentials) > 0 {
+		methods = append(methods, authz.WebAuthnMethod)
+	}
+	if len(u.RecoveryCodes) > 0 {
+		methods = append(methods, authz.RecoveryMethod)
+	}
+	return methods
+}
+
+// WebAuthnID implements webauthn.User interface
+func (u User) WebAuthnID() []byte {
+	return []byte(u.Name)
+}
+
+// WebAuthnName implements webauthn.User interface
+func (u User) WebAuthnName() string {
+	return u.Name
+}
+
+// WebAuthnDisplayName implements webauthn.User interface
+func (u User) WebAuthnDisplayName() string {
+	if u.FullName != "" {
+		return u.FullName
+	}
+	return u.Name
+}
+
+// WebAuthnCredentials implements webauthn.User interface
+func (u User) WebAuthnCredentials() []webauthn.Credential {
+	return u.Credentials
+}
+
+// WebAuthnIcon implements webauthn.User interface
+func (u User) WebAuthnIcon() string {
+	return ""
+}
+
+// helper function to convert WebAuthn credentials into storage value, they
+// are kept as JSON to have the same representation in all backends
+func credentialsValue(creds []webauthn.Credential) string {
+	if len(creds) == 0 {
+		return ""
+	}
+	data, err := json.Marshal(creds)
+	if err != nil {
+		log.Printf("ERROR: unable to encode WebAuthn credentials, error %v", err)
+		return ""
+	}
+	return string(data)
+}
+
+// helper function to convert storage value into WebAuthn credentials
+func credentialsRecord(val string) []webauthn.Credential {
+	var creds []webauthn.Credential
+	if val != "" {
+		if err := json.Unmarshal([]byte(val), &creds); err != nil {
+			log.Printf("ERROR: unable to decode WebAuthn credentials, error %v", err)
+		}
+	}
+	return creds
+}
+
+// helper function to issue challenge of second step of login
+func (m *Manager) challenge(ctx context.Context, user User) (*TwoFactorChallenge, error) {
+	token, expires, err := m.issueToken(ctx, user.Name, TokenTwoFactor, m.TwoFactorExpires)
+	if err != nil {
+		return nil, err
+	}
+	return &TwoFactorChallenge{
+		Challenge: token,
+		Methods:   user.TwoFactorMethods(),
+		Enroll:    !user.TwoFactorEnabled(),
+		Expires:   expires,
+	}, nil
+}
+
+// ChallengeUser returns user of pending login challenge without consuming
+// it, e.g. to enroll second factor
+func (m *Manager) ChallengeUser(ctx context.Context, challenge string) (User, error) {
+	name, err := m.lookupToken(ctx, challenge, TokenTwoFactor)
+	if err != nil {
+		return User{}, err
+	}
+	return m.Get(ctx, name)
+}
+
+// LoginTwoFactor completes login of given challenge with second factor of
+// given method: TOTP or recovery code, or WebAuthn assertion provided by
+// navigator.credentials.get in browser. The challenge is single use, it
+// sets user cookie and returns JWT access token.
+func (m *Manager) LoginTwoFactor(c *gin.Context, challenge, method, code string, credential []byte) (string, error) {
+	ctx := c.Request.Context()
+	name, err := m.consumeToken(ctx, challenge, TokenTwoFactor)
+	if err != nil {
+		return "", err
+	}
+	user, err := m.Get(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	if user.Disabled {
+		return "", ErrUserDisabled
+	}
+	if !user.TwoFactorEnabled() {
+		return "", ErrTwoFactorNotEnrolled
+	}
+	amr := []string{authz.AMRPassword, authz.AMRMultiFactor}
+	switch method {
+	case authz.TOTPMethod:
+		if !user.TOTPEnabled {
+			return "", ErrTwoFactorNotEnrolled
+		}
+		period, err := authz.VerifyTOTP(user.TOTPSecret, code, user.TOTPPeriod)
+		if err != nil {
+			return "", err
+		}
+		if err := m.update(ctx, name, map[string]any{"totp_period": period}); err != nil {
+			return "", err
+		}
+		amr = append(amr, authz.AMROTP)
+	case authz.RecoveryMethod:
+		remaining, err := authz.UseRecoveryCode(code, user.RecoveryCodes)
+		if err != nil {
+			return "", err
+		}
+		if err := m.update(ctx, name, map[string]any{"recovery_codes": remaining}); err != nil {
+			return "", err
+		}
+		log.Printf("INFO: user %s used recovery code, %d codes remain", name, len(remaining))
+	case authz.WebAuthnMethod:
+		if err := m.finishWebAuthnLogin(ctx, user, challenge, credential); err != nil {
+			return "", err
+		}
+		amr = append(amr, authz.AMRHardwareKey)
+	default:
+		return "", fmt.Errorf("unsupported method of second factor '%s'", method)
+	}
+	return m.login(c, user, amr)
+}
+
+// helper function to enable second factor of the user with given fields,
+// recovery codes are generated if the user has none
+func (m *Manager) enableTwoFactor(ctx context.Context, user User, fields map[string]any) ([]string, error) {
+	var codes []string
+	if len(user.RecoveryCodes) == 0 {
+		var hashes []string
+		var err error
+		codes, hashes, err = authz.NewRecoveryCodes(m.RecoveryCodes)
+		if err != nil {
+			return nil, err
+		}
+		fields["recovery_codes"] = hashes
+	}
+	if err := m.update(ctx, user.Name, fields); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// EnrollTOTP generates TOTP secret of the user, the key provides otpauth
+// URL and QR code of authenticator apps (see authz.TOTPQRCode). The secret
+// is enabled after confirmation by valid code (see ConfirmTOTP).
+func (m *Manager) EnrollTOTP(ctx context.Context, name string) (*otp.Key, error) {
+	user, err := m.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, ErrTwoFactorEnabled
+	}
+	key, err := authz.NewTOTPKey(user.Name)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.update(ctx, name, map[string]any{"totp_secret": key.Secret()}); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// ConfirmTOTP enables enrolled TOTP secret of the user with code of
+// authenticator app, it returns recovery codes if they are generated
+func (m *Manager) ConfirmTOTP(ctx context.Context, name, code string) ([]string, error) {
+	user, err := m.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, ErrTwoFactorEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, ErrTwoFactorNotEnrolled
+	}
+	period, err := authz.VerifyTOTP(user.TOTPSecret, code, 0)
+	if err != nil {
+		return nil, err
+	}
+	return m.enableTwoFactor(ctx, user, map[string]any{"totp_enabled": true, "totp_period": period})
+}
+
+// RegenerateRecoveryCodes replaces recovery codes of the user with new ones
+func (m *Manager) RegenerateRecoveryCodes(ctx context.Context, name string) ([]string, error) {
+	user, err := m.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if !user.TwoFactorEnabled() {
+		return nil, ErrTwoFactorNotEnrolled
+	}
+	user.RecoveryCodes = nil
+	return m.enableTwoFactor(ctx, user, map[string]any{})
+}
+
+// DisableTwoFactor removes all second factors of the user, it is not
+// allowed for users whose roles require second factor
+func (m *Manager) DisableTwoFactor(ctx context.Context, name string) error {
+	user, err := m.Get(ctx, name)
+	if err != nil {
+		return err
+	}
+	if authz.RequiresTwoFactor(user.Roles) {
+		return fmt.Errorf("%w for roles %v", authz.ErrTwoFactorMissing, user.Roles)
+	}
+	fields := map[string]any{
+		"totp_enabled":   false,
+		"totp_secret":    "",
+		"totp_period":    int64(0),
+		"recovery_codes": []string{},
+		"credentials":    "",
+	}
+	return m.update(ctx, name, fields)
+}
+
+// helper function to store WebAuthn session of given kind and key, e.g.
+// user name or login challenge, previous session is replaced
+func (m *Manager) saveSession(ctx context.Context, kind, key, name string, session *webauthn.SessionData) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	id := tokenHash(kind + ":" + key)
+	if _, err := m.Store.Remove(ctx, TokensCollection, map[string]any{"_id": id}); err != nil {
+		return err
+	}
+	rec := map[string]any{
+		"_id":     id,
+		"user":    name,
+		"kind":    kind,
+		"session": string(data),
+		"expires": time.Now().Add(m.TwoFactorExpires).Unix(),
+	}
+	return m.Store.Insert(ctx, TokensCollection, rec)
+}
+
+// helper function to consume WebAuthn session of given kind and key
+func (m *Manager) loadSession(ctx context.Context, kind, key string) (webauthn.SessionData, error) {
+	var session webauthn.SessionData
+	spec := map[string]any{"_id": tokenHash(kind + ":" + key), "kind": kind}
+	rec, err := storage.FindOne(ctx, m.Store, TokensCollection, spec)
+	if errors.Is(err, storage.ErrNotFound) {
+		return session, ErrInvalidToken
+	}
+	if err != nil {
+		return session, err
+	}
+	if _, err := m.Store.Remove(ctx, TokensCollection, spec); err != nil {
+		return session, err
+	}
+	if utils.ToInt64(rec["expires"]) < time.Now().Unix() {
+		return session, ErrInvalidToken
+	}
+	data, _ := rec["session"].(string)
+	err = json.Unmarshal([]byte(data), &session)
+	return session, err
+}
+
+// BeginWebAuthnRegistration starts registration of security key of the
+// user, it returns options of navigator.credentials.create in browser
+func (m *Manager) BeginWebAuthnRegistration(ctx context.Context, name string) (*protocol.CredentialCreation, error) {
+	if m.WebAuthn == nil {
+		return nil, authz.ErrWebAuthnDisabled
+	}
+	user, err := m.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	var exclusions []protocol.CredentialDescriptor
+	for _, cred := range user.Credentials {
+		exclusions = append(exclusions, cred.Descriptor())
+	}
+	creation, session, err := m.WebAuthn.BeginRegistration(user, webauthn.WithExclusions(exclusions))
+	if err != nil {
+		return nil, err
+	}
+	if err := m.saveSession(ctx, TokenWebAuthnRegister, name, name, session); err != nil {
+		return nil, err
+	}
+	return creation, nil
+}
+
+// FinishWebAuthnRegistration completes registration of security key with
+// response of navigator.credentials.create, it returns recovery codes if
+// they are generated
+func (m *Manager) FinishWebAuthnRegistration(ctx context.Context, name string, response []byte) ([]string, error) {
+	if m.WebAuthn == nil {
+		return nil, authz.ErrWebAuthnDisabled
+	}
+	user, err := m.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	session, err := m.loadSession(ctx, TokenWebAuthnRegister, name)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := protocol.ParseCredentialCreationResponseBody(bytes.NewReader(response))
+	if err != nil {
+		return nil, err
+	}
+	cred, err := m.WebAuthn.CreateCredential(user, session, parsed)
+	if err != nil {
+		return nil, err
+	}
+	creds := append(user.Credentials, *cred)
+	return m.enableTwoFactor(ctx, user, map[string]any{"credentials": credentialsValue(creds)})
+}
+
+// BeginWebAuthnLogin starts WebAuthn assertion of given login challenge,
+// it returns options of navigator.credentials.get in browser
+func (m *Manager) BeginWebAuthnLogin(ctx context.Context, challenge string) (*protocol.CredentialAssertion, error) {
+	if m.WebAuthn == nil {
+		return nil, authz.ErrWebAuthnDisabled
+	}
+	user, err := m.ChallengeUser(ctx, challenge)
+	if err != nil {
+		return nil, err
+	}
+	if len(user.Credentials) == 0 {
+		return nil, ErrTwoFactorNotEnrolled
+	}
+	assertion, session, err := m.WebAuthn.BeginLogin(user)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.saveSession(ctx, TokenWebAuthnLogin, challenge, user.Name, session); err != nil {
+		return nil, err
+	}
+	return assertion, nil
+}
+
+// helper function to verify WebAuthn assertion of login challenge, sign
+// counter of the credential is updated to detect cloned authenticators
+func (m *Manager) finishWebAuthnLogin(ctx context.Context, user User, challenge string, response []byte) error {
+	if m.WebAuthn == nil {
+		return authz.ErrWebAuthnDisabled
+	}
+	session, err := m.loadSession(ctx, TokenWebAuthnLogin, challenge)
+	if err != nil {
+		return err
+	}
+	parsed, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(response))
+	if err != nil {
+		return err
+	}
+	cred, err := m.WebAuthn.ValidateLogin(user, session, parsed)
+	if err != nil {
+		return err
+	}
+	if cred.Authenticator.CloneWarning {
+		log.Printf("WARNING: sign counter of WebAuthn credential of user %s did not increase, authenticator may be cloned", user.Name)
+		return errors.New("WebAuthn authenticator may be cloned")
+	}
+	for i, c := range user.Credentials {
+		if bytes.Equal(c.ID, cred.ID) {
+			user.Credentials[i] = *cred
+		}
+	}
+	return m.update(ctx, user.Name, map[string]any{"credentials": credentialsValue(user.Credentials)})
+}
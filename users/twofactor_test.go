@@ -0,0 +1,280 @@
+package users
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/pquerna/otp/totp"
+)
+
+// testAuthenticator represents software WebAuthn authenticator
+type testAuthenticator struct {
+	key     *ecdsa.PrivateKey
+	id      []byte
+	rpID    string
+	origin  string
+	counter uint32
+}
+
+// helper function to create software WebAuthn authenticator
+func newTestAuthenticator(t *testing.T) *testAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	return &testAuthenticator{key: key, id: id, rpID: "foxden.test", origin: "https://foxden.test"}
+}
+
+// helper function to encode bytes as base64url
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// helper function to provide client data and authenticator data of the
+// ceremony
+func (a *testAuthenticator) data(kind, challenge string, flags byte) ([]byte, []byte) {
+	clientData, _ := json.Marshal(map[string]string{"type": kind, "challenge": challenge, "origin": a.origin})
+	rpHash := sha256.Sum256([]byte(a.rpID))
+	authData := append(rpHash[:], flags, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(authData[33:], a.counter)
+	return clientData, authData
+}
+
+// create provides response of navigator.credentials.create with none
+// attestation
+func (a *testAuthenticator) create(challenge string) []byte {
+	clientData, authData := a.data("webauthn.create", challenge, 0x45)
+	coseKey, _ := webauthncbor.Marshal(map[int]any{1: 2, 3: -7, -1: 1, -2: a.key.X.FillBytes(make([]byte, 32)), -3: a.key.Y.FillBytes(make([]byte, 32))})
+	authData = append(authData, make([]byte, 16)...) // AAGUID
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(a.id)))
+	authData = append(append(authData, a.id...), coseKey...)
+	attestation, _ := webauthncbor.Marshal(map[string]any{"fmt": "none", "attStmt": map[string]any{}, "authData": authData})
+	body, _ := json.Marshal(map[string]any{
+		"id": b64(a.id), "rawId": b64(a.id), "type": "public-key",
+		"response": map[string]string{"clientDataJSON": b64(clientData), "attestationObject": b64(attestation)},
+	})
+	return body
+}
+
+// get provides response of navigator.credentials.get
+func (a *testAuthenticator) get(challenge, user string) []byte {
+	a.counter++
+	clientData, authData := a.data("webauthn.get", challenge, 0x05)
+	clientHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientHash[:]...))
+	sig, _ := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	body, _ := json.Marshal(map[string]any{
+		"id": b64(a.id), "rawId": b64(a.id), "type": "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64(clientData),
+			"authenticatorData": b64(authData),
+			"signature":         b64(sig),
+			"userHandle":        b64([]byte(user)),
+		},
+	})
+	return body
+}
+
+// helper function to provide gin context of tests
+func testContext() *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/login", nil)
+	return c
+}
+
+// TestTwoFactorWebAuthn
+func TestTwoFactorWebAuthn(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	m := testManager()
+	var err error
+	m.WebAuthn, err = webauthn.New(&webauthn.Config{RPID: "foxden.test", RPDisplayName: "FOXDEN", RPOrigins: []string{"https://foxden.test"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Create(ctx, User{Name: "bob", Email: "bob@example.com"}, "secret-password"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Login(testContext(), "bob", "secret-password"); err != nil {
+		t.Fatalf("user without second factor is not logged in, error %v", err)
+	}
+
+	// registration of security key
+	key := newTestAuthenticator(t)
+	creation, err := m.BeginWebAuthnRegistration(ctx, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	codes, err := m.FinishWebAuthnRegistration(ctx, "bob", key.create(creation.Response.Challenge.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != m.RecoveryCodes {
+		t.Errorf("wrong number of recovery codes %d", len(codes))
+	}
+	if _, err := m.FinishWebAuthnRegistration(ctx, "bob", key.create(creation.Response.Challenge.String())); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("registration session is not single use, error %v", err)
+	}
+
+	// login with security key
+	_, err = m.Login(testContext(), "bob", "secret-password")
+	var challenge *TwoFactorChallenge
+	if !errors.As(err, &challenge) || challenge.Enroll || strings.Join(challenge.Methods, ",") != "webauthn,recovery" {
+		t.Fatalf("second factor is not required, challenge %+v error %v", challenge, err)
+	}
+	assertion, err := m.BeginWebAuthnLogin(ctx, challenge.Challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := m.LoginTwoFactor(testContext(), challenge.Challenge, authz.WebAuthnMethod, "", key.get(assertion.Response.Challenge.String(), "bob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := authz.TokenClaims(token, m.Secret)
+	if err != nil || !authz.HasTwoFactor(claims) || strings.Join(claims.AMR, ",") != "pwd,mfa,hwk" {
+		t.Errorf("wrong claims %+v, error %v", claims, err)
+	}
+	if user, _ := m.Get(ctx, "bob"); user.Credentials[0].Authenticator.SignCount != 1 {
+		t.Errorf("sign counter is not updated %+v", user.Credentials[0].Authenticator)
+	}
+
+	// assertion of another challenge and cloned authenticator are rejected
+	_, err = m.Login(testContext(), "bob", "secret-password")
+	errors.As(err, &challenge)
+	assertion, _ = m.BeginWebAuthnLogin(ctx, challenge.Challenge)
+	if _, err := m.LoginTwoFactor(testContext(), challenge.Challenge, authz.WebAuthnMethod, "", key.get("other", "bob")); err == nil {
+		t.Error("assertion of another challenge is accepted")
+	}
+	_, err = m.Login(testContext(), "bob", "secret-password")
+	errors.As(err, &challenge)
+	assertion, _ = m.BeginWebAuthnLogin(ctx, challenge.Challenge)
+	key.counter = 0
+	if _, err := m.LoginTwoFactor(testContext(), challenge.Challenge, authz.WebAuthnMethod, "", key.get(assertion.Response.Challenge.String(), "bob")); err == nil {
+		t.Error("assertion of cloned authenticator is accepted")
+	}
+
+	// recovery codes are single use
+	_, err = m.Login(testContext(), "bob", "secret-password")
+	errors.As(err, &challenge)
+	if _, err := m.LoginTwoFactor(testContext(), challenge.Challenge, authz.RecoveryMethod, codes[0], nil); err != nil {
+		t.Errorf("recovery code is rejected, error %v", err)
+	}
+	_, err = m.Login(testContext(), "bob", "secret-password")
+	errors.As(err, &challenge)
+	if _, err := m.LoginTwoFactor(testContext(), challenge.Challenge, authz.RecoveryMethod, codes[0], nil); !errors.Is(err, authz.ErrInvalidRecoveryCode) {
+		t.Errorf("used recovery code is accepted, error %v", err)
+	}
+	if _, err := m.LoginTwoFactor(testContext(), challenge.Challenge, authz.RecoveryMethod, codes[1], nil); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("login challenge is not single use, error %v", err)
+	}
+
+	if err := m.DisableTwoFactor(ctx, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Login(testContext(), "bob", "secret-password"); err != nil {
+		t.Errorf("second factor is required after it is disabled, error %v", err)
+	}
+}
+
+// TestTwoFactorHandlers
+func TestTwoFactorHandlers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	m := testManager()
+	admin := User{Name: "root", Email: "root@example.com", Roles: authz.DefaultTwoFactorRoles}
+	if _, err := m.Create(ctx, admin, "secret-password"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.AccessToken(admin); !errors.Is(err, authz.ErrTwoFactorMissing) {
+		t.Errorf("token is issued to admin without second factor, error %v", err)
+	}
+	r := gin.New()
+	for _, route := range m.Routes("/users") {
+		r.Handle(route.Method, route.Path, route.Handler)
+	}
+	call := func(method, path string, body any, header, value string, out any) int {
+		data, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, strings.NewReader(string(data)))
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if out != nil {
+			json.Unmarshal(w.Body.Bytes(), out)
+		}
+		return w.Code
+	}
+
+	// admin without second factor enrolls TOTP with login challenge
+	var challenge TwoFactorChallenge
+	if code := call("POST", "/users/login", LoginRequest{User: "root", Password: "secret-password"}, "", "", &challenge); code != http.StatusAccepted || !challenge.Enroll {
+		t.Fatalf("wrong login response %d %+v", code, challenge)
+	}
+	if code := call("POST", "/users/2fa/totp", nil, "", "", nil); code != http.StatusUnauthorized {
+		t.Errorf("enrollment without credentials, code %d", code)
+	}
+	var enrollment TOTPEnrollment
+	if code := call("POST", "/users/2fa/totp", nil, TwoFactorChallengeHeader, challenge.Challenge, &enrollment); code != http.StatusOK || !strings.HasPrefix(enrollment.URL, "otpauth://totp/") || len(enrollment.QRCode) == 0 {
+		t.Fatalf("wrong enrollment response %d %+v", code, enrollment)
+	}
+	code, _ := totp.GenerateCode(enrollment.Secret, time.Now())
+	var resp EnrollmentResponse
+	if code := call("POST", "/users/2fa/totp/confirm", TwoFactorRequest{Code: code}, TwoFactorChallengeHeader, challenge.Challenge, &resp); code != http.StatusOK || resp.Token == nil || len(resp.RecoveryCodes) == 0 {
+		t.Fatalf("wrong confirmation response %d %+v", code, resp)
+	}
+	if claims, err := authz.TokenClaims(resp.Token.AccessToken, m.Secret); err != nil || !authz.HasTwoFactor(claims) {
+		t.Errorf("token of enrollment is issued without second factor, error %v", err)
+	}
+	if code := call("POST", "/users/2fa/totp", nil, TwoFactorChallengeHeader, challenge.Challenge, nil); code != http.StatusUnauthorized {
+		t.Errorf("login challenge is reused, code %d", code)
+	}
+
+	// login with TOTP code, codes can not be replayed
+	call("POST", "/users/login", LoginRequest{User: "root", Password: "secret-password"}, "", "", &challenge)
+	if challenge.Enroll || challenge.Methods[0] != authz.TOTPMethod {
+		t.Errorf("wrong login challenge %+v", challenge)
+	}
+	req := TwoFactorRequest{Challenge: challenge.Challenge, Method: authz.TOTPMethod, Code: code}
+	if code := call("POST", "/users/login/2fa", req, "", "", nil); code != http.StatusUnauthorized {
+		t.Errorf("replayed TOTP code is accepted, code %d", code)
+	}
+	call("POST", "/users/login", LoginRequest{User: "root", Password: "secret-password"}, "", "", &challenge)
+	req.Challenge = challenge.Challenge
+	req.Code, _ = totp.GenerateCode(enrollment.Secret, time.Now().Add(30*time.Second))
+	var token authz.Token
+	if code := call("POST", "/users/login/2fa", req, "", "", &token); code != http.StatusOK || token.AccessToken == "" {
+		t.Fatalf("wrong response of TOTP login %d", code)
+	}
+
+	// token issued after second factor manages recovery codes, second
+	// factor of admin can not be disabled
+	bearer := "Bearer " + token.AccessToken
+	if code := call("POST", "/users/2fa/recovery", nil, "Authorization", bearer, &resp); code != http.StatusOK || len(resp.RecoveryCodes) != m.RecoveryCodes {
+		t.Errorf("wrong recovery codes response %d %+v", code, resp)
+	}
+	if code := call("DELETE", "/users/2fa", nil, "Authorization", bearer, nil); code != http.StatusForbidden {
+		t.Errorf("second factor of admin is disabled, code %d", code)
+	}
+	if code := call("POST", "/users/2fa/totp", nil, "Authorization", bearer, nil); code != http.StatusConflict {
+		t.Errorf("enabled TOTP is enrolled again, code %d", code)
+	}
+}
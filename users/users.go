@@ -0,0 +1,588 @@
+package users
+
+// users module provides local user accounts for deployments without
+// institutional SSO. Accounts are persisted via storage interface,
+// passwords are hashed with bcrypt and users obtain JWT tokens issued by
+// authz module.
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	srvConfig "github.com/CHESSComputing/golib/config"
+	"github.com/CHESSComputing/golib/storage"
+	utils "github.com/CHESSComputing/golib/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// storage collections
+const (
+	UsersCollection  = "users"
+	TokensCollection = "user_tokens"
+)
+
+// kinds of one-time user tokens
+const (
+	TokenVerifyEmail   = "verify_email"
+	TokenResetPassword = "reset_password"
+)
+
+// errors of users module
+var (
+	ErrUserExists         = errors.New("user already exists")
+	ErrUserNotFound       = errors.New("user not found")
+	ErrInvalidCredentials = errors.New("invalid user name or password")
+	ErrUserDisabled       = errors.New("user is disabled")
+	ErrEmailNotVerified   = errors.New("user email is not verified")
+	ErrInvalidToken       = errors.New("invalid or expired token")
+)
+
+// patternUserName defines valid user names
+var patternUserName = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_.-]{1,63}$`)
+
+// patternEmail defines valid emails
+var patternEmail = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+
+// User represents local user account
+type User struct {
+	Name          string   `json:"name"`
+	Email         string   `json:"email"`
+	FullName      string   `json:"full_name"`
+	Roles         []string `json:"roles"`
+	Scope         string   `json:"scope"` // token scope, e.g. read or write
+	Disabled      bool     `json:"disabled"`
+	EmailVerified bool     `json:"email_verified"`
+	PasswordHash  string   `json:"-"`
+	Created       int64    `json:"created"`
+	Updated       int64    `json:"updated"`
+	LastLogin     int64    `json:"last_login"`
+
+	// password policy, see password module
+	PasswordChanged int64    `json:"password_changed"`
+	MustReset       bool     `json:"must_reset"` // password reset is forced by administrator
+	PasswordHistory []string `json:"-"`          // hashes of previous passwords
+
+	// notification preferences, see notifications module
+	Notifications Notifications `json:"notifications,omitempty"`
+
+	// two-factor authentication, see twofactor module
+	TOTPEnabled   bool                  `json:"totp_enabled"`
+	TOTPSecret    string                `json:"-"` // TOTP secret, it is pending until enrollment is confirmed
+	TOTPPeriod    int64                 `json:"-"` // last used TOTP period, codes can not be replayed
+	RecoveryCodes []string              `json:"-"` // hashes of unused recovery codes
+	Credentials   []webauthn.Credential `json:"-"` // WebAuthn credentials of security keys
+}
+
+// helper function to convert user into storage record
+func (u User) record() map[string]any {
+	return map[string]any{
+		"_id":              u.Name,
+		"email":            strings.ToLower(u.Email),
+		"full_name":        u.FullName,
+		"roles":            u.Roles,
+		"scope":            u.Scope,
+		"disabled":         u.Disabled,
+		"email_verified":   u.EmailVerified,
+		"password_hash":    u.PasswordHash,
+		"created":          u.Created,
+		"updated":          u.Updated,
+		"last_login":       u.LastLogin,
+		"password_changed": u.PasswordChanged,
+		"must_reset":       u.MustReset,
+		"password_history": u.PasswordHistory,
+		"notifications":    notificationsValue(u.Notifications),
+		"totp_enabled":     u.TOTPEnabled,
+		"totp_secret":      u.TOTPSecret,
+		"totp_period":      u.TOTPPeriod,
+		"recovery_codes":   u.RecoveryCodes,
+		"credentials":      credentialsValue(u.Credentials),
+	}
+}
+
+// helper function to convert storage record into user
+func userRecord(rec map[string]any) User {
+	str := func(key string) string {
+		if v, ok := rec[key].(string); ok {
+			return v
+		}
+		return ""
+	}
+	flag := func(key string) bool {
+		v, _ := rec[key].(bool)
+		return v
+	}
+	list := func(key string) []string {
+		var out []string
+		// MongoDB returns arrays as primitive.A
+		if vals, ok := utils.ListValues(rec[key]); ok {
+			for _, v := range vals {
+				out = append(out, fmt.Sprintf("%v", v))
+			}
+		}
+		return out
+	}
+	return User{
+		Name:            str("_id"),
+		Email:           str("email"),
+		FullName:        str("full_name"),
+		Roles:           list("roles"),
+		Scope:           str("scope"),
+		Disabled:        flag("disabled"),
+		EmailVerified:   flag("email_verified"),
+		PasswordHash:    str("password_hash"),
+		Created:         utils.ToInt64(rec["created"]),
+		Updated:         utils.ToInt64(rec["updated"]),
+		LastLogin:       utils.ToInt64(rec["last_login"]),
+		PasswordChanged: utils.ToInt64(rec["password_changed"]),
+		MustReset:       flag("must_reset"),
+		PasswordHistory: list("password_history"),
+		Notifications:   notificationsRecord(str("notifications")),
+		TOTPEnabled:     flag("totp_enabled"),
+		TOTPSecret:      str("totp_secret"),
+		TOTPPeriod:      utils.ToInt64(rec["totp_period"]),
+		RecoveryCodes:   list("recovery_codes"),
+		Credentials:     credentialsRecord(str("credentials")),
+	}
+}
+
+// Manager manages local user accounts
+type Manager struct {
+	Store             storage.Store
+	Secret            string                   // secret used to sign JWT tokens
+	TokenExpires      int64                    // expiration of access tokens in seconds, zero means Authz token lifetime policy
+	CookieExpires     int                      // expiration of user cookie in seconds
+	VerificationTTL   time.Duration            // validity of email verification tokens
+	ResetTTL          time.Duration            // validity of password reset tokens
+	MinPasswordLength int                      // minimum length of passwords
+	Policy            srvConfig.PasswordPolicy // password complexity, history and expiry rules
+	AdminRoles        []string                 // roles allowed to use admin API, e.g. to force password resets
+	RequireVerified   bool                     // require verified email to login
+	BcryptCost        int                      // bcrypt cost of password hashes
+	TwoFactorExpires  time.Duration            // validity of second step of login
+	RecoveryCodes     int                      // number of generated recovery codes
+
+	// WebAuthn relying party of security keys, nil disables them
+	WebAuthn *webauthn.WebAuthn
+
+	// event types of notification preferences, see notifications module
+	NotificationEvents []string
+
+	// Notify sends one-time token of given kind to the user, e.g. by email
+	// (see mail module), it is called when token is issued
+	Notify func(ctx context.Context, kind string, user User, token string, expires time.Time) error
+
+	dummyOnce sync.Once
+	dummyHash string
+}
+
+// NewManager returns users manager with settings from Authz and Frontend
+// configuration
+func NewManager(store storage.Store) *Manager {
+	tf := authz.TwoFactorConfig()
+	m := &Manager{
+		Store:             store,
+		CookieExpires:     7200,
+		VerificationTTL:   48 * time.Hour,
+		ResetTTL:          time.Hour,
+		MinPasswordLength: 8,
+		BcryptCost:        bcrypt.DefaultCost,
+		TwoFactorExpires:  tf.Expires,
+		RecoveryCodes:     tf.RecoveryCodes,
+		Policy:            passwordPolicy(),
+		AdminRoles:        []string{"foxden-admin"},
+
+		NotificationEvents: DefaultNotificationEvents,
+	}
+	if m.Policy.MinLength > 0 {
+		m.MinPasswordLength = m.Policy.MinLength
+	}
+	if len(m.Policy.AdminRoles) > 0 {
+		m.AdminRoles = m.Policy.AdminRoles
+	}
+	if tf.RPID != "" {
+		wa, err := authz.NewWebAuthn()
+		if err != nil {
+			log.Printf("ERROR: unable to setup WebAuthn, error %v", err)
+		}
+		m.WebAuthn = wa
+	}
+	if srvConfig.Config != nil {
+		m.Secret = srvConfig.Config.Authz.ClientID
+		if srvConfig.Config.Frontend.UserCookieExpires > 0 {
+			m.CookieExpires = int(srvConfig.Config.Frontend.UserCookieExpires.Seconds())
+		}
+	}
+	return m
+}
+
+// helper function to produce hash of the password
+func (m *Manager) hashPassword(password string) (string, error) {
+	cost := m.BcryptCost
+	if cost == 0 {
+		cost = bcrypt.DefaultCost
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// Create creates new user with given password
+func (m *Manager) Create(ctx context.Context, user User, password string) (User, error) {
+	if !patternUserName.MatchString(user.Name) {
+		return user, fmt.Errorf("invalid user name '%s'", user.Name)
+	}
+	if !patternEmail.MatchString(user.Email) {
+		return user, fmt.Errorf("invalid email '%s'", user.Email)
+	}
+	if _, err := m.GetByEmail(ctx, user.Email); err == nil {
+		return user, fmt.Errorf("%w: email %s is already used", ErrUserExists, user.Email)
+	}
+	if err := m.ValidatePassword(user, password); err != nil {
+		return user, err
+	}
+	hash, err := m.hashPassword(password)
+	if err != nil {
+		return user, err
+	}
+	now := time.Now().Unix()
+	user.PasswordHash = hash
+	user.PasswordChanged = now
+	user.Email = strings.ToLower(user.Email)
+	user.Created = now
+	user.Updated = now
+	if user.Scope == "" {
+		user.Scope = "read"
+	}
+	if err := m.Store.Insert(ctx, UsersCollection, user.record()); err != nil {
+		if errors.Is(err, storage.ErrDuplicate) {
+			return user, fmt.Errorf("%w: %s", ErrUserExists, user.Name)
+		}
+		return user, err
+	}
+	return user, nil
+}
+
+// helper function to find single user
+func (m *Manager) find(ctx context.Context, spec map[string]any) (User, error) {
+	rec, err := storage.FindOne(ctx, m.Store, UsersCollection, spec)
+	if errors.Is(err, storage.ErrNotFound) {
+		return User{}, ErrUserNotFound
+	}
+	if err != nil {
+		return User{}, err
+	}
+	return userRecord(rec), nil
+}
+
+// Get returns user with given name
+func (m *Manager) Get(ctx context.Context, name string) (User, error) {
+	return m.find(ctx, map[string]any{"_id": name})
+}
+
+// GetByEmail returns user with given email
+func (m *Manager) GetByEmail(ctx context.Context, email string) (User, error) {
+	return m.find(ctx, map[string]any{"email": strings.ToLower(email)})
+}
+
+// List returns users
+func (m *Manager) List(ctx context.Context, opts *storage.FindOptions) ([]User, error) {
+	records, err := m.Store.Find(ctx, UsersCollection, nil, opts)
+	if err != nil {
+		return nil, err
+	}
+	var out []User
+	for _, rec := range records {
+		out = append(out, userRecord(rec))
+	}
+	return out, nil
+}
+
+// helper function to update user fields
+func (m *Manager) update(ctx context.Context, name string, fields map[string]any) error {
+	fields["updated"] = time.Now().Unix()
+	n, err := m.Store.Update(ctx, UsersCollection, map[string]any{"_id": name}, fields)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// Update updates full name, email, roles and scope of the user. Change of
+// email resets its verification status.
+func (m *Manager) Update(ctx context.Context, user User) (User, error) {
+	old, err := m.Get(ctx, user.Name)
+	if err != nil {
+		return user, err
+	}
+	fields := map[string]any{"full_name": user.FullName, "roles": user.Roles, "scope": user.Scope}
+	if email := strings.ToLower(user.Email); email != "" && email != old.Email {
+		if !patternEmail.MatchString(email) {
+			return user, fmt.Errorf("invalid email '%s'", user.Email)
+		}
+		if _, err := m.GetByEmail(ctx, email); err == nil {
+			return user, fmt.Errorf("%w: email %s is already used", ErrUserExists, email)
+		}
+		fields["email"] = email
+		fields["email_verified"] = false
+	}
+	if err := m.update(ctx, user.Name, fields); err != nil {
+		return user, err
+	}
+	return m.Get(ctx, user.Name)
+}
+
+// SetPassword sets new password of the user, it should satisfy password
+// policy
+func (m *Manager) SetPassword(ctx context.Context, name, password string) error {
+	user, err := m.Get(ctx, name)
+	if err != nil {
+		return err
+	}
+	return m.setPassword(ctx, user, password)
+}
+
+// Disable disables the user
+func (m *Manager) Disable(ctx context.Context, name string) error {
+	return m.update(ctx, name, map[string]any{"disabled": true})
+}
+
+// Enable enables the user
+func (m *Manager) Enable(ctx context.Context, name string) error {
+	return m.update(ctx, name, map[string]any{"disabled": false})
+}
+
+// helper function to check password of the user
+func (m *Manager) authenticate(ctx context.Context, name, password string) (User, error) {
+	user, err := m.Get(ctx, name)
+	if errors.Is(err, ErrUserNotFound) {
+		// spend the same time as for existing users to not reveal them
+		m.dummyOnce.Do(func() {
+			m.dummyHash, _ = m.hashPassword(strings.Repeat("x", m.MinPasswordLength))
+		})
+		bcrypt.CompareHashAndPassword([]byte(m.dummyHash), []byte(password))
+		return user, ErrInvalidCredentials
+	}
+	if err != nil {
+		return user, err
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		return user, ErrInvalidCredentials
+	}
+	if user.Disabled {
+		return user, ErrUserDisabled
+	}
+	return user, nil
+}
+
+// Authenticate checks user credentials and returns the user. Users with
+// expired password get ErrPasswordExpired, they should change password via
+// ChangePassword or password reset flow.
+func (m *Manager) Authenticate(ctx context.Context, name, password string) (User, error) {
+	user, err := m.authenticate(ctx, name, password)
+	if err != nil {
+		return user, err
+	}
+	if m.RequireVerified && !user.EmailVerified {
+		return user, ErrEmailNotVerified
+	}
+	if m.PasswordExpired(user) {
+		return user, ErrPasswordExpired
+	}
+	user.LastLogin = time.Now().Unix()
+	m.update(ctx, name, map[string]any{"last_login": user.LastLogin})
+	return user, nil
+}
+
+// AccessToken issues JWT access token for the user, users whose roles
+// require second factor obtain tokens via LoginTwoFactor only
+func (m *Manager) AccessToken(user User) (string, error) {
+	if authz.RequiresTwoFactor(user.Roles) {
+		return "", fmt.Errorf("%w for roles %v", authz.ErrTwoFactorMissing, user.Roles)
+	}
+	return m.accessToken(user, nil)
+}
+
+// helper function to issue JWT access token with given authentication
+// methods of the user
+func (m *Manager) accessToken(user User, amr []string) (string, error) {
+	if m.Secret == "" {
+		return "", errors.New("token secret is not configured")
+	}
+	claims := authz.CustomClaims{User: user.Name, Scope: user.Scope, Kind: "local", Roles: user.Roles}
+	return authz.AuthenticatedJWTAccessToken(m.Secret, m.TokenExpires, claims, amr)
+}
+
+// helper function to issue access token and set user cookie
+func (m *Manager) login(c *gin.Context, user User, amr []string) (string, error) {
+	token, err := m.accessToken(user, amr)
+	if err != nil {
+		return "", err
+	}
+	authz.SetUserCookie(c, user.Name, m.CookieExpires)
+	return token, nil
+}
+
+// Login authenticates user, sets user cookie used by frontends and
+// returns JWT access token. Users with enabled second factor or whose
+// roles require it get *TwoFactorChallenge error which wraps
+// ErrTwoFactorRequired, their login is completed by LoginTwoFactor.
+func (m *Manager) Login(c *gin.Context, name, password string) (string, error) {
+	user, err := m.Authenticate(c.Request.Context(), name, password)
+	if err != nil {
+		return "", err
+	}
+	if user.TwoFactorEnabled() || authz.RequiresTwoFactor(user.Roles) {
+		challenge, err := m.challenge(c.Request.Context(), user)
+		if err != nil {
+			return "", err
+		}
+		return "", challenge
+	}
+	return m.login(c, user, []string{authz.AMRPassword})
+}
+
+// helper function to hash one-time token
+func tokenHash(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// helper function to issue one-time token of given kind and validity for
+// the user, only hash of the token is stored
+func (m *Manager) issueToken(ctx context.Context, name, kind string, ttl time.Duration) (string, time.Time, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", time.Time{}, err
+	}
+	token := hex.EncodeToString(buf)
+	expires := time.Now().Add(ttl)
+	rec := map[string]any{
+		"_id":     tokenHash(token),
+		"user":    name,
+		"kind":    kind,
+		"expires": expires.Unix(),
+	}
+	if err := m.Store.Insert(ctx, TokensCollection, rec); err != nil {
+		return "", expires, err
+	}
+	return token, expires, nil
+}
+
+// IssueToken issues one-time token of given kind for the user, only hash
+// of the token is stored. The token is sent to the user via Notify function
+// if it is set.
+func (m *Manager) IssueToken(ctx context.Context, name, kind string) (string, error) {
+	user, err := m.Get(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	ttl := m.VerificationTTL
+	if kind == TokenResetPassword {
+		ttl = m.ResetTTL
+	}
+	token, expires, err := m.issueToken(ctx, name, kind, ttl)
+	if err != nil {
+		return "", err
+	}
+	if m.Notify != nil {
+		if err := m.Notify(ctx, kind, user, token, expires); err != nil {
+			log.Printf("ERROR: unable to notify user %s about %s token, error %v", name, kind, err)
+			return "", err
+		}
+	}
+	return token, nil
+}
+
+// helper function to find valid one-time token, it returns user name
+func (m *Manager) lookupToken(ctx context.Context, token, kind string) (string, error) {
+	spec := map[string]any{"_id": tokenHash(token), "kind": kind}
+	rec, err := storage.FindOne(ctx, m.Store, TokensCollection, spec)
+	if errors.Is(err, storage.ErrNotFound) {
+		return "", ErrInvalidToken
+	}
+	if err != nil {
+		return "", err
+	}
+	if utils.ToInt64(rec["expires"]) < time.Now().Unix() {
+		return "", ErrInvalidToken
+	}
+	name, _ := rec["user"].(string)
+	return name, nil
+}
+
+// helper function to consume one-time token, it returns user name
+func (m *Manager) consumeToken(ctx context.Context, token, kind string) (string, error) {
+	name, err := m.lookupToken(ctx, token, kind)
+	if err != nil && !errors.Is(err, ErrInvalidToken) {
+		return "", err
+	}
+	// tokens are single use, expired ones are removed as well
+	spec := map[string]any{"_id": tokenHash(token), "kind": kind}
+	if _, err := m.Store.Remove(ctx, TokensCollection, spec); err != nil {
+		return "", err
+	}
+	return name, err
+}
+
+// VerifyEmail verifies user email with given verification token
+func (m *Manager) VerifyEmail(ctx context.Context, token string) (User, error) {
+	name, err := m.consumeToken(ctx, token, TokenVerifyEmail)
+	if err != nil {
+		return User{}, err
+	}
+	if err := m.update(ctx, name, map[string]any{"email_verified": true}); err != nil {
+		return User{}, err
+	}
+	return m.Get(ctx, name)
+}
+
+// RequestPasswordReset issues password reset token for user with given
+// email, the token should be sent to the user by email
+func (m *Manager) RequestPasswordReset(ctx context.Context, email string) (User, string, error) {
+	user, err := m.GetByEmail(ctx, email)
+	if err != nil {
+		return user, "", err
+	}
+	if user.Disabled {
+		return user, "", ErrUserDisabled
+	}
+	token, err := m.IssueToken(ctx, user.Name, TokenResetPassword)
+	return user, token, err
+}
+
+// ResetPassword sets new password of the user with given reset token
+func (m *Manager) ResetPassword(ctx context.Context, token, password string) error {
+	name, err := m.lookupToken(ctx, token, TokenResetPassword)
+	if err != nil {
+		return err
+	}
+	user, err := m.Get(ctx, name)
+	if err != nil {
+		return err
+	}
+	// validate password before the token is consumed
+	if err := m.ValidatePassword(user, password); err != nil {
+		return err
+	}
+	if _, err := m.consumeToken(ctx, token, TokenResetPassword); err != nil {
+		return err
+	}
+	return m.setPassword(ctx, user, password)
+}
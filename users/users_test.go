@@ -0,0 +1,172 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	"github.com/CHESSComputing/golib/storage"
+	bson "go.mongodb.org/mongo-driver/bson"
+)
+
+// helper function to create users manager for tests
+func testManager() *Manager {
+	m := NewManager(storage.NewMemoryStore())
+	m.Secret = "test-secret"
+	m.BcryptCost = 4
+	return m
+}
+
+// TestUsers
+func TestUsers(t *testing.T) {
+	ctx := context.Background()
+	m := testManager()
+	user := User{Name: "alice", Email: "Alice@Example.com", Roles: []string{"admin"}}
+	if _, err := m.Create(ctx, user, "short"); err == nil {
+		t.Error("short password should be rejected")
+	}
+	user, err := m.Create(ctx, user, "secret-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Email != "alice@example.com" || user.Scope != "read" {
+		t.Errorf("unexpected user %+v", user)
+	}
+	if _, err := m.Create(ctx, User{Name: "alice", Email: "other@example.com"}, "secret-password"); !errors.Is(err, ErrUserExists) {
+		t.Errorf("expected ErrUserExists, got %v", err)
+	}
+	if _, err := m.Create(ctx, User{Name: "bob", Email: "alice@example.com"}, "secret-password"); !errors.Is(err, ErrUserExists) {
+		t.Errorf("expected ErrUserExists for duplicate email, got %v", err)
+	}
+	if u, err := m.GetByEmail(ctx, "ALICE@example.com"); err != nil || u.Name != "alice" {
+		t.Errorf("unable to get user by email, user %+v error %v", u, err)
+	}
+
+	// authentication
+	if _, err := m.Authenticate(ctx, "alice", "wrong-password"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("expected ErrInvalidCredentials, got %v", err)
+	}
+	if _, err := m.Authenticate(ctx, "nobody", "secret-password"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("expected ErrInvalidCredentials for unknown user, got %v", err)
+	}
+	m.RequireVerified = true
+	if _, err := m.Authenticate(ctx, "alice", "secret-password"); !errors.Is(err, ErrEmailNotVerified) {
+		t.Errorf("expected ErrEmailNotVerified, got %v", err)
+	}
+
+	// email verification
+	token, err := m.IssueToken(ctx, "alice", TokenVerifyEmail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.VerifyEmail(ctx, "bad-token"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected ErrInvalidToken, got %v", err)
+	}
+	if u, err := m.VerifyEmail(ctx, token); err != nil || !u.EmailVerified {
+		t.Errorf("unable to verify email, user %+v error %v", u, err)
+	}
+	if _, err := m.VerifyEmail(ctx, token); !errors.Is(err, ErrInvalidToken) {
+		t.Error("verification token should be single use")
+	}
+	user, err = m.Authenticate(ctx, "alice", "secret-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.LastLogin == 0 {
+		t.Error("last login is not set")
+	}
+
+	// access token
+	accessToken, err := m.AccessToken(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := authz.TokenClaims(accessToken, m.Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.CustomClaims.User != "alice" || len(claims.CustomClaims.Roles) != 1 {
+		t.Errorf("unexpected token claims %+v", claims.CustomClaims)
+	}
+
+	// password reset
+	_, token, err = m.RequestPasswordReset(ctx, "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.ResetPassword(ctx, token, "new-password"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Authenticate(ctx, "alice", "secret-password"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Error("old password should not be valid after reset")
+	}
+	if _, err := m.Authenticate(ctx, "alice", "new-password"); err != nil {
+		t.Error(err)
+	}
+	m.ResetTTL = -time.Second
+	_, token, _ = m.RequestPasswordReset(ctx, "alice@example.com")
+	if err := m.ResetPassword(ctx, token, "other-password"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected ErrInvalidToken for expired token, got %v", err)
+	}
+
+	// notification of issued tokens
+	m.ResetTTL = time.Hour
+	var notified string
+	m.Notify = func(ctx context.Context, kind string, u User, token string, expires time.Time) error {
+		if kind != TokenResetPassword || u.Email != "alice@example.com" || expires.Before(time.Now()) {
+			t.Errorf("unexpected notification %s of user %+v, expires %v", kind, u, expires)
+		}
+		notified = token
+		return nil
+	}
+	if _, token, err = m.RequestPasswordReset(ctx, "alice@example.com"); err != nil || token != notified {
+		t.Errorf("token %s is not notified, error %v", token, err)
+	}
+	m.Notify = func(ctx context.Context, kind string, u User, token string, expires time.Time) error {
+		return errors.New("smtp is down")
+	}
+	if _, err := m.IssueToken(ctx, "alice", TokenVerifyEmail); err == nil {
+		t.Error("failed notification should fail token issue")
+	}
+	m.Notify = nil
+
+	// update and disable
+	user.FullName = "Alice Smith"
+	user.Email = "alice@chess.org"
+	if user, err = m.Update(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	if user.FullName != "Alice Smith" || user.EmailVerified {
+		t.Errorf("unexpected updated user %+v", user)
+	}
+	if err := m.Disable(ctx, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	m.RequireVerified = false
+	if _, err := m.Authenticate(ctx, "alice", "new-password"); !errors.Is(err, ErrUserDisabled) {
+		t.Errorf("expected ErrUserDisabled, got %v", err)
+	}
+	if err := m.Disable(ctx, "nobody"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound, got %v", err)
+	}
+}
+
+// TestUserRecord
+func TestUserRecord(t *testing.T) {
+	user := User{Name: "alice", Email: "alice@chess.org", Roles: []string{"admin", "staff"}, Created: 123}
+	// MongoDB decodes arrays of records as primitive.A
+	data, err := bson.Marshal(user.record())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rec map[string]any
+	if err := bson.Unmarshal(data, &rec); err != nil {
+		t.Fatal(err)
+	}
+	out := userRecord(rec)
+	if len(out.Roles) != 2 || out.Roles[1] != "staff" || out.Email != user.Email || out.Created != 123 {
+		t.Errorf("wrong user %+v", out)
+	}
+}
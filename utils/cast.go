@@ -30,18 +30,38 @@ func CastInt(val interface{}) (int, error) {
 	return 0, errors.New(msg)
 }
 
-// CastInt64 function to check and cast interface{} to int64 data-type
+// CastInt64 function to check and cast interface{} to int64 data-type,
+// it accepts numeric types returned by databases, e.g. int32 and float64
+// numbers of MongoDB or decimal strings and bytes of SQL drivers
 func CastInt64(val interface{}) (int64, error) {
 	switch v := val.(type) {
 	case int:
 		return int64(v), nil
+	case int32:
+		return int64(v), nil
 	case int64:
 		return v, nil
+	case float64:
+		return int64(v), nil
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	case []byte:
+		return strconv.ParseInt(string(v), 10, 64)
 	}
 	msg := fmt.Sprintf("wrong data type for %v type %T", val, val)
 	return 0, errors.New(msg)
 }
 
+// ToInt64 converts numeric value of storage record into int64 via
+// CastInt64, missing and invalid values are zero
+func ToInt64(val interface{}) int64 {
+	v, err := CastInt64(val)
+	if err != nil {
+		return 0
+	}
+	return v
+}
+
 // CastFloat function to check and cast interface{} to int64 data-type
 func CastFloat(val interface{}) (float64, error) {
 	switch v := val.(type) {
@@ -0,0 +1,20 @@
+package utils
+
+import "testing"
+
+// TestCastInt64
+func TestCastInt64(t *testing.T) {
+	for _, val := range []any{int(12), int32(12), int64(12), float64(12), "12", []byte("12")} {
+		if v, err := CastInt64(val); err != nil || v != 12 {
+			t.Errorf("wrong value %d of %v type %T, error %v", v, val, val, err)
+		}
+	}
+	for _, val := range []any{nil, "abc", true} {
+		if _, err := CastInt64(val); err == nil {
+			t.Errorf("value %v type %T is converted", val, val)
+		}
+		if v := ToInt64(val); v != 0 {
+			t.Errorf("invalid value %v is converted into %d", val, v)
+		}
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"reflect"
 	"sort"
 	"strings"
 
@@ -316,3 +317,25 @@ func UniqueFormValues(vals []string) []string {
 	}
 	return OrderedSet[string](items)
 }
+
+// ListValues converts slice of any type, e.g. []interface{}, []string or
+// MongoDB primitive.A used for arrays of decoded documents, into list of
+// interfaces. It returns false if value is not a slice, byte slices are not
+// treated as lists.
+func ListValues(val interface{}) ([]interface{}, bool) {
+	switch v := val.(type) {
+	case nil, []byte:
+		return nil, false
+	case []interface{}:
+		return v, true
+	}
+	rv := reflect.ValueOf(val)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return nil, false
+	}
+	out := make([]interface{}, rv.Len())
+	for i := range out {
+		out[i] = rv.Index(i).Interface()
+	}
+	return out, true
+}
@@ -36,3 +36,19 @@ func TestUniqueFormValues(t *testing.T) {
 		t.Error("Fail TestUniqueFormValues")
 	}
 }
+
+// TestListValues
+func TestListValues(t *testing.T) {
+	type list []interface{} // e.g. MongoDB primitive.A
+	for _, val := range []interface{}{list{"a", "b"}, []string{"a", "b"}, []interface{}{"a", "b"}} {
+		vals, ok := ListValues(val)
+		if !ok || len(vals) != 2 || vals[1] != "b" {
+			t.Errorf("wrong list values of %#v: %v", val, vals)
+		}
+	}
+	for _, val := range []interface{}{nil, "a", []byte("ab"), map[string]interface{}{}} {
+		if _, ok := ListValues(val); ok {
+			t.Errorf("%#v should not be a list", val)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package vocab
+
+// handlers module provides HTTP endpoints of controlled vocabularies, e.g.
+// for autocomplete of web forms
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	server "github.com/CHESSComputing/golib/server"
+	services "github.com/CHESSComputing/golib/services"
+	"github.com/gin-gonic/gin"
+)
+
+// helper function to abort request with service error
+func abort(c *gin.Context, code, srvCode int, err error) {
+	rec := services.Response("vocab", code, srvCode, err)
+	c.AbortWithStatusJSON(code, rec)
+}
+
+// helper function to get vocabulary of name parameter
+func (m *Manager) vocabulary(c *gin.Context) (*Vocabulary, bool) {
+	v, err := m.Get(c.Request.Context(), c.Param("name"))
+	switch {
+	case errors.Is(err, ErrUnknown):
+		abort(c, http.StatusNotFound, services.QueryError, err)
+		return nil, false
+	case err != nil:
+		abort(c, http.StatusBadGateway, services.ServiceError, err)
+		return nil, false
+	}
+	return v, true
+}
+
+// ListHandler provides names of vocabularies
+func (m *Manager) ListHandler(c *gin.Context) {
+	names := m.Names()
+	if names == nil {
+		names = []string{}
+	}
+	c.JSON(http.StatusOK, names)
+}
+
+// GetHandler provides terms of vocabulary
+func (m *Manager) GetHandler(c *gin.Context) {
+	if v, ok := m.vocabulary(c); ok {
+		c.JSON(http.StatusOK, v)
+	}
+}
+
+// SuggestHandler provides terms of vocabulary matching q query parameter,
+// number of terms is limited by limit parameter
+func (m *Manager) SuggestHandler(c *gin.Context) {
+	limit := DefaultSuggestLimit
+	if val := c.Query("limit"); val != "" {
+		n, err := strconv.Atoi(val)
+		if err != nil || n <= 0 {
+			abort(c, http.StatusBadRequest, services.QueryError, errors.New("invalid limit"))
+			return
+		}
+		limit = n
+	}
+	v, ok := m.vocabulary(c)
+	if !ok {
+		return
+	}
+	terms := v.Suggest(c.Query("q"), limit)
+	if terms == nil {
+		terms = []Term{}
+	}
+	c.JSON(http.StatusOK, terms)
+}
+
+// Routes returns server routes of vocabularies under given path, e.g.
+// /vocabularies
+func (m *Manager) Routes(path string) []server.Route {
+	return []server.Route{
+		{Method: "GET", Path: path, Authorized: true, Scope: "read", Handler: m.ListHandler,
+			Summary: "list controlled vocabularies", Response: []string{}},
+		{Method: "GET", Path: path + "/:name", Authorized: true, Scope: "read", Handler: m.GetHandler,
+			Summary: "get terms of controlled vocabulary", Response: Vocabulary{}},
+		{Method: "GET", Path: path + "/:name/suggest", Authorized: true, Scope: "read", Handler: m.SuggestHandler,
+			Summary: "suggest terms of controlled vocabulary", Response: []Term{}},
+	}
+}
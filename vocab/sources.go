@@ -0,0 +1,204 @@
+package vocab
+
+// sources module fetches controlled vocabularies from local files or
+// remote services, i.e. JSON and text term lists, ontology lookup service
+// (OLS) ontologies, e.g. PaN-ET, and enumerations of NeXus application
+// definitions (NXDL)
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// supported formats of vocabularies
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+	FormatOLS  = "ols"
+	FormatNXDL = "nxdl"
+)
+
+// OLSPageSize defines number of terms fetched per request of ontology
+// lookup service
+var OLSPageSize = 500
+
+// helper function to check if source is remote
+func remote(source string) bool {
+	return strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://")
+}
+
+// helper function to fetch data of remote source
+func (m *Manager) get(ctx context.Context, rurl string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", rurl, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := m.HttpClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s, status %s", ErrUnavailable, rurl, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// helper function to read file or remote source
+func (m *Manager) read(ctx context.Context, source string) ([]byte, error) {
+	if remote(source) {
+		return m.get(ctx, source)
+	}
+	return os.ReadFile(source)
+}
+
+// ParseJSON parses JSON list of terms, either strings or term objects
+func ParseJSON(data []byte) ([]Term, error) {
+	var names []string
+	if err := json.Unmarshal(data, &names); err == nil {
+		var terms []Term
+		for _, name := range names {
+			terms = append(terms, Term{Label: name})
+		}
+		return terms, nil
+	}
+	var terms []Term
+	if err := json.Unmarshal(data, &terms); err != nil {
+		return nil, fmt.Errorf("unable to parse vocabulary, error %v", err)
+	}
+	return terms, nil
+}
+
+// ParseText parses list of terms, one term per line, lines starting with
+// # are comments
+func ParseText(data []byte) ([]Term, error) {
+	var terms []Term
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		terms = append(terms, Term{Label: line})
+	}
+	return terms, scanner.Err()
+}
+
+// ParseNXDL parses enumeration items of given field of NeXus application
+// definition, all enumerations are used if field is empty
+func ParseNXDL(data []byte, field string) ([]Term, error) {
+	var terms []Term
+	var fields []string
+	var item *Term
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse NXDL, error %v", err)
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			attr := func(name string) string {
+				for _, a := range t.Attr {
+					if a.Name.Local == name {
+						return a.Value
+					}
+				}
+				return ""
+			}
+			switch t.Name.Local {
+			case "field", "attribute":
+				fields = append(fields, attr("name"))
+			case "item":
+				if len(fields) > 0 && (field == "" || fields[len(fields)-1] == field) {
+					item = &Term{Label: attr("value")}
+				}
+			case "doc":
+				if item != nil {
+					var doc string
+					if err := decoder.DecodeElement(&doc, &t); err == nil {
+						item.Definition = strings.Join(strings.Fields(doc), " ")
+					}
+				}
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "field", "attribute":
+				if len(fields) > 0 {
+					fields = fields[:len(fields)-1]
+				}
+			case "item":
+				if item != nil && item.Label != "" {
+					terms = append(terms, *item)
+				}
+				item = nil
+			}
+		}
+	}
+	return terms, nil
+}
+
+// olsPage represents page of terms of ontology lookup service API
+type olsPage struct {
+	Embedded struct {
+		Terms []struct {
+			IRI         string   `json:"iri"`
+			Label       string   `json:"label"`
+			Synonyms    []string `json:"synonyms"`
+			Description []string `json:"description"`
+			Obsolete    bool     `json:"is_obsolete"`
+		} `json:"terms"`
+	} `json:"_embedded"`
+	Page struct {
+		Number     int `json:"number"`
+		TotalPages int `json:"totalPages"`
+	} `json:"page"`
+}
+
+// helper function to fetch terms of ontology from ontology lookup service,
+// e.g. https://www.ebi.ac.uk/ols4
+func (m *Manager) fetchOLS(ctx context.Context, base, ontology string) ([]Term, error) {
+	var terms []Term
+	for page := 0; ; page++ {
+		rurl := fmt.Sprintf("%s/api/ontologies/%s/terms?page=%d&size=%d",
+			strings.TrimSuffix(base, "/"), url.PathEscape(ontology), page, OLSPageSize)
+		data, err := m.get(ctx, rurl)
+		if err != nil {
+			return nil, err
+		}
+		var rec olsPage
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return nil, fmt.Errorf("unable to parse ontology %s, error %v", ontology, err)
+		}
+		for _, t := range rec.Embedded.Terms {
+			if t.Obsolete || t.Label == "" {
+				continue
+			}
+			term := Term{ID: t.IRI, Label: t.Label, Synonyms: t.Synonyms}
+			if len(t.Description) > 0 {
+				term.Definition = t.Description[0]
+			}
+			terms = append(terms, term)
+		}
+		if page+1 >= rec.Page.TotalPages {
+			break
+		}
+	}
+	return terms, nil
+}
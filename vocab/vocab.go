@@ -0,0 +1,300 @@
+package vocab
+
+// vocab module provides controlled vocabularies of schema fields. Terms of
+// vocabularies are fetched from local files or remote ontology services,
+// cached for CacheTTL and used to validate record values and to suggest
+// terms to frontend, e.g. for autocomplete of web forms.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	beamlines "github.com/CHESSComputing/golib/beamlines"
+	srvConfig "github.com/CHESSComputing/golib/config"
+)
+
+// defaults of vocabularies
+var (
+	DefaultCacheTTL     = 24 * time.Hour
+	DefaultTimeout      = 30 * time.Second
+	DefaultSuggestLimit = 10
+)
+
+// errors of vocab module
+var (
+	ErrUnknown     = errors.New("unknown vocabulary")
+	ErrInvalidTerm = errors.New("invalid term")
+	ErrUnavailable = errors.New("vocabulary service is unavailable")
+)
+
+// Term represents term of controlled vocabulary
+type Term struct {
+	ID         string   `json:"id,omitempty"`
+	Label      string   `json:"label"`
+	Synonyms   []string `json:"synonyms,omitempty"`
+	Definition string   `json:"definition,omitempty"`
+}
+
+// Vocabulary represents controlled vocabulary
+type Vocabulary struct {
+	Name    string    `json:"name"`
+	Terms   []Term    `json:"terms"`
+	Fetched time.Time `json:"fetched"`
+
+	index map[string]int // lower case labels, ids and synonyms
+}
+
+// NewVocabulary returns vocabulary of given terms
+func NewVocabulary(name string, terms []Term) *Vocabulary {
+	v := &Vocabulary{Name: name, Terms: terms, Fetched: time.Now(), index: make(map[string]int)}
+	for i, t := range terms {
+		for _, key := range append([]string{t.Label, t.ID}, t.Synonyms...) {
+			key = strings.ToLower(strings.TrimSpace(key))
+			if _, ok := v.index[key]; key != "" && !ok {
+				v.index[key] = i
+			}
+		}
+	}
+	return v
+}
+
+// Lookup returns term of given label, id or synonym, lookup is case
+// insensitive
+func (v *Vocabulary) Lookup(value string) (Term, bool) {
+	if i, ok := v.index[strings.ToLower(strings.TrimSpace(value))]; ok {
+		return v.Terms[i], true
+	}
+	return Term{}, false
+}
+
+// helper function to rank term match of lower case query, lower rank is
+// better match and negative rank means no match
+func rank(t Term, query string) int {
+	label := strings.ToLower(t.Label)
+	switch {
+	case label == query:
+		return 0
+	case strings.HasPrefix(label, query):
+		return 1
+	}
+	for _, s := range t.Synonyms {
+		if strings.HasPrefix(strings.ToLower(s), query) {
+			return 2
+		}
+	}
+	for _, word := range strings.Fields(label) {
+		if strings.HasPrefix(word, query) {
+			return 3
+		}
+	}
+	if strings.Contains(label, query) {
+		return 4
+	}
+	return -1
+}
+
+// Suggest returns terms matching given query, terms with label starting
+// with query come first
+func (v *Vocabulary) Suggest(query string, limit int) []Term {
+	query = strings.ToLower(strings.TrimSpace(query))
+	if limit <= 0 {
+		limit = DefaultSuggestLimit
+	}
+	type match struct {
+		term Term
+		rank int
+	}
+	var matches []match
+	for _, t := range v.Terms {
+		if r := rank(t, query); r >= 0 {
+			matches = append(matches, match{t, r})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].rank != matches[j].rank {
+			return matches[i].rank < matches[j].rank
+		}
+		return matches[i].term.Label < matches[j].term.Label
+	})
+	var out []Term
+	for i := 0; i < len(matches) && i < limit; i++ {
+		out = append(out, matches[i].term)
+	}
+	return out
+}
+
+// Manager represents controlled vocabularies of configured sources
+type Manager struct {
+	Sources    map[string]srvConfig.Vocabulary // vocabulary sources by name
+	CacheTTL   time.Duration                   // time to keep fetched vocabularies
+	HttpClient *http.Client                    // HTTP client of remote sources
+
+	mu    sync.Mutex
+	cache map[string]*Vocabulary
+}
+
+// Vocabularies represents global vocabularies manager, it should be
+// initialized via Init function
+var Vocabularies *Manager
+
+// NewManager returns vocabularies manager of given configuration
+func NewManager(cfg srvConfig.Vocabularies) *Manager {
+	m := &Manager{
+		Sources:  make(map[string]srvConfig.Vocabulary),
+		CacheTTL: cfg.CacheTTL,
+		cache:    make(map[string]*Vocabulary),
+	}
+	for _, src := range cfg.Sources {
+		m.Sources[src.Name] = src
+	}
+	if m.CacheTTL == 0 {
+		m.CacheTTL = DefaultCacheTTL
+	}
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	m.HttpClient = &http.Client{Timeout: timeout}
+	return m
+}
+
+// Init initializes global vocabularies manager of Vocabularies
+// configuration and uses it to validate schema fields with vocabulary
+func Init() error {
+	if srvConfig.Config == nil {
+		return errors.New("server configuration is not initialized")
+	}
+	Vocabularies = NewManager(srvConfig.Config.Vocabularies)
+	beamlines.Vocabularies = Vocabularies
+	return nil
+}
+
+// Names returns sorted names of vocabularies
+func (m *Manager) Names() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var names []string
+	for name := range m.Sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// helper function to fetch terms of vocabulary source
+func (m *Manager) fetch(ctx context.Context, src srvConfig.Vocabulary) ([]Term, error) {
+	if src.Format == FormatOLS {
+		return m.fetchOLS(ctx, src.Source, src.Ontology)
+	}
+	data, err := m.read(ctx, src.Source)
+	if err != nil {
+		return nil, err
+	}
+	switch src.Format {
+	case FormatJSON:
+		return ParseJSON(data)
+	case FormatText:
+		return ParseText(data)
+	case FormatNXDL:
+		return ParseNXDL(data, src.Field)
+	}
+	return nil, fmt.Errorf("unsupported format %s of vocabulary %s", src.Format, src.Name)
+}
+
+// Get returns vocabulary of given name, vocabularies are fetched when
+// their cache expires and stale vocabulary is used if its source fails
+func (m *Manager) Get(ctx context.Context, name string) (*Vocabulary, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	src, ok := m.Sources[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknown, name)
+	}
+	if m.cache == nil {
+		m.cache = make(map[string]*Vocabulary)
+	}
+	v, ok := m.cache[name]
+	if ok && time.Since(v.Fetched) < m.CacheTTL {
+		return v, nil
+	}
+	terms, err := m.fetch(ctx, src)
+	if err != nil {
+		if ok {
+			log.Printf("WARNING: unable to refresh vocabulary %s, use cached one, error %v", name, err)
+			return v, nil
+		}
+		log.Printf("ERROR: unable to fetch vocabulary %s, error %v", name, err)
+		return nil, err
+	}
+	v = NewVocabulary(name, terms)
+	m.cache[name] = v
+	return v, nil
+}
+
+// Set replaces terms of given vocabulary, e.g. to preload vocabularies
+func (m *Manager) Set(name string, terms []Term) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cache == nil {
+		m.cache = make(map[string]*Vocabulary)
+	}
+	if m.Sources == nil {
+		m.Sources = make(map[string]srvConfig.Vocabulary)
+	}
+	if _, ok := m.Sources[name]; !ok {
+		m.Sources[name] = srvConfig.Vocabulary{Name: name}
+	}
+	m.cache[name] = NewVocabulary(name, terms)
+}
+
+// Validate checks that value, either string or list of strings, consists
+// of terms of given vocabulary
+func (m *Manager) Validate(ctx context.Context, name string, value any) error {
+	v, err := m.Get(ctx, name)
+	if err != nil {
+		return err
+	}
+	var values []string
+	switch val := value.(type) {
+	case string:
+		values = []string{val}
+	case []string:
+		values = val
+	case []any:
+		for _, item := range val {
+			s, ok := item.(string)
+			if !ok {
+				return fmt.Errorf("%w: value %v of type %T", ErrInvalidTerm, item, item)
+			}
+			values = append(values, s)
+		}
+	default:
+		return fmt.Errorf("%w: value %v of type %T", ErrInvalidTerm, value, value)
+	}
+	for _, val := range values {
+		if _, ok := v.Lookup(val); ok {
+			continue
+		}
+		var labels []string
+		for _, t := range v.Suggest(val, 3) {
+			labels = append(labels, t.Label)
+		}
+		if len(labels) > 0 {
+			return fmt.Errorf("%w: '%s' is not term of vocabulary %s, did you mean %s", ErrInvalidTerm, val, name, strings.Join(labels, ", "))
+		}
+		return fmt.Errorf("%w: '%s' is not term of vocabulary %s", ErrInvalidTerm, val, name)
+	}
+	return nil
+}
+
+// ValidateTerm implements beamlines.TermValidator interface
+func (m *Manager) ValidateTerm(name string, value any) error {
+	return m.Validate(context.Background(), name, value)
+}
@@ -0,0 +1,224 @@
+package vocab
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	beamlines "github.com/CHESSComputing/golib/beamlines"
+	srvConfig "github.com/CHESSComputing/golib/config"
+	"github.com/gin-gonic/gin"
+)
+
+// test NeXus application definition
+var testNXDL = `<?xml version="1.0" encoding="UTF-8"?>
+<definition name="NXxbase" type="group" extends="NXobject">
+  <group type="NXentry">
+    <field name="definition">
+      <enumeration><item value="NXxbase"/></enumeration>
+    </field>
+    <group type="NXsample">
+      <field name="temperature" type="NX_FLOAT"/>
+    </group>
+    <group type="NXinstrument">
+      <group type="NXsource">
+        <field name="probe">
+          <enumeration>
+            <item value="x-ray"><doc>X-ray
+              photons</doc></item>
+            <item value="neutron"/>
+            <item value="electron"/>
+          </enumeration>
+        </field>
+      </group>
+    </group>
+  </group>
+</definition>`
+
+// helper function to start fake ontology lookup service
+func olsServer(t *testing.T, calls *int) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*calls++
+		if r.URL.Path != "/api/ontologies/pan-et/terms" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		switch r.URL.Query().Get("page") {
+		case "0":
+			fmt.Fprint(w, `{"_embedded": {"terms": [
+{"iri": "http://purl.org/pan-science/PaNET/PaNET01012", "label": "x-ray diffraction", "synonyms": ["XRD"], "description": ["diffraction of x-rays"]},
+{"iri": "http://purl.org/pan-science/PaNET/PaNET00001", "label": "old technique", "is_obsolete": true}]},
+"page": {"number": 0, "totalPages": 2}}`)
+		case "1":
+			fmt.Fprint(w, `{"_embedded": {"terms": [
+{"iri": "http://purl.org/pan-science/PaNET/PaNET01188", "label": "small angle x-ray scattering", "synonyms": ["SAXS"]},
+{"iri": "http://purl.org/pan-science/PaNET/PaNET01127", "label": "x-ray absorption spectroscopy", "synonyms": ["XAS"]}]},
+"page": {"number": 1, "totalPages": 2}}`)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// TestSources
+func TestSources(t *testing.T) {
+	terms, err := ParseNXDL([]byte(testNXDL), "probe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(terms) != 3 || terms[0].Label != "x-ray" || terms[0].Definition != "X-ray photons" {
+		t.Errorf("wrong NXDL terms %+v", terms)
+	}
+	if terms, _ := ParseNXDL([]byte(testNXDL), ""); len(terms) != 4 {
+		t.Errorf("wrong number of all NXDL terms %+v", terms)
+	}
+	if terms, _ := ParseText([]byte("# detectors\neiger\n\npilatus\n")); len(terms) != 2 || terms[1].Label != "pilatus" {
+		t.Errorf("wrong text terms %+v", terms)
+	}
+	if terms, _ := ParseJSON([]byte(`["eiger", "pilatus"]`)); len(terms) != 2 {
+		t.Errorf("wrong JSON terms %+v", terms)
+	}
+	if terms, _ := ParseJSON([]byte(`[{"label": "eiger", "synonyms": ["Eiger2"]}]`)); len(terms) != 1 || terms[0].Synonyms[0] != "Eiger2" {
+		t.Errorf("wrong JSON terms %+v", terms)
+	}
+}
+
+// TestManager
+func TestManager(t *testing.T) {
+	var calls int
+	server := olsServer(t, &calls)
+	fname := filepath.Join(t.TempDir(), "detectors.txt")
+	if err := os.WriteFile(fname, []byte("eiger\npilatus\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m := NewManager(srvConfig.Vocabularies{Sources: []srvConfig.Vocabulary{
+		{Name: "techniques", Source: server.URL, Format: FormatOLS, Ontology: "pan-et"},
+		{Name: "detectors", Source: fname, Format: FormatText},
+	}})
+	ctx := context.Background()
+	v, err := m.Get(ctx, "techniques")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Terms) != 3 || calls != 2 {
+		t.Errorf("wrong terms %+v of %d calls", v.Terms, calls)
+	}
+	m.Get(ctx, "techniques")
+	if calls != 2 {
+		t.Errorf("vocabulary is not cached, %d calls", calls)
+	}
+	expect := []struct {
+		name  string
+		value any
+		err   error
+	}{
+		{"techniques", "X-ray diffraction", nil},
+		{"techniques", "SAXS", nil},
+		{"techniques", []any{"XRD", "http://purl.org/pan-science/PaNET/PaNET01127"}, nil},
+		{"techniques", "x-ray", ErrInvalidTerm},
+		{"techniques", "old technique", ErrInvalidTerm},
+		{"techniques", 42, ErrInvalidTerm},
+		{"detectors", []string{"eiger"}, nil},
+		{"detectors", "medipix", ErrInvalidTerm},
+		{"unknown", "eiger", ErrUnknown},
+	}
+	for _, e := range expect {
+		if err := m.Validate(ctx, e.name, e.value); !errors.Is(err, e.err) {
+			t.Errorf("%s %v: expect error %v, got %v", e.name, e.value, e.err, err)
+		}
+	}
+	if err := m.Validate(ctx, "techniques", "x-ray"); err == nil || !strings.Contains(err.Error(), "did you mean x-ray absorption spectroscopy") {
+		t.Errorf("wrong suggestions of invalid term, error %v", err)
+	}
+
+	// stale vocabulary is used if source fails
+	server.Close()
+	m.CacheTTL = -1
+	if _, err := m.Get(ctx, "techniques"); err != nil {
+		t.Errorf("stale vocabulary is not used, error %v", err)
+	}
+}
+
+// TestSuggest
+func TestSuggest(t *testing.T) {
+	v := NewVocabulary("techniques", []Term{
+		{Label: "x-ray diffraction", Synonyms: []string{"XRD"}},
+		{Label: "powder diffraction"},
+		{Label: "diffraction"},
+		{Label: "small angle x-ray scattering", Synonyms: []string{"SAXS"}},
+	})
+	var labels []string
+	for _, t := range v.Suggest("diff", 10) {
+		labels = append(labels, t.Label)
+	}
+	if strings.Join(labels, ",") != "diffraction,powder diffraction,x-ray diffraction" {
+		t.Errorf("wrong suggestions %v", labels)
+	}
+	if terms := v.Suggest("sax", 10); len(terms) != 1 || terms[0].Label != "small angle x-ray scattering" {
+		t.Errorf("wrong suggestions of synonym %+v", terms)
+	}
+	if terms := v.Suggest("", 2); len(terms) != 2 {
+		t.Errorf("wrong number of suggestions %+v", terms)
+	}
+}
+
+// TestSchema
+func TestSchema(t *testing.T) {
+	m := NewManager(srvConfig.Vocabularies{})
+	m.Set("detectors", []Term{{Label: "eiger"}, {Label: "pilatus"}})
+	validator := beamlines.Vocabularies
+	beamlines.Vocabularies = m
+	defer func() { beamlines.Vocabularies = validator }()
+
+	fname := filepath.Join(t.TempDir(), "schema.json")
+	data := `[{"key": "Detectors", "type": "list_str", "optional": false, "vocabulary": "detectors"}]`
+	if err := os.WriteFile(fname, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := &beamlines.Schema{FileName: fname}
+	if err := s.Validate(map[string]any{"Detectors": []any{"eiger", "Pilatus"}}); err != nil {
+		t.Error(err)
+	}
+	if err := s.Validate(map[string]any{"Detectors": []any{"medipix"}}); err == nil {
+		t.Error("unknown term is accepted")
+	}
+}
+
+// TestHandlers
+func TestHandlers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	m := NewManager(srvConfig.Vocabularies{Sources: []srvConfig.Vocabulary{
+		{Name: "broken", Source: "https://localhost:1/vocab.json", Format: FormatJSON},
+	}})
+	m.Set("detectors", []Term{{Label: "eiger"}, {Label: "pilatus"}, {Label: "eiger2"}})
+	r := gin.New()
+	for _, route := range m.Routes("/vocabularies") {
+		r.Handle(route.Method, route.Path, route.Handler)
+	}
+	expect := []struct {
+		target string
+		code   int
+		body   string
+	}{
+		{"/vocabularies", http.StatusOK, `["broken","detectors"]`},
+		{"/vocabularies/detectors", http.StatusOK, `"label":"pilatus"`},
+		{"/vocabularies/detectors/suggest?q=EIG&limit=1", http.StatusOK, `[{"label":"eiger"}]`},
+		{"/vocabularies/detectors/suggest?q=x", http.StatusOK, `[]`},
+		{"/vocabularies/detectors/suggest?limit=none", http.StatusBadRequest, ""},
+		{"/vocabularies/unknown/suggest?q=eig", http.StatusNotFound, ""},
+		{"/vocabularies/broken", http.StatusBadGateway, ""},
+	}
+	for _, e := range expect {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", e.target, nil))
+		if w.Code != e.code || !strings.Contains(w.Body.String(), e.body) {
+			t.Errorf("%s: expect %d %s, got %d %s", e.target, e.code, e.body, w.Code, w.Body.String())
+		}
+	}
+}
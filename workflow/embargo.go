@@ -0,0 +1,214 @@
+package workflow
+
+// embargo module enforces embargo of records in read APIs. Embargoed
+// records and their files are visible only to their owners until the
+// embargo ends, administrators may override the embargo and every override
+// is recorded in audit log.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	audit "github.com/CHESSComputing/golib/audit"
+	authz "github.com/CHESSComputing/golib/authz"
+	policy "github.com/CHESSComputing/golib/authz/policy"
+	download "github.com/CHESSComputing/golib/download"
+	services "github.com/CHESSComputing/golib/services"
+	storage "github.com/CHESSComputing/golib/storage"
+	utils "github.com/CHESSComputing/golib/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// OverrideHeader defines request header which requests embargo override
+var OverrideHeader = "X-Embargo-Override"
+
+// OverrideAction defines audit action of embargo overrides
+var OverrideAction = "embargo.override"
+
+// AdminRoles defines roles which may override embargo
+var AdminRoles = []string{"admin"}
+
+// Viewer represents user who reads records
+type Viewer struct {
+	User     string // user name, empty for anonymous access
+	Override bool   // embargo is overridden by administrator
+}
+
+// viewerKey defines context key of the viewer
+type viewerKey struct{}
+
+// WithViewer returns context with given viewer
+func WithViewer(ctx context.Context, v Viewer) context.Context {
+	return context.WithValue(ctx, viewerKey{}, v)
+}
+
+// ViewerFromContext returns viewer of the context, contexts without viewer
+// represent anonymous access
+func ViewerFromContext(ctx context.Context) (Viewer, bool) {
+	v, ok := ctx.Value(viewerKey{}).(Viewer)
+	return v, ok
+}
+
+// Visible checks if record is visible to given viewer at given time
+func Visible(rec map[string]any, v Viewer, ownerKey string, now time.Time) bool {
+	if v.Override || State(rec) != StateEmbargoed {
+		return true
+	}
+	if v.User != "" && rec[ownerKey] == v.User {
+		return true
+	}
+	// records whose embargo is over are visible before their release
+	until := Embargo(rec)
+	return until > 0 && until <= now.Unix()
+}
+
+// EmbargoFilter adds embargo condition for given viewer to the spec, it is
+// used by services which query MongoDB directly
+func EmbargoFilter(spec map[string]any, v Viewer, ownerKey string, now time.Time) map[string]any {
+	out := make(map[string]any, len(spec)+1)
+	for k, val := range spec {
+		out[k] = val
+	}
+	if v.Override {
+		return out
+	}
+	cond := []map[string]any{
+		{StateKey: map[string]any{"$ne": StateEmbargoed}},
+		{EmbargoKey: map[string]any{"$gt": 0, "$lte": now.Unix()}},
+	}
+	if v.User != "" {
+		cond = append(cond, map[string]any{ownerKey: v.User})
+	}
+	if _, ok := out["$or"]; ok {
+		return map[string]any{"$and": []map[string]any{out, {"$or": cond}}}
+	}
+	out["$or"] = cond
+	return out
+}
+
+// Filter adds embargo condition for viewer of the context to the spec
+func Filter(ctx context.Context, spec map[string]any) map[string]any {
+	v, _ := ViewerFromContext(ctx)
+	return EmbargoFilter(spec, v, DefaultOwnerKey, time.Now())
+}
+
+// EmbargoStore represents storage which hides embargoed records from
+// viewer of the context, i.e. they can not be found, counted, updated or
+// removed by others than their owners
+type EmbargoStore struct {
+	Store    storage.Store
+	OwnerKey string
+}
+
+// NewEmbargoStore returns embargo enforcing storage
+func NewEmbargoStore(store storage.Store) *EmbargoStore {
+	return &EmbargoStore{Store: store, OwnerKey: DefaultOwnerKey}
+}
+
+// helper function to add embargo condition of the context to the spec
+func (s *EmbargoStore) filter(ctx context.Context, spec map[string]any) map[string]any {
+	v, _ := ViewerFromContext(ctx)
+	return EmbargoFilter(spec, v, s.OwnerKey, time.Now())
+}
+
+// Insert implements storage.Store interface
+func (s *EmbargoStore) Insert(ctx context.Context, collection string, records ...map[string]any) error {
+	return s.Store.Insert(ctx, collection, records...)
+}
+
+// Find implements storage.Store interface
+func (s *EmbargoStore) Find(ctx context.Context, collection string, spec map[string]any, opts *storage.FindOptions) ([]map[string]any, error) {
+	return s.Store.Find(ctx, collection, s.filter(ctx, spec), opts)
+}
+
+// Update implements storage.Store interface
+func (s *EmbargoStore) Update(ctx context.Context, collection string, spec, fields map[string]any) (int64, error) {
+	return s.Store.Update(ctx, collection, s.filter(ctx, spec), fields)
+}
+
+// Count implements storage.Store interface
+func (s *EmbargoStore) Count(ctx context.Context, collection string, spec map[string]any) (int64, error) {
+	return s.Store.Count(ctx, collection, s.filter(ctx, spec))
+}
+
+// Remove implements storage.Store interface
+func (s *EmbargoStore) Remove(ctx context.Context, collection string, spec map[string]any) (int64, error) {
+	return s.Store.Remove(ctx, collection, s.filter(ctx, spec))
+}
+
+// EmbargoLoader wraps record loader of download module, files of records
+// which are not visible to viewer of the context are reported as not found
+func EmbargoLoader(loader download.RecordLoader, ownerKey string) download.RecordLoader {
+	return func(ctx context.Context, source, fname string) (map[string]any, error) {
+		rec, err := loader(ctx, source, fname)
+		if err != nil {
+			return rec, err
+		}
+		v, _ := ViewerFromContext(ctx)
+		if !Visible(rec, v, ownerKey, time.Now()) {
+			return nil, fmt.Errorf("%w, file %s:%s", policy.ErrRecordNotFound, source, fname)
+		}
+		return rec, nil
+	}
+}
+
+// helper function to abort request of viewer middleware
+func abortViewer(c *gin.Context, code, srvCode int, err error) {
+	rec := services.Response("workflow", code, srvCode, err)
+	c.AbortWithStatusJSON(code, rec)
+}
+
+// ViewerMiddleware provides viewer of the request to the request context,
+// it should follow token validation middleware. Requests with true
+// OverrideHeader override embargo if user has one of AdminRoles, the
+// override is recorded in given audit logger and it is refused if the
+// logger is unable to record it.
+func ViewerMiddleware(logger *audit.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var claims *authz.Claims
+		if val, ok := c.Get("claims"); ok {
+			claims, _ = val.(*authz.Claims)
+		}
+		var v Viewer
+		if claims != nil {
+			v.User = claims.CustomClaims.User
+		}
+		if c.GetHeader(OverrideHeader) == "true" {
+			if claims == nil || !hasRole(claims, AdminRoles) {
+				abortViewer(c, http.StatusForbidden, services.PolicyError, errors.New("embargo override requires administrator role"))
+				return
+			}
+			rec := audit.Record{
+				Subject:   v.User,
+				Kind:      claims.CustomClaims.Kind,
+				Action:    OverrideAction,
+				Resource:  c.Request.URL.Path,
+				IP:        c.ClientIP(),
+				RequestID: audit.RequestID(c),
+				Details:   map[string]any{"method": c.Request.Method, "query": c.Request.URL.RawQuery},
+			}
+			if err := logger.Record(c.Request.Context(), rec); err != nil {
+				log.Printf("ERROR: unable to audit embargo override of %s, error %v", v.User, err)
+				abortViewer(c, http.StatusInternalServerError, services.PolicyError, errors.New("unable to audit embargo override"))
+				return
+			}
+			v.Override = true
+		}
+		c.Request = c.Request.WithContext(WithViewer(c.Request.Context(), v))
+		c.Next()
+	}
+}
+
+// helper function to check if claims have one of given roles
+func hasRole(claims *authz.Claims, roles []string) bool {
+	for _, role := range claims.CustomClaims.Roles {
+		if utils.InList(role, roles) {
+			return true
+		}
+	}
+	return false
+}
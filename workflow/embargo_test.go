@@ -0,0 +1,133 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	audit "github.com/CHESSComputing/golib/audit"
+	policy "github.com/CHESSComputing/golib/authz/policy"
+	storage "github.com/CHESSComputing/golib/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// helper function to create embargo store with published, embargoed and
+// expired embargo records
+func testEmbargoStore(t *testing.T) *EmbargoStore {
+	now := time.Now().Unix()
+	store := storage.NewMemoryStore()
+	err := store.Insert(context.Background(), "meta",
+		map[string]any{"_id": "/a", "user": "alice", StateKey: StatePublished},
+		map[string]any{"_id": "/b", "user": "alice", StateKey: StateEmbargoed, EmbargoKey: now + 3600},
+		map[string]any{"_id": "/c", "user": "bob", StateKey: StateEmbargoed, EmbargoKey: now - 1},
+		map[string]any{"_id": "/d", "user": "bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewEmbargoStore(store)
+}
+
+// TestEmbargoStore
+func TestEmbargoStore(t *testing.T) {
+	s := testEmbargoStore(t)
+	expect := []struct {
+		viewer Viewer
+		count  int64
+	}{
+		{Viewer{}, 3},
+		{Viewer{User: "bob"}, 3},
+		{Viewer{User: "alice"}, 4},
+		{Viewer{User: "bob", Override: true}, 4},
+	}
+	for _, e := range expect {
+		ctx := WithViewer(context.Background(), e.viewer)
+		if n, err := s.Count(ctx, "meta", nil); err != nil || n != e.count {
+			t.Errorf("viewer %+v: expect %d records, got %d, error %v", e.viewer, e.count, n, err)
+		}
+	}
+	ctx := WithViewer(context.Background(), Viewer{User: "bob"})
+	if _, err := storage.FindOne(ctx, s, "meta", map[string]any{"_id": "/b"}); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("embargoed record is found by other user, error %v", err)
+	}
+	if n, _ := s.Update(ctx, "meta", map[string]any{"_id": "/b"}, map[string]any{"user": "bob"}); n != 0 {
+		t.Error("embargoed record is updated by other user")
+	}
+	// embargo condition is combined with $or of the spec
+	spec := map[string]any{"$or": []map[string]any{{"_id": "/a"}, {"_id": "/b"}}}
+	if out, _ := s.Find(ctx, "meta", spec, nil); len(out) != 1 || out[0]["_id"] != "/a" {
+		t.Errorf("wrong records of $or spec %v", out)
+	}
+
+	loader := EmbargoLoader(func(ctx context.Context, source, fname string) (map[string]any, error) {
+		return storage.FindOne(context.Background(), s.Store, "meta", map[string]any{"_id": fname})
+	}, DefaultOwnerKey)
+	if _, err := loader(ctx, "raw", "/b"); !errors.Is(err, policy.ErrRecordNotFound) {
+		t.Errorf("file of embargoed record is visible, error %v", err)
+	}
+	if _, err := loader(WithViewer(context.Background(), Viewer{User: "alice"}), "raw", "/b"); err != nil {
+		t.Errorf("file of embargoed record is not visible to owner, error %v", err)
+	}
+}
+
+// helper function to decode JSON response
+func decode(w *httptest.ResponseRecorder, v any) error {
+	return json.Unmarshal(w.Body.Bytes(), v)
+}
+
+// TestViewerMiddleware
+func TestViewerMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s := testEmbargoStore(t)
+	logger := &audit.Logger{Store: audit.NewDocumentStore(storage.NewMemoryStore())}
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		if user := c.GetHeader("X-User"); user != "" {
+			c.Set("claims", claims(user, c.Request.Header.Values("X-Role")...))
+		}
+	}, ViewerMiddleware(logger))
+	r.GET("/records", func(c *gin.Context) {
+		records, err := s.Find(c.Request.Context(), "meta", nil, nil)
+		if err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		c.JSON(http.StatusOK, records)
+	})
+	send := func(user, role string, override bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/records", nil)
+		req.Header.Set("X-User", user)
+		if role != "" {
+			req.Header.Set("X-Role", role)
+		}
+		if override {
+			req.Header.Set(OverrideHeader, "true")
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	var records []map[string]any
+	if w := send("bob", "", false); w.Code != http.StatusOK || decode(w, &records) != nil || len(records) != 3 {
+		t.Errorf("unexpected response %d %s", w.Code, w.Body.String())
+	}
+	if w := send("bob", "", true); w.Code != http.StatusForbidden {
+		t.Errorf("embargo is overridden by regular user, response %d %s", w.Code, w.Body.String())
+	}
+	if w := send("root", "admin", true); w.Code != http.StatusOK || decode(w, &records) != nil || len(records) != 4 {
+		t.Errorf("unexpected response %d %s", w.Code, w.Body.String())
+	}
+	arecs, err := logger.Query(context.Background(), audit.Query{Action: OverrideAction})
+	if err != nil || len(arecs) != 1 || arecs[0].Subject != "root" || arecs[0].Resource != "/records" {
+		t.Errorf("wrong audit records of override %+v, error %v", arecs, err)
+	}
+
+	// override is refused if it can not be audited
+	logger.Store = nil
+	if w := send("root", "admin", true); w.Code != http.StatusInternalServerError {
+		t.Errorf("unaudited override: unexpected response %d %s", w.Code, w.Body.String())
+	}
+}
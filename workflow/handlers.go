@@ -0,0 +1,110 @@
+package workflow
+
+// handlers module provides HTTP endpoints of publication workflow
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	server "github.com/CHESSComputing/golib/server"
+	services "github.com/CHESSComputing/golib/services"
+	storage "github.com/CHESSComputing/golib/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// StateInfo represents workflow state of the record
+type StateInfo struct {
+	RecordID any      `json:"record_id"`
+	State    string   `json:"state"`
+	Until    int64    `json:"until,omitempty"`
+	Allowed  []string `json:"allowed"` // states which user may move the record to
+}
+
+// helper function to abort request with service error
+func abort(c *gin.Context, code, srvCode int, err error) {
+	rec := services.Response("workflow", code, srvCode, err)
+	c.AbortWithStatusJSON(code, rec)
+}
+
+// helper function to abort request with error of the engine
+func abortWithError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		abort(c, http.StatusNotFound, services.QueryError, err)
+	case errors.Is(err, ErrInvalid):
+		abort(c, http.StatusBadRequest, services.ValidateError, err)
+	case errors.Is(err, ErrForbidden):
+		abort(c, http.StatusForbidden, services.PolicyError, err)
+	case errors.Is(err, ErrTransition), errors.Is(err, storage.ErrVersionConflict):
+		abort(c, http.StatusConflict, services.ValidateError, err)
+	default:
+		log.Printf("ERROR: workflow request failed, error %v", err)
+		abort(c, http.StatusInternalServerError, services.DatabaseError, err)
+	}
+}
+
+// helper function to provide token claims of the request
+func requestClaims(c *gin.Context) (*authz.Claims, bool) {
+	if val, ok := c.Get("claims"); ok {
+		if claims, ok := val.(*authz.Claims); ok && claims.CustomClaims.User != "" {
+			return claims, true
+		}
+	}
+	abort(c, http.StatusUnauthorized, services.CredentialsError, errors.New("no token claims"))
+	return nil, false
+}
+
+// StateHandler provides workflow state of the record along with states
+// which the user may move it to
+func (e *Engine) StateHandler(c *gin.Context) {
+	claims, ok := requestClaims(c)
+	if !ok {
+		return
+	}
+	rec, err := e.Get(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	info := StateInfo{
+		RecordID: rec["_id"],
+		State:    State(rec),
+		Until:    Embargo(rec),
+		Allowed:  e.Allowed(claims, rec),
+	}
+	c.JSON(http.StatusOK, info)
+}
+
+// TransitionHandler moves the record to requested state, the request body
+// is JSON transition request with state, comment and end of embargo
+func (e *Engine) TransitionHandler(c *gin.Context) {
+	claims, ok := requestClaims(c)
+	if !ok {
+		return
+	}
+	var req Request
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abort(c, http.StatusBadRequest, services.BindError, err)
+		return
+	}
+	change, err := e.Transition(c.Request.Context(), claims, c.Param("id"), req)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, change)
+}
+
+// Routes returns server routes of publication workflow under given path,
+// e.g. /records, i.e. states are managed via <path>/:id/state. Transitions
+// are additionally restricted by roles, see Transition.
+func (e *Engine) Routes(path string) []server.Route {
+	return []server.Route{
+		{Method: "GET", Path: path + "/:id/state", Authorized: true, Scope: "read", Handler: e.StateHandler,
+			Summary: "get workflow state of the record"},
+		{Method: "POST", Path: path + "/:id/state", Authorized: true, Scope: "write", Handler: e.TransitionHandler,
+			Summary: "change workflow state of the record"},
+	}
+}
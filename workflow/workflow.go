@@ -0,0 +1,384 @@
+package workflow
+
+// workflow module provides publication workflow of metadata records. Records
+// move through draft, under-review, published, embargoed and retracted
+// states via allowed transitions which are restricted by user roles. Every
+// transition is recorded as new version of the record and announced on the
+// message bus, embargo expiry is scheduled via jobs queue.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	srvConfig "github.com/CHESSComputing/golib/config"
+	jobs "github.com/CHESSComputing/golib/jobs"
+	pubsub "github.com/CHESSComputing/golib/pubsub"
+	storage "github.com/CHESSComputing/golib/storage"
+	utils "github.com/CHESSComputing/golib/utils"
+)
+
+// workflow states
+const (
+	StateDraft       = "draft"
+	StateUnderReview = "under-review"
+	StatePublished   = "published"
+	StateEmbargoed   = "embargoed"
+	StateRetracted   = "retracted"
+)
+
+// EmbargoTaskType defines jobs task type of embargo expiry
+const EmbargoTaskType = "workflow.embargo"
+
+// StateKey defines record key which holds workflow state, records without
+// it are drafts
+var StateKey = "_state"
+
+// EmbargoKey defines record key which holds end of embargo (unix seconds)
+var EmbargoKey = "_embargo"
+
+// DefaultOwnerKey defines record key which holds owner of the record
+var DefaultOwnerKey = "user"
+
+// DefaultSubject defines message bus subject of state change events
+var DefaultSubject = "records"
+
+// SystemActor defines actor of transitions performed by the workflow
+// itself, e.g. embargo expiry
+var SystemActor = "workflow"
+
+// ReleaseInterval defines interval of expired embargoes check of Run
+var ReleaseInterval = time.Hour
+
+// CuratorRole defines role of users who review and publish records
+var CuratorRole = "curator"
+
+// DefaultTransitions defines allowed transitions of publication workflow,
+// owners submit their drafts for review and curators decide about them
+var DefaultTransitions = []Transition{
+	{From: StateDraft, To: StateUnderReview, Roles: []string{CuratorRole}, Owner: true},
+	{From: StateUnderReview, To: StateDraft, Roles: []string{CuratorRole}, Owner: true},
+	{From: StateUnderReview, To: StatePublished, Roles: []string{CuratorRole}},
+	{From: StateUnderReview, To: StateEmbargoed, Roles: []string{CuratorRole}},
+	{From: StateEmbargoed, To: StatePublished, Roles: []string{CuratorRole}},
+	{From: StateEmbargoed, To: StateRetracted, Roles: []string{CuratorRole}},
+	{From: StatePublished, To: StateRetracted, Roles: []string{CuratorRole}},
+}
+
+// DefaultRetryPolicy defines retry policy of embargo expiry tasks
+var DefaultRetryPolicy = jobs.RetryPolicy{
+	MaxAttempts: 10,
+	InitialWait: time.Minute,
+	MaxWait:     time.Hour,
+	Multiplier:  2,
+}
+
+// ErrTransition is returned for transitions which are not defined
+var ErrTransition = errors.New("transition is not allowed")
+
+// ErrForbidden is returned when user is not allowed to perform transition
+var ErrForbidden = errors.New("user is not allowed to perform transition")
+
+// ErrInvalid is returned for invalid transition requests
+var ErrInvalid = errors.New("invalid transition request")
+
+// Transition represents allowed transition between workflow states
+type Transition struct {
+	From  string   // source state
+	To    string   // target state
+	Roles []string // roles allowed to perform transition, empty means any user
+	Owner bool     // owner of the record may perform transition regardless of roles
+}
+
+// Request represents request of state transition
+type Request struct {
+	State   string `json:"state"`             // target state
+	Comment string `json:"comment,omitempty"` // comment of the transition
+	Until   int64  `json:"until,omitempty"`   // end of embargo (unix seconds), required by embargoed state
+}
+
+// Change represents state change of the record
+type Change struct {
+	RecordID any       `json:"record_id"`
+	From     string    `json:"from"`
+	To       string    `json:"to"`
+	Actor    string    `json:"actor"`
+	Comment  string    `json:"comment,omitempty"`
+	Until    int64     `json:"until,omitempty"`
+	Version  int       `json:"version"`
+	Time     time.Time `json:"time"`
+}
+
+// Engine represents workflow engine of metadata records
+type Engine struct {
+	Store       *storage.VersionedStore // store of metadata records
+	Collection  string                  // collection of metadata records
+	Transitions []Transition            // allowed transitions
+	OwnerKey    string                  // record key of record owner
+	Queue       *jobs.Queue             // queue which schedules embargo expiry, optional
+	Bus         pubsub.Bus              // message bus of state change events, optional
+	Subject     string                  // subject of state change events
+}
+
+// Workflow represents global workflow engine, it should be initialized via
+// Init function
+var Workflow *Engine
+
+// NewEngine returns workflow engine of records of given collection with
+// default transitions, embargo expiry handler is registered in given queue
+func NewEngine(store *storage.VersionedStore, collection string, queue *jobs.Queue) *Engine {
+	e := &Engine{
+		Store:       store,
+		Collection:  collection,
+		Transitions: DefaultTransitions,
+		OwnerKey:    DefaultOwnerKey,
+		Queue:       queue,
+		Subject:     DefaultSubject,
+	}
+	if queue != nil {
+		queue.Register(EmbargoTaskType, e.handleEmbargo, DefaultRetryPolicy)
+	}
+	return e
+}
+
+// Init initializes global workflow engine of records of CHESS MetaData
+// service, embargo expiry is scheduled by given queue and state changes are
+// published to global message bus
+func Init(queue *jobs.Queue) error {
+	if srvConfig.Config == nil {
+		return errors.New("server configuration is not initialized")
+	}
+	cfg := srvConfig.Config.CHESSMetaData
+	if cfg.DBName == "" || cfg.DBColl == "" {
+		return errors.New("MetaData database is not configured")
+	}
+	store := storage.NewVersionedStore(storage.NewStore(cfg.DBName))
+	e := NewEngine(store, cfg.DBColl, queue)
+	e.Bus = pubsub.MessageBus
+	Workflow = e
+	return nil
+}
+
+// State returns workflow state of the record
+func State(rec map[string]any) string {
+	if state, ok := rec[StateKey].(string); ok && state != "" {
+		return state
+	}
+	return StateDraft
+}
+
+// Embargo returns end of embargo of the record, zero if record is not
+// embargoed
+func Embargo(rec map[string]any) int64 {
+	if State(rec) != StateEmbargoed {
+		return 0
+	}
+	return utils.ToInt64(rec[EmbargoKey])
+}
+
+// helper function to find transition between given states
+func (e *Engine) transition(from, to string) (Transition, bool) {
+	for _, t := range e.Transitions {
+		if t.From == from && t.To == to {
+			return t, true
+		}
+	}
+	return Transition{}, false
+}
+
+// helper function to check if user of given claims may perform transition
+// of the record
+func (e *Engine) permitted(t Transition, claims *authz.Claims, rec map[string]any) bool {
+	if claims == nil || claims.CustomClaims.User == "" {
+		return false
+	}
+	if len(t.Roles) == 0 {
+		return true
+	}
+	if t.Owner && e.OwnerKey != "" && rec[e.OwnerKey] == claims.CustomClaims.User {
+		return true
+	}
+	return hasRole(claims, t.Roles)
+}
+
+// Allowed returns states which user of given claims may move the record to
+func (e *Engine) Allowed(claims *authz.Claims, rec map[string]any) []string {
+	states := []string{}
+	from := State(rec)
+	for _, t := range e.Transitions {
+		if t.From == from && e.permitted(t, claims, rec) {
+			states = append(states, t.To)
+		}
+	}
+	return states
+}
+
+// Get returns record of given id
+func (e *Engine) Get(ctx context.Context, id any) (map[string]any, error) {
+	return storage.FindOne(ctx, e.Store.Store, e.Collection, map[string]any{"_id": id})
+}
+
+// Transition moves record of given id to requested state on behalf of user
+// of given claims. ErrTransition is returned if transition from current
+// state is not defined and ErrForbidden if user roles do not allow it.
+func (e *Engine) Transition(ctx context.Context, claims *authz.Claims, id any, req Request) (Change, error) {
+	rec, err := e.Get(ctx, id)
+	if err != nil {
+		return Change{}, err
+	}
+	from := State(rec)
+	t, ok := e.transition(from, req.State)
+	if !ok {
+		return Change{}, fmt.Errorf("%w from %s to %s", ErrTransition, from, req.State)
+	}
+	if !e.permitted(t, claims, rec) {
+		return Change{}, fmt.Errorf("%w from %s to %s", ErrForbidden, from, req.State)
+	}
+	if req.State == StateEmbargoed {
+		if req.Until <= time.Now().Unix() {
+			return Change{}, fmt.Errorf("%w, embargo should end in the future", ErrInvalid)
+		}
+	} else if req.Until != 0 {
+		return Change{}, fmt.Errorf("%w, end of embargo is allowed for embargoed state only", ErrInvalid)
+	}
+	return e.apply(ctx, claims.CustomClaims.User, rec, req)
+}
+
+// helper function to apply transition to the record, version condition of
+// the update guarantees that concurrent transitions do not override each
+// other
+func (e *Engine) apply(ctx context.Context, actor string, rec map[string]any, req Request) (Change, error) {
+	id := rec["_id"]
+	fields := map[string]any{StateKey: req.State, EmbargoKey: req.Until}
+	version := int(utils.ToInt64(rec[storage.VersionKey]))
+	ver, err := e.Store.Update(ctx, e.Collection, actor, req.Comment, id, fields, version)
+	if err != nil {
+		return Change{}, err
+	}
+	change := Change{
+		RecordID: id,
+		From:     State(rec),
+		To:       req.State,
+		Actor:    actor,
+		Comment:  req.Comment,
+		Until:    req.Until,
+		Version:  ver.Version,
+		Time:     ver.Time,
+	}
+	if req.State == StateEmbargoed && e.Queue != nil {
+		delay := time.Until(time.Unix(req.Until, 0))
+		if _, err := e.Queue.Enqueue(ctx, EmbargoTaskType, map[string]any{"id": id}, delay); err != nil {
+			// embargo is still released by Run
+			log.Printf("WARNING: unable to schedule embargo expiry of record %v, error %v", id, err)
+		}
+	}
+	e.publish(ctx, change)
+	return change, nil
+}
+
+// helper function to publish state change event
+func (e *Engine) publish(ctx context.Context, change Change) {
+	if e.Bus == nil {
+		return
+	}
+	env, err := pubsub.NewEnvelope(pubsub.EventRecordState, pubsub.Source, change)
+	if err == nil {
+		err = e.Bus.Publish(ctx, e.Subject, env)
+	}
+	if err != nil {
+		log.Printf("WARNING: unable to publish state change of record %v, error %v", change.RecordID, err)
+	}
+}
+
+// helper function to publish embargoed record if its embargo is over
+func (e *Engine) release(ctx context.Context, rec map[string]any, now time.Time) (bool, error) {
+	until := Embargo(rec)
+	if until == 0 || until > now.Unix() {
+		return false, nil
+	}
+	req := Request{State: StatePublished, Comment: "embargo expired"}
+	_, err := e.apply(ctx, SystemActor, rec, req)
+	if errors.Is(err, storage.ErrVersionConflict) {
+		// record was changed concurrently, e.g. released by other instance
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Release publishes all records whose embargo ended before given time and
+// returns their number
+func (e *Engine) Release(ctx context.Context, now time.Time) (int, error) {
+	spec := map[string]any{
+		StateKey:   StateEmbargoed,
+		EmbargoKey: map[string]any{"$gt": 0, "$lte": now.Unix()},
+	}
+	records, err := e.Store.Store.Find(ctx, e.Collection, spec, nil)
+	if err != nil {
+		return 0, err
+	}
+	var nrec int
+	var errs []error
+	for _, rec := range records {
+		ok, err := e.release(ctx, rec, now)
+		if err != nil {
+			log.Printf("ERROR: unable to release embargo of record %v, error %v", rec["_id"], err)
+			errs = append(errs, err)
+			continue
+		}
+		if ok {
+			nrec++
+		}
+	}
+	return nrec, errors.Join(errs...)
+}
+
+// Run periodically releases expired embargoes until context is cancelled,
+// it backs up embargo expiry tasks of the queue
+func (e *Engine) Run(ctx context.Context) {
+	ticker := time.NewTicker(ReleaseInterval)
+	defer ticker.Stop()
+	for {
+		nrec, err := e.Release(ctx, time.Now())
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("ERROR: release of embargoed records failed, error %v", err)
+			}
+		} else if nrec > 0 {
+			log.Printf("published %d records with expired embargo", nrec)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// helper function to release embargo of the queue task, records which are
+// no longer embargoed are skipped
+func (e *Engine) handleEmbargo(ctx context.Context, task *jobs.Task) error {
+	var payload struct {
+		ID any `json:"id"`
+	}
+	if err := task.Decode(&payload); err != nil {
+		return err
+	}
+	rec, err := e.Get(ctx, payload.ID)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if until := Embargo(rec); until > now.Unix() {
+		// task run ahead of embargo end, reschedule it
+		_, err := e.Queue.Enqueue(ctx, EmbargoTaskType, map[string]any{"id": payload.ID}, time.Unix(until, 0).Sub(now))
+		return err
+	}
+	_, err = e.release(ctx, rec, now)
+	return err
+}
@@ -0,0 +1,210 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	jobs "github.com/CHESSComputing/golib/jobs"
+	pubsub "github.com/CHESSComputing/golib/pubsub"
+	storage "github.com/CHESSComputing/golib/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// testBus records published state changes
+type testBus struct {
+	pubsub.Bus
+	mu      sync.Mutex
+	changes []Change
+}
+
+// Publish implements pubsub.Bus interface
+func (b *testBus) Publish(ctx context.Context, subject string, env pubsub.Envelope) error {
+	var change Change
+	if err := json.Unmarshal(env.Data, &change); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.changes = append(b.changes, change)
+	return nil
+}
+
+// helper function to create engine over memory store with single draft
+func testEngine(t *testing.T, queue *jobs.Queue) (*Engine, *testBus) {
+	store := storage.NewVersionedStore(storage.NewMemoryStore())
+	if err := store.Insert(context.Background(), "meta", "alice", map[string]any{"_id": "/a", "user": "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	e := NewEngine(store, "meta", queue)
+	bus := &testBus{}
+	e.Bus = bus
+	return e, bus
+}
+
+// helper function to create claims of given user and roles
+func claims(user string, roles ...string) *authz.Claims {
+	return &authz.Claims{CustomClaims: authz.CustomClaims{User: user, Roles: roles}}
+}
+
+// TestTransition
+func TestTransition(t *testing.T) {
+	e, bus := testEngine(t, nil)
+	ctx := context.Background()
+	alice, bob, curator := claims("alice"), claims("bob"), claims("carol", CuratorRole)
+
+	if _, err := e.Transition(ctx, alice, "/a", Request{State: StatePublished}); !errors.Is(err, ErrTransition) {
+		t.Errorf("draft is published, error %v", err)
+	}
+	if _, err := e.Transition(ctx, bob, "/a", Request{State: StateUnderReview}); !errors.Is(err, ErrForbidden) {
+		t.Errorf("draft of other user is submitted, error %v", err)
+	}
+	if _, err := e.Transition(ctx, alice, "/a", Request{State: StateUnderReview, Comment: "ready"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Transition(ctx, alice, "/a", Request{State: StatePublished}); !errors.Is(err, ErrForbidden) {
+		t.Errorf("owner publishes own record, error %v", err)
+	}
+	rec, _ := e.Get(ctx, "/a")
+	if states := e.Allowed(curator, rec); len(states) != 3 {
+		t.Errorf("wrong allowed states of curator %v", states)
+	}
+	change, err := e.Transition(ctx, curator, "/a", Request{State: StatePublished})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if change.From != StateUnderReview || change.To != StatePublished || change.Actor != "carol" || change.Version != 3 {
+		t.Errorf("wrong change %+v", change)
+	}
+	if _, err := e.Transition(ctx, curator, "/a", Request{State: StateRetracted}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Transition(ctx, curator, "/a", Request{State: StateDraft}); !errors.Is(err, ErrTransition) {
+		t.Errorf("retracted record becomes draft, error %v", err)
+	}
+	if _, err := e.Transition(ctx, curator, "/b", Request{State: StateUnderReview}); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("unknown record is changed, error %v", err)
+	}
+
+	// transitions are recorded in history and announced on the bus
+	history, err := e.Store.History(ctx, "meta", "/a")
+	if err != nil || len(history) != 4 || history[1].Comment != "ready" || history[1].Actor != "alice" {
+		t.Errorf("wrong history %+v, error %v", history, err)
+	}
+	bus.mu.Lock()
+	if len(bus.changes) != 3 || bus.changes[2].To != StateRetracted {
+		t.Errorf("wrong state change events %+v", bus.changes)
+	}
+	bus.mu.Unlock()
+}
+
+// TestEmbargo
+func TestEmbargo(t *testing.T) {
+	queue := jobs.NewQueue(jobs.NewMemoryStore())
+	e, bus := testEngine(t, queue)
+	ctx := context.Background()
+	curator := claims("carol", CuratorRole)
+	if _, err := e.Transition(ctx, claims("alice"), "/a", Request{State: StateUnderReview}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Transition(ctx, curator, "/a", Request{State: StateEmbargoed}); !errors.Is(err, ErrInvalid) {
+		t.Errorf("record is embargoed without end of embargo, error %v", err)
+	}
+	if _, err := e.Transition(ctx, curator, "/a", Request{State: StatePublished, Until: 1}); !errors.Is(err, ErrInvalid) {
+		t.Errorf("record is published with end of embargo, error %v", err)
+	}
+	until := time.Now().Add(time.Hour).Unix()
+	if _, err := e.Transition(ctx, curator, "/a", Request{State: StateEmbargoed, Until: until}); err != nil {
+		t.Fatal(err)
+	}
+	// embargo expiry is scheduled in the future
+	if ok, err := queue.Process(ctx, "test"); ok || err != nil {
+		t.Errorf("embargo expiry task runs ahead of time, error %v", err)
+	}
+	if nrec, err := e.Release(ctx, time.Now()); err != nil || nrec != 0 {
+		t.Errorf("embargo is released ahead of time, released %d, error %v", nrec, err)
+	}
+	// task which runs early does not release the embargo
+	task := &jobs.Task{Payload: json.RawMessage(`{"id": "/a"}`)}
+	if err := e.handleEmbargo(ctx, task); err != nil {
+		t.Fatal(err)
+	}
+	rec, _ := e.Get(ctx, "/a")
+	if State(rec) != StateEmbargoed || Embargo(rec) != until {
+		t.Errorf("wrong embargoed record %v", rec)
+	}
+	if nrec, err := e.Release(ctx, time.Unix(until, 0)); err != nil || nrec != 1 {
+		t.Errorf("expect one released record, got %d, error %v", nrec, err)
+	}
+	rec, _ = e.Get(ctx, "/a")
+	if State(rec) != StatePublished || Embargo(rec) != 0 {
+		t.Errorf("wrong released record %v", rec)
+	}
+	bus.mu.Lock()
+	last := bus.changes[len(bus.changes)-1]
+	if last.From != StateEmbargoed || last.To != StatePublished || last.Actor != SystemActor {
+		t.Errorf("wrong release event %+v", last)
+	}
+	bus.mu.Unlock()
+}
+
+// TestHandlers
+func TestHandlers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	e, _ := testEngine(t, nil)
+	if err := e.Store.Insert(context.Background(), "meta", "alice", map[string]any{"_id": "a", "user": "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		if user := c.GetHeader("X-User"); user != "" {
+			c.Set("claims", claims(user, c.Request.Header.Values("X-Role")...))
+		}
+	})
+	for _, route := range e.Routes("/records") {
+		r.Handle(route.Method, route.Path, route.Handler)
+	}
+	send := func(method, target, user, role, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("X-User", user)
+		if role != "" {
+			req.Header.Set("X-Role", role)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	w := send("GET", "/records/a/state", "alice", "", "")
+	var info StateInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil || w.Code != http.StatusOK || info.State != StateDraft {
+		t.Errorf("unexpected response %d %s", w.Code, w.Body.String())
+	}
+	if len(info.Allowed) != 1 || info.Allowed[0] != StateUnderReview {
+		t.Errorf("wrong allowed states %v", info.Allowed)
+	}
+	expect := []struct {
+		method, target, user, role, body string
+		code                             int
+	}{
+		{"GET", "/records/b/state", "alice", "", "", http.StatusNotFound},
+		{"GET", "/records/a/state", "", "", "", http.StatusUnauthorized},
+		{"POST", "/records/a/state", "alice", "", `{"state": "published"}`, http.StatusConflict},
+		{"POST", "/records/a/state", "bob", "", `{"state": "under-review"}`, http.StatusForbidden},
+		{"POST", "/records/a/state", "alice", "", `[`, http.StatusBadRequest},
+		{"POST", "/records/a/state", "alice", "", `{"state": "under-review"}`, http.StatusOK},
+		{"POST", "/records/a/state", "carol", CuratorRole, `{"state": "embargoed"}`, http.StatusBadRequest},
+		{"POST", "/records/a/state", "carol", CuratorRole, `{"state": "published"}`, http.StatusOK},
+	}
+	for _, e := range expect {
+		if w := send(e.method, e.target, e.user, e.role, e.body); w.Code != e.code {
+			t.Errorf("%s %s of %s: expect %d, got %d %s", e.method, e.target, e.user, e.code, w.Code, w.Body.String())
+		}
+	}
+}